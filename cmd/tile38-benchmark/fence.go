@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/redbench"
+	"github.com/tidwall/resp"
+)
+
+// The fence scenarios move objects around a small area that is covered by
+// geofences, and measure the time from each update to the delivery of the
+// fence event that it caused.
+var (
+	objects  = 1000
+	rate     = 1
+	fences   = 10
+	duration = 10
+	sinkHost = "127.0.0.1"
+)
+
+const (
+	fenceKey    = "key:bench:fence"
+	fencePrefix = "bench:fence:"
+	fenceLat    = 33.4484
+	fenceLon    = -112.0740
+	fenceArea   = 10000 // meters from the center to the edge of the area
+	fenceRadius = 1000
+	fenceStep   = 100 // the most that an object moves in one update
+)
+
+// fenceConn is a connection that sends one command and reads its reply.
+type fenceConn struct {
+	conn net.Conn
+	wr   *bufio.Writer
+	rd   *resp.Reader
+	buf  []byte
+}
+
+func dialFence(prep func(conn net.Conn) bool) *fenceConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !prep(conn) {
+		os.Exit(1)
+	}
+	return &fenceConn{
+		conn: conn,
+		wr:   bufio.NewWriter(conn),
+		rd:   resp.NewReader(conn),
+	}
+}
+
+func (c *fenceConn) send(args ...string) error {
+	c.buf = redbench.AppendCommand(c.buf[:0], args...)
+	if _, err := c.wr.Write(c.buf); err != nil {
+		return err
+	}
+	return c.wr.Flush()
+}
+
+func (c *fenceConn) do(args ...string) (resp.Value, error) {
+	if err := c.send(args...); err != nil {
+		return resp.Value{}, err
+	}
+	v, _, err := c.rd.ReadValue()
+	if err != nil {
+		return v, err
+	}
+	if err := v.Error(); err != nil {
+		return v, err
+	}
+	if json && v.Type() == resp.BulkString {
+		if res := gjson.ParseBytes(v.Bytes()); !res.Get("ok").Bool() {
+			return v, errors.New(res.Get("err").String())
+		}
+	}
+	return v, nil
+}
+
+func (c *fenceConn) must(args ...string) resp.Value {
+	v, err := c.do(args...)
+	if err != nil {
+		log.Fatalf("%s: %v", args[0], err)
+	}
+	return v
+}
+
+// fenceLatency collects the delivery latency of fence events. The update
+// stamps each object with the microseconds since the start of the run in
+// its "ts" field, which comes back with the event.
+type fenceLatency struct {
+	start time.Time
+	mu    sync.Mutex
+	lats  []time.Duration
+	last  time.Time
+}
+
+func (fl *fenceLatency) record(msg []byte) {
+	ts := gjson.GetBytes(msg, "fields.ts")
+	if !ts.Exists() {
+		return
+	}
+	now := time.Now()
+	lat := now.Sub(fl.start) - time.Duration(ts.Int())*time.Microsecond
+	fl.mu.Lock()
+	fl.lats = append(fl.lats, lat)
+	fl.last = now
+	fl.mu.Unlock()
+}
+
+// settle waits for the events that are still in flight once the updates
+// have stopped.
+func (fl *fenceLatency) settle() {
+	for i := 0; i < 50; i++ {
+		fl.mu.Lock()
+		quiet := time.Since(fl.last) > time.Millisecond*200
+		fl.mu.Unlock()
+		if quiet {
+			return
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func (fl *fenceLatency) percentile(p float64) time.Duration {
+	if len(fl.lats) == 0 {
+		return 0
+	}
+	i := int(float64(len(fl.lats)-1) * p)
+	return fl.lats[i]
+}
+
+// startHookSink starts the http endpoint that the hooks deliver to.
+func startHookSink(fl *fenceLatency) (endpoint string, closer io.Closer) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(sinkHost, "0"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			msg, _ := io.ReadAll(r.Body)
+			fl.record(msg)
+		},
+	)}
+	go srv.Serve(ln)
+	return "http://" + ln.Addr().String() + "/", srv
+}
+
+// startChanSink subscribes to the channels.
+func startChanSink(fl *fenceLatency) io.Closer {
+	c := dialFence(authFn)
+	c.must("PSUBSCRIBE", fencePrefix+"*")
+	go func() {
+		for {
+			v, _, err := c.rd.ReadValue()
+			if err != nil {
+				return
+			}
+			if vals := v.Array(); len(vals) == 4 &&
+				vals[0].String() == "pmessage" {
+				fl.record(vals[3].Bytes())
+			}
+		}
+	}()
+	return c.conn
+}
+
+func randFencePoint(meters float64) (lat, lon float64) {
+	lat, _ = destinationPoint(fenceLat, fenceLon,
+		(rand.Float64()*2-1)*meters, 0)
+	_, lon = destinationPoint(fenceLat, fenceLon,
+		(rand.Float64()*2-1)*meters, 90)
+	return lat, lon
+}
+
+// moveFencePoint moves a point a random step, keeping it in the area.
+func moveFencePoint(lat, lon float64) (float64, float64) {
+	nlat, nlon := destinationPoint(lat, lon, rand.Float64()*fenceStep,
+		rand.Float64()*360)
+	minlat, _ := destinationPoint(fenceLat, fenceLon, fenceArea, 180)
+	maxlat, _ := destinationPoint(fenceLat, fenceLon, fenceArea, 0)
+	_, minlon := destinationPoint(fenceLat, fenceLon, fenceArea, 270)
+	_, maxlon := destinationPoint(fenceLat, fenceLon, fenceArea, 90)
+	if nlat < minlat || nlat > maxlat || nlon < minlon || nlon > maxlon {
+		return lat, lon
+	}
+	return nlat, nlon
+}
+
+func cleanFences(c *fenceConn) {
+	c.must("PDELHOOK", fencePrefix+"*")
+	c.must("PDELCHAN", fencePrefix+"*")
+	c.must("DROP", fenceKey)
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', 6, 64)
+}
+
+func fmtLatency(d time.Duration) string {
+	return fmt.Sprintf("%.2f", float64(d)/float64(time.Millisecond))
+}
+
+// benchFence runs a fence scenario. The objects are updated at the rate by
+// the clients, each client owning a share of the objects. With chans, the
+// fences are channels instead of hooks. With queries, the clients also run
+// searches over the area as fast as they can.
+func benchFence(name string, chans, queries bool) {
+	fl := &fenceLatency{}
+	admin := dialFence(prepFn)
+	defer admin.conn.Close()
+	cleanFences(admin)
+	defer cleanFences(admin)
+
+	var sink io.Closer
+	var endpoint string
+	if chans {
+		sink = startChanSink(fl)
+	} else {
+		endpoint, sink = startHookSink(fl)
+	}
+	defer sink.Close()
+
+	for i := 0; i < fences; i++ {
+		lat, lon := randFencePoint(fenceArea)
+		args := []string{"SETHOOK", fencePrefix + strconv.Itoa(i),
+			endpoint + strconv.Itoa(i)}
+		if chans {
+			args = []string{"SETCHAN", fencePrefix + strconv.Itoa(i)}
+		}
+		args = append(args, "NEARBY", fenceKey, "FENCE",
+			"DETECT", "enter,exit", "POINT", ftoa(lat), ftoa(lon),
+			strconv.Itoa(fenceRadius))
+		admin.must(args...)
+	}
+
+	nclients := clients
+	if nclients > objects {
+		nclients = objects
+	}
+	var updates, searches int64
+	var wg sync.WaitGroup
+	fl.start = time.Now()
+	end := fl.start.Add(time.Duration(duration) * time.Second)
+	interval := time.Second / time.Duration(rate)
+	for i := 0; i < nclients; i++ {
+		// the objects that this client owns
+		lo := objects * i / nclients
+		hi := objects * (i + 1) / nclients
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := dialFence(prepFn)
+			defer c.conn.Close()
+			lats := make([]float64, hi-lo)
+			lons := make([]float64, hi-lo)
+			for j := range lats {
+				lats[j], lons[j] = randFencePoint(fenceArea)
+			}
+			for next := time.Now(); next.Before(end); next = next.Add(interval) {
+				for j := range lats {
+					lats[j], lons[j] = moveFencePoint(lats[j], lons[j])
+					ts := time.Since(fl.start) / time.Microsecond
+					c.must("SET", fenceKey, "id:"+strconv.Itoa(lo+j),
+						"FIELD", "ts", strconv.FormatInt(int64(ts), 10),
+						"POINT", ftoa(lats[j]), ftoa(lons[j]))
+					atomic.AddInt64(&updates, 1)
+				}
+				// fill the rest of the interval with searches, or wait
+				// for it to pass
+				for queries && time.Now().Before(next.Add(interval)) {
+					lat, lon := randFencePoint(fenceArea)
+					c.must("NEARBY", fenceKey, "COUNT", "POINT",
+						ftoa(lat), ftoa(lon), strconv.Itoa(fenceRadius))
+					atomic.AddInt64(&searches, 1)
+				}
+				time.Sleep(time.Until(next.Add(interval)))
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(fl.start)
+	fl.settle()
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	sort.Slice(fl.lats, func(i, j int) bool { return fl.lats[i] < fl.lats[j] })
+	ups := float64(updates) / elapsed.Seconds()
+	sps := float64(searches) / elapsed.Seconds()
+	switch {
+	case csv:
+		fmt.Fprintf(os.Stdout, "\"%s\",\"%.2f\"\n", name, ups)
+		if queries {
+			fmt.Fprintf(os.Stdout, "\"%s searches\",\"%.2f\"\n", name, sps)
+		}
+		fmt.Fprintf(os.Stdout, "\"%s p99 ms\",\"%s\"\n", name,
+			fmtLatency(fl.percentile(0.99)))
+	case quiet:
+		fmt.Fprintf(os.Stdout, "%s: %.2f updates per second", name, ups)
+		if queries {
+			fmt.Fprintf(os.Stdout, ", %.2f searches per second", sps)
+		}
+		fmt.Fprintf(os.Stdout, ", %s ms p99 fence latency\n",
+			fmtLatency(fl.percentile(0.99)))
+	default:
+		fmt.Fprintf(os.Stdout, "====== %s ======\n", name)
+		fmt.Fprintf(os.Stdout, "  %d objects, %d updates per second each\n",
+			objects, rate)
+		fmt.Fprintf(os.Stdout, "  %d fences, %d parallel clients\n",
+			fences, nclients)
+		fmt.Fprintf(os.Stdout, "  %d updates completed in %.2f seconds\n",
+			updates, elapsed.Seconds())
+		if queries {
+			fmt.Fprintf(os.Stdout, "  %d searches completed\n", searches)
+		}
+		fmt.Fprintf(os.Stdout, "  %d fence events delivered\n", len(fl.lats))
+		fmt.Fprintf(os.Stdout, "\n")
+		if len(fl.lats) > 0 {
+			for _, p := range []float64{0.5, 0.9, 0.99, 1} {
+				fmt.Fprintf(os.Stdout, "%.0f%% <= %s milliseconds\n",
+					p*100, fmtLatency(fl.percentile(p)))
+			}
+		}
+		fmt.Fprintf(os.Stdout, "%.2f updates per second\n", ups)
+		if queries {
+			fmt.Fprintf(os.Stdout, "%.2f searches per second\n", sps)
+		}
+		fmt.Fprintf(os.Stdout, "\n")
+	}
+}
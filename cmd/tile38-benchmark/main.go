@@ -58,6 +58,13 @@ func showHelp() bool {
 	fmt.Fprintf(os.Stdout, " --csv              Output in CSV format.\n")
 	fmt.Fprintf(os.Stdout, " --json             Request JSON responses (default is RESP output)\n")
 	fmt.Fprintf(os.Stdout, " --redis            Runs against a Redis server\n")
+	fmt.Fprintf(os.Stdout, "\nFence tests (FENCE, FENCE-CHAN, MIXED):\n")
+	fmt.Fprintf(os.Stdout, " --objects <n>      Number of moving objects (default %d)\n", objects)
+	fmt.Fprintf(os.Stdout, " --rate <n>         Updates per second for each object (default %d)\n", rate)
+	fmt.Fprintf(os.Stdout, " --fences <n>       Number of geofences (default %d)\n", fences)
+	fmt.Fprintf(os.Stdout, " --duration <secs>  Length of the run (default %d)\n", duration)
+	fmt.Fprintf(os.Stdout, " --sink <host>      Address that the server delivers hook events to,\n")
+	fmt.Fprintf(os.Stdout, "                    as seen from the server (default %s)\n", sinkHost)
 	fmt.Fprintf(os.Stdout, "\n")
 	return false
 }
@@ -135,6 +142,25 @@ func parseArgs() bool {
 			json = true
 		case "--redis":
 			redis = true
+		case "--objects":
+			objects = readIntArg(arg)
+			if objects <= 0 {
+				objects = 1
+			}
+		case "--rate":
+			rate = readIntArg(arg)
+			if rate <= 0 {
+				rate = 1
+			}
+		case "--fences":
+			fences = readIntArg(arg)
+		case "--duration":
+			duration = readIntArg(arg)
+			if duration <= 0 {
+				duration = 1
+			}
+		case "--sink":
+			sinkHost = readArg(arg)
 		}
 	}
 	return true
@@ -173,7 +199,7 @@ func randRect(meters float64) (minlat, minlon, maxlat, maxlon float64) {
 	}
 }
 
-func prepFn(conn net.Conn) bool {
+func authFn(conn net.Conn) bool {
 	var resp [64]byte
 	conn.Write([]byte("CONFIG GET requirepass\r\n"))
 	n, err := conn.Read(resp[:])
@@ -196,6 +222,11 @@ func prepFn(conn net.Conn) bool {
 	} else if auth != "" {
 		log.Fatal("invalid auth")
 	}
+	return true
+}
+
+func prepFn(conn net.Conn) bool {
+	authFn(conn)
 	if json {
 		conn.Write([]byte("output json\r\n"))
 		conn.Read(make([]byte, 64))
@@ -646,6 +677,22 @@ func main() {
 					},
 				)
 			}
+		case "FENCE", "FENCE-HOOK", "FENCE-CHAN", "MIXED":
+			if redis {
+				break
+			}
+			switch strings.ToUpper(strings.TrimSpace(test)) {
+			case "FENCE", "FENCE-HOOK":
+				benchFence("FENCE (hooks)", false, false)
+			}
+			switch strings.ToUpper(strings.TrimSpace(test)) {
+			case "FENCE", "FENCE-CHAN":
+				benchFence("FENCE (channels)", true, false)
+			}
+			switch strings.ToUpper(strings.TrimSpace(test)) {
+			case "MIXED":
+				benchFence("MIXED (hooks and nearby)", false, true)
+			}
 		case "EVAL":
 			if !redis {
 				var i int64
@@ -65,6 +65,15 @@ func showHelp() bool {
 	fmt.Fprintf(os.Stdout, " --noprompt         Do not display a prompt\n")
 	fmt.Fprintf(os.Stdout, " --resp             Use RESP output formatting (default is JSON output)\n")
 	fmt.Fprintf(os.Stdout, " --json             Use JSON output formatting (default is JSON output)\n")
+	fmt.Fprintf(os.Stdout, " --pretty           Pretty print JSON replies, in color on a terminal\n")
+	fmt.Fprintf(os.Stdout, " --nocolor          Do not color pretty printed replies\n")
+	fmt.Fprintf(os.Stdout, " --map              Open the geometries of JSON replies on a map in the browser\n")
+	fmt.Fprintf(os.Stdout, " -f <file>          Run the commands in <file>, one per line, and exit.\n")
+	fmt.Fprintf(os.Stdout, "                    Use '-' to read from stdin. Exits with 1 when a\n")
+	fmt.Fprintf(os.Stdout, "                    command fails and 2 when the script cannot run.\n")
+	fmt.Fprintf(os.Stdout, " --var <name=value> Set a variable for the script, used as $name or ${name}.\n")
+	fmt.Fprintf(os.Stdout, "                    Environment variables are also available.\n")
+	fmt.Fprintf(os.Stdout, " --continue         Keep running the script after a command fails\n")
 	fmt.Fprintf(os.Stdout, " -h <hostname>      Server hostname (default: %s)\n", hostname)
 	fmt.Fprintf(os.Stdout, " -p <port>          Server port (default: %d)\n", port)
 	fmt.Fprintf(os.Stdout, "\n")
@@ -116,6 +125,20 @@ func parseArgs() bool {
 			output = "resp"
 		case "--json":
 			output = "json"
+		case "--pretty":
+			prettyJSON = true
+		case "--nocolor":
+			nocolor = true
+		case "--map":
+			mapView = true
+		case "-f":
+			scriptFile = readArg(arg)
+		case "--var":
+			if !parseVar(readArg(arg)) {
+				return badArg(arg)
+			}
+		case "--continue":
+			keepGoing = true
 		case "-h":
 			hostname = readArg(arg)
 		case "-p":
@@ -162,6 +185,9 @@ func main() {
 				fmt.Fprintln(os.Stderr, err.Error())
 				os.Exit(1)
 			}
+			if scriptFile != "" {
+				os.Exit(exitScript)
+			}
 			if oneCommand != "" {
 				os.Exit(1)
 			}
@@ -171,6 +197,16 @@ func main() {
 		}
 	}
 	connDial()
+	if scriptFile != "" {
+		name, rd, err := openScript(scriptFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(exitScript)
+		}
+		code := runScript(conn, name, rd)
+		rd.Close()
+		os.Exit(code)
+	}
 	monitor := false
 	livemode := false
 	aof := false
@@ -382,7 +418,10 @@ func main() {
 					break // break out of prompt and just feed data to screen
 				}
 				if mustOutput {
-					fmt.Fprintln(os.Stdout, string(msg))
+					fmt.Fprintln(os.Stdout, string(render(msg)))
+					if mapView {
+						showMap(msg)
+					}
 				}
 			}
 		} else if err == liner.ErrPromptAborted {
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+)
+
+var (
+	prettyJSON bool
+	nocolor    bool
+	mapView    bool
+)
+
+// isTerminal returns true when the file is a terminal rather than a pipe or
+// a regular file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// render pretty prints a JSON reply when --pretty is set, and colors it when
+// the output is a terminal.
+func render(msg []byte) []byte {
+	if !prettyJSON || output != "json" || !gjson.ValidBytes(msg) {
+		return msg
+	}
+	msg = bytes.TrimSpace(pretty.Pretty(msg))
+	if !nocolor && isTerminal(os.Stdout) {
+		msg = pretty.Color(msg, nil)
+	}
+	return msg
+}
+
+// appendFeature appends a GeoJSON object as one or more features. Geometries
+// are wrapped in a feature with the id as a property.
+func appendFeature(dst []byte, id string, obj gjson.Result) []byte {
+	if !obj.IsObject() {
+		return dst
+	}
+	switch obj.Get("type").String() {
+	case "":
+		return dst
+	case "FeatureCollection":
+		obj.Get("features").ForEach(func(_, f gjson.Result) bool {
+			dst = appendFeature(dst, id, f)
+			return true
+		})
+		return dst
+	}
+	if len(dst) > 0 {
+		dst = append(dst, ',')
+	}
+	if obj.Get("type").String() == "Feature" {
+		return append(dst, obj.Raw...)
+	}
+	dst = append(dst, `{"type":"Feature","geometry":`...)
+	dst = append(dst, obj.Raw...)
+	dst = append(dst, `,"properties":{"id":`...)
+	sid, _ := json.Marshal(id)
+	dst = append(dst, sid...)
+	return append(dst, `}}`...)
+}
+
+// pointObject converts a {"lat":..,"lon":..} point to GeoJSON.
+func pointObject(p gjson.Result) gjson.Result {
+	if !p.Get("lat").Exists() || !p.Get("lon").Exists() {
+		return gjson.Result{}
+	}
+	return gjson.Parse(fmt.Sprintf(`{"type":"Point","coordinates":[%s,%s]}`,
+		p.Get("lon").Raw, p.Get("lat").Raw))
+}
+
+// boundsObject converts a {"sw":..,"ne":..} bounds to a GeoJSON polygon.
+func boundsObject(b gjson.Result) gjson.Result {
+	sw, ne := b.Get("sw"), b.Get("ne")
+	if !sw.Exists() || !ne.Exists() {
+		return gjson.Result{}
+	}
+	minx, miny := sw.Get("lon").Raw, sw.Get("lat").Raw
+	maxx, maxy := ne.Get("lon").Raw, ne.Get("lat").Raw
+	return gjson.Parse(fmt.Sprintf(`{"type":"Polygon","coordinates":`+
+		`[[[%s,%s],[%s,%s],[%s,%s],[%s,%s],[%s,%s]]]}`,
+		minx, miny, maxx, miny, maxx, maxy, minx, maxy, minx, miny))
+}
+
+// replyFeatures collects the geometries of a JSON reply, such as the object
+// of a GET or the objects, points, and bounds of a search.
+func replyFeatures(msg []byte) []byte {
+	var dst []byte
+	res := gjson.ParseBytes(msg)
+	id := res.Get("id").String()
+	dst = appendFeature(dst, id, res.Get("object"))
+	dst = appendFeature(dst, id, pointObject(res.Get("point")))
+	dst = appendFeature(dst, id, boundsObject(res.Get("bounds")))
+	res.Get("objects").ForEach(func(_, v gjson.Result) bool {
+		dst = appendFeature(dst, v.Get("id").String(), v.Get("object"))
+		return true
+	})
+	res.Get("points").ForEach(func(_, v gjson.Result) bool {
+		dst = appendFeature(dst, v.Get("id").String(),
+			pointObject(v.Get("point")))
+		return true
+	})
+	res.Get("bounds").ForEach(func(_, v gjson.Result) bool {
+		dst = appendFeature(dst, v.Get("id").String(),
+			boundsObject(v.Get("bounds")))
+		return true
+	})
+	return dst
+}
+
+const mapHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>tile38-cli</title>
+<link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css">
+<script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"></script>
+<style>html, body, #map { height: 100%%; margin: 0; }</style>
+</head>
+<body>
+<div id="map"></div>
+<script>
+var data = %s;
+var map = L.map('map');
+L.tileLayer('https://tile.openstreetmap.org/{z}/{x}/{y}.png', {
+  maxZoom: 19,
+  attribution: '&copy; OpenStreetMap contributors'
+}).addTo(map);
+var layer = L.geoJSON(data, {
+  onEachFeature: function(f, l) {
+    if (f.properties) {
+      l.bindPopup('<pre>' + JSON.stringify(f.properties, null, 2)
+        .replace(/</g, '&lt;') + '</pre>');
+    }
+  }
+}).addTo(map);
+map.fitBounds(layer.getBounds(), { maxZoom: 16 });
+</script>
+</body>
+</html>
+`
+
+// showMap writes the geometries of a JSON reply to an html map and opens it
+// in the browser. Replies without geometries are ignored.
+func showMap(msg []byte) {
+	if output != "json" {
+		return
+	}
+	features := replyFeatures(msg)
+	if len(features) == 0 {
+		return
+	}
+	data := `{"type":"FeatureCollection","features":[` + string(features) + `]}`
+	// keep the data from closing the script element
+	data = strings.ReplaceAll(data, "</", `<\/`)
+	f, err := os.CreateTemp("", "tile38-map-*.html")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+	_, err = fmt.Fprintf(f, mapHTML, data)
+	f.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+	fmt.Fprintf(os.Stderr, "map written to %s\n", f.Name())
+	if err := openBrowser(f.Name()); err != nil {
+		fmt.Fprintf(os.Stderr, "could not open the browser: %s\n", err)
+	}
+}
+
+func openBrowser(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	return cmd.Start()
+}
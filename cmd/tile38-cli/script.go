@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Exit codes of the scripting mode.
+const (
+	exitOK      = 0 // every command succeeded
+	exitCommand = 1 // a command replied with an error
+	exitScript  = 2 // the script could not be read, or the connection failed
+)
+
+var (
+	scriptFile string
+	vars       = make(map[string]string)
+	keepGoing  bool
+)
+
+// parseVar parses a NAME=VALUE variable for the scripting mode.
+func parseVar(arg string) bool {
+	i := strings.IndexByte(arg, '=')
+	if i <= 0 {
+		return false
+	}
+	vars[arg[:i]] = arg[i+1:]
+	return true
+}
+
+// expandVars replaces the $NAME and ${NAME} variables in a command. The
+// variables given with --var take precedence over the environment, and "$$"
+// is a literal "$".
+func expandVars(command string) (string, error) {
+	var missing []string
+	command = os.Expand(command, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		missing = append(missing, name)
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", errors.New("undefined variable '" + missing[0] + "'")
+	}
+	return command, nil
+}
+
+// replyErr returns the error of a reply, or an empty string when the command
+// succeeded.
+func replyErr(msg []byte) string {
+	if output == "json" {
+		if !jsonOK(msg) {
+			return gjson.GetBytes(msg, "err").String()
+		}
+		return ""
+	}
+	if len(msg) > 0 && msg[0] == '-' {
+		return strings.TrimSpace(string(msg[1:]))
+	}
+	return ""
+}
+
+// runScript runs the commands of a script, one per line, and returns the
+// exit code. Blank lines and lines starting with '#' are skipped. The
+// script stops at the first command that fails, unless keepGoing is set.
+func runScript(conn *client, name string, rd io.Reader) int {
+	code := exitOK
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(nil, 64*1024*1024)
+	var lineno int
+	for scanner.Scan() {
+		lineno++
+		command := strings.TrimSpace(scanner.Text())
+		if command == "" || command[0] == '#' {
+			continue
+		}
+		command, err := expandVars(command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", name, lineno, err)
+			return exitScript
+		}
+		switch strings.ToLower(command) {
+		case "output json":
+			output = "json"
+		case "output resp":
+			output = "resp"
+		}
+		msg, err := conn.Do(command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", name, lineno, err)
+			return exitScript
+		}
+		if rerr := replyErr(msg); rerr != "" {
+			fmt.Fprintf(os.Stderr, "%s:%d: (error) %s\n", name, lineno, rerr)
+			if !keepGoing {
+				return exitCommand
+			}
+			code = exitCommand
+			continue
+		}
+		if !raw {
+			if output == "resp" {
+				msg = convert2termresp(msg)
+			} else {
+				msg = convert2termjson(msg)
+			}
+		}
+		fmt.Fprintln(os.Stdout, string(render(msg)))
+		if mapView {
+			showMap(msg)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+		return exitScript
+	}
+	return code
+}
+
+// openScript opens the script file, where "-" is stdin.
+func openScript(path string) (name string, rd io.ReadCloser, err error) {
+	if path == "-" {
+		return "stdin", io.NopCloser(os.Stdin), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, f, nil
+}
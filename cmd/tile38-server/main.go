@@ -38,6 +38,8 @@ var (
 	memprofile  string
 	pprofport   int
 	nohup       bool
+	checkAOF    bool
+	fixAOF      bool
 )
 
 // TODO: Set to false in 2.*
@@ -96,12 +98,24 @@ Basic Options:
 Advanced Options: 
   --pidfile path          : file that contains the pid
   --appendonly yes/no     : AOF persistence (default: yes)
+  --persistengine aof/kv  : Persistence engine (default: aof)
   --appendfilename path   : AOF path (default: data/appendonly.aof)
   --queuefilename path    : Event queue path (default:data/queue.db)
+  --snapshotfilename path : Snapshot path (default: data/snapshot.db)
+  --encryptkeyfile path   : AES key for encrypting the AOF and snapshot
   --http-transport yes/no : HTTP transport (default: yes)
   --protected-mode yes/no : protected mode (default: yes)
   --threads num           : number of network threads (default: num cores)
-  --nohup                 : do not exit on SIGHUP
+  --tls-port port         : listening port for TLS connections, which may be the main port
+  --tls-cert-file path    : certificate for TLS connections
+  --tls-key-file path     : private key for TLS connections
+  --tls-ca-cert-file path : CA for verifying the client certificates of TLS connections
+  --tls-auth-clients yes/no/optional : require client certificates (default: yes)
+  --grpc-port port        : listening port for the gRPC query service
+  --log-format text/json  : format of the log lines (default: text)
+  --nohup                 : do not exit on SIGHUP, reload the config instead
+  --check-aof             : check the AOF for corruption and exit
+  --fix-aof               : truncate a corrupt AOF to the last valid command and exit
 
 Developer Options:
   --dev                             : enable developer mode
@@ -185,6 +199,27 @@ Developer Options:
 		case "--nohup", "-nohup":
 			nohup = true
 			continue
+		case "--log-format", "-log-format":
+			i++
+			if i < len(os.Args) {
+				switch strings.ToLower(os.Args[i]) {
+				case "text":
+					log.SetJSON(false)
+					continue
+				case "json":
+					log.SetJSON(true)
+					continue
+				}
+			}
+			fmt.Fprintf(os.Stderr, "log-format must be 'text' or 'json'\n")
+			os.Exit(1)
+		case "--check-aof", "-check-aof":
+			checkAOF = true
+			continue
+		case "--fix-aof", "-fix-aof":
+			checkAOF = true
+			fixAOF = true
+			continue
 		case "--appendonly", "-appendonly":
 			i++
 			if i < len(os.Args) {
@@ -199,6 +234,17 @@ Developer Options:
 			}
 			fmt.Fprintf(os.Stderr, "appendonly must be 'yes' or 'no'\n")
 			os.Exit(1)
+		case "--persistengine", "-persistengine":
+			i++
+			if i < len(os.Args) {
+				switch strings.ToLower(os.Args[i]) {
+				case "aof", "kv":
+					core.PersistEngine = strings.ToLower(os.Args[i])
+					continue
+				}
+			}
+			fmt.Fprintf(os.Stderr, "persistengine must be 'aof' or 'kv'\n")
+			os.Exit(1)
 		case "--appendfilename", "-appendfilename":
 			i++
 			if i == len(os.Args) || os.Args[i] == "" {
@@ -213,6 +259,77 @@ Developer Options:
 				os.Exit(1)
 			}
 			core.QueueFileName = os.Args[i]
+		case "--snapshotfilename", "-snapshotfilename":
+			i++
+			if i == len(os.Args) || os.Args[i] == "" {
+				fmt.Fprintf(os.Stderr, "snapshotfilename must have a value\n")
+				os.Exit(1)
+			}
+			core.SnapshotFileName = os.Args[i]
+		case "--encryptkeyfile", "-encryptkeyfile":
+			i++
+			if i == len(os.Args) || os.Args[i] == "" {
+				fmt.Fprintf(os.Stderr, "encryptkeyfile must have a value\n")
+				os.Exit(1)
+			}
+			core.EncryptionKeyFile = os.Args[i]
+		case "--tls-port", "-tls-port":
+			i++
+			if i < len(os.Args) {
+				n, err := strconv.ParseUint(os.Args[i], 10, 16)
+				if err == nil && n > 0 {
+					core.TLSPort = int(n)
+					continue
+				}
+			}
+			fmt.Fprintf(os.Stderr, "tls-port must be a valid port\n")
+			os.Exit(1)
+		case "--grpc-port", "-grpc-port":
+			i++
+			if i < len(os.Args) {
+				n, err := strconv.ParseUint(os.Args[i], 10, 16)
+				if err == nil && n > 0 {
+					core.GRPCPort = int(n)
+					continue
+				}
+			}
+			fmt.Fprintf(os.Stderr, "grpc-port must be a valid port\n")
+			os.Exit(1)
+		case "--tls-cert-file", "-tls-cert-file":
+			i++
+			if i == len(os.Args) || os.Args[i] == "" {
+				fmt.Fprintf(os.Stderr, "tls-cert-file must have a value\n")
+				os.Exit(1)
+			}
+			core.TLSCertFile = os.Args[i]
+			continue
+		case "--tls-key-file", "-tls-key-file":
+			i++
+			if i == len(os.Args) || os.Args[i] == "" {
+				fmt.Fprintf(os.Stderr, "tls-key-file must have a value\n")
+				os.Exit(1)
+			}
+			core.TLSKeyFile = os.Args[i]
+			continue
+		case "--tls-ca-cert-file", "-tls-ca-cert-file":
+			i++
+			if i == len(os.Args) || os.Args[i] == "" {
+				fmt.Fprintf(os.Stderr, "tls-ca-cert-file must have a value\n")
+				os.Exit(1)
+			}
+			core.TLSCACertFile = os.Args[i]
+			continue
+		case "--tls-auth-clients", "-tls-auth-clients":
+			i++
+			if i < len(os.Args) {
+				switch strings.ToLower(os.Args[i]) {
+				case "yes", "no", "optional":
+					core.TLSAuthClients = strings.ToLower(os.Args[i])
+					continue
+				}
+			}
+			fmt.Fprintf(os.Stderr, "tls-auth-clients must be 'yes', 'no', or 'optional'\n")
+			os.Exit(1)
 		case "--http-transport", "-http-transport":
 			i++
 			if i < len(os.Args) {
@@ -255,6 +372,10 @@ Developer Options:
 		nargs = append(nargs, os.Args[i])
 	}
 	os.Args = nargs
+	if core.TLSPort != 0 && (core.TLSCertFile == "" || core.TLSKeyFile == "") {
+		fmt.Fprintf(os.Stderr, "tls-port requires tls-cert-file and tls-key-file\n")
+		os.Exit(1)
+	}
 
 	flag.IntVar(&port, "p", 9851, "The listening port.")
 	flag.StringVar(&pidfile, "pidfile", "", "A file that contains the pid")
@@ -285,6 +406,17 @@ Developer Options:
 	core.DevMode = devMode
 	core.ShowDebugMessages = veryVerbose
 
+	if checkAOF {
+		ok, err := server.CheckAOF(dir, fixAOF, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
 	hostd := ""
 	if host != "" {
 		hostd = "Addr: " + host + ", "
@@ -370,9 +502,17 @@ Developer Options:
 	go func() {
 		for s := range c {
 			if s == syscall.SIGHUP && nohup {
+				if core.ReloadConfig != nil {
+					if err := core.ReloadConfig(); err != nil {
+						log.Errorf("reload: %v", err)
+					}
+				}
 				continue
 			}
 			log.Warnf("signal: %v", s)
+			if s == syscall.SIGTERM && core.Shutdown != nil {
+				core.Shutdown()
+			}
 			pidcleanup()
 			pprofcleanup()
 			switch {
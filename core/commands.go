@@ -65,14 +65,15 @@ func (a EnumArg) String() string {
 
 // Argument represents a command argument.
 type Argument struct {
-	Command  string      `json:"command"`
-	NameAny  interface{} `json:"name"`
-	TypeAny  interface{} `json:"type"`
-	Optional bool        `json:"optional"`
-	Multiple bool        `json:"multiple"`
-	Variadic bool        `json:"variadic"`
-	Enum     []string    `json:"enum"`
-	EnumArgs []EnumArg   `json:"enumargs"`
+	Command     string      `json:"command"`
+	NameAny     interface{} `json:"name"`
+	TypeAny     interface{} `json:"type"`
+	Optional    bool        `json:"optional"`
+	Multiple    bool        `json:"multiple"`
+	Variadic    bool        `json:"variadic"`
+	Enum        []string    `json:"enum"`
+	EnumArgs    []EnumArg   `json:"enumargs"`
+	Description string      `json:"description"`
 }
 
 // String returns a string representation of an Argument.
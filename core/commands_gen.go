@@ -65,14 +65,15 @@ func (a EnumArg) String() string {
 
 // Argument represents a command argument.
 type Argument struct {
-	Command  string      `json:"command"`
-	NameAny  interface{} `json:"name"`
-	TypeAny  interface{} `json:"type"`
-	Optional bool        `json:"optional"`
-	Multiple bool        `json:"multiple"`
-	Variadic bool        `json:"variadic"`
-	Enum     []string    `json:"enum"`
-	EnumArgs []EnumArg   `json:"enumargs"`
+	Command     string      `json:"command"`
+	NameAny     interface{} `json:"name"`
+	TypeAny     interface{} `json:"type"`
+	Optional    bool        `json:"optional"`
+	Multiple    bool        `json:"multiple"`
+	Variadic    bool        `json:"variadic"`
+	Enum        []string    `json:"enum"`
+	EnumArgs    []EnumArg   `json:"enumargs"`
+	Description string      `json:"description"`
 }
 
 // String returns a string representation of an Argument.
@@ -180,7 +181,7 @@ var commandsJSON = `{
       {
         "command": "FIELD",
         "name": ["name", "value"],
-        "type": ["string", "double"],
+        "type": ["string", "string"],
         "optional": true,
         "multiple": true
       },
@@ -191,6 +192,65 @@ var commandsJSON = `{
         "optional": true,
         "multiple": false
       },
+      {
+        "command": "JITTER",
+        "name": ["seconds"],
+        "type": ["double"],
+        "optional": true,
+        "multiple": false
+      },
+      {
+        "command": "TIMESTAMP",
+        "name": ["time"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "VERSION",
+        "name": ["version"],
+        "type": ["integer"],
+        "optional": true
+      },
+      {
+        "command": "IF",
+        "name": [],
+        "type": [],
+        "optional": true,
+        "multiple": true,
+        "enumargs": [
+          {
+            "name": "VERSION",
+            "arguments": [
+              {
+                "name": "op",
+                "type": "string",
+                "optional": true
+              },
+              {
+                "name": "version",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "FIELD",
+            "arguments": [
+              {
+                "name": "name",
+                "type": "string"
+              },
+              {
+                "name": "op",
+                "type": "string"
+              },
+              {
+                "name": "value",
+                "type": "string"
+              }
+            ]
+          }
+        ]
+      },
       {
         "name": "type",
         "optional": true,
@@ -293,13 +353,19 @@ var commandsJSON = `{
       {
         "name": "seconds",
         "type": "double"
+      },
+      {
+        "command": "JITTER",
+        "name": "seconds",
+        "type": "double",
+        "optional": true
       }
     ],
     "since": "1.0.0",
     "group": "keys"
   },
   "TTL": {
-    "summary": "Get a timeout on an id",
+    "summary": "Get a timeout on an id, or on a field of an id",
     "complexity": "O(1)",
     "arguments":[
       {
@@ -309,11 +375,49 @@ var commandsJSON = `{
       {
         "name": "id",
         "type": "string"
+      },
+      {
+        "name": "field",
+        "type": "string",
+        "optional": true
       }
     ],
     "since": "1.0.0",
     "group": "keys"
   },
+  "EXISTS": {
+    "summary": "Returns 1 for each id of an object that exists in a collection, and 0 for the others, without the objects",
+    "complexity": "O(N) where N is the number of ids",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string",
+        "multiple": true
+      }
+    ],
+    "group": "keys"
+  },
+  "TYPE": {
+    "summary": "Returns the type of a collection, which is hash, or the types of the objects of the ids, such as point, linestring, polygon, or string, and null for the objects that don't exist",
+    "complexity": "O(N) where N is the number of ids",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "group": "keys"
+  },
   "PERSIST": {
     "summary": "Remove the existing timeout on an id",
     "complexity": "O(1)",
@@ -331,7 +435,7 @@ var commandsJSON = `{
     "group": "keys"
   },
   "FSET": {
-    "summary": "Set the value for one or more fields of an id",
+    "summary": "Set the value for one or more fields of an id. A value is a number, a string, true or false, or a json object or array. EX clears the fields after a number of seconds, independent of the timeout of the id",
     "complexity": "O(1)",
     "arguments":[
       {
@@ -348,13 +452,19 @@ var commandsJSON = `{
         "type": [],
         "optional": true
       },
+      {
+        "command": "EX",
+        "name": ["seconds"],
+        "type": ["double"],
+        "optional": true
+      },
       {
         "name": ["field","value"],
-        "type": ["string","double"]
+        "type": ["string","string"]
       },
       {
         "name": ["field","value"],
-        "type": ["string","double"],
+        "type": ["string","string"],
         "multiple": true,
         "optional": true
       }
@@ -362,20 +472,31 @@ var commandsJSON = `{
     "since": "1.0.0",
     "group": "keys"
   },
-  "BOUNDS": {
-    "summary": "Get the combined bounds of all the objects in a key",
+  "FINCRBY": {
+    "summary": "Adds a delta to the number of a field, which is zero when the field is not set, and returns the new number",
     "complexity": "O(1)",
-    "arguments":[
+    "arguments": [
       {
         "name": "key",
         "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string"
+      },
+      {
+        "name": "field",
+        "type": "string"
+      },
+      {
+        "name": "delta",
+        "type": "double"
       }
     ],
-    "since": "1.3.0",
     "group": "keys"
   },
-  "GET": {
-    "summary": "Get the object of an id",
+  "SETSCHEMA": {
+    "summary": "Set the schema of the fields of a key. Each field has a type of number, string, bool, or json, and the options that follow it apply to that field. STRICT rejects the fields that are not in the schema",
     "complexity": "O(1)",
     "arguments":[
       {
@@ -383,61 +504,57 @@ var commandsJSON = `{
         "type": "string"
       },
       {
-        "name": "id",
-        "type": "string"
+        "command": "STRICT",
+        "name": [],
+        "type": [],
+        "optional": true
       },
       {
-        "command": "WITHFIELDS",
+        "command": "FIELD",
+        "name": ["name","type"],
+        "type": ["string","string"],
+        "multiple": true
+      },
+      {
+        "command": "REQUIRED",
         "name": [],
         "type": [],
         "optional": true
       },
       {
-        "name": "type",
-        "optional": true,
-        "enumargs": [
-          {
-            "name": "OBJECT"
-          },
-          {
-            "name": "POINT"
-          },
-          {
-            "name": "BOUNDS"
-          },
-          {
-            "name": "HASH",
-            "arguments": [
-              {
-                "name": "geohash",
-                "type": "geohash"
-              }
-            ]
-          }
-        ]
+        "command": "MIN",
+        "name": ["value"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "MAX",
+        "name": ["value"],
+        "type": ["double"],
+        "optional": true
+      },
+      {
+        "command": "ENUM",
+        "name": ["count","value"],
+        "type": ["integer","string"],
+        "optional": true
       }
     ],
-    "since": "1.0.0",
     "group": "keys"
   },
-  "DEL": {
-    "summary": "Delete an id from a key",
+  "DELSCHEMA": {
+    "summary": "Remove the schema of a key",
     "complexity": "O(1)",
     "arguments":[
       {
         "name": "key",
         "type": "string"
-      },
-      {
-        "name": "id",
-        "type": "string"
       }
     ],
-    "since": "1.0.0",
     "group": "keys"
   },
-  "DROP": {
-    "summary": "Remove a key from the database",
+  "SCHEMA": {
+    "summary": "Get the schema of a key",
     "complexity": "O(1)",
     "arguments":[
       {
@@ -445,395 +562,3366 @@ var commandsJSON = `{
         "type": "string"
       }
     ],
-    "since": "1.0.0",
     "group": "keys"
   },
-  "RENAME": {
-    "summary": "Rename a key to be stored under a different name.",
+  "SETLIMITS": {
+    "summary": "Set the limits of the objects of a key, which are the most points of a geometry, the most bytes of an object and its fields, the most objects of the key, and the most fence events of an object per second",
     "complexity": "O(1)",
-    "arguments": [
+    "arguments":[
       {
         "name": "key",
         "type": "string"
       },
       {
-        "name": "newkey",
-        "type": "string"
+        "command": "MAXPOINTS",
+        "name": ["count"],
+        "type": ["integer"],
+        "optional": true
+      },
+      {
+        "command": "MAXBYTES",
+        "name": ["size"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "MAXOBJECTS",
+        "name": ["count"],
+        "type": ["integer"],
+        "optional": true
+      },
+      {
+        "command": "MAXEVENTRATE",
+        "name": ["count"],
+        "type": ["integer"],
+        "optional": true
       }
     ],
-    "since": "1.14.5",
     "group": "keys"
   },
-  "RENAMENX": {
-    "summary": "Rename a key to be stored under a different name, if a new key does not exist.",
+  "DELLIMITS": {
+    "summary": "Remove the limits of a key",
     "complexity": "O(1)",
-    "arguments": [
+    "arguments":[
       {
         "name": "key",
         "type": "string"
-      },
+      }
+    ],
+    "group": "keys"
+  },
+  "LIMITS": {
+    "summary": "Get the limits of a key",
+    "complexity": "O(1)",
+    "arguments":[
       {
-        "name": "newkey",
+        "name": "key",
         "type": "string"
       }
     ],
-    "since": "1.14.5",
     "group": "keys"
   },
-  "KEYS": {
-    "summary": "Finds all keys matching the given pattern",
-    "complexity": "O(N) where N is the number of keys in the database",
+  "SETINDEX": {
+    "summary": "Index a field of the objects of a key, which is used by the searches that have a WHERE on the field",
+    "complexity": "O(N) where N is the number of objects in the key",
     "arguments":[
       {
-        "name": "pattern",
-        "type": "pattern"
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "field",
+        "type": "string"
       }
     ],
-    "since": "1.0.0",
     "group": "keys"
   },
-  "STATS": {
-    "summary": "Show stats for one or more keys",
-    "complexity": "O(N) where N is the number of keys being requested",
+  "DELINDEX": {
+    "summary": "Remove the index of a field of a key",
+    "complexity": "O(N) where N is the number of objects in the key",
     "arguments":[
       {
         "name": "key",
-        "type": "string",
-        "variadic": true
+        "type": "string"
+      },
+      {
+        "name": "field",
+        "type": "string"
       }
     ],
-    "since": "1.0.0",
     "group": "keys"
   },
-  "SEARCH": {
-    "summary": "Search for string values in a key",
-    "complexity": "O(N) where N is the number of values in the key",
+  "SETGEOINDEX": {
+    "summary": "Set the options of the indexes inside of the geometries of a key, or reset them to those of the server",
+    "complexity": "O(N) where N is the number of geometries in the key",
     "arguments":[
       {
         "name": "key",
         "type": "string"
       },
       {
-        "command": "CURSOR",
-        "name": "start",
+        "command": "KIND",
+        "enum": ["NONE", "RTREE", "QUADTREE"],
+        "optional": true
+      },
+      {
+        "command": "POINTS",
+        "name": "min",
         "type": "integer",
         "optional": true
       },
       {
-        "command": "LIMIT",
-        "name": "count",
+        "command": "MULTI",
+        "name": "min",
         "type": "integer",
         "optional": true
+      }
+    ],
+    "group": "keys"
+  },
+  "GEOINDEX": {
+    "summary": "Get the options of the indexes inside of the geometries of a key",
+    "complexity": "O(1)",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      }
+    ],
+    "group": "keys"
+  },
+  "REINDEX": {
+    "summary": "Rebuilds the spatial index of a key in the background, without blocking its searches and writes",
+    "complexity": "O(N log N) where N is the number of objects in the key",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      }
+    ],
+    "group": "keys"
+  },
+  "INDEXES": {
+    "summary": "Get the indexed fields of a key",
+    "complexity": "O(1)",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      }
+    ],
+    "group": "keys"
+  },
+  "SETHISTORY": {
+    "summary": "Keep the recent positions of the objects of a key, which are the last count positions of each object, or those in the duration before its newest position",
+    "complexity": "O(N) where N is the number of kept positions of the key",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
       },
       {
-        "command": "MATCH",
-        "name": "pattern",
-        "type": "pattern",
+        "command": "LIMIT",
+        "name": ["count"],
+        "type": ["integer"],
         "optional": true
       },
       {
-        "name": "order",
-        "optional": true,
+        "command": "WINDOW",
+        "name": ["duration"],
+        "type": ["string"],
+        "optional": true
+      }
+    ],
+    "group": "keys"
+  },
+  "DELHISTORY": {
+    "summary": "Stop keeping the positions of the objects of a key",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      }
+    ],
+    "group": "keys"
+  },
+  "SETLABEL": {
+    "summary": "Sets labels of a key, such as its owner, tenant, or description. The labels stay in place when the key is dropped",
+    "complexity": "O(N) where N is the number of labels",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": ["name","value"],
+        "type": ["string","string"],
+        "multiple": true
+      }
+    ],
+    "group": "keys"
+  },
+  "DELLABEL": {
+    "summary": "Removes labels of a key, or all of them when no name is given. Returns the number of removed labels",
+    "complexity": "O(N) where N is the number of labels",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "name",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "group": "keys"
+  },
+  "LABELS": {
+    "summary": "Get the labels of a key",
+    "complexity": "O(N) where N is the number of labels",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      }
+    ],
+    "group": "keys"
+  },
+  "HISTORY": {
+    "summary": "Get the settings and the number of kept positions of a key",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      }
+    ],
+    "group": "keys"
+  },
+  "TRAJECTORY": {
+    "summary": "Get the kept positions of an object as a LineString, with the time of each position",
+    "complexity": "O(log N + M) where N is the number of kept positions of the object and M is the number of positions returned",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string"
+      },
+      {
+        "command": "SINCE",
+        "name": ["age"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "BETWEEN",
+        "name": ["start", "end"],
+        "type": ["string", "string"],
+        "optional": true
+      }
+    ],
+    "group": "search"
+  },
+  "PASSED": {
+    "summary": "Get the ids of the objects that have passed through an area, using their kept positions",
+    "complexity": "O(N) where N is the number of kept positions of the key",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "SINCE",
+        "name": ["age"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "BETWEEN",
+        "name": ["start", "end"],
+        "type": ["string", "string"],
+        "optional": true
+      },
+      {
+        "name": "area",
         "enumargs": [
           {
-            "name": "ASC"
+            "name": "POINT",
+            "arguments": [
+              {
+                "name": "lat",
+                "type": "double"
+              },
+              {
+                "name": "lon",
+                "type": "double"
+              }
+            ]
           },
           {
-            "name": "DESC"
+            "name": "GET",
+            "arguments": [
+              {
+                "name": "key",
+                "type": "string"
+              },
+              {
+                "name": "id",
+                "type": "string"
+              }
+            ]
+          },
+          {
+            "name": "BOUNDS",
+            "arguments": [
+              {
+                "name": "minlat",
+                "type": "double"
+              },
+              {
+                "name": "minlon",
+                "type": "double"
+              },
+              {
+                "name": "maxlat",
+                "type": "double"
+              },
+              {
+                "name": "maxlon",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "OBJECT",
+            "arguments": [
+              {
+                "name": "geojson",
+                "type": "geojson"
+              }
+            ]
+          },
+          {
+            "name": "CIRCLE",
+            "arguments": [
+              {
+                "name": "lat",
+                "type": "double"
+              },
+              {
+                "name": "lon",
+                "type": "double"
+              },
+              {
+                "name": "meters",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "TILE",
+            "arguments": [
+              {
+                "name": "x",
+                "type": "double"
+              },
+              {
+                "name": "y",
+                "type": "double"
+              },
+              {
+                "name": "z",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "QUADKEY",
+            "arguments": [
+              {
+                "name": "quadkey",
+                "type": "string"
+              }
+            ]
+          },
+          {
+            "name": "HASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "GEOHASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "HEX",
+            "arguments": [
+              {
+                "name": "h3index",
+                "type": "string"
+              }
+            ]
           }
-		]
-	  },
+        ]
+      }
+    ],
+    "group": "search"
+  },
+  "BOUNDS": {
+    "summary": "Get the combined bounds of all the objects in a key",
+    "complexity": "O(1)",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      }
+    ],
+    "since": "1.3.0",
+    "group": "keys"
+  },
+  "GET": {
+    "summary": "Get the object of an id",
+    "complexity": "O(1)",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string"
+      },
+      {
+        "command": "WITHFIELDS",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "WITHVERSION",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "name": "type",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "OBJECT"
+          },
+          {
+            "name": "POINT"
+          },
+          {
+            "name": "BOUNDS"
+          },
+          {
+            "name": "HASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          }
+        ]
+      }
+    ],
+    "since": "1.0.0",
+    "group": "keys"
+  },
+  "DEL": {
+    "summary": "Delete an id from a key",
+    "complexity": "O(1)",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string"
+      }
+    ],
+    "since": "1.0.0",
+    "group": "keys"
+  },
+  "DROP": {
+    "summary": "Remove a key from the database",
+    "complexity": "O(1)",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      }
+    ],
+    "since": "1.0.0",
+    "group": "keys"
+  },
+  "RENAME": {
+    "summary": "Rename a key to be stored under a different name.",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "newkey",
+        "type": "string"
+      }
+    ],
+    "since": "1.14.5",
+    "group": "keys"
+  },
+  "RENAMENX": {
+    "summary": "Rename a key to be stored under a different name, if a new key does not exist.",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "newkey",
+        "type": "string"
+      }
+    ],
+    "since": "1.14.5",
+    "group": "keys"
+  },
+  "COPY": {
+    "summary": "Copies the objects of a key that match the filters, with their fields and expirations, to another key. Nothing is copied when the other key exists, unless REPLACE is used, which deletes its objects first. Returns the number of copied objects",
+    "complexity": "O(N) where N is the number of copied objects",
+    "arguments": [
+      {
+        "name": "source",
+        "type": "string"
+      },
+      {
+        "name": "destination",
+        "type": "string"
+      },
+      {
+        "command": "LIMIT",
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "MATCH",
+        "name": "pattern",
+        "type": "pattern",
+        "optional": true
+      },
+      {
+        "command": "WHERE",
+        "name": ["field","min","max"],
+        "type": ["string","double","double"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHERE",
+        "name": ["id|value|field","eq|prefix|suffix|regex","str"],
+        "type": ["string","string","string"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHEREIN",
+        "name": ["field","count","value"],
+        "type": ["string","integer","double"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "WHEREEVAL",
+        "name": ["script","numargs","arg"],
+        "type": ["string","integer","string"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "name": "area",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "WITHIN",
+            "arguments": [
+              {
+                "name": "area",
+                "type": "string",
+                "variadic": true
+              }
+            ]
+          },
+          {
+            "name": "INTERSECTS",
+            "arguments": [
+              {
+                "name": "area",
+                "type": "string",
+                "variadic": true
+              }
+            ]
+          }
+        ]
+      },
+      {
+        "command": "REPLACE",
+        "name": [],
+        "type": [],
+        "optional": true
+      }
+    ],
+    "group": "keys"
+  },
+  "MIGRATE": {
+    "summary": "Moves a collection, with its objects, fields, expirations, and hooks, to another server. Writes to the collection are not blocked while its objects are sent, and the writes that happen meanwhile are sent afterwards. Writes are then blocked briefly for the cutover, after which the collection is removed from this server. With REPLACE, a collection with the same key on the other server is replaced. Returns the number of objects that were moved",
+    "complexity": "O(N) where N is the number of objects in the collection",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "host",
+        "type": "string"
+      },
+      {
+        "name": "port",
+        "type": "integer"
+      },
+      {
+        "command": "AUTH",
+        "name": ["password"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "TLS",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "REPLACE",
+        "name": [],
+        "type": [],
+        "optional": true
+      }
+    ],
+    "group": "keys"
+  },
+  "KEYS": {
+    "summary": "Finds all keys matching the given pattern, and the patterns of their labels",
+    "complexity": "O(N) where N is the number of keys in the database",
+    "arguments":[
+      {
+        "name": "pattern",
+        "type": "pattern"
+      },
+      {
+        "command": "LABEL",
+        "name": ["name","pattern"],
+        "type": ["string","pattern"],
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "since": "1.0.0",
+    "group": "keys"
+  },
+  "STATS": {
+    "summary": "Show stats for one or more keys. EXT adds the points and the other geometries, the fields and field indexes, the depth, nodes, and fill of the spatial index, the expiring objects and fields, and the bounds",
+    "complexity": "O(N) where N is the number of keys being requested, or O(N*M) with EXT where M is the number of objects in a key",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string",
+        "variadic": true
+      },
+      {
+        "command": "EXT",
+        "name": [],
+        "type": [],
+        "optional": true
+      }
+    ],
+    "since": "1.0.0",
+    "group": "keys"
+  },
+  "MEMORY USAGE": {
+    "summary": "Returns the in-memory cost in bytes of a collection, or of an object",
+    "complexity": "O(1) for a collection, O(log N) for an object where N is the number of objects in the key",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string",
+        "optional": true
+      }
+    ],
+    "group": "keys"
+  },
+  "MEMORY STATS": {
+    "summary": "Returns the in-memory cost in bytes of a collection, or of an object, broken down into the geometries, field values, field index entries, and ids",
+    "complexity": "O(1) for a collection, O(log N) for an object where N is the number of objects in the key",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string",
+        "optional": true
+      }
+    ],
+    "group": "keys"
+  },
+  "SEARCH": {
+    "summary": "Search for string values in a key",
+    "complexity": "O(N) where N is the number of values in the key",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "CURSOR",
+        "name": "start",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "LIMIT",
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "TIMEOUT",
+        "name": "milliseconds",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "STREAM",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "MATCH",
+        "name": "pattern",
+        "type": "pattern",
+        "optional": true
+      },
+      {
+        "name": "order",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "ASC"
+          },
+          {
+            "name": "DESC"
+          }
+		]
+	  },
+      {
+        "command": "WHERE",
+        "name": ["field","min","max"],
+        "type": ["string","double","double"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHERE",
+        "name": ["id|value|field","eq|prefix|suffix|regex","str"],
+        "type": ["string","string","string"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHEREIN",
+        "name": ["field","count","value"],
+        "type": ["string","integer","double"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "WHEREEVAL",
+        "name": ["script","numargs","arg"],
+        "type": ["string","integer","string"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "WHEREEVALSHA",
+        "name": ["sha1","numargs","arg"],
+        "type": ["string","integer","string"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "NOFIELDS",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "GROUPBY",
+        "name": "field",
+        "type": "string",
+        "optional": true
+      },
+      {
+        "name": "type",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "COUNT"
+          },
+          {
+            "name": "STATS",
+            "arguments": [
+              {
+                "name": "fields",
+                "type": "string"
+              }
+            ]
+          },
+          {
+            "name": "IDS"
+          }
+        ]
+      }
+    ],
+    "since": "1.4.2",
+    "group": "search"
+  },
+  "SCAN": {
+    "summary": "Incrementally iterate though a key. With STABLE the cursor is a token of the last id returned, and every id that is present for the whole iteration is returned",
+    "complexity": "O(N) where N is the number of ids in the key",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "STABLE",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "CURSOR",
+        "name": "start",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "LIMIT",
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "TIMEOUT",
+        "name": "milliseconds",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "STREAM",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "SAMPLE",
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "MATCH",
+        "name": "pattern",
+        "type": "pattern",
+        "optional": true
+      },
+      {
+        "name": "order",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "ASC"
+          },
+          {
+            "name": "DESC"
+          }
+		]
+	  },
+      {
+        "command": "WHERE",
+        "name": ["field","min","max"],
+        "type": ["string","double","double"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHERE",
+        "name": ["id|value|field","eq|prefix|suffix|regex","str"],
+        "type": ["string","string","string"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHEREIN",
+        "name": ["field","count","value"],
+        "type": ["string","integer","double"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "WHEREEVAL",
+        "name": ["script","numargs","arg"],
+        "type": ["string","integer","string"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "WHEREEVALSHA",
+        "name": ["sha1","numargs","arg"],
+        "type": ["string","integer","string"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "NOFIELDS",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "ZRANGE",
+        "name": ["min", "max"],
+        "type": ["double", "double"],
+        "optional": true
+      },
+      {
+        "command": "SINCE",
+        "name": ["age"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "BETWEEN",
+        "name": ["start", "end"],
+        "type": ["string", "string"],
+        "optional": true
+      },
+      {
+        "command": "GROUPBY",
+        "name": "field",
+        "type": "string",
+        "optional": true
+      },
+      {
+        "name": "type",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "COUNT"
+          },
+          {
+            "name": "STATS",
+            "arguments": [
+              {
+                "name": "fields",
+                "type": "string"
+              }
+            ]
+          },
+          {
+            "name": "CLUSTERS",
+            "arguments": [
+              {
+                "name": "zoom",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "IDS"
+          },
+          {
+            "name": "OBJECTS"
+          },
+          {
+            "name": "POINTS"
+          },
+          {
+            "name": "BOUNDS"
+          },
+          {
+            "name": "HASHES",
+            "arguments": [
+              {
+                "name": "precision",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "H3",
+            "arguments": [
+              {
+                "name": "resolution",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "TOPOJSON",
+            "arguments": [
+              {
+                "name": "quantization",
+                "type": "integer"
+              }
+            ]
+          }
+        ]
+      }
+    ],
+    "since": "1.0.0",
+    "group": "search"
+  },
+  "NEARBY": {
+    "summary": "Searches for ids that are nearby a point, or nearby an area such as a route, which is an OBJECT or the object of a GET. With STABLE the ids are ordered by distance, and the cursor is a token of the position of the last id returned, so that the pages have no gaps when the ids change between them",
+    "complexity": "O(log(N)) where N is the number of ids in the area",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "STABLE",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "MODEL",
+        "enum": ["SPHERICAL", "GEODESIC", "PLANAR"],
+        "optional": true
+      },
+      {
+        "command": "CURSOR",
+        "name": "start",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "LIMIT",
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "TIMEOUT",
+        "name": "milliseconds",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "STREAM",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "SPARSE",
+        "name": "spread",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "MATCH",
+        "name": "pattern",
+        "type": "pattern",
+        "optional": true
+      },
+      {
+        "command": "DISTANCE",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "SORTBY",
+        "name": "field",
+        "type": "string",
+        "optional": true
+      },
+      {
+        "command": "SCORE",
+        "name": "expression",
+        "type": "string",
+        "optional": true
+      },
+      {
+        "name": "order",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "ASC"
+          },
+          {
+            "name": "DESC"
+          }
+        ]
+      },
+      {
+        "command": "WHERE",
+        "name": ["field","min","max"],
+        "type": ["string","double","double"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHERE",
+        "name": ["id|value|field","eq|prefix|suffix|regex","str"],
+        "type": ["string","string","string"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHEREIN",
+        "name": ["field","count","value"],
+        "type": ["string","integer","double"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "WHEREEVAL",
+        "name": ["script","numargs","arg"],
+        "type": ["string","integer","string"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "WHEREEVALSHA",
+        "name": ["sha1","numargs","arg"],
+        "type": ["string","integer","string"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "NOFIELDS",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "FENCE",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "DETECT",
+        "name": ["what"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "ZRANGE",
+        "name": ["min", "max"],
+        "type": ["double", "double"],
+        "optional": true
+      },
+      {
+        "command": "SINCE",
+        "name": ["age"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "BETWEEN",
+        "name": ["start", "end"],
+        "type": ["string", "string"],
+        "optional": true
+      },
+      {
+        "command": "COMMANDS",
+        "name": ["which"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "GROUPBY",
+        "name": "field",
+        "type": "string",
+        "optional": true
+      },
+      {
+        "name": "type",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "COUNT"
+          },
+          {
+            "name": "STATS",
+            "arguments": [
+              {
+                "name": "fields",
+                "type": "string"
+              }
+            ]
+          },
+          {
+            "name": "CLUSTERS",
+            "arguments": [
+              {
+                "name": "zoom",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "IDS"
+          },
+          {
+            "name": "OBJECTS"
+          },
+          {
+            "name": "POINTS"
+          },
+          {
+            "name": "BOUNDS"
+          },
+          {
+            "name": "HASHES",
+            "arguments": [
+              {
+                "name": "precision",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "H3",
+            "arguments": [
+              {
+                "name": "resolution",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "TOPOJSON",
+            "arguments": [
+              {
+                "name": "quantization",
+                "type": "integer"
+              }
+            ]
+          }
+        ]
+      },
+      {
+        "name": "area",
+        "enumargs": [
+          {
+            "name": "POINT",
+            "arguments": [
+              {
+                "name": "lat",
+                "type": "double"
+              },
+              {
+                "name": "lon",
+                "type": "double"
+              },
+              {
+                "name": "meters",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "OBJECT",
+            "arguments": [
+              {
+                "name": "geojson",
+                "type": "geojson"
+              },
+              {
+                "name": "meters",
+                "type": "double",
+                "optional": true
+              }
+            ]
+          },
+          {
+            "name": "GET",
+            "arguments": [
+              {
+                "name": "key",
+                "type": "string"
+              },
+              {
+                "name": "id",
+                "type": "string"
+              },
+              {
+                "name": "meters",
+                "type": "double",
+                "optional": true
+              }
+            ]
+          },
+          {
+            "name": "ROAM",
+            "arguments":[
+              {
+                "name": "key",
+                "type": "string"
+              },
+              {
+                "name": "pattern",
+                "type": "pattern"
+              },
+              {
+                "name": "meters",
+                "type": "double"
+              }
+            ]
+          }
+        ]
+      }
+    ],
+    "since": "1.0.0",
+    "group": "search"
+  },
+  "WITHIN": {
+    "summary": "Searches for ids that completely within the area, or within each area of the ids of another collection that match a GET pattern. With STABLE the ids are ordered by id, and the cursor is a token of the last id returned, so that the pages have no gaps when the ids change between them. With SORTBY DISTANCE the ids are ordered by their distance to a point",
+    "complexity": "O(log(N)) where N is the number of ids in the area",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "STABLE",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "MODEL",
+        "enum": ["SPHERICAL", "GEODESIC", "PLANAR"],
+        "optional": true
+      },
+      {
+        "command": "CURSOR",
+        "name": "start",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "LIMIT",
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "TIMEOUT",
+        "name": "milliseconds",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "STREAM",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "SPARSE",
+        "name": "spread",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "SAMPLE",
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "SORTBY DISTANCE",
+        "name": ["lat","lon"],
+        "type": ["double","double"],
+        "optional": true
+      },
+      {
+        "name": "order",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "ASC"
+          },
+          {
+            "name": "DESC"
+          }
+        ]
+      },
+      {
+        "command": "MATCH",
+        "name": "pattern",
+        "type": "pattern",
+        "optional": true
+      },
+      {
+        "command": "WHERE",
+        "name": ["field","min","max"],
+        "type": ["string","double","double"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHERE",
+        "name": ["id|value|field","eq|prefix|suffix|regex","str"],
+        "type": ["string","string","string"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHEREIN",
+        "name": ["field","count","value"],
+        "type": ["string","integer","double"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "WHEREEVAL",
+        "name": ["script","numargs","arg"],
+        "type": ["string","integer","string"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "WHEREEVALSHA",
+        "name": ["sha1","numargs","arg"],
+        "type": ["string","integer","string"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "NOFIELDS",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "FENCE",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "DETECT",
+        "name": ["what"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "ZRANGE",
+        "name": ["min", "max"],
+        "type": ["double", "double"],
+        "optional": true
+      },
+      {
+        "command": "SINCE",
+        "name": ["age"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "BETWEEN",
+        "name": ["start", "end"],
+        "type": ["string", "string"],
+        "optional": true
+      },
+      {
+        "command": "COMMANDS",
+        "name": ["which"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "BUFFER",
+        "name": "meters",
+        "type": "double",
+        "optional": true
+      },
+      {
+        "command": "GROUPBY",
+        "name": "field",
+        "type": "string",
+        "optional": true
+      },
+      {
+        "name": "type",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "COUNT"
+          },
+          {
+            "name": "STATS",
+            "arguments": [
+              {
+                "name": "fields",
+                "type": "string"
+              }
+            ]
+          },
+          {
+            "name": "CLUSTERS",
+            "arguments": [
+              {
+                "name": "zoom",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "IDS"
+          },
+          {
+            "name": "OBJECTS"
+          },
+          {
+            "name": "POINTS"
+          },
+          {
+            "name": "BOUNDS"
+          },
+          {
+            "name": "HASHES",
+            "arguments": [
+              {
+                "name": "precision",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "H3",
+            "arguments": [
+              {
+                "name": "resolution",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "TOPOJSON",
+            "arguments": [
+              {
+                "name": "quantization",
+                "type": "integer"
+              }
+            ]
+          }
+        ]
+      },
+      {
+        "name": "area",
+        "enumargs": [
+          {
+            "name": "GET",
+            "arguments": [
+              {
+                "name": "key",
+                "type": "string"
+              },
+              {
+                "name": "id",
+                "type": "string"
+              }
+            ]
+          },
+          {
+            "name": "BOUNDS",
+            "arguments":[
+              {
+                "name": "minlat",
+                "type": "double"
+              },
+              {
+                "name": "minlon",
+                "type": "double"
+              },
+              {
+                "name": "maxlat",
+                "type": "double"
+              },
+              {
+                "name": "maxlon",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "OBJECT",
+            "arguments":[
+              {
+                "name": "geojson",
+                "type": "geojson"
+              }
+            ]
+          },
+          {
+            "name": "CIRCLE",
+            "arguments": [
+              {
+                "name": "lat",
+                "type": "double"
+              },
+              {
+                "name": "lon",
+                "type": "double"
+              },
+              {
+                "name": "meters",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "TILE",
+            "arguments":[
+              {
+                "name": "x",
+                "type": "double"
+              },
+              {
+                "name": "y",
+                "type": "double"
+              },
+              {
+                "name": "z",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "QUADKEY",
+            "arguments":[
+              {
+                "name": "quadkey",
+                "type": "string"
+              }
+            ]
+          },
+          {
+            "name": "HASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "GEOHASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "HEX",
+            "arguments": [
+              {
+                "name": "h3index",
+                "type": "string"
+              }
+            ]
+          }
+        ]
+      }
+    ],
+    "since": "1.0.0",
+    "group": "search"
+  },
+  "INTERSECTS": {
+    "summary": "Searches for ids that intersect an area, or each area of the ids of another collection that match a GET pattern. With STABLE the ids are ordered by id, and the cursor is a token of the last id returned, so that the pages have no gaps when the ids change between them. With SORTBY DISTANCE the ids are ordered by their distance to a point",
+    "complexity": "O(log(N)) where N is the number of ids in the area",
+    "arguments":[
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "STABLE",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "MODEL",
+        "enum": ["SPHERICAL", "GEODESIC", "PLANAR"],
+        "optional": true
+      },
+      {
+        "command": "CURSOR",
+        "name": "start",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "LIMIT",
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "TIMEOUT",
+        "name": "milliseconds",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "STREAM",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "SPARSE",
+        "name": "spread",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "SAMPLE",
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "SORTBY DISTANCE",
+        "name": ["lat","lon"],
+        "type": ["double","double"],
+        "optional": true
+      },
+      {
+        "name": "order",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "ASC"
+          },
+          {
+            "name": "DESC"
+          }
+        ]
+      },
+      {
+        "command": "MATCH",
+        "name": "pattern",
+        "type": "pattern",
+        "optional": true
+      },
+      {
+        "command": "WHERE",
+        "name": ["field","min","max"],
+        "type": ["string","double","double"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHERE",
+        "name": ["id|value|field","eq|prefix|suffix|regex","str"],
+        "type": ["string","string","string"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "command": "WHEREIN",
+        "name": ["field","count","value"],
+        "type": ["string","integer","double"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "WHEREEVAL",
+        "name": ["script","numargs","arg"],
+        "type": ["string","integer","string"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "WHEREEVALSHA",
+        "name": ["sha1","numargs","arg"],
+        "type": ["string","integer","string"],
+        "optional": true,
+        "multiple": true,
+        "variadic": true
+      },
+      {
+        "command": "CLIP",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "NOFIELDS",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "FENCE",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "DETECT",
+        "name": ["what"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "ZRANGE",
+        "name": ["min", "max"],
+        "type": ["double", "double"],
+        "optional": true
+      },
+      {
+        "command": "SINCE",
+        "name": ["age"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "BETWEEN",
+        "name": ["start", "end"],
+        "type": ["string", "string"],
+        "optional": true
+      },
+      {
+        "command": "COMMANDS",
+        "name": ["which"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "BUFFER",
+        "name": "meters",
+        "type": "double",
+        "optional": true
+      },
+      {
+        "command": "GROUPBY",
+        "name": "field",
+        "type": "string",
+        "optional": true
+      },
+      {
+        "name": "type",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "COUNT"
+          },
+          {
+            "name": "STATS",
+            "arguments": [
+              {
+                "name": "fields",
+                "type": "string"
+              }
+            ]
+          },
+          {
+            "name": "CLUSTERS",
+            "arguments": [
+              {
+                "name": "zoom",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "IDS"
+          },
+          {
+            "name": "OBJECTS"
+          },
+          {
+            "name": "POINTS"
+          },
+          {
+            "name": "BOUNDS"
+          },
+          {
+            "name": "HASHES",
+            "arguments": [
+              {
+                "name": "precision",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "H3",
+            "arguments": [
+              {
+                "name": "resolution",
+                "type": "integer"
+              }
+            ]
+          },
+          {
+            "name": "TOPOJSON",
+            "arguments": [
+              {
+                "name": "quantization",
+                "type": "integer"
+              }
+            ]
+          }
+        ]
+      },
+      {
+        "name": "area",
+        "enumargs": [
+          {
+            "name": "GET",
+            "arguments": [
+              {
+                "name": "key",
+                "type": "string"
+              },
+              {
+                "name": "id",
+                "type": "string"
+              }
+            ]
+          },
+          {
+            "name": "BOUNDS",
+            "arguments":[
+              {
+                "name": "minlat",
+                "type": "double"
+              },
+              {
+                "name": "minlon",
+                "type": "double"
+              },
+              {
+                "name": "maxlat",
+                "type": "double"
+              },
+              {
+                "name": "maxlon",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "OBJECT",
+            "arguments":[
+              {
+                "name": "geojson",
+                "type": "geojson"
+              }
+            ]
+          },
+          {
+            "name": "CIRCLE",
+            "arguments": [
+              {
+                "name": "lat",
+                "type": "double"
+              },
+              {
+                "name": "lon",
+                "type": "double"
+              },
+              {
+                "name": "meters",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "TILE",
+            "arguments":[
+              {
+                "name": "x",
+                "type": "double"
+              },
+              {
+                "name": "y",
+                "type": "double"
+              },
+              {
+                "name": "z",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "QUADKEY",
+            "arguments":[
+              {
+                "name": "quadkey",
+                "type": "string"
+              }
+            ]
+          },
+          {
+            "name": "HASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "GEOHASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "HEX",
+            "arguments": [
+              {
+                "name": "h3index",
+                "type": "string"
+              }
+            ]
+          }
+        ]
+      }
+    ],
+    "since": "1.0.0",
+    "group": "search"
+  },
+  "CONFIG GET": {
+    "summary": "Get the value of a configuration parameter",
+    "arguments":[
+      {
+        "name": "parameter",
+        "type": "string"
+      }
+    ],
+    "group": "server"
+  },
+  "CONFIG SET": {
+    "summary": "Set a configuration parameter to the given value",
+    "arguments":[
+      {
+        "name": "parameter",
+        "type": "string"
+      },
+      {
+        "name": "value",
+        "type": "string",
+        "optional": true
+      }
+    ],
+    "group": "server"
+  },
+  "CONFIG REWRITE": {
+    "summary": "Rewrite the configuration file with the in memory configuration",
+    "arguments":[],
+    "group": "server"
+  },
+  "CONFIG RELOAD": {
+    "summary": "Reload the configuration file, and the TLS certificates of the server and of the kafka and mqtt endpoints, without a restart. Certificate files are also reloaded when they are modified. The configuration is rewritten with the reloaded properties. The same as SIGHUP when the server was started with --nohup",
+    "arguments":[],
+    "group": "server"
+  },
+  "MATRIX": {
+    "summary": "Returns the distances from each origin to each destination. An origin or destination is the id of an object in the collection, whose center is used, or a point. The distances are in meters, or in the units of the coordinates with the PLANAR model. With KNN, each origin has only its nearest destinations, by their position in the destinations, in the order of their distance",
+    "complexity": "O(N*M) where N is the number of origins and M is the number of destinations",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "MODEL",
+        "enum": ["SPHERICAL", "GEODESIC", "PLANAR"],
+        "optional": true
+      },
+      {
+        "command": "KNN",
+        "name": "k",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "ORIGINS",
+        "name": [],
+        "type": [],
+        "enumargs": [
+          {
+            "name": "id"
+          },
+          {
+            "name": "POINT",
+            "arguments":[
+              {
+                "name": "lat",
+                "type": "double"
+              },
+              {
+                "name": "lon",
+                "type": "double"
+              }
+            ]
+          }
+        ]
+      },
+      {
+        "command": "DESTINATIONS",
+        "name": [],
+        "type": [],
+        "enumargs": [
+          {
+            "name": "id"
+          },
+          {
+            "name": "POINT",
+            "arguments":[
+              {
+                "name": "lat",
+                "type": "double"
+              },
+              {
+                "name": "lon",
+                "type": "double"
+              }
+            ]
+          }
+        ]
+      }
+    ],
+    "group": "search"
+  },
+  "TILE": {
+    "summary": "Returns the objects that intersect a map tile as a Mapbox Vector Tile. The tile has one layer, which is named by the key, and each object is a feature with its id and the listed fields as properties. The objects are clipped to the tile with a buffer, and the lines and polygons are simplified for the zoom level. The tile may instead be a GeoJSON FeatureCollection, or a PNG picture whose size in pixels is the extent, which is 256 by default. The tiles are also served over HTTP at /tiles/key/z/x/y.png, .geojson, or .mvt, for showing a collection on a browser map",
+    "complexity": "O(log(N)+M) where N is the number of ids in the area and M is the number of points of the objects in the tile",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "z",
+        "type": "integer"
+      },
+      {
+        "name": "x",
+        "type": "integer"
+      },
+      {
+        "name": "y",
+        "type": "integer"
+      },
+      {
+        "command": "FIELDS",
+        "name": "fields",
+        "type": "string",
+        "optional": true
+      },
+      {
+        "command": "EXTENT",
+        "name": "extent",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "BUFFER",
+        "name": "buffer",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "FORMAT",
+        "enum": ["MVT", "GEOJSON", "PNG"],
+        "optional": true
+      }
+    ],
+    "group": "search"
+  },
+  "SERVER": {
+    "summary":"Show server stats and details",
+    "complexity": "O(1)",
+    "arguments": [],
+    "since": "1.0.0",
+    "group": "server"
+  },
+  "METRICS": {
+    "summary": "Returns the metrics of the server in the Prometheus text format, which are also served at /metrics by the HTTP transport. The metrics have the calls, errors, and latency histograms of the commands, the objects and memory of the collections, the deliveries of the hooks, and the replication lag",
+    "complexity": "O(N) where N is the number of collections, commands, and hooks",
+    "arguments": [],
+    "group": "server"
+  },
+  "SLOWLOG GET": {
+    "summary": "Returns the commands that took longer than slowlog-log-slower-than microseconds, newest first, with their id, time, duration, arguments, and client. The count is 10 by default, and -1 for all of the slowlog-max-len commands",
+    "complexity": "O(N) where N is the number of returned commands",
+    "arguments": [
+      {
+        "name": "count",
+        "type": "integer",
+        "optional": true
+      }
+    ],
+    "group": "server"
+  },
+  "SLOWLOG LEN": {
+    "summary": "Returns the number of commands in the slowlog",
+    "complexity": "O(1)",
+    "arguments": [],
+    "group": "server"
+  },
+  "SLOWLOG RESET": {
+    "summary": "Removes the commands in the slowlog",
+    "complexity": "O(1)",
+    "arguments": [],
+    "group": "server"
+  },
+  "HEALTH LIVE": {
+    "summary": "Checks that the server is responsive, which is when the lock of the data is acquired within a second. Also served at /livez by the HTTP transport, which responds with 503 when the check fails. Does not require authentication",
+    "complexity": "O(1)",
+    "arguments": [],
+    "group": "server"
+  },
+  "HEALTH READY": {
+    "summary": "Checks that the server is responsive, caught up to its leader, and not out of memory, and with ENDPOINTS that the endpoints of the hooks are reachable. Also served at /readyz and /readyz?endpoints by the HTTP transport, which responds with 503 when a check fails. Does not require authentication",
+    "complexity": "O(N) where N is the number of endpoints",
+    "arguments": [
+      {
+        "command": "ENDPOINTS",
+        "name": [],
+        "type": [],
+        "optional": true
+      }
+    ],
+    "group": "server"
+  },
+  "LATENCY LATEST": {
+    "summary": "Returns the last spike of each event of the latency monitor, which are command, aof-fsync, hook-delivery, and replication, with its time, latency, and the largest latency in milliseconds. Spikes are latencies over latency-monitor-threshold milliseconds",
+    "complexity": "O(N) where N is the number of events",
+    "arguments": [],
+    "group": "server"
+  },
+  "LATENCY HISTORY": {
+    "summary": "Returns the spikes of an event of the latency monitor, oldest first, with their time and latency in milliseconds. There's one spike per second, up to 160",
+    "complexity": "O(N) where N is the number of spikes",
+    "arguments": [
+      {
+        "name": "event",
+        "type": "string"
+      }
+    ],
+    "group": "server"
+  },
+  "LATENCY RESET": {
+    "summary": "Removes the spikes of the events, or of every event, and returns the number of events that were removed",
+    "complexity": "O(N) where N is the number of events",
+    "arguments": [
+      {
+        "name": "event",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "group": "server"
+  },
+  "ACL SETUSER": {
+    "summary": "Creates or modifies a user of the ACL with rules, which are on or off, >password, <password, nopass, resetpass, +command, -command, +@category, -@category, allcommands, nocommands, ~pattern for the keys, allkeys, resetkeys, cert:pattern for the identities of client certificates, such as cert:CN=name or cert:URI:spiffe://*, resetcerts, and reset. A new user is off and may not run any command",
+    "complexity": "O(N) where N is the number of rules",
+    "arguments": [
+      {
+        "name": "username",
+        "type": "string"
+      },
+      {
+        "name": "rule",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "group": "server"
+  },
+  "ACL DELUSER": {
+    "summary": "Deletes users of the ACL and returns the number of deleted users",
+    "complexity": "O(N) where N is the number of users",
+    "arguments": [
+      {
+        "name": "username",
+        "type": "string",
+        "multiple": true
+      }
+    ],
+    "group": "server"
+  },
+  "ACL GETUSER": {
+    "summary": "Returns the flags, password digests, command rules, key patterns, and certificate patterns of a user of the ACL",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "username",
+        "type": "string"
+      }
+    ],
+    "group": "server"
+  },
+  "ACL USERS": {
+    "summary": "Returns the names of the users of the ACL",
+    "complexity": "O(N) where N is the number of users",
+    "arguments": [],
+    "group": "server"
+  },
+  "ACL LIST": {
+    "summary": "Returns the rules of the users of the ACL",
+    "complexity": "O(N) where N is the number of users",
+    "arguments": [],
+    "group": "server"
+  },
+  "ACL WHOAMI": {
+    "summary": "Returns the user of the connection",
+    "complexity": "O(1)",
+    "arguments": [],
+    "group": "server"
+  },
+  "ACL CAT": {
+    "summary": "Returns the categories of the ACL rules, or the commands of a category",
+    "complexity": "O(N) where N is the number of categories or commands",
+    "arguments": [
+      {
+        "name": "category",
+        "type": "string",
+        "optional": true
+      }
+    ],
+    "group": "server"
+  },
+  "ACL TOKEN ADD": {
+    "summary": "Adds a password of the default user that is accepted along with the requirepass, and that expires after the seconds of EX. A password is rotated by adding the old password as a token that expires, and setting the new requirepass",
+    "complexity": "O(N) where N is the number of tokens",
+    "arguments": [
+      {
+        "name": "token",
+        "type": "string"
+      },
+      {
+        "command": "EX",
+        "name": ["seconds"],
+        "type": ["integer"],
+        "optional": true
+      }
+    ],
+    "group": "server"
+  },
+  "ACL TOKEN DEL": {
+    "summary": "Deletes a token of the default user, and returns the number of deleted tokens",
+    "complexity": "O(N) where N is the number of tokens",
+    "arguments": [
+      {
+        "name": "token",
+        "type": "string"
+      }
+    ],
+    "group": "server"
+  },
+  "ACL TOKEN LIST": {
+    "summary": "Returns the digests of the tokens of the default user, and the seconds until they expire, which is -1 for never",
+    "complexity": "O(N) where N is the number of tokens",
+    "arguments": [],
+    "group": "server"
+  },
+  "NAMESPACE SET": {
+    "summary": "Adds a namespace, or changes its quotas. The keys, hooks, and channels of a namespace are only seen by the clients that SELECT it, or whose user of the ACL has the namespace",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
+      },
+      {
+        "command": "MAXKEYS",
+        "name": ["count"],
+        "type": ["integer"],
+        "optional": true
+      },
+      {
+        "command": "MAXOBJECTS",
+        "name": ["count"],
+        "type": ["integer"],
+        "optional": true
+      }
+    ],
+    "group": "server"
+  },
+  "NAMESPACE DEL": {
+    "summary": "Deletes a namespace. Its keys stay in the default namespace",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
+      }
+    ],
+    "group": "server"
+  },
+  "NAMESPACE LIST": {
+    "summary": "Returns the names of the namespaces",
+    "complexity": "O(N) where N is the number of namespaces",
+    "arguments": [],
+    "group": "server"
+  },
+  "NAMESPACE STATS": {
+    "summary": "Returns the number of keys, objects, points, hooks, and channels of a namespace, its memory size, and its quotas",
+    "complexity": "O(N) where N is the number of keys and hooks",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
+      }
+    ],
+    "group": "server"
+  },
+  "OPENAPI": {
+    "summary": "Returns the OpenAPI document of the REST API, which is also served at /openapi.json by the HTTP transport. The REST API has the collections at /keys/{key}, the objects at /keys/{key}/objects/{id}, and the nearby, within, and intersects searches at /keys/{key}/search",
+    "complexity": "O(1)",
+    "arguments": [],
+    "group": "server"
+  },
+  "GRAPHQL": {
+    "summary": "Executes a GraphQL query of the collections, objects, fields, and the nearby, within, intersects, and scan searches, which is also served at /graphql by the HTTP transport when the graphql config property is yes. Returns the json of the GraphQL response with only the selected fields",
+    "complexity": "O(N) where N is the number of selected objects",
+    "arguments": [
+      {
+        "name": "query",
+        "type": "string"
+      },
+      {
+        "name": "variables",
+        "type": "string",
+        "optional": true
+      },
+      {
+        "name": "operation",
+        "type": "string",
+        "optional": true
+      }
+    ],
+    "group": "server"
+  },
+  "GC": {
+    "summary":"Forces a garbage collection",
+    "complexity": "O(1)",
+    "arguments": [],
+    "since": "1.0.0",
+    "group": "server"
+  },
+  "READONLY": {
+    "summary": "Turns on or off readonly mode. Writes from the replication link are still applied. With NOSAVE, the mode is not saved to the config file and lasts until restart",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "enum": ["yes","no"]
+      },
+      {
+        "command": "NOSAVE",
+        "name": [],
+        "type": [],
+        "optional": true
+      }
+    ],
+    "since": "1.0.0",
+    "group": "server"
+  },
+  "FLUSHDB": {
+    "summary":"Removes all keys",
+    "complexity": "O(1)",
+    "arguments": [],
+    "since": "1.0.0",
+    "group": "server"
+  },
+  "FOLLOW": {
+    "summary": "Follows a leader host. With TLS, the leader is connected to over TLS. With KEYS, followed by one or more patterns, only the collections matching the patterns are replicated",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "host",
+        "type": "string"
+      },
+      {
+        "name": "port",
+        "type": "integer"
+      },
+      {
+        "command": "TLS",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "command": "KEYS",
+        "name": ["pattern"],
+        "type": ["pattern"],
+        "optional": true
+      }
+    ],
+    "since": "1.0.0",
+    "group": "replication"
+  },
+  "AOF": {
+    "summary": "Downloads the AOF starting from pos and keeps the connection alive",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "pos",
+        "type": "integer"
+      }
+    ],
+    "since": "1.0.0",
+    "group": "replication"
+  },
+  "AOFMD5": {
+    "summary": "Performs a checksum on a portion of the aof",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "pos",
+        "type": "integer"
+      },
+      {
+        "name": "size",
+        "type": "integer"
+      }
+    ],
+    "since": "1.0.0",
+    "group": "replication"
+  },
+  "AOFSHRINK": {
+    "summary": "Shrinks the aof in the background",
+    "group": "replication"
+  },
+  "AOFCHECK": {
+    "summary": "Checks the aof for an incomplete or corrupt tail, and optionally truncates it to the last valid command",
+    "complexity": "O(N) where N is the size of the aof",
+    "arguments": [
+      {
+        "command": "FIX",
+        "name": [],
+        "type": [],
+        "optional": true
+      }
+    ],
+    "group": "replication"
+  },
+  "RAFT STATE": {
+    "summary": "Shows the role, term, and leader of the server when in raft mode, which is enabled by setting the raftaddr and raftpeers config properties",
+    "complexity": "O(1)",
+    "arguments": [],
+    "group": "replication"
+  },
+  "WAIT": {
+    "summary": "Blocks until the previous writes have been received by a number of followers, or until the timeout in milliseconds is reached. A timeout of 0 blocks forever",
+    "complexity": "O(N) where N is the number of followers",
+    "arguments": [
+      {
+        "name": "numreplicas",
+        "type": "integer"
+      },
+      {
+        "name": "timeout",
+        "type": "integer"
+      }
+    ],
+    "group": "replication"
+  },
+  "WAITOFFSET": {
+    "summary": "Blocks on a follower until it has received the writes of the leader up to an aof offset, or until the timeout in milliseconds is reached. Reads that follow will include those writes",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "offset",
+        "type": "integer"
+      },
+      {
+        "name": "timeout",
+        "type": "integer"
+      }
+    ],
+    "group": "replication"
+  },
+  "PEER": {
+    "summary": "Replicates the writes of another primary, while still accepting writes. Two primaries that peer with each other replicate in both directions, and conflicting writes are resolved by last-writer-wins, with fields merged by the last write of each field. With TLS, the peer is connected to over TLS. Use PEER no one to stop",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "host",
+        "type": "string"
+      },
+      {
+        "name": "port",
+        "type": "integer"
+      },
+      {
+        "command": "TLS",
+        "name": [],
+        "type": [],
+        "optional": true
+      }
+    ],
+    "group": "replication"
+  },
+  "LWW": {
+    "summary": "Applies a write with a version, which is a timestamp in nanoseconds and the id of the server that accepted it. The write is ignored when what it changes has a newer version. Used by PEER",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "timestamp",
+        "type": "integer"
+      },
+      {
+        "name": "origin",
+        "type": "string"
+      },
+      {
+        "name": "command",
+        "type": "string"
+      },
+      {
+        "name": "arg",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "group": "replication"
+  },
+  "REPLICA INFO": {
+    "summary": "Returns the replication lag. On a leader it has the offset of each follower, the bytes of the aof that the follower has not received, the age in seconds of the oldest write that it has not received, and the seconds since it last acknowledged. On a follower it has the same for the follower itself",
+    "complexity": "O(N) where N is the number of followers",
+    "arguments": [],
+    "group": "replication"
+  },
+  "WAITAOF": {
+    "summary": "Syncs the aof to disk, returning once all previous writes are durable",
+    "group": "replication"
+  },
+  "CLUSTER INFO": {
+    "summary": "Returns the state of the cluster, with the number of slots that are assigned and served, and the number of known nodes",
+    "complexity": "O(N) where N is the number of slots",
+    "arguments": [],
+    "group": "cluster"
+  },
+  "CLUSTER MYID": {
+    "summary": "Returns the id of the node",
+    "complexity": "O(1)",
+    "arguments": [],
+    "group": "cluster"
+  },
+  "CLUSTER NODES": {
+    "summary": "Returns the nodes of the cluster, with the address and the slots that each node serves",
+    "complexity": "O(N) where N is the number of slots",
+    "arguments": [],
+    "group": "cluster"
+  },
+  "CLUSTER SLOTS": {
+    "summary": "Returns the ranges of slots and the node that serves each range",
+    "complexity": "O(N) where N is the number of slots",
+    "arguments": [],
+    "group": "cluster"
+  },
+  "CLUSTER KEYSLOT": {
+    "summary": "Returns the slot of a key. When the key has a {tag}, only the tag is hashed, so keys with the same tag are in the same slot",
+    "complexity": "O(N) where N is the length of the key",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      }
+    ],
+    "group": "cluster"
+  },
+  "CLUSTER COUNTKEYSINSLOT": {
+    "summary": "Returns the number of keys in a slot",
+    "complexity": "O(N) where N is the number of keys",
+    "arguments": [
+      {
+        "name": "slot",
+        "type": "integer"
+      }
+    ],
+    "group": "cluster"
+  },
+  "CLUSTER GETKEYSINSLOT": {
+    "summary": "Returns up to count keys in a slot",
+    "complexity": "O(N) where N is the number of keys",
+    "arguments": [
+      {
+        "name": "slot",
+        "type": "integer"
+      },
+      {
+        "name": "count",
+        "type": "integer"
+      }
+    ],
+    "group": "cluster"
+  },
+  "CLUSTER MEET": {
+    "summary": "Adds the node at host and port to the cluster, and exchanges the topology with it",
+    "complexity": "O(N) where N is the number of slots",
+    "arguments": [
+      {
+        "name": "host",
+        "type": "string"
+      },
+      {
+        "name": "port",
+        "type": "integer"
+      }
+    ],
+    "group": "cluster"
+  },
+  "CLUSTER FORGET": {
+    "summary": "Removes a node that serves no slots from the cluster",
+    "complexity": "O(N) where N is the number of slots",
+    "arguments": [
+      {
+        "name": "id",
+        "type": "string"
+      }
+    ],
+    "group": "cluster"
+  },
+  "CLUSTER ADDSLOTS": {
+    "summary": "Assigns unassigned slots to the node",
+    "complexity": "O(N) where N is the number of slots",
+    "arguments": [
+      {
+        "name": "slot",
+        "type": "integer",
+        "multiple": true
+      }
+    ],
+    "group": "cluster"
+  },
+  "CLUSTER ADDSLOTSRANGE": {
+    "summary": "Assigns unassigned ranges of slots to the node",
+    "complexity": "O(N) where N is the number of slots",
+    "arguments": [
+      {
+        "name": ["start", "end"],
+        "type": ["integer", "integer"],
+        "multiple": true
+      }
+    ],
+    "group": "cluster"
+  },
+  "CLUSTER DELSLOTS": {
+    "summary": "Removes slots that are served by the node, leaving them unassigned",
+    "complexity": "O(N) where N is the number of slots",
+    "arguments": [
+      {
+        "name": "slot",
+        "type": "integer",
+        "multiple": true
+      }
+    ],
+    "group": "cluster"
+  },
+  "CLUSTER SETSLOT": {
+    "summary": "Assigns a slot to a node, without moving the keys of the slot",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "slot",
+        "type": "integer"
+      },
+      {
+        "command": "NODE",
+        "name": ["id"],
+        "type": ["string"]
+      }
+    ],
+    "group": "cluster"
+  },
+  "CLUSTER MIGRATE": {
+    "summary": "Moves the keys and hooks of a slot to another node, and then assigns the slot to that node. Writes to the slot return TRYAGAIN while it is migrating. Returns the number of keys that were moved",
+    "complexity": "O(N) where N is the number of objects in the slot",
+    "arguments": [
+      {
+        "name": "slot",
+        "type": "integer"
+      },
+      {
+        "name": "id",
+        "type": "string"
+      }
+    ],
+    "group": "cluster"
+  },
+  "SAVE": {
+    "summary": "Saves a binary snapshot of the dataset to disk",
+    "group": "replication"
+  },
+  "BGSAVE": {
+    "summary": "Saves a binary snapshot of the dataset to disk in the background",
+    "group": "replication"
+  },
+  "BACKUP": {
+    "summary": "Uploads a snapshot of the dataset, or the aof written since the last backup, to S3 or Google Cloud Storage",
+    "complexity": "O(N) where N is the number of objects in the database",
+    "arguments": [
       {
-        "command": "WHERE",
-        "name": ["field","min","max"],
-        "type": ["string","double","double"],
-        "optional": true,
-        "multiple": true
+        "name": "url",
+        "type": "string",
+        "optional": true
       },
       {
-        "command": "WHEREIN",
-        "name": ["field","count","value"],
-        "type": ["string","integer","double"],
+        "command": "INCREMENTAL",
+        "name": [],
+        "type": [],
+        "optional": true
+      }
+    ],
+    "group": "replication"
+  },
+  "RESTORE": {
+    "summary": "Replaces the dataset with a backup, or a point in time, from S3 or Google Cloud Storage",
+    "complexity": "O(N) where N is the number of objects in the backup",
+    "arguments": [
+      {
+        "name": "url",
+        "type": "string",
+        "optional": true
+      },
+      {
+        "name": "name",
+        "type": "string",
+        "optional": true
+      },
+      {
+        "command": "AT",
+        "name": ["timestamp"],
+        "type": ["string"],
         "optional": true,
-        "multiple": true,
-        "variadic": true
+        "multiple": false
+      }
+    ],
+    "group": "replication"
+  },
+  "IMPORT": {
+    "summary": "Loads the features of a GeoJSON FeatureCollection, ESRI Shapefile, GeoPackage, or CSV file into a key",
+    "complexity": "O(N) where N is the number of features in the file",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
       },
       {
-        "command": "WHEREEVAL",
-        "name": ["script","numargs","arg"],
-        "type": ["string","integer","string"],
+        "name": "path",
+        "type": "string"
+      },
+      {
+        "command": "LAYER",
+        "name": ["name"],
+        "type": ["string"],
         "optional": true,
-        "multiple": true,
-        "variadic": true
+        "multiple": false
       },
       {
-        "command": "WHEREEVALSHA",
-        "name": ["sha1","numargs","arg"],
-        "type": ["string","integer","string"],
+        "command": "ID",
+        "name": ["property"],
+        "type": ["string"],
         "optional": true,
-        "multiple": true,
-        "variadic": true
+        "multiple": false
       },
       {
-        "command": "NOFIELDS",
-        "name": [],
-        "type": [],
-        "optional": true
+        "command": "POINT",
+        "name": ["lat", "lon"],
+        "type": ["string", "string"],
+        "optional": true,
+        "multiple": false
       },
       {
-        "name": "type",
+        "command": "FIELD",
+        "name": ["property"],
+        "type": ["string"],
         "optional": true,
-        "enumargs": [
-          {
-            "name": "COUNT"
-          },
-          {
-            "name": "IDS"
-          }
-        ]
+        "multiple": true
       }
     ],
-    "since": "1.4.2",
-    "group": "search"
+    "group": "keys"
   },
-  "SCAN": {
-    "summary": "Incrementally iterate though a key",
-    "complexity": "O(N) where N is the number of ids in the key",
-    "arguments":[
+  "EXPORT": {
+    "summary": "Writes the objects in a key to a GeoJSON FeatureCollection file, or returns the FeatureCollection when the path is omitted. ARROW and PARQUET write a columnar file of the ids, the geometries as WKB, and the fields. The path may be an s3:// or gs:// url",
+    "complexity": "O(N) where N is the number of objects in the key",
+    "arguments": [
       {
         "name": "key",
         "type": "string"
       },
       {
-        "command": "CURSOR",
-        "name": "start",
-        "type": "integer",
+        "name": "format",
+        "enum": ["GEOJSON", "ARROW", "PARQUET"],
         "optional": true
       },
       {
-        "command": "LIMIT",
-        "name": "count",
-        "type": "integer",
+        "name": "path",
+        "type": "string",
         "optional": true
+      }
+    ],
+    "group": "keys"
+  },
+  "DUMP": {
+    "summary": "Writes a consistent dump of the keys that match the patterns, or of every key, to a file while the writes go on. The keys are copied on write, so the writes that follow are not in the dump. COMMANDS writes the commands of an aof, which is the default, and SNAPSHOT writes the format of the snapshot file. The path may be an s3:// or gs:// url. Returns the number of objects",
+    "complexity": "O(N) where N is the number of objects in the keys",
+    "arguments": [
+      {
+        "name": "path",
+        "type": "string"
       },
       {
         "command": "MATCH",
-        "name": "pattern",
-        "type": "pattern",
+        "name": ["pattern"],
+        "type": ["pattern"],
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "name": "format",
+        "enum": ["COMMANDS", "SNAPSHOT"],
+        "optional": true
+      }
+    ],
+    "group": "server"
+  },
+  "PING": {
+    "summary": "Ping the server",
+    "group": "connection"
+  },
+  "QUIT": {
+    "summary": "Close the connection",
+    "group": "connection"
+  },
+  "AUTH": {
+    "summary": "Authenticate to the server with the requirepass, or as a user of the ACL with a username",
+    "arguments": [
+      {
+        "name": "username",
+        "type": "string",
         "optional": true
       },
       {
-        "name": "order",
+        "name": "password",
+        "type": "string"
+      }
+    ],
+    "group": "connection"
+  },
+  "SELECT": {
+    "summary": "Selects the namespace of the connection, or the default namespace, which has every key",
+    "arguments": [
+      {
+        "name": "namespace",
+        "type": "string"
+      }
+    ],
+    "group": "connection"
+  },
+  "HELLO": {
+    "summary": "Switches the protocol of the connection to RESP2 or RESP3, and returns the info of the server. RESP3 replies are typed, and the messages of subscriptions and fences are pushed",
+    "arguments": [
+      {
+        "name": "protover",
+        "type": "integer",
+        "optional": true
+      },
+      {
+        "command": "AUTH",
+        "name": ["username", "password"],
+        "type": ["string", "string"],
+        "optional": true
+      },
+      {
+        "command": "SETNAME",
+        "name": "clientname",
+        "type": "string",
+        "optional": true
+      }
+    ],
+    "group": "connection"
+  },
+  "COMMAND": {
+    "summary": "Returns the commands that the clients may call, which have the new names of the rename-commands config property, and not the disabled commands. LIST is the names of the commands, COUNT is their number, and INFO is the group and the summary of each named command, or null for an unknown command",
+    "complexity": "O(N) where N is the number of commands",
+    "arguments": [
+      {
+        "name": "subcommand",
         "optional": true,
         "enumargs": [
           {
-            "name": "ASC"
+            "name": "LIST"
           },
           {
-            "name": "DESC"
+            "name": "COUNT"
+          },
+          {
+            "name": "INFO",
+            "arguments": [
+              {
+                "name": "name",
+                "type": "string",
+                "multiple": true
+              }
+            ]
           }
-		]
-	  },
+        ]
+      }
+    ],
+    "group": "connection"
+  },
+  "OUTPUT": {
+    "summary": "Gets or sets the output format for the current connection. The msgpack format is the json replies and fence events encoded as MessagePack. The protobuf format is the json replies and fence events encoded as the Reply message of qservice.proto. The csv format is the objects of the replies and fence events as rows of CSV, where the FIELDS are comma separated columns, such as id,lat,lon,speed. The flatbuffers format is the json replies encoded as the Reply table of reply.fbs, which is read in place without parsing. BOUNDS adds the bounding box and the centroid of every object of the json replies and live fences, which are cached for the large objects.",
+    "arguments": [
       {
-        "command": "WHERE",
-        "name": ["field","min","max"],
-        "type": ["string","double","double"],
+        "name": "format",
         "optional": true,
-        "multiple": true
+        "enumargs": [
+          {
+            "name": "json"
+          },
+          {
+            "name": "msgpack"
+          },
+          {
+            "name": "protobuf"
+          },
+          {
+            "name": "flatbuffers"
+          },
+          {
+            "name": "csv",
+            "arguments": [
+              {
+                "command": "FIELDS",
+                "name": "columns",
+                "type": "string",
+                "optional": true
+              }
+            ]
+          },
+          {
+            "name": "resp"
+          }
+        ]
+      },
+      {
+        "command": "BOUNDS",
+        "name": [],
+        "type": [],
+        "optional": true
+      }
+    ],
+    "group": "connection"
+  },
+  "TIMEOUT": {
+    "summary": "Runs the following command with the timeout, which replaces the query-timeout of a search. A search that runs longer is aborted with an error, and a search is canceled when its client disconnects. A search may also have its own TIMEOUT in milliseconds",
+    "arguments": [
+      {
+        "name": "seconds",
+        "type": "double"
+      },
+      {
+        "name": "COMMAND",
+        "type": "string"
+      },
+      {
+        "command": "arg",
+        "type": "string",
+        "multiple": true,
+        "optional": true
+      }
+    ],
+    "group": "connection"
+  },
+  "MULTI": {
+    "summary": "Starts a transaction, queuing the commands that follow",
+    "group": "transactions"
+  },
+  "EXEC": {
+    "summary": "Applies the queued commands of a transaction at once",
+    "group": "transactions"
+  },
+  "DISCARD": {
+    "summary": "Drops the queued commands of a transaction",
+    "group": "transactions"
+  },
+  "WATCH": {
+    "summary": "Fails the following transaction when a key or object is changed",
+    "arguments": [
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "name": "id",
+        "type": "string",
+        "optional": true
+      }
+    ],
+    "group": "transactions"
+  },
+  "UNWATCH": {
+    "summary": "Unwatches the keys and objects watched for a transaction",
+    "group": "transactions"
+  },
+  "SETHOOK": {
+    "summary": "Creates a webhook which points to geofenced search",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
+      },
+      {
+        "name": "endpoint",
+        "type": "string",
+        "description": "The ${NAME} references of the endpoint are replaced with the environment variables when the endpoint is connected, so that the credentials are not stored in the AOF"
       },
       {
-        "command": "WHEREIN",
-        "name": ["field","count","value"],
-        "type": ["string","integer","double"],
+        "command": "META",
+        "name": ["name", "value"],
+        "type": ["string", "string"],
         "optional": true,
-        "multiple": true,
-        "variadic": true
+		    "multiple": true
       },
       {
-        "command": "WHEREEVAL",
-        "name": ["script","numargs","arg"],
-        "type": ["string","integer","string"],
+        "command": "EX",
+        "name": ["seconds"],
+        "type": ["double"],
         "optional": true,
-        "multiple": true,
-        "variadic": true
+        "multiple": false
       },
       {
-        "command": "WHEREEVALSHA",
-        "name": ["sha1","numargs","arg"],
-        "type": ["string","integer","string"],
-        "optional": true,
-        "multiple": true,
-        "variadic": true
+        "command": "REPLACE",
+        "name": [],
+        "type": [],
+        "optional": true
       },
       {
-        "command": "NOFIELDS",
+        "command": "FOLLOWER",
         "name": [],
         "type": [],
         "optional": true
       },
       {
-        "name": "type",
-        "optional": true,
-        "enumargs": [
-          {
-            "name": "COUNT"
-          },
-          {
-            "name": "IDS"
-          },
-          {
-            "name": "OBJECTS"
-          },
-          {
-            "name": "POINTS"
-          },
-          {
-            "name": "BOUNDS"
-          },
-          {
-            "name": "HASHES",
-            "arguments": [
-              {
-                "name": "precision",
-                "type": "integer"
-              }
-            ]
-          }
-        ]
-      }
-    ],
-    "since": "1.0.0",
-    "group": "search"
-  },
-  "NEARBY": {
-    "summary": "Searches for ids that are nearby a point",
-    "complexity": "O(log(N)) where N is the number of ids in the area",
-    "arguments":[
+        "enum": ["NEARBY", "WITHIN", "INTERSECTS"]
+      },
       {
         "name": "key",
         "type": "string"
       },
       {
-        "command": "CURSOR",
-        "name": "start",
-        "type": "integer",
-        "optional": true
+        "command": "FENCE",
+        "name": [],
+        "type": []
       },
       {
-        "command": "LIMIT",
-        "name": "count",
-        "type": "integer",
+        "command": "DETECT",
+        "name": ["what"],
+        "type": ["string"],
         "optional": true
       },
       {
-        "command": "SPARSE",
-        "name": "spread",
-        "type": "integer",
+        "command": "ZRANGE",
+        "name": ["min", "max"],
+        "type": ["double", "double"],
         "optional": true
       },
       {
-        "command": "MATCH",
-        "name": "pattern",
-        "type": "pattern",
+        "command": "COMMANDS",
+        "name": ["which"],
+        "type": ["string"],
         "optional": true
       },
       {
-        "command": "DISTANCE",
-        "name": [],
-        "type": [],
-        "optional": true
-      },
+        "name": "param",
+        "type": "string",
+        "variadic": true
+      }
+
+    ],
+    "group": "webhook"
+  },
+  "DELHOOK": {
+    "summary": "Removes a webhook",
+    "arguments": [
       {
-        "command": "WHERE",
-        "name": ["field","min","max"],
-        "type": ["string","double","double"],
-        "optional": true,
-        "multiple": true
-      },
+        "name": "name",
+        "type": "string"
+      }
+    ],
+    "group": "webhook"
+  },
+  "RENAMEHOOK": {
+    "summary": "Renames a webhook",
+    "arguments": [
       {
-        "command": "WHEREIN",
-        "name": ["field","count","value"],
-        "type": ["string","integer","double"],
-        "optional": true,
-        "multiple": true,
-        "variadic": true
+        "name": "name",
+        "type": "string"
       },
       {
-        "command": "WHEREEVAL",
-        "name": ["script","numargs","arg"],
-        "type": ["string","integer","string"],
-        "optional": true,
-        "multiple": true,
-        "variadic": true
-      },
+        "name": "newname",
+        "type": "string"
+      }
+    ],
+    "group": "webhook"
+  },
+  "PAUSEHOOK": {
+    "summary": "Pauses the delivery of messages for a webhook",
+    "arguments": [
       {
-        "command": "WHEREEVALSHA",
-        "name": ["sha1","numargs","arg"],
-        "type": ["string","integer","string"],
-        "optional": true,
-        "multiple": true,
-        "variadic": true
+        "name": "name",
+        "type": "string"
       },
       {
-        "command": "NOFIELDS",
+        "command": "BUFFER",
         "name": [],
         "type": [],
         "optional": true
+      }
+    ],
+    "group": "webhook"
+  },
+  "PPAUSEHOOK": {
+    "summary": "Pauses all hooks matching a pattern",
+    "arguments": [
+      {
+        "name": "pattern",
+        "type": "pattern"
       },
       {
-        "command": "FENCE",
+        "command": "BUFFER",
         "name": [],
         "type": [],
         "optional": true
-      },
+      }
+    ],
+    "group": "webhook"
+  },
+  "RESUMEHOOK": {
+    "summary": "Resumes the delivery of messages for a webhook",
+    "arguments": [
       {
-        "command": "DETECT",
-        "name": ["what"],
-        "type": ["string"],
-        "optional": true
-      },
+        "name": "name",
+        "type": "string"
+      }
+    ],
+    "group": "webhook"
+  },
+  "PRESUMEHOOK": {
+    "summary": "Resumes all hooks matching a pattern",
+    "arguments": [
       {
-        "command": "COMMANDS",
-        "name": ["which"],
-        "type": ["string"],
-        "optional": true
+        "name": "pattern",
+        "type": "pattern"
+      }
+    ],
+    "group": "webhook"
+  },
+  "FENCETEST": {
+    "summary": "Returns the events a hook would generate for an object",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
       },
       {
-        "name": "type",
-        "optional": true,
+        "name": "value",
         "enumargs": [
           {
-            "name": "COUNT"
-          },
-          {
-            "name": "IDS"
-          },
-          {
-            "name": "OBJECTS"
-          },
-          {
-            "name": "POINTS"
-          },
-          {
-            "name": "BOUNDS"
-          },
-          {
-            "name": "HASHES",
-            "arguments": [
+            "name": "OBJECT",
+            "arguments":[
               {
-                "name": "precision",
-                "type": "integer"
+                "name": "geojson",
+                "type": "geojson"
               }
             ]
-          }
-        ]
-      },
-      {
-        "name": "area",
-        "enumargs": [
+          },
           {
             "name": "POINT",
-            "arguments": [
+            "arguments":[
               {
                 "name": "lat",
                 "type": "double"
@@ -841,156 +3929,84 @@ var commandsJSON = `{
               {
                 "name": "lon",
                 "type": "double"
-              },
-              {
-                "name": "meters",
-                "type": "double"
-              }
-            ]
-          },
-          {
-            "name": "ROAM",
-            "arguments":[
-              {
-                "name": "key",
-                "type": "string"
-              },
-              {
-                "name": "pattern",
-                "type": "pattern"
-              },
-              {
-                "name": "meters",
-                "type": "double"
               }
             ]
           }
         ]
+      },
+      {
+        "name": "id",
+        "type": "string",
+        "optional": true
       }
     ],
-    "since": "1.0.0",
-    "group": "search"
-  },
-  "WITHIN": {
-    "summary": "Searches for ids that completely within the area",
-    "complexity": "O(log(N)) where N is the number of ids in the area",
-    "arguments":[
+    "group": "webhook"
+  },
+  "HOOKREPLAY": {
+    "summary": "Evaluates the writes of the aof with the fence of a hook or a channel, and returns or delivers the events of the writes from an offset of the aof or a time, such as the events that were missed while an endpoint was down. A time is the observation time of the objects. The events have \"replay\":true",
+    "complexity": "O(N) where N is the number of commands in the aof",
+    "arguments": [
       {
-        "name": "key",
+        "name": "name",
         "type": "string"
       },
       {
-        "command": "CURSOR",
-        "name": "start",
-        "type": "integer",
+        "command": "FROM",
+        "name": ["offset_or_time"],
+        "type": ["string"]
+      },
+      {
+        "command": "TO",
+        "name": ["offset_or_time"],
+        "type": ["string"],
         "optional": true
       },
       {
         "command": "LIMIT",
-        "name": "count",
-        "type": "integer",
+        "name": ["count"],
+        "type": ["integer"],
         "optional": true
       },
       {
-        "command": "SPARSE",
-        "name": "spread",
-        "type": "integer",
+        "name": "DELIVER",
+        "enum": ["DELIVER"],
         "optional": true
-      },
+      }
+    ],
+    "group": "webhook"
+  },
+  "HOOKS": {
+    "summary": "Finds all hooks matching a pattern",
+    "arguments":[
       {
-        "command": "MATCH",
         "name": "pattern",
-        "type": "pattern",
-        "optional": true
+        "type": "pattern"
       },
       {
-        "command": "WHERE",
-        "name": ["field","min","max"],
-        "type": ["string","double","double"],
+        "command": "META",
+        "name": ["name", "value"],
+        "type": ["string", "pattern"],
         "optional": true,
         "multiple": true
       },
       {
-        "command": "WHEREIN",
-        "name": ["field","count","value"],
-        "type": ["string","integer","double"],
-        "optional": true,
-        "multiple": true,
-        "variadic": true
-      },
-      {
-        "command": "WHEREEVAL",
-        "name": ["script","numargs","arg"],
-        "type": ["string","integer","string"],
-        "optional": true,
-        "multiple": true,
-        "variadic": true
-      },
-      {
-        "command": "WHEREEVALSHA",
-        "name": ["sha1","numargs","arg"],
-        "type": ["string","integer","string"],
-        "optional": true,
-        "multiple": true,
-        "variadic": true
-      },
-      {
-        "command": "NOFIELDS",
-        "name": [],
-        "type": [],
-        "optional": true
-      },
-      {
-        "command": "FENCE",
-        "name": [],
-        "type": [],
-        "optional": true
-      },
-      {
-        "command": "DETECT",
-        "name": ["what"],
-        "type": ["string"],
-        "optional": true
-      },
-      {
-        "command": "COMMANDS",
-        "name": ["which"],
-        "type": ["string"],
-        "optional": true
-      },
-      {
-        "name": "type",
+        "command": "INTERSECTS",
+        "name": "area",
         "optional": true,
         "enumargs": [
           {
-            "name": "COUNT"
-          },
-          {
-            "name": "IDS"
-          },
-          {
-            "name": "OBJECTS"
-          },
-          {
-            "name": "POINTS"
-          },
-          {
-            "name": "BOUNDS"
-          },
-          {
-            "name": "HASHES",
+            "name": "POINT",
             "arguments": [
               {
-                "name": "precision",
-                "type": "integer"
+                "name": "lat",
+                "type": "double"
+              },
+              {
+                "name": "lon",
+                "type": "double"
               }
             ]
-          }
-        ]
-      },
-      {
-        "name": "area",
-        "enumargs": [
+          },
           {
             "name": "GET",
             "arguments": [
@@ -1085,20 +4101,26 @@ var commandsJSON = `{
                 "type": "geohash"
               }
             ]
+          },
+          {
+            "name": "GEOHASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "HEX",
+            "arguments": [
+              {
+                "name": "h3index",
+                "type": "string"
+              }
+            ]
           }
         ]
-      }
-    ],
-    "since": "1.0.0",
-    "group": "search"
-  },
-  "INTERSECTS": {
-    "summary": "Searches for ids that intersect an area",
-    "complexity": "O(log(N)) where N is the number of ids in the area",
-    "arguments":[
-      {
-        "name": "key",
-        "type": "string"
       },
       {
         "command": "CURSOR",
@@ -1113,111 +4135,205 @@ var commandsJSON = `{
         "optional": true
       },
       {
-        "command": "SPARSE",
-        "name": "spread",
-        "type": "integer",
+        "command": "WITHSTATUS",
+        "name": [],
+        "type": [],
         "optional": true
-      },
+      }
+    ],
+    "group": "webhook"
+  },
+  "PDELHOOK": {
+    "summary": "Removes all hooks matching a pattern",
+    "arguments":[
       {
-        "command": "MATCH",
         "name": "pattern",
-        "type": "pattern",
-        "optional": true
+        "type": "pattern"
+      }
+    ],
+    "group": "webhook"
+  },
+
+  "SETCHAN": {
+    "summary": "Creates a pubsub channel which points to geofenced search",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
       },
       {
-        "command": "WHERE",
-        "name": ["field","min","max"],
-        "type": ["string","double","double"],
+        "command": "META",
+        "name": ["name", "value"],
+        "type": ["string", "string"],
         "optional": true,
-        "multiple": true
+		    "multiple": true
       },
       {
-        "command": "WHEREIN",
-        "name": ["field","count","value"],
-        "type": ["string","integer","double"],
+        "command": "EX",
+        "name": ["seconds"],
+        "type": ["double"],
         "optional": true,
-        "multiple": true,
-        "variadic": true
+        "multiple": false
       },
       {
-        "command": "WHEREEVAL",
-        "name": ["script","numargs","arg"],
-        "type": ["string","integer","string"],
-        "optional": true,
-        "multiple": true,
-        "variadic": true
+        "command": "REPLACE",
+        "name": [],
+        "type": [],
+        "optional": true
       },
       {
-        "command": "WHEREEVALSHA",
-        "name": ["sha1","numargs","arg"],
-        "type": ["string","integer","string"],
-        "optional": true,
-        "multiple": true,
+        "command": "FOLLOWER",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "enum": ["NEARBY", "WITHIN", "INTERSECTS"]
+      },
+      {
+        "name": "key",
+        "type": "string"
+      },
+      {
+        "command": "FENCE",
+        "name": [],
+        "type": []
+      },
+      {
+        "command": "DETECT",
+        "name": ["what"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "command": "ZRANGE",
+        "name": ["min", "max"],
+        "type": ["double", "double"],
+        "optional": true
+      },
+      {
+        "command": "COMMANDS",
+        "name": ["which"],
+        "type": ["string"],
+        "optional": true
+      },
+      {
+        "name": "param",
+        "type": "string",
         "variadic": true
+      }
+
+    ],
+    "group": "pubsub"
+  },
+  "DELCHAN": {
+    "summary": "Removes a channel",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
+      }
+    ],
+    "group": "pubsub"
+  },
+  "RENAMECHAN": {
+    "summary": "Renames a channel",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
       },
       {
-        "command": "CLIP",
-        "name": [],
-        "type": [],
-        "optional": true
+        "name": "newname",
+        "type": "string"
+      }
+    ],
+    "group": "pubsub"
+  },
+  "PAUSECHAN": {
+    "summary": "Pauses the delivery of messages for a channel",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
       },
       {
-        "command": "NOFIELDS",
+        "command": "BUFFER",
         "name": [],
         "type": [],
         "optional": true
+      }
+    ],
+    "group": "pubsub"
+  },
+  "PPAUSECHAN": {
+    "summary": "Pauses all channels matching a pattern",
+    "arguments": [
+      {
+        "name": "pattern",
+        "type": "pattern"
       },
       {
-        "command": "FENCE",
+        "command": "BUFFER",
         "name": [],
         "type": [],
         "optional": true
-      },
+      }
+    ],
+    "group": "pubsub"
+  },
+  "RESUMECHAN": {
+    "summary": "Resumes the delivery of messages for a channel",
+    "arguments": [
       {
-        "command": "DETECT",
-        "name": ["what"],
-        "type": ["string"],
-        "optional": true
+        "name": "name",
+        "type": "string"
+      }
+    ],
+    "group": "pubsub"
+  },
+  "PRESUMECHAN": {
+    "summary": "Resumes all channels matching a pattern",
+    "arguments": [
+      {
+        "name": "pattern",
+        "type": "pattern"
+      }
+    ],
+    "group": "pubsub"
+  },
+  "CHANS": {
+    "summary": "Finds all channels matching a pattern",
+    "arguments":[
+      {
+        "name": "pattern",
+        "type": "pattern"
       },
       {
-        "command": "COMMANDS",
-        "name": ["which"],
-        "type": ["string"],
-        "optional": true
+        "command": "META",
+        "name": ["name", "value"],
+        "type": ["string", "pattern"],
+        "optional": true,
+        "multiple": true
       },
       {
-        "name": "type",
+        "command": "INTERSECTS",
+        "name": "area",
         "optional": true,
         "enumargs": [
           {
-            "name": "COUNT"
-          },
-          {
-            "name": "IDS"
-          },
-          {
-            "name": "OBJECTS"
-          },
-          {
-            "name": "POINTS"
-          },
-          {
-            "name": "BOUNDS"
-          },
-          {
-            "name": "HASHES",
+            "name": "POINT",
             "arguments": [
               {
-                "name": "precision",
-                "type": "integer"
+                "name": "lat",
+                "type": "double"
+              },
+              {
+                "name": "lon",
+                "type": "double"
               }
             ]
-          }
-        ]
-      },
-      {
-        "name": "area",
-        "enumargs": [
+          },
           {
             "name": "GET",
             "arguments": [
@@ -1285,308 +4401,139 @@ var commandsJSON = `{
                 "name": "x",
                 "type": "double"
               },
-              {
-                "name": "y",
-                "type": "double"
-              },
-              {
-                "name": "z",
-                "type": "double"
-              }
-            ]
-          },
-          {
-            "name": "QUADKEY",
-            "arguments":[
-              {
-                "name": "quadkey",
-                "type": "string"
-              }
-            ]
-          },
-          {
-            "name": "HASH",
-            "arguments": [
-              {
-                "name": "geohash",
-                "type": "geohash"
-              }
-            ]
-          }
-        ]
-      }
-    ],
-    "since": "1.0.0",
-    "group": "search"
-  },
-  "CONFIG GET": {
-    "summary": "Get the value of a configuration parameter",
-    "arguments":[
-      {
-        "name": "parameter",
-        "type": "string"
-      }
-    ],
-    "group": "server"
-  },
-  "CONFIG SET": {
-    "summary": "Set a configuration parameter to the given value",
-    "arguments":[
-      {
-        "name": "parameter",
-        "type": "string"
-      },
-      {
-        "name": "value",
-        "type": "string",
-        "optional": true
-      }
-    ],
-    "group": "server"
-  },
-  "CONFIG REWRITE": {
-    "summary": "Rewrite the configuration file with the in memory configuration",
-    "arguments":[],
-    "group": "server"
-  },
-  "SERVER": {
-    "summary":"Show server stats and details",
-    "complexity": "O(1)",
-    "arguments": [],
-    "since": "1.0.0",
-    "group": "server"
-  },
-  "GC": {
-    "summary":"Forces a garbage collection",
-    "complexity": "O(1)",
-    "arguments": [],
-    "since": "1.0.0",
-    "group": "server"
-  },
-  "READONLY": {
-    "summary": "Turns on or off readonly mode",
-    "complexity": "O(1)",
-    "arguments": [
-      {
-        "enum": ["yes","no"]
-      }
-    ],
-    "since": "1.0.0",
-    "group": "server"
-  },
-  "FLUSHDB": {
-    "summary":"Removes all keys",
-    "complexity": "O(1)",
-    "arguments": [],
-    "since": "1.0.0",
-    "group": "server"
-  },
-  "FOLLOW": {
-    "summary": "Follows a leader host",
-    "complexity": "O(1)",
-    "arguments": [
-      {
-        "name": "host",
-        "type": "string"
-      },
-      {
-        "name": "port",
-        "type": "integer"
-      }
-    ],
-    "since": "1.0.0",
-    "group": "replication"
-  },
-  "AOF": {
-    "summary": "Downloads the AOF starting from pos and keeps the connection alive",
-    "complexity": "O(1)",
-    "arguments": [
-      {
-        "name": "pos",
-        "type": "integer"
-      }
-    ],
-    "since": "1.0.0",
-    "group": "replication"
-  },
-  "AOFMD5": {
-    "summary": "Performs a checksum on a portion of the aof",
-    "complexity": "O(1)",
-    "arguments": [
-      {
-        "name": "pos",
-        "type": "integer"
-      },
-      {
-        "name": "size",
-        "type": "integer"
-      }
-    ],
-    "since": "1.0.0",
-    "group": "replication"
-  },
-  "AOFSHRINK": {
-    "summary": "Shrinks the aof in the background",
-    "group": "replication"
-  },
-  "PING": {
-    "summary": "Ping the server",
-    "group": "connection"
-  },
-  "QUIT": {
-    "summary": "Close the connection",
-    "group": "connection"
-  },
-  "AUTH": {
-    "summary": "Authenticate to the server",
-    "arguments": [
-      {
-        "name": "password",
-        "type": "string"
-      }
-    ],
-    "group": "connection"
-  },
-  "OUTPUT": {
-    "summary": "Gets or sets the output format for the current connection.",
-    "arguments": [
-      {
-        "name": "format",
-        "optional": true,
-        "enumargs": [
+              {
+                "name": "y",
+                "type": "double"
+              },
+              {
+                "name": "z",
+                "type": "double"
+              }
+            ]
+          },
           {
-            "name": "json"
+            "name": "QUADKEY",
+            "arguments":[
+              {
+                "name": "quadkey",
+                "type": "string"
+              }
+            ]
           },
           {
-            "name": "resp"
+            "name": "HASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "GEOHASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "HEX",
+            "arguments": [
+              {
+                "name": "h3index",
+                "type": "string"
+              }
+            ]
           }
         ]
-      }
-    ],
-    "group": "connection"
-  },
-  "TIMEOUT": {
-    "summary": "Runs the following command with the timeout",
-    "arguments": [
-      {
-        "name": "seconds",
-        "type": "double"
-      },
-      {
-        "name": "COMMAND",
-        "type": "string"
       },
       {
-        "command": "arg",
-        "type": "string",
-        "multiple": true,
+        "command": "CURSOR",
+        "name": "start",
+        "type": "integer",
         "optional": true
-      }
-    ],
-    "group": "connection"
-  },
-  "SETHOOK": {
-    "summary": "Creates a webhook which points to geofenced search",
-    "arguments": [
-      {
-        "name": "name",
-        "type": "string"
-      },
-      {
-        "name": "endpoint",
-        "type": "string"
-      },
-      {
-        "command": "META",
-        "name": ["name", "value"],
-        "type": ["string", "string"],
-        "optional": true,
-		    "multiple": true
-      },
-      {
-        "command": "EX",
-        "name": ["seconds"],
-        "type": ["double"],
-        "optional": true,
-        "multiple": false
-      },
-      {
-        "enum": ["NEARBY", "WITHIN", "INTERSECTS"]
-      },
-      {
-        "name": "key",
-        "type": "string"
-      },
-      {
-        "command": "FENCE",
-        "name": [],
-        "type": []
       },
       {
-        "command": "DETECT",
-        "name": ["what"],
-        "type": ["string"],
+        "command": "LIMIT",
+        "name": "count",
+        "type": "integer",
         "optional": true
       },
       {
-        "command": "COMMANDS",
-        "name": ["which"],
-        "type": ["string"],
+        "command": "WITHSTATUS",
+        "name": [],
+        "type": [],
         "optional": true
-      },
+      }
+    ],
+    "group": "pubsub"
+  },
+  "PDELCHAN": {
+    "summary": "Removes all channels matching a pattern",
+    "arguments":[
       {
-        "name": "param",
-        "type": "string",
-        "variadic": true
+        "name": "pattern",
+        "type": "pattern"
       }
-
     ],
-    "group": "webhook"
+    "group": "pubsub"
   },
-  "DELHOOK": {
-    "summary": "Removes a webhook",
-    "arguments": [
+  "SUBSCRIBE": {
+    "summary": "Subscribe to a geofence channel",
+    "arguments":[
       {
-        "name": "name",
-        "type": "string"
+        "name": "channel",
+        "type": "string",
+        "variadic": true
       }
     ],
-    "group": "webhook"
+    "group": "pubsub"
   },
-  "HOOKS": {
-    "summary": "Finds all hooks matching a pattern",
+  "PSUBSCRIBE": {
+    "summary": "Subscribes the client to the given patterns",
     "arguments":[
       {
         "name": "pattern",
-        "type": "pattern"
+        "type": "pattern",
+        "variadic": true
       }
     ],
-    "group": "webhook"
+    "group": "pubsub"
   },
-  "PDELHOOK": {
-    "summary": "Removes all hooks matching a pattern",
+  "PDEL": {
+    "summary": "Removes all objects matching a pattern",
     "arguments":[
       {
+        "name": "key",
+        "type": "string"
+      },
+	  {
         "name": "pattern",
         "type": "pattern"
       }
     ],
-    "group": "webhook"
+    "group": "keys"
   },
-
-  "SETCHAN": {
-    "summary": "Creates a pubsub channel which points to geofenced search",
+  "PSET": {
+    "summary": "Sets the values of many ids in one atomic command",
+    "complexity": "O(N) where N is the number of objects",
     "arguments": [
       {
-        "name": "name",
+        "name": "key",
         "type": "string"
       },
       {
-        "command": "META",
+        "name": "id",
+        "type": "string"
+      },
+      {
+        "command": "FIELD",
         "name": ["name", "value"],
         "type": ["string", "string"],
         "optional": true,
-		    "multiple": true
+        "multiple": true
       },
       {
         "command": "EX",
@@ -1596,106 +4543,105 @@ var commandsJSON = `{
         "multiple": false
       },
       {
-        "enum": ["NEARBY", "WITHIN", "INTERSECTS"]
-      },
-      {
-        "name": "key",
-        "type": "string"
-      },
-      {
-        "command": "FENCE",
-        "name": [],
-        "type": []
-      },
-      {
-        "command": "DETECT",
-        "name": ["what"],
+        "command": "TIMESTAMP",
+        "name": ["time"],
         "type": ["string"],
         "optional": true
       },
       {
-        "command": "COMMANDS",
-        "name": ["which"],
-        "type": ["string"],
-        "optional": true
+        "name": "type",
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "NX"
+          },
+          {
+            "name": "XX"
+          }
+		]
+      },
+	  {
+		"name": "value",
+        "enumargs": [
+          {
+            "name": "OBJECT",
+            "arguments":[
+              {
+                "name": "geojson",
+                "type": "geojson"
+              }
+            ]
+          },
+          {
+            "name": "POINT",
+            "arguments":[
+              {
+                "name": "lat",
+                "type": "double"
+              },
+              {
+                "name": "lon",
+                "type": "double"
+              },
+              {
+                "name": "z",
+                "type": "double",
+                "optional": true
+              }
+            ]
+          },
+          {
+            "name": "BOUNDS",
+            "arguments":[
+              {
+                "name": "minlat",
+                "type": "double"
+              },
+              {
+                "name": "minlon",
+                "type": "double"
+              },
+              {
+                "name": "maxlat",
+                "type": "double"
+              },
+              {
+                "name": "maxlon",
+                "type": "double"
+              }
+            ]
+          },
+          {
+            "name": "HASH",
+            "arguments":[
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "STRING",
+            "arguments":[
+              {
+                "name": "value",
+                "type": "string"
+              }
+            ]
+          }
+        ]
       },
       {
-        "name": "param",
-        "type": "string",
-        "variadic": true
-      }
-
-    ],
-    "group": "pubsub"
-  },
-  "DELCHAN": {
-    "summary": "Removes a channel",
-    "arguments": [
-      {
-        "name": "name",
-        "type": "string"
-      }
-    ],
-    "group": "pubsub"
-  },
-  "CHANS": {
-    "summary": "Finds all channels matching a pattern",
-    "arguments":[
-      {
-        "name": "pattern",
-        "type": "pattern"
-      }
-    ],
-    "group": "pubsub"
-  },
-  "PDELCHAN": {
-    "summary": "Removes all channels matching a pattern",
-    "arguments":[
-      {
-        "name": "pattern",
-        "type": "pattern"
-      }
-    ],
-    "group": "pubsub"
-  },
-  "SUBSCRIBE": {
-    "summary": "Subscribe to a geofence channel",
-    "arguments":[
-      {
-        "name": "channel",
+        "name": "object",
         "type": "string",
-        "variadic": true
-      }
-    ],
-    "group": "pubsub"
-  },
-  "PSUBSCRIBE": {
-    "summary": "Subscribes the client to the given patterns",
-    "arguments":[
-      {
-        "name": "pattern",
-        "type": "pattern",
-        "variadic": true
-      }
-    ],
-    "group": "pubsub"
-  },
-  "PDEL": {
-    "summary": "Removes all objects matching a pattern",
-    "arguments":[
-      {
-        "name": "key",
-        "type": "string"
-      },
-	  {
-        "name": "pattern",
-        "type": "pattern"
+        "optional": true,
+        "multiple": true
       }
     ],
     "group": "keys"
   },
   "JGET": {
-    "summary": "Get a value from a JSON document",
+    "summary": "Get a value from a JSON document. A path that starts with a '$' is a JSONPath, which may have wildcards and filter expressions such as $.stops[?(@.status==\"late\")]. With more than one path, the values are returned in an array, with null for a path that does not exist",
     "complexity": "O(1)",
     "arguments":[
       {
@@ -1708,7 +4654,9 @@ var commandsJSON = `{
       },
       {
         "name": "path",
-        "type": "string"
+        "type": "string",
+        "optional": true,
+        "multiple": true
       },
       {
         "command": "RAW",
@@ -1720,7 +4668,7 @@ var commandsJSON = `{
     "group": "keys"
   },
   "JSET": {
-    "summary": "Set a value in a JSON document",
+    "summary": "Set a value in a JSON document. A path that starts with a '$' is a JSONPath without wildcards or filters. With APPEND, the value is added to the end of the array at the path, and with INSERT it is inserted into the array at the index",
     "complexity": "O(1)",
     "arguments":[
       {
@@ -1750,6 +4698,24 @@ var commandsJSON = `{
             "name": "STR"
           }
         ]
+      },
+      {
+        "name": [],
+        "optional": true,
+        "enumargs": [
+          {
+            "name": "APPEND"
+          },
+          {
+            "name": "INSERT",
+            "arguments":[
+              {
+                "name": "index",
+                "type": "integer"
+              }
+            ]
+          }
+        ]
       }
     ],
     "group": "keys"
@@ -1972,6 +4938,160 @@ var commandsJSON = `{
     "since": "1.10.0",
     "group": "scripting"
   },
+  "FUNCTION LOAD":{
+    "summary": "Loads a library of Lua functions",
+    "complexity": "Depends on the library code",
+    "arguments": [
+      {
+        "command": "REPLACE",
+        "name": [],
+        "type": [],
+        "optional": true
+      },
+      {
+        "name": "code",
+        "type": "string"
+      }
+    ],
+    "group": "scripting"
+  },
+  "FUNCTION DELETE":{
+    "summary": "Deletes a library of Lua functions",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "library",
+        "type": "string"
+      }
+    ],
+    "group": "scripting"
+  },
+  "FUNCTION FLUSH":{
+    "summary": "Deletes every library of Lua functions",
+    "complexity": "O(1)",
+    "group": "scripting"
+  },
+  "FUNCTION LIST":{
+    "summary": "Returns the libraries of Lua functions",
+    "complexity": "O(N) where N is the number of functions",
+    "arguments": [
+      {
+        "command": "LIBRARYNAME",
+        "name": "pattern",
+        "type": "pattern",
+        "optional": true
+      },
+      {
+        "command": "WITHCODE",
+        "name": [],
+        "type": [],
+        "optional": true
+      }
+    ],
+    "group": "scripting"
+  },
+  "FCALL":{
+    "summary": "Calls a Lua function of a library",
+    "complexity": "Depends on the called function",
+    "arguments": [
+      {
+        "name": "function",
+        "type": "string"
+      },
+      {
+        "name": "numkeys",
+        "type": "integer"
+      },
+      {
+        "name": "key",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "name": "arg",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "group": "scripting"
+  },
+  "FCALL_RO":{
+    "summary": "Calls a read-only Lua function of a library",
+    "complexity": "Depends on the called function",
+    "arguments": [
+      {
+        "name": "function",
+        "type": "string"
+      },
+      {
+        "name": "numkeys",
+        "type": "integer"
+      },
+      {
+        "name": "key",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      },
+      {
+        "name": "arg",
+        "type": "string",
+        "optional": true,
+        "multiple": true
+      }
+    ],
+    "group": "scripting"
+  },
+  "SETTRIGGER":{
+    "summary": "Calls a Lua function of a library before or after the SET and DEL commands on the keys that match a pattern",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
+      },
+      {
+        "name": "pattern",
+        "type": "pattern"
+      },
+      {
+        "enum": ["BEFORE", "AFTER"]
+      },
+      {
+        "enum": ["SET", "DEL", "ALL"]
+      },
+      {
+        "name": "function",
+        "type": "string"
+      }
+    ],
+    "group": "scripting"
+  },
+  "DELTRIGGER":{
+    "summary": "Removes a trigger",
+    "complexity": "O(1)",
+    "arguments": [
+      {
+        "name": "name",
+        "type": "string"
+      }
+    ],
+    "group": "scripting"
+  },
+  "TRIGGERS":{
+    "summary": "Finds all triggers that match a pattern",
+    "complexity": "O(N) where N is the number of triggers",
+    "arguments": [
+      {
+        "name": "pattern",
+        "type": "pattern",
+        "optional": true
+      }
+    ],
+    "group": "scripting"
+  },
   "TEST":{
     "summary": "Performs spatial test",
     "complexity": "One test per command, complexity depends on the test",
@@ -2086,6 +5206,24 @@ var commandsJSON = `{
                 "type": "geohash"
               }
             ]
+          },
+          {
+            "name": "GEOHASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "HEX",
+            "arguments": [
+              {
+                "name": "h3index",
+                "type": "string"
+              }
+            ]
           }
         ]
       },
@@ -2216,6 +5354,24 @@ var commandsJSON = `{
                 "type": "geohash"
               }
             ]
+          },
+          {
+            "name": "GEOHASH",
+            "arguments": [
+              {
+                "name": "geohash",
+                "type": "geohash"
+              }
+            ]
+          },
+          {
+            "name": "HEX",
+            "arguments": [
+              {
+                "name": "h3index",
+                "type": "string"
+              }
+            ]
           }
         ]
       }
@@ -12,11 +12,79 @@ var ProtectedMode = "no"
 // AppendOnly allows for disabling the appendonly file.
 var AppendOnly = true
 
+// PersistEngine is the storage engine used for persistence, which is "aof"
+// or "kv".
+var PersistEngine = "aof"
+
+// KVFileName allows for custom path of the kv persistence engine
+var KVFileName = ""
+
 // AppendFileName allows for custom appendonly file path
 var AppendFileName = ""
 
 // QueueFileName allows for custom queue.db file path
 var QueueFileName = ""
 
+// SnapshotFileName allows for custom snapshot file path
+var SnapshotFileName = ""
+
+// EncryptionKeyFile is the path to the key used for encrypting the aof and
+// snapshot files.
+var EncryptionKeyFile = ""
+
 // NumThreads is the number of network threads to use.
 var NumThreads int
+
+// TLSPort is the listening port for TLS connections. Zero disables it. The
+// main port only accepts TLS connections when they are the same port.
+var TLSPort int
+
+// GRPCPort is the listening port for the gRPC query service. Zero disables
+// it.
+var GRPCPort int
+
+// TLSCertFile and TLSKeyFile are the certificate and key for TLS connections.
+var TLSCertFile = ""
+var TLSKeyFile = ""
+
+// TLSCACertFile is the CA certificate for verifying the client certificates
+// of TLS connections. Client certificates are not required when empty.
+var TLSCACertFile = ""
+
+// TLSAuthClients is "yes" when the clients of TLS connections must have a
+// certificate that is signed by the TLSCACertFile, "optional" when the
+// certificate is only verified when there is one, or "no".
+var TLSAuthClients = "yes"
+
+// ReloadConfig reads the config file of the server again, and loads the TLS
+// certificates again. It's set when the server starts.
+var ReloadConfig func() error
+
+// Shutdown stops the server before the process exits on SIGTERM, which
+// delivers the queued messages of the hooks and syncs the AOF. It's set when
+// the server starts.
+var Shutdown func()
+
+// Snapper snaps a point to a road network.
+type Snapper interface {
+	Snap(key, id string, lat, lon float64) (slat, slon float64, err error)
+}
+
+// PointSnapper snaps the points of SET, for the keys that match the snapkeys
+// config property, when it's not nil. It's used in place of the snapurl
+// endpoint.
+var PointSnapper Snapper
+
+// H3Indexer returns the cells of the H3 grid, such as with the functions of
+// the github.com/uber/h3-go package.
+type H3Indexer interface {
+	// LatLngToCell returns the cell at a resolution, from 0 to 15, that
+	// contains a point.
+	LatLngToCell(lat, lon float64, res int) uint64
+	// CellToBoundary returns the vertices of a cell, as [lat, lon] pairs.
+	CellToBoundary(cell uint64) [][2]float64
+}
+
+// H3 overrides the built-in indexer of the HEX areas, the H3 output, and the
+// GROUPBY H3:res aggregations of the searches, when it's not nil.
+var H3 H3Indexer
@@ -0,0 +1,168 @@
+package collection
+
+import (
+	"math"
+	"sync"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+)
+
+// boundsCacheSize is the most objects whose bounds and centroid a collection
+// caches. The cache starts over once it's full.
+const boundsCacheSize = 1 << 18
+
+// objectBounds is the bounding box and the centroid of an object.
+type objectBounds struct {
+	rect     geometry.Rect
+	centroid geometry.Point
+}
+
+// boundsCache is the bounds and centroid of the large objects of a
+// collection, see ObjectBounds. Like the json, they are forgotten when the
+// object is replaced or deleted.
+type boundsCache struct {
+	mu sync.RWMutex
+	m  map[geojson.Object]objectBounds
+}
+
+func (bc *boundsCache) get(obj geojson.Object) (objectBounds, bool) {
+	bc.mu.RLock()
+	b, ok := bc.m[obj]
+	bc.mu.RUnlock()
+	return b, ok
+}
+
+func (bc *boundsCache) set(obj geojson.Object, b objectBounds) {
+	bc.mu.Lock()
+	if len(bc.m) >= boundsCacheSize {
+		bc.m = nil
+	}
+	if bc.m == nil {
+		bc.m = make(map[geojson.Object]objectBounds)
+	}
+	bc.m[obj] = b
+	bc.mu.Unlock()
+}
+
+// forget drops the bounds of an object that was replaced or deleted.
+func (bc *boundsCache) forget(obj geojson.Object) {
+	if obj.NumPoints() < jsonCacheMinPoints {
+		return
+	}
+	bc.mu.Lock()
+	delete(bc.m, obj)
+	bc.mu.Unlock()
+}
+
+func (bc *boundsCache) reset() {
+	bc.mu.Lock()
+	bc.m = nil
+	bc.mu.Unlock()
+}
+
+// ObjectBounds returns the bounding box and the centroid of an object of the
+// collection, which are cached for the large objects.
+func (c *Collection) ObjectBounds(obj geojson.Object) (
+	rect geometry.Rect, centroid geometry.Point,
+) {
+	if c.disk != nil || obj.NumPoints() < jsonCacheMinPoints {
+		return obj.Rect(), Centroid(obj)
+	}
+	if b, ok := c.bounds.get(obj); ok {
+		return b.rect, b.centroid
+	}
+	b := objectBounds{rect: obj.Rect(), centroid: Centroid(obj)}
+	c.bounds.set(obj, b)
+	return b.rect, b.centroid
+}
+
+// Centroid returns the centroid of an object, which is the center of mass of
+// its areas, or of its lines when it has no areas, or of its points when it
+// has neither. The center of the bounding box is used for the objects
+// without a geometry.
+func Centroid(obj geojson.Object) geometry.Point {
+	var areas, lines, points centroidSum
+	addCentroid(obj, &areas, &lines, &points)
+	for _, sum := range []*centroidSum{&areas, &lines, &points} {
+		if sum.w > 0 {
+			return geometry.Point{X: sum.x / sum.w, Y: sum.y / sum.w}
+		}
+	}
+	return obj.Center()
+}
+
+// centroidSum is the sum of the weighted centers of the parts of an object.
+type centroidSum struct {
+	x, y, w float64
+}
+
+func (sum *centroidSum) add(p geometry.Point, w float64) {
+	sum.x += p.X * w
+	sum.y += p.Y * w
+	sum.w += w
+}
+
+func addCentroid(obj geojson.Object, areas, lines, points *centroidSum) {
+	switch g := obj.(type) {
+	case *geojson.Polygon:
+		poly := g.Base()
+		addRingCentroid(poly.Exterior, 1, areas)
+		for _, hole := range poly.Holes {
+			addRingCentroid(hole, -1, areas)
+		}
+	case *geojson.Rect:
+		rect := g.Base()
+		w := (rect.Max.X - rect.Min.X) * (rect.Max.Y - rect.Min.Y)
+		if w > 0 {
+			areas.add(rect.Center(), w)
+		} else {
+			points.add(rect.Center(), 1)
+		}
+	case *geojson.LineString:
+		line := g.Base()
+		for i := 0; i < line.NumSegments(); i++ {
+			seg := line.SegmentAt(i)
+			w := math.Hypot(seg.B.X-seg.A.X, seg.B.Y-seg.A.Y)
+			lines.add(geometry.Point{
+				X: (seg.A.X + seg.B.X) / 2,
+				Y: (seg.A.Y + seg.B.Y) / 2,
+			}, w)
+		}
+		if line.NumPoints() > 0 {
+			points.add(line.PointAt(0), 1)
+		}
+	case *geojson.Feature:
+		addCentroid(g.Base(), areas, lines, points)
+	case interface{ Children() []geojson.Object }:
+		for _, child := range g.Children() {
+			addCentroid(child, areas, lines, points)
+		}
+	default:
+		points.add(obj.Center(), 1)
+	}
+}
+
+// addRingCentroid adds the area of a ring, with the sign of a hole, to the
+// sum of the areas.
+func addRingCentroid(ring geometry.Ring, sign float64, areas *centroidSum) {
+	n := ring.NumPoints()
+	if n < 3 {
+		return
+	}
+	var a, cx, cy float64
+	for i := 0; i < n; i++ {
+		p, q := ring.PointAt(i), ring.PointAt((i+1)%n)
+		cross := p.X*q.Y - q.X*p.Y
+		a += cross
+		cx += (p.X + q.X) * cross
+		cy += (p.Y + q.Y) * cross
+	}
+	if a == 0 {
+		return
+	}
+	// the centroid of the ring, weighted by its area, which is positive for
+	// the exteriors and negative for the holes, whatever their winding
+	areas.add(geometry.Point{X: cx / (3 * a), Y: cy / (3 * a)},
+		sign*math.Abs(a)/2)
+}
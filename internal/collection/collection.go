@@ -2,15 +2,18 @@ package collection
 
 import (
 	"runtime"
+	"sync/atomic"
 
 	"github.com/tidwall/btree"
 	"github.com/tidwall/geoindex"
 	"github.com/tidwall/geoindex/algo"
+	"github.com/tidwall/geoindex/child"
 	"github.com/tidwall/geojson"
 	"github.com/tidwall/geojson/geo"
 	"github.com/tidwall/geojson/geometry"
 	"github.com/tidwall/rtree"
 	"github.com/tidwall/tile38/internal/deadline"
+	"github.com/tidwall/tile38/internal/field"
 )
 
 // yieldStep forces the iterator to yield goroutine every 255 steps.
@@ -23,8 +26,12 @@ type Cursor interface {
 }
 
 type itemT struct {
-	id  string
-	obj geojson.Object
+	id      string
+	obj     geojson.Object
+	fields  []field.Value
+	ts      int64  // observation time, unix nano
+	version uint64 // write count, for conditional writes
+	gen     uint64 // the snapshot generation that the item was made in
 }
 
 func byID(a, b interface{}) bool {
@@ -51,11 +58,23 @@ type Collection struct {
 	values      *btree.BTree    // items sorted by value+key
 	fieldMap    map[string]int
 	fieldArr    []string
-	fieldValues map[string][]float64
+	strs        field.Pool              // the shared text of the field values
+	indexes     map[string]*btree.BTree // field name to field index
 	weight      int
+	fieldWeight int // field values part of the weight
+	indexWeight int // field index entries part of the weight
+	idWeight    int // object ids part of the weight
 	points      int
 	objects     int // geometry count
 	nobjects    int // non-geometry count
+	disk        *diskStore
+	loading     bool                  // objects are indexed at the end, see BeginLoad
+	pending     []*itemT              // objects to index at the end of the load
+	opts        *geojson.ParseOptions // the options of the geometries
+	gen         uint64                // the snapshot generation, see Snapshot
+	rebuild     *Rebuild              // the rebuild of the index in progress
+	json        jsonCache             // the json of the large objects
+	bounds      boundsCache           // the bounds of the large objects
 }
 
 // New creates an empty collection
@@ -70,18 +89,62 @@ func New() *Collection {
 	return col
 }
 
-func (c *Collection) setFieldValues(id string, values []float64) {
-	if c.fieldValues == nil {
-		c.fieldValues = make(map[string][]float64)
+func (c *Collection) getItem(id string) *itemT {
+	if v := c.items.Get(&itemT{id: id}); v != nil {
+		return v.(*itemT)
 	}
-	c.fieldValues[id] = values
+	return nil
 }
-func (c *Collection) getFieldValues(id string) (values []float64) {
-	return c.fieldValues[id]
+
+// writable returns the item to change in place. An item that was made
+// before the last snapshot may be held by the snapshot, and is replaced by a
+// copy.
+func (c *Collection) writable(item *itemT) *itemT {
+	if item.gen == c.gen {
+		return item
+	}
+	dup := *item
+	dup.fields = append([]field.Value(nil), item.fields...)
+	dup.gen = c.gen
+	c.items.Set(&dup)
+	if objIsSpatial(dup.obj) {
+		c.indexDelete(item)
+		if c.loading {
+			c.addPending(&dup)
+		} else {
+			c.indexInsert(&dup)
+		}
+	} else {
+		c.values.Set(&dup)
+	}
+	return &dup
 }
-func (c *Collection) deleteFieldValues(id string) {
-	if c.fieldValues != nil {
-		delete(c.fieldValues, id)
+
+// fieldsWeight returns the in-memory cost of field values in bytes.
+func fieldsWeight(values []field.Value) int {
+	var weight int
+	for _, value := range values {
+		weight += value.Weight()
+	}
+	return weight
+}
+
+// internFields returns a copy of the values with the text of the pool of
+// the collection, with no room to spare, since the values are held for as
+// long as the object.
+func (c *Collection) internFields(values []field.Value) []field.Value {
+	interned := make([]field.Value, len(values))
+	for i, value := range values {
+		interned[i] = c.strs.Intern(value)
+	}
+	return interned
+}
+
+// releaseFields drops the values of an object from the pool of the
+// collection.
+func (c *Collection) releaseFields(values []field.Value) {
+	for _, value := range values {
+		c.strs.Release(value)
 	}
 }
 
@@ -105,8 +168,126 @@ func (c *Collection) TotalWeight() int {
 	return c.weight
 }
 
+// Usage is the in-memory cost of objects in bytes, by what it's used for.
+type Usage struct {
+	Geometry int // geometries and strings
+	Fields   int // field values
+	Index    int // entries in the field indexes
+	IDs      int // object ids
+}
+
+// Total returns the in-memory cost in bytes.
+func (u Usage) Total() int {
+	return u.Geometry + u.Fields + u.Index + u.IDs
+}
+
+// Usage returns the in-memory cost of the collection in bytes, which adds
+// up to the TotalWeight.
+func (c *Collection) Usage() Usage {
+	return Usage{
+		Geometry: c.weight - c.fieldWeight - c.indexWeight - c.idWeight,
+		Fields:   c.fieldWeight,
+		Index:    c.indexWeight,
+		IDs:      c.idWeight,
+	}
+}
+
+// ObjectUsage returns the in-memory cost of an object in bytes.
+// If the object does not exist then the 'ok' return value will be false.
+func (c *Collection) ObjectUsage(id string) (u Usage, ok bool) {
+	item := c.getItem(id)
+	if item == nil {
+		return u, false
+	}
+	values := item.fields
+	u.Fields = fieldsWeight(values)
+	u.IDs = len(id)
+	u.Geometry = c.objWeight(item) - u.Fields - u.IDs
+	for name := range c.indexes {
+		idx, ok := c.fieldMap[name]
+		if ok && idx < len(values) && !values[idx].IsZero() {
+			e := indexEntry{value: values[idx], id: id}
+			u.Index += e.weight()
+		}
+	}
+	return u, true
+}
+
+// rtreeMaxEntries is the size of a node of the spatial index.
+const rtreeMaxEntries = 32
+
+// Stats are the details of a collection that are not counted as the objects
+// are written, such as the shape of the spatial index.
+type Stats struct {
+	Points     int     // objects that are a single point
+	Geometries int     // spatial objects that are not a single point
+	IndexDepth int     // levels of the spatial index
+	IndexNodes int     // nodes of the spatial index
+	IndexFill  float64 // the average fill of the nodes, from 0 to 1
+
+	// the spatial objects by their number of points, see ComplexityBounds,
+	// and the object with the most points
+	Complexity  [len(ComplexityBounds) + 1]int
+	MaxPoints   int
+	MaxPointsID string
+}
+
+// ComplexityBounds are the most points of the objects of each bucket of
+// Stats.Complexity. The last bucket is the objects with more points.
+var ComplexityBounds = [...]int{1, 10, 100, 1000, 10000, 100000}
+
+// Stats returns the details of the collection. It visits every object and
+// every node of the spatial index.
+func (c *Collection) Stats() Stats {
+	var stats Stats
+	c.items.Ascend(nil, func(v interface{}) bool {
+		item := v.(*itemT)
+		if objIsSpatial(item.obj) {
+			n := item.obj.NumPoints()
+			if n == 1 {
+				stats.Points++
+			} else {
+				stats.Geometries++
+			}
+			i := 0
+			for i < len(ComplexityBounds) && n > ComplexityBounds[i] {
+				i++
+			}
+			stats.Complexity[i]++
+			if n > stats.MaxPoints {
+				stats.MaxPoints = n
+				stats.MaxPointsID = item.id
+			}
+		}
+		return true
+	})
+	var entries int
+	var reuse []child.Child
+	c.indexPending()
+	level := c.index.Children(nil, nil)
+	for len(level) > 0 {
+		stats.IndexDepth++
+		var next []child.Child
+		for _, node := range level {
+			reuse = c.index.Children(node.Data, reuse[:0])
+			stats.IndexNodes++
+			entries += len(reuse)
+			if len(reuse) > 0 && !reuse[0].Item {
+				next = append(next, reuse...)
+			}
+		}
+		level = next
+	}
+	if stats.IndexNodes > 0 {
+		stats.IndexFill = float64(entries) /
+			float64(stats.IndexNodes*rtreeMaxEntries)
+	}
+	return stats
+}
+
 // Bounds returns the bounds of all the items in the collection.
 func (c *Collection) Bounds() (minX, minY, maxX, maxY float64) {
+	c.indexPending()
 	min, max := c.index.Bounds()
 	if len(min) >= 2 && len(max) >= 2 {
 		return min[0], min[1], max[0], max[1]
@@ -115,18 +296,24 @@ func (c *Collection) Bounds() (minX, minY, maxX, maxY float64) {
 }
 
 func objIsSpatial(obj geojson.Object) bool {
-	_, ok := obj.(geojson.Spatial)
-	return ok
+	switch obj.(type) {
+	case geojson.Spatial, *diskObject:
+		return true
+	}
+	return false
 }
 
 func (c *Collection) objWeight(item *itemT) int {
 	var weight int
-	if objIsSpatial(item.obj) {
+	if _, ok := item.obj.(*diskObject); ok {
+		// only the rect and the location on disk are in memory
+		weight = 64
+	} else if objIsSpatial(item.obj) {
 		weight = item.obj.NumPoints() * 16
 	} else {
 		weight = len(item.obj.String())
 	}
-	return weight + len(c.getFieldValues(item.id))*8 + len(item.id)
+	return weight + fieldsWeight(item.fields) + len(item.id)
 }
 
 func (c *Collection) indexDelete(item *itemT) {
@@ -136,6 +323,7 @@ func (c *Collection) indexDelete(item *itemT) {
 			[2]float64{rect.Min.X, rect.Min.Y},
 			[2]float64{rect.Max.X, rect.Max.Y},
 			item)
+		c.logRebuild(item, false)
 	}
 }
 
@@ -146,6 +334,7 @@ func (c *Collection) indexInsert(item *itemT) {
 			[2]float64{rect.Min.X, rect.Min.Y},
 			[2]float64{rect.Max.X, rect.Max.Y},
 			item)
+		c.logRebuild(item, true)
 	}
 }
 
@@ -155,11 +344,11 @@ func (c *Collection) indexInsert(item *itemT) {
 // The fields argument is optional.
 // The return values are the old object, the old fields, and the new fields
 func (c *Collection) Set(
-	id string, obj geojson.Object, fields []string, values []float64,
+	id string, obj geojson.Object, fields []string, values []field.Value,
 ) (
-	oldObject geojson.Object, oldFields []float64, newFields []float64,
+	oldObject geojson.Object, oldFields []field.Value, newFields []field.Value,
 ) {
-	newItem := &itemT{id: id, obj: obj}
+	newItem := &itemT{id: id, obj: c.storeObject(obj), gen: c.gen}
 
 	// add the new item to main btree and remove the old one if needed
 	oldItem := c.items.Set(newItem)
@@ -179,15 +368,31 @@ func (c *Collection) Set(
 
 		// decrement the weights
 		c.weight -= c.objWeight(oldItem)
+		c.idWeight -= len(id)
+
+		c.json.forget(oldItem.obj)
+		c.bounds.forget(oldItem.obj)
 
 		// references
-		oldObject = oldItem.obj
-		oldFields = c.getFieldValues(id)
+		oldObject = loadObject(oldItem.obj)
+		oldFields = oldItem.fields
 		newFields = oldFields
+
+		// the new item adopts the fields, time, and version of the old one
+		newItem.fields = oldItem.fields
+		if oldItem.gen != c.gen {
+			newItem.fields = append([]field.Value(nil), oldItem.fields...)
+		}
+		newItem.ts = oldItem.ts
+		newItem.version = oldItem.version
 	}
 	// insert the new item into the rtree or strings tree.
 	if objIsSpatial(newItem.obj) {
-		c.indexInsert(newItem)
+		if c.loading {
+			c.addPending(newItem)
+		} else {
+			c.indexInsert(newItem)
+		}
 		c.objects++
 	} else {
 		c.values.Set(newItem)
@@ -199,29 +404,89 @@ func (c *Collection) Set(
 
 	// add the new weights
 	c.weight += c.objWeight(newItem)
+	c.idWeight += len(id)
 
 	if fields == nil {
 		if len(values) > 0 {
 			// directly set the field values, update weight
-			c.weight -= len(newFields) * 8
+			c.weight -= fieldsWeight(newFields)
+			c.fieldWeight -= fieldsWeight(newFields)
+			values = c.internFields(values)
+			c.indexUpdateAll(id, newFields, values)
+			c.releaseFields(newFields)
 			newFields = values
-			c.setFieldValues(id, newFields)
-			c.weight += len(newFields) * 8
+			newItem.fields = newFields
+			c.weight += fieldsWeight(newFields)
+			c.fieldWeight += fieldsWeight(newFields)
 		}
 	} else {
 		// map field name to value
-		for i, field := range fields {
-			c.setField(newItem, field, values[i])
+		for i, name := range fields {
+			c.setField(newItem, name, values[i])
 		}
-		newFields = c.getFieldValues(id)
+		newFields = newItem.fields
 	}
 	return oldObject, oldFields, newFields
 }
 
+// Reparse parses the geometries of the collection again with the options,
+// which builds their geometry indexes to match, such as after the options of
+// the key were changed. It does nothing when the geometries were already
+// parsed with the options. The geometries on disk are parsed with the
+// options as they are read.
+func (c *Collection) Reparse(opts *geojson.ParseOptions) error {
+	if c.opts == opts {
+		return nil
+	}
+	c.indexPending()
+	var olds, news []*itemT
+	var err error
+	c.items.Ascend(nil, func(v interface{}) bool {
+		item := v.(*itemT)
+		switch item.obj.(type) {
+		case *geojson.Point, *geojson.SimplePoint, *geojson.Rect,
+			*geojson.Circle, *diskObject:
+			// nothing to index
+			return true
+		}
+		if !objIsSpatial(item.obj) {
+			return true
+		}
+		var obj geojson.Object
+		obj, err = geojson.Parse(item.obj.String(), opts)
+		if err != nil {
+			return false
+		}
+		dup := *item
+		dup.obj = obj
+		dup.gen = c.gen
+		olds = append(olds, item)
+		news = append(news, &dup)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for i, item := range news {
+		c.items.Set(item)
+		c.indexDelete(olds[i])
+		c.indexInsert(item)
+	}
+	c.opts = opts
+	c.json.reset()
+	c.bounds.reset()
+	if c.disk != nil {
+		c.disk.mu.Lock()
+		c.disk.opts = opts
+		c.disk.mu.Unlock()
+	}
+	return nil
+}
+
 // Delete removes an object and returns it.
 // If the object does not exist then the 'ok' return value will be false.
 func (c *Collection) Delete(id string) (
-	obj geojson.Object, fields []float64, ok bool,
+	obj geojson.Object, fields []field.Value, ok bool,
 ) {
 	oldItemV := c.items.Delete(&itemT{id: id})
 	if oldItemV == nil {
@@ -238,75 +503,124 @@ func (c *Collection) Delete(id string) (
 		c.nobjects--
 	}
 	c.weight -= c.objWeight(oldItem)
+	c.idWeight -= len(id)
 	c.points -= oldItem.obj.NumPoints()
+	c.json.forget(oldItem.obj)
+	c.bounds.forget(oldItem.obj)
 
-	fields = c.getFieldValues(id)
-	c.deleteFieldValues(id)
-	return oldItem.obj, fields, true
+	fields = oldItem.fields
+	c.fieldWeight -= fieldsWeight(fields)
+	c.indexUpdateAll(id, fields, nil)
+	c.releaseFields(fields)
+	return loadObject(oldItem.obj), fields, true
 }
 
 // Get returns an object.
 // If the object does not exist then the 'ok' return value will be false.
 func (c *Collection) Get(id string) (
-	obj geojson.Object, fields []float64, ok bool,
+	obj geojson.Object, fields []field.Value, ok bool,
 ) {
-	itemV := c.items.Get(&itemT{id: id})
-	if itemV == nil {
+	item := c.getItem(id)
+	if item == nil {
 		return nil, nil, false
 	}
-	item := itemV.(*itemT)
-	return item.obj, c.getFieldValues(id), true
+	return loadObject(item.obj), item.fields, true
+}
+
+// SetTimestamp sets the time that an object was observed, in unix
+// nanoseconds, or clears it when ts is zero. The time is removed when the
+// object is deleted.
+func (c *Collection) SetTimestamp(id string, ts int64) {
+	if item := c.getItem(id); item != nil && item.ts != ts {
+		c.writable(item).ts = ts
+	}
+}
+
+// Timestamp returns the time that an object was observed, in unix
+// nanoseconds, or zero when the time is not known.
+func (c *Collection) Timestamp(id string) int64 {
+	if item := c.getItem(id); item != nil {
+		return item.ts
+	}
+	return 0
+}
+
+// SetVersion sets the version of an object, or clears it when v is zero.
+// The version is removed when the object is deleted.
+func (c *Collection) SetVersion(id string, v uint64) {
+	if item := c.getItem(id); item != nil && item.version != v {
+		c.writable(item).version = v
+	}
+}
+
+// Version returns the version of an object, or zero when the object does
+// not exist.
+func (c *Collection) Version(id string) uint64 {
+	if item := c.getItem(id); item != nil {
+		return item.version
+	}
+	return 0
 }
 
 // SetField set a field value for an object and returns that object.
 // If the object does not exist then the 'ok' return value will be false.
-func (c *Collection) SetField(id, field string, value float64) (
-	obj geojson.Object, fields []float64, updated bool, ok bool,
+func (c *Collection) SetField(id, name string, value field.Value) (
+	obj geojson.Object, fields []field.Value, updated bool, ok bool,
 ) {
-	itemV := c.items.Get(&itemT{id: id})
-	if itemV == nil {
+	item := c.getItem(id)
+	if item == nil {
 		return nil, nil, false, false
 	}
-	item := itemV.(*itemT)
-	updated = c.setField(item, field, value)
-	return item.obj, c.getFieldValues(id), updated, true
+	item = c.writable(item)
+	updated = c.setField(item, name, value)
+	return loadObject(item.obj), item.fields, updated, true
 }
 
 // SetFields is similar to SetField, just setting multiple fields at once
 func (c *Collection) SetFields(
-	id string, inFields []string, inValues []float64,
-) (obj geojson.Object, fields []float64, updatedCount int, ok bool) {
-	itemV := c.items.Get(&itemT{id: id})
-	if itemV == nil {
+	id string, inFields []string, inValues []field.Value,
+) (obj geojson.Object, fields []field.Value, updatedCount int, ok bool) {
+	item := c.getItem(id)
+	if item == nil {
 		return nil, nil, 0, false
 	}
-	item := itemV.(*itemT)
-	for idx, field := range inFields {
-		if c.setField(item, field, inValues[idx]) {
+	item = c.writable(item)
+	for idx, name := range inFields {
+		if c.setField(item, name, inValues[idx]) {
 			updatedCount++
 		}
 	}
-	return item.obj, c.getFieldValues(id), updatedCount, true
+	return loadObject(item.obj), item.fields, updatedCount, true
 }
 
-func (c *Collection) setField(item *itemT, field string, value float64) (
+// setField sets a field of an item that can be changed in place.
+func (c *Collection) setField(item *itemT, name string, value field.Value) (
 	updated bool,
 ) {
-	idx, ok := c.fieldMap[field]
+	idx, ok := c.fieldMap[name]
 	if !ok {
 		idx = len(c.fieldMap)
-		c.fieldMap[field] = idx
-		c.addToFieldArr(field)
+		c.fieldMap[name] = idx
+		c.addToFieldArr(name)
 	}
-	fields := c.getFieldValues(item.id)
-	c.weight -= len(fields) * 8
-	for idx >= len(fields) {
-		fields = append(fields, 0)
+	fields := item.fields
+	c.weight -= fieldsWeight(fields)
+	c.fieldWeight -= fieldsWeight(fields)
+	if idx >= len(fields) {
+		// grow to the exact size, since the values are held for as long as
+		// the object
+		grown := make([]field.Value, idx+1)
+		copy(grown, fields)
+		fields = grown
 	}
-	c.weight += len(fields) * 8
+	value = c.strs.Intern(value)
 	ovalue := fields[idx]
 	fields[idx] = value
-	c.setFieldValues(item.id, fields)
+	c.strs.Release(ovalue)
+	c.weight += fieldsWeight(fields)
+	c.fieldWeight += fieldsWeight(fields)
+	item.fields = fields
+	c.indexUpdate(item.id, name, ovalue, value)
 	return ovalue != value
 }
 
@@ -350,7 +664,17 @@ func (c *Collection) Scan(
 	desc bool,
 	cursor Cursor,
 	deadline *deadline.Deadline,
-	iterator func(id string, obj geojson.Object, fields []float64) bool,
+	iterator func(id string, obj geojson.Object, fields []field.Value) bool,
+) bool {
+	return scanItems(c.items, desc, cursor, deadline, iterator)
+}
+
+func scanItems(
+	items *btree.BTree,
+	desc bool,
+	cursor Cursor,
+	deadline *deadline.Deadline,
+	iterator func(id string, obj geojson.Object, fields []field.Value) bool,
 ) bool {
 	var keepon = true
 	var count uint64
@@ -366,13 +690,13 @@ func (c *Collection) Scan(
 		}
 		nextStep(count, cursor, deadline)
 		iitm := item.(*itemT)
-		keepon = iterator(iitm.id, iitm.obj, c.getFieldValues(iitm.id))
+		keepon = iterator(iitm.id, loadObject(iitm.obj), iitm.fields)
 		return keepon
 	}
 	if desc {
-		c.items.Descend(nil, iter)
+		items.Descend(nil, iter)
 	} else {
-		c.items.Ascend(nil, iter)
+		items.Ascend(nil, iter)
 	}
 	return keepon
 }
@@ -383,7 +707,18 @@ func (c *Collection) ScanRange(
 	desc bool,
 	cursor Cursor,
 	deadline *deadline.Deadline,
-	iterator func(id string, obj geojson.Object, fields []float64) bool,
+	iterator func(id string, obj geojson.Object, fields []field.Value) bool,
+) bool {
+	return scanItemsRange(c.items, start, end, desc, cursor, deadline, iterator)
+}
+
+func scanItemsRange(
+	items *btree.BTree,
+	start, end string,
+	desc bool,
+	cursor Cursor,
+	deadline *deadline.Deadline,
+	iterator func(id string, obj geojson.Object, fields []field.Value) bool,
 ) bool {
 	var keepon = true
 	var count uint64
@@ -409,14 +744,14 @@ func (c *Collection) ScanRange(
 			}
 		}
 		iitm := value.(*itemT)
-		keepon = iterator(iitm.id, iitm.obj, c.getFieldValues(iitm.id))
+		keepon = iterator(iitm.id, loadObject(iitm.obj), iitm.fields)
 		return keepon
 	}
 
 	if desc {
-		c.items.Descend(&itemT{id: start}, iter)
+		items.Descend(&itemT{id: start}, iter)
 	} else {
-		c.items.Ascend(&itemT{id: start}, iter)
+		items.Ascend(&itemT{id: start}, iter)
 	}
 	return keepon
 }
@@ -426,7 +761,7 @@ func (c *Collection) SearchValues(
 	desc bool,
 	cursor Cursor,
 	deadline *deadline.Deadline,
-	iterator func(id string, obj geojson.Object, fields []float64) bool,
+	iterator func(id string, obj geojson.Object, fields []field.Value) bool,
 ) bool {
 	var keepon = true
 	var count uint64
@@ -442,7 +777,7 @@ func (c *Collection) SearchValues(
 		}
 		nextStep(count, cursor, deadline)
 		iitm := item.(*itemT)
-		keepon = iterator(iitm.id, iitm.obj, c.getFieldValues(iitm.id))
+		keepon = iterator(iitm.id, loadObject(iitm.obj), iitm.fields)
 		return keepon
 	}
 	if desc {
@@ -457,7 +792,7 @@ func (c *Collection) SearchValues(
 func (c *Collection) SearchValuesRange(start, end string, desc bool,
 	cursor Cursor,
 	deadline *deadline.Deadline,
-	iterator func(id string, obj geojson.Object, fields []float64) bool,
+	iterator func(id string, obj geojson.Object, fields []field.Value) bool,
 ) bool {
 	var keepon = true
 	var count uint64
@@ -473,7 +808,7 @@ func (c *Collection) SearchValuesRange(start, end string, desc bool,
 		}
 		nextStep(count, cursor, deadline)
 		iitm := item.(*itemT)
-		keepon = iterator(iitm.id, iitm.obj, c.getFieldValues(iitm.id))
+		keepon = iterator(iitm.id, loadObject(iitm.obj), iitm.fields)
 		return keepon
 	}
 	pstart := &itemT{obj: String(start)}
@@ -498,7 +833,15 @@ func bGT(tr *btree.BTree, a, b interface{}) bool { return tr.Less(b, a) }
 func (c *Collection) ScanGreaterOrEqual(id string, desc bool,
 	cursor Cursor,
 	deadline *deadline.Deadline,
-	iterator func(id string, obj geojson.Object, fields []float64) bool,
+	iterator func(id string, obj geojson.Object, fields []field.Value) bool,
+) bool {
+	return scanItemsGreaterOrEqual(c.items, id, desc, cursor, deadline, iterator)
+}
+
+func scanItemsGreaterOrEqual(items *btree.BTree, id string, desc bool,
+	cursor Cursor,
+	deadline *deadline.Deadline,
+	iterator func(id string, obj geojson.Object, fields []field.Value) bool,
 ) bool {
 	var keepon = true
 	var count uint64
@@ -514,28 +857,30 @@ func (c *Collection) ScanGreaterOrEqual(id string, desc bool,
 		}
 		nextStep(count, cursor, deadline)
 		iitm := value.(*itemT)
-		keepon = iterator(iitm.id, iitm.obj, c.getFieldValues(iitm.id))
+		keepon = iterator(iitm.id, loadObject(iitm.obj), iitm.fields)
 		return keepon
 	}
 	if desc {
-		c.items.Descend(&itemT{id: id}, iter)
+		items.Descend(&itemT{id: id}, iter)
 	} else {
-		c.items.Ascend(&itemT{id: id}, iter)
+		items.Ascend(&itemT{id: id}, iter)
 	}
 	return keepon
 }
 
 func (c *Collection) geoSearch(
 	rect geometry.Rect,
-	iter func(id string, obj geojson.Object, fields []float64) bool,
+	iter func(id string, obj geojson.Object, fields []field.Value) bool,
 ) bool {
+	c.indexPending()
 	alive := true
 	c.index.Search(
 		[2]float64{rect.Min.X, rect.Min.Y},
 		[2]float64{rect.Max.X, rect.Max.Y},
 		func(_, _ [2]float64, itemv interface{}) bool {
 			item := itemv.(*itemT)
-			alive = iter(item.id, item.obj, c.getFieldValues(item.id))
+			alive = iter(item.id, loadObject(item.obj),
+				item.fields)
 			return alive
 		},
 	)
@@ -544,12 +889,12 @@ func (c *Collection) geoSearch(
 
 func (c *Collection) geoSparse(
 	obj geojson.Object, sparse uint8,
-	iter func(id string, obj geojson.Object, fields []float64) (match, ok bool),
+	iter func(id string, obj geojson.Object, fields []field.Value) (match, ok bool),
 ) bool {
 	matches := make(map[string]bool)
 	alive := true
 	c.geoSparseInner(obj.Rect(), sparse,
-		func(id string, o geojson.Object, fields []float64) (
+		func(id string, o geojson.Object, fields []field.Value) (
 			match, ok bool,
 		) {
 			ok = true
@@ -566,7 +911,7 @@ func (c *Collection) geoSparse(
 }
 func (c *Collection) geoSparseInner(
 	rect geometry.Rect, sparse uint8,
-	iter func(id string, obj geojson.Object, fields []float64) (match, ok bool),
+	iter func(id string, obj geojson.Object, fields []field.Value) (match, ok bool),
 ) bool {
 	if sparse > 0 {
 		w := rect.Max.X - rect.Min.X
@@ -598,7 +943,7 @@ func (c *Collection) geoSparseInner(
 	}
 	alive := true
 	c.geoSearch(rect,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			match, ok := iter(id, obj, fields)
 			if !ok {
 				alive = false
@@ -617,17 +962,17 @@ func (c *Collection) Within(
 	sparse uint8,
 	cursor Cursor,
 	deadline *deadline.Deadline,
-	iter func(id string, obj geojson.Object, fields []float64) bool,
+	iter func(id string, obj geojson.Object, fields []field.Value) bool,
 ) bool {
-	var count uint64
-	var offset uint64
-	if cursor != nil {
-		offset = cursor.Offset()
-		cursor.Step(offset)
-	}
 	if sparse > 0 {
+		var count uint64
+		var offset uint64
+		if cursor != nil {
+			offset = cursor.Offset()
+			cursor.Step(offset)
+		}
 		return c.geoSparse(obj, sparse,
-			func(id string, o geojson.Object, fields []float64) (
+			func(id string, o geojson.Object, fields []field.Value) (
 				match, ok bool,
 			) {
 				count++
@@ -642,19 +987,8 @@ func (c *Collection) Within(
 			},
 		)
 	}
-	return c.geoSearch(obj.Rect(),
-		func(id string, o geojson.Object, fields []float64) bool {
-			count++
-			if count <= offset {
-				return true
-			}
-			nextStep(count, cursor, deadline)
-			if o.Within(obj) {
-				return iter(id, o, fields)
-			}
-			return true
-		},
-	)
+	return c.geoTest(obj.Rect(), cursor, deadline, testsInParallel(obj),
+		func(o geojson.Object) bool { return o.Within(obj) }, iter)
 }
 
 // Intersects returns all object that are intersect an object or bounding box.
@@ -664,17 +998,17 @@ func (c *Collection) Intersects(
 	sparse uint8,
 	cursor Cursor,
 	deadline *deadline.Deadline,
-	iter func(id string, obj geojson.Object, fields []float64) bool,
+	iter func(id string, obj geojson.Object, fields []field.Value) bool,
 ) bool {
-	var count uint64
-	var offset uint64
-	if cursor != nil {
-		offset = cursor.Offset()
-		cursor.Step(offset)
-	}
 	if sparse > 0 {
+		var count uint64
+		var offset uint64
+		if cursor != nil {
+			offset = cursor.Offset()
+			cursor.Step(offset)
+		}
 		return c.geoSparse(obj, sparse,
-			func(id string, o geojson.Object, fields []float64) (
+			func(id string, o geojson.Object, fields []field.Value) (
 				match, ok bool,
 			) {
 				count++
@@ -689,19 +1023,8 @@ func (c *Collection) Intersects(
 			},
 		)
 	}
-	return c.geoSearch(obj.Rect(),
-		func(id string, o geojson.Object, fields []float64) bool {
-			count++
-			if count <= offset {
-				return true
-			}
-			nextStep(count, cursor, deadline)
-			if o.Intersects(obj) {
-				return iter(id, o, fields)
-			}
-			return true
-		},
-	)
+	return c.geoTest(obj.Rect(), cursor, deadline, testsInParallel(obj),
+		func(o geojson.Object) bool { return o.Intersects(obj) }, iter)
 }
 
 // Nearby returns the nearest neighbors
@@ -709,8 +1032,9 @@ func (c *Collection) Nearby(
 	target geojson.Object,
 	cursor Cursor,
 	deadline *deadline.Deadline,
-	iter func(id string, obj geojson.Object, fields []float64) bool,
+	iter func(id string, obj geojson.Object, fields []field.Value) bool,
 ) bool {
+	c.indexPending()
 	// First look to see if there's at least one candidate in the circle's
 	// outer rectangle. This is a fast-fail operation.
 	if circle, ok := target.(*geojson.Circle); ok {
@@ -755,7 +1079,8 @@ func (c *Collection) Nearby(
 			}
 			nextStep(count, cursor, deadline)
 			item := itemv.(*itemT)
-			alive = iter(item.id, item.obj, c.getFieldValues(item.id))
+			alive = iter(item.id, loadObject(item.obj),
+				item.fields)
 			return alive
 		},
 	)
@@ -771,3 +1096,102 @@ func nextStep(step uint64, cursor Cursor, deadline *deadline.Deadline) {
 		cursor.Step(1)
 	}
 }
+
+// Snapshot is a read-only, point-in-time view of a collection's items and
+// fields. It's not affected by later changes to the collection, and can be
+// read without the lock of the collection, while the collection is written.
+type Snapshot struct {
+	items    *btree.BTree
+	fieldMap map[string]int
+	fieldArr []string
+}
+
+// Snapshot returns a point-in-time view of the collection. The items are
+// shared with the collection using a copy-on-write btree, so this operation
+// is fast. An item that the snapshot holds is not changed in place by the
+// collection, which replaces it with a copy the first time that it's written
+// after the snapshot. Many snapshots may be taken at once by the readers of
+// the collection.
+func (c *Collection) Snapshot() *Snapshot {
+	atomic.AddUint64(&c.gen, 1)
+	snap := &Snapshot{
+		items:    c.items.Copy(),
+		fieldMap: make(map[string]int, len(c.fieldMap)),
+		fieldArr: append([]string(nil), c.fieldArr...),
+	}
+	for field, idx := range c.fieldMap {
+		snap.fieldMap[field] = idx
+	}
+	return snap
+}
+
+// Count returns the number of objects in the snapshot.
+func (s *Snapshot) Count() int {
+	return s.items.Len()
+}
+
+// FieldMap return a maps of the field names.
+func (s *Snapshot) FieldMap() map[string]int {
+	return s.fieldMap
+}
+
+// FieldArr return an array representation of the field names.
+func (s *Snapshot) FieldArr() []string {
+	return s.fieldArr
+}
+
+func (s *Snapshot) getItem(id string) *itemT {
+	if v := s.items.Get(&itemT{id: id}); v != nil {
+		return v.(*itemT)
+	}
+	return nil
+}
+
+// Timestamp returns the time that an object was observed, in unix
+// nanoseconds, or zero when the time is not known.
+func (s *Snapshot) Timestamp(id string) int64 {
+	if item := s.getItem(id); item != nil {
+		return item.ts
+	}
+	return 0
+}
+
+// Version returns the version of an object, or zero when it's not known.
+func (s *Snapshot) Version(id string) uint64 {
+	if item := s.getItem(id); item != nil {
+		return item.version
+	}
+	return 0
+}
+
+// Scan iterates though the snapshot ids.
+func (s *Snapshot) Scan(
+	desc bool,
+	cursor Cursor,
+	deadline *deadline.Deadline,
+	iterator func(id string, obj geojson.Object, fields []field.Value) bool,
+) bool {
+	return scanItems(s.items, desc, cursor, deadline, iterator)
+}
+
+// ScanRange iterates though the snapshot starting with specified id.
+func (s *Snapshot) ScanRange(
+	start, end string,
+	desc bool,
+	cursor Cursor,
+	deadline *deadline.Deadline,
+	iterator func(id string, obj geojson.Object, fields []field.Value) bool,
+) bool {
+	return scanItemsRange(s.items, start, end, desc, cursor, deadline, iterator)
+}
+
+// ScanGreaterOrEqual iterates though the snapshot starting with specified
+// id.
+func (s *Snapshot) ScanGreaterOrEqual(id string, desc bool,
+	cursor Cursor,
+	deadline *deadline.Deadline,
+	iterator func(id string, obj geojson.Object, fields []field.Value) bool,
+) bool {
+	return scanItemsGreaterOrEqual(s.items, id, desc, cursor, deadline,
+		iterator)
+}
@@ -2,8 +2,10 @@ package collection
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"reflect"
+	"sort"
 	"strconv"
 	"testing"
 	"time"
@@ -11,12 +13,21 @@ import (
 	"github.com/tidwall/geojson"
 	"github.com/tidwall/geojson/geometry"
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/internal/field"
 )
 
 func PO(x, y float64) *geojson.Point {
 	return geojson.NewPoint(geometry.Point{X: x, Y: y})
 }
 
+func nums(values ...float64) []field.Value {
+	fields := make([]field.Value, len(values))
+	for i, value := range values {
+		fields[i] = field.Num(value)
+	}
+	return fields
+}
+
 func init() {
 	seed := time.Now().UnixNano()
 	println(seed)
@@ -53,7 +64,7 @@ func TestCollectionNewCollection(t *testing.T) {
 		Min: geometry.Point{X: -180, Y: -90},
 		Max: geometry.Point{X: 180, Y: 90},
 	}
-	c.geoSearch(bbox, func(id string, obj geojson.Object, field []float64) bool {
+	c.geoSearch(bbox, func(id string, obj geojson.Object, fields []field.Value) bool {
 		count++
 		return true
 	})
@@ -114,23 +125,23 @@ func TestCollectionSet(t *testing.T) {
 		c := New()
 		str1 := String("hello")
 		fNames := []string{"a", "b", "c"}
-		fValues := []float64{1, 2, 3}
+		fValues := nums(1, 2, 3)
 		oldObj, oldFlds, newFlds := c.Set("str", str1, fNames, fValues)
 		expect(t, oldObj == nil)
 		expect(t, len(oldFlds) == 0)
 		expect(t, reflect.DeepEqual(newFlds, fValues))
 		str2 := String("hello")
 		fNames = []string{"d", "e", "f"}
-		fValues = []float64{4, 5, 6}
+		fValues = nums(4, 5, 6)
 		oldObj, oldFlds, newFlds = c.Set("str", str2, fNames, fValues)
 		expect(t, oldObj == str1)
-		expect(t, reflect.DeepEqual(oldFlds, []float64{1, 2, 3}))
-		expect(t, reflect.DeepEqual(newFlds, []float64{1, 2, 3, 4, 5, 6}))
-		fValues = []float64{7, 8, 9, 10, 11, 12}
+		expect(t, reflect.DeepEqual(oldFlds, nums(1, 2, 3)))
+		expect(t, reflect.DeepEqual(newFlds, nums(1, 2, 3, 4, 5, 6)))
+		fValues = nums(7, 8, 9, 10, 11, 12)
 		oldObj, oldFlds, newFlds = c.Set("str", str1, nil, fValues)
 		expect(t, oldObj == str2)
-		expect(t, reflect.DeepEqual(oldFlds, []float64{1, 2, 3, 4, 5, 6}))
-		expect(t, reflect.DeepEqual(newFlds, []float64{7, 8, 9, 10, 11, 12}))
+		expect(t, reflect.DeepEqual(oldFlds, nums(1, 2, 3, 4, 5, 6)))
+		expect(t, reflect.DeepEqual(newFlds, nums(7, 8, 9, 10, 11, 12)))
 	})
 	t.Run("Delete", func(t *testing.T) {
 		c := New()
@@ -147,7 +158,7 @@ func TestCollectionSet(t *testing.T) {
 			Max: geometry.Point{X: 1, Y: 2}})
 		var v geojson.Object
 		var ok bool
-		var flds []float64
+		var flds []field.Value
 		var updated bool
 		var updateCount int
 
@@ -167,26 +178,26 @@ func TestCollectionSet(t *testing.T) {
 
 		expect(t, len(c.FieldMap()) == 0)
 
-		_, flds, updated, ok = c.SetField("3", "hello", 123)
+		_, flds, updated, ok = c.SetField("3", "hello", field.Num(123))
 		expect(t, ok)
-		expect(t, reflect.DeepEqual(flds, []float64{123}))
+		expect(t, reflect.DeepEqual(flds, nums(123)))
 		expect(t, updated)
 		expect(t, c.FieldMap()["hello"] == 0)
 
-		_, flds, updated, ok = c.SetField("3", "hello", 1234)
+		_, flds, updated, ok = c.SetField("3", "hello", field.Num(1234))
 		expect(t, ok)
-		expect(t, reflect.DeepEqual(flds, []float64{1234}))
+		expect(t, reflect.DeepEqual(flds, nums(1234)))
 		expect(t, updated)
 
-		_, flds, updated, ok = c.SetField("3", "hello", 1234)
+		_, flds, updated, ok = c.SetField("3", "hello", field.Num(1234))
 		expect(t, ok)
-		expect(t, reflect.DeepEqual(flds, []float64{1234}))
+		expect(t, reflect.DeepEqual(flds, nums(1234)))
 		expect(t, !updated)
 
 		_, flds, updateCount, ok = c.SetFields("3",
-			[]string{"planet", "world"}, []float64{55, 66})
+			[]string{"planet", "world"}, nums(55, 66))
 		expect(t, ok)
-		expect(t, reflect.DeepEqual(flds, []float64{1234, 55, 66}))
+		expect(t, reflect.DeepEqual(flds, nums(1234, 55, 66)))
 		expect(t, updateCount == 2)
 		expect(t, c.FieldMap()["hello"] == 0)
 		expect(t, c.FieldMap()["planet"] == 1)
@@ -206,9 +217,9 @@ func TestCollectionSet(t *testing.T) {
 		v, _, ok = c.Get("3")
 		expect(t, v == nil)
 		expect(t, !ok)
-		_, _, _, ok = c.SetField("3", "hello", 123)
+		_, _, _, ok = c.SetField("3", "hello", field.Num(123))
 		expect(t, !ok)
-		_, _, _, ok = c.SetFields("3", []string{"hello"}, []float64{123})
+		_, _, _, ok = c.SetFields("3", []string{"hello"}, nums(123))
 		expect(t, !ok)
 		expect(t, c.TotalWeight() == 0)
 		expect(t, c.FieldMap()["hello"] == 0)
@@ -225,26 +236,26 @@ func TestCollectionScan(t *testing.T) {
 	c := New()
 	for _, i := range rand.Perm(N) {
 		id := fmt.Sprintf("%04d", i)
-		c.Set(id, String(id), []string{"ex"}, []float64{float64(i)})
+		c.Set(id, String(id), []string{"ex"}, nums(float64(i)))
 	}
 	var n int
 	var prevID string
-	c.Scan(false, nil, nil, func(id string, obj geojson.Object, fields []float64) bool {
+	c.Scan(false, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
 		if n > 0 {
 			expect(t, id > prevID)
 		}
-		expect(t, id == fmt.Sprintf("%04d", int(fields[0])))
+		expect(t, id == fmt.Sprintf("%04d", int(fields[0].Num())))
 		n++
 		prevID = id
 		return true
 	})
 	expect(t, n == c.Count())
 	n = 0
-	c.Scan(true, nil, nil, func(id string, obj geojson.Object, fields []float64) bool {
+	c.Scan(true, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
 		if n > 0 {
 			expect(t, id < prevID)
 		}
-		expect(t, id == fmt.Sprintf("%04d", int(fields[0])))
+		expect(t, id == fmt.Sprintf("%04d", int(fields[0].Num())))
 		n++
 		prevID = id
 		return true
@@ -253,11 +264,11 @@ func TestCollectionScan(t *testing.T) {
 
 	n = 0
 	c.ScanRange("0060", "0070", false, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			if n > 0 {
 				expect(t, id > prevID)
 			}
-			expect(t, id == fmt.Sprintf("%04d", int(fields[0])))
+			expect(t, id == fmt.Sprintf("%04d", int(fields[0].Num())))
 			n++
 			prevID = id
 			return true
@@ -266,11 +277,11 @@ func TestCollectionScan(t *testing.T) {
 
 	n = 0
 	c.ScanRange("0070", "0060", true, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			if n > 0 {
 				expect(t, id < prevID)
 			}
-			expect(t, id == fmt.Sprintf("%04d", int(fields[0])))
+			expect(t, id == fmt.Sprintf("%04d", int(fields[0].Num())))
 			n++
 			prevID = id
 			return true
@@ -279,11 +290,11 @@ func TestCollectionScan(t *testing.T) {
 
 	n = 0
 	c.ScanGreaterOrEqual("0070", true, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			if n > 0 {
 				expect(t, id < prevID)
 			}
-			expect(t, id == fmt.Sprintf("%04d", int(fields[0])))
+			expect(t, id == fmt.Sprintf("%04d", int(fields[0].Num())))
 			n++
 			prevID = id
 			return true
@@ -292,11 +303,11 @@ func TestCollectionScan(t *testing.T) {
 
 	n = 0
 	c.ScanGreaterOrEqual("0070", false, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			if n > 0 {
 				expect(t, id > prevID)
 			}
-			expect(t, id == fmt.Sprintf("%04d", int(fields[0])))
+			expect(t, id == fmt.Sprintf("%04d", int(fields[0].Num())))
 			n++
 			prevID = id
 			return true
@@ -312,26 +323,26 @@ func TestCollectionSearch(t *testing.T) {
 		id := fmt.Sprintf("%04d", j)
 		ex := fmt.Sprintf("%04d", i)
 		c.Set(id, String(ex), []string{"i", "j"},
-			[]float64{float64(i), float64(j)})
+			nums(float64(i), float64(j)))
 	}
 	var n int
 	var prevValue string
-	c.SearchValues(false, nil, nil, func(id string, obj geojson.Object, fields []float64) bool {
+	c.SearchValues(false, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
 		if n > 0 {
 			expect(t, obj.String() > prevValue)
 		}
-		expect(t, id == fmt.Sprintf("%04d", int(fields[1])))
+		expect(t, id == fmt.Sprintf("%04d", int(fields[1].Num())))
 		n++
 		prevValue = obj.String()
 		return true
 	})
 	expect(t, n == c.Count())
 	n = 0
-	c.SearchValues(true, nil, nil, func(id string, obj geojson.Object, fields []float64) bool {
+	c.SearchValues(true, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
 		if n > 0 {
 			expect(t, obj.String() < prevValue)
 		}
-		expect(t, id == fmt.Sprintf("%04d", int(fields[1])))
+		expect(t, id == fmt.Sprintf("%04d", int(fields[1].Num())))
 		n++
 		prevValue = obj.String()
 		return true
@@ -340,11 +351,11 @@ func TestCollectionSearch(t *testing.T) {
 
 	n = 0
 	c.SearchValuesRange("0060", "0070", false, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			if n > 0 {
 				expect(t, obj.String() > prevValue)
 			}
-			expect(t, id == fmt.Sprintf("%04d", int(fields[1])))
+			expect(t, id == fmt.Sprintf("%04d", int(fields[1].Num())))
 			n++
 			prevValue = obj.String()
 			return true
@@ -353,11 +364,11 @@ func TestCollectionSearch(t *testing.T) {
 
 	n = 0
 	c.SearchValuesRange("0070", "0060", true, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			if n > 0 {
 				expect(t, obj.String() < prevValue)
 			}
-			expect(t, id == fmt.Sprintf("%04d", int(fields[1])))
+			expect(t, id == fmt.Sprintf("%04d", int(fields[1].Num())))
 			n++
 			prevValue = obj.String()
 			return true
@@ -373,22 +384,22 @@ func TestCollectionWeight(t *testing.T) {
 	expect(t, c.TotalWeight() == 0)
 	c.Set("1", String("1"),
 		[]string{"a", "b", "c"},
-		[]float64{1, 2, 3},
+		nums(1, 2, 3),
 	)
 	expect(t, c.TotalWeight() > 0)
 	c.Delete("1")
 	expect(t, c.TotalWeight() == 0)
 	c.Set("1", String("1"),
 		[]string{"a", "b", "c"},
-		[]float64{1, 2, 3},
+		nums(1, 2, 3),
 	)
 	c.Set("2", String("2"),
 		[]string{"d", "e", "f"},
-		[]float64{4, 5, 6},
+		nums(4, 5, 6),
 	)
 	c.Set("1", String("1"),
 		[]string{"d", "e", "f"},
-		[]float64{4, 5, 6},
+		nums(4, 5, 6),
 	)
 	c.Delete("1")
 	c.Delete("2")
@@ -436,7 +447,7 @@ func TestSpatialSearch(t *testing.T) {
 
 	n = 0
 	c.Within(q1, 0, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			n++
 			return true
 		},
@@ -445,7 +456,7 @@ func TestSpatialSearch(t *testing.T) {
 
 	n = 0
 	c.Within(q2, 0, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			n++
 			return true
 		},
@@ -454,7 +465,7 @@ func TestSpatialSearch(t *testing.T) {
 
 	n = 0
 	c.Within(q3, 0, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			n++
 			return true
 		},
@@ -463,7 +474,7 @@ func TestSpatialSearch(t *testing.T) {
 
 	n = 0
 	c.Intersects(q1, 0, nil, nil,
-		func(_ string, _ geojson.Object, _ []float64) bool {
+		func(_ string, _ geojson.Object, _ []field.Value) bool {
 			n++
 			return true
 		},
@@ -472,7 +483,7 @@ func TestSpatialSearch(t *testing.T) {
 
 	n = 0
 	c.Intersects(q2, 0, nil, nil,
-		func(_ string, _ geojson.Object, _ []float64) bool {
+		func(_ string, _ geojson.Object, _ []field.Value) bool {
 			n++
 			return true
 		},
@@ -481,7 +492,7 @@ func TestSpatialSearch(t *testing.T) {
 
 	n = 0
 	c.Intersects(q3, 0, nil, nil,
-		func(_ string, _ geojson.Object, _ []float64) bool {
+		func(_ string, _ geojson.Object, _ []field.Value) bool {
 			n++
 			return true
 		},
@@ -490,7 +501,7 @@ func TestSpatialSearch(t *testing.T) {
 
 	n = 0
 	c.Intersects(q3, 0, nil, nil,
-		func(_ string, _ geojson.Object, _ []float64) bool {
+		func(_ string, _ geojson.Object, _ []field.Value) bool {
 			n++
 			return n <= 1
 		},
@@ -502,7 +513,7 @@ func TestSpatialSearch(t *testing.T) {
 		r2, p1, p4, r1, p3, r3, p2,
 	}
 	c.Nearby(q4, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			items = append(items, obj)
 			return true
 		},
@@ -528,7 +539,7 @@ func TestCollectionSparse(t *testing.T) {
 	var n int
 	n = 0
 	c.Within(rect, 1, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			n++
 			return true
 		},
@@ -537,7 +548,7 @@ func TestCollectionSparse(t *testing.T) {
 
 	n = 0
 	c.Within(rect, 2, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			n++
 			return true
 		},
@@ -546,7 +557,7 @@ func TestCollectionSparse(t *testing.T) {
 
 	n = 0
 	c.Within(rect, 3, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			n++
 			return true
 		},
@@ -555,7 +566,7 @@ func TestCollectionSparse(t *testing.T) {
 
 	n = 0
 	c.Within(rect, 3, nil, nil,
-		func(id string, obj geojson.Object, fields []float64) bool {
+		func(id string, obj geojson.Object, fields []field.Value) bool {
 			n++
 			return n <= 30
 		},
@@ -564,7 +575,7 @@ func TestCollectionSparse(t *testing.T) {
 
 	n = 0
 	c.Intersects(rect, 3, nil, nil,
-		func(id string, _ geojson.Object, _ []float64) bool {
+		func(id string, _ geojson.Object, _ []field.Value) bool {
 			n++
 			return true
 		},
@@ -573,7 +584,7 @@ func TestCollectionSparse(t *testing.T) {
 
 	n = 0
 	c.Intersects(rect, 3, nil, nil,
-		func(id string, _ geojson.Object, _ []float64) bool {
+		func(id string, _ geojson.Object, _ []field.Value) bool {
 			n++
 			return n <= 30
 		},
@@ -626,7 +637,7 @@ func TestManyCollections(t *testing.T) {
 		Min: geometry.Point{X: -180, Y: 30},
 		Max: geometry.Point{X: 34, Y: 100},
 	}
-	col.geoSearch(bbox, func(id string, obj geojson.Object, fields []float64) bool {
+	col.geoSearch(bbox, func(id string, obj geojson.Object, fields []field.Value) bool {
 		//println(id)
 		return true
 	})
@@ -718,3 +729,518 @@ func BenchmarkRemove(t *testing.B) {
 		}
 	}
 }
+
+func TestCollectionSnapshot(t *testing.T) {
+	N := 256
+	c := New()
+	for i := 0; i < N; i++ {
+		id := fmt.Sprintf("%04d", i)
+		c.Set(id, PO(float64(i), float64(i)), []string{"a"}, nums(float64(i)))
+		c.SetVersion(id, 1)
+	}
+	snap := c.Snapshot()
+	for i := 0; i < N; i++ {
+		id := fmt.Sprintf("%04d", i)
+		if i%2 == 0 {
+			c.Delete(id)
+		} else {
+			c.SetField(id, "a", field.Num(-1))
+			c.SetField(id, "b", field.Num(-1))
+			c.SetVersion(id, 2)
+			c.SetTimestamp(id, 100)
+		}
+	}
+	c.Set("9999", String("new"), nil, nil)
+	expect(t, snap.Count() == N)
+	expect(t, reflect.DeepEqual(snap.FieldArr(), []string{"a"}))
+	var n int
+	snap.Scan(false, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
+		expect(t, id == fmt.Sprintf("%04d", n))
+		expect(t, len(fields) == 1 && fields[0] == field.Num(float64(n)))
+		expect(t, snap.Version(id) == 1 && snap.Timestamp(id) == 0)
+		n++
+		return true
+	})
+	expect(t, n == N)
+
+	// the copies of the changed objects replaced them in the spatial index
+	n = 0
+	c.Intersects(geojson.NewRect(geometry.Rect{Max: geometry.Point{X: float64(N), Y: float64(N)}}),
+		0, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
+			expect(t, reflect.DeepEqual(fields, nums(-1, -1)))
+			expect(t, c.Version(id) == 2 && c.Timestamp(id) == 100)
+			n++
+			return true
+		})
+	expect(t, n == N/2)
+
+	// a snapshot is read while the collection is written
+	snap = c.Snapshot()
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < N; i++ {
+			id := fmt.Sprintf("%04d", i)
+			c.SetField(id, "a", field.Num(float64(i)))
+			c.Set(id, PO(float64(i), float64(i)), nil, nil)
+		}
+		close(done)
+	}()
+	n = 0
+	snap.ScanRange("0100", "0000", true, nil, nil,
+		func(id string, obj geojson.Object, fields []field.Value) bool {
+			expect(t, fields[0] == field.Num(-1))
+			n++
+			return true
+		})
+	<-done
+	expect(t, n == 50)
+}
+
+func TestCollectionTimestamp(t *testing.T) {
+	c := New()
+	c.Set("a", PO(1, 1), nil, nil)
+	c.SetTimestamp("a", 100)
+	expect(t, c.Timestamp("a") == 100)
+	snap := c.Snapshot()
+	// replacing the object keeps its time, until it's set again
+	c.Set("a", PO(2, 2), nil, nil)
+	expect(t, c.Timestamp("a") == 100)
+	c.SetTimestamp("a", 200)
+	expect(t, c.Timestamp("a") == 200 && snap.Timestamp("a") == 100)
+	c.Delete("a")
+	expect(t, c.Timestamp("a") == 0)
+	c.Set("b", PO(1, 1), nil, nil)
+	c.SetTimestamp("b", 100)
+	c.SetTimestamp("b", 0)
+	expect(t, c.Timestamp("b") == 0)
+}
+
+func TestCollectionVersion(t *testing.T) {
+	c := New()
+	c.Set("a", PO(1, 1), nil, nil)
+	c.SetVersion("a", 1)
+	snap := c.Snapshot()
+	c.SetVersion("a", c.Version("a")+1)
+	expect(t, c.Version("a") == 2 && snap.Version("a") == 1)
+	c.Delete("a")
+	expect(t, c.Version("a") == 0)
+}
+
+func TestCollectionDisk(t *testing.T) {
+	c, err := NewDisk(t.TempDir(), geojson.DefaultParseOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c.Disk())
+	poly := func(x, y float64) geojson.Object {
+		obj, err := geojson.Parse(fmt.Sprintf(
+			`{"type":"Feature","id":"p","properties":{"x":%v},`+
+				`"geometry":{"type":"Polygon","coordinates":`+
+				`[[[%v,%v],[%v,%v],[%v,%v],[%v,%v]]]}}`,
+			x, x, y, x+1, y, x+1, y+1, x, y), geojson.DefaultParseOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obj
+	}
+	c.Set("1", poly(0, 0), nil, nil)
+	c.Set("2", poly(10, 10), []string{"a"}, nums(1))
+	c.Set("3", PO(20, 20), nil, nil)
+	c.Set("4", String("hello"), nil, nil)
+	expect(t, c.Count() == 4)
+	expect(t, c.StringCount() == 1)
+	expect(t, c.PointCount() == 9)
+	_, isDisk := c.items.Get(&itemT{id: "1"}).(*itemT).obj.(*diskObject)
+	expect(t, isDisk)
+	_, isDisk = c.items.Get(&itemT{id: "3"}).(*itemT).obj.(*diskObject)
+	expect(t, !isDisk)
+
+	obj, fields, ok := c.Get("2")
+	expect(t, ok && obj.String() == poly(10, 10).String())
+	expect(t, len(fields) == 1 && fields[0] == field.Num(1))
+	_, isDisk = obj.(*diskObject)
+	expect(t, !isDisk)
+
+	old, _, _ := c.Set("1", poly(5, 5), nil, nil)
+	expect(t, old.String() == poly(0, 0).String())
+	snap := c.Snapshot()
+	old, _, ok = c.Delete("2")
+	expect(t, ok && old.String() == poly(10, 10).String())
+
+	var ids []string
+	c.Intersects(poly(5.5, 5.5), 0, nil, nil,
+		func(id string, obj geojson.Object, fields []field.Value) bool {
+			_, isDisk := obj.(*diskObject)
+			expect(t, !isDisk)
+			ids = append(ids, id)
+			return true
+		},
+	)
+	expect(t, reflect.DeepEqual(ids, []string{"1"}))
+	ids = nil
+	c.Nearby(PO(20, 20), nil, nil,
+		func(id string, obj geojson.Object, fields []field.Value) bool {
+			ids = append(ids, id)
+			return true
+		},
+	)
+	expect(t, reflect.DeepEqual(ids, []string{"3", "1"}))
+
+	// the snapshot still reads the deleted geometry
+	snap.Scan(false, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
+		if id == "2" {
+			expect(t, obj.String() == poly(10, 10).String())
+		}
+		return true
+	})
+}
+
+func TestCollectionIndex(t *testing.T) {
+	N := 256
+	c := New()
+	for i := 0; i < N; i++ {
+		id := fmt.Sprintf("%04d", i)
+		c.Set(id, PO(float64(i), float64(i)), []string{"a"}, nums(float64(i%16)))
+	}
+	weight := c.TotalWeight()
+	expect(t, c.AddIndex("a") && !c.AddIndex("a") && c.AddIndex("b"))
+	expect(t, reflect.DeepEqual(c.Indexes(), []string{"a", "b"}))
+	expect(t, c.TotalWeight() > weight)
+	ids := func(name string, pivot field.Value) (ids []string) {
+		c.IndexScan(name, pivot, nil, nil, func(value field.Value, id string,
+			obj geojson.Object, fields []field.Value,
+		) bool {
+			if value != pivot {
+				return false
+			}
+			ids = append(ids, id)
+			return true
+		})
+		return ids
+	}
+	// zeros are not indexed
+	expect(t, len(ids("a", field.Num(0))) == 0)
+	expect(t, len(ids("a", field.Num(3))) == N/16)
+	c.SetField("0003", "a", field.Num(0))
+	c.SetField("0005", "a", field.Num(3))
+	c.SetField("0005", "b", field.Parse("idle"))
+	c.Delete("0019")
+	got := ids("a", field.Num(3))
+	expect(t, len(got) == N/16-1 && got[0] == "0005" && got[1] == "0035")
+	expect(t, reflect.DeepEqual(ids("b", field.Parse("idle")), []string{"0005"}))
+	// replacing the object keeps its fields
+	c.Set("0005", PO(1, 1), nil, nil)
+	expect(t, reflect.DeepEqual(ids("b", field.Parse("idle")), []string{"0005"}))
+	expect(t, c.DeleteIndex("b") && !c.DeleteIndex("b"))
+	expect(t, reflect.DeepEqual(c.Indexes(), []string{"a"}) && !c.HasIndex("b"))
+	for i := 0; i < N; i++ {
+		c.Delete(fmt.Sprintf("%04d", i))
+	}
+	expect(t, c.TotalWeight() == 0)
+}
+
+func TestCollectionFieldPool(t *testing.T) {
+	N := 100
+	c := New()
+	states := []string{"idle", "moving"}
+	for i := 0; i < N; i++ {
+		id := strconv.Itoa(i)
+		c.Set(id, PO(float64(i), float64(i)), []string{"state", "speed"},
+			[]field.Value{field.Parse(states[i%2]), field.Num(float64(i))})
+	}
+	expect(t, c.strs.Len() == 2)
+	_, fields, _ := c.Get("3")
+	expect(t, len(fields) == 2 && cap(fields) == 2)
+	c.SetField("3", "state", field.Parse("parked"))
+	c.Set("4", PO(4, 4), nil, []field.Value{field.Parse("parked")})
+	expect(t, c.strs.Len() == 3)
+	for i := 0; i < N; i++ {
+		c.Delete(strconv.Itoa(i))
+	}
+	expect(t, c.strs.Len() == 0)
+}
+
+func TestCollectionUsage(t *testing.T) {
+	c := New()
+	c.AddIndex("a")
+	c.Set("1", PO(1, 1), []string{"a"}, nums(5))
+	c.Set("22", String("hello"), nil, nil)
+	u, ok := c.ObjectUsage("1")
+	expect(t, ok && u.Geometry == 16 && u.Fields > 0 && u.IDs == 1 &&
+		u.Index == u.Fields+1)
+	u2, ok := c.ObjectUsage("22")
+	expect(t, ok && u2.Geometry == 5 && u2.Fields == 0 && u2.IDs == 2)
+	_, ok = c.ObjectUsage("3")
+	expect(t, !ok)
+	expect(t, c.Usage() == Usage{Geometry: 21, Fields: u.Fields,
+		Index: u.Index, IDs: 3})
+	expect(t, c.Usage().Total() == c.TotalWeight())
+	c.SetField("1", "b", field.Parse("idle"))
+	c.Set("1", PO(2, 2), nil, nil)
+	expect(t, c.Usage().Total() == c.TotalWeight())
+	c.DeleteIndex("a")
+	c.Delete("1")
+	c.Delete("22")
+	expect(t, c.Usage() == Usage{})
+}
+
+func TestCollectionStats(t *testing.T) {
+	c := New()
+	expect(t, c.Stats() == Stats{})
+	for i := 0; i < 1000; i++ {
+		c.Set(strconv.Itoa(i), PO(float64(i%100), float64(i/100)), nil, nil)
+	}
+	c.Set("rect", geojson.NewRect(geometry.Rect{
+		Min: geometry.Point{X: 0, Y: 0}, Max: geometry.Point{X: 1, Y: 1},
+	}), nil, nil)
+	c.Set("str", String("hello"), nil, nil)
+	stats := c.Stats()
+	expect(t, stats.Points == 1000 && stats.Geometries == 1)
+	expect(t, stats.IndexDepth >= 2 &&
+		stats.IndexNodes >= 1001/rtreeMaxEntries+1)
+	expect(t, stats.IndexFill > 0 && stats.IndexFill <= 1)
+	expect(t, stats.Complexity[0] == 1000 && stats.Complexity[1] == 1)
+	expect(t, stats.MaxPoints > 1 && stats.MaxPointsID == "rect")
+}
+
+type testCursor struct{ offset, steps uint64 }
+
+func (c *testCursor) Offset() uint64    { return c.offset }
+func (c *testCursor) Step(count uint64) { c.steps += count }
+
+func TestCollectionParallel(t *testing.T) {
+	defer SetWorkers(func() int { return 1 })
+	c := New()
+	for i := 0; i < 5000; i++ {
+		c.Set(strconv.Itoa(i), PO(rand.Float64()*20-10, rand.Float64()*20-10),
+			nil, nil)
+	}
+	area := geojson.NewCircle(geometry.Point{X: 0, Y: 0}, 800000, 64)
+	search := func(workers int, within bool, offset uint64, limit int,
+	) (ids []string, steps uint64) {
+		SetWorkers(func() int { return workers })
+		cursor := &testCursor{offset: offset}
+		iter := func(id string, _ geojson.Object, _ []field.Value) bool {
+			ids = append(ids, id)
+			return len(ids) < limit
+		}
+		if within {
+			c.Within(area, 0, cursor, nil, iter)
+		} else {
+			c.Intersects(area, 0, cursor, nil, iter)
+		}
+		return ids, cursor.steps
+	}
+	for _, within := range []bool{true, false} {
+		for _, offset := range []uint64{0, 300} {
+			for _, limit := range []int{10, 1000, 10000} {
+				ids1, steps1 := search(1, within, offset, limit)
+				ids2, steps2 := search(8, within, offset, limit)
+				if len(ids1) == 0 || !reflect.DeepEqual(ids1, ids2) {
+					t.Fatalf("within=%t offset=%d limit=%d: results differ",
+						within, offset, limit)
+				}
+				if steps1 != steps2 {
+					t.Fatalf("within=%t offset=%d limit=%d: expected %d "+
+						"steps, got %d", within, offset, limit, steps1, steps2)
+				}
+			}
+		}
+	}
+}
+
+func TestCollectionLoad(t *testing.T) {
+	c1, c2 := New(), New()
+	c2.BeginLoad()
+	expect(t, c2.Loading())
+	set := func(id string, obj geojson.Object) {
+		c1.Set(id, obj, nil, nil)
+		c2.Set(id, obj, nil, nil)
+	}
+	for i := 0; i < 5000; i++ {
+		set(strconv.Itoa(i), PO(rand.Float64()*20-10, rand.Float64()*20-10))
+	}
+	// updates, deletes, and strings while loading
+	for i := 0; i < 5000; i += 3 {
+		set(strconv.Itoa(i), PO(rand.Float64()*20-10, rand.Float64()*20-10))
+	}
+	for i := 1; i < 5000; i += 7 {
+		c1.Delete(strconv.Itoa(i))
+		c2.Delete(strconv.Itoa(i))
+	}
+	set("str", String("hello"))
+	area := geojson.NewRect(geometry.Rect{
+		Min: geometry.Point{X: -5, Y: -5}, Max: geometry.Point{X: 5, Y: 5},
+	})
+	search := func(c *Collection) (ids []string) {
+		c.Intersects(area, 0, nil, nil,
+			func(id string, _ geojson.Object, _ []field.Value) bool {
+				ids = append(ids, id)
+				return true
+			},
+		)
+		sort.Strings(ids)
+		return ids
+	}
+	// a search while loading indexes the pending objects
+	expect(t, reflect.DeepEqual(search(c1), search(c2)))
+	for i := 5000; i < 6000; i++ {
+		set(strconv.Itoa(i), PO(rand.Float64()*20-10, rand.Float64()*20-10))
+	}
+	c2.EndLoad()
+	expect(t, !c2.Loading())
+	expect(t, len(c2.pending) == 0)
+	expect(t, reflect.DeepEqual(search(c1), search(c2)))
+	expect(t, c1.Count() == c2.Count())
+	expect(t, bounds(c1) == bounds(c2))
+	expect(t, c2.Stats().Points == c1.Stats().Points)
+	// the objects are indexed one by one after the load
+	set("a", PO(0, 0))
+	expect(t, reflect.DeepEqual(search(c1), search(c2)))
+
+	// the updates of a long load do not grow the pending list forever
+	c3 := New()
+	c3.BeginLoad()
+	for i := 0; i < 100000; i++ {
+		c3.Set(strconv.Itoa(i%10), PO(rand.Float64(), rand.Float64()), nil, nil)
+	}
+	expect(t, len(c3.pending) <= 2*c3.objects+1024)
+	c3.EndLoad()
+	expect(t, c3.index.Len() == 10)
+}
+
+func TestCollectionRebuild(t *testing.T) {
+	c1, c2 := New(), New()
+	set := func(id string, obj geojson.Object) {
+		c1.Set(id, obj, nil, nil)
+		c2.Set(id, obj, nil, nil)
+	}
+	del := func(id string) {
+		c1.Delete(id)
+		c2.Delete(id)
+	}
+	for i := 0; i < 20000; i++ {
+		set(strconv.Itoa(i), PO(rand.Float64()*20-10, rand.Float64()*20-10))
+	}
+	for i := 0; i < 20000; i++ {
+		if i%10 != 0 {
+			del(strconv.Itoa(i))
+		}
+	}
+	nodes := c2.Stats().IndexNodes
+	r := c2.BeginRebuild()
+	expect(t, r != nil)
+	expect(t, c2.Rebuilding())
+	expect(t, c2.BeginRebuild() == nil)
+	// the writes while the index is built
+	for i := 0; i < 20000; i += 30 {
+		set(strconv.Itoa(i), PO(rand.Float64()*20-10, rand.Float64()*20-10))
+	}
+	for i := 10; i < 20000; i += 70 {
+		del(strconv.Itoa(i))
+	}
+	set("new", PO(0, 0))
+	c2.SetTimestamp("20", 1)
+	r.Build()
+	del("40")
+	r.End()
+	expect(t, !c2.Rebuilding())
+	area := geojson.NewRect(geometry.Rect{
+		Min: geometry.Point{X: -5, Y: -5}, Max: geometry.Point{X: 5, Y: 5},
+	})
+	search := func(c *Collection) (ids []string) {
+		c.Intersects(area, 0, nil, nil,
+			func(id string, _ geojson.Object, _ []field.Value) bool {
+				ids = append(ids, id)
+				return true
+			},
+		)
+		sort.Strings(ids)
+		return ids
+	}
+	expect(t, reflect.DeepEqual(search(c1), search(c2)))
+	expect(t, c2.index.Len() == c1.index.Len())
+	expect(t, c2.Stats().IndexNodes < nodes)
+
+	// a rebuild that is aborted leaves the index as it is
+	r = c2.BeginRebuild()
+	r.Build()
+	r.Abort()
+	expect(t, !c2.Rebuilding())
+	expect(t, reflect.DeepEqual(search(c1), search(c2)))
+}
+
+func TestCollectionObjectJSON(t *testing.T) {
+	c := New()
+	var ring []geometry.Point
+	for i := 0; i < jsonCacheMinPoints; i++ {
+		a := float64(i) / jsonCacheMinPoints * 2 * math.Pi
+		ring = append(ring, geometry.Point{X: 10 * math.Cos(a), Y: 10 * math.Sin(a)})
+	}
+	ring = append(ring, ring[0])
+	poly := geojson.NewPolygon(geometry.NewPoly(ring, nil, nil))
+	c.Set("poly", poly, nil, nil)
+	c.Set("point", PO(1, 2), nil, nil)
+
+	expect(t, c.ObjectJSON(poly) == poly.JSON())
+	expect(t, string(c.AppendObjectJSON([]byte("x"), poly)) == "x"+poly.JSON())
+	expect(t, len(c.json.m) == 1 && c.json.size == len(poly.JSON()))
+	expect(t, c.ObjectJSON(PO(1, 2)) == PO(1, 2).JSON())
+	expect(t, len(c.json.m) == 1)
+
+	// the json is forgotten when the object is replaced or deleted
+	c.Set("poly", PO(3, 4), nil, nil)
+	expect(t, len(c.json.m) == 0 && c.json.size == 0)
+	c.Set("poly", poly, nil, nil)
+	c.ObjectJSON(poly)
+	c.Delete("poly")
+	expect(t, len(c.json.m) == 0 && c.json.size == 0)
+}
+
+func TestCollectionObjectBounds(t *testing.T) {
+	near := func(p geometry.Point, x, y float64) bool {
+		return math.Abs(p.X-x) < 1e-9 && math.Abs(p.Y-y) < 1e-9
+	}
+	square := func(x, y, size float64) []geometry.Point {
+		return []geometry.Point{{X: x, Y: y}, {X: x + size, Y: y},
+			{X: x + size, Y: y + size}, {X: x, Y: y + size}, {X: x, Y: y}}
+	}
+	// an L of three squares, whose centroid is not the center of its box
+	ell := geojson.NewPolygon(geometry.NewPoly([]geometry.Point{
+		{X: 0, Y: 0}, {X: 2, Y: 0}, {X: 2, Y: 1}, {X: 1, Y: 1}, {X: 1, Y: 2},
+		{X: 0, Y: 2}, {X: 0, Y: 0},
+	}, nil, nil))
+	expect(t, near(Centroid(ell), 5.0/6, 5.0/6))
+	// a hole moves the centroid away from it
+	holed := geojson.NewPolygon(geometry.NewPoly(square(0, 0, 4),
+		[][]geometry.Point{square(2, 0, 2)}, nil))
+	expect(t, near(Centroid(holed), 5.0/3, 7.0/3))
+	line := geojson.NewLineString(geometry.NewLine([]geometry.Point{
+		{X: 0, Y: 0}, {X: 3, Y: 0}, {X: 3, Y: 1},
+	}, nil))
+	expect(t, near(Centroid(line), 15.0/8, 1.0/8))
+	multi := geojson.NewMultiPoint([]geometry.Point{{X: 0, Y: 0}, {X: 2, Y: 4}})
+	expect(t, near(Centroid(multi), 1, 2))
+	// the points of a collection with an area are ignored
+	coll := geojson.NewGeometryCollection([]geojson.Object{PO(100, 100),
+		geojson.NewPolygon(geometry.NewPoly(square(0, 0, 2), nil, nil))})
+	expect(t, near(Centroid(coll), 1, 1))
+
+	c := New()
+	var ring []geometry.Point
+	for i := 0; i < jsonCacheMinPoints; i++ {
+		a := float64(i) / jsonCacheMinPoints * 2 * math.Pi
+		ring = append(ring, geometry.Point{X: 10 + math.Cos(a), Y: 20 + math.Sin(a)})
+	}
+	ring = append(ring, ring[0])
+	poly := geojson.NewPolygon(geometry.NewPoly(ring, nil, nil))
+	c.Set("poly", poly, nil, nil)
+	rect, centroid := c.ObjectBounds(poly)
+	expect(t, rect == poly.Rect() && near(centroid, 10, 20))
+	expect(t, len(c.bounds.m) == 1)
+	c.ObjectBounds(PO(1, 2))
+	expect(t, len(c.bounds.m) == 1)
+	// the bounds are forgotten when the object is deleted
+	c.Delete("poly")
+	expect(t, len(c.bounds.m) == 0)
+}
@@ -0,0 +1,186 @@
+package collection
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+)
+
+// diskStore is an append-only file that holds the geometries of a disk
+// backed collection. The file is unlinked as soon as it's created, so the
+// space is returned to the filesystem when the collection, and every
+// snapshot that refers to it, is garbage collected. There's no compaction,
+// replaced and deleted geometries are reclaimed when the collection is
+// dropped or the server restarts.
+type diskStore struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	opts *geojson.ParseOptions
+}
+
+func openDiskStore(dir string, opts *geojson.ParseOptions) (*diskStore, error) {
+	f, err := ioutil.TempFile(dir, "collection-*.dat")
+	if err != nil {
+		return nil, err
+	}
+	// Windows does not allow for removing open files, in which case the
+	// file is left behind.
+	os.Remove(f.Name())
+	store := &diskStore{f: f, opts: opts}
+	runtime.SetFinalizer(store, func(store *diskStore) { store.f.Close() })
+	return store, nil
+}
+
+func (store *diskStore) write(data []byte) (off int64, err error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	off = store.size
+	if _, err := store.f.WriteAt(data, off); err != nil {
+		return 0, err
+	}
+	store.size += int64(len(data))
+	return off, nil
+}
+
+// diskObject is a geometry that's stored in a diskStore. The rect and the
+// number of points are kept in memory for the spatial index and the
+// collection stats, everything else reads the geometry from disk.
+type diskObject struct {
+	store     *diskStore
+	off       int64
+	size      int
+	rect      geometry.Rect
+	numPoints int
+	empty     bool
+}
+
+func (o *diskObject) load() geojson.Object {
+	data := make([]byte, o.size)
+	if _, err := o.store.f.ReadAt(data, o.off); err != nil {
+		panic(err)
+	}
+	o.store.mu.Lock()
+	opts := o.store.opts
+	o.store.mu.Unlock()
+	obj, err := geojson.Parse(string(data), opts)
+	if err != nil {
+		panic(err)
+	}
+	return obj
+}
+
+// loadObject returns the geometry for objects that are stored on disk, and
+// the object itself for everything else.
+func loadObject(obj geojson.Object) geojson.Object {
+	if o, ok := obj.(*diskObject); ok {
+		return o.load()
+	}
+	return obj
+}
+
+// Empty ...
+func (o *diskObject) Empty() bool { return o.empty }
+
+// Valid ...
+func (o *diskObject) Valid() bool { return o.load().Valid() }
+
+// Rect ...
+func (o *diskObject) Rect() geometry.Rect { return o.rect }
+
+// Center ...
+func (o *diskObject) Center() geometry.Point { return o.rect.Center() }
+
+// Contains ...
+func (o *diskObject) Contains(other geojson.Object) bool {
+	return o.load().Contains(other)
+}
+
+// Within ...
+func (o *diskObject) Within(other geojson.Object) bool {
+	return o.load().Within(other)
+}
+
+// Intersects ...
+func (o *diskObject) Intersects(other geojson.Object) bool {
+	return o.load().Intersects(other)
+}
+
+// AppendJSON ...
+func (o *diskObject) AppendJSON(dst []byte) []byte {
+	return o.load().AppendJSON(dst)
+}
+
+// JSON ...
+func (o *diskObject) JSON() string { return o.load().JSON() }
+
+// String ...
+func (o *diskObject) String() string { return o.load().String() }
+
+// Distance ...
+func (o *diskObject) Distance(obj geojson.Object) float64 {
+	return o.load().Distance(obj)
+}
+
+// NumPoints ...
+func (o *diskObject) NumPoints() int { return o.numPoints }
+
+// ForEach ...
+func (o *diskObject) ForEach(iter func(geom geojson.Object) bool) bool {
+	return o.load().ForEach(iter)
+}
+
+// Spatial ...
+func (o *diskObject) Spatial() geojson.Spatial { return o.load().Spatial() }
+
+// MarshalJSON ...
+func (o *diskObject) MarshalJSON() ([]byte, error) {
+	return o.load().MarshalJSON()
+}
+
+// NewDisk creates an empty collection that stores the geometries in a file
+// in dir, rather than in memory. The ids, fields, and the spatial index stay
+// in memory. Points and strings are small, and are always kept in memory.
+func NewDisk(dir string, opts *geojson.ParseOptions) (*Collection, error) {
+	store, err := openDiskStore(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	col := New()
+	col.disk = store
+	return col, nil
+}
+
+// Disk returns true when the collection stores its geometries on disk.
+func (c *Collection) Disk() bool {
+	return c.disk != nil
+}
+
+// storeObject writes the object to the collection's disk store, returning the
+// object that's stored in the collection instead.
+func (c *Collection) storeObject(obj geojson.Object) geojson.Object {
+	if c.disk == nil || !objIsSpatial(obj) {
+		return obj
+	}
+	switch obj.(type) {
+	case *diskObject, *geojson.Point, *geojson.SimplePoint:
+		return obj
+	}
+	data := obj.AppendJSON(nil)
+	off, err := c.disk.write(data)
+	if err != nil {
+		panic(err)
+	}
+	return &diskObject{
+		store:     c.disk,
+		off:       off,
+		size:      len(data),
+		rect:      obj.Rect(),
+		numPoints: obj.NumPoints(),
+		empty:     obj.Empty(),
+	}
+}
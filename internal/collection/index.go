@@ -0,0 +1,175 @@
+package collection
+
+import (
+	"sort"
+
+	"github.com/tidwall/btree"
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/tile38/internal/deadline"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+// indexEntry is the value of a field of an object in a field index
+type indexEntry struct {
+	value field.Value
+	id    string
+}
+
+func byIndexEntry(a, b interface{}) bool {
+	ea, eb := a.(*indexEntry), b.(*indexEntry)
+	if field.Less(ea.value, eb.value) {
+		return true
+	}
+	if field.Less(eb.value, ea.value) {
+		return false
+	}
+	return ea.id < eb.id
+}
+
+func (e *indexEntry) weight() int {
+	return e.value.Weight() + len(e.id)
+}
+
+// AddIndex adds an index on a field, which orders the objects that have a
+// value for the field by that value. Returns false when the field is already
+// indexed.
+func (c *Collection) AddIndex(name string) bool {
+	if c.indexes[name] != nil {
+		return false
+	}
+	if c.indexes == nil {
+		c.indexes = make(map[string]*btree.BTree)
+	}
+	tr := btree.New(byIndexEntry)
+	if idx, ok := c.fieldMap[name]; ok {
+		c.items.Ascend(nil, func(v interface{}) bool {
+			item := v.(*itemT)
+			if idx < len(item.fields) && !item.fields[idx].IsZero() {
+				e := &indexEntry{value: item.fields[idx], id: item.id}
+				tr.Set(e)
+				c.weight += e.weight()
+				c.indexWeight += e.weight()
+			}
+			return true
+		})
+	}
+	c.indexes[name] = tr
+	return true
+}
+
+// DeleteIndex removes the index on a field. Returns false when the field is
+// not indexed.
+func (c *Collection) DeleteIndex(name string) bool {
+	tr := c.indexes[name]
+	if tr == nil {
+		return false
+	}
+	tr.Ascend(nil, func(item interface{}) bool {
+		c.weight -= item.(*indexEntry).weight()
+		c.indexWeight -= item.(*indexEntry).weight()
+		return true
+	})
+	delete(c.indexes, name)
+	return true
+}
+
+// HasIndex returns true when the field is indexed.
+func (c *Collection) HasIndex(name string) bool {
+	return c.indexes[name] != nil
+}
+
+// Indexes returns the indexed fields.
+func (c *Collection) Indexes() []string {
+	names := make([]string, 0, len(c.indexes))
+	for name := range c.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// indexUpdate changes the value of a field of an object in the index of the
+// field, if any. Zero values are not indexed.
+func (c *Collection) indexUpdate(id, name string, oldValue, newValue field.Value) {
+	tr := c.indexes[name]
+	if tr == nil || oldValue == newValue {
+		return
+	}
+	if !oldValue.IsZero() {
+		if item := tr.Delete(&indexEntry{value: oldValue, id: id}); item != nil {
+			c.weight -= item.(*indexEntry).weight()
+			c.indexWeight -= item.(*indexEntry).weight()
+		}
+	}
+	if !newValue.IsZero() {
+		e := &indexEntry{value: newValue, id: id}
+		tr.Set(e)
+		c.weight += e.weight()
+		c.indexWeight += e.weight()
+	}
+}
+
+// indexUpdateAll changes all of the field values of an object in the
+// indexes.
+func (c *Collection) indexUpdateAll(id string, oldValues, newValues []field.Value) {
+	for name := range c.indexes {
+		idx, ok := c.fieldMap[name]
+		if !ok {
+			continue
+		}
+		var oldValue, newValue field.Value
+		if idx < len(oldValues) {
+			oldValue = oldValues[idx]
+		}
+		if idx < len(newValues) {
+			newValue = newValues[idx]
+		}
+		c.indexUpdate(id, name, oldValue, newValue)
+	}
+}
+
+// IndexAscend iterates over the values of an indexed field, in order and
+// starting with pivot, without loading the objects.
+func (c *Collection) IndexAscend(name string, pivot field.Value,
+	iter func(value field.Value, id string) bool,
+) {
+	tr := c.indexes[name]
+	if tr == nil {
+		return
+	}
+	tr.Ascend(&indexEntry{value: pivot}, func(item interface{}) bool {
+		e := item.(*indexEntry)
+		return iter(e.value, e.id)
+	})
+}
+
+// IndexScan iterates over the objects that have a value for an indexed
+// field, in the order of the value and starting with pivot.
+func (c *Collection) IndexScan(name string, pivot field.Value,
+	cursor Cursor,
+	deadline *deadline.Deadline,
+	iter func(value field.Value, id string, obj geojson.Object,
+		fields []field.Value) bool,
+) bool {
+	var keepon = true
+	var count uint64
+	var offset uint64
+	if cursor != nil {
+		offset = cursor.Offset()
+		cursor.Step(offset)
+	}
+	c.IndexAscend(name, pivot, func(value field.Value, id string) bool {
+		count++
+		if count <= offset {
+			return true
+		}
+		nextStep(count, cursor, deadline)
+		item := c.getItem(id)
+		if item == nil {
+			return true
+		}
+		keepon = iter(value, id, loadObject(item.obj), item.fields)
+		return keepon
+	})
+	return keepon
+}
@@ -0,0 +1,93 @@
+package collection
+
+import (
+	"sync"
+
+	"github.com/tidwall/geojson"
+)
+
+// jsonCacheMinPoints is the number of points of the objects whose json is
+// cached. The json of the smaller objects is about as quick to encode again
+// as to copy.
+const jsonCacheMinPoints = 64
+
+// jsonCacheSize is the most bytes of json that a collection caches. The
+// cache starts over once it's full.
+const jsonCacheSize = 32 << 20
+
+// jsonCache is the json of the large objects of a collection, which are
+// encoded once for all of the queries and the fences that output them. An
+// object is not changed once it's stored, and its json is forgotten when it's
+// replaced or deleted.
+type jsonCache struct {
+	mu   sync.RWMutex
+	m    map[geojson.Object]string
+	size int
+}
+
+func (jc *jsonCache) get(obj geojson.Object) (string, bool) {
+	jc.mu.RLock()
+	json, ok := jc.m[obj]
+	jc.mu.RUnlock()
+	return json, ok
+}
+
+func (jc *jsonCache) set(obj geojson.Object, json string) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	if jc.size+len(json) > jsonCacheSize {
+		jc.m = nil
+		jc.size = 0
+	}
+	if jc.m == nil {
+		jc.m = make(map[geojson.Object]string)
+	}
+	if _, ok := jc.m[obj]; !ok {
+		jc.m[obj] = json
+		jc.size += len(json)
+	}
+}
+
+// forget drops the json of an object that was replaced or deleted.
+func (jc *jsonCache) forget(obj geojson.Object) {
+	if obj.NumPoints() < jsonCacheMinPoints {
+		return
+	}
+	jc.mu.Lock()
+	if json, ok := jc.m[obj]; ok {
+		delete(jc.m, obj)
+		jc.size -= len(json)
+	}
+	jc.mu.Unlock()
+}
+
+func (jc *jsonCache) reset() {
+	jc.mu.Lock()
+	jc.m = nil
+	jc.size = 0
+	jc.mu.Unlock()
+}
+
+// ObjectJSON returns the json of an object of the collection, which is
+// cached for the large objects. The objects of the collections on disk are
+// not cached, see NewDisk.
+func (c *Collection) ObjectJSON(obj geojson.Object) string {
+	if c.disk != nil || obj.NumPoints() < jsonCacheMinPoints {
+		return obj.JSON()
+	}
+	if json, ok := c.json.get(obj); ok {
+		return json
+	}
+	json := obj.JSON()
+	c.json.set(obj, json)
+	return json
+}
+
+// AppendObjectJSON appends the json of an object of the collection, see
+// ObjectJSON.
+func (c *Collection) AppendObjectJSON(dst []byte, obj geojson.Object) []byte {
+	if c.disk != nil || obj.NumPoints() < jsonCacheMinPoints {
+		return obj.AppendJSON(dst)
+	}
+	return append(dst, c.ObjectJSON(obj)...)
+}
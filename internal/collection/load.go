@@ -0,0 +1,106 @@
+package collection
+
+import (
+	"math"
+	"sort"
+
+	"github.com/tidwall/geojson/geometry"
+)
+
+// loadEntry is an object that is added to the spatial index at the end of a
+// bulk load.
+type loadEntry struct {
+	rect geometry.Rect
+	item *itemT
+}
+
+// BeginLoad starts a bulk load, such as the replay of the aof. The objects
+// that are set are not added to the spatial index one by one, but all at
+// once by EndLoad, in the order of a sort-tile-recursive packing.
+func (c *Collection) BeginLoad() {
+	c.loading = true
+}
+
+// EndLoad ends a bulk load and adds the objects that were set to the spatial
+// index.
+func (c *Collection) EndLoad() {
+	c.indexPending()
+	c.loading = false
+	c.pending = nil
+}
+
+// Loading returns true while a bulk load has not ended.
+func (c *Collection) Loading() bool {
+	return c.loading
+}
+
+// addPending defers the indexing of an item until the end of the load.
+func (c *Collection) addPending(item *itemT) {
+	if len(c.pending) >= 2*c.objects+1024 {
+		// drop the items that were replaced or deleted since they were set,
+		// which keeps the list from growing with the updates of the objects
+		c.pending = c.livePending(c.pending[:0])
+	}
+	c.pending = append(c.pending, item)
+}
+
+// livePending appends the pending items that are still in the collection.
+func (c *Collection) livePending(dst []*itemT) []*itemT {
+	for _, item := range c.pending {
+		if v := c.items.Get(item); v != nil && v.(*itemT) == item {
+			dst = append(dst, item)
+		}
+	}
+	return dst
+}
+
+// indexPending adds the pending items to the spatial index. It's called by
+// the searches of a loading collection too, such as a COPY that is replayed
+// from the aof.
+func (c *Collection) indexPending() {
+	if len(c.pending) == 0 {
+		return
+	}
+	items := c.livePending(nil)
+	c.pending = c.pending[:0]
+	entries := make([]loadEntry, 0, len(items))
+	for _, item := range items {
+		if !item.obj.Empty() {
+			entries = append(entries, loadEntry{item.obj.Rect(), item})
+		}
+	}
+	strOrder(entries, rtreeMaxEntries)
+	for _, e := range entries {
+		c.index.Insert(
+			[2]float64{e.rect.Min.X, e.rect.Min.Y},
+			[2]float64{e.rect.Max.X, e.rect.Max.Y},
+			e.item)
+		c.logRebuild(e.item, true)
+	}
+}
+
+// strOrder sorts the entries in the order of the leaves of a
+// sort-tile-recursive packing. The entries are sorted by x into vertical
+// slices with the entries of about the square root of the number of leaves,
+// and each slice is sorted by y. The rtree has no bulk load, but inserting
+// the entries in this order fills the nodes one after another with entries
+// that are near each other, which is quicker than inserting them in any
+// order, and gives nodes with less overlap for the searches.
+func strOrder(entries []loadEntry, nodeSize int) {
+	centerX := func(e *loadEntry) float64 { return e.rect.Min.X + e.rect.Max.X }
+	centerY := func(e *loadEntry) float64 { return e.rect.Min.Y + e.rect.Max.Y }
+	sort.Slice(entries, func(i, j int) bool {
+		return centerX(&entries[i]) < centerX(&entries[j])
+	})
+	leaves := math.Ceil(float64(len(entries)) / float64(nodeSize))
+	slice := int(math.Ceil(math.Sqrt(leaves))) * nodeSize
+	for i := 0; i < len(entries); i += slice {
+		part := entries[i:]
+		if len(part) > slice {
+			part = part[:slice]
+		}
+		sort.Slice(part, func(i, j int) bool {
+			return centerY(&part[i]) < centerY(&part[j])
+		})
+	}
+}
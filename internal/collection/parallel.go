@@ -0,0 +1,169 @@
+package collection
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/tile38/internal/deadline"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+// testBatch is the number of candidates of a search that are tested at once
+// by the workers.
+const testBatch = 256
+
+var workers atomic.Value // func() int
+
+// SetWorkers sets the number of goroutines that test the candidates of a
+// Within or Intersects. The candidates are tested by one goroutine when the
+// number is one or less.
+func SetWorkers(n func() int) {
+	workers.Store(n)
+}
+
+func numWorkers() int {
+	if n, ok := workers.Load().(func() int); ok {
+		return n()
+	}
+	return 1
+}
+
+// pool is the goroutines that are shared by the searches to test their
+// candidates, one for each CPU.
+var pool struct {
+	once  sync.Once
+	tasks chan func()
+}
+
+// runTasks runs the tasks in parallel and waits for all of them. The first
+// task is run by the caller, and so are the tasks that no worker of the pool
+// is free for, such as when other searches are using all of them.
+func runTasks(tasks []func()) {
+	pool.once.Do(func() {
+		pool.tasks = make(chan func())
+		for i := 0; i < runtime.NumCPU(); i++ {
+			go func() {
+				for task := range pool.tasks {
+					task()
+				}
+			}()
+		}
+	})
+	var wg sync.WaitGroup
+	for _, task := range tasks[1:] {
+		task := task
+		wg.Add(1)
+		fn := func() {
+			defer wg.Done()
+			task()
+		}
+		select {
+		case pool.tasks <- fn:
+		default:
+			fn()
+		}
+	}
+	tasks[0]()
+	wg.Wait()
+}
+
+type candidate struct {
+	step   uint64
+	id     string
+	obj    geojson.Object
+	fields []field.Value
+	match  bool
+}
+
+// testParallel tests the candidates, splitting them between the workers.
+func testParallel(n int, cands []candidate, test func(o geojson.Object) bool) {
+	if n > len(cands) {
+		n = len(cands)
+	}
+	tasks := make([]func(), n)
+	for i := range tasks {
+		part := cands[len(cands)*i/n : len(cands)*(i+1)/n]
+		tasks[i] = func() {
+			for j := range part {
+				part[j].match = test(part[j].obj)
+			}
+		}
+	}
+	runTasks(tasks)
+}
+
+// geoTest iterates over the objects in the rect that pass the test, after
+// the offset of the cursor. With more than one worker, the candidates are
+// tested in batches by the workers, and then iterated over in the order of
+// the index, so that the results are the same as a test by one goroutine.
+func (c *Collection) geoTest(
+	rect geometry.Rect, cursor Cursor, deadline *deadline.Deadline,
+	parallel bool, test func(o geojson.Object) bool,
+	iter func(id string, obj geojson.Object, fields []field.Value) bool,
+) bool {
+	var count uint64
+	var offset uint64
+	if cursor != nil {
+		offset = cursor.Offset()
+		cursor.Step(offset)
+	}
+	n := 1
+	if parallel {
+		n = numWorkers()
+	}
+	if n <= 1 {
+		return c.geoSearch(rect,
+			func(id string, o geojson.Object, fields []field.Value) bool {
+				count++
+				if count <= offset {
+					return true
+				}
+				nextStep(count, cursor, deadline)
+				if test(o) {
+					return iter(id, o, fields)
+				}
+				return true
+			},
+		)
+	}
+	cands := make([]candidate, 0, testBatch)
+	flush := func() bool {
+		testParallel(n, cands, test)
+		for _, cand := range cands {
+			nextStep(cand.step, cursor, deadline)
+			if cand.match && !iter(cand.id, cand.obj, cand.fields) {
+				return false
+			}
+		}
+		cands = cands[:0]
+		return true
+	}
+	alive := c.geoSearch(rect,
+		func(id string, o geojson.Object, fields []field.Value) bool {
+			count++
+			if count <= offset {
+				return true
+			}
+			cands = append(cands, candidate{step: count, id: id, obj: o,
+				fields: fields})
+			if len(cands) < testBatch {
+				return true
+			}
+			return flush()
+		},
+	)
+	if alive && len(cands) > 0 {
+		alive = flush()
+	}
+	return alive
+}
+
+// testsInParallel returns true when the candidates of a search of the area
+// are worth testing in parallel. The test of a rectangle is too quick.
+func testsInParallel(area geojson.Object) bool {
+	_, rect := area.(*geojson.Rect)
+	return !rect
+}
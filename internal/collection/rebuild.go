@@ -0,0 +1,101 @@
+package collection
+
+import (
+	"github.com/tidwall/btree"
+	"github.com/tidwall/geoindex"
+	"github.com/tidwall/rtree"
+)
+
+// Rebuild is a new spatial index of a collection, which is packed from a
+// snapshot of the collection while the collection is written, and then takes
+// the place of the index of the collection. An index that had many objects
+// deleted, or was filled one object at a time, has nodes that are not full
+// and overlap each other, and a rebuild packs the objects that are left into
+// fewer nodes, in the order of a sort-tile-recursive packing.
+type Rebuild struct {
+	c     *Collection
+	items *btree.BTree
+	index *geoindex.Index
+	log   []rebuildOp // the changes of the index since the snapshot
+}
+
+// rebuildOp is an insert or a delete of the index of the collection.
+type rebuildOp struct {
+	item   *itemT
+	insert bool
+}
+
+// BeginRebuild starts a rebuild of the spatial index from a snapshot of the
+// collection. It returns nil when a rebuild is in progress, or the
+// collection is loading.
+func (c *Collection) BeginRebuild() *Rebuild {
+	if c.rebuild != nil || c.loading {
+		return nil
+	}
+	c.indexPending()
+	c.rebuild = &Rebuild{
+		c:     c,
+		items: c.Snapshot().items,
+		index: geoindex.Wrap(&rtree.RTree{}),
+	}
+	return c.rebuild
+}
+
+// Rebuilding returns true while a rebuild of the spatial index is in
+// progress.
+func (c *Collection) Rebuilding() bool {
+	return c.rebuild != nil
+}
+
+// logRebuild keeps a change of the index for the rebuild in progress.
+func (c *Collection) logRebuild(item *itemT, insert bool) {
+	if c.rebuild != nil {
+		c.rebuild.log = append(c.rebuild.log, rebuildOp{item, insert})
+	}
+}
+
+// Build packs the objects of the snapshot into the new index. It reads
+// nothing but the snapshot, and is called without the lock of the
+// collection.
+func (r *Rebuild) Build() {
+	var entries []loadEntry
+	r.items.Ascend(nil, func(v interface{}) bool {
+		item := v.(*itemT)
+		if objIsSpatial(item.obj) && !item.obj.Empty() {
+			entries = append(entries, loadEntry{item.obj.Rect(), item})
+		}
+		return true
+	})
+	strOrder(entries, rtreeMaxEntries)
+	for _, e := range entries {
+		r.index.Insert(
+			[2]float64{e.rect.Min.X, e.rect.Min.Y},
+			[2]float64{e.rect.Max.X, e.rect.Max.Y},
+			e.item)
+	}
+}
+
+// End applies the changes of the collection since the snapshot to the new
+// index, which then takes the place of the index of the collection.
+func (r *Rebuild) End() {
+	c := r.c
+	for _, op := range r.log {
+		rect := op.item.obj.Rect()
+		min := [2]float64{rect.Min.X, rect.Min.Y}
+		max := [2]float64{rect.Max.X, rect.Max.Y}
+		if op.insert {
+			r.index.Insert(min, max, op.item)
+		} else {
+			r.index.Delete(min, max, op.item)
+		}
+	}
+	c.index = r.index
+	c.rebuild = nil
+}
+
+// Abort ends a rebuild without changing the index of the collection.
+func (r *Rebuild) Abort() {
+	if r.c.rebuild == r {
+		r.c.rebuild = nil
+	}
+}
@@ -0,0 +1,270 @@
+package columnar
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/tidwall/tile38/internal/flatbuf"
+)
+
+// arrowMagic is the magic of the start and the end of an Arrow file.
+const arrowMagic = "ARROW1"
+
+// arrowVersion is the MetadataVersion V5 of Schema.fbs.
+const arrowVersion = 4
+
+// The MessageHeader types of Message.fbs.
+const (
+	arrowSchema      = 1
+	arrowRecordBatch = 3
+)
+
+// The Type types of Schema.fbs.
+const (
+	arrowFloatingPoint = 3
+	arrowBinary        = 4
+	arrowUtf8          = 5
+	arrowBool          = 6
+)
+
+// arrowDouble is the Precision DOUBLE of Schema.fbs.
+const arrowDouble = 2
+
+// The ids of the fields of the tables of Schema.fbs, Message.fbs, and
+// File.fbs.
+const (
+	arrowMessageVersion    = 0
+	arrowMessageHeaderType = 1
+	arrowMessageHeader     = 2
+	arrowMessageBodyLength = 3
+	arrowMessageNumFields  = 5
+
+	arrowSchemaFields    = 1
+	arrowSchemaNumFields = 4
+
+	arrowFieldName           = 0
+	arrowFieldNullable       = 1
+	arrowFieldTypeType       = 2
+	arrowFieldType           = 3
+	arrowFieldChildren       = 5
+	arrowFieldCustomMetadata = 6
+	arrowFieldNumFields      = 7
+
+	arrowRecordBatchLength    = 0
+	arrowRecordBatchNodes     = 1
+	arrowRecordBatchBuffers   = 2
+	arrowRecordBatchNumFields = 4
+
+	arrowFooterVersion       = 0
+	arrowFooterSchema        = 1
+	arrowFooterDictionaries  = 2
+	arrowFooterRecordBatches = 3
+	arrowFooterNumFields     = 5
+)
+
+// arrowWriter writes an Arrow IPC file, which is the schema, a record batch
+// for each Write, and a footer with the blocks of the record batches.
+type arrowWriter struct {
+	w       *countWriter
+	schema  []Column
+	b       *flatbuf.Builder
+	blocks  []byte // the Block structs of the record batches
+	body    []byte
+	nodes   []byte
+	buffers []byte
+}
+
+// NewArrowWriter returns a writer of an Arrow IPC file with a schema. The
+// Geometry columns are the geoarrow.wkb extension type.
+func NewArrowWriter(w io.Writer, schema []Column) (Writer, error) {
+	aw := &arrowWriter{
+		w:      &countWriter{w: w},
+		schema: schema,
+		b:      flatbuf.NewBuilder(1024),
+	}
+	aw.w.Write([]byte(arrowMagic + "\x00\x00"))
+	b := aw.b
+	header := aw.createSchema()
+	b.StartTable(arrowMessageNumFields)
+	b.AddOffset(arrowMessageHeader, header)
+	b.AddUint16(arrowMessageVersion, arrowVersion)
+	b.AddUint8(arrowMessageHeaderType, arrowSchema)
+	aw.writeMessage(b.Finish(b.EndTable(), ""), nil)
+	return aw, aw.w.err
+}
+
+// createSchema creates the Schema table of the columns.
+func (aw *arrowWriter) createSchema() int {
+	b := aw.b
+	fields := make([]int, len(aw.schema))
+	for i, c := range aw.schema {
+		name := b.CreateString(c.Name)
+		var typeType uint8
+		switch c.Type {
+		case String:
+			typeType = arrowUtf8
+		case Binary:
+			typeType = arrowBinary
+		case Double:
+			typeType = arrowFloatingPoint
+		case Bool:
+			typeType = arrowBool
+		}
+		// the types other than FloatingPoint are empty tables
+		b.StartTable(1)
+		if c.Type == Double {
+			b.AddUint16(0, arrowDouble)
+		}
+		typ := b.EndTable()
+		children := b.CreateOffsets(nil)
+		var metadata int
+		if c.Geometry {
+			metadata = b.CreateOffsets([]int{
+				createKeyValue(b, "ARROW:extension:name", "geoarrow.wkb"),
+				createKeyValue(b, "ARROW:extension:metadata", "{}"),
+			})
+		}
+		b.StartTable(arrowFieldNumFields)
+		b.AddOffset(arrowFieldName, name)
+		b.AddOffset(arrowFieldType, typ)
+		b.AddOffset(arrowFieldChildren, children)
+		b.AddOffset(arrowFieldCustomMetadata, metadata)
+		b.AddBool(arrowFieldNullable, c.Nullable)
+		b.AddUint8(arrowFieldTypeType, typeType)
+		fields[i] = b.EndTable()
+	}
+	vec := b.CreateOffsets(fields)
+	b.StartTable(arrowSchemaNumFields)
+	b.AddOffset(arrowSchemaFields, vec)
+	return b.EndTable()
+}
+
+func createKeyValue(b *flatbuf.Builder, key, value string) int {
+	k := b.CreateString(key)
+	v := b.CreateString(value)
+	b.StartTable(2)
+	b.AddOffset(0, k)
+	b.AddOffset(1, v)
+	return b.EndTable()
+}
+
+// writeMessage writes an encapsulated message, which is the continuation
+// marker, the length of the metadata, the metadata, and the body. The
+// metadata is padded, so that the body is aligned to eight bytes.
+func (aw *arrowWriter) writeMessage(metadata, body []byte) (offset int64,
+	metadataLen int,
+) {
+	offset = aw.w.n
+	pad := -len(metadata) & 7
+	var prefix [8]byte
+	binary.LittleEndian.PutUint32(prefix[:], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(len(metadata)+pad))
+	aw.w.Write(prefix[:])
+	aw.w.Write(metadata)
+	aw.w.Write(make([]byte, pad))
+	aw.w.Write(body)
+	return offset, 8 + len(metadata) + pad
+}
+
+// Write writes a record batch of the rows.
+func (aw *arrowWriter) Write(batch *Batch) error {
+	body := aw.body[:0]
+	nodes := aw.nodes[:0]
+	buffers := aw.buffers[:0]
+	n := batch.Len
+	// buffer adds the Buffer of the body from start, which is padded.
+	buffer := func(start int) {
+		buffers = appendUint64(buffers, uint64(start))
+		buffers = appendUint64(buffers, uint64(len(body)-start))
+		body = pad8(body)
+	}
+	for col, c := range aw.schema {
+		v := &batch.Columns[col]
+		nulls := v.nullCount()
+		nodes = appendUint64(nodes, uint64(n))
+		nodes = appendUint64(nodes, uint64(nulls))
+		// the validity bitmap is omitted when there are no nulls
+		start := len(body)
+		if nulls > 0 {
+			body = appendBitmap(body, n, func(i int) bool { return !v.null(i) })
+		}
+		buffer(start)
+		switch c.Type {
+		case String, Binary:
+			start = len(body)
+			var off uint32
+			body = appendUint32(body, 0)
+			for i := 0; i < n; i++ {
+				if !v.null(i) {
+					off += uint32(len(v.Bytes[i]))
+				}
+				body = appendUint32(body, off)
+			}
+			buffer(start)
+			start = len(body)
+			for i := 0; i < n; i++ {
+				if !v.null(i) {
+					body = append(body, v.Bytes[i]...)
+				}
+			}
+			buffer(start)
+		case Double:
+			start = len(body)
+			for i := 0; i < n; i++ {
+				body = appendFloat64(body, v.Doubles[i])
+			}
+			buffer(start)
+		case Bool:
+			start = len(body)
+			body = appendBitmap(body, n, func(i int) bool {
+				return !v.null(i) && v.Bools[i]
+			})
+			buffer(start)
+		}
+	}
+	aw.body, aw.nodes, aw.buffers = body, nodes, buffers
+
+	b := aw.b
+	b.Reset()
+	nodesVec := b.CreateStructs(nodes, len(nodes)/16, 8)
+	buffersVec := b.CreateStructs(buffers, len(buffers)/16, 8)
+	b.StartTable(arrowRecordBatchNumFields)
+	b.AddUint64(arrowRecordBatchLength, uint64(n))
+	b.AddOffset(arrowRecordBatchNodes, nodesVec)
+	b.AddOffset(arrowRecordBatchBuffers, buffersVec)
+	header := b.EndTable()
+	b.StartTable(arrowMessageNumFields)
+	b.AddUint64(arrowMessageBodyLength, uint64(len(body)))
+	b.AddOffset(arrowMessageHeader, header)
+	b.AddUint16(arrowMessageVersion, arrowVersion)
+	b.AddUint8(arrowMessageHeaderType, arrowRecordBatch)
+	offset, metadataLen := aw.writeMessage(b.Finish(b.EndTable(), ""), body)
+
+	// the Block struct is the offset, the metadata length, and the body
+	// length, with four bytes of padding after the metadata length.
+	aw.blocks = appendUint64(aw.blocks, uint64(offset))
+	aw.blocks = appendUint32(aw.blocks, uint32(metadataLen))
+	aw.blocks = appendUint32(aw.blocks, 0)
+	aw.blocks = appendUint64(aw.blocks, uint64(len(body)))
+	return aw.w.err
+}
+
+// Close writes the end of stream marker and the footer.
+func (aw *arrowWriter) Close() error {
+	aw.w.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0})
+	b := aw.b
+	b.Reset()
+	schema := aw.createSchema()
+	dictionaries := b.CreateStructs(nil, 0, 8)
+	batches := b.CreateStructs(aw.blocks, len(aw.blocks)/24, 8)
+	b.StartTable(arrowFooterNumFields)
+	b.AddOffset(arrowFooterSchema, schema)
+	b.AddOffset(arrowFooterDictionaries, dictionaries)
+	b.AddOffset(arrowFooterRecordBatches, batches)
+	b.AddUint16(arrowFooterVersion, arrowVersion)
+	footer := b.Finish(b.EndTable(), "")
+	aw.w.Write(footer)
+	aw.w.Write(appendUint32(nil, uint32(len(footer))))
+	aw.w.Write([]byte(arrowMagic))
+	return aw.w.err
+}
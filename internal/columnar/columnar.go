@@ -0,0 +1,146 @@
+// Package columnar writes columnar files, which are Apache Arrow IPC files
+// and Apache Parquet files, for loading the objects of a collection into
+// analytics engines such as Spark or DuckDB. The files are not compressed.
+package columnar
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Type is the type of a column.
+type Type int
+
+const (
+	// String is a column of UTF-8 strings.
+	String Type = iota
+	// Binary is a column of bytes.
+	Binary
+	// Double is a column of float64s.
+	Double
+	// Bool is a column of bools.
+	Bool
+)
+
+// Column is a column of a schema. A Geometry column is a Binary column of
+// well-known binary geometries, which is described by the GeoArrow and the
+// GeoParquet metadata of the files.
+type Column struct {
+	Name     string
+	Type     Type
+	Nullable bool
+	Geometry bool
+}
+
+// Values are the values of a column, which are in Bytes for the String and
+// Binary columns, in Doubles for the Double columns, and in Bools for the
+// Bool columns. The nulls of a Nullable column are true in Nulls, which has a
+// value for each row, or is empty when there are no nulls.
+type Values struct {
+	Bytes   [][]byte
+	Doubles []float64
+	Bools   []bool
+	Nulls   []bool
+}
+
+func (v *Values) null(i int) bool {
+	return len(v.Nulls) > 0 && v.Nulls[i]
+}
+
+func (v *Values) nullCount() int {
+	var n int
+	for _, null := range v.Nulls {
+		if null {
+			n++
+		}
+	}
+	return n
+}
+
+// Batch is the rows of a Write, with the Values of each column of the schema.
+type Batch struct {
+	Len     int
+	Columns []Values
+}
+
+// NewBatch returns an empty batch for a schema.
+func NewBatch(schema []Column) *Batch {
+	return &Batch{Columns: make([]Values, len(schema))}
+}
+
+// Reset empties the batch, which keeps its memory for the next rows.
+func (b *Batch) Reset() {
+	b.Len = 0
+	for i := range b.Columns {
+		v := &b.Columns[i]
+		v.Bytes = v.Bytes[:0]
+		v.Doubles = v.Doubles[:0]
+		v.Bools = v.Bools[:0]
+		v.Nulls = v.Nulls[:0]
+	}
+}
+
+// Writer writes the batches of rows of a file. Close writes the end of the
+// file, and does not close the underlying writer.
+type Writer interface {
+	Write(b *Batch) error
+	Close() error
+}
+
+// countWriter counts the bytes that are written, and keeps the first error,
+// so that the writes of a file are checked once.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+// appendBitmap appends the bits of n values, with the first value in the
+// least significant bit of the first byte.
+func appendBitmap(dst []byte, n int, bit func(i int) bool) []byte {
+	for i := 0; i < n; i += 8 {
+		var c byte
+		for j := 0; j < 8 && i+j < n; j++ {
+			if bit(i + j) {
+				c |= 1 << uint(j)
+			}
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(dst, b[:]...)
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(dst, b[:]...)
+}
+
+func appendFloat64(dst []byte, f float64) []byte {
+	return appendUint64(dst, math.Float64bits(f))
+}
+
+// pad8 pads to a multiple of eight bytes.
+func pad8(dst []byte) []byte {
+	for len(dst)%8 != 0 {
+		dst = append(dst, 0)
+	}
+	return dst
+}
@@ -0,0 +1,273 @@
+package columnar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/internal/flatbuf"
+)
+
+var testSchema = []Column{
+	{Name: "id", Type: String},
+	{Name: "geometry", Type: Binary, Geometry: true},
+	{Name: "speed", Type: Double},
+	{Name: "active", Type: Bool, Nullable: true},
+	{Name: "name", Type: String, Nullable: true},
+}
+
+// writeTest writes a batch of three rows and a batch of one row.
+func writeTest(t *testing.T, w Writer) {
+	t.Helper()
+	b := NewBatch(testSchema)
+	for _, rows := range [][]string{{"a", "b", "c"}, {"d"}} {
+		b.Reset()
+		for i, id := range rows {
+			c := b.Columns
+			c[0].Bytes = append(c[0].Bytes, []byte(id))
+			c[1].Bytes = append(c[1].Bytes, []byte{1, 2, 3})
+			c[2].Doubles = append(c[2].Doubles, float64(i)+0.5)
+			c[3].Bools = append(c[3].Bools, i%2 == 0)
+			c[3].Nulls = append(c[3].Nulls, i == 1)
+			c[4].Bytes = append(c[4].Bytes, []byte("name-"+id))
+			c[4].Nulls = append(c[4].Nulls, id == "b")
+			b.Len++
+		}
+		if err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArrow(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewArrowWriter(&buf, testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTest(t, w)
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("ARROW1\x00\x00")) ||
+		!bytes.HasSuffix(data, []byte("ARROW1")) {
+		t.Fatal("missing magic")
+	}
+	n := int(binary.LittleEndian.Uint32(data[len(data)-10:]))
+	footer := flatbuf.Root(data[len(data)-10-n : len(data)-10])
+	if footer.Uint16(arrowFooterVersion) != arrowVersion {
+		t.Fatal("unexpected version")
+	}
+	fields := footer.Table(arrowFooterSchema).Vector(arrowSchemaFields)
+	if fields.Len() != len(testSchema) {
+		t.Fatalf("expected %d fields, got %d", len(testSchema), fields.Len())
+	}
+	types := []uint8{arrowUtf8, arrowBinary, arrowFloatingPoint, arrowBool,
+		arrowUtf8}
+	for i, c := range testSchema {
+		f := fields.Table(i)
+		if f.String(arrowFieldName) != c.Name ||
+			f.Bool(arrowFieldNullable) != c.Nullable ||
+			f.Uint8(arrowFieldTypeType) != types[i] ||
+			f.Vector(arrowFieldChildren).Len() != 0 {
+			t.Fatalf("unexpected field %d", i)
+		}
+	}
+	meta := fields.Table(1).Vector(arrowFieldCustomMetadata)
+	if meta.Len() != 2 || meta.Table(0).String(1) != "geoarrow.wkb" {
+		t.Fatal("expected the geoarrow extension")
+	}
+	if fields.Table(2).Table(arrowFieldType).Uint16(0) != arrowDouble {
+		t.Fatal("expected a double")
+	}
+	blocks := footer.Vector(arrowFooterRecordBatches)
+	if blocks.Len() != 2 {
+		t.Fatalf("expected 2 record batches, got %d", blocks.Len())
+	}
+	// the first record batch
+	block := blocks.Struct(0, 24)
+	offset := int(binary.LittleEndian.Uint64(block))
+	metaLen := int(binary.LittleEndian.Uint32(block[8:]))
+	bodyLen := int(binary.LittleEndian.Uint64(block[16:]))
+	if offset%8 != 0 || metaLen%8 != 0 ||
+		binary.LittleEndian.Uint32(data[offset:]) != 0xFFFFFFFF {
+		t.Fatal("unexpected block")
+	}
+	msg := flatbuf.Root(data[offset+8 : offset+metaLen])
+	if msg.Uint8(arrowMessageHeaderType) != arrowRecordBatch ||
+		int(msg.Uint64(arrowMessageBodyLength)) != bodyLen {
+		t.Fatal("unexpected message")
+	}
+	rb := msg.Table(arrowMessageHeader)
+	if rb.Uint64(arrowRecordBatchLength) != 3 {
+		t.Fatal("expected 3 rows")
+	}
+	nodes := rb.Vector(arrowRecordBatchNodes)
+	nulls := []uint64{0, 0, 0, 1, 1}
+	for i := range testSchema {
+		node := nodes.Struct(i, 16)
+		if binary.LittleEndian.Uint64(node) != 3 ||
+			binary.LittleEndian.Uint64(node[8:]) != nulls[i] {
+			t.Fatalf("unexpected node %d", i)
+		}
+	}
+	body := data[offset+metaLen : offset+metaLen+bodyLen]
+	buffers := rb.Vector(arrowRecordBatchBuffers)
+	buffer := func(i int) []byte {
+		b := buffers.Struct(i, 16)
+		off := binary.LittleEndian.Uint64(b)
+		if off%8 != 0 {
+			t.Fatalf("unaligned buffer %d", i)
+		}
+		return body[off : off+binary.LittleEndian.Uint64(b[8:])]
+	}
+	// id: validity, offsets, data
+	if len(buffer(0)) != 0 || string(buffer(2)) != "abc" {
+		t.Fatal("unexpected ids")
+	}
+	// speed: validity, data
+	if math.Float64frombits(binary.LittleEndian.Uint64(buffer(7)[8:])) != 1.5 {
+		t.Fatal("unexpected speed")
+	}
+	// active: validity, data
+	if buffer(8)[0] != 0x5 || buffer(9)[0] != 0x5 {
+		t.Fatalf("unexpected active %x %x", buffer(8), buffer(9))
+	}
+	// name: validity, offsets, data
+	offs := buffer(11)
+	if buffer(10)[0] != 0x5 || string(buffer(12)) != "name-aname-c" ||
+		binary.LittleEndian.Uint32(offs[8:]) != 6 {
+		t.Fatal("unexpected names")
+	}
+}
+
+func TestParquet(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewParquetWriter(&buf, testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTest(t, w)
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("PAR1")) ||
+		!bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatal("missing magic")
+	}
+	n := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	r := &thriftReader{b: data[len(data)-8-n : len(data)-8]}
+	md := r.readStruct()
+	if len(r.b) != 0 {
+		t.Fatal("unexpected bytes after the metadata")
+	}
+	if md[1] != int64(1) || md[3] != int64(4) || md[6] != "tile38" {
+		t.Fatalf("unexpected metadata %v", md)
+	}
+	schema := md[2].([]interface{})
+	if len(schema) != len(testSchema)+1 ||
+		schema[0].(map[int16]interface{})[5] != int64(len(testSchema)) {
+		t.Fatal("unexpected schema")
+	}
+	name := schema[5].(map[int16]interface{})
+	if name[1] != int64(parquetByteArray) || name[3] != int64(parquetOptional) ||
+		name[4] != "name" || name[6] != int64(parquetUTF8) {
+		t.Fatalf("unexpected column %v", name)
+	}
+	kv := md[5].([]interface{})[0].(map[int16]interface{})
+	if kv[1] != "geo" ||
+		gjson.Get(kv[2].(string), "columns.geometry.encoding").String() != "WKB" {
+		t.Fatalf("unexpected key value %v", kv)
+	}
+	groups := md[4].([]interface{})
+	if len(groups) != 2 || groups[0].(map[int16]interface{})[3] != int64(3) {
+		t.Fatal("unexpected row groups")
+	}
+	// the name column of the first row group
+	chunk := groups[0].(map[int16]interface{})[1].([]interface{})[4].(map[int16]interface{})
+	cmd := chunk[3].(map[int16]interface{})
+	if cmd[3].([]interface{})[0] != "name" || cmd[5] != int64(3) {
+		t.Fatalf("unexpected column chunk %v", cmd)
+	}
+	start := int(cmd[9].(int64))
+	end := start + int(cmd[7].(int64))
+	r = &thriftReader{b: data[start:end]}
+	header := r.readStruct()
+	page := r.b
+	if header[1] != int64(0) || header[2] != int64(len(page)) ||
+		header[5].(map[int16]interface{})[1] != int64(3) {
+		t.Fatalf("unexpected page header %v", header)
+	}
+	// the definition levels are one bit-packed group of 101
+	levels := int(binary.LittleEndian.Uint32(page))
+	if levels != 2 || page[4] != 3 || page[5] != 0x5 {
+		t.Fatalf("unexpected levels %x", page[:4+levels])
+	}
+	values := page[4+levels:]
+	if string(values) != "\x06\x00\x00\x00name-a\x06\x00\x00\x00name-c" {
+		t.Fatalf("unexpected values %q", values)
+	}
+	if !strings.Contains(string(data), "name-d") {
+		t.Fatal("missing the second row group")
+	}
+}
+
+// thriftReader reads the thrift compact protocol, where the structs are maps
+// of the field ids.
+type thriftReader struct {
+	b []byte
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	m := map[int16]interface{}{}
+	var id int16
+	for {
+		h := r.b[0]
+		r.b = r.b[1:]
+		if h == 0 {
+			return m
+		}
+		id += int16(h >> 4)
+		m[id] = r.read(h & 0xF)
+	}
+}
+
+func (r *thriftReader) read(typ byte) interface{} {
+	switch typ {
+	case 1:
+		return true
+	case 2:
+		return false
+	case thriftI32, thriftI64:
+		v := r.uvarint()
+		return int64(v>>1) ^ -int64(v&1)
+	case thriftBinary:
+		n := int(r.uvarint())
+		s := string(r.b[:n])
+		r.b = r.b[n:]
+		return s
+	case thriftList:
+		h := r.b[0]
+		r.b = r.b[1:]
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.read(h & 0xF)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	panic("unsupported type")
+}
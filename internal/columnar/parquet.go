@@ -0,0 +1,348 @@
+package columnar
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// parquetMagic is the magic of the start and the end of a Parquet file.
+const parquetMagic = "PAR1"
+
+// The Types of parquet.thrift.
+const (
+	parquetBoolean   = 0
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// The FieldRepetitionTypes of parquet.thrift.
+const (
+	parquetRequired = 0
+	parquetOptional = 1
+)
+
+// The Encodings of parquet.thrift.
+const (
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// parquetUTF8 is the ConvertedType UTF8 of parquet.thrift.
+const parquetUTF8 = 0
+
+// parquetColumnChunk is the metadata of a column chunk of a row group.
+type parquetColumnChunk struct {
+	offset int64
+	size   int64
+}
+
+// parquetRowGroup is the metadata of a row group.
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetColumnChunk
+}
+
+// parquetWriter writes a Parquet file, which is a row group for each Write
+// with a PLAIN data page for each column, and the FileMetaData of the row
+// groups.
+type parquetWriter struct {
+	w         *countWriter
+	schema    []Column
+	rows      int64
+	rowGroups []parquetRowGroup
+	page      []byte
+	bits      []bool
+	header    thriftWriter
+}
+
+// NewParquetWriter returns a writer of a Parquet file with a schema. The
+// Geometry columns are described by the GeoParquet metadata of the file.
+func NewParquetWriter(w io.Writer, schema []Column) (Writer, error) {
+	pw := &parquetWriter{w: &countWriter{w: w}, schema: schema}
+	pw.w.Write([]byte(parquetMagic))
+	return pw, pw.w.err
+}
+
+// Write writes a row group of the rows.
+func (pw *parquetWriter) Write(batch *Batch) error {
+	n := batch.Len
+	chunks := make([]parquetColumnChunk, len(pw.schema))
+	for col, c := range pw.schema {
+		v := &batch.Columns[col]
+		page := pw.page[:0]
+		if c.Nullable {
+			// the definition levels are a bit-packed run of the RLE
+			// hybrid encoding, which is prefixed by its length.
+			levels := appendUvarint(nil, uint64((n+7)/8)<<1|1)
+			levels = appendBitmap(levels, n, func(i int) bool {
+				return !v.null(i)
+			})
+			page = appendUint32(page, uint32(len(levels)))
+			page = append(page, levels...)
+		}
+		switch c.Type {
+		case String, Binary:
+			for i := 0; i < n; i++ {
+				if !v.null(i) {
+					page = appendUint32(page, uint32(len(v.Bytes[i])))
+					page = append(page, v.Bytes[i]...)
+				}
+			}
+		case Double:
+			for i := 0; i < n; i++ {
+				if !v.null(i) {
+					page = appendFloat64(page, v.Doubles[i])
+				}
+			}
+		case Bool:
+			// the nulls are skipped
+			bits := pw.bits[:0]
+			for i := 0; i < n; i++ {
+				if !v.null(i) {
+					bits = append(bits, v.Bools[i])
+				}
+			}
+			page = appendBitmap(page, len(bits), func(i int) bool {
+				return bits[i]
+			})
+			pw.bits = bits
+		}
+		pw.page = page
+
+		h := &pw.header
+		h.reset()
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(page)))
+		h.i32(3, int32(len(page)))
+		h.beginStruct(5)
+		h.i32(1, int32(n))
+		h.i32(2, parquetPlain)
+		h.i32(3, parquetRLE)
+		h.i32(4, parquetRLE)
+		h.endStruct()
+		h.end()
+
+		chunks[col] = parquetColumnChunk{
+			offset: pw.w.n,
+			size:   int64(len(h.buf) + len(page)),
+		}
+		pw.w.Write(h.buf)
+		pw.w.Write(page)
+	}
+	pw.rows += int64(n)
+	pw.rowGroups = append(pw.rowGroups, parquetRowGroup{
+		rows:   int64(n),
+		chunks: chunks,
+	})
+	return pw.w.err
+}
+
+// Close writes the FileMetaData.
+func (pw *parquetWriter) Close() error {
+	t := &thriftWriter{}
+	t.i32(1, 1) // version
+	t.beginList(2, thriftStruct, len(pw.schema)+1)
+	t.beginElem()
+	t.str(4, "schema")
+	t.i32(5, int32(len(pw.schema)))
+	t.endStruct()
+	for _, c := range pw.schema {
+		t.beginElem()
+		t.i32(1, parquetType(c.Type))
+		if c.Nullable {
+			t.i32(3, parquetOptional)
+		} else {
+			t.i32(3, parquetRequired)
+		}
+		t.str(4, c.Name)
+		if c.Type == String {
+			t.i32(6, parquetUTF8)
+		}
+		t.endStruct()
+	}
+	t.i64(3, pw.rows)
+	t.beginList(4, thriftStruct, len(pw.rowGroups))
+	for _, rg := range pw.rowGroups {
+		t.beginElem()
+		t.beginList(1, thriftStruct, len(rg.chunks))
+		var size int64
+		for col, chunk := range rg.chunks {
+			c := pw.schema[col]
+			t.beginElem()
+			t.i64(2, chunk.offset)
+			t.beginStruct(3)
+			t.i32(1, parquetType(c.Type))
+			t.beginList(2, thriftI32, 2)
+			t.elemI32(parquetPlain)
+			t.elemI32(parquetRLE)
+			t.beginList(3, thriftBinary, 1)
+			t.elemStr(c.Name)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, rg.rows)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+			size += chunk.size
+		}
+		t.i64(2, size)
+		t.i64(3, rg.rows)
+		t.endStruct()
+	}
+	if geo := pw.geoMetadata(); geo != "" {
+		t.beginList(5, thriftStruct, 1)
+		t.beginElem()
+		t.str(1, "geo")
+		t.str(2, geo)
+		t.endStruct()
+	}
+	t.str(6, "tile38")
+	t.end()
+	pw.w.Write(t.buf)
+	pw.w.Write(appendUint32(nil, uint32(len(t.buf))))
+	pw.w.Write([]byte(parquetMagic))
+	return pw.w.err
+}
+
+// geoMetadata returns the GeoParquet metadata of the Geometry columns, or an
+// empty string when there are none.
+func (pw *parquetWriter) geoMetadata() string {
+	type column struct {
+		Encoding      string   `json:"encoding"`
+		GeometryTypes []string `json:"geometry_types"`
+	}
+	var geo struct {
+		Version       string            `json:"version"`
+		PrimaryColumn string            `json:"primary_column"`
+		Columns       map[string]column `json:"columns"`
+	}
+	geo.Version = "1.0.0"
+	geo.Columns = map[string]column{}
+	for _, c := range pw.schema {
+		if c.Geometry {
+			if geo.PrimaryColumn == "" {
+				geo.PrimaryColumn = c.Name
+			}
+			geo.Columns[c.Name] = column{
+				Encoding:      "WKB",
+				GeometryTypes: []string{},
+			}
+		}
+	}
+	if geo.PrimaryColumn == "" {
+		return ""
+	}
+	b, _ := json.Marshal(geo)
+	return string(b)
+}
+
+func parquetType(typ Type) int32 {
+	switch typ {
+	case Double:
+		return parquetDouble
+	case Bool:
+		return parquetBoolean
+	default:
+		return parquetByteArray
+	}
+}
+
+// The types of the thrift compact protocol.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes a struct with the thrift compact protocol, which is the
+// protocol of the metadata of Parquet. The fields are written in the order
+// of their ids.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // the last field ids of the nested structs
+}
+
+func (t *thriftWriter) reset() {
+	t.buf = t.buf[:0]
+	t.last = t.last[:0]
+}
+
+// field writes the header of a field, which is the delta from the last field
+// id and the type.
+func (t *thriftWriter) field(id int16, typ byte) {
+	if len(t.last) == 0 {
+		t.last = append(t.last, 0)
+	}
+	last := t.last[len(t.last)-1]
+	t.last[len(t.last)-1] = id
+	t.buf = append(t.buf, byte(id-last)<<4|typ)
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.elemI32(v)
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = appendUvarint(t.buf, uint64(v<<1^v>>63))
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.elemStr(s)
+}
+
+// beginStruct begins a struct field, which is ended by endStruct.
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.last = append(t.last, 0)
+}
+
+// endStruct ends a struct field or a struct element of a list.
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// end ends the top level struct.
+func (t *thriftWriter) end() {
+	t.buf = append(t.buf, 0)
+}
+
+// beginList begins a list field of n elements, which are written with the
+// elem methods.
+func (t *thriftWriter) beginList(id int16, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|typ)
+	} else {
+		t.buf = append(t.buf, 0xF0|typ)
+		t.buf = appendUvarint(t.buf, uint64(n))
+	}
+}
+
+// beginElem begins a struct element of a list, which is ended by endStruct.
+func (t *thriftWriter) beginElem() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) elemI32(v int32) {
+	t.buf = appendUvarint(t.buf, uint64(uint32(v<<1^v>>31)))
+}
+
+func (t *thriftWriter) elemStr(s string) {
+	t.buf = appendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	for v >= 0x80 {
+		dst = append(dst, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
@@ -1,11 +1,16 @@
 package deadline
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
-// Deadline allows for commands to expire when they run too long
+// Deadline allows for commands to expire when they run too long, or to be
+// canceled. The zero value has no time limit.
 type Deadline struct {
 	unixNano int64
 	hit      bool
+	canceled int32
 }
 
 // New returns a new deadline object
@@ -16,10 +21,11 @@ func New(dl time.Time) *Deadline {
 // Check the deadline and panic when reached
 //go:noinline
 func (dl *Deadline) Check() {
-	if dl == nil || dl.unixNano == 0 {
+	if dl == nil || dl.hit {
 		return
 	}
-	if !dl.hit && time.Now().UnixNano() > dl.unixNano {
+	if atomic.LoadInt32(&dl.canceled) != 0 ||
+		(dl.unixNano != 0 && time.Now().UnixNano() > dl.unixNano) {
 		dl.hit = true
 		panic("deadline")
 	}
@@ -30,6 +36,27 @@ func (dl *Deadline) Hit() bool {
 	return dl.hit
 }
 
+// Set changes the time of the deadline, before it's checked. The zero time
+// is no time limit.
+func (dl *Deadline) Set(t time.Time) {
+	if t.IsZero() {
+		dl.unixNano = 0
+	} else {
+		dl.unixNano = t.UnixNano()
+	}
+}
+
+// Cancel makes the next check panic, like a deadline that was reached. It
+// may be called from another goroutine.
+func (dl *Deadline) Cancel() {
+	atomic.StoreInt32(&dl.canceled, 1)
+}
+
+// Canceled returns true if the deadline was canceled
+func (dl *Deadline) Canceled() bool {
+	return atomic.LoadInt32(&dl.canceled) != 0
+}
+
 // GetDeadlineTime returns the time object for the deadline, and an
 // "empty" boolean
 func (dl *Deadline) GetDeadlineTime() time.Time {
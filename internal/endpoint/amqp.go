@@ -113,6 +113,8 @@ func (conn *AMQPConn) Send(msg string) error {
 		conn.channel = channel
 	}
 
+	b := getBuf(msg)
+	defer putBuf(b)
 	return conn.channel.Publish(
 		conn.ep.AMQP.QueueName,
 		conn.ep.AMQP.RouteKey,
@@ -122,7 +124,7 @@ func (conn *AMQPConn) Send(msg string) error {
 			Headers:         amqp.Table{},
 			ContentType:     "application/json",
 			ContentEncoding: "",
-			Body:            []byte(msg),
+			Body:            *b,
 			DeliveryMode:    conn.ep.AMQP.DeliveryMode,
 			Priority:        conn.ep.AMQP.Priority,
 		},
@@ -0,0 +1,147 @@
+package endpoint
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/tile38/internal/log"
+)
+
+// certReloadInterval is how often the certificate files of the endpoints are
+// checked for changes.
+const certReloadInterval = time.Second
+
+// certEntry is a client certificate, or a CA bundle, of the endpoints.
+type certEntry struct {
+	files   []string
+	checked time.Time   // the last check of the files
+	modTime []time.Time // the modification times of the loaded files
+	cert    tls.Certificate
+	pool    *x509.CertPool
+	loaded  bool
+}
+
+// certCache holds the certificate files of the endpoints, which are loaded
+// again when they are modified. The connections that are already open keep
+// using the certificates that they were opened with, and the new connections
+// use the new ones, so that short-lived certificates are rotated without a
+// restart and without reconnecting every endpoint at once.
+type certCache struct {
+	mu      sync.Mutex
+	entries map[string]*certEntry
+}
+
+func newCertCache() *certCache {
+	return &certCache{entries: make(map[string]*certEntry)}
+}
+
+// load loads the files of the entry when they are modified since the last
+// load, or when force is true. The last certificate is kept when the files
+// cannot be loaded, such as when they are half written.
+func (e *certEntry) load(now time.Time, force bool) error {
+	e.checked = now
+	modTime := make([]time.Time, len(e.files))
+	changed := !e.loaded || force
+	for i, file := range e.files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTime[i] = fi.ModTime()
+		if !changed && !modTime[i].Equal(e.modTime[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if len(e.files) == 2 {
+		cert, err := tls.LoadX509KeyPair(e.files[0], e.files[1])
+		if err != nil {
+			return err
+		}
+		e.cert = cert
+	} else {
+		data, err := ioutil.ReadFile(e.files[0])
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", e.files[0])
+		}
+		e.pool = pool
+	}
+	if e.loaded {
+		log.Infof("Reloaded %s", strings.Join(e.files, ", "))
+	}
+	e.loaded = true
+	e.modTime = modTime
+	return nil
+}
+
+// get returns the entry of the files, checking them for changes.
+func (c *certCache) get(files ...string) (*certEntry, error) {
+	key := strings.Join(files, "\x00")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &certEntry{files: files}
+		if err := e.load(time.Now(), false); err != nil {
+			return nil, err
+		}
+		c.entries[key] = e
+		return e, nil
+	}
+	if now := time.Now(); now.Sub(e.checked) >= certReloadInterval {
+		if err := e.load(now, false); err != nil {
+			log.Errorf("endpoint: %v", err)
+		}
+	}
+	return e, nil
+}
+
+// keyPair returns the client certificate of the cert and key files.
+func (c *certCache) keyPair(certFile, keyFile string) (tls.Certificate, error) {
+	e, err := c.get(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return e.cert, nil
+}
+
+// caPool returns the CAs of the bundle file.
+func (c *certCache) caPool(caFile string) (*x509.CertPool, error) {
+	e, err := c.get(caFile)
+	if err != nil {
+		return nil, err
+	}
+	return e.pool, nil
+}
+
+// reload loads all of the files now, even when they are not modified.
+func (c *certCache) reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var firstErr error
+	for _, e := range c.entries {
+		if err := e.load(time.Now(), true); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ReloadCerts loads the certificate and CA files of the endpoints now. The
+// files are also loaded when they are modified, which is checked for at most
+// once a second when an endpoint connects.
+func (epc *Manager) ReloadCerts() error {
+	return epc.certs.reload()
+}
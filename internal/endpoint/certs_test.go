@@ -0,0 +1,127 @@
+package endpoint
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey,
+		key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+		Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func certSerial(t *testing.T, cert tls.Certificate) int64 {
+	t.Helper()
+	c, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.SerialNumber.Int64()
+}
+
+func touch(files ...string) {
+	future := time.Now().Add(time.Minute)
+	for _, file := range files {
+		os.Chtimes(file, future, future)
+	}
+}
+
+func TestCertCache(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, 1)
+	certs := newCertCache()
+	cert, err := certs.keyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certSerial(t, cert) != 1 {
+		t.Fatal("expected the first certificate")
+	}
+	if _, err := certs.caPool(certFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := certs.caPool(keyFile); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// the files are not checked again until the interval has passed
+	writeTestCert(t, certFile, keyFile, 2)
+	touch(certFile, keyFile)
+	cert, _ = certs.keyPair(certFile, keyFile)
+	if certSerial(t, cert) != 1 {
+		t.Fatal("expected the first certificate")
+	}
+	for _, e := range certs.entries {
+		e.checked = time.Time{}
+	}
+	cert, _ = certs.keyPair(certFile, keyFile)
+	if certSerial(t, cert) != 2 {
+		t.Fatal("expected the second certificate")
+	}
+
+	// a broken file keeps the last certificate
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	for _, e := range certs.entries {
+		e.checked = time.Time{}
+	}
+	cert, _ = certs.keyPair(certFile, keyFile)
+	if certSerial(t, cert) != 2 {
+		t.Fatal("expected the second certificate")
+	}
+	if err := certs.reload(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// a reload loads the files even when they are not modified
+	writeTestCert(t, certFile, keyFile, 3)
+	touch(certFile, keyFile)
+	if err := certs.reload(); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = certs.keyPair(certFile, keyFile)
+	if certSerial(t, cert) != 3 {
+		t.Fatal("expected the third certificate")
+	}
+}
@@ -0,0 +1,92 @@
+package endpoint
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Check returns an error when an endpoint is not reachable, which is when a
+// connection to its host cannot be opened within the timeout. A local
+// endpoint is always reachable.
+func (epc *Manager) Check(endpoint string, timeout time.Duration) error {
+	ep, err := parseEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	addr, err := ep.addr()
+	if err != nil || addr == "" {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// addr returns the host and port of an endpoint, which is empty for a local
+// endpoint.
+func (ep Endpoint) addr() (string, error) {
+	hostPort := func(host string, port int) string {
+		return net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	switch ep.Protocol {
+	case Local:
+		return "", nil
+	case HTTP:
+		return urlAddr(ep.Original)
+	case SQS:
+		if ep.SQS.PlainURL != "" {
+			return urlAddr(ep.SQS.PlainURL)
+		}
+		return hostPort("sqs."+ep.SQS.Region+".amazonaws.com", 443), nil
+	case AMQP:
+		// the uri is user:pass@host:port/vhost, without the scheme
+		host := ep.AMQP.URI
+		if i := strings.IndexByte(host, '/'); i != -1 {
+			host = host[:i]
+		}
+		if i := strings.LastIndexByte(host, '@'); i != -1 {
+			host = host[i+1:]
+		}
+		if _, _, err := net.SplitHostPort(host); err == nil {
+			return host, nil
+		}
+		if strings.HasPrefix(ep.Original, "amqps:") {
+			return hostPort(host, 5671), nil
+		}
+		return hostPort(host, 5672), nil
+	case GRPC:
+		return hostPort(ep.GRPC.Host, ep.GRPC.Port), nil
+	case Disque:
+		return hostPort(ep.Disque.Host, ep.Disque.Port), nil
+	case Redis:
+		return hostPort(ep.Redis.Host, ep.Redis.Port), nil
+	case Kafka:
+		return hostPort(ep.Kafka.Host, ep.Kafka.Port), nil
+	case MQTT:
+		return hostPort(ep.MQTT.Host, ep.MQTT.Port), nil
+	case NATS:
+		return hostPort(ep.NATS.Host, ep.NATS.Port), nil
+	}
+	return "", errors.New("invalid protocol")
+}
+
+// urlAddr returns the host and port of an http or https url.
+func urlAddr(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+	return net.JoinHostPort(u.Hostname(), "80"), nil
+}
@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/gomodule/redigo/redis"
-	"github.com/tidwall/tile38/internal/log"
 )
 
 const disqueExpiresAfter = time.Second * 30
@@ -77,6 +76,6 @@ func (conn *DisqueConn) Send(msg string) error {
 		conn.close()
 		return err
 	}
-	log.Debugf("Disque: ADDJOB '%s'", reply)
+	epLog.Debugf("Disque: ADDJOB '%s'", reply)
 	return nil
 }
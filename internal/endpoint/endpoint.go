@@ -9,10 +9,15 @@ import (
 	"time"
 
 	"github.com/streadway/amqp"
+	"github.com/tidwall/tile38/internal/log"
+	"github.com/tidwall/tile38/internal/trace"
 )
 
 var errExpired = errors.New("expired")
 
+// epLog is the logger of the endpoints, see the loglevels of the server.
+var epLog = log.New("endpoint")
+
 // Protocol is the type of protocol that the endpoint represents.
 type Protocol string
 
@@ -121,84 +126,153 @@ type Conn interface {
 	Send(val string) error
 }
 
+// connShards is the number of the shards of the connections of a manager,
+// which are locked apart so that the sends to different endpoints don't wait
+// on each other.
+const connShards = 16
+
+// endpointCacheTime is how long the parsed endpoint of a connection that
+// expired is kept for the next connection to the endpoint.
+const endpointCacheTime = time.Minute
+
 // Manager manages all endpoints
 type Manager struct {
 	mu        sync.RWMutex
-	conns     map[string]Conn
+	shards    [connShards]connShard
 	publisher LocalPublisher
+	tracer    *trace.Tracer
+	reap      func() time.Duration // the interval of the reaping of conns
+	certs     *certCache           // the certificates of the TLS endpoints
+}
+
+// connShard is the connections of the endpoints that hash to a shard.
+type connShard struct {
+	mu    sync.Mutex
+	conns map[string]*epConn
+}
+
+// epConn is the connection of an endpoint, and the endpoint that it was
+// connected with, which is reused when the connection expires. The endpoints
+// with secret references are parsed on each connect instead, see
+// expandSecrets.
+type epConn struct {
+	conn   Conn // nil once expired
+	ep     Endpoint
+	parsed bool
+	used   time.Time
 }
 
 // NewManager returns a new manager
 func NewManager(publisher LocalPublisher) *Manager {
 	epc := &Manager{
-		conns:     make(map[string]Conn),
 		publisher: publisher,
+		certs:     newCertCache(),
 	}
 	go epc.Run()
 	return epc
 }
 
+// SetReapInterval sets how often the expired connections are closed, which
+// is every second by default.
+func (epc *Manager) SetReapInterval(interval func() time.Duration) {
+	epc.mu.Lock()
+	epc.reap = interval
+	epc.mu.Unlock()
+}
+
+// reapInterval returns the interval of the reaping of connections.
+func (epc *Manager) reapInterval() time.Duration {
+	epc.mu.RLock()
+	reap := epc.reap
+	epc.mu.RUnlock()
+	if reap == nil {
+		return time.Second
+	}
+	return reap()
+}
+
 // Run starts the managing of endpoints
 func (epc *Manager) Run() {
 	for {
-		time.Sleep(time.Second)
-		func() {
-			epc.mu.Lock()
-			defer epc.mu.Unlock()
-			for endpoint, conn := range epc.conns {
-				if conn.Expired() {
-					delete(epc.conns, endpoint)
-				}
+		time.Sleep(epc.reapInterval())
+		epc.reapConns(time.Now())
+	}
+}
+
+// reapConns drops the connections that expired, and the endpoints that were
+// not sent to for the endpointCacheTime.
+func (epc *Manager) reapConns(now time.Time) {
+	for i := range epc.shards {
+		sh := &epc.shards[i]
+		sh.mu.Lock()
+		for endpoint, c := range sh.conns {
+			if c.conn != nil && c.conn.Expired() {
+				c.conn = nil
+			}
+			if c.conn == nil && now.Sub(c.used) > endpointCacheTime {
+				delete(sh.conns, endpoint)
 			}
-		}()
+		}
+		sh.mu.Unlock()
 	}
 }
 
+// shard returns the shard of the connection of an endpoint.
+func (epc *Manager) shard(endpoint string) *connShard {
+	// fnv-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(endpoint); i++ {
+		h ^= uint32(endpoint[i])
+		h *= 16777619
+	}
+	return &epc.shards[h%connShards]
+}
+
 // Validate an endpoint url
 func (epc *Manager) Validate(url string) error {
 	_, err := parseEndpoint(url)
 	return err
 }
 
+// SetTracer sets the tracer of the sends, which are spans.
+func (epc *Manager) SetTracer(tracer *trace.Tracer) {
+	epc.tracer = tracer
+}
+
 // Send send a message to an endpoint
 func (epc *Manager) Send(endpoint, msg string) error {
+	return epc.SendTrace(trace.SpanContext{}, endpoint, msg)
+}
+
+// SendTrace sends a message to an endpoint, which is a span that is a child
+// of the parent, or of a new trace when the parent is not valid.
+func (epc *Manager) SendTrace(parent trace.SpanContext, endpoint, msg string,
+) error {
+	if !epc.tracer.Enabled() {
+		return epc.send(endpoint, msg)
+	}
+	scheme := endpoint
+	if i := strings.IndexByte(scheme, ':'); i != -1 {
+		scheme = scheme[:i]
+	}
+	span := epc.tracer.Start("send "+scheme, trace.KindClient, parent)
+	span.SetAttr("endpoint.scheme", scheme)
+	span.SetAttr("message.size", len(msg))
+	err := epc.send(endpoint, msg)
+	if err != nil {
+		span.SetError(err.Error())
+	}
+	span.End()
+	return err
+}
+
+func (epc *Manager) send(endpoint, msg string) error {
 	for {
-		epc.mu.Lock()
-		conn, exists := epc.conns[endpoint]
-		if !exists || conn.Expired() {
-			ep, err := parseEndpoint(endpoint)
-			if err != nil {
-				epc.mu.Unlock()
-				return err
-			}
-			switch ep.Protocol {
-			default:
-				return errors.New("invalid protocol")
-			case HTTP:
-				conn = newHTTPConn(ep)
-			case Disque:
-				conn = newDisqueConn(ep)
-			case GRPC:
-				conn = newGRPCConn(ep)
-			case Redis:
-				conn = newRedisConn(ep)
-			case Kafka:
-				conn = newKafkaConn(ep)
-			case MQTT:
-				conn = newMQTTConn(ep)
-			case AMQP:
-				conn = newAMQPConn(ep)
-			case SQS:
-				conn = newSQSConn(ep)
-			case NATS:
-				conn = newNATSConn(ep)
-			case Local:
-				conn = newLocalConn(ep, epc.publisher)
-			}
-			epc.conns[endpoint] = conn
+		conn, err := epc.conn(endpoint)
+		if err != nil {
+			return err
 		}
-		epc.mu.Unlock()
-		err := conn.Send(msg)
+		err = conn.Send(msg)
 		if err != nil {
 			if err == errExpired {
 				// it's possible that the connection has expired in-between
@@ -212,8 +286,100 @@ func (epc *Manager) Send(endpoint, msg string) error {
 	}
 }
 
+// conn returns the connection of an endpoint, which is connected again when
+// it expired.
+func (epc *Manager) conn(endpoint string) (Conn, error) {
+	sh := epc.shard(endpoint)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	c := sh.conns[endpoint]
+	if c != nil {
+		c.used = time.Now()
+		if c.conn != nil && !c.conn.Expired() {
+			return c.conn, nil
+		}
+	}
+	var ep Endpoint
+	if c != nil && c.parsed {
+		ep = c.ep
+	} else {
+		var err error
+		ep, err = parseEndpoint(endpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+	conn, err := epc.newConn(ep)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		if sh.conns == nil {
+			sh.conns = make(map[string]*epConn)
+		}
+		c = &epConn{used: time.Now()}
+		sh.conns[endpoint] = c
+	}
+	c.conn = conn
+	if !strings.Contains(endpoint, "${") {
+		c.ep = ep
+		c.parsed = true
+	}
+	return conn, nil
+}
+
+// newConn returns a new connection of an endpoint.
+func (epc *Manager) newConn(ep Endpoint) (Conn, error) {
+	switch ep.Protocol {
+	case HTTP:
+		return newHTTPConn(ep), nil
+	case Disque:
+		return newDisqueConn(ep), nil
+	case GRPC:
+		return newGRPCConn(ep), nil
+	case Redis:
+		return newRedisConn(ep), nil
+	case Kafka:
+		return newKafkaConn(ep, epc.certs), nil
+	case MQTT:
+		return newMQTTConn(ep, epc.certs), nil
+	case AMQP:
+		return newAMQPConn(ep), nil
+	case SQS:
+		return newSQSConn(ep), nil
+	case NATS:
+		return newNATSConn(ep), nil
+	case Local:
+		return newLocalConn(ep, epc.publisher), nil
+	}
+	return nil, errors.New("invalid protocol")
+}
+
+// bufPool is the buffers of the messages of the endpoints that send bytes.
+var bufPool = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+// getBuf returns a buffer from the pool with a copy of a message. The buffer
+// is put back with putBuf once the message was sent.
+func getBuf(msg string) *[]byte {
+	b := bufPool.Get().(*[]byte)
+	*b = append((*b)[:0], msg...)
+	return b
+}
+
+func putBuf(b *[]byte) {
+	if cap(*b) > 1<<20 {
+		// don't keep the buffers of the odd large message
+		return
+	}
+	bufPool.Put(b)
+}
+
 func parseEndpoint(s string) (Endpoint, error) {
 	var endpoint Endpoint
+	s, err := expandSecrets(s)
+	if err != nil {
+		return endpoint, err
+	}
 	endpoint.Original = s
 	switch {
 	default:
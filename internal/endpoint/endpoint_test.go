@@ -0,0 +1,74 @@
+package endpoint
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+type testPublisher struct{ msgs []string }
+
+func (p *testPublisher) Publish(channel string, message ...string) int {
+	p.msgs = append(p.msgs, message...)
+	return 1
+}
+
+func TestManagerConns(t *testing.T) {
+	pub := &testPublisher{}
+	epc := &Manager{publisher: pub}
+	for i := 0; i < 3; i++ {
+		if err := epc.Send("local://chan", "msg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pub.msgs) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(pub.msgs))
+	}
+	c := epc.shard("local://chan").conns["local://chan"]
+	if c == nil || !c.parsed || c.ep.Local.Channel != "chan" {
+		t.Fatal("expected the parsed endpoint of the connection")
+	}
+	conn := c.conn
+	if err := epc.Send("local://chan", "msg"); err != nil {
+		t.Fatal(err)
+	}
+	if c.conn != conn {
+		t.Fatal("expected the connection to be reused")
+	}
+
+	// the endpoint of an expired connection is not parsed again
+	c.conn = nil
+	c.ep.Local.Channel = "cached"
+	if err := epc.Send("local://chan", "msg"); err != nil {
+		t.Fatal(err)
+	}
+	if c.conn == nil || c.conn.(*LocalConn).ep.Local.Channel != "cached" {
+		t.Fatal("expected the cached endpoint")
+	}
+
+	// the endpoints with secrets are parsed on each connect
+	os.Setenv("EP_TEST_CHAN", "secret")
+	defer os.Unsetenv("EP_TEST_CHAN")
+	if err := epc.Send("local://${EP_TEST_CHAN}", "msg"); err != nil {
+		t.Fatal(err)
+	}
+	s := epc.shard("local://${EP_TEST_CHAN}").conns["local://${EP_TEST_CHAN}"]
+	if s == nil || s.parsed {
+		t.Fatal("expected the endpoint with secrets to not be cached")
+	}
+
+	// the endpoints that were not sent to for a while are dropped
+	c.conn = nil
+	epc.reapConns(time.Now())
+	if epc.shard("local://chan").conns["local://chan"] == nil {
+		t.Fatal("expected the recent endpoint to be kept")
+	}
+	epc.reapConns(time.Now().Add(endpointCacheTime * 2))
+	if epc.shard("local://chan").conns["local://chan"] != nil {
+		t.Fatal("expected the endpoint to be dropped")
+	}
+
+	if err := epc.Send("bad://chan", "msg"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
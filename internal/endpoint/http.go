@@ -1,11 +1,11 @@
 package endpoint
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -41,7 +41,7 @@ func (conn *HTTPConn) Expired() bool {
 
 // Send sends a message
 func (conn *HTTPConn) Send(msg string) error {
-	req, err := http.NewRequest("POST", conn.ep.Original, bytes.NewBufferString(msg))
+	req, err := http.NewRequest("POST", conn.ep.Original, strings.NewReader(msg))
 	if err != nil {
 		return err
 	}
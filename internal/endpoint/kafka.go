@@ -2,10 +2,8 @@ package endpoint
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"sync"
 	"time"
 
@@ -20,11 +18,12 @@ const kafkaExpiresAfter = time.Second * 30
 
 // KafkaConn is an endpoint connection
 type KafkaConn struct {
-	mu   sync.Mutex
-	ep   Endpoint
-	conn sarama.SyncProducer
-	ex   bool
-	t    time.Time
+	mu    sync.Mutex
+	ep    Endpoint
+	conn  sarama.SyncProducer
+	ex    bool
+	t     time.Time
+	certs *certCache
 }
 
 // Expired returns true if the connection has expired
@@ -59,7 +58,7 @@ func (conn *KafkaConn) Send(msg string) error {
 	}
 	conn.t = time.Now()
 
-	if log.Level > 2 {
+	if epLog.Level() > 2 {
 		sarama.Logger = lg.New(log.Output(), "[sarama] ", 0)
 	}
 
@@ -68,8 +67,8 @@ func (conn *KafkaConn) Send(msg string) error {
 		cfg := sarama.NewConfig()
 
 		if conn.ep.Kafka.TLS {
-			log.Debugf("building kafka tls config")
-			tlsConfig, err := newKafkaTLSConfig(conn.ep.Kafka.CertFile, conn.ep.Kafka.KeyFile, conn.ep.Kafka.CACertFile)
+			epLog.Debugf("building kafka tls config")
+			tlsConfig, err := newKafkaTLSConfig(conn.certs, conn.ep.Kafka.CertFile, conn.ep.Kafka.KeyFile, conn.ep.Kafka.CACertFile)
 			if err != nil {
 				return err
 			}
@@ -117,30 +116,29 @@ func (conn *KafkaConn) Send(msg string) error {
 	return nil
 }
 
-func newKafkaConn(ep Endpoint) *KafkaConn {
+func newKafkaConn(ep Endpoint, certs *certCache) *KafkaConn {
 	return &KafkaConn{
-		ep: ep,
-		t:  time.Now(),
+		ep:    ep,
+		t:     time.Now(),
+		certs: certs,
 	}
 }
 
-func newKafkaTLSConfig(CertFile, KeyFile, CACertFile string) (*tls.Config, error) {
+func newKafkaTLSConfig(certs *certCache, CertFile, KeyFile, CACertFile string) (*tls.Config, error) {
 	tlsConfig := tls.Config{}
 
 	// Load client cert
-	cert, err := tls.LoadX509KeyPair(CertFile, KeyFile)
+	cert, err := certs.keyPair(CertFile, KeyFile)
 	if err != nil {
 		return &tlsConfig, err
 	}
 	tlsConfig.Certificates = []tls.Certificate{cert}
 
 	// Load CA cert
-	caCert, err := ioutil.ReadFile(CACertFile)
+	caCertPool, err := certs.caPool(CACertFile)
 	if err != nil {
 		return &tlsConfig, err
 	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
 	tlsConfig.RootCAs = caCertPool
 
 	return &tlsConfig, err
@@ -2,15 +2,12 @@ package endpoint
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
-	"io/ioutil"
 	"math/rand"
 	"sync"
 	"time"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
-	"github.com/tidwall/tile38/internal/log"
 )
 
 const (
@@ -20,11 +17,12 @@ const (
 
 // MQTTConn is an endpoint connection
 type MQTTConn struct {
-	mu   sync.Mutex
-	ep   Endpoint
-	conn paho.Client
-	ex   bool
-	t    time.Time
+	mu    sync.Mutex
+	ep    Endpoint
+	conn  paho.Client
+	ex    bool
+	t     time.Time
+	certs *certCache
 }
 
 // Expired returns true if the connection has expired
@@ -67,7 +65,7 @@ func (conn *MQTTConn) Send(msg string) error {
 			conn.ep.MQTT.CACertFile != "" {
 			var config tls.Config
 			if conn.ep.MQTT.CertFile != "" || conn.ep.MQTT.KeyFile != "" {
-				cert, err := tls.LoadX509KeyPair(conn.ep.MQTT.CertFile,
+				cert, err := conn.certs.keyPair(conn.ep.MQTT.CertFile,
 					conn.ep.MQTT.KeyFile)
 				if err != nil {
 					return err
@@ -76,12 +74,10 @@ func (conn *MQTTConn) Send(msg string) error {
 			}
 			if conn.ep.MQTT.CACertFile != "" {
 				// Load CA cert
-				caCert, err := ioutil.ReadFile(conn.ep.MQTT.CACertFile)
+				caCertPool, err := conn.certs.caPool(conn.ep.MQTT.CACertFile)
 				if err != nil {
 					return err
 				}
-				caCertPool := x509.NewCertPool()
-				caCertPool.AppendCertsFromPEM(caCert)
 				config.RootCAs = caCertPool
 			}
 			ops = ops.SetTLSConfig(&config)
@@ -90,7 +86,7 @@ func (conn *MQTTConn) Send(msg string) error {
 		b := make([]byte, 16)
 		_, err := rand.Read(b)
 		if err != nil {
-			log.Debugf("Failed to generate guid for the mqtt client. The endpoint will not work")
+			epLog.Debugf("Failed to generate guid for the mqtt client. The endpoint will not work")
 			return err
 		}
 		uuid := fmt.Sprintf("tile38-%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
@@ -116,9 +112,10 @@ func (conn *MQTTConn) Send(msg string) error {
 	return nil
 }
 
-func newMQTTConn(ep Endpoint) *MQTTConn {
+func newMQTTConn(ep Endpoint, certs *certCache) *MQTTConn {
 	return &MQTTConn{
-		ep: ep,
-		t:  time.Now(),
+		ep:    ep,
+		t:     time.Now(),
+		certs: certs,
 	}
 }
@@ -69,7 +69,9 @@ func (conn *NATSConn) Send(msg string) error {
 			return err
 		}
 	}
-	err := conn.conn.Publish(conn.ep.NATS.Topic, []byte(msg))
+	b := getBuf(msg)
+	err := conn.conn.Publish(conn.ep.NATS.Topic, *b)
+	putBuf(b)
 	if err != nil {
 		conn.close()
 		return err
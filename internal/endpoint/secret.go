@@ -0,0 +1,114 @@
+package endpoint
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandSecrets replaces the ${NAME} references of an endpoint with the
+// environment variables, so that the credentials of an endpoint are not in
+// the AOF or in the output of HOOKS. The references are expanded each time
+// that an endpoint is connected.
+func expandSecrets(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i == -1 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j == -1 {
+			return "", fmt.Errorf("unterminated secret reference")
+		}
+		name := s[i+2 : i+j]
+		if !validSecretName(name) {
+			return "", fmt.Errorf("invalid secret reference '%s'", name)
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("undefined secret '%s'", name)
+		}
+		b.WriteString(s[:i])
+		b.WriteString(value)
+		s = s[i+j+1:]
+	}
+	b.WriteString(s)
+	return b.String(), nil
+}
+
+func validSecretName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// redacted replaces a credential of a redacted endpoint.
+const redacted = "xxxxx"
+
+// secretParam returns true for the query parameters of an endpoint that are
+// credentials, such as pass, password, token, or secret.
+func secretParam(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"pass", "token", "secret", "apikey",
+		"api_key", "signature", "credential"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return key == "sig"
+}
+
+// Redact returns an endpoint without its credentials, which are the password
+// of the user info, and the values of the query parameters that are
+// credentials. The ${NAME} secret references are not redacted.
+func Redact(s string) string {
+	i := strings.Index(s, "://")
+	if i == -1 {
+		return s
+	}
+	head, rest := s[:i+3], s[i+3:]
+	var query string
+	if j := strings.IndexByte(rest, '?'); j != -1 {
+		rest, query = rest[:j], rest[j+1:]
+	}
+	// the user info is before the last @ of the host
+	host := rest
+	if j := strings.IndexByte(host, '/'); j != -1 {
+		host = host[:j]
+	}
+	if at := strings.LastIndexByte(host, '@'); at != -1 {
+		if colon := strings.IndexByte(host[:at], ':'); colon != -1 &&
+			!isSecretRef(host[colon+1:at]) {
+			rest = host[:colon+1] + redacted + rest[at:]
+		}
+	}
+	if query == "" {
+		return head + rest
+	}
+	params := strings.Split(query, "&")
+	for k, param := range params {
+		if eq := strings.IndexByte(param, '='); eq != -1 &&
+			secretParam(param[:eq]) && !isSecretRef(param[eq+1:]) {
+			params[k] = param[:eq+1] + redacted
+		}
+	}
+	return head + rest + "?" + strings.Join(params, "&")
+}
+
+// isSecretRef returns true when a value is only a ${NAME} reference.
+func isSecretRef(s string) bool {
+	return strings.HasPrefix(s, "${") && strings.HasSuffix(s, "}") &&
+		validSecretName(s[2:len(s)-1])
+}
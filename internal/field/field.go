@@ -0,0 +1,159 @@
+// Package field has the values of the fields of objects, which are numbers,
+// strings, bools, or json.
+package field
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+// Kind is the type of a field value
+type Kind byte
+
+const (
+	// Number is a float64 value. It's the kind of the zero Value.
+	Number Kind = iota
+	// String is a string value
+	String
+	// Bool is a true or false value
+	Bool
+	// JSON is a json object or array
+	JSON
+)
+
+func (kind Kind) String() string {
+	switch kind {
+	case Number:
+		return "number"
+	case String:
+		return "string"
+	case Bool:
+		return "bool"
+	case JSON:
+		return "json"
+	}
+	return "unknown"
+}
+
+// Value is the value of a field. The zero Value is the number zero, which is
+// also the value of a field that has not been set.
+type Value struct {
+	kind Kind
+	num  float64
+	str  string
+}
+
+// Num returns a number value
+func Num(num float64) Value {
+	return Value{num: num}
+}
+
+// Str returns a string value, even for text that Parse would read as
+// another kind.
+func Str(s string) Value {
+	return Value{kind: String, str: s}
+}
+
+// Parse returns the value of a field argument. The argument is a number when
+// it can be parsed as one, a bool when it's 'true' or 'false', json when it's
+// a valid json object or array, and otherwise a string. Since the kind only
+// depends on the text, Parse(v.String()) always returns v.
+func Parse(s string) Value {
+	if num, err := strconv.ParseFloat(s, 64); err == nil {
+		return Value{num: num}
+	}
+	switch s {
+	case "true":
+		return Value{kind: Bool, num: 1, str: "true"}
+	case "false":
+		return Value{kind: Bool, str: "false"}
+	}
+	if len(s) > 0 && (s[0] == '{' || s[0] == '[') && gjson.Valid(s) {
+		return Value{kind: JSON, str: s}
+	}
+	return Value{kind: String, str: s}
+}
+
+// Kind returns the kind of the value
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+// Num returns the number of a Number, 1 or 0 for a Bool, and 0 for a String
+// or JSON.
+func (v Value) Num() float64 {
+	return v.num
+}
+
+// IsNumeric returns true for a Number or Bool, which can be compared with
+// numbers.
+func (v Value) IsNumeric() bool {
+	return v.kind == Number || v.kind == Bool
+}
+
+// IsZero returns true for the number zero.
+func (v Value) IsZero() bool {
+	return v.kind == Number && v.num == 0
+}
+
+// String returns the text of the value, which is the argument that would set
+// the value.
+func (v Value) String() string {
+	if v.kind == Number {
+		return strconv.FormatFloat(v.num, 'f', -1, 64)
+	}
+	return v.str
+}
+
+// JSON returns the value as json. A String is quoted and everything else is
+// written as is.
+func (v Value) JSON() string {
+	switch v.kind {
+	case Number:
+		return strconv.FormatFloat(v.num, 'f', -1, 64)
+	case String:
+		b, _ := json.Marshal(v.str)
+		return string(b)
+	}
+	return v.str
+}
+
+// AppendJSON appends the value as json, which is the same as JSON.
+func (v Value) AppendJSON(dst []byte) []byte {
+	switch v.kind {
+	case Number:
+		return strconv.AppendFloat(dst, v.num, 'f', -1, 64)
+	case String:
+		for i := 0; i < len(v.str); i++ {
+			c := v.str[i]
+			if c < ' ' || c > 126 || c == '"' || c == '\\' ||
+				c == '<' || c == '>' || c == '&' {
+				b, _ := json.Marshal(v.str)
+				return append(dst, b...)
+			}
+		}
+		dst = append(dst, '"')
+		dst = append(dst, v.str...)
+		return append(dst, '"')
+	}
+	return append(dst, v.str...)
+}
+
+// Weight returns the number of bytes that the value uses.
+func (v Value) Weight() int {
+	return 8 + len(v.str)
+}
+
+// Less returns true when a is ordered before b. Numbers and bools come first
+// in numeric order, then the strings and json in text order.
+func Less(a, b Value) bool {
+	if a.IsNumeric() != b.IsNumeric() {
+		return a.IsNumeric()
+	}
+	if a.IsNumeric() {
+		return a.num < b.num
+	}
+	return a.str < b.str
+}
@@ -0,0 +1,85 @@
+package field
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		arg  string
+		kind Kind
+		json string
+	}{
+		{"10", Number, "10"},
+		{"-1.5", Number, "-1.5"},
+		{"1e3", Number, "1000"},
+		{"true", Bool, "true"},
+		{"false", Bool, "false"},
+		{"TRUE", String, `"TRUE"`},
+		{"idle", String, `"idle"`},
+		{`say "hi"`, String, `"say \"hi\""`},
+		{`{"a":[1,2]}`, JSON, `{"a":[1,2]}`},
+		{"[1,2]", JSON, "[1,2]"},
+		{"{bad", String, `"{bad"`},
+		{"a<b", String, `"a\u003cb"`},
+		{"café", String, `"café"`},
+	}
+	for _, tt := range tests {
+		v := Parse(tt.arg)
+		if v.Kind() != tt.kind || v.JSON() != tt.json {
+			t.Fatalf("%s: expected %s %s, got %s %s",
+				tt.arg, tt.kind, tt.json, v.Kind(), v.JSON())
+		}
+		if string(v.AppendJSON([]byte("x"))) != "x"+tt.json {
+			t.Fatalf("%s: expected %s, got %s", tt.arg, tt.json,
+				v.AppendJSON(nil))
+		}
+		if Parse(v.String()) != v {
+			t.Fatalf("%s: expected the same value after parsing %s",
+				tt.arg, v.String())
+		}
+	}
+	if !(Value{}).IsZero() || Parse("0") != (Value{}) || Parse("false").IsZero() {
+		t.Fatal("expected only the number zero to be zero")
+	}
+	if Parse("true").Num() != 1 || Parse("idle").Num() != 0 {
+		t.Fatal("expected bools to be numeric")
+	}
+}
+
+func TestLess(t *testing.T) {
+	ordered := []Value{Num(-1), Parse("false"), Num(0.5), Parse("true"),
+		Num(2), Parse("[1]"), Parse("a"), Parse("b")}
+	for i := 0; i < len(ordered)-1; i++ {
+		if !Less(ordered[i], ordered[i+1]) || Less(ordered[i+1], ordered[i]) {
+			t.Fatalf("expected %s before %s", ordered[i], ordered[i+1])
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	var p Pool
+	arg := "status=idle"
+	a := p.Intern(Parse(arg[7:]))
+	b := p.Intern(Parse("idle"))
+	if a != b || p.Len() != 1 {
+		t.Fatalf("expected one text, got %d", p.Len())
+	}
+	if p.Intern(Num(10)) != Num(10) || p.Intern(Str("")) != Str("") {
+		t.Fatal("expected the same value")
+	}
+	long := Parse(strings.Repeat("a", poolMaxLen+1))
+	if p.Intern(long) != long || p.Len() != 1 {
+		t.Fatal("expected long text to not be pooled")
+	}
+	p.Release(a)
+	if p.Len() != 1 {
+		t.Fatal("expected the text to be held by the other value")
+	}
+	p.Release(b)
+	p.Release(b)
+	if p.Len() != 0 {
+		t.Fatalf("expected an empty pool, got %d", p.Len())
+	}
+}
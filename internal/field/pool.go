@@ -0,0 +1,65 @@
+package field
+
+// poolMaxLen is the length of the longest text that a Pool shares. Longer
+// text, such as json documents, is rarely the same for many objects.
+const poolMaxLen = 64
+
+// Pool shares the text of equal values, so that the objects with the same
+// value of a field, such as a status or a type, hold one copy of the text
+// instead of one each. The text is counted, and dropped from the pool when
+// the last value that holds it is released. The zero Pool is ready to use.
+type Pool struct {
+	entries map[string]poolEntry
+}
+
+type poolEntry struct {
+	str  string
+	refs int
+}
+
+func pooled(v Value) bool {
+	return v.kind != Number && len(v.str) > 0 && len(v.str) <= poolMaxLen
+}
+
+// Intern returns the value with the text of the pool, adding the text to the
+// pool when it's not there yet. Every value that is interned must be
+// released when it's no longer held.
+func (p *Pool) Intern(v Value) Value {
+	if !pooled(v) {
+		return v
+	}
+	if p.entries == nil {
+		p.entries = make(map[string]poolEntry)
+	}
+	e, ok := p.entries[v.str]
+	if !ok {
+		// copy the text, which may be a part of a larger string, such as
+		// the command that set it
+		e.str = string([]byte(v.str))
+	}
+	e.refs++
+	p.entries[e.str] = e
+	v.str = e.str
+	return v
+}
+
+// Release drops a value that was interned.
+func (p *Pool) Release(v Value) {
+	if !pooled(v) {
+		return
+	}
+	e, ok := p.entries[v.str]
+	if !ok {
+		return
+	}
+	if e.refs--; e.refs == 0 {
+		delete(p.entries, v.str)
+	} else {
+		p.entries[v.str] = e
+	}
+}
+
+// Len returns the number of texts in the pool.
+func (p *Pool) Len() int {
+	return len(p.entries)
+}
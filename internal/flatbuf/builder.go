@@ -0,0 +1,223 @@
+// Package flatbuf builds and reads FlatBuffers, which are read in place
+// without parsing. The builder is like the builders of flatc, from the back
+// of the buffer to the front, so that the offsets point forward.
+package flatbuf
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Builder builds a FlatBuffer. The buffer is reused by Reset, so a builder
+// that is sized for the buffers does not allocate.
+type Builder struct {
+	buf      []byte
+	head     int   // the front of the data, which grows to the front
+	minalign int   // the largest alignment of the data
+	vtable   []int // the offsets of the fields of the table
+	start    int   // the offset of the start of the table
+}
+
+// NewBuilder returns a builder with a buffer of size bytes.
+func NewBuilder(size int) *Builder {
+	return &Builder{buf: make([]byte, size), head: size, minalign: 1}
+}
+
+// Reset resets the builder for a new buffer.
+func (b *Builder) Reset() {
+	b.head = len(b.buf)
+	b.minalign = 1
+	b.vtable = b.vtable[:0]
+}
+
+// Offset returns the offset of the front of the data, from the back of the
+// buffer. The offsets of the strings, vectors, and tables are offsets.
+func (b *Builder) Offset() int {
+	return len(b.buf) - b.head
+}
+
+// grow grows the buffer until it has n bytes in front of the data.
+func (b *Builder) grow(n int) {
+	for b.head < n {
+		size := len(b.buf) * 2
+		if size == 0 {
+			size = 64
+		}
+		buf := make([]byte, size)
+		copy(buf[size-b.Offset():], b.buf[b.head:])
+		b.head += size - len(b.buf)
+		b.buf = buf
+	}
+}
+
+// prep pads the front, so that a value of size is aligned after the
+// additional bytes are prepended.
+func (b *Builder) prep(size, additional int) {
+	if size > b.minalign {
+		b.minalign = size
+	}
+	pad := -(b.Offset() + additional) & (size - 1)
+	b.grow(pad + size + additional)
+	for i := 0; i < pad; i++ {
+		b.head--
+		b.buf[b.head] = 0
+	}
+}
+
+func (b *Builder) prependUint16(v uint16) {
+	b.prep(2, 0)
+	b.head -= 2
+	binary.LittleEndian.PutUint16(b.buf[b.head:], v)
+}
+
+func (b *Builder) prependUint32(v uint32) {
+	b.prep(4, 0)
+	b.head -= 4
+	binary.LittleEndian.PutUint32(b.buf[b.head:], v)
+}
+
+func (b *Builder) prependUint64(v uint64) {
+	b.prep(8, 0)
+	b.head -= 8
+	binary.LittleEndian.PutUint64(b.buf[b.head:], v)
+}
+
+// prependUOffset prepends an offset to a value, which is relative to the
+// offset itself.
+func (b *Builder) prependUOffset(off int) {
+	b.prep(4, 0)
+	b.prependUint32(uint32(b.Offset() - off + 4))
+}
+
+// CreateString prepends a string and returns its offset.
+func (b *Builder) CreateString(s string) int {
+	b.prep(4, len(s)+1)
+	b.head--
+	b.buf[b.head] = 0
+	b.head -= len(s)
+	copy(b.buf[b.head:], s)
+	b.prependUint32(uint32(len(s)))
+	return b.Offset()
+}
+
+// CreateStructs prepends a vector of n structs, which are the bytes of the
+// structs in order, and returns its offset. The align is the alignment of
+// the structs.
+func (b *Builder) CreateStructs(structs []byte, n, align int) int {
+	b.prep(4, len(structs))
+	b.prep(align, len(structs))
+	b.head -= len(structs)
+	copy(b.buf[b.head:], structs)
+	b.prependUint32(uint32(n))
+	return b.Offset()
+}
+
+// CreateOffsets prepends a vector of the offsets of strings or tables and
+// returns its offset.
+func (b *Builder) CreateOffsets(offs []int) int {
+	b.prep(4, 4*len(offs))
+	for i := len(offs) - 1; i >= 0; i-- {
+		b.prependUOffset(offs[i])
+	}
+	b.prependUint32(uint32(len(offs)))
+	return b.Offset()
+}
+
+// StartTable starts a table of fields, which are added with the ids of the
+// schema. The strings, vectors, and tables of the fields are created before
+// the table is started.
+func (b *Builder) StartTable(fields int) {
+	b.vtable = b.vtable[:0]
+	for i := 0; i < fields; i++ {
+		b.vtable = append(b.vtable, 0)
+	}
+	b.start = b.Offset()
+}
+
+// AddBool adds a bool field, which is not added when it is false.
+func (b *Builder) AddBool(id int, v bool) {
+	if v {
+		b.prep(1, 0)
+		b.head--
+		b.buf[b.head] = 1
+		b.vtable[id] = b.Offset()
+	}
+}
+
+// AddUint8 adds a ubyte field, which is not added when it is zero.
+func (b *Builder) AddUint8(id int, v uint8) {
+	if v != 0 {
+		b.prep(1, 0)
+		b.head--
+		b.buf[b.head] = v
+		b.vtable[id] = b.Offset()
+	}
+}
+
+// AddUint16 adds a ushort or short field, which is not added when it is
+// zero.
+func (b *Builder) AddUint16(id int, v uint16) {
+	if v != 0 {
+		b.prependUint16(v)
+		b.vtable[id] = b.Offset()
+	}
+}
+
+// AddUint64 adds a ulong or long field, which is not added when it is zero.
+func (b *Builder) AddUint64(id int, v uint64) {
+	if v != 0 {
+		b.prependUint64(v)
+		b.vtable[id] = b.Offset()
+	}
+}
+
+// AddFloat64 adds a double field, which is not added when it is zero.
+func (b *Builder) AddFloat64(id int, v float64) {
+	if v != 0 {
+		b.prependUint64(math.Float64bits(v))
+		b.vtable[id] = b.Offset()
+	}
+}
+
+// AddOffset adds the offset of a string, vector, or table, which is not
+// added when it is zero.
+func (b *Builder) AddOffset(id int, off int) {
+	if off != 0 {
+		b.prependUOffset(off)
+		b.vtable[id] = b.Offset()
+	}
+}
+
+// EndTable prepends the table and its vtable, and returns its offset.
+func (b *Builder) EndTable() int {
+	b.prependUint32(0) // the offset of the vtable
+	table := b.Offset()
+	n := len(b.vtable)
+	for n > 0 && b.vtable[n-1] == 0 {
+		n--
+	}
+	for i := n - 1; i >= 0; i-- {
+		var off int
+		if b.vtable[i] != 0 {
+			off = table - b.vtable[i]
+		}
+		b.prependUint16(uint16(off))
+	}
+	b.prependUint16(uint16(table - b.start))
+	b.prependUint16(uint16(4 + 2*n))
+	// the vtable is in front of the table
+	binary.LittleEndian.PutUint32(b.buf[len(b.buf)-table:],
+		uint32(int32(b.Offset()-table)))
+	return table
+}
+
+// Finish prepends the offset of the root table and the file identifier,
+// which is four bytes, and returns the buffer. The buffer is valid until the
+// builder is reset.
+func (b *Builder) Finish(root int, ident string) []byte {
+	b.prep(b.minalign, 4+len(ident))
+	b.head -= len(ident)
+	copy(b.buf[b.head:], ident)
+	b.prependUOffset(root)
+	return b.buf[b.head:]
+}
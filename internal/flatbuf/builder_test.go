@@ -0,0 +1,64 @@
+package flatbuf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder(16) // grows
+	for i := 0; i < 2; i++ {
+		b.Reset()
+		name := b.CreateString("truck1")
+		b.StartTable(3)
+		b.AddOffset(0, name)
+		b.AddFloat64(1, 33.5)
+		child := b.EndTable()
+		names := b.CreateOffsets([]int{b.CreateString("a"),
+			b.CreateString("bc")})
+		children := b.CreateOffsets([]int{child})
+		b.StartTable(6)
+		b.AddBool(0, true)
+		b.AddOffset(1, names)
+		b.AddUint64(2, 12)
+		b.AddOffset(3, children)
+		b.AddUint64(4, 0) // absent
+		buf := b.Finish(b.EndTable(), "TEST")
+		if len(buf)%8 != 0 {
+			t.Fatalf("expected an aligned buffer, got %d bytes", len(buf))
+		}
+		if Identifier(buf) != "TEST" {
+			t.Fatalf("unexpected identifier: %q", buf[4:8])
+		}
+		root := Root(buf)
+		if !root.Bool(0) || root.Uint64(2) != 12 || root.Uint64(4) != 0 ||
+			root.String(5) != "" {
+			t.Fatal("unexpected scalars")
+		}
+		if v := root.Vector(1); v.Len() != 2 || v.String(0) != "a" ||
+			v.String(1) != "bc" {
+			t.Fatal("unexpected strings")
+		}
+		if root.Vector(5).Len() != 0 {
+			t.Fatal("expected an empty vector")
+		}
+		v := root.Vector(3)
+		if v.Len() != 1 {
+			t.Fatal("expected a table")
+		}
+		tbl := v.Table(0)
+		if tbl.String(0) != "truck1" || tbl.Float64(1) != 33.5 ||
+			tbl.Float64(2) != 0 {
+			t.Fatal("unexpected table")
+		}
+		// the doubles are aligned
+		if pos := tbl.field(1); pos%8 != 0 {
+			t.Fatalf("unaligned double at %d", pos)
+		}
+		// the strings are terminated
+		pos := tbl.indirect(tbl.field(0))
+		if n := binary.LittleEndian.Uint32(buf[pos:]); buf[pos+4+int(n)] != 0 {
+			t.Fatal("expected a terminated string")
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package flatbuf
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Table is a table of a FlatBuffer, which is read in place.
+type Table struct {
+	buf []byte
+	pos int
+}
+
+// Root returns the root table of a buffer.
+func Root(buf []byte) Table {
+	return Table{buf: buf, pos: int(binary.LittleEndian.Uint32(buf))}
+}
+
+// Identifier returns the file identifier of a buffer.
+func Identifier(buf []byte) string {
+	return string(buf[4:8])
+}
+
+// field returns the position of a field, or zero when it is absent.
+func (t Table) field(id int) int {
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	off := 4 + 2*id
+	if off >= int(binary.LittleEndian.Uint16(t.buf[vtable:])) {
+		return 0
+	}
+	if v := binary.LittleEndian.Uint16(t.buf[vtable+off:]); v != 0 {
+		return t.pos + int(v)
+	}
+	return 0
+}
+
+// indirect returns the position that an offset points to.
+func (t Table) indirect(pos int) int {
+	return pos + int(binary.LittleEndian.Uint32(t.buf[pos:]))
+}
+
+// Bool returns a bool field.
+func (t Table) Bool(id int) bool {
+	pos := t.field(id)
+	return pos != 0 && t.buf[pos] != 0
+}
+
+// Uint8 returns a ubyte field.
+func (t Table) Uint8(id int) uint8 {
+	if pos := t.field(id); pos != 0 {
+		return t.buf[pos]
+	}
+	return 0
+}
+
+// Uint16 returns a ushort or short field.
+func (t Table) Uint16(id int) uint16 {
+	if pos := t.field(id); pos != 0 {
+		return binary.LittleEndian.Uint16(t.buf[pos:])
+	}
+	return 0
+}
+
+// Uint64 returns a ulong or long field.
+func (t Table) Uint64(id int) uint64 {
+	if pos := t.field(id); pos != 0 {
+		return binary.LittleEndian.Uint64(t.buf[pos:])
+	}
+	return 0
+}
+
+// Float64 returns a double field.
+func (t Table) Float64(id int) float64 {
+	if pos := t.field(id); pos != 0 {
+		return math.Float64frombits(binary.LittleEndian.Uint64(t.buf[pos:]))
+	}
+	return 0
+}
+
+// String returns a string field.
+func (t Table) String(id int) string {
+	if pos := t.field(id); pos != 0 {
+		return t.str(t.indirect(pos))
+	}
+	return ""
+}
+
+func (t Table) str(pos int) string {
+	n := int(binary.LittleEndian.Uint32(t.buf[pos:]))
+	return string(t.buf[pos+4 : pos+4+n])
+}
+
+// Table returns a table field.
+func (t Table) Table(id int) Table {
+	if pos := t.field(id); pos != 0 {
+		return Table{buf: t.buf, pos: t.indirect(pos)}
+	}
+	return Table{}
+}
+
+// Vector returns a vector field of strings, tables, or structs.
+func (t Table) Vector(id int) Vector {
+	if pos := t.field(id); pos != 0 {
+		return Vector{t: t, pos: t.indirect(pos)}
+	}
+	return Vector{}
+}
+
+// Vector is a vector of strings, tables, or structs.
+type Vector struct {
+	t   Table
+	pos int
+}
+
+// Len returns the length of the vector.
+func (v Vector) Len() int {
+	if v.t.buf == nil {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(v.t.buf[v.pos:]))
+}
+
+// String returns a string of the vector.
+func (v Vector) String(i int) string {
+	return v.t.str(v.t.indirect(v.pos + 4 + 4*i))
+}
+
+// Struct returns the bytes of a struct of size of the vector.
+func (v Vector) Struct(i, size int) []byte {
+	pos := v.pos + 4 + size*i
+	return v.t.buf[pos : pos+size]
+}
+
+// Table returns a table of the vector.
+func (v Vector) Table(i int) Table {
+	return Table{buf: v.t.buf, pos: v.t.indirect(v.pos + 4 + 4*i)}
+}
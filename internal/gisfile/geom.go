@@ -0,0 +1,357 @@
+package gisfile
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+var errInvalidGeometry = errors.New("invalid geometry")
+
+// geomWriter appends GeoJSON geometries, converting each coordinate with a
+// projection.
+type geomWriter struct {
+	proj Projection
+	dst  []byte
+}
+
+func (w *geomWriter) coord(x, y float64, z []float64) {
+	lon, lat := w.proj(x, y)
+	w.dst = append(w.dst, '[')
+	w.dst = appendFloat(w.dst, lon)
+	w.dst = append(w.dst, ',')
+	w.dst = appendFloat(w.dst, lat)
+	if len(z) > 0 {
+		w.dst = append(w.dst, ',')
+		w.dst = appendFloat(w.dst, z[0])
+	}
+	w.dst = append(w.dst, ']')
+}
+
+func appendFloat(dst []byte, f float64) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return append(dst, '0')
+	}
+	return strconv.AppendFloat(dst, f, 'f', -1, 64)
+}
+
+func (w *geomWriter) begin(typ string) {
+	w.dst = append(w.dst, `{"type":"`...)
+	w.dst = append(w.dst, typ...)
+	w.dst = append(w.dst, `","coordinates":`...)
+}
+
+// wkbReader reads a well-known binary geometry.
+type wkbReader struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+func (r *wkbReader) uint32() (uint32, error) {
+	if len(r.b) < 4 {
+		return 0, errInvalidGeometry
+	}
+	v := r.order.Uint32(r.b)
+	r.b = r.b[4:]
+	return v, nil
+}
+
+func (r *wkbReader) float64() (float64, error) {
+	if len(r.b) < 8 {
+		return 0, errInvalidGeometry
+	}
+	v := math.Float64frombits(r.order.Uint64(r.b))
+	r.b = r.b[8:]
+	return v, nil
+}
+
+// appendWKB appends the WKB geometry in b as GeoJSON. Both the ISO and the
+// extended WKB dimension flags are supported. The Z values are kept and the M
+// values are dropped.
+func appendWKB(w *geomWriter, b []byte) error {
+	r := &wkbReader{b: b}
+	return r.geometry(w)
+}
+
+func (r *wkbReader) geometry(w *geomWriter) error {
+	if len(r.b) < 1 {
+		return errInvalidGeometry
+	}
+	switch r.b[0] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return errInvalidGeometry
+	}
+	r.b = r.b[1:]
+	typ, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	hasZ := typ&0x80000000 != 0
+	hasM := typ&0x40000000 != 0
+	typ &= 0x0FFFFFFF
+	switch typ / 1000 {
+	case 1:
+		hasZ = true
+	case 2:
+		hasM = true
+	case 3:
+		hasZ, hasM = true, true
+	}
+	typ %= 1000
+	point := func() error {
+		x, err := r.float64()
+		if err != nil {
+			return err
+		}
+		y, err := r.float64()
+		if err != nil {
+			return err
+		}
+		var z []float64
+		if hasZ {
+			zv, err := r.float64()
+			if err != nil {
+				return err
+			}
+			z = []float64{zv}
+		}
+		if hasM {
+			if _, err := r.float64(); err != nil {
+				return err
+			}
+		}
+		w.coord(x, y, z)
+		return nil
+	}
+	list := func(item func() error) error {
+		n, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		if int(n) > len(r.b) {
+			return errInvalidGeometry
+		}
+		w.dst = append(w.dst, '[')
+		for i := 0; i < int(n); i++ {
+			if i > 0 {
+				w.dst = append(w.dst, ',')
+			}
+			if err := item(); err != nil {
+				return err
+			}
+		}
+		w.dst = append(w.dst, ']')
+		return nil
+	}
+	points := func() error { return list(point) }
+	rings := func() error { return list(points) }
+	// the members of multi geometries are complete geometries, which
+	// include their own byte order and type.
+	member := func(coords func() error) func() error {
+		return func() error {
+			if len(r.b) < 5 {
+				return errInvalidGeometry
+			}
+			if r.b[0] == 0 {
+				r.order = binary.BigEndian
+			} else {
+				r.order = binary.LittleEndian
+			}
+			r.b = r.b[5:]
+			return coords()
+		}
+	}
+	switch typ {
+	case 1:
+		w.begin("Point")
+		if err := point(); err != nil {
+			return err
+		}
+	case 2:
+		w.begin("LineString")
+		if err := points(); err != nil {
+			return err
+		}
+	case 3:
+		w.begin("Polygon")
+		if err := rings(); err != nil {
+			return err
+		}
+	case 4:
+		w.begin("MultiPoint")
+		if err := list(member(point)); err != nil {
+			return err
+		}
+	case 5:
+		w.begin("MultiLineString")
+		if err := list(member(points)); err != nil {
+			return err
+		}
+	case 6:
+		w.begin("MultiPolygon")
+		if err := list(member(rings)); err != nil {
+			return err
+		}
+	case 7:
+		w.dst = append(w.dst, `{"type":"GeometryCollection","geometries":`...)
+		if err := list(func() error { return r.geometry(w) }); err != nil {
+			return err
+		}
+	default:
+		return errInvalidGeometry
+	}
+	w.dst = append(w.dst, '}')
+	return nil
+}
+
+func appendJSONString(dst []byte, s string) []byte {
+	b, _ := json.Marshal(s)
+	return append(dst, b...)
+}
+
+// AppendWKB appends the little endian ISO well-known binary of a GeoJSON
+// geometry. A Feature is its geometry, and a FeatureCollection is a
+// GeometryCollection of the geometries of its features. The geometries with
+// three values in their first coordinate are Z geometries.
+func AppendWKB(dst []byte, geojson string) ([]byte, error) {
+	return appendWKBGeometry(dst, gjson.Parse(geojson))
+}
+
+var wkbTypes = map[string]uint32{
+	"Point": 1, "LineString": 2, "Polygon": 3, "MultiPoint": 4,
+	"MultiLineString": 5, "MultiPolygon": 6, "GeometryCollection": 7,
+}
+
+func appendWKBGeometry(dst []byte, g gjson.Result) ([]byte, error) {
+	typ := g.Get("type").String()
+	switch typ {
+	case "Feature":
+		return appendWKBGeometry(dst, g.Get("geometry"))
+	case "FeatureCollection":
+		var geoms []gjson.Result
+		g.Get("features").ForEach(func(_, f gjson.Result) bool {
+			geoms = append(geoms, f.Get("geometry"))
+			return true
+		})
+		return appendWKBCollection(dst, geoms)
+	case "GeometryCollection":
+		return appendWKBCollection(dst, g.Get("geometries").Array())
+	}
+	code, ok := wkbTypes[typ]
+	if !ok {
+		return dst, errInvalidGeometry
+	}
+	coords := g.Get("coordinates")
+	hasZ := wkbHasZ(coords)
+	header := func(dst []byte, code uint32) []byte {
+		if hasZ {
+			code += 1000
+		}
+		dst = append(dst, 1)
+		return appendWKBUint32(dst, code)
+	}
+	point := func(dst []byte, c gjson.Result) []byte {
+		x, y, z := math.NaN(), math.NaN(), 0.0
+		if arr := c.Array(); len(arr) >= 2 {
+			x, y = arr[0].Float(), arr[1].Float()
+			if len(arr) >= 3 {
+				z = arr[2].Float()
+			}
+		}
+		dst = appendWKBFloat(dst, x)
+		dst = appendWKBFloat(dst, y)
+		if hasZ {
+			dst = appendWKBFloat(dst, z)
+		}
+		return dst
+	}
+	list := func(dst []byte, c gjson.Result,
+		item func(dst []byte, c gjson.Result) []byte,
+	) []byte {
+		arr := c.Array()
+		dst = appendWKBUint32(dst, uint32(len(arr)))
+		for _, c := range arr {
+			dst = item(dst, c)
+		}
+		return dst
+	}
+	points := func(dst []byte, c gjson.Result) []byte {
+		return list(dst, c, point)
+	}
+	rings := func(dst []byte, c gjson.Result) []byte {
+		return list(dst, c, points)
+	}
+	// the members of multi geometries are complete geometries, which
+	// include their own byte order and type.
+	member := func(code uint32,
+		item func(dst []byte, c gjson.Result) []byte,
+	) func(dst []byte, c gjson.Result) []byte {
+		return func(dst []byte, c gjson.Result) []byte {
+			return item(header(dst, code), c)
+		}
+	}
+	dst = header(dst, code)
+	switch code {
+	case 1:
+		dst = point(dst, coords)
+	case 2:
+		dst = points(dst, coords)
+	case 3:
+		dst = rings(dst, coords)
+	case 4:
+		dst = list(dst, coords, member(1, point))
+	case 5:
+		dst = list(dst, coords, member(2, points))
+	case 6:
+		dst = list(dst, coords, member(3, rings))
+	}
+	return dst, nil
+}
+
+func appendWKBCollection(dst []byte, geoms []gjson.Result) ([]byte, error) {
+	dst = append(dst, 1)
+	dst = appendWKBUint32(dst, 7)
+	dst = appendWKBUint32(dst, uint32(len(geoms)))
+	for _, g := range geoms {
+		var err error
+		if dst, err = appendWKBGeometry(dst, g); err != nil {
+			return dst, err
+		}
+	}
+	return dst, nil
+}
+
+// wkbHasZ returns true when the first coordinate has a Z value.
+func wkbHasZ(coords gjson.Result) bool {
+	for coords.IsArray() {
+		arr := coords.Array()
+		if len(arr) == 0 {
+			return false
+		}
+		if !arr[0].IsArray() {
+			return len(arr) >= 3
+		}
+		coords = arr[0]
+	}
+	return false
+}
+
+func appendWKBFloat(dst []byte, f float64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	return append(dst, b[:]...)
+}
+
+func appendWKBUint32(dst []byte, v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(dst, b[:]...)
+}
@@ -0,0 +1,146 @@
+package gisfile
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestReadShapefile(t *testing.T) {
+	var features []string
+	err := ReadShapefile("testdata/polygons.shp", func(feature []byte) error {
+		if !gjson.ValidBytes(feature) {
+			t.Fatalf("invalid json: %s", feature)
+		}
+		features = append(features, string(feature))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the null shape is skipped
+	if len(features) != 2 {
+		t.Fatalf("expected 2 features, got %d", len(features))
+	}
+	expect := []string{
+		`{"type":"Feature","id":1,"geometry":{"type":"MultiPolygon","coordinates":` +
+			`[[[[0,0],[0,10],[10,10],[10,0],[0,0]],[[2,2],[4,2],[4,4],[2,4],[2,2]]],` +
+			`[[[20,0],[20,5],[25,5],[25,0],[20,0]]]]},` +
+			`"properties":{"NAME":"lot one","AREA":96,"ACTIVE":true}}`,
+		`{"type":"Feature","id":3,"geometry":{"type":"Polygon","coordinates":` +
+			`[[[20,0],[20,5],[25,5],[25,0],[20,0]]]},` +
+			`"properties":{"NAME":"lot two","AREA":25.5,"ACTIVE":null}}`,
+	}
+	for i := range expect {
+		if features[i] != expect[i] {
+			t.Fatalf("expected\n%s\ngot\n%s", expect[i], features[i])
+		}
+	}
+}
+
+func TestReadGeoPackage(t *testing.T) {
+	var features []gjson.Result
+	err := ReadGeoPackage("testdata/test.gpkg", "", func(feature []byte) error {
+		if !gjson.ValidBytes(feature) {
+			t.Fatalf("invalid json: %s", feature)
+		}
+		features = append(features, gjson.ParseBytes(feature))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the row with a null geometry is skipped
+	if len(features) != 60 {
+		t.Fatalf("expected 60 features, got %d", len(features))
+	}
+	for i, f := range features {
+		id := i + 1
+		if f.Get("id").Int() != int64(id) ||
+			f.Get("properties.apn").String() != "apn-"+f.Get("id").String() ||
+			f.Get("properties.area").Float() != float64(id)*1.5 {
+			t.Fatalf("unexpected feature %d: %s", id, f.Raw)
+		}
+		if f.Get("properties.fid").Exists() || f.Get("properties.geom").Exists() {
+			t.Fatalf("unexpected properties: %s", f.Raw)
+		}
+		// the geometries were projected from UTM zone 11N
+		lon := f.Get("geometry.coordinates.0.0.0").Float()
+		lat := f.Get("geometry.coordinates.0.0.1").Float()
+		if math.Abs(lon-(-117+float64(id)*0.01)) > 1e-6 || math.Abs(lat-33) > 1e-6 {
+			t.Fatalf("unexpected coordinates for feature %d: %v %v", id, lon, lat)
+		}
+	}
+	// the notes of feature 7 are stored on overflow pages
+	if len(features[6].Get("properties.notes").String()) != 3000 {
+		t.Fatal("expected 3000 characters")
+	}
+
+	var well string
+	err = ReadGeoPackage("testdata/test.gpkg", "WELLS", func(feature []byte) error {
+		well = string(feature)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"type":"Feature","id":1,"geometry":{"type":"Point","coordinates":` +
+		`[-115.5,33.25,-120.5]},"properties":{"name":"well \"one\"","depth":120}}`
+	if well != expect {
+		t.Fatalf("expected\n%s\ngot\n%s", expect, well)
+	}
+	err = ReadGeoPackage("testdata/test.gpkg", "missing", func([]byte) error {
+		return nil
+	})
+	if err == nil || err.Error() != "layer 'missing' not found" {
+		t.Fatalf("expected an error, got %v", err)
+	}
+	err = ReadGeoPackage("testdata/polygons.shp", "", func([]byte) error {
+		return nil
+	})
+	if err != errInvalidDatabase {
+		t.Fatalf("expected %v, got %v", errInvalidDatabase, err)
+	}
+}
+
+func TestAppendWKB(t *testing.T) {
+	for _, geom := range []string{
+		`{"type":"Point","coordinates":[-112.5,33.25]}`,
+		`{"type":"Point","coordinates":[-112.5,33.25,100]}`,
+		`{"type":"LineString","coordinates":[[0,0],[1,1],[2,0]]}`,
+		`{"type":"Polygon","coordinates":[[[0,0],[0,10],[10,10],[0,0]],` +
+			`[[1,1],[2,1],[2,2],[1,1]]]}`,
+		`{"type":"MultiPoint","coordinates":[[1,2,3],[4,5,6]]}`,
+		`{"type":"MultiLineString","coordinates":[[[0,0],[1,1]],[[2,2],[3,3]]]}`,
+		`{"type":"MultiPolygon","coordinates":[[[[0,0],[0,1],[1,1],[0,0]]]]}`,
+		`{"type":"GeometryCollection","geometries":[` +
+			`{"type":"Point","coordinates":[1,2]},` +
+			`{"type":"LineString","coordinates":[[0,0],[1,1]]}]}`,
+	} {
+		b, err := AppendWKB(nil, geom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := &geomWriter{proj: identity}
+		if err := appendWKB(w, b); err != nil {
+			t.Fatal(err)
+		}
+		if string(w.dst) != geom {
+			t.Fatalf("expected\n%s\ngot\n%s", geom, w.dst)
+		}
+	}
+	// a feature is its geometry
+	b, err := AppendWKB(nil, `{"type":"Feature","geometry":`+
+		`{"type":"Point","coordinates":[1,2]},"properties":{}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, _ := AppendWKB(nil, `{"type":"Point","coordinates":[1,2]}`)
+	if string(b) != string(expect) {
+		t.Fatalf("expected %x, got %x", expect, b)
+	}
+	if _, err := AppendWKB(nil, `{"type":"Circle"}`); err == nil {
+		t.Fatal("expected an error")
+	}
+}
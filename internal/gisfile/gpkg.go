@@ -0,0 +1,181 @@
+package gisfile
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ReadGeoPackage reads the features in a GeoPackage layer, calling iter with
+// a GeoJSON Feature for each one. The first feature layer is used when layer
+// is empty. The feature id is the row's primary key, and the other columns
+// become the feature properties. The coordinates are converted to WGS84
+// using the layer's spatial reference system. Rows with a null geometry are
+// skipped.
+func ReadGeoPackage(path, layer string, iter func(feature []byte) error) error {
+	db, err := openSQLite(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tables, err := db.tables()
+	if err != nil {
+		return err
+	}
+	geomCols := tables["gpkg_geometry_columns"]
+	srsTable := tables["gpkg_spatial_ref_sys"]
+	if geomCols == nil || srsTable == nil {
+		return errors.New("not a geopackage")
+	}
+
+	// find the geometry column and srs of the layer
+	var geomCol string
+	var srsID int64
+	var found bool
+	err = db.rows(geomCols, func(values []interface{}) error {
+		row := columnMap(geomCols, values)
+		name, _ := row["table_name"].(string)
+		if found || (layer != "" && !strings.EqualFold(name, layer)) {
+			return nil
+		}
+		layer = name
+		geomCol, _ = row["column_name"].(string)
+		srsID, _ = row["srs_id"].(int64)
+		found = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	table := tables[strings.ToLower(layer)]
+	if !found || table == nil {
+		if layer == "" {
+			return errors.New("no feature layers")
+		}
+		return fmt.Errorf("layer '%s' not found", layer)
+	}
+	proj := Projection(identity)
+	err = db.rows(srsTable, func(values []interface{}) error {
+		row := columnMap(srsTable, values)
+		if id, _ := row["srs_id"].(int64); id != srsID {
+			return nil
+		}
+		org, _ := row["organization"].(string)
+		code, _ := row["organization_coordsys_id"].(int64)
+		def, _ := row["definition"].(string)
+		if (strings.EqualFold(org, "epsg") && code == 4326) ||
+			strings.EqualFold(def, "undefined") {
+			return nil
+		}
+		var err error
+		if proj, err = ParseProjection(def); err != nil {
+			return fmt.Errorf("srs %d: %v", srsID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w := &geomWriter{proj: proj}
+	return db.rows(table, func(values []interface{}) error {
+		var id []byte
+		var props []byte
+		w.dst = w.dst[:0]
+		for i, col := range table.columns {
+			switch {
+			case strings.EqualFold(col, geomCol):
+				blob, _ := values[i].([]byte)
+				if blob == nil {
+					continue
+				}
+				if err := appendGPKGGeometry(w, blob); err != nil {
+					return fmt.Errorf("%s: %v", layer, err)
+				}
+			case i == table.rowidCol:
+				id = appendValue(id, values[i])
+			default:
+				if _, ok := values[i].([]byte); ok {
+					// blobs are not supported as properties
+					continue
+				}
+				if len(props) > 0 {
+					props = append(props, ',')
+				}
+				props = appendJSONString(props, col)
+				props = append(props, ':')
+				props = appendValue(props, values[i])
+			}
+		}
+		if len(w.dst) == 0 {
+			return nil
+		}
+		var feature []byte
+		feature = append(feature, `{"type":"Feature",`...)
+		if id != nil {
+			feature = append(feature, `"id":`...)
+			feature = append(feature, id...)
+			feature = append(feature, ',')
+		}
+		feature = append(feature, `"geometry":`...)
+		feature = append(feature, w.dst...)
+		feature = append(feature, `,"properties":{`...)
+		feature = append(feature, props...)
+		feature = append(feature, "}}"...)
+		return iter(feature)
+	})
+}
+
+func columnMap(table *sqliteTable, values []interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(values))
+	for i, col := range table.columns {
+		m[strings.ToLower(col)] = values[i]
+	}
+	return m
+}
+
+func appendValue(dst []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case int64:
+		return strconv.AppendInt(dst, v, 10)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return append(dst, "null"...)
+		}
+		return strconv.AppendFloat(dst, v, 'f', -1, 64)
+	case string:
+		return appendJSONString(dst, v)
+	}
+	return append(dst, "null"...)
+}
+
+// appendGPKGGeometry appends a GeoPackage geometry blob, which is a header
+// followed by a WKB geometry. Nothing is appended for empty geometries.
+func appendGPKGGeometry(w *geomWriter, b []byte) error {
+	if len(b) < 8 || b[0] != 'G' || b[1] != 'P' {
+		return errInvalidGeometry
+	}
+	flags := b[3]
+	if flags&0x10 != 0 {
+		// empty geometry
+		return nil
+	}
+	var envelope int
+	switch (flags >> 1) & 0x07 {
+	case 0:
+	case 1:
+		envelope = 32
+	case 2, 3:
+		envelope = 48
+	case 4:
+		envelope = 64
+	default:
+		return errInvalidGeometry
+	}
+	if len(b) < 8+envelope {
+		return errInvalidGeometry
+	}
+	return appendWKB(w, b[8+envelope:])
+}
@@ -0,0 +1,422 @@
+package gisfile
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Projection converts projected coordinates to WGS84 longitude and latitude.
+type Projection func(x, y float64) (lon, lat float64)
+
+func identity(x, y float64) (float64, float64) { return x, y }
+
+// wktNode is an element of a well-known text coordinate system, such as
+// PROJCS["name",GEOGCS[...],PARAMETER["central_meridian",-117],...]. The
+// values are strings, numbers, and child nodes.
+type wktNode struct {
+	name   string
+	values []interface{}
+}
+
+func (n *wktNode) child(name string) *wktNode {
+	for _, v := range n.values {
+		if c, ok := v.(*wktNode); ok && strings.EqualFold(c.name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *wktNode) str(i int) string {
+	if i < len(n.values) {
+		s, _ := n.values[i].(string)
+		return s
+	}
+	return ""
+}
+
+func (n *wktNode) num(i int) float64 {
+	if i < len(n.values) {
+		f, _ := n.values[i].(float64)
+		return f
+	}
+	return 0
+}
+
+func parseWKT(s string) (*wktNode, error) {
+	p := &wktParser{s: s}
+	n, err := p.node()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.i != len(p.s) {
+		return nil, fmt.Errorf("invalid wkt")
+	}
+	return n, nil
+}
+
+type wktParser struct {
+	s string
+	i int
+}
+
+func (p *wktParser) skipSpace() {
+	for p.i < len(p.s) && unicode.IsSpace(rune(p.s[p.i])) {
+		p.i++
+	}
+}
+
+func (p *wktParser) ident() string {
+	p.skipSpace()
+	start := p.i
+	for p.i < len(p.s) && (p.s[p.i] == '_' || unicode.IsLetter(rune(p.s[p.i])) ||
+		unicode.IsDigit(rune(p.s[p.i]))) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+func (p *wktParser) node() (*wktNode, error) {
+	name := p.ident()
+	p.skipSpace()
+	if name == "" || p.i == len(p.s) || (p.s[p.i] != '[' && p.s[p.i] != '(') {
+		return nil, fmt.Errorf("invalid wkt")
+	}
+	return p.body(name)
+}
+
+func (p *wktParser) body(name string) (*wktNode, error) {
+	n := &wktNode{name: name}
+	close := byte(']')
+	if p.s[p.i] == '(' {
+		close = ')'
+	}
+	p.i++
+	for {
+		p.skipSpace()
+		if p.i == len(p.s) {
+			return nil, fmt.Errorf("invalid wkt")
+		}
+		switch c := p.s[p.i]; {
+		case c == '"':
+			end := strings.IndexByte(p.s[p.i+1:], '"')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid wkt")
+			}
+			n.values = append(n.values, p.s[p.i+1:p.i+1+end])
+			p.i += end + 2
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			start := p.i
+			for p.i < len(p.s) && strings.IndexByte("+-.eE0123456789", p.s[p.i]) != -1 {
+				p.i++
+			}
+			f, err := strconv.ParseFloat(p.s[start:p.i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid wkt")
+			}
+			n.values = append(n.values, f)
+		default:
+			name := p.ident()
+			if name == "" {
+				return nil, fmt.Errorf("invalid wkt")
+			}
+			p.skipSpace()
+			if p.i < len(p.s) && (p.s[p.i] == '[' || p.s[p.i] == '(') {
+				child, err := p.body(name)
+				if err != nil {
+					return nil, err
+				}
+				n.values = append(n.values, child)
+			} else {
+				// bare keywords, such as the axis directions NORTH and EAST
+				n.values = append(n.values, name)
+			}
+		}
+		p.skipSpace()
+		if p.i < len(p.s) && p.s[p.i] == ',' {
+			p.i++
+			continue
+		}
+		if p.i < len(p.s) && p.s[p.i] == close {
+			p.i++
+			return n, nil
+		}
+		return nil, fmt.Errorf("invalid wkt")
+	}
+}
+
+// ParseProjection returns the projection for a well-known text coordinate
+// system, as found in Shapefile .prj files and GeoPackage spatial reference
+// systems. Geographic coordinate systems are assumed to be close enough to
+// WGS84 that no datum shift is needed. The supported projections are
+// Transverse Mercator (including UTM), Mercator (including Web Mercator),
+// Lambert Conformal Conic, and Albers Equal Area.
+func ParseProjection(wkt string) (Projection, error) {
+	wkt = strings.TrimSpace(wkt)
+	if wkt == "" {
+		return identity, nil
+	}
+	root, err := parseWKT(wkt)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToUpper(root.name) {
+	case "GEOGCS", "GEOGCRS":
+		return identity, nil
+	case "PROJCS":
+	default:
+		return nil, fmt.Errorf("unsupported coordinate system '%s'", root.name)
+	}
+	proj := root.child("PROJECTION")
+	if proj == nil {
+		return nil, fmt.Errorf("missing projection")
+	}
+	a, invf := 6378137.0, 298.257223563
+	if geog := root.child("GEOGCS"); geog != nil {
+		if datum := geog.child("DATUM"); datum != nil {
+			if sph := datum.child("SPHEROID"); sph != nil {
+				a, invf = sph.num(1), sph.num(2)
+			}
+		}
+	}
+	var f float64
+	if invf != 0 {
+		f = 1 / invf
+	}
+	e2 := 2*f - f*f
+	params := make(map[string]float64)
+	for _, v := range root.values {
+		if c, ok := v.(*wktNode); ok && strings.EqualFold(c.name, "PARAMETER") {
+			params[strings.ToLower(c.str(0))] = c.num(1)
+		}
+	}
+	param := func(def float64, names ...string) float64 {
+		for _, name := range names {
+			if v, ok := params[name]; ok {
+				return v
+			}
+		}
+		return def
+	}
+	unit := 1.0
+	if u := root.child("UNIT"); u != nil && u.num(1) != 0 {
+		unit = u.num(1)
+	}
+	fe := param(0, "false_easting")
+	fn := param(0, "false_northing")
+	lon0 := param(0, "central_meridian", "longitude_of_center",
+		"longitude_of_origin") * math.Pi / 180
+	lat0 := param(0, "latitude_of_origin", "latitude_of_center") * math.Pi / 180
+	k0 := param(1, "scale_factor")
+	sp1 := param(0, "standard_parallel_1") * math.Pi / 180
+	sp2 := param(0, "standard_parallel_2") * math.Pi / 180
+
+	var inv func(x, y float64) (lon, lat float64)
+	name := strings.ToLower(proj.str(0))
+	switch {
+	case name == "transverse_mercator" || name == "gauss_kruger":
+		inv = tmInverse(a, e2, k0, lon0, lat0)
+	case strings.Contains(name, "mercator"):
+		if isWebMercator(root, name) {
+			inv = func(x, y float64) (float64, float64) {
+				return lon0 + x/a, math.Pi/2 - 2*math.Atan(math.Exp(-y/a))
+			}
+		} else {
+			if _, ok := params["standard_parallel_1"]; ok {
+				k0 = math.Cos(sp1) / math.Sqrt(1-e2*math.Sin(sp1)*math.Sin(sp1))
+			}
+			inv = mercInverse(a, e2, k0, lon0)
+		}
+	case strings.HasPrefix(name, "lambert_conformal_conic"):
+		if _, ok := params["standard_parallel_1"]; !ok {
+			sp1 = lat0
+		}
+		if _, ok := params["standard_parallel_2"]; !ok {
+			sp2 = sp1
+		}
+		inv = lccInverse(a, e2, k0, lon0, lat0, sp1, sp2)
+	case name == "albers" || name == "albers_conic_equal_area":
+		inv = albersInverse(a, e2, lon0, lat0, sp1, sp2)
+	default:
+		return nil, fmt.Errorf("unsupported projection '%s'", proj.str(0))
+	}
+	return func(x, y float64) (float64, float64) {
+		lon, lat := inv((x-fe)*unit, (y-fn)*unit)
+		return normalizeLon(lon * 180 / math.Pi), lat * 180 / math.Pi
+	}, nil
+}
+
+// isWebMercator returns true for the spherical Mercator used by web maps,
+// which is defined as Mercator on the WGS84 ellipsoid by some sources.
+func isWebMercator(root *wktNode, name string) bool {
+	if strings.Contains(name, "auxiliary_sphere") ||
+		strings.Contains(name, "pseudo") ||
+		strings.Contains(strings.ToLower(root.str(0)), "pseudo") {
+		return true
+	}
+	if auth := root.child("AUTHORITY"); auth != nil {
+		switch auth.str(1) {
+		case "3857", "3785", "900913", "102100", "102113":
+			return true
+		}
+	}
+	if ext := root.child("EXTENSION"); ext != nil {
+		return strings.Contains(ext.str(1), "+a=6378137 +b=6378137")
+	}
+	return false
+}
+
+func normalizeLon(lon float64) float64 {
+	for lon > 180 {
+		lon -= 360
+	}
+	for lon < -180 {
+		lon += 360
+	}
+	return lon
+}
+
+// meridianArc returns the distance from the equator to phi along a
+// meridian.
+func meridianArc(a, e2, phi float64) float64 {
+	e4, e6 := e2*e2, e2*e2*e2
+	return a * ((1-e2/4-3*e4/64-5*e6/256)*phi -
+		(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*phi) +
+		(15*e4/256+45*e6/1024)*math.Sin(4*phi) -
+		(35*e6/3072)*math.Sin(6*phi))
+}
+
+func tmInverse(a, e2, k0, lon0, lat0 float64) func(x, y float64) (float64, float64) {
+	e4, e6 := e2*e2, e2*e2*e2
+	ep2 := e2 / (1 - e2)
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+	m0 := meridianArc(a, e2, lat0)
+	return func(x, y float64) (float64, float64) {
+		m := m0 + y/k0
+		mu := m / (a * (1 - e2/4 - 3*e4/64 - 5*e6/256))
+		phi1 := mu + (3*e1/2-27*e1*e1*e1/32)*math.Sin(2*mu) +
+			(21*e1*e1/16-55*e1*e1*e1*e1/32)*math.Sin(4*mu) +
+			(151*e1*e1*e1/96)*math.Sin(6*mu) +
+			(1097*e1*e1*e1*e1/512)*math.Sin(8*mu)
+		sin1, cos1, tan1 := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+		c1 := ep2 * cos1 * cos1
+		t1 := tan1 * tan1
+		n1 := a / math.Sqrt(1-e2*sin1*sin1)
+		r1 := a * (1 - e2) / math.Pow(1-e2*sin1*sin1, 1.5)
+		d := x / (n1 * k0)
+		lat := phi1 - (n1*tan1/r1)*(d*d/2-
+			(5+3*t1+10*c1-4*c1*c1-9*ep2)*d*d*d*d/24+
+			(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*d*d*d*d*d*d/720)
+		lon := lon0 + (d-(1+2*t1+c1)*d*d*d/6+
+			(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*d*d*d*d*d/120)/cos1
+		return lon, lat
+	}
+}
+
+// latFromT returns the latitude for the isometric value t that's used by the
+// conformal projections.
+func latFromT(e, t float64) float64 {
+	phi := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15; i++ {
+		es := e * math.Sin(phi)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-es)/(1+es), e/2))
+		if math.Abs(next-phi) < 1e-12 {
+			return next
+		}
+		phi = next
+	}
+	return phi
+}
+
+func isoT(e, phi float64) float64 {
+	es := e * math.Sin(phi)
+	return math.Tan(math.Pi/4-phi/2) / math.Pow((1-es)/(1+es), e/2)
+}
+
+func isoM(e2, phi float64) float64 {
+	s := math.Sin(phi)
+	return math.Cos(phi) / math.Sqrt(1-e2*s*s)
+}
+
+func mercInverse(a, e2, k0, lon0 float64) func(x, y float64) (float64, float64) {
+	e := math.Sqrt(e2)
+	return func(x, y float64) (float64, float64) {
+		return lon0 + x/(a*k0), latFromT(e, math.Exp(-y/(a*k0)))
+	}
+}
+
+func lccInverse(a, e2, k0, lon0, lat0, sp1, sp2 float64) func(x, y float64) (float64, float64) {
+	e := math.Sqrt(e2)
+	m1, t1 := isoM(e2, sp1), isoT(e, sp1)
+	var n float64
+	if sp1 == sp2 {
+		n = math.Sin(sp1)
+	} else {
+		n = (math.Log(m1) - math.Log(isoM(e2, sp2))) /
+			(math.Log(t1) - math.Log(isoT(e, sp2)))
+	}
+	f := m1 / (n * math.Pow(t1, n))
+	rho0 := a * f * k0 * math.Pow(isoT(e, lat0), n)
+	return func(x, y float64) (float64, float64) {
+		sign := 1.0
+		if n < 0 {
+			sign = -1
+		}
+		rho := sign * math.Sqrt(x*x+(rho0-y)*(rho0-y))
+		theta := math.Atan2(sign*x, sign*(rho0-y))
+		t := math.Pow(rho/(a*f*k0), 1/n)
+		return lon0 + theta/n, latFromT(e, t)
+	}
+}
+
+func albersInverse(a, e2, lon0, lat0, sp1, sp2 float64) func(x, y float64) (float64, float64) {
+	e := math.Sqrt(e2)
+	q := func(phi float64) float64 {
+		s := math.Sin(phi)
+		if e == 0 {
+			return 2 * s
+		}
+		return (1 - e2) * (s/(1-e2*s*s) - 1/(2*e)*math.Log((1-e*s)/(1+e*s)))
+	}
+	m1, m2 := isoM(e2, sp1), isoM(e2, sp2)
+	q1, q2 := q(sp1), q(sp2)
+	var n float64
+	if sp1 == sp2 {
+		n = math.Sin(sp1)
+	} else {
+		n = (m1*m1 - m2*m2) / (q2 - q1)
+	}
+	c := m1*m1 + n*q1
+	rho0 := a * math.Sqrt(c-n*q(lat0)) / n
+	return func(x, y float64) (float64, float64) {
+		sign := 1.0
+		if n < 0 {
+			sign = -1
+		}
+		rho := math.Sqrt(x*x + (rho0-y)*(rho0-y))
+		theta := math.Atan2(sign*x, sign*(rho0-y))
+		qv := (c - rho*rho*n*n/(a*a)) / n
+		phi := math.Asin(qv / 2)
+		for i := 0; i < 15; i++ {
+			s := math.Sin(phi)
+			cs := math.Cos(phi)
+			var next float64
+			if e == 0 {
+				next = math.Asin(qv / 2)
+			} else {
+				next = phi + (1-e2*s*s)*(1-e2*s*s)/(2*cs)*
+					(qv/(1-e2)-s/(1-e2*s*s)+1/(2*e)*math.Log((1-e*s)/(1+e*s)))
+			}
+			if math.Abs(next-phi) < 1e-12 {
+				phi = next
+				break
+			}
+			phi = next
+		}
+		return lon0 + theta/n, phi
+	}
+}
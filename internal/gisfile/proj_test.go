@@ -0,0 +1,86 @@
+package gisfile
+
+import (
+	"math"
+	"testing"
+)
+
+const clarke1866 = `GEOGCS["GCS_North_American_1927",` +
+	`DATUM["D_North_American_1927",SPHEROID["Clarke_1866",6378206.4,294.9786982]],` +
+	`PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+func TestParseProjection(t *testing.T) {
+	tests := []struct {
+		wkt      string
+		x, y     float64
+		lon, lat float64
+	}{
+		{
+			// UTM zone 18N, the Empire State Building
+			`PROJCS["WGS 84 / UTM zone 18N",GEOGCS["WGS 84",DATUM["WGS_1984",` +
+				`SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]]],` +
+				`PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],` +
+				`PROJECTION["Transverse_Mercator"],PARAMETER["latitude_of_origin",0],` +
+				`PARAMETER["central_meridian",-75],PARAMETER["scale_factor",0.9996],` +
+				`PARAMETER["false_easting",500000],PARAMETER["false_northing",0],` +
+				`UNIT["metre",1],AXIS["Easting",EAST],AXIS["Northing",NORTH],` +
+				`AUTHORITY["EPSG","32618"]]`,
+			585632.08, 4511326.15, -73.985656, 40.748433,
+		},
+		{
+			`PROJCS["WGS_1984_Web_Mercator_Auxiliary_Sphere",GEOGCS["GCS_WGS_1984",` +
+				`DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],` +
+				`PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],` +
+				`PROJECTION["Mercator_Auxiliary_Sphere"],PARAMETER["False_Easting",0.0],` +
+				`PARAMETER["False_Northing",0.0],PARAMETER["Central_Meridian",0.0],` +
+				`PARAMETER["Standard_Parallel_1",0.0],PARAMETER["Auxiliary_Sphere_Type",0.0],` +
+				`UNIT["Meter",1.0]]`,
+			-8236045.5519263055, 4975306.102820313, -73.985656, 40.748433,
+		},
+		{
+			// the Lambert Conformal Conic example from Snyder's Map
+			// Projections: A Working Manual
+			`PROJCS["lcc",` + clarke1866 + `,PROJECTION["Lambert_Conformal_Conic"],` +
+				`PARAMETER["Standard_Parallel_1",33],PARAMETER["Standard_Parallel_2",45],` +
+				`PARAMETER["Latitude_Of_Origin",23],PARAMETER["Central_Meridian",-96],` +
+				`UNIT["Meter",1.0]]`,
+			1894410.9, 1564649.5, -75, 35,
+		},
+		{
+			// the Albers Equal Area example from Snyder
+			`PROJCS["albers",` + clarke1866 + `,PROJECTION["Albers"],` +
+				`PARAMETER["Standard_Parallel_1",29.5],PARAMETER["Standard_Parallel_2",45.5],` +
+				`PARAMETER["Latitude_Of_Origin",23],PARAMETER["Central_Meridian",-96],` +
+				`UNIT["Meter",1.0]]`,
+			1885472.7, 1535925.0, -75, 35,
+		},
+		{
+			// the same point in US survey feet, with a false easting
+			`PROJCS["lcc",` + clarke1866 + `,PROJECTION["Lambert_Conformal_Conic"],` +
+				`PARAMETER["False_Easting",2000000],` +
+				`PARAMETER["Standard_Parallel_1",33],PARAMETER["Standard_Parallel_2",45],` +
+				`PARAMETER["Latitude_Of_Origin",23],PARAMETER["Central_Meridian",-96],` +
+				`UNIT["Foot_US",0.3048006096012192]]`,
+			2000000 + 1894410.9/0.3048006096012192, 1564649.5 / 0.3048006096012192,
+			-75, 35,
+		},
+		{clarke1866, -75, 35, -75, 35},
+	}
+	for i, tt := range tests {
+		proj, err := ParseProjection(tt.wkt)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		lon, lat := proj(tt.x, tt.y)
+		if math.Abs(lon-tt.lon) > 1e-5 || math.Abs(lat-tt.lat) > 1e-5 {
+			t.Fatalf("%d: expected %v %v, got %v %v", i, tt.lon, tt.lat, lon, lat)
+		}
+	}
+	if _, err := ParseProjection(`PROJCS["x",` + clarke1866 +
+		`,PROJECTION["Polyconic"]]`); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := ParseProjection(`PROJCS["x"`); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,397 @@
+package gisfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var errInvalidShapefile = errors.New("invalid shapefile")
+
+// ReadShapefile reads the shapes in an ESRI Shapefile, calling iter with a
+// GeoJSON Feature for each one. The attributes in the .dbf file, when
+// present, become the feature properties, and the feature id is the record
+// number. The coordinates are converted to WGS84 using the .prj file, when
+// present. Null shapes are skipped.
+func ReadShapefile(path string, iter func(feature []byte) error) error {
+	base := strings.TrimSuffix(path, ".shp")
+	proj := Projection(identity)
+	if data, err := ioutil.ReadFile(base + ".prj"); err == nil {
+		proj, err = ParseProjection(string(data))
+		if err != nil {
+			return fmt.Errorf("%s.prj: %v", base, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	shp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer shp.Close()
+	var dbf *dbfReader
+	if f, err := os.Open(base + ".dbf"); err == nil {
+		defer f.Close()
+		if dbf, err = newDBFReader(bufio.NewReader(f)); err != nil {
+			return fmt.Errorf("%s.dbf: %v", base, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	rd := bufio.NewReader(shp)
+	var hdr [100]byte
+	if _, err := io.ReadFull(rd, hdr[:]); err != nil {
+		return errInvalidShapefile
+	}
+	if binary.BigEndian.Uint32(hdr[0:]) != 9994 {
+		return errInvalidShapefile
+	}
+	w := &geomWriter{proj: proj}
+	var content []byte
+	for {
+		var rhdr [8]byte
+		if _, err := io.ReadFull(rd, rhdr[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errInvalidShapefile
+		}
+		num := binary.BigEndian.Uint32(rhdr[0:])
+		size := int(binary.BigEndian.Uint32(rhdr[4:])) * 2
+		if cap(content) < size {
+			content = make([]byte, size)
+		}
+		content = content[:size]
+		if _, err := io.ReadFull(rd, content); err != nil {
+			return errInvalidShapefile
+		}
+		var props []byte
+		if dbf != nil {
+			if props, err = dbf.next(); err != nil {
+				return fmt.Errorf("%s.dbf: %v", base, err)
+			}
+		}
+		w.dst = w.dst[:0]
+		if err := appendShape(w, content); err != nil {
+			return fmt.Errorf("shape %d: %v", num, err)
+		}
+		if len(w.dst) == 0 {
+			// null shape
+			continue
+		}
+		var feature []byte
+		feature = append(feature, `{"type":"Feature","id":`...)
+		feature = strconv.AppendUint(feature, uint64(num), 10)
+		feature = append(feature, `,"geometry":`...)
+		feature = append(feature, w.dst...)
+		feature = append(feature, `,"properties":`...)
+		if props == nil {
+			feature = append(feature, "{}"...)
+		} else {
+			feature = append(feature, props...)
+		}
+		feature = append(feature, '}')
+		if err := iter(feature); err != nil {
+			return err
+		}
+	}
+}
+
+// appendShape appends the shape record in b as a GeoJSON geometry. Nothing
+// is appended for null shapes.
+func appendShape(w *geomWriter, b []byte) error {
+	if len(b) < 4 {
+		return errInvalidGeometry
+	}
+	typ := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	f64 := func(i int) float64 {
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[i*8:]))
+	}
+	hasZ := typ == 11 || typ == 13 || typ == 15 || typ == 18
+	switch typ {
+	case 0:
+		return nil
+	case 1, 11, 21:
+		if len(b) < 16 || (hasZ && len(b) < 24) {
+			return errInvalidGeometry
+		}
+		w.begin("Point")
+		if hasZ {
+			w.coord(f64(0), f64(1), []float64{f64(2)})
+		} else {
+			w.coord(f64(0), f64(1), nil)
+		}
+		w.dst = append(w.dst, '}')
+		return nil
+	case 8, 18, 28:
+		if len(b) < 36 {
+			return errInvalidGeometry
+		}
+		n := int(binary.LittleEndian.Uint32(b[32:]))
+		pts := b[36:]
+		if len(pts) < n*16 || (hasZ && len(pts) < n*16+16+n*8) {
+			return errInvalidGeometry
+		}
+		w.begin("MultiPoint")
+		w.dst = append(w.dst, '[')
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				w.dst = append(w.dst, ',')
+			}
+			x := math.Float64frombits(binary.LittleEndian.Uint64(pts[i*16:]))
+			y := math.Float64frombits(binary.LittleEndian.Uint64(pts[i*16+8:]))
+			var z []float64
+			if hasZ {
+				z = []float64{math.Float64frombits(binary.LittleEndian.Uint64(
+					pts[n*16+16+i*8:]))}
+			}
+			w.coord(x, y, z)
+		}
+		w.dst = append(w.dst, "]}"...)
+		return nil
+	case 3, 13, 23, 5, 15, 25:
+	default:
+		return fmt.Errorf("unsupported shape type %d", typ)
+	}
+	if len(b) < 40 {
+		return errInvalidGeometry
+	}
+	nparts := int(binary.LittleEndian.Uint32(b[32:]))
+	npoints := int(binary.LittleEndian.Uint32(b[36:]))
+	b = b[40:]
+	if nparts == 0 || len(b) < nparts*4+npoints*16 ||
+		(hasZ && len(b) < nparts*4+npoints*16+16+npoints*8) {
+		return errInvalidGeometry
+	}
+	parts := make([][2]int, nparts)
+	for i := range parts {
+		parts[i][0] = int(binary.LittleEndian.Uint32(b[i*4:]))
+		if i > 0 {
+			parts[i-1][1] = parts[i][0]
+		}
+	}
+	parts[nparts-1][1] = npoints
+	pts := b[nparts*4:]
+	xy := func(i int) (float64, float64) {
+		return math.Float64frombits(binary.LittleEndian.Uint64(pts[i*16:])),
+			math.Float64frombits(binary.LittleEndian.Uint64(pts[i*16+8:]))
+	}
+	for _, part := range parts {
+		if part[0] < 0 || part[0] > part[1] || part[1] > npoints {
+			return errInvalidGeometry
+		}
+	}
+	appendPart := func(part [2]int) {
+		w.dst = append(w.dst, '[')
+		for i := part[0]; i < part[1]; i++ {
+			if i > part[0] {
+				w.dst = append(w.dst, ',')
+			}
+			x, y := xy(i)
+			var z []float64
+			if hasZ {
+				z = []float64{math.Float64frombits(binary.LittleEndian.Uint64(
+					pts[npoints*16+16+i*8:]))}
+			}
+			w.coord(x, y, z)
+		}
+		w.dst = append(w.dst, ']')
+	}
+	appendParts := func(parts [][2]int) {
+		w.dst = append(w.dst, '[')
+		for i, part := range parts {
+			if i > 0 {
+				w.dst = append(w.dst, ',')
+			}
+			appendPart(part)
+		}
+		w.dst = append(w.dst, ']')
+	}
+	if typ == 3 || typ == 13 || typ == 23 {
+		if nparts == 1 {
+			w.begin("LineString")
+			appendPart(parts[0])
+		} else {
+			w.begin("MultiLineString")
+			appendParts(parts)
+		}
+		w.dst = append(w.dst, '}')
+		return nil
+	}
+	// Polygon rings are clockwise, and holes are counterclockwise. Each hole
+	// belongs to the first polygon that contains it.
+	var polys [][][2]int
+	var holes [][2]int
+	for _, part := range parts {
+		if ringArea(xy, part) <= 0 {
+			polys = append(polys, [][2]int{part})
+		} else {
+			holes = append(holes, part)
+		}
+	}
+	if len(polys) == 0 {
+		// the rings have the wrong orientation, use them as is
+		for _, part := range holes {
+			polys = append(polys, [][2]int{part})
+		}
+		holes = nil
+	}
+	for _, hole := range holes {
+		owner := len(polys) - 1
+		if hole[1] > hole[0] {
+			x, y := xy(hole[0])
+			for i, poly := range polys {
+				if ringContains(xy, poly[0], x, y) {
+					owner = i
+					break
+				}
+			}
+		}
+		polys[owner] = append(polys[owner], hole)
+	}
+	if len(polys) == 1 {
+		w.begin("Polygon")
+		appendParts(polys[0])
+	} else {
+		w.begin("MultiPolygon")
+		w.dst = append(w.dst, '[')
+		for i, poly := range polys {
+			if i > 0 {
+				w.dst = append(w.dst, ',')
+			}
+			appendParts(poly)
+		}
+		w.dst = append(w.dst, ']')
+	}
+	w.dst = append(w.dst, '}')
+	return nil
+}
+
+// ringArea returns the signed area of a ring, which is negative for
+// clockwise rings.
+func ringArea(xy func(i int) (float64, float64), part [2]int) float64 {
+	var area float64
+	for i := part[0]; i < part[1]-1; i++ {
+		x1, y1 := xy(i)
+		x2, y2 := xy(i + 1)
+		area += x1*y2 - x2*y1
+	}
+	return area / 2
+}
+
+func ringContains(xy func(i int) (float64, float64), part [2]int,
+	x, y float64,
+) bool {
+	var in bool
+	for i, j := part[0], part[1]-1; i < part[1]; j, i = i, i+1 {
+		xi, yi := xy(i)
+		xj, yj := xy(j)
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
+
+// dbfReader reads the records of a dBase file, which holds the attributes
+// of a Shapefile.
+type dbfReader struct {
+	rd     io.Reader
+	fields []dbfField
+	record []byte
+	left   int
+}
+
+type dbfField struct {
+	name string
+	typ  byte
+	size int
+}
+
+func newDBFReader(rd io.Reader) (*dbfReader, error) {
+	var hdr [32]byte
+	if _, err := io.ReadFull(rd, hdr[:]); err != nil {
+		return nil, err
+	}
+	count := int(binary.LittleEndian.Uint32(hdr[4:]))
+	hdrSize := int(binary.LittleEndian.Uint16(hdr[8:]))
+	recSize := int(binary.LittleEndian.Uint16(hdr[10:]))
+	if hdrSize < 33 || recSize < 1 {
+		return nil, errors.New("invalid header")
+	}
+	desc := make([]byte, hdrSize-32)
+	if _, err := io.ReadFull(rd, desc); err != nil {
+		return nil, err
+	}
+	r := &dbfReader{rd: rd, record: make([]byte, recSize), left: count}
+	size := 1
+	for len(desc) >= 32 && desc[0] != 0x0D {
+		name := desc[:11]
+		if i := strings.IndexByte(string(name), 0); i != -1 {
+			name = name[:i]
+		}
+		field := dbfField{
+			name: strings.TrimSpace(string(name)),
+			typ:  desc[11],
+			size: int(desc[16]),
+		}
+		size += field.size
+		r.fields = append(r.fields, field)
+		desc = desc[32:]
+	}
+	if size > recSize {
+		return nil, errors.New("invalid header")
+	}
+	return r, nil
+}
+
+// next returns the attributes of the next record as a JSON object.
+func (r *dbfReader) next() ([]byte, error) {
+	if r.left == 0 {
+		return nil, errors.New("missing record")
+	}
+	r.left--
+	if _, err := io.ReadFull(r.rd, r.record); err != nil {
+		return nil, err
+	}
+	dst := []byte{'{'}
+	data := r.record[1:]
+	for i, field := range r.fields {
+		value := strings.TrimSpace(strings.TrimRight(string(data[:field.size]), "\x00"))
+		data = data[field.size:]
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, field.name)
+		dst = append(dst, ':')
+		switch field.typ {
+		case 'N', 'F':
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				dst = appendFloat(dst, f)
+			} else {
+				dst = append(dst, "null"...)
+			}
+		case 'L':
+			switch value {
+			case "T", "t", "Y", "y":
+				dst = append(dst, "true"...)
+			case "F", "f", "N", "n":
+				dst = append(dst, "false"...)
+			default:
+				dst = append(dst, "null"...)
+			}
+		default:
+			dst = appendJSONString(dst, value)
+		}
+	}
+	return append(dst, '}'), nil
+}
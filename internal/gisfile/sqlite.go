@@ -0,0 +1,386 @@
+package gisfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"strings"
+)
+
+var errInvalidDatabase = errors.New("invalid sqlite database")
+
+// sqliteDB is a minimal read-only reader for SQLite database files, which is
+// enough to scan the tables of a GeoPackage. Indexes, WAL files, and
+// encodings other than UTF-8 are not supported.
+type sqliteDB struct {
+	f        *os.File
+	pageSize int
+	usable   int
+}
+
+// sqliteTable is a table in the sqlite_master schema.
+type sqliteTable struct {
+	name     string
+	rootPage int
+	columns  []string
+	rowidCol int // the INTEGER PRIMARY KEY column, or -1
+}
+
+func openSQLite(path string) (*sqliteDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var hdr [100]byte
+	if _, err := f.ReadAt(hdr[:], 0); err != nil ||
+		string(hdr[:16]) != "SQLite format 3\x00" {
+		f.Close()
+		return nil, errInvalidDatabase
+	}
+	pageSize := int(binary.BigEndian.Uint16(hdr[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || binary.BigEndian.Uint32(hdr[56:]) > 1 {
+		f.Close()
+		return nil, errInvalidDatabase
+	}
+	return &sqliteDB{
+		f:        f,
+		pageSize: pageSize,
+		usable:   pageSize - int(hdr[20]),
+	}, nil
+}
+
+func (db *sqliteDB) Close() error {
+	return db.f.Close()
+}
+
+func (db *sqliteDB) readPage(num int) ([]byte, error) {
+	if num < 1 {
+		return nil, errInvalidDatabase
+	}
+	page := make([]byte, db.pageSize)
+	if _, err := db.f.ReadAt(page, int64(num-1)*int64(db.pageSize)); err != nil {
+		return nil, errInvalidDatabase
+	}
+	return page, nil
+}
+
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7F)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// scan calls iter with the rowid and the payload of each row in the table
+// b-tree that starts at page root.
+func (db *sqliteDB) scan(root int, iter func(rowid int64, payload []byte) error) error {
+	return db.scanPage(root, 0, iter)
+}
+
+func (db *sqliteDB) scanPage(num, depth int,
+	iter func(rowid int64, payload []byte) error,
+) error {
+	if depth > 64 {
+		return errInvalidDatabase
+	}
+	page, err := db.readPage(num)
+	if err != nil {
+		return err
+	}
+	hdr := page
+	if num == 1 {
+		hdr = page[100:]
+	}
+	if len(hdr) < 8 {
+		return errInvalidDatabase
+	}
+	ncells := int(binary.BigEndian.Uint16(hdr[3:]))
+	switch hdr[0] {
+	case 0x05:
+		// interior table page
+		if len(hdr) < 12+ncells*2 {
+			return errInvalidDatabase
+		}
+		for i := 0; i < ncells; i++ {
+			off := int(binary.BigEndian.Uint16(hdr[12+i*2:]))
+			if off+4 > len(page) {
+				return errInvalidDatabase
+			}
+			child := int(binary.BigEndian.Uint32(page[off:]))
+			if err := db.scanPage(child, depth+1, iter); err != nil {
+				return err
+			}
+		}
+		right := int(binary.BigEndian.Uint32(hdr[8:]))
+		return db.scanPage(right, depth+1, iter)
+	case 0x0D:
+		// leaf table page
+		if len(hdr) < 8+ncells*2 {
+			return errInvalidDatabase
+		}
+		for i := 0; i < ncells; i++ {
+			off := int(binary.BigEndian.Uint16(hdr[8+i*2:]))
+			if off >= len(page) {
+				return errInvalidDatabase
+			}
+			size, n := sqliteVarint(page[off:])
+			if n == 0 {
+				return errInvalidDatabase
+			}
+			off += n
+			rowid, n := sqliteVarint(page[off:])
+			if n == 0 {
+				return errInvalidDatabase
+			}
+			off += n
+			payload, err := db.payload(page[off:], int(size))
+			if err != nil {
+				return err
+			}
+			if err := iter(int64(rowid), payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errInvalidDatabase
+}
+
+// payload returns the payload of a cell, following the overflow pages when
+// the payload doesn't fit on the page.
+func (db *sqliteDB) payload(cell []byte, size int) ([]byte, error) {
+	u := db.usable
+	x := u - 35
+	local := size
+	if size > x {
+		m := ((u-12)*32/255 - 23)
+		k := m + (size-m)%(u-4)
+		if k <= x {
+			local = k
+		} else {
+			local = m
+		}
+	}
+	if local > len(cell) {
+		return nil, errInvalidDatabase
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, cell[:local]...)
+	if local == size {
+		return payload, nil
+	}
+	if local+4 > len(cell) {
+		return nil, errInvalidDatabase
+	}
+	next := int(binary.BigEndian.Uint32(cell[local:]))
+	for len(payload) < size {
+		if next == 0 {
+			return nil, errInvalidDatabase
+		}
+		page, err := db.readPage(next)
+		if err != nil {
+			return nil, err
+		}
+		next = int(binary.BigEndian.Uint32(page))
+		n := size - len(payload)
+		if n > u-4 {
+			n = u - 4
+		}
+		payload = append(payload, page[4:4+n]...)
+	}
+	return payload, nil
+}
+
+// sqliteRecord decodes a record into its values, which are nil, int64,
+// float64, string, or []byte.
+func sqliteRecord(b []byte) ([]interface{}, error) {
+	hdrSize, n := sqliteVarint(b)
+	if n == 0 || int(hdrSize) > len(b) {
+		return nil, errInvalidDatabase
+	}
+	hdr := b[n:hdrSize]
+	body := b[hdrSize:]
+	var values []interface{}
+	for len(hdr) > 0 {
+		typ, n := sqliteVarint(hdr)
+		if n == 0 {
+			return nil, errInvalidDatabase
+		}
+		hdr = hdr[n:]
+		var size int
+		switch {
+		case typ >= 12:
+			size = int(typ-12) / 2
+		case typ >= 1 && typ <= 4:
+			size = int(typ)
+		case typ == 5:
+			size = 6
+		case typ == 6 || typ == 7:
+			size = 8
+		}
+		if size > len(body) {
+			return nil, errInvalidDatabase
+		}
+		data := body[:size]
+		body = body[size:]
+		switch {
+		case typ == 0:
+			values = append(values, nil)
+		case typ >= 1 && typ <= 6:
+			var v int64
+			for _, c := range data {
+				v = v<<8 | int64(c)
+			}
+			// sign extend
+			shift := uint(64 - size*8)
+			values = append(values, v<<shift>>shift)
+		case typ == 7:
+			values = append(values,
+				math.Float64frombits(binary.BigEndian.Uint64(data)))
+		case typ == 8:
+			values = append(values, int64(0))
+		case typ == 9:
+			values = append(values, int64(1))
+		case typ >= 12 && typ%2 == 0:
+			values = append(values, data)
+		case typ >= 13:
+			values = append(values, string(data))
+		default:
+			return nil, errInvalidDatabase
+		}
+	}
+	return values, nil
+}
+
+// tables returns the tables in the database schema.
+func (db *sqliteDB) tables() (map[string]*sqliteTable, error) {
+	tables := make(map[string]*sqliteTable)
+	err := db.scan(1, func(rowid int64, payload []byte) error {
+		rec, err := sqliteRecord(payload)
+		if err != nil {
+			return err
+		}
+		if len(rec) < 5 || rec[0] != "table" {
+			return nil
+		}
+		name, _ := rec[1].(string)
+		root, _ := rec[3].(int64)
+		sql, _ := rec[4].(string)
+		table := &sqliteTable{name: name, rootPage: int(root), rowidCol: -1}
+		table.columns, table.rowidCol = sqliteColumns(sql)
+		tables[strings.ToLower(name)] = table
+		return nil
+	})
+	return tables, err
+}
+
+// rows calls iter with the values of each row in the table, in column order.
+// The rowid is used for the INTEGER PRIMARY KEY column, which is stored as a
+// null.
+func (db *sqliteDB) rows(table *sqliteTable, iter func(values []interface{}) error) error {
+	return db.scan(table.rootPage, func(rowid int64, payload []byte) error {
+		values, err := sqliteRecord(payload)
+		if err != nil {
+			return err
+		}
+		// columns added with ALTER TABLE are missing from older rows
+		for len(values) < len(table.columns) {
+			values = append(values, nil)
+		}
+		if table.rowidCol >= 0 {
+			values[table.rowidCol] = rowid
+		}
+		return iter(values)
+	})
+}
+
+// sqliteColumns returns the column names in a CREATE TABLE statement, and
+// the index of the INTEGER PRIMARY KEY column, which is an alias for the
+// rowid.
+func sqliteColumns(sql string) (columns []string, rowidCol int) {
+	rowidCol = -1
+	start := strings.IndexByte(sql, '(')
+	end := strings.LastIndexByte(sql, ')')
+	if start == -1 || end < start {
+		return nil, -1
+	}
+	var defs []string
+	var depth int
+	var quote byte
+	last := start + 1
+	for i := start + 1; i < end; i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, sql[last:i])
+			last = i + 1
+		}
+	}
+	defs = append(defs, sql[last:end])
+	for _, def := range defs {
+		def = strings.TrimSpace(def)
+		upper := strings.ToUpper(def)
+		var keyword bool
+		for _, prefix := range []string{"CONSTRAINT ", "PRIMARY ", "UNIQUE",
+			"CHECK", "FOREIGN "} {
+			if strings.HasPrefix(upper, prefix) {
+				keyword = true
+				break
+			}
+		}
+		if keyword || def == "" {
+			continue
+		}
+		var name string
+		if q := def[0]; q == '"' || q == '`' || q == '[' || q == '\'' {
+			closeq := q
+			if q == '[' {
+				closeq = ']'
+			}
+			i := strings.IndexByte(def[1:], closeq)
+			if i == -1 {
+				continue
+			}
+			name = def[1 : i+1]
+			upper = upper[i+2:]
+		} else {
+			i := strings.IndexAny(def, " \t\r\n")
+			if i == -1 {
+				i = len(def)
+			}
+			name = def[:i]
+			upper = upper[i:]
+		}
+		fields := strings.Fields(upper)
+		if len(fields) > 0 && fields[0] == "INTEGER" &&
+			strings.Contains(strings.Join(fields, " "), "PRIMARY KEY") &&
+			!strings.Contains(upper, " DESC") {
+			rowidCol = len(columns)
+		}
+		columns = append(columns, name)
+	}
+	return columns, rowidCol
+}
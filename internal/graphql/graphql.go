@@ -0,0 +1,905 @@
+// Package graphql parses the query documents of GraphQL, which are the
+// operations, selections, arguments, variables, fragments, and directives of
+// the spec. The type system and the mutations are not supported.
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a parsed query document.
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation is a query, which may be the shorthand of a selection set.
+type Operation struct {
+	Name       string
+	Variables  []*VariableDef
+	Directives []*Directive
+	Selections []Selection
+}
+
+// VariableDef is a variable of an operation.
+type VariableDef struct {
+	Name       string
+	Type       string // such as "String!" or "[Float]"
+	Default    interface{}
+	HasDefault bool
+}
+
+// Selection is a *Field, a *FragmentSpread, or an *InlineFragment.
+type Selection interface{}
+
+// Field is a selected field, which is returned with the alias, when it has
+// one, or the name.
+type Field struct {
+	Alias      string
+	Name       string
+	Arguments  []*Argument
+	Directives []*Directive
+	Selections []Selection
+}
+
+// Key returns the key of the field in the response.
+func (f *Field) Key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// Argument is an argument of a field or directive. The value is nil, a
+// bool, an int64, a float64, a string, an Enum, a Variable, a
+// []interface{}, or a map[string]interface{}.
+type Argument struct {
+	Name  string
+	Value interface{}
+}
+
+// Directive is a directive, such as @skip or @include.
+type Directive struct {
+	Name      string
+	Arguments []*Argument
+}
+
+// Fragment is a named fragment.
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	Directives    []*Directive
+	Selections    []Selection
+}
+
+// FragmentSpread is a use of a named fragment.
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+}
+
+// InlineFragment is a fragment in a selection set.
+type InlineFragment struct {
+	TypeCondition string
+	Directives    []*Directive
+	Selections    []Selection
+}
+
+// Variable is a reference to a variable.
+type Variable string
+
+// Enum is an enum value.
+type Enum string
+
+// Error is an error of the syntax of a document.
+type Error struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("Syntax Error: %s (%d:%d)", err.Message, err.Line,
+		err.Column)
+}
+
+// Parse parses a query document.
+func Parse(src string) (*Document, error) {
+	p := &parser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	doc := &Document{Fragments: make(map[string]*Fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek("{"):
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &Operation{
+				Selections: sels,
+			})
+		case p.tok.kind == tokName && p.tok.val == "query":
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case p.tok.kind == tokName && p.tok.val == "fragment":
+			frag, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.Fragments[frag.Name] != nil {
+				return nil, fmt.Errorf("There can be only one fragment "+
+					"named \"%s\".", frag.Name)
+			}
+			doc.Fragments[frag.Name] = frag
+		case p.tok.kind == tokName &&
+			(p.tok.val == "mutation" || p.tok.val == "subscription"):
+			return nil, fmt.Errorf("The %s operations are not supported.",
+				p.tok.val)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, errors.New("The document has no operations.")
+	}
+	return doc, nil
+}
+
+// Operation returns an operation by name, which may be empty when the
+// document has one operation.
+func (doc *Document) Operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(doc.Operations) > 1 {
+			return nil, errors.New("Must provide operation name if query " +
+				"contains multiple operations.")
+		}
+		return doc.Operations[0], nil
+	}
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown operation named \"%s\".", name)
+}
+
+// Values returns the values of the variables of an operation, which are
+// the provided values or the defaults. A variable of a non-null type must
+// have a value.
+func (op *Operation) Values(vars map[string]interface{}) (
+	map[string]interface{}, error,
+) {
+	values := make(map[string]interface{})
+	for _, def := range op.Variables {
+		v, ok := vars[def.Name]
+		if !ok && def.HasDefault {
+			v, ok = def.Default, true
+		}
+		if (!ok || v == nil) && strings.HasSuffix(def.Type, "!") {
+			return nil, fmt.Errorf("Variable \"$%s\" of required type "+
+				"\"%s\" was not provided.", def.Name, def.Type)
+		}
+		if ok {
+			values[def.Name] = v
+		}
+	}
+	return values, nil
+}
+
+// Value returns a value with the variables replaced by their values.
+func Value(v interface{}, vars map[string]interface{}) interface{} {
+	switch v := v.(type) {
+	case Variable:
+		return vars[string(v)]
+	case []interface{}:
+		vals := make([]interface{}, len(v))
+		for i := range v {
+			vals[i] = Value(v[i], vars)
+		}
+		return vals
+	case map[string]interface{}:
+		vals := make(map[string]interface{}, len(v))
+		for k := range v {
+			vals[k] = Value(v[k], vars)
+		}
+		return vals
+	}
+	return v
+}
+
+// Included returns false when a selection is skipped by @skip or @include.
+func Included(directives []*Directive, vars map[string]interface{}) bool {
+	for _, d := range directives {
+		for _, arg := range d.Arguments {
+			if arg.Name != "if" {
+				continue
+			}
+			on, _ := Value(arg.Value, vars).(bool)
+			if (d.Name == "skip" && on) || (d.Name == "include" && !on) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// CollectFields returns the fields of a selection set, which includes the
+// fields of the fragments. The type conditions of the fragments are matched
+// with typ, or ignored when typ is empty. The fields with the same key are
+// merged into the first one.
+func (doc *Document) CollectFields(typ string, sels []Selection,
+	vars map[string]interface{},
+) ([]*Field, error) {
+	var fields []*Field
+	keys := make(map[string]*Field)
+	var collect func(sels []Selection, visited map[string]bool) error
+	collect = func(sels []Selection, visited map[string]bool) error {
+		for _, sel := range sels {
+			switch sel := sel.(type) {
+			case *Field:
+				if !Included(sel.Directives, vars) {
+					continue
+				}
+				if f := keys[sel.Key()]; f != nil {
+					if f.Name != sel.Name {
+						return fmt.Errorf("Fields \"%s\" conflict because "+
+							"%s and %s are different fields.", sel.Key(),
+							f.Name, sel.Name)
+					}
+					merged := *f
+					merged.Selections = append(append([]Selection{},
+						f.Selections...), sel.Selections...)
+					*f = merged
+					continue
+				}
+				f := *sel
+				keys[sel.Key()] = &f
+				fields = append(fields, &f)
+			case *InlineFragment:
+				if !Included(sel.Directives, vars) ||
+					(typ != "" && sel.TypeCondition != "" &&
+						sel.TypeCondition != typ) {
+					continue
+				}
+				if err := collect(sel.Selections, visited); err != nil {
+					return err
+				}
+			case *FragmentSpread:
+				if !Included(sel.Directives, vars) || visited[sel.Name] {
+					continue
+				}
+				frag := doc.Fragments[sel.Name]
+				if frag == nil {
+					return fmt.Errorf("Unknown fragment \"%s\".", sel.Name)
+				}
+				if typ != "" && frag.TypeCondition != typ {
+					continue
+				}
+				visited[sel.Name] = true
+				err := collect(frag.Selections, visited)
+				delete(visited, sel.Name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := collect(sels, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+const (
+	tokEOF = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind int
+	val  string
+	pos  int
+}
+
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+func (p *parser) errorf(pos int, format string, args ...interface{}) error {
+	line, col := 1, 1
+	for _, c := range p.src[:pos] {
+		if c == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return &Error{Message: fmt.Sprintf(format, args...), Line: line,
+		Column: col}
+}
+
+func (p *parser) unexpected() error {
+	switch p.tok.kind {
+	case tokEOF:
+		return p.errorf(p.tok.pos, "Expected Name, found <EOF>.")
+	case tokString:
+		return p.errorf(p.tok.pos, "Unexpected string.")
+	}
+	return p.errorf(p.tok.pos, "Unexpected \"%s\".", p.tok.val)
+}
+
+// next reads the next token, skipping the whitespace, commas, and comments.
+func (p *parser) next() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' &&
+				p.src[p.pos] != '\r' {
+				p.pos++
+			}
+		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' ||
+			c == ',' {
+			p.pos++
+		} else if strings.HasPrefix(p.src[p.pos:], "\ufeff") {
+			p.pos += len("\ufeff")
+		} else {
+			break
+		}
+	}
+	start := p.pos
+	if p.pos == len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+	c := p.src[p.pos]
+	switch {
+	case strings.IndexByte("!$&()[]{}:=@|", c) != -1:
+		p.pos++
+		p.tok = token{kind: tokPunct, val: string(c), pos: start}
+	case c == '.':
+		if !strings.HasPrefix(p.src[p.pos:], "...") {
+			return p.errorf(start, "Unexpected \".\".")
+		}
+		p.pos += 3
+		p.tok = token{kind: tokPunct, val: "...", pos: start}
+	case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+		p.tok = token{kind: tokName, val: p.src[start:p.pos], pos: start}
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.number()
+	case c == '"':
+		if strings.HasPrefix(p.src[p.pos:], `"""`) {
+			return p.blockString()
+		}
+		return p.string()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		return p.errorf(start, "Unexpected character \"%c\".", r)
+	}
+	return nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
+
+func (p *parser) number() error {
+	start := p.pos
+	kind := tokInt
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+			n++
+		}
+		return n
+	}
+	if digits() == 0 {
+		return p.errorf(start, "Invalid number.")
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		p.pos++
+		kind = tokFloat
+		if digits() == 0 {
+			return p.errorf(start, "Invalid number.")
+		}
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		p.pos++
+		kind = tokFloat
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		if digits() == 0 {
+			return p.errorf(start, "Invalid number.")
+		}
+	}
+	if p.pos < len(p.src) && (isNameChar(p.src[p.pos]) || p.src[p.pos] == '.') {
+		return p.errorf(start, "Invalid number.")
+	}
+	p.tok = token{kind: kind, val: p.src[start:p.pos], pos: start}
+	return nil
+}
+
+func (p *parser) string() error {
+	start := p.pos
+	p.pos++
+	var sb strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' || p.src[p.pos] == '\r' {
+			return p.errorf(start, "Unterminated string.")
+		}
+		c := p.src[p.pos]
+		if c == '"' {
+			p.pos++
+			break
+		}
+		if c != '\\' {
+			sb.WriteByte(c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(p.src) {
+			return p.errorf(start, "Unterminated string.")
+		}
+		esc := p.src[p.pos+1]
+		p.pos += 2
+		switch esc {
+		case '"', '\\', '/':
+			sb.WriteByte(esc)
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'u':
+			if p.pos+4 > len(p.src) {
+				return p.errorf(p.pos-2, "Invalid character escape sequence.")
+			}
+			n, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 16)
+			if err != nil {
+				return p.errorf(p.pos-2, "Invalid character escape sequence.")
+			}
+			sb.WriteRune(rune(n))
+			p.pos += 4
+		default:
+			return p.errorf(p.pos-2, "Invalid character escape sequence.")
+		}
+	}
+	p.tok = token{kind: tokString, val: sb.String(), pos: start}
+	return nil
+}
+
+// blockString reads a """ string, where the common indentation of the lines
+// and the blank first and last lines are removed.
+func (p *parser) blockString() error {
+	start := p.pos
+	p.pos += 3
+	var raw strings.Builder
+	for {
+		if p.pos >= len(p.src) {
+			return p.errorf(start, "Unterminated string.")
+		}
+		if strings.HasPrefix(p.src[p.pos:], `"""`) {
+			p.pos += 3
+			break
+		}
+		if strings.HasPrefix(p.src[p.pos:], `\"""`) {
+			raw.WriteString(`"""`)
+			p.pos += 4
+			continue
+		}
+		raw.WriteByte(p.src[p.pos])
+		p.pos++
+	}
+	lines := strings.Split(strings.ReplaceAll(raw.String(), "\r\n", "\n"),
+		"\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if n < len(line) && (indent == -1 || n < indent) {
+			indent = n
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	p.tok = token{kind: tokString, val: strings.Join(lines, "\n"), pos: start}
+	return nil
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.val == punct
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.peek(punct) {
+		if p.tok.kind == tokEOF {
+			return p.errorf(p.tok.pos, "Expected \"%s\", found <EOF>.", punct)
+		}
+		return p.errorf(p.tok.pos, "Expected \"%s\", found \"%s\".", punct,
+			p.tok.val)
+	}
+	return p.next()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.val
+	return name, p.next()
+}
+
+func (p *parser) operation() (*Operation, error) {
+	op := new(Operation)
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.tok.kind == tokName {
+		if op.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for !p.peek(")") {
+			def, err := p.variableDef()
+			if err != nil {
+				return nil, err
+			}
+			op.Variables = append(op.Variables, def)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if op.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if op.Selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (p *parser) variableDef() (*VariableDef, error) {
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+	def := new(VariableDef)
+	var err error
+	if def.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	if def.Type, err = p.typeRef(); err != nil {
+		return nil, err
+	}
+	if p.peek("=") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if def.Default, err = p.value(true); err != nil {
+			return nil, err
+		}
+		def.HasDefault = true
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+func (p *parser) typeRef() (string, error) {
+	var typ string
+	if p.peek("[") {
+		if err := p.next(); err != nil {
+			return "", err
+		}
+		elem, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + elem + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if p.peek("!") {
+		if err := p.next(); err != nil {
+			return "", err
+		}
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *parser) fragment() (*Fragment, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	frag := new(Fragment)
+	var err error
+	if frag.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if frag.Name == "on" {
+		return nil, p.errorf(p.tok.pos, "Unexpected Name \"on\".")
+	}
+	if p.tok.kind != tokName || p.tok.val != "on" {
+		return nil, p.errorf(p.tok.pos, "Expected \"on\".")
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if frag.TypeCondition, err = p.name(); err != nil {
+		return nil, err
+	}
+	if frag.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if frag.Selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return frag, nil
+}
+
+func (p *parser) selectionSet() ([]Selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []Selection
+	for !p.peek("}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, p.errorf(p.tok.pos, "Expected Name, found \"}\".")
+	}
+	return sels, p.next()
+}
+
+func (p *parser) selection() (Selection, error) {
+	if p.peek("...") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokName && p.tok.val != "on" {
+			spread := new(FragmentSpread)
+			var err error
+			if spread.Name, err = p.name(); err != nil {
+				return nil, err
+			}
+			if spread.Directives, err = p.directives(); err != nil {
+				return nil, err
+			}
+			return spread, nil
+		}
+		frag := new(InlineFragment)
+		var err error
+		if p.tok.kind == tokName {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if frag.TypeCondition, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if frag.Directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		if frag.Selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+		return frag, nil
+	}
+	f := new(Field)
+	var err error
+	if f.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.peek(":") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		f.Alias = f.Name
+		if f.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Arguments, err = p.arguments(false); err != nil {
+		return nil, err
+	}
+	if f.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if f.Selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) arguments(isConst bool) ([]*Argument, error) {
+	if !p.peek("(") {
+		return nil, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	var args []*Argument
+	for !p.peek(")") {
+		arg := new(Argument)
+		var err error
+		if arg.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if arg.Value, err = p.value(isConst); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) == 0 {
+		return nil, p.errorf(p.tok.pos, "Expected Name, found \")\".")
+	}
+	return args, p.next()
+}
+
+func (p *parser) directives() ([]*Directive, error) {
+	var ds []*Directive
+	for p.peek("@") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		d := new(Directive)
+		var err error
+		if d.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if d.Arguments, err = p.arguments(false); err != nil {
+			return nil, err
+		}
+		ds = append(ds, d)
+	}
+	return ds, nil
+}
+
+// value reads a value, which may not have variables when isConst is true,
+// such as the default of a variable.
+func (p *parser) value(isConst bool) (interface{}, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokInt:
+		n, err := strconv.ParseInt(tok.val, 10, 64)
+		if err != nil {
+			return nil, p.errorf(tok.pos, "Invalid number.")
+		}
+		return n, p.next()
+	case tokFloat:
+		f, err := strconv.ParseFloat(tok.val, 64)
+		if err != nil {
+			return nil, p.errorf(tok.pos, "Invalid number.")
+		}
+		return f, p.next()
+	case tokString:
+		return tok.val, p.next()
+	case tokName:
+		var v interface{}
+		switch tok.val {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			v = Enum(tok.val)
+		}
+		return v, p.next()
+	case tokPunct:
+		switch tok.val {
+		case "$":
+			if isConst {
+				return nil, p.errorf(tok.pos, "Unexpected \"$\".")
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			return Variable(name), nil
+		case "[":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			vals := []interface{}{}
+			for !p.peek("]") {
+				v, err := p.value(isConst)
+				if err != nil {
+					return nil, err
+				}
+				vals = append(vals, v)
+			}
+			return vals, p.next()
+		case "{":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			obj := make(map[string]interface{})
+			for !p.peek("}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.value(isConst); err != nil {
+					return nil, err
+				}
+			}
+			return obj, p.next()
+		}
+	}
+	return nil, p.unexpected()
+}
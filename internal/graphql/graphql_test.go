@@ -0,0 +1,150 @@
+package graphql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	doc, err := Parse(`
+		# the fleet
+		query Fleet($key: String! = "fleet", $near: Boolean) {
+			all: collection(key: $key) { count }
+			nearby(key: $key, lat: 33.5, lon: -115, radius: 1e3,
+				where: [{field: "speed", min: 0, max: 100}]) @include(if: $near) {
+				...ids
+			}
+			scan(key: """block "string" """, desc: true, limit: 10) {
+				... on Search { count }
+			}
+		}
+		fragment ids on Search { objects { id } }
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, err := doc.Operation("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Name != "Fleet" || len(op.Variables) != 2 ||
+		op.Variables[0].Type != "String!" ||
+		op.Variables[0].Default != "fleet" || op.Variables[1].HasDefault {
+		t.Fatalf("unexpected operation: %+v", op)
+	}
+	if len(op.Selections) != 3 {
+		t.Fatalf("expected 3 selections, got %d", len(op.Selections))
+	}
+	all := op.Selections[0].(*Field)
+	if all.Key() != "all" || all.Name != "collection" ||
+		all.Arguments[0].Value != Variable("key") {
+		t.Fatalf("unexpected field: %+v", all)
+	}
+	nearby := op.Selections[1].(*Field)
+	args := make(map[string]interface{})
+	for _, arg := range nearby.Arguments {
+		args[arg.Name] = arg.Value
+	}
+	expect := map[string]interface{}{
+		"key": Variable("key"), "lat": 33.5, "lon": int64(-115),
+		"radius": 1000.0, "where": []interface{}{map[string]interface{}{
+			"field": "speed", "min": int64(0), "max": int64(100),
+		}},
+	}
+	if !reflect.DeepEqual(args, expect) {
+		t.Fatalf("expected %v, got %v", expect, args)
+	}
+	scan := op.Selections[2].(*Field)
+	if scan.Arguments[0].Value != `block "string" ` {
+		t.Fatalf("unexpected block string: %q", scan.Arguments[0].Value)
+	}
+
+	vars, err := op.Values(map[string]interface{}{"near": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Value(Variable("key"), vars) != "fleet" {
+		t.Fatalf("expected the default, got %v", vars)
+	}
+	fields, err := doc.CollectFields("Search", nearby.Selections, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 1 || fields[0].Name != "objects" {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+	fields, err = doc.CollectFields("Query", op.Selections,
+		map[string]interface{}{"near": false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 || fields[1].Name != "scan" {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+}
+
+func TestCollectFields(t *testing.T) {
+	doc, err := Parse(`{ a { x } a { y } b: c @skip(if: true) }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := doc.CollectFields("", doc.Operations[0].Selections, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 1 || len(fields[0].Selections) != 2 {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+	doc, err = Parse(`{ a: b a: c }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doc.CollectFields("", doc.Operations[0].Selections,
+		nil); err == nil {
+		t.Fatal("expected a conflict")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src, err string
+	}{
+		{`{ a `, "Syntax Error"},
+		{`{ a(x: "abc) }`, "Syntax Error"},
+		{`{ a(x: $v) }`, ""},
+		{`query { a(x: 1.) }`, "Syntax Error"},
+		{`mutation { a }`, "not supported"},
+		{``, "no operations"},
+		{`fragment f on T { a } fragment f on T { b } { ...f }`,
+			"only one fragment"},
+	} {
+		_, err := Parse(tc.src)
+		if tc.err == "" {
+			if err != nil {
+				t.Fatalf("%q: %v", tc.src, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("%q: expected %q, got %v", tc.src, tc.err, err)
+		}
+	}
+	doc, err := Parse(`query A { a } query B { b }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doc.Operation(""); err == nil {
+		t.Fatal("expected an error for multiple operations")
+	}
+	if op, err := doc.Operation("B"); err != nil || op.Name != "B" {
+		t.Fatalf("unexpected operation: %v %v", op, err)
+	}
+	doc, err = Parse(`query ($k: String!) { a(k: $k) }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doc.Operations[0].Values(nil); err == nil {
+		t.Fatal("expected an error for a missing variable")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2016-2021 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The hex ijk+ coordinates, from the coordijk.c file of the H3 library.
+
+package h3
+
+import "math"
+
+// coordIJK is a cell of a hex grid, as the ijk+ coordinates of its center.
+// Normalized coordinates have no negative component and at least one zero.
+type coordIJK struct {
+	i, j, k int
+}
+
+// the unit vectors of the digits, from the center to the k, j, jk, i, ik and
+// ij axes.
+var unitVecs = [...]coordIJK{
+	{0, 0, 0}, {0, 0, 1}, {0, 1, 0}, {0, 1, 1}, {1, 0, 0}, {1, 0, 1}, {1, 1, 0},
+}
+
+const (
+	centerDigit  = 0
+	kAxesDigit   = 1
+	jAxesDigit   = 2
+	jkAxesDigit  = 3
+	iAxesDigit   = 4
+	ikAxesDigit  = 5
+	ijAxesDigit  = 6
+	invalidDigit = 7
+)
+
+// hex2dToCoordIJK returns the cell of a hex grid that contains a point.
+func hex2dToCoordIJK(v vec2d) coordIJK {
+	var h coordIJK
+	a1 := math.Abs(v.x)
+	a2 := math.Abs(v.y)
+
+	// first do a reverse conversion
+	x2 := a2 / sin60
+	x1 := a1 + x2/2
+
+	// check if we have the center of a hex
+	m1 := int(x1)
+	m2 := int(x2)
+
+	// otherwise round correctly
+	r1 := x1 - float64(m1)
+	r2 := x2 - float64(m2)
+
+	if r1 < 0.5 {
+		if r1 < 1.0/3.0 {
+			if r2 < (1+r1)/2 {
+				h.i, h.j = m1, m2
+			} else {
+				h.i, h.j = m1, m2+1
+			}
+		} else {
+			if r2 < 1-r1 {
+				h.j = m2
+			} else {
+				h.j = m2 + 1
+			}
+			if 1-r1 <= r2 && r2 < 2*r1 {
+				h.i = m1 + 1
+			} else {
+				h.i = m1
+			}
+		}
+	} else {
+		if r1 < 2.0/3.0 {
+			if r2 < 1-r1 {
+				h.j = m2
+			} else {
+				h.j = m2 + 1
+			}
+			if 2*r1-1 < r2 && r2 < 1-r1 {
+				h.i = m1
+			} else {
+				h.i = m1 + 1
+			}
+		} else {
+			if r2 < r1/2 {
+				h.i, h.j = m1+1, m2
+			} else {
+				h.i, h.j = m1+1, m2+1
+			}
+		}
+	}
+
+	// now fold across the axes if necessary
+	if v.x < 0 {
+		if h.j%2 == 0 {
+			axisi := h.j / 2
+			diff := h.i - axisi
+			h.i = h.i - 2*diff
+		} else {
+			axisi := (h.j + 1) / 2
+			diff := h.i - axisi
+			h.i = h.i - (2*diff + 1)
+		}
+	}
+	if v.y < 0 {
+		h.i = h.i - (2*h.j+1)/2
+		h.j = -h.j
+	}
+	h.normalize()
+	return h
+}
+
+// hex2d returns the center of a cell on its hex grid.
+func (h coordIJK) hex2d() vec2d {
+	i := h.i - h.k
+	j := h.j - h.k
+	return vec2d{float64(i) - 0.5*float64(j), float64(j) * sqrt3_2}
+}
+
+func (h coordIJK) add(o coordIJK) coordIJK {
+	return coordIJK{h.i + o.i, h.j + o.j, h.k + o.k}
+}
+
+func (h coordIJK) sub(o coordIJK) coordIJK {
+	return coordIJK{h.i - o.i, h.j - o.j, h.k - o.k}
+}
+
+func (h coordIJK) scale(factor int) coordIJK {
+	return coordIJK{h.i * factor, h.j * factor, h.k * factor}
+}
+
+// normalize removes the negative components and the common minimum.
+func (h *coordIJK) normalize() {
+	if h.i < 0 {
+		h.j -= h.i
+		h.k -= h.i
+		h.i = 0
+	}
+	if h.j < 0 {
+		h.i -= h.j
+		h.k -= h.j
+		h.j = 0
+	}
+	if h.k < 0 {
+		h.i -= h.k
+		h.j -= h.k
+		h.k = 0
+	}
+	min := h.i
+	if h.j < min {
+		min = h.j
+	}
+	if h.k < min {
+		min = h.k
+	}
+	if min > 0 {
+		h.i -= min
+		h.j -= min
+		h.k -= min
+	}
+}
+
+// unitDigit returns the digit of a unit vector, or invalidDigit.
+func (h coordIJK) unitDigit() int {
+	h.normalize()
+	for d := centerDigit; d < invalidDigit; d++ {
+		if h == unitVecs[d] {
+			return d
+		}
+	}
+	return invalidDigit
+}
+
+// mul returns the sum of the unit vectors of the new grid, scaled by the
+// components of a cell.
+func (h *coordIJK) mul(iVec, jVec, kVec coordIJK) {
+	*h = iVec.scale(h.i).add(jVec.scale(h.j)).add(kVec.scale(h.k))
+	h.normalize()
+}
+
+// upAp7 moves a cell to its parent, in the ccw aperture 7 grid.
+func (h *coordIJK) upAp7() {
+	i := h.i - h.k
+	j := h.j - h.k
+	h.i = int(math.Round(float64(3*i-j) / 7))
+	h.j = int(math.Round(float64(i+2*j) / 7))
+	h.k = 0
+	h.normalize()
+}
+
+// upAp7r moves a cell to its parent, in the cw aperture 7 grid.
+func (h *coordIJK) upAp7r() {
+	i := h.i - h.k
+	j := h.j - h.k
+	h.i = int(math.Round(float64(2*i+j) / 7))
+	h.j = int(math.Round(float64(3*j-i) / 7))
+	h.k = 0
+	h.normalize()
+}
+
+// downAp7 moves a cell to its center child, in the ccw aperture 7 grid.
+func (h *coordIJK) downAp7() {
+	h.mul(coordIJK{3, 0, 1}, coordIJK{1, 3, 0}, coordIJK{0, 1, 3})
+}
+
+// downAp7r moves a cell to its center child, in the cw aperture 7 grid.
+func (h *coordIJK) downAp7r() {
+	h.mul(coordIJK{3, 1, 0}, coordIJK{0, 3, 1}, coordIJK{1, 0, 3})
+}
+
+// downAp3 moves a cell to its center child, in the ccw aperture 3 grid.
+func (h *coordIJK) downAp3() {
+	h.mul(coordIJK{2, 0, 1}, coordIJK{1, 2, 0}, coordIJK{0, 1, 2})
+}
+
+// downAp3r moves a cell to its center child, in the cw aperture 3 grid.
+func (h *coordIJK) downAp3r() {
+	h.mul(coordIJK{2, 1, 0}, coordIJK{0, 2, 1}, coordIJK{1, 0, 2})
+}
+
+// neighbor moves a cell to its neighbor in the direction of a digit.
+func (h *coordIJK) neighbor(digit int) {
+	if digit > centerDigit && digit < invalidDigit {
+		*h = h.add(unitVecs[digit])
+		h.normalize()
+	}
+}
+
+func (h *coordIJK) rotate60ccw() {
+	h.mul(coordIJK{1, 1, 0}, coordIJK{0, 1, 1}, coordIJK{1, 0, 1})
+}
+
+func (h *coordIJK) rotate60cw() {
+	h.mul(coordIJK{1, 0, 1}, coordIJK{1, 1, 0}, coordIJK{0, 1, 1})
+}
+
+// the digits rotated 60 degrees ccw and cw
+var (
+	digitRotate60ccw = [...]int{0, 5, 3, 1, 6, 4, 2, 7}
+	digitRotate60cw  = [...]int{0, 3, 6, 2, 5, 1, 4, 7}
+)
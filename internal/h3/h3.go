@@ -0,0 +1,651 @@
+// Copyright 2016-2021 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package h3 is a pure Go port of the indexing functions of the H3 library,
+// https://github.com/uber/h3, which are the cells of points and the
+// boundaries of cells. It's the default core.H3 indexer.
+package h3
+
+import "math"
+
+const (
+	maxRes        = 15
+	numIcosaFaces = 20
+	numBaseCells  = 122
+	numHexVerts   = 6
+	numPentVerts  = 5
+
+	epsilon       = 0.0000000000000001
+	sqrt7         = 2.6457513110645905905016157536392604257102
+	sqrt3_2       = 0.8660254037844386467637231707529361834714
+	sin60         = sqrt3_2
+	ap7RotRads    = 0.333473172251832115336090755351601070065900389
+	res0UGnomonic = 0.38196601125010500003
+
+	// the largest ijk+ component of a base cell on a face
+	maxFaceCoord = 2
+
+	// the directions of the faceNeighbors
+	faceIJ = 1
+	faceKI = 2
+	faceJK = 3
+)
+
+// the overages of a cell on a face
+const (
+	noOverage = iota
+	faceEdge  // on the edge of the face, in a substrate grid
+	newFace   // in the interior of another face
+)
+
+// the distances on a face at the class II resolutions, and the one past 15
+var (
+	maxDimByCIIres = [...]int{2, -1, 14, -1, 98, -1, 686, -1, 4802, -1,
+		33614, -1, 235298, -1, 1647086, -1, 11529602}
+	unitScaleByCIIres = [...]int{1, -1, 7, -1, 49, -1, 343, -1, 2401, -1,
+		16807, -1, 117649, -1, 823543, -1, 5764801}
+)
+
+type latLng struct {
+	lat, lng float64
+}
+
+type vec2d struct {
+	x, y float64
+}
+
+type vec3d struct {
+	x, y, z float64
+}
+
+// faceIJK is a cell on the hex grid of an icosahedron face.
+type faceIJK struct {
+	face  int
+	coord coordIJK
+}
+
+// faceOrientIJK is the transform into the coordinate system of an adjacent
+// face.
+type faceOrientIJK struct {
+	face      int
+	translate coordIJK
+	ccwRot60  int
+}
+
+type baseCellRotation struct {
+	baseCell int
+	ccwRot60 int
+}
+
+type baseCell struct {
+	homeFijk     faceIJK
+	isPentagon   bool
+	cwOffsetPent [2]int
+}
+
+// Indexer is the core.H3Indexer of this package.
+type Indexer struct{}
+
+// LatLngToCell returns the cell at a resolution, from 0 to 15, that contains
+// a point.
+func (Indexer) LatLngToCell(lat, lon float64, res int) uint64 {
+	return LatLngToCell(lat, lon, res)
+}
+
+// CellToBoundary returns the vertices of a cell, as [lat, lon] pairs.
+func (Indexer) CellToBoundary(cell uint64) [][2]float64 {
+	return CellToBoundary(cell)
+}
+
+// LatLngToCell returns the cell at a resolution, from 0 to 15, that contains
+// a point in degrees. It returns zero for an invalid resolution or point.
+func LatLngToCell(lat, lon float64, res int) uint64 {
+	if res < 0 || res > maxRes || math.IsNaN(lat) || math.IsInf(lat, 0) ||
+		math.IsNaN(lon) || math.IsInf(lon, 0) {
+		return 0
+	}
+	g := latLng{lat * math.Pi / 180, lon * math.Pi / 180}
+	face, v := geoToHex2d(g, res)
+	return faceIjkToH3(faceIJK{face, hex2dToCoordIJK(v)}, res)
+}
+
+// CellToBoundary returns the vertices of a cell, as [lat, lon] pairs in
+// degrees, in ccw order. It returns nil for an invalid cell.
+func CellToBoundary(cell uint64) [][2]float64 {
+	if cell>>63 != 0 || cell>>59&15 != 1 || getBaseCell(cell) >= numBaseCells {
+		return nil
+	}
+	res := getResolution(cell)
+	fijk := h3ToFaceIjk(cell)
+	var verts []latLng
+	if isPentagon(cell) {
+		verts = faceIjkPentToCellBoundary(fijk, res)
+	} else {
+		verts = faceIjkToCellBoundary(fijk, res)
+	}
+	boundary := make([][2]float64, len(verts))
+	for i, g := range verts {
+		boundary[i] = [2]float64{g.lat * 180 / math.Pi, g.lng * 180 / math.Pi}
+	}
+	return boundary
+}
+
+// the fields of a cell index
+
+func getResolution(h uint64) int {
+	return int(h >> 52 & 15)
+}
+
+func getBaseCell(h uint64) int {
+	return int(h >> 45 & 127)
+}
+
+func getDigit(h uint64, r int) int {
+	return int(h >> (uint(maxRes-r) * 3) & 7)
+}
+
+func setDigit(h uint64, r, digit int) uint64 {
+	shift := uint(maxRes-r) * 3
+	return h&^(7<<shift) | uint64(digit)<<shift
+}
+
+// newCell returns an index of the cell mode, with base cell 0 and all the
+// digits set to 7.
+func newCell(res int) uint64 {
+	return 1<<59 | uint64(res)<<52 | (1<<45 - 1)
+}
+
+func isResClassIII(res int) bool {
+	return res%2 == 1
+}
+
+func isBaseCellPentagon(baseCell int) bool {
+	return baseCell >= 0 && baseCell < numBaseCells &&
+		baseCellData[baseCell].isPentagon
+}
+
+func isBaseCellCwOffset(baseCell, face int) bool {
+	return baseCellData[baseCell].cwOffsetPent[0] == face ||
+		baseCellData[baseCell].cwOffsetPent[1] == face
+}
+
+func isPentagon(h uint64) bool {
+	return isBaseCellPentagon(getBaseCell(h)) && leadingNonZeroDigit(h) == 0
+}
+
+// leadingNonZeroDigit returns the first digit that is not zero, or zero.
+func leadingNonZeroDigit(h uint64) int {
+	for r := 1; r <= getResolution(h); r++ {
+		if d := getDigit(h, r); d != 0 {
+			return d
+		}
+	}
+	return centerDigit
+}
+
+func rotate60ccw(h uint64) uint64 {
+	for r := 1; r <= getResolution(h); r++ {
+		h = setDigit(h, r, digitRotate60ccw[getDigit(h, r)])
+	}
+	return h
+}
+
+func rotate60cw(h uint64) uint64 {
+	for r := 1; r <= getResolution(h); r++ {
+		h = setDigit(h, r, digitRotate60cw[getDigit(h, r)])
+	}
+	return h
+}
+
+// rotatePent60ccw rotates the cell of a pentagon base cell, skipping the
+// deleted k-axes sequence.
+func rotatePent60ccw(h uint64) uint64 {
+	found := false
+	for r := 1; r <= getResolution(h); r++ {
+		h = setDigit(h, r, digitRotate60ccw[getDigit(h, r)])
+		if !found && getDigit(h, r) != 0 {
+			found = true
+			if leadingNonZeroDigit(h) == kAxesDigit {
+				h = rotate60ccw(h)
+			}
+		}
+	}
+	return h
+}
+
+// faceIjkToH3 returns the index of a cell on a face, or zero when it's out of
+// range.
+func faceIjkToH3(fijk faceIJK, res int) uint64 {
+	// build the index from the finest resolution up, leaving the ijk of the
+	// base cell in the coordinate system of the face
+	h := newCell(res)
+	ijk := &fijk.coord
+	for r := res - 1; r >= 0; r-- {
+		last := *ijk
+		var center coordIJK
+		if isResClassIII(r + 1) {
+			ijk.upAp7()
+			center = *ijk
+			center.downAp7()
+		} else {
+			ijk.upAp7r()
+			center = *ijk
+			center.downAp7r()
+		}
+		diff := last.sub(center)
+		diff.normalize()
+		h = setDigit(h, r+1, diff.unitDigit())
+	}
+	if ijk.i > maxFaceCoord || ijk.j > maxFaceCoord || ijk.k > maxFaceCoord {
+		return 0
+	}
+
+	// rotate into the orientation of the base cell
+	bcr := faceIjkBaseCells[fijk.face][ijk.i][ijk.j][ijk.k]
+	h |= uint64(bcr.baseCell) << 45
+	if isBaseCellPentagon(bcr.baseCell) {
+		// force the rotation out of the missing k-axes sub-sequence
+		if leadingNonZeroDigit(h) == kAxesDigit {
+			if isBaseCellCwOffset(bcr.baseCell, fijk.face) {
+				h = rotate60cw(h)
+			} else {
+				h = rotate60ccw(h)
+			}
+		}
+		for i := 0; i < bcr.ccwRot60; i++ {
+			h = rotatePent60ccw(h)
+		}
+	} else {
+		for i := 0; i < bcr.ccwRot60; i++ {
+			h = rotate60ccw(h)
+		}
+	}
+	return h
+}
+
+// h3ToFaceIjk returns the face and the ijk+ coordinates of a cell.
+func h3ToFaceIjk(h uint64) faceIJK {
+	bc := getBaseCell(h)
+	// adjust for the pentagonal missing sequence; all of sub-sequence 5 needs
+	// to be adjusted (and some of sub-sequence 4 below)
+	if isBaseCellPentagon(bc) && leadingNonZeroDigit(h) == ikAxesDigit {
+		h = rotate60cw(h)
+	}
+
+	// start with the home face of the base cell
+	fijk := baseCellData[bc].homeFijk
+	res := getResolution(h)
+	possibleOverage := isBaseCellPentagon(bc) ||
+		(res != 0 && fijk.coord != coordIJK{})
+	for r := 1; r <= res; r++ {
+		if isResClassIII(r) {
+			fijk.coord.downAp7()
+		} else {
+			fijk.coord.downAp7r()
+		}
+		fijk.coord.neighbor(getDigit(h, r))
+	}
+	if !possibleOverage {
+		return fijk
+	}
+
+	// the cell may be on an adjacent face
+	orig := fijk.coord
+	adjRes := res
+	if isResClassIII(res) {
+		// drop into the next finer class II grid
+		fijk.coord.downAp7r()
+		adjRes++
+	}
+	pentLeading4 := isBaseCellPentagon(bc) &&
+		leadingNonZeroDigit(h) == iAxesDigit
+	if adjustOverageClassII(&fijk, adjRes, pentLeading4, false) != noOverage {
+		// a pentagon may have secondary overages
+		if isBaseCellPentagon(bc) {
+			for adjustOverageClassII(&fijk, adjRes, false, false) != noOverage {
+			}
+		}
+		if adjRes != res {
+			fijk.coord.upAp7r()
+		}
+	} else if adjRes != res {
+		fijk.coord = orig
+	}
+	return fijk
+}
+
+// geoToHex2d returns the icosahedron face of a point, and its position on
+// the hex grid of the face at a resolution.
+func geoToHex2d(g latLng, res int) (int, vec2d) {
+	// the closest face center
+	r := math.Cos(g.lat)
+	v3 := vec3d{math.Cos(g.lng) * r, math.Sin(g.lng) * r, math.Sin(g.lat)}
+	face, sqd := 0, 5.0
+	for f := 0; f < numIcosaFaces; f++ {
+		c := faceCenterPoint[f]
+		d := (c.x-v3.x)*(c.x-v3.x) + (c.y-v3.y)*(c.y-v3.y) +
+			(c.z-v3.z)*(c.z-v3.z)
+		if d < sqd {
+			face, sqd = f, d
+		}
+	}
+
+	// cos(r) = 1 - 2 * sin^2(r/2) = 1 - 2 * (sqd / 4) = 1 - sqd/2
+	dist := math.Acos(1 - sqd/2)
+	if dist < epsilon {
+		return face, vec2d{}
+	}
+
+	// the ccw theta from the class II i-axis
+	theta := posAngleRads(faceAxesAzRadsCII[face][0] -
+		posAngleRads(geoAzimuthRads(faceCenterGeo[face], g)))
+	if isResClassIII(res) {
+		theta = posAngleRads(theta - ap7RotRads)
+	}
+
+	// gnomonic scaling, for the length of the unit at the resolution
+	dist = math.Tan(dist) / res0UGnomonic
+	for i := 0; i < res; i++ {
+		dist *= sqrt7
+	}
+	return face, vec2d{dist * math.Cos(theta), dist * math.Sin(theta)}
+}
+
+// hex2dToGeo returns the point at a position on the hex grid of a face,
+// which may be a substrate grid of the resolution.
+func hex2dToGeo(v vec2d, face, res int, substrate bool) latLng {
+	r := math.Sqrt(v.x*v.x + v.y*v.y)
+	if r < epsilon {
+		return faceCenterGeo[face]
+	}
+	theta := math.Atan2(v.y, v.x)
+	for i := 0; i < res; i++ {
+		r /= sqrt7
+	}
+	if substrate {
+		r /= 3
+		if isResClassIII(res) {
+			r /= sqrt7
+		}
+	}
+	r = math.Atan(r * res0UGnomonic)
+
+	// a substrate grid is already adjusted for class III
+	if !substrate && isResClassIII(res) {
+		theta = posAngleRads(theta + ap7RotRads)
+	}
+	theta = posAngleRads(faceAxesAzRadsCII[face][0] - theta)
+	return geoAzDistanceRads(faceCenterGeo[face], theta, r)
+}
+
+// faceEdgeVerts returns the edge of a face, on its substrate grid at a class II
+// resolution, in a direction of the faceNeighbors.
+func faceEdgeVerts(dir, adjRes int) (vec2d, vec2d) {
+	maxDim := float64(maxDimByCIIres[adjRes])
+	v0 := vec2d{3 * maxDim, 0}
+	v1 := vec2d{-1.5 * maxDim, 3 * sqrt3_2 * maxDim}
+	v2 := vec2d{-1.5 * maxDim, -3 * sqrt3_2 * maxDim}
+	switch dir {
+	case faceIJ:
+		return v0, v1
+	case faceJK:
+		return v1, v2
+	default:
+		return v2, v0
+	}
+}
+
+// faceIjkToVerts returns the vertices of a cell on the substrate grid,
+// which is at the next class II resolution.
+func faceIjkToVerts(fijk faceIJK, res, n int) ([]faceIJK, int) {
+	// the vertices of a cell at the origin, on the aperture 33r substrate
+	// grid of a class II resolution, or the aperture 33r7r substrate grid of
+	// a class III resolution, ccw from the i-axis
+	vertsCII := [...]coordIJK{
+		{2, 1, 0}, {1, 2, 0}, {0, 2, 1}, {0, 1, 2}, {1, 0, 2}, {2, 0, 1},
+	}
+	vertsCIII := [...]coordIJK{
+		{5, 4, 0}, {1, 5, 0}, {0, 5, 4}, {0, 1, 5}, {4, 0, 5}, {5, 0, 1},
+	}
+	verts := vertsCII[:n]
+	if isResClassIII(res) {
+		verts = vertsCIII[:n]
+	}
+	fijk.coord.downAp3()
+	fijk.coord.downAp3r()
+	if isResClassIII(res) {
+		fijk.coord.downAp7r()
+		res++
+	}
+	fijkVerts := make([]faceIJK, n)
+	for v := range fijkVerts {
+		fijkVerts[v].face = fijk.face
+		fijkVerts[v].coord = fijk.coord.add(verts[v])
+		fijkVerts[v].coord.normalize()
+	}
+	return fijkVerts, res
+}
+
+// faceIjkToCellBoundary returns the vertices of a hexagon. An edge that
+// crosses the edge of an icosahedron face has a vertex at the crossing, for
+// the projections of both faces.
+func faceIjkToCellBoundary(h faceIJK, res int) []latLng {
+	fijkVerts, adjRes := faceIjkToVerts(h, res, numHexVerts)
+	var g []latLng
+	lastFace, lastOverage := -1, noOverage
+	// one more iteration for a crossing on the last edge
+	for vert := 0; vert < numHexVerts+1; vert++ {
+		v := vert % numHexVerts
+		fijk := fijkVerts[v]
+		overage := adjustOverageClassII(&fijk, adjRes, false, true)
+
+		// class II cells have their vertices on the edges of the faces
+		if isResClassIII(res) && vert > 0 && fijk.face != lastFace &&
+			lastOverage != faceEdge {
+			orig0 := fijkVerts[(v+5)%numHexVerts].coord.hex2d()
+			orig1 := fijkVerts[v].coord.hex2d()
+			face2 := lastFace
+			if lastFace == h.face {
+				face2 = fijk.face
+			}
+			edge0, edge1 := faceEdgeVerts(adjacentFaceDir[h.face][face2], adjRes)
+			inter := v2dIntersect(orig0, orig1, edge0, edge1)
+			// a crossing at a vertex needs no vertex
+			if !v2dAlmostEquals(orig0, inter) && !v2dAlmostEquals(orig1, inter) {
+				g = append(g, hex2dToGeo(inter, h.face, adjRes, true))
+			}
+		}
+		if vert < numHexVerts {
+			g = append(g, hex2dToGeo(fijk.coord.hex2d(), fijk.face, adjRes, true))
+		}
+		lastFace, lastOverage = fijk.face, overage
+	}
+	return g
+}
+
+// faceIjkPentToCellBoundary returns the vertices of a pentagon, with the
+// vertices of the crossings of the faces at class III resolutions.
+func faceIjkPentToCellBoundary(h faceIJK, res int) []latLng {
+	fijkVerts, adjRes := faceIjkToVerts(h, res, numPentVerts)
+	var g []latLng
+	var last faceIJK
+	// one more iteration for a crossing on the last edge
+	for vert := 0; vert < numPentVerts+1; vert++ {
+		v := vert % numPentVerts
+		fijk := fijkVerts[v]
+		for adjustOverageClassII(&fijk, adjRes, false, true) == newFace {
+		}
+
+		// all the edges of class III pentagons cross the edges of the faces
+		if isResClassIII(res) && vert > 0 {
+			tmp := fijk
+			orig0 := last.coord.hex2d()
+			orient := faceNeighbors[tmp.face][adjacentFaceDir[tmp.face][last.face]]
+			tmp.face = orient.face
+			for i := 0; i < orient.ccwRot60; i++ {
+				tmp.coord.rotate60ccw()
+			}
+			tmp.coord = tmp.coord.add(
+				orient.translate.scale(unitScaleByCIIres[adjRes] * 3))
+			tmp.coord.normalize()
+			orig1 := tmp.coord.hex2d()
+			edge0, edge1 := faceEdgeVerts(adjacentFaceDir[tmp.face][fijk.face], adjRes)
+			inter := v2dIntersect(orig0, orig1, edge0, edge1)
+			g = append(g, hex2dToGeo(inter, tmp.face, adjRes, true))
+		}
+		if vert < numPentVerts {
+			g = append(g, hex2dToGeo(fijk.coord.hex2d(), fijk.face, adjRes, true))
+		}
+		last = fijk
+	}
+	return g
+}
+
+// adjustOverageClassII moves a cell, at a class II resolution, onto the face
+// that it's on when it's past the edge of its face.
+func adjustOverageClassII(fijk *faceIJK, res int, pentLeading4,
+	substrate bool,
+) int {
+	ijk := &fijk.coord
+	maxDim := maxDimByCIIres[res]
+	if substrate {
+		maxDim *= 3
+	}
+	sum := ijk.i + ijk.j + ijk.k
+	if substrate && sum == maxDim {
+		return faceEdge
+	}
+	if sum <= maxDim {
+		return noOverage
+	}
+	var orient faceOrientIJK
+	if ijk.k > 0 {
+		if ijk.j > 0 {
+			orient = faceNeighbors[fijk.face][faceJK]
+		} else {
+			orient = faceNeighbors[fijk.face][faceKI]
+			// adjust for the pentagonal missing sequence
+			if pentLeading4 {
+				// rotate about the center of the pentagon
+				origin := coordIJK{maxDim, 0, 0}
+				tmp := ijk.sub(origin)
+				tmp.rotate60cw()
+				*ijk = tmp.add(origin)
+			}
+		}
+	} else {
+		orient = faceNeighbors[fijk.face][faceIJ]
+	}
+	fijk.face = orient.face
+
+	// rotate and translate for the adjacent face
+	for i := 0; i < orient.ccwRot60; i++ {
+		ijk.rotate60ccw()
+	}
+	unitScale := unitScaleByCIIres[res]
+	if substrate {
+		unitScale *= 3
+	}
+	*ijk = ijk.add(orient.translate.scale(unitScale))
+	ijk.normalize()
+
+	// the overages of pentagons can end up on the edges
+	if substrate && ijk.i+ijk.j+ijk.k == maxDim {
+		return faceEdge
+	}
+	return newFace
+}
+
+func posAngleRads(rads float64) float64 {
+	tmp := rads
+	if rads < 0 {
+		tmp = rads + 2*math.Pi
+	}
+	if rads >= 2*math.Pi {
+		tmp -= 2 * math.Pi
+	}
+	return tmp
+}
+
+func constrainLng(lng float64) float64 {
+	for lng > math.Pi {
+		lng -= 2 * math.Pi
+	}
+	for lng < -math.Pi {
+		lng += 2 * math.Pi
+	}
+	return lng
+}
+
+// geoAzimuthRads returns the azimuth from one point to another.
+func geoAzimuthRads(p1, p2 latLng) float64 {
+	return math.Atan2(math.Cos(p2.lat)*math.Sin(p2.lng-p1.lng),
+		math.Cos(p1.lat)*math.Sin(p2.lat)-
+			math.Sin(p1.lat)*math.Cos(p2.lat)*math.Cos(p2.lng-p1.lng))
+}
+
+// geoAzDistanceRads returns the point at an azimuth and a distance from
+// another point.
+func geoAzDistanceRads(p1 latLng, az, distance float64) latLng {
+	if distance < epsilon {
+		return p1
+	}
+	var p2 latLng
+	az = posAngleRads(az)
+	if az < epsilon || math.Abs(az-math.Pi) < epsilon {
+		// due north or south
+		if az < epsilon {
+			p2.lat = p1.lat + distance
+		} else {
+			p2.lat = p1.lat - distance
+		}
+		if math.Abs(p2.lat-math.Pi/2) < epsilon {
+			return latLng{math.Pi / 2, 0}
+		}
+		if math.Abs(p2.lat+math.Pi/2) < epsilon {
+			return latLng{-math.Pi / 2, 0}
+		}
+		p2.lng = constrainLng(p1.lng)
+		return p2
+	}
+	sinlat := math.Sin(p1.lat)*math.Cos(distance) +
+		math.Cos(p1.lat)*math.Sin(distance)*math.Cos(az)
+	p2.lat = math.Asin(math.Max(-1, math.Min(1, sinlat)))
+	if math.Abs(p2.lat-math.Pi/2) < epsilon {
+		return latLng{math.Pi / 2, 0}
+	}
+	if math.Abs(p2.lat+math.Pi/2) < epsilon {
+		return latLng{-math.Pi / 2, 0}
+	}
+	sinlng := math.Sin(az) * math.Sin(distance) / math.Cos(p2.lat)
+	coslng := (math.Cos(distance) - math.Sin(p1.lat)*math.Sin(p2.lat)) /
+		math.Cos(p1.lat) / math.Cos(p2.lat)
+	sinlng = math.Max(-1, math.Min(1, sinlng))
+	coslng = math.Max(-1, math.Min(1, coslng))
+	p2.lng = constrainLng(p1.lng + math.Atan2(sinlng, coslng))
+	return p2
+}
+
+// v2dIntersect returns the intersection of the lines of two segments.
+func v2dIntersect(p0, p1, p2, p3 vec2d) vec2d {
+	s1 := vec2d{p1.x - p0.x, p1.y - p0.y}
+	s2 := vec2d{p3.x - p2.x, p3.y - p2.y}
+	t := (s2.x*(p0.y-p2.y) - s2.y*(p0.x-p2.x)) / (-s2.x*s1.y + s1.x*s2.y)
+	return vec2d{p0.x + t*s1.x, p0.y + t*s1.y}
+}
+
+func v2dAlmostEquals(v1, v2 vec2d) bool {
+	const fltEpsilon = 1.1920928955078125e-07
+	return math.Abs(v1.x-v2.x) < fltEpsilon && math.Abs(v1.y-v2.y) < fltEpsilon
+}
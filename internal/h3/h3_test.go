@@ -0,0 +1,116 @@
+package h3
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLatLngToCell(t *testing.T) {
+	// the cells of the H3 library
+	for _, tt := range []struct {
+		lat, lon float64
+		res      int
+		cell     string
+	}{
+		{37.775938728915946, -122.41795063018799, 9, "8928308280fffff"},
+		{33.4484, -112.074, 7, "8729b6d35ffffff"},
+		{-33.8688, 151.2093, 12, "8cbe0e35cbad1ff"},
+		{90, 0, 3, "830326fffffffff"},
+		{-90, 0, 15, "8ff29380e0d0cc4"},
+		{0, 180, 5, "857eb573fffffff"},
+		{1.5, 2.5, 0, "8075fffffffffff"},
+	} {
+		cell := strconv.FormatUint(LatLngToCell(tt.lat, tt.lon, tt.res), 16)
+		if cell != tt.cell {
+			t.Fatalf("%v %v %d: expected %s, got %s", tt.lat, tt.lon, tt.res,
+				tt.cell, cell)
+		}
+	}
+	if LatLngToCell(0, 0, 16) != 0 || LatLngToCell(math.NaN(), 0, 1) != 0 {
+		t.Fatal("expected no cell")
+	}
+}
+
+func TestCellToBoundary(t *testing.T) {
+	// the boundaries of the H3 library, which are a hexagon, a pentagon, and
+	// a class III pentagon that crosses the edges of the faces.
+	for _, tt := range []struct {
+		cell     string
+		boundary [][2]float64
+	}{
+		{"8928308280fffff", [][2]float64{
+			{37.775197782893386, -122.41719971841658},
+			{37.77688044840226, -122.41612835779266},
+			{37.778385004930925, -122.41738797617619},
+			{37.77820687262237, -122.41971895414808},
+			{37.77652420699321, -122.42079024541876},
+			{37.775019673792606, -122.41953062807339},
+		}},
+		{"8009fffffffffff", [][2]float64{
+			{63.095054077525454, -10.444977544778325},
+			{55.706768465152265, 5.523646549290313},
+			{58.4015448703527, 25.082722326707874},
+			{68.92995788193983, 31.83128049908738},
+			{73.31022368544396, 0.32561035194326043},
+		}},
+		{"81083ffffffffff", [][2]float64{
+			{63.3270613280184, 4.012620898449968},
+			{61.89083847532621, 8.644221197607212},
+			{61.5405146000252, 11.080660058482366},
+			{62.88996835796253, 15.771773841154104},
+			{63.800792653212156, 17.535446308408343},
+			{66.32726173342832, 16.433696996747415},
+			{67.35176867523613, 14.81365872582752},
+			{67.46842788455002, 8.130261032188706},
+			{67.01563262841769, 5.239258880169474},
+			{64.5256084219684, 3.699933260287907},
+		}},
+	} {
+		cell, _ := strconv.ParseUint(tt.cell, 16, 64)
+		boundary := CellToBoundary(cell)
+		if len(boundary) != len(tt.boundary) {
+			t.Fatalf("%s: expected %v, got %v", tt.cell, tt.boundary, boundary)
+		}
+		for i, v := range boundary {
+			if math.Abs(v[0]-tt.boundary[i][0]) > 1e-9 ||
+				math.Abs(v[1]-tt.boundary[i][1]) > 1e-9 {
+				t.Fatalf("%s: expected %v, got %v", tt.cell, tt.boundary,
+					boundary)
+			}
+		}
+	}
+	if CellToBoundary(0) != nil || CellToBoundary(1<<63|1<<59) != nil {
+		t.Fatal("expected no boundary")
+	}
+}
+
+func TestCellFuzz(t *testing.T) {
+	// the center of the boundary of the cell of a point is in the same cell
+	rand.Seed(time.Now().UnixNano())
+	for i := 0; i < 10000; i++ {
+		lat := math.Asin(rand.Float64()*2-1) * 180 / math.Pi
+		lon := rand.Float64()*360 - 180
+		res := rand.Intn(maxRes + 1)
+		cell := LatLngToCell(lat, lon, res)
+		boundary := CellToBoundary(cell)
+		if len(boundary) < 5 {
+			t.Fatalf("%v %v %d: expected a boundary, got %v", lat, lon, res,
+				boundary)
+		}
+		var x, y, z float64
+		for _, v := range boundary {
+			la, lo := v[0]*math.Pi/180, v[1]*math.Pi/180
+			x += math.Cos(la) * math.Cos(lo)
+			y += math.Cos(la) * math.Sin(lo)
+			z += math.Sin(la)
+		}
+		clat := math.Atan2(z, math.Hypot(x, y)) * 180 / math.Pi
+		clon := math.Atan2(y, x) * 180 / math.Pi
+		if c := LatLngToCell(clat, clon, res); c != cell {
+			t.Fatalf("%v %v %d: expected %x, got %x", lat, lon, res, cell, c)
+		}
+	}
+}
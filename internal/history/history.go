@@ -0,0 +1,154 @@
+// Package history keeps the recent positions of the objects of a
+// collection, so that the path of an object can be replayed.
+package history
+
+import (
+	"sort"
+	"time"
+)
+
+// Position is where an object was at a point in time.
+type Position struct {
+	X, Y float64
+	Time int64 // unix nano
+}
+
+// Store is the recent positions of each object, ordered by time. It's not
+// safe for concurrent use.
+type Store struct {
+	limit  int           // positions kept per object, zero for no limit
+	window time.Duration // age of the positions kept, zero for no limit
+	tracks map[string][]Position
+	count  int
+}
+
+// New returns a store that keeps the last limit positions of each object,
+// which are no older than window from the newest position of that object.
+// A zero limit or window has no limit.
+func New(limit int, window time.Duration) *Store {
+	return &Store{
+		limit:  limit,
+		window: window,
+		tracks: make(map[string][]Position),
+	}
+}
+
+// Limit returns the number of positions kept per object.
+func (s *Store) Limit() int {
+	return s.limit
+}
+
+// Window returns the age of the positions that are kept, from the newest
+// position of an object.
+func (s *Store) Window() time.Duration {
+	return s.window
+}
+
+// SetLimits changes the positions that are kept, and removes those that
+// are no longer kept.
+func (s *Store) SetLimits(limit int, window time.Duration) {
+	s.limit, s.window = limit, window
+	for id, track := range s.tracks {
+		s.setTrack(id, track)
+	}
+}
+
+// Add adds the position of an object. A position at the same time as one
+// that is already kept replaces it.
+func (s *Store) Add(id string, pos Position) {
+	track := s.tracks[id]
+	i := sort.Search(len(track), func(i int) bool {
+		return track[i].Time >= pos.Time
+	})
+	if i < len(track) && track[i].Time == pos.Time {
+		track[i] = pos
+		return
+	}
+	track = append(track, Position{})
+	copy(track[i+1:], track[i:])
+	track[i] = pos
+	s.count++
+	s.setTrack(id, track)
+}
+
+// setTrack trims the track to the limits and stores it.
+func (s *Store) setTrack(id string, track []Position) {
+	n := len(track)
+	if s.window > 0 && len(track) > 0 {
+		min := track[len(track)-1].Time - int64(s.window)
+		i := sort.Search(len(track), func(i int) bool {
+			return track[i].Time >= min
+		})
+		track = track[i:]
+	}
+	if s.limit > 0 && len(track) > s.limit {
+		track = track[len(track)-s.limit:]
+	}
+	s.count -= n - len(track)
+	if len(track) == 0 {
+		delete(s.tracks, id)
+		return
+	}
+	if cap(track) > len(track)*2 {
+		// release the positions that were trimmed
+		track = append([]Position(nil), track...)
+	}
+	s.tracks[id] = track
+}
+
+// Track returns the positions of an object from min to max, inclusive,
+// ordered by time.
+func (s *Store) Track(id string, min, max int64) []Position {
+	track := s.tracks[id]
+	i := sort.Search(len(track), func(i int) bool {
+		return track[i].Time >= min
+	})
+	j := sort.Search(len(track), func(i int) bool {
+		return track[i].Time > max
+	})
+	if i >= j {
+		return nil
+	}
+	return append([]Position(nil), track[i:j]...)
+}
+
+// Delete removes the positions of an object.
+func (s *Store) Delete(id string) {
+	s.count -= len(s.tracks[id])
+	delete(s.tracks, id)
+}
+
+// Clear removes the positions of every object.
+func (s *Store) Clear() {
+	s.tracks = make(map[string][]Position)
+	s.count = 0
+}
+
+// Len returns the number of objects that have positions.
+func (s *Store) Len() int {
+	return len(s.tracks)
+}
+
+// Count returns the number of positions of every object.
+func (s *Store) Count() int {
+	return s.count
+}
+
+// Scan iterates over the objects, ordered by id, with their positions from
+// min to max. The objects without a position in that range are skipped.
+func (s *Store) Scan(min, max int64, iter func(id string, track []Position) bool) {
+	ids := make([]string, 0, len(s.tracks))
+	for id := range s.tracks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		track := s.Track(id, min, max)
+		if len(track) == 0 {
+			continue
+		}
+		if !iter(id, track) {
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package history
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func times(track []Position) []int64 {
+	var ts []int64
+	for _, pos := range track {
+		ts = append(ts, pos.Time)
+	}
+	return ts
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLimit(t *testing.T) {
+	s := New(3, 0)
+	for _, ts := range []int64{5, 1, 3, 2, 4} {
+		s.Add("a", Position{X: float64(ts), Time: ts})
+	}
+	if ts := times(s.Track("a", 0, math.MaxInt64)); !equal(ts, []int64{3, 4, 5}) {
+		t.Fatalf("expected [3 4 5], got %v", ts)
+	}
+	// same time replaces the position
+	s.Add("a", Position{X: 10, Time: 4})
+	if track := s.Track("a", 4, 4); len(track) != 1 || track[0].X != 10 {
+		t.Fatalf("expected one position at x 10, got %v", track)
+	}
+	if s.Count() != 3 || s.Len() != 1 {
+		t.Fatalf("expected 3 positions of 1 object, got %d of %d",
+			s.Count(), s.Len())
+	}
+	s.SetLimits(1, 0)
+	if ts := times(s.Track("a", 0, math.MaxInt64)); !equal(ts, []int64{5}) {
+		t.Fatalf("expected [5], got %v", ts)
+	}
+	s.Delete("a")
+	if s.Count() != 0 || s.Len() != 0 {
+		t.Fatalf("expected no positions, got %d", s.Count())
+	}
+}
+
+func TestWindow(t *testing.T) {
+	s := New(0, time.Duration(10))
+	for ts := int64(1); ts <= 30; ts++ {
+		s.Add("a", Position{Time: ts})
+	}
+	s.Add("b", Position{Time: 100})
+	if ts := times(s.Track("a", 0, math.MaxInt64)); len(ts) != 11 ||
+		ts[0] != 20 || ts[10] != 30 {
+		t.Fatalf("expected 20 to 30, got %v", ts)
+	}
+	if ts := times(s.Track("a", 25, 27)); !equal(ts, []int64{25, 26, 27}) {
+		t.Fatalf("expected [25 26 27], got %v", ts)
+	}
+	var ids []string
+	s.Scan(50, math.MaxInt64, func(id string, track []Position) bool {
+		ids = append(ids, id)
+		return true
+	})
+	if len(ids) != 1 || ids[0] != "b" {
+		t.Fatalf("expected [b], got %v", ids)
+	}
+	s.Clear()
+	if s.Count() != 0 || s.Len() != 0 {
+		t.Fatalf("expected no positions, got %d", s.Count())
+	}
+}
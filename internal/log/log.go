@@ -1,10 +1,14 @@
 package log
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/term"
@@ -37,105 +41,285 @@ func Output() io.Writer {
 	return wr
 }
 
-func log(level int, tag, color string, formatted bool, format string, args ...interface{}) {
-	if Level < level {
-		return
+// jsonFormat is 1 when the lines are JSON, see SetJSON.
+var jsonFormat int32
+
+// SetJSON sets the format of the lines to JSON, which are objects with the
+// time, level, subsystem, and message, or to text.
+func SetJSON(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&jsonFormat, v)
+}
+
+// JSON returns true when the lines are JSON.
+func JSON() bool {
+	return atomic.LoadInt32(&jsonFormat) == 1
+}
+
+// Logger logs the messages of a subsystem, which may have its own level.
+type Logger struct {
+	name  string
+	level int32 // the level of the subsystem, or -1 to use Level
+}
+
+var subsystems = map[string]*Logger{}
+
+// New returns the logger of a subsystem, which uses Level until its own
+// level is set with SetLevels.
+func New(name string) *Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	if l, ok := subsystems[name]; ok {
+		return l
+	}
+	l := &Logger{name: name, level: -1}
+	subsystems[name] = l
+	return l
+}
+
+// std is the logger of the package functions.
+var std = New("server")
+
+// Level returns the level of the subsystem.
+func (l *Logger) Level() int {
+	if level := atomic.LoadInt32(&l.level); level >= 0 {
+		return int(level)
+	}
+	return Level
+}
+
+// levelNames are the names of the levels of SetLevels.
+var levelNames = []string{"silent", "info", "warn", "debug"}
+
+// ParseLevels parses the levels of the subsystems, which are a comma
+// separated list of subsystem:level, where the level is silent, info, warn,
+// or debug.
+func ParseLevels(s string) (map[string]int, error) {
+	levels := make(map[string]int)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.IndexByte(part, ':')
+		if i == -1 {
+			return nil, fmt.Errorf("invalid subsystem level '%s'", part)
+		}
+		name := strings.ToLower(strings.TrimSpace(part[:i]))
+		mu.Lock()
+		_, ok := subsystems[name]
+		mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown subsystem '%s'", name)
+		}
+		level := -1
+		for j, levelName := range levelNames {
+			if strings.EqualFold(strings.TrimSpace(part[i+1:]), levelName) {
+				level = j
+			}
+		}
+		if level == -1 {
+			return nil, fmt.Errorf("invalid level '%s'", part[i+1:])
+		}
+		levels[name] = level
 	}
-	s := []byte(time.Now().Format("2006/01/02 15:04:05"))
-	s = append(s, ' ')
-	if tty {
-		s = append(s, color...)
+	return levels, nil
+}
+
+// FormatLevels formats the levels of ParseLevels, by subsystem name.
+func FormatLevels(levels map[string]int) string {
+	names := make([]string, 0, len(levels))
+	for name := range levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = name + ":" + levelNames[levels[name]]
+	}
+	return strings.Join(names, ",")
+}
+
+// SetLevels sets the levels of the subsystems. The other subsystems use
+// Level.
+func SetLevels(levels map[string]int) {
+	mu.Lock()
+	defer mu.Unlock()
+	for name, l := range subsystems {
+		level, ok := levels[name]
+		if !ok {
+			level = -1
+		}
+		atomic.StoreInt32(&l.level, int32(level))
 	}
-	s = append(s, '[')
-	s = append(s, tag...)
-	s = append(s, ']')
-	if tty {
-		s = append(s, "\x1b[0m"...)
+}
+
+func (l *Logger) log(level int, tag, color string, formatted bool, format string, args ...interface{}) {
+	if l.Level() < level {
+		return
 	}
-	s = append(s, ' ')
+	var msg string
 	if formatted {
-		s = append(s, fmt.Sprintf(format, args...)...)
+		msg = fmt.Sprintf(format, args...)
 	} else {
-		s = append(s, fmt.Sprint(args...)...)
+		msg = fmt.Sprint(args...)
 	}
-	if s[len(s)-1] != '\n' {
-		s = append(s, '\n')
+	var s []byte
+	if JSON() {
+		s = appendJSON(s, time.Now(), tag, l.name, msg)
+	} else {
+		s = append(s, time.Now().Format("2006/01/02 15:04:05")...)
+		s = append(s, ' ')
+		if tty {
+			s = append(s, color...)
+		}
+		s = append(s, '[')
+		s = append(s, tag...)
+		s = append(s, ']')
+		if tty {
+			s = append(s, "\x1b[0m"...)
+		}
+		s = append(s, ' ')
+		s = append(s, msg...)
+		if s[len(s)-1] != '\n' {
+			s = append(s, '\n')
+		}
 	}
 	mu.Lock()
 	wr.Write(s)
 	mu.Unlock()
 }
 
+// jsonLevels are the levels of the tags of the lines.
+var jsonLevels = map[string]string{
+	"INFO": "info", "HTTP": "info", "ERRO": "error", "WARN": "warn",
+	"DEBU": "debug", "FATA": "fatal",
+}
+
+// appendJSON appends a line that is a JSON object.
+func appendJSON(dst []byte, t time.Time, tag, subsystem, msg string) []byte {
+	dst = append(dst, `{"time":"`...)
+	dst = t.UTC().AppendFormat(dst, "2006-01-02T15:04:05.000Z07:00")
+	dst = append(dst, `","level":"`+jsonLevels[tag]+`","subsystem":`...)
+	dst = appendJSONString(dst, subsystem)
+	if tag == "HTTP" {
+		dst = append(dst, `,"http":true`...)
+	}
+	dst = append(dst, `,"msg":`...)
+	dst = appendJSONString(dst, strings.TrimRight(msg, "\n"))
+	return append(dst, "}\n"...)
+}
+
+func appendJSONString(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] == '\\' || s[i] == '"' || s[i] > 126 {
+			d, _ := json.Marshal(s)
+			return append(dst, d...)
+		}
+	}
+	dst = append(dst, '"')
+	dst = append(dst, s...)
+	return append(dst, '"')
+}
+
 var emptyFormat string
 
+// Infof ...
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(1, "INFO", "\x1b[36m", true, format, args...)
+}
+
+// Info ...
+func (l *Logger) Info(args ...interface{}) {
+	l.log(1, "INFO", "\x1b[36m", false, emptyFormat, args...)
+}
+
+// Errorf ...
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(1, "ERRO", "\x1b[1m\x1b[31m", true, format, args...)
+}
+
+// Error ..
+func (l *Logger) Error(args ...interface{}) {
+	l.log(1, "ERRO", "\x1b[1m\x1b[31m", false, emptyFormat, args...)
+}
+
+// Warnf ...
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(2, "WARN", "\x1b[33m", true, format, args...)
+}
+
+// Warn ...
+func (l *Logger) Warn(args ...interface{}) {
+	l.log(2, "WARN", "\x1b[33m", false, emptyFormat, args...)
+}
+
+// Debugf ...
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(3, "DEBU", "\x1b[35m", true, format, args...)
+}
+
+// Debug ...
+func (l *Logger) Debug(args ...interface{}) {
+	l.log(3, "DEBU", "\x1b[35m", false, emptyFormat, args...)
+}
+
+// Fatalf ...
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(1, "FATA", "\x1b[31m", true, format, args...)
+	os.Exit(1)
+}
+
 // Infof ...
 func Infof(format string, args ...interface{}) {
-	if Level >= 1 {
-		log(1, "INFO", "\x1b[36m", true, format, args...)
-	}
+	std.Infof(format, args...)
 }
 
 // Info ...
 func Info(args ...interface{}) {
-	if Level >= 1 {
-		log(1, "INFO", "\x1b[36m", false, emptyFormat, args...)
-	}
+	std.Info(args...)
 }
 
 // HTTPf ...
 func HTTPf(format string, args ...interface{}) {
-	if Level >= 1 {
-		log(1, "HTTP", "\x1b[1m\x1b[30m", true, format, args...)
-	}
+	std.log(1, "HTTP", "\x1b[1m\x1b[30m", true, format, args...)
 }
 
 // HTTP ...
 func HTTP(args ...interface{}) {
-	if Level >= 1 {
-		log(1, "HTTP", "\x1b[1m\x1b[30m", false, emptyFormat, args...)
-	}
+	std.log(1, "HTTP", "\x1b[1m\x1b[30m", false, emptyFormat, args...)
 }
 
 // Errorf ...
 func Errorf(format string, args ...interface{}) {
-	if Level >= 1 {
-		log(1, "ERRO", "\x1b[1m\x1b[31m", true, format, args...)
-	}
+	std.Errorf(format, args...)
 }
 
 // Error ..
 func Error(args ...interface{}) {
-	if Level >= 1 {
-		log(1, "ERRO", "\x1b[1m\x1b[31m", false, emptyFormat, args...)
-	}
+	std.Error(args...)
 }
 
 // Warnf ...
 func Warnf(format string, args ...interface{}) {
-	if Level >= 2 {
-		log(2, "WARN", "\x1b[33m", true, format, args...)
-	}
+	std.Warnf(format, args...)
 }
 
 // Warn ...
 func Warn(args ...interface{}) {
-	if Level >= 2 {
-		log(2, "WARN", "\x1b[33m", false, emptyFormat, args...)
-	}
+	std.Warn(args...)
 }
 
 // Debugf ...
 func Debugf(format string, args ...interface{}) {
-	if Level >= 3 {
-		log(3, "DEBU", "\x1b[35m", true, format, args...)
-	}
+	std.Debugf(format, args...)
 }
 
 // Debug ...
 func Debug(args ...interface{}) {
-	if Level >= 3 {
-		log(3, "DEBU", "\x1b[35m", false, emptyFormat, args...)
-	}
+	std.Debug(args...)
 }
 
 // Printf ...
@@ -150,12 +334,12 @@ func Print(format string, args ...interface{}) {
 
 // Fatalf ...
 func Fatalf(format string, args ...interface{}) {
-	log(1, "FATA", "\x1b[31m", true, format, args...)
+	std.log(1, "FATA", "\x1b[31m", true, format, args...)
 	os.Exit(1)
 }
 
 // Fatal ...
 func Fatal(args ...interface{}) {
-	log(1, "FATA", "\x1b[31m", false, emptyFormat, args...)
+	std.log(1, "FATA", "\x1b[31m", false, emptyFormat, args...)
 	os.Exit(1)
 }
@@ -16,6 +16,56 @@ func TestLog(t *testing.T) {
 	}
 }
 
+func TestJSON(t *testing.T) {
+	f := &bytes.Buffer{}
+	SetOutput(f)
+	SetJSON(true)
+	defer SetJSON(false)
+	aof := New("aof")
+	aof.Errorf("bad \"%s\"\n", "line")
+	line := f.String()
+	if !strings.HasPrefix(line, `{"time":"`) || !strings.HasSuffix(line,
+		`","level":"error","subsystem":"aof","msg":"bad \"line\""}`+"\n") {
+		t.Fatalf("got %s", line)
+	}
+}
+
+func TestLevels(t *testing.T) {
+	f := &bytes.Buffer{}
+	SetOutput(f)
+	Level = 1
+	repl := New("replication")
+	defer SetLevels(nil)
+	if _, err := ParseLevels("nope:debug"); err == nil {
+		t.Fatal("expected an error for an unknown subsystem")
+	}
+	if _, err := ParseLevels("replication:loud"); err == nil {
+		t.Fatal("expected an error for an unknown level")
+	}
+	levels, err := ParseLevels(" replication:DEBUG, server:silent ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := FormatLevels(levels); s != "replication:debug,server:silent" {
+		t.Fatalf("got %s", s)
+	}
+	SetLevels(levels)
+	repl.Debugf("caught up")
+	Infof("hello")
+	if s := f.String(); !strings.HasSuffix(s, "[DEBU] caught up\n") {
+		t.Fatalf("got %s", s)
+	}
+	// the levels that are not set use Level
+	SetLevels(nil)
+	f.Reset()
+	repl.Debugf("caught up")
+	Infof("hello")
+	if s := f.String(); strings.Contains(s, "caught up") ||
+		!strings.HasSuffix(s, "hello\n") {
+		t.Fatalf("got %s", s)
+	}
+}
+
 func BenchmarkLogPrintf(t *testing.B) {
 	SetOutput(ioutil.Discard)
 	t.ResetTimer()
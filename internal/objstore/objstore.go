@@ -0,0 +1,306 @@
+// Package objstore is a minimal client for S3 compatible object storage,
+// which includes Amazon S3 and the Google Cloud Storage XML API.
+package objstore
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// PartSize is the size of each part of a multipart upload.
+var PartSize = 16 * 1024 * 1024
+
+// Store is a bucket and prefix in object storage.
+type Store struct {
+	scheme   string // s3 or gs
+	bucket   string
+	prefix   string
+	endpoint string // base url of the service
+	region   string
+	signer   *v4.Signer
+	client   *http.Client
+}
+
+// Open returns the store for a url in the form of s3://bucket/prefix or
+// gs://bucket/prefix.
+//
+// Amazon S3 uses the standard AWS credential chain and region. Google Cloud
+// Storage uses HMAC keys from the GCS_ACCESS_KEY_ID and GCS_SECRET_ACCESS_KEY
+// environment variables. The "region" and "endpoint" query parameters may be
+// used for overriding the defaults, such as for an S3 compatible server.
+func Open(rawurl string) (*Store, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid url '%s': missing bucket", rawurl)
+	}
+	s := &Store{
+		scheme: strings.ToLower(u.Scheme),
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+		region: u.Query().Get("region"),
+		client: &http.Client{Timeout: time.Hour},
+	}
+	var creds *credentials.Credentials
+	switch s.scheme {
+	case "s3":
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		creds = sess.Config.Credentials
+		if s.region == "" && sess.Config.Region != nil {
+			s.region = *sess.Config.Region
+		}
+		if s.region == "" {
+			s.region = "us-east-1"
+		}
+		s.endpoint = "https://s3." + s.region + ".amazonaws.com"
+	case "gs":
+		creds = credentials.NewStaticCredentials(
+			os.Getenv("GCS_ACCESS_KEY_ID"), os.Getenv("GCS_SECRET_ACCESS_KEY"), "")
+		if s.region == "" {
+			s.region = "auto"
+		}
+		s.endpoint = "https://storage.googleapis.com"
+	default:
+		return nil, fmt.Errorf("invalid url '%s': scheme must be s3 or gs",
+			rawurl)
+	}
+	if endpoint := u.Query().Get("endpoint"); endpoint != "" {
+		s.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+	s.signer = v4.NewSigner(creds)
+	return s, nil
+}
+
+// URL returns the url of an object in the store.
+func (s *Store) URL(name string) string {
+	return s.scheme + "://" + s.bucket + "/" + s.key(name)
+}
+
+func (s *Store) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+// do sends a request for the object key, which includes the prefix.
+func (s *Store) do(method, key string, query url.Values, body []byte,
+) (*http.Response, error) {
+	// path style requests work for both services and for buckets with dots.
+	u := s.endpoint + "/" + s.bucket + "/" + escapePath(key)
+	if len(query) > 0 {
+		u += "?" + strings.Replace(query.Encode(), "+", "%20", -1)
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	var rd io.ReadSeeker
+	if len(body) > 0 {
+		rd = bytes.NewReader(body)
+	}
+	if _, err := s.signer.Sign(req, rd, "s3", s.region, time.Now()); err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var serr struct {
+			Code    string
+			Message string
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		xml.Unmarshal(data, &serr)
+		if serr.Code == "" {
+			serr.Code = resp.Status
+		}
+		objURL := s.scheme + "://" + s.bucket + "/" + key
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, &NotFoundError{objURL}
+		}
+		return nil, fmt.Errorf("%s %s: %s %s", method, objURL,
+			serr.Code, serr.Message)
+	}
+	return resp, nil
+}
+
+func escapePath(key string) string {
+	parts := strings.Split(key, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+// NotFoundError is returned when an object does not exist.
+type NotFoundError struct {
+	URL string
+}
+
+func (err *NotFoundError) Error() string {
+	return "not found: " + err.URL
+}
+
+// Put uploads a small object.
+func (s *Store) Put(name string, data []byte) error {
+	resp, err := s.do("PUT", s.key(name), nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get downloads an object. The caller must close the reader.
+func (s *Store) Get(name string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", s.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// List returns the names of the objects in the store that start with the
+// name prefix, sorted in ascending order.
+func (s *Store) List(prefix string) ([]string, error) {
+	var names []string
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.key(prefix)}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var res struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range res.Contents {
+			name := c.Key
+			if s.prefix != "" {
+				name = strings.TrimPrefix(name, s.prefix+"/")
+			}
+			names = append(names, name)
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Upload streams the reader to an object. Large objects are sent as a
+// multipart upload, so the size doesn't need to be known upfront.
+func (s *Store) Upload(name string, r io.Reader) error {
+	part := make([]byte, PartSize)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// fits in a single part
+		return s.Put(name, part[:n])
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := s.do("POST", s.key(name), url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var initRes struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initRes)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if initRes.UploadID == "" {
+		return errors.New("missing upload id")
+	}
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+	var parts []completedPart
+	err = func() error {
+		for {
+			query := url.Values{
+				"partNumber": {strconv.Itoa(len(parts) + 1)},
+				"uploadId":   {initRes.UploadID},
+			}
+			resp, err := s.do("PUT", s.key(name), query, part[:n])
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			parts = append(parts, completedPart{
+				PartNumber: len(parts) + 1,
+				ETag:       resp.Header.Get("ETag"),
+			})
+			n, err = io.ReadFull(r, part)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return err
+			}
+		}
+	}()
+	if err == nil {
+		var body []byte
+		body, err = xml.Marshal(struct {
+			XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+			Parts   []completedPart `xml:"Part"`
+		}{Parts: parts})
+		if err == nil {
+			resp, err = s.do("POST", s.key(name),
+				url.Values{"uploadId": {initRes.UploadID}}, body)
+			if err == nil {
+				resp.Body.Close()
+				return nil
+			}
+		}
+	}
+	// abort the upload, otherwise the parts are retained.
+	if resp, err := s.do("DELETE", s.key(name),
+		url.Values{"uploadId": {initRes.UploadID}}, nil); err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package objstore
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is an in-memory server for the subset of the S3 api that's used
+// by the store.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	q := r.URL.Query()
+	body, _ := ioutil.ReadAll(r.Body)
+	switch {
+	case r.Method == "GET" && q.Get("list-type") == "2":
+		var keys []string
+		for key := range f.objects {
+			if strings.HasPrefix(key, "bucket/"+q.Get("prefix")) {
+				keys = append(keys, strings.TrimPrefix(key, "bucket/"))
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "<ListBucketResult>")
+		for _, key := range keys {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", key)
+		}
+		fmt.Fprintf(w, "</ListBucketResult>")
+	case r.Method == "GET":
+		data, ok := f.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Write(data)
+	case r.Method == "POST" && q["uploads"] != nil:
+		id := fmt.Sprint(rand.Int())
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId>"+
+			"</InitiateMultipartUploadResult>", id)
+	case r.Method == "PUT" && q.Get("uploadId") != "":
+		var n int
+		fmt.Sscan(q.Get("partNumber"), &n)
+		f.uploads[q.Get("uploadId")][n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		var complete struct {
+			Part []struct{ PartNumber int }
+		}
+		xml.Unmarshal(body, &complete)
+		var data []byte
+		for _, part := range complete.Part {
+			data = append(data, f.uploads[q.Get("uploadId")][part.PartNumber]...)
+		}
+		f.objects[path] = data
+		delete(f.uploads, q.Get("uploadId"))
+	case r.Method == "PUT":
+		f.objects[path] = body
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestStore(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	fake := &fakeS3{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	s, err := Open("s3://bucket/backups?endpoint=" + ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.URL("a") != "s3://bucket/backups/a" {
+		t.Fatalf("unexpected url '%s'", s.URL("a"))
+	}
+	if err := s.Put("b", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(partSize int) { PartSize = partSize }(PartSize)
+	PartSize = 100
+	big := make([]byte, 250)
+	rand.Read(big)
+	if err := s.Upload("a", bytes.NewReader(big)); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.uploads) != 0 {
+		t.Fatal("expected upload to complete")
+	}
+	rd, err := s.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(rd)
+	rd.Close()
+	if !bytes.Equal(data, big) {
+		t.Fatal("multipart data mismatch")
+	}
+
+	names, err := s.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "a,b" {
+		t.Fatalf("expected 'a,b', got '%s'", strings.Join(names, ","))
+	}
+	_, err = s.Get("c")
+	if _, ok := err.(*NotFoundError); !ok {
+		t.Fatalf("expected not found, got '%v'", err)
+	}
+	if _, err := Open("ftp://bucket"); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,4 @@
+#!/bin/bash
+
+cd $(dirname "${BASH_SOURCE[0]}")
+protoc --go_out=plugins=grpc,import_path=qservice:. *.proto
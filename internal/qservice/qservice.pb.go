@@ -0,0 +1,795 @@
+// Code generated by protoc-gen-go.
+// source: qservice.proto
+// DO NOT EDIT!
+
+/*
+Package qservice is a generated protocol buffer package.
+
+It is generated from these files:
+
+	qservice.proto
+
+It has these top-level messages:
+
+	Point
+	Bounds
+	Field
+	Where
+	SetRequest
+	SetReply
+	GetRequest
+	GetReply
+	NearbyRequest
+	WithinRequest
+	ScanRequest
+	SearchObject
+	SearchReply
+	SubscribeRequest
+	FenceEvent
+	CommandRequest
+	Reply
+*/
+package qservice
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+// A point, with an optional z coordinate
+type Point struct {
+	Lat float64 `protobuf:"fixed64,1,opt,name=lat" json:"lat,omitempty"`
+	Lon float64 `protobuf:"fixed64,2,opt,name=lon" json:"lon,omitempty"`
+	Z   float64 `protobuf:"fixed64,3,opt,name=z" json:"z,omitempty"`
+}
+
+func (m *Point) Reset()                    { *m = Point{} }
+func (m *Point) String() string            { return proto.CompactTextString(m) }
+func (*Point) ProtoMessage()               {}
+func (*Point) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+// A rectangle
+type Bounds struct {
+	MinLat float64 `protobuf:"fixed64,1,opt,name=min_lat,json=minLat" json:"min_lat,omitempty"`
+	MinLon float64 `protobuf:"fixed64,2,opt,name=min_lon,json=minLon" json:"min_lon,omitempty"`
+	MaxLat float64 `protobuf:"fixed64,3,opt,name=max_lat,json=maxLat" json:"max_lat,omitempty"`
+	MaxLon float64 `protobuf:"fixed64,4,opt,name=max_lon,json=maxLon" json:"max_lon,omitempty"`
+}
+
+func (m *Bounds) Reset()                    { *m = Bounds{} }
+func (m *Bounds) String() string            { return proto.CompactTextString(m) }
+func (*Bounds) ProtoMessage()               {}
+func (*Bounds) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+// A field of an object. The value is the text of the FIELD argument, which
+// is a number, a string, true or false, or a json object or array.
+type Field struct {
+	Name  string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *Field) Reset()                    { *m = Field{} }
+func (m *Field) String() string            { return proto.CompactTextString(m) }
+func (*Field) ProtoMessage()               {}
+func (*Field) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+// A WHERE filter of a search, for the values of a field from min to max
+type Where struct {
+	Field string  `protobuf:"bytes,1,opt,name=field" json:"field,omitempty"`
+	Min   float64 `protobuf:"fixed64,2,opt,name=min" json:"min,omitempty"`
+	Max   float64 `protobuf:"fixed64,3,opt,name=max" json:"max,omitempty"`
+}
+
+func (m *Where) Reset()                    { *m = Where{} }
+func (m *Where) String() string            { return proto.CompactTextString(m) }
+func (*Where) ProtoMessage()               {}
+func (*Where) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+// The request of Set, where the object is a point or GeoJSON
+type SetRequest struct {
+	Key    string   `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Id     string   `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Point  *Point   `protobuf:"bytes,3,opt,name=point" json:"point,omitempty"`
+	Object string   `protobuf:"bytes,4,opt,name=object" json:"object,omitempty"`
+	Fields []*Field `protobuf:"bytes,5,rep,name=fields" json:"fields,omitempty"`
+	// seconds until the object expires, or zero for never
+	Ex float64 `protobuf:"fixed64,6,opt,name=ex" json:"ex,omitempty"`
+	// only set the object when it does not exist
+	Nx bool `protobuf:"varint,7,opt,name=nx" json:"nx,omitempty"`
+	// only set the object when it exists
+	Xx bool `protobuf:"varint,8,opt,name=xx" json:"xx,omitempty"`
+}
+
+func (m *SetRequest) Reset()                    { *m = SetRequest{} }
+func (m *SetRequest) String() string            { return proto.CompactTextString(m) }
+func (*SetRequest) ProtoMessage()               {}
+func (*SetRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *SetRequest) GetPoint() *Point {
+	if m != nil {
+		return m.Point
+	}
+	return nil
+}
+
+func (m *SetRequest) GetFields() []*Field {
+	if m != nil {
+		return m.Fields
+	}
+	return nil
+}
+
+// The reply of Set, which is not ok when the object was not set because of
+// NX or XX
+type SetReply struct {
+	Ok bool `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
+}
+
+func (m *SetReply) Reset()                    { *m = SetReply{} }
+func (m *SetReply) String() string            { return proto.CompactTextString(m) }
+func (*SetReply) ProtoMessage()               {}
+func (*SetReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+// The request of Get
+type GetRequest struct {
+	Key        string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Id         string `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	WithFields bool   `protobuf:"varint,3,opt,name=with_fields,json=withFields" json:"with_fields,omitempty"`
+}
+
+func (m *GetRequest) Reset()                    { *m = GetRequest{} }
+func (m *GetRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetRequest) ProtoMessage()               {}
+func (*GetRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+// The reply of Get, where the object is GeoJSON
+type GetReply struct {
+	Object string   `protobuf:"bytes,1,opt,name=object" json:"object,omitempty"`
+	Fields []*Field `protobuf:"bytes,2,rep,name=fields" json:"fields,omitempty"`
+}
+
+func (m *GetReply) Reset()                    { *m = GetReply{} }
+func (m *GetReply) String() string            { return proto.CompactTextString(m) }
+func (*GetReply) ProtoMessage()               {}
+func (*GetReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *GetReply) GetFields() []*Field {
+	if m != nil {
+		return m.Fields
+	}
+	return nil
+}
+
+// The request of Nearby, where a zero radius is no radius
+type NearbyRequest struct {
+	Key    string   `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Point  *Point   `protobuf:"bytes,2,opt,name=point" json:"point,omitempty"`
+	Radius float64  `protobuf:"fixed64,3,opt,name=radius" json:"radius,omitempty"`
+	Cursor uint64   `protobuf:"varint,4,opt,name=cursor" json:"cursor,omitempty"`
+	Limit  uint64   `protobuf:"varint,5,opt,name=limit" json:"limit,omitempty"`
+	Where  []*Where `protobuf:"bytes,6,rep,name=where" json:"where,omitempty"`
+}
+
+func (m *NearbyRequest) Reset()                    { *m = NearbyRequest{} }
+func (m *NearbyRequest) String() string            { return proto.CompactTextString(m) }
+func (*NearbyRequest) ProtoMessage()               {}
+func (*NearbyRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *NearbyRequest) GetPoint() *Point {
+	if m != nil {
+		return m.Point
+	}
+	return nil
+}
+
+func (m *NearbyRequest) GetWhere() []*Where {
+	if m != nil {
+		return m.Where
+	}
+	return nil
+}
+
+// The request of Within, where the area is bounds or GeoJSON
+type WithinRequest struct {
+	Key    string   `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Bounds *Bounds  `protobuf:"bytes,2,opt,name=bounds" json:"bounds,omitempty"`
+	Object string   `protobuf:"bytes,3,opt,name=object" json:"object,omitempty"`
+	Cursor uint64   `protobuf:"varint,4,opt,name=cursor" json:"cursor,omitempty"`
+	Limit  uint64   `protobuf:"varint,5,opt,name=limit" json:"limit,omitempty"`
+	Where  []*Where `protobuf:"bytes,6,rep,name=where" json:"where,omitempty"`
+}
+
+func (m *WithinRequest) Reset()                    { *m = WithinRequest{} }
+func (m *WithinRequest) String() string            { return proto.CompactTextString(m) }
+func (*WithinRequest) ProtoMessage()               {}
+func (*WithinRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *WithinRequest) GetBounds() *Bounds {
+	if m != nil {
+		return m.Bounds
+	}
+	return nil
+}
+
+func (m *WithinRequest) GetWhere() []*Where {
+	if m != nil {
+		return m.Where
+	}
+	return nil
+}
+
+// The request of Scan
+type ScanRequest struct {
+	Key    string   `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Match  string   `protobuf:"bytes,2,opt,name=match" json:"match,omitempty"`
+	Desc   bool     `protobuf:"varint,3,opt,name=desc" json:"desc,omitempty"`
+	Cursor uint64   `protobuf:"varint,4,opt,name=cursor" json:"cursor,omitempty"`
+	Limit  uint64   `protobuf:"varint,5,opt,name=limit" json:"limit,omitempty"`
+	Where  []*Where `protobuf:"bytes,6,rep,name=where" json:"where,omitempty"`
+}
+
+func (m *ScanRequest) Reset()                    { *m = ScanRequest{} }
+func (m *ScanRequest) String() string            { return proto.CompactTextString(m) }
+func (*ScanRequest) ProtoMessage()               {}
+func (*ScanRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *ScanRequest) GetWhere() []*Where {
+	if m != nil {
+		return m.Where
+	}
+	return nil
+}
+
+// An object of a search, where the distance is in meters, and only for
+// Nearby
+type SearchObject struct {
+	Id       string   `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Object   string   `protobuf:"bytes,2,opt,name=object" json:"object,omitempty"`
+	Fields   []*Field `protobuf:"bytes,3,rep,name=fields" json:"fields,omitempty"`
+	Distance float64  `protobuf:"fixed64,4,opt,name=distance" json:"distance,omitempty"`
+}
+
+func (m *SearchObject) Reset()                    { *m = SearchObject{} }
+func (m *SearchObject) String() string            { return proto.CompactTextString(m) }
+func (*SearchObject) ProtoMessage()               {}
+func (*SearchObject) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *SearchObject) GetFields() []*Field {
+	if m != nil {
+		return m.Fields
+	}
+	return nil
+}
+
+// The reply of a search, where the cursor is the start of the next page, or
+// zero for the last page
+type SearchReply struct {
+	Objects []*SearchObject `protobuf:"bytes,1,rep,name=objects" json:"objects,omitempty"`
+	Count   uint64          `protobuf:"varint,2,opt,name=count" json:"count,omitempty"`
+	Cursor  uint64          `protobuf:"varint,3,opt,name=cursor" json:"cursor,omitempty"`
+}
+
+func (m *SearchReply) Reset()                    { *m = SearchReply{} }
+func (m *SearchReply) String() string            { return proto.CompactTextString(m) }
+func (*SearchReply) ProtoMessage()               {}
+func (*SearchReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *SearchReply) GetObjects() []*SearchObject {
+	if m != nil {
+		return m.Objects
+	}
+	return nil
+}
+
+// The request of Subscribe, where the channels are patterns when pattern
+// is true
+type SubscribeRequest struct {
+	Channels []string `protobuf:"bytes,1,rep,name=channels" json:"channels,omitempty"`
+	Pattern  bool     `protobuf:"varint,2,opt,name=pattern" json:"pattern,omitempty"`
+}
+
+func (m *SubscribeRequest) Reset()                    { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string            { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()               {}
+func (*SubscribeRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+// An event of a geofence channel. The json is the message of the event.
+type FenceEvent struct {
+	Channel string `protobuf:"bytes,1,opt,name=channel" json:"channel,omitempty"`
+	Command string `protobuf:"bytes,2,opt,name=command" json:"command,omitempty"`
+	Detect  string `protobuf:"bytes,3,opt,name=detect" json:"detect,omitempty"`
+	Key     string `protobuf:"bytes,4,opt,name=key" json:"key,omitempty"`
+	Id      string `protobuf:"bytes,5,opt,name=id" json:"id,omitempty"`
+	Time    string `protobuf:"bytes,6,opt,name=time" json:"time,omitempty"`
+	Object  string `protobuf:"bytes,7,opt,name=object" json:"object,omitempty"`
+	Json    string `protobuf:"bytes,8,opt,name=json" json:"json,omitempty"`
+}
+
+func (m *FenceEvent) Reset()                    { *m = FenceEvent{} }
+func (m *FenceEvent) String() string            { return proto.CompactTextString(m) }
+func (*FenceEvent) ProtoMessage()               {}
+func (*FenceEvent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+// The request of Command, where the args are the command and its arguments
+type CommandRequest struct {
+	Args []string `protobuf:"bytes,1,rep,name=args" json:"args,omitempty"`
+}
+
+func (m *CommandRequest) Reset()                    { *m = CommandRequest{} }
+func (m *CommandRequest) String() string            { return proto.CompactTextString(m) }
+func (*CommandRequest) ProtoMessage()               {}
+func (*CommandRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+// The reply of a command, which is the reply of the connections with OUTPUT
+// protobuf. The object is the object of GET, and the objects, ids, count,
+// and cursor are a search. The point and bounds are GET POINT and GET
+// BOUNDS, and the event is a geofence event. The json is the json reply of
+// the other commands.
+type Reply struct {
+	Ok      bool            `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
+	Err     string          `protobuf:"bytes,2,opt,name=err" json:"err,omitempty"`
+	Elapsed string          `protobuf:"bytes,3,opt,name=elapsed" json:"elapsed,omitempty"`
+	Object  *SearchObject   `protobuf:"bytes,4,opt,name=object" json:"object,omitempty"`
+	Objects []*SearchObject `protobuf:"bytes,5,rep,name=objects" json:"objects,omitempty"`
+	Ids     []string        `protobuf:"bytes,6,rep,name=ids" json:"ids,omitempty"`
+	Count   uint64          `protobuf:"varint,7,opt,name=count" json:"count,omitempty"`
+	Cursor  uint64          `protobuf:"varint,8,opt,name=cursor" json:"cursor,omitempty"`
+	Point   *Point          `protobuf:"bytes,9,opt,name=point" json:"point,omitempty"`
+	Bounds  *Bounds         `protobuf:"bytes,10,opt,name=bounds" json:"bounds,omitempty"`
+	Event   *FenceEvent     `protobuf:"bytes,11,opt,name=event" json:"event,omitempty"`
+	Json    string          `protobuf:"bytes,12,opt,name=json" json:"json,omitempty"`
+}
+
+func (m *Reply) Reset()                    { *m = Reply{} }
+func (m *Reply) String() string            { return proto.CompactTextString(m) }
+func (*Reply) ProtoMessage()               {}
+func (*Reply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+func (m *Reply) GetObject() *SearchObject {
+	if m != nil {
+		return m.Object
+	}
+	return nil
+}
+
+func (m *Reply) GetObjects() []*SearchObject {
+	if m != nil {
+		return m.Objects
+	}
+	return nil
+}
+
+func (m *Reply) GetPoint() *Point {
+	if m != nil {
+		return m.Point
+	}
+	return nil
+}
+
+func (m *Reply) GetBounds() *Bounds {
+	if m != nil {
+		return m.Bounds
+	}
+	return nil
+}
+
+func (m *Reply) GetEvent() *FenceEvent {
+	if m != nil {
+		return m.Event
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Point)(nil), "qservice.Point")
+	proto.RegisterType((*Bounds)(nil), "qservice.Bounds")
+	proto.RegisterType((*Field)(nil), "qservice.Field")
+	proto.RegisterType((*Where)(nil), "qservice.Where")
+	proto.RegisterType((*SetRequest)(nil), "qservice.SetRequest")
+	proto.RegisterType((*SetReply)(nil), "qservice.SetReply")
+	proto.RegisterType((*GetRequest)(nil), "qservice.GetRequest")
+	proto.RegisterType((*GetReply)(nil), "qservice.GetReply")
+	proto.RegisterType((*NearbyRequest)(nil), "qservice.NearbyRequest")
+	proto.RegisterType((*WithinRequest)(nil), "qservice.WithinRequest")
+	proto.RegisterType((*ScanRequest)(nil), "qservice.ScanRequest")
+	proto.RegisterType((*SearchObject)(nil), "qservice.SearchObject")
+	proto.RegisterType((*SearchReply)(nil), "qservice.SearchReply")
+	proto.RegisterType((*SubscribeRequest)(nil), "qservice.SubscribeRequest")
+	proto.RegisterType((*FenceEvent)(nil), "qservice.FenceEvent")
+	proto.RegisterType((*CommandRequest)(nil), "qservice.CommandRequest")
+	proto.RegisterType((*Reply)(nil), "qservice.Reply")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion3
+
+// Client API for QueryService service
+
+type QueryServiceClient interface {
+	// Sets an object, like SET
+	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetReply, error)
+	// Gets an object, like GET
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetReply, error)
+	// Searches for the objects near a point, like NEARBY
+	Nearby(ctx context.Context, in *NearbyRequest, opts ...grpc.CallOption) (*SearchReply, error)
+	// Searches for the objects within an area, like WITHIN
+	Within(ctx context.Context, in *WithinRequest, opts ...grpc.CallOption) (*SearchReply, error)
+	// Iterates the objects of a collection, like SCAN
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*SearchReply, error)
+	// Streams the events of the geofence channels, like SUBSCRIBE and
+	// PSUBSCRIBE
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (QueryService_SubscribeClient, error)
+	// Runs a command, and returns its reply like OUTPUT protobuf
+	Command(ctx context.Context, in *CommandRequest, opts ...grpc.CallOption) (*Reply, error)
+}
+
+type queryServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewQueryServiceClient(cc *grpc.ClientConn) QueryServiceClient {
+	return &queryServiceClient{cc}
+}
+
+func (c *queryServiceClient) Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetReply, error) {
+	out := new(SetReply)
+	err := grpc.Invoke(ctx, "/qservice.QueryService/Set", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queryServiceClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetReply, error) {
+	out := new(GetReply)
+	err := grpc.Invoke(ctx, "/qservice.QueryService/Get", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queryServiceClient) Nearby(ctx context.Context, in *NearbyRequest, opts ...grpc.CallOption) (*SearchReply, error) {
+	out := new(SearchReply)
+	err := grpc.Invoke(ctx, "/qservice.QueryService/Nearby", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queryServiceClient) Within(ctx context.Context, in *WithinRequest, opts ...grpc.CallOption) (*SearchReply, error) {
+	out := new(SearchReply)
+	err := grpc.Invoke(ctx, "/qservice.QueryService/Within", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queryServiceClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*SearchReply, error) {
+	out := new(SearchReply)
+	err := grpc.Invoke(ctx, "/qservice.QueryService/Scan", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queryServiceClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (QueryService_SubscribeClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_QueryService_serviceDesc.Streams[0], c.cc, "/qservice.QueryService/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &queryServiceSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type QueryService_SubscribeClient interface {
+	Recv() (*FenceEvent, error)
+	grpc.ClientStream
+}
+
+type queryServiceSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *queryServiceSubscribeClient) Recv() (*FenceEvent, error) {
+	m := new(FenceEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *queryServiceClient) Command(ctx context.Context, in *CommandRequest, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := grpc.Invoke(ctx, "/qservice.QueryService/Command", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for QueryService service
+
+type QueryServiceServer interface {
+	// Sets an object, like SET
+	Set(context.Context, *SetRequest) (*SetReply, error)
+	// Gets an object, like GET
+	Get(context.Context, *GetRequest) (*GetReply, error)
+	// Searches for the objects near a point, like NEARBY
+	Nearby(context.Context, *NearbyRequest) (*SearchReply, error)
+	// Searches for the objects within an area, like WITHIN
+	Within(context.Context, *WithinRequest) (*SearchReply, error)
+	// Iterates the objects of a collection, like SCAN
+	Scan(context.Context, *ScanRequest) (*SearchReply, error)
+	// Streams the events of the geofence channels, like SUBSCRIBE and
+	// PSUBSCRIBE
+	Subscribe(*SubscribeRequest, QueryService_SubscribeServer) error
+	// Runs a command, and returns its reply like OUTPUT protobuf
+	Command(context.Context, *CommandRequest) (*Reply, error)
+}
+
+func RegisterQueryServiceServer(s *grpc.Server, srv QueryServiceServer) {
+	s.RegisterService(&_QueryService_serviceDesc, srv)
+}
+
+func _QueryService_Set_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryServiceServer).Set(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/qservice.QueryService/Set",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryServiceServer).Set(ctx, req.(*SetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _QueryService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/qservice.QueryService/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryServiceServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _QueryService_Nearby_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NearbyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryServiceServer).Nearby(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/qservice.QueryService/Nearby",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryServiceServer).Nearby(ctx, req.(*NearbyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _QueryService_Within_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WithinRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryServiceServer).Within(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/qservice.QueryService/Within",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryServiceServer).Within(ctx, req.(*WithinRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _QueryService_Scan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryServiceServer).Scan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/qservice.QueryService/Scan",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryServiceServer).Scan(ctx, req.(*ScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _QueryService_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(QueryServiceServer).Subscribe(m, &queryServiceSubscribeServer{stream})
+}
+
+type QueryService_SubscribeServer interface {
+	Send(*FenceEvent) error
+	grpc.ServerStream
+}
+
+type queryServiceSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *queryServiceSubscribeServer) Send(m *FenceEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _QueryService_Command_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommandRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryServiceServer).Command(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/qservice.QueryService/Command",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryServiceServer).Command(ctx, req.(*CommandRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _QueryService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "qservice.QueryService",
+	HandlerType: (*QueryServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Set",
+			Handler:    _QueryService_Set_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _QueryService_Get_Handler,
+		},
+		{
+			MethodName: "Nearby",
+			Handler:    _QueryService_Nearby_Handler,
+		},
+		{
+			MethodName: "Within",
+			Handler:    _QueryService_Within_Handler,
+		},
+		{
+			MethodName: "Scan",
+			Handler:    _QueryService_Scan_Handler,
+		},
+		{
+			MethodName: "Command",
+			Handler:    _QueryService_Command_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _QueryService_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: fileDescriptor0,
+}
+
+func init() { proto.RegisterFile("qservice.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 940 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x56, 0xdd, 0x6e, 0xdc, 0x44,
+	0x14, 0xae, 0xed, 0xb5, 0xd7, 0x3e, 0x49, 0x43, 0x18, 0x42, 0x6b, 0xed, 0x0d, 0x91, 0x05, 0x22,
+	0xea, 0x45, 0xd4, 0x1f, 0xa9, 0x02, 0xee, 0x1a, 0x44, 0x17, 0x09, 0x44, 0xc3, 0xec, 0x45, 0x2f,
+	0xab, 0x59, 0x7b, 0x60, 0xa7, 0xb1, 0xc7, 0x5b, 0x7b, 0x36, 0x75, 0xca, 0xbb, 0xc0, 0x53, 0x20,
+	0x21, 0x1e, 0x80, 0x47, 0xe2, 0x1a, 0xcd, 0x99, 0xf1, 0xdf, 0x2a, 0x9b, 0xd0, 0x8b, 0xde, 0x9d,
+	0x6f, 0xce, 0x9c, 0x39, 0x7f, 0xdf, 0x39, 0x36, 0x1c, 0xbc, 0xa9, 0x79, 0x75, 0x29, 0x52, 0x7e,
+	0xba, 0xae, 0x4a, 0x55, 0x92, 0xb0, 0xc5, 0xc9, 0xd7, 0xe0, 0x9f, 0x97, 0x42, 0x2a, 0x72, 0x08,
+	0x5e, 0xce, 0x54, 0xec, 0x1c, 0x3b, 0x27, 0x0e, 0xd5, 0x22, 0x9e, 0x94, 0x32, 0x76, 0xed, 0x49,
+	0x29, 0xc9, 0x3e, 0x38, 0xef, 0x62, 0x0f, 0xb1, 0xf3, 0x2e, 0xc9, 0x21, 0x38, 0x2b, 0x37, 0x32,
+	0xab, 0xc9, 0x7d, 0x98, 0x16, 0x42, 0xbe, 0xea, 0xed, 0x83, 0x42, 0xc8, 0x1f, 0x99, 0xea, 0x14,
+	0xdd, 0x33, 0xa8, 0x28, 0x25, 0x2a, 0x58, 0x83, 0x16, 0x9e, 0x55, 0xb0, 0xa6, 0xb5, 0xd0, 0x8a,
+	0x52, 0xc6, 0x93, 0x5e, 0x51, 0xca, 0xe4, 0x11, 0xf8, 0xcf, 0x05, 0xcf, 0x33, 0x42, 0x60, 0x22,
+	0x59, 0xc1, 0xd1, 0x53, 0x44, 0x51, 0x26, 0x47, 0xe0, 0x5f, 0xb2, 0x7c, 0xc3, 0xd1, 0x4b, 0x44,
+	0x0d, 0x48, 0x9e, 0x81, 0xff, 0x72, 0xc5, 0x2b, 0x54, 0xff, 0xa2, 0x6d, 0xad, 0x8d, 0x01, 0x3a,
+	0xbf, 0x42, 0x74, 0xf9, 0x15, 0x42, 0xe2, 0x09, 0x6b, 0x6c, 0x44, 0x5a, 0x4c, 0xfe, 0x71, 0x00,
+	0x16, 0x5c, 0x51, 0xfe, 0x66, 0xc3, 0x6b, 0x2c, 0xc9, 0x05, 0xbf, 0xb2, 0xcf, 0x68, 0x91, 0x1c,
+	0x80, 0x2b, 0x32, 0xeb, 0xd6, 0x15, 0x19, 0xf9, 0x02, 0xfc, 0xb5, 0xae, 0x27, 0x3e, 0xb2, 0xf7,
+	0xf8, 0xa3, 0xd3, 0xae, 0xf2, 0x58, 0x66, 0x6a, 0xb4, 0xe4, 0x1e, 0x04, 0xe5, 0xf2, 0x35, 0x4f,
+	0x15, 0x66, 0x19, 0x51, 0x8b, 0xc8, 0x97, 0x10, 0x60, 0x70, 0x75, 0xec, 0x1f, 0x7b, 0x63, 0x7b,
+	0xcc, 0x9e, 0x5a, 0xb5, 0xf6, 0xcb, 0x9b, 0x38, 0xc0, 0x48, 0x5d, 0xde, 0x68, 0x2c, 0x9b, 0x78,
+	0x7a, 0xec, 0x9c, 0x84, 0xd4, 0x95, 0x88, 0x9b, 0x26, 0x0e, 0x0d, 0x6e, 0x9a, 0x64, 0x06, 0x21,
+	0xe6, 0xb1, 0xce, 0x31, 0xe6, 0xf2, 0x02, 0x93, 0x08, 0xa9, 0x5b, 0x5e, 0x24, 0x2f, 0x00, 0xe6,
+	0xef, 0x93, 0xe3, 0x67, 0xb0, 0xf7, 0x56, 0xa8, 0xd5, 0x2b, 0x1b, 0xa9, 0x87, 0x0f, 0x81, 0x3e,
+	0xc2, 0x18, 0xeb, 0xe4, 0x07, 0x08, 0xe7, 0xad, 0xb3, 0x3e, 0x53, 0x67, 0x47, 0xa6, 0xee, 0x8d,
+	0x99, 0x26, 0x7f, 0x3a, 0x70, 0xf7, 0x27, 0xce, 0xaa, 0xe5, 0xd5, 0xee, 0x08, 0xbb, 0xaa, 0xbb,
+	0xb7, 0x55, 0xbd, 0x62, 0x99, 0xd8, 0xd4, 0x2d, 0xe9, 0x0c, 0xd2, 0xe7, 0xe9, 0xa6, 0xaa, 0xcb,
+	0x0a, 0xbb, 0x31, 0xa1, 0x16, 0x69, 0xde, 0xe4, 0xa2, 0x10, 0x2a, 0xf6, 0xf1, 0xd8, 0x00, 0xed,
+	0xec, 0xad, 0xa6, 0x55, 0x1c, 0x6c, 0x07, 0x8e, 0x6c, 0xa3, 0x46, 0x9b, 0xfc, 0xe5, 0xc0, 0xdd,
+	0x97, 0x42, 0xad, 0x84, 0xdc, 0x1d, 0xf7, 0x09, 0x04, 0x4b, 0x1c, 0x21, 0x1b, 0xf8, 0x61, 0xff,
+	0x96, 0x19, 0x2d, 0x6a, 0xf5, 0x83, 0x32, 0x7a, 0xa3, 0x32, 0x7e, 0x90, 0xd0, 0x7f, 0x77, 0x60,
+	0x6f, 0x91, 0xb2, 0x1b, 0x02, 0x3f, 0x02, 0xbf, 0x60, 0x2a, 0x5d, 0xb5, 0x03, 0x87, 0x40, 0x8f,
+	0x66, 0xc6, 0xeb, 0xd4, 0x32, 0x02, 0xe5, 0x0f, 0x13, 0xe0, 0x6f, 0xb0, 0xbf, 0xe0, 0xac, 0x4a,
+	0x57, 0x2f, 0x4c, 0x15, 0x0c, 0x43, 0x9d, 0x8e, 0xa1, 0x7d, 0xb5, 0xdc, 0x1d, 0xa4, 0xf3, 0x6e,
+	0x1e, 0xaf, 0x19, 0x84, 0x99, 0xa8, 0x15, 0x93, 0x29, 0xb7, 0x7b, 0xa8, 0xc3, 0x49, 0x01, 0x7b,
+	0xc6, 0xb9, 0x21, 0xf8, 0x43, 0x98, 0x9a, 0xd7, 0xeb, 0xd8, 0xc1, 0x47, 0xef, 0xf5, 0x8f, 0x0e,
+	0x83, 0xa4, 0xed, 0x35, 0x9d, 0x7a, 0x5a, 0x6e, 0x2c, 0x5b, 0x27, 0xd4, 0x80, 0x41, 0xa1, 0xbc,
+	0x61, 0xa1, 0x92, 0xef, 0xe1, 0x70, 0xb1, 0x59, 0xd6, 0x69, 0x25, 0x96, 0xbc, 0x6d, 0xc8, 0x0c,
+	0xc2, 0x74, 0xc5, 0xa4, 0xe4, 0xb9, 0x71, 0x1a, 0xd1, 0x0e, 0x93, 0x18, 0xa6, 0x6b, 0xa6, 0x14,
+	0xaf, 0xcc, 0x6a, 0x0b, 0x69, 0x0b, 0x93, 0xbf, 0x1d, 0x80, 0xe7, 0x5c, 0xa6, 0xfc, 0xbb, 0x4b,
+	0x2e, 0x95, 0xbe, 0x68, 0x8d, 0x6c, 0xe5, 0x5a, 0x88, 0x9a, 0xb2, 0x28, 0x98, 0x6c, 0xa7, 0xbe,
+	0x85, 0x3a, 0xc8, 0x8c, 0xab, 0x01, 0x0d, 0x0d, 0x6a, 0x19, 0x32, 0xd9, 0x5e, 0x1a, 0x7e, 0xd7,
+	0x12, 0x02, 0x13, 0x25, 0x0a, 0x8e, 0x2b, 0x2b, 0xa2, 0x28, 0x0f, 0xda, 0x34, 0x1d, 0xb5, 0x89,
+	0xc0, 0xe4, 0x75, 0x5d, 0x4a, 0x5c, 0x5f, 0x11, 0x45, 0x39, 0xf9, 0x1c, 0x0e, 0xbe, 0x35, 0x41,
+	0xb4, 0x45, 0x20, 0x30, 0x61, 0xd5, 0xaf, 0x6d, 0x01, 0x50, 0x4e, 0xfe, 0x75, 0xc1, 0xbf, 0x76,
+	0xc9, 0xe9, 0x08, 0x79, 0x55, 0xd9, 0x7c, 0xb4, 0xa8, 0xb3, 0xe4, 0x39, 0x5b, 0xd7, 0x3c, 0xb3,
+	0xc9, 0xb4, 0x90, 0x9c, 0x8e, 0xb6, 0xf3, 0xee, 0x8e, 0xb6, 0xf1, 0x0e, 0x28, 0xe0, 0xff, 0x3f,
+	0x0a, 0x1c, 0x82, 0x27, 0xb2, 0x1a, 0x59, 0x1e, 0x51, 0x2d, 0xf6, 0xa4, 0x98, 0x5e, 0x4f, 0x8a,
+	0x70, 0x34, 0x3d, 0xdd, 0xc2, 0x8b, 0x6e, 0x5c, 0x78, 0xfd, 0x7e, 0x81, 0x5b, 0xf6, 0xcb, 0x03,
+	0xf0, 0xb9, 0x66, 0x45, 0xbc, 0x87, 0x17, 0x8f, 0x06, 0x83, 0xd1, 0x31, 0x86, 0x9a, 0x2b, 0x5d,
+	0x7b, 0xf6, 0xfb, 0xf6, 0x3c, 0xfe, 0xc3, 0x83, 0xfd, 0x9f, 0x37, 0xbc, 0xba, 0x5a, 0x18, 0x33,
+	0xf2, 0x08, 0xbc, 0x05, 0x57, 0xe4, 0x68, 0x58, 0x89, 0xf6, 0x1b, 0x33, 0x23, 0x5b, 0xa7, 0xeb,
+	0xfc, 0x2a, 0xb9, 0xa3, 0x4d, 0xe6, 0x63, 0x93, 0xf9, 0xb5, 0x26, 0xf3, 0xde, 0xe4, 0x1b, 0x08,
+	0xcc, 0xb7, 0x81, 0xdc, 0xef, 0xf5, 0xa3, 0xaf, 0xc5, 0xec, 0xd3, 0xed, 0x5e, 0x0c, 0x6c, 0xcd,
+	0x7e, 0x1e, 0xda, 0x8e, 0x36, 0xf6, 0x6e, 0xdb, 0xa7, 0x30, 0xd1, 0x0b, 0x92, 0x0c, 0x2f, 0xa4,
+	0xec, 0x76, 0xbb, 0x67, 0x10, 0x75, 0xc3, 0x4c, 0x66, 0x83, 0x5b, 0x5b, 0x13, 0x3e, 0xbb, 0xb6,
+	0x01, 0xc9, 0x9d, 0x87, 0x0e, 0x79, 0x0a, 0x53, 0x3b, 0x08, 0x24, 0xee, 0x2f, 0x8d, 0x67, 0x63,
+	0x36, 0x20, 0x84, 0x75, 0x7d, 0xf6, 0x00, 0x3e, 0x49, 0xcb, 0xe2, 0x54, 0x89, 0x9c, 0x3f, 0xf9,
+	0xaa, 0x53, 0x9f, 0x7d, 0x3c, 0xec, 0xda, 0xb9, 0xfe, 0x3b, 0x3c, 0x77, 0x96, 0x01, 0xfe, 0x26,
+	0x3e, 0xf9, 0x6f, 0x00, 0x93, 0x1a, 0xd2, 0xe3, 0x38, 0x0a, 0x00, 0x00,
+}
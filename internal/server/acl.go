@@ -0,0 +1,1004 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/core"
+	"github.com/tidwall/tile38/internal/glob"
+)
+
+// aclDefaultUser is the user of the clients that authenticate with the
+// requirepass, or that don't authenticate when there's no requirepass. The
+// default user may run every command.
+const aclDefaultUser = "default"
+
+// aclUser is a user of the ACL, which authenticates with AUTH username
+// password, or with a client certificate of the TLS port. The rules of the
+// commands are applied in order, so that a later rule overrides an earlier
+// one, such as +@read -jget.
+type aclUser struct {
+	Name      string   `json:"name"`
+	On        bool     `json:"on,omitempty"`
+	NoPass    bool     `json:"nopass,omitempty"`
+	Passwords []string `json:"passwords,omitempty"` // sha256 hex digests
+	Commands  []string `json:"commands,omitempty"`  // +cmd, -cmd, +@cat, -@cat
+	Keys      []string `json:"keys,omitempty"`      // patterns of the keys
+	Certs     []string `json:"certs,omitempty"`     // patterns of the certs
+	Namespace string   `json:"namespace,omitempty"` // the namespace of the user
+}
+
+// aclToken is a password of the default user that is accepted along with
+// the requirepass, so that the requirepass can be changed without a restart
+// of every client. The token expires at a unix time, or never when zero.
+type aclToken struct {
+	Hash    string `json:"hash"` // sha256 hex digest
+	Expires int64  `json:"expires,omitempty"`
+}
+
+func (t aclToken) expired(now time.Time) bool {
+	return t.Expires != 0 && now.Unix() >= t.Expires
+}
+
+// acl is the users and the tokens of the ACL, which are persisted in the
+// config.
+type acl struct {
+	mu     sync.RWMutex
+	users  map[string]*aclUser
+	tokens []aclToken
+}
+
+// aclCategories are the commands of the read, write, and admin categories.
+// The other categories are the groups of the commands, such as @search or
+// @webhook, and @all is every command.
+var aclCategories = map[string][]string{
+	"read": {"get", "keys", "scan", "nearby", "within", "intersects",
+		"hooks", "chans", "search", "ttl", "bounds", "type", "exists", "jget", "evalro",
+		"evalrosha", "fcall_ro", "fencetest", "schema", "limits", "indexes", "geoindex", "matrix",
+		"tile", "history", "trajectory", "passed", "memory", "triggers",
+		"labels", "stats", "export", "graphql", "subscribe", "psubscribe"},
+	"write": {"set", "del", "drop", "fset", "fincrby", "flushdb", "setchan",
+		"pdelchan", "delchan", "renamechan", "pausechan", "ppausechan",
+		"resumechan", "presumechan", "sethook", "pdelhook", "delhook",
+		"renamehook", "pausehook", "ppausehook", "resumehook", "presumehook",
+		"setschema", "delschema", "setlimits", "dellimits", "setindex", "delindex", "setgeoindex", "sethistory",
+		"delhistory", "expire", "persist", "jset", "jdel", "pdel", "pset",
+		"rename", "renamenx", "copy", "settrigger", "deltrigger", "setlabel",
+		"dellabel", "import", "eval", "evalsha", "evalna", "evalnasha",
+		"fcall", "publish"},
+	"admin": {"acl", "namespace", "config", "server", "info", "metrics", "slowlog",
+		"latency", "gc", "readonly", "save", "bgsave", "backup", "restore", "dump", "hookreplay",
+		"aof", "aofmd5", "aofshrink", "reindex", "aofcheck", "follow", "slaveof", "replconf", "peer",
+		"raft", "cluster", "migrate", "replica", "wait", "waitaof",
+		"waitoffset", "lww", "client", "monitor", "shutdown", "massinsert",
+		"sleep"},
+}
+
+// aclCommands are the commands of each category.
+var aclCommands = func() map[string]map[string]bool {
+	cats := map[string]map[string]bool{"all": {}}
+	add := func(cat, command string) {
+		if cats[cat] == nil {
+			cats[cat] = map[string]bool{}
+		}
+		cats[cat][command] = true
+		cats["all"][command] = true
+	}
+	for cat, commands := range aclCategories {
+		for _, command := range commands {
+			add(cat, command)
+		}
+	}
+	for name, c := range core.Commands {
+		if c.Group != "" && c.Group != "tests" {
+			// subcommands, such as CONFIG GET, are in the category of
+			// their command
+			add(c.Group, strings.ToLower(strings.Fields(name)[0]))
+		}
+	}
+	return cats
+}()
+
+// aclAlwaysAllowed returns true for the commands that every user may run,
+// which are for the connection.
+func aclAlwaysAllowed(command string) bool {
+	switch command {
+	case "auth", "hello", "quit", "ping", "echo", "output", "health":
+		return true
+	}
+	return false
+}
+
+// aclUserName returns the name of the user of a client, which is empty for
+// the default user.
+func aclUserName(user string) string {
+	if user == "" {
+		return aclDefaultUser
+	}
+	return user
+}
+
+func aclHash(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// allowed returns true when the user may run a command.
+func (u *aclUser) allowed(command string) bool {
+	var allowed bool
+	for _, rule := range u.Commands {
+		name := rule[1:]
+		if strings.HasPrefix(name, "@") {
+			if !aclCommands[name[1:]][command] {
+				continue
+			}
+		} else if name != command {
+			continue
+		}
+		allowed = rule[0] == '+'
+	}
+	return allowed
+}
+
+// allowedKey returns true when the user may access a key.
+func (u *aclUser) allowedKey(key string) bool {
+	for _, pattern := range u.Keys {
+		if ok, _ := glob.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allKeys returns true when the user may access every key, which is the
+// allkeys rule, or ~*.
+func (u *aclUser) allKeys() bool {
+	for _, pattern := range u.Keys {
+		if pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassword returns true when a password authenticates the user.
+func (u *aclUser) checkPassword(password string) bool {
+	if !u.On {
+		return false
+	}
+	if u.NoPass {
+		return true
+	}
+	hash := aclHash(password)
+	for _, p := range u.Passwords {
+		if p == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// apply applies a rule of ACL SETUSER.
+func (u *aclUser) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.On = true
+		return nil
+	case "off":
+		u.On = false
+		return nil
+	case "nopass":
+		u.NoPass, u.Passwords = true, nil
+		return nil
+	case "resetpass":
+		u.NoPass, u.Passwords = false, nil
+		return nil
+	case "allkeys":
+		u.Keys = []string{"*"}
+		return nil
+	case "resetkeys":
+		u.Keys = nil
+		return nil
+	case "resetcerts":
+		u.Certs = nil
+		return nil
+	case "resetnamespace":
+		u.Namespace = ""
+		return nil
+	case "allcommands":
+		u.Commands = []string{"+@all"}
+		return nil
+	case "nocommands":
+		u.Commands = nil
+		return nil
+	case "reset":
+		*u = aclUser{Name: u.Name}
+		return nil
+	}
+	if rule == "" {
+		return errInvalidArgument(rule)
+	}
+	if len(rule) > 5 && strings.EqualFold(rule[:5], "cert:") {
+		for _, c := range u.Certs {
+			if c == rule[5:] {
+				return nil
+			}
+		}
+		u.Certs = append(u.Certs, rule[5:])
+		return nil
+	}
+	if len(rule) > 10 && strings.EqualFold(rule[:10], "namespace:") {
+		if !validNamespace(rule[10:]) {
+			return errInvalidArgument(rule)
+		}
+		u.Namespace = rule[10:]
+		return nil
+	}
+	switch arg := rule[1:]; rule[0] {
+	case '>':
+		u.NoPass = false
+		u.removePassword(aclHash(arg))
+		u.Passwords = append(u.Passwords, aclHash(arg))
+	case '<':
+		u.removePassword(aclHash(arg))
+	case '#':
+		if len(arg) != 64 {
+			return errInvalidArgument(rule)
+		}
+		if _, err := hex.DecodeString(arg); err != nil {
+			return errInvalidArgument(rule)
+		}
+		u.NoPass = false
+		u.removePassword(strings.ToLower(arg))
+		u.Passwords = append(u.Passwords, strings.ToLower(arg))
+	case '!':
+		u.removePassword(strings.ToLower(arg))
+	case '~':
+		if arg == "" {
+			return errInvalidArgument(rule)
+		}
+		u.Keys = append(u.Keys, arg)
+	case '+', '-':
+		name := strings.ToLower(arg)
+		if strings.HasPrefix(name, "@") {
+			if aclCommands[name[1:]] == nil {
+				return fmt.Errorf("unknown category '%s'", name[1:])
+			}
+			if name == "@all" {
+				// every earlier rule is overridden
+				u.Commands = nil
+			}
+		} else if !aclCommands["all"][name] {
+			return fmt.Errorf("unknown command '%s'", name)
+		}
+		u.Commands = append(u.Commands, rule[:1]+name)
+	default:
+		return fmt.Errorf("syntax error in ACL rule '%s'", rule)
+	}
+	return nil
+}
+
+func (u *aclUser) removePassword(hash string) {
+	for i, p := range u.Passwords {
+		if p == hash {
+			u.Passwords = append(u.Passwords[:i], u.Passwords[i+1:]...)
+			return
+		}
+	}
+}
+
+// flags returns the flags of the user, which are on or off, and nopass.
+func (u *aclUser) flags() []string {
+	flags := []string{"off"}
+	if u.On {
+		flags[0] = "on"
+	}
+	if u.NoPass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// commandRules returns the rules of the commands, which is -@all when the
+// user may not run any command.
+func (u *aclUser) commandRules() string {
+	if len(u.Commands) == 0 {
+		return "-@all"
+	}
+	return strings.Join(u.Commands, " ")
+}
+
+// String returns the rules of the user, as ACL LIST returns them.
+func (u *aclUser) String() string {
+	parts := append([]string{"user", u.Name}, u.flags()...)
+	for _, p := range u.Passwords {
+		parts = append(parts, "#"+p)
+	}
+	for _, k := range u.Keys {
+		parts = append(parts, "~"+k)
+	}
+	if len(u.Keys) == 0 {
+		parts = append(parts, "resetkeys")
+	}
+	for _, c := range u.Certs {
+		parts = append(parts, "cert:"+c)
+	}
+	if u.Namespace != "" {
+		parts = append(parts, "namespace:"+u.Namespace)
+	}
+	return strings.Join(append(parts, u.commandRules()), " ")
+}
+
+// aclLoad loads the users and the tokens that were persisted in the config.
+func (s *Server) aclLoad() error {
+	s.acl.mu.Lock()
+	defer s.acl.mu.Unlock()
+	s.acl.users = map[string]*aclUser{}
+	s.acl.tokens = nil
+	if data := s.config.authTokens(); data != "" {
+		if err := json.Unmarshal([]byte(data), &s.acl.tokens); err != nil {
+			return fmt.Errorf("invalid auth tokens: %v", err)
+		}
+	}
+	data := s.config.aclUsers()
+	if data == "" {
+		return nil
+	}
+	var users []*aclUser
+	if err := json.Unmarshal([]byte(data), &users); err != nil {
+		return fmt.Errorf("invalid acl users: %v", err)
+	}
+	for _, u := range users {
+		s.acl.users[u.Name] = u
+	}
+	return nil
+}
+
+// aclSave persists the users and the tokens in the config. The acl lock must
+// be held.
+func (s *Server) aclSave() {
+	users := make([]*aclUser, 0, len(s.acl.users))
+	for _, u := range s.acl.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	var data string
+	if len(users) > 0 {
+		b, _ := json.Marshal(users)
+		data = string(b)
+	}
+	s.config.setACLUsers(data)
+	data = ""
+	if len(s.acl.tokens) > 0 {
+		b, _ := json.Marshal(s.acl.tokens)
+		data = string(b)
+	}
+	s.config.setAuthTokens(data)
+	s.config.write(false)
+}
+
+// checkRequirePass returns true when a password is the requirepass, or a
+// token that has not expired. There are no passwords of the default user
+// when the requirepass is not set.
+func (s *Server) checkRequirePass(password string) bool {
+	requirePass := s.config.requirePass()
+	if requirePass == "" {
+		return false
+	}
+	password = strings.TrimSpace(password)
+	if password == requirePass {
+		return true
+	}
+	hash := aclHash(password)
+	now := time.Now()
+	s.acl.mu.RLock()
+	defer s.acl.mu.RUnlock()
+	for _, t := range s.acl.tokens {
+		if t.Hash == hash && !t.expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// aclPruneTokens removes the expired tokens. Returns true when a token was
+// removed. The acl lock must be held.
+func (s *Server) aclPruneTokens(now time.Time) bool {
+	tokens := s.acl.tokens[:0]
+	for _, t := range s.acl.tokens {
+		if !t.expired(now) {
+			tokens = append(tokens, t)
+		}
+	}
+	pruned := len(tokens) < len(s.acl.tokens)
+	s.acl.tokens = tokens
+	return pruned
+}
+
+// aclEnabled returns true when there are users.
+func (s *Server) aclEnabled() bool {
+	s.acl.mu.RLock()
+	defer s.acl.mu.RUnlock()
+	return len(s.acl.users) > 0
+}
+
+// authenticate authenticates a client as a user, which is the requirepass
+// for the default user.
+func (s *Server) authenticate(client *Client, username, password string,
+) error {
+	if username == "" || username == aclDefaultUser {
+		if !s.checkRequirePass(password) {
+			return errors.New("invalid password")
+		}
+		username = ""
+	} else {
+		s.acl.mu.RLock()
+		u := s.acl.users[username]
+		ok := u != nil && u.checkPassword(password)
+		s.acl.mu.RUnlock()
+		if !ok {
+			return errors.New(
+				"invalid username-password pair or user is disabled")
+		}
+	}
+	client.mu.Lock()
+	client.user = username
+	client.mu.Unlock()
+	client.authd = true
+	return nil
+}
+
+// certIdentities returns the identities of a client certificate, which are
+// CN=name for the common name of the subject, and DNS:name, URI:uri,
+// email:address, and IP:address for the subject alternative names.
+func certIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, "CN="+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		ids = append(ids, "DNS:"+name)
+	}
+	for _, uri := range cert.URIs {
+		ids = append(ids, "URI:"+uri.String())
+	}
+	for _, addr := range cert.EmailAddresses {
+		ids = append(ids, "email:"+addr)
+	}
+	for _, ip := range cert.IPAddresses {
+		ids = append(ids, "IP:"+ip.String())
+	}
+	return ids
+}
+
+// authenticateCert authenticates a client of the TLS port with its client
+// certificate, which must be verified by the CA. The user is the first user,
+// in the order of the names, that is on and has a cert: pattern that matches
+// an identity of the certificate. Returns false when no user matches.
+func (s *Server) authenticateCert(client *Client, state tls.ConnectionState,
+) bool {
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return false
+	}
+	ids := certIdentities(state.PeerCertificates[0])
+	s.acl.mu.RLock()
+	names := make([]string, 0, len(s.acl.users))
+	for name := range s.acl.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var username string
+	for _, name := range names {
+		if u := s.acl.users[name]; u.On && u.matchCert(ids) {
+			username = name
+			break
+		}
+	}
+	s.acl.mu.RUnlock()
+	if username == "" {
+		return false
+	}
+	client.mu.Lock()
+	client.user = username
+	client.mu.Unlock()
+	client.authd = true
+	return true
+}
+
+// matchCert returns true when a cert: pattern of the user matches one of the
+// identities of a client certificate.
+func (u *aclUser) matchCert(ids []string) bool {
+	for _, pattern := range u.Certs {
+		for _, id := range ids {
+			if ok, _ := glob.Match(pattern, id); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// aclPermit returns an error when the user of a client may not run a
+// command, or may not access its keys, which include the keys of the other
+// objects of a search, see refKeys. The keys of a script are the keys that
+// are passed to it.
+func (s *Server) aclPermit(msg *Message, client *Client) error {
+	if client.user == "" || aclAlwaysAllowed(msg.Command()) {
+		return nil
+	}
+	s.acl.mu.RLock()
+	defer s.acl.mu.RUnlock()
+	u := s.acl.users[client.user]
+	if u == nil {
+		return fmt.Errorf("user '%s' does not exist", client.user)
+	}
+	if !u.allowed(msg.Command()) {
+		return fmt.Errorf("no permission to run the '%s' command",
+			msg.Command())
+	}
+	if aclAllKeysCommands[msg.Command()] && !u.allKeys() {
+		return fmt.Errorf("no permission to access every key with the "+
+			"'%s' command", msg.Command())
+	}
+	keys := append([]string(nil), clusterKeys(msg.Args)...)
+	for _, i := range refKeys(msg.Command(), msg.Args) {
+		keys = append(keys, msg.Args[i])
+	}
+	if msg.Command() == "stats" {
+		keys = append(keys, statsKeys(msg.Args)...)
+	}
+	for _, key := range keys {
+		if !u.allowedKey(key) {
+			return fmt.Errorf("no permission to access the key '%s'", key)
+		}
+	}
+	return nil
+}
+
+// aclAllKeysCommands are the commands that may access every key, which only
+// the users with allkeys may run. The hooks and the channels of the commands
+// with a pattern, and the triggers, may be of any key.
+var aclAllKeysCommands = map[string]bool{
+	"flushdb": true, "pdelhook": true, "ppausehook": true,
+	"presumehook": true, "pdelchan": true, "ppausechan": true,
+	"presumechan": true, "settrigger": true, "deltrigger": true,
+	"aof": true, "dump": true, "backup": true, "restore": true,
+}
+
+// statsKeys returns the keys of STATS key [key ...] [EXT].
+func statsKeys(args []string) []string {
+	keys := args[1:]
+	if len(keys) > 1 && lc(keys[len(keys)-1], "ext") {
+		keys = keys[:len(keys)-1]
+	}
+	return keys
+}
+
+// aclHookNames returns the names of the hooks and the channels of a command,
+// whose keys are the keys of the command, see aclPermitHooks.
+func aclHookNames(command string, args []string) []string {
+	switch command {
+	case "sethook", "setchan", "delhook", "delchan", "pausehook",
+		"pausechan", "resumehook", "resumechan", "fencetest":
+		if len(args) > 1 {
+			return args[1:2]
+		}
+	case "renamehook", "renamechan":
+		if len(args) > 2 {
+			return args[1:3]
+		}
+	}
+	return nil
+}
+
+// aclPermitHooks returns an error when the user of a client may not access
+// the key of a hook or a channel that is named by a command, such as DELHOOK
+// name, or a SETHOOK that replaces a hook of another key. The server must be
+// locked.
+func (s *Server) aclPermitHooks(msg *Message, client *Client) error {
+	names := aclHookNames(msg.Command(), msg.Args)
+	if len(names) == 0 {
+		return nil
+	}
+	allowed := s.aclKeyFilter(client)
+	if allowed == nil {
+		return nil
+	}
+	for _, name := range names {
+		if hook := s.hooks[name]; hook != nil {
+			key := strings.TrimPrefix(hook.Key, msg.namespace)
+			if !allowed(key) {
+				return fmt.Errorf("no permission to access the key '%s'",
+					key)
+			}
+		}
+	}
+	return nil
+}
+
+// aclKeyFilter returns a func that returns true when the user of a client
+// may access a key, which filters the keys and the hooks that are listed to
+// the client, or nil when the user may access every key.
+func (s *Server) aclKeyFilter(client *Client) func(key string) bool {
+	if client == nil || client.user == "" {
+		return nil
+	}
+	s.acl.mu.RLock()
+	defer s.acl.mu.RUnlock()
+	u := s.acl.users[client.user]
+	if u == nil {
+		return func(string) bool { return false }
+	}
+	if u.allKeys() {
+		return nil
+	}
+	filter := &aclUser{Keys: append([]string(nil), u.Keys...)}
+	return filter.allowedKey
+}
+
+// aclPermitKey returns an error when the user of a client may not access a
+// key, for the keys that are not the arguments of a command, such as the keys
+// of the resolvers of GRAPHQL.
+func (s *Server) aclPermitKey(client *Client, key string) error {
+	if s.aclAllowedKey(client, key) {
+		return nil
+	}
+	return fmt.Errorf("no permission to access the key '%s'", key)
+}
+
+// aclAllowedKey returns true when the user of a client may access a key,
+// which filters the keys that are listed to the client.
+func (s *Server) aclAllowedKey(client *Client, key string) bool {
+	if client == nil || client.user == "" {
+		return true
+	}
+	s.acl.mu.RLock()
+	defer s.acl.mu.RUnlock()
+	u := s.acl.users[client.user]
+	return u != nil && u.allowedKey(key)
+}
+
+// ACL SETUSER username [rule ...]
+// ACL DELUSER username [username ...]
+// ACL GETUSER username
+// ACL USERS
+// ACL LIST
+// ACL WHOAMI
+// ACL CAT [category]
+// ACL TOKEN ADD token [EX seconds]
+// ACL TOKEN DEL token
+// ACL TOKEN LIST
+//
+// Manages the users of the ACL, which authenticate with AUTH username
+// password, or with a client certificate that matches a cert: rule, such as
+// cert:CN=tracker or cert:URI:spiffe://mesh/*. A new user is off and may not
+// run any command or access any key, until the rules of SETUSER allow it.
+//
+// The tokens are passwords of the default user that are accepted along with
+// the requirepass, and that may expire. A password is rotated by adding the
+// old password as a token that expires, and setting the new requirepass.
+func (s *Server) cmdACL(msg *Message, client *Client) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) < 2 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	args := msg.Args[2:]
+	switch strings.ToLower(msg.Args[1]) {
+	case "setuser":
+		if len(args) < 1 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		name := args[0]
+		if name == aclDefaultUser {
+			return NOMessage, errors.New(
+				"the default user is authenticated with the requirepass")
+		}
+		s.acl.mu.Lock()
+		defer s.acl.mu.Unlock()
+		// the rules are applied to a copy, so that the user is unchanged
+		// when a rule is not valid
+		u := &aclUser{Name: name}
+		if prev := s.acl.users[name]; prev != nil {
+			*u = *prev
+			u.Passwords = append([]string(nil), prev.Passwords...)
+			u.Commands = append([]string(nil), prev.Commands...)
+			u.Keys = append([]string(nil), prev.Keys...)
+			u.Certs = append([]string(nil), prev.Certs...)
+		}
+		for _, rule := range args[1:] {
+			if err := u.apply(rule); err != nil {
+				return NOMessage, err
+			}
+		}
+		s.acl.users[name] = u
+		s.aclSave()
+		return OKMessage(msg, start), nil
+	case "deluser":
+		if len(args) < 1 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		s.acl.mu.Lock()
+		defer s.acl.mu.Unlock()
+		var n int
+		for _, name := range args {
+			if s.acl.users[name] != nil {
+				delete(s.acl.users, name)
+				n++
+			}
+		}
+		if n > 0 {
+			s.aclSave()
+		}
+		switch msg.OutputType {
+		case JSON:
+			return resp.StringValue(`{"ok":true,"deleted":` + strconv.Itoa(n) +
+				`,"elapsed":"` + time.Since(start).String() + `"}`), nil
+		case RESP:
+			return resp.IntegerValue(n), nil
+		}
+	case "getuser":
+		if len(args) != 1 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		s.acl.mu.RLock()
+		u := s.acl.users[args[0]]
+		s.acl.mu.RUnlock()
+		if u == nil {
+			return NOMessage, fmt.Errorf("user '%s' does not exist", args[0])
+		}
+		return aclUserValue(msg, start, u), nil
+	case "users", "list":
+		if len(args) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		s.acl.mu.RLock()
+		var names []string
+		for name := range s.acl.users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		list := make([]string, 0, len(names)+1)
+		if strings.ToLower(msg.Args[1]) == "users" {
+			list = append(list, aclDefaultUser)
+			list = append(list, names...)
+		} else {
+			def := "user default on nopass ~* +@all"
+			if requirePass := s.config.requirePass(); requirePass != "" {
+				def = "user default on #" + aclHash(requirePass)
+				now := time.Now()
+				for _, t := range s.acl.tokens {
+					if !t.expired(now) {
+						def += " #" + t.Hash
+					}
+				}
+				def += " ~* +@all"
+			}
+			list = append(list, def)
+			for _, name := range names {
+				list = append(list, s.acl.users[name].String())
+			}
+		}
+		s.acl.mu.RUnlock()
+		return aclListValue(msg, start, strings.ToLower(msg.Args[1]), list),
+			nil
+	case "whoami":
+		if len(args) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		var name string
+		if client != nil {
+			name = client.user
+		}
+		name = aclUserName(name)
+		switch msg.OutputType {
+		case JSON:
+			return resp.StringValue(`{"ok":true,"user":` + jsonString(name) +
+				`,"elapsed":"` + time.Since(start).String() + `"}`), nil
+		case RESP:
+			return resp.StringValue(name), nil
+		}
+	case "cat":
+		var list []string
+		switch len(args) {
+		case 0:
+			for cat := range aclCommands {
+				list = append(list, cat)
+			}
+		case 1:
+			commands := aclCommands[strings.ToLower(args[0])]
+			if commands == nil {
+				return NOMessage, fmt.Errorf("unknown category '%s'", args[0])
+			}
+			for command := range commands {
+				list = append(list, command)
+			}
+		default:
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		sort.Strings(list)
+		return aclListValue(msg, start, "list", list), nil
+	case "token":
+		return s.cmdACLToken(msg, args, start)
+	default:
+		return NOMessage, clientErrorf("Syntax error, try ACL (SETUSER | " +
+			"DELUSER | GETUSER | USERS | LIST | WHOAMI | CAT | TOKEN)")
+	}
+	return NOMessage, nil
+}
+
+// cmdACLToken is ACL TOKEN ADD, DEL, and LIST. The tokens are listed as
+// their digests and the seconds until they expire, which is -1 for never.
+func (s *Server) cmdACLToken(msg *Message, args []string, start time.Time,
+) (resp.Value, error) {
+	if len(args) < 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	s.acl.mu.Lock()
+	defer s.acl.mu.Unlock()
+	now := time.Now()
+	changed := s.aclPruneTokens(now)
+	defer func() {
+		if changed {
+			s.aclSave()
+		}
+	}()
+	switch strings.ToLower(args[0]) {
+	case "add":
+		var expires int64
+		switch len(args) {
+		case 2:
+		case 4:
+			if strings.ToLower(args[2]) != "ex" {
+				return NOMessage, errInvalidArgument(args[2])
+			}
+			secs, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil || secs <= 0 {
+				return NOMessage, errInvalidArgument(args[3])
+			}
+			expires = now.Unix() + secs
+		default:
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		if strings.TrimSpace(args[1]) == "" {
+			return NOMessage, errInvalidArgument(args[1])
+		}
+		hash := aclHash(strings.TrimSpace(args[1]))
+		token := aclToken{Hash: hash, Expires: expires}
+		var found bool
+		for i, t := range s.acl.tokens {
+			if t.Hash == hash {
+				s.acl.tokens[i], found = token, true
+			}
+		}
+		if !found {
+			s.acl.tokens = append(s.acl.tokens, token)
+		}
+		changed = true
+		return OKMessage(msg, start), nil
+	case "del":
+		if len(args) != 2 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		hash := aclHash(strings.TrimSpace(args[1]))
+		var n int
+		for i, t := range s.acl.tokens {
+			if t.Hash == hash {
+				s.acl.tokens = append(s.acl.tokens[:i], s.acl.tokens[i+1:]...)
+				n, changed = 1, true
+				break
+			}
+		}
+		switch msg.OutputType {
+		case JSON:
+			return resp.StringValue(`{"ok":true,"deleted":` + strconv.Itoa(n) +
+				`,"elapsed":"` + time.Since(start).String() + `"}`), nil
+		case RESP:
+			return resp.IntegerValue(n), nil
+		}
+	case "list":
+		if len(args) != 1 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		ttl := func(t aclToken) int64 {
+			if t.Expires == 0 {
+				return -1
+			}
+			return t.Expires - now.Unix()
+		}
+		switch msg.OutputType {
+		case JSON:
+			var buf []byte
+			buf = append(buf, `{"ok":true,"tokens":[`...)
+			for i, t := range s.acl.tokens {
+				if i > 0 {
+					buf = append(buf, ',')
+				}
+				buf = append(buf, `{"hash":"`+t.Hash+`","ttl":`...)
+				buf = strconv.AppendInt(buf, ttl(t), 10)
+				buf = append(buf, '}')
+			}
+			buf = append(buf, `],"elapsed":"`+time.Since(start).String()+
+				`"}`...)
+			return resp.StringValue(string(buf)), nil
+		case RESP:
+			vals := make([]resp.Value, len(s.acl.tokens))
+			for i, t := range s.acl.tokens {
+				vals[i] = resp.ArrayValue([]resp.Value{
+					resp.StringValue(t.Hash),
+					resp.IntegerValue(int(ttl(t))),
+				})
+			}
+			return resp.ArrayValue(vals), nil
+		}
+	default:
+		return NOMessage, clientErrorf("Syntax error, try ACL TOKEN " +
+			"(ADD | DEL | LIST)")
+	}
+	return NOMessage, nil
+}
+
+func aclListValue(msg *Message, start time.Time, name string, list []string,
+) resp.Value {
+	switch msg.OutputType {
+	case JSON:
+		data, _ := json.Marshal(list)
+		return resp.StringValue(`{"ok":true,"` + name + `":` + string(data) +
+			`,"elapsed":"` + time.Since(start).String() + `"}`)
+	case RESP:
+		vals := make([]resp.Value, len(list))
+		for i, s := range list {
+			vals[i] = resp.StringValue(s)
+		}
+		return resp.ArrayValue(vals)
+	}
+	return NOMessage
+}
+
+func aclUserValue(msg *Message, start time.Time, u *aclUser) resp.Value {
+	passwords := append([]string{}, u.Passwords...)
+	keys := append([]string{}, u.Keys...)
+	certs := append([]string{}, u.Certs...)
+	switch msg.OutputType {
+	case JSON:
+		data, _ := json.Marshal(map[string]interface{}{
+			"flags":     u.flags(),
+			"passwords": passwords,
+			"commands":  u.commandRules(),
+			"keys":      keys,
+			"certs":     certs,
+			"namespace": u.Namespace,
+		})
+		return resp.StringValue(`{"ok":true,"user":` + string(data) +
+			`,"elapsed":"` + time.Since(start).String() + `"}`)
+	case RESP:
+		strs := func(list []string) resp.Value {
+			vals := make([]resp.Value, len(list))
+			for i, s := range list {
+				vals[i] = resp.StringValue(s)
+			}
+			return resp.ArrayValue(vals)
+		}
+		return resp.ArrayValue([]resp.Value{
+			resp.StringValue("flags"), strs(u.flags()),
+			resp.StringValue("passwords"), strs(passwords),
+			resp.StringValue("commands"), resp.StringValue(u.commandRules()),
+			resp.StringValue("keys"), strs(keys),
+			resp.StringValue("certs"), strs(certs),
+			resp.StringValue("namespace"), resp.StringValue(u.Namespace),
+		})
+	}
+	return NOMessage
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestACLUser(t *testing.T) {
+	u := &aclUser{Name: "alice"}
+	for _, rule := range []string{"on", ">secret", "~fleet:*", "~zones",
+		"+@read", "-jget", "+set"} {
+		if err := u.apply(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for command, expect := range map[string]bool{
+		"get": true, "nearby": true, "jget": false, "set": true, "del": false,
+		"config": false,
+	} {
+		if u.allowed(command) != expect {
+			t.Fatalf("expected %t for '%s'", expect, command)
+		}
+	}
+	for key, expect := range map[string]bool{
+		"fleet:1": true, "zones": true, "zones:1": false, "other": false,
+	} {
+		if u.allowedKey(key) != expect {
+			t.Fatalf("expected %t for '%s'", expect, key)
+		}
+	}
+	if !u.checkPassword("secret") || u.checkPassword("nope") {
+		t.Fatal("unexpected password check")
+	}
+	// -@all overrides the earlier rules
+	if err := u.apply("-@all"); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Commands) != 1 || u.allowed("get") {
+		t.Fatalf("expected no commands, got %v", u.Commands)
+	}
+	if err := u.apply("<secret"); err != nil {
+		t.Fatal(err)
+	}
+	if u.checkPassword("secret") {
+		t.Fatal("expected the password to be removed")
+	}
+	if err := u.apply("nopass"); err != nil {
+		t.Fatal(err)
+	}
+	if !u.checkPassword("anything") {
+		t.Fatal("expected nopass")
+	}
+	if err := u.apply("off"); err != nil {
+		t.Fatal(err)
+	}
+	if u.checkPassword("anything") {
+		t.Fatal("expected a disabled user")
+	}
+	expect := "user alice off nopass ~fleet:* ~zones -@all"
+	if s := u.String(); s != expect {
+		t.Fatalf("expected '%s', got '%s'", expect, s)
+	}
+	if err := u.apply("reset"); err != nil {
+		t.Fatal(err)
+	}
+	if s := u.String(); s != "user alice off resetkeys -@all" {
+		t.Fatalf("unexpected reset user '%s'", s)
+	}
+	for _, rule := range []string{"+nope", "-@nope", "#abc", "~", "?"} {
+		if err := u.apply(rule); err == nil {
+			t.Fatalf("expected an error for '%s'", rule)
+		}
+	}
+}
+
+func TestACLCert(t *testing.T) {
+	uri, _ := url.Parse("spiffe://mesh/ns/fleet/sa/tracker")
+	cert := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "tracker"},
+		DNSNames:    []string{"tracker.fleet.svc"},
+		URIs:        []*url.URL{uri},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	expect := []string{"CN=tracker", "DNS:tracker.fleet.svc",
+		"URI:spiffe://mesh/ns/fleet/sa/tracker", "IP:10.0.0.1"}
+	if ids := certIdentities(cert); !reflect.DeepEqual(ids, expect) {
+		t.Fatalf("expected %v, got %v", expect, ids)
+	}
+
+	s := &Server{}
+	s.acl.users = map[string]*aclUser{}
+	for name, rules := range map[string][]string{
+		"admin":   {"on", "cert:CN=admin"},
+		"tracker": {"on", "cert:URI:spiffe://mesh/ns/fleet/*", "cert:URI:spiffe://mesh/ns/fleet/*"},
+		"fleet":   {"off", "cert:DNS:*.fleet.svc"},
+	} {
+		u := &aclUser{Name: name}
+		for _, rule := range rules {
+			if err := u.apply(rule); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.acl.users[name] = u
+	}
+	if n := len(s.acl.users["tracker"].Certs); n != 1 {
+		t.Fatalf("expected 1 cert pattern, got %d", n)
+	}
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	client := &Client{}
+	if s.authenticateCert(client, state) {
+		t.Fatal("expected an unverified certificate to be ignored")
+	}
+	state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	if !s.authenticateCert(client, state) || client.user != "tracker" ||
+		!client.authd {
+		t.Fatalf("expected the tracker user, got '%s'", client.user)
+	}
+	// the fleet user matches the DNS name, but is off
+	s.acl.users["tracker"].apply("resetcerts")
+	client = &Client{}
+	if s.authenticateCert(client, state) || client.authd {
+		t.Fatal("expected no user")
+	}
+	expectUser := "user tracker on resetkeys -@all"
+	if str := s.acl.users["tracker"].String(); str != expectUser {
+		t.Fatalf("expected '%s', got '%s'", expectUser, str)
+	}
+	expectUser = "user admin on resetkeys cert:CN=admin -@all"
+	if str := s.acl.users["admin"].String(); str != expectUser {
+		t.Fatalf("expected '%s', got '%s'", expectUser, str)
+	}
+}
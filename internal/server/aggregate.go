@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+// aggregation collects the number of objects that match a search, and the
+// min, max, avg, and sum of some of their fields, instead of returning the
+// objects. The objects are grouped by the value of a field when groupBy is
+// set, or by the H3 cell of their center for a GROUPBY H3:res, which leaves
+// out the objects that are not spatial.
+type aggregation struct {
+	groupBy string
+	h3Res   int // -1 unless grouped by H3 cell
+	fields  []string
+	total   aggGroup
+	groups  map[field.Value]*aggGroup
+}
+
+type aggGroup struct {
+	count uint64
+	stats []aggStat
+}
+
+type aggStat struct {
+	min, max, sum float64
+}
+
+// newAggregation returns an aggregation for the STATS fields, which are
+// separated by commas, and the GROUPBY field.
+func newAggregation(groupBy, fields string) (*aggregation, error) {
+	h3Res, err := parseH3Group(groupBy)
+	if err != nil {
+		return nil, err
+	}
+	agg := &aggregation{groupBy: groupBy, h3Res: h3Res}
+	if fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				return nil, errInvalidArgument(fields)
+			}
+			for _, f := range agg.fields {
+				if f == field {
+					return nil, errDuplicateArgument(field)
+				}
+			}
+			agg.fields = append(agg.fields, field)
+		}
+	}
+	if groupBy != "" {
+		agg.groups = make(map[field.Value]*aggGroup)
+	}
+	return agg, nil
+}
+
+// fresh returns an empty aggregation with the same fields.
+func (agg *aggregation) fresh() *aggregation {
+	if agg == nil {
+		return nil
+	}
+	nagg := &aggregation{groupBy: agg.groupBy, h3Res: agg.h3Res,
+		fields: agg.fields}
+	if agg.groupBy != "" {
+		nagg.groups = make(map[field.Value]*aggGroup)
+	}
+	return nagg
+}
+
+// aggValue returns the value of a field of an object.
+func aggValue(fmap map[string]int, fields []field.Value, name string) field.Value {
+	if idx, ok := fmap[name]; ok && idx < len(fields) {
+		return fields[idx]
+	}
+	return field.Value{}
+}
+
+// add includes the fields of an object that matched the search.
+func (agg *aggregation) add(o geojson.Object, fmap map[string]int, fields []field.Value) {
+	g := &agg.total
+	if agg.groupBy != "" {
+		var value field.Value
+		if agg.h3Res >= 0 {
+			if !objIsSpatial(o) {
+				return
+			}
+			value = field.Str(h3Cell(o, agg.h3Res))
+		} else {
+			value = aggValue(fmap, fields, agg.groupBy)
+		}
+		if g = agg.groups[value]; g == nil {
+			g = &aggGroup{}
+			agg.groups[value] = g
+		}
+	}
+	if g.stats == nil && len(agg.fields) > 0 {
+		g.stats = make([]aggStat, len(agg.fields))
+		for i := range g.stats {
+			g.stats[i].min = math.Inf(+1)
+			g.stats[i].max = math.Inf(-1)
+		}
+	}
+	for i, name := range agg.fields {
+		value := aggValue(fmap, fields, name).Num()
+		st := &g.stats[i]
+		st.min = math.Min(st.min, value)
+		st.max = math.Max(st.max, value)
+		st.sum += value
+	}
+	g.count++
+}
+
+// sortedGroups returns the values of the groups in ascending order, with the
+// numbers before the strings.
+func (agg *aggregation) sortedGroups() []field.Value {
+	values := make([]field.Value, 0, len(agg.groups))
+	for value := range agg.groups {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return field.Less(values[i], values[j])
+	})
+	return values
+}
+
+// values returns the min, max, avg, and sum of a field. They are all zero
+// when the group is empty. Strings and json count as zero.
+func (g *aggGroup) values(i int) [4]float64 {
+	if g.count == 0 {
+		return [4]float64{}
+	}
+	st := g.stats[i]
+	return [4]float64{st.min, st.max, st.sum / float64(g.count), st.sum}
+}
+
+func (agg *aggregation) appendJSONStats(wr *bytes.Buffer, g *aggGroup) {
+	wr.WriteString(`"stats":{`)
+	for i, name := range agg.fields {
+		if i > 0 {
+			wr.WriteByte(',')
+		}
+		vals := g.values(i)
+		wr.WriteString(jsonString(name) + `:{`)
+		for j, what := range [...]string{"min", "max", "avg", "sum"} {
+			if j > 0 {
+				wr.WriteByte(',')
+			}
+			wr.WriteString(`"` + what + `":` +
+				strconv.FormatFloat(vals[j], 'f', -1, 64))
+		}
+		wr.WriteByte('}')
+	}
+	wr.WriteByte('}')
+}
+
+// writeJSON writes the groups, or the stats when the objects are not
+// grouped.
+func (agg *aggregation) writeJSON(wr *bytes.Buffer) {
+	if agg.groupBy == "" {
+		if agg.fields != nil {
+			wr.WriteByte(',')
+			agg.appendJSONStats(wr, &agg.total)
+		}
+		return
+	}
+	wr.WriteString(`,"groups":[`)
+	for i, value := range agg.sortedGroups() {
+		if i > 0 {
+			wr.WriteByte(',')
+		}
+		g := agg.groups[value]
+		wr.WriteString(`{"value":` + value.JSON())
+		wr.WriteString(`,"count":` + strconv.FormatUint(g.count, 10))
+		if agg.fields != nil {
+			wr.WriteByte(',')
+			agg.appendJSONStats(wr, g)
+		}
+		wr.WriteByte('}')
+	}
+	wr.WriteByte(']')
+}
+
+func (agg *aggregation) respStats(g *aggGroup) resp.Value {
+	vals := make([]resp.Value, 0, len(agg.fields)*2)
+	for i, name := range agg.fields {
+		fvals := g.values(i)
+		vals = append(vals, resp.StringValue(name), resp.ArrayValue([]resp.Value{
+			resp.FloatValue(fvals[0]),
+			resp.FloatValue(fvals[1]),
+			resp.FloatValue(fvals[2]),
+			resp.FloatValue(fvals[3]),
+		}))
+	}
+	return resp.ArrayValue(vals)
+}
+
+// respFieldValue returns a number as a float and everything else as a
+// string.
+func respFieldValue(value field.Value) resp.Value {
+	if value.Kind() == field.Number {
+		return resp.FloatValue(value.Num())
+	}
+	return resp.StringValue(value.String())
+}
+
+// respValue returns the groups, each with its value, count, and stats, or
+// the count and stats when the objects are not grouped.
+func (agg *aggregation) respValue(count uint64) resp.Value {
+	if agg.groupBy == "" {
+		return resp.ArrayValue([]resp.Value{
+			resp.IntegerValue(int(count)),
+			agg.respStats(&agg.total),
+		})
+	}
+	var groups []resp.Value
+	for _, value := range agg.sortedGroups() {
+		g := agg.groups[value]
+		vals := []resp.Value{
+			respFieldValue(value),
+			resp.IntegerValue(int(g.count)),
+		}
+		if agg.fields != nil {
+			vals = append(vals, agg.respStats(g))
+		}
+		groups = append(groups, resp.ArrayValue(vals))
+	}
+	return resp.ArrayValue(groups)
+}
@@ -4,14 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math"
 	"net"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/tidwall/buntdb"
@@ -30,17 +27,19 @@ func (err errAOFHook) Error() string {
 }
 
 func (s *Server) loadAOF() (err error) {
-	fi, err := s.aof.Stat()
+	size, err := s.aof.Size()
 	if err != nil {
 		return err
 	}
 	start := time.Now()
 	var count int
+	s.beginBulkLoad()
 	defer func() {
+		s.endBulkLoad()
 		d := time.Since(start)
 		ps := float64(count) / (float64(d) / float64(time.Second))
 		suf := []string{"bytes/s", "KB/s", "MB/s", "GB/s", "TB/s"}
-		bps := float64(fi.Size()) / (float64(d) / float64(time.Second))
+		bps := float64(size) / (float64(d) / float64(time.Second))
 		for i := 0; bps > 1024; i++ {
 			if len(suf) == 1 {
 				break
@@ -49,24 +48,56 @@ func (s *Server) loadAOF() (err error) {
 			suf = suf[1:]
 		}
 		byteSpeed := fmt.Sprintf("%.0f %s", bps, suf[0])
-		log.Infof("AOF loaded %d commands: %.2fs, %.0f/s, %s",
+		aofLog.Infof("AOF loaded %d commands: %.2fs, %.0f/s, %s",
 			count, float64(d)/float64(time.Second), ps, byteSpeed)
 	}()
+	apply := func(args []string) error {
+		_, d, err := s.command(&Message{Args: args}, nil)
+		if err != nil {
+			if commandErrIsFatal(err) {
+				return err
+			}
+		} else if d.updated {
+			s.updateFenceRefs(&d)
+		}
+		count++
+		return nil
+	}
+	l := s.newAOFLoader(apply)
+	defer l.close()
 	var buf []byte
 	var args [][]byte
 	var packet [0xFFFF]byte
 	var zeros int
+	var tx [][]string // the commands of a transaction, following a MULTI
+	var inTx bool
+	var txPos int // the aof offset of the MULTI
 	for {
 		n, err := s.aof.Read(packet[:])
 		if err != nil {
 			if err == io.EOF {
+				if inTx {
+					// The server stopped while writing a transaction.
+					// Truncate the file to the MULTI, so that none of the
+					// transaction is applied.
+					aofLog.Infof("Truncating %d bytes of an incomplete "+
+						"transaction from AOF", s.aofsz-txPos)
+					buf, zeros = nil, 0
+					s.aofsz = txPos
+					if err := s.aof.Truncate(int64(s.aofsz)); err != nil {
+						return err
+					}
+					if _, err := s.aof.Seek(int64(s.aofsz), 0); err != nil {
+						return err
+					}
+				}
 				if len(buf) > 0 {
 					return io.ErrUnexpectedEOF
 				}
 				if zeros > 0 {
 					// Trailing zeros in AOF. Truncate the file so it's sane.
 					// See issue #230 for more information. Force a warning.
-					log.Infof("Truncating %d zeros from AOF (issue #230)", zeros)
+					aofLog.Infof("Truncating %d zeros from AOF (issue #230)", zeros)
 					s.aofsz -= zeros
 					if err := s.aof.Truncate(int64(s.aofsz)); err != nil {
 						return err
@@ -75,6 +106,10 @@ func (s *Server) loadAOF() (err error) {
 						return err
 					}
 				}
+				if err := l.close(); err != nil {
+					return err
+				}
+				s.resetBacklog(true)
 				return nil
 			}
 			return err
@@ -96,6 +131,7 @@ func (s *Server) loadAOF() (err error) {
 					return clientErrorf("Zeros found in AOF file (issue #230)")
 				}
 			}
+			pos := s.aofsz - len(data)
 			complete, args, _, data, err = redcon.ReadNextCommand(data, args[:0])
 			if err != nil {
 				return err
@@ -103,18 +139,29 @@ func (s *Server) loadAOF() (err error) {
 			if !complete {
 				break
 			}
-			if len(args) > 0 {
-				var msg Message
-				msg.Args = msg.Args[:0]
-				for _, arg := range args {
-					msg.Args = append(msg.Args, string(arg))
-				}
-				if _, _, err := s.command(&msg, nil); err != nil {
-					if commandErrIsFatal(err) {
-						return err
+			if len(args) == 0 {
+				continue
+			}
+			sargs := make([]string, len(args))
+			for i, arg := range args {
+				sargs[i] = string(arg)
+			}
+			switch {
+			case txMarker(sargs, "multi"):
+				tx, inTx, txPos = tx[:0], true, pos
+			case inTx && txMarker(sargs, "exec"):
+				for _, args := range tx {
+					if !l.add(args) {
+						return l.close()
 					}
 				}
-				count++
+				tx, inTx = tx[:0], false
+			case inTx:
+				tx = append(tx, sargs)
+			default:
+				if !l.add(sargs) {
+					return l.close()
+				}
 			}
 		}
 		if len(data) > 0 {
@@ -137,58 +184,116 @@ func commandErrIsFatal(err error) bool {
 }
 
 // flushAOF flushes all aof buffer data to disk. Set sync to true to sync the
-// fsync the file.
+// fsync the file, which is skipped when there's nothing new to sync.
 func (s *Server) flushAOF(sync bool) {
 	if len(s.aofbuf) > 0 {
 		_, err := s.aof.Write(s.aofbuf)
 		if err != nil {
 			panic(err)
 		}
-		if sync {
-			if err := s.aof.Sync(); err != nil {
-				panic(err)
-			}
-		}
+		s.aofunsynced = true
+		s.flushBacklog(s.aofbuf)
 		if cap(s.aofbuf) > 1024*1024*32 {
 			s.aofbuf = make([]byte, 0, 1024*1024*32)
 		} else {
 			s.aofbuf = s.aofbuf[:0]
 		}
+		s.repl.wrote(int64(s.aofsz))
+		// notify aof live connections that we have new data
+		s.fcond.L.Lock()
+		s.fcond.Broadcast()
+		s.fcond.L.Unlock()
+	}
+	flushed := s.aofwrote.get()
+	s.aofflushed.set(flushed)
+	if sync {
+		if s.aofunsynced {
+			start := time.Now()
+			if err := s.aof.Sync(); err != nil {
+				panic(err)
+			}
+			s.latency.observe(latencyAOFFsync, time.Since(start))
+			s.aofunsynced = false
+		}
+		s.aofsynced.set(flushed)
 	}
 }
 
+// flushClientAOF flushes the aof before a reply to a client, once its writes
+// are in the aof file, and synced to disk when appendfsync is "always". The
+// writes of the other clients, and their flushes, don't matter.
+func (s *Server) flushClientAOF(client *Client) {
+	always := s.config.appendFsync() == "always"
+	done := &s.aofflushed
+	if always {
+		done = &s.aofsynced
+	}
+	if done.get() >= client.aofpos {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushAOF(always)
+}
+
+// cmdWaitAOF flushes the aof and syncs it to disk, returning once every
+// write that came before it is durable.
+func (s *Server) cmdWaitAOF(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if s.aof == nil {
+		return NOMessage, errors.New("aof disabled")
+	}
+	s.flushAOF(true)
+	return OKMessage(msg, start), nil
+}
+
 func (s *Server) writeAOF(args []string, d *commandDetails) error {
 	if d != nil && !d.updated {
 		// just ignore writes if the command did not update
 		return nil
 	}
 
+	if err := s.expireHookLease(); err != nil {
+		return err
+	}
+
 	if s.shrinking {
 		nargs := make([]string, len(args))
 		copy(nargs, args)
 		s.shrinklog = append(s.shrinklog, nargs)
 	}
 
-	if s.aof != nil {
-		atomic.StoreInt32(&s.aofdirty, 1) // prewrite optimization flag
-		n := len(s.aofbuf)
-		s.aofbuf = redcon.AppendArray(s.aofbuf, len(args))
-		for _, arg := range args {
-			s.aofbuf = redcon.AppendBulkString(s.aofbuf, arg)
+	if len(s.migrations) > 0 {
+		s.logMigration(args)
+	}
+
+	if s.persist != nil {
+		if err := s.persist.write(args); err != nil {
+			return err
 		}
-		s.aofsz += len(s.aofbuf) - n
 	}
 
-	// notify aof live connections that we have new data
-	s.fcond.L.Lock()
-	s.fcond.Broadcast()
-	s.fcond.L.Unlock()
+	if d != nil {
+		if err := s.queueChanges(args, d); err != nil {
+			return err
+		}
+		s.notifyKeyspace(args, d)
+		s.touchWatches(d)
+	}
 
 	// process geofences
 	if d != nil {
+		// move the fences that follow an object
+		s.updateFenceRefs(d)
+
 		// webhook geofences
-		if s.config.followHost() == "" {
-			// for leader only
+		if (s.config.followHost() == "" || s.hookOwner == s.config.serverID()) &&
+			s.lwwLocal(args) {
+			// for leader, or the follower with the lease of the follower
+			// hooks, and not for the writes from a peer
 			if d.parent {
 				// queue children
 				for _, d := range d.children {
@@ -221,86 +326,46 @@ func (s *Server) writeAOF(args []string, d *commandDetails) error {
 	return nil
 }
 
-func (s *Server) getQueueCandidates(d *commandDetails) []*Hook {
-	candidates := make(map[*Hook]bool)
-	// add the hooks with "outside" detection
-	for _, hook := range s.hooksOut {
-		if hook.Key == d.key {
-			candidates[hook] = true
-		}
-	}
-	// look for candidates that might "cross" geofences
-	if d.oldObj != nil && d.obj != nil && s.hookCross.Len() > 0 {
-		r1, r2 := d.oldObj.Rect(), d.obj.Rect()
-		s.hookCross.Search(
-			[2]float64{
-				math.Min(r1.Min.X, r2.Min.X),
-				math.Min(r1.Min.Y, r2.Min.Y),
-			},
-			[2]float64{
-				math.Max(r1.Max.X, r2.Max.X),
-				math.Max(r1.Max.Y, r2.Max.Y),
-			},
-			func(min, max [2]float64, value interface{}) bool {
-				hook := value.(*Hook)
-				if hook.Key == d.key {
-					candidates[hook] = true
-				}
-				return true
-			})
-	}
-	// look for candidates that overlap the old object
-	if d.oldObj != nil {
-		r1 := d.oldObj.Rect()
-		s.hookTree.Search(
-			[2]float64{r1.Min.X, r1.Min.Y},
-			[2]float64{r1.Max.X, r1.Max.Y},
-			func(min, max [2]float64, value interface{}) bool {
-				hook := value.(*Hook)
-				if hook.Key == d.key {
-					candidates[hook] = true
-				}
-				return true
-			})
-	}
-	// look for candidates that overlap the new object
-	if d.obj != nil {
-		r1 := d.obj.Rect()
-		s.hookTree.Search(
-			[2]float64{r1.Min.X, r1.Min.Y},
-			[2]float64{r1.Max.X, r1.Max.Y},
-			func(min, max [2]float64, value interface{}) bool {
-				hook := value.(*Hook)
-				if hook.Key == d.key {
-					candidates[hook] = true
-				}
-				return true
-			})
-	}
-	if len(candidates) == 0 {
-		return nil
-	}
-	// return the candidates as a slice
-	ret := make([]*Hook, 0, len(candidates))
-	for hook := range candidates {
-		ret = append(ret, hook)
-	}
-	return ret
-}
-
 func (s *Server) queueHooks(d *commandDetails) error {
 	// Create the slices that will store all messages and hooks
-	var cmsgs, wmsgs []string
+	var cmsgs, wmsgs, pmsgs []string
 	var whooks []*Hook
 
 	// Compile a slice of potential hook recipients
-	candidates := s.getQueueCandidates(d)
+	candidates := s.hookIndex.candidates(d)
+	maxRate := s.maxEventRate(d.key)
 	for _, hook := range candidates {
+		if !s.evaluatesHook(hook) {
+			continue
+		}
 		// Calculate all matching fence messages for all candidates and append
 		// them to the appropriate message slice
 		msgs := FenceMatch(hook.Name, hook.ScanWriter, hook.Fence, hook.Metas, d)
+		if len(msgs) > 0 && hook.paused && !hook.buffered {
+			// paused without buffering, drop the messages, which do not
+			// count toward the event rate of the object
+			s.statsFenceEvents.add(len(msgs))
+			continue
+		}
+		if len(msgs) > 0 && maxRate > 0 {
+			msgs = s.limitEventRate(d, msgs, maxRate)
+		}
+		s.statsFenceEvents.add(len(msgs))
+		if len(msgs) > 0 && hook.paused {
+			if hook.channel {
+				// channels have no queue, hold on to the messages until
+				// the channel is resumed.
+				hook.bufferMessages(msgs)
+			} else {
+				// the queued messages of a paused hook do not expire
+				// until the hook is resumed, see expireHookLogs.
+				pmsgs = append(pmsgs, msgs...)
+			}
+			continue
+		}
 		if len(msgs) > 0 {
 			if hook.channel {
+				hook.delivery.delivered(len(msgs))
 				cmsgs = append(cmsgs, msgs...)
 			} else {
 				wmsgs = append(wmsgs, msgs...)
@@ -310,7 +375,7 @@ func (s *Server) queueHooks(d *commandDetails) error {
 	}
 
 	// Return nil if there are no messages to be sent
-	if len(cmsgs)+len(wmsgs) == 0 {
+	if len(cmsgs)+len(wmsgs)+len(pmsgs) == 0 {
 		return nil
 	}
 
@@ -321,6 +386,9 @@ func (s *Server) queueHooks(d *commandDetails) error {
 	if len(wmsgs) > 1 {
 		sortMsgs(wmsgs)
 	}
+	if len(pmsgs) > 1 {
+		sortMsgs(pmsgs)
+	}
 
 	// Publish all channel messages if any exist
 	if len(cmsgs) > 0 {
@@ -330,14 +398,43 @@ func (s *Server) queueHooks(d *commandDetails) error {
 	}
 
 	// Queue the webhook messages in the buntdb database
-	err := s.qdb.Update(func(tx *buntdb.Tx) error {
-		for _, msg := range wmsgs {
+	if err := s.queueHookLogs(wmsgs, hookLogSetDefaults); err != nil {
+		return err
+	}
+	if err := s.queueHookLogs(pmsgs, nil); err != nil {
+		return err
+	}
+	// all the messages have been queued.
+	// notify the hooks
+	for _, hook := range whooks {
+		hook.Signal()
+	}
+	return nil
+}
+
+// queueHookLogs queues the messages of the webhooks, which are sent by the
+// hooks that are named in the messages. The messages never expire when the
+// opts are nil. The caller must hold the server lock, and signal the hooks.
+func (s *Server) queueHookLogs(msgs []string, opts *buntdb.SetOptions) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	return s.qdb.Update(func(tx *buntdb.Tx) error {
+		for _, msg := range msgs {
 			s.qidx++ // increment the log id
 			key := hookLogPrefix + uint64ToString(s.qidx)
-			_, _, err := tx.Set(key, msg, hookLogSetDefaults)
+			_, _, err := tx.Set(key, msg, opts)
 			if err != nil {
 				return err
 			}
+			if s.writeSpan.IsValid() {
+				// the parent of the span of the delivery
+				_, _, err := tx.Set(hookTracePrefix+uint64ToString(s.qidx),
+					s.writeSpan.Traceparent(), opts)
+				if err != nil {
+					return err
+				}
+			}
 			log.Debugf("queued hook: %d", s.qidx)
 		}
 		_, _, err := tx.Set("hook:idx", uint64ToString(s.qidx), nil)
@@ -346,15 +443,35 @@ func (s *Server) queueHooks(d *commandDetails) error {
 		}
 		return nil
 	})
-	if err != nil {
-		return err
+}
+
+// limitEventRate caps the number of fence events that a single object may
+// generate per second across all hooks, see maxEventRate. The excess
+// messages are dropped.
+func (s *Server) limitEventRate(d *commandDetails, msgs []string,
+	maxRate uint64,
+) []string {
+	if d.id == "" {
+		return msgs
+	}
+	sec := time.Now().Unix()
+	if s.eventRates == nil || s.eventRatesSec != sec {
+		s.eventRates = make(map[string]uint64)
+		s.eventRatesSec = sec
+	}
+	key := d.key + ":" + d.id
+	count := s.eventRates[key]
+	if count >= maxRate {
+		s.statsDroppedEvents.add(len(msgs))
+		return nil
 	}
-	// all the messages have been queued.
-	// notify the hooks
-	for _, hook := range whooks {
-		hook.Signal()
+	if count+uint64(len(msgs)) > maxRate {
+		n := int(maxRate - count)
+		s.statsDroppedEvents.add(len(msgs) - n)
+		msgs = msgs[:n]
 	}
-	return nil
+	s.eventRates[key] = count + uint64(len(msgs))
+	return msgs
 }
 
 // sortMsgs sorts passed notification messages by their detect and hook fields
@@ -403,7 +520,10 @@ func uint64ToString(u uint64) string {
 }
 
 type liveAOFSwitches struct {
-	pos int64
+	pos    int64
+	keys   []string
+	peer   string
+	member string
 }
 
 func (s liveAOFSwitches) Error() string {
@@ -411,6 +531,9 @@ func (s liveAOFSwitches) Error() string {
 }
 
 func (s *Server) cmdAOFMD5(msg *Message) (res resp.Value, err error) {
+	if s.aof == nil {
+		return NOMessage, errors.New("aof disabled")
+	}
 	start := time.Now()
 	vs := msg.Args[1:]
 	var ok bool
@@ -458,19 +581,43 @@ func (s *Server) cmdAOF(msg *Message) (res resp.Value, err error) {
 	if vs, spos, ok = tokenval(vs); !ok || spos == "" {
 		return NOMessage, errInvalidNumberOfArguments
 	}
+	var keys []string
+	var peer, member string
 	if len(vs) != 0 {
-		return NOMessage, errInvalidNumberOfArguments
+		switch strings.ToLower(vs[0]) {
+		case "keys":
+			keys = vs[1:]
+			if len(keys) == 0 {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+		case "peer":
+			if len(vs) != 2 {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			peer = vs[1]
+		case "member":
+			// the raft address of a follower that is a raft member
+			if len(vs) != 2 {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			if !validHostPort(vs[1]) {
+				return NOMessage, errInvalidArgument(vs[1])
+			}
+			member = vs[1]
+		default:
+			return NOMessage, errInvalidArgument(vs[0])
+		}
 	}
 	pos, err := strconv.ParseInt(spos, 10, 64)
 	if err != nil || pos < 0 {
 		return NOMessage, errInvalidArgument(spos)
 	}
-	f, err := os.Open(s.aof.Name())
+	f, err := s.openAOFReader()
 	if err != nil {
 		return NOMessage, err
 	}
 	defer f.Close()
-	n, err := f.Seek(0, 2)
+	n, err := f.Size()
 	if err != nil {
 		return NOMessage, err
 	}
@@ -479,10 +626,15 @@ func (s *Server) cmdAOF(msg *Message) (res resp.Value, err error) {
 	}
 	var ls liveAOFSwitches
 	ls.pos = pos
+	ls.keys = keys
+	ls.peer = peer
+	ls.member = member
 	return NOMessage, ls
 }
 
-func (s *Server) liveAOF(pos int64, conn net.Conn, rd *PipelineReader, msg *Message) error {
+func (s *Server) liveAOF(pos int64, keys []string, peer, member string,
+	conn net.Conn, rd *PipelineReader, msg *Message,
+) error {
 	s.mu.Lock()
 	s.aofconnM[conn] = true
 	s.mu.Unlock()
@@ -490,20 +642,38 @@ func (s *Server) liveAOF(pos int64, conn net.Conn, rd *PipelineReader, msg *Mess
 		s.mu.Lock()
 		delete(s.aofconnM, conn)
 		s.mu.Unlock()
+		s.repl.remove(conn)
 		conn.Close()
 	}()
 
 	if _, err := conn.Write([]byte("+OK\r\n")); err != nil {
 		return err
 	}
+	if peer == "" {
+		s.repl.add(conn, pos, member)
+	}
 
 	s.mu.RLock()
-	f, err := os.Open(s.aof.Name())
+	f, err := s.openAOFReader()
 	s.mu.RUnlock()
 	if err != nil {
 		return err
 	}
 	defer f.Close()
+	var w io.Writer = conn
+	if len(keys) > 0 || peer != "" {
+		// only the commands for the followed collections, or the commands
+		// that did not come from the peer, are sent
+		tail, err := aofFilterTailAt(f, pos)
+		if err != nil {
+			return err
+		}
+		af := newAOFFilter(conn, keys, pos, tail)
+		if peer != "" {
+			af.peer, af.origin = peer, s.config.serverID()
+		}
+		w = af
+	}
 	if _, err := f.Seek(pos, 0); err != nil {
 		return err
 	}
@@ -520,17 +690,29 @@ func (s *Server) liveAOF(pos int64, conn net.Conn, rd *PipelineReader, msg *Mess
 			vs, err := rd.ReadMessages()
 			if err != nil {
 				if err != io.EOF {
-					log.Error(err)
+					replLog.Error(err)
 				}
 				return
 			}
 			for _, v := range vs {
 				switch v.Command() {
 				default:
-					log.Error("received a live command that was not QUIT")
+					replLog.Error("received a live command that was not QUIT")
 					return
 				case "quit", "":
 					return
+				case "replconf":
+					// REPLCONF ACK offset
+					if len(v.Args) != 3 || strings.ToLower(v.Args[1]) != "ack" {
+						replLog.Error("received an invalid REPLCONF")
+						return
+					}
+					offset, err := strconv.ParseInt(v.Args[2], 10, 64)
+					if err != nil {
+						replLog.Error("received an invalid REPLCONF")
+						return
+					}
+					s.repl.ack(conn, offset)
 				}
 			}
 		}
@@ -543,7 +725,7 @@ func (s *Server) liveAOF(pos int64, conn net.Conn, rd *PipelineReader, msg *Mess
 			cond.L.Unlock()
 		}()
 		err := func() error {
-			_, err := io.Copy(conn, f)
+			_, err := io.Copy(w, f)
 			if err != nil {
 				return err
 			}
@@ -551,17 +733,20 @@ func (s *Server) liveAOF(pos int64, conn net.Conn, rd *PipelineReader, msg *Mess
 			b := make([]byte, 4096)
 			// The reader needs to be OK with the eof not
 			for {
+				// The read happens under the condition lock so that a flush
+				// cannot be missed between the read and the wait.
+				s.fcond.L.Lock()
 				n, err := f.Read(b)
 				if err != io.EOF && n > 0 {
+					s.fcond.L.Unlock()
 					if err != nil {
 						return err
 					}
-					if _, err := conn.Write(b[:n]); err != nil {
+					if _, err := w.Write(b[:n]); err != nil {
 						return err
 					}
 					continue
 				}
-				s.fcond.L.Lock()
 				s.fcond.Wait()
 				s.fcond.L.Unlock()
 			}
@@ -569,7 +754,7 @@ func (s *Server) liveAOF(pos int64, conn net.Conn, rd *PipelineReader, msg *Mess
 		if err != nil {
 			if !strings.Contains(err.Error(), "use of closed network connection") &&
 				!strings.Contains(err.Error(), "bad file descriptor") {
-				log.Error(err)
+				replLog.Error(err)
 			}
 			return
 		}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFlushClientAOF(t *testing.T) {
+	s := newSnapshotTestServer()
+	s.fcond = sync.NewCond(&sync.Mutex{})
+	if err := s.config.setProperty(AppendFsync, "always", false); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	s.aof, err = openCryptFile(filepath.Join(t.TempDir(), "appendonly.aof"),
+		os.O_CREATE|os.O_RDWR, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.aof.Close()
+	p := &aofPersister{s}
+
+	// the write of a client is flushed by another client, without a sync,
+	// which is the sync of its reply.
+	a := &Client{}
+	if err := p.write([]string{"set", "fleet", "truck1", "point", "33", "-115"}); err != nil {
+		t.Fatal(err)
+	}
+	a.aofpos = s.aofwrote.get()
+	s.flushAOF(false)
+	if s.aofsynced.get() >= a.aofpos || !s.aofunsynced {
+		t.Fatal("expected an unsynced write")
+	}
+	s.flushClientAOF(a)
+	if s.aofsynced.get() < a.aofpos || s.aofunsynced {
+		t.Fatal("expected a synced write")
+	}
+
+	// the writes of the other clients do not hold up the reply
+	if err := p.write([]string{"set", "fleet", "truck2", "point", "33", "-115"}); err != nil {
+		t.Fatal(err)
+	}
+	s.flushClientAOF(a)
+	if s.aofflushed.get() == s.aofwrote.get() {
+		t.Fatal("expected an unflushed write")
+	}
+	b := &Client{aofpos: s.aofwrote.get()}
+	s.flushClientAOF(b)
+	if s.aofsynced.get() != s.aofwrote.get() {
+		t.Fatal("expected the synced writes")
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/core"
+)
+
+var errAOFZeros = errors.New("zeros found in aof")
+
+// aofCheck is the result of an aof integrity check.
+type aofCheck struct {
+	size     int64  // plaintext size of the aof
+	valid    int64  // offset following the last complete command
+	commands int    // number of complete commands
+	status   string // "ok", "truncated", or "corrupt"
+	err      error  // the problem found at the valid offset, if any
+}
+
+// checkAOF reads the first size bytes of an aof and returns the offset
+// following the last complete command. The aof is truncated when it ends
+// with an incomplete command, such as from a full disk, and corrupt when it
+// has data that is not a command.
+func checkAOF(r io.Reader, size int64) aofCheck {
+	res := aofCheck{size: size, status: "ok"}
+	var pending []byte
+	var args [][]byte
+	var zeros int64
+	packet := make([]byte, 0xFFFF)
+	for read := int64(0); read < size; {
+		p := packet
+		if int64(len(p)) > size-read {
+			p = p[:size-read]
+		}
+		n, err := r.Read(p)
+		read += int64(n)
+		buf := append(pending, p[:n]...)
+		for len(buf) > 0 {
+			if buf[0] == 0 || zeros > 0 {
+				// Trailing zeros are left behind by some filesystems after
+				// a crash. See issue #230.
+				for len(buf) > 0 && buf[0] == 0 {
+					zeros++
+					buf = buf[1:]
+				}
+				if len(buf) > 0 {
+					res.status, res.err = "corrupt", errAOFZeros
+					return res
+				}
+				break
+			}
+			if buf[0] != '*' {
+				res.status = "corrupt"
+				res.err = fmt.Errorf("invalid data at offset %d", res.valid)
+				return res
+			}
+			complete, nargs, _, rest, err := redcon.ReadNextCommand(buf, args[:0])
+			if err != nil {
+				res.status, res.err = "corrupt", err
+				return res
+			}
+			if !complete {
+				break
+			}
+			args = nargs
+			res.valid += int64(len(buf) - len(rest))
+			res.commands++
+			buf = rest
+		}
+		pending = append(pending[:0], buf...)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			res.status, res.err = "corrupt", err
+			return res
+		}
+	}
+	if zeros > 0 {
+		res.status, res.err = "corrupt", errAOFZeros
+	} else if len(pending) > 0 {
+		res.status, res.err = "truncated", io.ErrUnexpectedEOF
+	}
+	return res
+}
+
+// fixAOF truncates the aof to the last complete command.
+func fixAOF(f *cryptFile, res aofCheck) error {
+	if err := f.Truncate(res.valid); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	_, err := f.Seek(res.valid, 0)
+	return err
+}
+
+// CheckAOF checks the aof in the data directory for an incomplete or corrupt
+// tail, and writes a report to w. When fix is true the aof is truncated to
+// the last complete command. Returns false when the aof has a problem that
+// was not fixed.
+func CheckAOF(dir string, fix bool, w io.Writer) (ok bool, err error) {
+	name := core.AppendFileName
+	if name == "" {
+		name = path.Join(dir, "appendonly.aof")
+	}
+	aead, err := loadEncryptionKey()
+	if err != nil {
+		return false, err
+	}
+	flag := os.O_RDONLY
+	if fix {
+		flag = os.O_RDWR
+	}
+	f, err := openCryptFile(name, flag, aead)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	size, err := f.Size()
+	if err != nil {
+		return false, err
+	}
+	res := checkAOF(f, size)
+	fmt.Fprintf(w, "aof: %s\n", name)
+	fmt.Fprintf(w, "size: %d\n", res.size)
+	fmt.Fprintf(w, "commands: %d\n", res.commands)
+	fmt.Fprintf(w, "valid_offset: %d\n", res.valid)
+	fmt.Fprintf(w, "status: %s\n", res.status)
+	if res.err != nil {
+		fmt.Fprintf(w, "error: %v\n", res.err)
+	}
+	if res.status == "ok" {
+		return true, nil
+	}
+	if !fix {
+		return false, nil
+	}
+	if err := fixAOF(f, res); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(w, "truncated %d bytes\n", res.size-res.valid)
+	return true, nil
+}
+
+// AOFCHECK [FIX]
+func (s *Server) cmdAOFCheck(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var fix bool
+	if len(vs) > 0 {
+		if len(vs) > 1 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		if strings.ToLower(vs[0]) != "fix" {
+			return NOMessage, errInvalidArgument(vs[0])
+		}
+		fix = true
+	}
+	if s.aof == nil {
+		return NOMessage, errors.New("aof disabled")
+	}
+	if fix && s.shrinking {
+		return NOMessage, errors.New("aof shrink in progress")
+	}
+	s.flushAOF(false)
+	f, err := s.openAOFReader()
+	if err != nil {
+		return NOMessage, err
+	}
+	defer f.Close()
+	size, err := f.Size()
+	if err != nil {
+		return NOMessage, err
+	}
+	check := checkAOF(f, size)
+	var fixed bool
+	if fix && check.status != "ok" {
+		if err := fixAOF(s.aof, check); err != nil {
+			return NOMessage, err
+		}
+		s.aofsz = int(check.valid)
+		s.resetBacklog(true)
+		fixed = true
+	}
+	var errmsg string
+	if check.err != nil {
+		errmsg = check.err.Error()
+	}
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"status":`...)
+		buf = appendJSONString(buf, check.status)
+		buf = append(buf, `,"size":`...)
+		buf = strconv.AppendInt(buf, check.size, 10)
+		buf = append(buf, `,"commands":`...)
+		buf = strconv.AppendInt(buf, int64(check.commands), 10)
+		buf = append(buf, `,"valid_offset":`...)
+		buf = strconv.AppendInt(buf, check.valid, 10)
+		if errmsg != "" {
+			buf = append(buf, `,"error":`...)
+			buf = appendJSONString(buf, errmsg)
+		}
+		buf = append(buf, `,"fixed":`...)
+		buf = strconv.AppendBool(buf, fixed)
+		buf = append(buf, `,"elapsed":"`...)
+		buf = append(buf, time.Since(start).String()...)
+		buf = append(buf, `"}`...)
+		res = resp.StringValue(string(buf))
+	case RESP:
+		m := map[string]interface{}{
+			"status":       check.status,
+			"size":         check.size,
+			"commands":     check.commands,
+			"valid_offset": check.valid,
+			"fixed":        fixed,
+		}
+		if errmsg != "" {
+			m["error"] = errmsg
+		}
+		res = resp.ArrayValue(respValuesSimpleMap(m))
+	}
+	return res, nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/redcon"
+)
+
+func TestCheckAOF(t *testing.T) {
+	var aof []byte
+	for i := 0; i < 10000; i++ {
+		aof = redcon.AppendArray(aof, 5)
+		for _, arg := range []string{"set", "fleet", "truck", "point", "33"} {
+			aof = redcon.AppendBulkString(aof, arg)
+		}
+	}
+	cmdsz := int64(len(aof) / 10000)
+	size := int64(len(aof))
+
+	check := func(data []byte) aofCheck {
+		return checkAOF(bytes.NewReader(data), int64(len(data)))
+	}
+	res := check(aof)
+	if res.status != "ok" || res.valid != size || res.commands != 10000 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	res = check(aof[:size-3])
+	if res.status != "truncated" || res.valid != size-cmdsz ||
+		res.err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	res = check(append(append([]byte{}, aof...), 0, 0, 0))
+	if res.status != "corrupt" || res.valid != size || res.err != errAOFZeros {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	corrupt := append([]byte{}, aof...)
+	copy(corrupt[cmdsz*5000:], "garbage")
+	res = check(corrupt)
+	if res.status != "corrupt" || res.valid != cmdsz*5000 || res.commands != 5000 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	res = check(nil)
+	if res.status != "ok" || res.valid != 0 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	dir, err := ioutil.TempDir("", "aofcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "appendonly.aof")
+	if err := ioutil.WriteFile(name, aof[:size-3], 0600); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	ok, err := CheckAOF(dir, false, &out)
+	if err != nil || ok || !strings.Contains(out.String(), "status: truncated") {
+		t.Fatalf("unexpected result: %v %v\n%s", ok, err, out.String())
+	}
+	out.Reset()
+	if ok, err = CheckAOF(dir, true, &out); err != nil || !ok {
+		t.Fatalf("unexpected result: %v %v\n%s", ok, err, out.String())
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, aof[:size-cmdsz]) {
+		t.Fatal("expected the aof to be truncated to the last command")
+	}
+	out.Reset()
+	if ok, err = CheckAOF(dir, false, &out); err != nil || !ok {
+		t.Fatalf("unexpected result: %v %v\n%s", ok, err, out.String())
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+	"github.com/tidwall/tile38/internal/glob"
+)
+
+// aofFilterTail is the size of the aof data, before a position, that is used
+// for verifying the position when a filtered follower reconnects.
+const aofFilterTail = 512
+
+// aofFilter is a writer for the live aof of a follower that only follows the
+// collections matching a set of key patterns. The aof data written to it is
+// passed on as the commands for the matching collections, and commands that
+// do not belong to a collection, such as SETHOOK.
+//
+// The aof of a filtered follower is not a copy of the leader's aof, so after
+// each write a "REPLCONF POS offset md5" command is passed on with the
+// leader's aof offset following the last command and the checksum of the
+// aofFilterTail bytes before it. The follower uses them to resume from the
+// same offset after a reconnect.
+//
+// For a peer, the commands that did not come from the peer itself are passed
+// on. The commands without a version, which were written before peering
+// started, are passed on with the oldest version from the origin server.
+type aofFilter struct {
+	w       io.Writer
+	keys    []string
+	peer    string // the server id of the peer
+	origin  string // the server id of this server
+	pos     int64  // the aof offset following the last complete command
+	marked  int64  // the offset of the last REPLCONF POS
+	pending []byte // the start of an incomplete command
+	inTx    bool   // the commands follow the MULTI of a transaction
+	tail    []byte // the aof data before pos
+	args    [][]byte
+	out     []byte
+}
+
+func newAOFFilter(w io.Writer, keys []string, pos int64, tail []byte) *aofFilter {
+	f := &aofFilter{w: w, keys: keys, pos: pos, marked: pos}
+	f.pushTail(tail)
+	return f
+}
+
+func (f *aofFilter) Write(p []byte) (int, error) {
+	f.pending = append(f.pending, p...)
+	buf := f.pending
+	for len(buf) > 0 {
+		if buf[0] != '*' {
+			return 0, fmt.Errorf("invalid aof data at offset %d", f.pos)
+		}
+		complete, args, _, rest, err := redcon.ReadNextCommand(buf, f.args[:0])
+		if err != nil {
+			return 0, err
+		}
+		if !complete {
+			break
+		}
+		f.args = args
+		cmd := buf[:len(buf)-len(rest)]
+		f.filter(args, cmd)
+		f.pushTail(cmd)
+		f.pos += int64(len(cmd))
+		buf = rest
+	}
+	f.pending = f.pending[:copy(f.pending, buf)]
+	if f.pos != f.marked && !f.inTx {
+		// a position is not marked inside of a transaction, which the
+		// follower applies once its EXEC is received
+		f.marked = f.pos
+		f.out = redcon.AppendArray(f.out, 4)
+		f.out = redcon.AppendBulkString(f.out, "replconf")
+		f.out = redcon.AppendBulkString(f.out, "pos")
+		f.out = redcon.AppendBulkString(f.out, strconv.FormatInt(f.pos, 10))
+		f.out = redcon.AppendBulkString(f.out, fmt.Sprintf("%x", md5.Sum(f.tail)))
+	}
+	if len(f.out) > 0 {
+		if _, err := f.w.Write(f.out); err != nil {
+			return 0, err
+		}
+		f.out = f.out[:0]
+	}
+	return len(p), nil
+}
+
+// filter appends the command to the output when it belongs to a matching
+// collection, or to no collection at all.
+func (f *aofFilter) filter(args [][]byte, cmd []byte) {
+	if len(args) == 1 {
+		switch strings.ToLower(string(args[0])) {
+		case "multi", "exec":
+			f.inTx = strings.ToLower(string(args[0])) == "multi"
+			if f.peer != "" {
+				// the commands of a transaction are passed on to a peer
+				// one at a time
+				return
+			}
+		}
+	}
+	if f.peer != "" {
+		f.filterPeer(args, cmd)
+		return
+	}
+	if len(args) > 3 && strings.ToLower(string(args[0])) == "lww" {
+		// match the versioned command
+		args = args[3:]
+	}
+	if len(args) < 2 {
+		f.out = append(f.out, cmd...)
+		return
+	}
+	switch strings.ToLower(string(args[0])) {
+	default:
+		f.out = append(f.out, cmd...)
+	case "set", "pset", "fset", "fincrby", "jset", "jdel", "del", "pdel",
+		"expire", "persist", "drop", "setschema", "delschema", "setlimits", "dellimits", "setindex", "delindex", "setgeoindex",
+		"setlabel", "dellabel":
+		if f.match(args[1]) {
+			f.out = append(f.out, cmd...)
+		}
+	case "rename", "renamenx":
+		if len(args) < 3 {
+			return
+		}
+		src, dst := f.match(args[1]), f.match(args[2])
+		if src && dst {
+			f.out = append(f.out, cmd...)
+		} else if src {
+			// The collection was renamed to a key that is not followed.
+			f.out = redcon.AppendArray(f.out, 2)
+			f.out = redcon.AppendBulkString(f.out, "drop")
+			f.out = redcon.AppendBulkString(f.out, string(args[1]))
+		}
+	case "copy":
+		// The copy needs the source collection, so it's only kept when
+		// both keys are followed.
+		if len(args) >= 3 && f.match(args[1]) && f.match(args[2]) {
+			f.out = append(f.out, cmd...)
+		}
+	}
+}
+
+// filterPeer appends the command to the output when it did not come from the
+// peer.
+func (f *aofFilter) filterPeer(args [][]byte, cmd []byte) {
+	if len(args) == 0 {
+		return
+	}
+	if strings.ToLower(string(args[0])) == "hooklease" {
+		// the lease of the follower hooks is given by each peer to its own
+		// followers
+		return
+	}
+	if strings.ToLower(string(args[0])) == "lww" {
+		if len(args) > 3 && string(args[2]) != f.peer {
+			f.out = append(f.out, cmd...)
+		}
+		return
+	}
+	f.out = redcon.AppendArray(f.out, len(args)+3)
+	f.out = redcon.AppendBulkString(f.out, "lww")
+	f.out = redcon.AppendBulkString(f.out, "0")
+	f.out = redcon.AppendBulkString(f.out, f.origin)
+	for _, arg := range args {
+		f.out = redcon.AppendBulk(f.out, arg)
+	}
+}
+
+func (f *aofFilter) match(key []byte) bool {
+	for _, pattern := range f.keys {
+		if ok, _ := glob.Match(pattern, string(key)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *aofFilter) pushTail(b []byte) {
+	if len(b) >= aofFilterTail {
+		f.tail = append(f.tail[:0], b[len(b)-aofFilterTail:]...)
+		return
+	}
+	if over := len(f.tail) + len(b) - aofFilterTail; over > 0 {
+		f.tail = f.tail[:copy(f.tail, f.tail[over:])]
+	}
+	f.tail = append(f.tail, b...)
+}
+
+// aofFilterTailAt reads the aof data before pos, which is used for the
+// checksums of the positions of a filtered follower.
+func aofFilterTailAt(f *cryptFile, pos int64) ([]byte, error) {
+	start := pos - aofFilterTail
+	if start < 0 {
+		start = 0
+	}
+	if _, err := f.Seek(start, 0); err != nil {
+		return nil, err
+	}
+	tail := make([]byte, pos-start)
+	if _, err := io.ReadFull(f, tail); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("pos is too big")
+		}
+		return nil, err
+	}
+	return tail, nil
+}
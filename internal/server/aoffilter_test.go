@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/redcon"
+)
+
+func TestAOFFilter(t *testing.T) {
+	var aof []byte
+	var cmds []string
+	for i := 0; i < 20; i++ {
+		cmds = append(cmds, fmt.Sprintf("set other truck%d point 33 -115", i))
+	}
+	for _, cmd := range append(cmds, []string{
+		"set fleet:1 truck1 point 33 -115",
+		"set other truck1 point 33 -115",
+		"sethook hook1 http://localhost:1 nearby zones point 33 -115 100",
+		"del fleet:1 truck1",
+		"rename fleet:1 fleet:2",
+		"rename fleet:2 other",
+		"rename other fleet:3",
+		"flushdb",
+	}...) {
+		args := strings.Split(cmd, " ")
+		aof = redcon.AppendArray(aof, len(args))
+		for _, arg := range args {
+			aof = redcon.AppendBulkString(aof, arg)
+		}
+	}
+	var out bytes.Buffer
+	f := newAOFFilter(&out, []string{"fleet:*", "zones"}, 0, nil)
+	// write one byte at a time to test incomplete commands
+	for i := 0; i < len(aof); i++ {
+		if _, err := f.Write(aof[i : i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.pos != int64(len(aof)) {
+		t.Fatalf("expected %d, got %d", len(aof), f.pos)
+	}
+	cmds = nil
+	var last []string
+	rest := out.Bytes()
+	for len(rest) > 0 {
+		complete, args, _, nrest, err := redcon.ReadNextCommand(rest, nil)
+		if err != nil || !complete {
+			t.Fatalf("invalid output: %v", err)
+		}
+		rest = nrest
+		var sargs []string
+		for _, arg := range args {
+			sargs = append(sargs, string(arg))
+		}
+		if sargs[0] == "replconf" {
+			last = sargs
+			continue
+		}
+		cmds = append(cmds, strings.Join(sargs, " "))
+	}
+	expect := []string{
+		"set fleet:1 truck1 point 33 -115",
+		"sethook hook1 http://localhost:1 nearby zones point 33 -115 100",
+		"del fleet:1 truck1",
+		"rename fleet:1 fleet:2",
+		"drop fleet:2",
+		"flushdb",
+	}
+	if strings.Join(cmds, "\n") != strings.Join(expect, "\n") {
+		t.Fatalf("expected\n%s\ngot\n%s", strings.Join(expect, "\n"),
+			strings.Join(cmds, "\n"))
+	}
+	tail := aof
+	if len(tail) > aofFilterTail {
+		tail = tail[len(tail)-aofFilterTail:]
+	}
+	sum := fmt.Sprintf("%x", md5.Sum(tail))
+	if len(last) != 4 || last[2] != fmt.Sprint(len(aof)) || last[3] != sum {
+		t.Fatalf("unexpected replconf: %v", last)
+	}
+
+	// invalid data
+	f = newAOFFilter(&out, []string{"fleet:*"}, 0, nil)
+	if _, err := f.Write([]byte("hello\r\n")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestAOFFilterPeer(t *testing.T) {
+	var aof []byte
+	for _, cmd := range []string{
+		"set fleet truck1 point 33 -115",
+		"lww 100 a set fleet truck2 point 33 -115",
+		"lww 101 b set fleet truck3 point 33 -115",
+		"del fleet truck1",
+	} {
+		args := strings.Split(cmd, " ")
+		aof = redcon.AppendArray(aof, len(args))
+		for _, arg := range args {
+			aof = redcon.AppendBulkString(aof, arg)
+		}
+	}
+	var out bytes.Buffer
+	f := newAOFFilter(&out, nil, 0, nil)
+	f.peer, f.origin = "b", "a"
+	if _, err := f.Write(aof); err != nil {
+		t.Fatal(err)
+	}
+	var cmds []string
+	rest := out.Bytes()
+	for len(rest) > 0 {
+		complete, args, _, nrest, err := redcon.ReadNextCommand(rest, nil)
+		if err != nil || !complete {
+			t.Fatalf("invalid output: %v", err)
+		}
+		rest = nrest
+		var sargs []string
+		for _, arg := range args {
+			sargs = append(sargs, string(arg))
+		}
+		if sargs[0] != "replconf" {
+			cmds = append(cmds, strings.Join(sargs, " "))
+		}
+	}
+	expect := []string{
+		"lww 0 a set fleet truck1 point 33 -115",
+		"lww 100 a set fleet truck2 point 33 -115",
+		"lww 0 a del fleet truck1",
+	}
+	if strings.Join(cmds, "\n") != strings.Join(expect, "\n") {
+		t.Fatalf("expected\n%s\ngot\n%s", strings.Join(expect, "\n"),
+			strings.Join(cmds, "\n"))
+	}
+}
+
+func TestAOFFilterTx(t *testing.T) {
+	var cmds [][]byte
+	for _, cmd := range []string{
+		"multi", "del fleet:1 truck1", "set other truck1 point 33 -115", "exec",
+	} {
+		args := strings.Split(cmd, " ")
+		b := redcon.AppendArray(nil, len(args))
+		for _, arg := range args {
+			b = redcon.AppendBulkString(b, arg)
+		}
+		cmds = append(cmds, b)
+	}
+	read := func(out []byte) []string {
+		var cmds []string
+		for len(out) > 0 {
+			complete, args, _, rest, err := redcon.ReadNextCommand(out, nil)
+			if err != nil || !complete {
+				t.Fatalf("invalid output: %v", err)
+			}
+			out = rest
+			cmds = append(cmds, string(args[0]))
+		}
+		return cmds
+	}
+	var out bytes.Buffer
+	f := newAOFFilter(&out, []string{"fleet:*"}, 0, nil)
+	// the position is marked once the transaction is complete
+	for _, cmd := range cmds {
+		if _, err := f.Write(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := strings.Join(read(out.Bytes()), " ")
+	if expect := "multi del exec replconf"; got != expect {
+		t.Fatalf("expected %q, got %q", expect, got)
+	}
+	// the commands are passed on to a peer one at a time
+	out.Reset()
+	f = newAOFFilter(&out, nil, 0, nil)
+	f.peer, f.origin = "peer", "origin"
+	for _, cmd := range cmds {
+		if _, err := f.Write(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got = strings.Join(read(out.Bytes()), " ")
+	if expect := "lww lww replconf"; got != expect {
+		t.Fatalf("expected %q, got %q", expect, got)
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/tile38/internal/collection"
+)
+
+// aofLoadBatch is the number of commands of the aof that are parsed at once
+// by a worker of the loader.
+const aofLoadBatch = 256
+
+// aofLoader applies the commands that are read from the aof, in the order of
+// the aof, while the objects of the commands that follow are parsed by other
+// goroutines. The geometries of the objects, which are most of the work of a
+// load, are parsed in parallel, and each command is applied by one goroutine
+// because the commands of all keys share the state of the server, such as the
+// expires and the hooks. The objects are indexed per collection in parallel
+// at the end of the load, see endBulkLoad.
+type aofLoader struct {
+	s       *Server
+	apply   func(args []string) error
+	opts    geojson.ParseOptions // the options that the objects are parsed with
+	batch   []aofCommand
+	work    chan *aofBatch // to the workers
+	batches chan *aofBatch // to the applier, in the order of the aof
+	done    chan error
+	failed  int32
+	closed  bool
+	err     error
+}
+
+// aofBatch is commands of the aof that are parsed by a worker.
+type aofBatch struct {
+	cmds   []aofCommand
+	parsed chan struct{}
+}
+
+// aofCommand is a command of the aof, and the object of a SET, which is
+// parsed ahead of the command.
+type aofCommand struct {
+	args []string
+	obj  *parsedObject
+}
+
+// parsedObject is the geometry of a SET that was parsed ahead of the command.
+type parsedObject struct {
+	object string
+	opts   geojson.ParseOptions
+	obj    geojson.Object
+	err    error
+}
+
+func (s *Server) newAOFLoader(apply func(args []string) error) *aofLoader {
+	n := runtime.GOMAXPROCS(0)
+	l := &aofLoader{
+		s:       s,
+		apply:   apply,
+		opts:    s.geomParseOpts,
+		work:    make(chan *aofBatch, n),
+		batches: make(chan *aofBatch, n*2),
+		done:    make(chan error, 1),
+	}
+	for i := 0; i < n; i++ {
+		go l.parse()
+	}
+	go l.applyBatches()
+	return l
+}
+
+// add adds a command that is applied after the commands that were added
+// before it. It returns false once a command failed, which is returned by
+// close.
+func (l *aofLoader) add(args []string) bool {
+	l.batch = append(l.batch, aofCommand{args: args})
+	if len(l.batch) == aofLoadBatch {
+		l.flush()
+	}
+	return atomic.LoadInt32(&l.failed) == 0
+}
+
+func (l *aofLoader) flush() {
+	if len(l.batch) == 0 {
+		return
+	}
+	b := &aofBatch{cmds: l.batch, parsed: make(chan struct{})}
+	l.batch = make([]aofCommand, 0, aofLoadBatch)
+	l.batches <- b
+	l.work <- b
+}
+
+// close applies the commands that are left, and returns the error of the
+// command that failed.
+func (l *aofLoader) close() error {
+	if l.closed {
+		return l.err
+	}
+	l.closed = true
+	l.flush()
+	close(l.work)
+	close(l.batches)
+	l.err = <-l.done
+	return l.err
+}
+
+// parse parses the objects of the batches.
+func (l *aofLoader) parse() {
+	for b := range l.work {
+		for i := range b.cmds {
+			object, ok := setObject(b.cmds[i].args)
+			if !ok {
+				continue
+			}
+			p := &parsedObject{object: object, opts: l.opts}
+			p.obj, p.err = geojson.Parse(object, &p.opts)
+			b.cmds[i].obj = p
+		}
+		close(b.parsed)
+	}
+}
+
+// applyBatches applies the commands of the batches in order.
+func (l *aofLoader) applyBatches() {
+	var err error
+	for b := range l.batches {
+		<-b.parsed
+		if err != nil {
+			// the batches that were added before the failure was seen
+			continue
+		}
+		for _, cmd := range b.cmds {
+			l.s.parsed = cmd.obj
+			err = l.apply(cmd.args)
+			l.s.parsed = nil
+			if err != nil {
+				atomic.StoreInt32(&l.failed, 1)
+				break
+			}
+		}
+	}
+	l.done <- err
+}
+
+// setObject returns the json of a SET with an OBJECT, which is the last
+// argument of the command.
+func setObject(args []string) (string, bool) {
+	if len(args) < 5 || !strings.EqualFold(args[0], "set") ||
+		!strings.EqualFold(args[len(args)-2], "object") {
+		return "", false
+	}
+	return args[len(args)-1], true
+}
+
+// parseObject parses the json of the object of a key, or returns the object
+// that was parsed ahead by the loader of the aof.
+func (s *Server) parseObject(key, object string) (geojson.Object, error) {
+	opts := s.parseOpts(key)
+	if p := s.parsed; p != nil && p.object == object && p.opts == *opts {
+		return p.obj, p.err
+	}
+	return geojson.Parse(object, opts)
+}
+
+// parallelCols calls fn for each collection, at once by one goroutine for
+// each CPU.
+func (s *Server) parallelCols(fn func(col *collection.Collection)) {
+	var cols []*collection.Collection
+	s.cols.Ascend(nil, func(v interface{}) bool {
+		cols = append(cols, v.(*collectionKeyContainer).col)
+		return true
+	})
+	var wg sync.WaitGroup
+	next := int64(-1)
+	for i := 0; i < runtime.GOMAXPROCS(0) && i < len(cols); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j := int(atomic.AddInt64(&next, 1))
+				if j >= len(cols) {
+					return
+				}
+				fn(cols[j])
+			}
+		}()
+	}
+	wg.Wait()
+}
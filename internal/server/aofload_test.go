@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestAOFLoader(t *testing.T) {
+	s := newSnapshotTestServer()
+	var applied []string
+	var parsed int
+	l := s.newAOFLoader(func(args []string) error {
+		applied = append(applied, args[2])
+		if object, ok := setObject(args); ok {
+			obj, err := s.parseObject(args[1], object)
+			if err != nil {
+				return err
+			}
+			if s.parsed == nil || obj != s.parsed.obj {
+				return errors.New("the object was not parsed ahead")
+			}
+			parsed++
+		}
+		return nil
+	})
+	const n = 1000
+	for i := 0; i < n; i++ {
+		id := strconv.Itoa(i)
+		args := []string{"set", "fleet", id, "point", "33", "-112"}
+		if i%2 == 0 {
+			args = []string{"set", "fleet", id, "FIELD", "speed", "10",
+				"OBJECT", fmt.Sprintf(`{"type":"Point","coordinates":[%d,33]}`,
+					i%180)}
+		}
+		if !l.add(args) {
+			t.Fatal("expected no failure")
+		}
+	}
+	if err := l.close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != n || parsed != n/2 {
+		t.Fatalf("expected %d commands and %d objects, got %d and %d", n, n/2,
+			len(applied), parsed)
+	}
+	for i, id := range applied {
+		if id != strconv.Itoa(i) {
+			t.Fatalf("command %d: expected id %d, got %s", i, i, id)
+		}
+	}
+
+	// the commands that follow a failure are not applied
+	applied = applied[:0]
+	l = s.newAOFLoader(func(args []string) error {
+		if args[2] == "10" {
+			return errors.New("failed")
+		}
+		applied = append(applied, args[2])
+		return nil
+	})
+	for i := 0; i < n && l.add([]string{"del", "fleet", strconv.Itoa(i)}); i++ {
+	}
+	if err := l.close(); err == nil || err.Error() != "failed" {
+		t.Fatalf("expected 'failed', got %v", err)
+	}
+	if len(applied) != 10 {
+		t.Fatalf("expected 10 commands, got %d", len(applied))
+	}
+}
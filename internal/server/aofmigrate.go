@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/tidwall/resp"
-	"github.com/tidwall/tile38/internal/log"
 )
 
 var errCorruptedAOF = errors.New("corrupted aof file")
@@ -98,7 +97,7 @@ func (s *Server) migrateAOF() error {
 		}
 		return err
 	}
-	log.Warn("Migrating aof to new format")
+	aofLog.Warn("Migrating aof to new format")
 	newf, err := os.Create(path.Join(s.dir, "migrate.aof"))
 	if err != nil {
 		return err
@@ -154,6 +153,6 @@ func (s *Server) migrateAOF() error {
 	}
 	oldf.Close()
 	newf.Close()
-	log.Debugf("%d items: %.0f/sec", count, float64(count)/(float64(time.Since(start))/float64(time.Second)))
+	aofLog.Debugf("%d items: %.0f/sec", count, float64(count)/(float64(time.Since(start))/float64(time.Second)))
 	return os.Rename(path.Join(s.dir, "migrate.aof"), path.Join(s.dir, "appendonly.aof"))
 }
@@ -12,13 +12,43 @@ import (
 	"github.com/tidwall/rhh"
 	"github.com/tidwall/tile38/core"
 	"github.com/tidwall/tile38/internal/collection"
-	"github.com/tidwall/tile38/internal/log"
+	"github.com/tidwall/tile38/internal/field"
 )
 
-const maxkeys = 8
-const maxids = 32
 const maxchunk = 4 * 1024 * 1024
 
+// maxtail is the number of pending tail commands that are allowed to remain
+// before the final swap, which happens while holding the server lock.
+const maxtail = 1024
+
+// snapshotCol is a point-in-time view of a single collection, and the object
+// expirations that belong to it.
+type snapshotCol struct {
+	key     string
+	snap    *collection.Snapshot
+	expires map[string]int64
+}
+
+// appendAOFCommand appends a command to an aof buffer.
+func appendAOFCommand(aofbuf []byte, values []string) []byte {
+	aofbuf = append(aofbuf, '*')
+	aofbuf = append(aofbuf, strconv.FormatInt(int64(len(values)), 10)...)
+	aofbuf = append(aofbuf, '\r', '\n')
+	for _, value := range values {
+		aofbuf = append(aofbuf, '$')
+		aofbuf = append(aofbuf, strconv.FormatInt(int64(len(value)), 10)...)
+		aofbuf = append(aofbuf, '\r', '\n')
+		aofbuf = append(aofbuf, value...)
+		aofbuf = append(aofbuf, '\r', '\n')
+	}
+	return aofbuf
+}
+
+// aofshrink rewrites the aof in the background. A snapshot of the dataset is
+// taken while briefly holding the server lock, and is then written to a new
+// file without blocking other clients. Writes that happen after the snapshot
+// are collected in the shrinklog and applied to the end of the new file
+// before it replaces the live aof.
 func (server *Server) aofshrink() {
 	if server.aof == nil {
 		return
@@ -31,197 +61,76 @@ func (server *Server) aofshrink() {
 	}
 	server.shrinking = true
 	server.shrinklog = nil
+	cols, hooks := server.datasetSnapshot()
+	hooks = append(hooks, server.hookLeaseCommands()...)
 	server.mu.Unlock()
+	aofLog.Infof("aof shrink snapshot took %v", time.Since(start))
 
 	defer func() {
 		server.mu.Lock()
 		server.shrinking = false
 		server.shrinklog = nil
 		server.mu.Unlock()
-		log.Infof("aof shrink ended %v", time.Since(start))
+		aofLog.Infof("aof shrink ended %v", time.Since(start))
 	}()
 
 	err := func() error {
-		f, err := os.Create(core.AppendFileName + "-shrink")
+		f, err := openCryptFile(core.AppendFileName+"-shrink",
+			os.O_CREATE|os.O_RDWR|os.O_TRUNC, server.aead)
 		if err != nil {
 			return err
 		}
 		defer f.Close()
 		var aofbuf []byte
 		var values []string
-		var keys []string
-		var nextkey string
-		var keysdone bool
-		for {
-			if len(keys) == 0 {
-				// load more keys
-				if keysdone {
-					break
-				}
-				keysdone = true
-				func() {
-					server.mu.Lock()
-					defer server.mu.Unlock()
-					server.scanGreaterOrEqual(nextkey, func(key string, col *collection.Collection) bool {
-						if len(keys) == maxkeys {
-							keysdone = false
-							nextkey = key
-							return false
-						}
-						keys = append(keys, key)
-						return true
-					})
-				}()
-				continue
-			}
+		for _, scol := range cols {
+			var fnames = scol.snap.FieldArr() // an array of field names to match each object
+			var fmap = scol.snap.FieldMap()   //
+			var now = time.Now().UnixNano()   // used for expiration
+			var werr error
+			scol.snap.Scan(false, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
+				// here we fill the values array with a new command
+				values = setCommand(values[:0], scol, id, obj, fields, fmap,
+					fnames, now)
 
-			var idsdone bool
-			var nextid string
-			for {
-				if idsdone {
-					keys = keys[1:]
-					break
-				}
-
-				// load more objects
-				func() {
-					idsdone = true
-					server.mu.Lock()
-					defer server.mu.Unlock()
-					col := server.getCol(keys[0])
-					if col == nil {
-						return
-					}
-					var fnames = col.FieldArr() // reload an array of field names to match each object
-					var fmap = col.FieldMap()   //
-					var exm *rhh.Map            // the expiration map
-					if value, ok := server.expires.Get(keys[0]); ok {
-						exm = value.(*rhh.Map)
-					}
-					var now = time.Now().UnixNano() // used for expiration
-					var count = 0                   // the object count
-					col.ScanGreaterOrEqual(nextid, false, nil, nil,
-						func(id string, obj geojson.Object, fields []float64) bool {
-							if count == maxids {
-								// we reached the max number of ids for one batch
-								nextid = id
-								idsdone = false
-								return false
-							}
-
-							// here we fill the values array with a new command
-							values = values[:0]
-							values = append(values, "set")
-							values = append(values, keys[0])
-							values = append(values, id)
-							if len(fields) > 0 {
-								fvs := orderFields(fmap, fnames, fields)
-								for _, fv := range fvs {
-									if fv.value != 0 {
-										values = append(values, "field")
-										values = append(values, fv.field)
-										values = append(values, strconv.FormatFloat(fv.value, 'f', -1, 64))
-									}
-								}
-							}
-							if exm != nil {
-								if at, ok := exm.Get(id); ok {
-									expires := at.(int64) - now
-									if expires > 0 {
-										values = append(values, "ex")
-										values = append(values, strconv.FormatFloat(math.Floor(float64(expires)/float64(time.Second)*10)/10, 'f', -1, 64))
-									}
-								}
-							}
-							if objIsSpatial(obj) {
-								values = append(values, "object")
-								values = append(values, string(obj.AppendJSON(nil)))
-							} else {
-								values = append(values, "string")
-								values = append(values, obj.String())
-							}
-
-							// append the values to the aof buffer
-							aofbuf = append(aofbuf, '*')
-							aofbuf = append(aofbuf, strconv.FormatInt(int64(len(values)), 10)...)
-							aofbuf = append(aofbuf, '\r', '\n')
-							for _, value := range values {
-								aofbuf = append(aofbuf, '$')
-								aofbuf = append(aofbuf, strconv.FormatInt(int64(len(value)), 10)...)
-								aofbuf = append(aofbuf, '\r', '\n')
-								aofbuf = append(aofbuf, value...)
-								aofbuf = append(aofbuf, '\r', '\n')
-							}
-
-							// increment the object count
-							count++
-							return true
-						},
-					)
-
-				}()
+				// append the values to the aof buffer
+				aofbuf = appendAOFCommand(aofbuf, values)
 				if len(aofbuf) > maxchunk {
-					if _, err := f.Write(aofbuf); err != nil {
-						return err
+					if _, werr = f.Write(aofbuf); werr != nil {
+						return false
 					}
 					aofbuf = aofbuf[:0]
 				}
+				return true
+			})
+			if werr != nil {
+				return werr
 			}
 		}
+		for _, values := range hooks {
+			aofbuf = appendAOFCommand(aofbuf, values)
+		}
+		if len(aofbuf) > 0 {
+			if _, err := f.Write(aofbuf); err != nil {
+				return err
+			}
+			aofbuf = aofbuf[:0]
+		}
 
-		// load hooks
-		// first load the names of the hooks
-		var hnames []string
-		func() {
+		// catch up on the writes that happened since the snapshot without
+		// holding the lock, until only a short tail remains.
+		for {
 			server.mu.Lock()
-			defer server.mu.Unlock()
-			for name := range server.hooks {
-				hnames = append(hnames, name)
+			tail := server.shrinklog
+			if len(tail) <= maxtail {
+				server.mu.Unlock()
+				break
+			}
+			server.shrinklog = nil
+			server.mu.Unlock()
+			for _, values := range tail {
+				aofbuf = appendAOFCommand(aofbuf, values)
 			}
-		}()
-		// sort the names for consistency
-		sort.Strings(hnames)
-		for _, name := range hnames {
-			func() {
-				server.mu.Lock()
-				defer server.mu.Unlock()
-				hook := server.hooks[name]
-				if hook == nil {
-					return
-				}
-				hook.cond.L.Lock()
-				defer hook.cond.L.Unlock()
-
-				var values []string
-				if hook.channel {
-					values = append(values, "setchan", name)
-				} else {
-					values = append(values, "sethook", name,
-						strings.Join(hook.Endpoints, ","))
-				}
-				for _, meta := range hook.Metas {
-					values = append(values, "meta", meta.Name, meta.Value)
-				}
-				if !hook.expires.IsZero() {
-					ex := float64(time.Until(hook.expires)) / float64(time.Second)
-					values = append(values, "ex",
-						strconv.FormatFloat(ex, 'f', 1, 64))
-				}
-				values = append(values, hook.Message.Args...)
-				// append the values to the aof buffer
-				aofbuf = append(aofbuf, '*')
-				aofbuf = append(aofbuf, strconv.FormatInt(int64(len(values)), 10)...)
-				aofbuf = append(aofbuf, '\r', '\n')
-				for _, value := range values {
-					aofbuf = append(aofbuf, '$')
-					aofbuf = append(aofbuf, strconv.FormatInt(int64(len(value)), 10)...)
-					aofbuf = append(aofbuf, '\r', '\n')
-					aofbuf = append(aofbuf, value...)
-					aofbuf = append(aofbuf, '\r', '\n')
-				}
-			}()
-		}
-		if len(aofbuf) > 0 {
 			if _, err := f.Write(aofbuf); err != nil {
 				return err
 			}
@@ -242,17 +151,7 @@ func (server *Server) aofshrink() {
 
 			aofbuf = aofbuf[:0]
 			for _, values := range server.shrinklog {
-				// append the values to the aof buffer
-				aofbuf = append(aofbuf, '*')
-				aofbuf = append(aofbuf, strconv.FormatInt(int64(len(values)), 10)...)
-				aofbuf = append(aofbuf, '\r', '\n')
-				for _, value := range values {
-					aofbuf = append(aofbuf, '$')
-					aofbuf = append(aofbuf, strconv.FormatInt(int64(len(value)), 10)...)
-					aofbuf = append(aofbuf, '\r', '\n')
-					aofbuf = append(aofbuf, value...)
-					aofbuf = append(aofbuf, '\r', '\n')
-				}
+				aofbuf = appendAOFCommand(aofbuf, values)
 			}
 			if _, err := f.Write(aofbuf); err != nil {
 				return err
@@ -267,27 +166,29 @@ func (server *Server) aofshrink() {
 			// anything below this point is unrecoverable. just log and exit process
 			// back up the live aof, just in case of fatal error
 			if err := server.aof.Close(); err != nil {
-				log.Fatalf("shrink live aof close fatal operation: %v", err)
+				aofLog.Fatalf("shrink live aof close fatal operation: %v", err)
 			}
 			if err := f.Close(); err != nil {
-				log.Fatalf("shrink new aof close fatal operation: %v", err)
+				aofLog.Fatalf("shrink new aof close fatal operation: %v", err)
 			}
 			if err := os.Rename(core.AppendFileName, core.AppendFileName+"-bak"); err != nil {
-				log.Fatalf("shrink backup fatal operation: %v", err)
+				aofLog.Fatalf("shrink backup fatal operation: %v", err)
 			}
 			if err := os.Rename(core.AppendFileName+"-shrink", core.AppendFileName); err != nil {
-				log.Fatalf("shrink rename fatal operation: %v", err)
+				aofLog.Fatalf("shrink rename fatal operation: %v", err)
 			}
-			server.aof, err = os.OpenFile(core.AppendFileName, os.O_CREATE|os.O_RDWR, 0600)
+			server.aof, err = server.openAOF(core.AppendFileName)
 			if err != nil {
-				log.Fatalf("shrink openfile fatal operation: %v", err)
+				aofLog.Fatalf("shrink openfile fatal operation: %v", err)
 			}
 			var n int64
 			n, err = server.aof.Seek(0, 2)
 			if err != nil {
-				log.Fatalf("shrink seek end fatal operation: %v", err)
+				aofLog.Fatalf("shrink seek end fatal operation: %v", err)
 			}
 			server.aofsz = int(n)
+			// the offsets of the new aof do not match the old one
+			server.resetBacklog(true)
 
 			os.Remove(core.AppendFileName + "-bak") // ignore error
 
@@ -299,7 +200,139 @@ func (server *Server) aofshrink() {
 		}()
 	}()
 	if err != nil {
-		log.Errorf("aof shrink failed: %v", err)
+		aofLog.Errorf("aof shrink failed: %v", err)
 		return
 	}
 }
+
+// setCommand appends the SET command that recreates an object of a
+// collection snapshot to values.
+func setCommand(values []string, scol snapshotCol, id string,
+	obj geojson.Object, fields []field.Value, fmap map[string]int,
+	fnames []string, now int64,
+) []string {
+	values = append(values, "set")
+	values = append(values, scol.key)
+	values = append(values, id)
+	if ts := scol.snap.Timestamp(id); ts != 0 {
+		values = append(values, "timestamp", formatTimestamp(ts))
+	}
+	if v := scol.snap.Version(id); v != 0 {
+		values = append(values, "version", strconv.FormatUint(v, 10))
+	}
+	if len(fields) > 0 {
+		fvs := orderFields(fmap, fnames, fields)
+		for _, fv := range fvs {
+			if !fv.value.IsZero() {
+				values = append(values, "field")
+				values = append(values, fv.field)
+				values = append(values, fv.value.String())
+			}
+		}
+	}
+	if at, ok := scol.expires[id]; ok {
+		expires := at - now
+		if expires > 0 {
+			values = append(values, "ex")
+			values = append(values, strconv.FormatFloat(math.Floor(float64(expires)/float64(time.Second)*10)/10, 'f', -1, 64))
+		}
+	}
+	if objIsSpatial(obj) {
+		values = append(values, "object")
+		values = append(values, string(obj.AppendJSON(nil)))
+	} else {
+		values = append(values, "string")
+		values = append(values, obj.String())
+	}
+	return values
+}
+
+// datasetSnapshot returns a point-in-time view of every collection and the
+// commands needed to recreate the hooks, field expirations, schemas, limits,
+// kept positions settings, labels, indexes, and libraries of functions. The
+// schemas and limits follow the objects, which may have been stored before
+// they were set.
+// The caller must hold the server lock.
+func (server *Server) datasetSnapshot() (cols []snapshotCol, hooks [][]string) {
+	server.scanGreaterOrEqual("", func(key string, col *collection.Collection) bool {
+		cols = append(cols, server.snapshotCol(key, col))
+		return true
+	})
+	hooks = append(server.hookCommands(), server.fieldExpireCommands(nil)...)
+	hooks = append(hooks, server.schemaCommands(nil)...)
+	hooks = append(hooks, server.limitsCommands(nil)...)
+	hooks = append(hooks, server.historyCommands(nil)...)
+	hooks = append(hooks, server.labelCommands(nil)...)
+	hooks = append(hooks, server.functionCommands()...)
+	hooks = append(hooks, server.triggerCommands()...)
+	return cols, append(hooks, server.indexCommands(nil)...)
+}
+
+// snapshotCol returns a point-in-time view of a collection. The caller must
+// hold the server lock.
+func (server *Server) snapshotCol(key string, col *collection.Collection) snapshotCol {
+	scol := snapshotCol{key: key, snap: col.Snapshot()}
+	if value, ok := server.expires.Get(key); ok {
+		exm := value.(*rhh.Map)
+		scol.expires = make(map[string]int64, exm.Len())
+		exm.Range(func(id string, at interface{}) bool {
+			scol.expires[id] = at.(int64)
+			return true
+		})
+	}
+	return scol
+}
+
+// hookCommands returns the commands needed to recreate the hooks. The caller
+// must hold the server lock.
+func (server *Server) hookCommands() (hooks [][]string) {
+	// sort the names for consistency
+	var hnames []string
+	for name := range server.hooks {
+		hnames = append(hnames, name)
+	}
+	sort.Strings(hnames)
+	return server.hookCommandsFor(hnames)
+}
+
+// hookCommandsFor returns the commands needed to recreate the named hooks.
+// The caller must hold the server lock.
+func (server *Server) hookCommandsFor(hnames []string) (hooks [][]string) {
+	for _, name := range hnames {
+		hook := server.hooks[name]
+		hook.cond.L.Lock()
+		var values []string
+		if hook.channel {
+			values = append(values, "setchan", name)
+		} else {
+			values = append(values, "sethook", name,
+				strings.Join(hook.Endpoints, ","))
+		}
+		for _, meta := range hook.Metas {
+			values = append(values, "meta", meta.Name, meta.Value)
+		}
+		if !hook.expires.IsZero() {
+			ex := float64(time.Until(hook.expires)) / float64(time.Second)
+			values = append(values, "ex",
+				strconv.FormatFloat(ex, 'f', 1, 64))
+		}
+		if hook.follower {
+			values = append(values, "follower")
+		}
+		values = append(values, hook.Message.Args...)
+		hooks = append(hooks, values)
+		if hook.paused {
+			// retain the paused state
+			pause := []string{"pausehook", name}
+			if hook.channel {
+				pause[0] = "pausechan"
+			}
+			if hook.buffered {
+				pause = append(pause, "buffer")
+			}
+			hooks = append(hooks, pause)
+		}
+		hook.cond.L.Unlock()
+	}
+	return hooks
+}
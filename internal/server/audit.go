@@ -0,0 +1,212 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/tile38/internal/endpoint"
+	"github.com/tidwall/tile38/internal/log"
+)
+
+const (
+	auditLogPrefix = "audit:log:"
+	auditIdxKey    = "audit:idx"
+	auditHashKey   = "audit:hash"
+
+	// auditBatchSize is the maximum number of records that are read from the
+	// queue at a time.
+	auditBatchSize = 1000
+)
+
+// auditCommand returns true for the commands that are recorded in the audit
+// log, which are the write and admin commands, and AUTH. INFO and METRICS
+// are not recorded, because they are polled by monitoring.
+func auditCommand(command string) bool {
+	switch command {
+	case "auth":
+		return true
+	case "info", "metrics":
+		return false
+	}
+	return aclCommands["write"][command] || aclCommands["admin"][command]
+}
+
+// auditIsFile returns true when the auditlog is a file, and not an endpoint.
+func auditIsFile(target string) bool {
+	return !strings.Contains(target, "://")
+}
+
+// audit records a command in the audit log, when the auditlog property is
+// set. A record is the seq, the time, the address and the user of the
+// client, the command and its arguments, which have no secrets, and whether
+// the command succeeded.
+//
+// The records are tamper-evident. The seq increments by one for each record,
+// and never restarts. The prev member is the hash of the previous record,
+// and the hash member is the SHA-256 of the record up to the hash member, so
+// that a removed or changed record breaks the chain.
+func (s *Server) audit(args []string, client *Client, start time.Time,
+	failed bool,
+) {
+	if len(args) == 0 || s.config.auditLog() == "" {
+		return
+	}
+	command := strings.ToLower(args[0])
+	if !auditCommand(command) {
+		return
+	}
+	var addr, user string
+	if client != nil {
+		client.mu.Lock()
+		addr, user = client.remoteAddr, client.user
+		client.mu.Unlock()
+	}
+	var buf []byte
+	buf = appendJSONTimeFormat(append(buf, `,"time":`...), start)
+	buf = appendJSONString(append(buf, `,"addr":`...), addr)
+	buf = appendJSONString(append(buf, `,"user":`...), aclUserName(user))
+	buf = appendJSONString(append(buf, `,"command":`...), command)
+	buf = append(buf, `,"args":[`...)
+	for i, arg := range slowlogArgs(args) {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONString(buf, arg)
+	}
+	buf = append(buf, `],"ok":`...)
+	buf = strconv.AppendBool(buf, !failed)
+
+	s.auditmu.Lock()
+	defer s.auditmu.Unlock()
+	seq := s.auditidx + 1
+	record := `{"seq":` + strconv.FormatUint(seq, 10) + string(buf) +
+		`,"prev":"` + s.audithash + `"`
+	sum := sha256.Sum256([]byte(record))
+	hash := hex.EncodeToString(sum[:])
+	record += `,"hash":"` + hash + `"}`
+	err := s.qdb.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(auditLogPrefix+uint64ToString(seq), record, nil)
+		if err != nil {
+			return err
+		}
+		if _, _, err := tx.Set(auditIdxKey, uint64ToString(seq), nil); err != nil {
+			return err
+		}
+		_, _, err = tx.Set(auditHashKey, hash, nil)
+		return err
+	})
+	if err != nil {
+		log.Errorf("audit: %v", err)
+		return
+	}
+	s.auditidx, s.audithash = seq, hash
+	select {
+	case s.auditsig <- struct{}{}:
+	default:
+	}
+}
+
+// watchAudit delivers the queued records to the auditlog, in the order of
+// their seqs. A record is removed from the queue after it has been written,
+// so a record may be delivered more than once when the server stops during a
+// delivery.
+func (s *Server) watchAudit() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.auditsig:
+		case <-t.C:
+		}
+		if s.stopServer.on() {
+			return
+		}
+		for s.sendAudit() {
+		}
+	}
+}
+
+// sendAudit delivers the next batch of queued records. Returns true when the
+// whole batch was delivered and there may be more records in the queue.
+func (s *Server) sendAudit() bool {
+	target := s.config.auditLog()
+	if target == "" {
+		return false
+	}
+	var keys, vals []string
+	err := s.qdb.View(func(tx *buntdb.Tx) error {
+		return tx.AscendGreaterOrEqual("", auditLogPrefix,
+			func(key, val string) bool {
+				if !strings.HasPrefix(key, auditLogPrefix) {
+					return false
+				}
+				keys = append(keys, key)
+				vals = append(vals, val)
+				return len(keys) < auditBatchSize
+			},
+		)
+	})
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	var sent int
+	if auditIsFile(target) {
+		if err := writeAuditFile(target, vals); err != nil {
+			log.Errorf("audit: %v", err)
+		} else {
+			sent = len(vals)
+		}
+	} else {
+		for _, val := range vals {
+			if err := s.epc.Send(target, val); err != nil {
+				epLog.Debugf("Audit endpoint send error: %v: %v",
+					endpoint.Redact(target), err)
+				break
+			}
+			sent++
+		}
+	}
+	if sent == 0 {
+		return false
+	}
+	err = s.qdb.Update(func(tx *buntdb.Tx) error {
+		for _, key := range keys[:sent] {
+			if _, err := tx.Delete(key); err != nil && err != buntdb.ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	return sent == auditBatchSize
+}
+
+// writeAuditFile appends records to a file, one per line, and syncs it.
+func writeAuditFile(path string, records []string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	for _, record := range records {
+		buf = append(append(buf, record...), '\n')
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/core"
+)
+
+// replResumeSumSize is the number of bytes before the offset of a follower
+// that are compared to resume replication.
+const replResumeSumSize = 64 * 1024
+
+var errCannotResume = errors.New("cannot resume")
+
+// replBacklog has the most recent writes to the aof, so that a follower that
+// reconnects after a short outage can resume from its offset, rather than
+// searching for the offset with checksums of the aof files or syncing the
+// whole aof.
+//
+// The id identifies the history of the aof. It changes when the aof is
+// rewritten, because the offsets of the new aof do not match the old one. A
+// follower takes the id of its leader, since its aof is a copy of the
+// leader's aof.
+type replBacklog struct {
+	id    string
+	start int64  // aof offset of the first byte in buf
+	buf   []byte // the tail of the aof
+
+	partialOK  uint64 // resumed followers
+	partialErr uint64 // followers that could not resume
+}
+
+// reset empties the backlog, which then starts at the offset. A new id is
+// used when the history of the aof changed.
+func (b *replBacklog) reset(offset int64, newID bool) {
+	if newID || b.id == "" {
+		b.id = randomKey(20)
+	}
+	b.start = offset
+	b.buf = b.buf[:0]
+}
+
+// offset returns the aof offset of the end of the backlog, which is the
+// offset of the writes that were flushed to the aof.
+func (b *replBacklog) offset() int64 {
+	return b.start + int64(len(b.buf))
+}
+
+// write appends data that was written to the aof, and discards the oldest
+// data that does not fit in size bytes.
+func (b *replBacklog) write(data []byte, size int) {
+	if size <= 0 {
+		b.start += int64(len(b.buf) + len(data))
+		b.buf = nil
+		return
+	}
+	b.buf = append(b.buf, data...)
+	if n := len(b.buf) - size; n > 0 && len(b.buf) >= size*2 {
+		// compact once the discarded data is as large as the backlog
+		b.start += int64(n)
+		b.buf = append(b.buf[:0], b.buf[n:]...)
+	}
+}
+
+// sum returns the checksum of the size bytes before the offset.
+func (b *replBacklog) sum(offset int64, size int) (string, bool) {
+	end := offset - b.start
+	if size <= 0 || end < int64(size) || end > int64(len(b.buf)) {
+		return "", false
+	}
+	return fmt.Sprintf("%x", md5.Sum(b.buf[end-int64(size):end])), true
+}
+
+// flushBacklog adds the aof buffer to the backlog. The caller must hold the
+// server lock.
+func (s *Server) flushBacklog(data []byte) {
+	s.backlog.write(data, s.config.replBacklogSize())
+}
+
+// resetBacklog empties the backlog after the aof was loaded, truncated, or
+// rewritten. The caller must hold the server lock.
+func (s *Server) resetBacklog(newID bool) {
+	s.backlog.reset(int64(s.aofsz), newID)
+}
+
+// replResume checks if a follower can resume from its offset. The follower
+// has the id of the backlog, and a checksum of the bytes before its offset
+// that match the backlog. The caller must hold the server lock.
+//
+// REPLCONF RESUME id offset size sum
+func (s *Server) replResume(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	vs := msg.Args[2:]
+	if len(vs) != 4 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	offset, err := strconv.ParseInt(vs[1], 10, 64)
+	if err != nil || offset < 0 {
+		return NOMessage, errInvalidArgument(vs[1])
+	}
+	size, err := strconv.ParseUint(vs[2], 10, 32)
+	if err != nil {
+		return NOMessage, errInvalidArgument(vs[2])
+	}
+	s.flushAOF(false)
+	sum, ok := s.backlog.sum(offset, int(size))
+	if !ok || vs[0] != s.backlog.id || vs[3] != sum ||
+		offset > int64(s.aofsz) {
+		s.backlog.partialErr++
+		return NOMessage, errCannotResume
+	}
+	s.backlog.partialOK++
+	return OKMessage(msg, start), nil
+}
+
+// followResume asks the leader to resume from the offset of this server,
+// and returns false when it cannot, in which case the offset must be found
+// with checksums.
+func (s *Server) followResume(conn *RESPConn, id string, followc int) (int64,
+	bool,
+) {
+	s.mu.Lock()
+	if s.followc.get() != followc || id == "" || id != s.backlog.id {
+		s.mu.Unlock()
+		return 0, false
+	}
+	s.flushAOF(false)
+	offset := int64(s.aofsz)
+	size := replResumeSumSize
+	if n := int(offset - s.backlog.start); n < size {
+		size = n
+	}
+	sum, ok := s.backlog.sum(offset, size)
+	s.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	v, err := conn.Do("replconf", "resume", id, offset, size, sum)
+	if err != nil || v.Error() != nil {
+		if core.ShowDebugMessages {
+			replLog.Debugf("follow: cannot resume from %d", offset)
+		}
+		return 0, false
+	}
+	replLog.Infof("resuming replication from %d", offset)
+	return offset, true
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/md5"
+	"fmt"
+	"testing"
+)
+
+func TestReplBacklog(t *testing.T) {
+	var b replBacklog
+	b.reset(100, false)
+	id := b.id
+	if len(id) != 40 {
+		t.Fatalf("expected a 40 character id, got '%s'", id)
+	}
+	if _, ok := b.sum(100, 1); ok {
+		t.Fatal("expected no sum for an empty backlog")
+	}
+	for i := 0; i < 10; i++ {
+		b.write([]byte("0123456789"), 25)
+	}
+	// compacted to the most recent 25 bytes once 50 bytes were held
+	if b.start+int64(len(b.buf)) != 200 {
+		t.Fatalf("expected the backlog to end at 200, got %d",
+			b.start+int64(len(b.buf)))
+	}
+	if len(b.buf) < 25 || len(b.buf) >= 50 {
+		t.Fatalf("expected between 25 and 50 bytes, got %d", len(b.buf))
+	}
+	sum, ok := b.sum(200, 10)
+	if !ok || sum != fmt.Sprintf("%x", md5.Sum([]byte("0123456789"))) {
+		t.Fatalf("unexpected sum '%s'", sum)
+	}
+	if sum, _ := b.sum(195, 10); sum != fmt.Sprintf("%x",
+		md5.Sum([]byte("5678901234"))) {
+		t.Fatalf("unexpected sum '%s'", sum)
+	}
+	if _, ok := b.sum(201, 10); ok {
+		t.Fatal("expected no sum past the end")
+	}
+	if _, ok := b.sum(b.start+5, 10); ok {
+		t.Fatal("expected no sum before the start")
+	}
+	b.reset(300, false)
+	if b.id != id || b.start != 300 || len(b.buf) != 0 {
+		t.Fatal("expected an empty backlog with the same id")
+	}
+	b.reset(0, true)
+	if b.id == id {
+		t.Fatal("expected a new id")
+	}
+
+	// disabled
+	b.write([]byte("0123456789"), 0)
+	if b.start != 10 || len(b.buf) != 0 {
+		t.Fatalf("expected an empty backlog at 10, got %d bytes at %d",
+			len(b.buf), b.start)
+	}
+}
@@ -0,0 +1,602 @@
+package server
+
+import (
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/core"
+	"github.com/tidwall/tile38/internal/log"
+	"github.com/tidwall/tile38/internal/objstore"
+)
+
+// Backups are stored in object storage, such as s3://bucket/prefix.
+//
+// A full backup is a snapshot of the dataset, and an incremental backup is
+// the segment of the aof that was written since the previous backup. The
+// manifest lists every backup in order, along with the aof range that it
+// covers, which allows for restoring to a point in time by replaying the
+// incremental backups that follow a full backup.
+const backupPrefix = "tile38-"
+const backupManifestName = backupPrefix + "manifest.json"
+
+var errBackupInProgress = errors.New("backup already in progress")
+var errNoBackups = errors.New("no backups found")
+var errBackupChainBroken = errors.New("backup chain is broken, a full backup is required")
+
+func backupName(t time.Time, incremental bool) string {
+	name := backupPrefix + t.UTC().Format("20060102T150405.000Z")
+	if incremental {
+		return name + ".aof"
+	}
+	return name + ".snap"
+}
+
+type backupManifest struct {
+	Backups []backupEntry `json:"backups"`
+}
+
+type backupEntry struct {
+	Name        string `json:"name"`
+	Incremental bool   `json:"incremental,omitempty"`
+	Time        int64  `json:"time"`      // unix nano
+	AOFStart    int64  `json:"aof_start"` // aof range that the backup covers
+	AOFEnd      int64  `json:"aof_end"`
+	AOFTailCRC  uint32 `json:"aof_tail_crc"` // checksum of the aof before end
+}
+
+func loadBackupManifest(store *objstore.Store) (*backupManifest, error) {
+	rd, err := store.Get(backupManifestName)
+	if err != nil {
+		if _, ok := err.(*objstore.NotFoundError); ok {
+			return &backupManifest{}, nil
+		}
+		return nil, err
+	}
+	defer rd.Close()
+	var manifest backupManifest
+	if err := json.NewDecoder(rd).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("manifest: %v", err)
+	}
+	return &manifest, nil
+}
+
+// uploadBackup streams the output of write to an object, encrypting it when
+// an encryption key is configured.
+func (server *Server) uploadBackup(store *objstore.Store, name string,
+	write func(w io.Writer) error,
+) error {
+	pr, pw := io.Pipe()
+	go func() {
+		var w io.Writer = pw
+		if server.aead != nil {
+			w = &cryptWriter{w: pw, aead: server.aead}
+		}
+		pw.CloseWithError(write(w))
+	}()
+	err := store.Upload(name, pr)
+	// unblocks the writer when the upload fails early
+	pr.CloseWithError(err)
+	return err
+}
+
+// backup uploads a full or incremental backup and returns the url of the new
+// object. An incremental backup falls back to a full backup when there's no
+// previous backup that the aof follows, such as after an aof rewrite. The
+// server lock is only held while taking the snapshot.
+func (server *Server) backup(rawurl string, incremental bool) (string, error) {
+	store, err := objstore.Open(rawurl)
+	if err != nil {
+		return "", err
+	}
+	manifest, err := loadBackupManifest(store)
+	if err != nil {
+		return "", err
+	}
+	start := time.Now()
+	server.mu.Lock()
+	if server.backingUp {
+		server.mu.Unlock()
+		return "", errBackupInProgress
+	}
+	if incremental && server.aof == nil {
+		server.mu.Unlock()
+		return "", errors.New("incremental backups require the aof")
+	}
+	server.backingUp = true
+	entry := backupEntry{Time: start.UnixNano()}
+	var cols []snapshotCol
+	var hooks [][]string
+	var aofrd *cryptFile
+	err = func() error {
+		if server.aof != nil {
+			server.flushAOF(false)
+			entry.AOFEnd = int64(server.aofsz)
+			crc, err := aofTailCRC(server.aof, entry.AOFEnd)
+			if err != nil {
+				return err
+			}
+			entry.AOFTailCRC = crc
+		}
+		if incremental && len(manifest.Backups) > 0 {
+			last := manifest.Backups[len(manifest.Backups)-1]
+			if last.AOFEnd <= entry.AOFEnd {
+				crc, err := aofTailCRC(server.aof, last.AOFEnd)
+				if err != nil {
+					return err
+				}
+				if crc == last.AOFTailCRC {
+					entry.Incremental = true
+					entry.AOFStart = last.AOFEnd
+				}
+			}
+		}
+		if entry.Incremental {
+			if entry.AOFStart == entry.AOFEnd {
+				return nil
+			}
+			// a separate handle keeps reading from the same file, even
+			// when the aof is rewritten during the upload.
+			var err error
+			aofrd, err = server.openAOFReader()
+			return err
+		}
+		if incremental {
+			log.Infof("backup: aof does not follow the last backup, " +
+				"making a full backup")
+		}
+		entry.AOFStart = entry.AOFEnd
+		cols, hooks = server.datasetSnapshot()
+		return nil
+	}()
+	server.mu.Unlock()
+
+	if err == nil {
+		uploaded := true
+		switch {
+		case entry.Incremental && entry.AOFStart == entry.AOFEnd:
+			// nothing was written since the last backup
+			entry = manifest.Backups[len(manifest.Backups)-1]
+			uploaded = false
+		case entry.Incremental:
+			entry.Name = backupName(start, true)
+			err = server.uploadBackup(store, entry.Name, func(w io.Writer) error {
+				_, err := io.Copy(w, io.NewSectionReader(aofrd,
+					entry.AOFStart, entry.AOFEnd-entry.AOFStart))
+				return err
+			})
+			aofrd.Close()
+		default:
+			entry.Name = backupName(start, false)
+			err = server.uploadBackup(store, entry.Name, func(w io.Writer) error {
+				hdr := snapshotHeader{created: start.UnixNano()}
+				return encodeSnapshot(w, cols, hooks, hdr)
+			})
+		}
+		if err == nil && uploaded {
+			manifest.Backups = append(manifest.Backups, entry)
+			var data []byte
+			data, err = json.MarshalIndent(manifest, "", "\t")
+			if err == nil {
+				err = store.Put(backupManifestName, data)
+			}
+		}
+	}
+
+	server.mu.Lock()
+	server.backingUp = false
+	server.lastBackupErr = err != nil
+	if err == nil {
+		server.lastBackup = start
+		if !entry.Incremental {
+			server.lastFullBackup = start
+		}
+	}
+	server.mu.Unlock()
+	if err != nil {
+		log.Errorf("backup failed: %v", err)
+		return "", err
+	}
+	log.Infof("backup saved to %s %v", store.URL(entry.Name), time.Since(start))
+	return store.URL(entry.Name), nil
+}
+
+// backupChain returns the backups that restore to the named backup, or to
+// the latest backup at or before the time, or to the latest backup. The
+// chain starts with a full backup.
+func backupChain(manifest *backupManifest, name string, at time.Time,
+) ([]backupEntry, error) {
+	target := -1
+	for i, entry := range manifest.Backups {
+		if name != "" {
+			if entry.Name == name {
+				target = i
+			}
+		} else if at.IsZero() || entry.Time <= at.UnixNano() {
+			target = i
+		}
+	}
+	if target == -1 {
+		if name != "" && !strings.HasSuffix(name, ".aof") {
+			// a full backup that is not in the manifest
+			return []backupEntry{{Name: name}}, nil
+		}
+		return nil, errNoBackups
+	}
+	for i := target; i >= 0; i-- {
+		if !manifest.Backups[i].Incremental {
+			chain := manifest.Backups[i : target+1]
+			for j := 1; j < len(chain); j++ {
+				if !chain[j].Incremental ||
+					chain[j].AOFStart != chain[j-1].AOFEnd {
+					return nil, errBackupChainBroken
+				}
+			}
+			return chain, nil
+		}
+	}
+	return nil, errBackupChainBroken
+}
+
+// readAOFCommands calls iter for every command in an aof.
+func readAOFCommands(r io.Reader, iter func(args []string) error) error {
+	return readAOFCommandsAt(r, func(_ int64, args []string) error {
+		return iter(args)
+	})
+}
+
+// readAOFCommandsAt calls iter for every command in an aof, with the offset
+// of the command from the start of the reader.
+func readAOFCommandsAt(r io.Reader, iter func(pos int64, args []string) error,
+) error {
+	var packet [0xFFFF]byte
+	var buf []byte
+	var args [][]byte
+	var sargs []string
+	var read int64
+	for {
+		n, err := r.Read(packet[:])
+		if err != nil {
+			if err == io.EOF {
+				if len(buf) > 0 {
+					return io.ErrUnexpectedEOF
+				}
+				return nil
+			}
+			return err
+		}
+		read += int64(n)
+		data := append(buf, packet[:n]...)
+		for {
+			var complete bool
+			pos := read - int64(len(data))
+			complete, args, _, data, err = redcon.ReadNextCommand(data, args[:0])
+			if err != nil {
+				return err
+			}
+			if !complete {
+				break
+			}
+			if len(args) > 0 {
+				sargs = sargs[:0]
+				for _, arg := range args {
+					sargs = append(sargs, string(arg))
+				}
+				if err := iter(pos, sargs); err != nil {
+					return err
+				}
+			}
+		}
+		buf = append(buf[:0], data...)
+	}
+}
+
+// restore replaces the dataset with a backup, which is a full backup that's
+// followed by zero or more incremental backups. The restored dataset is
+// written to the aof as regular commands, which are also sent to followers.
+func (server *Server) restore(rawurl, name string, at time.Time,
+) (string, error) {
+	store, err := objstore.Open(rawurl)
+	if err != nil {
+		return "", err
+	}
+	manifest, err := loadBackupManifest(store)
+	if err != nil {
+		return "", err
+	}
+	chain, err := backupChain(manifest, name, at)
+	if err != nil {
+		return "", err
+	}
+
+	// download to temporary files, which are verified before the dataset
+	// is replaced.
+	var files []*cryptFile
+	defer func() {
+		for _, f := range files {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	for i, entry := range chain {
+		path := fmt.Sprintf("%s-restore-%d", core.SnapshotFileName, i)
+		f, err := downloadBackup(store, entry.Name, path, server.aead)
+		if f != nil {
+			files = append(files, f)
+		}
+		if err != nil {
+			return "", err
+		}
+		if i == 0 {
+			if _, err := verifySnapshot(f); err != nil {
+				return "", err
+			}
+		} else if size, err := f.Size(); err != nil {
+			return "", err
+		} else if size != entry.AOFEnd-entry.AOFStart {
+			return "", fmt.Errorf("%s: incomplete backup", entry.Name)
+		}
+	}
+
+	start := time.Now()
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !server.isLeader() {
+		return "", server.notLeaderErr()
+	}
+	if server.config.readOnly() {
+		return "", errReadOnly
+	}
+	var count int
+	apply := func(args []string) error {
+		msg := &Message{Args: args}
+		if _, _, err := server.command(msg, nil); err != nil {
+			if commandErrIsFatal(err) {
+				return err
+			}
+			return nil
+		}
+		if err := server.writeAOF(args, nil); err != nil {
+			return err
+		}
+		if len(server.aofbuf) > maxchunk {
+			server.flushAOF(false)
+		}
+		count++
+		return nil
+	}
+	err = func() error {
+		if err := apply([]string{"flushdb"}); err != nil {
+			return err
+		}
+		if err := server.restoreSnapshot(files[0], apply); err != nil {
+			return err
+		}
+		for _, f := range files[1:] {
+			if _, err := f.Seek(0, 0); err != nil {
+				return err
+			}
+			if err := readAOFCommands(f, apply); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	server.flushAOF(false)
+	target := store.URL(chain[len(chain)-1].Name)
+	if err != nil {
+		// the dataset is partially restored at this point
+		log.Errorf("restore failed: %v", err)
+		return "", err
+	}
+	log.Infof("restored %d commands from %s %v", count, target,
+		time.Since(start))
+	return target, nil
+}
+
+// downloadBackup downloads an object to a file.
+func downloadBackup(store *objstore.Store, name, path string,
+	aead cipher.AEAD,
+) (*cryptFile, error) {
+	rd, err := store.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(f, rd)
+	f.Close()
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	cf, err := openCryptFile(path, os.O_RDONLY, aead)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return cf, nil
+}
+
+// restoreSnapshot applies the objects and hooks in a snapshot as commands.
+func (server *Server) restoreSnapshot(f *cryptFile,
+	apply func(args []string) error,
+) error {
+	var args []string
+	now := time.Now().UnixNano()
+	return server.readSnapshot(f, func(rec *snapshotRecord) error {
+		switch rec.kind {
+		case snapshotRecObj:
+			if rec.ex != 0 && rec.ex <= now {
+				return nil
+			}
+			args = append(args[:0], "set", rec.key, rec.id)
+			if rec.ts != 0 {
+				args = append(args, "timestamp", formatTimestamp(rec.ts))
+			}
+			if rec.ver != 0 {
+				args = append(args, "version", strconv.FormatUint(rec.ver, 10))
+			}
+			for i, value := range rec.values {
+				if !value.IsZero() {
+					args = append(args, "field", rec.fields[i],
+						value.String())
+				}
+			}
+			if rec.ex != 0 {
+				ex := float64(rec.ex-now) / float64(time.Second)
+				args = append(args, "ex",
+					strconv.FormatFloat(math.Ceil(ex*10)/10, 'f', -1, 64))
+			}
+			if objIsSpatial(rec.obj) {
+				args = append(args, "object", string(rec.obj.AppendJSON(nil)))
+			} else {
+				args = append(args, "string", rec.obj.String())
+			}
+			return apply(args)
+		case snapshotRecCmd:
+			return apply(rec.args)
+		}
+		return nil
+	})
+}
+
+// watchBackups runs scheduled backups to the backupurl at every
+// backupinterval seconds. The backups are incremental, except for every
+// backupfullinterval seconds.
+func (server *Server) watchBackups() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	s := time.Now()
+	for range t.C {
+		if server.stopServer.on() {
+			return
+		}
+		interval := server.config.backupInterval()
+		rawurl := server.config.backupURL()
+		if interval == 0 || rawurl == "" {
+			continue
+		}
+		if server.config.followHost() != "" {
+			continue
+		}
+		if time.Since(s) < time.Second*time.Duration(interval) {
+			continue
+		}
+		server.mu.RLock()
+		lastFull := server.lastFullBackup
+		server.mu.RUnlock()
+		fullInterval := server.config.backupFullInterval()
+		incremental := fullInterval > 0 && !lastFull.IsZero() &&
+			time.Since(lastFull) < time.Second*time.Duration(fullInterval)
+		server.backup(rawurl, incremental)
+		s = time.Now()
+	}
+}
+
+func backupMessage(msg *Message, start time.Time, objURL string) resp.Value {
+	switch msg.OutputType {
+	case JSON:
+		return resp.StringValue(`{"ok":true,"url":` + jsonString(objURL) +
+			`,"elapsed":"` + time.Since(start).String() + "\"}")
+	case RESP:
+		return resp.StringValue(objURL)
+	}
+	return NOMessage
+}
+
+var errBackupURLRequired = errors.New("missing url, and backupurl is not configured")
+
+// BACKUP [url] [INCREMENTAL]
+func (server *Server) cmdBackup(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var incremental bool
+	if len(vs) > 0 && strings.ToLower(vs[len(vs)-1]) == "incremental" {
+		incremental = true
+		vs = vs[:len(vs)-1]
+	}
+	var rawurl string
+	switch len(vs) {
+	case 0:
+		rawurl = server.config.backupURL()
+		if rawurl == "" {
+			return NOMessage, errBackupURLRequired
+		}
+	case 1:
+		rawurl = vs[0]
+	default:
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	objURL, err := server.backup(rawurl, incremental)
+	if err != nil {
+		return NOMessage, err
+	}
+	return backupMessage(msg, start, objURL), nil
+}
+
+// RESTORE [url [name]] [AT timestamp]
+func (server *Server) cmdRestore(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	var rawurl, name string
+	var at time.Time
+	var pos []string
+	vs := msg.Args[1:]
+	for len(vs) > 0 {
+		if strings.ToLower(vs[0]) == "at" {
+			if len(vs) < 2 {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			var ok bool
+			if at, ok = parseBackupTime(vs[1]); !ok {
+				return NOMessage, errInvalidArgument(vs[1])
+			}
+			vs = vs[2:]
+			continue
+		}
+		pos = append(pos, vs[0])
+		vs = vs[1:]
+	}
+	switch len(pos) {
+	case 0:
+		rawurl = server.config.backupURL()
+		if rawurl == "" {
+			return NOMessage, errBackupURLRequired
+		}
+	case 1:
+		rawurl = pos[0]
+	case 2:
+		if !at.IsZero() {
+			return NOMessage, errInvalidArgument(pos[1])
+		}
+		rawurl, name = pos[0], pos[1]
+	default:
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	objURL, err := server.restore(rawurl, name, at)
+	if err != nil {
+		return NOMessage, err
+	}
+	return backupMessage(msg, start, objURL), nil
+}
+
+// parseBackupTime parses a unix timestamp in seconds or an RFC 3339 time.
+func parseBackupTime(s string) (time.Time, bool) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), true
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackupChain(t *testing.T) {
+	manifest := &backupManifest{Backups: []backupEntry{
+		{Name: "a.snap", Time: 10, AOFStart: 100, AOFEnd: 100},
+		{Name: "b.aof", Time: 20, Incremental: true, AOFStart: 100, AOFEnd: 200},
+		{Name: "c.aof", Time: 30, Incremental: true, AOFStart: 200, AOFEnd: 300},
+		{Name: "d.snap", Time: 40, AOFStart: 50, AOFEnd: 50},
+		{Name: "e.aof", Time: 50, Incremental: true, AOFStart: 60, AOFEnd: 70},
+	}}
+	names := func(name string, at int64) string {
+		var tm time.Time
+		if at != 0 {
+			tm = time.Unix(0, at)
+		}
+		chain, err := backupChain(manifest, name, tm)
+		if err != nil {
+			return err.Error()
+		}
+		var s string
+		for _, entry := range chain {
+			s += entry.Name + " "
+		}
+		return s
+	}
+	tests := []struct {
+		name   string
+		at     int64
+		expect string
+	}{
+		{"", 0, errBackupChainBroken.Error()},
+		{"", 45, "d.snap "},
+		{"", 35, "a.snap b.aof c.aof "},
+		{"", 20, "a.snap b.aof "},
+		{"", 5, errNoBackups.Error()},
+		{"b.aof", 0, "a.snap b.aof "},
+		{"x.snap", 0, "x.snap "},
+		{"x.aof", 0, errNoBackups.Error()},
+	}
+	for _, tt := range tests {
+		if got := names(tt.name, tt.at); got != tt.expect {
+			t.Fatalf("%q at %d: expected %q, got %q", tt.name, tt.at,
+				tt.expect, got)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"math"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geo"
+	"github.com/tidwall/geojson/geometry"
+)
+
+// metersPerDegree is the length of a degree of latitude.
+const metersPerDegree = 6371e3 * math.Pi / 180
+
+// bufferMaxSteps is the most points that are tested along an edge of an
+// object for a WITHIN with a BUFFER.
+const bufferMaxSteps = 256
+
+// bufferParts are the vertices and edges of an object.
+type bufferParts struct {
+	points []geometry.Point
+	segs   []geometry.Segment
+}
+
+func (parts *bufferParts) addSeries(series geometry.Series) {
+	for i := 0; i < series.NumPoints(); i++ {
+		parts.points = append(parts.points, series.PointAt(i))
+	}
+	for i := 0; i < series.NumSegments(); i++ {
+		parts.segs = append(parts.segs, series.SegmentAt(i))
+	}
+}
+
+func (parts *bufferParts) addPoly(poly *geometry.Poly) {
+	parts.addSeries(poly.Exterior)
+	for _, hole := range poly.Holes {
+		parts.addSeries(hole)
+	}
+}
+
+func (parts *bufferParts) addRect(rect geometry.Rect) {
+	parts.addPoly(geometry.NewPoly([]geometry.Point{
+		rect.Min, {X: rect.Max.X, Y: rect.Min.Y},
+		rect.Max, {X: rect.Min.X, Y: rect.Max.Y}, rect.Min,
+	}, nil, &geometry.IndexOptions{Kind: geometry.None}))
+}
+
+func (parts *bufferParts) add(obj geojson.Object) {
+	switch o := obj.(type) {
+	case *geojson.Point:
+		parts.points = append(parts.points, o.Base())
+	case *geojson.SimplePoint:
+		parts.points = append(parts.points, o.Base())
+	case *geojson.Rect:
+		parts.addRect(o.Base())
+	case *geojson.LineString:
+		parts.addSeries(o.Base())
+	case *geojson.Polygon:
+		parts.addPoly(o.Base())
+	case *geojson.Circle:
+		parts.add(o.Primative())
+	case *geojson.Feature:
+		parts.add(o.Base())
+	case geojson.Collection:
+		for _, child := range o.Children() {
+			parts.add(child)
+		}
+	}
+}
+
+// bufferedObject is an area that is expanded by a distance, for the BUFFER
+// option of WITHIN and INTERSECTS. An object intersects the area when it is
+// within the distance of the original area, and it is within the area when
+// all of its points are. The interior of a polygon is not tested, so a
+// polygon that surrounds a gap in the area, such as the middle of a buffered
+// ring, is still within the area.
+type bufferedObject struct {
+	bufferParts
+	base   geojson.Object
+	meters float64
+	rect   geometry.Rect
+}
+
+// bufferObject returns the object expanded by the meters, or the object
+// itself when there is no buffer.
+func bufferObject(obj geojson.Object, meters float64) geojson.Object {
+	if obj == nil || meters <= 0 {
+		return obj
+	}
+	b := &bufferedObject{base: obj, meters: meters}
+	b.add(obj)
+	b.rect = bufferRect(obj.Rect(), meters)
+	return b
+}
+
+// bufferRect returns the rect expanded by the meters.
+func bufferRect(rect geometry.Rect, meters float64) geometry.Rect {
+	minLat, minLon1, _, _ := geo.RectFromCenter(rect.Min.Y, 0, meters)
+	_, minLon2, maxLat, _ := geo.RectFromCenter(rect.Max.Y, 0, meters)
+	// the longitude expands the most at the latitude nearest to a pole
+	dlon := -math.Min(minLon1, minLon2)
+	return geometry.Rect{
+		Min: geometry.Point{
+			X: math.Max(rect.Min.X-dlon, -180),
+			Y: math.Min(minLat, rect.Min.Y),
+		},
+		Max: geometry.Point{
+			X: math.Min(rect.Max.X+dlon, 180),
+			Y: math.Max(maxLat, rect.Max.Y),
+		},
+	}
+}
+
+// segDist returns the distance in meters from a point to a segment, on a
+// plane that is tangent to the earth at the point.
+func segDist(p geometry.Point, seg geometry.Segment) float64 {
+	kx := math.Cos(p.Y*math.Pi/180) * metersPerDegree
+	lon := func(x float64) float64 {
+		d := x - p.X
+		if d > 180 {
+			d -= 360
+		} else if d < -180 {
+			d += 360
+		}
+		return d * kx
+	}
+	ax, ay := lon(seg.A.X), (seg.A.Y-p.Y)*metersPerDegree
+	bx, by := lon(seg.B.X), (seg.B.Y-p.Y)*metersPerDegree
+	dx, dy := bx-ax, by-ay
+	var t float64
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
+
+// distTo returns the distance in meters from a point to the edges and
+// vertices of the original area.
+func (b *bufferedObject) distTo(p geometry.Point) float64 {
+	dist := math.Inf(+1)
+	for _, q := range b.points {
+		dist = math.Min(dist, geo.DistanceTo(p.Y, p.X, q.Y, q.X))
+	}
+	for _, seg := range b.segs {
+		dist = math.Min(dist, segDist(p, seg))
+	}
+	return dist
+}
+
+// covers returns true when the point is within the buffered area.
+func (b *bufferedObject) covers(p geometry.Point) bool {
+	return b.base.Spatial().IntersectsPoint(p) || b.distTo(p) <= b.meters
+}
+
+// near returns true when the distance between the parts and the original
+// area is not more than the meters. Two sets of edges that do not cross are
+// closest at a vertex of one of them.
+func (b *bufferedObject) near(parts bufferParts) bool {
+	for _, p := range parts.points {
+		if b.distTo(p) <= b.meters {
+			return true
+		}
+	}
+	for _, p := range b.points {
+		for _, seg := range parts.segs {
+			if segDist(p, seg) <= b.meters {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Empty ...
+func (b *bufferedObject) Empty() bool {
+	return b.base.Empty()
+}
+
+// Valid ...
+func (b *bufferedObject) Valid() bool {
+	return b.base.Valid()
+}
+
+// Rect ...
+func (b *bufferedObject) Rect() geometry.Rect {
+	return b.rect
+}
+
+// Center ...
+func (b *bufferedObject) Center() geometry.Point {
+	return b.base.Center()
+}
+
+// Contains returns true when all of the points of the object are within
+// the buffered area. The edges are tested at a quarter of the buffer apart.
+func (b *bufferedObject) Contains(obj geojson.Object) bool {
+	var parts bufferParts
+	parts.add(obj)
+	if len(parts.points) == 0 {
+		return false
+	}
+	for _, p := range parts.points {
+		if !b.covers(p) {
+			return false
+		}
+	}
+	for _, seg := range parts.segs {
+		length := geo.DistanceTo(seg.A.Y, seg.A.X, seg.B.Y, seg.B.X)
+		steps := int(math.Min(math.Ceil(length/(b.meters/4)), bufferMaxSteps))
+		for i := 1; i < steps; i++ {
+			t := float64(i) / float64(steps)
+			p := geometry.Point{
+				X: seg.A.X + (seg.B.X-seg.A.X)*t,
+				Y: seg.A.Y + (seg.B.Y-seg.A.Y)*t,
+			}
+			if !b.covers(p) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Within ...
+func (b *bufferedObject) Within(obj geojson.Object) bool {
+	return obj.Contains(b)
+}
+
+// Intersects ...
+func (b *bufferedObject) Intersects(obj geojson.Object) bool {
+	return obj.Intersects(b)
+}
+
+// AppendJSON ...
+func (b *bufferedObject) AppendJSON(dst []byte) []byte {
+	return b.base.AppendJSON(dst)
+}
+
+// JSON ...
+func (b *bufferedObject) JSON() string {
+	return b.base.JSON()
+}
+
+// String ...
+func (b *bufferedObject) String() string {
+	return b.base.String()
+}
+
+// MarshalJSON ...
+func (b *bufferedObject) MarshalJSON() ([]byte, error) {
+	return b.base.MarshalJSON()
+}
+
+// Distance ...
+func (b *bufferedObject) Distance(obj geojson.Object) float64 {
+	return math.Max(0, b.base.Distance(obj)-b.meters)
+}
+
+// NumPoints ...
+func (b *bufferedObject) NumPoints() int {
+	return b.base.NumPoints()
+}
+
+// ForEach ...
+func (b *bufferedObject) ForEach(iter func(geom geojson.Object) bool) bool {
+	return iter(b)
+}
+
+// Spatial ...
+func (b *bufferedObject) Spatial() geojson.Spatial {
+	return b
+}
+
+// WithinRect ...
+func (b *bufferedObject) WithinRect(rect geometry.Rect) bool {
+	return rect.ContainsRect(b.rect)
+}
+
+// WithinPoint ...
+func (b *bufferedObject) WithinPoint(point geometry.Point) bool {
+	return false
+}
+
+// WithinLine ...
+func (b *bufferedObject) WithinLine(line *geometry.Line) bool {
+	return false
+}
+
+// WithinPoly ...
+func (b *bufferedObject) WithinPoly(poly *geometry.Poly) bool {
+	return poly.ContainsRect(b.rect)
+}
+
+// IntersectsPoint ...
+func (b *bufferedObject) IntersectsPoint(point geometry.Point) bool {
+	return b.covers(point)
+}
+
+// IntersectsRect ...
+func (b *bufferedObject) IntersectsRect(rect geometry.Rect) bool {
+	if b.base.Spatial().IntersectsRect(rect) {
+		return true
+	}
+	var parts bufferParts
+	parts.addRect(rect)
+	return b.near(parts)
+}
+
+// IntersectsLine ...
+func (b *bufferedObject) IntersectsLine(line *geometry.Line) bool {
+	if b.base.Spatial().IntersectsLine(line) {
+		return true
+	}
+	var parts bufferParts
+	parts.addSeries(line)
+	return b.near(parts)
+}
+
+// IntersectsPoly ...
+func (b *bufferedObject) IntersectsPoly(poly *geometry.Poly) bool {
+	if b.base.Spatial().IntersectsPoly(poly) {
+		return true
+	}
+	var parts bufferParts
+	parts.addPoly(poly)
+	return b.near(parts)
+}
+
+// DistancePoint ...
+func (b *bufferedObject) DistancePoint(point geometry.Point) float64 {
+	if b.covers(point) {
+		return 0
+	}
+	return b.distTo(point) - b.meters
+}
+
+// DistanceRect ...
+func (b *bufferedObject) DistanceRect(rect geometry.Rect) float64 {
+	return math.Max(0, b.base.Spatial().DistanceRect(rect)-b.meters)
+}
+
+// DistanceLine ...
+func (b *bufferedObject) DistanceLine(line *geometry.Line) float64 {
+	return math.Max(0, b.base.Spatial().DistanceLine(line)-b.meters)
+}
+
+// DistancePoly ...
+func (b *bufferedObject) DistancePoly(poly *geometry.Poly) float64 {
+	return math.Max(0, b.base.Spatial().DistancePoly(poly)-b.meters)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+)
+
+func TestSegDist(t *testing.T) {
+	seg := geometry.Segment{
+		A: geometry.Point{X: -115, Y: 33},
+		B: geometry.Point{X: -114, Y: 33},
+	}
+	tests := []struct {
+		p    geometry.Point
+		dist float64
+	}{
+		{geometry.Point{X: -114.5, Y: 33}, 0},
+		{geometry.Point{X: -114.5, Y: 33.01}, 1112},
+		{geometry.Point{X: -113.99, Y: 33}, 933},
+		{geometry.Point{X: -115.01, Y: 32.99}, 1451},
+	}
+	for _, tt := range tests {
+		if dist := segDist(tt.p, seg); math.Abs(dist-tt.dist) > 1 {
+			t.Fatalf("%v: expected %v, got %v", tt.p, tt.dist, dist)
+		}
+	}
+}
+
+func TestBufferObject(t *testing.T) {
+	point := geojson.NewPoint(geometry.Point{X: -115, Y: 33})
+	if bufferObject(point, 0) != point {
+		t.Fatal("expected the same object")
+	}
+	b := bufferObject(point, 1000)
+	rect := b.Rect()
+	if rect.Min.Y > 32.9911 || rect.Max.Y < 33.0089 ||
+		rect.Min.X > -115.0107 || rect.Max.X < -114.9893 {
+		t.Fatalf("rect %v is too small", rect)
+	}
+	near := geojson.NewPoint(geometry.Point{X: -115, Y: 33.008})
+	far := geojson.NewPoint(geometry.Point{X: -115, Y: 33.01})
+	if !near.Intersects(b) || !near.Within(b) {
+		t.Fatal("expected the point to be in the buffer")
+	}
+	if far.Intersects(b) || far.Within(b) {
+		t.Fatal("expected the point to not be in the buffer")
+	}
+	line := geojson.NewLineString(geometry.NewLine([]geometry.Point{
+		{X: -115.02, Y: 33.008}, {X: -114.98, Y: 33.008},
+	}, nil))
+	if !line.Intersects(b) {
+		t.Fatal("expected the line to intersect the buffer")
+	}
+	if line.Within(b) {
+		t.Fatal("expected the line to not be within the buffer")
+	}
+}
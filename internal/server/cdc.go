@@ -0,0 +1,242 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/tile38/internal/endpoint"
+	"github.com/tidwall/tile38/internal/field"
+	"github.com/tidwall/tile38/internal/log"
+)
+
+const (
+	cdcLogPrefix = "cdc:log:"
+	cdcIdxKey    = "cdc:idx"
+
+	// cdcBatchSize is the maximum number of change events that are read from
+	// the queue at a time.
+	cdcBatchSize = 1000
+)
+
+// queueChanges queues change events for the writes in a command. The events
+// are stored in the hook queue database, and are removed once they've been
+// delivered to the cdc endpoint. Only the leader captures changes, and the
+// writes from a peer are captured by the peer.
+func (s *Server) queueChanges(args []string, d *commandDetails) error {
+	if s.config.cdcEndpoint() == "" || s.config.followHost() != "" {
+		return nil
+	}
+	args, origin := lwwArgs(args)
+	if origin != "" && origin != s.config.serverID() {
+		return nil
+	}
+	events := appendChangeEvents(nil, args, d)
+	if len(events) == 0 {
+		return nil
+	}
+	err := s.qdb.Update(func(tx *buntdb.Tx) error {
+		for _, event := range events {
+			s.cdcidx++
+			event = `{"seq":` + strconv.FormatUint(s.cdcidx, 10) + "," + event[1:]
+			_, _, err := tx.Set(cdcLogPrefix+uint64ToString(s.cdcidx), event, nil)
+			if err != nil {
+				return err
+			}
+		}
+		_, _, err := tx.Set(cdcIdxKey, uint64ToString(s.cdcidx), nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case s.cdcsig <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// appendChangeEvents appends the change events for the writes in a command.
+// Commands that do not change the data in collections, such as SETHOOK, have
+// no events.
+func appendChangeEvents(events []string, args []string, d *commandDetails) []string {
+	if len(args) == 0 {
+		return events
+	}
+	command := strings.ToLower(args[0])
+	ts := d.timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	event := func(key string, tail func(buf []byte) []byte) []byte {
+		var buf []byte
+		buf = append(buf, `{"command":`...)
+		buf = appendJSONString(buf, command)
+		buf = appendJSONTimeFormat(append(buf, `,"time":`...), ts)
+		if key != "" {
+			buf = appendJSONString(append(buf, `,"key":`...), key)
+		}
+		if tail != nil {
+			buf = tail(buf)
+		}
+		return append(buf, '}')
+	}
+	withID := func(id string) func(buf []byte) []byte {
+		return func(buf []byte) []byte {
+			return appendJSONString(append(buf, `,"id":`...), id)
+		}
+	}
+	switch command {
+	case "set", "fset", "fincrby", "jset", "jdel":
+		if d.obj == nil {
+			return events
+		}
+		events = append(events, string(event(d.key, func(buf []byte) []byte {
+			buf = withID(d.id)(buf)
+			buf = d.obj.AppendJSON(append(buf, `,"object":`...))
+			return appendChangeFields(buf, d.fmap, d.fields)
+		})))
+	case "del":
+		events = append(events, string(event(d.key, func(buf []byte) []byte {
+			buf = withID(d.id)(buf)
+			if d.expired {
+				buf = append(buf, `,"expired":true`...)
+			}
+			return buf
+		})))
+	case "pdel":
+		for _, child := range d.children {
+			events = appendChangeEvents(events,
+				[]string{"del", child.key, child.id}, child)
+		}
+	case "pset":
+		for _, child := range d.children {
+			events = appendChangeEvents(events,
+				[]string{"set", child.key, child.id}, child)
+		}
+	case "copy":
+		for _, child := range d.children {
+			events = appendChangeEvents(events,
+				[]string{child.command, child.key, child.id}, child)
+		}
+	case "drop":
+		events = append(events, string(event(d.key, nil)))
+	case "rename", "renamenx":
+		events = append(events, string(event(d.key, func(buf []byte) []byte {
+			return appendJSONString(append(buf, `,"newkey":`...), d.newKey)
+		})))
+	case "flushdb":
+		events = append(events, string(event("", nil)))
+	case "expire":
+		if len(args) != 4 {
+			return events
+		}
+		events = append(events, string(event(args[1], func(buf []byte) []byte {
+			buf = withID(args[2])(buf)
+			return append(append(buf, `,"ttl":`...), args[3]...)
+		})))
+	case "persist":
+		if len(args) != 3 {
+			return events
+		}
+		events = append(events, string(event(args[1], withID(args[2]))))
+	}
+	return events
+}
+
+// appendChangeFields appends the non-zero fields of an object.
+func appendChangeFields(buf []byte, fmap map[string]int, fields []field.Value) []byte {
+	if len(fmap) == 0 || len(fields) == 0 {
+		return buf
+	}
+	farr := make([]string, 0, len(fmap))
+	for field := range fmap {
+		farr = append(farr, field)
+	}
+	sort.Slice(farr, func(i, j int) bool { return fmap[farr[i]] < fmap[farr[j]] })
+	buf = append(buf, `,"fields":{`...)
+	for i, fv := range orderFields(fmap, farr, fields) {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONString(buf, fv.field)
+		buf = append(buf, ':')
+		buf = append(buf, fv.value.JSON()...)
+	}
+	return append(buf, '}')
+}
+
+// watchCDC delivers the queued change events to the cdc endpoint, in the
+// order that they were queued. An event is removed from the queue after it
+// has been sent, so an event may be delivered more than once when the server
+// stops during a send. The seq member can be used to detect duplicates.
+func (s *Server) watchCDC() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.cdcsig:
+		case <-t.C:
+		}
+		if s.stopServer.on() {
+			return
+		}
+		for s.sendChanges() {
+		}
+	}
+}
+
+// sendChanges sends the next batch of queued change events. Returns true when
+// the whole batch was sent and there may be more events in the queue.
+func (s *Server) sendChanges() bool {
+	target := s.config.cdcEndpoint()
+	if target == "" {
+		return false
+	}
+	var keys, vals []string
+	err := s.qdb.View(func(tx *buntdb.Tx) error {
+		return tx.AscendGreaterOrEqual("", cdcLogPrefix,
+			func(key, val string) bool {
+				if !strings.HasPrefix(key, cdcLogPrefix) {
+					return false
+				}
+				keys = append(keys, key)
+				vals = append(vals, val)
+				return len(keys) < cdcBatchSize
+			},
+		)
+	})
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	var sent int
+	for _, val := range vals {
+		if err := s.epc.Send(target, val); err != nil {
+			epLog.Debugf("CDC endpoint send error: %v: %v",
+				endpoint.Redact(target), err)
+			break
+		}
+		sent++
+	}
+	if sent == 0 {
+		return false
+	}
+	s.statsCDCSent.add(sent)
+	err = s.qdb.Update(func(tx *buntdb.Tx) error {
+		for _, key := range keys[:sent] {
+			if _, err := tx.Delete(key); err != nil && err != buntdb.ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	return sent == cdcBatchSize
+}
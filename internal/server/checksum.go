@@ -6,11 +6,9 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"time"
 
 	"github.com/tidwall/resp"
 	"github.com/tidwall/tile38/core"
-	"github.com/tidwall/tile38/internal/log"
 )
 
 // checksum performs a simple md5 checksum on the aof file
@@ -18,8 +16,8 @@ func (s *Server) checksum(pos, size int64) (sum string, err error) {
 	if pos+size > int64(s.aofsz) {
 		return "", io.EOF
 	}
-	var f *os.File
-	f, err = os.Open(s.aof.Name())
+	var f *cryptFile
+	f, err = s.openAOFReader()
 	if err != nil {
 		return
 	}
@@ -94,9 +92,9 @@ func (s *Server) matchChecksums(conn *RESPConn, pos, size int64) (match bool, er
 
 // getEndOfLastValuePositionInFile is a very slow operation because it reads the file
 // backwards on byte at a time. Eek. It seek+read, seek+read, etc.
-func getEndOfLastValuePositionInFile(fname string, startPos int64) (int64, error) {
+func (s *Server) getEndOfLastValuePositionInFile(fname string, startPos int64) (int64, error) {
 	pos := startPos
-	f, err := os.Open(fname)
+	f, err := openCryptFile(fname, os.O_RDONLY, s.aead)
 	if err != nil {
 		return 0, err
 	}
@@ -136,11 +134,31 @@ func getEndOfLastValuePositionInFile(fname string, startPos int64) (int64, error
 	}
 }
 
+// followStartOver empties the aof and the dataset, so that the whole aof of
+// the leader is followed. The caller must hold the server lock.
+func (s *Server) followStartOver() error {
+	if s.aofsz > 0 {
+		replLog.Infof("follow: starting over with an empty dataset")
+	}
+	fname := s.aof.Name()
+	s.aof.Close()
+	var err error
+	s.aof, err = openCryptFile(fname, os.O_CREATE|os.O_RDWR|os.O_TRUNC, s.aead)
+	if err != nil {
+		replLog.Fatalf("could not recreate aof, possible data loss. %s", err.Error())
+		return err
+	}
+	s.aofbuf = s.aofbuf[:0]
+	s.reset()
+	s.resetBacklog(true)
+	return nil
+}
+
 // followCheckSome is not a full checksum. It just "checks some" data.
 // We will do some various checksums on the leader until we find the correct position to start at.
 func (s *Server) followCheckSome(addr string, followc int) (pos int64, err error) {
 	if core.ShowDebugMessages {
-		log.Debug("follow:", addr, ":check some")
+		replLog.Debug("follow:", addr, ":check some")
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -148,10 +166,10 @@ func (s *Server) followCheckSome(addr string, followc int) (pos int64, err error
 		return 0, errNoLongerFollowing
 	}
 	if s.aofsz < checksumsz {
-		return 0, nil
+		return 0, s.followStartOver()
 	}
 
-	conn, err := DialTimeout(addr, time.Second*2)
+	conn, err := s.followDial(addr, s.config.leaderAuth(), s.config.followTLS())
 	if err != nil {
 		return 0, err
 	}
@@ -188,48 +206,41 @@ func (s *Server) followCheckSome(addr string, followc int) (pos int64, err error
 	fullpos := pos
 	fname := s.aof.Name()
 	if pos == 0 {
-		s.aof.Close()
-		s.aof, err = os.Create(fname)
-		if err != nil {
-			log.Fatalf("could not recreate aof, possible data loss. %s", err.Error())
-			return 0, err
-		}
-		return 0, nil
+		return 0, s.followStartOver()
 	}
-
 	// we want to truncate at a command location
 	// search for nearest command
-	pos, err = getEndOfLastValuePositionInFile(s.aof.Name(), fullpos)
+	pos, err = s.getEndOfLastValuePositionInFile(s.aof.Name(), fullpos)
 	if err != nil {
 		return 0, err
 	}
 	if pos == fullpos {
 		if core.ShowDebugMessages {
-			log.Debug("follow: aof fully intact")
+			replLog.Debug("follow: aof fully intact")
 		}
 		return pos, nil
 	}
-	log.Warnf("truncating aof to %d", pos)
+	replLog.Warnf("truncating aof to %d", pos)
 	// any errror below are fatal.
 	s.aof.Close()
-	if err := os.Truncate(fname, pos); err != nil {
-		log.Fatalf("could not truncate aof, possible data loss. %s", err.Error())
+	s.aof, err = s.openAOF(fname)
+	if err != nil {
+		replLog.Fatalf("could not create aof, possible data loss. %s", err.Error())
 		return 0, err
 	}
-	s.aof, err = os.OpenFile(fname, os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
-		log.Fatalf("could not create aof, possible data loss. %s", err.Error())
+	if err := s.aof.Truncate(pos); err != nil {
+		replLog.Fatalf("could not truncate aof, possible data loss. %s", err.Error())
 		return 0, err
 	}
 	// reset the entire system.
-	log.Infof("reloading aof commands")
+	replLog.Infof("reloading aof commands")
 	s.reset()
 	if err := s.loadAOF(); err != nil {
-		log.Fatalf("could not reload aof, possible data loss. %s", err.Error())
+		replLog.Fatalf("could not reload aof, possible data loss. %s", err.Error())
 		return 0, err
 	}
 	if int64(s.aofsz) != pos {
-		log.Fatalf("aof size mismatch during reload, possible data loss.")
+		replLog.Fatalf("aof size mismatch during reload, possible data loss.")
 		return 0, errors.New("?")
 	}
 	return pos, nil
@@ -6,32 +6,61 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/trace"
 )
 
 // Client is an remote connection into to Tile38
 type Client struct {
-	id         int            // unique id
-	replPort   int            // the known replication port for follower connections
-	authd      bool           // client has been authenticated
-	outputType Type           // Null, JSON, or RESP
-	remoteAddr string         // original remote address
-	in         InputStream    // input stream
-	pr         PipelineReader // command reader
-	out        []byte         // output write buffer
+	id            int            // unique id
+	replPort      int            // the known replication port for follower connections
+	authd         bool           // client has been authenticated
+	repl          bool           // client has been authenticated with the replpass
+	tls           bool           // client is connected to the tls port
+	clusterImport bool           // client may write to any slot, see CLUSTER IMPORTING
+	outputType    Type           // Null, JSON, or RESP
+	resp3         bool           // client switched to RESP3 with HELLO 3
+	remoteAddr    string         // original remote address
+	in            InputStream    // input stream
+	pr            PipelineReader // command reader
+	out           []byte         // output write buffer
+	pending       []byte         // input read while a query ran, see watchDisconnect
 
 	goLiveErr error    // error type used for going line
 	goLiveMsg *Message // last message for go live
 
+	multi   bool       // a transaction was started with MULTI
+	queued  []*Message // commands of the transaction
+	aborted bool       // a command could not be queued
+	watched []watch    // keys and objects watched for the transaction
+	dirty   bool       // a watched key or object was changed
+
+	writes    uint64 // writes in the current second, for the quota
+	writesSec int64  // the current second of the writes
+	aofpos    int    // the aof writes up to the last write, see flushClientAOF
+	raftpos   int    // the aofpos of the last write committed, see raftCommit
+
+	traceparent trace.SpanContext // parent of the spans, see CLIENT TRACEPARENT
+
+	encoding encoding     // the encoding of the json output, see OUTPUT
+	csv      []string     // the columns of OUTPUT csv
+	flat     *flatEncoder // the encoder of OUTPUT flatbuffers
+	bounds   bool         // the bounds of the objects, see OUTPUT BOUNDS
+
 	mu     sync.Mutex         // guard
-	conn   io.ReadWriteCloser // out-of-loop connection.
+	conn   io.ReadWriteCloser // the connection, for CLIENT KILL
 	name   string             // optional defined name
+	user   string             // the user of the ACL, or empty for the default user
+	ns     string             // the selected namespace, or empty for the default
 	opened time.Time          // when the client was created/opened, unix nano
 	last   time.Time          // last client request/response, unix nano
+	omem   int                // the size of the output that is being written
+	killed bool               // the connection is closed after the output
 }
 
 // Write ...
@@ -61,30 +90,28 @@ func (s *Server) cmdClient(msg *Message, client *Client) (resp.Value, error) {
 	switch strings.ToLower(msg.Args[1]) {
 	default:
 		return NOMessage, clientErrorf(
-			"Syntax error, try CLIENT (LIST | KILL | GETNAME | SETNAME)",
+			"Syntax error, try CLIENT (LIST | KILL | GETNAME | SETNAME | TRACEPARENT)",
 		)
 	case "list":
-		if len(msg.Args) != 2 {
-			return NOMessage, errInvalidNumberOfArguments
-		}
-		var list []*Client
-		s.connsmu.RLock()
-		for _, cc := range s.conns {
-			list = append(list, cc)
+		filter, err := parseClientFilter(msg.Args[2:], false)
+		if err != nil {
+			return NOMessage, err
 		}
-		s.connsmu.RUnlock()
-		sort.Sort(byID(list))
+		list := s.clients(filter, nil)
 		now := time.Now()
 		var buf []byte
 		for _, client := range list {
 			client.mu.Lock()
 			buf = append(buf,
-				fmt.Sprintf("id=%d addr=%s name=%s age=%d idle=%d\n",
+				fmt.Sprintf("id=%d addr=%s name=%s user=%s age=%d idle=%d resp=%d omem=%d\n",
 					client.id,
 					client.remoteAddr,
 					client.name,
+					aclUserName(client.user),
 					now.Sub(client.opened)/time.Second,
 					now.Sub(client.last)/time.Second,
+					2+boolInt(client.resp3),
+					client.omem,
 				)...,
 			)
 			client.mu.Unlock()
@@ -117,6 +144,10 @@ func (s *Server) cmdClient(msg *Message, client *Client) (resp.Value, error) {
 			}
 			return resp.StringValue(`{"ok":true,"list":` + string(data) + `,"elapsed":"` + time.Since(start).String() + "\"}"), nil
 		case RESP:
+			if len(buf) == 0 {
+				// an empty bulk string would be no reply at all
+				return resp.NullValue(), nil
+			}
 			return resp.BytesValue(buf), nil
 		}
 		return NOMessage, nil
@@ -157,77 +188,174 @@ func (s *Server) cmdClient(msg *Message, client *Client) (resp.Value, error) {
 		case RESP:
 			return resp.SimpleStringValue("OK"), nil
 		}
+	case "traceparent":
+		if len(msg.Args) != 3 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		// an empty traceparent clears the parent
+		var parent trace.SpanContext
+		if msg.Args[2] != "" {
+			var err error
+			if parent, err = trace.ParseTraceparent(msg.Args[2]); err != nil {
+				return NOMessage, err
+			}
+		}
+		client.traceparent = parent
+		switch msg.OutputType {
+		case JSON:
+			return resp.StringValue(`{"ok":true,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+		case RESP:
+			return resp.SimpleStringValue("OK"), nil
+		}
 	case "kill":
 		if len(msg.Args) < 3 {
 			return NOMessage, errInvalidNumberOfArguments
 		}
-		var useAddr bool
-		var addr string
-		var useID bool
-		var id string
-		for i := 2; i < len(msg.Args); i++ {
-			arg := msg.Args[i]
-			if strings.Contains(arg, ":") {
-				addr = arg
-				useAddr = true
-				break
-			}
-			switch strings.ToLower(arg) {
-			default:
+		if len(msg.Args) == 3 {
+			// the old form, which is the address of a client
+			list := s.clients(&clientFilter{addr: msg.Args[2]}, nil)
+			if len(list) == 0 {
 				return NOMessage, clientErrorf("No such client")
-			case "addr":
-				i++
-				if i == len(msg.Args) {
-					return NOMessage, errors.New("syntax error")
+			}
+			s.killClients(list, client)
+			switch msg.OutputType {
+			case JSON:
+				return resp.StringValue(`{"ok":true,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+			case RESP:
+				return resp.SimpleStringValue("OK"), nil
+			}
+		}
+		filter, err := parseClientFilter(msg.Args[2:], true)
+		if err != nil {
+			return NOMessage, err
+		}
+		skip := client
+		if !filter.skipMe {
+			skip = nil
+		}
+		list := s.clients(filter, skip)
+		s.killClients(list, client)
+		switch msg.OutputType {
+		case JSON:
+			return resp.StringValue(`{"ok":true,"killed":` + strconv.Itoa(len(list)) +
+				`,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+		case RESP:
+			return resp.IntegerValue(len(list)), nil
+		}
+	}
+	return NOMessage, errors.New("invalid output type")
+}
+
+// clientFilter selects the clients of CLIENT LIST and CLIENT KILL. The
+// clients must match all of the filters that are set.
+type clientFilter struct {
+	ids    map[int]bool // the ids, or nil for any client
+	addr   string       // the address, or empty for any client
+	user   string       // the user of the ACL, or empty for any client
+	skipMe bool         // the client of CLIENT KILL is not killed
+}
+
+// parseClientFilter parses the filters of CLIENT LIST, which are
+// ID id [id ...], USER username, and ADDR ip:port, or the filters of CLIENT
+// KILL, which are ID id, USER username, ADDR ip:port, and SKIPME yes/no.
+func parseClientFilter(args []string, kill bool) (*clientFilter, error) {
+	filter := &clientFilter{skipMe: true}
+	for i := 0; i < len(args); i++ {
+		if i+1 == len(args) {
+			return nil, errInvalidNumberOfArguments
+		}
+		switch strings.ToLower(args[i]) {
+		default:
+			return nil, errInvalidArgument(args[i])
+		case "id":
+			if filter.ids == nil {
+				filter.ids = make(map[int]bool)
+			}
+			for i+1 < len(args) {
+				id, err := strconv.Atoi(args[i+1])
+				if err != nil {
+					if len(filter.ids) == 0 {
+						return nil, errInvalidArgument(args[i+1])
+					}
+					break
 				}
-				addr = msg.Args[i]
-				useAddr = true
-			case "id":
+				filter.ids[id] = true
 				i++
-				if i == len(msg.Args) {
-					return NOMessage, errors.New("syntax error")
+				if kill {
+					break
 				}
-				id = msg.Args[i]
-				useID = true
 			}
-		}
-		var cclose *Client
-		s.connsmu.RLock()
-		for _, cc := range s.conns {
-			if useID && fmt.Sprintf("%d", cc.id) == id {
-				cclose = cc
-				break
-			} else if useAddr && client.remoteAddr == addr {
-				cclose = cc
-				break
+		case "addr":
+			i++
+			filter.addr = args[i]
+		case "user":
+			i++
+			filter.user = args[i]
+		case "skipme":
+			if !kill {
+				return nil, errInvalidArgument(args[i])
+			}
+			i++
+			switch strings.ToLower(args[i]) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return nil, errInvalidArgument(args[i])
 			}
 		}
-		s.connsmu.RUnlock()
-		if cclose == nil {
-			return NOMessage, clientErrorf("No such client")
+	}
+	return filter, nil
+}
+
+// match returns true when a client matches the filter.
+func (filter *clientFilter) match(client *Client) bool {
+	if filter.ids != nil && !filter.ids[client.id] {
+		return false
+	}
+	if filter.addr != "" && client.remoteAddr != filter.addr {
+		return false
+	}
+	if filter.user != "" {
+		client.mu.Lock()
+		user := client.user
+		client.mu.Unlock()
+		if aclUserName(user) != filter.user {
+			return false
 		}
+	}
+	return true
+}
 
-		var res resp.Value
-		switch msg.OutputType {
-		case JSON:
-			res = resp.StringValue(`{"ok":true,"elapsed":"` + time.Since(start).String() + "\"}")
-		case RESP:
-			res = resp.SimpleStringValue("OK")
+// clients returns the clients that match a filter, except for skip, in the
+// order of their ids.
+func (s *Server) clients(filter *clientFilter, skip *Client) []*Client {
+	var list []*Client
+	s.connsmu.RLock()
+	for _, cc := range s.conns {
+		if cc != skip && filter.match(cc) {
+			list = append(list, cc)
 		}
+	}
+	s.connsmu.RUnlock()
+	sort.Sort(byID(list))
+	return list
+}
 
-		client.conn.Close()
-		// closing self, return response now
-		// NOTE: This is the only exception where we do convert response to a string
-		var outBytes []byte
-		switch msg.OutputType {
-		case JSON:
-			outBytes = res.Bytes()
-		case RESP:
-			outBytes, _ = res.MarshalRESP()
+// killClients closes the connections of clients. The connection of the
+// client of the command is closed after its output has been written.
+func (s *Server) killClients(list []*Client, self *Client) {
+	for _, cc := range list {
+		if cc == self {
+			cc.killed = true
+			continue
+		}
+		cc.mu.Lock()
+		conn := cc.conn
+		cc.mu.Unlock()
+		if conn != nil {
+			conn.Close()
 		}
-		cclose.conn.Write(outBytes)
-		cclose.conn.Close()
-		return res, nil
 	}
-	return NOMessage, errors.New("invalid output type")
 }
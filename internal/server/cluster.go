@@ -0,0 +1,1210 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/field"
+	"github.com/tidwall/tile38/internal/log"
+)
+
+// Cluster mode is enabled when the clusteraddr property is set. Collections
+// are partitioned across the nodes of the cluster by the hash slot of their
+// key, which is the CRC16 of the key modulo 16384, as with Redis Cluster. A
+// key that has a {tag} is hashed by the tag alone, which places collections
+// with the same tag, such as the geohash prefix of a region in
+// fleet:{9q8} and stops:{9q8}, on the same node. Every object of a collection
+// is on one node, so that a search such as NEARBY has the same results as on
+// a single server.
+//
+// A command for a key in a slot that is served by another node returns a
+// MOVED error with the slot and the address of the node. The nodes exchange
+// the topology every second. Each slot assignment has an epoch, and the
+// assignment with the highest epoch wins.
+
+const (
+	clusterSlots = 16384
+	// clusterGossipInterval is how often the topology is exchanged.
+	clusterGossipInterval = time.Second
+	// clusterMigrateBatch is the number of commands that are sent at a time
+	// when migrating a slot.
+	clusterMigrateBatch = 1000
+)
+
+var (
+	errClusterDisabled = errors.New("cluster disabled")
+	errCrossSlot       = errors.New(
+		"CROSSSLOT keys in request don't hash to the same slot")
+	errClusterDown = errors.New("CLUSTERDOWN hash slot not served")
+	errTryAgain    = errors.New("TRYAGAIN slot is migrating")
+)
+
+// isClusterErr returns true for the errors that cluster clients expect
+// without the ERR prefix.
+func isClusterErr(errMsg string) bool {
+	for _, prefix := range []string{"MOVED ", "CROSSSLOT ", "CLUSTERDOWN ",
+		"TRYAGAIN "} {
+		if strings.HasPrefix(errMsg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var crc16tab = func() (tab [256]uint16) {
+	for i := range tab {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		tab[i] = crc
+	}
+	return tab
+}()
+
+// crc16 is CRC16-CCITT (XMODEM), which is used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16tab[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// keySlot returns the hash slot of a key.
+func keySlot(key string) int {
+	if i := strings.IndexByte(key, '{'); i != -1 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// clusterNode is a member of the cluster. A node that was removed is kept,
+// so that the removal is not undone by the other nodes.
+type clusterNode struct {
+	ID      string `json:"id"`
+	Addr    string `json:"addr"`
+	Epoch   uint64 `json:"epoch"`
+	Removed bool   `json:"removed,omitempty"`
+
+	seen time.Time // when the topology was last exchanged with the node
+}
+
+// clusterRange is a range of slots that are assigned to a node in the same
+// epoch. A range without a node is not served.
+type clusterRange struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Node  string `json:"node,omitempty"`
+	Epoch uint64 `json:"epoch"`
+}
+
+// clusterTopology is the topology that is persisted and exchanged.
+type clusterTopology struct {
+	Epoch uint64         `json:"epoch"`
+	Nodes []*clusterNode `json:"nodes"`
+	Slots []clusterRange `json:"slots"`
+}
+
+type clusterSlot struct {
+	node  string
+	epoch uint64
+}
+
+// clusterState is the topology of the cluster. The server mutex must not be
+// acquired while holding mu.
+type clusterState struct {
+	mu        sync.RWMutex
+	epoch     uint64
+	nodes     map[string]*clusterNode
+	slots     [clusterSlots]clusterSlot
+	migrating map[int]bool
+}
+
+// topology returns the topology. The caller must hold the lock.
+func (c *clusterState) topology() clusterTopology {
+	t := clusterTopology{Epoch: c.epoch, Nodes: []*clusterNode{},
+		Slots: []clusterRange{}}
+	for _, node := range c.nodes {
+		t.Nodes = append(t.Nodes, node)
+	}
+	sort.Slice(t.Nodes, func(i, j int) bool {
+		return t.Nodes[i].ID < t.Nodes[j].ID
+	})
+	for i := 0; i < clusterSlots; i++ {
+		slot := c.slots[i]
+		if slot.epoch == 0 {
+			continue
+		}
+		n := len(t.Slots)
+		if n > 0 && t.Slots[n-1].End == i-1 && t.Slots[n-1].Node == slot.node &&
+			t.Slots[n-1].Epoch == slot.epoch {
+			t.Slots[n-1].End = i
+			continue
+		}
+		t.Slots = append(t.Slots, clusterRange{i, i, slot.node, slot.epoch})
+	}
+	return t
+}
+
+// encode returns the topology as json. The caller must hold the lock.
+func (c *clusterState) encode() string {
+	data, _ := json.Marshal(c.topology())
+	return string(data)
+}
+
+// merge merges another topology, and returns true when the topology
+// changed. The node or slot assignment with the highest epoch wins, and a tie
+// is won by the highest address of a node or node id of a slot. The caller
+// must hold the lock.
+func (c *clusterState) merge(t clusterTopology) (changed bool) {
+	if c.nodes == nil {
+		c.nodes = make(map[string]*clusterNode)
+	}
+	if t.Epoch > c.epoch {
+		c.epoch = t.Epoch
+		changed = true
+	}
+	for _, node := range t.Nodes {
+		if node == nil || node.ID == "" {
+			continue
+		}
+		cur := c.nodes[node.ID]
+		if cur == nil || node.Epoch > cur.Epoch ||
+			(node.Epoch == cur.Epoch && node.Addr > cur.Addr) {
+			n := *node
+			if cur != nil {
+				n.seen = cur.seen
+			}
+			c.nodes[node.ID] = &n
+			changed = true
+		}
+	}
+	for _, r := range t.Slots {
+		if r.Start < 0 || r.End >= clusterSlots {
+			continue
+		}
+		for i := r.Start; i <= r.End; i++ {
+			cur := c.slots[i]
+			if r.Epoch > cur.epoch || (r.Epoch == cur.epoch && r.Node > cur.node) {
+				c.slots[i] = clusterSlot{r.Node, r.Epoch}
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// nextEpoch returns a new epoch for a change. The caller must hold the lock.
+func (c *clusterState) nextEpoch() uint64 {
+	c.epoch++
+	return c.epoch
+}
+
+// setNode adds or updates a node. The caller must hold the lock.
+func (c *clusterState) setNode(id, addr string) bool {
+	if c.nodes == nil {
+		c.nodes = make(map[string]*clusterNode)
+	}
+	if node := c.nodes[id]; node != nil && node.Addr == addr && !node.Removed {
+		return false
+	}
+	c.nodes[id] = &clusterNode{ID: id, Addr: addr, Epoch: c.nextEpoch()}
+	return true
+}
+
+// node returns a node that has not been removed. The caller must hold the
+// lock.
+func (c *clusterState) node(id string) *clusterNode {
+	if node := c.nodes[id]; node != nil && !node.Removed {
+		return node
+	}
+	return nil
+}
+
+// assign assigns the slots to a node, or unassigns them when the node is
+// empty. The caller must hold the lock.
+func (c *clusterState) assign(slots []int, node string) {
+	epoch := c.nextEpoch()
+	for _, slot := range slots {
+		c.slots[slot] = clusterSlot{node, epoch}
+	}
+}
+
+// nodeSlots returns the slot ranges of a node. The caller must hold the lock.
+func (c *clusterState) nodeSlots(id string) (ranges [][2]int) {
+	for i := 0; i < clusterSlots; i++ {
+		if c.slots[i].node != id {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1][1] == i-1 {
+			ranges[n-1][1] = i
+		} else {
+			ranges = append(ranges, [2]int{i, i})
+		}
+	}
+	return ranges
+}
+
+func formatSlotRanges(ranges [][2]int) []string {
+	strs := make([]string, len(ranges))
+	for i, r := range ranges {
+		if r[0] == r[1] {
+			strs[i] = strconv.Itoa(r[0])
+		} else {
+			strs[i] = fmt.Sprintf("%d-%d", r[0], r[1])
+		}
+	}
+	return strs
+}
+
+func (s *Server) clusterEnabled() bool {
+	return s.config.clusterAddr() != ""
+}
+
+// clusterSelf adds this server to the topology, or updates its address.
+func (s *Server) clusterSelf() {
+	addr := s.config.clusterAddr()
+	if addr == "" {
+		return
+	}
+	s.cluster.mu.Lock()
+	changed := s.cluster.setNode(s.config.serverID(), addr)
+	s.cluster.mu.Unlock()
+	if changed {
+		s.clusterSave()
+	}
+}
+
+// clusterLoad loads the topology that was persisted in the config.
+func (s *Server) clusterLoad() error {
+	data := s.config.clusterTopology()
+	if data == "" {
+		return nil
+	}
+	var t clusterTopology
+	if err := json.Unmarshal([]byte(data), &t); err != nil {
+		return fmt.Errorf("invalid cluster topology: %v", err)
+	}
+	s.cluster.mu.Lock()
+	s.cluster.merge(t)
+	s.cluster.mu.Unlock()
+	return nil
+}
+
+// clusterSave persists the topology in the config.
+func (s *Server) clusterSave() {
+	s.cluster.mu.RLock()
+	data := s.cluster.encode()
+	s.cluster.mu.RUnlock()
+	s.config.setClusterTopology(data)
+	s.config.write(false)
+}
+
+// clusterMerge merges a topology from another node.
+func (s *Server) clusterMerge(data string) error {
+	var t clusterTopology
+	if err := json.Unmarshal([]byte(data), &t); err != nil {
+		return errors.New("invalid topology")
+	}
+	s.cluster.mu.Lock()
+	changed := s.cluster.merge(t)
+	s.cluster.mu.Unlock()
+	if changed {
+		s.clusterSave()
+	}
+	return nil
+}
+
+// clusterExchange sends the topology to a node and merges the topology that
+// it sends back.
+func (s *Server) clusterExchange(conn *RESPConn) error {
+	s.cluster.mu.RLock()
+	data := s.cluster.encode()
+	s.cluster.mu.RUnlock()
+	conn.conn.SetDeadline(time.Now().Add(time.Second * 2))
+	v, err := conn.Do("cluster", "topology", data)
+	if err != nil {
+		return err
+	}
+	if v.Error() != nil {
+		return v.Error()
+	}
+	return s.clusterMerge(v.String())
+}
+
+// clusterDial connects to another node.
+func (s *Server) clusterDial(addr string) (*RESPConn, error) {
+	conn, err := DialTimeout(addr, time.Second*2)
+	if err != nil {
+		return nil, err
+	}
+	if auth := s.config.leaderAuth(); auth != "" {
+		if err := s.followDoLeaderAuth(conn, auth); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// watchCluster exchanges the topology with the other nodes.
+func (s *Server) watchCluster() {
+	conns := make(map[string]*RESPConn)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	t := time.NewTicker(clusterGossipInterval)
+	defer t.Stop()
+	for range t.C {
+		if s.stopServer.on() {
+			return
+		}
+		if !s.clusterEnabled() {
+			continue
+		}
+		s.clusterSelf()
+		myid := s.config.serverID()
+		s.cluster.mu.RLock()
+		addrs := make(map[string]string)
+		for _, node := range s.cluster.nodes {
+			if node.ID != myid && !node.Removed {
+				addrs[node.ID] = node.Addr
+			}
+		}
+		s.cluster.mu.RUnlock()
+		for id, conn := range conns {
+			if addrs[id] == "" || conn.conn.RemoteAddr() == nil {
+				conn.Close()
+				delete(conns, id)
+			}
+		}
+		for id, addr := range addrs {
+			conn := conns[id]
+			if conn == nil {
+				var err error
+				if conn, err = s.clusterDial(addr); err != nil {
+					continue
+				}
+				conns[id] = conn
+			}
+			if err := s.clusterExchange(conn); err != nil {
+				log.Debugf("cluster: %s: %v", addr, err)
+				conn.Close()
+				delete(conns, id)
+				continue
+			}
+			s.cluster.mu.Lock()
+			if node := s.cluster.nodes[id]; node != nil {
+				node.seen = time.Now()
+			}
+			s.cluster.mu.Unlock()
+		}
+	}
+}
+
+// clusterKeys returns the keys of the collections that a command uses.
+func clusterKeys(args []string) []string {
+	if len(args) < 2 {
+		return nil
+	}
+	switch strings.ToLower(args[0]) {
+	case "set", "pset", "fset", "fincrby", "jset", "jdel", "jget", "get",
+		"del", "pdel", "drop", "expire", "persist", "ttl", "type", "exists", "bounds",
+		"scan", "nearby", "within", "intersects", "search", "import", "export",
+		"setschema", "delschema", "schema", "setlimits", "dellimits", "limits",
+		"setindex", "delindex", "indexes",
+		"setgeoindex", "geoindex", "sethistory", "delhistory", "history",
+		"trajectory", "passed", "matrix", "tile", "setlabel", "dellabel",
+		"labels", "reindex":
+		return args[1:2]
+	case "rename", "renamenx", "copy":
+		if len(args) > 2 {
+			return args[1:3]
+		}
+		return args[1:2]
+	case "lww":
+		if len(args) > 3 {
+			return clusterKeys(args[3:])
+		}
+	case "memory":
+		// the key follows the subcommand
+		if len(args) > 2 {
+			return args[2:3]
+		}
+	case "sethook", "setchan":
+		// the key follows the type of the search
+		for i := 3; i < len(args)-1; i++ {
+			switch strings.ToLower(args[i]) {
+			case "nearby", "within", "intersects":
+				return args[i+1 : i+2]
+			}
+		}
+	case "eval", "evalsha", "evalro", "evalrosha", "evalna", "evalnasha",
+		"fcall", "fcall_ro":
+		// the function or script is followed by the numkeys and the keys
+		if len(args) > 2 {
+			n, err := strconv.Atoi(args[2])
+			if err == nil && n > 0 && len(args) >= 3+n {
+				return args[3 : 3+n]
+			}
+		}
+	}
+	return nil
+}
+
+// clusterWrite returns true for the commands that may change a collection.
+func clusterWrite(command string) bool {
+	switch command {
+	case "set", "pset", "fset", "fincrby", "jset", "jdel", "del", "pdel",
+		"drop", "expire", "persist", "rename", "renamenx", "copy", "lww",
+		"import", "sethook", "setchan", "setschema", "delschema", "setlimits", "dellimits", "setindex", "delindex", "setgeoindex",
+		"sethistory", "delhistory", "setlabel", "dellabel",
+		"eval", "evalsha", "evalna", "evalnasha", "fcall":
+		return true
+	}
+	return false
+}
+
+// clusterCheck returns an error when the keys of a command are not served by
+// this node. The server lock must be held for a write, so that a slot does
+// not start migrating while the write happens.
+func (s *Server) clusterCheck(msg *Message, client *Client) error {
+	if client.clusterImport || !s.clusterEnabled() {
+		return nil
+	}
+	keys := clusterKeys(msg.Args)
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := keySlot(keys[0])
+	for _, key := range keys[1:] {
+		if keySlot(key) != slot {
+			return errCrossSlot
+		}
+	}
+	s.cluster.mu.RLock()
+	defer s.cluster.mu.RUnlock()
+	owner := s.cluster.slots[slot].node
+	if owner == "" {
+		return errClusterDown
+	}
+	if owner != s.config.serverID() {
+		node := s.cluster.node(owner)
+		if node == nil {
+			return errClusterDown
+		}
+		return fmt.Errorf("MOVED %d %s", slot, node.Addr)
+	}
+	if s.cluster.migrating[slot] && clusterWrite(msg.Command()) {
+		return errTryAgain
+	}
+	return nil
+}
+
+// parseSlot parses a hash slot.
+func parseSlot(s string) (int, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n >= clusterSlots {
+		return 0, errInvalidArgument(s)
+	}
+	return int(n), nil
+}
+
+// CLUSTER INFO
+// CLUSTER MYID
+// CLUSTER NODES
+// CLUSTER SLOTS
+// CLUSTER KEYSLOT key
+// CLUSTER COUNTKEYSINSLOT slot
+// CLUSTER GETKEYSINSLOT slot count
+// CLUSTER MEET host port
+// CLUSTER FORGET id
+// CLUSTER ADDSLOTS slot [slot ...]
+// CLUSTER ADDSLOTSRANGE start end [start end ...]
+// CLUSTER DELSLOTS slot [slot ...]
+// CLUSTER SETSLOT slot NODE id
+// CLUSTER MIGRATE slot id
+func (s *Server) cmdCluster(msg *Message, client *Client) (res resp.Value,
+	err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	var subcmd string
+	if vs, subcmd, ok = tokenval(vs); !ok {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	subcmd = strings.ToLower(subcmd)
+	switch subcmd {
+	case "keyslot":
+		if len(vs) != 1 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		return intMessage(msg, start, "slot", keySlot(vs[0])), nil
+	case "info":
+		if len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		return s.clusterInfo(msg, start), nil
+	}
+	if !s.clusterEnabled() {
+		return NOMessage, errClusterDisabled
+	}
+	s.clusterSelf()
+	myid := s.config.serverID()
+	switch subcmd {
+	case "myid":
+		if len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		if msg.OutputType == JSON {
+			return resp.StringValue(`{"ok":true,"id":` + jsonString(myid) +
+				`,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+		}
+		return resp.StringValue(myid), nil
+	case "nodes":
+		if len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		return s.clusterNodes(msg, start), nil
+	case "slots":
+		if len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		return s.clusterSlotsInfo(msg, start), nil
+	case "countkeysinslot", "getkeysinslot":
+		if (subcmd == "countkeysinslot" && len(vs) != 1) ||
+			(subcmd == "getkeysinslot" && len(vs) != 2) {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		slot, err := parseSlot(vs[0])
+		if err != nil {
+			return NOMessage, err
+		}
+		count := -1
+		if subcmd == "getkeysinslot" {
+			n, err := strconv.ParseUint(vs[1], 10, 32)
+			if err != nil {
+				return NOMessage, errInvalidArgument(vs[1])
+			}
+			count = int(n)
+		}
+		s.mu.RLock()
+		keys := s.slotKeys(slot, count)
+		s.mu.RUnlock()
+		if subcmd == "countkeysinslot" {
+			return intMessage(msg, start, "count", len(keys)), nil
+		}
+		if msg.OutputType == JSON {
+			data, _ := json.Marshal(keys)
+			return resp.StringValue(`{"ok":true,"keys":` + string(data) +
+				`,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+		}
+		vals := make([]resp.Value, len(keys))
+		for i, key := range keys {
+			vals[i] = resp.StringValue(key)
+		}
+		return resp.ArrayValue(vals), nil
+	case "topology":
+		// internal, exchanges the topology with another node
+		if len(vs) != 1 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		if err := s.clusterMerge(vs[0]); err != nil {
+			return NOMessage, err
+		}
+		s.cluster.mu.RLock()
+		data := s.cluster.encode()
+		s.cluster.mu.RUnlock()
+		return resp.StringValue(data), nil
+	case "importing":
+		// internal, allows the connection to write to any slot while a
+		// slot is migrated to this node
+		if len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		client.clusterImport = true
+		return OKMessage(msg, start), nil
+	case "meet":
+		if len(vs) != 2 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		port, err := strconv.ParseUint(vs[1], 10, 16)
+		if err != nil {
+			return NOMessage, errInvalidArgument(vs[1])
+		}
+		conn, err := s.clusterDial(fmt.Sprintf("%s:%d", vs[0], port))
+		if err != nil {
+			return NOMessage, fmt.Errorf("cannot meet: %v", err)
+		}
+		defer conn.Close()
+		if err := s.clusterExchange(conn); err != nil {
+			return NOMessage, fmt.Errorf("cannot meet: %v", err)
+		}
+		return OKMessage(msg, start), nil
+	case "forget":
+		if len(vs) != 1 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		id := vs[0]
+		s.cluster.mu.Lock()
+		node := s.cluster.node(id)
+		switch {
+		case node == nil:
+			err = errors.New("unknown node")
+		case id == myid:
+			err = errors.New("cannot forget self")
+		case len(s.cluster.nodeSlots(id)) > 0:
+			err = errors.New("cannot forget a node that serves slots")
+		default:
+			s.cluster.nodes[id] = &clusterNode{ID: id, Addr: node.Addr,
+				Epoch: s.cluster.nextEpoch(), Removed: true}
+		}
+		s.cluster.mu.Unlock()
+		if err != nil {
+			return NOMessage, err
+		}
+	case "addslots", "addslotsrange", "delslots":
+		if len(vs) == 0 || (subcmd == "addslotsrange" && len(vs)%2 != 0) {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		var slots []int
+		for i := 0; i < len(vs); i++ {
+			slot, err := parseSlot(vs[i])
+			if err != nil {
+				return NOMessage, err
+			}
+			end := slot
+			if subcmd == "addslotsrange" {
+				i++
+				if end, err = parseSlot(vs[i]); err != nil {
+					return NOMessage, err
+				}
+				if end < slot {
+					return NOMessage, errInvalidArgument(vs[i])
+				}
+			}
+			for ; slot <= end; slot++ {
+				slots = append(slots, slot)
+			}
+		}
+		node := myid
+		s.cluster.mu.Lock()
+		for _, slot := range slots {
+			owner := s.cluster.slots[slot].node
+			if subcmd == "delslots" {
+				if owner != myid {
+					err = fmt.Errorf("slot %d is not served by this node", slot)
+					break
+				}
+			} else if owner != "" && s.cluster.node(owner) != nil {
+				err = fmt.Errorf("slot %d is already served", slot)
+				break
+			}
+		}
+		if subcmd == "delslots" {
+			node = ""
+		}
+		if err == nil {
+			s.cluster.assign(slots, node)
+		}
+		s.cluster.mu.Unlock()
+		if err != nil {
+			return NOMessage, err
+		}
+	case "setslot":
+		if len(vs) != 3 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		slot, err := parseSlot(vs[0])
+		if err != nil {
+			return NOMessage, err
+		}
+		if strings.ToLower(vs[1]) != "node" {
+			return NOMessage, errInvalidArgument(vs[1])
+		}
+		s.cluster.mu.Lock()
+		if s.cluster.node(vs[2]) == nil {
+			err = errors.New("unknown node")
+		} else if s.cluster.migrating[slot] {
+			err = errTryAgain
+		} else {
+			s.cluster.assign([]int{slot}, vs[2])
+		}
+		s.cluster.mu.Unlock()
+		if err != nil {
+			return NOMessage, err
+		}
+	case "migrate":
+		if len(vs) != 2 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		slot, err := parseSlot(vs[0])
+		if err != nil {
+			return NOMessage, err
+		}
+		n, err := s.clusterMigrate(slot, vs[1])
+		if err != nil {
+			return NOMessage, err
+		}
+		s.clusterSave()
+		return intMessage(msg, start, "migrated", n), nil
+	default:
+		return NOMessage, errInvalidArgument(subcmd)
+	}
+	s.clusterSave()
+	return OKMessage(msg, start), nil
+}
+
+// intMessage returns an integer, or a json object with the integer as the
+// named value.
+func intMessage(msg *Message, start time.Time, name string, n int) resp.Value {
+	if msg.OutputType == JSON {
+		return resp.StringValue(`{"ok":true,"` + name + `":` + strconv.Itoa(n) +
+			`,"elapsed":"` + time.Since(start).String() + "\"}")
+	}
+	return resp.IntegerValue(n)
+}
+
+// slotKeys returns up to count keys of the collections in a slot, or all of
+// them when count is -1. The caller must hold the server lock.
+func (s *Server) slotKeys(slot, count int) []string {
+	keys := []string{}
+	s.cols.Ascend(nil, func(v interface{}) bool {
+		if count != -1 && len(keys) >= count {
+			return false
+		}
+		key := v.(*collectionKeyContainer).key
+		if keySlot(key) == slot {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+func (s *Server) clusterInfo(msg *Message, start time.Time) resp.Value {
+	m := map[string]interface{}{"cluster_enabled": 0}
+	if s.clusterEnabled() {
+		s.cluster.mu.RLock()
+		var assigned, nodes, mine int
+		myid := s.config.serverID()
+		for _, slot := range s.cluster.slots {
+			if slot.node != "" && s.cluster.node(slot.node) != nil {
+				assigned++
+				if slot.node == myid {
+					mine++
+				}
+			}
+		}
+		for _, node := range s.cluster.nodes {
+			if !node.Removed {
+				nodes++
+			}
+		}
+		state := "ok"
+		if assigned < clusterSlots {
+			state = "fail"
+		}
+		m["cluster_enabled"] = 1
+		m["cluster_state"] = state
+		m["cluster_slots_assigned"] = assigned
+		m["cluster_slots_served"] = mine
+		m["cluster_known_nodes"] = nodes
+		m["cluster_current_epoch"] = s.cluster.epoch
+		s.cluster.mu.RUnlock()
+	}
+	if msg.OutputType == JSON {
+		data, _ := json.Marshal(m)
+		return resp.StringValue(`{"ok":true,"info":` + string(data) +
+			`,"elapsed":"` + time.Since(start).String() + "\"}")
+	}
+	return resp.ArrayValue(respValuesSimpleMap(m))
+}
+
+// clusterNodes returns the nodes. The RESP output has the same layout as
+// CLUSTER NODES in Redis Cluster.
+func (s *Server) clusterNodes(msg *Message, start time.Time) resp.Value {
+	myid := s.config.serverID()
+	s.cluster.mu.RLock()
+	defer s.cluster.mu.RUnlock()
+	t := s.cluster.topology()
+	if msg.OutputType == JSON {
+		var buf []byte
+		buf = append(buf, `{"ok":true,"nodes":[`...)
+		var i int
+		for _, node := range t.Nodes {
+			if node.Removed {
+				continue
+			}
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			i++
+			slots, _ := json.Marshal(formatSlotRanges(
+				s.cluster.nodeSlots(node.ID)))
+			buf = append(buf, `{"id":`...)
+			buf = appendJSONString(buf, node.ID)
+			buf = append(buf, `,"addr":`...)
+			buf = appendJSONString(buf, node.Addr)
+			buf = append(buf, `,"myself":`...)
+			buf = strconv.AppendBool(buf, node.ID == myid)
+			buf = append(buf, `,"connected":`...)
+			buf = strconv.AppendBool(buf, node.ID == myid ||
+				time.Since(node.seen) < clusterGossipInterval*3)
+			buf = append(buf, `,"slots":`...)
+			buf = append(buf, slots...)
+			buf = append(buf, '}')
+		}
+		buf = append(buf, `],"elapsed":"`...)
+		buf = append(buf, time.Since(start).String()...)
+		buf = append(buf, `"}`...)
+		return resp.StringValue(string(buf))
+	}
+	var lines []string
+	for _, node := range t.Nodes {
+		if node.Removed {
+			continue
+		}
+		flags, link := "master", "disconnected"
+		if node.ID == myid {
+			flags, link = "myself,master", "connected"
+		} else if time.Since(node.seen) < clusterGossipInterval*3 {
+			link = "connected"
+		}
+		line := fmt.Sprintf("%s %s %s - 0 0 %d %s", node.ID, node.Addr, flags,
+			node.Epoch, link)
+		for _, r := range formatSlotRanges(s.cluster.nodeSlots(node.ID)) {
+			line += " " + r
+		}
+		lines = append(lines, line)
+	}
+	return resp.StringValue(strings.Join(lines, "\n") + "\n")
+}
+
+// clusterSlotsInfo returns the slot ranges of the nodes. The RESP output has
+// the same layout as CLUSTER SLOTS in Redis Cluster.
+func (s *Server) clusterSlotsInfo(msg *Message, start time.Time) resp.Value {
+	s.cluster.mu.RLock()
+	defer s.cluster.mu.RUnlock()
+	var vals []resp.Value
+	var buf []byte
+	buf = append(buf, `{"ok":true,"slots":[`...)
+	var i int
+	for _, r := range s.cluster.topology().Slots {
+		node := s.cluster.node(r.Node)
+		if node == nil {
+			continue
+		}
+		if n := len(vals); n > 0 {
+			// join the ranges of the same node from different epochs
+			prev := vals[n-1].Array()
+			if prev[1].Integer() == r.Start-1 &&
+				prev[2].Array()[2].String() == node.ID {
+				vals[n-1] = resp.ArrayValue(append([]resp.Value{prev[0],
+					resp.IntegerValue(r.End)}, prev[2:]...))
+				continue
+			}
+		}
+		host, sport, _ := net.SplitHostPort(node.Addr)
+		port, _ := strconv.Atoi(sport)
+		vals = append(vals, resp.ArrayValue([]resp.Value{
+			resp.IntegerValue(r.Start),
+			resp.IntegerValue(r.End),
+			resp.ArrayValue([]resp.Value{
+				resp.StringValue(host),
+				resp.IntegerValue(port),
+				resp.StringValue(node.ID),
+			}),
+		}))
+	}
+	if msg.OutputType != JSON {
+		return resp.ArrayValue(vals)
+	}
+	for _, v := range vals {
+		arr := v.Array()
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		i++
+		buf = append(buf, `{"start":`...)
+		buf = strconv.AppendInt(buf, int64(arr[0].Integer()), 10)
+		buf = append(buf, `,"end":`...)
+		buf = strconv.AppendInt(buf, int64(arr[1].Integer()), 10)
+		buf = append(buf, `,"id":`...)
+		buf = appendJSONString(buf, arr[2].Array()[2].String())
+		buf = append(buf, `,"addr":`...)
+		buf = appendJSONString(buf, net.JoinHostPort(arr[2].Array()[0].String(),
+			arr[2].Array()[1].String()))
+		buf = append(buf, '}')
+	}
+	buf = append(buf, `],"elapsed":"`...)
+	buf = append(buf, time.Since(start).String()...)
+	buf = append(buf, `"}`...)
+	return resp.StringValue(string(buf))
+}
+
+// clusterMigrate moves the collections and hooks of a slot to another node,
+// and then assigns the slot to the node. The writes to the slot are refused
+// with TRYAGAIN while the slot is migrating. Returns the number of objects
+// that were moved.
+func (s *Server) clusterMigrate(slot int, id string) (int, error) {
+	myid := s.config.serverID()
+	s.mu.Lock()
+	s.cluster.mu.Lock()
+	var err error
+	node := s.cluster.node(id)
+	switch {
+	case s.cluster.slots[slot].node != myid:
+		err = fmt.Errorf("slot %d is not served by this node", slot)
+	case node == nil:
+		err = errors.New("unknown node")
+	case id == myid:
+		err = errors.New("cannot migrate to self")
+	case s.cluster.migrating[slot]:
+		err = errTryAgain
+	}
+	var addr string
+	if err == nil {
+		addr = node.Addr
+		if s.cluster.migrating == nil {
+			s.cluster.migrating = make(map[int]bool)
+		}
+		s.cluster.migrating[slot] = true
+	}
+	s.cluster.mu.Unlock()
+	if err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	keys := s.slotKeys(slot, -1)
+	var cols []snapshotCol
+	for _, key := range keys {
+		cols = append(cols, s.snapshotCol(key, s.getCol(key)))
+	}
+	var hnames []string
+	for name, hook := range s.hooks {
+		if keySlot(hook.Key) == slot {
+			hnames = append(hnames, name)
+		}
+	}
+	sort.Strings(hnames)
+	// a schema or index may be set for a key that has no collection
+	skeys := make(map[string]bool)
+	for key := range s.schemas {
+		skeys[key] = true
+	}
+	for key := range s.limits {
+		skeys[key] = true
+	}
+	for key := range s.indexes {
+		skeys[key] = true
+	}
+	for key := range s.geoIndexes {
+		skeys[key] = true
+	}
+	for key := range s.histories {
+		skeys[key] = true
+	}
+	for key := range s.labels {
+		skeys[key] = true
+	}
+	for key := range skeys {
+		if keySlot(key) == slot && s.getCol(key) == nil {
+			keys = append(keys, key)
+		}
+	}
+	hooks := append(s.hookCommandsFor(hnames), s.fieldExpireCommands(keys)...)
+	hooks = append(hooks, s.schemaCommands(keys)...)
+	hooks = append(hooks, s.limitsCommands(keys)...)
+	hooks = append(hooks, s.historyCommands(keys)...)
+	hooks = append(hooks, s.labelCommands(keys)...)
+	hooks = append(hooks, s.indexCommands(keys)...)
+	s.mu.Unlock()
+	defer func() {
+		s.cluster.mu.Lock()
+		delete(s.cluster.migrating, slot)
+		s.cluster.mu.Unlock()
+	}()
+
+	n, err := s.clusterSend(addr, cols, hooks)
+	if err != nil {
+		return 0, fmt.Errorf("cannot migrate: %v", err)
+	}
+
+	// assign the slot and remove the local copy
+	s.mu.Lock()
+	s.cluster.mu.Lock()
+	s.cluster.assign([]int{slot}, id)
+	s.cluster.mu.Unlock()
+	s.dropLocal(keys, hnames)
+	s.mu.Unlock()
+
+	// let the node know right away, rather than on the next exchange
+	if conn, err := s.clusterDial(addr); err == nil {
+		s.clusterExchange(conn)
+		conn.Close()
+	}
+	return n, nil
+}
+
+// dropLocal drops the collections, schemas, and hooks that were moved to
+// another server. The caller must hold the server lock.
+func (s *Server) dropLocal(keys, hnames []string) {
+	var dels [][]string
+	for _, key := range keys {
+		dels = append(dels, []string{"drop", key})
+		if s.schemas[key] != nil {
+			dels = append(dels, []string{"delschema", key})
+		}
+		if s.limits[key] != nil {
+			dels = append(dels, []string{"dellimits", key})
+		}
+		for _, name := range s.indexes[key] {
+			dels = append(dels, []string{"delindex", key, name})
+		}
+		if s.geoIndexes[key] != nil {
+			dels = append(dels, []string{"setgeoindex", key})
+		}
+		if s.histories[key] != nil {
+			dels = append(dels, []string{"delhistory", key})
+		}
+		if s.labels[key] != nil {
+			dels = append(dels, []string{"dellabel", key})
+		}
+	}
+	for _, name := range hnames {
+		hook := s.hooks[name]
+		switch {
+		case hook == nil:
+		case hook.channel:
+			dels = append(dels, []string{"delchan", name})
+		default:
+			dels = append(dels, []string{"delhook", name})
+		}
+	}
+	for _, args := range dels {
+		_, d, err := s.command(&Message{Args: args}, nil)
+		if err == nil {
+			err = s.writeAOF(args, &d)
+		}
+		if err != nil {
+			log.Errorf("%s: %v", strings.Join(args, " "), err)
+		}
+	}
+}
+
+// clusterSend sends the commands that recreate the collections and hooks to
+// a node.
+func (s *Server) clusterSend(addr string, cols []snapshotCol,
+	hooks [][]string,
+) (int, error) {
+	conn, err := s.clusterDial(addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	v, err := conn.Do("cluster", "importing")
+	if err != nil {
+		return 0, err
+	}
+	if v.Error() != nil {
+		return 0, v.Error()
+	}
+	pipe := &commandPipe{conn: conn}
+	n, err := pipe.sendCols(cols)
+	if err != nil {
+		return 0, err
+	}
+	for _, values := range hooks {
+		if err := pipe.send(values); err != nil {
+			return 0, err
+		}
+	}
+	if err := pipe.flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// commandPipe pipelines commands to another server. The replies are read in
+// batches, and the first error that is replied is returned.
+type commandPipe struct {
+	conn    *RESPConn
+	buf     []byte
+	pending int
+}
+
+// send queues a command, and flushes the queue once it has a full batch.
+func (p *commandPipe) send(values []string) error {
+	p.buf = appendAOFCommand(p.buf, values)
+	p.pending++
+	if p.pending == clusterMigrateBatch {
+		return p.flush()
+	}
+	return nil
+}
+
+// flush sends the queued commands and waits for their replies.
+func (p *commandPipe) flush() error {
+	if p.pending == 0 {
+		return nil
+	}
+	p.conn.conn.SetDeadline(time.Now().Add(time.Second * 30))
+	if _, err := p.conn.conn.Write(p.buf); err != nil {
+		return err
+	}
+	for ; p.pending > 0; p.pending-- {
+		v, _, err := p.conn.rd.ReadValue()
+		if err != nil {
+			return err
+		}
+		if v.Error() != nil {
+			return v.Error()
+		}
+	}
+	p.buf = p.buf[:0]
+	return nil
+}
+
+// sendCols sends the commands that recreate the objects of the collections,
+// and returns the number of objects.
+func (p *commandPipe) sendCols(cols []snapshotCol) (int, error) {
+	var n int
+	var values []string
+	now := time.Now().UnixNano()
+	for _, scol := range cols {
+		fnames := scol.snap.FieldArr()
+		fmap := scol.snap.FieldMap()
+		var err error
+		scol.snap.Scan(false, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
+			values = setCommand(values[:0], scol, id, obj, fields, fmap,
+				fnames, now)
+			err = p.send(values)
+			n++
+			return err == nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import "testing"
+
+func TestClusterKeySlot(t *testing.T) {
+	if crc := crc16("123456789"); crc != 0x31C3 {
+		t.Fatalf("expected 0x31C3, got 0x%04X", crc)
+	}
+	for key, slot := range map[string]int{
+		"foo":             12182,
+		"bar":             5061,
+		"{foo}.fleet":     12182,
+		"fleet.{foo}":     12182,
+		"{bar}{foo}":      5061,
+		"{user1000}.road": keySlot("user1000"),
+	} {
+		if n := keySlot(key); n != slot {
+			t.Fatalf("%q: expected %d, got %d", key, slot, n)
+		}
+	}
+	if keySlot("{}foo") == keySlot("") {
+		t.Fatal("expected an empty tag to hash the whole key")
+	}
+}
+
+func TestClusterMerge(t *testing.T) {
+	var a, b clusterState
+	a.merge(clusterTopology{})
+	b.merge(clusterTopology{})
+	a.setNode("a", "localhost:9851")
+	a.assign([]int{0, 1, 2}, "a")
+	b.setNode("b", "localhost:9852")
+	b.assign([]int{2, 3}, "b")
+
+	// equal epochs, the higher node id wins the slot
+	if !a.merge(b.topology()) {
+		t.Fatal("expected a change")
+	}
+	if !b.merge(a.topology()) {
+		t.Fatal("expected a change")
+	}
+	if a.merge(b.topology()) {
+		t.Fatal("expected no change")
+	}
+	if a.encode() != b.encode() {
+		t.Fatalf("expected\n%s\ngot\n%s", a.encode(), b.encode())
+	}
+	if r := a.nodeSlots("a"); len(r) != 1 || r[0] != [2]int{0, 1} {
+		t.Fatalf("expected [[0 1]], got %v", r)
+	}
+	if r := a.nodeSlots("b"); len(r) != 1 || r[0] != [2]int{2, 3} {
+		t.Fatalf("expected [[2 3]], got %v", r)
+	}
+
+	// a higher epoch wins
+	a.assign([]int{3}, "a")
+	b.merge(a.topology())
+	if b.slots[3].node != "a" {
+		t.Fatalf("expected 'a', got '%s'", b.slots[3].node)
+	}
+	b.nodes["a"] = &clusterNode{ID: "a", Addr: "localhost:9851",
+		Epoch: b.nextEpoch(), Removed: true}
+	a.merge(b.topology())
+	if a.node("a") != nil {
+		t.Fatal("expected the node to be removed")
+	}
+	if s := formatSlotRanges(b.nodeSlots("b")); len(s) != 1 || s[0] != "2" {
+		t.Fatalf("expected [2], got %v", s)
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/core"
+)
+
+// commandRenames are the commands of the rename-commands config property,
+// which are called by the clients with their new names, or not at all when
+// they are disabled.
+type commandRenames struct {
+	to   map[string]string // the new name of a command, empty when disabled
+	from map[string]string // the command of a new name
+}
+
+// parseRenameCommands parses the rename-commands, which are comma separated
+// command=name pairs, such as "flushdb=,shutdown=admin-shutdown", where an
+// empty name disables the command.
+func parseRenameCommands(s string) (*commandRenames, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	r := &commandRenames{
+		to:   make(map[string]string),
+		from: make(map[string]string),
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.IndexByte(part, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("invalid rename '%s'", part)
+		}
+		command := strings.ToLower(strings.TrimSpace(part[:eq]))
+		name := strings.ToLower(strings.TrimSpace(part[eq+1:]))
+		switch {
+		case !aclCommands["all"][command]:
+			return nil, fmt.Errorf("unknown command '%s'", command)
+		case command == "quit":
+			// the connection is closed before the command is renamed
+			return nil, fmt.Errorf("cannot rename '%s'", command)
+		case strings.ContainsAny(name, " \t\"'"):
+			return nil, fmt.Errorf("invalid name '%s'", name)
+		case aclCommands["all"][name]:
+			return nil, fmt.Errorf("'%s' is a command", name)
+		case r.from[name] != "":
+			return nil, fmt.Errorf("duplicate name '%s'", name)
+		}
+		if _, ok := r.to[command]; ok {
+			return nil, fmt.Errorf("duplicate command '%s'", command)
+		}
+		r.to[command] = name
+		if name != "" {
+			r.from[name] = command
+		}
+	}
+	return r, nil
+}
+
+// renameCommand applies the rename-commands to a command of a client, whose
+// arguments then have the command that it was renamed from. It returns false
+// when the command is called by its own name after it was renamed or
+// disabled, which is then unknown.
+func (s *Server) renameCommand(msg *Message) bool {
+	r := s.config.renameCommands()
+	if r == nil {
+		return true
+	}
+	if command, ok := r.from[msg.Command()]; ok {
+		msg.Args[0] = command
+		msg._command = command
+		return true
+	}
+	_, renamed := r.to[msg.Command()]
+	return !renamed
+}
+
+// commandName returns the name of a command that the clients call, which is
+// empty for a disabled command.
+func (r *commandRenames) commandName(command string) string {
+	if r != nil {
+		if name, ok := r.to[command]; ok {
+			return name
+		}
+	}
+	return command
+}
+
+// COMMAND [COUNT|LIST|INFO name ...]
+//
+// Returns the commands that the clients may call, which are the new names of
+// the rename-commands. COMMAND and COMMAND LIST are the names, COMMAND COUNT
+// is the number of commands, and COMMAND INFO is the group and the summary of
+// the commands, or null for an unknown command.
+func (s *Server) cmdCommand(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	r := s.config.renameCommands()
+	var names []string
+	for command := range aclCommands["all"] {
+		if name := r.commandName(command); name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	sub := "list"
+	if len(msg.Args) > 1 {
+		sub = strings.ToLower(msg.Args[1])
+	}
+	switch sub {
+	case "list", "count":
+		if len(msg.Args) > 2 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		if sub == "count" {
+			switch msg.OutputType {
+			case JSON:
+				return resp.StringValue(fmt.Sprintf(`{"ok":true,"count":%d,"elapsed":"%s"}`,
+					len(names), time.Since(start))), nil
+			case RESP:
+				return resp.IntegerValue(len(names)), nil
+			}
+			return NOMessage, nil
+		}
+		switch msg.OutputType {
+		case JSON:
+			buf := []byte(`{"ok":true,"commands":[`)
+			for i, name := range names {
+				if i > 0 {
+					buf = append(buf, ',')
+				}
+				buf = appendJSONString(buf, name)
+			}
+			buf = append(buf, `],"elapsed":"`+time.Since(start).String()+`"}`...)
+			return resp.BytesValue(buf), nil
+		case RESP:
+			vals := make([]resp.Value, len(names))
+			for i, name := range names {
+				vals[i] = resp.StringValue(name)
+			}
+			return resp.ArrayValue(vals), nil
+		}
+		return NOMessage, nil
+	case "info":
+		if len(msg.Args) < 3 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		buf := []byte(`{"ok":true,"commands":[`)
+		var vals []resp.Value
+		for i, name := range msg.Args[2:] {
+			name = strings.ToLower(name)
+			command, ok := name, aclCommands["all"][name]
+			if r != nil {
+				if c, renamed := r.from[name]; renamed {
+					command, ok = c, true
+				} else if _, renamed := r.to[name]; renamed {
+					ok = false
+				}
+			}
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if !ok {
+				buf = append(buf, "null"...)
+				vals = append(vals, resp.NullValue())
+				continue
+			}
+			c := core.Commands[strings.ToUpper(command)]
+			buf = appendJSONString(append(buf, `{"name":`...), name)
+			buf = appendJSONString(append(buf, `,"group":`...), c.Group)
+			buf = appendJSONString(append(buf, `,"summary":`...), c.Summary)
+			buf = append(buf, '}')
+			vals = append(vals, resp.ArrayValue([]resp.Value{
+				resp.StringValue(name),
+				resp.StringValue(c.Group),
+				resp.StringValue(c.Summary),
+			}))
+		}
+		switch msg.OutputType {
+		case JSON:
+			buf = append(buf, `],"elapsed":"`+time.Since(start).String()+`"}`...)
+			return resp.BytesValue(buf), nil
+		case RESP:
+			return resp.ArrayValue(vals), nil
+		}
+		return NOMessage, nil
+	}
+	return NOMessage, clientErrorf(
+		"Syntax error, try COMMAND (LIST | COUNT | INFO name ...)")
+}
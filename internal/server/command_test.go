@@ -0,0 +1,81 @@
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestRenameCommands(t *testing.T) {
+	for _, s := range []string{
+		"nope=x", "flushdb", "flushdb=get", "flushdb=a,shutdown=a",
+		"flushdb=,flushdb=x", "quit=", "flushdb=a b",
+	} {
+		if _, err := parseRenameCommands(s); err == nil {
+			t.Fatalf("%s: expected an error", s)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "config")
+	err := ioutil.WriteFile(path,
+		[]byte(`{"rename-commands":"FLUSHDB=, shutdown=admin-shutdown, keys=list-keys"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.setProperty(RenameCommands, "", false); err == nil {
+		t.Fatal("expected an error")
+	}
+	if v := config.getProperty(RenameCommands); v != "FLUSHDB=, shutdown=admin-shutdown, keys=list-keys" {
+		t.Fatalf("unexpected '%s'", v)
+	}
+
+	s := newSnapshotTestServer()
+	s.config = config
+	for _, tc := range []struct {
+		command string
+		known   bool
+		expect  string
+	}{
+		{"ADMIN-SHUTDOWN", true, "shutdown"},
+		{"shutdown", false, "shutdown"},
+		{"flushdb", false, "flushdb"},
+		{"get", true, "get"},
+	} {
+		msg := &Message{Args: []string{tc.command}}
+		if s.renameCommand(msg) != tc.known || msg.Command() != tc.expect {
+			t.Fatalf("%s: expected %v %s, got %s", tc.command, tc.known,
+				tc.expect, msg.Command())
+		}
+	}
+
+	msg := &Message{Args: []string{"command", "list"}, OutputType: JSON}
+	res, err := s.cmdCommand(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, name := range gjson.Get(res.String(), "commands").Array() {
+		names[name.String()] = true
+	}
+	if names["flushdb"] || names["shutdown"] || !names["admin-shutdown"] ||
+		!names["get"] {
+		t.Fatalf("unexpected commands %s", res.String())
+	}
+	msg = &Message{Args: []string{"command", "info", "list-keys", "flushdb"},
+		OutputType: JSON}
+	res, err = s.cmdCommand(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := gjson.Get(res.String(), "commands")
+	if info.Get("0.name").String() != "list-keys" ||
+		info.Get("0.group").String() != "keys" || info.Get("1").Type != gjson.Null {
+		t.Fatalf("unexpected info %s", info.Raw)
+	}
+}
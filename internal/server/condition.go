@@ -0,0 +1,118 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+// setCondition is an IF of a SET, which must hold on the current object for
+// the write to be applied.
+type setCondition struct {
+	field string      // field name, or empty for the version of the object
+	op    string      // <, <=, >, >=, ==, or !=
+	value field.Value // compared with the current value
+}
+
+// isConditionOp returns true for the operators of a condition.
+func isConditionOp(op string) bool {
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+// parseSetCondition parses the condition that follows an IF.
+//
+// VERSION [op] version
+// FIELD name op value
+func parseSetCondition(vs []string) (nvs []string, cond setCondition, err error) {
+	var tok, op, val string
+	var ok bool
+	if vs, tok, ok = tokenval(vs); !ok || tok == "" {
+		return nil, cond, errInvalidNumberOfArguments
+	}
+	switch strings.ToLower(tok) {
+	case "version":
+		op = "=="
+		if _, peek, ok := tokenval(vs); ok && isConditionOp(peek) {
+			vs, op, _ = tokenval(vs)
+		}
+		if vs, val, ok = tokenval(vs); !ok || val == "" {
+			return nil, cond, errInvalidNumberOfArguments
+		}
+		n, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return nil, cond, errInvalidArgument(val)
+		}
+		cond.value = field.Num(float64(n))
+	case "field":
+		if vs, cond.field, ok = tokenval(vs); !ok || cond.field == "" {
+			return nil, cond, errInvalidNumberOfArguments
+		}
+		if vs, op, ok = tokenval(vs); !ok || op == "" {
+			return nil, cond, errInvalidNumberOfArguments
+		}
+		if vs, val, ok = tokenval(vs); !ok || val == "" {
+			return nil, cond, errInvalidNumberOfArguments
+		}
+		cond.value = field.Parse(val)
+	default:
+		return nil, cond, errInvalidArgument(tok)
+	}
+	if !isConditionOp(op) {
+		return nil, cond, errInvalidArgument(op)
+	}
+	cond.op = op
+	return vs, cond, nil
+}
+
+// conditionArgs returns the number of arguments of the IF condition at the
+// start of vs, including the IF.
+func conditionArgs(vs []string) int {
+	if len(vs) > 1 && lc(vs[1], "version") {
+		if len(vs) > 2 && isConditionOp(vs[2]) {
+			return 4
+		}
+		return 3
+	}
+	return 5
+}
+
+// match returns true when the condition holds on an object of a collection,
+// which may be nil. An object that does not exist has a zero version and
+// zero fields.
+func (cond setCondition) match(col *collection.Collection, id string) bool {
+	var value field.Value
+	if col != nil {
+		if cond.field == "" {
+			value = field.Num(float64(col.Version(id)))
+		} else if _, fields, ok := col.Get(id); ok {
+			if idx, ok := col.FieldMap()[cond.field]; ok && idx < len(fields) {
+				value = fields[idx]
+			}
+		}
+	}
+	less, more := field.Less(value, cond.value), field.Less(cond.value, value)
+	switch cond.op {
+	case "<":
+		return less
+	case "<=":
+		return !more
+	case ">":
+		return more
+	case ">=":
+		return !less
+	case "==":
+		return !less && !more
+	}
+	return less || more
+}
+
+// bumpVersion adds one to the version of an object that was written.
+func bumpVersion(col *collection.Collection, id string) {
+	col.SetVersion(id, col.Version(id)+1)
+}
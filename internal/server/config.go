@@ -3,7 +3,10 @@ package server
 import (
 	"encoding/json"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -11,12 +14,31 @@ import (
 
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/endpoint"
 	"github.com/tidwall/tile38/internal/glob"
+	"github.com/tidwall/tile38/internal/log"
+	"github.com/tidwall/tile38/internal/objstore"
 )
 
 const (
 	defaultKeepAlive     = 300 // seconds
 	defaultProtectedMode = "yes"
+	defaultAppendFsync   = "everysec"
+	defaultReplTLSOnly   = "no"
+	defaultGraphQL       = "no"
+	defaultFollowerHooks = "no"
+
+	defaultReplBacklogSize = 16 * 1024 * 1024
+
+	defaultSlowlogSlowerThan = 10000 // microseconds
+	defaultSlowlogMaxLen     = 128
+
+	defaultExpireSweepInterval  = 100 // milliseconds
+	defaultExpireSweepSize      = 20
+	defaultAOFFlushInterval     = 1000 // milliseconds
+	defaultEndpointReapInterval = 1000 // milliseconds
+
+	defaultShutdownDrainTimeout = 10 // seconds
 )
 
 // Config keys
@@ -25,17 +47,104 @@ const (
 	FollowPort    = "follow_port"
 	FollowID      = "follow_id"
 	FollowPos     = "follow_pos"
+	FollowSum     = "follow_sum"
+	FollowKeys    = "follow_keys"
+	PeerHost      = "peer_host"
+	PeerPort      = "peer_port"
+	PeerID        = "peer_id"
+	PeerPos       = "peer_pos"
+	PeerSum       = "peer_sum"
+	PeerTLS       = "peer_tls"
+	FollowTLS     = "follow_tls"
 	ServerID      = "server_id"
 	ReadOnly      = "read_only"
+	RaftTerm      = "raft_term"
+	RaftVote      = "raft_vote"
+	RaftLogTerm   = "raft_log_term"
+	ClusterTopo   = "cluster_topology"
+	ACLUsers      = "acl_users"
+	Namespaces    = "namespaces"
+	AuthTokens    = "auth_tokens"
 	RequirePass   = "requirepass"
 	LeaderAuth    = "leaderauth"
 	ProtectedMode = "protected-mode"
 	MaxMemory     = "maxmemory"
 	AutoGC        = "autogc"
 	KeepAlive     = "keepalive"
+	MaxEventRate  = "maxeventrate"
+
+	BackupURL          = "backupurl"
+	BackupInterval     = "backupinterval"
+	BackupFullInterval = "backupfullinterval"
+
+	DiskCollections = "diskcollections"
+	AppendFsync     = "appendfsync"
+
+	CDCEndpoint = "cdcendpoint"
+
+	HookErrorsEndpoint = "hookerrorsendpoint"
+
+	KeyspaceEvents = "keyspaceevents"
+
+	SnapKeys = "snapkeys"
+	SnapURL  = "snapurl"
+
+	RaftAddr  = "raftaddr"
+	RaftPeers = "raftpeers"
+
+	ReplPass        = "replpass"
+	ReplTLSOnly     = "repltlsonly"
+	LeaderTLSCACert = "leadertlscacert"
+	LeaderTLSCert   = "leadertlscert"
+	LeaderTLSKey    = "leadertlskey"
+	ReplBacklogSize = "replbacklogsize"
+
+	ClusterAddr = "clusteraddr"
+
+	MaxKeyRate    = "maxkeyrate"
+	MaxClientRate = "maxclientrate"
+	MaxKeyObjects = "maxkeyobjects"
+	MaxObjectSize = "maxobjectsize"
+
+	Timeout                 = "timeout"
+	ClientOutputBufferLimit = "client-output-buffer-limit"
+
+	TraceURL = "traceurl"
+
+	StatsDAddr = "statsdaddr"
+	StatsDTags = "statsdtags"
+
+	LogFormat = "logformat"
+	LogLevels = "loglevels"
+
+	SlowlogSlowerThan = "slowlog-log-slower-than"
+	SlowlogMaxLen     = "slowlog-max-len"
+
+	LatencyMonitorThreshold = "latency-monitor-threshold"
+
+	GraphQL = "graphql"
+
+	FollowerHooks = "followerhooks"
+
+	AuditLog = "auditlog"
+
+	NetRules = "netrules"
+
+	RenameCommands = "rename-commands"
+
+	ExpireSweepInterval  = "expire-sweep-interval"
+	ExpireSweepSize      = "expire-sweep-size"
+	ExpireMaxRate        = "expire-max-rate"
+	AOFFlushInterval     = "aof-flush-interval"
+	EndpointReapInterval = "endpoint-reap-interval"
+
+	ShutdownDrainTimeout = "shutdown-drain-timeout"
+
+	QueryTimeout = "query-timeout"
+	QueryWorkers = "query-workers"
 )
 
-var validProperties = []string{RequirePass, LeaderAuth, ProtectedMode, MaxMemory, AutoGC, KeepAlive}
+var validProperties = []string{RequirePass, LeaderAuth, ProtectedMode, MaxMemory, AutoGC, KeepAlive, MaxEventRate, BackupURL, BackupInterval, BackupFullInterval, DiskCollections, AppendFsync, CDCEndpoint, HookErrorsEndpoint, KeyspaceEvents, SnapKeys, SnapURL, RaftAddr, RaftPeers, ReplPass, ReplTLSOnly, LeaderTLSCACert, LeaderTLSCert, LeaderTLSKey, ReplBacklogSize, ClusterAddr, MaxKeyRate, MaxClientRate, MaxKeyObjects, MaxObjectSize, Timeout, ClientOutputBufferLimit, TraceURL, StatsDAddr, StatsDTags, LogFormat, LogLevels, SlowlogSlowerThan, SlowlogMaxLen, LatencyMonitorThreshold, GraphQL, FollowerHooks, AuditLog, NetRules, RenameCommands, ExpireSweepInterval, ExpireSweepSize, ExpireMaxRate, AOFFlushInterval, EndpointReapInterval, ShutdownDrainTimeout, QueryTimeout, QueryWorkers}
 
 // Config is a tile38 config
 type Config struct {
@@ -43,12 +152,29 @@ type Config struct {
 
 	mu sync.RWMutex
 
-	_followHost string
-	_followPort int64
-	_followID   string
-	_followPos  int64
-	_serverID   string
-	_readOnly   bool
+	_followHost  string
+	_followPort  int64
+	_followID    string
+	_followPos   int64
+	_followSum   string
+	_followKeys  []string
+	_peerHost    string
+	_peerPort    int64
+	_peerID      string
+	_peerPos     int64
+	_peerSum     string
+	_peerTLS     bool
+	_followTLS   bool
+	_serverID    string
+	_readOnly    bool
+	_readOnlyP   bool // the saved mode, which NOSAVE leaves alone
+	_raftTerm    uint64
+	_raftVote    string
+	_raftLogTerm uint64
+	_clusterTopo string
+	_aclUsers    string
+	_namespaces  string
+	_authTokens  string
 
 	_requirePassP   string
 	_requirePass    string
@@ -62,6 +188,126 @@ type Config struct {
 	_autoGC         uint64
 	_keepAliveP     string
 	_keepAlive      int64
+	_maxEventRateP  string
+	_maxEventRate   uint64
+
+	_backupURLP          string
+	_backupURL           string
+	_backupIntervalP     string
+	_backupInterval      uint64
+	_backupFullIntervalP string
+	_backupFullInterval  uint64
+
+	_diskCollectionsP string
+	_diskCollections  string
+	_appendFsyncP     string
+	_appendFsync      string
+
+	_cdcEndpointP string
+	_cdcEndpoint  string
+
+	_hookErrorsEndpointP string
+	_hookErrorsEndpoint  string
+
+	_keyspaceEventsP string
+	_keyspaceEvents  []string
+
+	_snapKeysP string
+	_snapKeys  []string
+	_snapURLP  string
+	_snapURL   string
+
+	_raftAddrP  string
+	_raftAddr   string
+	_raftPeersP string
+	_raftPeers  []string
+
+	_replPassP        string
+	_replPass         string
+	_replTLSOnlyP     string
+	_replTLSOnly      string
+	_leaderTLSCACertP string
+	_leaderTLSCACert  string
+	_leaderTLSCertP   string
+	_leaderTLSCert    string
+	_leaderTLSKeyP    string
+	_leaderTLSKey     string
+	_replBacklogSizeP string
+	_replBacklogSize  int64
+
+	_clusterAddrP string
+	_clusterAddr  string
+
+	_maxKeyRateP    string
+	_maxKeyRate     uint64
+	_maxClientRateP string
+	_maxClientRate  uint64
+	_maxKeyObjectsP string
+	_maxKeyObjects  uint64
+	_maxObjectSizeP string
+	_maxObjectSize  int64
+
+	_timeoutP                 string
+	_timeout                  uint64
+	_clientOutputBufferLimitP string
+	_clientOutputBufferLimit  int64
+
+	_traceURLP string
+	_traceURL  string
+
+	_auditLogP string
+	_auditLog  string
+
+	_netRulesP string
+	_netRulesS string
+	_netRules  []netRule
+
+	_renameCommandsP string
+	_renameCommandsS string
+	_renameCommands  *commandRenames
+
+	_statsdAddrP string
+	_statsdAddr  string
+	_statsdTagsP string
+	_statsdTags  []string
+
+	_logFormatP string
+	_logFormat  string
+	_logLevelsP string
+	_logLevels  string
+
+	_slowlogSlowerThanP string
+	_slowlogSlowerThan  int64
+	_slowlogMaxLenP     string
+	_slowlogMaxLen      int64
+
+	_latencyMonitorThresholdP string
+	_latencyMonitorThreshold  uint64
+
+	_graphqlP string
+	_graphql  string
+
+	_followerHooksP string
+	_followerHooks  string
+
+	_expireSweepIntervalP  string
+	_expireSweepInterval   uint64
+	_expireSweepSizeP      string
+	_expireSweepSize       uint64
+	_expireMaxRateP        string
+	_expireMaxRate         uint64
+	_aofFlushIntervalP     string
+	_aofFlushInterval      uint64
+	_endpointReapIntervalP string
+	_endpointReapInterval  uint64
+
+	_shutdownDrainTimeoutP string
+	_shutdownDrainTimeout  uint64
+
+	_queryTimeoutP string
+	_queryTimeout  uint64
+	_queryWorkersP string
+	_queryWorkers  uint64
 }
 
 func loadConfig(path string) (*Config, error) {
@@ -82,14 +328,104 @@ func loadConfig(path string) (*Config, error) {
 		_followPort:     gjson.Get(json, FollowPort).Int(),
 		_followID:       gjson.Get(json, FollowID).String(),
 		_followPos:      gjson.Get(json, FollowPos).Int(),
+		_followSum:      gjson.Get(json, FollowSum).String(),
+		_peerHost:       gjson.Get(json, PeerHost).String(),
+		_peerPort:       gjson.Get(json, PeerPort).Int(),
+		_peerID:         gjson.Get(json, PeerID).String(),
+		_peerPos:        gjson.Get(json, PeerPos).Int(),
+		_peerSum:        gjson.Get(json, PeerSum).String(),
+		_peerTLS:        gjson.Get(json, PeerTLS).Bool(),
+		_followTLS:      gjson.Get(json, FollowTLS).Bool(),
 		_serverID:       gjson.Get(json, ServerID).String(),
 		_readOnly:       gjson.Get(json, ReadOnly).Bool(),
+		_readOnlyP:      gjson.Get(json, ReadOnly).Bool(),
+		_raftTerm:       gjson.Get(json, RaftTerm).Uint(),
+		_raftVote:       gjson.Get(json, RaftVote).String(),
+		_raftLogTerm:    gjson.Get(json, RaftLogTerm).Uint(),
+		_clusterTopo:    gjson.Get(json, ClusterTopo).Raw,
+		_aclUsers:       gjson.Get(json, ACLUsers).Raw,
+		_namespaces:     gjson.Get(json, Namespaces).Raw,
+		_authTokens:     gjson.Get(json, AuthTokens).Raw,
 		_requirePassP:   gjson.Get(json, RequirePass).String(),
 		_leaderAuthP:    gjson.Get(json, LeaderAuth).String(),
 		_protectedModeP: gjson.Get(json, ProtectedMode).String(),
 		_maxMemoryP:     gjson.Get(json, MaxMemory).String(),
 		_autoGCP:        gjson.Get(json, AutoGC).String(),
 		_keepAliveP:     gjson.Get(json, KeepAlive).String(),
+		_maxEventRateP:  gjson.Get(json, MaxEventRate).String(),
+
+		_backupURLP:          gjson.Get(json, BackupURL).String(),
+		_backupIntervalP:     gjson.Get(json, BackupInterval).String(),
+		_backupFullIntervalP: gjson.Get(json, BackupFullInterval).String(),
+
+		_diskCollectionsP: gjson.Get(json, DiskCollections).String(),
+		_appendFsyncP:     gjson.Get(json, AppendFsync).String(),
+
+		_cdcEndpointP: gjson.Get(json, CDCEndpoint).String(),
+
+		_hookErrorsEndpointP: gjson.Get(json, HookErrorsEndpoint).String(),
+
+		_keyspaceEventsP: gjson.Get(json, KeyspaceEvents).String(),
+
+		_snapKeysP: gjson.Get(json, SnapKeys).String(),
+		_snapURLP:  gjson.Get(json, SnapURL).String(),
+
+		_raftAddrP:  gjson.Get(json, RaftAddr).String(),
+		_raftPeersP: gjson.Get(json, RaftPeers).String(),
+
+		_replPassP:        gjson.Get(json, ReplPass).String(),
+		_replTLSOnlyP:     gjson.Get(json, ReplTLSOnly).String(),
+		_leaderTLSCACertP: gjson.Get(json, LeaderTLSCACert).String(),
+		_leaderTLSCertP:   gjson.Get(json, LeaderTLSCert).String(),
+		_leaderTLSKeyP:    gjson.Get(json, LeaderTLSKey).String(),
+		_replBacklogSizeP: gjson.Get(json, ReplBacklogSize).String(),
+
+		_clusterAddrP: gjson.Get(json, ClusterAddr).String(),
+
+		_maxKeyRateP:    gjson.Get(json, MaxKeyRate).String(),
+		_maxClientRateP: gjson.Get(json, MaxClientRate).String(),
+		_maxKeyObjectsP: gjson.Get(json, MaxKeyObjects).String(),
+		_maxObjectSizeP: gjson.Get(json, MaxObjectSize).String(),
+
+		_timeoutP:                 gjson.Get(json, Timeout).String(),
+		_clientOutputBufferLimitP: gjson.Get(json, ClientOutputBufferLimit).String(),
+
+		_traceURLP: gjson.Get(json, TraceURL).String(),
+
+		_auditLogP: gjson.Get(json, AuditLog).String(),
+
+		_netRulesP: gjson.Get(json, NetRules).String(),
+
+		_renameCommandsP: gjson.Get(json, RenameCommands).String(),
+
+		_statsdAddrP: gjson.Get(json, StatsDAddr).String(),
+		_statsdTagsP: gjson.Get(json, StatsDTags).String(),
+
+		_logFormatP: gjson.Get(json, LogFormat).String(),
+		_logLevelsP: gjson.Get(json, LogLevels).String(),
+
+		_slowlogSlowerThanP: gjson.Get(json, SlowlogSlowerThan).String(),
+		_slowlogMaxLenP:     gjson.Get(json, SlowlogMaxLen).String(),
+
+		_latencyMonitorThresholdP: gjson.Get(json, LatencyMonitorThreshold).String(),
+
+		_graphqlP: gjson.Get(json, GraphQL).String(),
+
+		_followerHooksP: gjson.Get(json, FollowerHooks).String(),
+
+		_expireSweepIntervalP:  gjson.Get(json, ExpireSweepInterval).String(),
+		_expireSweepSizeP:      gjson.Get(json, ExpireSweepSize).String(),
+		_expireMaxRateP:        gjson.Get(json, ExpireMaxRate).String(),
+		_aofFlushIntervalP:     gjson.Get(json, AOFFlushInterval).String(),
+		_endpointReapIntervalP: gjson.Get(json, EndpointReapInterval).String(),
+
+		_shutdownDrainTimeoutP: gjson.Get(json, ShutdownDrainTimeout).String(),
+
+		_queryTimeoutP: gjson.Get(json, QueryTimeout).String(),
+		_queryWorkersP: gjson.Get(json, QueryWorkers).String(),
+	}
+	for _, key := range gjson.Get(json, FollowKeys).Array() {
+		config._followKeys = append(config._followKeys, key.String())
 	}
 	// load properties
 	if err := config.setProperty(RequirePass, config._requirePassP, true); err != nil {
@@ -110,6 +446,147 @@ func loadConfig(path string) (*Config, error) {
 	if err := config.setProperty(KeepAlive, config._keepAliveP, true); err != nil {
 		return nil, err
 	}
+	if err := config.setProperty(MaxEventRate, config._maxEventRateP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(BackupURL, config._backupURLP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(BackupInterval, config._backupIntervalP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(BackupFullInterval, config._backupFullIntervalP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(DiskCollections, config._diskCollectionsP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(AppendFsync, config._appendFsyncP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(CDCEndpoint, config._cdcEndpointP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(HookErrorsEndpoint, config._hookErrorsEndpointP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(KeyspaceEvents, config._keyspaceEventsP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(SnapKeys, config._snapKeysP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(SnapURL, config._snapURLP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(RaftAddr, config._raftAddrP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(RaftPeers, config._raftPeersP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(ReplPass, config._replPassP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(ReplTLSOnly, config._replTLSOnlyP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(LeaderTLSCACert, config._leaderTLSCACertP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(LeaderTLSCert, config._leaderTLSCertP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(LeaderTLSKey, config._leaderTLSKeyP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(ReplBacklogSize, config._replBacklogSizeP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(ClusterAddr, config._clusterAddrP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(MaxKeyRate, config._maxKeyRateP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(MaxClientRate, config._maxClientRateP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(MaxKeyObjects, config._maxKeyObjectsP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(MaxObjectSize, config._maxObjectSizeP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(Timeout, config._timeoutP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(ClientOutputBufferLimit, config._clientOutputBufferLimitP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(TraceURL, config._traceURLP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(AuditLog, config._auditLogP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(NetRules, config._netRulesP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(RenameCommands, config._renameCommandsP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(StatsDAddr, config._statsdAddrP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(StatsDTags, config._statsdTagsP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(LogFormat, config._logFormatP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(LogLevels, config._logLevelsP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(SlowlogSlowerThan, config._slowlogSlowerThanP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(SlowlogMaxLen, config._slowlogMaxLenP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(LatencyMonitorThreshold, config._latencyMonitorThresholdP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(GraphQL, config._graphqlP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(FollowerHooks, config._followerHooksP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(ExpireSweepInterval, config._expireSweepIntervalP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(ExpireSweepSize, config._expireSweepSizeP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(ExpireMaxRate, config._expireMaxRateP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(AOFFlushInterval, config._aofFlushIntervalP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(EndpointReapInterval, config._endpointReapIntervalP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(ShutdownDrainTimeout, config._shutdownDrainTimeoutP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(QueryTimeout, config._queryTimeoutP, true); err != nil {
+		return nil, err
+	}
+	if err := config.setProperty(QueryWorkers, config._queryWorkersP, true); err != nil {
+		return nil, err
+	}
 	config.write(false)
 	return config, nil
 }
@@ -138,6 +615,98 @@ func (config *Config) write(writeProperties bool) {
 		} else {
 			config._keepAliveP = strconv.FormatUint(uint64(config._keepAlive), 10)
 		}
+		if config._maxEventRate == 0 {
+			config._maxEventRateP = ""
+		} else {
+			config._maxEventRateP = strconv.FormatUint(config._maxEventRate, 10)
+		}
+		config._backupURLP = config._backupURL
+		if config._backupInterval == 0 {
+			config._backupIntervalP = ""
+		} else {
+			config._backupIntervalP = strconv.FormatUint(config._backupInterval, 10)
+		}
+		if config._backupFullInterval == 0 {
+			config._backupFullIntervalP = ""
+		} else {
+			config._backupFullIntervalP = strconv.FormatUint(config._backupFullInterval, 10)
+		}
+		config._diskCollectionsP = config._diskCollections
+		if config._appendFsync == defaultAppendFsync {
+			config._appendFsyncP = ""
+		} else {
+			config._appendFsyncP = config._appendFsync
+		}
+		config._cdcEndpointP = config._cdcEndpoint
+		config._hookErrorsEndpointP = config._hookErrorsEndpoint
+		config._keyspaceEventsP = strings.Join(config._keyspaceEvents, ",")
+		config._snapKeysP = strings.Join(config._snapKeys, ",")
+		config._snapURLP = config._snapURL
+		config._raftAddrP = config._raftAddr
+		config._raftPeersP = strings.Join(config._raftPeers, ",")
+		config._replPassP = config._replPass
+		if config._replTLSOnly == defaultReplTLSOnly {
+			config._replTLSOnlyP = ""
+		} else {
+			config._replTLSOnlyP = config._replTLSOnly
+		}
+		config._leaderTLSCACertP = config._leaderTLSCACert
+		config._leaderTLSCertP = config._leaderTLSCert
+		config._leaderTLSKeyP = config._leaderTLSKey
+		if config._replBacklogSize == defaultReplBacklogSize {
+			config._replBacklogSizeP = ""
+		} else {
+			config._replBacklogSizeP = formatBacklogSize(config._replBacklogSize)
+		}
+		config._clusterAddrP = config._clusterAddr
+		config._maxKeyRateP = formatQuota(config._maxKeyRate)
+		config._maxClientRateP = formatQuota(config._maxClientRate)
+		config._maxKeyObjectsP = formatQuota(config._maxKeyObjects)
+		config._maxObjectSizeP = formatMemSize(config._maxObjectSize)
+		config._timeoutP = formatQuota(config._timeout)
+		config._clientOutputBufferLimitP = formatMemSize(config._clientOutputBufferLimit)
+		config._traceURLP = config._traceURL
+		config._auditLogP = config._auditLog
+		config._netRulesP = config._netRulesS
+		config._renameCommandsP = config._renameCommandsS
+		config._statsdAddrP = config._statsdAddr
+		config._statsdTagsP = strings.Join(config._statsdTags, ",")
+		config._logFormatP = config._logFormat
+		config._logLevelsP = config._logLevels
+		if config._slowlogSlowerThan == defaultSlowlogSlowerThan {
+			config._slowlogSlowerThanP = ""
+		} else {
+			config._slowlogSlowerThanP = strconv.FormatInt(config._slowlogSlowerThan, 10)
+		}
+		if config._slowlogMaxLen == defaultSlowlogMaxLen {
+			config._slowlogMaxLenP = ""
+		} else {
+			config._slowlogMaxLenP = strconv.FormatInt(config._slowlogMaxLen, 10)
+		}
+		config._latencyMonitorThresholdP = formatQuota(config._latencyMonitorThreshold)
+		if config._graphql == defaultGraphQL {
+			config._graphqlP = ""
+		} else {
+			config._graphqlP = config._graphql
+		}
+		if config._followerHooks == defaultFollowerHooks {
+			config._followerHooksP = ""
+		} else {
+			config._followerHooksP = config._followerHooks
+		}
+		config._expireSweepIntervalP = formatNonDefault(
+			config._expireSweepInterval, defaultExpireSweepInterval)
+		config._expireSweepSizeP = formatNonDefault(
+			config._expireSweepSize, defaultExpireSweepSize)
+		config._expireMaxRateP = formatQuota(config._expireMaxRate)
+		config._aofFlushIntervalP = formatNonDefault(
+			config._aofFlushInterval, defaultAOFFlushInterval)
+		config._endpointReapIntervalP = formatNonDefault(
+			config._endpointReapInterval, defaultEndpointReapInterval)
+		config._shutdownDrainTimeoutP = formatNonDefault(
+			config._shutdownDrainTimeout, defaultShutdownDrainTimeout)
+		config._queryTimeoutP = formatQuota(config._queryTimeout)
+		config._queryWorkersP = formatQuota(config._queryWorkers)
 	}
 
 	m := make(map[string]interface{})
@@ -153,11 +722,59 @@ func (config *Config) write(writeProperties bool) {
 	if config._followPos != 0 {
 		m[FollowPos] = config._followPos
 	}
+	if config._followSum != "" {
+		m[FollowSum] = config._followSum
+	}
+	if len(config._followKeys) > 0 {
+		m[FollowKeys] = config._followKeys
+	}
+	if config._peerHost != "" {
+		m[PeerHost] = config._peerHost
+	}
+	if config._peerPort != 0 {
+		m[PeerPort] = config._peerPort
+	}
+	if config._peerID != "" {
+		m[PeerID] = config._peerID
+	}
+	if config._peerPos != 0 {
+		m[PeerPos] = config._peerPos
+	}
+	if config._peerSum != "" {
+		m[PeerSum] = config._peerSum
+	}
+	if config._peerTLS {
+		m[PeerTLS] = config._peerTLS
+	}
+	if config._followTLS {
+		m[FollowTLS] = config._followTLS
+	}
 	if config._serverID != "" {
 		m[ServerID] = config._serverID
 	}
-	if config._readOnly {
-		m[ReadOnly] = config._readOnly
+	if config._readOnlyP {
+		m[ReadOnly] = config._readOnlyP
+	}
+	if config._raftTerm != 0 {
+		m[RaftTerm] = config._raftTerm
+	}
+	if config._raftVote != "" {
+		m[RaftVote] = config._raftVote
+	}
+	if config._raftLogTerm != 0 {
+		m[RaftLogTerm] = config._raftLogTerm
+	}
+	if config._clusterTopo != "" {
+		m[ClusterTopo] = json.RawMessage(config._clusterTopo)
+	}
+	if config._aclUsers != "" {
+		m[ACLUsers] = json.RawMessage(config._aclUsers)
+	}
+	if config._namespaces != "" {
+		m[Namespaces] = json.RawMessage(config._namespaces)
+	}
+	if config._authTokens != "" {
+		m[AuthTokens] = json.RawMessage(config._authTokens)
 	}
 	if config._requirePassP != "" {
 		m[RequirePass] = config._requirePassP
@@ -177,6 +794,147 @@ func (config *Config) write(writeProperties bool) {
 	if config._keepAliveP != "" {
 		m[KeepAlive] = config._keepAliveP
 	}
+	if config._maxEventRateP != "" {
+		m[MaxEventRate] = config._maxEventRateP
+	}
+	if config._backupURLP != "" {
+		m[BackupURL] = config._backupURLP
+	}
+	if config._backupIntervalP != "" {
+		m[BackupInterval] = config._backupIntervalP
+	}
+	if config._backupFullIntervalP != "" {
+		m[BackupFullInterval] = config._backupFullIntervalP
+	}
+	if config._diskCollectionsP != "" {
+		m[DiskCollections] = config._diskCollectionsP
+	}
+	if config._appendFsyncP != "" {
+		m[AppendFsync] = config._appendFsyncP
+	}
+	if config._cdcEndpointP != "" {
+		m[CDCEndpoint] = config._cdcEndpointP
+	}
+	if config._hookErrorsEndpointP != "" {
+		m[HookErrorsEndpoint] = config._hookErrorsEndpointP
+	}
+	if config._keyspaceEventsP != "" {
+		m[KeyspaceEvents] = config._keyspaceEventsP
+	}
+	if config._snapKeysP != "" {
+		m[SnapKeys] = config._snapKeysP
+	}
+	if config._snapURLP != "" {
+		m[SnapURL] = config._snapURLP
+	}
+	if config._raftAddrP != "" {
+		m[RaftAddr] = config._raftAddrP
+	}
+	if config._raftPeersP != "" {
+		m[RaftPeers] = config._raftPeersP
+	}
+	if config._replPassP != "" {
+		m[ReplPass] = config._replPassP
+	}
+	if config._replTLSOnlyP != "" {
+		m[ReplTLSOnly] = config._replTLSOnlyP
+	}
+	if config._leaderTLSCACertP != "" {
+		m[LeaderTLSCACert] = config._leaderTLSCACertP
+	}
+	if config._leaderTLSCertP != "" {
+		m[LeaderTLSCert] = config._leaderTLSCertP
+	}
+	if config._leaderTLSKeyP != "" {
+		m[LeaderTLSKey] = config._leaderTLSKeyP
+	}
+	if config._replBacklogSizeP != "" {
+		m[ReplBacklogSize] = config._replBacklogSizeP
+	}
+	if config._clusterAddrP != "" {
+		m[ClusterAddr] = config._clusterAddrP
+	}
+	if config._maxKeyRateP != "" {
+		m[MaxKeyRate] = config._maxKeyRateP
+	}
+	if config._maxClientRateP != "" {
+		m[MaxClientRate] = config._maxClientRateP
+	}
+	if config._maxKeyObjectsP != "" {
+		m[MaxKeyObjects] = config._maxKeyObjectsP
+	}
+	if config._maxObjectSizeP != "" {
+		m[MaxObjectSize] = config._maxObjectSizeP
+	}
+	if config._timeoutP != "" {
+		m[Timeout] = config._timeoutP
+	}
+	if config._clientOutputBufferLimitP != "" {
+		m[ClientOutputBufferLimit] = config._clientOutputBufferLimitP
+	}
+	if config._traceURLP != "" {
+		m[TraceURL] = config._traceURLP
+	}
+	if config._auditLogP != "" {
+		m[AuditLog] = config._auditLogP
+	}
+	if config._netRulesP != "" {
+		m[NetRules] = config._netRulesP
+	}
+	if config._renameCommandsP != "" {
+		m[RenameCommands] = config._renameCommandsP
+	}
+	if config._statsdAddrP != "" {
+		m[StatsDAddr] = config._statsdAddrP
+	}
+	if config._statsdTagsP != "" {
+		m[StatsDTags] = config._statsdTagsP
+	}
+	if config._logFormatP != "" {
+		m[LogFormat] = config._logFormatP
+	}
+	if config._logLevelsP != "" {
+		m[LogLevels] = config._logLevelsP
+	}
+	if config._slowlogSlowerThanP != "" {
+		m[SlowlogSlowerThan] = config._slowlogSlowerThanP
+	}
+	if config._slowlogMaxLenP != "" {
+		m[SlowlogMaxLen] = config._slowlogMaxLenP
+	}
+	if config._latencyMonitorThresholdP != "" {
+		m[LatencyMonitorThreshold] = config._latencyMonitorThresholdP
+	}
+	if config._graphqlP != "" {
+		m[GraphQL] = config._graphqlP
+	}
+	if config._followerHooksP != "" {
+		m[FollowerHooks] = config._followerHooksP
+	}
+	if config._expireSweepIntervalP != "" {
+		m[ExpireSweepInterval] = config._expireSweepIntervalP
+	}
+	if config._expireSweepSizeP != "" {
+		m[ExpireSweepSize] = config._expireSweepSizeP
+	}
+	if config._expireMaxRateP != "" {
+		m[ExpireMaxRate] = config._expireMaxRateP
+	}
+	if config._aofFlushIntervalP != "" {
+		m[AOFFlushInterval] = config._aofFlushIntervalP
+	}
+	if config._endpointReapIntervalP != "" {
+		m[EndpointReapInterval] = config._endpointReapIntervalP
+	}
+	if config._shutdownDrainTimeoutP != "" {
+		m[ShutdownDrainTimeout] = config._shutdownDrainTimeoutP
+	}
+	if config._queryTimeoutP != "" {
+		m[QueryTimeout] = config._queryTimeoutP
+	}
+	if config._queryWorkersP != "" {
+		m[QueryWorkers] = config._queryWorkersP
+	}
 	data, err := json.MarshalIndent(m, "", "\t")
 	if err != nil {
 		panic(err)
@@ -234,8 +992,33 @@ func formatMemSize(sz int64) string {
 	return strconv.FormatInt(sz, 10) + "gb"
 }
 
-func (config *Config) setProperty(name, value string, fromLoad bool) error {
-	config.mu.Lock()
+// formatQuota formats a quota, which is unlimited when zero.
+func formatQuota(n uint64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// formatNonDefault formats a number, which is empty when it's the default.
+func formatNonDefault(n, def uint64) string {
+	if n == def {
+		return ""
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// formatBacklogSize formats the size of the replication backlog, which is
+// disabled when zero.
+func formatBacklogSize(sz int64) string {
+	if sz <= 0 {
+		return "0"
+	}
+	return formatMemSize(sz)
+}
+
+func (config *Config) setProperty(name, value string, fromLoad bool) error {
+	config.mu.Lock()
 	defer config.mu.Unlock()
 	var invalid bool
 	switch name {
@@ -285,6 +1068,431 @@ func (config *Config) setProperty(name, value string, fromLoad bool) error {
 				config._keepAlive = int64(keepalive)
 			}
 		}
+	case MaxEventRate:
+		if value == "" {
+			config._maxEventRate = 0
+		} else {
+			rate, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				invalid = true
+			} else {
+				config._maxEventRate = rate
+			}
+		}
+	case BackupURL:
+		if value != "" {
+			if _, err := objstore.Open(value); err != nil {
+				return clientErrorf("Invalid argument '%s' for CONFIG SET '%s'", value, name)
+			}
+		}
+		config._backupURL = value
+	case BackupInterval:
+		if value == "" {
+			config._backupInterval = 0
+		} else {
+			interval, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				invalid = true
+			} else {
+				config._backupInterval = interval
+			}
+		}
+	case BackupFullInterval:
+		if value == "" {
+			config._backupFullInterval = 0
+		} else {
+			interval, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				invalid = true
+			} else {
+				config._backupFullInterval = interval
+			}
+		}
+	case DiskCollections:
+		config._diskCollections = value
+	case AppendFsync:
+		switch strings.ToLower(value) {
+		case "":
+			if fromLoad {
+				config._appendFsync = defaultAppendFsync
+			} else {
+				invalid = true
+			}
+		case "always", "everysec", "no":
+			config._appendFsync = strings.ToLower(value)
+		default:
+			invalid = true
+		}
+	case CDCEndpoint:
+		if value != "" {
+			if err := new(endpoint.Manager).Validate(value); err != nil {
+				invalid = true
+				break
+			}
+		}
+		config._cdcEndpoint = value
+	case HookErrorsEndpoint:
+		if value != "" {
+			if err := new(endpoint.Manager).Validate(value); err != nil {
+				invalid = true
+				break
+			}
+		}
+		config._hookErrorsEndpoint = value
+	case KeyspaceEvents:
+		var patterns []string
+		for _, pattern := range strings.Split(value, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+		config._keyspaceEvents = patterns
+	case SnapKeys:
+		var patterns []string
+		for _, pattern := range strings.Split(value, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+		config._snapKeys = patterns
+	case SnapURL:
+		if value != "" {
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+				u.Host == "" {
+				invalid = true
+				break
+			}
+		}
+		config._snapURL = value
+	case RaftAddr:
+		if value != "" && !validHostPort(value) {
+			invalid = true
+			break
+		}
+		config._raftAddr = value
+	case ReplBacklogSize:
+		if value == "" {
+			config._replBacklogSize = defaultReplBacklogSize
+			break
+		}
+		sz, ok := parseMemSize(value)
+		if !ok {
+			invalid = true
+			break
+		}
+		config._replBacklogSize = sz
+	case ClusterAddr:
+		if value != "" && !validHostPort(value) {
+			invalid = true
+			break
+		}
+		config._clusterAddr = value
+	case RaftPeers:
+		var peers []string
+		for _, peer := range strings.Split(value, ",") {
+			peer = strings.TrimSpace(peer)
+			if peer == "" {
+				continue
+			}
+			if !validHostPort(peer) {
+				invalid = true
+				break
+			}
+			peers = append(peers, peer)
+		}
+		if !invalid {
+			config._raftPeers = peers
+		}
+	case ReplPass:
+		config._replPass = value
+	case ReplTLSOnly:
+		switch strings.ToLower(value) {
+		case "":
+			if fromLoad {
+				config._replTLSOnly = defaultReplTLSOnly
+			} else {
+				invalid = true
+			}
+		case "yes", "no":
+			config._replTLSOnly = strings.ToLower(value)
+		default:
+			invalid = true
+		}
+	case LeaderTLSCACert:
+		config._leaderTLSCACert = value
+	case LeaderTLSCert:
+		config._leaderTLSCert = value
+	case LeaderTLSKey:
+		config._leaderTLSKey = value
+	case MaxKeyRate, MaxClientRate, MaxKeyObjects:
+		var n uint64
+		if value != "" {
+			var err error
+			if n, err = strconv.ParseUint(value, 10, 64); err != nil {
+				invalid = true
+				break
+			}
+		}
+		switch name {
+		case MaxKeyRate:
+			config._maxKeyRate = n
+		case MaxClientRate:
+			config._maxClientRate = n
+		case MaxKeyObjects:
+			config._maxKeyObjects = n
+		}
+	case MaxObjectSize:
+		sz, ok := parseMemSize(value)
+		if !ok {
+			invalid = true
+			break
+		}
+		config._maxObjectSize = sz
+	case Timeout:
+		// seconds that a client may be idle, or zero for no timeout
+		if value == "" {
+			config._timeout = 0
+		} else {
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				invalid = true
+			} else {
+				config._timeout = n
+			}
+		}
+	case ClientOutputBufferLimit:
+		sz, ok := parseMemSize(value)
+		if !ok {
+			invalid = true
+			break
+		}
+		config._clientOutputBufferLimit = sz
+	case TraceURL:
+		if value != "" {
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+				u.Host == "" {
+				invalid = true
+				break
+			}
+		}
+		config._traceURL = value
+	case AuditLog:
+		// a file, or an endpoint url
+		if value != "" && !auditIsFile(value) {
+			if err := new(endpoint.Manager).Validate(value); err != nil {
+				invalid = true
+				break
+			}
+		}
+		config._auditLog = value
+	case NetRules:
+		rules, err := parseNetRules(value)
+		if err != nil {
+			invalid = true
+			break
+		}
+		config._netRules, config._netRulesS = rules, strings.TrimSpace(value)
+	case RenameCommands:
+		// the commands are renamed by the config file only, so that a
+		// client cannot call a command that was renamed or disabled
+		if !fromLoad {
+			return clientErrorf("CONFIG SET '%s' is not allowed, it's set in the config file", name)
+		}
+		renames, err := parseRenameCommands(value)
+		if err != nil {
+			return clientErrorf("Invalid argument '%s' for CONFIG SET '%s': %v", value, name, err)
+		}
+		config._renameCommands = renames
+		config._renameCommandsS = strings.TrimSpace(value)
+	case StatsDAddr:
+		if value != "" && !validHostPort(value) {
+			invalid = true
+			break
+		}
+		config._statsdAddr = value
+	case StatsDTags:
+		var tags []string
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "" {
+				continue
+			}
+			if strings.ContainsAny(tag, "|# ") {
+				invalid = true
+				break
+			}
+			tags = append(tags, tag)
+		}
+		if !invalid {
+			config._statsdTags = tags
+		}
+	case LogFormat:
+		// the logger is global, and the format of the command line is kept
+		// when the config has none
+		switch strings.ToLower(value) {
+		case "":
+			if !fromLoad {
+				invalid = true
+			}
+		case "text", "json":
+			config._logFormat = strings.ToLower(value)
+			log.SetJSON(config._logFormat == "json")
+		default:
+			invalid = true
+		}
+	case LogLevels:
+		levels, err := log.ParseLevels(value)
+		if err != nil {
+			invalid = true
+			break
+		}
+		config._logLevels = log.FormatLevels(levels)
+		log.SetLevels(levels)
+	case SlowlogSlowerThan:
+		// microseconds, where a negative value turns off the slowlog and
+		// zero logs every command
+		if value == "" {
+			config._slowlogSlowerThan = defaultSlowlogSlowerThan
+		} else {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				invalid = true
+			} else {
+				config._slowlogSlowerThan = n
+			}
+		}
+	case SlowlogMaxLen:
+		if value == "" {
+			config._slowlogMaxLen = defaultSlowlogMaxLen
+		} else {
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				invalid = true
+			} else {
+				config._slowlogMaxLen = int64(n)
+			}
+		}
+	case LatencyMonitorThreshold:
+		// milliseconds, where zero turns off the latency monitor
+		if value == "" {
+			config._latencyMonitorThreshold = 0
+		} else {
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				invalid = true
+			} else {
+				config._latencyMonitorThreshold = n
+			}
+		}
+	case GraphQL:
+		switch strings.ToLower(value) {
+		case "":
+			if fromLoad {
+				config._graphql = defaultGraphQL
+			} else {
+				invalid = true
+			}
+		case "yes", "no":
+			config._graphql = strings.ToLower(value)
+		default:
+			invalid = true
+		}
+	case FollowerHooks:
+		switch strings.ToLower(value) {
+		case "":
+			if fromLoad {
+				config._followerHooks = defaultFollowerHooks
+			} else {
+				invalid = true
+			}
+		case "yes", "no":
+			config._followerHooks = strings.ToLower(value)
+		default:
+			invalid = true
+		}
+	case ExpireSweepInterval, ExpireSweepSize, AOFFlushInterval,
+		EndpointReapInterval:
+		// the background routines, which are the defaults when empty
+		var n uint64
+		switch name {
+		case ExpireSweepInterval:
+			n = defaultExpireSweepInterval
+		case ExpireSweepSize:
+			n = defaultExpireSweepSize
+		case AOFFlushInterval:
+			n = defaultAOFFlushInterval
+		case EndpointReapInterval:
+			n = defaultEndpointReapInterval
+		}
+		if value != "" {
+			var err error
+			if n, err = strconv.ParseUint(value, 10, 32); err != nil || n == 0 {
+				invalid = true
+				break
+			}
+		}
+		switch name {
+		case ExpireSweepInterval:
+			config._expireSweepInterval = n
+		case ExpireSweepSize:
+			config._expireSweepSize = n
+		case AOFFlushInterval:
+			config._aofFlushInterval = n
+		case EndpointReapInterval:
+			config._endpointReapInterval = n
+		}
+	case ShutdownDrainTimeout:
+		// seconds, where zero does not wait for the hooks to be delivered
+		if value == "" {
+			config._shutdownDrainTimeout = defaultShutdownDrainTimeout
+		} else {
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				invalid = true
+			} else {
+				config._shutdownDrainTimeout = n
+			}
+		}
+	case ExpireMaxRate:
+		// the expired objects deleted per second, where zero is no limit
+		if value == "" {
+			config._expireMaxRate = 0
+		} else {
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				invalid = true
+			} else {
+				config._expireMaxRate = n
+			}
+		}
+	case QueryTimeout:
+		// milliseconds, where zero is no limit
+		if value == "" {
+			config._queryTimeout = 0
+		} else {
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				invalid = true
+			} else {
+				config._queryTimeout = n
+			}
+		}
+	case QueryWorkers:
+		// goroutines for each search, where zero is one for each CPU
+		if value == "" {
+			config._queryWorkers = 0
+		} else {
+			n, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				invalid = true
+			} else {
+				config._queryWorkers = n
+			}
+		}
 	}
 
 	if invalid {
@@ -322,9 +1530,116 @@ func (config *Config) getProperty(name string) string {
 		return formatMemSize(config._maxMemory)
 	case KeepAlive:
 		return strconv.FormatUint(uint64(config._keepAlive), 10)
+	case MaxEventRate:
+		return strconv.FormatUint(config._maxEventRate, 10)
+	case BackupURL:
+		return config._backupURL
+	case BackupInterval:
+		return strconv.FormatUint(config._backupInterval, 10)
+	case BackupFullInterval:
+		return strconv.FormatUint(config._backupFullInterval, 10)
+	case DiskCollections:
+		return config._diskCollections
+	case AppendFsync:
+		return config._appendFsync
+	case CDCEndpoint:
+		return config._cdcEndpoint
+	case HookErrorsEndpoint:
+		return config._hookErrorsEndpoint
+	case KeyspaceEvents:
+		return strings.Join(config._keyspaceEvents, ",")
+	case SnapKeys:
+		return strings.Join(config._snapKeys, ",")
+	case SnapURL:
+		return config._snapURL
+	case RaftAddr:
+		return config._raftAddr
+	case RaftPeers:
+		return strings.Join(config._raftPeers, ",")
+	case ReplPass:
+		return config._replPass
+	case ReplTLSOnly:
+		return config._replTLSOnly
+	case LeaderTLSCACert:
+		return config._leaderTLSCACert
+	case LeaderTLSCert:
+		return config._leaderTLSCert
+	case LeaderTLSKey:
+		return config._leaderTLSKey
+	case ReplBacklogSize:
+		return formatBacklogSize(config._replBacklogSize)
+	case ClusterAddr:
+		return config._clusterAddr
+	case MaxKeyRate:
+		return strconv.FormatUint(config._maxKeyRate, 10)
+	case MaxClientRate:
+		return strconv.FormatUint(config._maxClientRate, 10)
+	case MaxKeyObjects:
+		return strconv.FormatUint(config._maxKeyObjects, 10)
+	case MaxObjectSize:
+		return formatMemSize(config._maxObjectSize)
+	case Timeout:
+		return strconv.FormatUint(config._timeout, 10)
+	case ClientOutputBufferLimit:
+		return formatMemSize(config._clientOutputBufferLimit)
+	case TraceURL:
+		return config._traceURL
+	case AuditLog:
+		return config._auditLog
+	case NetRules:
+		return config._netRulesS
+	case RenameCommands:
+		return config._renameCommandsS
+	case StatsDAddr:
+		return config._statsdAddr
+	case StatsDTags:
+		return strings.Join(config._statsdTags, ",")
+	case LogFormat:
+		if log.JSON() {
+			return "json"
+		}
+		return "text"
+	case LogLevels:
+		return config._logLevels
+	case SlowlogSlowerThan:
+		return strconv.FormatInt(config._slowlogSlowerThan, 10)
+	case SlowlogMaxLen:
+		return strconv.FormatInt(config._slowlogMaxLen, 10)
+	case LatencyMonitorThreshold:
+		return strconv.FormatUint(config._latencyMonitorThreshold, 10)
+	case GraphQL:
+		return config._graphql
+	case FollowerHooks:
+		return config._followerHooks
+	case ExpireSweepInterval:
+		return strconv.FormatUint(config._expireSweepInterval, 10)
+	case ExpireSweepSize:
+		return strconv.FormatUint(config._expireSweepSize, 10)
+	case ExpireMaxRate:
+		return strconv.FormatUint(config._expireMaxRate, 10)
+	case AOFFlushInterval:
+		return strconv.FormatUint(config._aofFlushInterval, 10)
+	case EndpointReapInterval:
+		return strconv.FormatUint(config._endpointReapInterval, 10)
+	case ShutdownDrainTimeout:
+		return strconv.FormatUint(config._shutdownDrainTimeout, 10)
+	case QueryTimeout:
+		return strconv.FormatUint(config._queryTimeout, 10)
+	case QueryWorkers:
+		return strconv.FormatUint(config._queryWorkers, 10)
 	}
 }
 
+// validHostPort returns true when s is a host:port pair with a numeric port.
+func validHostPort(s string) bool {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil || host == "" {
+		return false
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	return err == nil && n != 0
+}
+
 func (s *Server) cmdConfigGet(msg *Message) (res resp.Value, err error) {
 	start := time.Now()
 	vs := msg.Args[1:]
@@ -384,6 +1699,83 @@ func (s *Server) cmdConfigRewrite(msg *Message) (res resp.Value, err error) {
 	s.config.write(true)
 	return OKMessage(msg, start), nil
 }
+func (s *Server) cmdConfigReload(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+
+	if len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if err := s.reloadConfig(); err != nil {
+		return NOMessage, err
+	}
+	return OKMessage(msg, start), nil
+}
+
+// reloadConfig reads the config file again, and loads the certificates of
+// the TLS port and of the endpoints again. It's CONFIG RELOAD, and SIGHUP
+// with --nohup.
+func (s *Server) reloadConfig() error {
+	if err := s.config.reload(); err != nil {
+		return err
+	}
+	if s.tlsCerts != nil {
+		if err := s.tlsCerts.reload(); err != nil {
+			return err
+		}
+	}
+	if err := s.epc.ReloadCerts(); err != nil {
+		return err
+	}
+	log.Infof("Reloaded the config")
+	return nil
+}
+
+// reload sets the properties to the values of the config file, such as
+// after the file has been edited, and to their defaults when they are not in
+// the file. Nothing is changed when a value is invalid. The properties are
+// written back to the file, like with CONFIG REWRITE, so that the file has
+// the changes of CONFIG SET that were not rewritten yet.
+func (config *Config) reload() error {
+	data, err := ioutil.ReadFile(config.path)
+	if err != nil {
+		return err
+	}
+	json := string(data)
+	if !gjson.Valid(json) {
+		return clientErrorf("Invalid config file")
+	}
+	values := make(map[string]string, len(validProperties))
+	check := &Config{}
+	for _, name := range validProperties {
+		values[name] = gjson.Get(json, name).String()
+		switch name {
+		case LogFormat, LogLevels:
+			// the logger is changed by setProperty
+			continue
+		}
+		if err := check.setProperty(name, values[name], true); err != nil {
+			return err
+		}
+	}
+	switch strings.ToLower(values[LogFormat]) {
+	case "", "text", "json":
+	default:
+		return clientErrorf("Invalid argument '%s' for CONFIG SET '%s'",
+			values[LogFormat], LogFormat)
+	}
+	if _, err := log.ParseLevels(values[LogLevels]); err != nil {
+		return clientErrorf("Invalid argument '%s' for CONFIG SET '%s'",
+			values[LogLevels], LogLevels)
+	}
+	for _, name := range validProperties {
+		if err := config.setProperty(name, values[name], true); err != nil {
+			return err
+		}
+	}
+	config.write(true)
+	return nil
+}
 
 func (config *Config) followHost() string {
 	config.mu.RLock()
@@ -397,6 +1789,67 @@ func (config *Config) followPort() int {
 	config.mu.RUnlock()
 	return int(v)
 }
+func (config *Config) followKeys() []string {
+	config.mu.RLock()
+	v := config._followKeys
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) followResume() (id string, pos int64, sum string) {
+	config.mu.RLock()
+	id, pos, sum = config._followID, config._followPos, config._followSum
+	config.mu.RUnlock()
+	return id, pos, sum
+}
+func (config *Config) peerHost() string {
+	config.mu.RLock()
+	v := config._peerHost
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) peerPort() int {
+	config.mu.RLock()
+	v := config._peerPort
+	config.mu.RUnlock()
+	return int(v)
+}
+func (config *Config) peerTLS() bool {
+	config.mu.RLock()
+	v := config._peerTLS
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) followTLS() bool {
+	config.mu.RLock()
+	v := config._followTLS
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) replPass() string {
+	config.mu.RLock()
+	v := config._replPass
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) replTLSOnly() bool {
+	config.mu.RLock()
+	v := config._replTLSOnly == "yes"
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) leaderTLS() (caCert, cert, key string) {
+	config.mu.RLock()
+	caCert = config._leaderTLSCACert
+	cert, key = config._leaderTLSCert, config._leaderTLSKey
+	config.mu.RUnlock()
+	return caCert, cert, key
+}
+func (config *Config) peerResume() (id string, pos int64, sum string) {
+	config.mu.RLock()
+	id, pos, sum = config._peerID, config._peerPos, config._peerSum
+	config.mu.RUnlock()
+	return id, pos, sum
+}
 func (config *Config) serverID() string {
 	config.mu.RLock()
 	v := config._serverID
@@ -445,6 +1898,269 @@ func (config *Config) keepAlive() int64 {
 	config.mu.RUnlock()
 	return v
 }
+func (config *Config) maxEventRate() uint64 {
+	config.mu.RLock()
+	v := config._maxEventRate
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) backupURL() string {
+	config.mu.RLock()
+	v := config._backupURL
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) backupInterval() uint64 {
+	config.mu.RLock()
+	v := config._backupInterval
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) backupFullInterval() uint64 {
+	config.mu.RLock()
+	v := config._backupFullInterval
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) diskCollections() string {
+	config.mu.RLock()
+	v := config._diskCollections
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) appendFsync() string {
+	config.mu.RLock()
+	v := config._appendFsync
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) cdcEndpoint() string {
+	config.mu.RLock()
+	v := config._cdcEndpoint
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) hookErrorsEndpoint() string {
+	config.mu.RLock()
+	v := config._hookErrorsEndpoint
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) keyspaceEvents() []string {
+	config.mu.RLock()
+	v := config._keyspaceEvents
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) snapKeys() []string {
+	config.mu.RLock()
+	v := config._snapKeys
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) snapURL() string {
+	config.mu.RLock()
+	v := config._snapURL
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) replBacklogSize() int {
+	config.mu.RLock()
+	v := config._replBacklogSize
+	config.mu.RUnlock()
+	return int(v)
+}
+func (config *Config) clusterAddr() string {
+	config.mu.RLock()
+	v := config._clusterAddr
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) clusterTopology() string {
+	config.mu.RLock()
+	v := config._clusterTopo
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) aclUsers() string {
+	config.mu.RLock()
+	v := config._aclUsers
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) namespaces() string {
+	config.mu.RLock()
+	v := config._namespaces
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) authTokens() string {
+	config.mu.RLock()
+	v := config._authTokens
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) raftAddr() string {
+	config.mu.RLock()
+	v := config._raftAddr
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) raftPeers() []string {
+	config.mu.RLock()
+	v := config._raftPeers
+	config.mu.RUnlock()
+	return v
+}
+
+// quotas returns the quotas of the writes from clients, which are unlimited
+// when zero.
+func (config *Config) quotas() (keyRate, clientRate, keyObjects uint64,
+	objectSize int64,
+) {
+	config.mu.RLock()
+	keyRate, clientRate = config._maxKeyRate, config._maxClientRate
+	keyObjects, objectSize = config._maxKeyObjects, config._maxObjectSize
+	config.mu.RUnlock()
+	return keyRate, clientRate, keyObjects, objectSize
+}
+
+// expireSweep returns how often the expired objects are purged, and how
+// many objects are sampled at a time.
+func (config *Config) expireSweep() (interval time.Duration, size int) {
+	config.mu.RLock()
+	interval = time.Duration(config._expireSweepInterval) * time.Millisecond
+	size = int(config._expireSweepSize)
+	config.mu.RUnlock()
+	return interval, size
+}
+func (config *Config) expireMaxRate() int {
+	config.mu.RLock()
+	v := int(config._expireMaxRate)
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) aofFlushInterval() time.Duration {
+	config.mu.RLock()
+	v := time.Duration(config._aofFlushInterval) * time.Millisecond
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) endpointReapInterval() time.Duration {
+	config.mu.RLock()
+	v := time.Duration(config._endpointReapInterval) * time.Millisecond
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) shutdownDrainTimeout() time.Duration {
+	config.mu.RLock()
+	v := time.Duration(config._shutdownDrainTimeout) * time.Second
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) queryTimeout() time.Duration {
+	config.mu.RLock()
+	v := time.Duration(config._queryTimeout) * time.Millisecond
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) queryWorkers() int {
+	config.mu.RLock()
+	v := int(config._queryWorkers)
+	config.mu.RUnlock()
+	if v == 0 {
+		v = runtime.GOMAXPROCS(0)
+	}
+	return v
+}
+
+// clientLimits returns the seconds that a client may be idle, and the size
+// of the largest output of a client, which are unlimited when zero.
+func (config *Config) clientLimits() (timeout time.Duration,
+	outputLimit int64,
+) {
+	config.mu.RLock()
+	timeout = time.Duration(config._timeout) * time.Second
+	outputLimit = config._clientOutputBufferLimit
+	config.mu.RUnlock()
+	return timeout, outputLimit
+}
+func (config *Config) traceURL() string {
+	config.mu.RLock()
+	v := config._traceURL
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) auditLog() string {
+	config.mu.RLock()
+	v := config._auditLog
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) netRules() []netRule {
+	config.mu.RLock()
+	v := config._netRules
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) renameCommands() *commandRenames {
+	config.mu.RLock()
+	v := config._renameCommands
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) statsd() (addr string, tags []string) {
+	config.mu.RLock()
+	addr, tags = config._statsdAddr, config._statsdTags
+	config.mu.RUnlock()
+	return addr, tags
+}
+func (config *Config) slowlog() (slowerThan, maxLen int64) {
+	config.mu.RLock()
+	slowerThan, maxLen = config._slowlogSlowerThan, config._slowlogMaxLen
+	config.mu.RUnlock()
+	return slowerThan, maxLen
+}
+func (config *Config) latencyThreshold() time.Duration {
+	config.mu.RLock()
+	v := time.Duration(config._latencyMonitorThreshold) * time.Millisecond
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) graphql() bool {
+	config.mu.RLock()
+	v := config._graphql == "yes"
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) followerHooks() bool {
+	config.mu.RLock()
+	v := config._followerHooks == "yes"
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) raftTerm() (term uint64, vote string) {
+	config.mu.RLock()
+	term, vote = config._raftTerm, config._raftVote
+	config.mu.RUnlock()
+	return term, vote
+}
+func (config *Config) setRaftTerm(term uint64, vote string) {
+	config.mu.Lock()
+	config._raftTerm, config._raftVote = term, vote
+	config.mu.Unlock()
+}
+func (config *Config) raftLogTerm() uint64 {
+	config.mu.RLock()
+	v := config._raftLogTerm
+	config.mu.RUnlock()
+	return v
+}
+func (config *Config) setRaftLogTerm(v uint64) {
+	config.mu.Lock()
+	config._raftLogTerm = v
+	config.mu.Unlock()
+}
 func (config *Config) setFollowHost(v string) {
 	config.mu.Lock()
 	config._followHost = v
@@ -455,8 +2171,66 @@ func (config *Config) setFollowPort(v int) {
 	config._followPort = int64(v)
 	config.mu.Unlock()
 }
-func (config *Config) setReadOnly(v bool) {
+func (config *Config) setFollowKeys(v []string) {
+	config.mu.Lock()
+	config._followKeys = v
+	config.mu.Unlock()
+}
+func (config *Config) setFollowResume(id string, pos int64, sum string) {
+	config.mu.Lock()
+	config._followID, config._followPos, config._followSum = id, pos, sum
+	config.mu.Unlock()
+}
+func (config *Config) setPeerHost(v string) {
+	config.mu.Lock()
+	config._peerHost = v
+	config.mu.Unlock()
+}
+func (config *Config) setPeerPort(v int) {
+	config.mu.Lock()
+	config._peerPort = int64(v)
+	config.mu.Unlock()
+}
+func (config *Config) setPeerTLS(v bool) {
+	config.mu.Lock()
+	config._peerTLS = v
+	config.mu.Unlock()
+}
+func (config *Config) setFollowTLS(v bool) {
+	config.mu.Lock()
+	config._followTLS = v
+	config.mu.Unlock()
+}
+func (config *Config) setPeerResume(id string, pos int64, sum string) {
+	config.mu.Lock()
+	config._peerID, config._peerPos, config._peerSum = id, pos, sum
+	config.mu.Unlock()
+}
+func (config *Config) setClusterTopology(v string) {
+	config.mu.Lock()
+	config._clusterTopo = v
+	config.mu.Unlock()
+}
+func (config *Config) setACLUsers(v string) {
+	config.mu.Lock()
+	config._aclUsers = v
+	config.mu.Unlock()
+}
+func (config *Config) setNamespaces(v string) {
+	config.mu.Lock()
+	config._namespaces = v
+	config.mu.Unlock()
+}
+func (config *Config) setAuthTokens(v string) {
+	config.mu.Lock()
+	config._authTokens = v
+	config.mu.Unlock()
+}
+func (config *Config) setReadOnly(v, save bool) {
 	config.mu.Lock()
 	config._readOnly = v
+	if save {
+		config._readOnlyP = v
+	}
 	config.mu.Unlock()
 }
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := config.serverID()
+	if err := config.setProperty(KeepAlive, "10", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is edited, and the keepalive of CONFIG SET is replaced
+	err = ioutil.WriteFile(path, []byte(`{"server_id":"`+id+`",`+
+		`"maxmemory":"2gb","slowlog-max-len":"5"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if config.maxMemory() != 2*1024*1024*1024 {
+		t.Fatalf("expected 2gb, got %d", config.maxMemory())
+	}
+	if config.keepAlive() != defaultKeepAlive {
+		t.Fatalf("expected %d, got %d", defaultKeepAlive, config.keepAlive())
+	}
+	if config.serverID() != id {
+		t.Fatal("expected the same server id")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := gjson.GetBytes(data, SlowlogMaxLen).String(); v != "5" {
+		t.Fatalf("expected the file to be rewritten, got '%s'", v)
+	}
+
+	// an invalid value changes nothing
+	err = ioutil.WriteFile(path, []byte(`{"server_id":"`+id+`",`+
+		`"maxmemory":"1gb","loglevels":"bad"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.reload(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if config.maxMemory() != 2*1024*1024*1024 {
+		t.Fatalf("expected 2gb, got %d", config.maxMemory())
+	}
+}
+
+func TestConfigBackgroundIntervals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interval, size := config.expireSweep()
+	if interval != time.Second/10 || size != defaultExpireSweepSize ||
+		config.aofFlushInterval() != time.Second ||
+		config.endpointReapInterval() != time.Second {
+		t.Fatal("expected the defaults")
+	}
+	for _, name := range []string{ExpireSweepInterval, ExpireSweepSize,
+		AOFFlushInterval, EndpointReapInterval} {
+		if err := config.setProperty(name, "0", false); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+		if err := config.setProperty(name, "250", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	interval, size = config.expireSweep()
+	if interval != time.Millisecond*250 || size != 250 ||
+		config.aofFlushInterval() != time.Millisecond*250 ||
+		config.endpointReapInterval() != time.Millisecond*250 {
+		t.Fatal("expected the new values")
+	}
+	config.setProperty(AOFFlushInterval, "", false)
+	config.write(true)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gjson.GetBytes(data, AOFFlushInterval).Exists() ||
+		gjson.GetBytes(data, ExpireSweepSize).String() != "250" {
+		t.Fatalf("unexpected config file %s", data)
+	}
+}
+
+func TestConfigShutdownDrainTimeout(t *testing.T) {
+	config, err := loadConfig(filepath.Join(t.TempDir(), "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.shutdownDrainTimeout() != time.Second*10 {
+		t.Fatal("expected the default")
+	}
+	if err := config.setProperty(ShutdownDrainTimeout, "-1", false); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := config.setProperty(ShutdownDrainTimeout, "0", false); err != nil {
+		t.Fatal(err)
+	}
+	if config.shutdownDrainTimeout() != 0 {
+		t.Fatal("expected no draining")
+	}
+	if err := config.setProperty(ShutdownDrainTimeout, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if config.shutdownDrainTimeout() != time.Second*10 {
+		t.Fatal("expected the default")
+	}
+}
+
+func TestConfigReadOnlyNoSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.setReadOnly(true, false)
+	config.write(false)
+	if !config.readOnly() {
+		t.Fatal("expected read only")
+	}
+	config, err = loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.readOnly() {
+		t.Fatal("expected the unsaved mode to be dropped")
+	}
+	config.setReadOnly(true, true)
+	config.write(false)
+	config, err = loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.readOnly() {
+		t.Fatal("expected the saved mode")
+	}
+}
+
+func TestConfigQueryWorkers(t *testing.T) {
+	config, err := loadConfig(filepath.Join(t.TempDir(), "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.queryWorkers() != runtime.GOMAXPROCS(0) {
+		t.Fatal("expected one for each CPU")
+	}
+	if err := config.setProperty(QueryWorkers, "-1", false); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := config.setProperty(QueryWorkers, "1", false); err != nil {
+		t.Fatal(err)
+	}
+	if config.queryWorkers() != 1 || config.getProperty(QueryWorkers) != "1" {
+		t.Fatal("expected one worker")
+	}
+}
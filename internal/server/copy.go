@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/clip"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+// COPY source destination [MATCH pattern] [WHERE ...] [WHEREIN ...]
+// [WHEREEVAL ...] [WHERESTR ...] [LIMIT count]
+// [(WITHIN | INTERSECTS) area] [REPLACE]
+//
+// Copies the objects of a collection that match the filters into another
+// collection, along with their fields and expirations. Nothing is copied
+// when the destination exists, unless REPLACE is used, which deletes its
+// objects first. Returns the number of copied objects.
+func (s *Server) cmdCopy(msg *Message) (res resp.Value, d commandDetails, err error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	if len(vs) < 2 {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	src, dst := vs[0], vs[1]
+	if src == "" || dst == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	opts := vs[2:]
+	var replace bool
+	if len(opts) > 0 && lc(opts[len(opts)-1], "replace") {
+		replace, opts = true, opts[:len(opts)-1]
+	}
+	if src == dst {
+		return NOMessage, d, clientErrorf(
+			"source and destination keys are the same")
+	}
+	ls, err := s.copyArgs(src, opts)
+	if ls.usingLua() {
+		defer ls.Close()
+		defer func() {
+			if r := recover(); r != nil {
+				res = NOMessage
+				err = errors.New(r.(string))
+			}
+		}()
+	}
+	if err != nil {
+		return NOMessage, d, err
+	}
+	d.key = dst
+	d.command = "copy"
+	d.parent = true
+	d.timestamp = time.Now()
+	if s.getCol(dst) != nil && !replace {
+		return copyResult(msg, start, 0), d, nil
+	}
+	items, err := s.copyObjects(msg, &ls)
+	if err != nil {
+		return NOMessage, d, err
+	}
+	// Check every object before changing anything, so that an invalid
+	// object fails the whole command.
+	srcCol := s.getCol(src)
+	lim := s.limits[dst]
+	var added int
+	args := make([][]string, len(items))
+	for i, item := range items {
+		var ok bool
+		if args[i], ok = objectArgs(srcCol, dst, item.id); !ok {
+			continue
+		}
+		if item.clipped {
+			args[i] = append(args[i][:len(args[i])-2],
+				"object", string(item.obj.AppendJSON(nil)))
+		}
+		cd, fields, values, _, _, _, _, _, _, err := s.parseSetArgs(args[i][1:])
+		if err != nil {
+			return NOMessage, d, err
+		}
+		// the schema is checked without the objects of the destination,
+		// which are deleted by REPLACE
+		if sc := s.schemas[dst]; sc != nil {
+			if err := sc.checkSet(nil, cd.id, fields, values); err != nil {
+				return NOMessage, d, err
+			}
+		}
+		if lim != nil {
+			err := lim.check(nil, dst, cd.id, cd.obj, fields, values, added)
+			if err != nil {
+				lim.rejected.add(1)
+				return NOMessage, d, err
+			}
+			added++
+		}
+	}
+	if col := s.getCol(dst); col != nil {
+		var ids []string
+		col.Scan(false, nil, nil, func(id string, _ geojson.Object,
+			_ []field.Value,
+		) bool {
+			ids = append(ids, id)
+			return true
+		})
+		for _, id := range ids {
+			cmsg := *msg
+			cmsg._command = "del"
+			cmsg.Args = []string{"del", dst, id}
+			_, cd, err := s.cmdDel(&cmsg)
+			if err != nil {
+				return NOMessage, d, err
+			}
+			if cd.updated {
+				d.children = append(d.children, &cd)
+			}
+		}
+	}
+	var count int
+	for i, item := range items {
+		if args[i] == nil {
+			continue
+		}
+		cmsg := *msg
+		cmsg._command = "set"
+		cmsg.Args = args[i]
+		_, cd, err := s.cmdSet(&cmsg, true)
+		if err != nil {
+			d.updated = len(d.children) > 0
+			return NOMessage, d, err
+		}
+		if at, ok := s.getExpires(src, item.id); ok {
+			s.expireAt(dst, item.id, at)
+		}
+		for name, at := range s.fexpires[src][item.id] {
+			s.expireFieldAt(dst, item.id, name, time.Unix(0, at))
+		}
+		d.children = append(d.children, &cd)
+		count++
+	}
+	d.updated = len(d.children) > 0
+	return copyResult(msg, start, count), d, nil
+}
+
+// copyResult returns the number of copied objects.
+func copyResult(msg *Message, start time.Time, count int) resp.Value {
+	switch msg.OutputType {
+	case JSON:
+		return resp.StringValue(`{"ok":true,"count":` + strconv.Itoa(count) +
+			`,"elapsed":"` + time.Since(start).String() + "\"}")
+	case RESP:
+		return resp.IntegerValue(count)
+	}
+	return NOMessage
+}
+
+// copyArgs parses the filters of a COPY, which are those of a SCAN, and those
+// of a WITHIN or INTERSECTS when followed by an area.
+func (s *Server) copyArgs(src string, opts []string) (
+	ls liveFenceSwitches, err error,
+) {
+	var t searchScanBaseTokens
+	vs, t, err := s.parseSearchScanBaseTokens("copy", t,
+		append([]string{src}, opts...))
+	ls.searchScanBaseTokens = t
+	if err != nil {
+		return ls, err
+	}
+	if t.output != defaultSearchOutput || t.fence || t.stable ||
+		t.sample != 0 || t.cursor != 0 || t.nofields || t.usparse ||
+		t.distance || t.sortBy != "" || t.agg != nil || t.clusters != nil {
+		return ls, clientErrorf("COPY only accepts filters")
+	}
+	if len(vs) == 0 {
+		ls.cmd = "scan"
+		return ls, nil
+	}
+	cmd := strings.ToLower(vs[0])
+	if cmd != "within" && cmd != "intersects" {
+		return ls, errInvalidArgument(vs[0])
+	}
+	// the filters are parsed again along with the area
+	ls.Close()
+	filters := opts[:len(opts)-len(vs)]
+	ls, err = s.cmdSearchArgs(false, cmd,
+		append(append([]string{src}, filters...), vs[1:]...),
+		withinOrIntersectsTypes)
+	if err != nil {
+		return ls, err
+	}
+	if ls.join.key != "" {
+		return ls, clientErrorf("COPY only accepts filters")
+	}
+	ls.cmd = cmd
+	return ls, nil
+}
+
+// copyItem is an object of a COPY.
+type copyItem struct {
+	id      string
+	obj     geojson.Object
+	clipped bool // the object was clipped to the area of an INTERSECTS
+}
+
+// copyObjects returns the objects of a COPY that match its filters.
+func (s *Server) copyObjects(msg *Message, ls *liveFenceSwitches) (
+	[]copyItem, error,
+) {
+	sw, err := s.newScanWriter(
+		&bytes.Buffer{}, msg, ls.key, outputCount, 0, ls.glob, false,
+		0, ls.limit, ls.wheres, ls.whereins, ls.whereevals, ls.wherestrs,
+		false, nil)
+	if err != nil || sw.col == nil {
+		return nil, err
+	}
+	sw.zrange, sw.zmin, sw.zmax = ls.zrange, ls.zmin, ls.zmax
+	sw.tsrange, sw.tsmin, sw.tsmax = ls.tsrange, ls.tsmin, ls.tsmax
+	var items []copyItem
+	add := func(params ScanWriterParams) bool {
+		ok, keepGoing, _ := sw.testObject(params.id, params.o, params.fields,
+			false)
+		if !ok {
+			return keepGoing
+		}
+		item := copyItem{id: params.id, obj: params.o}
+		if params.clip != nil {
+			item.obj = clip.Clip(params.o, params.clip, &s.geomIndexOpts)
+			item.clipped = true
+		}
+		items = append(items, item)
+		return keepGoing && uint64(len(items)) < sw.limit
+	}
+	if ls.cmd == "scan" {
+		sw.col.Scan(ls.desc, nil, msg.Deadline,
+			func(id string, o geojson.Object, fields []field.Value) bool {
+				if s.hasExpired(ls.key, id) {
+					return true
+				}
+				return add(ScanWriterParams{id: id, o: o, fields: fields})
+			},
+		)
+	} else {
+		s.searchAreaObjects(ls, sw, nil, msg, ls.obj, add)
+	}
+	return items, nil
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"bytes"
+	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -12,17 +14,18 @@ import (
 	"github.com/tidwall/geojson/geometry"
 	"github.com/tidwall/resp"
 	"github.com/tidwall/rhh"
-	"github.com/tidwall/rtree"
 	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/field"
 	"github.com/tidwall/tile38/internal/glob"
+	"github.com/tidwall/tile38/internal/history"
 )
 
 type fvt struct {
 	field string
-	value float64
+	value field.Value
 }
 
-func orderFields(fmap map[string]int, farr []string, fields []float64) []fvt {
+func orderFields(fmap map[string]int, farr []string, fields []field.Value) []fvt {
 	var fv fvt
 	var idx int
 	fvs := make([]fvt, 0, len(fmap))
@@ -31,7 +34,7 @@ func orderFields(fmap map[string]int, farr []string, fields []float64) []fvt {
 		if idx < len(fields) {
 			fv.field = field
 			fv.value = fields[idx]
-			if fv.value != 0 {
+			if !fv.value.IsZero() {
 				fvs = append(fvs, fv)
 			}
 		}
@@ -101,9 +104,12 @@ func (server *Server) cmdType(msg *Message) (resp.Value, error) {
 
 	var ok bool
 	var key string
-	if _, key, ok = tokenval(vs); !ok || key == "" {
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
 		return NOMessage, errInvalidNumberOfArguments
 	}
+	if len(vs) > 0 {
+		return server.cmdTypeIDs(msg, start, key, vs)
+	}
 
 	col := server.getCol(key)
 	if col == nil {
@@ -117,13 +123,138 @@ func (server *Server) cmdType(msg *Message) (resp.Value, error) {
 
 	switch msg.OutputType {
 	case JSON:
-		return resp.StringValue(`{"ok":true,"type":` + string(typ) + `,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+		return resp.StringValue(`{"ok":true,"type":` + jsonString(typ) + `,"elapsed":"` + time.Since(start).String() + "\"}"), nil
 	case RESP:
 		return resp.SimpleStringValue(typ), nil
 	}
 	return NOMessage, nil
 }
 
+// TYPE key id [id ...]
+//
+// Returns the types of the objects, which are point, linestring, polygon,
+// string, and so on, or null for the objects that don't exist.
+func (server *Server) cmdTypeIDs(msg *Message, start time.Time, key string,
+	ids []string,
+) (resp.Value, error) {
+	col := server.getCol(key)
+	types := make([]string, len(ids))
+	for i, id := range ids {
+		if col == nil {
+			break
+		}
+		if o, _, ok := col.Get(id); ok && !server.hasExpired(key, id) {
+			types[i] = objectType(o)
+		}
+	}
+	switch msg.OutputType {
+	case JSON:
+		buf := []byte(`{"ok":true,"types":[`)
+		for i, typ := range types {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if typ == "" {
+				buf = append(buf, "null"...)
+			} else {
+				buf = appendJSONString(buf, typ)
+			}
+		}
+		buf = append(buf, `],"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		vals := make([]resp.Value, len(types))
+		for i, typ := range types {
+			if typ == "" {
+				vals[i] = resp.NullValue()
+			} else {
+				vals[i] = resp.SimpleStringValue(typ)
+			}
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
+// objectType returns the type of an object for TYPE, which is the lowercase
+// GeoJSON type of its geometry, or string.
+func objectType(o geojson.Object) string {
+	switch o := o.(type) {
+	case collection.String:
+		return "string"
+	case *geojson.Point, *geojson.SimplePoint:
+		return "point"
+	case *geojson.MultiPoint:
+		return "multipoint"
+	case *geojson.LineString:
+		return "linestring"
+	case *geojson.MultiLineString:
+		return "multilinestring"
+	case *geojson.Polygon, *geojson.Rect:
+		return "polygon"
+	case *geojson.MultiPolygon:
+		return "multipolygon"
+	case *geojson.Circle:
+		return "circle"
+	case *geojson.GeometryCollection:
+		return "geometrycollection"
+	case *geojson.FeatureCollection:
+		return "featurecollection"
+	case *geojson.Feature:
+		return objectType(o.Base())
+	}
+	return "object"
+}
+
+// EXISTS key id [id ...]
+//
+// Returns 1 for each of the objects that exists, and 0 for the others.
+func (server *Server) cmdExists(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	var key string
+	if vs, key, ok = tokenval(vs); !ok || key == "" || len(vs) == 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	col := server.getCol(key)
+	exists := make([]bool, len(vs))
+	for i, id := range vs {
+		if col == nil {
+			break
+		}
+		_, _, ok := col.Get(id)
+		exists[i] = ok && !server.hasExpired(key, id)
+	}
+	switch msg.OutputType {
+	case JSON:
+		buf := []byte(`{"ok":true,"exists":[`)
+		for i, ok := range exists {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if ok {
+				buf = append(buf, '1')
+			} else {
+				buf = append(buf, '0')
+			}
+		}
+		buf = append(buf, `],"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		vals := make([]resp.Value, len(exists))
+		for i, ok := range exists {
+			if ok {
+				vals[i] = resp.IntegerValue(1)
+			} else {
+				vals[i] = resp.IntegerValue(0)
+			}
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
 func (server *Server) cmdGet(msg *Message) (resp.Value, error) {
 	start := time.Now()
 	vs := msg.Args[1:]
@@ -137,9 +268,16 @@ func (server *Server) cmdGet(msg *Message) (resp.Value, error) {
 		return NOMessage, errInvalidNumberOfArguments
 	}
 
-	withfields := false
-	if _, peek, ok := tokenval(vs); ok && strings.ToLower(peek) == "withfields" {
-		withfields = true
+	var withfields, withversion bool
+	for {
+		_, peek, ok := tokenval(vs)
+		if ok && lc(peek, "withfields") && !withfields {
+			withfields = true
+		} else if ok && lc(peek, "withversion") && !withversion {
+			withversion = true
+		} else {
+			break
+		}
 		vs = vs[1:]
 	}
 
@@ -175,7 +313,12 @@ func (server *Server) cmdGet(msg *Message) (resp.Value, error) {
 	case "object":
 		if msg.OutputType == JSON {
 			buf.WriteString(`,"object":`)
-			buf.WriteString(string(o.AppendJSON(nil)))
+			buf.Write(col.AppendObjectJSON(nil, o))
+			if msg.bounds {
+				buf.Write(appendJSONObjectBounds(nil, col, o))
+			}
+		} else if objIsSpatial(o) {
+			vals = append(vals, resp.StringValue(col.ObjectJSON(o)))
 		} else {
 			vals = append(vals, resp.StringValue(o.String()))
 		}
@@ -254,9 +397,9 @@ func (server *Server) cmdGet(msg *Message) (resp.Value, error) {
 					if i > 0 {
 						buf.WriteString(`,`)
 					}
-					buf.WriteString(jsonString(fv.field) + ":" + strconv.FormatFloat(fv.value, 'f', -1, 64))
+					buf.WriteString(jsonString(fv.field) + ":" + fv.value.JSON())
 				} else {
-					fvals = append(fvals, resp.StringValue(fv.field), resp.StringValue(strconv.FormatFloat(fv.value, 'f', -1, 64)))
+					fvals = append(fvals, resp.StringValue(fv.field), resp.StringValue(fv.value.String()))
 				}
 				i++
 			}
@@ -267,13 +410,21 @@ func (server *Server) cmdGet(msg *Message) (resp.Value, error) {
 			}
 		}
 	}
+	if withversion {
+		version := col.Version(id)
+		if msg.OutputType == JSON {
+			buf.WriteString(`,"version":` + strconv.FormatUint(version, 10))
+		} else {
+			vals = append(vals, resp.IntegerValue(int(version)))
+		}
+	}
 	switch msg.OutputType {
 	case JSON:
 		buf.WriteString(`,"elapsed":"` + time.Since(start).String() + "\"}")
 		return resp.StringValue(buf.String()), nil
 	case RESP:
 		var oval resp.Value
-		if withfields {
+		if withfields || withversion {
 			oval = resp.ArrayValue(vals)
 		} else {
 			oval = vals[0]
@@ -302,15 +453,25 @@ func (server *Server) cmdDel(msg *Message) (res resp.Value, d commandDetails, er
 	found := false
 	col := server.getCol(d.key)
 	if col != nil {
+		fmap := col.FieldMap()
 		d.obj, d.fields, ok = col.Delete(d.id)
 		if ok {
+			d.fmap = make(map[string]int)
+			for key, idx := range fmap {
+				d.fmap[key] = idx
+			}
 			if col.Count() == 0 {
 				server.deleteCol(d.key)
 			}
 			found = true
+			// the expired objects are deleted by the expire sweeps, and
+			// the followers that replay the deletes see them as expired too
+			d.expired = server.hasExpired(d.key, d.id)
 		}
 	}
 	server.clearIDExpires(d.key, d.id)
+	server.clearIDFieldExpires(d.key, d.id)
+	server.historyDelete(d.key, d.id)
 	d.command = "del"
 	d.updated = found
 	d.timestamp = time.Now()
@@ -344,7 +505,7 @@ func (server *Server) cmdPdel(msg *Message) (res resp.Value, d commandDetails, e
 		return
 	}
 	now := time.Now()
-	iter := func(id string, o geojson.Object, fields []float64) bool {
+	iter := func(id string, o geojson.Object, fields []field.Value) bool {
 		if match, _ := glob.Match(d.pattern, id); match {
 			d.children = append(d.children, &commandDetails{
 				command:   "del",
@@ -367,7 +528,12 @@ func (server *Server) cmdPdel(msg *Message) (res resp.Value, d commandDetails, e
 			col.ScanRange(g.Limits[0], g.Limits[1], false, nil, msg.Deadline, iter)
 		}
 		var atLeastOneNotDeleted bool
+		fmap := make(map[string]int)
+		for key, idx := range col.FieldMap() {
+			fmap[key] = idx
+		}
 		for i, dc := range d.children {
+			dc.fmap = fmap
 			dc.obj, dc.fields, ok = col.Delete(dc.id)
 			if !ok {
 				d.children[i].command = "?"
@@ -376,6 +542,8 @@ func (server *Server) cmdPdel(msg *Message) (res resp.Value, d commandDetails, e
 				d.children[i] = dc
 			}
 			server.clearIDExpires(d.key, dc.id)
+			server.clearIDFieldExpires(d.key, dc.id)
+			server.historyDelete(d.key, dc.id)
 		}
 		if atLeastOneNotDeleted {
 			var nchildren []*commandDetails
@@ -422,6 +590,7 @@ func (server *Server) cmdDrop(msg *Message) (res resp.Value, d commandDetails, e
 	col := server.getCol(d.key)
 	if col != nil {
 		server.deleteCol(d.key)
+		server.historyDelete(d.key, "")
 		d.updated = true
 	} else {
 		d.key = "" // ignore the details
@@ -485,6 +654,9 @@ func (server *Server) cmdRename(msg *Message, nx bool) (res resp.Value, d comman
 		server.deleteCol(d.key)
 		server.setCol(d.newKey, col)
 		server.moveKeyExpires(d.key, d.newKey)
+		// the positions are kept by key, and do not follow the objects
+		server.historyDelete(d.key, "")
+		server.historyDelete(d.newKey, "")
 	}
 	d.timestamp = time.Now()
 	switch msg.OutputType {
@@ -509,12 +681,21 @@ func (server *Server) cmdFlushDB(msg *Message) (res resp.Value, d commandDetails
 		err = errInvalidNumberOfArguments
 		return
 	}
+	for _, hook := range server.hooks {
+		server.forgetPausedHookLogs(hook)
+	}
 	server.cols = btree.New(byCollectionKey)
 	server.expires = rhh.New(0)
+	server.fexpires = make(map[string]map[string]map[string]int64)
 	server.hooks = make(map[string]*Hook)
-	server.hooksOut = make(map[string]*Hook)
-	server.hookTree = rtree.RTree{}
-	server.hookCross = rtree.RTree{}
+	server.schemas = make(map[string]*schema)
+	server.limits = make(map[string]*limits)
+	server.indexes = make(map[string][]string)
+	server.geoIndexes = make(map[string]*geojson.ParseOptions)
+	server.histories = make(map[string]*history.Store)
+	server.labels = make(map[string]map[string]string)
+	server.triggers = make(map[string]*trigger)
+	server.hookIndex = hookIndex{}
 	d.command = "flushdb"
 	d.updated = true
 	d.timestamp = time.Now()
@@ -528,8 +709,8 @@ func (server *Server) cmdFlushDB(msg *Message) (res resp.Value, d commandDetails
 }
 
 func (server *Server) parseSetArgs(vs []string) (
-	d commandDetails, fields []string, values []float64,
-	xx, nx bool,
+	d commandDetails, fields []string, values []field.Value,
+	xx, nx bool, conds []setCondition,
 	expires *float64, etype []byte, evs []string, err error,
 ) {
 	var ok bool
@@ -553,7 +734,6 @@ func (server *Server) parseSetArgs(vs []string) (
 			vs = nvs
 			var name string
 			var svalue string
-			var value float64
 			if vs, name, ok = tokenval(vs); !ok || name == "" {
 				err = errInvalidNumberOfArguments
 				return
@@ -566,13 +746,8 @@ func (server *Server) parseSetArgs(vs []string) (
 				err = errInvalidNumberOfArguments
 				return
 			}
-			value, err = strconv.ParseFloat(svalue, 64)
-			if err != nil {
-				err = errInvalidArgument(svalue)
-				return
-			}
 			fields = append(fields, name)
-			values = append(values, value)
+			values = append(values, field.Parse(svalue))
 			continue
 		}
 		if lcb(arg, "ex") {
@@ -595,6 +770,63 @@ func (server *Server) parseSetArgs(vs []string) (
 			expires = &v
 			continue
 		}
+		if lcb(arg, "jitter") {
+			// a valid JITTER that follows the EX was replaced with the ttl,
+			// see jitterStamp
+			vs = nvs
+			var s string
+			if vs, s, ok = tokenval(vs); !ok || s == "" {
+				err = errInvalidNumberOfArguments
+				return
+			}
+			if expires == nil {
+				s = string(arg)
+			}
+			err = errInvalidArgument(s)
+			return
+		}
+		if lcb(arg, "timestamp") {
+			vs = nvs
+			if d.observed != 0 {
+				err = errInvalidArgument(string(arg))
+				return
+			}
+			var s string
+			if vs, s, ok = tokenval(vs); !ok || s == "" {
+				err = errInvalidNumberOfArguments
+				return
+			}
+			if d.observed, err = parseTimestamp(s); err != nil {
+				return
+			}
+			continue
+		}
+		if lcb(arg, "version") {
+			vs = nvs
+			if d.version != 0 {
+				err = errInvalidArgument(string(arg))
+				return
+			}
+			var s string
+			if vs, s, ok = tokenval(vs); !ok || s == "" {
+				err = errInvalidNumberOfArguments
+				return
+			}
+			if d.version, err = strconv.ParseUint(s, 10, 64); err != nil ||
+				d.version == 0 {
+				err = errInvalidArgument(s)
+				return
+			}
+			continue
+		}
+		if lcb(arg, "if") {
+			var cond setCondition
+			if vs, cond, err = parseSetCondition(nvs); err != nil {
+				return
+			}
+			conds = append(conds, cond)
+			continue
+		}
 		if lcb(arg, "xx") {
 			vs = nvs
 			if nx {
@@ -736,7 +968,7 @@ func (server *Server) parseSetArgs(vs []string) (
 			err = errInvalidNumberOfArguments
 			return
 		}
-		d.obj, err = geojson.Parse(object, &server.geomParseOpts)
+		d.obj, err = server.parseObject(d.key, object)
 		if err != nil {
 			return
 		}
@@ -756,19 +988,36 @@ func (server *Server) cmdSet(msg *Message, resetExpires bool) (res resp.Value, d
 	vs := msg.Args[1:]
 	var fmap map[string]int
 	var fields []string
-	var values []float64
+	var values []field.Value
 	var xx, nx bool
+	var conds []setCondition
+	var failed bool // a condition does not hold
 	var ex *float64
-	d, fields, values, xx, nx, ex, _, _, err = server.parseSetArgs(vs)
+	d, fields, values, xx, nx, conds, ex, _, _, err = server.parseSetArgs(vs)
 	if err != nil {
 		return
 	}
+	if err = server.checkSchema(d.key, d.id, fields, values); err != nil {
+		return
+	}
+	if err = server.checkLimits(d.key, d.id, d.obj, fields, values, 0); err != nil {
+		return
+	}
 	col := server.getCol(d.key)
+	for _, cond := range conds {
+		if !cond.match(col, d.id) {
+			failed = true
+			goto notok
+		}
+	}
 	if col == nil {
 		if xx {
 			goto notok
 		}
-		col = collection.New()
+		col, err = server.newCol(d.key)
+		if err != nil {
+			return
+		}
 		server.setCol(d.key, col)
 	}
 	if xx || nx {
@@ -781,9 +1030,16 @@ func (server *Server) cmdSet(msg *Message, resetExpires bool) (res resp.Value, d
 		server.clearIDExpires(d.key, d.id)
 	}
 	d.oldObj, d.oldFields, d.fields = col.Set(d.id, d.obj, fields, values)
+	col.SetTimestamp(d.id, d.observed)
+	if d.version == 0 {
+		d.version = col.Version(d.id) + 1
+	}
+	col.SetVersion(d.id, d.version)
+	server.clearFieldExpires(d.key, d.id, fields)
 	d.command = "set"
 	d.updated = true // perhaps we should do a diff on the previous object?
 	d.timestamp = time.Now()
+	server.historyAdd(&d)
 	if msg.ConnType != Null || msg.OutputType != Null {
 		// likely loaded from aof at server startup, ignore field remapping.
 		fmap = col.FieldMap()
@@ -807,7 +1063,9 @@ notok:
 	switch msg.OutputType {
 	default:
 	case JSON:
-		if nx {
+		if failed {
+			err = errConditionNotMet
+		} else if nx {
 			err = errIDAlreadyExists
 		} else {
 			err = errIDNotFound
@@ -820,7 +1078,8 @@ notok:
 }
 
 func (server *Server) parseFSetArgs(vs []string) (
-	d commandDetails, fields []string, values []float64, xx bool, err error,
+	d commandDetails, fields []string, values []field.Value, xx bool,
+	ex *float64, err error,
 ) {
 	var ok bool
 	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" {
@@ -841,23 +1100,31 @@ func (server *Server) parseFSetArgs(vs []string) (
 			xx = true
 			continue
 		}
+		if lc(name, "ex") {
+			var s string
+			if vs, s, ok = tokenval(vs); !ok || s == "" {
+				err = errInvalidNumberOfArguments
+				return
+			}
+			v, perr := strconv.ParseFloat(s, 64)
+			if perr != nil {
+				err = errInvalidArgument(s)
+				return
+			}
+			ex = &v
+			continue
+		}
 		if isReservedFieldName(name) {
 			err = errInvalidArgument(name)
 			return
 		}
 		var svalue string
-		var value float64
 		if vs, svalue, ok = tokenval(vs); !ok || svalue == "" {
 			err = errInvalidNumberOfArguments
 			return
 		}
-		value, err = strconv.ParseFloat(svalue, 64)
-		if err != nil {
-			err = errInvalidArgument(svalue)
-			return
-		}
 		fields = append(fields, name)
-		values = append(values, value)
+		values = append(values, field.Parse(svalue))
 	}
 	return
 }
@@ -870,16 +1137,34 @@ func (server *Server) cmdFset(msg *Message) (res resp.Value, d commandDetails, e
 	start := time.Now()
 	vs := msg.Args[1:]
 	var fields []string
-	var values []float64
+	var values []field.Value
 	var xx bool
+	var ex *float64
 	var updateCount int
-	d, fields, values, xx, err = server.parseFSetArgs(vs)
+	d, fields, values, xx, ex, err = server.parseFSetArgs(vs)
+	if err != nil {
+		return
+	}
 
 	col := server.getCol(d.key)
 	if col == nil {
 		err = errKeyNotFound
 		return
 	}
+	if err = server.checkSchemaFields(d.key, fields, values); err != nil {
+		return
+	}
+	if ex != nil {
+		// a field that expires is cleared, which a schema must allow
+		if sc := server.schemas[d.key]; sc != nil {
+			for _, name := range fields {
+				if sf := sc.fields[name]; sf != nil && sf.required {
+					err = fmt.Errorf("field '%s' is required", name)
+					return
+				}
+			}
+		}
+	}
 	var ok bool
 	d.obj, d.fields, updateCount, ok = col.SetFields(d.id, fields, values)
 	if !(ok || xx) {
@@ -887,9 +1172,24 @@ func (server *Server) cmdFset(msg *Message) (res resp.Value, d commandDetails, e
 		return
 	}
 	if ok {
+		if ex != nil {
+			at := time.Now().Add(time.Duration(*ex * float64(time.Second)))
+			for i, name := range fields {
+				if values[i].IsZero() {
+					server.clearFieldExpires(d.key, d.id, []string{name})
+				} else {
+					server.expireFieldAt(d.key, d.id, name, at)
+				}
+			}
+		} else {
+			server.clearFieldExpires(d.key, d.id, fields)
+		}
 		d.command = "fset"
 		d.timestamp = time.Now()
 		d.updated = updateCount > 0
+		if d.updated {
+			bumpVersion(col, d.id)
+		}
 		fmap := col.FieldMap()
 		d.fmap = make(map[string]int)
 		for key, idx := range fmap {
@@ -906,6 +1206,100 @@ func (server *Server) cmdFset(msg *Message) (res resp.Value, d commandDetails, e
 	return
 }
 
+// FINCRBY key id field delta
+//
+// Adds the delta to the number of a field, which is zero when the field is
+// not set, and returns the new number. The expiration of the field is kept.
+func (server *Server) cmdFincrby(msg *Message) (res resp.Value, d commandDetails, err error) {
+	if server.config.maxMemory() > 0 && server.outOfMemory.on() {
+		err = errOOM
+		return
+	}
+	start := time.Now()
+	vs := msg.Args[1:]
+	var name, sdelta string
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" {
+		err = errInvalidNumberOfArguments
+		return
+	}
+	if vs, d.id, ok = tokenval(vs); !ok || d.id == "" {
+		err = errInvalidNumberOfArguments
+		return
+	}
+	if vs, name, ok = tokenval(vs); !ok || name == "" {
+		err = errInvalidNumberOfArguments
+		return
+	}
+	if vs, sdelta, ok = tokenval(vs); !ok || sdelta == "" || len(vs) != 0 {
+		err = errInvalidNumberOfArguments
+		return
+	}
+	if isReservedFieldName(name) {
+		err = errInvalidArgument(name)
+		return
+	}
+	delta, perr := strconv.ParseFloat(sdelta, 64)
+	if perr != nil || math.IsInf(delta, 0) || math.IsNaN(delta) {
+		err = errInvalidArgument(sdelta)
+		return
+	}
+	col := server.getCol(d.key)
+	if col == nil {
+		err = errKeyNotFound
+		return
+	}
+	_, values, ok := col.Get(d.id)
+	if !ok {
+		err = errIDNotFound
+		return
+	}
+	var value field.Value
+	if idx, ok := col.FieldMap()[name]; ok && idx < len(values) {
+		value = values[idx]
+	}
+	if value.Kind() != field.Number {
+		err = fmt.Errorf("field '%s' is not a number", name)
+		return
+	}
+	num := value.Num() + delta
+	if math.IsInf(num, 0) {
+		err = fmt.Errorf("field '%s' would overflow", name)
+		return
+	}
+	fields := []string{name}
+	nvalues := []field.Value{field.Num(num)}
+	if err = server.checkSchemaFields(d.key, fields, nvalues); err != nil {
+		return
+	}
+	var updateCount int
+	d.obj, d.fields, updateCount, _ = col.SetFields(d.id, fields, nvalues)
+	if num == 0 {
+		// a zero field is not set, so it no longer expires
+		server.clearFieldExpires(d.key, d.id, fields)
+	}
+	d.command = "fset"
+	d.timestamp = time.Now()
+	d.updated = updateCount > 0
+	if d.updated {
+		bumpVersion(col, d.id)
+	}
+	fmap := col.FieldMap()
+	d.fmap = make(map[string]int)
+	for key, idx := range fmap {
+		d.fmap[key] = idx
+	}
+	snum := strconv.FormatFloat(num, 'f', -1, 64)
+	switch msg.OutputType {
+	case JSON:
+		res = resp.StringValue(`{"ok":true,"value":` + snum + `,"elapsed":"` +
+			time.Since(start).String() + "\"}")
+	case RESP:
+		res = resp.StringValue(snum)
+	}
+	return
+}
+
 func (server *Server) cmdExpire(msg *Message) (res resp.Value, d commandDetails, err error) {
 	start := time.Now()
 	vs := msg.Args[1:]
@@ -923,6 +1317,11 @@ func (server *Server) cmdExpire(msg *Message) (res resp.Value, d commandDetails,
 		err = errInvalidNumberOfArguments
 		return
 	}
+	if len(vs) == 2 && lc(vs[0], "jitter") {
+		// a valid JITTER was replaced with the ttl, see jitterStamp
+		err = errInvalidArgument(vs[1])
+		return
+	}
 	if len(vs) != 0 {
 		err = errInvalidNumberOfArguments
 		return
@@ -1022,6 +1421,13 @@ func (server *Server) cmdTTL(msg *Message) (res resp.Value, err error) {
 		err = errInvalidNumberOfArguments
 		return
 	}
+	var name string
+	if len(vs) > 0 {
+		if vs, name, ok = tokenval(vs); !ok || name == "" {
+			err = errInvalidNumberOfArguments
+			return
+		}
+	}
 	if len(vs) != 0 {
 		err = errInvalidNumberOfArguments
 		return
@@ -1035,7 +1441,11 @@ func (server *Server) cmdTTL(msg *Message) (res resp.Value, err error) {
 		ok = ok && !server.hasExpired(key, id)
 		if ok {
 			var at time.Time
-			at, ok2 = server.getExpires(key, id)
+			if name != "" {
+				at, ok2 = server.getFieldExpires(key, id, name)
+			} else {
+				at, ok2 = server.getExpires(key, id)
+			}
 			if ok2 {
 				if time.Now().After(at) {
 					ok2 = false
@@ -0,0 +1,442 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/tidwall/tile38/core"
+	"github.com/tidwall/tile38/internal/log"
+)
+
+// An encrypted file starts with a header, which is followed by frames of
+// AES-GCM sealed data. Each frame is:
+//
+//	plaintext length (uint32) | nonce | ciphertext and tag
+//
+// The plaintext offset of the frame is used as additional data, which binds
+// every frame to its position in the file.
+const cryptMagic = "TILE38ENC"
+const cryptVersion = 1
+const cryptHeaderSize = len(cryptMagic) + 1
+
+var errEncryptionKeyRequired = errors.New("file is encrypted, an encryption key is required")
+
+// loadEncryptionKey returns the cipher used for encrypting the aof and
+// snapshot files, or nil when encryption is not configured. The key is read
+// from the --encryptkeyfile file, or from the T38ENCRYPTIONKEY
+// environment variable, and must be a hex or base64 encoded AES key.
+func loadEncryptionKey() (cipher.AEAD, error) {
+	var key string
+	if core.EncryptionKeyFile != "" {
+		data, err := ioutil.ReadFile(core.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = string(data)
+	} else {
+		key = os.Getenv("T38ENCRYPTIONKEY")
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := hex.DecodeString(key)
+	if err != nil {
+		raw, err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, errors.New("encryption key must be hex or base64 encoded")
+		}
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cryptFile is a file that is transparently encrypted when a cipher is
+// provided. All offsets and sizes are of the plaintext, which allows for
+// using the same aof positions for encrypted and unencrypted files.
+type cryptFile struct {
+	f    *os.File
+	aead cipher.AEAD // nil for plaintext files
+
+	pos  int64 // read position
+	fend int64 // file offset following the last known frame
+	pend int64 // plaintext size of the known frames
+
+	// the last frame that was decrypted
+	fstart int64
+	pstart int64
+	data   []byte
+	cached bool
+}
+
+// openCryptFile opens the named file. New and empty files are encrypted
+// when a cipher is provided, otherwise an existing plaintext file is opened
+// as plaintext, and the caller may check Encrypted.
+func openCryptFile(name string, flag int, aead cipher.AEAD) (*cryptFile, error) {
+	f, err := os.OpenFile(name, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	cf := &cryptFile{f: f}
+	hdr := make([]byte, cryptHeaderSize)
+	n, err := f.ReadAt(hdr, 0)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, err
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	switch {
+	case n >= len(cryptMagic) && string(hdr[:len(cryptMagic)]) == cryptMagic:
+		if aead == nil {
+			f.Close()
+			return nil, errEncryptionKeyRequired
+		}
+		if n < cryptHeaderSize || hdr[len(cryptMagic)] != cryptVersion {
+			f.Close()
+			return nil, errors.New("unsupported encrypted file version")
+		}
+	case n == 0 && aead != nil && writable:
+		hdr = append(hdr[:0], cryptMagic...)
+		hdr = append(hdr, cryptVersion)
+		if _, err := f.WriteAt(hdr, 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+	default:
+		return cf, nil
+	}
+	cf.aead = aead
+	cf.fend = int64(cryptHeaderSize)
+	torn, err := cf.scan()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if torn && writable {
+		// A frame was partially written, such as from a crash during a
+		// write. Remove it so that new frames can be appended.
+		log.Warnf("Truncating incomplete frame from %s", name)
+		if err := f.Truncate(cf.fend); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return cf, nil
+}
+
+// Encrypted returns true when the file is encrypted.
+func (cf *cryptFile) Encrypted() bool {
+	return cf.aead != nil
+}
+
+// Name returns the name of the file.
+func (cf *cryptFile) Name() string {
+	return cf.f.Name()
+}
+
+// Sync commits the file to stable storage.
+func (cf *cryptFile) Sync() error {
+	return cf.f.Sync()
+}
+
+// Close closes the file.
+func (cf *cryptFile) Close() error {
+	return cf.f.Close()
+}
+
+// Size returns the plaintext size of the file.
+func (cf *cryptFile) Size() (int64, error) {
+	if cf.aead == nil {
+		fi, err := cf.f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+	if _, err := cf.scan(); err != nil {
+		return 0, err
+	}
+	return cf.pend, nil
+}
+
+func (cf *cryptFile) frameSize(plen int) int64 {
+	return int64(4 + cf.aead.NonceSize() + plen + cf.aead.Overhead())
+}
+
+// scan picks up any frames that were appended since the last scan. Returns
+// true when there's an incomplete frame at the end of the file.
+func (cf *cryptFile) scan() (torn bool, err error) {
+	fi, err := cf.f.Stat()
+	if err != nil {
+		return false, err
+	}
+	var hdr [4]byte
+	for cf.fend < fi.Size() {
+		if fi.Size()-cf.fend < 4 {
+			return true, nil
+		}
+		if _, err := cf.f.ReadAt(hdr[:], cf.fend); err != nil {
+			return false, err
+		}
+		plen := int(binary.LittleEndian.Uint32(hdr[:]))
+		if cf.fend+cf.frameSize(plen) > fi.Size() {
+			return true, nil
+		}
+		cf.fend += cf.frameSize(plen)
+		cf.pend += int64(plen)
+	}
+	return false, nil
+}
+
+// load decrypts the frame that contains the plaintext offset.
+func (cf *cryptFile) load(off int64) error {
+	if cf.cached && off >= cf.pstart && off < cf.pstart+int64(len(cf.data)) {
+		return nil
+	}
+	if off >= cf.pend {
+		if _, err := cf.scan(); err != nil {
+			return err
+		}
+		if off >= cf.pend {
+			return io.EOF
+		}
+	}
+	fstart, pstart := int64(cryptHeaderSize), int64(0)
+	if cf.cached && off >= cf.pstart {
+		fstart = cf.fstart + cf.frameSize(len(cf.data))
+		pstart = cf.pstart + int64(len(cf.data))
+	}
+	var hdr [4]byte
+	for {
+		if _, err := cf.f.ReadAt(hdr[:], fstart); err != nil {
+			return err
+		}
+		plen := int(binary.LittleEndian.Uint32(hdr[:]))
+		if off < pstart+int64(plen) {
+			frame := make([]byte, cf.frameSize(plen))
+			if _, err := cf.f.ReadAt(frame, fstart); err != nil {
+				return err
+			}
+			ns := cf.aead.NonceSize()
+			var aad [8]byte
+			binary.LittleEndian.PutUint64(aad[:], uint64(pstart))
+			data, err := cf.aead.Open(cf.data[:0], frame[4:4+ns], frame[4+ns:],
+				aad[:])
+			if err != nil {
+				cf.cached = false
+				return err
+			}
+			cf.fstart, cf.pstart, cf.data, cf.cached = fstart, pstart, data, true
+			return nil
+		}
+		fstart += cf.frameSize(plen)
+		pstart += int64(plen)
+	}
+}
+
+// ReadAt reads from the plaintext offset.
+func (cf *cryptFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if cf.aead == nil {
+		return cf.f.ReadAt(p, off)
+	}
+	for n < len(p) {
+		if err := cf.load(off + int64(n)); err != nil {
+			return n, err
+		}
+		n += copy(p[n:], cf.data[off+int64(n)-cf.pstart:])
+	}
+	return n, nil
+}
+
+// Read reads from the current plaintext position.
+func (cf *cryptFile) Read(p []byte) (n int, err error) {
+	if cf.aead == nil {
+		return cf.f.Read(p)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := cf.load(cf.pos); err != nil {
+		return 0, err
+	}
+	n = copy(p, cf.data[cf.pos-cf.pstart:])
+	cf.pos += int64(n)
+	return n, nil
+}
+
+// Seek sets the plaintext position for the next Read.
+func (cf *cryptFile) Seek(offset int64, whence int) (int64, error) {
+	if cf.aead == nil {
+		return cf.f.Seek(offset, whence)
+	}
+	switch whence {
+	case 0:
+		cf.pos = offset
+	case 1:
+		cf.pos += offset
+	case 2:
+		if _, err := cf.scan(); err != nil {
+			return 0, err
+		}
+		cf.pos = cf.pend + offset
+	}
+	if cf.pos < 0 {
+		cf.pos = 0
+		return 0, errors.New("invalid seek")
+	}
+	return cf.pos, nil
+}
+
+// Write appends the data to the end of an encrypted file as a single frame.
+// Plaintext files are written at the current position.
+func (cf *cryptFile) Write(p []byte) (int, error) {
+	if cf.aead == nil {
+		return cf.f.Write(p)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	frame, err := sealFrame(cf.aead, cf.pend, p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := cf.f.WriteAt(frame, cf.fend); err != nil {
+		return 0, err
+	}
+	cf.fend += int64(len(frame))
+	cf.pend += int64(len(p))
+	return len(p), nil
+}
+
+// sealFrame returns an encrypted frame for the plaintext at offset.
+func sealFrame(aead cipher.AEAD, offset int64, p []byte) ([]byte, error) {
+	ns := aead.NonceSize()
+	frame := make([]byte, 4+ns, 4+ns+len(p)+aead.Overhead())
+	binary.LittleEndian.PutUint32(frame, uint32(len(p)))
+	if _, err := rand.Read(frame[4:]); err != nil {
+		return nil, err
+	}
+	var aad [8]byte
+	binary.LittleEndian.PutUint64(aad[:], uint64(offset))
+	return aead.Seal(frame, frame[4:], p, aad[:]), nil
+}
+
+// cryptWriter encrypts a stream into the same format as an encrypted
+// cryptFile, such as for uploading a snapshot.
+type cryptWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	pend int64
+	hdr  bool
+}
+
+func (cw *cryptWriter) Write(p []byte) (int, error) {
+	if !cw.hdr {
+		hdr := append([]byte(cryptMagic), cryptVersion)
+		if _, err := cw.w.Write(hdr); err != nil {
+			return 0, err
+		}
+		cw.hdr = true
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	frame, err := sealFrame(cw.aead, cw.pend, p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := cw.w.Write(frame); err != nil {
+		return 0, err
+	}
+	cw.pend += int64(len(p))
+	return len(p), nil
+}
+
+// Truncate changes the plaintext size of the file.
+func (cf *cryptFile) Truncate(size int64) error {
+	if cf.aead == nil {
+		return cf.f.Truncate(size)
+	}
+	if _, err := cf.scan(); err != nil {
+		return err
+	}
+	if size >= cf.pend {
+		return nil
+	}
+	var keep []byte
+	if err := cf.load(size); err != nil {
+		return err
+	}
+	keep = append(keep, cf.data[:size-cf.pstart]...)
+	if err := cf.f.Truncate(cf.fstart); err != nil {
+		return err
+	}
+	cf.fend, cf.pend, cf.cached = cf.fstart, cf.pstart, false
+	if cf.pos > size {
+		cf.pos = size
+	}
+	_, err := cf.Write(keep)
+	return err
+}
+
+// openAOF opens the aof, and encrypts an existing plaintext aof when an
+// encryption key is configured.
+func (s *Server) openAOF(name string) (*cryptFile, error) {
+	cf, err := openCryptFile(name, os.O_CREATE|os.O_RDWR, s.aead)
+	if err != nil {
+		return nil, err
+	}
+	if s.aead == nil || cf.Encrypted() {
+		return cf, nil
+	}
+	log.Warnf("Encrypting %s", name)
+	err = func() error {
+		defer cf.Close()
+		ef, err := openCryptFile(name+"-encrypt",
+			os.O_CREATE|os.O_RDWR|os.O_TRUNC, s.aead)
+		if err != nil {
+			return err
+		}
+		defer ef.Close()
+		buf := make([]byte, maxchunk)
+		for {
+			n, err := io.ReadFull(cf, buf)
+			if n > 0 {
+				if _, err := ef.Write(buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err := ef.Sync(); err != nil {
+			return err
+		}
+		return os.Rename(name+"-encrypt", name)
+	}()
+	if err != nil {
+		return nil, err
+	}
+	return openCryptFile(name, os.O_CREATE|os.O_RDWR, s.aead)
+}
+
+// openAOFReader opens a separate read-only handle to the aof.
+func (s *Server) openAOFReader() (*cryptFile, error) {
+	return openCryptFile(s.aof.Name(), os.O_RDONLY, s.aead)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testAEAD(t *testing.T, key byte) cipher.AEAD {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{key}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func TestCryptFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "appendonly.aof")
+	aead := testAEAD(t, 1)
+
+	cf, err := openCryptFile(name, os.O_CREATE|os.O_RDWR, aead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain []byte
+	for _, s := range []string{"hello ", "world, ", "this is ", "encrypted"} {
+		if _, err := cf.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		plain = append(plain, s...)
+	}
+	cf.Close()
+
+	raw, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("world")) {
+		t.Fatal("expected ciphertext")
+	}
+	if _, err := openCryptFile(name, os.O_RDONLY, nil); err != errEncryptionKeyRequired {
+		t.Fatalf("expected '%v', got '%v'", errEncryptionKeyRequired, err)
+	}
+	if _, err := openCryptFile(name, os.O_RDONLY, testAEAD(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err = openCryptFile(name, os.O_RDWR, aead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(cf)
+	if err != nil || !bytes.Equal(data, plain) {
+		t.Fatalf("expected '%s', got '%s' (%v)", plain, data, err)
+	}
+	buf := make([]byte, 10)
+	if _, err := cf.ReadAt(buf, 3); err != nil || string(buf) != string(plain[3:13]) {
+		t.Fatalf("expected '%s', got '%s' (%v)", plain[3:13], buf, err)
+	}
+	if _, err := cf.ReadAt(buf, int64(len(plain))-5); err != io.EOF {
+		t.Fatalf("expected EOF, got '%v'", err)
+	}
+
+	// truncate within a frame
+	if err := cf.Truncate(9); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cf.Write([]byte("!")); err != nil {
+		t.Fatal(err)
+	}
+	cf.Close()
+
+	// a torn frame at the end is removed on open
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{100, 0, 0, 0, 1, 2, 3})
+	f.Close()
+	cf, err = openCryptFile(name, os.O_RDWR, aead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := cf.Size(); err != nil || n != 10 {
+		t.Fatalf("expected 10, got %d (%v)", n, err)
+	}
+	data, err = ioutil.ReadAll(cf)
+	if err != nil || string(data) != "hello wor!" {
+		t.Fatalf("expected 'hello wor!', got '%s' (%v)", data, err)
+	}
+	cf.Close()
+
+	// a frame that was tampered with fails to decrypt
+	raw, _ = ioutil.ReadFile(name)
+	raw[len(raw)-1]++
+	ioutil.WriteFile(name, raw, 0600)
+	cf, err = openCryptFile(name, os.O_RDONLY, aead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(cf); err == nil {
+		t.Fatal("expected error")
+	}
+	cf.Close()
+}
+
+func TestCryptFileEncryptAOF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "appendonly.aof")
+	plain := []byte("*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\nb\r\n")
+	if err := ioutil.WriteFile(name, plain, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{aead: testAEAD(t, 1)}
+	cf, err := s.openAOF(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cf.Close()
+	if !cf.Encrypted() {
+		t.Fatal("expected encrypted aof")
+	}
+	data, err := ioutil.ReadAll(cf)
+	if err != nil || !bytes.Equal(data, plain) {
+		t.Fatalf("expected '%s', got '%s' (%v)", plain, data, err)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/gjson"
+)
+
+// defaultCSVColumns are the columns of OUTPUT csv without FIELDS.
+var defaultCSVColumns = []string{"id", "lat", "lon"}
+
+// csvOfJSON returns the CSV of the objects of a json reply, which is a
+// header of the columns and a row for each object. The columns are id, lat,
+// lon, z, distance, and the names of the fields. The objects are the objects
+// or ids of a search, the object of GET, or the object of a geofence event,
+// which is only the row so that the events are a stream of rows. A reply
+// without objects, such as an error, is not changed.
+func csvOfJSON(data []byte, columns []string) []byte {
+	res := gjson.ParseBytes(data)
+	var rows [][]string
+	switch {
+	case res.Get("objects").Exists():
+		names := res.Get("fields").Array()
+		res.Get("objects").ForEach(func(_, o gjson.Result) bool {
+			fields := make(map[string]gjson.Result)
+			for i, value := range o.Get("fields").Array() {
+				if i < len(names) {
+					fields[names[i].String()] = value
+				}
+			}
+			rows = append(rows, csvRow(o, fields, columns))
+			return true
+		})
+	case res.Get("ids").Exists():
+		res.Get("ids").ForEach(func(_, id gjson.Result) bool {
+			o := gjson.Parse(`{"id":` + id.Raw + `}`)
+			rows = append(rows, csvRow(o, nil, columns))
+			return true
+		})
+	case res.Get("object").Exists(), res.Get("point").Exists():
+		// GET, or a geofence event, where the fields are an object
+		fields := make(map[string]gjson.Result)
+		res.Get("fields").ForEach(func(name, value gjson.Result) bool {
+			fields[name.String()] = value
+			return true
+		})
+		rows = append(rows, csvRow(res, fields, columns))
+	default:
+		return data
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if !res.Get("detect").Exists() {
+		w.Write(columns)
+	}
+	w.WriteAll(rows)
+	return buf.Bytes()
+}
+
+// csvRow returns the row of an object.
+func csvRow(o gjson.Result, fields map[string]gjson.Result, columns []string,
+) []string {
+	var lat, lon, z string
+	if plat, plon, pz, ok := objectPoint(o); ok {
+		lat = strconv.FormatFloat(plat, 'f', -1, 64)
+		lon = strconv.FormatFloat(plon, 'f', -1, 64)
+		if pz != 0 {
+			z = strconv.FormatFloat(pz, 'f', -1, 64)
+		}
+	}
+	row := make([]string, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "id":
+			row[i] = o.Get("id").String()
+		case "lat":
+			row[i] = lat
+		case "lon":
+			row[i] = lon
+		case "z":
+			row[i] = z
+		case "distance":
+			row[i] = o.Get("distance").Raw
+		default:
+			row[i] = fieldText(fields[column])
+		}
+	}
+	return row
+}
+
+// objectPoint returns the point of the json of an object, which is the
+// point of POINTS, the coordinates of a GeoJSON Point, or the center of the
+// other objects. It is false for an object without a point, such as a
+// string.
+func objectPoint(o gjson.Result) (lat, lon, z float64, ok bool) {
+	if point := o.Get("point"); point.Exists() {
+		return point.Get("lat").Float(), point.Get("lon").Float(),
+			point.Get("z").Float(), true
+	}
+	object := o.Get("object")
+	if !object.IsObject() {
+		return 0, 0, 0, false
+	}
+	if object.Get("type").String() == "Point" {
+		coords := object.Get("coordinates").Array()
+		if len(coords) < 2 {
+			return 0, 0, 0, false
+		}
+		if len(coords) >= 3 {
+			z = coords[2].Float()
+		}
+		return coords[1].Float(), coords[0].Float(), z, true
+	}
+	g, err := geojson.Parse(object.Raw, nil)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	center := g.Center()
+	return center.Y, center.X, 0, true
+}
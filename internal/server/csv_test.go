@@ -0,0 +1,30 @@
+package server
+
+import "testing"
+
+func TestCSVOfJSON(t *testing.T) {
+	columns := []string{"id", "lat", "lon", "speed", "name"}
+	for _, tc := range []struct {
+		json, csv string
+	}{
+		{`{"ok":true,"fields":["speed"],"objects":[` +
+			`{"id":"truck1","object":{"type":"Point","coordinates":[-115,33]},"fields":[90]},` +
+			`{"id":"truck2","object":{"type":"Polygon","coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]},"fields":[0]}` +
+			`],"count":2,"cursor":0}`,
+			"id,lat,lon,speed,name\ntruck1,33,-115,90,\ntruck2,1,1,0,\n"},
+		{`{"ok":true,"ids":["truck1","a,b"],"count":2}`,
+			"id,lat,lon,speed,name\ntruck1,,,,\n\"a,b\",,,,\n"},
+		{`{"ok":true,"point":{"lat":33,"lon":-115}}`,
+			"id,lat,lon,speed,name\n,33,-115,,\n"},
+		{`{"command":"set","detect":"inside","id":"truck1",` +
+			`"object":{"type":"Point","coordinates":[-115,33]},` +
+			`"fields":{"speed":90,"name":"Bob"}}`,
+			"truck1,33,-115,90,Bob\n"},
+		{`{"ok":false,"err":"key not found"}`,
+			`{"ok":false,"err":"key not found"}`},
+	} {
+		if s := string(csvOfJSON([]byte(tc.json), columns)); s != tc.csv {
+			t.Fatalf("%s: expected %q, got %q", tc.json, tc.csv, s)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"math"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geo"
+	"github.com/tidwall/geojson/geometry"
+)
+
+// distModel is how the distances of a search are measured, which is set
+// with the MODEL keyword.
+type distModel uint8
+
+const (
+	// modelSpherical measures the great-circle distance on a sphere, which
+	// is the default.
+	modelSpherical distModel = iota
+	// modelGeodesic measures the shortest distance on the WGS84 ellipsoid.
+	modelGeodesic
+	// modelPlanar measures the straight line distance of projected
+	// coordinates, which is in the units of the coordinates.
+	modelPlanar
+)
+
+// modelCircleSteps is the number of points of the circle of a geodesic or
+// planar search, which is more than the default so that the polygon is
+// within 0.01% of the radius.
+const modelCircleSteps = 256
+
+// the WGS84 ellipsoid
+const (
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+	wgs84B = wgs84A * (1 - wgs84F)
+)
+
+func parseDistModel(s string) (distModel, bool) {
+	switch s {
+	case "spherical":
+		return modelSpherical, true
+	case "geodesic":
+		return modelGeodesic, true
+	case "planar":
+		return modelPlanar, true
+	}
+	return 0, false
+}
+
+// distance returns the distance between two points.
+func (m distModel) distance(a, b geometry.Point) float64 {
+	switch m {
+	case modelGeodesic:
+		return geodesicDistance(a.Y, a.X, b.Y, b.X)
+	case modelPlanar:
+		return math.Hypot(b.X-a.X, b.Y-a.Y)
+	}
+	return geo.DistanceTo(a.Y, a.X, b.Y, b.X)
+}
+
+// areaDistance returns the distance between a point and the nearest point
+// of an area, which is zero for a point in the area.
+func (m distModel) areaDistance(area geojson.Object, p geometry.Point) float64 {
+	switch area := area.(type) {
+	case *geojson.Point:
+		return m.distance(p, area.Base())
+	case *geojson.SimplePoint:
+		return m.distance(p, area.Base())
+	case *geojson.Circle:
+		return math.Max(0, m.distance(p, area.Center())-area.Meters())
+	case *geojson.Rect:
+		if area.Base().ContainsPoint(p) {
+			return 0
+		}
+		return m.segmentsDistance(area.Base(), p)
+	case *geojson.LineString:
+		return m.segmentsDistance(area.Base(), p)
+	case *geojson.Polygon:
+		poly := area.Base()
+		if poly.ContainsPoint(p) {
+			return 0
+		}
+		dist := m.segmentsDistance(poly.Exterior, p)
+		for _, hole := range poly.Holes {
+			dist = math.Min(dist, m.segmentsDistance(hole, p))
+		}
+		return dist
+	case *geojson.Feature:
+		return m.areaDistance(area.Base(), p)
+	case interface{ Base() []geojson.Object }:
+		// a multi geometry or a collection
+		dist := math.Inf(1)
+		for _, child := range area.Base() {
+			dist = math.Min(dist, m.areaDistance(child, p))
+		}
+		return dist
+	}
+	return m.distance(p, area.Center())
+}
+
+// segments are the segments of a line, a ring, or a rectangle.
+type segments interface {
+	NumSegments() int
+	SegmentAt(index int) geometry.Segment
+}
+
+// segmentsDistance returns the distance between a point and the nearest
+// segment. The nearest point of a segment is found in a projection that is
+// local to the point, which is close enough for the segments of a route or
+// a region.
+func (m distModel) segmentsDistance(series segments, p geometry.Point) float64 {
+	kx := 1.0
+	if m != modelPlanar {
+		kx = math.Cos(p.Y * math.Pi / 180)
+	}
+	dist := math.Inf(1)
+	for i := 0; i < series.NumSegments(); i++ {
+		seg := series.SegmentAt(i)
+		ax, ay := (seg.A.X-p.X)*kx, seg.A.Y-p.Y
+		dx, dy := (seg.B.X-seg.A.X)*kx, seg.B.Y-seg.A.Y
+		var t float64
+		if l := dx*dx + dy*dy; l > 0 {
+			t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+		}
+		q := geometry.Point{
+			X: seg.A.X + t*(seg.B.X-seg.A.X),
+			Y: seg.A.Y + t*(seg.B.Y-seg.A.Y),
+		}
+		dist = math.Min(dist, m.distance(p, q))
+	}
+	return dist
+}
+
+// circle returns the polygon of the points at the distance from the center.
+// The circle of the spherical model is a geojson.Circle.
+func (m distModel) circle(center geometry.Point, meters float64) geojson.Object {
+	if m == modelSpherical {
+		return geojson.NewCircle(center, meters, defaultCircleSteps)
+	}
+	if meters <= 0 {
+		return geojson.NewPoint(center)
+	}
+	points := make([]geometry.Point, 0, modelCircleSteps+1)
+	for i := 0; i < modelCircleSteps; i++ {
+		bearing := 360 * float64(i) / modelCircleSteps
+		if m == modelPlanar {
+			rad := bearing * math.Pi / 180
+			points = append(points, geometry.Point{
+				X: center.X + meters*math.Sin(rad),
+				Y: center.Y + meters*math.Cos(rad),
+			})
+		} else {
+			lat, lon := geodesicDestination(center.Y, center.X, meters, bearing)
+			points = append(points, geometry.Point{X: lon, Y: lat})
+		}
+	}
+	points = append(points, points[0])
+	return geojson.NewPolygon(geometry.NewPoly(points, nil, nil))
+}
+
+// geodesicDistance returns the distance in meters between two points on the
+// WGS84 ellipsoid, using the inverse formula of Vincenty. The formula does
+// not converge for some nearly antipodal points, and then the spherical
+// distance is returned.
+func geodesicDistance(latA, lonA, latB, lonB float64) float64 {
+	const rad = math.Pi / 180
+	L := (lonB - lonA) * rad
+	U1 := math.Atan((1 - wgs84F) * math.Tan(latA*rad))
+	U2 := math.Atan((1 - wgs84F) * math.Tan(latB*rad))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+	lambda := L
+	for i := 0; i < 200; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma := math.Hypot(cosU2*sinLambda,
+			cosU1*sinU2-sinU1*cosU2*cosLambda)
+		if sinSigma == 0 {
+			// the same point
+			return 0
+		}
+		cosSigma := sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma := math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cos2Alpha := 1 - sinAlpha*sinAlpha
+		var cos2SigmaM float64
+		if cos2Alpha != 0 {
+			// not on the equator
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		}
+		C := wgs84F / 16 * cos2Alpha * (4 + wgs84F*(4-3*cos2Alpha))
+		prev := lambda
+		lambda = L + (1-C)*wgs84F*sinAlpha*(sigma+C*sinSigma*
+			(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) > 1e-12 {
+			continue
+		}
+		u2 := cos2Alpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+		A := 1 + u2/16384*(4096+u2*(-768+u2*(320-175*u2)))
+		B := u2 / 1024 * (256 + u2*(-128+u2*(74-47*u2)))
+		deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*
+			(-1+2*cos2SigmaM*cos2SigmaM)-B/6*cos2SigmaM*
+			(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+		return wgs84B * A * (sigma - deltaSigma)
+	}
+	return geo.DistanceTo(latA, lonA, latB, lonB)
+}
+
+// geodesicDestination returns the point at the distance in meters and the
+// initial bearing in degrees from a point on the WGS84 ellipsoid, using the
+// direct formula of Vincenty.
+func geodesicDestination(lat, lon, meters, bearing float64) (float64, float64) {
+	const rad = math.Pi / 180
+	sinAlpha1, cosAlpha1 := math.Sincos(bearing * rad)
+	tanU1 := (1 - wgs84F) * math.Tan(lat*rad)
+	cosU1 := 1 / math.Sqrt(1+tanU1*tanU1)
+	sinU1 := tanU1 * cosU1
+	sigma1 := math.Atan2(tanU1, cosAlpha1)
+	sinAlpha := cosU1 * sinAlpha1
+	cos2Alpha := 1 - sinAlpha*sinAlpha
+	u2 := cos2Alpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+	A := 1 + u2/16384*(4096+u2*(-768+u2*(320-175*u2)))
+	B := u2 / 1024 * (256 + u2*(-128+u2*(74-47*u2)))
+	sigma := meters / (wgs84B * A)
+	var sinSigma, cosSigma, cos2SigmaM float64
+	for i := 0; i < 200; i++ {
+		cos2SigmaM = math.Cos(2*sigma1 + sigma)
+		sinSigma, cosSigma = math.Sincos(sigma)
+		deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*
+			(-1+2*cos2SigmaM*cos2SigmaM)-B/6*cos2SigmaM*
+			(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+		prev := sigma
+		sigma = meters/(wgs84B*A) + deltaSigma
+		if math.Abs(sigma-prev) <= 1e-12 {
+			break
+		}
+	}
+	sinSigma, cosSigma = math.Sincos(sigma)
+	x := sinU1*sinSigma - cosU1*cosSigma*cosAlpha1
+	lat2 := math.Atan2(sinU1*cosSigma+cosU1*sinSigma*cosAlpha1,
+		(1-wgs84F)*math.Hypot(sinAlpha, x))
+	lambda := math.Atan2(sinSigma*sinAlpha1,
+		cosU1*cosSigma-sinU1*sinSigma*cosAlpha1)
+	C := wgs84F / 16 * cos2Alpha * (4 + wgs84F*(4-3*cos2Alpha))
+	L := lambda - (1-C)*wgs84F*sinAlpha*(sigma+C*sinSigma*
+		(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+	lon2 := math.Mod(lon*rad+L+3*math.Pi, 2*math.Pi) - math.Pi
+	return lat2 / rad, lon2 / rad
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+)
+
+func TestGeodesicDistance(t *testing.T) {
+	// Flinders Peak to Buninyong
+	d := geodesicDistance(-37.95103341666667, 144.42486788888888,
+		-37.65282113888889, 143.92649552777777)
+	if math.Abs(d-54972.271) > 0.001 {
+		t.Fatalf("expected 54972.271, got %f", d)
+	}
+	if d := geodesicDistance(33, -115, 33, -115); d != 0 {
+		t.Fatalf("expected 0, got %f", d)
+	}
+	// along the equator
+	d = geodesicDistance(0, 0, 0, 1)
+	if math.Abs(d-111319.491) > 0.001 {
+		t.Fatalf("expected 111319.491, got %f", d)
+	}
+	// nearly antipodal points fall back to the sphere
+	d = geodesicDistance(0, 0, 0.5, 179.7)
+	if math.IsNaN(d) || d < 19e6 || d > 20.1e6 {
+		t.Fatalf("unexpected %f", d)
+	}
+}
+
+func TestGeodesicDestination(t *testing.T) {
+	for _, bearing := range []float64{0, 45, 90, 180, 270, 315} {
+		lat, lon := geodesicDestination(33, -115, 25000, bearing)
+		d := geodesicDistance(33, -115, lat, lon)
+		if math.Abs(d-25000) > 0.001 {
+			t.Fatalf("bearing %f: expected 25000, got %f", bearing, d)
+		}
+	}
+}
+
+func TestDistModel(t *testing.T) {
+	a := geometry.Point{X: 3, Y: 4}
+	b := geometry.Point{X: 6, Y: 8}
+	if d := modelPlanar.distance(a, b); d != 5 {
+		t.Fatalf("expected 5, got %f", d)
+	}
+	if _, ok := modelSpherical.circle(a, 100).(*geojson.Circle); !ok {
+		t.Fatal("expected a circle")
+	}
+	circle := modelPlanar.circle(geometry.Point{}, 10)
+	if !geojson.NewPoint(geometry.Point{X: 6, Y: 7.9}).Within(circle) {
+		t.Fatal("expected within")
+	}
+	if geojson.NewPoint(geometry.Point{X: 6, Y: 8.1}).Within(circle) {
+		t.Fatal("expected not within")
+	}
+	center := geometry.Point{X: -115, Y: 33}
+	circle = modelGeodesic.circle(center, 10000)
+	for _, bearing := range []float64{10, 100, 200, 300} {
+		lat, lon := geodesicDestination(33, -115, 9990, bearing)
+		if !geojson.NewPoint(geometry.Point{X: lon, Y: lat}).Within(circle) {
+			t.Fatal("expected within")
+		}
+		lat, lon = geodesicDestination(33, -115, 10010, bearing)
+		if geojson.NewPoint(geometry.Point{X: lon, Y: lat}).Within(circle) {
+			t.Fatal("expected not within")
+		}
+	}
+}
+
+func TestAreaDistance(t *testing.T) {
+	poly := geojson.NewPolygon(geometry.NewPoly([]geometry.Point{
+		{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}, {X: 0, Y: 10}, {X: 0, Y: 0},
+	}, nil, nil))
+	line := geojson.NewLineString(geometry.NewLine([]geometry.Point{
+		{X: 0, Y: 0}, {X: 10, Y: 0},
+	}, nil))
+	tests := []struct {
+		area geojson.Object
+		p    geometry.Point
+		dist float64
+	}{
+		{poly, geometry.Point{X: 5, Y: 5}, 0},
+		{poly, geometry.Point{X: 13, Y: 14}, 5},
+		{poly, geometry.Point{X: 5, Y: -2}, 2},
+		{line, geometry.Point{X: 5, Y: 3}, 3},
+		{line, geometry.Point{X: -3, Y: 4}, 5},
+		{geojson.NewRect(poly.Rect()), geometry.Point{X: 12, Y: 5}, 2},
+	}
+	for i, tt := range tests {
+		if d := modelPlanar.areaDistance(tt.area, tt.p); math.Abs(d-tt.dist) > 1e-9 {
+			t.Fatalf("%d: expected %f, got %f", i, tt.dist, d)
+		}
+	}
+	// about 1112 meters north of a route along the equator
+	d := modelSpherical.areaDistance(line, geometry.Point{X: 5, Y: 0.01})
+	if math.Abs(d-1111.95) > 0.01 {
+		t.Fatalf("expected 1111.95, got %f", d)
+	}
+}
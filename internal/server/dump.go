@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/field"
+	"github.com/tidwall/tile38/internal/glob"
+)
+
+// dumpSnapshot returns a point-in-time view of the collections whose keys
+// match one of the patterns, and the commands needed to recreate their
+// hooks, field expirations, schemas, limits, kept positions settings,
+// labels, and indexes. Every collection is used when there are no patterns,
+// see datasetSnapshot. The caller must hold the server lock.
+func (server *Server) dumpSnapshot(patterns []string) (cols []snapshotCol,
+	cmds [][]string,
+) {
+	if len(patterns) == 0 {
+		return server.datasetSnapshot()
+	}
+	var keys []string
+	server.scanGreaterOrEqual("", func(key string, col *collection.Collection) bool {
+		for _, pattern := range patterns {
+			if match, _ := glob.Match(pattern, key); match {
+				cols = append(cols, server.snapshotCol(key, col))
+				keys = append(keys, key)
+				break
+			}
+		}
+		return true
+	})
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	selected := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		selected[key] = true
+	}
+	var hnames []string
+	for name, hook := range server.hooks {
+		if selected[hook.Key] {
+			hnames = append(hnames, name)
+		}
+	}
+	sort.Strings(hnames)
+	cmds = append(server.hookCommandsFor(hnames),
+		server.fieldExpireCommands(keys)...)
+	cmds = append(cmds, server.schemaCommands(keys)...)
+	cmds = append(cmds, server.limitsCommands(keys)...)
+	cmds = append(cmds, server.historyCommands(keys)...)
+	cmds = append(cmds, server.labelCommands(keys)...)
+	return cols, append(cmds, server.indexCommands(keys)...)
+}
+
+// writeDumpCommands writes the collections and the commands as the commands
+// of an aof, which are replayed by a server that loads the file as its aof,
+// or by a client that pipes it to a server.
+func writeDumpCommands(w io.Writer, cols []snapshotCol, cmds [][]string,
+) (count int, err error) {
+	bw := bufio.NewWriter(w)
+	var values []string
+	var buf []byte
+	now := time.Now().UnixNano()
+	for _, scol := range cols {
+		fnames, fmap := scol.snap.FieldArr(), scol.snap.FieldMap()
+		scol.snap.Scan(false, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
+			values = setCommand(values[:0], scol, id, obj, fields, fmap,
+				fnames, now)
+			buf = appendAOFCommand(buf[:0], values)
+			if _, err = bw.Write(buf); err != nil {
+				return false
+			}
+			count++
+			return true
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, values := range cmds {
+		buf = appendAOFCommand(buf[:0], values)
+		if _, err := bw.Write(buf); err != nil {
+			return 0, err
+		}
+	}
+	return count, bw.Flush()
+}
+
+// DUMP path [MATCH pattern ...] [COMMANDS|SNAPSHOT]
+//
+// Writes a consistent dump of the collections whose keys match the
+// patterns, or of every collection, to a file or to the object of a url,
+// such as s3://bucket/prefix/fleet.aof. The collections are copied on write
+// while the server lock is briefly held, so the writes that follow go on
+// while the dump is written, and are not in the dump. The COMMANDS format,
+// which is the default, is the commands of an aof. The SNAPSHOT format is
+// the format of the snapshot file, see SAVE. Returns the number of objects.
+func (server *Server) cmdDump(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var path string
+	var ok bool
+	if vs, path, ok = tokenval(vs); !ok || path == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	var patterns []string
+	var format string
+	for len(vs) > 0 {
+		var tok string
+		vs, tok, _ = tokenval(vs)
+		switch {
+		case lc(tok, "match"):
+			var pattern string
+			if vs, pattern, ok = tokenval(vs); !ok || pattern == "" {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			patterns = append(patterns, pattern)
+		case lc(tok, "commands"), lc(tok, "snapshot"):
+			if format != "" {
+				return NOMessage, errDuplicateArgument(strings.ToUpper(tok))
+			}
+			format = strings.ToLower(tok)
+		default:
+			return NOMessage, errInvalidArgument(tok)
+		}
+	}
+
+	server.mu.Lock()
+	cols, cmds := server.dumpSnapshot(patterns)
+	server.mu.Unlock()
+
+	write := func(w io.Writer) (int, error) {
+		if format != "snapshot" {
+			return writeDumpCommands(w, cols, cmds)
+		}
+		hdr := snapshotHeader{created: start.UnixNano()}
+		if err := encodeSnapshot(w, cols, cmds, hdr); err != nil {
+			return 0, err
+		}
+		var count int
+		for _, scol := range cols {
+			count += scol.snap.Count()
+		}
+		return count, nil
+	}
+	var count int
+	var err error
+	if lower := strings.ToLower(path); strings.HasPrefix(lower, "s3://") ||
+		strings.HasPrefix(lower, "gs://") {
+		count, err = uploadExport(path, write)
+	} else {
+		count, err = writeExport(server.resolveDataPath(path), write)
+	}
+	if err != nil {
+		return NOMessage, err
+	}
+	switch msg.OutputType {
+	case JSON:
+		return resp.StringValue(`{"ok":true,"count":` + strconv.Itoa(count) +
+			`,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+	case RESP:
+		return resp.IntegerValue(count), nil
+	}
+	return NOMessage, nil
+}
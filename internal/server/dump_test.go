@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/tile38/internal/collection"
+)
+
+func TestDump(t *testing.T) {
+	s := newSnapshotTestServer()
+	point := func(x, y float64) geojson.Object {
+		return geojson.NewPoint(geometry.Point{X: x, Y: y})
+	}
+	fleet := collection.New()
+	fleet.Set("truck1", point(-112, 33), nil, nil)
+	fleet.Set("truck2", point(-113, 34), nil, nil)
+	s.setCol("fleet", fleet)
+	other := collection.New()
+	other.Set("bus", point(-114, 35), nil, nil)
+	s.setCol("other", other)
+
+	cols, cmds := s.dumpSnapshot([]string{"fl*"})
+	if len(cols) != 1 || cols[0].key != "fleet" {
+		t.Fatalf("expected the fleet collection, got %d collections", len(cols))
+	}
+	// the writes that follow the snapshot are not in the dump
+	fleet.Set("truck1", point(-100, 30), nil, nil)
+	fleet.Set("truck3", point(-100, 30), nil, nil)
+	fleet.Delete("truck2")
+
+	var buf bytes.Buffer
+	count, err := writeDumpCommands(&buf, cols, cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2, got %d", count)
+	}
+	var lines []string
+	err = readAOFCommands(&buf, func(args []string) error {
+		lines = append(lines, strings.Join(args, " "))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		`set fleet truck1 object {"type":"Point","coordinates":[-112,33]}`,
+		`set fleet truck2 object {"type":"Point","coordinates":[-113,34]}`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(expect, "\n") {
+		t.Fatalf("expected %q, got %q", expect, lines)
+	}
+
+	if cols, _ := s.dumpSnapshot([]string{"nope*"}); len(cols) != 0 {
+		t.Fatalf("expected no collections, got %d", len(cols))
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Open opens the database of a directory without the network server, which
+// is Tile38 embedded by the pkg/engine package. The options of the core
+// package, such as core.AppendOnly, are the options of Serve.
+func Open(dir string) (*Server, error) {
+	return open("", 0, dir, false)
+}
+
+// Close stops the background routines and closes the database.
+func (s *Server) Close() error {
+	s.followc.add(1)
+	s.peerc.add(1)
+	s.stopServer.set(true)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.close()
+	return nil
+}
+
+// Do runs a command and returns its json output. The error of a command is
+// the err of the output, and the error is only for a command that could not
+// run, such as a live geofence.
+func (s *Server) Do(args ...string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, errInvalidNumberOfArguments
+	}
+	client := new(Client)
+	client.id = int(atomic.AddInt64(&s.clientID, 1))
+	client.opened = time.Now()
+	client.last = client.opened
+	client.authd = true
+	return s.exec(client, &Message{
+		Args:       args,
+		ConnType:   Embedded,
+		OutputType: JSON,
+	})
+}
+
+// exec runs the command of a client that is not a connection, and returns
+// the output. The writes are flushed, and committed in raft mode, before it
+// returns, like the replies of the connections.
+func (s *Server) exec(client *Client, msg *Message) ([]byte, error) {
+	s.statsTotalCommands.add(1)
+	wrote := s.aofwrote.get()
+	if err := s.handleInputCommand(client, msg); err != nil {
+		return nil, err
+	}
+	if pos := s.aofwrote.get(); pos != wrote {
+		client.aofpos = pos
+	}
+	s.flushClientAOF(client)
+	if err := s.raftCommit(client); err != nil {
+		return nil, err
+	}
+	out := client.out
+	client.out = nil
+	return out, nil
+}
+
+// Subscribe calls fn with the messages of channels, which are the patterns
+// of PSUBSCRIBE when pattern is true, until the context is done or fn
+// returns an error.
+func (s *Server) Subscribe(ctx context.Context, channels []string,
+	pattern bool, fn func(channel, message string) error,
+) error {
+	if len(channels) == 0 {
+		return errInvalidNumberOfArguments
+	}
+	kind := pubsubChannel
+	if pattern {
+		kind = pubsubPattern
+	}
+	target := newSubtarget()
+	for _, channel := range channels {
+		s.pubsub.register(kind, channel, target)
+	}
+	defer func() {
+		for _, channel := range channels {
+			s.pubsub.unregister(kind, channel, target)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		target.cond.L.Lock()
+		target.closed = true
+		target.cond.Broadcast()
+		target.cond.L.Unlock()
+	}()
+	for {
+		target.cond.L.Lock()
+		for len(target.msgs) == 0 && !target.closed {
+			target.cond.Wait()
+		}
+		msgs, closed := target.msgs, target.closed
+		target.msgs = nil
+		target.cond.L.Unlock()
+		if closed {
+			return nil
+		}
+		for _, msg := range msgs {
+			if err := fn(msg.channel, msg.message); err != nil {
+				return err
+			}
+			s.statsTotalMsgsSent.add(1)
+		}
+	}
+}
+
+// SendEndpoint sends a message to an endpoint of a hook, such as an HTTP
+// url or a Kafka topic.
+func (s *Server) SendEndpoint(endpoint, msg string) error {
+	return s.epc.Send(endpoint, msg)
+}
+
+// ValidateEndpoint returns an error for an endpoint that is not valid.
+func (s *Server) ValidateEndpoint(endpoint string) error {
+	return s.epc.Validate(endpoint)
+}
@@ -1,7 +1,11 @@
 package server
 
 import (
+	"math"
 	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/tidwall/rhh"
@@ -14,7 +18,9 @@ func (s *Server) clearIDExpires(key, id string) (cleared bool) {
 		if idm, ok := s.expires.Get(key); ok {
 			if _, ok := idm.(*rhh.Map).Delete(id); ok {
 				if idm.(*rhh.Map).Len() == 0 {
+					s.expmu.Lock()
 					s.expires.Delete(key)
+					s.expmu.Unlock()
 				}
 				return true
 			}
@@ -23,16 +29,161 @@ func (s *Server) clearIDExpires(key, id string) (cleared bool) {
 	return false
 }
 
-// clearKeyExpires clears all items that are marked as expires from a single key.
+// clearKeyExpires clears all items that are marked as expires from a single
+// key, along with the expiring fields of the items.
 func (s *Server) clearKeyExpires(key string) {
+	s.expmu.Lock()
 	s.expires.Delete(key)
+	s.expmu.Unlock()
+	delete(s.fexpires, key)
 }
 
-// moveKeyExpires moves all items that are marked as expires from a key to a newKey.
+// moveKeyExpires moves all items that are marked as expires from a key to a
+// newKey, along with the expiring fields of the items.
 func (s *Server) moveKeyExpires(key, newKey string) {
+	s.expmu.Lock()
 	if idm, ok := s.expires.Delete(key); ok {
 		s.expires.Set(newKey, idm)
 	}
+	s.expmu.Unlock()
+	if fm, ok := s.fexpires[key]; ok {
+		delete(s.fexpires, key)
+		s.fexpires[newKey] = fm
+	}
+}
+
+// fieldExpiry is a field of an item that expires
+type fieldExpiry struct {
+	key, id, name string
+	at            time.Time
+}
+
+// Expires returns when the field expires
+func (fe *fieldExpiry) Expires() time.Time {
+	return fe.at
+}
+
+// expireFieldAt marks a field of an item as expires at a specific time.
+func (s *Server) expireFieldAt(key, id, name string, at time.Time) {
+	if s.fexpires[key] == nil {
+		s.fexpires[key] = make(map[string]map[string]int64)
+	}
+	if s.fexpires[key][id] == nil {
+		s.fexpires[key][id] = make(map[string]int64)
+	}
+	s.fexpires[key][id][name] = at.UnixNano()
+	s.fieldex.Push(&fieldExpiry{key: key, id: id, name: name, at: at})
+}
+
+// clearFieldExpires clears the expiration of the fields of an item.
+func (s *Server) clearFieldExpires(key, id string, names []string) {
+	fm := s.fexpires[key][id]
+	if fm == nil {
+		return
+	}
+	for _, name := range names {
+		delete(fm, name)
+	}
+	if len(fm) == 0 {
+		s.clearIDFieldExpires(key, id)
+	}
+}
+
+// clearIDFieldExpires clears the expiration of all of the fields of an item.
+func (s *Server) clearIDFieldExpires(key, id string) {
+	if im := s.fexpires[key]; im != nil {
+		delete(im, id)
+		if len(im) == 0 {
+			delete(s.fexpires, key)
+		}
+	}
+}
+
+// getFieldExpires returns when a field of an item expires.
+func (s *Server) getFieldExpires(key, id, name string) (at time.Time, ok bool) {
+	if atv, ok := s.fexpires[key][id][name]; ok {
+		return time.Unix(0, atv), true
+	}
+	return time.Time{}, false
+}
+
+// fieldExpireCommands returns the commands needed to recreate the field
+// expirations of the keys, or of every key when keys is nil. The caller must
+// hold the server lock.
+func (s *Server) fieldExpireCommands(keys []string) (cmds [][]string) {
+	if keys == nil {
+		for key := range s.fexpires {
+			keys = append(keys, key)
+		}
+		// sort the keys for consistency
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		var ids []string
+		for id := range s.fexpires[key] {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			cmds = append(cmds, s.idFieldExpireCommands(key, id)...)
+		}
+	}
+	return cmds
+}
+
+// idFieldExpireCommands returns an FSET with EX for each field of an item
+// that expires. The caller must hold the server lock.
+func (s *Server) idFieldExpireCommands(key, id string) (cmds [][]string) {
+	col := s.getCol(key)
+	if col == nil {
+		return nil
+	}
+	_, values, ok := col.Get(id)
+	if !ok {
+		return nil
+	}
+	fmap := col.FieldMap()
+	var names []string
+	for name := range s.fexpires[key][id] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now().UnixNano()
+	for _, name := range names {
+		idx, ok := fmap[name]
+		if !ok || idx >= len(values) || values[idx].IsZero() {
+			continue
+		}
+		// a field that is past due still expires as soon as it's loaded
+		secs := math.Max(0, math.Floor(float64(s.fexpires[key][id][name]-now)/
+			float64(time.Second)*10)/10)
+		cmds = append(cmds, []string{"fset", key, id, "ex",
+			strconv.FormatFloat(secs, 'f', -1, 64), name, values[idx].String()})
+	}
+	return cmds
+}
+
+// possiblyExpireField clears a field when it's still marked as expires at
+// the time of the expiry, which is not the case after the field was changed.
+func (s *Server) possiblyExpireField(fe *fieldExpiry) {
+	l := s.mu.lockKey(fe.key, true)
+	defer l.unlock()
+	if at, ok := s.getFieldExpires(fe.key, fe.id, fe.name); !ok ||
+		!at.Equal(fe.at) || time.Now().Before(at) {
+		return
+	}
+	msg := &Message{}
+	msg.Args = []string{"fset", fe.key, fe.id, fe.name, "0"}
+	_, d, err := s.cmdFset(msg)
+	if err != nil {
+		// the item is gone, or a schema requires the field
+		log.Errorf("%s: %v", strings.Join(msg.Args, " "), err)
+		s.clearFieldExpires(fe.key, fe.id, []string{fe.name})
+		return
+	}
+	if err := s.writeAOF(msg.Args, &d); err != nil {
+		log.Fatal(err)
+	}
 }
 
 // expireAt marks an item as expires at a specific time.
@@ -40,18 +191,23 @@ func (s *Server) expireAt(key, id string, at time.Time) {
 	idm, ok := s.expires.Get(key)
 	if !ok {
 		idm = rhh.New(0)
+		s.expmu.Lock()
 		s.expires.Set(key, idm)
+		s.expmu.Unlock()
 	}
 	idm.(*rhh.Map).Set(id, at.UnixNano())
 }
 
-// getExpires returns the when an item expires.
+// getExpires returns the when an item expires. It's called by the searches
+// that hold the lock of their key only, while the writes of other keys may
+// add and remove the keys of the expires.
 func (s *Server) getExpires(key, id string) (at time.Time, ok bool) {
-	if s.expires.Len() > 0 {
-		if idm, ok := s.expires.Get(key); ok {
-			if atv, ok := idm.(*rhh.Map).Get(id); ok {
-				return time.Unix(0, atv.(int64)), true
-			}
+	s.expmu.RLock()
+	idm, ok := s.expires.Get(key)
+	s.expmu.RUnlock()
+	if ok {
+		if atv, ok := idm.(*rhh.Map).Get(id); ok {
+			return time.Unix(0, atv.(int64)), true
 		}
 	}
 	return time.Time{}, false
@@ -65,59 +221,199 @@ func (s *Server) hasExpired(key, id string) bool {
 	return false
 }
 
-const bgExpireDelay = time.Second / 10
-const bgExpireSegmentSize = 20
-
 // expirePurgeSweep is ran from backgroundExpiring operation and performs
-// segmented sweep of the expires list
-func (s *Server) expirePurgeSweep(rng *rand.Rand) (purged int) {
+// segmented sweep of the expires list, of size samples. The samples are
+// taken with the read lock, and an item that expired is deleted with the
+// lock of its key, so that the sweep doesn't wait for the searches of other
+// keys.
+func (s *Server) expirePurgeSweep(rng *rand.Rand, size int) (purged int) {
 	now := time.Now().UnixNano()
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if s.expires.Len() == 0 {
-		return 0
-	}
-	for i := 0; i < bgExpireSegmentSize; i++ {
-		if key, idm, ok := s.expires.GetPos(rng.Uint64()); ok {
-			id, atv, ok := idm.(*rhh.Map).GetPos(rng.Uint64())
-			if ok {
-				if now > atv.(int64) {
-					// expired, purge from database
-					msg := &Message{}
-					msg.Args = []string{"del", key, id}
-					_, d, err := s.cmdDel(msg)
-					if err != nil {
-						log.Fatal(err)
-					}
-					if err := s.writeAOF(msg.Args, &d); err != nil {
-						log.Fatal(err)
-					}
-					purged++
-				}
+	for i := 0; i < size; i++ {
+		var key, id string
+		var expired bool
+		s.mu.RLock()
+		if s.expires.Len() == 0 {
+			s.mu.RUnlock()
+			break
+		}
+		if k, idm, ok := s.expires.GetPos(rng.Uint64()); ok {
+			if k2, atv, ok := idm.(*rhh.Map).GetPos(rng.Uint64()); ok {
+				key, id, expired = k, k2, now > atv.(int64)
 			}
 		}
-		// recycle the lock
-		s.mu.Unlock()
-		s.mu.Lock()
+		s.mu.RUnlock()
+		if expired && s.purgeExpired(key, id, now) {
+			purged++
+		}
 	}
 	return purged
 }
 
+// purgeExpired deletes an item that is still past due when its key is
+// locked.
+func (s *Server) purgeExpired(key, id string, now int64) bool {
+	l := s.mu.lockKey(key, true)
+	defer l.unlock()
+	if at, ok := s.getExpires(key, id); !ok || now <= at.UnixNano() {
+		return false
+	}
+	// expired, purge from database
+	msg := &Message{}
+	msg.Args = []string{"del", key, id}
+	_, d, err := s.cmdDel(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := s.writeAOF(msg.Args, &d); err != nil {
+		log.Fatal(err)
+	}
+	return true
+}
+
+// jitterArgs returns the arguments of an EXPIRE key id seconds JITTER
+// seconds, or of a SET with an EX seconds JITTER seconds, with a random part
+// of the JITTER, which is the fraction of 0 to 1, added to the ttl. The
+// arguments are returned as they are when there's no JITTER, or when it's
+// not valid, which is then an error of the command.
+func jitterArgs(args []string, fraction float64) []string {
+	i := -1
+	switch {
+	case lc(args[0], "expire"):
+		if len(args) == 6 && lc(args[4], "jitter") {
+			i = 3
+		}
+	case lc(args[0], "set"):
+		for j := 3; j+3 < len(args); j++ {
+			if lc(args[j], "ex") && lc(args[j+2], "jitter") {
+				i = j + 1
+				break
+			}
+		}
+	}
+	if i == -1 {
+		return args
+	}
+	ttl, err := strconv.ParseFloat(args[i], 64)
+	if err != nil {
+		return args
+	}
+	jitter, err := strconv.ParseFloat(args[i+2], 64)
+	if err != nil || jitter < 0 || math.IsInf(jitter, 0) {
+		return args
+	}
+	ttl = math.Round((ttl+jitter*fraction)*1000) / 1000
+	nargs := make([]string, 0, len(args)-2)
+	nargs = append(nargs, args[:i]...)
+	nargs = append(nargs, strconv.FormatFloat(ttl, 'f', -1, 64))
+	return append(nargs, args[i+3:]...)
+}
+
+// jitterStamp chooses the ttl of an EXPIRE or a SET with a JITTER, so that
+// the items that are set with the same ttl at once do not expire at once.
+// This happens before the write is applied, so that the ttl is kept in the
+// aof, and is the same on the followers and peers.
+func (s *Server) jitterStamp(msg *Message) *Message {
+	switch msg.Command() {
+	case "set", "expire":
+		args := jitterArgs(msg.Args, rand.Float64())
+		if len(args) != len(msg.Args) {
+			nmsg := *msg
+			nmsg.Args = args
+			return &nmsg
+		}
+	}
+	return msg
+}
+
+// expireLimiter paces the deletions of the expired items to the
+// expire-max-rate per second, so that the items that were set with the same
+// ttl at once are deleted over time, instead of in one long run of sweeps.
+type expireLimiter struct {
+	tokens float64 // the deletions that are allowed
+	last   time.Time
+}
+
+// allow returns the number of items that a sweep may delete, which is at
+// most size, and is zero when the sweep must wait.
+func (l *expireLimiter) allow(rate, size int, interval time.Duration,
+	now time.Time,
+) int {
+	if rate <= 0 {
+		return size
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+	}
+	l.last = now
+	// the deletions of at most one sweep are saved up
+	burst := math.Max(1, float64(rate)*interval.Seconds())
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+	if n := int(l.tokens); n < size {
+		return n
+	}
+	return size
+}
+
+// used takes the deleted items from the allowed deletions.
+func (l *expireLimiter) used(n int) {
+	l.tokens -= float64(n)
+}
+
+// observeExpireSweep records the time of a sweep.
+func (s *Server) observeExpireSweep(elapsed time.Duration) {
+	us := int(elapsed / time.Microsecond)
+	s.statsExpireSweeps.add(1)
+	s.statsExpireTime.add(us)
+	if us > s.statsExpireMax.get() {
+		// the sweeps are made by one goroutine
+		s.statsExpireMax.set(us)
+	}
+	s.latency.observe(latencyExpireCycle, elapsed)
+}
+
 // backgroundExpiring watches for when items that have expired must be purged
-// from the database. It's executes 10 times a seconds.
+// from the database. It's executes every expire-sweep-interval milliseconds,
+// which is 10 times a second by default. The sweeps delete at most
+// expire-max-rate items per second.
 func (s *Server) backgroundExpiring() {
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var purgedVersions time.Time
+	var limiter expireLimiter
 	for {
 		if s.stopServer.on() {
 			return
 		}
-		purged := s.expirePurgeSweep(rng)
-		if purged > bgExpireSegmentSize/4 {
+		if time.Since(purgedVersions) > time.Minute {
+			// forget the versions of objects that were deleted long ago
+			s.mu.Lock()
+			s.lww.purge()
+			s.mu.Unlock()
+			purgedVersions = time.Now()
+		}
+		interval, size := s.config.expireSweep()
+		start := time.Now()
+		n := limiter.allow(s.config.expireMaxRate(), size, interval, start)
+		if n == 0 {
+			s.mu.RLock()
+			pending := s.expires.Len() > 0
+			s.mu.RUnlock()
+			if pending {
+				s.statsExpireLimited.add(1)
+			}
+			time.Sleep(interval)
+			continue
+		}
+		purged := s.expirePurgeSweep(rng, n)
+		s.observeExpireSweep(time.Since(start))
+		limiter.used(purged)
+		if purged > n/4 {
 			// do another purge immediately
 			continue
 		} else {
 			// back off
-			time.Sleep(bgExpireDelay)
+			time.Sleep(interval)
 		}
 	}
 }
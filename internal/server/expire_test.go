@@ -0,0 +1,64 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJitterArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args     string
+		fraction float64
+		expect   string
+	}{
+		{"expire fleet truck1 60 JITTER 30", 0.5, "expire fleet truck1 75"},
+		{"expire fleet truck1 60 jitter 0.001", 0.25, "expire fleet truck1 60"},
+		{"expire fleet truck1 60", 0.5, "expire fleet truck1 60"},
+		{"expire fleet truck1 60 JITTER -1", 0.5, "expire fleet truck1 60 JITTER -1"},
+		{"set fleet truck1 FIELD speed 10 EX 10 JITTER 10 POINT 33 -115", 0.1,
+			"set fleet truck1 FIELD speed 10 EX 11 POINT 33 -115"},
+		{"set fleet truck1 EX 10 POINT 33 -115", 0.1,
+			"set fleet truck1 EX 10 POINT 33 -115"},
+		{"set fleet truck1 JITTER 10 POINT 33 -115", 0.1,
+			"set fleet truck1 JITTER 10 POINT 33 -115"},
+		{"fset fleet truck1 EX 10 JITTER 10 speed 1", 0.1,
+			"fset fleet truck1 EX 10 JITTER 10 speed 1"},
+	} {
+		args := jitterArgs(strings.Split(tc.args, " "), tc.fraction)
+		if strings.Join(args, " ") != tc.expect {
+			t.Fatalf("expected '%s', got '%s'", tc.expect, strings.Join(args, " "))
+		}
+	}
+}
+
+func TestExpireLimiter(t *testing.T) {
+	var l expireLimiter
+	start := time.Now()
+	interval := 100 * time.Millisecond
+	if n := l.allow(0, 20, interval, start); n != 20 {
+		t.Fatalf("expected no limit, got %d", n)
+	}
+	// 100 per second is 10 per sweep, which are saved up once the first
+	// sweep was made
+	if n := l.allow(100, 20, interval, start); n != 0 {
+		t.Fatalf("expected 0, got %d", n)
+	}
+	if n := l.allow(100, 20, interval, start.Add(50*time.Millisecond)); n != 5 {
+		t.Fatalf("expected 5, got %d", n)
+	}
+	l.used(5)
+	if n := l.allow(100, 20, interval, start.Add(time.Second)); n != 10 {
+		t.Fatalf("expected 10, got %d", n)
+	}
+	l.used(10)
+	if n := l.allow(100, 20, interval, start.Add(time.Second)); n != 0 {
+		t.Fatalf("expected 0, got %d", n)
+	}
+	// a rate under one per sweep still deletes one at a time
+	l = expireLimiter{}
+	l.allow(5, 20, interval, start)
+	if n := l.allow(5, 20, interval, start.Add(time.Second)); n != 1 {
+		t.Fatalf("expected 1, got %d", n)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/tidwall/geojson/geo"
 	"github.com/tidwall/geojson/geometry"
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/internal/field"
 	"github.com/tidwall/tile38/internal/glob"
 )
 
@@ -83,7 +84,8 @@ func fenceMatch(
 			return nil
 		}
 	}
-	if details.command == "del" {
+	if details.command == "del" && (fence.detect == nil ||
+		(!fence.detect["delete"] && !fence.detect["expire"])) {
 		return []string{
 			`{"command":"del"` + hookJSONString(hookName, metas) +
 				`,"key":` + jsonString(details.key) +
@@ -93,7 +95,19 @@ func fenceMatch(
 	}
 	var roamNearbys, roamFaraways []roamMatch
 	var detect = "outside"
-	if fence != nil {
+	if details.command == "del" {
+		// a DETECT with delete or expire has the deletes of the objects in
+		// the area, and their expirations, in place of the plain del
+		// messages
+		detect = "delete"
+		if details.expired {
+			detect = "expire"
+		}
+		if !fenceMatchObject(fence, details.obj) ||
+			!fenceMatchZRange(fence, details.obj) {
+			return nil
+		}
+	} else if fence != nil {
 		if fence.roam.on {
 			if details.command == "set" {
 				roamNearbys, roamFaraways =
@@ -106,8 +120,10 @@ func fenceMatch(
 			detect = "roam"
 		} else {
 			// not using roaming
-			match1 := fenceMatchObject(fence, details.oldObj)
-			match2 := fenceMatchObject(fence, details.obj)
+			match1 := fenceMatchObject(fence, details.oldObj) &&
+				fenceMatchZRange(fence, details.oldObj)
+			match2 := fenceMatchObject(fence, details.obj) &&
+				fenceMatchZRange(fence, details.obj)
 			if match1 && match2 {
 				detect = "inside"
 			} else if match1 && !match2 {
@@ -134,7 +150,8 @@ func fenceMatch(
 							fence.cmd = "intersects"
 							temp = true
 						}
-						if fenceMatchObject(fence, ls) {
+						if fenceMatchObject(fence, ls) &&
+							fenceMatchZRange(fence, details.oldObj, details.obj) {
 							detect = "cross"
 						}
 						if temp {
@@ -164,9 +181,16 @@ func fenceMatch(
 		break
 	}
 	sw.mu.Lock()
+	// the key may have been dropped and set again since the fence was made
+	sw.col = sw.s.getCol(details.key)
 	var distance float64
 	if fence.distance && fence.obj != nil {
-		distance = details.obj.Distance(fence.obj)
+		if fence.model == modelSpherical {
+			distance = details.obj.Distance(fence.obj)
+		} else {
+			distance = fence.model.distance(fence.obj.Center(),
+				details.obj.Center())
+		}
 	}
 	sw.fmap = details.fmap
 	sw.fullFields = true
@@ -207,6 +231,12 @@ func fenceMatch(
 	} else if detect == "cross" {
 		group = bsonID()
 		delete(fence.groups, groupkey)
+	} else if detect == "delete" || detect == "expire" {
+		group, ok = fence.groups[groupkey]
+		if !ok {
+			group = bsonID()
+		}
+		delete(fence.groups, groupkey)
 	} else {
 		group, ok = fence.groups[groupkey]
 		if !ok {
@@ -268,25 +298,29 @@ func extendRoamMessage(
 	nmsg = append(nmsg, `,"id":`...)
 	nmsg = appendJSONString(nmsg, match.id)
 	nmsg = append(nmsg, `,"object":`...)
-	nmsg = match.obj.AppendJSON(nmsg)
+	col := sw.s.getCol(fence.roam.key)
+	if col != nil {
+		nmsg = col.AppendObjectJSON(nmsg, match.obj)
+	} else {
+		nmsg = match.obj.AppendJSON(nmsg)
+	}
 	nmsg = append(nmsg, `,"meters":`...)
 	nmsg = strconv.AppendFloat(nmsg,
 		math.Floor(match.meters*1000)/1000, 'f', -1, 64)
 	if fence.roam.scan != "" {
 		nmsg = append(nmsg, `,"scan":[`...)
-		col := sw.s.getCol(fence.roam.key)
 		if col != nil {
 			obj, _, ok := col.Get(match.id)
 			if ok {
 				nmsg = append(nmsg, `{"id":`...)
 				nmsg = appendJSONString(nmsg, match.id)
 				nmsg = append(nmsg, `,"self":true,"object":`...)
-				nmsg = obj.AppendJSON(nmsg)
+				nmsg = col.AppendObjectJSON(nmsg, obj)
 				nmsg = append(nmsg, '}')
 			}
 			pattern := match.id + fence.roam.scan
 			iterator := func(
-				oid string, o geojson.Object, fields []float64,
+				oid string, o geojson.Object, fields []field.Value,
 			) bool {
 				if oid == match.id {
 					return true
@@ -295,7 +329,7 @@ func extendRoamMessage(
 					nmsg = append(nmsg, `,{"id":`...)
 					nmsg = appendJSONString(nmsg, oid)
 					nmsg = append(nmsg, `,"object":`...)
-					nmsg = o.AppendJSON(nmsg)
+					nmsg = col.AppendObjectJSON(nmsg, o)
 					nmsg = append(nmsg, '}')
 				}
 				return true
@@ -343,9 +377,18 @@ func fenceMatchObject(fence *liveFenceSwitches, obj geojson.Object) bool {
 		// we need to check this object against
 		return false
 	}
+	if fence.obj == nil {
+		// the referenced fence object does not exist
+		return false
+	}
 	switch fence.cmd {
 	case "nearby":
 		// nearby is an INTERSECT on a Circle
+		if circle, ok := fence.obj.(*geojson.Circle); ok &&
+			fence.model != modelSpherical {
+			return gobj.Intersects(
+				fence.model.circle(circle.Center(), circle.Meters()))
+		}
 		return gobj.Intersects(fence.obj)
 	case "within":
 		return gobj.Within(fence.obj)
@@ -355,6 +398,15 @@ func fenceMatchObject(fence *liveFenceSwitches, obj geojson.Object) bool {
 	return false
 }
 
+// fenceMatchZRange returns true when the vertical span of the objects
+// overlaps the fence altitude range.
+func fenceMatchZRange(fence *liveFenceSwitches, objs ...geojson.Object) bool {
+	if !fence.zrange {
+		return true
+	}
+	return zrangeMatch(fence.zmin, fence.zmax, objs...)
+}
+
 func fenceMatchNearbys(
 	s *Server, fence *liveFenceSwitches,
 	id string, obj geojson.Object,
@@ -374,7 +426,7 @@ func fenceMatchNearbys(
 		Max: geometry.Point{X: maxLon, Y: maxLat},
 	}
 	col.Intersects(geojson.NewRect(rect), 0, nil, nil, func(
-		id2 string, obj2 geojson.Object, fields []float64,
+		id2 string, obj2 geojson.Object, fields []field.Value,
 	) bool {
 		if s.hasExpired(fence.roam.key, id2) {
 			return true // skip expired
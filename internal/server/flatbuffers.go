@@ -0,0 +1,150 @@
+package server
+
+import (
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/internal/flatbuf"
+)
+
+// flatEncoderSize is the size of the buffer of a flatEncoder, which fits
+// the replies of the searches with the default limit.
+const flatEncoderSize = 64 * 1024
+
+// flatReplyIdent is the file identifier of the Reply of reply.fbs.
+const flatReplyIdent = "T38R"
+
+// The ids of the fields of the Reply of reply.fbs.
+const (
+	flatReplyOk = iota
+	flatReplyErr
+	flatReplyElapsed
+	flatReplyFields
+	flatReplyObjects
+	flatReplyIds
+	flatReplyCount
+	flatReplyCursor
+	flatReplyJSON
+	flatReplyNumFields
+)
+
+// The ids of the fields of the Object of reply.fbs.
+const (
+	flatObjectID = iota
+	flatObjectObject
+	flatObjectLat
+	flatObjectLon
+	flatObjectDistance
+	flatObjectFields
+	flatObjectNumFields
+)
+
+// flatEncoder is the FlatBuffers encoder of a connection, see OUTPUT
+// flatbuffers. The builder and the offsets are reused by the replies, so
+// that the replies of a connection do not allocate.
+type flatEncoder struct {
+	b       *flatbuf.Builder
+	objects []int
+	strs    []int
+}
+
+func newFlatEncoder() *flatEncoder {
+	return &flatEncoder{b: flatbuf.NewBuilder(flatEncoderSize)}
+}
+
+// encode returns the FlatBuffer of the Reply of a message of json, which is
+// valid until the next message is encoded. The members that are not in the
+// schema are kept with the json of the reply, and a message that is not a
+// json object, such as a geofence event, is only json.
+func (e *flatEncoder) encode(data []byte) []byte {
+	b := e.b
+	b.Reset()
+	res := gjson.ParseBytes(data)
+	if !res.IsObject() || res.Get("detect").Exists() {
+		json := b.CreateString(string(data))
+		b.StartTable(flatReplyNumFields)
+		b.AddOffset(flatReplyJSON, json)
+		return b.Finish(b.EndTable(), flatReplyIdent)
+	}
+	var ok, other bool
+	var errMsg, elapsed, fields, objects, ids int
+	var count, cursor uint64
+	res.ForEach(func(key, value gjson.Result) bool {
+		switch key.String() {
+		case "ok":
+			ok = value.Bool()
+		case "err":
+			errMsg = b.CreateString(value.String())
+		case "elapsed":
+			elapsed = b.CreateString(value.String())
+		case "fields":
+			if !value.IsArray() {
+				other = true
+				break
+			}
+			fields = e.createStrings(value)
+		case "objects":
+			objects = e.createObjects(value)
+		case "ids":
+			ids = e.createStrings(value)
+		case "count":
+			count = value.Uint()
+		case "cursor":
+			cursor = value.Uint()
+		default:
+			other = true
+		}
+		return true
+	})
+	var json int
+	if other {
+		json = b.CreateString(string(data))
+	}
+	b.StartTable(flatReplyNumFields)
+	b.AddUint64(flatReplyCount, count)
+	b.AddUint64(flatReplyCursor, cursor)
+	b.AddOffset(flatReplyErr, errMsg)
+	b.AddOffset(flatReplyElapsed, elapsed)
+	b.AddOffset(flatReplyFields, fields)
+	b.AddOffset(flatReplyObjects, objects)
+	b.AddOffset(flatReplyIds, ids)
+	b.AddOffset(flatReplyJSON, json)
+	b.AddBool(flatReplyOk, ok)
+	return b.Finish(b.EndTable(), flatReplyIdent)
+}
+
+// createStrings creates a vector of the strings of a json array, where the
+// values that are not strings are their json.
+func (e *flatEncoder) createStrings(arr gjson.Result) int {
+	e.strs = e.strs[:0]
+	arr.ForEach(func(_, value gjson.Result) bool {
+		e.strs = append(e.strs, e.b.CreateString(fieldText(value)))
+		return true
+	})
+	return e.b.CreateOffsets(e.strs)
+}
+
+// createObjects creates a vector of the objects of a search.
+func (e *flatEncoder) createObjects(arr gjson.Result) int {
+	b := e.b
+	e.objects = e.objects[:0]
+	arr.ForEach(func(_, o gjson.Result) bool {
+		id := b.CreateString(o.Get("id").String())
+		var object, fields int
+		if v := o.Get("object"); v.Exists() {
+			object = b.CreateString(v.Raw)
+		}
+		if v := o.Get("fields"); v.Exists() {
+			fields = e.createStrings(v)
+		}
+		lat, lon, _, _ := objectPoint(o)
+		b.StartTable(flatObjectNumFields)
+		b.AddFloat64(flatObjectLat, lat)
+		b.AddFloat64(flatObjectLon, lon)
+		b.AddFloat64(flatObjectDistance, o.Get("distance").Float())
+		b.AddOffset(flatObjectID, id)
+		b.AddOffset(flatObjectObject, object)
+		b.AddOffset(flatObjectFields, fields)
+		e.objects = append(e.objects, b.EndTable())
+		return true
+	})
+	return b.CreateOffsets(e.objects)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/tidwall/tile38/internal/flatbuf"
+)
+
+func TestFlatEncoder(t *testing.T) {
+	e := newFlatEncoder()
+	buf := e.encode([]byte(`{"ok":true,"fields":["speed"],"objects":[` +
+		`{"id":"truck1","object":{"type":"Point","coordinates":[-115,33]},` +
+		`"fields":[90],"distance":10}],"count":1,"cursor":5,"elapsed":"1µs"}`))
+	if flatbuf.Identifier(buf) != flatReplyIdent {
+		t.Fatalf("unexpected identifier: %q", buf[4:8])
+	}
+	reply := flatbuf.Root(buf)
+	if !reply.Bool(flatReplyOk) || reply.Uint64(flatReplyCount) != 1 ||
+		reply.Uint64(flatReplyCursor) != 5 ||
+		reply.String(flatReplyElapsed) != "1µs" ||
+		reply.String(flatReplyJSON) != "" {
+		t.Fatal("unexpected reply")
+	}
+	if names := reply.Vector(flatReplyFields); names.Len() != 1 ||
+		names.String(0) != "speed" {
+		t.Fatal("unexpected fields")
+	}
+	objects := reply.Vector(flatReplyObjects)
+	if objects.Len() != 1 {
+		t.Fatal("expected an object")
+	}
+	obj := objects.Table(0)
+	if obj.String(flatObjectID) != "truck1" ||
+		obj.Float64(flatObjectLat) != 33 || obj.Float64(flatObjectLon) != -115 ||
+		obj.Float64(flatObjectDistance) != 10 ||
+		obj.Vector(flatObjectFields).String(0) != "90" {
+		t.Fatal("unexpected object")
+	}
+	// the members that are not in the schema are kept with the json
+	data := `{"ok":true,"point":{"lat":33,"lon":-115}}`
+	reply = flatbuf.Root(e.encode([]byte(data)))
+	if !reply.Bool(flatReplyOk) || reply.String(flatReplyJSON) != data {
+		t.Fatal("expected the json")
+	}
+	reply = flatbuf.Root(e.encode([]byte(`{"ok":false,"err":"id not found"}`)))
+	if reply.Bool(flatReplyOk) || reply.String(flatReplyErr) != "id not found" {
+		t.Fatal("expected the error")
+	}
+}
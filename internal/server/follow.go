@@ -7,17 +7,19 @@ import (
 	"io/ioutil"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tidwall/resp"
 	"github.com/tidwall/tile38/core"
-	"github.com/tidwall/tile38/internal/log"
 )
 
 var errNoLongerFollowing = errors.New("no longer following")
 
 const checksumsz = 512 * 1024
 
+// FOLLOW host port [TLS] [KEYS pattern [pattern ...]]
+// FOLLOW no one
 func (s *Server) cmdFollow(msg *Message) (res resp.Value, err error) {
 	start := time.Now()
 	vs := msg.Args[1:]
@@ -30,37 +32,61 @@ func (s *Server) cmdFollow(msg *Message) (res resp.Value, err error) {
 	if vs, sport, ok = tokenval(vs); !ok || sport == "" {
 		return NOMessage, errInvalidNumberOfArguments
 	}
+	var useTLS bool
+	if len(vs) != 0 && strings.ToLower(vs[0]) == "tls" {
+		vs = vs[1:]
+		useTLS = true
+	}
+	var keys []string
 	if len(vs) != 0 {
-		return NOMessage, errInvalidNumberOfArguments
+		if strings.ToLower(vs[0]) != "keys" {
+			return NOMessage, errInvalidArgument(vs[0])
+		}
+		keys = vs[1:]
+		if len(keys) == 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+	}
+	if s.raftEnabled() {
+		return NOMessage, errors.New("cannot follow in raft mode")
+	}
+	if s.config.peerHost() != "" {
+		return NOMessage, errors.New("cannot follow while peering")
 	}
 	host = strings.ToLower(host)
 	sport = strings.ToLower(sport)
 	var update bool
 	if host == "no" && sport == "one" {
+		if len(keys) != 0 || useTLS {
+			return NOMessage, errInvalidNumberOfArguments
+		}
 		update = s.config.followHost() != "" || s.config.followPort() != 0
 		s.config.setFollowHost("")
 		s.config.setFollowPort(0)
+		s.config.setFollowKeys(nil)
+		s.config.setFollowTLS(false)
 	} else {
+		if s.aof == nil {
+			return NOMessage, errors.New("aof disabled")
+		}
 		n, err := strconv.ParseUint(sport, 10, 64)
 		if err != nil {
 			return NOMessage, errInvalidArgument(sport)
 		}
 		port := int(n)
-		update = s.config.followHost() != host || s.config.followPort() != port
+		update = s.config.followHost() != host || s.config.followPort() != port ||
+			strings.Join(s.config.followKeys(), " ") != strings.Join(keys, " ") ||
+			s.config.followTLS() != useTLS
 		auth := s.config.leaderAuth()
 		if update {
 			s.mu.Unlock()
-			conn, err := DialTimeout(fmt.Sprintf("%s:%d", host, port), time.Second*2)
+			conn, err := s.followDial(fmt.Sprintf("%s:%d", host, port), auth,
+				useTLS)
 			if err != nil {
 				s.mu.Lock()
 				return NOMessage, fmt.Errorf("cannot follow: %v", err)
 			}
 			defer conn.Close()
-			if auth != "" {
-				if err := s.followDoLeaderAuth(conn, auth); err != nil {
-					return NOMessage, fmt.Errorf("cannot follow: %v", err)
-				}
-			}
 			m, err := doServer(conn)
 			if err != nil {
 				s.mu.Lock()
@@ -82,15 +108,21 @@ func (s *Server) cmdFollow(msg *Message) (res resp.Value, err error) {
 		}
 		s.config.setFollowHost(host)
 		s.config.setFollowPort(port)
+		s.config.setFollowKeys(keys)
+		s.config.setFollowTLS(useTLS)
+	}
+	if update {
+		// a filtered follower starts over with a new leader or keys
+		s.config.setFollowResume("", 0, "")
 	}
 	s.config.write(false)
 	if update {
 		s.followc.add(1)
 		if s.config.followHost() != "" {
-			log.Infof("following new host '%s' '%s'.", host, sport)
+			replLog.Infof("following new host '%s' '%s'.", host, sport)
 			go s.follow(s.config.followHost(), s.config.followPort(), s.followc.get())
 		} else {
-			log.Infof("following no one")
+			replLog.Infof("following no one")
 		}
 	}
 	return OKMessage(msg, start), nil
@@ -107,6 +139,9 @@ func (s *Server) cmdReplConf(msg *Message, client *Client) (res resp.Value, err
 	if vs, cmd, ok = tokenval(vs); !ok || cmd == "" {
 		return NOMessage, errInvalidNumberOfArguments
 	}
+	if strings.ToLower(cmd) == "resume" {
+		return s.replResume(msg)
+	}
 	if _, val, ok = tokenval(vs); !ok || val == "" {
 		return NOMessage, errInvalidNumberOfArguments
 	}
@@ -131,6 +166,28 @@ func (s *Server) cmdReplConf(msg *Message, client *Client) (res resp.Value, err
 				return OKMessage(msg, start), nil
 			}
 		}
+	case "hooklease":
+		// the follower with followerhooks asks for the lease of the
+		// follower hooks, which is its id
+		if s.config.followHost() != "" {
+			return NOMessage, errors.New("not the leader")
+		}
+		granted, err := s.grantHookLease(val)
+		if err != nil {
+			return NOMessage, err
+		}
+		switch msg.OutputType {
+		case JSON:
+			return resp.StringValue(`{"ok":true,"granted":` +
+				strconv.FormatBool(granted) + `,"elapsed":"` +
+				time.Since(start).String() + "\"}"), nil
+		case RESP:
+			if granted {
+				return resp.IntegerValue(1), nil
+			}
+			return resp.IntegerValue(0), nil
+		}
+		return NOMessage, nil
 	}
 	return NOMessage, fmt.Errorf("cannot find follower")
 }
@@ -152,21 +209,42 @@ func doServer(conn *RESPConn) (map[string]string, error) {
 }
 
 func (s *Server) followHandleCommand(args []string, followc int, w io.Writer) (int, error) {
+	start := time.Now()
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer func() {
+		s.mu.Unlock()
+		s.latency.observe(latencyReplication, time.Since(start))
+	}()
 	if s.followc.get() != followc {
 		return s.aofsz, errNoLongerFollowing
 	}
-	msg := &Message{Args: args}
-
-	_, d, err := s.command(msg, nil)
-	if err != nil {
-		if commandErrIsFatal(err) {
+	switch {
+	case txMarker(args, "multi"):
+		// the commands of a transaction are applied once its EXEC is
+		// received
+		s.followTx, s.followInTx = s.followTx[:0], true
+		return s.aofsz, nil
+	case s.followInTx && txMarker(args, "exec"):
+		s.followInTx = false
+		if err := s.writeAOF([]string{"multi"}, nil); err != nil {
+			return s.aofsz, err
+		}
+		for _, args := range s.followTx {
+			if err := s.followApply(args); err != nil {
+				return s.aofsz, err
+			}
+		}
+		s.followTx = s.followTx[:0]
+		if err := s.writeAOF(args, nil); err != nil {
+			return s.aofsz, err
+		}
+	case s.followInTx:
+		s.followTx = append(s.followTx, args)
+		return s.aofsz, nil
+	default:
+		if err := s.followApply(args); err != nil {
 			return s.aofsz, err
 		}
-	}
-	if err := s.writeAOF(args, &d); err != nil {
-		return s.aofsz, err
 	}
 	if len(s.aofbuf) > 10240 {
 		s.flushAOF(false)
@@ -174,6 +252,27 @@ func (s *Server) followHandleCommand(args []string, followc int, w io.Writer) (i
 	return s.aofsz, nil
 }
 
+// followApply applies a command from the leader. The caller must hold the
+// server lock.
+func (s *Server) followApply(args []string) error {
+	msg := &Message{Args: args}
+	if s.hookOwner != "" && s.hookOwner == s.config.serverID() {
+		// the writes return their field maps for the follower hooks
+		msg.OutputType = JSON
+	}
+	_, d, err := s.command(msg, nil)
+	if err != nil {
+		if commandErrIsFatal(err) {
+			return err
+		}
+	}
+	if lc(args[0], "hooklease") {
+		// the marker changes no objects, but is kept in the aof
+		return s.writeAOF(args, nil)
+	}
+	return s.writeAOF(args, &d)
+}
+
 func (s *Server) followDoLeaderAuth(conn *RESPConn, auth string) error {
 	v, err := conn.Do("auth", auth)
 	if err != nil {
@@ -188,27 +287,41 @@ func (s *Server) followDoLeaderAuth(conn *RESPConn, auth string) error {
 	return nil
 }
 
+// followDial connects to the leader, over TLS when useTLS is true, and
+// authenticates with the leaderauth.
+func (s *Server) followDial(addr, auth string, useTLS bool) (*RESPConn, error) {
+	conn, err := s.dialLeader(addr, useTLS)
+	if err != nil {
+		return nil, err
+	}
+	if auth != "" {
+		if err := s.followDoLeaderAuth(conn, auth); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
 func (s *Server) followStep(host string, port int, followc int) error {
 	if s.followc.get() != followc {
 		return errNoLongerFollowing
 	}
 	s.mu.Lock()
 	s.fcup = false
+	s.followInTx = false
 	auth := s.config.leaderAuth()
+	keys := s.config.followKeys()
 	s.mu.Unlock()
 	addr := fmt.Sprintf("%s:%d", host, port)
 
 	// check if we are following self
-	conn, err := DialTimeout(addr, time.Second*2)
+	useTLS := s.config.followTLS()
+	conn, err := s.followDial(addr, auth, useTLS)
 	if err != nil {
 		return fmt.Errorf("cannot follow: %v", err)
 	}
 	defer conn.Close()
-	if auth != "" {
-		if err := s.followDoLeaderAuth(conn, auth); err != nil {
-			return fmt.Errorf("cannot follow: %v", err)
-		}
-	}
 	m, err := doServer(conn)
 	if err != nil {
 		return fmt.Errorf("cannot follow: %v", err)
@@ -224,11 +337,31 @@ func (s *Server) followStep(host string, port int, followc int) error {
 		return fmt.Errorf("cannot follow a follower")
 	}
 
-	// verify checksum
-	pos, err := s.followCheckSome(addr, followc)
+	aofSize, err := strconv.ParseInt(m["aof_size"], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	var pos int64
+	if len(keys) > 0 {
+		// find where the filtered follow stopped
+		pos, err = s.followFilteredPos(addr, m["id"], aofSize, followc)
+	} else {
+		// resume from the backlog of the leader, or verify checksum
+		var resumed bool
+		if pos, resumed = s.followResume(conn, m["repl_id"], followc); !resumed {
+			pos, err = s.followCheckSome(addr, followc)
+		}
+	}
 	if err != nil {
 		return err
 	}
+	if len(keys) == 0 {
+		// the aof is now a copy of the leader's aof
+		s.mu.Lock()
+		s.backlog.id = m["repl_id"]
+		s.mu.Unlock()
+	}
 
 	// Send the replication port to the leader
 	v, err := conn.Do("replconf", "listening-port", s.port)
@@ -242,10 +375,21 @@ func (s *Server) followStep(host string, port int, followc int) error {
 		return errors.New("invalid response to replconf request")
 	}
 	if core.ShowDebugMessages {
-		log.Debug("follow:", addr, ":replconf")
+		replLog.Debug("follow:", addr, ":replconf")
 	}
 
-	v, err = conn.Do("aof", pos)
+	args := []interface{}{pos}
+	if len(keys) > 0 {
+		args = append(args, "keys")
+		for _, key := range keys {
+			args = append(args, key)
+		}
+	} else if s.raftEnabled() {
+		// the acknowledgements of the members commit the writes of the
+		// leader, see raftCommit
+		args = append(args, "member", s.config.raftAddr())
+	}
+	v, err = conn.Do("aof", args...)
 	if err != nil {
 		return err
 	}
@@ -256,13 +400,30 @@ func (s *Server) followStep(host string, port int, followc int) error {
 		return errors.New("invalid response to aof live request")
 	}
 	if core.ShowDebugMessages {
-		log.Debug("follow:", addr, ":read aof")
+		replLog.Debug("follow:", addr, ":read aof")
 	}
 
-	aofSize, err := strconv.ParseInt(m["aof_size"], 10, 64)
-	if err != nil {
-		return err
-	}
+	// Acknowledge the received writes for WAIT, and poll the leader's aof
+	// size for the replication lag.
+	s.repl.setLeaderSize(aofSize)
+	s.repl.setApplied(pos)
+	s.repl.setLinked(true)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.followAck(conn, done)
+	}()
+	go func() {
+		defer wg.Done()
+		s.followPollLeader(addr, auth, useTLS, done)
+	}()
+	defer func() {
+		s.repl.setLinked(false)
+		close(done)
+		wg.Wait()
+	}()
 
 	caughtUp := pos >= aofSize
 	if caughtUp {
@@ -270,7 +431,11 @@ func (s *Server) followStep(host string, port int, followc int) error {
 		s.fcup = true
 		s.fcuponce = true
 		s.mu.Unlock()
-		log.Info("caught up")
+		replLog.Info("caught up")
+	}
+	var saved time.Time
+	if len(keys) > 0 {
+		defer s.followSavePos(followc)
 	}
 	nullw := ioutil.Discard
 	for {
@@ -287,19 +452,44 @@ func (s *Server) followStep(host string, port int, followc int) error {
 			svals[i] = vals[i].String()
 		}
 
-		aofsz, err := s.followHandleCommand(svals, followc, nullw)
-		if err != nil {
-			return err
+		var offset int64
+		if len(keys) > 0 {
+			// The offset of the leader comes from REPLCONF POS.
+			if len(svals) != 4 || strings.ToLower(svals[0]) != "replconf" {
+				_, err := s.followHandleCommand(svals, followc, nullw)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			offset, err = strconv.ParseInt(svals[2], 10, 64)
+			if err != nil {
+				return errors.New("invalid replconf pos")
+			}
+			s.mu.Lock()
+			s.followPos, s.followSum = offset, svals[3]
+			s.mu.Unlock()
+			if time.Since(saved) > time.Second {
+				s.followSavePos(followc)
+				saved = time.Now()
+			}
+		} else {
+			aofsz, err := s.followHandleCommand(svals, followc, nullw)
+			if err != nil {
+				return err
+			}
+			offset = int64(aofsz)
 		}
+		s.repl.setApplied(offset)
 		if !caughtUp {
-			if aofsz >= int(aofSize) {
+			if offset >= aofSize {
 				caughtUp = true
 				s.mu.Lock()
 				s.flushAOF(false)
 				s.fcup = true
 				s.fcuponce = true
 				s.mu.Unlock()
-				log.Info("caught up")
+				replLog.Info("caught up")
 			}
 		}
 
@@ -313,8 +503,67 @@ func (s *Server) follow(host string, port int, followc int) {
 			return
 		}
 		if err != nil && err != io.EOF {
-			log.Error("follow: " + err.Error())
+			replLog.Error("follow: " + err.Error())
 		}
 		time.Sleep(time.Second)
 	}
 }
+
+// followFilteredPos returns the leader's aof offset to resume a filtered
+// follow from. The offset of the last REPLCONF POS from the leader is used
+// when its checksum still matches the leader's aof. Otherwise the follower
+// starts over with an empty dataset.
+func (s *Server) followFilteredPos(addr, leaderID string, aofSize int64,
+	followc int,
+) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.followc.get() != followc {
+		return 0, errNoLongerFollowing
+	}
+	id, pos, sum := s.config.followResume()
+	if id == leaderID && pos > 0 && pos <= aofSize {
+		conn, err := s.followDial(addr, s.config.leaderAuth(),
+			s.config.followTLS())
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		size := int64(aofFilterTail)
+		if pos < size {
+			size = pos
+		}
+		csum, err := connAOFMD5(conn, pos-size, size)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if csum == sum {
+			s.followPos, s.followSum = pos, sum
+			return pos, nil
+		}
+	}
+	if err := s.followStartOver(); err != nil {
+		return 0, err
+	}
+	s.followPos, s.followSum = 0, ""
+	s.config.setFollowResume(leaderID, 0, "")
+	s.config.write(false)
+	return 0, nil
+}
+
+// followSavePos saves the leader's aof offset of a filtered follow. The aof
+// is flushed first, so that it has all of the commands before the offset.
+func (s *Server) followSavePos(followc int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.followc.get() != followc {
+		return
+	}
+	id, pos, sum := s.config.followResume()
+	if pos == s.followPos && sum == s.followSum {
+		return
+	}
+	s.flushAOF(false)
+	s.config.setFollowResume(id, s.followPos, s.followSum)
+	s.config.write(false)
+}
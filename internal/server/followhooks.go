@@ -0,0 +1,90 @@
+package server
+
+import (
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// hookLeaseTime is how long a follower keeps the lease of the follower hooks
+// after it was last renewed, see REPLCONF HOOKLEASE. The followers with
+// followerhooks renew it every second.
+const hookLeaseTime = 5 * time.Second
+
+// evaluatesHook returns true when the server evaluates and delivers the
+// events of a hook. The follower hooks are evaluated by the follower that
+// has the lease, or by the leader while no follower has it, and the others
+// by the leader. The lease is given and taken back with the hooklease
+// markers that the leader writes to the aof, so every write is evaluated by
+// one server, the one that held the lease at its offset of the aof. The
+// caller must hold the server lock.
+func (s *Server) evaluatesHook(hook *Hook) bool {
+	leader := s.config.followHost() == ""
+	if !hook.follower || s.hookOwner == "" {
+		return leader
+	}
+	return s.hookOwner == s.config.serverID()
+}
+
+// grantHookLease gives the lease of the follower hooks to a follower, or
+// renews it, unless another follower has it. The caller must hold the server
+// lock.
+func (s *Server) grantHookLease(id string) (bool, error) {
+	now := time.Now()
+	if s.hookOwner != id {
+		if s.hookOwner != "" && now.Before(s.hookLeaseExpires) {
+			return false, nil
+		}
+		if err := s.writeAOF([]string{"hooklease", id}, nil); err != nil {
+			return false, err
+		}
+		s.hookOwner = id
+	}
+	s.hookLeaseExpires = now.Add(hookLeaseTime)
+	return true, nil
+}
+
+// expireHookLease takes the lease of the follower hooks back from a follower
+// that stopped renewing it, before the leader writes to the aof. A lease that
+// was loaded from the aof gets a full lease time, so that its follower can
+// renew it after the leader restarts. The caller must hold the server lock.
+func (s *Server) expireHookLease() error {
+	if s.hookOwner == "" || s.config.followHost() != "" {
+		return nil
+	}
+	now := time.Now()
+	if s.hookLeaseExpires.IsZero() {
+		s.hookLeaseExpires = now.Add(hookLeaseTime)
+	}
+	if now.Before(s.hookLeaseExpires) {
+		return nil
+	}
+	s.hookOwner = ""
+	return s.writeAOF([]string{"hooklease"}, nil)
+}
+
+// hookLeaseCommands returns the marker of the follower that has the lease of
+// the follower hooks, for the shrunk aof.
+func (s *Server) hookLeaseCommands() [][]string {
+	if s.hookOwner == "" {
+		return nil
+	}
+	return [][]string{{"hooklease", s.hookOwner}}
+}
+
+// cmdHookLease applies a hooklease marker of the aof, which has the id of
+// the follower that the lease of the follower hooks was given to, or no id
+// when the leader took it back. The markers are written by the leader, and
+// cannot be sent by a client.
+func (s *Server) cmdHookLease(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	switch len(msg.Args) {
+	case 1:
+		s.hookOwner = ""
+	case 2:
+		s.hookOwner = msg.Args[1]
+	default:
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	return OKMessage(msg, start), nil
+}
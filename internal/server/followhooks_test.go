@@ -0,0 +1,76 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHookLease(t *testing.T) {
+	s := newSnapshotTestServer()
+	s.config._serverID = "leader"
+	// the markers are kept by the shrink log
+	s.shrinking = true
+	markers := func() string {
+		var lines []string
+		for _, args := range s.shrinklog {
+			lines = append(lines, strings.Join(args, " "))
+		}
+		s.shrinklog = nil
+		return strings.Join(lines, ",")
+	}
+	hook := &Hook{follower: true}
+	other := &Hook{}
+	if !s.evaluatesHook(hook) || !s.evaluatesHook(other) {
+		t.Fatal("expected the leader to evaluate the hooks")
+	}
+
+	if granted, err := s.grantHookLease("f1"); err != nil || !granted {
+		t.Fatalf("expected the lease, got %v %v", granted, err)
+	}
+	if m := markers(); m != "hooklease f1" {
+		t.Fatalf("expected 'hooklease f1', got '%s'", m)
+	}
+	if s.evaluatesHook(hook) || !s.evaluatesHook(other) {
+		t.Fatal("expected the leader to evaluate the other hooks only")
+	}
+	// renewed without a marker, and not given to another follower
+	if granted, _ := s.grantHookLease("f1"); !granted {
+		t.Fatal("expected the lease to be renewed")
+	}
+	if granted, _ := s.grantHookLease("f2"); granted {
+		t.Fatal("expected the lease to be held by f1")
+	}
+	if m := markers(); m != "" {
+		t.Fatalf("expected no markers, got '%s'", m)
+	}
+
+	// taken back once it expires, before the next write
+	s.hookLeaseExpires = time.Now().Add(-time.Second)
+	if err := s.writeAOF([]string{"del", "fleet", "truck1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if m := markers(); m != "hooklease,del fleet truck1" {
+		t.Fatalf("expected 'hooklease,del fleet truck1', got '%s'", m)
+	}
+	if !s.evaluatesHook(hook) {
+		t.Fatal("expected the leader to evaluate the follower hooks")
+	}
+	if granted, _ := s.grantHookLease("f2"); !granted {
+		t.Fatal("expected the lease to be given to f2")
+	}
+	if m := markers(); m != "hooklease f2" {
+		t.Fatalf("expected 'hooklease f2', got '%s'", m)
+	}
+
+	// the follower with the lease evaluates the follower hooks only
+	s.config._serverID = "f2"
+	s.config._followHost = "localhost"
+	if !s.evaluatesHook(hook) || s.evaluatesHook(other) {
+		t.Fatal("expected the follower to evaluate the follower hooks only")
+	}
+	s.cmdHookLease(&Message{Args: []string{"hooklease", "f1"}})
+	if s.evaluatesHook(hook) || s.evaluatesHook(other) {
+		t.Fatal("expected the follower to evaluate no hooks")
+	}
+}
@@ -0,0 +1,493 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/glob"
+	"github.com/tidwall/tile38/internal/log"
+	lua "github.com/yuin/gopher-lua"
+)
+
+var errFunctionNotFound = errors.New("function not found")
+var errLibraryNotFound = errors.New("library not found")
+
+// luaLibrary is a library of functions that was loaded by FUNCTION LOAD. The
+// library code is run in a lua state before one of its functions is called,
+// which registers the functions of the library in that state.
+type luaLibrary struct {
+	name  string
+	code  string
+	proto *lua.FunctionProto
+	funcs []*luaFunction // ordered by name
+}
+
+// luaFunction is a function that was registered by a library.
+type luaFunction struct {
+	name     string
+	readonly bool // registered with the no-writes flag
+	lib      *luaLibrary
+}
+
+// parseLibraryName returns the name of a library from the first line of its
+// code, which is "#!lua name=<name>".
+func parseLibraryName(code string) (string, error) {
+	line := code
+	if i := strings.IndexByte(line, '\n'); i != -1 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "#!lua" {
+		return "", clientErrorf("missing library metadata, " +
+			"the code must start with #!lua name=<name>")
+	}
+	var name string
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "name=") || name != "" {
+			return "", clientErrorf("invalid library metadata '%s'", field)
+		}
+		name = field[len("name="):]
+	}
+	if name == "" {
+		return "", clientErrorf("library name was not given")
+	}
+	return name, nil
+}
+
+// luaRegisterFunction is tile38.register_function, which is only available
+// while the code of a library is run.
+//
+// tile38.register_function(name, callback)
+// tile38.register_function{function_name=name, callback=callback, flags={...}}
+func luaRegisterFunction(ls *lua.LState) int {
+	registry, ok := ls.GetGlobal("FUNCTIONS").(*lua.LTable)
+	if !ok {
+		ls.RaiseError("register_function is only allowed in FUNCTION LOAD")
+		return 0
+	}
+	var name lua.LValue
+	var callback lua.LValue
+	flags := ls.NewTable()
+	if tbl, ok := ls.Get(1).(*lua.LTable); ok {
+		name = tbl.RawGetString("function_name")
+		callback = tbl.RawGetString("callback")
+		if v := tbl.RawGetString("flags"); v != lua.LNil {
+			if flags, ok = v.(*lua.LTable); !ok {
+				ls.RaiseError("flags must be a table")
+				return 0
+			}
+		}
+	} else {
+		name, callback = ls.Get(1), ls.Get(2)
+	}
+	if name.Type() != lua.LTString || name.String() == "" {
+		ls.RaiseError("function name is missing")
+		return 0
+	}
+	if callback.Type() != lua.LTFunction {
+		ls.RaiseError("callback of '%s' is not a function", name.String())
+		return 0
+	}
+	var bad lua.LValue
+	flags.ForEach(func(_, flag lua.LValue) {
+		if flag.String() != "no-writes" {
+			bad = flag
+		}
+	})
+	if bad != nil {
+		ls.RaiseError("unknown flag '%s'", bad.String())
+		return 0
+	}
+	if registry.RawGetString(name.String()) != lua.LNil {
+		ls.RaiseError("function '%s' already exists", name.String())
+		return 0
+	}
+	entry := ls.CreateTable(0, 2)
+	entry.RawSetString("callback", callback)
+	entry.RawSetString("flags", flags)
+	registry.RawSetString(name.String(), entry)
+	return 0
+}
+
+// register runs the code of the library in a lua state, and returns its
+// registered functions by name.
+func (lib *luaLibrary) register(L *lua.LState) (*lua.LTable, error) {
+	registry := L.NewTable()
+	luaSetRawGlobals(L, map[string]lua.LValue{"FUNCTIONS": registry})
+	defer luaSetRawGlobals(L, map[string]lua.LValue{"FUNCTIONS": lua.LNil})
+	L.Push(&lua.LFunction{
+		Env:      L.Env,
+		Proto:    lib.proto,
+		Upvalues: make([]*lua.Upvalue, 0),
+	})
+	if err := L.PCall(0, 0, nil); err != nil {
+		return nil, makeSafeErr(err)
+	}
+	return registry, nil
+}
+
+// loadLibrary compiles the code of a library, and runs it to find its
+// functions.
+func (s *Server) loadLibrary(code string) (*luaLibrary, error) {
+	name, err := parseLibraryName(code)
+	if err != nil {
+		return nil, err
+	}
+	L, err := s.luapool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.luapool.Put(L)
+	// the metadata line is not lua, but is kept for the line numbers
+	src := code[strings.IndexByte(code+"\n", '\n'):]
+	fn, err := L.Load(strings.NewReader(src), "l_"+name)
+	if err != nil {
+		return nil, makeSafeErr(err)
+	}
+	lib := &luaLibrary{name: name, code: code, proto: fn.Proto}
+	registry, err := lib.register(L)
+	if err != nil {
+		return nil, err
+	}
+	registry.ForEach(func(k, v lua.LValue) {
+		f := &luaFunction{name: k.String(), lib: lib}
+		flags := v.(*lua.LTable).RawGetString("flags").(*lua.LTable)
+		flags.ForEach(func(_, flag lua.LValue) {
+			f.readonly = f.readonly || flag.String() == "no-writes"
+		})
+		lib.funcs = append(lib.funcs, f)
+	})
+	if len(lib.funcs) == 0 {
+		return nil, clientErrorf("no functions registered")
+	}
+	sort.Slice(lib.funcs, func(i, j int) bool {
+		return lib.funcs[i].name < lib.funcs[j].name
+	})
+	return lib, nil
+}
+
+// FUNCTION LOAD [REPLACE] code
+// FUNCTION DELETE library
+// FUNCTION FLUSH
+// FUNCTION LIST [LIBRARYNAME pattern] [WITHCODE]
+//
+// Manages the libraries of lua functions, which are called by name with
+// FCALL and FCALL_RO. Unlike the scripts of SCRIPT LOAD, the libraries are
+// written to the aof, so that they're kept after a restart and are sent to
+// the followers.
+func (s *Server) cmdFunction(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var sub string
+	var ok bool
+	if vs, sub, ok = tokenval(vs); !ok || sub == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	switch strings.ToLower(sub) {
+	default:
+		return NOMessage, d, clientErrorf(
+			"Syntax error, try FUNCTION (LOAD | DELETE | FLUSH | LIST)",
+		)
+	case "load":
+		var replace bool
+		if len(vs) > 1 && lc(vs[0], "replace") {
+			replace, vs = true, vs[1:]
+		}
+		var code string
+		if vs, code, ok = tokenval(vs); !ok || code == "" || len(vs) != 0 {
+			return NOMessage, d, errInvalidNumberOfArguments
+		}
+		lib, err := s.loadLibrary(code)
+		if err != nil {
+			return NOMessage, d, err
+		}
+		old := s.libraries[lib.name]
+		if old != nil && !replace {
+			return NOMessage, d, clientErrorf(
+				"library '%s' already exists", lib.name)
+		}
+		for _, f := range lib.funcs {
+			if other := s.functions[f.name]; other != nil && other.lib != old {
+				return NOMessage, d, clientErrorf(
+					"function '%s' already exists in library '%s'",
+					f.name, other.lib.name)
+			}
+		}
+		s.deleteLibrary(lib.name)
+		s.libraries[lib.name] = lib
+		for _, f := range lib.funcs {
+			s.functions[f.name] = f
+		}
+		s.pruneTriggers()
+		d.updated = true
+		d.timestamp = time.Now()
+		switch msg.OutputType {
+		case JSON:
+			return resp.StringValue(`{"ok":true,"library":` +
+				jsonString(lib.name) + `,"elapsed":"` +
+				time.Since(start).String() + "\"}"), d, nil
+		case RESP:
+			return resp.StringValue(lib.name), d, nil
+		}
+		return NOMessage, d, nil
+	case "delete":
+		var name string
+		if vs, name, ok = tokenval(vs); !ok || name == "" || len(vs) != 0 {
+			return NOMessage, d, errInvalidNumberOfArguments
+		}
+		if s.libraries[name] == nil {
+			return NOMessage, d, errLibraryNotFound
+		}
+		s.deleteLibrary(name)
+	case "flush":
+		if len(vs) != 0 {
+			return NOMessage, d, errInvalidNumberOfArguments
+		}
+		s.libraries = make(map[string]*luaLibrary)
+		s.functions = make(map[string]*luaFunction)
+	case "list":
+		res, err = s.functionList(msg, vs, start)
+		return res, d, err
+	}
+	s.pruneTriggers()
+	d.updated = true
+	d.timestamp = time.Now()
+	return OKMessage(msg, start), d, nil
+}
+
+// deleteLibrary removes a library and its functions.
+func (s *Server) deleteLibrary(name string) {
+	if lib := s.libraries[name]; lib != nil {
+		for _, f := range lib.funcs {
+			delete(s.functions, f.name)
+		}
+		delete(s.libraries, name)
+	}
+}
+
+// functionList is FUNCTION LIST, which returns the libraries with their
+// functions and the flags of the functions.
+func (s *Server) functionList(msg *Message, vs []string, start time.Time) (
+	resp.Value, error,
+) {
+	var pattern string
+	var withCode bool
+	for len(vs) > 0 {
+		var tok string
+		vs, tok, _ = tokenval(vs)
+		switch {
+		case lc(tok, "libraryname") && pattern == "":
+			var ok bool
+			if vs, pattern, ok = tokenval(vs); !ok || pattern == "" {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+		case lc(tok, "withcode") && !withCode:
+			withCode = true
+		default:
+			return NOMessage, errInvalidArgument(tok)
+		}
+	}
+	var libs []*luaLibrary
+	for name, lib := range s.libraries {
+		if pattern != "" {
+			if ok, _ := glob.Match(pattern, name); !ok {
+				continue
+			}
+		}
+		libs = append(libs, lib)
+	}
+	sort.Slice(libs, func(i, j int) bool {
+		return libs[i].name < libs[j].name
+	})
+	switch msg.OutputType {
+	case JSON:
+		var buf bytes.Buffer
+		buf.WriteString(`{"ok":true,"libraries":[`)
+		for i, lib := range libs {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"library_name":` + jsonString(lib.name) +
+				`,"functions":[`)
+			for j, f := range lib.funcs {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(`{"name":` + jsonString(f.name) + `,"flags":[`)
+				if f.readonly {
+					buf.WriteString(`"no-writes"`)
+				}
+				buf.WriteString(`]}`)
+			}
+			buf.WriteByte(']')
+			if withCode {
+				buf.WriteString(`,"library_code":` + jsonString(lib.code))
+			}
+			buf.WriteByte('}')
+		}
+		buf.WriteString(`],"elapsed":"` + time.Since(start).String() + "\"}")
+		return resp.StringValue(buf.String()), nil
+	case RESP:
+		vals := make([]resp.Value, len(libs))
+		for i, lib := range libs {
+			funcs := make([]resp.Value, len(lib.funcs))
+			for j, f := range lib.funcs {
+				var flags []resp.Value
+				if f.readonly {
+					flags = append(flags, resp.StringValue("no-writes"))
+				}
+				funcs[j] = resp.ArrayValue([]resp.Value{
+					resp.StringValue("name"), resp.StringValue(f.name),
+					resp.StringValue("flags"), resp.ArrayValue(flags),
+				})
+			}
+			entry := []resp.Value{
+				resp.StringValue("library_name"), resp.StringValue(lib.name),
+				resp.StringValue("functions"), resp.ArrayValue(funcs),
+			}
+			if withCode {
+				entry = append(entry, resp.StringValue("library_code"),
+					resp.StringValue(lib.code))
+			}
+			vals[i] = resp.ArrayValue(entry)
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
+// FCALL function numkeys [key ...] [arg ...]
+// FCALL_RO function numkeys [key ...] [arg ...]
+//
+// Calls a function of a library with the table of keys and the table of
+// args. A function that was registered with the no-writes flag may only
+// read, like EVALRO, and is the only kind of function that FCALL_RO can
+// call.
+func (s *Server) cmdFcall(msg *Message, readonly bool, client *Client) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	var name, numkeysStr, key, arg string
+	if vs, name, ok = tokenval(vs); !ok || name == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if vs, numkeysStr, ok = tokenval(vs); !ok || numkeysStr == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	numkeys, err := strconv.ParseUint(numkeysStr, 10, 64)
+	if err != nil {
+		return NOMessage, errInvalidArgument(numkeysStr)
+	}
+	f := s.functions[name]
+	if f == nil {
+		return NOMessage, errFunctionNotFound
+	}
+	if readonly && !f.readonly {
+		return NOMessage, clientErrorf(
+			"FCALL_RO can only call a function with the no-writes flag")
+	}
+	L, err := s.luapool.Get()
+	if err != nil {
+		return NOMessage, err
+	}
+	defer s.luapool.Put(L)
+	s.luapool.setCaller(L, client)
+	luaDeadline := lua.LNil
+	if msg.Deadline != nil {
+		dlTime := msg.Deadline.GetDeadlineTime()
+		ctx, cancel := context.WithDeadline(context.Background(), dlTime)
+		defer cancel()
+		L.SetContext(ctx)
+		defer L.RemoveContext()
+		luaDeadline = lua.LNumber(float64(dlTime.UnixNano()) / 1e9)
+	}
+	keysTbl := L.CreateTable(int(numkeys), 0)
+	for i := uint64(0); i < numkeys; i++ {
+		if vs, key, ok = tokenval(vs); !ok || key == "" {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		keysTbl.Append(lua.LString(key))
+	}
+	argsTbl := L.CreateTable(len(vs), 0)
+	for len(vs) > 0 {
+		if vs, arg, ok = tokenval(vs); !ok || arg == "" {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		argsTbl.Append(lua.LString(arg))
+	}
+	registry, err := f.lib.register(L)
+	if err != nil {
+		return NOMessage, err
+	}
+	entry, ok := registry.RawGetString(name).(*lua.LTable)
+	if !ok {
+		return NOMessage, errFunctionNotFound
+	}
+	// the calls of a function are the same as those of EVAL or EVALRO
+	evalCmd := "eval"
+	if f.readonly {
+		evalCmd = "evalro"
+	}
+	luaSetRawGlobals(L, map[string]lua.LValue{
+		"DEADLINE": luaDeadline,
+		"EVAL_CMD": lua.LString(evalCmd),
+	})
+	defer luaSetRawGlobals(L, map[string]lua.LValue{
+		"DEADLINE": lua.LNil,
+		"EVAL_CMD": lua.LNil,
+	})
+	L.Push(entry.RawGetString("callback"))
+	L.Push(keysTbl)
+	L.Push(argsTbl)
+	if err := L.PCall(2, 1, nil); err != nil {
+		if strings.Contains(err.Error(), "context deadline exceeded") {
+			msg.Deadline.Check()
+		}
+		log.Debugf("%v", err.Error())
+		return NOMessage, makeSafeErr(err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	switch msg.OutputType {
+	case JSON:
+		var buf bytes.Buffer
+		buf.WriteString(`{"ok":true`)
+		buf.WriteString(`,"result":` + ConvertToJSON(ret))
+		buf.WriteString(`,"elapsed":"` + time.Since(start).String() + "\"}")
+		return resp.StringValue(buf.String()), nil
+	case RESP:
+		return ConvertToRESP(ret), nil
+	}
+	return NOMessage, nil
+}
+
+// functionWrite returns true for the FUNCTION subcommands that change the
+// libraries.
+func functionWrite(args []string) bool {
+	return len(args) > 1 && (lc(args[1], "load") || lc(args[1], "delete") ||
+		lc(args[1], "flush"))
+}
+
+// functionCommands returns the commands needed to recreate the libraries.
+// The caller must hold the server lock.
+func (s *Server) functionCommands() (cmds [][]string) {
+	var names []string
+	for name := range s.libraries {
+		names = append(names, name)
+	}
+	// sort the names for consistency
+	sort.Strings(names)
+	for _, name := range names {
+		cmds = append(cmds,
+			[]string{"function", "load", s.libraries[name].code})
+	}
+	return cmds
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/resp"
+)
+
+// geoIndexKinds are the names of the kinds of geometry indexes.
+var geoIndexKinds = map[geometry.IndexKind]string{
+	geometry.None:     "none",
+	geometry.RTree:    "rtree",
+	geometry.QuadTree: "quadtree",
+}
+
+// parseOpts returns the options that the geometries of a key are parsed
+// with, which are the options of the server unless SETGEOINDEX changed them.
+func (s *Server) parseOpts(key string) *geojson.ParseOptions {
+	if opts := s.geoIndexes[key]; opts != nil {
+		return opts
+	}
+	return &s.geomParseOpts
+}
+
+// SETGEOINDEX key [KIND none|rtree|quadtree] [POINTS min] [MULTI min]
+//
+// Sets the options of the indexes that are built inside of the polygons,
+// lines, and multi geometries of a key, such as a quadtree for a key with
+// large polygons, or no index for a key with many small ones. The options
+// that are not given are those of the server, and no options at all resets
+// the key to the options of the server. The geometries of the collection are
+// parsed again with the new options.
+func (s *Server) cmdSetGeoIndex(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	opts := s.geomParseOpts
+	for len(vs) > 0 {
+		var name, arg string
+		vs, name, _ = tokenval(vs)
+		if vs, arg, ok = tokenval(vs); !ok || arg == "" {
+			return NOMessage, d, errInvalidNumberOfArguments
+		}
+		switch strings.ToLower(name) {
+		case "kind":
+			found := false
+			for kind, kname := range geoIndexKinds {
+				if strings.EqualFold(arg, kname) {
+					opts.IndexGeometryKind = kind
+					found = true
+				}
+			}
+			if !found {
+				return NOMessage, d, errInvalidArgument(arg)
+			}
+		case "points", "multi":
+			n, err := strconv.ParseUint(arg, 10, 32)
+			if err != nil {
+				return NOMessage, d, errInvalidArgument(arg)
+			}
+			if strings.ToLower(name) == "points" {
+				opts.IndexGeometry = int(n)
+			} else {
+				opts.IndexChildren = int(n)
+			}
+		default:
+			return NOMessage, d, errInvalidArgument(name)
+		}
+	}
+	old := s.geoIndexes[d.key]
+	var next *geojson.ParseOptions
+	if len(msg.Args) > 2 {
+		next = &opts
+	}
+	switch {
+	case old == nil && next == nil:
+	case old != nil && next != nil && *old == *next:
+	default:
+		if next == nil {
+			delete(s.geoIndexes, d.key)
+		} else {
+			s.geoIndexes[d.key] = next
+		}
+		if col := s.getCol(d.key); col != nil {
+			if err := col.Reparse(s.parseOpts(d.key)); err != nil {
+				return NOMessage, d, err
+			}
+		}
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+	return intResult(msg, start, d.updated), d, nil
+}
+
+// GEOINDEX key
+func (s *Server) cmdGeoIndex(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var key string
+	var ok bool
+	if vs, key, ok = tokenval(vs); !ok || key == "" || len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	opts := s.parseOpts(key)
+	kind := geoIndexKinds[opts.IndexGeometryKind]
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"geoindex":{"kind":`...)
+		buf = appendJSONString(buf, kind)
+		buf = append(buf, `,"points":`...)
+		buf = strconv.AppendInt(buf, int64(opts.IndexGeometry), 10)
+		buf = append(buf, `,"multi":`...)
+		buf = strconv.AppendInt(buf, int64(opts.IndexChildren), 10)
+		buf = append(buf, `},"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		return resp.ArrayValue([]resp.Value{
+			resp.StringValue("kind"), resp.StringValue(kind),
+			resp.StringValue("points"), resp.IntegerValue(opts.IndexGeometry),
+			resp.StringValue("multi"), resp.IntegerValue(opts.IndexChildren),
+		}), nil
+	}
+	return NOMessage, nil
+}
+
+// geoIndexCommand returns the command that sets the options of a key.
+func geoIndexCommand(key string, opts *geojson.ParseOptions) []string {
+	return []string{"setgeoindex", key,
+		"kind", geoIndexKinds[opts.IndexGeometryKind],
+		"points", strconv.Itoa(opts.IndexGeometry),
+		"multi", strconv.Itoa(opts.IndexChildren),
+	}
+}
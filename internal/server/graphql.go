@@ -0,0 +1,797 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/graphql"
+)
+
+// graphqlContentType is the content type of the responses of /graphql.
+const graphqlContentType = "application/json; charset=utf-8"
+
+// graphqlArgs are the arguments of the fields of the schema, which is:
+//
+//	type Query {
+//	  collections(match: String = "*"): [Collection!]!
+//	  collection(key: String!): Collection
+//	  object(key: String!, id: String!): Object
+//	  nearby(key: String!, lat: Float!, lon: Float!, radius: Float,
+//	    match: String, where: [Where!], cursor: Int, limit: Int): Search!
+//	  within(key: String!, bounds: Bounds, object: String,
+//	    match: String, where: [Where!], cursor: Int, limit: Int): Search!
+//	  intersects(key: String!, bounds: Bounds, object: String,
+//	    match: String, where: [Where!], cursor: Int, limit: Int): Search!
+//	  scan(key: String!, match: String, desc: Boolean,
+//	    where: [Where!], cursor: Int, limit: Int): Search!
+//	}
+//	type Collection {
+//	  key: String!
+//	  count: Int!
+//	  points: Int!
+//	  memory: Int!
+//	  bounds: JSON
+//	  object(id: String!): Object
+//	  objects(match: String, desc: Boolean, where: [Where!], cursor: Int,
+//	    limit: Int): Search!
+//	}
+//	type Search { objects: [Object!]!  count: Int!  cursor: Int! }
+//	type Object {
+//	  key: String!
+//	  id: String!
+//	  object: JSON!
+//	  fields: [Field!]!
+//	  field(name: String!): JSON
+//	  distance: Float
+//	}
+//	type Field { name: String!  value: JSON! }
+//	input Bounds { minLat: Float!  minLon: Float!  maxLat: Float!  maxLon: Float! }
+//	input Where { field: String!  min: Float!  max: Float! }
+//
+// The JSON scalar is GeoJSON for the objects and bounds, and the json of
+// the values of the fields.
+var graphqlArgs = map[string][]string{
+	"Query.collections": {"match"},
+	"Query.collection":  {"key"},
+	"Query.object":      {"key", "id"},
+	"Query.nearby": {"key", "lat", "lon", "radius", "match", "where",
+		"cursor", "limit"},
+	"Query.within": {"key", "bounds", "object", "match", "where", "cursor",
+		"limit"},
+	"Query.intersects": {"key", "bounds", "object", "match", "where",
+		"cursor", "limit"},
+	"Query.scan":         {"key", "match", "desc", "where", "cursor", "limit"},
+	"Collection.object":  {"id"},
+	"Collection.objects": {"match", "desc", "where", "cursor", "limit"},
+	"Object.field":       {"name"},
+}
+
+// graphqlTypes are the fields of the object types, and their types, where
+// the types that are not object types are the scalars.
+var graphqlTypes = map[string]map[string]string{
+	"Query": {"collections": "Collection", "collection": "Collection",
+		"object": "Object", "nearby": "Search", "within": "Search",
+		"intersects": "Search", "scan": "Search"},
+	"Collection": {"key": "String", "count": "Int", "points": "Int",
+		"memory": "Int", "bounds": "JSON", "object": "Object",
+		"objects": "Search"},
+	"Search": {"objects": "Object", "count": "Int", "cursor": "Int"},
+	"Object": {"key": "String", "id": "String", "object": "JSON",
+		"fields": "Field", "field": "JSON", "distance": "Float"},
+	"Field": {"name": "String", "value": "JSON"},
+}
+
+// GRAPHQL query [variables [operation]]
+//
+// Executes a GraphQL query on the collections, which is also served at
+// /graphql by the HTTP transport, when the graphql config property is yes.
+// The reply is the json of the GraphQL response, with the data or errors. The
+// resolvers only reach the keys that the user of the client may access.
+func (s *Server) cmdGraphQL(msg *Message, client *Client) (resp.Value, error) {
+	if !s.config.graphql() {
+		return NOMessage, clientErrorf(
+			"GraphQL is disabled, see CONFIG SET graphql yes")
+	}
+	if len(msg.Args) < 2 || len(msg.Args) > 4 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	var vars map[string]interface{}
+	if len(msg.Args) > 2 && strings.TrimSpace(msg.Args[2]) != "" {
+		dec := json.NewDecoder(strings.NewReader(msg.Args[2]))
+		dec.UseNumber()
+		if err := dec.Decode(&vars); err != nil {
+			return NOMessage, errInvalidArgument(msg.Args[2])
+		}
+	}
+	var operation string
+	if len(msg.Args) > 3 {
+		operation = msg.Args[3]
+	}
+	return resp.BytesValue(s.graphqlExecute(client, msg.Args[1], vars,
+		operation)), nil
+}
+
+// graphqlHTTPArgs returns the arguments of GRAPHQL for a request of
+// /graphql, which is a GET with the query parameters, or a POST with a json
+// body or the query as the body.
+func graphqlHTTPArgs(path string, body []byte) ([]string, bool) {
+	if path != "graphql" && !strings.HasPrefix(path, "graphql?") {
+		return nil, false
+	}
+	args := []string{"graphql", "", "", ""}
+	if len(body) > 0 {
+		if res := gjson.ParseBytes(body); res.IsObject() {
+			args[1] = res.Get("query").String()
+			if vars := res.Get("variables"); vars.IsObject() {
+				args[2] = vars.Raw
+			}
+			args[3] = res.Get("operationName").String()
+		} else {
+			args[1] = string(body)
+		}
+		return args, true
+	}
+	// the path is unescaped
+	if i := strings.IndexByte(path, '?'); i != -1 {
+		for _, param := range strings.Split(path[i+1:], "&") {
+			switch {
+			case strings.HasPrefix(param, "query="):
+				args[1] = param[len("query="):]
+			case strings.HasPrefix(param, "variables="):
+				args[2] = param[len("variables="):]
+			case strings.HasPrefix(param, "operationName="):
+				args[3] = param[len("operationName="):]
+			}
+		}
+	}
+	return args, true
+}
+
+// graphqlError is an error of a field, with the path of the field.
+type graphqlError struct {
+	msg  string
+	path []interface{}
+}
+
+func (err *graphqlError) Error() string {
+	return err.msg
+}
+
+// graphqlExec is the execution of an operation, which holds the read lock.
+type graphqlExec struct {
+	s      *Server
+	client *Client // the keys are limited to those of its user of the ACL
+	doc    *graphql.Document
+	vars   map[string]interface{}
+	path   []interface{}
+}
+
+// graphqlObject is an object of a collection.
+type graphqlObject struct {
+	key, id     string
+	object      string // the geojson
+	fields      [][2]string
+	distance    float64
+	hasDistance bool
+}
+
+// graphqlExecute executes a query and returns the json of the response.
+func (s *Server) graphqlExecute(client *Client, query string,
+	vars map[string]interface{}, operation string,
+) []byte {
+	doc, err := graphql.Parse(query)
+	if err != nil {
+		return graphqlErrors(nil, err)
+	}
+	op, err := doc.Operation(operation)
+	if err != nil {
+		return graphqlErrors(nil, err)
+	}
+	values, err := op.Values(vars)
+	if err != nil {
+		return graphqlErrors(nil, err)
+	}
+	e := &graphqlExec{s: s, client: client, doc: doc, vars: values}
+	data, err := e.selections(nil, "Query", op.Selections, nil)
+	if err != nil {
+		return graphqlErrors(nil, err)
+	}
+	return append(append([]byte(`{"data":`), data...), '}')
+}
+
+// graphqlErrors returns the json of a response with an error.
+func graphqlErrors(dst []byte, err error) []byte {
+	dst = append(dst, `{"errors":[{"message":`...)
+	dst = appendJSONString(dst, err.Error())
+	var gerr *graphqlError
+	if errors.As(err, &gerr) && len(gerr.path) > 0 {
+		dst = append(dst, `,"path":[`...)
+		for i, p := range gerr.path {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			switch p := p.(type) {
+			case string:
+				dst = appendJSONString(dst, p)
+			case int:
+				dst = strconv.AppendInt(dst, int64(p), 10)
+			}
+		}
+		dst = append(dst, ']')
+	}
+	return append(dst, `}],"data":null}`...)
+}
+
+func (e *graphqlExec) errorf(format string, args ...interface{}) error {
+	return &graphqlError{
+		msg:  fmt.Sprintf(format, args...),
+		path: append([]interface{}(nil), e.path...),
+	}
+}
+
+// permit returns an error when the client may not access a key.
+func (e *graphqlExec) permit(key string) error {
+	if err := e.s.aclPermitKey(e.client, key); err != nil {
+		return e.errorf("%s", err.Error())
+	}
+	return nil
+}
+
+// selections appends the json object of the selected fields of a value.
+func (e *graphqlExec) selections(dst []byte, typ string,
+	sels []graphql.Selection, v interface{},
+) ([]byte, error) {
+	fields, err := e.doc.CollectFields(typ, sels, e.vars)
+	if err != nil {
+		return nil, err
+	}
+	dst = append(dst, '{')
+	for i, f := range fields {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, f.Key())
+		dst = append(dst, ':')
+		e.path = append(e.path, f.Key())
+		dst, err = e.field(dst, typ, f, v)
+		e.path = e.path[:len(e.path)-1]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, '}'), nil
+}
+
+// field appends the json of a field of a value.
+func (e *graphqlExec) field(dst []byte, typ string, f *graphql.Field,
+	v interface{},
+) ([]byte, error) {
+	if f.Name == "__typename" {
+		return appendJSONString(dst, typ), nil
+	}
+	ftyp, ok := graphqlTypes[typ][f.Name]
+	if !ok {
+		return nil, e.errorf("Cannot query field \"%s\" on type \"%s\".",
+			f.Name, typ)
+	}
+	if _, object := graphqlTypes[ftyp]; object && len(f.Selections) == 0 {
+		return nil, e.errorf("Field \"%s\" of type \"%s\" must have a "+
+			"selection of subfields.", f.Name, ftyp)
+	} else if !object && len(f.Selections) > 0 {
+		return nil, e.errorf("Field \"%s\" must not have a selection since "+
+			"type \"%s\" has no subfields.", f.Name, ftyp)
+	}
+	args := make(map[string]interface{})
+	for _, arg := range f.Arguments {
+		valid := false
+		for _, name := range graphqlArgs[typ+"."+f.Name] {
+			valid = valid || name == arg.Name
+		}
+		if !valid {
+			return nil, e.errorf("Unknown argument \"%s\" on field "+
+				"\"%s.%s\".", arg.Name, typ, f.Name)
+		}
+		args[arg.Name] = graphql.Value(arg.Value, e.vars)
+	}
+	a := graphqlArgValues{e: e, args: args}
+	switch typ {
+	case "Query":
+		return e.queryField(dst, f, a)
+	case "Collection":
+		return e.collectionField(dst, f, a, v.(string))
+	case "Search":
+		return e.searchField(dst, f, v.(gjson.Result))
+	case "Object":
+		return e.objectField(dst, f, a, v.(*graphqlObject))
+	case "Field":
+		field := v.([2]string)
+		if f.Name == "name" {
+			return appendJSONString(dst, field[0]), nil
+		}
+		return append(dst, field[1]...), nil
+	}
+	return nil, e.errorf("Unknown type \"%s\".", typ)
+}
+
+// command runs a read command and returns its json output.
+func (e *graphqlExec) command(args ...string) (gjson.Result, error) {
+	msg := &Message{Args: args, OutputType: JSON}
+	res, _, err := e.s.command(msg, nil)
+	if err != nil {
+		return gjson.Result{}, e.errorf("%s", err.Error())
+	}
+	if res.Type() == resp.Error {
+		return gjson.Result{}, e.errorf("%s", res.String())
+	}
+	return gjson.Parse(res.String()), nil
+}
+
+func (e *graphqlExec) queryField(dst []byte, f *graphql.Field,
+	a graphqlArgValues,
+) ([]byte, error) {
+	switch f.Name {
+	case "collections":
+		match, err := a.str("match", false)
+		if err != nil {
+			return nil, err
+		}
+		if match == "" {
+			match = "*"
+		}
+		res, err := e.command("keys", match)
+		if err != nil {
+			return nil, err
+		}
+		dst = append(dst, '[')
+		var i int
+		for _, key := range res.Get("keys").Array() {
+			if !e.s.aclAllowedKey(e.client, key.String()) {
+				continue
+			}
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			e.path = append(e.path, i)
+			dst, err = e.selections(dst, "Collection", f.Selections,
+				key.String())
+			e.path = e.path[:len(e.path)-1]
+			if err != nil {
+				return nil, err
+			}
+			i++
+		}
+		return append(dst, ']'), nil
+	case "collection":
+		key, err := a.str("key", true)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.permit(key); err != nil {
+			return nil, err
+		}
+		if e.s.getCol(key) == nil {
+			return append(dst, "null"...), nil
+		}
+		return e.selections(dst, "Collection", f.Selections, key)
+	case "object":
+		key, err := a.str("key", true)
+		if err != nil {
+			return nil, err
+		}
+		id, err := a.str("id", true)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.permit(key); err != nil {
+			return nil, err
+		}
+		return e.object(dst, f, key, id)
+	}
+	// the searches
+	key, err := a.str("key", true)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.permit(key); err != nil {
+		return nil, err
+	}
+	args := []string{f.Name, key}
+	if args, err = a.search(args); err != nil {
+		return nil, err
+	}
+	switch f.Name {
+	case "scan":
+		if desc, err := a.boolean("desc"); err != nil {
+			return nil, err
+		} else if desc {
+			args = append(args, "DESC")
+		}
+	case "nearby":
+		lat, _, err := a.float("lat", true)
+		if err != nil {
+			return nil, err
+		}
+		lon, _, err := a.float("lon", true)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "DISTANCE")
+		args = append(args, e.searchOutput(f)...)
+		args = append(args, "POINT", formatFloat(lat), formatFloat(lon))
+		if radius, ok, err := a.float("radius", false); err != nil {
+			return nil, err
+		} else if ok {
+			args = append(args, formatFloat(radius))
+		}
+		return e.search(dst, f, key, args)
+	case "within", "intersects":
+		args = append(args, e.searchOutput(f)...)
+		bounds, hasBounds := a.args["bounds"]
+		object, err := a.str("object", false)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case hasBounds && bounds != nil && object == "":
+			b, ok := bounds.(map[string]interface{})
+			if !ok {
+				return nil, e.errorf("Argument \"bounds\" has an invalid value.")
+			}
+			ba := graphqlArgValues{e: e, args: b}
+			args = append(args, "BOUNDS")
+			for _, name := range []string{"minLat", "minLon", "maxLat",
+				"maxLon"} {
+				v, _, err := ba.float(name, true)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, formatFloat(v))
+			}
+		case object != "" && (!hasBounds || bounds == nil):
+			args = append(args, "OBJECT", object)
+		default:
+			return nil, e.errorf("Field \"%s\" requires one of the "+
+				"arguments \"bounds\" or \"object\".", f.Name)
+		}
+		return e.search(dst, f, key, args)
+	}
+	return e.search(dst, f, key, append(args, e.searchOutput(f)...))
+}
+
+func (e *graphqlExec) collectionField(dst []byte, f *graphql.Field,
+	a graphqlArgValues, key string,
+) ([]byte, error) {
+	switch f.Name {
+	case "key":
+		return appendJSONString(dst, key), nil
+	case "count", "points", "memory":
+		res, err := e.command("stats", key)
+		if err != nil {
+			return nil, err
+		}
+		stat := map[string]string{"count": "num_objects",
+			"points": "num_points", "memory": "in_memory_size"}[f.Name]
+		return strconv.AppendInt(dst, res.Get("stats.0."+stat).Int(), 10),
+			nil
+	case "bounds":
+		res, err := e.command("bounds", key)
+		if err != nil {
+			return nil, err
+		}
+		if b := res.Get("bounds"); b.Exists() {
+			return append(dst, b.Raw...), nil
+		}
+		return append(dst, "null"...), nil
+	case "object":
+		id, err := a.str("id", true)
+		if err != nil {
+			return nil, err
+		}
+		return e.object(dst, f, key, id)
+	}
+	// objects
+	args, err := a.search([]string{"scan", key})
+	if err != nil {
+		return nil, err
+	}
+	if desc, err := a.boolean("desc"); err != nil {
+		return nil, err
+	} else if desc {
+		args = append(args, "DESC")
+	}
+	return e.search(dst, f, key, append(args, e.searchOutput(f)...))
+}
+
+// object appends an object, or null when the object does not exist.
+func (e *graphqlExec) object(dst []byte, f *graphql.Field, key, id string,
+) ([]byte, error) {
+	msg := &Message{Args: []string{"get", key, id, "withfields", "object"},
+		OutputType: JSON}
+	res, err := e.s.cmdGet(msg)
+	if err == errKeyNotFound || err == errIDNotFound {
+		return append(dst, "null"...), nil
+	} else if err != nil {
+		return nil, e.errorf("%s", err.Error())
+	}
+	json := gjson.Parse(res.String())
+	obj := &graphqlObject{key: key, id: id, object: json.Get("object").Raw}
+	json.Get("fields").ForEach(func(name, value gjson.Result) bool {
+		obj.fields = append(obj.fields, [2]string{name.String(), value.Raw})
+		return true
+	})
+	return e.selections(dst, "Object", f.Selections, obj)
+}
+
+// searchOutput returns the output of a search, which is only the count when
+// the objects are not selected, and only the ids when the objects are not
+// otherwise selected.
+func (e *graphqlExec) searchOutput(f *graphql.Field) []string {
+	fields, err := e.doc.CollectFields("Search", f.Selections, e.vars)
+	if err != nil {
+		// the error is returned by the selections
+		return nil
+	}
+	var objects []*graphql.Field
+	for _, f := range fields {
+		if f.Name == "objects" {
+			objects = append(objects, f)
+		}
+	}
+	if len(objects) == 0 {
+		return []string{"COUNT"}
+	}
+	for _, f := range objects {
+		fields, err := e.doc.CollectFields("Object", f.Selections, e.vars)
+		if err != nil {
+			return nil
+		}
+		for _, f := range fields {
+			switch f.Name {
+			case "object", "fields", "field", "distance":
+				return nil
+			}
+		}
+	}
+	return []string{"IDS"}
+}
+
+// search runs a search and appends its selected fields.
+func (e *graphqlExec) search(dst []byte, f *graphql.Field, key string,
+	args []string,
+) ([]byte, error) {
+	for _, i := range refKeys(args[0], args) {
+		// the key of the object of the area
+		if err := e.permit(args[i]); err != nil {
+			return nil, err
+		}
+	}
+	res, err := e.command(args...)
+	if err != nil {
+		return nil, err
+	}
+	// the key of the objects
+	res = gjson.Parse(`{"key":` + jsonString(key) + `,"res":` + res.Raw + `}`)
+	return e.selections(dst, "Search", f.Selections, res)
+}
+
+func (e *graphqlExec) searchField(dst []byte, f *graphql.Field,
+	res gjson.Result,
+) ([]byte, error) {
+	switch f.Name {
+	case "count", "cursor":
+		return strconv.AppendUint(dst, res.Get("res."+f.Name).Uint(), 10), nil
+	}
+	key := res.Get("key").String()
+	names := res.Get("res.fields").Array()
+	dst = append(dst, '[')
+	var objects []gjson.Result
+	if ids := res.Get("res.ids"); ids.Exists() {
+		objects = ids.Array()
+	} else {
+		objects = res.Get("res.objects").Array()
+	}
+	for i, o := range objects {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		obj := &graphqlObject{key: key}
+		if o.Type == gjson.String {
+			obj.id = o.String()
+		} else {
+			obj.id = o.Get("id").String()
+			obj.object = o.Get("object").Raw
+			if d := o.Get("distance"); d.Exists() {
+				obj.distance, obj.hasDistance = d.Float(), true
+			}
+			for j, value := range o.Get("fields").Array() {
+				if j < len(names) {
+					obj.fields = append(obj.fields,
+						[2]string{names[j].String(), value.Raw})
+				}
+			}
+		}
+		e.path = append(e.path, i)
+		var err error
+		dst, err = e.selections(dst, "Object", f.Selections, obj)
+		e.path = e.path[:len(e.path)-1]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, ']'), nil
+}
+
+func (e *graphqlExec) objectField(dst []byte, f *graphql.Field,
+	a graphqlArgValues, obj *graphqlObject,
+) ([]byte, error) {
+	switch f.Name {
+	case "key":
+		return appendJSONString(dst, obj.key), nil
+	case "id":
+		return appendJSONString(dst, obj.id), nil
+	case "object":
+		return append(dst, obj.object...), nil
+	case "distance":
+		if !obj.hasDistance {
+			return append(dst, "null"...), nil
+		}
+		return strconv.AppendFloat(dst, obj.distance, 'f', -1, 64), nil
+	case "field":
+		name, err := a.str("name", true)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range obj.fields {
+			if field[0] == name {
+				return append(dst, field[1]...), nil
+			}
+		}
+		return append(dst, "null"...), nil
+	}
+	// fields
+	dst = append(dst, '[')
+	for i, field := range obj.fields {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		e.path = append(e.path, i)
+		var err error
+		dst, err = e.selections(dst, "Field", f.Selections, field)
+		e.path = e.path[:len(e.path)-1]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, ']'), nil
+}
+
+// graphqlArgValues are the values of the arguments of a field, which are
+// coerced to the types of the arguments.
+type graphqlArgValues struct {
+	e    *graphqlExec
+	args map[string]interface{}
+}
+
+func (a graphqlArgValues) invalid(name string) error {
+	return a.e.errorf("Argument \"%s\" has an invalid value.", name)
+}
+
+func (a graphqlArgValues) str(name string, required bool) (string, error) {
+	switch v := a.args[name].(type) {
+	case string:
+		return v, nil
+	case nil:
+		if required {
+			return "", a.e.errorf("Argument \"%s\" is required.", name)
+		}
+		return "", nil
+	}
+	return "", a.invalid(name)
+}
+
+func (a graphqlArgValues) float(name string, required bool) (float64, bool,
+	error,
+) {
+	switch v := a.args[name].(type) {
+	case float64:
+		return v, true, nil
+	case int64:
+		return float64(v), true, nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return 0, false, a.invalid(name)
+		}
+		return f, true, nil
+	case nil:
+		if required {
+			return 0, false, a.e.errorf("Argument \"%s\" is required.", name)
+		}
+		return 0, false, nil
+	}
+	return 0, false, a.invalid(name)
+}
+
+func (a graphqlArgValues) int(name string) (uint64, error) {
+	f, _, err := a.float(name, false)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 || f != math.Trunc(f) {
+		return 0, a.invalid(name)
+	}
+	return uint64(f), nil
+}
+
+func (a graphqlArgValues) boolean(name string) (bool, error) {
+	switch v := a.args[name].(type) {
+	case bool:
+		return v, nil
+	case nil:
+		return false, nil
+	}
+	return false, a.invalid(name)
+}
+
+// search appends the options of a search, which are the cursor, limit,
+// match of the ids, and where filters.
+func (a graphqlArgValues) search(args []string) ([]string, error) {
+	cursor, err := a.int("cursor")
+	if err != nil {
+		return nil, err
+	}
+	if cursor != 0 {
+		args = append(args, "CURSOR", strconv.FormatUint(cursor, 10))
+	}
+	limit, err := a.int("limit")
+	if err != nil {
+		return nil, err
+	}
+	if limit != 0 {
+		args = append(args, "LIMIT", strconv.FormatUint(limit, 10))
+	}
+	match, err := a.str("match", false)
+	if err != nil {
+		return nil, err
+	}
+	if match != "" {
+		args = append(args, "MATCH", match)
+	}
+	where, ok := a.args["where"].([]interface{})
+	if !ok && a.args["where"] != nil {
+		// a single item is a list of one
+		where = []interface{}{a.args["where"]}
+	}
+	for _, w := range where {
+		m, ok := w.(map[string]interface{})
+		if !ok {
+			return nil, a.invalid("where")
+		}
+		wa := graphqlArgValues{e: a.e, args: m}
+		field, err := wa.str("field", true)
+		if err != nil {
+			return nil, err
+		}
+		min, _, err := wa.float("min", true)
+		if err != nil {
+			return nil, err
+		}
+		max, _, err := wa.float("max", true)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "WHERE", field, formatFloat(min),
+			formatFloat(max))
+	}
+	return args, nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/internal/deadline"
+	"github.com/tidwall/tile38/internal/qservice"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcService is the query service of the --grpc-port. The calls are run as
+// the commands of a client, like the HTTP requests, and the typed replies
+// are read from the json output of the commands.
+type grpcService struct {
+	s *Server
+}
+
+// serveGRPC serves the query service on a listener.
+func (s *Server) serveGRPC(ln net.Listener) error {
+	gs := grpc.NewServer()
+	qservice.RegisterQueryServiceServer(gs, &grpcService{s: s})
+	return gs.Serve(ln)
+}
+
+// newClient returns the client of a call, which is denied by protected mode
+// when it is not from the loopback.
+func (g *grpcService) newClient(ctx context.Context) (*Client, error) {
+	client := new(Client)
+	client.id = int(atomic.AddInt64(&g.s.clientID, 1))
+	client.opened = time.Now()
+	client.last = client.opened
+	if p, ok := peer.FromContext(ctx); ok {
+		client.remoteAddr = p.Addr.String()
+	}
+	if !strings.HasPrefix(client.remoteAddr, "127.0.0.1:") &&
+		!strings.HasPrefix(client.remoteAddr, "[::1]:") &&
+		g.s.isProtected() {
+		return nil, status.Error(codes.PermissionDenied,
+			"Tile38 is running in protected mode")
+	}
+	return client, nil
+}
+
+// authorization returns the password of the authorization metadata.
+func authorization(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get("authorization"); len(vals) > 0 {
+			return vals[0]
+		}
+	}
+	return ""
+}
+
+// do runs a command and returns its json output, or the error of the
+// command as a status.
+func (g *grpcService) do(ctx context.Context, args ...string) (
+	gjson.Result, error,
+) {
+	client, err := g.newClient(ctx)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	msg := &Message{
+		Args:       args,
+		ConnType:   GRPC,
+		OutputType: JSON,
+		Auth:       authorization(ctx),
+	}
+	if dl, ok := ctx.Deadline(); ok && !isWriteCommand(msg.Command()) {
+		msg.Deadline = deadline.New(dl)
+	}
+	out, err := g.s.exec(client, msg)
+	if err != nil {
+		return gjson.Result{}, status.Error(codes.Internal, err.Error())
+	}
+	res := gjson.ParseBytes(out)
+	if !res.Get("ok").Bool() {
+		return res, grpcError(res.Get("err").String())
+	}
+	return res, nil
+}
+
+// isWriteCommand returns true for the commands of the service that write,
+// which do not have a deadline.
+func isWriteCommand(command string) bool {
+	return command == "set"
+}
+
+// grpcError returns the status of the error of a command.
+func grpcError(errMsg string) error {
+	code := codes.Unknown
+	switch errMsg {
+	case "authentication required", "invalid password":
+		code = codes.Unauthenticated
+	case errKeyNotFound.Error(), errIDNotFound.Error():
+		code = codes.NotFound
+	case errIDAlreadyExists.Error():
+		code = codes.AlreadyExists
+	case "timeout":
+		code = codes.DeadlineExceeded
+	case "read only", "catching up to leader":
+		code = codes.Unavailable
+	default:
+		if errMsg == errInvalidNumberOfArguments.Error() ||
+			strings.HasPrefix(errMsg, "invalid argument") {
+			code = codes.InvalidArgument
+		}
+	}
+	return status.Error(code, errMsg)
+}
+
+// fieldText returns the text of the FIELD argument of a json value.
+func fieldText(v gjson.Result) string {
+	if v.Type == gjson.String {
+		return v.String()
+	}
+	return v.Raw
+}
+
+// appendWhere appends the WHERE filters of a search.
+func appendWhere(args []string, where []*qservice.Where) []string {
+	for _, w := range where {
+		args = append(args, "WHERE", w.Field,
+			strconv.FormatFloat(w.Min, 'f', -1, 64),
+			strconv.FormatFloat(w.Max, 'f', -1, 64))
+	}
+	return args
+}
+
+// appendPage appends the CURSOR and LIMIT of a search.
+func appendPage(args []string, cursor, limit uint64) []string {
+	if cursor != 0 {
+		args = append(args, "CURSOR", strconv.FormatUint(cursor, 10))
+	}
+	if limit != 0 {
+		args = append(args, "LIMIT", strconv.FormatUint(limit, 10))
+	}
+	return args
+}
+
+func (g *grpcService) Set(ctx context.Context, req *qservice.SetRequest,
+) (*qservice.SetReply, error) {
+	args := []string{"SET", req.Key, req.Id}
+	for _, f := range req.Fields {
+		args = append(args, "FIELD", f.Name, f.Value)
+	}
+	if req.Ex != 0 {
+		args = append(args, "EX", strconv.FormatFloat(req.Ex, 'f', -1, 64))
+	}
+	if req.Nx {
+		args = append(args, "NX")
+	}
+	if req.Xx {
+		args = append(args, "XX")
+	}
+	switch {
+	case req.Point != nil:
+		args = append(args, "POINT",
+			strconv.FormatFloat(req.Point.Lat, 'f', -1, 64),
+			strconv.FormatFloat(req.Point.Lon, 'f', -1, 64))
+		if req.Point.Z != 0 {
+			args = append(args, strconv.FormatFloat(req.Point.Z, 'f', -1, 64))
+		}
+	case req.Object != "":
+		args = append(args, "OBJECT", req.Object)
+	default:
+		return nil, status.Error(codes.InvalidArgument,
+			"a point or an object is required")
+	}
+	if _, err := g.do(ctx, args...); err != nil {
+		// the object is not set because of NX or XX
+		switch status.Convert(err).Message() {
+		case errIDAlreadyExists.Error():
+			if req.Nx {
+				return &qservice.SetReply{}, nil
+			}
+		case errIDNotFound.Error():
+			if req.Xx {
+				return &qservice.SetReply{}, nil
+			}
+		}
+		return nil, err
+	}
+	return &qservice.SetReply{Ok: true}, nil
+}
+
+func (g *grpcService) Get(ctx context.Context, req *qservice.GetRequest,
+) (*qservice.GetReply, error) {
+	args := []string{"GET", req.Key, req.Id}
+	if req.WithFields {
+		args = append(args, "WITHFIELDS")
+	}
+	res, err := g.do(ctx, append(args, "OBJECT")...)
+	if err != nil {
+		return nil, err
+	}
+	reply := &qservice.GetReply{Object: res.Get("object").Raw}
+	res.Get("fields").ForEach(func(name, value gjson.Result) bool {
+		reply.Fields = append(reply.Fields, &qservice.Field{
+			Name:  name.String(),
+			Value: fieldText(value),
+		})
+		return true
+	})
+	return reply, nil
+}
+
+func (g *grpcService) Nearby(ctx context.Context, req *qservice.NearbyRequest,
+) (*qservice.SearchReply, error) {
+	if req.Point == nil {
+		return nil, status.Error(codes.InvalidArgument, "a point is required")
+	}
+	args := appendPage([]string{"NEARBY", req.Key}, req.Cursor, req.Limit)
+	args = appendWhere(args, req.Where)
+	args = append(args, "DISTANCE", "POINT",
+		strconv.FormatFloat(req.Point.Lat, 'f', -1, 64),
+		strconv.FormatFloat(req.Point.Lon, 'f', -1, 64))
+	if req.Radius != 0 {
+		args = append(args, strconv.FormatFloat(req.Radius, 'f', -1, 64))
+	}
+	return g.search(ctx, args)
+}
+
+func (g *grpcService) Within(ctx context.Context, req *qservice.WithinRequest,
+) (*qservice.SearchReply, error) {
+	args := appendPage([]string{"WITHIN", req.Key}, req.Cursor, req.Limit)
+	args = appendWhere(args, req.Where)
+	switch {
+	case req.Bounds != nil:
+		args = append(args, "BOUNDS",
+			strconv.FormatFloat(req.Bounds.MinLat, 'f', -1, 64),
+			strconv.FormatFloat(req.Bounds.MinLon, 'f', -1, 64),
+			strconv.FormatFloat(req.Bounds.MaxLat, 'f', -1, 64),
+			strconv.FormatFloat(req.Bounds.MaxLon, 'f', -1, 64))
+	case req.Object != "":
+		args = append(args, "OBJECT", req.Object)
+	default:
+		return nil, status.Error(codes.InvalidArgument,
+			"bounds or an object is required")
+	}
+	return g.search(ctx, args)
+}
+
+func (g *grpcService) Scan(ctx context.Context, req *qservice.ScanRequest,
+) (*qservice.SearchReply, error) {
+	args := appendPage([]string{"SCAN", req.Key}, req.Cursor, req.Limit)
+	if req.Match != "" {
+		args = append(args, "MATCH", req.Match)
+	}
+	if req.Desc {
+		args = append(args, "DESC")
+	}
+	return g.search(ctx, appendWhere(args, req.Where))
+}
+
+// search runs a search, where the fields of the objects are in the order of
+// the fields of the reply.
+func (g *grpcService) search(ctx context.Context, args []string,
+) (*qservice.SearchReply, error) {
+	res, err := g.do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	names := res.Get("fields").Array()
+	reply := &qservice.SearchReply{
+		Count:  res.Get("count").Uint(),
+		Cursor: res.Get("cursor").Uint(),
+	}
+	res.Get("objects").ForEach(func(_, o gjson.Result) bool {
+		obj := &qservice.SearchObject{
+			Id:       o.Get("id").String(),
+			Object:   o.Get("object").Raw,
+			Distance: o.Get("distance").Float(),
+		}
+		for i, value := range o.Get("fields").Array() {
+			if i < len(names) {
+				obj.Fields = append(obj.Fields, &qservice.Field{
+					Name:  names[i].String(),
+					Value: fieldText(value),
+				})
+			}
+		}
+		reply.Objects = append(reply.Objects, obj)
+		return true
+	})
+	return reply, nil
+}
+
+func (g *grpcService) Command(ctx context.Context, req *qservice.CommandRequest,
+) (*qservice.Reply, error) {
+	if len(req.Args) == 0 {
+		return nil, grpcError(errInvalidNumberOfArguments.Error())
+	}
+	res, err := g.do(ctx, req.Args...)
+	if err != nil {
+		return nil, err
+	}
+	return replyOfJSON([]byte(res.Raw)), nil
+}
+
+func (g *grpcService) Subscribe(req *qservice.SubscribeRequest,
+	stream qservice.QueryService_SubscribeServer,
+) error {
+	ctx := stream.Context()
+	if _, err := g.newClient(ctx); err != nil {
+		return err
+	}
+	if g.s.config.requirePass() != "" {
+		auth := authorization(ctx)
+		if strings.TrimSpace(auth) == "" {
+			return grpcError("authentication required")
+		}
+		if !g.s.checkRequirePass(auth) {
+			return grpcError("invalid password")
+		}
+	}
+	if len(req.Channels) == 0 {
+		return grpcError(errInvalidNumberOfArguments.Error())
+	}
+	return g.s.Subscribe(ctx, req.Channels, req.Pattern,
+		func(channel, message string) error {
+			return stream.Send(fenceEvent(submsg{
+				channel: channel,
+				message: message,
+			}))
+		})
+}
+
+// fenceEvent returns the event of a message of a channel. A message that is
+// not json, such as from PUBLISH, is only the json of the event.
+func fenceEvent(msg submsg) *qservice.FenceEvent {
+	ev := &qservice.FenceEvent{Channel: msg.channel, Json: msg.message}
+	if gjson.Valid(msg.message) {
+		res := gjson.Parse(msg.message)
+		ev.Command = res.Get("command").String()
+		ev.Detect = res.Get("detect").String()
+		ev.Key = res.Get("key").String()
+		ev.Id = res.Get("id").String()
+		ev.Time = res.Get("time").String()
+		ev.Object = res.Get("object").Raw
+	}
+	return ev
+}
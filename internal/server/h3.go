@@ -0,0 +1,104 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/tile38/core"
+	"github.com/tidwall/tile38/internal/h3"
+)
+
+const (
+	h3MaxRes       = 15
+	h3NumBaseCells = 122
+	// h3GroupPrefix is the prefix of a GROUPBY that groups the objects by
+	// the H3 cell of their center, such as H3:7.
+	h3GroupPrefix = "h3:"
+)
+
+// parseH3 returns the index of an H3 cell, which is written in hex.
+func parseH3(s string) (uint64, bool) {
+	cell, err := strconv.ParseUint(s, 16, 64)
+	if err != nil || !h3IsCell(cell) {
+		return 0, false
+	}
+	return cell, true
+}
+
+// h3IsCell returns true when an index has the layout of a cell, which is the
+// cell mode, a resolution, a base cell, and a digit from 0 to 6 for each
+// resolution, with the digits past the resolution set to 7.
+func h3IsCell(cell uint64) bool {
+	if cell>>63 != 0 || (cell>>59)&15 != 1 || (cell>>56)&7 != 0 {
+		return false
+	}
+	if (cell>>45)&127 >= h3NumBaseCells {
+		return false
+	}
+	res := int(cell>>52) & 15
+	for r := 1; r <= h3MaxRes; r++ {
+		digit := (cell >> (uint(h3MaxRes-r) * 3)) & 7
+		if (r <= res) != (digit != 7) {
+			return false
+		}
+	}
+	return true
+}
+
+// h3Indexer returns the core.H3 indexer, or the indexer of the h3 package
+// when it's not set.
+func h3Indexer() core.H3Indexer {
+	if core.H3 != nil {
+		return core.H3
+	}
+	return h3.Indexer{}
+}
+
+func formatH3(cell uint64) string {
+	return strconv.FormatUint(cell, 16)
+}
+
+// parseH3Res returns an H3 resolution, from 0 to 15.
+func parseH3Res(s string) (int, error) {
+	res, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || res > h3MaxRes {
+		return 0, errInvalidArgument(s)
+	}
+	return int(res), nil
+}
+
+// parseH3Group returns the resolution of a GROUPBY H3:res, or -1 when the
+// objects are grouped by a field.
+func parseH3Group(groupBy string) (int, error) {
+	if len(groupBy) <= len(h3GroupPrefix) ||
+		strings.ToLower(groupBy[:len(h3GroupPrefix)]) != h3GroupPrefix {
+		return -1, nil
+	}
+	return parseH3Res(groupBy[len(h3GroupPrefix):])
+}
+
+// h3Cell returns the cell that contains the center of an object.
+func h3Cell(o geojson.Object, res int) string {
+	center := o.Center()
+	return formatH3(h3Indexer().LatLngToCell(center.Y, center.X, res))
+}
+
+// h3Area returns the polygon of a cell for a HEX area.
+func h3Area(s string) (geojson.Object, error) {
+	cell, ok := parseH3(s)
+	if !ok {
+		return nil, errInvalidArgument(s)
+	}
+	boundary := h3Indexer().CellToBoundary(cell)
+	if len(boundary) < 3 {
+		return nil, errInvalidArgument(s)
+	}
+	points := make([]geometry.Point, 0, len(boundary)+1)
+	for _, v := range boundary {
+		points = append(points, geometry.Point{X: v[1], Y: v[0]})
+	}
+	points = append(points, points[0])
+	return geojson.NewPolygon(geometry.NewPoly(points, nil, nil)), nil
+}
@@ -0,0 +1,38 @@
+package server
+
+import "testing"
+
+func TestH3IsCell(t *testing.T) {
+	for _, s := range []string{
+		"8928308280fffff", "85283473fffffff", "8001fffffffffff",
+		"80f3fffffffffff", "8f2830828052d25",
+	} {
+		if _, ok := parseH3(s); !ok {
+			t.Fatalf("%s: expected a cell", s)
+		}
+	}
+	for _, s := range []string{
+		"", "zz", "0", "ffffffffffffffff",
+		"8928308280ffff7",  // a digit past the resolution
+		"892830828ffffff",  // a missing digit
+		"80f5fffffffffff",  // base cell 122
+		"11928308280fffff", // an edge
+		"18928308280fffff", // too long
+	} {
+		if _, ok := parseH3(s); ok {
+			t.Fatalf("%s: expected not a cell", s)
+		}
+	}
+}
+
+func TestParseH3Group(t *testing.T) {
+	if res, err := parseH3Group("type"); res != -1 || err != nil {
+		t.Fatalf("expected -1, got %d %v", res, err)
+	}
+	if res, err := parseH3Group("h3"); res != -1 || err != nil {
+		t.Fatalf("expected -1, got %d %v", res, err)
+	}
+	if res, err := parseH3Group("H3:7"); res != 7 || err != nil {
+		t.Fatalf("expected 7, got %d %v", res, err)
+	}
+}
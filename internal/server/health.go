@@ -0,0 +1,220 @@
+package server
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/endpoint"
+)
+
+// healthContentType is the content type of the responses of /livez and
+// /readyz.
+const healthContentType = "application/json; charset=utf-8"
+
+// healthTimeout is how long a check of the health waits for the lock of the
+// data, or for a connection to an endpoint.
+const healthTimeout = time.Second
+
+// healthUnavailable is the status of a response of /livez or /readyz that
+// failed a check.
+const healthUnavailable = "503 Service Unavailable"
+
+// healthHTTPArgs returns the arguments of GET /livez and GET /readyz, which
+// checks the endpoints of the hooks with the endpoints query parameter.
+func healthHTTPArgs(path string) ([]string, bool) {
+	var query string
+	if i := strings.IndexByte(path, '?'); i != -1 {
+		path, query = path[:i], path[i+1:]
+	}
+	switch path {
+	case "livez":
+		return []string{"health", "live"}, true
+	case "readyz":
+		args := []string{"health", "ready"}
+		if q, err := url.ParseQuery(query); err == nil {
+			if _, ok := q["endpoints"]; ok {
+				args = append(args, "endpoints")
+			}
+		}
+		return args, true
+	}
+	return nil, false
+}
+
+// healthCheck is the result of a check, which is "ok" when it passed.
+type healthCheck struct {
+	name   string
+	status string
+}
+
+// rlockTimeout returns true when the read lock of the data is acquired
+// within the timeout. Otherwise the lock is released once it's acquired.
+func (s *Server) rlockTimeout(timeout time.Duration) bool {
+	locked := make(chan struct{})
+	go func() {
+		s.mu.RLock()
+		close(locked)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-locked:
+		return true
+	case <-timer.C:
+		go func() {
+			<-locked
+			s.mu.RUnlock()
+		}()
+		return false
+	}
+}
+
+// checkEndpoints returns the checks of the endpoints of the hooks, which are
+// redacted. The endpoints are connected to at the same time.
+func (s *Server) checkEndpoints(endpoints []string) []healthCheck {
+	checks := make([]healthCheck, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func(i int, ep string) {
+			defer wg.Done()
+			checks[i] = healthCheck{endpoint.Redact(ep), "ok"}
+			if err := s.epc.Check(ep, healthTimeout); err != nil {
+				checks[i].status = err.Error()
+			}
+		}(i, ep)
+	}
+	wg.Wait()
+	return checks
+}
+
+// HEALTH LIVE
+// HEALTH READY [ENDPOINTS]
+//
+// LIVE checks that the server is responsive, which is when the lock of the
+// data is acquired within a second. READY also checks that the server is
+// caught up to its leader and is not out of memory, and with ENDPOINTS that
+// the endpoints of the hooks are reachable. The AOF is loaded before the
+// server accepts connections. The checks are also served at /livez and
+// /readyz?endpoints by the HTTP transport, which respond with 503 when a
+// check fails. HEALTH does not require authentication.
+func (s *Server) cmdHealth(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) < 2 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	var ready, withEndpoints bool
+	switch strings.ToLower(msg.Args[1]) {
+	case "live":
+		if len(msg.Args) != 2 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+	case "ready":
+		ready = true
+		switch {
+		case len(msg.Args) == 3 && strings.ToLower(msg.Args[2]) == "endpoints":
+			withEndpoints = true
+		case len(msg.Args) != 2:
+			return NOMessage, errInvalidArgument(msg.Args[2])
+		}
+	default:
+		return NOMessage, clientErrorf(
+			"Syntax error, try HEALTH (LIVE | READY)",
+		)
+	}
+	checks := []healthCheck{{"responsive", "ok"}}
+	var endpoints []string
+	if !s.rlockTimeout(healthTimeout) {
+		checks[0].status = "timed out waiting for the lock of the data"
+	} else {
+		if ready {
+			follow := healthCheck{"follow", "ok"}
+			if s.config.followHost() != "" && !s.fcup {
+				follow.status = "catching up to leader"
+			}
+			memory := healthCheck{"memory", "ok"}
+			if s.config.maxMemory() > 0 && s.outOfMemory.on() {
+				memory.status = errOOM.Error()
+			}
+			checks = append(checks, follow, memory)
+			if withEndpoints {
+				seen := make(map[string]bool)
+				for _, h := range s.hooks {
+					for _, ep := range h.Endpoints {
+						if !seen[ep] {
+							seen[ep] = true
+							endpoints = append(endpoints, ep)
+						}
+					}
+				}
+			}
+		}
+		s.mu.RUnlock()
+	}
+	sort.Strings(endpoints)
+	var epChecks []healthCheck
+	if withEndpoints {
+		epChecks = s.checkEndpoints(endpoints)
+	}
+	var failed []string
+	for _, c := range checks {
+		if c.status != "ok" {
+			failed = append(failed, c.name+": "+c.status)
+		}
+	}
+	for _, c := range epChecks {
+		if c.status != "ok" {
+			failed = append(failed, "endpoint "+c.name+": "+c.status)
+		}
+	}
+	state := "live"
+	if ready {
+		state = "ready"
+	}
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"`+state+`":`...)
+		if len(failed) == 0 {
+			buf = append(buf, "true"...)
+		} else {
+			buf = append(buf, "false"...)
+		}
+		buf = append(buf, `,"checks":{`...)
+		for i, c := range checks {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, c.name)
+			buf = appendJSONString(append(buf, ':'), c.status)
+		}
+		if withEndpoints {
+			buf = append(buf, `,"endpoints":{`...)
+			for i, c := range epChecks {
+				if i > 0 {
+					buf = append(buf, ',')
+				}
+				buf = appendJSONString(buf, c.name)
+				buf = appendJSONString(append(buf, ':'), c.status)
+			}
+			buf = append(buf, '}')
+		}
+		buf = append(buf, `},"elapsed":"`+time.Since(start).String()+`"}`...)
+		if len(failed) > 0 && msg.ContentType != "" {
+			msg.status = healthUnavailable
+		}
+		return resp.BytesValue(buf), nil
+	case RESP:
+		if len(failed) > 0 {
+			return NOMessage, errors.New("not " + state + ", " +
+				strings.Join(failed, ", "))
+		}
+		return resp.SimpleStringValue("OK"), nil
+	}
+	return NOMessage, nil
+}
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/history"
+)
+
+// parseHistory parses the arguments of a SETHISTORY following the key.
+//
+// [LIMIT count] [WINDOW duration]
+func parseHistory(vs []string) (limit int, window time.Duration, err error) {
+	var ok bool
+	var haveLimit, haveWindow bool
+	for len(vs) > 0 {
+		var tok, val string
+		vs, tok, _ = tokenval(vs)
+		if vs, val, ok = tokenval(vs); !ok || val == "" {
+			return 0, 0, errInvalidNumberOfArguments
+		}
+		switch {
+		case lc(tok, "limit") && !haveLimit:
+			n, err := strconv.ParseUint(val, 10, 32)
+			if err != nil || n == 0 {
+				return 0, 0, errInvalidArgument(val)
+			}
+			limit, haveLimit = int(n), true
+		case lc(tok, "window") && !haveWindow:
+			if window, err = parseSince(val); err != nil {
+				return 0, 0, err
+			}
+			if window == 0 {
+				return 0, 0, errInvalidArgument(val)
+			}
+			haveWindow = true
+		default:
+			return 0, 0, errInvalidArgument(tok)
+		}
+	}
+	if !haveLimit && !haveWindow {
+		return 0, 0, errInvalidNumberOfArguments
+	}
+	return limit, window, nil
+}
+
+// historyArgs returns the SETHISTORY arguments following the key.
+func historyArgs(h *history.Store) []string {
+	var args []string
+	if h.Limit() > 0 {
+		args = append(args, "limit", strconv.Itoa(h.Limit()))
+	}
+	if h.Window() > 0 {
+		args = append(args, "window", h.Window().String())
+	}
+	return args
+}
+
+// SETHISTORY key [LIMIT count] [WINDOW duration]
+//
+// Keeps the recent positions of the objects of a key, which are the last
+// count positions of each object, or those in the duration before its
+// newest position. The positions are kept in memory, apart from the
+// collection, and are only added by the writes that follow. Changing the
+// limits of a key keeps the positions that are still in the limits.
+func (s *Server) cmdSetHistory(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	limit, window, err := parseHistory(vs)
+	if err != nil {
+		return NOMessage, d, err
+	}
+	if h := s.histories[d.key]; h != nil {
+		h.SetLimits(limit, window)
+	} else {
+		s.histories[d.key] = history.New(limit, window)
+	}
+	d.updated = true
+	d.timestamp = time.Now()
+	return OKMessage(msg, start), d, nil
+}
+
+// DELHISTORY key
+//
+// Stops keeping the positions of the objects of a key, and removes those
+// that are kept.
+func (s *Server) cmdDelHistory(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" || len(vs) != 0 {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if _, ok := s.histories[d.key]; ok {
+		delete(s.histories, d.key)
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+	return intResult(msg, start, d.updated), d, nil
+}
+
+// HISTORY key
+func (s *Server) cmdHistory(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var key string
+	var ok bool
+	if vs, key, ok = tokenval(vs); !ok || key == "" || len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	h := s.histories[key]
+	switch msg.OutputType {
+	case JSON:
+		if h == nil {
+			return NOMessage, errKeyNotFound
+		}
+		var buf []byte
+		buf = append(buf, `{"ok":true,"history":{"limit":`...)
+		buf = strconv.AppendInt(buf, int64(h.Limit()), 10)
+		buf = append(buf, `,"window":`...)
+		buf = strconv.AppendFloat(buf, h.Window().Seconds(), 'f', -1, 64)
+		buf = append(buf, `,"objects":`...)
+		buf = strconv.AppendInt(buf, int64(h.Len()), 10)
+		buf = append(buf, `,"positions":`...)
+		buf = strconv.AppendInt(buf, int64(h.Count()), 10)
+		buf = append(buf, `},"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		if h == nil {
+			return resp.NullValue(), nil
+		}
+		args := historyArgs(h)
+		vals := make([]resp.Value, len(args))
+		for i, arg := range args {
+			vals[i] = resp.StringValue(arg)
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
+// historyAdd adds the position of an object that was set, when the
+// positions of its key are kept. The position of an object that is not a
+// point is its center. The caller must hold the server lock.
+func (s *Server) historyAdd(d *commandDetails) {
+	h := s.histories[d.key]
+	if h == nil || !objIsSpatial(d.obj) {
+		return
+	}
+	ts := d.observed
+	if ts == 0 {
+		// from an aof that was written before the observation times
+		ts = d.timestamp.UnixNano()
+	}
+	center := d.obj.Center()
+	h.Add(d.id, history.Position{X: center.X, Y: center.Y, Time: ts})
+}
+
+// historyDelete removes the positions of an object, or of every object of
+// the key when id is empty. The caller must hold the server lock.
+func (s *Server) historyDelete(key, id string) {
+	if h := s.histories[key]; h != nil {
+		if id == "" {
+			h.Clear()
+		} else {
+			h.Delete(id)
+		}
+	}
+}
+
+// historyRange parses an optional SINCE age or BETWEEN start end.
+func historyRange(vs []string) (nvs []string, min, max int64, err error) {
+	min, max = math.MinInt64, math.MaxInt64
+	if nvs, tok, ok := tokenval(vs); ok && (lc(tok, "since") ||
+		lc(tok, "between")) {
+		return parseTimeRange(tok, nvs)
+	}
+	return vs, min, max, nil
+}
+
+// trackObject returns the positions of a track as a LineString, or as a
+// Point when there's only one.
+func trackObject(track []history.Position) geojson.Object {
+	points := make([]geometry.Point, len(track))
+	for i, pos := range track {
+		points[i] = geometry.Point{X: pos.X, Y: pos.Y}
+	}
+	if len(points) == 1 {
+		return geojson.NewPoint(points[0])
+	}
+	return geojson.NewLineString(geometry.NewLine(points, nil))
+}
+
+// TRAJECTORY key id [SINCE age|BETWEEN start end]
+//
+// Returns the kept positions of an object as a LineString, with the time of
+// each position.
+func (s *Server) cmdTrajectory(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var key, id string
+	var ok bool
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if vs, id, ok = tokenval(vs); !ok || id == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	vs, min, max, err := historyRange(vs)
+	if err != nil {
+		return NOMessage, err
+	}
+	if len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	h := s.histories[key]
+	if h == nil {
+		if msg.OutputType == RESP {
+			return resp.NullValue(), nil
+		}
+		return NOMessage, errKeyNotFound
+	}
+	track := h.Track(id, min, max)
+	if len(track) == 0 {
+		if msg.OutputType == RESP {
+			return resp.NullValue(), nil
+		}
+		return NOMessage, errIDNotFound
+	}
+	obj := trackObject(track)
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"object":`...)
+		buf = obj.AppendJSON(buf)
+		buf = append(buf, `,"times":[`...)
+		for i, pos := range track {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, formatTimestamp(pos.Time))
+		}
+		buf = append(buf, `],"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		times := make([]resp.Value, len(track))
+		for i, pos := range track {
+			times[i] = resp.StringValue(formatTimestamp(pos.Time))
+		}
+		return resp.ArrayValue([]resp.Value{
+			resp.StringValue(obj.String()),
+			resp.ArrayValue(times),
+		}), nil
+	}
+	return NOMessage, nil
+}
+
+// PASSED key [SINCE age|BETWEEN start end] area
+//
+// Returns the ids of the objects that passed through an area, which are
+// those with a kept position in the area, or that moved across the area
+// between two positions.
+func (s *Server) cmdPassed(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var key string
+	var ok bool
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	vs, min, max, err := historyRange(vs)
+	if err != nil {
+		return NOMessage, err
+	}
+	vs, area, err := s.parseArea(vs, false)
+	if err != nil {
+		return NOMessage, err
+	}
+	if len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	var ids []string
+	if h := s.histories[key]; h != nil {
+		h.Scan(min, max, func(id string, track []history.Position) bool {
+			if area.Intersects(trackObject(track)) {
+				ids = append(ids, id)
+			}
+			return true
+		})
+	}
+	sort.Strings(ids)
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"ids":[`...)
+		for i, id := range ids {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, id)
+		}
+		buf = append(buf, `],"count":`...)
+		buf = strconv.AppendInt(buf, int64(len(ids)), 10)
+		buf = append(buf, `,"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		vals := make([]resp.Value, len(ids))
+		for i, id := range ids {
+			vals[i] = resp.StringValue(id)
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
+// historyCommands returns the commands needed to recreate the kept
+// positions settings of the keys, or of every key when keys is nil. The
+// positions themselves are not recreated. The caller must hold the server
+// lock.
+func (s *Server) historyCommands(keys []string) (cmds [][]string) {
+	if keys == nil {
+		for key := range s.histories {
+			keys = append(keys, key)
+		}
+		// sort the keys for consistency
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		if h := s.histories[key]; h != nil {
+			cmds = append(cmds,
+				append([]string{"sethistory", key}, historyArgs(h)...))
+		}
+	}
+	return cmds
+}
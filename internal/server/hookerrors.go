@@ -0,0 +1,78 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/tidwall/tile38/internal/endpoint"
+)
+
+// hookErrorsChannel is the channel that the failed deliveries of the hooks
+// are published to.
+const hookErrorsChannel = "__tile38__:hook_errors"
+
+// hookErrorsBacklog is the number of failure events that wait to be sent to
+// the hookerrorsendpoint. The events that follow are dropped while the
+// endpoint is slow or down.
+const hookErrorsBacklog = 1024
+
+// hookFailed publishes an event for a failed attempt to deliver a message of
+// a hook to one of its endpoints, such as:
+//
+//	{"hook":"warehouse","key":"fleet","endpoint":"http://10.0.0.1/hook",
+//	 "error":"connection refused","attempt":3,"time":"..."}
+//
+// The attempt is the number of times that the message was tried, which goes
+// on every half second until it's delivered or it expires. The event is
+// published to the __tile38__:hook_errors channel, and is sent to the
+// hookerrorsendpoint, when it's set.
+func (s *Server) hookFailed(h *Hook, ep string, attempt int, err error) {
+	b := append([]byte(nil), `{"hook":`...)
+	b = appendJSONString(b, h.Name)
+	b = append(b, `,"key":`...)
+	b = appendJSONString(b, h.Key)
+	b = append(b, `,"endpoint":`...)
+	b = appendJSONString(b, endpoint.Redact(ep))
+	b = append(b, `,"error":`...)
+	b = appendJSONString(b, err.Error())
+	b = append(b, `,"attempt":`...)
+	b = strconv.AppendInt(b, int64(attempt), 10)
+	b = append(b, `,"time":`...)
+	b = appendJSONTimeFormat(b, time.Now())
+	b = append(b, '}')
+	event := string(b)
+	s.statsHookErrors.add(1)
+	s.Publish(hookErrorsChannel, event)
+	if s.config.hookErrorsEndpoint() == "" {
+		return
+	}
+	select {
+	case s.hookErrs <- event:
+	default:
+		s.statsHookErrDrops.add(1)
+	}
+}
+
+// watchHookErrors sends the failure events of the hooks to the
+// hookerrorsendpoint. The failures of the endpoint itself are only logged.
+func (s *Server) watchHookErrors() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case event := <-s.hookErrs:
+			target := s.config.hookErrorsEndpoint()
+			if target == "" {
+				continue
+			}
+			if err := s.epc.Send(target, event); err != nil {
+				epLog.Debugf("Hook errors endpoint send error: %v: %v",
+					endpoint.Redact(target), err)
+			}
+		case <-t.C:
+			if s.stopServer.on() {
+				return
+			}
+		}
+	}
+}
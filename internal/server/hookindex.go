@@ -0,0 +1,176 @@
+package server
+
+import (
+	"math"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/rtree"
+)
+
+// hookIndex is the hooks of each key, by the areas of their fences. A write
+// of an object is tested against the hooks of its key whose areas overlap
+// the object, rather than against all of the hooks, see candidates.
+type hookIndex struct {
+	keys  map[string]*keyHooks
+	hooks map[*Hook]hookEntry
+}
+
+// keyHooks is the hooks of a key. The hooks that detect "outside" fire for
+// an object anywhere, and the roaming hooks fire near the objects of another
+// key, which moves with those objects, so neither have an area.
+type keyHooks struct {
+	tree  rtree.RTree    // the areas of the fences
+	cross rtree.RTree    // the areas of the fences that detect "cross"
+	out   map[*Hook]bool // the hooks that detect "outside"
+	roam  map[*Hook]bool // the roaming hooks
+}
+
+// hookEntry is how a hook was added to the index, so that it can be removed
+// after its fence changed.
+type hookEntry struct {
+	key   string
+	rect  geometry.Rect
+	area  bool
+	cross bool
+	out   bool
+	roam  bool
+}
+
+func rectMinMax(rect geometry.Rect) (min, max [2]float64) {
+	return [2]float64{rect.Min.X, rect.Min.Y},
+		[2]float64{rect.Max.X, rect.Max.Y}
+}
+
+// set adds a hook, or updates the hook after its fence changed.
+func (hi *hookIndex) set(hook *Hook) {
+	hi.remove(hook)
+	if hook.Fence == nil {
+		return
+	}
+	e := hookEntry{
+		key:  hook.Key,
+		area: hook.Fence.obj != nil,
+		out:  hook.Fence.detect == nil || hook.Fence.detect["outside"],
+		roam: hook.Fence.roam.on,
+	}
+	if e.area {
+		e.rect = hook.Fence.obj.Rect()
+		e.cross = hook.Fence.detect["cross"]
+	}
+	if hi.keys == nil {
+		hi.keys = make(map[string]*keyHooks)
+		hi.hooks = make(map[*Hook]hookEntry)
+	}
+	kh := hi.keys[e.key]
+	if kh == nil {
+		kh = &keyHooks{
+			out:  make(map[*Hook]bool),
+			roam: make(map[*Hook]bool),
+		}
+		hi.keys[e.key] = kh
+	}
+	min, max := rectMinMax(e.rect)
+	if e.area {
+		kh.tree.Insert(min, max, hook)
+	}
+	if e.cross {
+		kh.cross.Insert(min, max, hook)
+	}
+	if e.out {
+		kh.out[hook] = true
+	}
+	if e.roam {
+		kh.roam[hook] = true
+	}
+	hi.hooks[hook] = e
+}
+
+// remove removes a hook.
+func (hi *hookIndex) remove(hook *Hook) {
+	e, ok := hi.hooks[hook]
+	if !ok {
+		return
+	}
+	delete(hi.hooks, hook)
+	kh := hi.keys[e.key]
+	min, max := rectMinMax(e.rect)
+	if e.area {
+		kh.tree.Delete(min, max, hook)
+	}
+	if e.cross {
+		kh.cross.Delete(min, max, hook)
+	}
+	delete(kh.out, hook)
+	delete(kh.roam, hook)
+	if kh.tree.Len() == 0 && len(kh.out) == 0 && len(kh.roam) == 0 {
+		delete(hi.keys, e.key)
+	}
+}
+
+// candidates returns the hooks that a write could fire, which are the hooks
+// of the key that detect "outside", the roaming hooks of the key, and the
+// hooks of the key whose areas overlap the old or the new object, or the
+// line between them for "cross".
+func (hi *hookIndex) candidates(d *commandDetails) []*Hook {
+	kh := hi.keys[d.key]
+	if kh == nil {
+		return nil
+	}
+	candidates := make(map[*Hook]bool, len(kh.out)+len(kh.roam))
+	for hook := range kh.out {
+		candidates[hook] = true
+	}
+	for hook := range kh.roam {
+		candidates[hook] = true
+	}
+	add := func(min, max [2]float64, value interface{}) bool {
+		candidates[value.(*Hook)] = true
+		return true
+	}
+	if d.oldObj != nil && d.obj != nil && kh.cross.Len() > 0 {
+		r1, r2 := d.oldObj.Rect(), d.obj.Rect()
+		kh.cross.Search(
+			[2]float64{
+				math.Min(r1.Min.X, r2.Min.X),
+				math.Min(r1.Min.Y, r2.Min.Y),
+			},
+			[2]float64{
+				math.Max(r1.Max.X, r2.Max.X),
+				math.Max(r1.Max.Y, r2.Max.Y),
+			}, add)
+	}
+	if d.oldObj != nil && kh.tree.Len() > 0 {
+		min, max := rectMinMax(d.oldObj.Rect())
+		kh.tree.Search(min, max, add)
+	}
+	if d.obj != nil && kh.tree.Len() > 0 {
+		min, max := rectMinMax(d.obj.Rect())
+		kh.tree.Search(min, max, add)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	hooks := make([]*Hook, 0, len(candidates))
+	for hook := range candidates {
+		hooks = append(hooks, hook)
+	}
+	return hooks
+}
+
+// intersects returns the hooks of all of the keys whose fences intersect an
+// area, see HOOKS INTERSECTS.
+func (hi *hookIndex) intersects(area geojson.Object) []*Hook {
+	var hooks []*Hook
+	min, max := rectMinMax(area.Rect())
+	for _, kh := range hi.keys {
+		kh.tree.Search(min, max, func(_, _ [2]float64, value interface{}) bool {
+			hook := value.(*Hook)
+			if hook.Fence.obj.Intersects(area) {
+				hooks = append(hooks, hook)
+			}
+			return true
+		})
+	}
+	return hooks
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+)
+
+func TestHookIndex(t *testing.T) {
+	rect := func(minX, minY, maxX, maxY float64) geojson.Object {
+		return geojson.NewRect(geometry.Rect{
+			Min: geometry.Point{X: minX, Y: minY},
+			Max: geometry.Point{X: maxX, Y: maxY},
+		})
+	}
+	point := func(x, y float64) geojson.Object {
+		return geojson.NewPoint(geometry.Point{X: x, Y: y})
+	}
+	hook := func(name, key string, obj geojson.Object, detect ...string,
+	) *Hook {
+		fence := &liveFenceSwitches{}
+		fence.obj = obj
+		fence.detect = make(map[string]bool)
+		for _, d := range detect {
+			fence.detect[d] = true
+		}
+		return &Hook{Name: name, Key: key, Fence: fence}
+	}
+	var hi hookIndex
+	a := hook("a", "fleet", rect(0, 0, 10, 10), "enter", "exit")
+	b := hook("b", "fleet", rect(20, 20, 30, 30), "cross")
+	c := hook("c", "fleet", rect(0, 0, 10, 10), "outside")
+	d := hook("d", "truck", rect(0, 0, 10, 10), "enter")
+	e := hook("e", "fleet", nil)
+	e.Fence.roam.on = true
+	for _, h := range []*Hook{a, b, c, d, e} {
+		hi.set(h)
+	}
+	names := func(d *commandDetails) string {
+		var names []string
+		for _, h := range hi.candidates(d) {
+			names = append(names, h.Name)
+		}
+		sort.Strings(names)
+		var s string
+		for _, name := range names {
+			s += name
+		}
+		return s
+	}
+	for _, tt := range []struct {
+		d      *commandDetails
+		expect string
+	}{
+		{&commandDetails{key: "fleet", obj: point(5, 5)}, "ace"},
+		{&commandDetails{key: "fleet", obj: point(50, 50)}, "ce"},
+		{&commandDetails{key: "fleet", oldObj: point(5, 5),
+			obj: point(50, 50)}, "abce"},
+		{&commandDetails{key: "fleet", oldObj: point(15, 15),
+			obj: point(35, 35)}, "bce"},
+		{&commandDetails{key: "truck", obj: point(5, 5)}, "d"},
+		{&commandDetails{key: "truck", obj: point(50, 50)}, ""},
+		{&commandDetails{key: "bus", obj: point(5, 5)}, ""},
+	} {
+		if s := names(tt.d); s != tt.expect {
+			t.Fatalf("%s %v: expected '%s', got '%s'", tt.d.key, tt.d.obj,
+				tt.expect, s)
+		}
+	}
+
+	intersects := func(area geojson.Object) string {
+		var names []string
+		for _, h := range hi.intersects(area) {
+			names = append(names, h.Name)
+		}
+		sort.Strings(names)
+		var s string
+		for _, name := range names {
+			s += name
+		}
+		return s
+	}
+	if s := intersects(rect(5, 5, 25, 25)); s != "abcd" {
+		t.Fatalf("expected 'abcd', got '%s'", s)
+	}
+	if s := intersects(point(25, 25)); s != "b" {
+		t.Fatalf("expected 'b', got '%s'", s)
+	}
+
+	// the fence of a hook moves
+	a.Fence.obj = rect(40, 40, 60, 60)
+	hi.set(a)
+	if s := names(&commandDetails{key: "fleet", obj: point(50, 50)}); s != "ace" {
+		t.Fatalf("expected 'ace', got '%s'", s)
+	}
+	for _, h := range []*Hook{a, b, c, d, e} {
+		hi.remove(h)
+	}
+	if len(hi.keys) != 0 || len(hi.hooks) != 0 {
+		t.Fatal("expected an empty index")
+	}
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// replayCommand returns true for the writes of the aof that change the
+// objects that a hook is replayed with.
+func replayCommand(command string) bool {
+	switch command {
+	case "set", "pset", "fset", "fincrby", "jset", "jdel", "del", "pdel",
+		"drop", "expire", "persist", "rename", "renamenx", "copy":
+		return true
+	}
+	return false
+}
+
+// replayBound is the FROM or the TO of a HOOKREPLAY, which is an offset of
+// the aof, or a time.
+type replayBound struct {
+	offset int64
+	time   int64 // unix nano, or zero for an offset
+}
+
+// parseReplayBound parses an offset of the aof, such as 1024, or a RFC 3339
+// time, such as 2021-06-01T12:00:00Z.
+func parseReplayBound(s string) (b replayBound, err error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+		return replayBound{offset: n}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return b, errInvalidArgument(s)
+	}
+	return replayBound{time: t.UnixNano()}, nil
+}
+
+// replayHook evaluates the writes of the aof, from its start up to the end,
+// with a copy of the fence of a hook, and returns the events of the writes
+// from the FROM to the TO. The objects of the key of the hook are kept by a
+// server of their own, so the aof is read without the server lock. A time
+// starts the events at the first write with an observation time at or after
+// it, see SET TIMESTAMP, and a time of the TO ends them at the first write
+// with an observation time after it.
+func (s *Server) replayHook(hook *Hook, fence liveFenceSwitches, end int64,
+	from, to replayBound, limit int,
+) ([]string, error) {
+	shadow := &Server{
+		config:        s.config,
+		lcond:         sync.NewCond(&sync.Mutex{}),
+		geomParseOpts: s.geomParseOpts,
+	}
+	shadow.cmdFlushDB(&Message{Args: []string{"flushdb"}})
+	var wr bytes.Buffer
+	sw, err := shadow.newScanWriter(&wr, hook.Message, fence.key,
+		fence.output, fence.precision, fence.glob, false, fence.cursor,
+		fence.limit, fence.wheres, fence.whereins, fence.whereevals,
+		fence.wherestrs, fence.nofields, fence.agg)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.openAOFReader()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	errReplayDone := errors.New("replay done")
+	var events []string
+	var on bool
+	err = readAOFCommandsAt(io.NewSectionReader(f, 0, end),
+		func(pos int64, args []string) error {
+			command := strings.ToLower(args[0])
+			if command == "flushdb" {
+				shadow.cmdFlushDB(&Message{Args: args})
+				return nil
+			}
+			if !replayCommand(command) {
+				return nil
+			}
+			var match bool
+			for _, key := range clusterKeys(args) {
+				match = match || key == hook.Key
+			}
+			if !match {
+				return nil
+			}
+			if to.time == 0 && to.offset > 0 && pos >= to.offset {
+				return errReplayDone
+			}
+			if from.time == 0 && pos >= from.offset {
+				on = true
+			}
+			// the output type makes the writes return their field maps,
+			// unlike the writes that are loaded from the aof
+			_, d, err := shadow.command(&Message{Args: args, OutputType: JSON},
+				nil)
+			if err != nil || !d.updated {
+				return nil
+			}
+			details := []*commandDetails{&d}
+			if d.parent {
+				details = d.children
+			}
+			for _, d := range details {
+				if d.observed != 0 {
+					if to.time != 0 && d.observed > to.time {
+						return errReplayDone
+					}
+					if from.time != 0 && d.observed >= from.time {
+						on = true
+					}
+					d.timestamp = time.Unix(0, d.observed)
+				}
+				if !on || d.key != hook.Key {
+					continue
+				}
+				msgs := FenceMatch(hook.Name, sw, &fence, hook.Metas, d)
+				for _, msg := range msgs {
+					// the replayed events are told apart from the others
+					events = append(events, msg[:len(msg)-1]+`,"replay":true}`)
+				}
+				if limit > 0 && len(events) >= limit {
+					events = events[:limit]
+					return errReplayDone
+				}
+			}
+			return nil
+		})
+	if err != nil && err != errReplayDone {
+		return nil, err
+	}
+	return events, nil
+}
+
+// HOOKREPLAY name FROM offset|time [TO offset|time] [LIMIT count] [DELIVER]
+//
+// Evaluates the writes of the aof with the fence of a hook or a channel, and
+// returns the events of the writes from the FROM to the TO, such as the
+// enter and exit events that were missed while an endpoint was down. The
+// events have "replay":true. DELIVER sends the events to the endpoints of
+// the hook, or publishes them to the channel, and returns the number of
+// events. The offsets are the offsets of the aof, which change when it's
+// shrunk. The area of the fence is its current area, and roaming fences
+// cannot be replayed.
+func (s *Server) cmdHookReplay(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var name, sfrom string
+	var ok bool
+	if vs, name, ok = tokenval(vs); !ok || name == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	var tok string
+	if vs, tok, ok = tokenval(vs); !ok || !lc(tok, "from") {
+		if !ok {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		return NOMessage, errInvalidArgument(tok)
+	}
+	if vs, sfrom, ok = tokenval(vs); !ok || sfrom == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	from, err := parseReplayBound(sfrom)
+	if err != nil {
+		return NOMessage, err
+	}
+	var to replayBound
+	var limit int
+	var deliver bool
+	for len(vs) > 0 {
+		vs, tok, _ = tokenval(vs)
+		switch {
+		case lc(tok, "to"):
+			var sto string
+			if vs, sto, ok = tokenval(vs); !ok || sto == "" {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			if to, err = parseReplayBound(sto); err != nil {
+				return NOMessage, err
+			}
+		case lc(tok, "limit"):
+			var slimit string
+			if vs, slimit, ok = tokenval(vs); !ok || slimit == "" {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			n, err := strconv.ParseUint(slimit, 10, 32)
+			if err != nil || n == 0 {
+				return NOMessage, errInvalidArgument(slimit)
+			}
+			limit = int(n)
+		case lc(tok, "deliver"):
+			deliver = true
+		default:
+			return NOMessage, errInvalidArgument(tok)
+		}
+	}
+
+	s.mu.Lock()
+	hook := s.hooks[name]
+	if hook == nil {
+		s.mu.Unlock()
+		return NOMessage, errHookNotFound
+	}
+	if s.aof == nil {
+		s.mu.Unlock()
+		return NOMessage, errors.New("hook replay requires the aof")
+	}
+	if hook.Fence.roam.on {
+		s.mu.Unlock()
+		return NOMessage, errors.New("roaming hooks cannot be replayed")
+	}
+	// work on a copy of the fence, like FENCETEST
+	fence := *hook.Fence
+	fence.groups = make(map[string]string)
+	s.flushAOF(false)
+	end := int64(s.aofsz)
+	s.mu.Unlock()
+
+	events, err := s.replayHook(hook, fence, end, from, to, limit)
+	if err != nil {
+		return NOMessage, err
+	}
+
+	if deliver && len(events) > 0 {
+		s.mu.Lock()
+		if s.hooks[name] != hook {
+			s.mu.Unlock()
+			return NOMessage, errors.New("hook changed during the replay")
+		}
+		if hook.channel {
+			hook.delivery.delivered(len(events))
+			for _, m := range events {
+				s.Publish(hook.Name, m)
+			}
+		} else if err = s.queueHookLogs(events, hook.logSetOptions()); err == nil {
+			hook.Signal()
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return NOMessage, err
+		}
+	}
+	switch msg.OutputType {
+	case JSON:
+		buf := &bytes.Buffer{}
+		buf.WriteString(`{"ok":true`)
+		if deliver {
+			buf.WriteString(`,"count":` + strconv.Itoa(len(events)))
+		} else {
+			buf.WriteString(`,"events":[`)
+			for i, m := range events {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(m)
+			}
+			buf.WriteString(`]`)
+		}
+		buf.WriteString(`,"elapsed":"` + time.Since(start).String() + "\"}")
+		return resp.StringValue(buf.String()), nil
+	case RESP:
+		if deliver {
+			return resp.IntegerValue(len(events)), nil
+		}
+		vals := make([]resp.Value, len(events))
+		for i, m := range events {
+			vals[i] = resp.StringValue(m)
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
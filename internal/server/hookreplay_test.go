@@ -0,0 +1,57 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReplayBound(t *testing.T) {
+	b, err := parseReplayBound("1024")
+	if err != nil || b.offset != 1024 || b.time != 0 {
+		t.Fatalf("expected offset 1024, got %v %v", b, err)
+	}
+	b, err = parseReplayBound("2021-01-01T00:00:00Z")
+	at := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	if err != nil || b.time != at {
+		t.Fatalf("expected time %d, got %v %v", at, b, err)
+	}
+	for _, s := range []string{"-1", "1.5", "yesterday"} {
+		if _, err := parseReplayBound(s); err == nil {
+			t.Fatalf("expected an error for '%s'", s)
+		}
+	}
+}
+
+func TestReadAOFCommandsAt(t *testing.T) {
+	var aof []byte
+	var expect []int64
+	for _, cmd := range [][]string{
+		{"set", "fleet", "truck1", "point", "33", "-115"},
+		{"del", "fleet", "truck1"},
+		{"drop", "fleet"},
+	} {
+		expect = append(expect, int64(len(aof)))
+		aof = appendAOFCommand(aof, cmd)
+	}
+	var offsets []int64
+	var commands []string
+	err := readAOFCommandsAt(bytes.NewReader(aof),
+		func(pos int64, args []string) error {
+			offsets = append(offsets, pos)
+			commands = append(commands, args[0])
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(commands, " ") != "set del drop" {
+		t.Fatalf("expected 'set del drop', got '%s'", strings.Join(commands, " "))
+	}
+	for i := range expect {
+		if offsets[i] != expect[i] {
+			t.Fatalf("expected %v, got %v", expect, offsets)
+		}
+	}
+}
@@ -10,18 +10,29 @@ import (
 	"time"
 
 	"github.com/tidwall/buntdb"
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/resp"
+	"github.com/tidwall/sjson"
 	"github.com/tidwall/tile38/internal/endpoint"
 	"github.com/tidwall/tile38/internal/glob"
 	"github.com/tidwall/tile38/internal/log"
+	"github.com/tidwall/tile38/internal/trace"
 )
 
+var errHookNotFound = errors.New("hook not found")
+var errHookAlreadyExists = errors.New("hook already exists")
+
 var hookLogSetDefaults = &buntdb.SetOptions{
 	Expires: true, // automatically delete after 30 seconds
 	TTL:     time.Second * 30,
 }
 
+// hookChanBufSize is the most messages that a paused channel holds on to,
+// see PAUSECHAN BUFFER. The messages that follow are dropped.
+const hookChanBufSize = 10000
+
 type hooksByName []*Hook
 
 func (a hooksByName) Len() int {
@@ -68,6 +79,8 @@ func (s *Server) cmdSetHook(msg *Message, chanCmd bool) (
 	var types []string
 	var expires float64
 	var expiresSet bool
+	var replace bool
+	var follower bool
 	metaMap := make(map[string]string)
 	for {
 		commandvs = vs
@@ -101,6 +114,12 @@ func (s *Server) cmdSetHook(msg *Message, chanCmd bool) (
 			expires = v
 			expiresSet = true
 			continue
+		case "replace":
+			replace = true
+			continue
+		case "follower":
+			follower = true
+			continue
 		case "nearby":
 			types = nearbyTypes
 		case "within", "intersects":
@@ -121,6 +140,9 @@ func (s *Server) cmdSetHook(msg *Message, chanCmd bool) (
 	args.cmd = cmdlc
 	cmsg := &Message{}
 	*cmsg = *msg
+	// the events are the same as the ones of the hook loaded from the aof,
+	// whatever the OUTPUT BOUNDS of the client
+	cmsg.bounds = false
 	cmsg.Args = make([]string, len(commandvs))
 	for i := 0; i < len(commandvs); i++ {
 		cmsg.Args[i] = commandvs[i]
@@ -142,6 +164,10 @@ func (s *Server) cmdSetHook(msg *Message, chanCmd bool) (
 		channel:   chanCmd,
 		cond:      sync.NewCond(&sync.Mutex{}),
 		counter:   &s.statsTotalMsgsSent,
+		delivery:  &hookDelivery{},
+		latency:   &s.latency,
+		failed:    s.hookFailed,
+		follower:  follower,
 	}
 	if expiresSet {
 		hook.expires =
@@ -154,7 +180,7 @@ func (s *Server) cmdSetHook(msg *Message, chanCmd bool) (
 	hook.ScanWriter, err = s.newScanWriter(
 		&wr, cmsg, args.key, args.output, args.precision, args.glob, false,
 		args.cursor, args.limit, args.wheres, args.whereins, args.whereevals,
-		args.nofields)
+		args.wherestrs, args.nofields, args.agg)
 	if err != nil {
 
 		return NOMessage, d, err
@@ -179,49 +205,42 @@ func (s *Server) cmdSetHook(msg *Message, chanCmd bool) (
 				return resp.IntegerValue(0), d, nil
 			}
 		}
-		prevHook.Close()
+		if replace {
+			// carry over the roaming and pause state so that objects which
+			// are already being tracked do not generate new groups.
+			hook.Fence.groups = prevHook.Fence.groups
+			hook.paused = prevHook.paused
+			hook.buffered = prevHook.buffered
+			hook.chanbuf = prevHook.chanbuf
+		} else {
+			prevHook.Close()
+			s.forgetPausedHookLogs(prevHook)
+		}
 		delete(s.hooks, name)
-		delete(s.hooksOut, name)
+		s.hookIndex.remove(prevHook)
 	}
 
 	d.updated = true
 	d.timestamp = time.Now()
 
 	s.hooks[name] = hook
-	if hook.Fence.detect == nil || hook.Fence.detect["outside"] {
-		s.hooksOut[name] = hook
-	}
-
-	// remove previous hook from spatial index
-	if prevHook != nil && prevHook.Fence != nil && prevHook.Fence.obj != nil {
-		rect := prevHook.Fence.obj.Rect()
-		s.hookTree.Delete(
-			[2]float64{rect.Min.X, rect.Min.Y},
-			[2]float64{rect.Max.X, rect.Max.Y},
-			prevHook)
-		if prevHook.Fence.detect["cross"] {
-			s.hookCross.Delete(
-				[2]float64{rect.Min.X, rect.Min.Y},
-				[2]float64{rect.Max.X, rect.Max.Y},
-				prevHook)
-		}
-	}
-	// add hook to spatial index
-	if hook != nil && hook.Fence != nil && hook.Fence.obj != nil {
-		rect := hook.Fence.obj.Rect()
-		s.hookTree.Insert(
-			[2]float64{rect.Min.X, rect.Min.Y},
-			[2]float64{rect.Max.X, rect.Max.Y},
-			hook)
-		if hook.Fence.detect["cross"] {
-			s.hookCross.Insert(
-				[2]float64{rect.Min.X, rect.Min.Y},
-				[2]float64{rect.Max.X, rect.Max.Y},
-				hook)
+	s.hookIndex.set(hook)
+
+	if hook.Fence.ref.key != "" {
+		if s.hookRefs[hook.Fence.ref] == nil {
+			s.hookRefs[hook.Fence.ref] = make(map[string]bool)
 		}
+		s.hookRefs[hook.Fence.ref][name] = true
 	}
 
 	hook.Open() // Opens a goroutine to notify the hook
+	if replace && prevHook != nil {
+		// the new hook is now receiving events, it's safe to close the
+		// previous one. Any messages that are still queued for the hook
+		// name will be delivered by the new hook.
+		prevHook.Close()
+		hook.Signal()
+	}
 	if !hook.expires.IsZero() {
 		s.hookex.Push(hook)
 	}
@@ -234,6 +253,442 @@ func (s *Server) cmdSetHook(msg *Message, chanCmd bool) (
 	return NOMessage, d, nil
 }
 
+func (s *Server) cmdRenameHook(msg *Message, chanCmd bool) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+
+	var name, newName string
+	var ok bool
+	if vs, name, ok = tokenval(vs); !ok || name == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if vs, newName, ok = tokenval(vs); !ok || newName == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if len(vs) != 0 {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	prevHook, ok := s.hooks[name]
+	if !ok || prevHook.channel != chanCmd {
+		return NOMessage, d, errHookNotFound
+	}
+	// renaming a hook to its own name is ok and does nothing
+	if name != newName {
+		if _, ok := s.hooks[newName]; ok {
+			return NOMessage, d, errHookAlreadyExists
+		}
+		// build a new hook that takes over everything from the previous
+		// one, including the roaming state.
+		hook := &Hook{
+			Key:        prevHook.Key,
+			Name:       newName,
+			Endpoints:  prevHook.Endpoints,
+			Fence:      prevHook.Fence,
+			Message:    prevHook.Message,
+			ScanWriter: prevHook.ScanWriter,
+			Metas:      prevHook.Metas,
+			db:         prevHook.db,
+			epm:        prevHook.epm,
+			channel:    prevHook.channel,
+			expires:    prevHook.expires,
+			cond:       sync.NewCond(&sync.Mutex{}),
+			counter:    prevHook.counter,
+			delivery:   prevHook.delivery,
+			latency:    prevHook.latency,
+			paused:     prevHook.paused,
+			buffered:   prevHook.buffered,
+			chanbuf:    prevHook.chanbuf,
+			failed:     prevHook.failed,
+			follower:   prevHook.follower,
+		}
+		if chanCmd {
+			hook.Endpoints = []string{"local://" + newName}
+		}
+		prevHook.Close()
+		if !chanCmd {
+			// move the pending messages over to the new hook name
+			if err := renameHookLogs(s.qdb, name, newName); err != nil {
+				log.Errorf("renamehook: %v", err)
+			}
+		}
+		delete(s.hooks, name)
+		s.hookIndex.remove(prevHook)
+		s.hooks[newName] = hook
+		s.hookIndex.set(hook)
+		if hook.Fence.ref.key != "" {
+			if s.hookRefs[hook.Fence.ref] == nil {
+				s.hookRefs[hook.Fence.ref] = make(map[string]bool)
+			}
+			s.hookRefs[hook.Fence.ref][newName] = true
+		}
+		hook.Open()
+		hook.Signal()
+		if !hook.expires.IsZero() {
+			s.hookex.Push(hook)
+		}
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+
+	switch msg.OutputType {
+	case JSON:
+		return OKMessage(msg, start), d, nil
+	case RESP:
+		return resp.SimpleStringValue("OK"), d, nil
+	}
+	return NOMessage, d, nil
+}
+
+func (s *Server) cmdPauseHook(msg *Message, chanCmd, pattern, pause bool) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+
+	var name string
+	var ok bool
+	if vs, name, ok = tokenval(vs); !ok || name == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	var buffered bool
+	if pause && len(vs) > 0 {
+		var arg string
+		if vs, arg, ok = tokenval(vs); !ok || strings.ToLower(arg) != "buffer" {
+			return NOMessage, d, errInvalidArgument(arg)
+		}
+		buffered = true
+	}
+	if len(vs) != 0 {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+
+	var hooks []*Hook
+	if pattern {
+		for hname, hook := range s.hooks {
+			if hook.channel != chanCmd {
+				continue
+			}
+			if match, _ := glob.Match(name, hname); match {
+				hooks = append(hooks, hook)
+			}
+		}
+	} else if hook, ok := s.hooks[name]; ok && hook.channel == chanCmd {
+		hooks = append(hooks, hook)
+	}
+	count := 0
+	for _, hook := range hooks {
+		if pause {
+			if hook.Pause(buffered) {
+				count++
+			}
+		} else {
+			var chanbuf []string
+			if hook.paused {
+				chanbuf = hook.chanbuf
+				hook.chanbuf = nil
+				if !hook.channel {
+					// the messages that were queued while paused expire
+					// like the others from now on
+					if err := expireHookLogs(s.qdb, hook.Name); err != nil {
+						log.Errorf("resumehook: %v", err)
+					}
+				}
+			}
+			if hook.Resume() {
+				count++
+			}
+			// deliver the messages that were buffered while paused
+			for _, m := range chanbuf {
+				s.Publish(hook.Name, m)
+			}
+			if len(chanbuf) > 0 {
+				hook.delivery.delivered(len(chanbuf))
+			}
+		}
+	}
+	if count > 0 {
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+
+	switch msg.OutputType {
+	case JSON:
+		return OKMessage(msg, start), d, nil
+	case RESP:
+		return resp.IntegerValue(count), d, nil
+	}
+	return NOMessage, d, nil
+}
+
+func (s *Server) cmdFenceTest(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+
+	var name, typ string
+	var ok bool
+	if vs, name, ok = tokenval(vs); !ok || name == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if vs, typ, ok = tokenval(vs); !ok || typ == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	var obj geojson.Object
+	switch strings.ToLower(typ) {
+	default:
+		return NOMessage, errInvalidArgument(typ)
+	case "point":
+		var slat, slon string
+		if vs, slat, ok = tokenval(vs); !ok || slat == "" {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		if vs, slon, ok = tokenval(vs); !ok || slon == "" {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		lat, err := strconv.ParseFloat(slat, 64)
+		if err != nil {
+			return NOMessage, errInvalidArgument(slat)
+		}
+		lon, err := strconv.ParseFloat(slon, 64)
+		if err != nil {
+			return NOMessage, errInvalidArgument(slon)
+		}
+		obj = geojson.NewPoint(geometry.Point{X: lon, Y: lat})
+	case "object":
+		var object string
+		if vs, object, ok = tokenval(vs); !ok || object == "" {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		obj, err = geojson.Parse(object, &s.geomParseOpts)
+		if err != nil {
+			return NOMessage, err
+		}
+	}
+	id := "fencetest"
+	if len(vs) > 0 {
+		if vs, id, ok = tokenval(vs); !ok || id == "" {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+	}
+	if len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	hook, ok := s.hooks[name]
+	if !ok {
+		return NOMessage, errHookNotFound
+	}
+
+	// Simulate a SET on the hook's collection. When the id already exists
+	// then the current object is used as the previous position.
+	d := &commandDetails{
+		command:   "set",
+		key:       hook.Key,
+		id:        id,
+		obj:       obj,
+		fmap:      map[string]int{},
+		timestamp: time.Now(),
+	}
+	if col := s.getCol(hook.Key); col != nil {
+		d.fmap = col.FieldMap()
+		d.oldObj, d.oldFields, _ = col.Get(id)
+		d.fields = d.oldFields
+	}
+	// Work on a copy of the fence so that the hook's tracking state is
+	// left untouched.
+	fence := *hook.Fence
+	fence.groups = make(map[string]string, len(hook.Fence.groups))
+	for k, v := range hook.Fence.groups {
+		fence.groups[k] = v
+	}
+	msgs := FenceMatch(hook.Name, hook.ScanWriter, &fence, hook.Metas, d)
+
+	switch msg.OutputType {
+	case JSON:
+		buf := &bytes.Buffer{}
+		buf.WriteString(`{"ok":true,"events":[`)
+		for i, m := range msgs {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(m)
+		}
+		buf.WriteString(`],"elapsed":"` + time.Since(start).String() + "\"}")
+		return resp.StringValue(buf.String()), nil
+	case RESP:
+		vals := make([]resp.Value, len(msgs))
+		for i, m := range msgs {
+			vals[i] = resp.StringValue(m)
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
+// updateFenceRefs updates the area of all hooks and live fences that
+// reference the object that was changed by the command. The fences lose
+// their area when the object is deleted or expires, or is no longer spatial.
+func (s *Server) updateFenceRefs(d *commandDetails) {
+	if d.parent {
+		for _, d := range d.children {
+			s.updateFenceRefs(d)
+		}
+		return
+	}
+	switch d.command {
+	case "del":
+		s.setFenceRefs(d.key, d.id, nil)
+	case "drop", "rename":
+		s.setFenceRefs(d.key, "", nil)
+	case "flushdb":
+		s.setFenceRefs("", "", nil)
+	default:
+		if d.obj == nil {
+			return
+		}
+		obj := d.obj
+		if !objIsSpatial(obj) {
+			obj = nil
+		}
+		s.setFenceRefs(d.key, d.id, obj)
+	}
+}
+
+// setFenceRefs sets the area of the hooks and live fences that reference an
+// object to the object, or to no area at all when the object is nil. An
+// empty id is all the objects of the key, and an empty key is all objects.
+func (s *Server) setFenceRefs(key, id string, obj geojson.Object) {
+	match := func(ref fenceRef) bool {
+		return ref.key != "" && (key == "" || ref.key == key) &&
+			(id == "" || ref.id == id)
+	}
+	var refs []fenceRef
+	if id != "" {
+		refs = append(refs, fenceRef{key: key, id: id})
+	} else {
+		for ref := range s.hookRefs {
+			if match(ref) {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	for _, ref := range refs {
+		for name := range s.hookRefs[ref] {
+			hook := s.hooks[name]
+			if hook == nil || hook.Fence.ref != ref {
+				// the hook was deleted or changed
+				delete(s.hookRefs[ref], name)
+				continue
+			}
+			hook.Fence.obj = bufferObject(obj, hook.Fence.buffer)
+			s.hookIndex.set(hook)
+		}
+		if len(s.hookRefs[ref]) == 0 {
+			delete(s.hookRefs, ref)
+		}
+	}
+	s.lcond.L.Lock()
+	for lb := range s.lives {
+		if match(lb.fence.ref) {
+			lb.fence.obj = bufferObject(obj, lb.fence.buffer)
+		}
+	}
+	s.lcond.L.Unlock()
+}
+
+// expireHookLogs sets the expiration of the queued hook logs of a hook that
+// were queued while it was paused, which do not expire until then.
+func expireHookLogs(db *buntdb.DB, name string) error {
+	return db.Update(func(tx *buntdb.Tx) error {
+		var keys, vals []string
+		query := `{"hook":` + jsonString(name) + `}`
+		err := tx.AscendGreaterOrEqual("hooks", query,
+			func(key, val string) bool {
+				if gjson.Get(val, "hook").String() != name {
+					return false
+				}
+				if strings.HasPrefix(key, hookLogPrefix) {
+					keys = append(keys, key)
+					vals = append(vals, val)
+				}
+				return true
+			},
+		)
+		if err != nil {
+			return err
+		}
+		for i, key := range keys {
+			if ttl, err := tx.TTL(key); err != nil || ttl >= 0 {
+				continue
+			}
+			if _, _, err := tx.Set(key, vals[i], hookLogSetDefaults); err != nil {
+				return err
+			}
+			tkey := hookTracePrefix + key[len(hookLogPrefix):]
+			if parent, err := tx.Get(tkey); err == nil {
+				_, _, err := tx.Set(tkey, parent, hookLogSetDefaults)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// forgetPausedHookLogs lets the queued hook logs of a paused hook that is
+// deleted expire, as they are never delivered.
+func (s *Server) forgetPausedHookLogs(hook *Hook) {
+	if hook.channel || !hook.paused {
+		return
+	}
+	if err := expireHookLogs(s.qdb, hook.Name); err != nil {
+		log.Errorf("hook logs: %v", err)
+	}
+}
+
+// renameHookLogs reassigns all queued hook logs from one hook name to
+// another.
+func renameHookLogs(db *buntdb.DB, name, newName string) error {
+	return db.Update(func(tx *buntdb.Tx) error {
+		var keys, vals []string
+		query := `{"hook":` + jsonString(name) + `}`
+		err := tx.AscendGreaterOrEqual("hooks", query,
+			func(key, val string) bool {
+				if gjson.Get(val, "hook").String() != name {
+					return false
+				}
+				if strings.HasPrefix(key, hookLogPrefix) {
+					keys = append(keys, key)
+					vals = append(vals, val)
+				}
+				return true
+			},
+		)
+		if err != nil {
+			return err
+		}
+		for i, key := range keys {
+			ttl, err := tx.TTL(key)
+			if err != nil {
+				return err
+			}
+			val, err := sjson.Set(vals[i], "hook", newName)
+			if err != nil {
+				return err
+			}
+			var opts *buntdb.SetOptions
+			if ttl > 0 {
+				opts = &buntdb.SetOptions{Expires: true, TTL: ttl}
+			}
+			if _, _, err := tx.Set(key, val, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *Server) cmdDelHook(msg *Message, chanCmd bool) (
 	res resp.Value, d commandDetails, err error,
 ) {
@@ -250,23 +705,10 @@ func (s *Server) cmdDelHook(msg *Message, chanCmd bool) (
 	}
 	if hook, ok := s.hooks[name]; ok && hook.channel == chanCmd {
 		hook.Close()
+		s.forgetPausedHookLogs(hook)
 		// remove hook from maps
 		delete(s.hooks, hook.Name)
-		delete(s.hooksOut, hook.Name)
-		// remove hook from spatial index
-		if hook.Fence != nil && hook.Fence.obj != nil {
-			rect := hook.Fence.obj.Rect()
-			s.hookTree.Delete(
-				[2]float64{rect.Min.X, rect.Min.Y},
-				[2]float64{rect.Max.X, rect.Max.Y},
-				hook)
-			if hook.Fence.detect["cross"] {
-				s.hookCross.Delete(
-					[2]float64{rect.Min.X, rect.Min.Y},
-					[2]float64{rect.Max.X, rect.Max.Y},
-					hook)
-			}
-		}
+		s.hookIndex.remove(hook)
 		d.updated = true
 	}
 	d.timestamp = time.Now()
@@ -308,23 +750,10 @@ func (s *Server) cmdPDelHook(msg *Message, channel bool) (
 			continue
 		}
 		hook.Close()
+		s.forgetPausedHookLogs(hook)
 		// remove hook from maps
 		delete(s.hooks, hook.Name)
-		delete(s.hooksOut, hook.Name)
-		// remove hook from spatial index
-		if hook.Fence != nil && hook.Fence.obj != nil {
-			rect := hook.Fence.obj.Rect()
-			s.hookTree.Delete(
-				[2]float64{rect.Min.X, rect.Min.Y},
-				[2]float64{rect.Max.X, rect.Max.Y},
-				hook)
-			if hook.Fence.detect["cross"] {
-				s.hookCross.Delete(
-					[2]float64{rect.Min.X, rect.Min.Y},
-					[2]float64{rect.Max.X, rect.Max.Y},
-					hook)
-			}
-		}
+		s.hookIndex.remove(hook)
 		d.updated = true
 		count++
 	}
@@ -368,7 +797,10 @@ func (s *Server) possiblyExpireHook(name string) {
 	s.mu.Unlock()
 }
 
-func (s *Server) cmdHooks(msg *Message, channel bool) (
+// cmdHooks lists the hooks, or the channels, whose names match a pattern.
+// The hooks of the keys that the user of the client may not access are not
+// listed.
+func (s *Server) cmdHooks(msg *Message, client *Client, channel bool) (
 	res resp.Value, err error,
 ) {
 	start := time.Now()
@@ -380,21 +812,101 @@ func (s *Server) cmdHooks(msg *Message, channel bool) (
 	if vs, pattern, ok = tokenval(vs); !ok || pattern == "" {
 		return NOMessage, errInvalidNumberOfArguments
 	}
-	if len(vs) != 0 {
-		return NOMessage, errInvalidNumberOfArguments
+	var withstatus bool
+	var metas []FenceMeta
+	var area geojson.Object
+	var scursor, slimit string
+	for len(vs) > 0 {
+		wtok := vs[0]
+		switch strings.ToLower(wtok) {
+		default:
+			return NOMessage, errInvalidArgument(wtok)
+		case "withstatus":
+			vs = vs[1:]
+			withstatus = true
+		case "meta":
+			var meta FenceMeta
+			if vs, meta.Name, ok = tokenval(vs[1:]); !ok {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			if vs, meta.Value, ok = tokenval(vs); !ok {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			metas = append(metas, meta)
+		case "intersects":
+			if area != nil {
+				return NOMessage, errDuplicateArgument(strings.ToUpper(wtok))
+			}
+			if vs, area, err = s.parseArea(vs[1:], false); err != nil {
+				return NOMessage, err
+			}
+		case "cursor":
+			if scursor != "" {
+				return NOMessage, errDuplicateArgument(strings.ToUpper(wtok))
+			}
+			if vs, scursor, ok = tokenval(vs[1:]); !ok || scursor == "" {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+		case "limit":
+			if slimit != "" {
+				return NOMessage, errDuplicateArgument(strings.ToUpper(wtok))
+			}
+			if vs, slimit, ok = tokenval(vs[1:]); !ok || slimit == "" {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+		}
+	}
+	paged := scursor != "" || slimit != ""
+	var cursor, limit uint64
+	if scursor != "" {
+		if cursor, err = strconv.ParseUint(scursor, 10, 64); err != nil {
+			return NOMessage, errInvalidArgument(scursor)
+		}
+	}
+	if slimit != "" {
+		limit, err = strconv.ParseUint(slimit, 10, 64)
+		if err != nil || limit == 0 {
+			return NOMessage, errInvalidArgument(slimit)
+		}
 	}
 
 	var hooks []*Hook
-	for name, hook := range s.hooks {
-		if hook.channel != channel {
-			continue
+	if area != nil {
+		// only the hooks whose fences are in the index can intersect
+		for _, hook := range s.hookIndex.intersects(area) {
+			if hookMatches(hook, channel, pattern, metas) {
+				hooks = append(hooks, hook)
+			}
 		}
-		match, _ := glob.Match(pattern, name)
-		if match {
-			hooks = append(hooks, hook)
+	} else {
+		for _, hook := range s.hooks {
+			if hookMatches(hook, channel, pattern, metas) {
+				hooks = append(hooks, hook)
+			}
 		}
 	}
+	if allowed := s.aclKeyFilter(client); allowed != nil {
+		listed := hooks[:0]
+		for _, hook := range hooks {
+			if allowed(strings.TrimPrefix(hook.Key, msg.namespace)) {
+				listed = append(listed, hook)
+			}
+		}
+		hooks = listed
+	}
 	sort.Sort(hooksByName(hooks))
+	var next uint64
+	if paged {
+		if cursor >= uint64(len(hooks)) {
+			hooks = nil
+		} else {
+			hooks = hooks[cursor:]
+		}
+		if limit > 0 && uint64(len(hooks)) > limit {
+			hooks = hooks[:limit]
+			next = cursor + limit
+		}
+	}
 
 	switch msg.OutputType {
 	case JSON:
@@ -410,15 +922,17 @@ func (s *Server) cmdHooks(msg *Message, channel bool) (
 				buf.WriteByte(',')
 			}
 			buf.WriteString(`{`)
-			buf.WriteString(`"name":` + jsonString(hook.Name))
-			buf.WriteString(`,"key":` + jsonString(hook.Key))
+			buf.WriteString(`"name":` +
+				jsonString(strings.TrimPrefix(hook.Name, msg.namespace)))
+			buf.WriteString(`,"key":` +
+				jsonString(strings.TrimPrefix(hook.Key, msg.namespace)))
 			if !channel {
 				buf.WriteString(`,"endpoints":[`)
-				for i, endpoint := range hook.Endpoints {
+				for i, ep := range hook.Endpoints {
 					if i > 0 {
 						buf.WriteByte(',')
 					}
-					buf.WriteString(jsonString(endpoint))
+					buf.WriteString(jsonString(endpoint.Redact(ep)))
 				}
 				buf.WriteString(`]`)
 			}
@@ -438,20 +952,37 @@ func (s *Server) cmdHooks(msg *Message, channel bool) (
 				buf.WriteString(`:`)
 				buf.WriteString(jsonString(meta.Value))
 			}
-			buf.WriteString(`}}`)
+			buf.WriteString(`}`)
+			if hook.paused {
+				buf.WriteString(`,"paused":true`)
+			}
+			if hook.follower {
+				buf.WriteString(`,"follower":true`)
+			}
+			if withstatus {
+				buf.WriteString(`,"status":`)
+				buf.Write(s.hookStatus(hook).appendJSON(nil))
+			}
+			buf.WriteString(`}`)
 		}
-		buf.WriteString(`],"elapsed":"` +
+		buf.WriteString(`]`)
+		if paged {
+			buf.WriteString(`,"cursor":` + strconv.FormatUint(next, 10))
+		}
+		buf.WriteString(`,"elapsed":"` +
 			time.Since(start).String() + "\"}")
 		return resp.StringValue(buf.String()), nil
 	case RESP:
 		var vals []resp.Value
 		for _, hook := range hooks {
 			var hvals []resp.Value
-			hvals = append(hvals, resp.StringValue(hook.Name))
-			hvals = append(hvals, resp.StringValue(hook.Key))
+			hvals = append(hvals, resp.StringValue(
+				strings.TrimPrefix(hook.Name, msg.namespace)))
+			hvals = append(hvals, resp.StringValue(
+				strings.TrimPrefix(hook.Key, msg.namespace)))
 			var evals []resp.Value
-			for _, endpoint := range hook.Endpoints {
-				evals = append(evals, resp.StringValue(endpoint))
+			for _, ep := range hook.Endpoints {
+				evals = append(evals, resp.StringValue(endpoint.Redact(ep)))
 			}
 			hvals = append(hvals, resp.ArrayValue(evals))
 			avals := make([]resp.Value, len(hook.Message.Args))
@@ -465,13 +996,58 @@ func (s *Server) cmdHooks(msg *Message, channel bool) (
 				metas = append(metas, resp.StringValue(meta.Value))
 			}
 			hvals = append(hvals, resp.ArrayValue(metas))
+			if withstatus {
+				hvals = append(hvals, s.hookStatus(hook).respValue())
+			}
 			vals = append(vals, resp.ArrayValue(hvals))
 		}
+		if paged {
+			return resp.ArrayValue([]resp.Value{
+				resp.IntegerValue(int(next)),
+				resp.ArrayValue(vals),
+			}), nil
+		}
 		return resp.ArrayValue(vals), nil
 	}
 	return resp.SimpleStringValue(""), nil
 }
 
+// hookMatches returns true when a hook, or a channel, has a name that matches
+// the pattern, and has all of the metas. The value of each meta is a pattern.
+func hookMatches(hook *Hook, channel bool, pattern string,
+	metas []FenceMeta,
+) bool {
+	if hook.channel != channel {
+		return false
+	}
+	if match, _ := glob.Match(pattern, hook.Name); !match {
+		return false
+	}
+	for _, meta := range metas {
+		var found bool
+		for _, hmeta := range hook.Metas {
+			if hmeta.Name == meta.Name {
+				found, _ = glob.Match(meta.Value, hmeta.Value)
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// redactEndpoints returns the comma separated endpoints of SETHOOK without
+// their credentials.
+func redactEndpoints(urls string) string {
+	eps := strings.Split(urls, ",")
+	for i, ep := range eps {
+		eps[i] = endpoint.Redact(ep)
+	}
+	return strings.Join(eps, ",")
+}
+
 // Hook represents a hook.
 type Hook struct {
 	cond       *sync.Cond
@@ -490,7 +1066,143 @@ type Hook struct {
 	epm        *endpoint.Manager
 	expires    time.Time
 	counter    *aint // counter that grows when a message was sent
+	delivery   *hookDelivery
+	latency    *latencyMonitor
 	sig        int
+	idle       bool     // the manager waits for a signal after sig
+	idleSig    int      // the sig that the manager has handled
+	paused     bool     // delivery is paused
+	buffered   bool     // messages are retained while paused
+	follower   bool     // evaluated by a follower, see followerhooks
+	chanbuf    []string // channel messages retained while paused
+	failed     func(h *Hook, ep string, attempt int, err error)
+	attempts   int // the failed attempts to deliver the next message
+}
+
+// hookDelivery are the delivery stats of a hook, which are kept when the
+// hook is renamed.
+type hookDelivery struct {
+	sent    aint // messages delivered to an endpoint
+	failed  aint // failed attempts to deliver a message
+	dropped aint // messages of a paused channel over hookChanBufSize
+
+	mu            sync.Mutex
+	lastSent      time.Time // when a message was last delivered
+	lastError     string    // the error of the last failed attempt
+	lastErrorTime time.Time
+}
+
+// delivered counts the messages that were delivered.
+func (d *hookDelivery) delivered(n int) {
+	d.sent.add(n)
+	d.mu.Lock()
+	d.lastSent = time.Now()
+	d.mu.Unlock()
+}
+
+// fail counts a failed attempt to deliver a message.
+func (d *hookDelivery) fail(err error) {
+	d.failed.add(1)
+	d.mu.Lock()
+	d.lastError = err.Error()
+	d.lastErrorTime = time.Now()
+	d.mu.Unlock()
+}
+
+// hookStatus is the delivery status of a hook, see HOOKS WITHSTATUS.
+type hookStatus struct {
+	sent          int
+	failed        int
+	queued        int // the messages that wait to be delivered
+	dropped       int // the messages that were dropped while paused
+	lastSent      time.Time
+	lastError     string
+	lastErrorTime time.Time
+}
+
+// hookStatus returns the delivery status of a hook. The queued messages of
+// a channel are the messages that are held while it's paused.
+func (s *Server) hookStatus(h *Hook) hookStatus {
+	d := h.delivery
+	st := hookStatus{
+		sent:    d.sent.get(),
+		failed:  d.failed.get(),
+		dropped: d.dropped.get(),
+	}
+	d.mu.Lock()
+	st.lastSent = d.lastSent
+	st.lastError = d.lastError
+	st.lastErrorTime = d.lastErrorTime
+	d.mu.Unlock()
+	if h.channel {
+		st.queued = len(h.chanbuf)
+	} else {
+		st.queued = queuedHookLogs(s.qdb, h.Name)
+	}
+	return st
+}
+
+// queuedHookLogs returns the number of queued hook logs of a hook.
+func queuedHookLogs(db *buntdb.DB, name string) int {
+	var n int
+	db.View(func(tx *buntdb.Tx) error {
+		query := `{"hook":` + jsonString(name) + `}`
+		return tx.AscendGreaterOrEqual("hooks", query,
+			func(key, val string) bool {
+				if gjson.Get(val, "hook").String() != name {
+					return false
+				}
+				if strings.HasPrefix(key, hookLogPrefix) {
+					n++
+				}
+				return true
+			},
+		)
+	})
+	return n
+}
+
+func (st hookStatus) appendJSON(b []byte) []byte {
+	b = append(b, `{"sent":`...)
+	b = strconv.AppendInt(b, int64(st.sent), 10)
+	b = append(b, `,"failed":`...)
+	b = strconv.AppendInt(b, int64(st.failed), 10)
+	b = append(b, `,"queued":`...)
+	b = strconv.AppendInt(b, int64(st.queued), 10)
+	b = append(b, `,"dropped":`...)
+	b = strconv.AppendInt(b, int64(st.dropped), 10)
+	if !st.lastSent.IsZero() {
+		b = append(b, `,"last_sent":`...)
+		b = appendJSONTimeFormat(b, st.lastSent)
+	}
+	if st.lastError != "" {
+		b = append(b, `,"last_error":`...)
+		b = appendJSONString(b, st.lastError)
+		b = append(b, `,"last_error_time":`...)
+		b = appendJSONTimeFormat(b, st.lastErrorTime)
+	}
+	return append(b, '}')
+}
+
+// respValue returns the status as a list of names and values.
+func (st hookStatus) respValue() resp.Value {
+	vals := []resp.Value{
+		resp.StringValue("sent"), resp.IntegerValue(st.sent),
+		resp.StringValue("failed"), resp.IntegerValue(st.failed),
+		resp.StringValue("queued"), resp.IntegerValue(st.queued),
+		resp.StringValue("dropped"), resp.IntegerValue(st.dropped),
+	}
+	if !st.lastSent.IsZero() {
+		vals = append(vals, resp.StringValue("last_sent"),
+			resp.StringValue(st.lastSent.Format(time.RFC3339Nano)))
+	}
+	if st.lastError != "" {
+		vals = append(vals, resp.StringValue("last_error"),
+			resp.StringValue(st.lastError),
+			resp.StringValue("last_error_time"),
+			resp.StringValue(st.lastErrorTime.Format(time.RFC3339Nano)))
+	}
+	return resp.ArrayValue(vals)
 }
 
 // Expires returns when the hook expires. Required by the expire.Item interface.
@@ -506,7 +1218,7 @@ func (h *Hook) Equals(hook *Hook) bool {
 		len(h.Metas) != len(hook.Metas) {
 		return false
 	}
-	if !h.expires.Equal(hook.expires) {
+	if !h.expires.Equal(hook.expires) || h.follower != hook.follower {
 		return false
 	}
 	for i, endpoint := range h.Endpoints {
@@ -582,6 +1294,58 @@ func (h *Hook) Close() {
 	h.cond.Broadcast()
 }
 
+// Pause stops the delivery of messages. The hook continues to track the
+// fence state. When buffered is true the messages are retained until the
+// hook is resumed, otherwise they are dropped.
+// Returns false if the hook was already paused.
+func (h *Hook) Pause(buffered bool) bool {
+	h.cond.L.Lock()
+	defer h.cond.L.Unlock()
+	if h.paused && h.buffered == buffered {
+		return false
+	}
+	h.paused = true
+	h.buffered = buffered
+	return true
+}
+
+// bufferMessages holds on to the messages of a paused channel until it's
+// resumed. The messages over hookChanBufSize are dropped.
+func (h *Hook) bufferMessages(msgs []string) {
+	if n := hookChanBufSize - len(h.chanbuf); n < len(msgs) {
+		if n < 0 {
+			n = 0
+		}
+		h.delivery.dropped.add(len(msgs) - n)
+		msgs = msgs[:n]
+	}
+	h.chanbuf = append(h.chanbuf, msgs...)
+}
+
+// logSetOptions returns the options of the queued hook logs of the hook,
+// which do not expire while the hook is paused, see expireHookLogs.
+func (h *Hook) logSetOptions() *buntdb.SetOptions {
+	if h.paused {
+		return nil
+	}
+	return hookLogSetDefaults
+}
+
+// Resume restarts the delivery of messages.
+// Returns false if the hook was not paused.
+func (h *Hook) Resume() bool {
+	h.cond.L.Lock()
+	defer h.cond.L.Unlock()
+	if !h.paused {
+		return false
+	}
+	h.paused = false
+	h.buffered = false
+	h.sig++
+	h.cond.Broadcast()
+	return true
+}
+
 // Signal can be called at any point to wake up the hook and
 // notify the manager that there may be something new in the queue.
 func (h *Hook) Signal() {
@@ -607,6 +1371,11 @@ func (h *Hook) manager() {
 			// the hook has closed, end manager
 			return
 		}
+		if h.paused {
+			// wait until the hook is resumed
+			h.cond.Wait()
+			continue
+		}
 		sig = h.sig
 		// unlock/logk the hook and send outgoing messages
 		if !func() bool {
@@ -623,15 +1392,28 @@ func (h *Hook) manager() {
 			continue
 		}
 		// wait on signal
+		h.idle, h.idleSig = true, sig
 		h.cond.Wait()
+		h.idle = false
+	}
+}
+
+// drained returns true when the manager has sent the queued messages, or
+// when the hook is closed or paused, see shutdown.
+func (h *Hook) drained() bool {
+	if h.channel {
+		return true
 	}
+	h.cond.L.Lock()
+	defer h.cond.L.Unlock()
+	return !h.opened || h.closed || h.paused || (h.idle && h.idleSig == h.sig)
 }
 
 // proc processes queued hook logs.
 // returning true will indicate that all log entries have been
 // successfully handled.
 func (h *Hook) proc() (ok bool) {
-	var keys, vals []string
+	var keys, vals, parents []string
 	var ttls []time.Duration
 	start := time.Now()
 	err := h.db.Update(func(tx *buntdb.Tx) error {
@@ -667,6 +1449,14 @@ func (h *Hook) proc() (ok bool) {
 					return err
 				}
 			}
+			// the traceparent of the write that queued the log
+			parent, err := tx.Delete(hookTracePrefix + key[len(hookLogPrefix):])
+			if err != nil {
+				if err != buntdb.ErrNotFound {
+					return err
+				}
+			}
+			parents = append(parents, parent)
 		}
 		return nil
 	})
@@ -679,38 +1469,61 @@ func (h *Hook) proc() (ok bool) {
 	for i, key := range keys {
 		val := vals[i]
 		idx := stringToUint64(key[len(hookLogPrefix):])
+		parent, _ := trace.ParseTraceparent(parents[i])
 		var sent bool
-		for _, endpoint := range h.Endpoints {
-			err := h.epm.Send(endpoint, val)
+		for _, ep := range h.Endpoints {
+			sendStart := time.Now()
+			err := h.epm.SendTrace(parent, ep, val)
+			h.latency.observe(latencyHookDelivery, time.Since(sendStart))
 			if err != nil {
-				log.Debugf("Endpoint connect/send error: %v: %v: %v",
-					idx, endpoint, err)
+				epLog.Debugf("Endpoint connect/send error: %v: %v: %v",
+					idx, endpoint.Redact(ep), err)
+				h.delivery.fail(err)
+				if h.failed != nil {
+					h.failed(h, ep, h.attempts+1, err)
+				}
 				continue
 			}
-			log.Debugf("Endpoint send ok: %v: %v: %v", idx, endpoint, err)
+			epLog.Debugf("Endpoint send ok: %v: %v: %v", idx,
+				endpoint.Redact(ep), err)
 			sent = true
+			h.attempts = 0
 			h.counter.add(1)
+			h.delivery.delivered(1)
 			break
 		}
 		if !sent {
+			h.attempts++
 			// failed to send. try to reinsert the remaining.
 			// if this fails we lose log entries.
 			keys = keys[i:]
 			vals = vals[i:]
 			ttls = ttls[i:]
+			parents = parents[i:]
 			h.db.Update(func(tx *buntdb.Tx) error {
 				for i, key := range keys {
 					val := vals[i]
 					ttl := ttls[i] - time.Since(start)
-					if ttl > 0 {
-						opts := &buntdb.SetOptions{
-							Expires: true,
-							TTL:     ttl,
+					if ttls[i] < 0 || ttl > 0 {
+						var opts *buntdb.SetOptions
+						if ttls[i] >= 0 {
+							// the logs of a paused hook do not expire
+							opts = &buntdb.SetOptions{
+								Expires: true,
+								TTL:     ttl,
+							}
 						}
 						_, _, err := tx.Set(key, val, opts)
 						if err != nil {
 							return err
 						}
+						if parents[i] != "" {
+							_, _, err := tx.Set(hookTracePrefix+
+								key[len(hookLogPrefix):], parents[i], opts)
+							if err != nil {
+								return err
+							}
+						}
 					}
 				}
 				return nil
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestPausedHookLogs(t *testing.T) {
+	db, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.CreateIndex("hooks", hookLogPrefix+"*",
+		buntdb.IndexJSONCaseSensitive("hook"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{qdb: db}
+	h := &Hook{Name: "fleet", cond: sync.NewCond(&sync.Mutex{}),
+		delivery: &hookDelivery{}}
+	h.Pause(true)
+	err = s.queueHookLogs([]string{`{"hook":"fleet"}`}, h.logSetOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.queueHookLogs([]string{`{"hook":"other"}`}, nil); err != nil {
+		t.Fatal(err)
+	}
+	ttl := func(idx uint64) time.Duration {
+		t.Helper()
+		var ttl time.Duration
+		err := db.View(func(tx *buntdb.Tx) error {
+			var err error
+			ttl, err = tx.TTL(hookLogPrefix + uint64ToString(idx))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ttl
+	}
+	// the messages of a paused hook do not expire until it's resumed
+	if ttl(1) >= 0 || ttl(2) >= 0 {
+		t.Fatalf("expected no expiration, got %s %s", ttl(1), ttl(2))
+	}
+	if err := expireHookLogs(db, "fleet"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl(1) <= 0 || ttl(1) > hookLogSetDefaults.TTL || ttl(2) >= 0 {
+		t.Fatalf("expected an expiration of fleet, got %s %s", ttl(1), ttl(2))
+	}
+	h.Resume()
+	if h.logSetOptions() != hookLogSetDefaults {
+		t.Fatal("expected the default options")
+	}
+
+	// the messages of a paused channel are capped
+	msgs := make([]string, hookChanBufSize-1)
+	h.bufferMessages(msgs)
+	h.bufferMessages([]string{"a", "b", "c"})
+	h.bufferMessages([]string{"d"})
+	if len(h.chanbuf) != hookChanBufSize || h.chanbuf[len(h.chanbuf)-1] != "a" {
+		t.Fatalf("expected %d messages, got %d", hookChanBufSize,
+			len(h.chanbuf))
+	}
+	if n := h.delivery.dropped.get(); n != 3 {
+		t.Fatalf("expected 3 dropped messages, got %d", n)
+	}
+}
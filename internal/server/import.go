@@ -0,0 +1,696 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/sjson"
+	"github.com/tidwall/tile38/internal/columnar"
+	"github.com/tidwall/tile38/internal/field"
+	"github.com/tidwall/tile38/internal/gisfile"
+	"github.com/tidwall/tile38/internal/objstore"
+)
+
+// importBatchSize is the number of features that are written while holding
+// the server lock during an IMPORT.
+const importBatchSize = 1000
+
+var errInvalidFeatureCollection = errors.New("invalid FeatureCollection")
+
+// resolveDataPath returns path, or for relative paths, path in the data
+// directory.
+func (server *Server) resolveDataPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(server.dir, path)
+}
+
+// readFeatures reads the features of a GeoJSON FeatureCollection one at a
+// time, without loading the whole document into memory.
+func readFeatures(r io.Reader, iter func(feature []byte) error) error {
+	dec := json.NewDecoder(r)
+	expectDelim := func(delim json.Delim) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok != delim {
+			return errInvalidFeatureCollection
+		}
+		return nil
+	}
+	if err := expectDelim('{'); err != nil {
+		return err
+	}
+	var typ string
+	var hasFeatures bool
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case "type":
+			if err := dec.Decode(&typ); err != nil {
+				return err
+			}
+			if typ != "FeatureCollection" {
+				return errInvalidFeatureCollection
+			}
+		case "features":
+			if err := expectDelim('['); err != nil {
+				return err
+			}
+			for dec.More() {
+				var feature json.RawMessage
+				if err := dec.Decode(&feature); err != nil {
+					return err
+				}
+				if err := iter(feature); err != nil {
+					return err
+				}
+			}
+			if err := expectDelim(']'); err != nil {
+				return err
+			}
+			hasFeatures = true
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	if typ == "" || !hasFeatures {
+		return errInvalidFeatureCollection
+	}
+	return nil
+}
+
+// featureArgs returns the SET command that stores a feature. The id is the
+// feature's "id" member, or the idProp property when provided. The fieldProps
+// properties are copied to fields of the same name.
+func featureArgs(key string, n int, feature []byte, idProp string,
+	fieldProps []string,
+) ([]string, error) {
+	if gjson.GetBytes(feature, "type").String() != "Feature" {
+		return nil, fmt.Errorf("feature %d: invalid feature", n)
+	}
+	var id gjson.Result
+	if idProp != "" {
+		id = gjson.GetBytes(feature, "properties."+escapeJSONPath(idProp))
+	} else {
+		id = gjson.GetBytes(feature, "id")
+	}
+	if id.String() == "" {
+		return nil, fmt.Errorf("feature %d: missing id", n)
+	}
+	args := []string{"set", key, id.String()}
+	for _, prop := range fieldProps {
+		value := gjson.GetBytes(feature, "properties."+escapeJSONPath(prop))
+		if !value.Exists() {
+			continue
+		}
+		if value.Type != gjson.Number {
+			return nil, fmt.Errorf("feature %d: property '%s' is not a number",
+				n, prop)
+		}
+		args = append(args, "field", prop, value.Raw)
+	}
+	return append(args, "object", string(feature)), nil
+}
+
+func escapeJSONPath(path string) string {
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '.', '*', '?', '|', '#', '@', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(path[i])
+	}
+	return sb.String()
+}
+
+// readFeatureFile reads the features in a GeoJSON FeatureCollection, an ESRI
+// Shapefile, or a GeoPackage layer, depending on the file extension.
+func readFeatureFile(path, layer string, iter func(feature []byte) error) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".shp":
+		return gisfile.ReadShapefile(path, iter)
+	case ".gpkg":
+		return gisfile.ReadGeoPackage(path, layer, iter)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readFeatures(bufio.NewReader(f), iter)
+}
+
+// importRows stores the SET commands that are produced by read. The commands
+// are written in batches, each of which holds the server lock, so that reads
+// and writes from other clients are not blocked for the whole import. The
+// unit names the rows in error messages.
+func (server *Server) importRows(unit string,
+	read func(iter func(args []string) error) error,
+) (count int, err error) {
+	batch := make([][]string, 0, importBatchSize)
+	flush := func() error {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		if !server.isLeader() {
+			return server.notLeaderErr()
+		}
+		if server.config.readOnly() {
+			return errReadOnly
+		}
+		server.beginBulkLoad()
+		defer server.endBulkLoad()
+		now := time.Now().UnixNano()
+		for _, args := range batch {
+			args = timestampArgs(args, now)
+			_, d, err := server.command(&Message{Args: args}, nil)
+			if err != nil {
+				return fmt.Errorf("%s %d: %v", unit, count, err)
+			}
+			if err := server.writeAOF(args, &d); err != nil {
+				return err
+			}
+			if len(server.aofbuf) > maxchunk {
+				server.flushAOF(false)
+			}
+			count++
+		}
+		batch = batch[:0]
+		return nil
+	}
+	err = read(func(args []string) error {
+		batch = append(batch, args)
+		if len(batch) == importBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	return count, err
+}
+
+// importFeatures stores the features in a GeoJSON, Shapefile, or GeoPackage
+// file in the collection at key.
+func (server *Server) importFeatures(key, path, layer, idProp string,
+	fieldProps []string,
+) (count int, err error) {
+	return server.importRows("feature", func(iter func(args []string) error) error {
+		var n int
+		return readFeatureFile(path, layer, func(feature []byte) error {
+			args, err := featureArgs(key, n, feature, idProp, fieldProps)
+			if err != nil {
+				return err
+			}
+			n++
+			return iter(args)
+		})
+	})
+}
+
+// csvField maps a csv column to a field.
+type csvField struct {
+	name   string
+	column string
+}
+
+// importCSV stores the rows of a csv file in the collection at key. The first
+// row is the header, which names the columns. Each row becomes a point with
+// the id in idCol and the coordinates in latCol and lonCol. Rows without
+// coordinates are skipped.
+func (server *Server) importCSV(key, path, idCol, latCol, lonCol string,
+	fields []csvField,
+) (count int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := csv.NewReader(bufio.NewReader(f))
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		if err == io.EOF {
+			err = errors.New("missing csv header")
+		}
+		return 0, err
+	}
+	columnIndex := func(name string) (int, error) {
+		for i, col := range header {
+			if i == 0 {
+				col = strings.TrimPrefix(col, "\ufeff")
+			}
+			if strings.EqualFold(strings.TrimSpace(col), name) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("column '%s' not found", name)
+	}
+	idIdx, err := columnIndex(idCol)
+	if err != nil {
+		return 0, err
+	}
+	latIdx, err := columnIndex(latCol)
+	if err != nil {
+		return 0, err
+	}
+	lonIdx, err := columnIndex(lonCol)
+	if err != nil {
+		return 0, err
+	}
+	fieldIdxs := make([]int, len(fields))
+	for i, field := range fields {
+		if fieldIdxs[i], err = columnIndex(field.column); err != nil {
+			return 0, err
+		}
+	}
+	return server.importRows("row", func(iter func(args []string) error) error {
+		for n := 0; ; n++ {
+			record, err := r.Read()
+			if err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			value := func(i int) string {
+				if i < len(record) {
+					return strings.TrimSpace(record[i])
+				}
+				return ""
+			}
+			id, lat, lon := value(idIdx), value(latIdx), value(lonIdx)
+			if lat == "" && lon == "" {
+				continue
+			}
+			if id == "" {
+				return fmt.Errorf("row %d: missing id", n)
+			}
+			if _, err := strconv.ParseFloat(lat, 64); err != nil {
+				return fmt.Errorf("row %d: invalid latitude", n)
+			}
+			if _, err := strconv.ParseFloat(lon, 64); err != nil {
+				return fmt.Errorf("row %d: invalid longitude", n)
+			}
+			args := []string{"set", key, id}
+			for i, field := range fields {
+				v := value(fieldIdxs[i])
+				if v == "" {
+					continue
+				}
+				if _, err := strconv.ParseFloat(v, 64); err != nil {
+					return fmt.Errorf("row %d: column '%s' is not a number",
+						n, field.column)
+				}
+				args = append(args, "field", field.name, v)
+			}
+			if err := iter(append(args, "point", lat, lon)); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// IMPORT key path [LAYER name] [ID property] [FIELD property ...]
+// IMPORT key path.csv ID column POINT lat lon [FIELD name column ...]
+func (server *Server) cmdImport(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	if len(vs) < 2 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	key, path := vs[0], vs[1]
+	isCSV := strings.EqualFold(filepath.Ext(path), ".csv")
+	var layer, idProp, latCol, lonCol string
+	var fieldProps []string
+	var csvFields []csvField
+	for vs = vs[2:]; len(vs) > 0; {
+		opt := strings.ToLower(vs[0])
+		nargs := 1
+		if isCSV && (opt == "field" || opt == "point") {
+			nargs = 2
+		}
+		if len(vs) < 1+nargs {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		switch {
+		case opt == "layer" && !isCSV:
+			layer = vs[1]
+		case opt == "id":
+			idProp = vs[1]
+		case opt == "point" && isCSV:
+			latCol, lonCol = vs[1], vs[2]
+		case opt == "field" && isCSV:
+			csvFields = append(csvFields, csvField{name: vs[1], column: vs[2]})
+		case opt == "field":
+			fieldProps = append(fieldProps, vs[1])
+		default:
+			return NOMessage, errInvalidArgument(vs[0])
+		}
+		vs = vs[1+nargs:]
+	}
+	var count int
+	var err error
+	path = server.resolveDataPath(path)
+	if isCSV {
+		if idProp == "" || latCol == "" {
+			return NOMessage, errors.New("ID and POINT are required for csv files")
+		}
+		count, err = server.importCSV(key, path, idProp, latCol, lonCol, csvFields)
+	} else {
+		count, err = server.importFeatures(key, path, layer, idProp, fieldProps)
+	}
+	if err != nil {
+		return NOMessage, err
+	}
+	switch msg.OutputType {
+	case JSON:
+		return resp.StringValue(`{"ok":true,"count":` + strconv.Itoa(count) +
+			`,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+	case RESP:
+		return resp.IntegerValue(count), nil
+	}
+	return NOMessage, nil
+}
+
+// exportFeatures writes the geometries in the collection at key as a GeoJSON
+// FeatureCollection. Each object is written as a Feature with the object's
+// id, and the fields are added to the properties. Strings are skipped.
+func (server *Server) exportFeatures(w io.Writer, key string) (count int, err error) {
+	server.mu.RLock()
+	col := server.getCol(key)
+	if col == nil {
+		server.mu.RUnlock()
+		return 0, errKeyNotFound
+	}
+	snap := col.Snapshot()
+	server.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"type":"FeatureCollection","features":[`)
+	var buf []byte
+	snap.Scan(false, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
+		if !objIsSpatial(obj) {
+			return true
+		}
+		buf = buf[:0]
+		if _, ok := obj.(*geojson.Feature); ok {
+			buf = obj.AppendJSON(buf)
+		} else {
+			buf = append(buf, `{"type":"Feature","geometry":`...)
+			buf = obj.AppendJSON(buf)
+			buf = append(buf, `,"properties":{}}`...)
+		}
+		buf, err = sjson.SetBytes(buf, "id", id)
+		if err != nil {
+			return false
+		}
+		for _, fv := range orderFields(snap.FieldMap(), snap.FieldArr(), fields) {
+			buf, err = sjson.SetRawBytes(buf,
+				"properties."+escapeJSONPath(fv.field), []byte(fv.value.JSON()))
+			if err != nil {
+				return false
+			}
+		}
+		if count > 0 {
+			bw.WriteByte(',')
+		}
+		bw.Write(buf)
+		count++
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	bw.WriteString(`]}`)
+	return count, bw.Flush()
+}
+
+// EXPORT key [GEOJSON|ARROW|PARQUET] [path]
+func (server *Server) cmdExport(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	if len(vs) < 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	key := vs[0]
+	vs = vs[1:]
+	format := "geojson"
+	if len(vs) > 0 {
+		switch strings.ToLower(vs[0]) {
+		case "geojson", "arrow", "parquet":
+			format = strings.ToLower(vs[0])
+			vs = vs[1:]
+		}
+	}
+	if len(vs) > 1 || (len(vs) == 0 && format != "geojson") {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if len(vs) == 0 {
+		// the collection is returned in the reply
+		var sb strings.Builder
+		if _, err := server.exportFeatures(&sb, key); err != nil {
+			return NOMessage, err
+		}
+		switch msg.OutputType {
+		case JSON:
+			return resp.StringValue(`{"ok":true,"object":` + sb.String() +
+				`,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+		case RESP:
+			return resp.StringValue(sb.String()), nil
+		}
+		return NOMessage, nil
+	}
+	write := func(w io.Writer) (int, error) {
+		if format == "geojson" {
+			return server.exportFeatures(w, key)
+		}
+		return server.exportColumnar(w, key, format)
+	}
+	var count int
+	var err error
+	if lower := strings.ToLower(vs[0]); strings.HasPrefix(lower, "s3://") ||
+		strings.HasPrefix(lower, "gs://") {
+		count, err = uploadExport(vs[0], write)
+	} else {
+		count, err = writeExport(server.resolveDataPath(vs[0]), write)
+	}
+	if err != nil {
+		return NOMessage, err
+	}
+	switch msg.OutputType {
+	case JSON:
+		return resp.StringValue(`{"ok":true,"count":` + strconv.Itoa(count) +
+			`,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+	case RESP:
+		return resp.IntegerValue(count), nil
+	}
+	return NOMessage, nil
+}
+
+// writeExport writes an export to a file. The export is written to a
+// temporary file, which replaces the file when it's complete.
+func writeExport(path string, write func(w io.Writer) (int, error),
+) (int, error) {
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return 0, err
+	}
+	count, err := write(f)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return 0, err
+	}
+	return count, nil
+}
+
+// uploadExport streams an export to the object of a url, such as
+// s3://bucket/prefix/points.parquet.
+func uploadExport(rawurl string, write func(w io.Writer) (int, error),
+) (int, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return 0, err
+	}
+	dir, name := path.Split(u.Path)
+	if name == "" {
+		return 0, fmt.Errorf("invalid url '%s': missing object name", rawurl)
+	}
+	u.Path = dir
+	store, err := objstore.Open(u.String())
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	done := make(chan struct{})
+	pr, pw := io.Pipe()
+	go func() {
+		defer close(done)
+		var err error
+		count, err = write(pw)
+		pw.CloseWithError(err)
+	}()
+	err = store.Upload(name, pr)
+	// unblocks the writer when the upload fails early
+	pr.CloseWithError(err)
+	<-done
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// exportBatchSize is the number of rows of each record batch or row group of
+// a columnar export.
+const exportBatchSize = 64 * 1024
+
+// exportColumnar writes the geometries in the collection at key as an Arrow
+// or a Parquet file. The columns are the id, the geometry as WKB, and the
+// fields. A field is a double column when all of its values are numbers, a
+// bool column when all of its values are bools, and otherwise a string
+// column. The fields that are not set are null, except for the double
+// columns where they are zero. Strings are skipped.
+func (server *Server) exportColumnar(w io.Writer, key, format string,
+) (count int, err error) {
+	server.mu.RLock()
+	col := server.getCol(key)
+	if col == nil {
+		server.mu.RUnlock()
+		return 0, errKeyNotFound
+	}
+	snap := col.Snapshot()
+	server.mu.RUnlock()
+
+	fmap, farr := snap.FieldMap(), snap.FieldArr()
+	numbers := make([]bool, len(farr))
+	bools := make([]bool, len(farr))
+	for i := range farr {
+		numbers[i], bools[i] = true, true
+	}
+	snap.Scan(false, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
+		if !objIsSpatial(obj) {
+			return true
+		}
+		for i, name := range farr {
+			if idx := fmap[name]; idx < len(fields) && !fields[idx].IsZero() {
+				numbers[i] = numbers[i] && fields[idx].Kind() == field.Number
+				bools[i] = bools[i] && fields[idx].Kind() == field.Bool
+			}
+		}
+		return true
+	})
+	schema := []columnar.Column{
+		{Name: "id", Type: columnar.String},
+		{Name: "geometry", Type: columnar.Binary, Geometry: true},
+	}
+	for i, name := range farr {
+		c := columnar.Column{Name: name, Type: columnar.String, Nullable: true}
+		if numbers[i] {
+			c.Type, c.Nullable = columnar.Double, false
+		} else if bools[i] {
+			c.Type = columnar.Bool
+		}
+		schema = append(schema, c)
+	}
+
+	bw := bufio.NewWriter(w)
+	var cw columnar.Writer
+	if format == "arrow" {
+		cw, err = columnar.NewArrowWriter(bw, schema)
+	} else {
+		cw, err = columnar.NewParquetWriter(bw, schema)
+	}
+	if err != nil {
+		return 0, err
+	}
+	batch := columnar.NewBatch(schema)
+	var buf, wkb []byte
+	snap.Scan(false, nil, nil, func(id string, obj geojson.Object, fields []field.Value) bool {
+		if !objIsSpatial(obj) {
+			return true
+		}
+		buf = obj.AppendJSON(buf[:0])
+		// the geometries of a batch share wkb, where the earlier ones stay
+		// valid when it grows.
+		start := len(wkb)
+		wkb, err = gisfile.AppendWKB(wkb, string(buf))
+		if err != nil {
+			return false
+		}
+		c := batch.Columns
+		c[0].Bytes = append(c[0].Bytes, []byte(id))
+		c[1].Bytes = append(c[1].Bytes, wkb[start:])
+		for i, name := range farr {
+			var v field.Value
+			if idx := fmap[name]; idx < len(fields) {
+				v = fields[idx]
+			}
+			vs := &c[i+2]
+			switch schema[i+2].Type {
+			case columnar.Double:
+				vs.Doubles = append(vs.Doubles, v.Num())
+			case columnar.Bool:
+				vs.Bools = append(vs.Bools, v.Num() != 0)
+				vs.Nulls = append(vs.Nulls, v.IsZero())
+			default:
+				vs.Bytes = append(vs.Bytes, []byte(v.String()))
+				vs.Nulls = append(vs.Nulls, v.IsZero())
+			}
+		}
+		batch.Len++
+		count++
+		if batch.Len == exportBatchSize {
+			if err = cw.Write(batch); err != nil {
+				return false
+			}
+			batch.Reset()
+			wkb = wkb[:0]
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if batch.Len > 0 {
+		if err := cw.Write(batch); err != nil {
+			return 0, err
+		}
+	}
+	if err := cw.Close(); err != nil {
+		return 0, err
+	}
+	return count, bw.Flush()
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/deadline"
+	"github.com/tidwall/tile38/internal/field"
+	"github.com/tidwall/tile38/internal/log"
+)
+
+// indexPlan is a range of a field index that has every object matching a
+// WHERE. The objects of the range are still tested with all of the WHERE
+// clauses, so the range may have more objects than those that match.
+type indexPlan struct {
+	name  string
+	pivot field.Value
+	stop  func(value field.Value) bool // past the end of the range
+}
+
+// wherePlan returns the range of the index of a WHERE field min max, or nil
+// when the objects without the field match.
+func wherePlan(where whereT) *indexPlan {
+	if where.match(field.Value{}) {
+		return nil
+	}
+	max, maxx := where.max, where.maxx
+	return &indexPlan{
+		name:  where.field,
+		pivot: field.Num(where.min),
+		stop: func(value field.Value) bool {
+			return !value.IsNumeric() || value.Num() > max ||
+				(maxx && value.Num() == max)
+		},
+	}
+}
+
+// wherestrPlan returns the range of the index of a WHERE field eq str or a
+// WHERE field prefix str, or nil for the other operators. Bools are ordered
+// with the numbers, so there's no plan when the string matches one.
+func wherestrPlan(wherestr wherestrT) *indexPlan {
+	if wherestr.field == "" {
+		return nil
+	}
+	str := wherestr.str
+	var stop func(value field.Value) bool
+	switch wherestr.op {
+	case "eq":
+		if str == "true" || str == "false" {
+			return nil
+		}
+		stop = func(value field.Value) bool {
+			return value.String() != str
+		}
+	case "prefix":
+		if strings.HasPrefix("true", str) || strings.HasPrefix("false", str) {
+			return nil
+		}
+		stop = func(value field.Value) bool {
+			return !strings.HasPrefix(value.String(), str)
+		}
+	default:
+		return nil
+	}
+	return &indexPlan{name: wherestr.field, pivot: field.Str(str), stop: stop}
+}
+
+// count returns the number of objects in the range, up to limit+1.
+func (p *indexPlan) count(col *collection.Collection, limit int) int {
+	var n int
+	col.IndexAscend(p.name, p.pivot, func(value field.Value, _ string) bool {
+		if p.stop(value) {
+			return false
+		}
+		n++
+		return n <= limit
+	})
+	return n
+}
+
+// scan iterates over the objects in the range.
+func (p *indexPlan) scan(col *collection.Collection, cursor collection.Cursor,
+	dl *deadline.Deadline,
+	iter func(id string, o geojson.Object, fields []field.Value) bool,
+) {
+	col.IndexScan(p.name, p.pivot, cursor, dl, func(value field.Value,
+		id string, o geojson.Object, fields []field.Value,
+	) bool {
+		if p.stop(value) {
+			return false
+		}
+		return iter(id, o, fields)
+	})
+}
+
+// indexPlan returns the smallest range of a field index that has every
+// object matching the WHERE clauses of a search, or nil when the objects
+// should be found without an index. An index is only used when its range
+// has at most a quarter of the objects of the collection, since looking up
+// each object of the range costs more than visiting it in a scan.
+func (sw *scanWriter) indexPlan() *indexPlan {
+	if sw.col == nil {
+		return nil
+	}
+	var plans []*indexPlan
+	for _, where := range sw.wheres {
+		if sw.col.HasIndex(where.field) {
+			if p := wherePlan(where); p != nil {
+				plans = append(plans, p)
+			}
+		}
+	}
+	for _, wherestr := range sw.wherestrs {
+		if sw.col.HasIndex(wherestr.field) {
+			if p := wherestrPlan(wherestr); p != nil {
+				plans = append(plans, p)
+			}
+		}
+	}
+	var best *indexPlan
+	limit := sw.col.Count() / 4
+	for _, p := range plans {
+		if n := p.count(sw.col, limit); n <= limit {
+			best, limit = p, n
+		}
+	}
+	return best
+}
+
+// applyIndexes adds and removes the indexes of a collection to match the
+// indexes of its key. The caller must hold the server lock.
+func (s *Server) applyIndexes(key string, col *collection.Collection) {
+	names := s.indexes[key]
+	for _, name := range col.Indexes() {
+		if i := sort.SearchStrings(names, name); i == len(names) ||
+			names[i] != name {
+			col.DeleteIndex(name)
+		}
+	}
+	for _, name := range names {
+		col.AddIndex(name)
+	}
+	if err := col.Reparse(s.parseOpts(key)); err != nil {
+		log.Errorf("%s: %v", key, err)
+	}
+}
+
+// SETINDEX key field
+//
+// Indexes a field of the objects of a key, so that a WHERE on the field can
+// be answered without visiting every object. The index is kept by collection
+// key, so it stays in place when the collection is dropped.
+func (s *Server) cmdSetIndex(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var name string
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if vs, name, ok = tokenval(vs); !ok || name == "" || len(vs) != 0 {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if isReservedFieldName(name) {
+		return NOMessage, d, errInvalidArgument(name)
+	}
+	names := s.indexes[d.key]
+	i := sort.SearchStrings(names, name)
+	if i == len(names) || names[i] != name {
+		names = append(names, "")
+		copy(names[i+1:], names[i:])
+		names[i] = name
+		s.indexes[d.key] = names
+		if col := s.getCol(d.key); col != nil {
+			col.AddIndex(name)
+		}
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+	return intResult(msg, start, d.updated), d, nil
+}
+
+// DELINDEX key field
+func (s *Server) cmdDelIndex(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var name string
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if vs, name, ok = tokenval(vs); !ok || name == "" || len(vs) != 0 {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	names := s.indexes[d.key]
+	i := sort.SearchStrings(names, name)
+	if i < len(names) && names[i] == name {
+		names = append(names[:i:i], names[i+1:]...)
+		if len(names) == 0 {
+			delete(s.indexes, d.key)
+		} else {
+			s.indexes[d.key] = names
+		}
+		if col := s.getCol(d.key); col != nil {
+			col.DeleteIndex(name)
+		}
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+	return intResult(msg, start, d.updated), d, nil
+}
+
+// intResult is the response of a write that returns 1 when it changed
+// something and 0 otherwise.
+func intResult(msg *Message, start time.Time, updated bool) resp.Value {
+	switch msg.OutputType {
+	case JSON:
+		return OKMessage(msg, start)
+	case RESP:
+		if updated {
+			return resp.IntegerValue(1)
+		}
+		return resp.IntegerValue(0)
+	}
+	return NOMessage
+}
+
+// INDEXES key
+func (s *Server) cmdIndexes(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var key string
+	var ok bool
+	if vs, key, ok = tokenval(vs); !ok || key == "" || len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	names := s.indexes[key]
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"indexes":[`...)
+		for i, name := range names {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, name)
+		}
+		buf = append(buf, `],"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		vals := make([]resp.Value, len(names))
+		for i, name := range names {
+			vals[i] = resp.StringValue(name)
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
+// indexCommands returns the commands needed to recreate the field and
+// geometry indexes of the keys, or of every key when keys is nil. The caller
+// must hold the server lock.
+func (s *Server) indexCommands(keys []string) (cmds [][]string) {
+	if keys == nil {
+		for key := range s.indexes {
+			keys = append(keys, key)
+		}
+		for key := range s.geoIndexes {
+			if s.indexes[key] == nil {
+				keys = append(keys, key)
+			}
+		}
+		// sort the keys for consistency
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		for _, name := range s.indexes[key] {
+			cmds = append(cmds, []string{"setindex", key, name})
+		}
+		if opts := s.geoIndexes[key]; opts != nil {
+			cmds = append(cmds, geoIndexCommand(key, opts))
+		}
+	}
+	return cmds
+}
@@ -3,11 +3,13 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/resp"
 	"github.com/tidwall/sjson"
@@ -118,7 +120,10 @@ func isJSONNumber(data string) bool {
 }
 
 func appendJSONSimpleBounds(dst []byte, o geojson.Object) []byte {
-	bbox := o.Rect()
+	return appendJSONRect(dst, o.Rect())
+}
+
+func appendJSONRect(dst []byte, bbox geometry.Rect) []byte {
 	dst = append(dst, `{"sw":{"lat":`...)
 	dst = strconv.AppendFloat(dst, bbox.Min.Y, 'f', -1, 64)
 	dst = append(dst, `,"lon":`...)
@@ -131,6 +136,30 @@ func appendJSONSimpleBounds(dst []byte, o geojson.Object) []byte {
 	return dst
 }
 
+// appendJSONObjectBounds appends the bounding box and the centroid of an
+// object, see OUTPUT BOUNDS, which are cached by the collection of the object
+// for the large objects.
+func appendJSONObjectBounds(dst []byte, col *collection.Collection,
+	o geojson.Object,
+) []byte {
+	if !objIsSpatial(o) {
+		return dst
+	}
+	var bbox geometry.Rect
+	var centroid geometry.Point
+	if col != nil {
+		bbox, centroid = col.ObjectBounds(o)
+	} else {
+		bbox, centroid = o.Rect(), collection.Centroid(o)
+	}
+	dst = appendJSONRect(append(dst, `,"bounds":`...), bbox)
+	dst = append(dst, `,"centroid":{"lat":`...)
+	dst = strconv.AppendFloat(dst, centroid.Y, 'f', -1, 64)
+	dst = append(dst, `,"lon":`...)
+	dst = strconv.AppendFloat(dst, centroid.X, 'f', -1, 64)
+	return append(dst, '}')
+}
+
 func appendJSONSimplePoint(dst []byte, o geojson.Object) []byte {
 	point := o.Center()
 	var z float64
@@ -162,31 +191,34 @@ func jsonTimeFormat(t time.Time) string {
 	return string(b)
 }
 
+// JGET key id [path ...] [RAW]
+//
+// A path is a gjson path, or a JSONPath that starts with a '$'. Each path
+// of a JGET with more than one path has a value, or null when the path does
+// not exist.
 func (s *Server) cmdJget(msg *Message) (resp.Value, error) {
 	start := time.Now()
 
 	if len(msg.Args) < 3 {
 		return NOMessage, errInvalidNumberOfArguments
 	}
-	if len(msg.Args) > 5 {
-		return NOMessage, errInvalidNumberOfArguments
-	}
 	key := msg.Args[1]
 	id := msg.Args[2]
-	var doget bool
-	var path string
+	paths := msg.Args[3:]
 	var raw bool
-	if len(msg.Args) > 3 {
-		doget = true
-		path = msg.Args[3]
-		if len(msg.Args) == 5 {
-			if strings.ToLower(msg.Args[4]) == "raw" {
-				raw = true
-			} else {
-				return NOMessage, errInvalidArgument(msg.Args[4])
-			}
+	if len(paths) > 1 && strings.ToLower(paths[len(paths)-1]) == "raw" {
+		raw = true
+		paths = paths[:len(paths)-1]
+	}
+	gpaths := make([]string, len(paths))
+	for i, path := range paths {
+		gpath, _, err := jsonPath(path)
+		if err != nil {
+			return NOMessage, err
 		}
+		gpaths[i] = gpath
 	}
+	paths = gpaths
 	col := s.getCol(key)
 	if col == nil {
 		if msg.OutputType == RESP {
@@ -201,18 +233,50 @@ func (s *Server) cmdJget(msg *Message) (resp.Value, error) {
 		}
 		return NOMessage, errIDNotFound
 	}
+	value := func(res gjson.Result) string {
+		if raw {
+			return res.Raw
+		}
+		return res.String()
+	}
+	if len(paths) > 1 {
+		results := gjson.GetMany(o.String(), paths...)
+		switch msg.OutputType {
+		case JSON:
+			var buf bytes.Buffer
+			buf.WriteString(`{"ok":true,"values":[`)
+			for i, res := range results {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				if res.Exists() {
+					buf.WriteString(jsonString(value(res)))
+				} else {
+					buf.WriteString("null")
+				}
+			}
+			buf.WriteString(`],"elapsed":"` + time.Since(start).String() + "\"}")
+			return resp.StringValue(buf.String()), nil
+		case RESP:
+			vals := make([]resp.Value, len(results))
+			for i, res := range results {
+				if res.Exists() {
+					vals[i] = resp.StringValue(value(res))
+				} else {
+					vals[i] = resp.NullValue()
+				}
+			}
+			return resp.ArrayValue(vals), nil
+		}
+		return NOMessage, nil
+	}
 	var res gjson.Result
-	if doget {
-		res = gjson.Get(o.String(), path)
+	if len(paths) == 1 {
+		res = gjson.Get(o.String(), paths[0])
 	} else {
 		res = gjson.Parse(o.String())
 	}
-	var val string
-	if raw {
-		val = res.Raw
-	} else {
-		val = res.String()
-	}
+	val := value(res)
 	var buf bytes.Buffer
 	if msg.OutputType == JSON {
 		buf.WriteString(`{"ok":true`)
@@ -234,29 +298,55 @@ func (s *Server) cmdJget(msg *Message) (resp.Value, error) {
 }
 
 func (s *Server) cmdJset(msg *Message) (res resp.Value, d commandDetails, err error) {
-	// JSET key path value [RAW]
+	// JSET key id path value [RAW|STR] [APPEND|INSERT index]
 	start := time.Now()
 
-	var raw, str bool
-	switch len(msg.Args) {
-	default:
+	if len(msg.Args) < 5 {
 		return NOMessage, d, errInvalidNumberOfArguments
-	case 5:
-	case 6:
-		switch strings.ToLower(msg.Args[5]) {
+	}
+	key := msg.Args[1]
+	id := msg.Args[2]
+	path, query, err := jsonPath(msg.Args[3])
+	if err != nil {
+		return NOMessage, d, err
+	}
+	if query || path == "@this" {
+		return NOMessage, d, errInvalidArgument(msg.Args[3])
+	}
+	val := msg.Args[4]
+	var raw, str, insert bool
+	var index int
+	for vs := msg.Args[5:]; len(vs) > 0; vs = vs[1:] {
+		switch strings.ToLower(vs[0]) {
 		default:
-			return NOMessage, d, errInvalidArgument(msg.Args[5])
+			return NOMessage, d, errInvalidArgument(vs[0])
 		case "raw":
 			raw = true
 		case "str":
 			str = true
+		case "append":
+			if insert {
+				return NOMessage, d, errInvalidArgument(vs[0])
+			}
+			insert, index = true, -1
+		case "insert":
+			if insert {
+				return NOMessage, d, errInvalidArgument(vs[0])
+			}
+			if len(vs) < 2 {
+				return NOMessage, d, errInvalidNumberOfArguments
+			}
+			vs = vs[1:]
+			n, err := strconv.ParseUint(vs[0], 10, 32)
+			if err != nil {
+				return NOMessage, d, errInvalidArgument(vs[0])
+			}
+			insert, index = true, int(n)
 		}
 	}
-
-	key := msg.Args[1]
-	id := msg.Args[2]
-	path := msg.Args[3]
-	val := msg.Args[4]
+	if raw && str {
+		return NOMessage, d, errInvalidArgument("STR")
+	}
 	if !str && !raw {
 		switch val {
 		default:
@@ -268,7 +358,10 @@ func (s *Server) cmdJset(msg *Message) (res resp.Value, d commandDetails, err er
 	col := s.getCol(key)
 	var createcol bool
 	if col == nil {
-		col = collection.New()
+		col, err = s.newCol(key)
+		if err != nil {
+			return NOMessage, d, err
+		}
 		createcol = true
 	}
 	var json string
@@ -278,7 +371,17 @@ func (s *Server) cmdJset(msg *Message) (res resp.Value, d commandDetails, err er
 		geoobj = objIsSpatial(o)
 		json = o.String()
 	}
-	if raw {
+	if insert {
+		// add to the array at the path
+		if !raw {
+			val = jsonString(val)
+		}
+		val, err = jsonArrayInsert(gjson.Get(json, path), index, val)
+		if err != nil {
+			return NOMessage, d, err
+		}
+		json, err = sjson.SetRaw(json, path, val)
+	} else if raw {
 		// set as raw block
 		json, err = sjson.SetRaw(json, path, val)
 	} else {
@@ -307,6 +410,7 @@ func (s *Server) cmdJset(msg *Message) (res resp.Value, d commandDetails, err er
 
 	s.clearIDExpires(key, id)
 	col.Set(d.id, d.obj, nil, nil)
+	bumpVersion(col, d.id)
 	switch msg.OutputType {
 	case JSON:
 		var buf bytes.Buffer
@@ -327,7 +431,13 @@ func (s *Server) cmdJdel(msg *Message) (res resp.Value, d commandDetails, err er
 	}
 	key := msg.Args[1]
 	id := msg.Args[2]
-	path := msg.Args[3]
+	path, query, err := jsonPath(msg.Args[3])
+	if err != nil {
+		return NOMessage, d, err
+	}
+	if query || path == "@this" {
+		return NOMessage, d, errInvalidArgument(msg.Args[3])
+	}
 
 	col := s.getCol(key)
 	if col == nil {
@@ -373,6 +483,7 @@ func (s *Server) cmdJdel(msg *Message) (res resp.Value, d commandDetails, err er
 
 	s.clearIDExpires(d.key, d.id)
 	col.Set(d.id, d.obj, nil, nil)
+	bumpVersion(col, d.id)
 	switch msg.OutputType {
 	case JSON:
 		var buf bytes.Buffer
@@ -384,3 +495,36 @@ func (s *Server) cmdJdel(msg *Message) (res resp.Value, d commandDetails, err er
 	}
 	return NOMessage, d, nil
 }
+
+// jsonArrayInsert returns the array with the raw value inserted at the index,
+// or appended when the index is -1. A path that does not exist is an empty
+// array.
+func jsonArrayInsert(arr gjson.Result, index int, raw string) (string, error) {
+	if arr.Exists() && !arr.IsArray() {
+		return "", errors.New("path is not an array")
+	}
+	elems := arr.Array()
+	if index == -1 {
+		index = len(elems)
+	} else if index > len(elems) {
+		return "", errInvalidArgument(strconv.Itoa(index))
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i := 0; i <= len(elems); i++ {
+		if i == index {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(raw)
+		}
+		if i < len(elems) {
+			if i > 0 || index == 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(elems[i].Raw)
+		}
+	}
+	buf.WriteByte(']')
+	return buf.String(), nil
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"encoding/json"
 	"testing"
+
+	"github.com/tidwall/gjson"
 )
 
 func BenchmarkJSONString(t *testing.B) {
@@ -44,3 +46,61 @@ func TestIsJsonNumber(t *testing.T) {
 	test(true, "1E+5")
 	test(true, "1E-10")
 }
+
+func TestJSONPath(t *testing.T) {
+	test := func(path, expected string, query bool) {
+		t.Helper()
+		gpath, q, err := jsonPath(path)
+		if err != nil {
+			t.Fatalf("jsonPath(%q): %v", path, err)
+		}
+		if gpath != expected || q != query {
+			t.Fatalf("jsonPath(%q) = %q, %t, expected %q, %t",
+				path, gpath, q, expected, query)
+		}
+	}
+	test("user.name", "user.name", false)
+	test("$", "@this", false)
+	test("$.user.name", "user.name", false)
+	test("$['user'][\"first.name\"]", `user.first\.name`, false)
+	test("$.stops[0].status", "stops.0.status", false)
+	test("$.stops[*].status", "stops.#.status", true)
+	test("$.stops.*", "stops.#", true)
+	test(`$.stops[?(@.status=="late")]`, `stops.#(status=="late")#`, true)
+	test(`$.stops[?(@.eta.min >= 10)].id`, `stops.#(eta.min>=10)#.id`, true)
+	test(`$.stops[?(@.status != 'late')]`, `stops.#(status!="late")#`, true)
+	test(`$.stops[?(@.late)]`, `stops.#(late)#`, true)
+	for _, path := range []string{
+		"$..name", "$.", "$[", "$[x]", "$name", "$[?(@.a ~ 1)]",
+		"$[?(@.a == late)]", "$[?(a == 1)]",
+	} {
+		if _, _, err := jsonPath(path); err == nil {
+			t.Fatalf("jsonPath(%q): expected an error", path)
+		}
+	}
+}
+
+func TestJSONArrayInsert(t *testing.T) {
+	test := func(json string, index int, expected string) {
+		t.Helper()
+		actual, err := jsonArrayInsert(gjson.Parse(json), index, "9")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expected {
+			t.Fatalf("expected %s, got %s", expected, actual)
+		}
+	}
+	test("", -1, "[9]")
+	test("", 0, "[9]")
+	test("[1,2]", -1, "[1,2,9]")
+	test("[1,2]", 0, "[9,1,2]")
+	test("[1,2]", 1, "[1,9,2]")
+	test("[1,2]", 2, "[1,2,9]")
+	if _, err := jsonArrayInsert(gjson.Parse("[1,2]"), 3, "9"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := jsonArrayInsert(gjson.Parse(`{"a":1}`), 0, "9"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+)
+
+// jsonPath converts a JSONPath, which starts with a '$', to a gjson path.
+// Other paths are gjson paths already, and are returned as they are. The
+// query is true when the path has a wildcard or a filter, and then it can
+// only be used to get values.
+//
+// The supported JSONPath syntax is:
+//
+//	$.name $['name'] $["name"]  a member
+//	$[0]                        an array element
+//	$.* $[*]                    all of the array elements
+//	$[?(@.name)]                the array elements that have a member
+//	$[?(@.name op value)]       the array elements with a member that
+//	                            compares to a value with ==, !=, <, <=, >,
+//	                            or >=. The value is a number, a 'string' or
+//	                            "string", true, false, or null. @ is the
+//	                            element itself.
+func jsonPath(path string) (gpath string, query bool, err error) {
+	if !strings.HasPrefix(path, "$") {
+		return path, false, nil
+	}
+	var parts []string
+	s := path[1:]
+	for len(s) > 0 {
+		var part string
+		switch {
+		case strings.HasPrefix(s, ".."):
+			// recursive descent
+			return "", false, errInvalidArgument(path)
+		case strings.HasPrefix(s, ".*"):
+			part, query, s = "#", true, s[2:]
+		case s[0] == '.':
+			i := strings.IndexAny(s[1:], ".[")
+			if i == -1 {
+				i = len(s) - 1
+			}
+			part, s = jsonPathEscape(s[1:i+1]), s[i+1:]
+			if part == "" {
+				return "", false, errInvalidArgument(path)
+			}
+		case s[0] == '[':
+			i := strings.IndexByte(s, ']')
+			if strings.HasPrefix(s, "[?(") {
+				i = strings.Index(s, ")]") + 1
+			} else if len(s) > 1 && (s[1] == '\'' || s[1] == '"') {
+				i = strings.Index(s[2:], string(s[1])+"]") + 3
+			}
+			if i < 1 {
+				return "", false, errInvalidArgument(path)
+			}
+			var ok bool
+			if part, ok = jsonPathBracket(s[1:i]); !ok {
+				return "", false, errInvalidArgument(path)
+			}
+			if strings.HasPrefix(part, "#") {
+				query = true
+			}
+			s = s[i+1:]
+		default:
+			return "", false, errInvalidArgument(path)
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "@this", false, nil
+	}
+	return strings.Join(parts, "."), query, nil
+}
+
+// jsonPathBracket converts the inside of a JSONPath bracket to a gjson path
+// component.
+func jsonPathBracket(s string) (string, bool) {
+	switch {
+	case s == "*":
+		return "#", true
+	case len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0]:
+		return jsonPathEscape(s[1 : len(s)-1]), true
+	case strings.HasPrefix(s, "?(") && strings.HasSuffix(s, ")"):
+		return jsonPathFilter(strings.TrimSpace(s[2 : len(s)-1]))
+	}
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return strconv.FormatUint(n, 10), true
+	}
+	return "", false
+}
+
+// jsonPathFilter converts a JSONPath filter expression to a gjson query.
+func jsonPathFilter(expr string) (string, bool) {
+	if !strings.HasPrefix(expr, "@") {
+		return "", false
+	}
+	expr = expr[1:]
+	var member string
+	i := strings.IndexAny(expr, "=!<> ")
+	if i == -1 {
+		i = len(expr)
+	}
+	if i > 0 {
+		if expr[0] != '.' {
+			return "", false
+		}
+		var names []string
+		for _, name := range strings.Split(expr[1:i], ".") {
+			if name == "" {
+				return "", false
+			}
+			names = append(names, jsonPathEscape(name))
+		}
+		member = strings.Join(names, ".")
+	}
+	expr = strings.TrimSpace(expr[i:])
+	if expr == "" {
+		if member == "" {
+			return "", false
+		}
+		// the elements that have the member
+		return "#(" + member + ")#", true
+	}
+	var op string
+	for _, o := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if strings.HasPrefix(expr, o) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return "", false
+	}
+	value := strings.TrimSpace(expr[len(op):])
+	switch {
+	case len(value) >= 2 && (value[0] == '\'' || value[0] == '"') &&
+		value[len(value)-1] == value[0]:
+		value = jsonString(value[1 : len(value)-1])
+	case value == "true", value == "false", value == "null",
+		isJSONNumber(value):
+	default:
+		return "", false
+	}
+	return "#(" + member + op + value + ")#", true
+}
+
+// jsonPathEscape escapes the characters of a member name that have a
+// meaning in a gjson path.
+func jsonPathEscape(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '\\', '.', '*', '?', '|', '#', '@', '(', ')', '=', '!', '<',
+			'>', '%':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
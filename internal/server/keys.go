@@ -9,7 +9,12 @@ import (
 	"github.com/tidwall/tile38/internal/glob"
 )
 
-func (s *Server) cmdKeys(msg *Message) (res resp.Value, err error) {
+// KEYS pattern [LABEL name pattern ...]
+//
+// The keys that the user of the client may not access are not listed.
+func (s *Server) cmdKeys(msg *Message, client *Client) (res resp.Value,
+	err error,
+) {
 	var start = time.Now()
 	vs := msg.Args[1:]
 
@@ -18,8 +23,21 @@ func (s *Server) cmdKeys(msg *Message) (res resp.Value, err error) {
 	if vs, pattern, ok = tokenval(vs); !ok || pattern == "" {
 		return NOMessage, errInvalidNumberOfArguments
 	}
-	if len(vs) != 0 {
-		return NOMessage, errInvalidNumberOfArguments
+	var filters []labelFilter
+	for len(vs) > 0 {
+		var tok string
+		var f labelFilter
+		vs, tok, _ = tokenval(vs)
+		if !lc(tok, "label") {
+			return NOMessage, errInvalidArgument(tok)
+		}
+		if vs, f.name, ok = tokenval(vs); !ok || f.name == "" {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		if vs, f.pattern, ok = tokenval(vs); !ok {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		filters = append(filters, f)
 	}
 
 	var wr = &bytes.Buffer{}
@@ -35,6 +53,7 @@ func (s *Server) cmdKeys(msg *Message) (res resp.Value, err error) {
 	var greater bool
 	var greaterPivot string
 	var vals []resp.Value
+	allowed := s.aclKeyFilter(client)
 
 	iterator := func(v interface{}) bool {
 		vcol := v.(*collectionKeyContainer)
@@ -49,6 +68,14 @@ func (s *Server) cmdKeys(msg *Message) (res resp.Value, err error) {
 		} else {
 			match, _ = glob.Match(pattern, vcol.key)
 		}
+		if match && len(filters) > 0 {
+			match = s.matchLabels(vcol.key, filters)
+		}
+		// the keys of a namespace are shown without its prefix
+		key := strings.TrimPrefix(vcol.key, msg.namespace)
+		if match && allowed != nil {
+			match = allowed(key)
+		}
 		if match {
 			if once {
 				if msg.OutputType == JSON {
@@ -59,9 +86,9 @@ func (s *Server) cmdKeys(msg *Message) (res resp.Value, err error) {
 			}
 			switch msg.OutputType {
 			case JSON:
-				wr.WriteString(jsonString(vcol.key))
+				wr.WriteString(jsonString(key))
 			case RESP:
-				vals = append(vals, resp.StringValue(vcol.key))
+				vals = append(vals, resp.StringValue(key))
 			}
 
 			// If no more than one match is expected, stop searching
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/match"
+)
+
+const (
+	keyspaceChannelPrefix = "__keyspace__:"
+	keyeventChannelPrefix = "__keyevent__:"
+)
+
+// notifyKeyspace publishes the change events of a command for the keys that
+// match the keyspaceevents patterns. Each event is published to the
+// "__keyspace__:{key}" channel of its key, and to the
+// "__keyevent__:{command}" channel of its command. The deletes of the expired
+// objects are also published to the "__keyevent__:expired" channel. The
+// events are the same as the ones delivered to the cdc endpoint.
+func (s *Server) notifyKeyspace(args []string, d *commandDetails) {
+	patterns := s.config.keyspaceEvents()
+	if len(patterns) == 0 {
+		return
+	}
+	args, _ = lwwArgs(args)
+	for _, event := range appendChangeEvents(nil, args, d) {
+		key := gjson.Get(event, "key").String()
+		if key != "" && !keyspaceMatch(patterns, key) {
+			continue
+		}
+		if key != "" {
+			s.Publish(keyspaceChannelPrefix+key, event)
+		}
+		s.Publish(keyeventChannelPrefix+gjson.Get(event, "command").String(),
+			event)
+		if gjson.Get(event, "expired").Bool() {
+			s.Publish(keyeventChannelPrefix+"expired", event)
+		}
+	}
+}
+
+// keyspaceMatch returns true when the key matches one of the patterns.
+func keyspaceMatch(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if match.Match(key, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/glob"
+)
+
+// SETLABEL key name value [name value ...]
+//
+// Sets labels of a collection, such as its owner, tenant, or description.
+// The labels are kept by collection key, so they stay in place when the
+// collection is dropped.
+func (s *Server) cmdSetLabel(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if len(vs) == 0 || len(vs)%2 != 0 {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	for i := 0; i < len(vs); i += 2 {
+		if vs[i] == "" {
+			return NOMessage, d, errInvalidArgument(vs[i])
+		}
+	}
+	labels := s.labels[d.key]
+	if labels == nil {
+		labels = make(map[string]string)
+		s.labels[d.key] = labels
+	}
+	for i := 0; i < len(vs); i += 2 {
+		labels[vs[i]] = vs[i+1]
+	}
+	d.updated = true
+	d.timestamp = time.Now()
+	return OKMessage(msg, start), d, nil
+}
+
+// DELLABEL key [name ...]
+//
+// Removes labels of a collection, or all of them when no name is given.
+// Returns the number of removed labels.
+func (s *Server) cmdDelLabel(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	labels := s.labels[d.key]
+	var n int
+	if len(vs) == 0 {
+		n = len(labels)
+	} else {
+		for _, name := range vs {
+			if _, ok := labels[name]; ok {
+				delete(labels, name)
+				n++
+			}
+		}
+	}
+	if len(vs) == 0 || len(labels) == 0 {
+		delete(s.labels, d.key)
+	}
+	d.updated = n > 0
+	d.timestamp = time.Now()
+	switch msg.OutputType {
+	case JSON:
+		return OKMessage(msg, start), d, nil
+	case RESP:
+		return resp.IntegerValue(n), d, nil
+	}
+	return NOMessage, d, nil
+}
+
+// LABELS key
+func (s *Server) cmdLabels(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var key string
+	var ok bool
+	if vs, key, ok = tokenval(vs); !ok || key == "" || len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	labels := s.labels[key]
+	names := sortedLabels(labels)
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"labels":{`...)
+		for i, name := range names {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, name)
+			buf = append(buf, ':')
+			buf = appendJSONString(buf, labels[name])
+		}
+		buf = append(buf, `},"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		vals := make([]resp.Value, 0, len(names)*2)
+		for _, name := range names {
+			vals = append(vals, resp.StringValue(name),
+				resp.StringValue(labels[name]))
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
+func sortedLabels(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// labelFilter is a LABEL name pattern filter of KEYS.
+type labelFilter struct {
+	name, pattern string
+}
+
+// matchLabels returns true when the labels of the key match every filter.
+// The caller must hold the server lock.
+func (s *Server) matchLabels(key string, filters []labelFilter) bool {
+	labels := s.labels[key]
+	for _, f := range filters {
+		value, ok := labels[f.name]
+		if !ok {
+			return false
+		}
+		if match, _ := glob.Match(f.pattern, value); !match {
+			return false
+		}
+	}
+	return true
+}
+
+// labelCommands returns the commands needed to recreate the labels of the
+// keys, or of every key when keys is nil. The caller must hold the server
+// lock.
+func (s *Server) labelCommands(keys []string) (cmds [][]string) {
+	if keys == nil {
+		for key := range s.labels {
+			keys = append(keys, key)
+		}
+		// sort the keys for consistency
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		labels := s.labels[key]
+		if len(labels) == 0 {
+			continue
+		}
+		args := []string{"setlabel", key}
+		for _, name := range sortedLabels(labels) {
+			args = append(args, name, labels[name])
+		}
+		cmds = append(cmds, args)
+	}
+	return cmds
+}
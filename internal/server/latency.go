@@ -0,0 +1,214 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// latencyHistoryLen is the number of samples of an event, which are one per
+// second at most.
+const latencyHistoryLen = 160
+
+// The events of the latency monitor.
+const (
+	latencyCommand      = "command"       // the execution of a command
+	latencyAOFFsync     = "aof-fsync"     // the fsync of the aof
+	latencyHookDelivery = "hook-delivery" // the send of a hook message
+	latencyReplication  = "replication"   // a command from the leader
+	latencyExpireCycle  = "expire-cycle"  // a sweep of the expired items
+)
+
+// latencySample is the largest latency of an event in a second.
+type latencySample struct {
+	time    int64 // unix seconds
+	latency int64 // milliseconds
+}
+
+// latencyEvent is the history of the spikes of an event, oldest first.
+type latencyEvent struct {
+	samples []latencySample
+	max     int64 // the largest latency since the last reset
+}
+
+// latencyMonitor records the latencies of the events that are over the
+// latency-monitor-threshold property, see LATENCY.
+type latencyMonitor struct {
+	threshold func() time.Duration // zero turns off the monitor
+
+	mu     sync.Mutex
+	events map[string]*latencyEvent
+}
+
+// observe records the latency of an event, when it's over the threshold.
+// A nil monitor records nothing.
+func (lm *latencyMonitor) observe(event string, elapsed time.Duration) {
+	if lm == nil || lm.threshold == nil {
+		return
+	}
+	threshold := lm.threshold()
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+	now := time.Now().Unix()
+	ms := elapsed.Milliseconds()
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if lm.events == nil {
+		lm.events = make(map[string]*latencyEvent)
+	}
+	e := lm.events[event]
+	if e == nil {
+		e = &latencyEvent{}
+		lm.events[event] = e
+	}
+	if ms > e.max {
+		e.max = ms
+	}
+	if n := len(e.samples); n > 0 && e.samples[n-1].time == now {
+		if ms > e.samples[n-1].latency {
+			e.samples[n-1].latency = ms
+		}
+		return
+	}
+	if len(e.samples) == latencyHistoryLen {
+		copy(e.samples, e.samples[1:])
+		e.samples = e.samples[:len(e.samples)-1]
+	}
+	e.samples = append(e.samples, latencySample{now, ms})
+}
+
+// LATENCY LATEST
+// LATENCY HISTORY event
+// LATENCY RESET [event ...]
+//
+// LATEST returns the last spike of each event, which is the time, the
+// latency, and the largest latency, in milliseconds. HISTORY returns the
+// spikes of an event, oldest first. RESET removes the spikes of the events,
+// or of every event, and returns the number of events that were removed.
+func (s *Server) cmdLatency(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) < 2 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	lm := &s.latency
+	switch strings.ToLower(msg.Args[1]) {
+	case "latest":
+		if len(msg.Args) != 2 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		lm.mu.Lock()
+		names := make([]string, 0, len(lm.events))
+		for name := range lm.events {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		type latest struct {
+			name string
+			last latencySample
+			max  int64
+		}
+		list := make([]latest, len(names))
+		for i, name := range names {
+			e := lm.events[name]
+			list[i] = latest{name, e.samples[len(e.samples)-1], e.max}
+		}
+		lm.mu.Unlock()
+		switch msg.OutputType {
+		case JSON:
+			var buf []byte
+			buf = append(buf, `{"ok":true,"latest":[`...)
+			for i, l := range list {
+				if i > 0 {
+					buf = append(buf, ',')
+				}
+				buf = appendJSONString(append(buf, `{"event":`...), l.name)
+				buf = strconv.AppendInt(append(buf, `,"time":`...), l.last.time, 10)
+				buf = strconv.AppendInt(append(buf, `,"latency":`...),
+					l.last.latency, 10)
+				buf = strconv.AppendInt(append(buf, `,"max":`...), l.max, 10)
+				buf = append(buf, '}')
+			}
+			buf = append(buf, `],"elapsed":"`+time.Since(start).String()+`"}`...)
+			return resp.BytesValue(buf), nil
+		case RESP:
+			vals := make([]resp.Value, len(list))
+			for i, l := range list {
+				vals[i] = resp.ArrayValue([]resp.Value{
+					resp.StringValue(l.name),
+					resp.IntegerValue(int(l.last.time)),
+					resp.IntegerValue(int(l.last.latency)),
+					resp.IntegerValue(int(l.max)),
+				})
+			}
+			return resp.ArrayValue(vals), nil
+		}
+	case "history":
+		if len(msg.Args) != 3 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		lm.mu.Lock()
+		var samples []latencySample
+		if e := lm.events[strings.ToLower(msg.Args[2])]; e != nil {
+			samples = append(samples, e.samples...)
+		}
+		lm.mu.Unlock()
+		switch msg.OutputType {
+		case JSON:
+			var buf []byte
+			buf = append(buf, `{"ok":true,"history":[`...)
+			for i, sample := range samples {
+				if i > 0 {
+					buf = append(buf, ',')
+				}
+				buf = strconv.AppendInt(append(buf, `{"time":`...), sample.time, 10)
+				buf = strconv.AppendInt(append(buf, `,"latency":`...),
+					sample.latency, 10)
+				buf = append(buf, '}')
+			}
+			buf = append(buf, `],"elapsed":"`+time.Since(start).String()+`"}`...)
+			return resp.BytesValue(buf), nil
+		case RESP:
+			vals := make([]resp.Value, len(samples))
+			for i, sample := range samples {
+				vals[i] = resp.ArrayValue([]resp.Value{
+					resp.IntegerValue(int(sample.time)),
+					resp.IntegerValue(int(sample.latency)),
+				})
+			}
+			return resp.ArrayValue(vals), nil
+		}
+	case "reset":
+		var n int
+		lm.mu.Lock()
+		if len(msg.Args) == 2 {
+			n = len(lm.events)
+			lm.events = nil
+		} else {
+			for _, name := range msg.Args[2:] {
+				name = strings.ToLower(name)
+				if lm.events[name] != nil {
+					delete(lm.events, name)
+					n++
+				}
+			}
+		}
+		lm.mu.Unlock()
+		switch msg.OutputType {
+		case JSON:
+			return resp.StringValue(`{"ok":true,"reset":` + strconv.Itoa(n) +
+				`,"elapsed":"` + time.Since(start).String() + `"}`), nil
+		case RESP:
+			return resp.IntegerValue(n), nil
+		}
+	default:
+		return NOMessage, clientErrorf(
+			"Syntax error, try LATENCY (LATEST | HISTORY | RESET)",
+		)
+	}
+	return NOMessage, nil
+}
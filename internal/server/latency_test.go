@@ -0,0 +1,47 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyMonitor(t *testing.T) {
+	var threshold time.Duration
+	lm := &latencyMonitor{threshold: func() time.Duration { return threshold }}
+
+	// the monitor is off
+	lm.observe(latencyCommand, time.Second)
+	if len(lm.events) != 0 {
+		t.Fatal("expected no events")
+	}
+
+	threshold = time.Millisecond * 10
+	lm.observe(latencyCommand, time.Millisecond*5)
+	if len(lm.events) != 0 {
+		t.Fatal("expected no events")
+	}
+
+	// the spikes of the same second are merged
+	lm.observe(latencyCommand, time.Millisecond*20)
+	lm.observe(latencyCommand, time.Millisecond*30)
+	lm.observe(latencyCommand, time.Millisecond*25)
+	e := lm.events[latencyCommand]
+	if e == nil || len(e.samples) != 1 || e.samples[0].latency != 30 ||
+		e.max != 30 {
+		t.Fatalf("unexpected event %v", e)
+	}
+
+	// the history is capped, oldest first
+	e.samples = e.samples[:0]
+	for i := 0; i < latencyHistoryLen+10; i++ {
+		e.samples = append(e.samples, latencySample{int64(i), 20})
+		if len(e.samples) > latencyHistoryLen {
+			e.samples = e.samples[1:]
+		}
+	}
+	lm.observe(latencyCommand, time.Millisecond*40)
+	if len(e.samples) != latencyHistoryLen || e.samples[0].time != 11 ||
+		e.samples[len(e.samples)-1].latency != 40 || e.max != 40 {
+		t.Fatalf("unexpected history of %d samples", len(e.samples))
+	}
+}
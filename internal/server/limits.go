@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+// limits are the limits of the objects of a collection. They are kept by
+// collection key, like a schema, so they stay in place when the collection
+// is dropped.
+type limits struct {
+	maxPoints    int64    // points of a geometry
+	maxBytes     int64    // bytes of an object and its fields, see objectSize
+	maxObjects   int64    // objects of the collection
+	maxEventRate int64    // fence events of an object per second
+	args         []string // the SETLIMITS arguments following the key
+	rejected     aint     // writes that were over a limit
+}
+
+// parseLimits parses the arguments of a SETLIMITS following the key.
+//
+// [MAXPOINTS n] [MAXBYTES size] [MAXOBJECTS n] [MAXEVENTRATE n]
+func parseLimits(vs []string) (*limits, error) {
+	lim := &limits{}
+	for len(vs) > 0 {
+		var tok, sval string
+		var ok bool
+		vs, tok, _ = tokenval(vs)
+		if vs, sval, ok = tokenval(vs); !ok || sval == "" {
+			return nil, errInvalidNumberOfArguments
+		}
+		var dst *int64
+		var n int64
+		switch {
+		case lc(tok, "maxpoints"):
+			dst = &lim.maxPoints
+			n, _ = strconv.ParseInt(sval, 10, 64)
+		case lc(tok, "maxbytes"):
+			dst = &lim.maxBytes
+			n, _ = parseMemSize(sval)
+		case lc(tok, "maxobjects"):
+			dst = &lim.maxObjects
+			n, _ = strconv.ParseInt(sval, 10, 64)
+		case lc(tok, "maxeventrate"):
+			dst = &lim.maxEventRate
+			n, _ = strconv.ParseInt(sval, 10, 64)
+		default:
+			return nil, errInvalidArgument(tok)
+		}
+		if *dst != 0 {
+			return nil, errDuplicateArgument(strings.ToUpper(tok))
+		}
+		if n <= 0 {
+			return nil, errInvalidArgument(sval)
+		}
+		*dst = n
+		lim.args = append(lim.args, strings.ToLower(tok), sval)
+	}
+	if len(lim.args) == 0 {
+		return nil, errInvalidNumberOfArguments
+	}
+	return lim, nil
+}
+
+// check returns an error when the object with the fields is over a limit.
+// The added objects are the new objects of the same command that come
+// before this one. Use a nil collection for a collection that does not exist
+// yet.
+func (lim *limits) check(col *collection.Collection, key, id string,
+	obj geojson.Object, fields []string, values []field.Value, added int,
+) error {
+	if lim.maxPoints > 0 && objIsSpatial(obj) {
+		if n := obj.NumPoints(); int64(n) > lim.maxPoints {
+			return clientErrorf("limit exceeded, the object '%s' has %d "+
+				"points, the key '%s' allows %d", id, n, key, lim.maxPoints)
+		}
+	}
+	if lim.maxBytes > 0 {
+		if sz := objectSize(obj, fields, values); sz > lim.maxBytes {
+			return clientErrorf("limit exceeded, the object '%s' is %d "+
+				"bytes, the key '%s' allows %d", id, sz, key, lim.maxBytes)
+		}
+	}
+	if lim.maxObjects > 0 {
+		var count int
+		if col != nil {
+			if _, _, ok := col.Get(id); ok {
+				return nil
+			}
+			count = col.Count()
+		}
+		if int64(count+added) >= lim.maxObjects {
+			return clientErrorf("limit exceeded, the key '%s' allows %d "+
+				"objects", key, lim.maxObjects)
+		}
+	}
+	return nil
+}
+
+// checkLimits returns an error when the object with the fields is over one
+// of the limits of its collection, see limits.check. The caller must hold
+// the server lock.
+func (s *Server) checkLimits(key, id string, obj geojson.Object,
+	fields []string, values []field.Value, added int,
+) error {
+	lim := s.limits[key]
+	if lim == nil {
+		return nil
+	}
+	err := lim.check(s.getCol(key), key, id, obj, fields, values, added)
+	if err != nil {
+		lim.rejected.add(1)
+	}
+	return err
+}
+
+// SETLIMITS key [MAXPOINTS n] [MAXBYTES size] [MAXOBJECTS n] [MAXEVENTRATE n]
+//
+// Sets the limits of the objects of a collection. The objects that are
+// already stored are not checked, only the writes that follow. The
+// MAXEVENTRATE takes the place of the maxeventrate config property for the
+// objects of the collection.
+func (s *Server) cmdSetLimits(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	lim, err := parseLimits(vs)
+	if err != nil {
+		return NOMessage, d, err
+	}
+	if old := s.limits[d.key]; old != nil {
+		lim.rejected.set(old.rejected.get())
+	}
+	s.limits[d.key] = lim
+	d.updated = true
+	d.timestamp = time.Now()
+	return OKMessage(msg, start), d, nil
+}
+
+// DELLIMITS key
+func (s *Server) cmdDelLimits(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" || len(vs) != 0 {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if _, ok := s.limits[d.key]; ok {
+		delete(s.limits, d.key)
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+	switch msg.OutputType {
+	case JSON:
+		return OKMessage(msg, start), d, nil
+	case RESP:
+		if d.updated {
+			return resp.IntegerValue(1), d, nil
+		}
+		return resp.IntegerValue(0), d, nil
+	}
+	return NOMessage, d, nil
+}
+
+// LIMITS key
+func (s *Server) cmdLimits(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var key string
+	var ok bool
+	if vs, key, ok = tokenval(vs); !ok || key == "" || len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	lim := s.limits[key]
+	switch msg.OutputType {
+	case JSON:
+		if lim == nil {
+			return NOMessage, errKeyNotFound
+		}
+		buf := &bytes.Buffer{}
+		buf.WriteString(`{"ok":true,"limits":{`)
+		var n int
+		for _, l := range []struct {
+			name  string
+			value int64
+		}{
+			{"maxpoints", lim.maxPoints},
+			{"maxbytes", lim.maxBytes},
+			{"maxobjects", lim.maxObjects},
+			{"maxeventrate", lim.maxEventRate},
+		} {
+			if l.value == 0 {
+				continue
+			}
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`"` + l.name + `":` +
+				strconv.FormatInt(l.value, 10))
+			n++
+		}
+		buf.WriteString(`},"elapsed":"` + time.Since(start).String() + "\"}")
+		return resp.StringValue(buf.String()), nil
+	case RESP:
+		if lim == nil {
+			return resp.NullValue(), nil
+		}
+		vals := make([]resp.Value, len(lim.args))
+		for i, arg := range lim.args {
+			vals[i] = resp.StringValue(arg)
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
+// maxEventRate returns the most fence events per second of an object of a
+// collection, which is the MAXEVENTRATE of its limits, or else the
+// maxeventrate config property. Zero is no limit. The caller must hold the
+// server lock.
+func (s *Server) maxEventRate(key string) uint64 {
+	if lim := s.limits[key]; lim != nil && lim.maxEventRate > 0 {
+		return uint64(lim.maxEventRate)
+	}
+	return s.config.maxEventRate()
+}
+
+// limitsCommands returns the commands needed to recreate the limits of the
+// keys, or of every key when keys is nil. The caller must hold the server
+// lock.
+func (s *Server) limitsCommands(keys []string) (cmds [][]string) {
+	if keys == nil {
+		for key := range s.limits {
+			keys = append(keys, key)
+		}
+		// sort the keys for consistency
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		if lim := s.limits[key]; lim != nil {
+			cmds = append(cmds, append([]string{"setlimits", key},
+				lim.args...))
+		}
+	}
+	return cmds
+}
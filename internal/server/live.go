@@ -50,13 +50,13 @@ func writeLiveMessage(
 	conn net.Conn,
 	message []byte,
 	wrapRESP bool,
-	connType Type, websocket bool,
+	connType Type, ws *webSocketOptions,
 ) error {
 	if len(message) == 0 {
 		return nil
 	}
-	if websocket {
-		return WriteWebSocketMessage(conn, message)
+	if ws != nil {
+		return writeWebSocketMessage(conn, message, *ws)
 	}
 	var err error
 	switch connType {
@@ -84,7 +84,8 @@ func (server *Server) goLive(
 	default:
 		return errors.New("invalid live type switches")
 	case liveAOFSwitches:
-		return server.liveAOF(s.pos, conn, rd, msg)
+		return server.liveAOF(s.pos, s.keys, s.peer, s.member, conn, rd,
+			msg)
 	case liveSubscriptionSwitches:
 		return server.liveSubscription(conn, rd, msg, websocket)
 	case liveMonitorSwitches:
@@ -107,7 +108,8 @@ func (server *Server) goLive(
 	server.mu.RLock()
 	sw, err = server.newScanWriter(
 		&wr, msg, s.key, s.output, s.precision, s.glob, false,
-		s.cursor, s.limit, s.wheres, s.whereins, s.whereevals, s.nofields)
+		s.cursor, s.limit, s.wheres, s.whereins, s.whereevals, s.wherestrs,
+		s.nofields, s.agg)
 	server.mu.RUnlock()
 
 	// everything below if for live SCAN, NEARBY, WITHIN, INTERSECTS
@@ -157,17 +159,32 @@ func (server *Server) goLive(
 	}()
 	outputType := msg.OutputType
 	connType := msg.ConnType
+	var ws *webSocketOptions
 	if websocket {
 		outputType = JSON
+		ws = &msg.ws
+	}
+	resp3 := msg.resp3 && outputType == RESP && connType == RESP
+	// the encoding of the json messages, see OUTPUT
+	enc := msg.encoding
+	if outputType != JSON || websocket {
+		enc = encodingJSON
 	}
+	outmsg := msg // the fence messages are also msg
 	var livemsg []byte
-	switch outputType {
-	case JSON:
+	switch {
+	case websocket:
+		// the frame is the json
+		livemsg = []byte(`{"ok":true,"live":true}`)
+	case enc != encodingJSON:
+		livemsg = redcon.AppendBulk(nil,
+			enc.encode([]byte(`{"ok":true,"live":true}`), msg))
+	case outputType == JSON:
 		livemsg = redcon.AppendBulkString(nil, `{"ok":true,"live":true}`)
-	case RESP:
+	case outputType == RESP:
 		livemsg = redcon.AppendOK(nil)
 	}
-	if err := writeLiveMessage(conn, livemsg, false, connType, websocket); err != nil {
+	if err := writeLiveMessage(conn, livemsg, false, connType, ws); err != nil {
 		return nil // nil return is fine here
 	}
 	for {
@@ -192,11 +209,20 @@ func (server *Server) goLive(
 				msgs = FenceMatch("", sw, fence, nil, details)
 			}()
 			for _, msg := range msgs {
-				if err := writeLiveMessage(conn, []byte(msg), true, connType, websocket); err != nil {
+				data, wrap := enc.encode([]byte(msg), outmsg), true
+				if resp3 {
+					// the fence messages are pushed to RESP3 clients
+					data = appendPush(nil, 2, true)
+					data = redcon.AppendBulkString(data, "fence")
+					data = redcon.AppendBulkString(data, msg)
+					wrap = false
+				}
+				if err := writeLiveMessage(conn, data, wrap, connType, ws); err != nil {
 					return nil // nil return is fine here
 				}
 			}
 			server.statsTotalMsgsSent.add(len(msgs))
+			server.statsFenceEvents.add(len(msgs))
 			lb.cond.L.Lock()
 
 		}
@@ -0,0 +1,169 @@
+package server
+
+import "sync"
+
+// serverMutex is the lock of the server. Lock and Unlock lock the whole
+// server, for the commands that change many keys or the state of the server,
+// and RLock and RUnlock lock it for reading, as a sync.RWMutex.
+//
+// The commands that change or search a single key lock the key instead, with
+// lockKey. The writes are still applied one at a time, also the writes of
+// different keys, as a write holds the lock of the server while it's
+// applied, because the aof, the hooks, and the expires are shared by all of
+// the keys. But a search of a key releases the lock of the server while it
+// reads the objects, holding only the lock of its key, so that a search of
+// one key does not hold back the writes of the other keys. And a write that
+// waits for the searches of its key waits without the lock of the server, so
+// that it does not hold back the writes of the other keys either.
+type serverMutex struct {
+	sync.RWMutex              // the shared state of the server
+	all          sync.RWMutex // held for writing by Lock, for reading by lockKey
+	keysmu       sync.Mutex
+	keys         map[string]*keyMutex
+}
+
+// keyMutex is the lock of a key, which is kept while it's used.
+type keyMutex struct {
+	sync.RWMutex
+	refs int
+}
+
+// Lock locks the whole server, waiting for the commands that lock a key.
+func (m *serverMutex) Lock() {
+	m.all.Lock()
+	m.RWMutex.Lock()
+}
+
+// Unlock unlocks the whole server.
+func (m *serverMutex) Unlock() {
+	m.RWMutex.Unlock()
+	m.all.Unlock()
+}
+
+// keyLock is the lock of a command on a single key.
+type keyLock struct {
+	m      *serverMutex
+	km     *keyMutex
+	key    string
+	write  bool
+	server bool // the lock of the server is held
+	held   bool // the lock of the key is held
+}
+
+// lockKey locks a key for writing or reading, and then the server. The locks
+// are taken in the order of the whole server, the key, and the shared state.
+func (m *serverMutex) lockKey(key string, write bool) *keyLock {
+	m.all.RLock()
+	m.keysmu.Lock()
+	km := m.keys[key]
+	if km == nil {
+		if m.keys == nil {
+			m.keys = make(map[string]*keyMutex)
+		}
+		km = new(keyMutex)
+		m.keys[key] = km
+	}
+	km.refs++
+	m.keysmu.Unlock()
+	l := &keyLock{m: m, km: km, key: key, write: write}
+	if write {
+		km.Lock()
+		m.RWMutex.Lock()
+	} else {
+		km.RLock()
+		m.RWMutex.RLock()
+	}
+	l.server, l.held = true, true
+	return l
+}
+
+// release releases the lock of the server while a search reads the
+// objects of its key, and also the lock of the key when the search reads a
+// snapshot of the collection. It's for reads only.
+func (l *keyLock) release(key bool) {
+	if l.write {
+		panic("release of a write lock")
+	}
+	if l.server {
+		l.m.RWMutex.RUnlock()
+		l.server = false
+	}
+	if key && l.held {
+		l.km.RUnlock()
+		l.held = false
+	}
+}
+
+// unlock releases the locks that are still held.
+func (l *keyLock) unlock() {
+	if l.server {
+		if l.write {
+			l.m.RWMutex.Unlock()
+		} else {
+			l.m.RWMutex.RUnlock()
+		}
+	}
+	if l.held {
+		if l.write {
+			l.km.Unlock()
+		} else {
+			l.km.RUnlock()
+		}
+	}
+	l.m.keysmu.Lock()
+	if l.km.refs--; l.km.refs == 0 {
+		delete(l.m.keys, l.key)
+	}
+	l.m.keysmu.Unlock()
+	l.m.all.RUnlock()
+}
+
+// keyWrites are the writes that change a single collection, the key of
+// which is the first argument.
+var keyWrites = map[string]bool{
+	"set": true, "del": true, "pdel": true, "pset": true, "fset": true,
+	"fincrby": true, "expire": true, "persist": true, "jset": true,
+}
+
+// keyReads are the searches of a single collection that release the lock of
+// the server while they read the objects.
+var keyReads = map[string]bool{
+	"scan": true, "search": true, "nearby": true, "within": true,
+	"intersects": true,
+}
+
+// lockWrite locks the key of a write that changes a single collection, and
+// returns nil when the write needs the lock of the whole server, such as a
+// write with triggers, which may write other keys.
+func (s *Server) lockWrite(msg *Message) *keyLock {
+	if !keyWrites[msg.Command()] || len(msg.Args) < 2 {
+		return nil
+	}
+	l := s.mu.lockKey(msg.Args[1], true)
+	if len(s.triggers) > 0 {
+		l.unlock()
+		return nil
+	}
+	return l
+}
+
+// lockRead locks the key of a search of a single collection, and returns
+// nil for the other reads.
+func (s *Server) lockRead(msg *Message) *keyLock {
+	if !keyReads[msg.Command()] || len(msg.Args) < 2 {
+		return nil
+	}
+	return s.mu.lockKey(msg.Args[1], false)
+}
+
+// releaseRead releases the lock of the server while a search reads the
+// objects of its key, when it's locked by lockRead and reads nothing but the
+// collection. A search with a lua WHERE expression shares the lua states of
+// the server, and keeps the lock.
+func (sw *scanWriter) releaseRead(key bool) bool {
+	if sw.msg.keyLock == nil || len(sw.whereevals) > 0 {
+		return false
+	}
+	sw.msg.keyLock.release(key)
+	return true
+}
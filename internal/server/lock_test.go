@@ -0,0 +1,113 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// locked returns true when fn returns before the timeout.
+func locked(fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+func TestServerMutex(t *testing.T) {
+	var m serverMutex
+
+	// a search of a key that released the lock of the server lets the
+	// writes of other keys go on, but not the writes of its key
+	read := m.lockKey("fleet", false)
+	read.release(false)
+	if !locked(func() { m.lockKey("truck", true).unlock() }) {
+		t.Fatal("the write of another key is blocked")
+	}
+	var write *keyLock
+	waitWrite := make(chan struct{})
+	go func() {
+		write = m.lockKey("fleet", true)
+		close(waitWrite)
+	}()
+	select {
+	case <-waitWrite:
+		t.Fatal("the write of the key is not blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+	read.unlock()
+	<-waitWrite
+	write.unlock()
+
+	// the lock of the server waits for the searches of all keys
+	read = m.lockKey("fleet", false)
+	read.release(false)
+	waitLock := make(chan struct{})
+	go func() {
+		m.Lock()
+		close(waitLock)
+	}()
+	select {
+	case <-waitLock:
+		t.Fatal("the lock of the server is not blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+	read.unlock()
+	<-waitLock
+	m.Unlock()
+
+	// a snapshot read releases the key too
+	read = m.lockKey("fleet", false)
+	read.release(true)
+	if !locked(func() { m.lockKey("fleet", true).unlock() }) {
+		t.Fatal("the write of the key is blocked")
+	}
+	read.unlock()
+
+	if len(m.keys) != 0 {
+		t.Fatalf("expected no key locks, got %d", len(m.keys))
+	}
+}
+
+func TestServerMutexWrites(t *testing.T) {
+	var m serverMutex
+
+	// the writes of different keys are applied one at a time
+	write := m.lockKey("fleet", true)
+	if locked(func() { m.lockKey("truck", true).unlock() }) {
+		t.Fatal("the write of another key is not blocked")
+	}
+	write.unlock()
+
+	// but a write that waits for a search of its key lets the writes of
+	// other keys go on before it
+	read := m.lockKey("fleet", false)
+	read.release(false)
+	waitWrite := make(chan struct{})
+	go func() {
+		write = m.lockKey("fleet", true)
+		close(waitWrite)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if !locked(func() { m.lockKey("truck", true).unlock() }) {
+		t.Fatal("the write of another key is blocked")
+	}
+	select {
+	case <-waitWrite:
+		t.Fatal("the write of the key is not blocked")
+	default:
+	}
+	read.unlock()
+	<-waitWrite
+	write.unlock()
+
+	if len(m.keys) != 0 {
+		t.Fatalf("expected no key locks, got %d", len(m.keys))
+	}
+}
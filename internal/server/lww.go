@@ -0,0 +1,660 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/field"
+	"github.com/tidwall/tile38/internal/glob"
+	"github.com/tidwall/tile38/internal/history"
+)
+
+// lwwTombstoneAge is how long the version of a deleted object is kept. A
+// write that arrives from a peer after its object has been deleted for longer
+// is ignored.
+const lwwTombstoneAge = 24 * time.Hour
+
+// lwwVersion is the version of a write with active-active replication. It's
+// the time that the write was accepted by a primary, and the id of that
+// primary, which breaks the tie between two writes with the same time.
+type lwwVersion struct {
+	ts     int64
+	origin string
+}
+
+func (v lwwVersion) after(o lwwVersion) bool {
+	return v.ts > o.ts || (v.ts == o.ts && v.origin > o.origin)
+}
+
+func lwwLatest(a, b lwwVersion) lwwVersion {
+	if b.after(a) {
+		return b
+	}
+	return a
+}
+
+// lwwObject has the versions of the last writes to an object.
+type lwwObject struct {
+	obj    lwwVersion            // last SET, JSET, JDEL, or DEL
+	del    lwwVersion            // last DEL
+	ttl    lwwVersion            // last EXPIRE, PERSIST, or SET
+	fields map[string]lwwVersion // last write of each field
+}
+
+// lwwState has the versions of the writes to the dataset, which are used to
+// resolve conflicting writes between two primaries that peer with each
+// other. The last writer wins, except for fields, which are merged so that
+// the last write of each field wins.
+type lwwState struct {
+	objects map[string]map[string]*lwwObject // key -> id -> versions
+	floors  map[string]lwwVersion            // last DROP of each collection
+	flush   lwwVersion                       // last FLUSHDB
+	clock   int64                            // last seen version time
+	origins map[string]string                // interned server ids
+}
+
+// stamp returns a new version for a write that is accepted by this server.
+// It's always after the versions that have been seen, even when the clock
+// of a peer is ahead.
+func (l *lwwState) stamp(origin string) lwwVersion {
+	ts := time.Now().UnixNano()
+	if ts <= l.clock {
+		ts = l.clock + 1
+	}
+	l.clock = ts
+	return lwwVersion{ts: ts, origin: l.intern(origin)}
+}
+
+// version returns the version of a write from a peer.
+func (l *lwwState) version(ts int64, origin string) lwwVersion {
+	if ts > l.clock {
+		l.clock = ts
+	}
+	return lwwVersion{ts: ts, origin: l.intern(origin)}
+}
+
+func (l *lwwState) intern(origin string) string {
+	if s, ok := l.origins[origin]; ok {
+		return s
+	}
+	if l.origins == nil {
+		l.origins = make(map[string]string)
+	}
+	l.origins[origin] = origin
+	return origin
+}
+
+func (l *lwwState) get(key, id string) *lwwObject {
+	return l.objects[key][id]
+}
+
+func (l *lwwState) object(key, id string) *lwwObject {
+	ids := l.objects[key]
+	if ids == nil {
+		if l.objects == nil {
+			l.objects = make(map[string]map[string]*lwwObject)
+		}
+		ids = make(map[string]*lwwObject)
+		l.objects[key] = ids
+	}
+	o := ids[id]
+	if o == nil {
+		o = &lwwObject{}
+		ids[id] = o
+	}
+	return o
+}
+
+// floor returns the version that a write to an object must be after.
+func (l *lwwState) floor(key string, o *lwwObject) lwwVersion {
+	v := lwwLatest(l.floors[key], l.flush)
+	if o != nil {
+		v = lwwLatest(v, o.obj)
+	}
+	return v
+}
+
+// fieldFloor returns the version that a write to a field must be after.
+func (l *lwwState) fieldFloor(key string, o *lwwObject, field string) lwwVersion {
+	v := lwwLatest(l.floors[key], l.flush)
+	if o != nil {
+		v = lwwLatest(lwwLatest(v, o.del), o.fields[field])
+	}
+	return v
+}
+
+// ttlFloor returns the version that a write to the expiration of an object
+// must be after.
+func (l *lwwState) ttlFloor(key string, o *lwwObject) lwwVersion {
+	v := lwwLatest(l.floors[key], l.flush)
+	if o != nil {
+		v = lwwLatest(lwwLatest(v, o.del), o.ttl)
+	}
+	return v
+}
+
+func (l *lwwState) setFields(key, id string, fields []string, v lwwVersion) {
+	if len(fields) == 0 {
+		return
+	}
+	o := l.object(key, id)
+	if o.fields == nil {
+		o.fields = make(map[string]lwwVersion)
+	}
+	for _, field := range fields {
+		o.fields[field] = v
+	}
+}
+
+func (l *lwwState) deleted(key, id string, v lwwVersion) {
+	o := l.object(key, id)
+	o.obj, o.del, o.ttl, o.fields = v, v, v, nil
+}
+
+// dropped forgets the versions of a collection that are older than a DROP
+// or FLUSHDB, which is the floor of the collection from then on.
+func (l *lwwState) dropped(key string, v lwwVersion) {
+	for id, o := range l.objects[key] {
+		if !l.newer(o, v) {
+			delete(l.objects[key], id)
+		}
+	}
+	if len(l.objects[key]) == 0 {
+		delete(l.objects, key)
+	}
+}
+
+// newer returns true when an object has a write after the version.
+func (l *lwwState) newer(o *lwwObject, v lwwVersion) bool {
+	if o == nil {
+		return false
+	}
+	if o.obj.after(v) || o.ttl.after(v) {
+		return true
+	}
+	for _, fv := range o.fields {
+		if fv.after(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// purge forgets the versions of objects that were deleted more than
+// lwwTombstoneAge ago. The floor of their collection is moved up instead, so
+// that a late write cannot bring a deleted object back.
+func (l *lwwState) purge() {
+	oldest := l.clock - int64(lwwTombstoneAge)
+	for key, ids := range l.objects {
+		for id, o := range ids {
+			if o.del == o.obj && o.del.ts < oldest && !l.newer(o, o.del) {
+				if o.del.after(l.floors[key]) {
+					if l.floors == nil {
+						l.floors = make(map[string]lwwVersion)
+					}
+					l.floors[key] = o.del
+				}
+				delete(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			delete(l.objects, key)
+		}
+	}
+}
+
+// lwwArgs returns the command that is wrapped by LWW, and the id of the
+// primary that accepted it. The origin is empty for any other command.
+func lwwArgs(args []string) (cmd []string, origin string) {
+	if len(args) > 3 && strings.ToLower(args[0]) == "lww" {
+		return args[3:], args[2]
+	}
+	return args, ""
+}
+
+// lwwLocal returns true when a write was accepted by this server, and not by
+// a peer.
+func (s *Server) lwwLocal(args []string) bool {
+	_, origin := lwwArgs(args)
+	return origin == "" || origin == s.config.serverID()
+}
+
+// lwwStamp wraps a write in an LWW command with a new version, when the
+// server has a peer. Otherwise the message is returned as is.
+func (s *Server) lwwStamp(msg *Message) *Message {
+	if s.config.peerHost() == "" || msg.Command() == "lww" {
+		return msg
+	}
+	v := s.lww.stamp(s.config.serverID())
+	nmsg := *msg
+	nmsg._command = "lww"
+	nmsg.Args = append([]string{"lww", strconv.FormatInt(v.ts, 10), v.origin},
+		msg.Args...)
+	return &nmsg
+}
+
+// LWW timestamp origin command [args...]
+//
+// Applies a write that has a version, which is the timestamp in nanoseconds
+// and the server id of the primary that accepted it. The write is ignored
+// when the object, field, or expiration that it changes has a newer version.
+func (s *Server) cmdLWW(msg *Message) (res resp.Value, d commandDetails, err error) {
+	vs := msg.Args[1:]
+	if len(vs) < 3 {
+		err = errInvalidNumberOfArguments
+		return
+	}
+	ts, perr := strconv.ParseInt(vs[0], 10, 64)
+	if perr != nil || ts < 0 {
+		err = errInvalidArgument(vs[0])
+		return
+	}
+	if vs[1] == "" {
+		err = errInvalidArgument(vs[1])
+		return
+	}
+	v := s.lww.version(ts, vs[1])
+	nmsg := *msg
+	nmsg._command = ""
+	nmsg.Args = vs[2:]
+	switch nmsg.Command() {
+	default:
+		err = errInvalidArgument(vs[2])
+	case "set", "jset", "jdel":
+		res, d, err = s.lwwSet(&nmsg, v)
+	case "pset":
+		res, d, err = s.pset(&nmsg,
+			func(msg *Message) (resp.Value, commandDetails, error) {
+				return s.lwwSet(msg, v)
+			})
+	case "fset":
+		res, d, err = s.lwwFset(&nmsg, v)
+	case "fincrby":
+		res, d, err = s.lwwFincrby(&nmsg, v)
+	case "del":
+		res, d, err = s.lwwDel(&nmsg, v)
+	case "pdel":
+		res, d, err = s.lwwPdel(&nmsg, v)
+	case "expire", "persist":
+		res, d, err = s.lwwTTL(&nmsg, v)
+	case "drop":
+		res, d, err = s.lwwDrop(&nmsg, v)
+	case "flushdb":
+		res, d, err = s.lwwFlushDB(&nmsg, v)
+	case "rename", "renamenx":
+		res, d, err = s.command(&nmsg, nil)
+		if err == nil && d.updated {
+			delete(s.lww.objects, d.newKey)
+			if ids, ok := s.lww.objects[d.key]; ok {
+				s.lww.objects[d.newKey] = ids
+				delete(s.lww.objects, d.key)
+			}
+		}
+	case "copy":
+		res, d, err = s.command(&nmsg, nil)
+		if err == nil {
+			for _, child := range d.children {
+				if child.command == "del" {
+					s.lww.deleted(child.key, child.id, v)
+				} else {
+					o := s.lww.object(child.key, child.id)
+					o.obj, o.ttl = v, v
+				}
+			}
+		}
+	case "setchan", "pdelchan", "delchan", "renamechan",
+		"pausechan", "ppausechan", "resumechan", "presumechan",
+		"sethook", "pdelhook", "delhook", "renamehook",
+		"pausehook", "ppausehook", "resumehook", "presumehook",
+		"setschema", "delschema", "setlimits", "dellimits", "setindex", "delindex", "setgeoindex", "function",
+		"settrigger", "deltrigger", "setlabel", "dellabel":
+		// hooks, channels, schemas, limits, indexes, labels, and libraries
+		// are not versioned
+		res, d, err = s.command(&nmsg, nil)
+	}
+	return
+}
+
+// lwwIgnored is the response to a write that lost to a newer one.
+func lwwIgnored(msg *Message) resp.Value {
+	if msg.OutputType == RESP {
+		return resp.IntegerValue(0)
+	}
+	return OKMessage(msg, time.Now())
+}
+
+// lwwSet applies a SET, JSET, or JDEL. The fields and expiration in a SET
+// are left out when they have a newer version, and the fields that do not
+// have a newer version are still applied when the object has one.
+func (s *Server) lwwSet(msg *Message, v lwwVersion) (res resp.Value, d commandDetails, err error) {
+	if len(msg.Args) < 3 {
+		return s.command(msg, nil)
+	}
+	key, id := msg.Args[1], msg.Args[2]
+	o := s.lww.get(key, id)
+	latest := v.after(s.lww.floor(key, o))
+	if msg.Command() != "set" {
+		if !latest {
+			return lwwIgnored(msg), d, nil
+		}
+		res, d, err = s.command(msg, nil)
+		if err == nil && d.updated {
+			s.lww.object(key, id).obj = v
+		}
+		return
+	}
+	var ttl bool
+	if o != nil {
+		ttl = o.ttl.after(v)
+	}
+	args := append([]string{}, msg.Args[:3]...)
+	var fields, fargs []string
+	vs := msg.Args[3:]
+	for len(vs) > 0 {
+		switch strings.ToLower(vs[0]) {
+		case "field":
+			if len(vs) < 3 {
+				break
+			}
+			if v.after(s.lww.fieldFloor(key, o, vs[1])) {
+				args = append(args, vs[:3]...)
+				fargs = append(fargs, vs[1:3]...)
+				fields = append(fields, vs[1])
+			}
+			vs = vs[3:]
+			continue
+		case "ex":
+			if len(vs) < 2 {
+				break
+			}
+			if !ttl {
+				args = append(args, vs[:2]...)
+			}
+			vs = vs[2:]
+			continue
+		case "timestamp":
+			if len(vs) < 2 {
+				break
+			}
+			args = append(args, vs[:2]...)
+			vs = vs[2:]
+			continue
+		case "nx", "xx":
+			args = append(args, vs[0])
+			vs = vs[1:]
+			continue
+		}
+		break
+	}
+	if !latest {
+		if len(fields) == 0 {
+			return lwwIgnored(msg), d, nil
+		}
+		// the object has a newer version, but these fields do not
+		nmsg := *msg
+		nmsg._command = "fset"
+		nmsg.Args = append([]string{"fset", key, id, "xx"}, fargs...)
+		res, d, err = s.cmdFset(&nmsg)
+		if err == nil && d.command == "fset" {
+			s.lww.setFields(key, id, fields, v)
+		}
+		return
+	}
+	nmsg := *msg
+	nmsg.Args = append(args, vs...)
+	res, d, err = s.cmdSet(&nmsg, !ttl)
+	if err == nil && d.updated {
+		o = s.lww.object(key, id)
+		o.obj = v
+		if !ttl {
+			o.ttl = v
+		}
+		s.lww.setFields(key, id, fields, v)
+	}
+	return
+}
+
+// lwwFset applies the fields of an FSET that do not have a newer version.
+func (s *Server) lwwFset(msg *Message, v lwwVersion) (res resp.Value, d commandDetails, err error) {
+	if len(msg.Args) < 3 {
+		return s.command(msg, nil)
+	}
+	key, id := msg.Args[1], msg.Args[2]
+	o := s.lww.get(key, id)
+	args := append([]string{}, msg.Args[:3]...)
+	var fields []string
+	vs := msg.Args[3:]
+	for len(vs) > 0 {
+		if strings.ToLower(vs[0]) == "xx" {
+			args = append(args, vs[0])
+			vs = vs[1:]
+			continue
+		}
+		if len(vs) < 2 {
+			return s.command(msg, nil)
+		}
+		if strings.ToLower(vs[0]) == "ex" {
+			args = append(args, vs[:2]...)
+			vs = vs[2:]
+			continue
+		}
+		if v.after(s.lww.fieldFloor(key, o, vs[0])) {
+			args = append(args, vs[:2]...)
+			fields = append(fields, vs[0])
+		}
+		vs = vs[2:]
+	}
+	if len(fields) == 0 {
+		return lwwIgnored(msg), d, nil
+	}
+	nmsg := *msg
+	nmsg.Args = args
+	res, d, err = s.cmdFset(&nmsg)
+	if err == nil && d.command == "fset" {
+		s.lww.setFields(key, id, fields, v)
+	}
+	return
+}
+
+// lwwFincrby applies a FINCRBY unless the field was set or its object was
+// deleted after it. The increments do not change the version of the field,
+// so that the increments from both peers are added up.
+func (s *Server) lwwFincrby(msg *Message, v lwwVersion) (res resp.Value, d commandDetails, err error) {
+	if len(msg.Args) != 5 {
+		return s.command(msg, nil)
+	}
+	key, id, name := msg.Args[1], msg.Args[2], msg.Args[3]
+	if !v.after(s.lww.fieldFloor(key, s.lww.get(key, id), name)) {
+		return lwwIgnored(msg), d, nil
+	}
+	return s.cmdFincrby(msg)
+}
+
+// lwwDel applies a DEL, and keeps its version so that an older write to the
+// object from a peer does not bring it back.
+func (s *Server) lwwDel(msg *Message, v lwwVersion) (res resp.Value, d commandDetails, err error) {
+	if len(msg.Args) != 3 {
+		return s.command(msg, nil)
+	}
+	key, id := msg.Args[1], msg.Args[2]
+	if !v.after(s.lww.floor(key, s.lww.get(key, id))) {
+		return lwwIgnored(msg), d, nil
+	}
+	res, d, err = s.cmdDel(msg)
+	if err == nil {
+		s.lww.deleted(key, id, v)
+	}
+	return
+}
+
+// lwwTTL applies an EXPIRE or PERSIST.
+func (s *Server) lwwTTL(msg *Message, v lwwVersion) (res resp.Value, d commandDetails, err error) {
+	if len(msg.Args) < 3 {
+		return s.command(msg, nil)
+	}
+	key, id := msg.Args[1], msg.Args[2]
+	if !v.after(s.lww.ttlFloor(key, s.lww.get(key, id))) {
+		return lwwIgnored(msg), d, nil
+	}
+	res, d, err = s.command(msg, nil)
+	if err == nil {
+		if col := s.getCol(key); col != nil {
+			if _, _, ok := col.Get(id); ok {
+				s.lww.object(key, id).ttl = v
+			}
+		}
+	}
+	return
+}
+
+// lwwDeleteOlder deletes the objects in a collection that match a pattern,
+// and that do not have a write after the version.
+func (s *Server) lwwDeleteOlder(msg *Message, key, pattern string, v lwwVersion,
+	d *commandDetails,
+) {
+	col := s.getCol(key)
+	if col == nil {
+		return
+	}
+	var ids []string
+	col.Scan(false, nil, msg.Deadline,
+		func(id string, obj geojson.Object, fields []field.Value) bool {
+			if match, _ := glob.Match(pattern, id); match {
+				if !s.lww.newer(s.lww.get(key, id), v) {
+					ids = append(ids, id)
+				}
+			}
+			return true
+		},
+	)
+	now := time.Now()
+	for _, id := range ids {
+		dc := &commandDetails{command: "del", key: key, id: id,
+			updated: true, timestamp: now}
+		var ok bool
+		dc.obj, dc.fields, ok = col.Delete(id)
+		if ok {
+			d.children = append(d.children, dc)
+		}
+		s.clearIDExpires(key, id)
+		s.clearIDFieldExpires(key, id)
+		s.historyDelete(key, id)
+	}
+	if col.Count() == 0 {
+		s.deleteCol(key)
+	}
+}
+
+// lwwPdel applies a PDEL to the matching objects that do not have a newer
+// version.
+func (s *Server) lwwPdel(msg *Message, v lwwVersion) (res resp.Value, d commandDetails, err error) {
+	if len(msg.Args) != 3 {
+		return s.command(msg, nil)
+	}
+	start := time.Now()
+	d.command = "pdel"
+	d.key, d.pattern = msg.Args[1], msg.Args[2]
+	d.parent = true
+	d.timestamp = start
+	s.lwwDeleteOlder(msg, d.key, d.pattern, v, &d)
+	for _, dc := range d.children {
+		s.lww.deleted(d.key, dc.id, v)
+	}
+	d.updated = len(d.children) > 0
+	switch msg.OutputType {
+	case JSON:
+		res = resp.StringValue(`{"ok":true,"elapsed":"` + time.Since(start).String() + "\"}")
+	case RESP:
+		res = resp.IntegerValue(len(d.children))
+	}
+	return
+}
+
+// lwwDrop applies a DROP. The objects that were written after the DROP by a
+// peer are kept.
+func (s *Server) lwwDrop(msg *Message, v lwwVersion) (res resp.Value, d commandDetails, err error) {
+	if len(msg.Args) != 2 {
+		return s.command(msg, nil)
+	}
+	key := msg.Args[1]
+	if !v.after(lwwLatest(s.lww.floors[key], s.lww.flush)) {
+		return lwwIgnored(msg), d, nil
+	}
+	if s.lww.floors == nil {
+		s.lww.floors = make(map[string]lwwVersion)
+	}
+	s.lww.floors[key] = v
+	s.lww.dropped(key, v)
+	if len(s.lww.objects[key]) == 0 {
+		return s.cmdDrop(msg)
+	}
+	start := time.Now()
+	d.command = "pdel"
+	d.key, d.pattern = key, "*"
+	d.parent = true
+	d.timestamp = start
+	s.lwwDeleteOlder(msg, key, "*", v, &d)
+	d.updated = len(d.children) > 0
+	switch msg.OutputType {
+	case JSON:
+		res = resp.StringValue(`{"ok":true,"elapsed":"` + time.Since(start).String() + "\"}")
+	case RESP:
+		res = resp.IntegerValue(1)
+	}
+	return
+}
+
+// lwwFlushDB applies a FLUSHDB. The objects that were written after the
+// FLUSHDB by a peer are kept.
+func (s *Server) lwwFlushDB(msg *Message, v lwwVersion) (res resp.Value, d commandDetails, err error) {
+	if len(msg.Args) != 1 {
+		return s.command(msg, nil)
+	}
+	if !v.after(s.lww.flush) {
+		return lwwIgnored(msg), d, nil
+	}
+	s.lww.flush = v
+	s.lww.floors = nil
+	for key := range s.lww.objects {
+		s.lww.dropped(key, v)
+	}
+	if len(s.lww.objects) == 0 {
+		return s.cmdFlushDB(msg)
+	}
+	start := time.Now()
+	var keys []string
+	s.cols.Ascend(nil, func(item interface{}) bool {
+		keys = append(keys, item.(*collectionKeyContainer).key)
+		return true
+	})
+	d.command = "pdel"
+	d.parent = true
+	d.timestamp = start
+	for _, key := range keys {
+		if len(s.lww.objects[key]) == 0 {
+			s.deleteCol(key)
+			s.clearKeyExpires(key)
+			continue
+		}
+		s.lwwDeleteOlder(msg, key, "*", v, &d)
+	}
+	for _, hook := range s.hooks {
+		s.forgetPausedHookLogs(hook)
+	}
+	s.hooks = make(map[string]*Hook)
+	s.schemas = make(map[string]*schema)
+	s.limits = make(map[string]*limits)
+	s.indexes = make(map[string][]string)
+	s.geoIndexes = make(map[string]*geojson.ParseOptions)
+	s.histories = make(map[string]*history.Store)
+	s.labels = make(map[string]map[string]string)
+	s.triggers = make(map[string]*trigger)
+	s.hookIndex = hookIndex{}
+	d.command = "flushdb"
+	d.updated = true
+	res = OKMessage(msg, start)
+	return
+}
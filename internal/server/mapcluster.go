@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/resp"
+)
+
+const (
+	// clusterMaxZoom is the highest zoom level of CLUSTERS.
+	clusterMaxZoom = 24
+	// clusterRadius is the size, in pixels of a 512 pixel tile, of the cells
+	// of the grid that the points are clustered by.
+	clusterRadius = 40.0
+	clusterExtent = 512.0
+)
+
+// pointClusters groups the centers of the objects that match a search by
+// the cells of a grid over the web mercator map at a zoom level, instead of
+// returning the objects. A cluster of one object is returned as the object's
+// id and point.
+type pointClusters struct {
+	zoom  int
+	cells map[[2]int64]*pointCluster
+}
+
+type pointCluster struct {
+	id       string
+	count    uint64
+	lat, lon float64 // the sums of the points
+	min, max [2]float64
+}
+
+func newPointClusters(zoom int) *pointClusters {
+	return &pointClusters{zoom: zoom, cells: make(map[[2]int64]*pointCluster)}
+}
+
+// fresh returns empty clusters with the same zoom level.
+func (pc *pointClusters) fresh() *pointClusters {
+	if pc == nil {
+		return nil
+	}
+	return newPointClusters(pc.zoom)
+}
+
+// mercator returns the position of a point on the web mercator map, from 0
+// to 1 on both axes.
+func mercator(lat, lon float64) [2]float64 {
+	sin := math.Sin(lat * math.Pi / 180)
+	y := 0.5 - 0.25*math.Log((1+sin)/(1-sin))/math.Pi
+	return [2]float64{
+		math.Max(0, math.Min(1, lon/360+0.5)),
+		math.Max(0, math.Min(1, y)),
+	}
+}
+
+// clusterCellSize returns the size of the cells of a zoom level, on the web
+// mercator map.
+func clusterCellSize(zoom int) float64 {
+	return clusterRadius / (clusterExtent * math.Exp2(float64(zoom)))
+}
+
+// add includes the center of an object that matched the search.
+func (pc *pointClusters) add(id string, o geojson.Object) {
+	if !objIsSpatial(o) {
+		return
+	}
+	center := o.Center()
+	p := mercator(center.Y, center.X)
+	size := clusterCellSize(pc.zoom)
+	cell := [2]int64{int64(p[0] / size), int64(p[1] / size)}
+	c := pc.cells[cell]
+	if c == nil {
+		c = &pointCluster{id: id, min: p, max: p}
+		pc.cells[cell] = c
+	}
+	c.count++
+	c.lat += center.Y
+	c.lon += center.X
+	c.min = [2]float64{math.Min(c.min[0], p[0]), math.Min(c.min[1], p[1])}
+	c.max = [2]float64{math.Max(c.max[0], p[0]), math.Max(c.max[1], p[1])}
+}
+
+// expansionZoom returns the zoom level at which the points of a cluster are
+// in more than one cluster, or clusterMaxZoom when they are all at the same
+// place.
+func (c *pointCluster) expansionZoom(zoom int) int {
+	for z := zoom + 1; z < clusterMaxZoom; z++ {
+		size := clusterCellSize(z)
+		if int64(c.min[0]/size) != int64(c.max[0]/size) ||
+			int64(c.min[1]/size) != int64(c.max[1]/size) {
+			return z
+		}
+	}
+	return clusterMaxZoom
+}
+
+// sorted returns the clusters from the top left of the map, row by row.
+func (pc *pointClusters) sorted() []*pointCluster {
+	cells := make([][2]int64, 0, len(pc.cells))
+	for cell := range pc.cells {
+		cells = append(cells, cell)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i][1] != cells[j][1] {
+			return cells[i][1] < cells[j][1]
+		}
+		return cells[i][0] < cells[j][0]
+	})
+	clusters := make([]*pointCluster, len(cells))
+	for i, cell := range cells {
+		clusters[i] = pc.cells[cell]
+	}
+	return clusters
+}
+
+func (pc *pointClusters) writeJSON(wr *bytes.Buffer) {
+	wr.WriteString(`,"clusters":[`)
+	for i, c := range pc.sorted() {
+		if i > 0 {
+			wr.WriteByte(',')
+		}
+		n := float64(c.count)
+		point := `"point":{"lat":` + strconv.FormatFloat(c.lat/n, 'f', -1, 64) +
+			`,"lon":` + strconv.FormatFloat(c.lon/n, 'f', -1, 64) + `}`
+		if c.count == 1 {
+			wr.WriteString(`{"id":` + jsonString(c.id) + `,` + point + `}`)
+			continue
+		}
+		wr.WriteString(`{"count":` + strconv.FormatUint(c.count, 10) +
+			`,` + point + `,"expansion_zoom":` +
+			strconv.Itoa(c.expansionZoom(pc.zoom)) + `}`)
+	}
+	wr.WriteByte(']')
+}
+
+// respValue returns the clusters, each as its count, point, and expansion
+// zoom, or as its id and point when it has one object.
+func (pc *pointClusters) respValue() resp.Value {
+	clusters := pc.sorted()
+	vals := make([]resp.Value, len(clusters))
+	for i, c := range clusters {
+		n := float64(c.count)
+		point := resp.ArrayValue([]resp.Value{
+			resp.FloatValue(c.lat / n),
+			resp.FloatValue(c.lon / n),
+		})
+		if c.count == 1 {
+			vals[i] = resp.ArrayValue([]resp.Value{resp.StringValue(c.id), point})
+			continue
+		}
+		vals[i] = resp.ArrayValue([]resp.Value{
+			resp.IntegerValue(int(c.count)),
+			point,
+			resp.IntegerValue(c.expansionZoom(pc.zoom)),
+		})
+	}
+	return resp.ArrayValue(vals)
+}
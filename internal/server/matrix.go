@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/resp"
+)
+
+// matrixDist is the distance to a destination of a MATRIX.
+type matrixDist struct {
+	index int
+	dist  float64
+}
+
+// cmdMatrix returns the distances from each origin to each destination.
+// With KNN, only the nearest destinations of each origin are returned, in
+// the order of their distance.
+//
+// MATRIX key [MODEL model] [KNN k] ORIGINS item ... DESTINATIONS item ...
+//
+// Each item is the id of an object in the collection, whose center is used,
+// or POINT lat lon.
+func (s *Server) cmdMatrix(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+
+	var ok bool
+	var key string
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	var model distModel
+	var knn int
+	var origins, destinations []geometry.Point
+	var items *[]geometry.Point
+	var hasModel bool
+	for len(vs) > 0 {
+		var tok string
+		vs, tok, _ = tokenval(vs)
+		switch strings.ToLower(tok) {
+		case "model":
+			if hasModel || items != nil {
+				return NOMessage, errInvalidArgument(tok)
+			}
+			var smodel string
+			if vs, smodel, ok = tokenval(vs); !ok || smodel == "" {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			if model, ok = parseDistModel(strings.ToLower(smodel)); !ok {
+				return NOMessage, errInvalidArgument(smodel)
+			}
+			hasModel = true
+			continue
+		case "knn":
+			if knn != 0 || items != nil {
+				return NOMessage, errInvalidArgument(tok)
+			}
+			var sknn string
+			if vs, sknn, ok = tokenval(vs); !ok || sknn == "" {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			n, err := strconv.ParseUint(sknn, 10, 32)
+			if err != nil || n == 0 {
+				return NOMessage, errInvalidArgument(sknn)
+			}
+			knn = int(n)
+			continue
+		case "origins":
+			if items != nil {
+				return NOMessage, errInvalidArgument(tok)
+			}
+			items = &origins
+			continue
+		case "destinations":
+			if items != &origins || len(origins) == 0 {
+				return NOMessage, errInvalidArgument(tok)
+			}
+			items = &destinations
+			continue
+		}
+		if items == nil {
+			return NOMessage, errInvalidArgument(tok)
+		}
+		point, err := s.matrixPoint(key, tok, &vs)
+		if err != nil {
+			return NOMessage, err
+		}
+		*items = append(*items, point)
+	}
+	if len(origins) == 0 || len(destinations) == 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+
+	rows := make([][]matrixDist, len(origins))
+	for i, origin := range origins {
+		row := make([]matrixDist, len(destinations))
+		for j, destination := range destinations {
+			row[j] = matrixDist{index: j, dist: model.distance(origin, destination)}
+		}
+		if knn > 0 {
+			sort.SliceStable(row, func(a, b int) bool {
+				return row[a].dist < row[b].dist
+			})
+			if len(row) > knn {
+				row = row[:knn]
+			}
+		}
+		rows[i] = row
+	}
+
+	switch msg.OutputType {
+	case JSON:
+		var buf bytes.Buffer
+		if knn > 0 {
+			buf.WriteString(`{"ok":true,"nearest":[`)
+		} else {
+			buf.WriteString(`{"ok":true,"distances":[`)
+		}
+		for i, row := range rows {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('[')
+			for j, d := range row {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				dist := strconv.FormatFloat(d.dist, 'f', -1, 64)
+				if knn > 0 {
+					buf.WriteString(`{"destination":` + strconv.Itoa(d.index) +
+						`,"distance":` + dist + `}`)
+				} else {
+					buf.WriteString(dist)
+				}
+			}
+			buf.WriteByte(']')
+		}
+		buf.WriteString(`],"elapsed":"` + time.Since(start).String() + "\"}")
+		return resp.StringValue(buf.String()), nil
+	case RESP:
+		vals := make([]resp.Value, len(rows))
+		for i, row := range rows {
+			rvals := make([]resp.Value, len(row))
+			for j, d := range row {
+				if knn > 0 {
+					rvals[j] = resp.ArrayValue([]resp.Value{
+						resp.IntegerValue(d.index),
+						resp.FloatValue(d.dist),
+					})
+				} else {
+					rvals[j] = resp.FloatValue(d.dist)
+				}
+			}
+			vals[i] = resp.ArrayValue(rvals)
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
+// matrixPoint returns the point of a MATRIX item, which is the center of the
+// object with the id, or a POINT lat lon that is read from vs.
+func (s *Server) matrixPoint(key, tok string, vs *[]string) (geometry.Point, error) {
+	if strings.ToLower(tok) == "point" {
+		var slat, slon string
+		var ok bool
+		if *vs, slat, ok = tokenval(*vs); !ok || slat == "" {
+			return geometry.Point{}, errInvalidNumberOfArguments
+		}
+		if *vs, slon, ok = tokenval(*vs); !ok || slon == "" {
+			return geometry.Point{}, errInvalidNumberOfArguments
+		}
+		lat, err := strconv.ParseFloat(slat, 64)
+		if err != nil {
+			return geometry.Point{}, errInvalidArgument(slat)
+		}
+		lon, err := strconv.ParseFloat(slon, 64)
+		if err != nil {
+			return geometry.Point{}, errInvalidArgument(slon)
+		}
+		return geometry.Point{X: lon, Y: lat}, nil
+	}
+	col := s.getCol(key)
+	if col == nil {
+		return geometry.Point{}, errKeyNotFound
+	}
+	o, _, ok := col.Get(tok)
+	if !ok || s.hasExpired(key, tok) {
+		return geometry.Point{}, errIDNotFound
+	}
+	if !objIsSpatial(o) {
+		return geometry.Point{}, errInvalidArgument(tok)
+	}
+	return o.Center(), nil
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/collection"
+)
+
+// usageStats adds the in-memory cost of a collection or an object, by what
+// it's used for, to the stats map.
+func usageStats(m map[string]interface{}, u collection.Usage) {
+	m["in_memory_geometry_size"] = u.Geometry
+	m["in_memory_fields_size"] = u.Fields
+	m["in_memory_index_size"] = u.Index
+	m["in_memory_ids_size"] = u.IDs
+}
+
+// MEMORY USAGE key [id]
+// MEMORY STATS key [id]
+//
+// USAGE returns the in-memory cost in bytes of a collection, or of one of
+// its objects. STATS returns the same cost, broken down into the geometries,
+// field values, field index entries, and ids. The cost is an estimate that
+// does not include the overhead of the runtime.
+func (s *Server) cmdMemory(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var sub, key, id string
+	var ok bool
+	if vs, sub, ok = tokenval(vs); !ok || sub == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	sub = strings.ToLower(sub)
+	if sub != "usage" && sub != "stats" {
+		return NOMessage, clientErrorf(
+			"Syntax error, try MEMORY (USAGE | STATS)",
+		)
+	}
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if len(vs) > 0 {
+		if vs, id, ok = tokenval(vs); !ok || id == "" || len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+	}
+	col := s.getCol(key)
+	if col == nil {
+		if msg.OutputType == RESP {
+			return resp.NullValue(), nil
+		}
+		return NOMessage, errKeyNotFound
+	}
+	m := make(map[string]interface{})
+	var u collection.Usage
+	if id == "" {
+		u = col.Usage()
+		m["num_objects"] = col.Count()
+	} else {
+		if u, ok = col.ObjectUsage(id); !ok || s.hasExpired(key, id) {
+			if msg.OutputType == RESP {
+				return resp.NullValue(), nil
+			}
+			return NOMessage, errIDNotFound
+		}
+		obj, values, _ := col.Get(id)
+		if objIsSpatial(obj) {
+			m["type"] = gjson.Get(obj.JSON(), "type").String()
+		} else {
+			m["type"] = "String"
+		}
+		m["num_points"] = obj.NumPoints()
+		var n int
+		for _, value := range values {
+			if !value.IsZero() {
+				n++
+			}
+		}
+		m["num_fields"] = n
+	}
+	m["in_memory_size"] = u.Total()
+	usageStats(m, u)
+	switch msg.OutputType {
+	case JSON:
+		if sub == "usage" {
+			return resp.StringValue(`{"ok":true,"usage":` +
+				strconv.Itoa(u.Total()) + `,"elapsed":"` +
+				time.Since(start).String() + "\"}"), nil
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return NOMessage, err
+		}
+		return resp.StringValue(`{"ok":true,"stats":` + string(data) +
+			`,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+	case RESP:
+		if sub == "usage" {
+			return resp.IntegerValue(u.Total()), nil
+		}
+		return resp.ArrayValue(respValuesSimpleMap(m)), nil
+	}
+	return NOMessage, nil
+}
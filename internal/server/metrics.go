@@ -0,0 +1,337 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/core"
+)
+
+// metricsContentType is the content type of the Prometheus text format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of the
+// latency histograms of the commands.
+var latencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1,
+	0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// metricsCommands are the names of the commands that have metrics, which
+// are the commands and their subcommands. The other commands are counted as
+// unknown, so that the metrics do not grow with made up commands.
+var metricsCommands = func() map[string]bool {
+	names := make(map[string]bool)
+	for name := range core.Commands {
+		name = strings.ToLower(name)
+		names[name] = true
+		if i := strings.IndexByte(name, ' '); i != -1 {
+			names[name[:i]] = true
+		}
+	}
+	return names
+}()
+
+// commandMetric is the number of calls, the number of errors, and the
+// latency histogram of a command.
+type commandMetric struct {
+	calls   uint64
+	errors  uint64
+	sum     float64
+	buckets []uint64 // calls by bucket, which are not cumulative
+}
+
+// commandMetrics are the metrics of the commands, by command.
+type commandMetrics struct {
+	mu       sync.Mutex
+	commands map[string]*commandMetric
+}
+
+// observe adds a call of a command.
+func (cm *commandMetrics) observe(command string, elapsed time.Duration,
+	failed bool,
+) {
+	if !metricsCommands[command] {
+		command = "unknown"
+	}
+	secs := elapsed.Seconds()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.commands == nil {
+		cm.commands = make(map[string]*commandMetric)
+	}
+	m := cm.commands[command]
+	if m == nil {
+		m = &commandMetric{buckets: make([]uint64, len(latencyBuckets))}
+		cm.commands[command] = m
+	}
+	m.calls++
+	if failed {
+		m.errors++
+	}
+	m.sum += secs
+	if i := sort.SearchFloat64s(latencyBuckets, secs); i < len(m.buckets) {
+		m.buckets[i]++
+	}
+}
+
+// metricsWriter writes metrics in the Prometheus text format.
+type metricsWriter struct {
+	buf []byte
+}
+
+// family writes the help and type of a metric.
+func (w *metricsWriter) family(name, typ, help string) {
+	if help != "" {
+		w.buf = append(w.buf, "# HELP "+name+" "+help+"\n"...)
+	}
+	w.buf = append(w.buf, "# TYPE "+name+" "+typ+"\n"...)
+}
+
+// sample writes a sample of a metric, with label names and values.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.buf = append(w.buf, name...)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			w.buf = append(w.buf, '{')
+		} else {
+			w.buf = append(w.buf, ',')
+		}
+		w.buf = append(w.buf, labels[i]+`="`...)
+		w.buf = appendLabelValue(w.buf, labels[i+1])
+		w.buf = append(w.buf, '"')
+	}
+	if len(labels) > 0 {
+		w.buf = append(w.buf, '}')
+	}
+	w.buf = append(w.buf, ' ')
+	w.buf = strconv.AppendFloat(w.buf, value, 'g', -1, 64)
+	w.buf = append(w.buf, '\n')
+}
+
+func appendLabelValue(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			dst = append(dst, `\\`...)
+		case '"':
+			dst = append(dst, `\"`...)
+		case '\n':
+			dst = append(dst, `\n`...)
+		default:
+			dst = append(dst, s[i])
+		}
+	}
+	return dst
+}
+
+// METRICS
+//
+// Returns the metrics of the server in the Prometheus text format, which is
+// also served at /metrics by the HTTP transport.
+func (s *Server) cmdMetrics(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	var w metricsWriter
+	s.writeCommandMetrics(&w)
+	s.writeCollectionMetrics(&w)
+	s.writeHookMetrics(&w)
+	s.writeReplicationMetrics(&w)
+	s.writeServerMetrics(&w)
+	if msg.ContentType != "" {
+		// the body of a response of the HTTP transport
+		return resp.BytesValue(w.buf), nil
+	}
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"metrics":`...)
+		buf = appendJSONString(buf, string(w.buf))
+		buf = append(buf, `,"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		return resp.BytesValue(w.buf), nil
+	}
+	return NOMessage, nil
+}
+
+func (s *Server) writeCommandMetrics(w *metricsWriter) {
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+	commands := make([]string, 0, len(s.metrics.commands))
+	for command := range s.metrics.commands {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	w.family("tile38_command_calls_total", "counter",
+		"Number of calls of a command.")
+	for _, command := range commands {
+		m := s.metrics.commands[command]
+		w.sample("tile38_command_calls_total", float64(m.calls),
+			"command", command)
+	}
+	w.family("tile38_command_errors_total", "counter",
+		"Number of calls of a command that returned an error.")
+	for _, command := range commands {
+		m := s.metrics.commands[command]
+		w.sample("tile38_command_errors_total", float64(m.errors),
+			"command", command)
+	}
+	w.family("tile38_command_duration_seconds", "histogram",
+		"Latency of a command.")
+	for _, command := range commands {
+		m := s.metrics.commands[command]
+		var n uint64
+		for i, le := range latencyBuckets {
+			n += m.buckets[i]
+			w.sample("tile38_command_duration_seconds_bucket", float64(n),
+				"command", command, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		w.sample("tile38_command_duration_seconds_bucket", float64(m.calls),
+			"command", command, "le", "+Inf")
+		w.sample("tile38_command_duration_seconds_sum", m.sum,
+			"command", command)
+		w.sample("tile38_command_duration_seconds_count", float64(m.calls),
+			"command", command)
+	}
+}
+
+// writeCollectionMetrics writes the metrics of the collections. The caller
+// must hold the server lock.
+func (s *Server) writeCollectionMetrics(w *metricsWriter) {
+	type colMetric struct {
+		key                      string
+		objects, points, strings int
+		memory                   int
+	}
+	var cols []colMetric
+	s.cols.Ascend(nil, func(v interface{}) bool {
+		cc := v.(*collectionKeyContainer)
+		cols = append(cols, colMetric{
+			key:     cc.key,
+			objects: cc.col.Count(),
+			points:  cc.col.PointCount(),
+			strings: cc.col.StringCount(),
+			memory:  cc.col.TotalWeight(),
+		})
+		return true
+	})
+	w.family("tile38_collection_objects", "gauge",
+		"Number of objects of a collection.")
+	for _, c := range cols {
+		w.sample("tile38_collection_objects", float64(c.objects), "key", c.key)
+	}
+	w.family("tile38_collection_points", "gauge",
+		"Number of points of a collection.")
+	for _, c := range cols {
+		w.sample("tile38_collection_points", float64(c.points), "key", c.key)
+	}
+	w.family("tile38_collection_strings", "gauge",
+		"Number of strings of a collection.")
+	for _, c := range cols {
+		w.sample("tile38_collection_strings", float64(c.strings), "key", c.key)
+	}
+	w.family("tile38_collection_memory_bytes", "gauge",
+		"Memory of the objects of a collection.")
+	for _, c := range cols {
+		w.sample("tile38_collection_memory_bytes", float64(c.memory),
+			"key", c.key)
+	}
+}
+
+// writeHookMetrics writes the delivery metrics of the hooks. The caller
+// must hold the server lock.
+func (s *Server) writeHookMetrics(w *metricsWriter) {
+	names := make([]string, 0, len(s.hooks))
+	for name, hook := range s.hooks {
+		if !hook.channel {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	w.family("tile38_hook_messages_sent_total", "counter",
+		"Number of messages of a hook delivered to an endpoint.")
+	for _, name := range names {
+		w.sample("tile38_hook_messages_sent_total",
+			float64(s.hooks[name].delivery.sent.get()), "hook", name)
+	}
+	w.family("tile38_hook_send_errors_total", "counter",
+		"Number of failed attempts to deliver a message of a hook.")
+	for _, name := range names {
+		w.sample("tile38_hook_send_errors_total",
+			float64(s.hooks[name].delivery.failed.get()), "hook", name)
+	}
+}
+
+// writeReplicationMetrics writes the lag of the followers, on a leader, or
+// of the server itself, on a follower.
+func (s *Server) writeReplicationMetrics(w *metricsWriter) {
+	var lags []replLag
+	var role string
+	if host := s.config.followHost(); host != "" {
+		lag, _ := s.repl.leaderLag()
+		lag.addr = host + ":" + strconv.Itoa(s.config.followPort())
+		lags, role = []replLag{lag}, "leader"
+	} else {
+		lags, role = s.repl.followerLags(int64(s.aofsz)), "follower"
+	}
+	w.family("tile38_replication_offset", "gauge",
+		"Offset of the aof of a follower.")
+	for _, lag := range lags {
+		w.sample("tile38_replication_offset", float64(lag.offset),
+			role, lag.addr)
+	}
+	w.family("tile38_replication_lag_bytes", "gauge",
+		"Bytes of the aof of the leader that a follower has not received.")
+	for _, lag := range lags {
+		w.sample("tile38_replication_lag_bytes", float64(lag.lagBytes),
+			role, lag.addr)
+	}
+	w.family("tile38_replication_seconds_behind", "gauge",
+		"Age of the oldest write that a follower has not received.")
+	for _, lag := range lags {
+		w.sample("tile38_replication_seconds_behind", seconds(lag.behind),
+			role, lag.addr)
+	}
+}
+
+// writeServerMetrics writes the numbers of the extended stats of SERVER.
+func (s *Server) writeServerMetrics(w *metricsWriter) {
+	m := make(map[string]interface{})
+	s.extStats(m)
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var value float64
+		switch v := m[name].(type) {
+		case int:
+			value = float64(v)
+		case int64:
+			value = float64(v)
+		case uint64:
+			value = float64(v)
+		case float64:
+			value = v
+		case bool:
+			value = float64(boolInt(v))
+		default:
+			continue
+		}
+		if !strings.HasPrefix(name, "tile38_") &&
+			!strings.HasPrefix(name, "go_") {
+			// the stats of the runtime
+			name = "go_" + name
+		}
+		w.family(name, "gauge", "")
+		w.sample(name, value)
+	}
+}
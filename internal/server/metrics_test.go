@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommandMetrics(t *testing.T) {
+	var cm commandMetrics
+	cm.observe("set", 300*time.Microsecond, false)
+	cm.observe("set", 2*time.Second, true)
+	cm.observe("set", time.Minute, false)
+	cm.observe("nosuchcmd", time.Millisecond, true)
+	m := cm.commands["set"]
+	if m.calls != 3 || m.errors != 1 {
+		t.Fatalf("expected 3 calls and 1 error, got %d and %d", m.calls, m.errors)
+	}
+	// 0.0005 and 2.5, and a minute is only in +Inf
+	var n uint64
+	for i, le := range latencyBuckets {
+		n += m.buckets[i]
+		switch le {
+		case 0.00025:
+			if n != 0 {
+				t.Fatalf("expected 0, got %d", n)
+			}
+		case 0.0005, 1:
+			if n != 1 {
+				t.Fatalf("expected 1, got %d", n)
+			}
+		case 10:
+			if n != 2 {
+				t.Fatalf("expected 2, got %d", n)
+			}
+		}
+	}
+	if cm.commands["unknown"] == nil || cm.commands["nosuchcmd"] != nil {
+		t.Fatal("expected an unknown command")
+	}
+}
+
+func TestMetricsWriter(t *testing.T) {
+	var w metricsWriter
+	w.family("tile38_collection_objects", "gauge", "Objects.")
+	w.sample("tile38_collection_objects", 2, "key", "a\"b\\c\nd")
+	w.sample("go_goroutines", 1.5)
+	expected := "# HELP tile38_collection_objects Objects.\n" +
+		"# TYPE tile38_collection_objects gauge\n" +
+		`tile38_collection_objects{key="a\"b\\c\nd"} 2` + "\n" +
+		"go_goroutines 1.5\n"
+	if string(w.buf) != expected {
+		t.Fatalf("expected %q, got %q", expected, w.buf)
+	}
+}
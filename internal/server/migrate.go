@@ -0,0 +1,256 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	// migrateCutover is the number of logged writes that are small enough to
+	// be sent while holding the server lock during the cutover.
+	migrateCutover = 100
+	// migrateRounds is the number of catch-up rounds after which the cutover
+	// happens, even when writes keep coming faster than they can be sent.
+	migrateRounds = 10
+)
+
+var errKeyMigrating = errors.New("TRYAGAIN key is migrating")
+
+// migration is a collection that is being moved to another server. The
+// writes to the collection that happen after its snapshot was taken are
+// collected in the log, and are sent to the other server before the cutover.
+type migration struct {
+	log [][]string
+}
+
+// logMigration collects a write for the collections that are migrating. The
+// caller must hold the server lock.
+func (s *Server) logMigration(args []string) {
+	switch strings.ToLower(args[0]) {
+	case "flushdb":
+		for key, m := range s.migrations {
+			m.log = append(m.log, []string{"drop", key})
+		}
+		return
+	case "sethook", "setchan", "setschema", "delschema", "setlimits",
+		"dellimits", "setindex", "delindex", "setgeoindex", "sethistory",
+		"delhistory", "setlabel", "dellabel":
+		// hooks, schemas, limits, indexes, kept positions settings, and
+		// labels are sent during the cutover
+		return
+	}
+	for _, key := range clusterKeys(args) {
+		if m := s.migrations[key]; m != nil {
+			nargs := make([]string, len(args))
+			copy(nargs, args)
+			m.log = append(m.log, nargs)
+			return
+		}
+	}
+}
+
+// migrateCheck returns an error for a rename from or to a collection that is
+// migrating, which cannot be replayed on the other server. The caller must
+// hold the server lock.
+func (s *Server) migrateCheck(msg *Message) error {
+	if len(s.migrations) == 0 {
+		return nil
+	}
+	args := msg.Args
+	if strings.ToLower(args[0]) == "lww" && len(args) > 3 {
+		args = args[3:]
+	}
+	switch strings.ToLower(args[0]) {
+	case "rename", "renamenx", "copy":
+		for _, key := range args[1:] {
+			if s.migrations[key] != nil {
+				return errKeyMigrating
+			}
+		}
+	}
+	return nil
+}
+
+// keyHooks returns the sorted names of the hooks and channels of a
+// collection. The caller must hold the server lock.
+func (s *Server) keyHooks(key string) []string {
+	var hnames []string
+	for name, hook := range s.hooks {
+		if hook.Key == key {
+			hnames = append(hnames, name)
+		}
+	}
+	sort.Strings(hnames)
+	return hnames
+}
+
+// cmdMigrate moves a collection to another server, without blocking writes
+// to the collection while its objects are sent.
+//
+// MIGRATE key host port [AUTH password] [TLS] [REPLACE]
+func (s *Server) cmdMigrate(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	var key, host, sport, auth string
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if vs, host, ok = tokenval(vs); !ok || host == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if vs, sport, ok = tokenval(vs); !ok || sport == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	port, err := strconv.ParseUint(sport, 10, 16)
+	if err != nil {
+		return NOMessage, errInvalidArgument(sport)
+	}
+	var useTLS, replace bool
+	for len(vs) > 0 {
+		switch strings.ToLower(vs[0]) {
+		case "auth":
+			if vs, auth, ok = tokenval(vs[1:]); !ok {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			continue
+		case "tls":
+			useTLS = true
+		case "replace":
+			replace = true
+		default:
+			return NOMessage, errInvalidArgument(vs[0])
+		}
+		vs = vs[1:]
+	}
+
+	// take a snapshot of the collection, and start logging its writes
+	s.mu.Lock()
+	if !s.isLeader() {
+		err = s.notLeaderErr()
+	} else if s.config.readOnly() {
+		err = errors.New("read only")
+	} else if s.migrations[key] != nil {
+		err = errKeyMigrating
+	}
+	col := s.getCol(key)
+	if err == nil && col == nil {
+		err = errKeyNotFound
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return NOMessage, err
+	}
+	if s.migrations == nil {
+		s.migrations = make(map[string]*migration)
+	}
+	s.migrations[key] = &migration{}
+	cols := []snapshotCol{s.snapshotCol(key, col)}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.migrations, key)
+		s.mu.Unlock()
+	}()
+
+	conn, err := s.followDial(fmt.Sprintf("%s:%d", host, port), auth, useTLS)
+	if err != nil {
+		return NOMessage, fmt.Errorf("cannot migrate: %v", err)
+	}
+	defer conn.Close()
+	m, err := doServer(conn)
+	if err != nil {
+		return NOMessage, fmt.Errorf("cannot migrate: %v", err)
+	}
+	switch {
+	case m["id"] == "":
+		return NOMessage, errors.New("cannot migrate: invalid id")
+	case m["id"] == s.config.serverID():
+		return NOMessage, errors.New("cannot migrate to self")
+	case m["following"] != "":
+		return NOMessage, errors.New("cannot migrate to a follower")
+	}
+	v, err := conn.Do("type", key)
+	if err != nil {
+		return NOMessage, fmt.Errorf("cannot migrate: %v", err)
+	}
+	if v.Error() != nil {
+		return NOMessage, fmt.Errorf("cannot migrate: %v", v.Error())
+	}
+	if v.String() != "none" && !replace {
+		return NOMessage, errors.New("target key already exists")
+	}
+
+	pipe := &commandPipe{conn: conn}
+	var locked bool
+	defer func() {
+		if locked {
+			s.mu.Unlock()
+		}
+		if err != nil {
+			// remove the partial copy
+			conn.Do("drop", key)
+		}
+	}()
+	if err = pipe.send([]string{"drop", key}); err != nil {
+		return NOMessage, fmt.Errorf("cannot migrate: %v", err)
+	}
+	n, err := pipe.sendCols(cols)
+	if err != nil {
+		return NOMessage, fmt.Errorf("cannot migrate: %v", err)
+	}
+
+	// catch up with the writes that happened while sending, until there
+	// are few enough of them to be sent while writes are blocked
+	for round := 0; ; round++ {
+		s.mu.Lock()
+		locked = true
+		writes := s.migrations[key].log
+		s.migrations[key].log = nil
+		if len(writes) <= migrateCutover || round == migrateRounds {
+			for _, values := range writes {
+				if err = pipe.send(values); err != nil {
+					return NOMessage, fmt.Errorf("cannot migrate: %v", err)
+				}
+			}
+			break
+		}
+		s.mu.Unlock()
+		locked = false
+		for _, values := range writes {
+			if err = pipe.send(values); err != nil {
+				return NOMessage, fmt.Errorf("cannot migrate: %v", err)
+			}
+		}
+		if err = pipe.flush(); err != nil {
+			return NOMessage, fmt.Errorf("cannot migrate: %v", err)
+		}
+	}
+
+	// cutover, while writes are blocked
+	hnames := s.keyHooks(key)
+	keys := []string{key}
+	cmds := append(s.hookCommandsFor(hnames), s.fieldExpireCommands(keys)...)
+	cmds = append(cmds, s.schemaCommands(keys)...)
+	cmds = append(cmds, s.limitsCommands(keys)...)
+	cmds = append(cmds, s.historyCommands(keys)...)
+	cmds = append(cmds, s.labelCommands(keys)...)
+	cmds = append(cmds, s.indexCommands(keys)...)
+	for _, values := range cmds {
+		if err = pipe.send(values); err != nil {
+			return NOMessage, fmt.Errorf("cannot migrate: %v", err)
+		}
+	}
+	if err = pipe.flush(); err != nil {
+		return NOMessage, fmt.Errorf("cannot migrate: %v", err)
+	}
+	delete(s.migrations, key)
+	s.dropLocal([]string{key}, hnames)
+	return intMessage(msg, start, "migrated", n), nil
+}
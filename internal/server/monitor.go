@@ -74,14 +74,19 @@ func (s *Server) sendMonitor(err error, msg *Message, c *Client, lua bool) {
 	// accept all commands except for these:
 	switch strings.ToLower(msg.Command()) {
 	case "config", "config set", "config get", "config rewrite",
-		"auth", "follow", "slaveof", "replconf",
-		"aof", "aofmd5", "client",
+		"config reload", "auth", "follow", "slaveof", "replconf",
+		"aof", "aofmd5", "client", "acl",
 		"monitor":
 		return
 	}
 
+	args := msg.Args
+	if strings.ToLower(msg.Command()) == "sethook" && len(args) > 2 {
+		args = append([]string{}, args...)
+		args[2] = redactEndpoints(args[2])
+	}
 	var line []byte
-	for i, arg := range msg.Args {
+	for i, arg := range args {
 		if i > 0 {
 			line = append(line, ' ')
 		}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// msgpackOfJSON returns the MessagePack of a message of json. A message that
+// is not json is a MessagePack string.
+func msgpackOfJSON(data []byte) []byte {
+	if !gjson.ValidBytes(data) {
+		return appendMsgpackString(nil, string(data))
+	}
+	return appendMsgpack(nil, gjson.ParseBytes(data))
+}
+
+// appendMsgpack appends the MessagePack of a json value, where the integers
+// are ints and the other numbers are float64s.
+func appendMsgpack(dst []byte, v gjson.Result) []byte {
+	switch v.Type {
+	case gjson.Null:
+		return append(dst, 0xc0)
+	case gjson.False:
+		return append(dst, 0xc2)
+	case gjson.True:
+		return append(dst, 0xc3)
+	case gjson.String:
+		return appendMsgpackString(dst, v.Str)
+	case gjson.Number:
+		if !strings.ContainsAny(v.Raw, ".eE") {
+			if n, err := strconv.ParseInt(v.Raw, 10, 64); err == nil {
+				return appendMsgpackInt(dst, n)
+			}
+		}
+		dst = append(dst, 0xcb)
+		return appendBigEndian(dst, math.Float64bits(v.Num), 8)
+	}
+	var n int
+	v.ForEach(func(_, _ gjson.Result) bool {
+		n++
+		return true
+	})
+	if v.IsObject() {
+		dst = appendMsgpackHead(dst, n, 0x80, 0xde, 0xdf)
+		v.ForEach(func(key, value gjson.Result) bool {
+			dst = appendMsgpackString(dst, key.Str)
+			dst = appendMsgpack(dst, value)
+			return true
+		})
+	} else {
+		dst = appendMsgpackHead(dst, n, 0x90, 0xdc, 0xdd)
+		v.ForEach(func(_, value gjson.Result) bool {
+			dst = appendMsgpack(dst, value)
+			return true
+		})
+	}
+	return dst
+}
+
+// appendMsgpackHead appends the head of a map or an array, which is a fix
+// head of up to 15 items, or a 16 or 32 bit head.
+func appendMsgpackHead(dst []byte, n int, fix, b16, b32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(dst, fix|byte(n))
+	case n <= math.MaxUint16:
+		return appendBigEndian(append(dst, b16), uint64(n), 2)
+	}
+	return appendBigEndian(append(dst, b32), uint64(n), 4)
+}
+
+func appendMsgpackString(dst []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		dst = append(dst, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		dst = append(dst, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		dst = appendBigEndian(append(dst, 0xda), uint64(n), 2)
+	default:
+		dst = appendBigEndian(append(dst, 0xdb), uint64(n), 4)
+	}
+	return append(dst, s...)
+}
+
+func appendMsgpackInt(dst []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= 127:
+		return append(dst, byte(n))
+	case n < 0 && n >= -32:
+		return append(dst, byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		return append(dst, 0xd0, byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		return appendBigEndian(append(dst, 0xd1), uint64(n), 2)
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		return appendBigEndian(append(dst, 0xd2), uint64(n), 4)
+	}
+	return appendBigEndian(append(dst, 0xd3), uint64(n), 8)
+}
+
+// appendBigEndian appends the size low bytes of n in big endian order.
+func appendBigEndian(dst []byte, n uint64, size int) []byte {
+	for i := size - 1; i >= 0; i-- {
+		dst = append(dst, byte(n>>(8*i)))
+	}
+	return dst
+}
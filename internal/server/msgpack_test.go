@@ -0,0 +1,47 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestAppendMsgpack(t *testing.T) {
+	for _, tc := range []struct {
+		json   string
+		expect []byte
+	}{
+		{`null`, []byte{0xc0}},
+		{`true`, []byte{0xc3}},
+		{`5`, []byte{0x05}},
+		{`-5`, []byte{0xfb}},
+		{`-100`, []byte{0xd0, 0x9c}},
+		{`1000`, []byte{0xd1, 0x03, 0xe8}},
+		{`1.5`, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{`"ok"`, []byte{0xa2, 'o', 'k'}},
+		{`[1,"a"]`, []byte{0x92, 0x01, 0xa1, 'a'}},
+		{`{"ok":true}`, []byte{0x81, 0xa2, 'o', 'k', 0xc3}},
+	} {
+		b := appendMsgpack(nil, gjson.Parse(tc.json))
+		if !bytes.Equal(b, tc.expect) {
+			t.Fatalf("%s: expected %x, got %x", tc.json, tc.expect, b)
+		}
+	}
+	long := appendMsgpack(nil, gjson.Parse(`"`+strings.Repeat("x", 300)+`"`))
+	if !bytes.Equal(long[:3], []byte{0xda, 0x01, 0x2c}) || len(long) != 303 {
+		t.Fatalf("unexpected string head: %x", long[:3])
+	}
+}
+
+func TestMsgpackOfJSON(t *testing.T) {
+	if b := msgpackOfJSON([]byte(`{"ok":true}`)); !bytes.Equal(b,
+		[]byte{0x81, 0xa2, 'o', 'k', 0xc3}) {
+		t.Fatalf("unexpected msgpack: %x", b)
+	}
+	if b := msgpackOfJSON([]byte(`+OK`)); !bytes.Equal(b,
+		[]byte{0xa3, '+', 'O', 'K'}) {
+		t.Fatalf("unexpected msgpack: %x", b)
+	}
+}
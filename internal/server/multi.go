@@ -0,0 +1,375 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/log"
+)
+
+// watch is a collection, or one of its objects, that is watched by a client
+// for the EXEC of a transaction.
+type watch struct {
+	key string
+	id  string // empty for every object of the collection
+}
+
+// txCommand returns true for the commands that may be queued in a
+// transaction, and whether the command is a write.
+func txCommand(command string) (queue, write bool) {
+	switch command {
+	case "set", "del", "drop", "fset", "fincrby", "flushdb", "jset", "jdel",
+		"pdel", "pset", "rename", "renamenx", "copy", "expire", "persist",
+		"setchan", "pdelchan", "delchan", "renamechan",
+		"sethook", "pdelhook", "delhook", "renamehook",
+		"setschema", "delschema", "setlimits", "dellimits", "setindex",
+		"delindex", "setgeoindex", "sethistory", "delhistory", "settrigger",
+		"deltrigger",
+		"setlabel", "dellabel":
+		return true, true
+	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks",
+		"chans", "search", "ttl", "bounds", "type", "exists", "jget", "schema", "limits",
+		"indexes", "geoindex", "matrix", "tile", "history", "trajectory", "passed",
+		"memory", "triggers", "labels":
+		return true, false
+	}
+	return false, false
+}
+
+// txMarker returns true for the MULTI and EXEC that surround the commands of
+// a transaction in the aof.
+func txMarker(args []string, command string) bool {
+	return len(args) == 1 && strings.ToLower(args[0]) == command
+}
+
+// queueCommand queues a command of a transaction that is started by MULTI.
+// A command that cannot be queued discards the transaction on EXEC.
+func (s *Server) queueCommand(msg *Message, client *Client) (resp.Value, error) {
+	start := time.Now()
+	if queue, _ := txCommand(msg.Command()); !queue {
+		client.aborted = true
+		return NOMessage, clientErrorf(
+			"%s is not allowed in a transaction", strings.ToUpper(msg.Command()))
+	}
+	client.queued = append(client.queued, msg)
+	switch msg.OutputType {
+	case JSON:
+		return resp.StringValue(`{"ok":true,"queued":true,"elapsed":"` +
+			time.Since(start).String() + "\"}"), nil
+	case RESP:
+		return resp.SimpleStringValue("QUEUED"), nil
+	}
+	return NOMessage, nil
+}
+
+// MULTI
+//
+// Starts a transaction. The commands that follow are queued, and are applied
+// together by EXEC, or dropped by DISCARD.
+func (s *Server) cmdMulti(msg *Message, client *Client) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if msg.ConnType == HTTP {
+		return NOMessage, clientErrorf("MULTI is not allowed over HTTP")
+	}
+	if client.multi {
+		return NOMessage, clientErrorf("MULTI calls can not be nested")
+	}
+	client.multi = true
+	return OKMessage(msg, start), nil
+}
+
+// DISCARD
+//
+// Drops the queued commands of a transaction, and unwatches every key.
+func (s *Server) cmdDiscard(msg *Message, client *Client) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if !client.multi {
+		return NOMessage, clientErrorf("DISCARD without MULTI")
+	}
+	s.endTx(client)
+	return OKMessage(msg, start), nil
+}
+
+// WATCH key [id]
+//
+// Watches a collection, or one of its objects, so that the EXEC of the
+// transaction that follows fails when it's changed before then.
+func (s *Server) cmdWatch(msg *Message, client *Client) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var w watch
+	var ok bool
+	if vs, w.key, ok = tokenval(vs); !ok || w.key == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if len(vs) > 0 {
+		if vs, w.id, ok = tokenval(vs); !ok || w.id == "" || len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+	}
+	if msg.ConnType == HTTP {
+		return NOMessage, clientErrorf("WATCH is not allowed over HTTP")
+	}
+	if client.multi {
+		return NOMessage, clientErrorf("WATCH inside MULTI is not allowed")
+	}
+	client.watched = append(client.watched, w)
+	clients := s.watches[w.key]
+	if clients == nil {
+		clients = make(map[*Client]bool)
+		s.watches[w.key] = clients
+	}
+	clients[client] = true
+	return OKMessage(msg, start), nil
+}
+
+// UNWATCH
+func (s *Server) cmdUnwatch(msg *Message, client *Client) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	s.unwatch(client)
+	return OKMessage(msg, start), nil
+}
+
+// EXEC
+//
+// Applies the queued commands of a transaction, all at once, and returns the
+// result of each. The writes are surrounded by a MULTI and an EXEC in the
+// aof, so that a transaction that was not completely written is not loaded.
+// A command that fails does not stop the commands that follow, and the
+// commands that came before are not undone. The kv persistence engine
+// persists each write of a transaction on its own.
+func (s *Server) cmdExec(msg *Message, client *Client) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if !client.multi {
+		return NOMessage, clientErrorf("EXEC without MULTI")
+	}
+	queued, aborted, dirty := client.queued, client.aborted, client.dirty
+	s.endTx(client)
+	if aborted {
+		return NOMessage, clientErrorf(
+			"EXECABORT Transaction discarded because of previous errors")
+	}
+	if dirty {
+		if msg.OutputType == RESP {
+			return resp.NullValue(), nil
+		}
+		return NOMessage, clientErrorf("watched key was changed")
+	}
+	var writes bool
+	var keys []string
+	for _, qmsg := range queued {
+		if _, write := txCommand(qmsg.Command()); write {
+			writes = true
+		}
+		keys = append(keys, clusterKeys(qmsg.Args)...)
+	}
+	if writes {
+		if !s.isLeader() {
+			return NOMessage, s.notLeaderErr()
+		}
+		if s.config.readOnly() {
+			return NOMessage, clientErrorf("read only")
+		}
+	}
+	if s.clusterEnabled() && !client.clusterImport {
+		for _, key := range keys {
+			if keySlot(key) != keySlot(keys[0]) {
+				return NOMessage, errCrossSlot
+			}
+		}
+	}
+	var wrote bool
+	results := make([]resp.Value, len(queued))
+	for i, qmsg := range queued {
+		_, write := txCommand(qmsg.Command())
+		if write {
+			if err := s.checkQuotas(qmsg, client); err != nil {
+				results[i] = txError(qmsg, err)
+				continue
+			}
+		}
+		triggered := triggerWrite(qmsg.Command())
+		if triggered {
+			// the triggers may change or reject the write
+			var err error
+			if qmsg, err = s.beforeTriggers(qmsg); err != nil {
+				results[i] = txError(qmsg, err)
+				continue
+			}
+		}
+		if write {
+			// writes are versioned for the peer
+			qmsg = s.lwwStamp(s.timestampStamp(s.jitterStamp(qmsg)))
+		}
+		res, err := s.execCommand(qmsg, client, write, triggered, &wrote)
+		if err != nil {
+			res = txError(qmsg, err)
+		}
+		results[i] = res
+	}
+	if wrote {
+		if err := s.writeAOF([]string{"exec"}, nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"results":[`...)
+		for i, res := range results {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, res.String()...)
+		}
+		buf = append(buf, `],"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		return resp.ArrayValue(results), nil
+	}
+	return NOMessage, nil
+}
+
+// execCommand applies one command of a transaction. The MULTI that starts the
+// transaction is written to the aof before its first write.
+func (s *Server) execCommand(msg *Message, client *Client, write, triggered bool,
+	wrote *bool,
+) (resp.Value, error) {
+	if err := s.clusterCheck(msg, client); err != nil {
+		return NOMessage, err
+	}
+	if write {
+		if err := s.migrateCheck(msg); err != nil {
+			return NOMessage, err
+		}
+	}
+	res, d, err := s.command(msg, client)
+	if err != nil {
+		if err.Error() == goingLive {
+			return NOMessage, clientErrorf(
+				"FENCE is not allowed in a transaction")
+		}
+		return NOMessage, err
+	}
+	if res.Type() == resp.Error {
+		return NOMessage, res.Error()
+	}
+	if write && d.updated {
+		if !*wrote {
+			if err := s.writeAOF([]string{"multi"}, nil); err != nil {
+				log.Fatal(err)
+			}
+			*wrote = true
+		}
+		if err := s.writeAOF(msg.Args, &d); err != nil {
+			if _, ok := err.(errAOFHook); ok {
+				return NOMessage, err
+			}
+			log.Fatal(err)
+		}
+		if triggered {
+			s.afterTriggers(&d)
+		}
+	}
+	return res, nil
+}
+
+// txError returns the error of a command of a transaction as its result,
+// which is the error of the command when it's not in a transaction.
+func txError(msg *Message, err error) resp.Value {
+	if msg.OutputType == JSON {
+		return resp.StringValue(`{"ok":false,"err":` + jsonString(err.Error()) +
+			`}`)
+	}
+	return respError(msg.Command(), err.Error())
+}
+
+// endTx ends the transaction of a client, and unwatches every key.
+func (s *Server) endTx(client *Client) {
+	client.multi = false
+	client.queued = nil
+	client.aborted = false
+	s.unwatch(client)
+}
+
+// unwatch removes the watched keys of a client. The caller must hold the
+// server lock.
+func (s *Server) unwatch(client *Client) {
+	for _, w := range client.watched {
+		if clients := s.watches[w.key]; clients != nil {
+			delete(clients, client)
+			if len(clients) == 0 {
+				delete(s.watches, w.key)
+			}
+		}
+	}
+	client.watched = nil
+	client.dirty = false
+}
+
+// touchWatches marks the clients that watch a collection or an object that
+// was written, so that their transactions fail. The caller must hold the
+// server lock.
+func (s *Server) touchWatches(d *commandDetails) {
+	if len(s.watches) == 0 {
+		return
+	}
+	if d.command == "flushdb" {
+		for _, clients := range s.watches {
+			for client := range clients {
+				client.dirty = true
+			}
+		}
+		return
+	}
+	var ids []string
+	if d.parent {
+		for _, child := range d.children {
+			ids = append(ids, child.id)
+		}
+	} else if d.id != "" {
+		ids = append(ids, d.id)
+	}
+	for _, key := range []string{d.key, d.newKey} {
+		if key == "" {
+			continue
+		}
+		for client := range s.watches[key] {
+			if !client.dirty && watching(client.watched, key, ids) {
+				client.dirty = true
+			}
+		}
+	}
+}
+
+// watching returns true when the watched keys include an object of a
+// collection, or any object when ids is empty.
+func watching(watched []watch, key string, ids []string) bool {
+	for _, w := range watched {
+		if w.key != key {
+			continue
+		}
+		if w.id == "" || len(ids) == 0 {
+			return true
+		}
+		for _, id := range ids {
+			if w.id == id {
+				return true
+			}
+		}
+	}
+	return false
+}
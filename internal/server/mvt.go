@@ -0,0 +1,580 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/clip"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+const (
+	defaultTileExtent = 4096
+	// defaultTileBuffer is the buffer of a tile with the default extent, and
+	// is scaled for other extents.
+	defaultTileBuffer = 64
+	// tileSimplify is the tolerance, in the units of the tile extent, of
+	// the simplification of the lines and polygons of a tile. The units are
+	// finer at each zoom level, so the objects are simplified less.
+	tileSimplify = 1.0
+)
+
+// mvt geometry types
+const (
+	mvtPoint   = 1
+	mvtLine    = 2
+	mvtPolygon = 3
+)
+
+// TILE key z x y [FIELDS fields] [EXTENT extent] [BUFFER buffer]
+// [FORMAT MVT|GEOJSON|PNG]
+//
+// Returns the objects of a collection that intersect a map tile as a Mapbox
+// Vector Tile, with one layer that has the key as its name. Each object is a
+// feature with its id as the "id" property, and the fields that are listed
+// in FIELDS, separated by commas, or all of them with '*'. The objects are
+// clipped to the tile with a buffer in the units of the extent. The tile may
+// instead be a GeoJSON FeatureCollection, or a PNG picture whose size in
+// pixels is the extent, which are for looking at a collection on a map.
+func (s *Server) cmdTile(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+
+	var ok bool
+	var key, sz, sx, sy string
+	if vs, key, ok = tokenval(vs); !ok || key == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if vs, sz, ok = tokenval(vs); !ok || sz == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if vs, sx, ok = tokenval(vs); !ok || sx == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if vs, sy, ok = tokenval(vs); !ok || sy == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	z, err := strconv.ParseUint(sz, 10, 8)
+	if err != nil || z > 30 {
+		return NOMessage, errInvalidArgument(sz)
+	}
+	x, err := strconv.ParseUint(sx, 10, 32)
+	if err != nil || x >= 1<<z {
+		return NOMessage, errInvalidArgument(sx)
+	}
+	y, err := strconv.ParseUint(sy, 10, 32)
+	if err != nil || y >= 1<<z {
+		return NOMessage, errInvalidArgument(sy)
+	}
+	var extent, buffer uint64
+	var hasExtent, hasBuffer bool
+	var format tileFormat
+	var fields []string
+	var allFields bool
+	for len(vs) > 0 {
+		var tok, val string
+		vs, tok, _ = tokenval(vs)
+		if vs, val, ok = tokenval(vs); !ok || val == "" {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		switch strings.ToLower(tok) {
+		default:
+			return NOMessage, errInvalidArgument(tok)
+		case "fields":
+			if fields != nil || allFields {
+				return NOMessage, errDuplicateArgument(strings.ToUpper(tok))
+			}
+			if val == "*" {
+				allFields = true
+				continue
+			}
+			for _, name := range strings.Split(val, ",") {
+				if name = strings.TrimSpace(name); name == "" {
+					return NOMessage, errInvalidArgument(val)
+				}
+				fields = append(fields, name)
+			}
+		case "extent":
+			extent, err = strconv.ParseUint(val, 10, 32)
+			if err != nil || extent == 0 {
+				return NOMessage, errInvalidArgument(val)
+			}
+			hasExtent = true
+		case "buffer":
+			buffer, err = strconv.ParseUint(val, 10, 32)
+			if err != nil {
+				return NOMessage, errInvalidArgument(val)
+			}
+			hasBuffer = true
+		case "format":
+			if format, ok = parseTileFormat(strings.ToLower(val)); !ok {
+				return NOMessage, errInvalidArgument(val)
+			}
+		}
+	}
+	if !hasExtent {
+		extent = defaultTileExtent
+		if format == tilePNG {
+			extent = defaultPNGTileSize
+		}
+	}
+	if format == tilePNG && extent > maxPNGTileSize {
+		return NOMessage, errInvalidArgument(strconv.FormatUint(extent, 10))
+	}
+	if !hasBuffer {
+		buffer = extent * defaultTileBuffer / defaultTileExtent
+	}
+
+	t := &mvtTile{z: z, x: float64(x), y: float64(y), extent: float64(extent)}
+	var objs []tileObject
+	if col := s.getCol(key); col != nil {
+		if allFields {
+			fields = col.FieldArr()
+		}
+		fmap := col.FieldMap()
+		b := float64(buffer) / float64(extent)
+		rect := geojson.NewRect(geometry.Rect{
+			Min: t.lonLat(-b, 1+b),
+			Max: t.lonLat(1+b, -b),
+		})
+		col.Intersects(rect, 0, nil, msg.Deadline,
+			func(id string, o geojson.Object, fvals []field.Value) bool {
+				if !objIsSpatial(o) || s.hasExpired(key, id) {
+					return true
+				}
+				obj := tileObject{id: id, obj: clip.Clip(o, rect, &s.geomIndexOpts)}
+				for _, name := range fields {
+					if idx, ok := fmap[name]; ok && idx < len(fvals) &&
+						!fvals[idx].IsZero() {
+						obj.props = append(obj.props, tileProp{name, fvals[idx]})
+					}
+				}
+				objs = append(objs, obj)
+				return true
+			},
+		)
+	}
+
+	var tile []byte
+	switch format {
+	case tileMVT:
+		layer := newMVTLayer(key, uint32(extent))
+		for _, obj := range objs {
+			var tags []uint32
+			tags = layer.appendTag(tags, "id", field.Str(obj.id))
+			for _, prop := range obj.props {
+				tags = layer.appendTag(tags, prop.name, prop.value)
+			}
+			t.addFeatures(layer, obj.obj, tags)
+		}
+		tile = appendMVTBytes(nil, 3, layer.bytes())
+	case tileGeoJSON:
+		tile = geoJSONTile(objs)
+	case tilePNG:
+		tile = t.png(objs)
+	}
+
+	if msg.ContentType != "" {
+		// the tile is the body of a response of the debug tile server
+		return resp.BytesValue(tile), nil
+	}
+	switch msg.OutputType {
+	case JSON:
+		var buf bytes.Buffer
+		if format == tileGeoJSON {
+			buf.WriteString(`{"ok":true,"tile":`)
+			buf.Write(tile)
+		} else {
+			buf.WriteString(`{"ok":true,"tile":"`)
+			buf.WriteString(base64.StdEncoding.EncodeToString(tile))
+			buf.WriteByte('"')
+		}
+		buf.WriteString(`,"elapsed":"` + time.Since(start).String() + "\"}")
+		return resp.StringValue(buf.String()), nil
+	case RESP:
+		return resp.BytesValue(tile), nil
+	}
+	return NOMessage, nil
+}
+
+// mvtTile projects the points of the objects onto a web mercator map tile.
+type mvtTile struct {
+	z      uint64
+	x, y   float64
+	extent float64
+}
+
+// project returns the position of a point in the units of the tile extent.
+func (t *mvtTile) project(p geometry.Point) (float64, float64) {
+	m := mercator(p.Y, p.X)
+	n := math.Exp2(float64(t.z))
+	return (m[0]*n - t.x) * t.extent, (m[1]*n - t.y) * t.extent
+}
+
+// lonLat returns the point at a position of the tile, from 0 to 1 on both
+// axes.
+func (t *mvtTile) lonLat(tx, ty float64) geometry.Point {
+	n := math.Exp2(float64(t.z))
+	lon := (t.x+tx)/n*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*(t.y+ty)/n))) * 180 / math.Pi
+	return geometry.Point{X: lon, Y: lat}
+}
+
+// addFeatures adds the features of an object to the layer. A collection is
+// a feature for each of its objects.
+func (t *mvtTile) addFeatures(layer *mvtLayer, o geojson.Object, tags []uint32) {
+	var typ uint32
+	var geom []uint32
+	switch o := o.(type) {
+	case *geojson.Feature:
+		t.addFeatures(layer, o.Base(), tags)
+		return
+	case *geojson.MultiPoint:
+		typ = mvtPoint
+		var points [][2]int64
+		for _, child := range o.Children() {
+			points = append(points, t.quantize([]geometry.Point{child.Center()})...)
+		}
+		geom = mvtPoints(points)
+	case *geojson.MultiLineString:
+		typ = mvtLine
+		for _, child := range o.Children() {
+			if line, ok := child.(*geojson.LineString); ok {
+				geom = t.appendLine(geom, line.Base())
+			}
+		}
+	case *geojson.MultiPolygon:
+		typ = mvtPolygon
+		for _, child := range o.Children() {
+			if poly, ok := child.(*geojson.Polygon); ok {
+				geom = t.appendPoly(geom, poly.Base())
+			}
+		}
+	case geojson.Collection:
+		for _, child := range o.Children() {
+			t.addFeatures(layer, child, tags)
+		}
+		return
+	case *geojson.LineString:
+		typ = mvtLine
+		geom = t.appendLine(nil, o.Base())
+	case *geojson.Polygon:
+		typ = mvtPolygon
+		geom = t.appendPoly(nil, o.Base())
+	case *geojson.Rect:
+		typ = mvtPolygon
+		r := o.Base()
+		geom = t.appendRing(nil, []geometry.Point{
+			r.Min, {X: r.Max.X, Y: r.Min.Y}, r.Max, {X: r.Min.X, Y: r.Max.Y},
+			r.Min,
+		}, true)
+	default:
+		typ = mvtPoint
+		geom = mvtPoints(t.quantize([]geometry.Point{o.Center()}))
+	}
+	if len(geom) > 0 {
+		layer.addFeature(typ, tags, geom)
+	}
+}
+
+// quantize returns the positions of the points, rounded to the units of the
+// extent, without the consecutive positions that are the same.
+func (t *mvtTile) quantize(points []geometry.Point) [][2]int64 {
+	var out [][2]int64
+	for _, p := range points {
+		x, y := t.project(p)
+		q := [2]int64{int64(math.Round(x)), int64(math.Round(y))}
+		if len(out) == 0 || out[len(out)-1] != q {
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+func (t *mvtTile) simplify(points []geometry.Point) []geometry.Point {
+	if len(points) < 3 {
+		return points
+	}
+	xy := make([][2]float64, len(points))
+	for i, p := range points {
+		xy[i][0], xy[i][1] = t.project(p)
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	simplifyDP(xy, keep, 0, len(points)-1, tileSimplify*tileSimplify)
+	var out []geometry.Point
+	for i, p := range points {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// simplifyDP marks the points between first and last that are kept by the
+// Douglas-Peucker algorithm.
+func simplifyDP(xy [][2]float64, keep []bool, first, last int, sqTolerance float64) {
+	maxDist, index := sqTolerance, -1
+	a, b := xy[first], xy[last]
+	for i := first + 1; i < last; i++ {
+		if d := sqSegDist(xy[i], a, b); d > maxDist {
+			maxDist, index = d, i
+		}
+	}
+	if index != -1 {
+		keep[index] = true
+		simplifyDP(xy, keep, first, index, sqTolerance)
+		simplifyDP(xy, keep, index, last, sqTolerance)
+	}
+}
+
+// sqSegDist returns the squared distance of a point to a segment.
+func sqSegDist(p, a, b [2]float64) float64 {
+	x, y := a[0], a[1]
+	dx, dy := b[0]-x, b[1]-y
+	if dx != 0 || dy != 0 {
+		t := ((p[0]-x)*dx + (p[1]-y)*dy) / (dx*dx + dy*dy)
+		if t > 1 {
+			x, y = b[0], b[1]
+		} else if t > 0 {
+			x, y = x+dx*t, y+dy*t
+		}
+	}
+	dx, dy = p[0]-x, p[1]-y
+	return dx*dx + dy*dy
+}
+
+func (t *mvtTile) appendLine(geom []uint32, line *geometry.Line) []uint32 {
+	if line == nil {
+		return geom
+	}
+	points := make([]geometry.Point, line.NumPoints())
+	for i := range points {
+		points[i] = line.PointAt(i)
+	}
+	q := t.quantize(t.simplify(points))
+	if len(q) < 2 {
+		return geom
+	}
+	return mvtPath(geom, q, false)
+}
+
+func (t *mvtTile) appendPoly(geom []uint32, poly *geometry.Poly) []uint32 {
+	if poly == nil || poly.Exterior == nil {
+		return geom
+	}
+	n := len(geom)
+	geom = t.appendRing(geom, ringPoints(poly.Exterior), true)
+	if len(geom) == n {
+		// the exterior is too small for the tile
+		return geom
+	}
+	for _, hole := range poly.Holes {
+		geom = t.appendRing(geom, ringPoints(hole), false)
+	}
+	return geom
+}
+
+func ringPoints(ring geometry.Ring) []geometry.Point {
+	points := make([]geometry.Point, ring.NumPoints())
+	for i := range points {
+		points[i] = ring.PointAt(i)
+	}
+	return points
+}
+
+// appendRing appends a ring of a polygon, which winds clockwise on the tile
+// when it's the exterior, and counterclockwise when it's a hole.
+func (t *mvtTile) appendRing(geom []uint32, points []geometry.Point, exterior bool) []uint32 {
+	q := t.quantize(t.simplify(points))
+	if len(q) > 1 && q[0] == q[len(q)-1] {
+		q = q[:len(q)-1]
+	}
+	if len(q) < 3 {
+		return geom
+	}
+	var area int64
+	for i := range q {
+		j := (i + 1) % len(q)
+		area += q[i][0]*q[j][1] - q[j][0]*q[i][1]
+	}
+	if area == 0 {
+		return geom
+	}
+	if (area > 0) != exterior {
+		for i, j := 0, len(q)-1; i < j; i, j = i+1, j-1 {
+			q[i], q[j] = q[j], q[i]
+		}
+	}
+	return mvtPath(geom, q, true)
+}
+
+// mvtPoints returns the geometry of points, relative to the cursor at 0,0.
+func mvtPoints(points [][2]int64) []uint32 {
+	if len(points) == 0 {
+		return nil
+	}
+	geom := []uint32{mvtCommand(1, len(points))}
+	var cx, cy int64
+	for _, p := range points {
+		geom = append(geom, mvtZigzag(p[0]-cx), mvtZigzag(p[1]-cy))
+		cx, cy = p[0], p[1]
+	}
+	return geom
+}
+
+// mvtPath appends a line or a ring. The cursor of each path of a geometry
+// starts at the end of the path before it.
+func mvtPath(geom []uint32, points [][2]int64, closed bool) []uint32 {
+	cx, cy := mvtCursor(geom)
+	geom = append(geom, mvtCommand(1, 1),
+		mvtZigzag(points[0][0]-cx), mvtZigzag(points[0][1]-cy))
+	geom = append(geom, mvtCommand(2, len(points)-1))
+	for i := 1; i < len(points); i++ {
+		geom = append(geom,
+			mvtZigzag(points[i][0]-points[i-1][0]),
+			mvtZigzag(points[i][1]-points[i-1][1]))
+	}
+	if closed {
+		geom = append(geom, mvtCommand(7, 1))
+	}
+	return geom
+}
+
+// mvtCursor returns the position of the cursor at the end of a geometry.
+func mvtCursor(geom []uint32) (x, y int64) {
+	for i := 0; i < len(geom); {
+		id, count := geom[i]&7, int(geom[i]>>3)
+		i++
+		if id == 7 {
+			continue
+		}
+		for j := 0; j < count; j++ {
+			x += mvtUnzigzag(geom[i])
+			y += mvtUnzigzag(geom[i+1])
+			i += 2
+		}
+	}
+	return x, y
+}
+
+func mvtCommand(id uint32, count int) uint32 {
+	return id&7 | uint32(count)<<3
+}
+
+func mvtZigzag(n int64) uint32 {
+	return uint32((n << 1) ^ (n >> 63))
+}
+
+func mvtUnzigzag(n uint32) int64 {
+	return int64(n>>1) ^ -int64(n&1)
+}
+
+// mvtLayer is a layer of a vector tile, with the keys and values of the tags
+// of its features.
+type mvtLayer struct {
+	name     string
+	extent   uint32
+	keys     []string
+	keyIdx   map[string]uint32
+	values   [][]byte
+	valueIdx map[string]uint32
+	features [][]byte
+}
+
+func newMVTLayer(name string, extent uint32) *mvtLayer {
+	return &mvtLayer{
+		name:     name,
+		extent:   extent,
+		keyIdx:   make(map[string]uint32),
+		valueIdx: make(map[string]uint32),
+	}
+}
+
+// appendTag appends the indexes of the key and the value of a property.
+func (layer *mvtLayer) appendTag(tags []uint32, key string, value field.Value) []uint32 {
+	ki, ok := layer.keyIdx[key]
+	if !ok {
+		ki = uint32(len(layer.keys))
+		layer.keyIdx[key] = ki
+		layer.keys = append(layer.keys, key)
+	}
+	var v []byte
+	switch value.Kind() {
+	case field.Number:
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(value.Num()))
+		v = append(appendMVTKey(nil, 3, 1), b[:]...)
+	case field.Bool:
+		var n uint64
+		if value.String() == "true" {
+			n = 1
+		}
+		v = appendMVTVarint(appendMVTKey(nil, 7, 0), n)
+	default:
+		v = appendMVTBytes(nil, 1, []byte(value.String()))
+	}
+	vi, ok := layer.valueIdx[string(v)]
+	if !ok {
+		vi = uint32(len(layer.values))
+		layer.valueIdx[string(v)] = vi
+		layer.values = append(layer.values, v)
+	}
+	return append(tags, ki, vi)
+}
+
+func (layer *mvtLayer) addFeature(typ uint32, tags, geom []uint32) {
+	var b []byte
+	b = appendMVTBytes(b, 2, appendMVTPacked(nil, tags))
+	b = appendMVTVarint(appendMVTKey(b, 3, 0), uint64(typ))
+	b = appendMVTBytes(b, 4, appendMVTPacked(nil, geom))
+	layer.features = append(layer.features, b)
+}
+
+func (layer *mvtLayer) bytes() []byte {
+	var b []byte
+	b = appendMVTVarint(appendMVTKey(b, 15, 0), 2)
+	b = appendMVTBytes(b, 1, []byte(layer.name))
+	for _, feature := range layer.features {
+		b = appendMVTBytes(b, 2, feature)
+	}
+	for _, key := range layer.keys {
+		b = appendMVTBytes(b, 3, []byte(key))
+	}
+	for _, value := range layer.values {
+		b = appendMVTBytes(b, 4, value)
+	}
+	return appendMVTVarint(appendMVTKey(b, 5, 0), uint64(layer.extent))
+}
+
+func appendMVTVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendMVTKey(b []byte, num, wireType uint64) []byte {
+	return appendMVTVarint(b, num<<3|wireType)
+}
+
+func appendMVTBytes(b []byte, num uint64, data []byte) []byte {
+	b = appendMVTVarint(appendMVTKey(b, num, 2), uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendMVTPacked(b []byte, vals []uint32) []byte {
+	for _, v := range vals {
+		b = appendMVTVarint(b, uint64(v))
+	}
+	return b
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+func TestMVTZigzag(t *testing.T) {
+	for _, tc := range []struct {
+		n int64
+		z uint32
+	}{{0, 0}, {-1, 1}, {1, 2}, {-2, 3}, {2, 4}, {4096, 8192}} {
+		if z := mvtZigzag(tc.n); z != tc.z {
+			t.Fatalf("%d: expected %d, got %d", tc.n, tc.z, z)
+		}
+		if n := mvtUnzigzag(tc.z); n != tc.n {
+			t.Fatalf("%d: expected %d, got %d", tc.z, tc.n, n)
+		}
+	}
+}
+
+func TestMVTTileBounds(t *testing.T) {
+	tile := &mvtTile{z: 1, x: 1, y: 0, extent: 4096}
+	p := tile.lonLat(0, 1)
+	if p.X != 0 || math.Abs(p.Y) > 1e-9 {
+		t.Fatalf("unexpected %v", p)
+	}
+	x, y := tile.project(geometry.Point{X: 90, Y: 0})
+	if math.Abs(x-2048) > 1e-6 || math.Abs(y-4096) > 1e-6 {
+		t.Fatalf("unexpected %f %f", x, y)
+	}
+}
+
+func TestMVTGeometry(t *testing.T) {
+	tile := &mvtTile{z: 0, x: 0, y: 0, extent: 4096}
+	layer := newMVTLayer("fleet", 4096)
+
+	// a counterclockwise polygon is wound clockwise on the tile, which is
+	// y-down
+	poly := geojson.NewPolygon(geometry.NewPoly([]geometry.Point{
+		{X: 0, Y: 0}, {X: 90, Y: 0}, {X: 90, Y: 60}, {X: 0, Y: 60}, {X: 0, Y: 0},
+	}, nil, nil))
+	tile.addFeatures(layer, poly, nil)
+	tile.addFeatures(layer, geojson.NewPoint(geometry.Point{X: 0, Y: 0}), nil)
+	if len(layer.features) != 2 {
+		t.Fatalf("expected 2 features, got %d", len(layer.features))
+	}
+	geom := tile.appendPoly(nil, poly.Base())
+	// MoveTo(1), 2 params, LineTo(3), 6 params, ClosePath
+	if len(geom) != 11 || geom[0] != mvtCommand(1, 1) ||
+		geom[3] != mvtCommand(2, 3) || geom[10] != mvtCommand(7, 1) {
+		t.Fatalf("unexpected %v", geom)
+	}
+	var q [][2]int64
+	var x, y int64
+	for _, i := range []int{1, 4, 6, 8} {
+		x += mvtUnzigzag(geom[i])
+		y += mvtUnzigzag(geom[i+1])
+		q = append(q, [2]int64{x, y})
+	}
+	var area int64
+	for i := range q {
+		j := (i + 1) % len(q)
+		area += q[i][0]*q[j][1] - q[j][0]*q[i][1]
+	}
+	if area <= 0 {
+		t.Fatalf("expected a clockwise exterior, got %v", q)
+	}
+	if cx, cy := mvtCursor(geom); cx != q[3][0] || cy != q[3][1] {
+		t.Fatalf("unexpected cursor %d %d", cx, cy)
+	}
+}
+
+func TestMVTSimplify(t *testing.T) {
+	tile := &mvtTile{z: 0, x: 0, y: 0, extent: 4096}
+	var points []geometry.Point
+	for i := 0; i <= 100; i++ {
+		points = append(points, geometry.Point{X: float64(i) / 10, Y: 0})
+	}
+	if n := len(tile.simplify(points)); n != 2 {
+		t.Fatalf("expected 2, got %d", n)
+	}
+}
+
+func TestHTTPTileArgs(t *testing.T) {
+	args, contentType, ok := httpTileArgs("tiles/fleet/3/2/1.png?fields=speed,age")
+	if !ok || contentType != "image/png" || strings.Join(args, " ") !=
+		"TILE fleet 3 2 1 FORMAT png FIELDS speed,age" {
+		t.Fatalf("unexpected %v %v %v", args, contentType, ok)
+	}
+	args, contentType, ok = httpTileArgs("tiles/fleet/0/0/0.geojson")
+	if !ok || contentType != "application/geo+json" || strings.Join(args, " ") !=
+		"TILE fleet 0 0 0 FORMAT geojson" {
+		t.Fatalf("unexpected %v %v %v", args, contentType, ok)
+	}
+	for _, path := range []string{
+		"tiles/fleet/0/0/0", "tiles/fleet/0/0/0.jpg", "tiles/fleet/0/0.png",
+		"tiles//0/0/0.png", "get/fleet/0/0/0.png", "GET+fleet+truck1",
+	} {
+		if _, _, ok := httpTileArgs(path); ok {
+			t.Fatalf("%s: expected not a tile", path)
+		}
+	}
+}
+
+func TestPNGTile(t *testing.T) {
+	tile := &mvtTile{z: 0, x: 0, y: 0, extent: 256}
+	objs := []tileObject{
+		{id: "a", obj: geojson.NewPoint(geometry.Point{X: 0, Y: 0})},
+		{id: "b", obj: geojson.NewRect(geometry.Rect{
+			Min: geometry.Point{X: -90, Y: -45}, Max: geometry.Point{X: -45, Y: 0},
+		})},
+	}
+	img, err := png.Decode(bytes.NewReader(tile.png(objs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 256 {
+		t.Fatalf("unexpected %v", b)
+	}
+	c := tileColor("a")
+	if r, g, b, a := img.At(128, 128).RGBA(); r>>8 != uint32(c.R) ||
+		g>>8 != uint32(c.G) || b>>8 != uint32(c.B) || a>>8 != 255 {
+		t.Fatalf("expected the point, got %v %v %v %v", r, g, b, a)
+	}
+	// inside the rect, which is filled
+	if _, _, _, a := img.At(80, 140).RGBA(); a>>8 != 0x50 {
+		t.Fatalf("expected 0x50, got %x", a>>8)
+	}
+	if _, _, _, a := img.At(10, 10).RGBA(); a != 0 {
+		t.Fatalf("expected 0, got %x", a)
+	}
+}
+
+func TestGeoJSONTile(t *testing.T) {
+	objs := []tileObject{
+		{id: "a", obj: geojson.NewPoint(geometry.Point{X: 1, Y: 2}),
+			props: []tileProp{{"speed", field.Num(55)}}},
+	}
+	expect := `{"type":"FeatureCollection","features":[{"type":"Feature",` +
+		`"geometry":{"type":"Point","coordinates":[1,2]},` +
+		`"properties":{"id":"a","speed":55}}]}`
+	if s := string(geoJSONTile(objs)); s != expect {
+		t.Fatalf("expected %s, got %s", expect, s)
+	}
+}
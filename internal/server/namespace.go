@@ -0,0 +1,462 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// nsDefault is the namespace of the clients that did not SELECT another
+// namespace. It has every key, including the keys of the other namespaces.
+const nsDefault = "default"
+
+// nsSep separates the name of a namespace from the keys, hooks, and
+// channels of the namespace. The key "fleet" of the namespace "acme" is
+// stored as "acme:fleet".
+const nsSep = ":"
+
+// namespace is a group of the keys, hooks, and channels of a tenant. The
+// clients of a namespace only see its keys, which are prefixed with its name
+// by the server, so a client cannot reach the keys of another namespace.
+type namespace struct {
+	Name       string `json:"name"`
+	MaxKeys    int64  `json:"maxkeys,omitempty"`    // keys of the namespace
+	MaxObjects int64  `json:"maxobjects,omitempty"` // objects of all of its keys
+	rejected   aint   // writes that were over a quota
+}
+
+// namespaces are the namespaces, which are persisted in the config.
+type namespaces struct {
+	mu sync.RWMutex
+	m  map[string]*namespace
+}
+
+// validNamespace returns true when a name may be used for a namespace.
+func validNamespace(name string) bool {
+	if name == "" || strings.EqualFold(name, nsDefault) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') &&
+			!(c >= '0' && c <= '9') && c != '_' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// nsLoad loads the namespaces that were persisted in the config.
+func (s *Server) nsLoad() error {
+	s.ns.mu.Lock()
+	defer s.ns.mu.Unlock()
+	s.ns.m = map[string]*namespace{}
+	data := s.config.namespaces()
+	if data == "" {
+		return nil
+	}
+	var nss []*namespace
+	if err := json.Unmarshal([]byte(data), &nss); err != nil {
+		return fmt.Errorf("invalid namespaces: %v", err)
+	}
+	for _, ns := range nss {
+		s.ns.m[ns.Name] = ns
+	}
+	return nil
+}
+
+// nsSave persists the namespaces in the config. The namespaces lock must be
+// held.
+func (s *Server) nsSave() {
+	nss := make([]*namespace, 0, len(s.ns.m))
+	for _, ns := range s.ns.m {
+		nss = append(nss, ns)
+	}
+	sort.Slice(nss, func(i, j int) bool {
+		return nss[i].Name < nss[j].Name
+	})
+	var data string
+	if len(nss) > 0 {
+		b, _ := json.Marshal(nss)
+		data = string(b)
+	}
+	s.config.setNamespaces(data)
+	s.config.write(false)
+}
+
+// nsGet returns a namespace, or nil when it does not exist.
+func (s *Server) nsGet(name string) *namespace {
+	s.ns.mu.RLock()
+	ns := s.ns.m[name]
+	s.ns.mu.RUnlock()
+	return ns
+}
+
+// clientNamespace returns the namespace of a client, which is the namespace
+// of its user of the ACL, or the namespace that it selected. It's empty for
+// the default namespace.
+func (s *Server) clientNamespace(client *Client) string {
+	if client == nil {
+		return ""
+	}
+	if client.user != "" {
+		s.acl.mu.RLock()
+		u := s.acl.users[client.user]
+		var name string
+		if u != nil {
+			name = u.Namespace
+		}
+		s.acl.mu.RUnlock()
+		if name != "" {
+			return name
+		}
+	}
+	client.mu.Lock()
+	name := client.ns
+	client.mu.Unlock()
+	return name
+}
+
+// nsCommand returns true for the commands that may be run in a namespace
+// other than the default. The other commands are for the whole server, or
+// may reach the keys of any namespace, such as the scripts.
+func nsCommand(command string) bool {
+	switch command {
+	case "auth", "hello", "quit", "ping", "echo", "output", "health",
+		"select", "multi", "exec", "discard", "watch", "unwatch",
+		"set", "pset", "fset", "fincrby", "jset", "jdel", "jget", "get",
+		"del", "pdel", "drop", "expire", "persist", "ttl", "type", "exists", "bounds",
+		"scan", "nearby", "within", "intersects", "search", "test",
+		"setschema", "delschema", "schema", "setlimits", "dellimits", "limits",
+		"setindex", "delindex", "indexes", "setgeoindex", "geoindex",
+		"sethistory", "delhistory", "history", "trajectory", "passed",
+		"matrix", "tile", "setlabel", "dellabel", "labels", "reindex",
+		"rename", "renamenx", "copy", "memory", "stats", "keys",
+		"sethook", "delhook", "pdelhook", "renamehook", "pausehook",
+		"ppausehook", "resumehook", "presumehook", "hooks",
+		"setchan", "delchan", "pdelchan", "renamechan", "pausechan",
+		"ppausechan", "resumechan", "presumechan", "chans",
+		"subscribe", "psubscribe", "publish":
+		return true
+	}
+	return false
+}
+
+// nsRewrite prefixes the keys, and the names of the hooks and channels, of a
+// command of a client of a namespace. The channels of SUBSCRIBE and
+// PSUBSCRIBE are prefixed by the subscription, see liveSubscription.
+func (s *Server) nsRewrite(msg *Message, name string) error {
+	if s.nsGet(name) == nil {
+		return fmt.Errorf("namespace '%s' does not exist", name)
+	}
+	command := msg.Command()
+	if !nsCommand(command) {
+		return fmt.Errorf("the '%s' command is not allowed in a namespace",
+			command)
+	}
+	prefix := name + nsSep
+	msg.namespace = prefix
+	args := msg.Args
+	// the keys are a part of the arguments
+	keys := clusterKeys(args)
+	for i := range keys {
+		keys[i] = prefix + keys[i]
+	}
+	switch command {
+	case "sethook", "setchan", "delhook", "delchan", "pdelhook", "pdelchan",
+		"pausehook", "pausechan", "ppausehook", "ppausechan", "resumehook",
+		"resumechan", "presumehook", "presumechan", "hooks", "chans",
+		"keys", "watch", "publish":
+		// the name, pattern, key, or channel
+		if len(args) > 1 {
+			args[1] = prefix + args[1]
+		}
+	case "renamehook", "renamechan":
+		for i := 1; i < len(args) && i < 3; i++ {
+			args[i] = prefix + args[i]
+		}
+	case "stats":
+		for i := 1; i < len(args); i++ {
+			if i > 1 && i == len(args)-1 && lc(args[i], "ext") {
+				break
+			}
+			args[i] = prefix + args[i]
+		}
+	}
+	for _, i := range refKeys(command, args) {
+		args[i] = prefix + args[i]
+	}
+	return nil
+}
+
+// refKeys returns the indexes of the arguments of a command that are the
+// keys of other objects, which are the areas of GET key id and FENCE OBJECT
+// key id, and the roaming objects of ROAM key pattern meters.
+func refKeys(command string, args []string) []int {
+	switch command {
+	case "nearby", "within", "intersects", "sethook", "setchan", "test",
+		"copy", "matrix":
+	default:
+		return nil
+	}
+	var idxs []int
+	i := 2
+	if command == "test" {
+		// the first area follows the command
+		i = 1
+	}
+	for ; i < len(args)-1; i++ {
+		if lc(args[i], "get") || lc(args[i], "roam") ||
+			(lc(args[i], "object") &&
+				!strings.HasPrefix(strings.TrimSpace(args[i+1]), "{")) {
+			// the object of a fence may be a key and id, or geojson
+			idxs = append(idxs, i+1)
+			i++
+		}
+	}
+	return idxs
+}
+
+// checkNamespaceQuotas returns an error when a write of a client of a
+// namespace is over one of the quotas of the namespace. The caller must hold
+// the server lock.
+func (s *Server) checkNamespaceQuotas(msg *Message) error {
+	if msg.namespace == "" {
+		return nil
+	}
+	ns := s.nsGet(strings.TrimSuffix(msg.namespace, nsSep))
+	if ns == nil || (ns.MaxKeys == 0 && ns.MaxObjects == 0) {
+		return nil
+	}
+	objs, err := s.quotaObjects(msg)
+	if err != nil || len(objs) == 0 {
+		// the error is returned by the command
+		return nil
+	}
+	col := s.getCol(objs[0].key)
+	var added int64
+	ids := make(map[string]bool)
+	for _, obj := range objs {
+		if ids[obj.id] {
+			continue
+		}
+		ids[obj.id] = true
+		if col == nil {
+			added++
+		} else if _, _, ok := col.Get(obj.id); !ok {
+			added++
+		}
+	}
+	if added == 0 {
+		return nil
+	}
+	st := s.nsStats(msg.namespace)
+	if col == nil && ns.MaxKeys > 0 && st.keys >= ns.MaxKeys {
+		ns.rejected.add(1)
+		return clientErrorf("quota exceeded, the namespace '%s' is over %d "+
+			"keys", ns.Name, ns.MaxKeys)
+	}
+	if ns.MaxObjects > 0 && st.objects+added > ns.MaxObjects {
+		ns.rejected.add(1)
+		return clientErrorf("quota exceeded, the namespace '%s' is over %d "+
+			"objects", ns.Name, ns.MaxObjects)
+	}
+	return nil
+}
+
+// nsStatsT are the stats of the keys and the hooks of a namespace.
+type nsStatsT struct {
+	keys, objects, points, size, hooks, chans int64
+}
+
+// nsStats returns the stats of the keys and the hooks that start with the
+// prefix of a namespace. The caller must hold the server lock.
+func (s *Server) nsStats(prefix string) nsStatsT {
+	var st nsStatsT
+	s.cols.Ascend(&collectionKeyContainer{key: prefix}, func(v interface{}) bool {
+		c := v.(*collectionKeyContainer)
+		if !strings.HasPrefix(c.key, prefix) {
+			return false
+		}
+		st.keys++
+		st.objects += int64(c.col.Count())
+		st.points += int64(c.col.PointCount())
+		st.size += int64(c.col.TotalWeight())
+		return true
+	})
+	for name, hook := range s.hooks {
+		if strings.HasPrefix(name, prefix) {
+			if hook.channel {
+				st.chans++
+			} else {
+				st.hooks++
+			}
+		}
+	}
+	return st
+}
+
+// SELECT namespace
+//
+// Selects the namespace of the connection. The default namespace is
+// selected with SELECT default. The clients whose user of the ACL has a
+// namespace may not select another.
+func (s *Server) cmdSelect(msg *Message, client *Client) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) != 2 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if client == nil {
+		return NOMessage, clientErrorf("SELECT is only allowed for a client")
+	}
+	name := msg.Args[1]
+	if client.user != "" {
+		s.acl.mu.RLock()
+		u := s.acl.users[client.user]
+		bound := u != nil && u.Namespace != ""
+		s.acl.mu.RUnlock()
+		if bound {
+			return NOMessage, clientErrorf(
+				"the namespace of the user cannot be changed")
+		}
+	}
+	if strings.EqualFold(name, nsDefault) {
+		name = ""
+	} else if s.nsGet(name) == nil {
+		return NOMessage, fmt.Errorf("namespace '%s' does not exist", name)
+	}
+	client.mu.Lock()
+	client.ns = name
+	client.mu.Unlock()
+	return OKMessage(msg, start), nil
+}
+
+// NAMESPACE SET name [MAXKEYS n] [MAXOBJECTS n]
+// NAMESPACE DEL name
+// NAMESPACE LIST
+// NAMESPACE STATS name
+//
+// Manages the namespaces. SET adds a namespace, or changes its quotas. DEL
+// removes a namespace, but not its keys, which stay in the default
+// namespace. STATS returns the keys, objects, and hooks of a namespace.
+func (s *Server) cmdNamespace(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) < 2 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	sub := strings.ToLower(msg.Args[1])
+	args := msg.Args[2:]
+	switch sub {
+	case "set":
+		if len(args) < 1 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		ns := &namespace{Name: args[0]}
+		if !validNamespace(ns.Name) {
+			return NOMessage, errInvalidArgument(ns.Name)
+		}
+		for i := 1; i < len(args); i += 2 {
+			if i+1 >= len(args) {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n < 0 {
+				return NOMessage, errInvalidArgument(args[i+1])
+			}
+			switch {
+			case lc(args[i], "maxkeys"):
+				ns.MaxKeys = n
+			case lc(args[i], "maxobjects"):
+				ns.MaxObjects = n
+			default:
+				return NOMessage, errInvalidArgument(args[i])
+			}
+		}
+		s.ns.mu.Lock()
+		if old := s.ns.m[ns.Name]; old != nil {
+			ns.rejected.set(old.rejected.get())
+		}
+		s.ns.m[ns.Name] = ns
+		s.nsSave()
+		s.ns.mu.Unlock()
+		return OKMessage(msg, start), nil
+	case "del":
+		if len(args) != 1 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		s.ns.mu.Lock()
+		_, ok := s.ns.m[args[0]]
+		if ok {
+			delete(s.ns.m, args[0])
+			s.nsSave()
+		}
+		s.ns.mu.Unlock()
+		if msg.OutputType == JSON {
+			return OKMessage(msg, start), nil
+		}
+		if ok {
+			return resp.IntegerValue(1), nil
+		}
+		return resp.IntegerValue(0), nil
+	case "list":
+		if len(args) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		s.ns.mu.RLock()
+		names := make([]string, 0, len(s.ns.m))
+		for name := range s.ns.m {
+			names = append(names, name)
+		}
+		s.ns.mu.RUnlock()
+		sort.Strings(names)
+		if msg.OutputType == JSON {
+			b, _ := json.Marshal(names)
+			return resp.StringValue(`{"ok":true,"namespaces":` + string(b) +
+				`,"elapsed":"` + time.Since(start).String() + "\"}"), nil
+		}
+		vals := make([]resp.Value, len(names))
+		for i, name := range names {
+			vals[i] = resp.StringValue(name)
+		}
+		return resp.ArrayValue(vals), nil
+	case "stats":
+		if len(args) != 1 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		ns := s.nsGet(args[0])
+		if ns == nil {
+			return NOMessage, fmt.Errorf("namespace '%s' does not exist",
+				args[0])
+		}
+		st := s.nsStats(ns.Name + nsSep)
+		m := map[string]interface{}{
+			"num_keys":           st.keys,
+			"num_objects":        st.objects,
+			"num_points":         st.points,
+			"in_memory_size":     st.size,
+			"num_hooks":          st.hooks,
+			"num_chans":          st.chans,
+			"max_keys":           ns.MaxKeys,
+			"max_objects":        ns.MaxObjects,
+			"num_quota_rejected": ns.rejected.get(),
+		}
+		if msg.OutputType == JSON {
+			buf := &bytes.Buffer{}
+			b, _ := json.Marshal(m)
+			buf.WriteString(`{"ok":true,"stats":`)
+			buf.Write(b)
+			buf.WriteString(`,"elapsed":"` + time.Since(start).String() + "\"}")
+			return resp.StringValue(buf.String()), nil
+		}
+		return resp.ArrayValue(respValuesSimpleMap(m)), nil
+	}
+	return NOMessage, clientErrorf(
+		"Syntax error, try NAMESPACE (SET | DEL | LIST | STATS)")
+}
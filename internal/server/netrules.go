@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// netRule allows or denies the clients of a network, for every command, or
+// for some commands and categories of the ACL.
+type netRule struct {
+	allow    bool
+	network  *net.IPNet
+	commands []string // commands and @categories, or empty for all
+}
+
+// parseNetRules parses the netrules property, which is rules that are
+// separated by commas, such as:
+//
+//	allow 10.0.0.0/8 @admin, deny 0.0.0.0/0 @admin, deny ::/0 @admin
+//
+// A rule is allow or deny, a network, which is a CIDR or an address, and
+// optionally the commands and the @categories that the rule is for.
+func parseNetRules(value string) ([]netRule, error) {
+	var rules []netRule
+	for _, s := range strings.Split(value, ",") {
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid rule '%s'", strings.TrimSpace(s))
+		}
+		var rule netRule
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			rule.allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("invalid rule '%s'", strings.TrimSpace(s))
+		}
+		network := fields[1]
+		if !strings.Contains(network, "/") {
+			if ip := net.ParseIP(network); ip != nil && ip.To4() != nil {
+				network += "/32"
+			} else {
+				network += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network '%s'", fields[1])
+		}
+		rule.network = ipnet
+		for _, name := range fields[2:] {
+			name = strings.ToLower(name)
+			if strings.HasPrefix(name, "@") {
+				if aclCommands[name[1:]] == nil {
+					return nil, fmt.Errorf("unknown category '%s'", name[1:])
+				}
+			} else if !aclCommands["all"][name] {
+				return nil, fmt.Errorf("unknown command '%s'", name)
+			}
+			rule.commands = append(rule.commands, name)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// matches returns true when the rule is for a command.
+func (r *netRule) matches(command string) bool {
+	if len(r.commands) == 0 {
+		return true
+	}
+	for _, name := range r.commands {
+		if name == command ||
+			(name[0] == '@' && aclCommands[name[1:]][command]) {
+			return true
+		}
+	}
+	return false
+}
+
+// netAllowed returns true when the first rule that matches the address of a
+// client and a command allows it, or when no rule matches. The command is
+// empty for a new connection, which is denied by the first rule for every
+// command that matches the address, unless an earlier rule allows some of
+// the commands.
+func netAllowed(rules []netRule, addr, command string) bool {
+	if len(rules) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		// not a tcp client, such as a script
+		return true
+	}
+	for i := range rules {
+		r := &rules[i]
+		if !r.network.Contains(ip) {
+			continue
+		}
+		if command == "" {
+			if len(r.commands) == 0 || r.allow {
+				return r.allow
+			}
+		} else if r.matches(command) {
+			return r.allow
+		}
+	}
+	return true
+}
+
+// errNetDenied is returned for a command that the netrules deny.
+var errNetDenied = errors.New("command not allowed from this network")
+
+// netPermit returns an error when the netrules deny a command of a client.
+func (s *Server) netPermit(msg *Message, client *Client) error {
+	rules := s.config.netRules()
+	if len(rules) == 0 || client == nil {
+		return nil
+	}
+	if !netAllowed(rules, client.remoteAddr, msg.Command()) {
+		return errNetDenied
+	}
+	return nil
+}
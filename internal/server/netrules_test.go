@@ -0,0 +1,47 @@
+package server
+
+import "testing"
+
+func TestNetRules(t *testing.T) {
+	rules, err := parseNetRules("allow 10.0.0.0/8 @admin, deny 0.0.0.0/0 @admin," +
+		"allow 192.168.1.10 GET, deny 192.168.1.0/24, deny 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 5 {
+		t.Fatalf("expected 5 rules, got %d", len(rules))
+	}
+	for _, tc := range []struct {
+		addr    string
+		command string
+		allowed bool
+	}{
+		{"10.1.2.3:5000", "config", true},
+		{"172.16.0.1:5000", "config", false},
+		{"172.16.0.1:5000", "set", true},
+		{"172.16.0.1:5000", "", true},
+		{"192.168.1.10:5000", "get", true},
+		{"192.168.1.10:5000", "set", false},
+		{"192.168.1.10:5000", "", true},
+		{"192.168.1.11:5000", "get", false},
+		{"192.168.1.11:5000", "", false},
+		{"[2001:db8::1]:5000", "", false},
+		{"[2001:db9::1]:5000", "get", true},
+		{"", "get", true},
+	} {
+		if netAllowed(rules, tc.addr, tc.command) != tc.allowed {
+			t.Fatalf("expected %t for %s '%s'", tc.allowed, tc.addr,
+				tc.command)
+		}
+	}
+	for _, value := range []string{"allow", "permit 10.0.0.0/8",
+		"deny 10.0.0.0/33", "deny nope", "deny 10.0.0.0/8 @nope",
+		"deny 10.0.0.0/8 nope"} {
+		if _, err := parseNetRules(value); err == nil {
+			t.Fatalf("expected an error for '%s'", value)
+		}
+	}
+	if rules, err := parseNetRules(" "); err != nil || len(rules) != 0 {
+		t.Fatal("expected no rules")
+	}
+}
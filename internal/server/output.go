@@ -14,18 +14,56 @@ func (s *Server) cmdOutput(msg *Message) (res resp.Value, err error) {
 	var ok bool
 
 	if len(vs) != 0 {
-		if _, arg, ok = tokenval(vs); !ok || arg == "" {
+		if vs, arg, ok = tokenval(vs); !ok || arg == "" {
 			return NOMessage, errInvalidNumberOfArguments
 		}
 		// Setting the original message output type will be picked up by the
 		// server prior to the next command being executed.
+		outputType, enc := JSON, encodingJSON
+		var columns []string
 		switch strings.ToLower(arg) {
 		default:
 			return NOMessage, errInvalidArgument(arg)
 		case "json":
-			msg.OutputType = JSON
+		case "msgpack":
+			enc = encodingMsgpack
+		case "protobuf":
+			enc = encodingProtobuf
+		case "flatbuffers":
+			enc = encodingFlatBuffers
+		case "csv":
+			enc = encodingCSV
+			columns = defaultCSVColumns
+			if len(vs) != 0 && !lc(vs[0], "bounds") {
+				if vs, arg, ok = tokenval(vs); !ok || strings.ToLower(arg) != "fields" {
+					return NOMessage, errInvalidArgument(arg)
+				}
+				if vs, arg, ok = tokenval(vs); !ok || arg == "" {
+					return NOMessage, errInvalidNumberOfArguments
+				}
+				columns = strings.Split(arg, ",")
+			}
 		case "resp":
-			msg.OutputType = RESP
+			outputType = RESP
+		}
+		// BOUNDS adds the bounding box and the centroid of the objects to
+		// the json replies
+		var bounds bool
+		if len(vs) != 0 && lc(vs[0], "bounds") && outputType == JSON {
+			bounds = true
+			vs = vs[1:]
+		}
+		if len(vs) != 0 {
+			return NOMessage, errInvalidArgument(vs[0])
+		}
+		msg.OutputType = outputType
+		msg.encoding = enc
+		msg.bounds = bounds
+		if enc == encodingCSV {
+			msg.csv = columns
+		}
+		if enc == encodingFlatBuffers && msg.flat == nil {
+			msg.flat = newFlatEncoder()
 		}
 		return OKMessage(msg, start), nil
 	}
@@ -34,8 +72,64 @@ func (s *Server) cmdOutput(msg *Message) (res resp.Value, err error) {
 	default:
 		return NOMessage, nil
 	case JSON:
-		return resp.StringValue(`{"ok":true,"output":"json","elapsed":` + time.Since(start).String() + `}`), nil
+		var fields string
+		if msg.encoding == encodingCSV {
+			fields = `,"fields":[`
+			for i, column := range msg.csv {
+				if i > 0 {
+					fields += ","
+				}
+				fields += jsonString(column)
+			}
+			fields += "]"
+		}
+		if msg.bounds {
+			fields += `,"bounds":true`
+		}
+		return resp.StringValue(`{"ok":true,"output":"` + msg.encoding.String() + `"` + fields + `,"elapsed":"` + time.Since(start).String() + `"}`), nil
 	case RESP:
 		return resp.StringValue("resp"), nil
 	}
 }
+
+// encoding is the encoding of the json output, see OUTPUT msgpack, OUTPUT
+// protobuf, OUTPUT csv, and OUTPUT flatbuffers.
+type encoding byte
+
+const (
+	encodingJSON        encoding = iota
+	encodingMsgpack              // MessagePack
+	encodingProtobuf             // the Reply of qservice.proto
+	encodingCSV                  // the rows of the objects
+	encodingFlatBuffers          // the Reply of reply.fbs
+)
+
+func (e encoding) String() string {
+	switch e {
+	case encodingMsgpack:
+		return "msgpack"
+	case encodingProtobuf:
+		return "protobuf"
+	case encodingCSV:
+		return "csv"
+	case encodingFlatBuffers:
+		return "flatbuffers"
+	}
+	return "json"
+}
+
+// encode returns a message of json in the encoding, with the columns of
+// OUTPUT csv and the encoder of OUTPUT flatbuffers of the message.
+func (e encoding) encode(data []byte, msg *Message) []byte {
+	switch e {
+	case encodingMsgpack:
+		return msgpackOfJSON(data)
+	case encodingProtobuf:
+		return protobufOfJSON(data)
+	case encodingCSV:
+		return csvOfJSON(data, msg.csv)
+	case encodingFlatBuffers:
+		return msg.flat.encode(data)
+	}
+	return data
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+)
+
+// pageToken is the position of the last object of a page of a STABLE scan or
+// search, which is passed as the CURSOR of the next page. The objects of a
+// NEARBY are ordered by their distance and then by their id, and the other
+// objects are ordered by their id, so the next page starts after the token
+// no matter what was written between the pages. The token is opaque to the
+// clients.
+type pageToken struct {
+	nearby bool
+	dist   float64
+	id     string
+}
+
+// String returns the encoded token.
+func (pt pageToken) String() string {
+	var buf []byte
+	if pt.nearby {
+		var b [9]byte
+		b[0] = 'd'
+		binary.BigEndian.PutUint64(b[1:], math.Float64bits(pt.dist))
+		buf = append(buf, b[:]...)
+	} else {
+		buf = append(buf, 'i')
+	}
+	buf = append(buf, pt.id...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// parsePageToken decodes a token from the previous page of a NEARBY, or of
+// another command.
+func parsePageToken(s string, nearby bool) (pageToken, bool) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(buf) == 0 {
+		return pageToken{}, false
+	}
+	pt := pageToken{nearby: nearby}
+	switch {
+	case nearby && buf[0] == 'd' && len(buf) >= 9:
+		pt.dist = math.Float64frombits(binary.BigEndian.Uint64(buf[1:9]))
+		pt.id = string(buf[9:])
+	case !nearby && buf[0] == 'i':
+		pt.id = string(buf[1:])
+	default:
+		return pageToken{}, false
+	}
+	return pt, true
+}
+
+// follows returns true when an object at the distance with the id comes
+// after the token of a NEARBY.
+func (pt pageToken) follows(dist float64, id string) bool {
+	return dist > pt.dist || (dist == pt.dist && id > pt.id)
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/core"
+)
+
+var errNoLongerPeering = errors.New("no longer peering")
+
+// PEER host port [TLS]
+// PEER no one
+//
+// Replicates the writes of another primary to this server, which keeps
+// accepting writes of its own. Two primaries that peer with each other
+// replicate in both directions. The writes of a server with a peer are
+// versioned, and conflicting writes are resolved by LWW.
+func (s *Server) cmdPeer(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	var host, sport string
+	if vs, host, ok = tokenval(vs); !ok || host == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if vs, sport, ok = tokenval(vs); !ok || sport == "" {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	var useTLS bool
+	if len(vs) > 0 && strings.ToLower(vs[0]) == "tls" {
+		vs = vs[1:]
+		useTLS = true
+	}
+	if len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	host = strings.ToLower(host)
+	sport = strings.ToLower(sport)
+	var update bool
+	if host == "no" && sport == "one" {
+		update = s.config.peerHost() != "" || s.config.peerPort() != 0
+		s.config.setPeerHost("")
+		s.config.setPeerPort(0)
+		s.config.setPeerTLS(false)
+	} else {
+		if s.aof == nil {
+			return NOMessage, errors.New("aof disabled")
+		}
+		if s.raftEnabled() {
+			return NOMessage, errors.New("cannot peer in raft mode")
+		}
+		if s.config.followHost() != "" {
+			return NOMessage, errors.New("cannot peer while following")
+		}
+		n, err := strconv.ParseUint(sport, 10, 64)
+		if err != nil {
+			return NOMessage, errInvalidArgument(sport)
+		}
+		port := int(n)
+		update = s.config.peerHost() != host || s.config.peerPort() != port ||
+			s.config.peerTLS() != useTLS
+		auth := s.config.leaderAuth()
+		if update {
+			s.mu.Unlock()
+			m, err := s.peerServer(fmt.Sprintf("%s:%d", host, port), auth,
+				useTLS)
+			s.mu.Lock()
+			if err != nil {
+				return NOMessage, fmt.Errorf("cannot peer: %v", err)
+			}
+			if err := s.peerCheck(m); err != nil {
+				return NOMessage, err
+			}
+		}
+		s.config.setPeerHost(host)
+		s.config.setPeerPort(port)
+		s.config.setPeerTLS(useTLS)
+	}
+	if update {
+		s.config.setPeerResume("", 0, "")
+	}
+	s.config.write(false)
+	if update {
+		s.peerc.add(1)
+		if s.config.peerHost() != "" {
+			replLog.Infof("peering with '%s' '%s'.", host, sport)
+			go s.peer(s.config.peerHost(), s.config.peerPort(), s.peerc.get())
+		} else {
+			replLog.Infof("peering with no one")
+		}
+	}
+	return OKMessage(msg, start), nil
+}
+
+// peerServer returns the SERVER stats of a peer.
+func (s *Server) peerServer(addr, auth string, useTLS bool,
+) (map[string]string, error) {
+	conn, err := s.dialLeader(addr, useTLS)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if auth != "" {
+		if err := peerDoAuth(conn, auth); err != nil {
+			return nil, err
+		}
+	}
+	return doServer(conn)
+}
+
+func peerDoAuth(conn *RESPConn, auth string) error {
+	v, err := conn.Do("auth", auth)
+	if err != nil {
+		return err
+	}
+	if v.Error() != nil {
+		return v.Error()
+	}
+	if v.String() != "OK" {
+		return errors.New("auth no ok")
+	}
+	return nil
+}
+
+// peerCheck returns an error when the server with the SERVER stats cannot be
+// a peer.
+func (s *Server) peerCheck(m map[string]string) error {
+	if m["id"] == "" {
+		return errors.New("cannot peer: invalid id")
+	}
+	if m["id"] == s.config.serverID() {
+		return errors.New("cannot peer with self")
+	}
+	if m["following"] != "" {
+		return errors.New("cannot peer with a follower")
+	}
+	return nil
+}
+
+func (s *Server) peer(host string, port int, peerc int) {
+	for {
+		err := s.peerStep(host, port, peerc)
+		if err == errNoLongerPeering {
+			return
+		}
+		if err != nil && err != io.EOF {
+			replLog.Error("peer: " + err.Error())
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (s *Server) peerStep(host string, port int, peerc int) error {
+	if s.peerc.get() != peerc {
+		return errNoLongerPeering
+	}
+	auth := s.config.leaderAuth()
+	useTLS := s.config.peerTLS()
+	addr := fmt.Sprintf("%s:%d", host, port)
+	conn, err := s.dialLeader(addr, useTLS)
+	if err != nil {
+		return fmt.Errorf("cannot peer: %v", err)
+	}
+	defer conn.Close()
+	if auth != "" {
+		if err := peerDoAuth(conn, auth); err != nil {
+			return fmt.Errorf("cannot peer: %v", err)
+		}
+	}
+	m, err := doServer(conn)
+	if err != nil {
+		return fmt.Errorf("cannot peer: %v", err)
+	}
+	if err := s.peerCheck(m); err != nil {
+		return err
+	}
+	aofSize, err := strconv.ParseInt(m["aof_size"], 10, 64)
+	if err != nil {
+		return err
+	}
+	pos, err := s.peerResumePos(addr, auth, useTLS, m["id"], aofSize, peerc)
+	if err != nil {
+		return err
+	}
+	v, err := conn.Do("aof", pos, "peer", s.config.serverID())
+	if err != nil {
+		return err
+	}
+	if v.Error() != nil {
+		return v.Error()
+	}
+	if v.String() != "OK" {
+		return errors.New("invalid response to aof live request")
+	}
+	if core.ShowDebugMessages {
+		replLog.Debug("peer:", addr, ":read aof")
+	}
+	defer s.peerSavePos(peerc)
+	var saved time.Time
+	for {
+		v, telnet, _, err := conn.rd.ReadMultiBulk()
+		if err != nil {
+			return err
+		}
+		vals := v.Array()
+		if telnet || v.Type() != resp.Array {
+			return errors.New("invalid multibulk")
+		}
+		svals := make([]string, len(vals))
+		for i := 0; i < len(vals); i++ {
+			svals[i] = vals[i].String()
+		}
+		if len(svals) != 4 || strings.ToLower(svals[0]) != "replconf" {
+			if err := s.peerHandleCommand(svals, peerc); err != nil {
+				return err
+			}
+			continue
+		}
+		offset, err := strconv.ParseInt(svals[2], 10, 64)
+		if err != nil {
+			return errors.New("invalid replconf pos")
+		}
+		s.mu.Lock()
+		s.peerPos, s.peerSum = offset, svals[3]
+		s.mu.Unlock()
+		if time.Since(saved) > time.Second {
+			s.peerSavePos(peerc)
+			saved = time.Now()
+		}
+	}
+}
+
+// peerHandleCommand applies a versioned write from the peer. A write that
+// fails, such as an FSET on an object that was deleted, is skipped.
+func (s *Server) peerHandleCommand(args []string, peerc int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.peerc.get() != peerc {
+		return errNoLongerPeering
+	}
+	if len(args) == 0 || strings.ToLower(args[0]) != "lww" {
+		return errors.New("invalid peer command")
+	}
+	_, d, err := s.command(&Message{Args: args}, nil)
+	if err != nil {
+		replLog.Debugf("peer: skipped %s: %v", strings.Join(args, " "), err)
+		return nil
+	}
+	if err := s.writeAOF(args, &d); err != nil {
+		return err
+	}
+	if len(s.aofbuf) > 10240 {
+		s.flushAOF(false)
+	}
+	return nil
+}
+
+// peerResumePos returns the peer's aof offset to resume from. The offset of
+// the last REPLCONF POS from the peer is used when its checksum still matches
+// the peer's aof. Otherwise all of the peer's aof is read again, which is
+// safe because the writes that are already applied have the same version.
+func (s *Server) peerResumePos(addr, auth string, useTLS bool, peerID string,
+	aofSize int64, peerc int,
+) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.peerc.get() != peerc {
+		return 0, errNoLongerPeering
+	}
+	id, pos, sum := s.config.peerResume()
+	if id == peerID && pos > 0 && pos <= aofSize {
+		conn, err := s.dialLeader(addr, useTLS)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		if auth != "" {
+			if err := peerDoAuth(conn, auth); err != nil {
+				return 0, err
+			}
+		}
+		size := int64(aofFilterTail)
+		if pos < size {
+			size = pos
+		}
+		csum, err := connAOFMD5(conn, pos-size, size)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if csum == sum {
+			s.peerPos, s.peerSum = pos, sum
+			return pos, nil
+		}
+	}
+	if id != "" {
+		replLog.Infof("peer: reading the peer's aof from the start")
+	}
+	s.peerPos, s.peerSum = 0, ""
+	s.config.setPeerResume(peerID, 0, "")
+	s.config.write(false)
+	return 0, nil
+}
+
+// peerSavePos saves the peer's aof offset. The aof is flushed first, so that
+// it has all of the writes before the offset.
+func (s *Server) peerSavePos(peerc int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.peerc.get() != peerc {
+		return
+	}
+	id, pos, sum := s.config.peerResume()
+	if pos == s.peerPos && sum == s.peerSum {
+		return
+	}
+	s.flushAOF(false)
+	s.config.setPeerResume(id, s.peerPos, s.peerSum)
+	s.config.write(false)
+}
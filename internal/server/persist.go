@@ -0,0 +1,698 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/redcon"
+	"github.com/tidwall/tile38/core"
+	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/field"
+	"github.com/tidwall/tile38/internal/log"
+)
+
+// persister stores the commands that modify the dataset, and loads the
+// dataset when the server starts. The engine is selected with the
+// --persistengine flag.
+type persister interface {
+	// load opens the storage and loads the dataset into the server.
+	load() error
+	// write persists a command that modified the dataset. The caller must
+	// hold the server lock.
+	write(args []string) error
+	// close flushes any pending writes.
+	close() error
+}
+
+func (s *Server) openPersister(engine string) (persister, error) {
+	switch engine {
+	case "aof":
+		return &aofPersister{s: s}, nil
+	case "kv":
+		if s.aead != nil {
+			return nil, errors.New("encryption is not supported by the kv " +
+				"persistence engine")
+		}
+		return &kvPersister{s: s}, nil
+	}
+	return nil, fmt.Errorf("unknown persistence engine '%s'", engine)
+}
+
+// persistEngine returns the name of the persistence engine, or "none" when
+// persistence is disabled.
+func (s *Server) persistEngine() string {
+	if s.persist == nil {
+		return "none"
+	}
+	return core.PersistEngine
+}
+
+// aofPersister appends every command to the aof, which is replayed on
+// startup. This is the default engine, and is required for replication,
+// snapshots, and incremental backups.
+type aofPersister struct {
+	s *Server
+}
+
+func (p *aofPersister) load() error {
+	s := p.s
+	f, err := s.openAOF(core.AppendFileName)
+	if err != nil {
+		return err
+	}
+	s.aof = f
+	if err := s.loadSnapshot(); err != nil {
+		return err
+	}
+	return s.loadAOF()
+}
+
+func (p *aofPersister) write(args []string) error {
+	s := p.s
+	n := len(s.aofbuf)
+	s.aofbuf = redcon.AppendArray(s.aofbuf, len(args))
+	for _, arg := range args {
+		s.aofbuf = redcon.AppendBulkString(s.aofbuf, arg)
+	}
+	s.aofsz += len(s.aofbuf) - n
+	s.aofwrote.add(len(s.aofbuf) - n)
+	return nil
+}
+
+func (p *aofPersister) close() error {
+	p.s.flushAOF(false)
+	return p.s.aof.Sync()
+}
+
+// kvPersister stores the latest state of each object, hook, schema, and
+// index as a key in a buntdb database. There's no log that grows with every
+// write, so the storage never needs to be shrunk, but the engine does not
+// support replication.
+//
+// Objects are stored at "o\x00{key}\x00{id}", fields that expire at
+// "f\x00{key}\x00{id}\x00{field}", hooks at "h\x00{name}", schemas at
+// "s\x00{key}", limits at "m\x00{key}", indexes at "i\x00{key}\x00{field}",
+// kept positions settings at "t\x00{key}", labels at "b\x00{key}", and
+// libraries of functions at "l\x00{name}".
+// The value is the command that recreates the item, prefixed with the
+// expiration in unix nanoseconds, or zero for no expiration.
+//
+// Each write command updates the items that it changed. A command that is
+// not known to the engine stores the whole dataset again, which costs as much
+// as the dataset for every such write, and is logged once per command.
+type kvPersister struct {
+	s  *Server
+	db *buntdb.DB
+
+	mu        sync.Mutex
+	fallbacks map[string]bool // the unknown commands, see write
+}
+
+const kvObjectPrefix = "o\x00"
+const kvFieldPrefix = "f\x00"
+const kvHookPrefix = "h\x00"
+const kvSchemaPrefix = "s\x00"
+const kvLimitsPrefix = "m\x00"
+const kvIndexPrefix = "i\x00"
+const kvHistoryPrefix = "t\x00"
+const kvLibraryPrefix = "l\x00"
+const kvTriggerPrefix = "r\x00"
+const kvLabelPrefix = "b\x00"
+
+func kvObjectKey(key, id string) string {
+	return kvObjectPrefix + key + "\x00" + id
+}
+
+// kvAscendPrefix iterates over the items that start with prefix.
+func kvAscendPrefix(tx *buntdb.Tx, prefix string,
+	iter func(key, value string) bool,
+) error {
+	end := prefix[:len(prefix)-1] + string(prefix[len(prefix)-1]+1)
+	return tx.AscendRange("", prefix, end, iter)
+}
+
+// kvDeletePrefix deletes the items that start with prefix.
+func kvDeletePrefix(tx *buntdb.Tx, prefix string) error {
+	var keys []string
+	err := kvAscendPrefix(tx, prefix, func(key, value string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := tx.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func kvEncode(ex int64, args []string) string {
+	buf := redcon.AppendArray(nil, len(args)+1)
+	buf = redcon.AppendBulkString(buf, strconv.FormatInt(ex, 10))
+	for _, arg := range args {
+		buf = redcon.AppendBulkString(buf, arg)
+	}
+	return string(buf)
+}
+
+func kvDecode(value string) (ex int64, args []string, err error) {
+	complete, bargs, _, _, err := redcon.ReadNextCommand([]byte(value), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !complete || len(bargs) < 2 {
+		return 0, nil, errors.New("invalid value")
+	}
+	ex, err = strconv.ParseInt(string(bargs[0]), 10, 64)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, arg := range bargs[1:] {
+		args = append(args, string(arg))
+	}
+	return ex, args, nil
+}
+
+func (p *kvPersister) load() error {
+	db, err := buntdb.Open(core.KVFileName)
+	if err != nil {
+		return err
+	}
+	p.db = db
+	start := time.Now()
+	now := start.UnixNano()
+	var count int
+	var expired []string
+	var resync [][2]string
+	p.s.beginBulkLoad()
+	err = db.View(func(tx *buntdb.Tx) error {
+		var lerr error
+		apply := func(key, value string) bool {
+			var ex int64
+			var args []string
+			ex, args, lerr = kvDecode(value)
+			if lerr != nil {
+				lerr = fmt.Errorf("%q: %v", key, lerr)
+				return false
+			}
+			if ex != 0 && ex <= now && strings.HasPrefix(key, kvFieldPrefix) {
+				// the field expired while the server was down, so it's
+				// cleared and the object is stored again without it
+				expired = append(expired, key)
+				resync = append(resync, [2]string{args[1], args[2]})
+				args = append(args[:len(args)-1:len(args)-1], "0")
+				ex = 0
+			}
+			if ex != 0 {
+				if ex <= now {
+					expired = append(expired, key)
+					return true
+				}
+				// the expiration goes before the object type
+				secs := float64(ex-now) / float64(time.Second)
+				n := len(args)
+				args = append(args[:n-2:n-2], "ex",
+					strconv.FormatFloat(math.Ceil(secs*10)/10, 'f', -1, 64),
+					args[n-2], args[n-1])
+			}
+			_, d, err := p.s.command(&Message{Args: args}, nil)
+			if err != nil {
+				if commandErrIsFatal(err) {
+					lerr = fmt.Errorf("%q: %v", key, err)
+					return false
+				}
+			} else if d.updated {
+				p.s.updateFenceRefs(&d)
+			}
+			count++
+			return true
+		}
+		// objects are loaded before the hooks, which may refer to them
+		if err := kvAscendPrefix(tx, kvObjectPrefix, apply); err != nil {
+			return err
+		}
+		if lerr != nil {
+			return lerr
+		}
+		if err := kvAscendPrefix(tx, kvFieldPrefix, apply); err != nil {
+			return err
+		}
+		if lerr != nil {
+			return lerr
+		}
+		if err := kvAscendPrefix(tx, kvHookPrefix, apply); err != nil {
+			return err
+		}
+		if lerr != nil {
+			return lerr
+		}
+		if err := kvAscendPrefix(tx, kvIndexPrefix, apply); err != nil {
+			return err
+		}
+		if lerr != nil {
+			return lerr
+		}
+		if err := kvAscendPrefix(tx, kvHistoryPrefix, apply); err != nil {
+			return err
+		}
+		if lerr != nil {
+			return lerr
+		}
+		if err := kvAscendPrefix(tx, kvLabelPrefix, apply); err != nil {
+			return err
+		}
+		if lerr != nil {
+			return lerr
+		}
+		if err := kvAscendPrefix(tx, kvLibraryPrefix, apply); err != nil {
+			return err
+		}
+		if lerr != nil {
+			return lerr
+		}
+		// triggers are loaded after the libraries of their functions
+		if err := kvAscendPrefix(tx, kvTriggerPrefix, apply); err != nil {
+			return err
+		}
+		if lerr != nil {
+			return lerr
+		}
+		// schemas and limits are loaded last, so that they're only applied
+		// to the writes that follow
+		if err := kvAscendPrefix(tx, kvSchemaPrefix, apply); err != nil {
+			return err
+		}
+		if lerr != nil {
+			return lerr
+		}
+		if err := kvAscendPrefix(tx, kvLimitsPrefix, apply); err != nil {
+			return err
+		}
+		return lerr
+	})
+	p.s.endBulkLoad()
+	if err != nil {
+		return err
+	}
+	if len(expired) > 0 {
+		err := db.Update(func(tx *buntdb.Tx) error {
+			for _, key := range expired {
+				if _, err := tx.Delete(key); err != nil {
+					return err
+				}
+			}
+			for _, item := range resync {
+				if err := p.syncObject(tx, item[0], item[1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	log.Infof("KV loaded %d items: %.2fs", count,
+		float64(time.Since(start))/float64(time.Second))
+	return nil
+}
+
+func (p *kvPersister) write(args []string) error {
+	args, _ = lwwArgs(args)
+	if len(args) == 0 || txMarker(args, "multi") || txMarker(args, "exec") {
+		// each write of a transaction is stored on its own
+		return nil
+	}
+	command := strings.ToLower(args[0])
+	return p.db.Update(func(tx *buntdb.Tx) error {
+		switch command {
+		case "set", "fset", "fincrby", "jset", "jdel", "del", "expire",
+			"persist":
+			if len(args) < 3 {
+				break
+			}
+			return p.syncObject(tx, args[1], args[2])
+		case "pset":
+			objs, err := psetObjects(args[2:])
+			if err != nil {
+				break
+			}
+			for _, obj := range objs {
+				if err := p.syncObject(tx, args[1], obj[0]); err != nil {
+					return err
+				}
+			}
+			return nil
+		case "pdel", "drop":
+			if len(args) < 2 {
+				break
+			}
+			return p.syncCollection(tx, args[1])
+		case "copy":
+			if len(args) < 3 {
+				break
+			}
+			return p.syncCollection(tx, args[2])
+		case "rename", "renamenx":
+			if len(args) < 3 {
+				break
+			}
+			if err := p.syncCollection(tx, args[1]); err != nil {
+				return err
+			}
+			return p.syncCollection(tx, args[2])
+		case "sethook", "delhook", "pdelhook", "renamehook",
+			"pausehook", "ppausehook", "resumehook", "presumehook",
+			"setchan", "delchan", "pdelchan", "renamechan",
+			"pausechan", "ppausechan", "resumechan", "presumechan":
+			return p.syncHooks(tx)
+		case "setschema", "delschema":
+			if len(args) < 2 {
+				break
+			}
+			return p.syncSchema(tx, args[1])
+		case "setlimits", "dellimits":
+			if len(args) < 2 {
+				break
+			}
+			return p.syncLimits(tx, args[1])
+		case "setindex", "delindex", "setgeoindex":
+			if len(args) < 2 {
+				break
+			}
+			return p.syncIndexes(tx, args[1])
+		case "sethistory", "delhistory":
+			if len(args) < 2 {
+				break
+			}
+			return p.syncHistory(tx, args[1])
+		case "setlabel", "dellabel":
+			if len(args) < 2 {
+				break
+			}
+			return p.syncLabels(tx, args[1])
+		case "function":
+			// the triggers of deleted functions are removed
+			if err := p.syncLibraries(tx); err != nil {
+				return err
+			}
+			return p.syncTriggers(tx)
+		case "settrigger", "deltrigger":
+			return p.syncTriggers(tx)
+		case "flushdb":
+			// everything but the libraries of functions is removed
+			if err := tx.DeleteAll(); err != nil {
+				return err
+			}
+			return p.syncLibraries(tx)
+		case "hooklease":
+			// the lease of the follower hooks is not part of the dataset
+			return nil
+		}
+		p.fallback(command)
+		return p.syncAll(tx)
+	})
+}
+
+// fallback logs the first write of a command that isn't known to the engine,
+// for which everything is stored again.
+func (p *kvPersister) fallback(command string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fallbacks[command] {
+		return
+	}
+	if p.fallbacks == nil {
+		p.fallbacks = make(map[string]bool)
+	}
+	p.fallbacks[command] = true
+	log.Warnf("kv: the writes of '%s' store the whole dataset", command)
+}
+
+func (p *kvPersister) close() error {
+	return p.db.Close()
+}
+
+// objectArgs returns the command that recreates an object, without the
+// expiration.
+func objectArgs(col *collection.Collection, key, id string) (args []string, ok bool) {
+	obj, values, ok := col.Get(id)
+	if !ok {
+		return nil, false
+	}
+	args = append(args, "set", key, id)
+	if ts := col.Timestamp(id); ts != 0 {
+		args = append(args, "timestamp", formatTimestamp(ts))
+	}
+	if v := col.Version(id); v != 0 {
+		args = append(args, "version", strconv.FormatUint(v, 10))
+	}
+	for _, fv := range orderFields(col.FieldMap(), col.FieldArr(), values) {
+		args = append(args, "field", fv.field, fv.value.String())
+	}
+	if objIsSpatial(obj) {
+		args = append(args, "object", string(obj.AppendJSON(nil)))
+	} else {
+		args = append(args, "string", obj.String())
+	}
+	return args, true
+}
+
+func (p *kvPersister) setObject(tx *buntdb.Tx, col *collection.Collection,
+	key, id string,
+) error {
+	args, ok := objectArgs(col, key, id)
+	if !ok {
+		_, err := tx.Delete(kvObjectKey(key, id))
+		if err == buntdb.ErrNotFound {
+			err = nil
+		}
+		return err
+	}
+	var ex int64
+	if at, ok := p.s.getExpires(key, id); ok {
+		ex = at.UnixNano()
+	}
+	if _, _, err := tx.Set(kvObjectKey(key, id), kvEncode(ex, args), nil); err != nil {
+		return err
+	}
+	fm := p.s.fexpires[key][id]
+	if len(fm) == 0 {
+		return nil
+	}
+	fmap := col.FieldMap()
+	_, values, _ := col.Get(id)
+	for name, at := range fm {
+		idx, ok := fmap[name]
+		if !ok || idx >= len(values) || values[idx].IsZero() {
+			continue
+		}
+		args := []string{"fset", key, id, name, values[idx].String()}
+		fkey := kvFieldPrefix + key + "\x00" + id + "\x00" + name
+		if _, _, err := tx.Set(fkey, kvEncode(at, args), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *kvPersister) syncObject(tx *buntdb.Tx, key, id string) error {
+	col := p.s.getCol(key)
+	if col == nil {
+		col = collection.New()
+	}
+	err := kvDeletePrefix(tx, kvFieldPrefix+key+"\x00"+id+"\x00")
+	if err != nil {
+		return err
+	}
+	return p.setObject(tx, col, key, id)
+}
+
+func (p *kvPersister) syncCollection(tx *buntdb.Tx, key string) error {
+	if err := kvDeletePrefix(tx, kvObjectPrefix+key+"\x00"); err != nil {
+		return err
+	}
+	if err := kvDeletePrefix(tx, kvFieldPrefix+key+"\x00"); err != nil {
+		return err
+	}
+	col := p.s.getCol(key)
+	if col == nil {
+		return nil
+	}
+	var err error
+	col.Scan(false, nil, nil, func(id string, _ geojson.Object, _ []field.Value) bool {
+		err = p.setObject(tx, col, key, id)
+		return err == nil
+	})
+	return err
+}
+
+func (p *kvPersister) syncHooks(tx *buntdb.Tx) error {
+	if err := kvDeletePrefix(tx, kvHookPrefix); err != nil {
+		return err
+	}
+	for _, args := range p.s.hookCommands() {
+		key := kvHookPrefix + args[1]
+		if args[0] != "sethook" && args[0] != "setchan" {
+			// pausehook or pausechan, which follows the hook
+			key += "\x00" + args[0]
+		}
+		if _, _, err := tx.Set(key, kvEncode(0, args), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *kvPersister) syncSchema(tx *buntdb.Tx, key string) error {
+	cmds := p.s.schemaCommands([]string{key})
+	if len(cmds) == 0 {
+		_, err := tx.Delete(kvSchemaPrefix + key)
+		if err == buntdb.ErrNotFound {
+			err = nil
+		}
+		return err
+	}
+	_, _, err := tx.Set(kvSchemaPrefix+key, kvEncode(0, cmds[0]), nil)
+	return err
+}
+
+func (p *kvPersister) syncHistory(tx *buntdb.Tx, key string) error {
+	cmds := p.s.historyCommands([]string{key})
+	if len(cmds) == 0 {
+		_, err := tx.Delete(kvHistoryPrefix + key)
+		if err == buntdb.ErrNotFound {
+			err = nil
+		}
+		return err
+	}
+	_, _, err := tx.Set(kvHistoryPrefix+key, kvEncode(0, cmds[0]), nil)
+	return err
+}
+
+func (p *kvPersister) syncLimits(tx *buntdb.Tx, key string) error {
+	cmds := p.s.limitsCommands([]string{key})
+	if len(cmds) == 0 {
+		_, err := tx.Delete(kvLimitsPrefix + key)
+		if err == buntdb.ErrNotFound {
+			err = nil
+		}
+		return err
+	}
+	_, _, err := tx.Set(kvLimitsPrefix+key, kvEncode(0, cmds[0]), nil)
+	return err
+}
+
+func (p *kvPersister) syncLabels(tx *buntdb.Tx, key string) error {
+	cmds := p.s.labelCommands([]string{key})
+	if len(cmds) == 0 {
+		_, err := tx.Delete(kvLabelPrefix + key)
+		if err == buntdb.ErrNotFound {
+			err = nil
+		}
+		return err
+	}
+	_, _, err := tx.Set(kvLabelPrefix+key, kvEncode(0, cmds[0]), nil)
+	return err
+}
+
+func (p *kvPersister) syncLibraries(tx *buntdb.Tx) error {
+	if err := kvDeletePrefix(tx, kvLibraryPrefix); err != nil {
+		return err
+	}
+	for _, args := range p.s.functionCommands() {
+		name, _ := parseLibraryName(args[2])
+		if _, _, err := tx.Set(kvLibraryPrefix+name, kvEncode(0, args), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *kvPersister) syncTriggers(tx *buntdb.Tx) error {
+	if err := kvDeletePrefix(tx, kvTriggerPrefix); err != nil {
+		return err
+	}
+	for _, args := range p.s.triggerCommands() {
+		if _, _, err := tx.Set(kvTriggerPrefix+args[1], kvEncode(0, args), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *kvPersister) syncIndexes(tx *buntdb.Tx, key string) error {
+	if err := kvDeletePrefix(tx, kvIndexPrefix+key+"\x00"); err != nil {
+		return err
+	}
+	return p.setIndexes(tx, []string{key})
+}
+
+func (p *kvPersister) setIndexes(tx *buntdb.Tx, keys []string) error {
+	for _, args := range p.s.indexCommands(keys) {
+		name := args[2]
+		if args[0] == "setgeoindex" {
+			// a field name is never empty
+			name = ""
+		}
+		key := kvIndexPrefix + args[1] + "\x00" + name
+		if _, _, err := tx.Set(key, kvEncode(0, args), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *kvPersister) syncAll(tx *buntdb.Tx) error {
+	if err := tx.DeleteAll(); err != nil {
+		return err
+	}
+	var err error
+	p.s.scanGreaterOrEqual("", func(key string, col *collection.Collection) bool {
+		err = p.syncCollection(tx, key)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := p.syncHooks(tx); err != nil {
+		return err
+	}
+	for _, args := range p.s.schemaCommands(nil) {
+		if _, _, err := tx.Set(kvSchemaPrefix+args[1], kvEncode(0, args), nil); err != nil {
+			return err
+		}
+	}
+	for _, args := range p.s.limitsCommands(nil) {
+		if _, _, err := tx.Set(kvLimitsPrefix+args[1], kvEncode(0, args), nil); err != nil {
+			return err
+		}
+	}
+	for _, args := range p.s.historyCommands(nil) {
+		if _, _, err := tx.Set(kvHistoryPrefix+args[1], kvEncode(0, args), nil); err != nil {
+			return err
+		}
+	}
+	for _, args := range p.s.labelCommands(nil) {
+		if _, _, err := tx.Set(kvLabelPrefix+args[1], kvEncode(0, args), nil); err != nil {
+			return err
+		}
+	}
+	if err := p.syncLibraries(tx); err != nil {
+		return err
+	}
+	if err := p.syncTriggers(tx); err != nil {
+		return err
+	}
+	return p.setIndexes(tx, nil)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/tile38/core"
+)
+
+func TestKVPersister(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	core.KVFileName = filepath.Join(dir, "kv.db")
+	defer func() { core.KVFileName = "" }()
+
+	config, err := loadConfig(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1 := newSnapshotTestServer()
+	s1.config = config
+	p1 := &kvPersister{s: s1}
+	if err := p1.load(); err != nil {
+		t.Fatal(err)
+	}
+	do := func(args ...string) {
+		t.Helper()
+		if _, _, err := s1.command(&Message{Args: args}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := p1.write(args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	do("SET", "fleet", "truck1", "FIELD", "speed", "10", "POINT", "33", "-112")
+	do("SET", "fleet", "truck2", "EX", "100", "POINT", "34", "-113")
+	do("SET", "fleet", "truck3", "STRING", "hello")
+	do("FSET", "fleet", "truck1", "age", "5")
+	do("DEL", "fleet", "truck3")
+	do("SET", "fleet2", "truck1", "POINT", "1", "2")
+	do("SET", "fleet3", "truck1", "POINT", "3", "4")
+	do("RENAME", "fleet2", "fleet4")
+	do("DROP", "fleet3")
+	if len(p1.fallbacks) != 0 {
+		t.Fatalf("expected no full writes, got %v", p1.fallbacks)
+	}
+	if err := p1.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := newSnapshotTestServer()
+	s2.config = config
+	p2 := &kvPersister{s: s2}
+	if err := p2.load(); err != nil {
+		t.Fatal(err)
+	}
+	defer p2.close()
+	for _, key := range []string{"fleet", "fleet4"} {
+		col1, col2 := s1.getCol(key), s2.getCol(key)
+		if col2 == nil || col1.Count() != col2.Count() {
+			t.Fatalf("%s: expected %d objects", key, col1.Count())
+		}
+		for _, id := range []string{"truck1", "truck2"} {
+			args1, ok1 := objectArgs(col1, key, id)
+			args2, ok2 := objectArgs(col2, key, id)
+			if ok1 != ok2 || len(args1) != len(args2) {
+				t.Fatalf("%s %s: expected %v, got %v", key, id, args1, args2)
+			}
+			for i := range args1 {
+				if args1[i] != args2[i] {
+					t.Fatalf("%s %s: expected %v, got %v", key, id, args1, args2)
+				}
+			}
+		}
+	}
+	if s2.getCol("fleet2") != nil || s2.getCol("fleet3") != nil {
+		t.Fatal("expected fleet2 and fleet3 to be removed")
+	}
+	at, ok := s2.getExpires("fleet", "truck2")
+	if !ok || time.Until(at) < 90*time.Second {
+		t.Fatalf("expected expiration, got %v", at)
+	}
+
+	// FLUSHDB removes the stored objects
+	if _, _, err := s2.command(&Message{Args: []string{"FLUSHDB"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p2.write([]string{"FLUSHDB"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(p2.fallbacks) != 0 {
+		t.Fatalf("expected no full writes, got %v", p2.fallbacks)
+	}
+	var n int
+	p2.db.View(func(tx *buntdb.Tx) error {
+		n, _ = tx.Len()
+		return nil
+	})
+	if n != 0 {
+		t.Fatalf("expected no items, got %d", n)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/internal/qservice"
+)
+
+// protobufOfJSON returns the protobuf of the Reply of a message of json.
+func protobufOfJSON(data []byte) []byte {
+	b, _ := proto.Marshal(replyOfJSON(data))
+	return b
+}
+
+// replyOfJSON returns the Reply of a message of json, which is published in
+// qservice.proto. The members that are not in the schema, such as the stats
+// of a collection or the GET HASH, are kept with the json of the reply. A
+// message that is not a json object, such as from PUBLISH, is only json.
+func replyOfJSON(data []byte) *qservice.Reply {
+	res := gjson.ParseBytes(data)
+	if !res.IsObject() {
+		return &qservice.Reply{Json: string(data)}
+	}
+	if res.Get("detect").Exists() && res.Get("command").Exists() {
+		// an event of a geofence, where the hook is the channel
+		return &qservice.Reply{Event: fenceEvent(submsg{
+			channel: res.Get("hook").String(),
+			message: string(data),
+		})}
+	}
+	reply := new(qservice.Reply)
+	var names []gjson.Result
+	other := false
+	res.ForEach(func(key, value gjson.Result) bool {
+		switch key.String() {
+		case "ok":
+			reply.Ok = value.Bool()
+		case "err":
+			reply.Err = value.String()
+		case "elapsed":
+			reply.Elapsed = value.String()
+		case "object":
+			reply.Object = &qservice.SearchObject{Object: value.Raw}
+		case "fields":
+			if value.IsArray() {
+				names = value.Array()
+			}
+		case "objects":
+			value.ForEach(func(_, o gjson.Result) bool {
+				obj, ok := searchObjectOfJSON(o)
+				other = other || !ok
+				reply.Objects = append(reply.Objects, obj)
+				return true
+			})
+		case "ids":
+			value.ForEach(func(_, id gjson.Result) bool {
+				reply.Ids = append(reply.Ids, id.String())
+				return true
+			})
+		case "count":
+			reply.Count = value.Uint()
+		case "cursor":
+			reply.Cursor = value.Uint()
+		case "point":
+			reply.Point = &qservice.Point{
+				Lat: value.Get("lat").Float(),
+				Lon: value.Get("lon").Float(),
+				Z:   value.Get("z").Float(),
+			}
+		case "bounds":
+			reply.Bounds = &qservice.Bounds{
+				MinLat: value.Get("sw.lat").Float(),
+				MinLon: value.Get("sw.lon").Float(),
+				MaxLat: value.Get("ne.lat").Float(),
+				MaxLon: value.Get("ne.lon").Float(),
+			}
+		default:
+			other = true
+		}
+		return true
+	})
+	if reply.Object != nil {
+		// the fields of GET WITHFIELDS
+		res.Get("fields").ForEach(func(name, value gjson.Result) bool {
+			reply.Object.Fields = append(reply.Object.Fields, &qservice.Field{
+				Name:  name.String(),
+				Value: fieldText(value),
+			})
+			return true
+		})
+	}
+	for _, obj := range reply.Objects {
+		// the values of the fields of a search are in the order of the names
+		for i, field := range obj.Fields {
+			if i < len(names) {
+				field.Name = names[i].String()
+			}
+		}
+	}
+	if other {
+		reply.Json = string(data)
+	}
+	return reply
+}
+
+// searchObjectOfJSON returns the object of a search, where the fields are
+// only the values, and false when the object has members that are not in
+// the schema.
+func searchObjectOfJSON(o gjson.Result) (*qservice.SearchObject, bool) {
+	obj := new(qservice.SearchObject)
+	ok := true
+	o.ForEach(func(key, value gjson.Result) bool {
+		switch key.String() {
+		case "id":
+			obj.Id = value.String()
+		case "object":
+			obj.Object = value.Raw
+		case "distance":
+			obj.Distance = value.Float()
+		case "fields":
+			value.ForEach(func(_, value gjson.Result) bool {
+				obj.Fields = append(obj.Fields,
+					&qservice.Field{Value: fieldText(value)})
+				return true
+			})
+		default:
+			ok = false
+		}
+		return true
+	})
+	return obj, ok
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tidwall/tile38/internal/qservice"
+)
+
+func TestReplyOfJSON(t *testing.T) {
+	reply := replyOfJSON([]byte(`{"ok":true,"fields":["speed"],"objects":[` +
+		`{"id":"truck1","object":{"type":"Point","coordinates":[-115,33]},` +
+		`"fields":[90],"distance":10}],"count":1,"cursor":0,"elapsed":"1µs"}`))
+	if !reply.Ok || reply.Count != 1 || len(reply.Objects) != 1 ||
+		reply.Objects[0].Distance != 10 || reply.Json != "" {
+		t.Fatalf("unexpected reply: %v", reply)
+	}
+	if fields := reply.Objects[0].Fields; len(fields) != 1 ||
+		fields[0].Name != "speed" || fields[0].Value != "90" {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+	reply = replyOfJSON([]byte(`{"ok":true,"bounds":{"sw":{"lat":1,"lon":2},` +
+		`"ne":{"lat":3,"lon":4}}}`))
+	if *reply.Bounds != (qservice.Bounds{MinLat: 1, MinLon: 2, MaxLat: 3,
+		MaxLon: 4}) {
+		t.Fatalf("unexpected bounds: %v", reply.Bounds)
+	}
+	// the members that are not in the schema are kept with the json
+	data := `{"ok":true,"hash":"9tbnthxzr"}`
+	if reply = replyOfJSON([]byte(data)); reply.Json != data {
+		t.Fatalf("expected the json, got %v", reply)
+	}
+	reply = replyOfJSON([]byte(`{"command":"set","detect":"inside",` +
+		`"hook":"warehouse","key":"fleet","id":"truck1"}`))
+	if reply.Event == nil || reply.Event.Channel != "warehouse" ||
+		reply.Event.Id != "truck1" {
+		t.Fatalf("unexpected event: %v", reply)
+	}
+	var res qservice.Reply
+	if err := proto.Unmarshal(protobufOfJSON([]byte(`"hello"`)), &res); err != nil ||
+		res.Json != `"hello"` {
+		t.Fatalf("unexpected reply: %v %v", &res, err)
+	}
+}
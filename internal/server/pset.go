@@ -0,0 +1,221 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+// psetKeyword returns true for the arguments that follow the id of an
+// object in a PSET.
+func psetKeyword(arg string) bool {
+	switch strings.ToLower(arg) {
+	case "field", "ex", "nx", "xx", "timestamp",
+		"string", "point", "bounds", "hash", "object":
+		return true
+	}
+	return false
+}
+
+// psetZ returns true when the argument after the longitude of a POINT may
+// be a z coordinate.
+func psetZ(arg string) bool {
+	_, err := strconv.ParseFloat(arg, 64)
+	return err == nil
+}
+
+// psetObjects splits the arguments of a PSET into the SET arguments of each
+// object, which start with the id. A POINT has a z coordinate when the
+// value after its longitude is a number that is followed by a value that is
+// not FIELD, EX, TIMESTAMP, NX, XX, or an object type, since the next
+// object starts with an id that is followed by one of those.
+func psetObjects(vs []string) ([][]string, error) {
+	var objs [][]string
+	for len(vs) > 0 {
+		i := 1
+	options:
+		for i < len(vs) {
+			switch strings.ToLower(vs[i]) {
+			case "field":
+				i += 3
+			case "ex", "timestamp":
+				i += 2
+			case "nx", "xx":
+				i++
+			default:
+				break options
+			}
+		}
+		if i >= len(vs) {
+			return nil, errInvalidNumberOfArguments
+		}
+		var n int
+		switch strings.ToLower(vs[i]) {
+		case "string", "hash", "object":
+			n = 1
+		case "bounds":
+			n = 4
+		case "point":
+			n = 2
+			if len(vs) == i+4 || (len(vs) > i+4 && psetZ(vs[i+3]) &&
+				!psetKeyword(vs[i+4])) {
+				n = 3
+			}
+		default:
+			return nil, errInvalidArgument(vs[i])
+		}
+		i += 1 + n
+		if i > len(vs) {
+			return nil, errInvalidNumberOfArguments
+		}
+		objs = append(objs, vs[:i:i])
+		vs = vs[i:]
+	}
+	return objs, nil
+}
+
+// PSET key id [FIELD name value ...] [EX seconds] [TIMESTAMP time] [NX|XX]
+// type ... [id ...]
+//
+// Sets many objects of a collection at once. The command is applied
+// atomically and is written to the aof as a single command.
+func (s *Server) cmdPset(msg *Message) (res resp.Value, d commandDetails, err error) {
+	return s.pset(msg, func(msg *Message) (resp.Value, commandDetails, error) {
+		return s.cmdSet(msg, true)
+	})
+}
+
+// pset sets the objects of a PSET with the set function, which is a SET or a
+// versioned SET from a peer.
+func (s *Server) pset(msg *Message,
+	set func(msg *Message) (resp.Value, commandDetails, error),
+) (res resp.Value, d commandDetails, err error) {
+	start := time.Now()
+	if len(msg.Args) < 3 {
+		err = errInvalidNumberOfArguments
+		return
+	}
+	if s.config.maxMemory() > 0 && s.outOfMemory.on() {
+		err = errOOM
+		return
+	}
+	key := msg.Args[1]
+	objs, err := psetObjects(msg.Args[2:])
+	if err != nil {
+		return
+	}
+	// Check every object before changing anything, so that an invalid
+	// object fails the whole command.
+	col := s.getCol(key)
+	added := make(map[string]bool)
+	args := make([][]string, len(objs))
+	for i, obj := range objs {
+		args[i] = append([]string{"set", key}, obj...)
+		var cd commandDetails
+		var fields []string
+		var values []field.Value
+		cd, fields, values, _, _, _, _, _, _, err = s.parseSetArgs(args[i][1:])
+		if err != nil {
+			return
+		}
+		if err = s.checkSchema(key, cd.id, fields, values); err != nil {
+			return
+		}
+		// the new objects before this one count toward MAXOBJECTS
+		n := len(added)
+		if added[cd.id] {
+			n--
+		}
+		err = s.checkLimits(key, cd.id, cd.obj, fields, values, n)
+		if err != nil {
+			return
+		}
+		if col == nil {
+			added[cd.id] = true
+		} else if _, _, ok := col.Get(cd.id); !ok {
+			added[cd.id] = true
+		}
+	}
+	d.key = key
+	d.command = "pset"
+	d.parent = true
+	d.timestamp = time.Now()
+	for i := range args {
+		cmsg := *msg
+		cmsg._command = "set"
+		cmsg.Args = args[i]
+		_, cd, cerr := set(&cmsg)
+		if cerr == errIDAlreadyExists || cerr == errIDNotFound {
+			// NX or XX
+			continue
+		}
+		if cerr != nil {
+			err = cerr
+			break
+		}
+		if cd.updated {
+			d.children = append(d.children, &cd)
+		}
+	}
+	d.updated = len(d.children) > 0
+	if err != nil {
+		return
+	}
+	switch msg.OutputType {
+	case JSON:
+		res = resp.StringValue(`{"ok":true,"count":` +
+			strconv.Itoa(len(d.children)) + `,"elapsed":"` +
+			time.Since(start).String() + "\"}")
+	case RESP:
+		res = resp.IntegerValue(len(d.children))
+	}
+	return
+}
+
+// psetArrayArgs returns the arguments of a PSET over HTTP that has a json
+// array as the body, such as
+//
+//	POST /pset+fleet
+//	[["truck1","POINT",33,-115],["truck2","FIELD","speed",10,"OBJECT",{...}]]
+//
+// where each element has the SET arguments of an object after the key.
+// Strings are used as is, and other values as json.
+func psetArrayArgs(path string, body []byte) ([]string, bool, error) {
+	fields := strings.Fields(path)
+	if len(fields) != 2 || strings.ToLower(fields[0]) != "pset" {
+		return nil, false, nil
+	}
+	json := strings.TrimSpace(string(body))
+	if len(json) == 0 || json[0] != '[' {
+		return nil, false, nil
+	}
+	if !gjson.Valid(json) {
+		return nil, true, errors.New("invalid json")
+	}
+	args := fields
+	var err error
+	gjson.Parse(json).ForEach(func(_, obj gjson.Result) bool {
+		if !obj.IsArray() {
+			err = errors.New("invalid json")
+			return false
+		}
+		obj.ForEach(func(_, v gjson.Result) bool {
+			if v.Type == gjson.String {
+				args = append(args, v.Str)
+			} else {
+				args = append(args, v.Raw)
+			}
+			return true
+		})
+		return true
+	})
+	if err != nil {
+		return nil, true, err
+	}
+	return args, true, nil
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPsetObjects(t *testing.T) {
+	tests := []struct {
+		args string
+		objs string
+		err  error
+	}{
+		{"a point 1 2", "[[a point 1 2]]", nil},
+		{"a point 1 2 3", "[[a point 1 2 3]]", nil},
+		{"a point 1 2 b point 3 4", "[[a point 1 2] [b point 3 4]]", nil},
+		{"a point 1 2 3 b point 3 4", "[[a point 1 2 3] [b point 3 4]]", nil},
+		{"a point 1 2 b field x 1 ex 10 nx string c",
+			"[[a point 1 2] [b field x 1 ex 10 nx string c]]", nil},
+		{"a bounds 1 2 3 4 b hash 9q c object {}",
+			"[[a bounds 1 2 3 4] [b hash 9q] [c object {}]]", nil},
+		{"a", "", errInvalidNumberOfArguments},
+		{"a point 1", "", errInvalidNumberOfArguments},
+		{"a field x", "", errInvalidNumberOfArguments},
+		{"a bounds 1 2 3 4 b", "", errInvalidNumberOfArguments},
+		{"a polygon 1 2", "", errInvalidArgument("polygon")},
+		{"a point 1 2 b polygon 1 2", "", errInvalidArgument("polygon")},
+		{"a point 1 2 3 polygon 1 2", "", errInvalidArgument("1")},
+	}
+	for _, tt := range tests {
+		objs, err := psetObjects(strings.Fields(tt.args))
+		if fmt.Sprint(err) != fmt.Sprint(tt.err) {
+			t.Fatalf("%q: expected error %v, got %v", tt.args, tt.err, err)
+		}
+		if err == nil && fmt.Sprint(objs) != tt.objs {
+			t.Fatalf("%q: expected %s, got %v", tt.args, tt.objs, objs)
+		}
+	}
+}
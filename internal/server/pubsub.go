@@ -4,6 +4,7 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -183,9 +184,21 @@ func (s *Server) liveSubscription(
 
 	outputType := msg.OutputType
 	connType := msg.ConnType
+	var ws *webSocketOptions
 	if websocket {
 		outputType = JSON
+		ws = &msg.ws
 	}
+	// the messages are pushed to RESP3 clients
+	resp3 := msg.resp3
+	// the encoding of the json messages, see OUTPUT
+	enc := msg.encoding
+	if websocket {
+		enc = encodingJSON
+	}
+	// the channels of a namespace are prefixed with its name, which the
+	// client does not see
+	ns := msg.namespace
 
 	var start time.Time
 
@@ -194,7 +207,10 @@ func (s *Server) liveSubscription(
 	write := func(data []byte) {
 		writeLock.Lock()
 		defer writeLock.Unlock()
-		writeLiveMessage(conn, data, outputType == JSON, connType, websocket)
+		if outputType == JSON {
+			data = enc.encode(data, msg)
+		}
+		writeLiveMessage(conn, data, outputType == JSON, connType, ws)
 	}
 	writeOK := func() {
 		switch outputType {
@@ -236,7 +252,7 @@ func (s *Server) liveSubscription(
 				`,"num":` + strconv.FormatInt(int64(num), 10) +
 				`,"elapsed":"` + time.Since(start).String() + `"}`))
 		case RESP:
-			b := redcon.AppendArray(nil, 3)
+			b := appendPush(nil, 3, resp3)
 			b = redcon.AppendBulkString(b, command)
 			b = redcon.AppendBulkString(b, channel)
 			b = redcon.AppendInt(b, int64(num))
@@ -255,9 +271,10 @@ func (s *Server) liveSubscription(
 				}
 				write(data)
 			case RESP:
-				b := redcon.AppendArray(nil, 3)
+				b := appendPush(nil, 3, resp3)
 				b = redcon.AppendBulkString(b, "message")
-				b = redcon.AppendBulkString(b, msg.channel)
+				b = redcon.AppendBulkString(b,
+					strings.TrimPrefix(msg.channel, ns))
 				b = redcon.AppendBulkString(b, msg.message)
 				write(b)
 			}
@@ -272,10 +289,12 @@ func (s *Server) liveSubscription(
 				}
 				write(data)
 			case RESP:
-				b := redcon.AppendArray(nil, 4)
+				b := appendPush(nil, 4, resp3)
 				b = redcon.AppendBulkString(b, "pmessage")
-				b = redcon.AppendBulkString(b, msg.pattern)
-				b = redcon.AppendBulkString(b, msg.channel)
+				b = redcon.AppendBulkString(b,
+					strings.TrimPrefix(msg.pattern, ns))
+				b = redcon.AppendBulkString(b,
+					strings.TrimPrefix(msg.channel, ns))
 				b = redcon.AppendBulkString(b, msg.message)
 				write(b)
 			}
@@ -351,7 +370,7 @@ func (s *Server) liveSubscription(
 				writeWrongNumberOfArgsErr(msg.Command())
 			}
 			for i := 1; i < len(msg.Args); i++ {
-				channel := msg.Args[i]
+				channel := ns + msg.Args[i]
 				if un {
 					delete(m[kind], channel)
 					s.pubsub.unregister(kind, channel, target)
@@ -359,7 +378,7 @@ func (s *Server) liveSubscription(
 					m[kind][channel] = true
 					s.pubsub.register(kind, channel, target)
 				}
-				writeSubscribe(msg.Command(), channel, len(m[0])+len(m[1]))
+				writeSubscribe(msg.Command(), msg.Args[i], len(m[0])+len(m[1]))
 			}
 		}
 		var err error
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+// checkQuotas returns an error when a write from a client is over one of the
+// quotas of its namespace, or of the maxkeyrate, maxclientrate,
+// maxkeyobjects, and maxobjectsize properties. The writes of scripts,
+// followers, and peers are not limited.
+// The caller must hold the server lock.
+func (s *Server) checkQuotas(msg *Message, client *Client) error {
+	if err := s.checkNamespaceQuotas(msg); err != nil {
+		return err
+	}
+	keyRate, clientRate, keyObjects, objectSize := s.config.quotas()
+	if keyRate == 0 && clientRate == 0 && keyObjects == 0 && objectSize == 0 {
+		return nil
+	}
+	keys := clusterKeys(msg.Args)
+	sec := time.Now().Unix()
+	if s.keyRates == nil || s.keyRatesSec != sec {
+		s.keyRates = make(map[string]uint64)
+		s.keyRatesSec = sec
+	}
+	if client.writesSec != sec {
+		client.writes = 0
+		client.writesSec = sec
+	}
+	err := func() error {
+		if clientRate > 0 && client.writes >= clientRate {
+			return clientErrorf("quota exceeded, the client is over %d writes "+
+				"per second", clientRate)
+		}
+		if keyRate > 0 {
+			for _, key := range keys {
+				if s.keyRates[key] >= keyRate {
+					return clientErrorf("quota exceeded, the key '%s' is over %d "+
+						"writes per second", key, keyRate)
+				}
+			}
+		}
+		objs, err := s.quotaObjects(msg)
+		if err != nil || len(objs) == 0 {
+			// the error is returned by the command
+			return nil
+		}
+		if keyObjects > 0 {
+			var added uint64
+			ids := make(map[string]bool)
+			col := s.getCol(objs[0].key)
+			for _, obj := range objs {
+				if ids[obj.id] {
+					continue
+				}
+				ids[obj.id] = true
+				if col == nil {
+					added++
+				} else if _, _, ok := col.Get(obj.id); !ok {
+					added++
+				}
+			}
+			var count uint64
+			if col != nil {
+				count = uint64(col.Count())
+			}
+			if added > 0 && count+added > keyObjects {
+				return clientErrorf("quota exceeded, the key '%s' is over %d "+
+					"objects", objs[0].key, keyObjects)
+			}
+		}
+		if objectSize > 0 {
+			for _, obj := range objs {
+				if obj.size > objectSize {
+					return clientErrorf("quota exceeded, the object '%s' is "+
+						"over %s", obj.id, formatMemSize(objectSize))
+				}
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		s.statsQuotaRejected.add(1)
+		return err
+	}
+	client.writes++
+	for _, key := range keys {
+		s.keyRates[key]++
+	}
+	return nil
+}
+
+// quotaObject is an object that is set by a write.
+type quotaObject struct {
+	key, id string
+	size    int64 // size of the object and its fields in bytes
+}
+
+// quotaObjects returns the objects that are set by a SET, PSET, or JSET.
+func (s *Server) quotaObjects(msg *Message) ([]quotaObject, error) {
+	switch msg.Command() {
+	case "set":
+		obj, err := s.quotaSetObject(msg.Args[1:])
+		if err != nil {
+			return nil, err
+		}
+		return []quotaObject{obj}, nil
+	case "pset":
+		if len(msg.Args) < 3 {
+			return nil, errInvalidNumberOfArguments
+		}
+		vss, err := psetObjects(msg.Args[2:])
+		if err != nil {
+			return nil, err
+		}
+		objs := make([]quotaObject, len(vss))
+		for i, vs := range vss {
+			objs[i], err = s.quotaSetObject(append([]string{msg.Args[1]}, vs...))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return objs, nil
+	case "jset":
+		// the size of the document is not known until it's changed
+		if len(msg.Args) < 3 {
+			return nil, errInvalidNumberOfArguments
+		}
+		return []quotaObject{{key: msg.Args[1], id: msg.Args[2]}}, nil
+	}
+	return nil, nil
+}
+
+// quotaSetObject returns the object of the arguments of a SET that follow
+// the command.
+func (s *Server) quotaSetObject(vs []string) (quotaObject, error) {
+	d, fields, values, _, _, _, _, _, _, err := s.parseSetArgs(vs)
+	if err != nil {
+		return quotaObject{}, err
+	}
+	return quotaObject{
+		key:  d.key,
+		id:   d.id,
+		size: objectSize(d.obj, fields, values),
+	}, nil
+}
+
+// objectSize returns the size of an object in bytes, which is the size of
+// its json, or of its string, and of its fields.
+func objectSize(obj geojson.Object, fields []string, values []field.Value) int64 {
+	var size int
+	if objIsSpatial(obj) {
+		size = len(obj.AppendJSON(nil))
+	} else {
+		size = len(obj.String())
+	}
+	for i, name := range fields {
+		size += len(name) + len(values[i].String())
+	}
+	return int64(size)
+}
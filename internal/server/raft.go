@@ -0,0 +1,590 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// Raft mode is enabled when both the raftaddr and raftpeers properties are
+// set. The members of the group elect a leader using the Raft election rules,
+// and the other members follow the leader using the normal aof replication.
+// When the leader becomes unreachable the remaining members elect a new
+// leader and follow it instead.
+//
+// The followers that are members send their raft address to the leader, and
+// the reply to a write is held until a majority of the members, counting the
+// leader, have acknowledged the write, which commits it. A committed write is
+// in the aof of the member that wins the next election, since a member only
+// votes for a candidate whose aof is at least as up to date as its own. A
+// write that is not committed within an election timeout is neither
+// confirmed nor undone, the connection of the client is closed instead, see
+// raftCommit. A leader that returns to the group as a follower has its
+// uncommitted writes truncated to match the new leader.
+//
+// The aof offsets must only move forward for the elections to compare them,
+// so AOFSHRINK is refused in raft mode.
+
+const (
+	raftFollower  = "follower"
+	raftCandidate = "candidate"
+	raftLeader    = "leader"
+
+	// raftHeartbeatInterval is how often the leader contacts its followers.
+	raftHeartbeatInterval = time.Millisecond * 500
+	// raftElectionTimeout is the minimum time that a follower waits to hear
+	// from a leader before starting an election. The actual timeout is
+	// randomized between one and two times this value.
+	raftElectionTimeout = time.Millisecond * 1500
+	// raftRPCTimeout is the maximum time to wait for a reply from a member.
+	raftRPCTimeout = time.Millisecond * 500
+)
+
+// errNotCommitted is the error for the writes that a majority of the
+// members did not acknowledge.
+var errNotCommitted = errors.New("write not committed")
+
+// raftNode is the election state of a server in raft mode. The term and vote
+// are persisted in the config. The server mutex must not be acquired while
+// holding mu.
+type raftNode struct {
+	mu       sync.Mutex
+	role     string    // follower, candidate, or leader
+	leader   string    // the address of the current leader, if known
+	deadline time.Time // when to start an election
+	quorum   time.Time // when the leader last heard from a majority
+	conns    map[string]*RESPConn
+}
+
+// raftReply is the reply from a member to a vote or heartbeat request.
+type raftReply struct {
+	term uint64
+	ok   bool
+}
+
+func raftDeadline() time.Time {
+	return time.Now().Add(raftElectionTimeout +
+		time.Duration(rand.Int63n(int64(raftElectionTimeout))))
+}
+
+// raftMembers returns the address of this server and the addresses of the
+// other members. The peers list may include this server's address, which
+// allows for every member to use the same list.
+func (s *Server) raftMembers() (addr string, peers []string) {
+	addr = s.config.raftAddr()
+	if addr == "" {
+		return "", nil
+	}
+	for _, peer := range s.config.raftPeers() {
+		if peer != addr {
+			peers = append(peers, peer)
+		}
+	}
+	return addr, peers
+}
+
+func (s *Server) raftEnabled() bool {
+	addr, peers := s.raftMembers()
+	return addr != "" && len(peers) > 0
+}
+
+// isLeader returns true when the server accepts writes.
+func (s *Server) isLeader() bool {
+	if s.config.followHost() != "" {
+		return false
+	}
+	if !s.raftEnabled() {
+		return true
+	}
+	s.raft.mu.Lock()
+	defer s.raft.mu.Unlock()
+	return s.raft.role == raftLeader
+}
+
+// notLeaderErr returns the error for a write on a server that is not the
+// leader. In raft mode the error includes the address of the leader, when
+// known, so that the client can redirect the write.
+func (s *Server) notLeaderErr() error {
+	if s.raftEnabled() {
+		s.raft.mu.Lock()
+		leader := s.raft.leader
+		s.raft.mu.Unlock()
+		if leader != "" && leader != s.config.raftAddr() {
+			return fmt.Errorf("%v, leader is %s", errNotLeader, leader)
+		}
+	}
+	return errNotLeader
+}
+
+// raftLogPosition returns the term of the most recent writes in the aof and
+// the replicated offset, which are compared to decide which member has the
+// more up to date data. The offset is the end of the writes that were flushed
+// to the aof, in the offsets of the leader's aof, and unlike the aof size it
+// does not count the prewrite buffer that no follower has received.
+func (s *Server) raftLogPosition() (term uint64, pos int64) {
+	s.mu.RLock()
+	pos = s.backlog.offset()
+	s.mu.RUnlock()
+	return s.config.raftLogTerm(), pos
+}
+
+// raftCommit waits for a majority of the members to acknowledge the writes
+// of a client before its replies are sent. The writes must be flushed to the
+// aof, see flushClientAOF. Returns errNotCommitted when the leader could not
+// reach a majority within an election timeout.
+func (s *Server) raftCommit(client *Client) error {
+	if client.aofpos <= client.raftpos {
+		return nil
+	}
+	client.raftpos = client.aofpos
+	if !s.raftEnabled() {
+		return nil
+	}
+	// the offset of the last flush is at or after the writes of the client
+	s.mu.RLock()
+	offset := s.backlog.offset()
+	s.mu.RUnlock()
+	_, peers := s.raftMembers()
+	timeout := time.NewTimer(raftElectionTimeout)
+	defer timeout.Stop()
+	for {
+		members, changed := s.repl.ackedMembers(offset)
+		acks := 1
+		for _, peer := range peers {
+			if members[peer] {
+				acks++
+			}
+		}
+		if acks*2 > len(peers)+1 {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return errNotCommitted
+		}
+	}
+}
+
+// watchRaft runs elections and sends heartbeats while in raft mode.
+func (s *Server) watchRaft() {
+	t := time.NewTicker(time.Millisecond * 100)
+	defer t.Stop()
+	var lastBeat time.Time
+	for range t.C {
+		if s.stopServer.on() {
+			return
+		}
+		addr, peers := s.raftMembers()
+		s.raft.mu.Lock()
+		if addr == "" || len(peers) == 0 {
+			s.raft.role = ""
+			s.raft.leader = ""
+			s.raft.mu.Unlock()
+			continue
+		}
+		if s.raft.role == "" {
+			s.raft.role = raftFollower
+			s.raft.deadline = raftDeadline()
+		}
+		role, deadline := s.raft.role, s.raft.deadline
+		s.raft.mu.Unlock()
+		if role == raftLeader {
+			if time.Since(lastBeat) >= raftHeartbeatInterval {
+				lastBeat = time.Now()
+				s.raftHeartbeat(addr, peers)
+			}
+		} else if time.Now().After(deadline) {
+			if s.raftElection(addr, peers) {
+				lastBeat = time.Now()
+				s.raftHeartbeat(addr, peers)
+			}
+		}
+	}
+}
+
+// raftElection starts a new term and requests votes from the other members.
+// Returns true when this server became the leader.
+func (s *Server) raftElection(addr string, peers []string) bool {
+	if s.aof == nil {
+		return false
+	}
+	lastTerm, lastPos := s.raftLogPosition()
+	s.raft.mu.Lock()
+	term, _ := s.config.raftTerm()
+	term++
+	s.config.setRaftTerm(term, addr)
+	s.config.write(false)
+	s.raft.role = raftCandidate
+	s.raft.leader = ""
+	s.raft.deadline = raftDeadline()
+	s.raft.mu.Unlock()
+	replLog.Debugf("raft: starting election for term %d", term)
+
+	votes := 1
+	for _, r := range s.raftBroadcast(peers, "vote", term, addr, lastTerm, lastPos) {
+		if r.term > term {
+			s.raftStepDown(r.term)
+			return false
+		}
+		if r.ok {
+			votes++
+		}
+	}
+	if votes*2 <= len(peers)+1 {
+		return false
+	}
+
+	// Stop following the old leader before accepting writes.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.raft.mu.Lock()
+	defer s.raft.mu.Unlock()
+	if cur, _ := s.config.raftTerm(); cur != term || s.raft.role != raftCandidate {
+		return false
+	}
+	if s.config.followHost() != "" {
+		s.config.setFollowHost("")
+		s.config.setFollowPort(0)
+		s.followc.add(1)
+	}
+	s.config.setRaftLogTerm(term)
+	s.config.write(false)
+	s.raft.role = raftLeader
+	s.raft.leader = addr
+	s.raft.quorum = time.Now()
+	replLog.Infof("raft: elected leader for term %d", term)
+	return true
+}
+
+// raftHeartbeat asserts leadership over the other members. The leader steps
+// down when it has not heard from a majority for an election timeout, which
+// stops a leader on the minority side of a network partition from accepting
+// writes.
+func (s *Server) raftHeartbeat(addr string, peers []string) {
+	term, _ := s.config.raftTerm()
+	acks := 1
+	for _, r := range s.raftBroadcast(peers, "heartbeat", term, addr) {
+		if r.term > term {
+			s.raftStepDown(r.term)
+			return
+		}
+		if r.ok {
+			acks++
+		}
+	}
+	s.raft.mu.Lock()
+	defer s.raft.mu.Unlock()
+	if s.raft.role != raftLeader {
+		return
+	}
+	if acks*2 > len(peers)+1 {
+		s.raft.quorum = time.Now()
+	} else if time.Since(s.raft.quorum) > raftElectionTimeout {
+		replLog.Warnf("raft: lost contact with the majority, stepping down")
+		s.raft.role = raftFollower
+		s.raft.leader = ""
+		s.raft.deadline = raftDeadline()
+	}
+}
+
+// raftStepDown moves to a newer term as a follower.
+func (s *Server) raftStepDown(term uint64) {
+	s.raft.mu.Lock()
+	defer s.raft.mu.Unlock()
+	if cur, _ := s.config.raftTerm(); term <= cur {
+		return
+	}
+	s.config.setRaftTerm(term, "")
+	s.config.write(false)
+	if s.raft.role == raftLeader {
+		replLog.Infof("raft: stepping down for term %d", term)
+	}
+	s.raft.role = raftFollower
+	s.raft.leader = ""
+	s.raft.deadline = raftDeadline()
+}
+
+// raftFollow makes the server follow the leader at addr.
+func (s *Server) raftFollow(addr string, term uint64) {
+	host, sport, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	port, _ := strconv.Atoi(sport)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.aof == nil {
+		return
+	}
+	if s.config.followHost() == host && s.config.followPort() == port {
+		// The writes of a term are only in the aof after catching up.
+		if s.fcup && s.config.raftLogTerm() != term {
+			s.config.setRaftLogTerm(term)
+			s.config.write(false)
+		}
+		return
+	}
+	s.config.setFollowHost(host)
+	s.config.setFollowPort(port)
+	s.config.setFollowKeys(nil)
+	s.config.write(false)
+	s.followc.add(1)
+	replLog.Infof("raft: following leader %s", addr)
+	go s.follow(host, port, s.followc.get())
+}
+
+// raftBroadcast sends a raft request to all peers and returns the replies
+// from the peers that responded.
+func (s *Server) raftBroadcast(peers []string, args ...interface{}) []raftReply {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var replies []raftReply
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			r, err := s.raftCall(peer, args...)
+			if err != nil {
+				replLog.Debugf("raft: %s: %v", peer, err)
+				return
+			}
+			mu.Lock()
+			replies = append(replies, r)
+			mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+	return replies
+}
+
+// raftCall sends a raft request to a peer. Connections are reused between
+// calls.
+func (s *Server) raftCall(peer string, args ...interface{}) (r raftReply, err error) {
+	s.raft.mu.Lock()
+	conn := s.raft.conns[peer]
+	delete(s.raft.conns, peer)
+	s.raft.mu.Unlock()
+	if conn == nil {
+		conn, err = DialTimeout(peer, raftRPCTimeout)
+		if err != nil {
+			return r, err
+		}
+		if auth := s.config.leaderAuth(); auth != "" {
+			conn.conn.SetDeadline(time.Now().Add(raftRPCTimeout))
+			if err := s.followDoLeaderAuth(conn, auth); err != nil {
+				conn.Close()
+				return r, err
+			}
+		}
+	}
+	conn.conn.SetDeadline(time.Now().Add(raftRPCTimeout))
+	v, err := conn.Do("raft", args...)
+	if err != nil {
+		conn.Close()
+		return r, err
+	}
+	s.raft.mu.Lock()
+	if s.raft.conns == nil {
+		s.raft.conns = make(map[string]*RESPConn)
+	}
+	s.raft.conns[peer] = conn
+	s.raft.mu.Unlock()
+	if v.Error() != nil {
+		return r, v.Error()
+	}
+	arr := v.Array()
+	if len(arr) != 2 {
+		return r, errors.New("invalid raft reply")
+	}
+	return raftReply{term: uint64(arr[0].Integer()), ok: arr[1].Integer() == 1}, nil
+}
+
+// raftVote handles a vote request from a candidate. The vote is granted when
+// this server has not voted for another candidate in the term, and the data
+// of the candidate is at least as up to date as its own.
+func (s *Server) raftVote(term uint64, candidate string, lastTerm uint64,
+	lastPos int64,
+) raftReply {
+	myTerm, myPos := s.raftLogPosition()
+	s.raft.mu.Lock()
+	defer s.raft.mu.Unlock()
+	cur, vote := s.config.raftTerm()
+	if term < cur {
+		return raftReply{term: cur}
+	}
+	if term > cur {
+		if s.raft.role == raftLeader {
+			replLog.Infof("raft: stepping down for term %d", term)
+		}
+		cur, vote = term, ""
+		s.raft.role = raftFollower
+		s.raft.leader = ""
+	}
+	upToDate := lastTerm > myTerm || (lastTerm == myTerm && lastPos >= myPos)
+	if (vote != "" && vote != candidate) || !upToDate {
+		s.config.setRaftTerm(cur, vote)
+		s.config.write(false)
+		return raftReply{term: cur}
+	}
+	s.config.setRaftTerm(cur, candidate)
+	s.config.write(false)
+	s.raft.deadline = raftDeadline()
+	return raftReply{term: cur, ok: true}
+}
+
+// raftHeartbeatReceived handles a heartbeat from a leader.
+func (s *Server) raftHeartbeatReceived(term uint64, leader string) raftReply {
+	s.raft.mu.Lock()
+	cur, _ := s.config.raftTerm()
+	if term < cur {
+		s.raft.mu.Unlock()
+		return raftReply{term: cur}
+	}
+	if term > cur {
+		s.config.setRaftTerm(term, "")
+		s.config.write(false)
+	}
+	s.raft.role = raftFollower
+	s.raft.leader = leader
+	s.raft.deadline = raftDeadline()
+	s.raft.mu.Unlock()
+	s.raftFollow(leader, term)
+	return raftReply{term: term, ok: true}
+}
+
+// RAFT VOTE term candidate lastterm lastpos
+// RAFT HEARTBEAT term leader
+// RAFT STATE
+func (s *Server) cmdRaft(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	var subcmd string
+	if vs, subcmd, ok = tokenval(vs); !ok {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	subcmd = strings.ToLower(subcmd)
+	if subcmd == "state" {
+		if len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		return s.raftState(msg, start), nil
+	}
+	if subcmd != "vote" && subcmd != "heartbeat" {
+		return NOMessage, errInvalidArgument(subcmd)
+	}
+	if !s.raftEnabled() {
+		return NOMessage, errors.New("raft disabled")
+	}
+	var sterm string
+	if vs, sterm, ok = tokenval(vs); !ok {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	term, err := strconv.ParseUint(sterm, 10, 64)
+	if err != nil {
+		return NOMessage, errInvalidArgument(sterm)
+	}
+	var addr string
+	if vs, addr, ok = tokenval(vs); !ok {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if !validHostPort(addr) {
+		return NOMessage, errInvalidArgument(addr)
+	}
+	var r raftReply
+	if subcmd == "vote" {
+		var slastTerm, slastPos string
+		if vs, slastTerm, ok = tokenval(vs); !ok {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		if vs, slastPos, ok = tokenval(vs); !ok {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		lastTerm, err := strconv.ParseUint(slastTerm, 10, 64)
+		if err != nil {
+			return NOMessage, errInvalidArgument(slastTerm)
+		}
+		lastPos, err := strconv.ParseInt(slastPos, 10, 64)
+		if err != nil || lastPos < 0 {
+			return NOMessage, errInvalidArgument(slastPos)
+		}
+		if len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		r = s.raftVote(term, addr, lastTerm, lastPos)
+	} else {
+		if len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		r = s.raftHeartbeatReceived(term, addr)
+	}
+	switch msg.OutputType {
+	case JSON:
+		res = resp.StringValue(`{"ok":true,"term":` +
+			strconv.FormatUint(r.term, 10) + `,"granted":` +
+			strconv.FormatBool(r.ok) + `,"elapsed":"` +
+			time.Since(start).String() + "\"}")
+	case RESP:
+		var granted int
+		if r.ok {
+			granted = 1
+		}
+		res = resp.ArrayValue([]resp.Value{
+			resp.IntegerValue(int(r.term)),
+			resp.IntegerValue(granted),
+		})
+	}
+	return res, nil
+}
+
+func (s *Server) raftState(msg *Message, start time.Time) resp.Value {
+	addr, peers := s.raftMembers()
+	term, vote := s.config.raftTerm()
+	s.raft.mu.Lock()
+	role, leader := s.raft.role, s.raft.leader
+	s.raft.mu.Unlock()
+	if addr == "" || len(peers) == 0 {
+		role = "disabled"
+	}
+	m := map[string]interface{}{
+		"role":   role,
+		"term":   term,
+		"vote":   vote,
+		"leader": leader,
+		"addr":   addr,
+		"peers":  strings.Join(peers, ","),
+	}
+	if msg.OutputType == JSON {
+		var buf []byte
+		buf = append(buf, `{"ok":true,"role":`...)
+		buf = appendJSONString(buf, role)
+		buf = append(buf, `,"term":`...)
+		buf = strconv.AppendUint(buf, term, 10)
+		buf = append(buf, `,"vote":`...)
+		buf = appendJSONString(buf, vote)
+		buf = append(buf, `,"leader":`...)
+		buf = appendJSONString(buf, leader)
+		buf = append(buf, `,"addr":`...)
+		buf = appendJSONString(buf, addr)
+		buf = append(buf, `,"peers":[`...)
+		for i, peer := range peers {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, peer)
+		}
+		buf = append(buf, `],"elapsed":"`...)
+		buf = append(buf, time.Since(start).String()...)
+		buf = append(buf, `"}`...)
+		return resp.StringValue(string(buf))
+	}
+	return resp.ArrayValue(respValuesSimpleMap(m))
+}
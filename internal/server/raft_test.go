@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRaftCommit(t *testing.T) {
+	s := newSnapshotTestServer()
+	for _, kv := range [][2]string{
+		{RaftAddr, "10.0.0.1:9851"},
+		{RaftPeers, "10.0.0.1:9851,10.0.0.2:9851,10.0.0.3:9851"},
+	} {
+		if err := s.config.setProperty(kv[0], kv[1], false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.backlog.reset(100, false)
+	s.backlog.write(make([]byte, 100), 1024)
+	c1, c2 := &net.TCPConn{}, &net.TCPConn{}
+	s.repl.add(c1, 0, "")
+	s.repl.add(c2, 0, "10.0.0.2:9851")
+
+	// no writes, nothing to commit
+	client := &Client{}
+	if err := s.raftCommit(client); err != nil {
+		t.Fatal(err)
+	}
+
+	// the follower that is not a member does not count
+	client.aofpos = 10
+	s.repl.ack(c1, 200)
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		s.repl.ack(c2, 200)
+	}()
+	start := time.Now()
+	if err := s.raftCommit(client); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < time.Millisecond*10 {
+		t.Fatal("expected to wait for the member")
+	}
+
+	// the writes of the member are behind
+	client.aofpos = 20
+	s.backlog.write(make([]byte, 100), 1024)
+	if err := s.raftCommit(client); err != errNotCommitted {
+		t.Fatalf("expected %v, got %v", errNotCommitted, err)
+	}
+}
@@ -17,27 +17,37 @@ func (s *Server) cmdReadOnly(msg *Message) (res resp.Value, err error) {
 	if vs, arg, ok = tokenval(vs); !ok || arg == "" {
 		return NOMessage, errInvalidNumberOfArguments
 	}
+	// NOSAVE changes the mode until the next restart, leaving the mode that
+	// is saved to the config file as it was.
+	save := true
+	if len(vs) > 0 {
+		var nosave string
+		if vs, nosave, _ = tokenval(vs); strings.ToLower(nosave) != "nosave" {
+			return NOMessage, errInvalidArgument(nosave)
+		}
+		save = false
+	}
 	if len(vs) != 0 {
 		return NOMessage, errInvalidNumberOfArguments
 	}
-	update := false
+	var v bool
 	switch strings.ToLower(arg) {
 	default:
 		return NOMessage, errInvalidArgument(arg)
 	case "yes":
-		if !s.config.readOnly() {
-			update = true
-			s.config.setReadOnly(true)
-			log.Info("read only")
-		}
+		v = true
 	case "no":
-		if s.config.readOnly() {
-			update = true
-			s.config.setReadOnly(false)
+		v = false
+	}
+	if v != s.config.readOnly() {
+		if v {
+			log.Info("read only")
+		} else {
 			log.Info("read write")
 		}
 	}
-	if update {
+	s.config.setReadOnly(v, save)
+	if save {
 		s.config.write(false)
 	}
 	return OKMessage(msg, start), nil
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/log"
+)
+
+var errReindexing = errors.New("the index of the key is being rebuilt")
+
+// REINDEX key
+//
+// Rebuilds the spatial index of a key in the background, such as after many
+// of its objects were deleted, or after a large import. The new index is
+// packed from a snapshot of the collection, while the key is searched and
+// written as usual, and takes the place of the old index when it's done.
+// STATS key EXT shows when a rebuild is in progress.
+func (s *Server) cmdReindex(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var key string
+	var ok bool
+	if vs, key, ok = tokenval(vs); !ok || key == "" || len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	l := s.mu.lockKey(key, true)
+	col := s.getCol(key)
+	var r *collection.Rebuild
+	if col != nil {
+		r = col.BeginRebuild()
+	}
+	l.unlock()
+	if col == nil {
+		return NOMessage, errKeyNotFound
+	}
+	if r == nil {
+		return NOMessage, errReindexing
+	}
+	go s.rebuildIndex(key, col, r)
+	return OKMessage(msg, start), nil
+}
+
+// rebuildIndex builds the new index of a collection without the locks, and
+// swaps it in with the lock of the key.
+func (s *Server) rebuildIndex(key string, col *collection.Collection,
+	r *collection.Rebuild,
+) {
+	start := time.Now()
+	r.Build()
+	l := s.mu.lockKey(key, true)
+	defer l.unlock()
+	if s.getCol(key) != col {
+		// the key was dropped, or replaced, while the index was built
+		r.Abort()
+		return
+	}
+	r.End()
+	log.Infof("reindex %s: %v", key, time.Since(start))
+}
@@ -0,0 +1,680 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// replOffsets tracks the progress of replication. On a leader it has the aof
+// offset that each follower has acknowledged, and on a follower it has the
+// aof offset of the last write that was received from the leader. The aof of
+// a follower is a copy of the leader's aof, so the offsets of both are the
+// same for a given write.
+type replOffsets struct {
+	mu      sync.Mutex
+	acks    map[net.Conn]*replFollower
+	writes  []replWrite // leader, writes that a follower has not acknowledged
+	applied int64
+	changed chan struct{} // closed when an offset changes
+
+	// follower
+	linked     bool      // connected to the leader
+	received   time.Time // when the last write was received
+	ackSent    time.Time // when the last acknowledgement was sent
+	leaderSize int64     // the leader's aof size, polled every second
+	synced     time.Time // when the follower last had all of the leader's aof
+}
+
+// replFollower is a follower that is connected to the leader.
+type replFollower struct {
+	addr   string    // remote address
+	member string    // raft address, when the follower is a raft member
+	offset int64     // last acknowledged offset
+	acked  time.Time // when the last acknowledgement was received
+}
+
+// replWrite is the time that the leader's aof grew to the offset.
+type replWrite struct {
+	offset int64
+	at     time.Time
+}
+
+const (
+	// replWriteInterval is the resolution of the write times that are used
+	// for the seconds that a follower is behind.
+	replWriteInterval = time.Millisecond * 10
+	// replMaxWrites limits the write times that are kept for a follower that
+	// is far behind, after which its seconds behind are underestimated.
+	replMaxWrites = 100000
+)
+
+func (r *replOffsets) notify() {
+	if r.changed != nil {
+		close(r.changed)
+		r.changed = nil
+	}
+}
+
+func (r *replOffsets) wait() <-chan struct{} {
+	if r.changed == nil {
+		r.changed = make(chan struct{})
+	}
+	return r.changed
+}
+
+func remoteAddr(conn net.Conn) string {
+	if addr := conn.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// add adds a follower that has everything before the offset. The member is
+// the raft address of the follower, or empty when it's not a raft member.
+func (r *replOffsets) add(conn net.Conn, offset int64, member string) {
+	r.mu.Lock()
+	if r.acks == nil {
+		r.acks = make(map[net.Conn]*replFollower)
+	}
+	r.acks[conn] = &replFollower{
+		addr:   remoteAddr(conn),
+		member: member,
+		offset: offset,
+	}
+	r.notify()
+	r.mu.Unlock()
+}
+
+// ack sets the offset that a follower has acknowledged.
+func (r *replOffsets) ack(conn net.Conn, offset int64) {
+	r.mu.Lock()
+	f := r.acks[conn]
+	if f == nil {
+		if r.acks == nil {
+			r.acks = make(map[net.Conn]*replFollower)
+		}
+		f = &replFollower{addr: remoteAddr(conn)}
+		r.acks[conn] = f
+	}
+	f.offset = offset
+	f.acked = time.Now()
+	r.trim()
+	r.notify()
+	r.mu.Unlock()
+}
+
+// remove removes a follower that has disconnected.
+func (r *replOffsets) remove(conn net.Conn) {
+	r.mu.Lock()
+	delete(r.acks, conn)
+	r.trim()
+	r.mu.Unlock()
+}
+
+// wrote records the time that the leader's aof grew to the offset, while
+// there are followers.
+func (r *replOffsets) wrote(offset int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.acks) == 0 {
+		return
+	}
+	now := time.Now()
+	if n := len(r.writes); n > 0 {
+		last := &r.writes[n-1]
+		if offset <= last.offset {
+			return
+		}
+		if now.Sub(last.at) < replWriteInterval {
+			// the earlier time is kept, which overestimates by no more
+			// than the interval
+			last.offset = offset
+			return
+		}
+	}
+	if len(r.writes) == replMaxWrites {
+		r.writes = append(r.writes[:0], r.writes[1:]...)
+	}
+	r.writes = append(r.writes, replWrite{offset, now})
+}
+
+// trim removes the writes that every follower has acknowledged.
+func (r *replOffsets) trim() {
+	if len(r.acks) == 0 {
+		r.writes = nil
+		return
+	}
+	min := int64(-1)
+	for _, f := range r.acks {
+		if min == -1 || f.offset < min {
+			min = f.offset
+		}
+	}
+	i := sort.Search(len(r.writes), func(i int) bool {
+		return r.writes[i].offset > min
+	})
+	if i > 0 {
+		r.writes = append(r.writes[:0], r.writes[i:]...)
+	}
+}
+
+// behind returns how long ago the oldest write that is not in the offset
+// was written, or zero when the offset has every write.
+func (r *replOffsets) behind(offset int64, now time.Time) time.Duration {
+	i := sort.Search(len(r.writes), func(i int) bool {
+		return r.writes[i].offset > offset
+	})
+	if i == len(r.writes) {
+		return 0
+	}
+	return now.Sub(r.writes[i].at)
+}
+
+// acked returns the number of followers that have acknowledged the offset,
+// and a channel that is closed on the next acknowledgement.
+func (r *replOffsets) acked(offset int64) (n int, changed <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.acks {
+		if f.offset >= offset {
+			n++
+		}
+	}
+	return n, r.wait()
+}
+
+// ackedMembers returns the raft addresses of the followers that have
+// acknowledged the offset, and a channel that is closed on the next
+// acknowledgement.
+func (r *replOffsets) ackedMembers(offset int64) (members map[string]bool,
+	changed <-chan struct{},
+) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	members = make(map[string]bool)
+	for _, f := range r.acks {
+		if f.member != "" && f.offset >= offset {
+			members[f.member] = true
+		}
+	}
+	return members, r.wait()
+}
+
+// setApplied sets the offset of the last write received from the leader.
+func (r *replOffsets) setApplied(offset int64) {
+	r.mu.Lock()
+	r.received = time.Now()
+	if offset >= r.leaderSize {
+		r.synced = r.received
+	}
+	if r.applied != offset {
+		r.applied = offset
+		r.notify()
+	}
+	r.mu.Unlock()
+}
+
+// appliedOffset returns the offset of the last write received from the
+// leader, and a channel that is closed when the offset changes.
+func (r *replOffsets) appliedOffset() (offset int64, changed <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.applied, r.wait()
+}
+
+// setLinked sets whether a follower is connected to the leader.
+func (r *replOffsets) setLinked(linked bool) {
+	r.mu.Lock()
+	r.linked = linked
+	r.mu.Unlock()
+}
+
+// setLeaderSize sets the leader's aof size, as seen by a follower.
+func (r *replOffsets) setLeaderSize(size int64) {
+	r.mu.Lock()
+	r.leaderSize = size
+	if r.applied >= size {
+		r.synced = time.Now()
+	}
+	r.mu.Unlock()
+}
+
+// setAckSent sets when a follower last sent an acknowledgement.
+func (r *replOffsets) setAckSent() {
+	r.mu.Lock()
+	r.ackSent = time.Now()
+	r.mu.Unlock()
+}
+
+// followAck sends the offset of the last received write back to the leader
+// until done is closed. The leader uses the offsets for the WAIT command.
+func (s *Server) followAck(conn *RESPConn, done <-chan struct{}) {
+	acked := int64(-1)
+	for {
+		offset, changed := s.repl.appliedOffset()
+		if offset != acked {
+			err := conn.wr.WriteMultiBulk("replconf", "ack",
+				strconv.FormatInt(offset, 10))
+			if err != nil {
+				return
+			}
+			acked = offset
+			s.repl.setAckSent()
+		}
+		select {
+		case <-changed:
+		case <-done:
+			return
+		}
+	}
+}
+
+// followPollLeader polls the leader's aof size every second until done is
+// closed. The size is compared to the offset of the follower for the
+// replication lag. A follower with followerhooks also renews the lease of the
+// follower hooks.
+func (s *Server) followPollLeader(addr, auth string, useTLS bool,
+	done <-chan struct{},
+) {
+	var conn *RESPConn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-done:
+			return
+		}
+		if conn == nil {
+			var err error
+			conn, err = s.followDial(addr, auth, useTLS)
+			if err != nil {
+				continue
+			}
+		}
+		conn.conn.SetDeadline(time.Now().Add(time.Second * 2))
+		m, err := doServer(conn)
+		if err != nil {
+			conn.Close()
+			conn = nil
+			continue
+		}
+		if size, err := strconv.ParseInt(m["aof_size"], 10, 64); err == nil {
+			s.repl.setLeaderSize(size)
+		}
+		if s.config.followerHooks() && len(s.config.followKeys()) == 0 {
+			// renew the lease of the follower hooks, which is written to
+			// the aof by the leader when it's given to this follower
+			_, err := conn.Do("replconf", "hooklease", s.config.serverID())
+			if err != nil {
+				conn.Close()
+				conn = nil
+			}
+		}
+	}
+}
+
+// parseWaitTimeout parses a timeout in milliseconds, where zero is no
+// timeout.
+func parseWaitTimeout(s string) (time.Duration, error) {
+	n, err := strconv.ParseUint(s, 10, 63)
+	if err != nil {
+		return 0, errInvalidArgument(s)
+	}
+	return time.Duration(n) * time.Millisecond, nil
+}
+
+// waitTimer returns a channel that fires after the timeout, or never when the
+// timeout is zero.
+func waitTimer(timeout time.Duration) (<-chan time.Time, func()) {
+	if timeout == 0 {
+		return nil, func() {}
+	}
+	t := time.NewTimer(timeout)
+	return t.C, func() { t.Stop() }
+}
+
+// WAIT numreplicas timeout
+//
+// Blocks until all of the writes that came before it have been received by
+// at least numreplicas followers, or until the timeout in milliseconds. A
+// timeout of zero blocks forever. Returns the number of followers that have
+// received the writes, which may be less than numreplicas on a timeout.
+func (s *Server) cmdWait(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	if len(vs) != 2 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	numreplicas, err := strconv.ParseUint(vs[0], 10, 32)
+	if err != nil {
+		return NOMessage, errInvalidArgument(vs[0])
+	}
+	timeout, err := parseWaitTimeout(vs[1])
+	if err != nil {
+		return NOMessage, err
+	}
+	if s.aof == nil {
+		return NOMessage, errors.New("aof disabled")
+	}
+	s.mu.Lock()
+	if s.config.followHost() != "" {
+		s.mu.Unlock()
+		return NOMessage, errors.New("WAIT cannot be used with a follower")
+	}
+	// The writes are sent to the followers once they are in the aof file.
+	s.flushAOF(false)
+	offset := int64(s.aofsz)
+	s.mu.Unlock()
+
+	timer, stop := waitTimer(timeout)
+	defer stop()
+	n, changed := s.repl.acked(offset)
+wait:
+	for n < int(numreplicas) {
+		select {
+		case <-changed:
+			n, changed = s.repl.acked(offset)
+		case <-timer:
+			break wait
+		}
+	}
+	switch msg.OutputType {
+	case JSON:
+		res = resp.StringValue(`{"ok":true,"replicas":` + strconv.Itoa(n) +
+			`,"offset":` + strconv.FormatInt(offset, 10) +
+			`,"elapsed":"` + time.Since(start).String() + "\"}")
+	case RESP:
+		res = resp.IntegerValue(n)
+	}
+	return res, nil
+}
+
+// WAITOFFSET offset timeout
+//
+// Blocks on a follower until it has received the writes from the leader up
+// to the aof offset, or until the timeout in milliseconds. A timeout of zero
+// blocks forever. The offset of a write is the leader's aof_size, as shown by
+// SERVER or WAIT, after the write. Reading from a follower after WAITOFFSET
+// returns will include the write. Returns the offset of the follower.
+func (s *Server) cmdWaitOffset(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	if len(vs) != 2 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	target, err := strconv.ParseInt(vs[0], 10, 64)
+	if err != nil || target < 0 {
+		return NOMessage, errInvalidArgument(vs[0])
+	}
+	timeout, err := parseWaitTimeout(vs[1])
+	if err != nil {
+		return NOMessage, err
+	}
+	s.mu.RLock()
+	following := s.config.followHost() != ""
+	offset := int64(s.aofsz)
+	s.mu.RUnlock()
+	if following {
+		// Wait for the leader's writes to arrive.
+		timer, stop := waitTimer(timeout)
+		defer stop()
+		var changed <-chan struct{}
+		offset, changed = s.repl.appliedOffset()
+		for offset < target {
+			select {
+			case <-changed:
+				offset, changed = s.repl.appliedOffset()
+			case <-timer:
+				return NOMessage, errors.New("timeout")
+			}
+		}
+	}
+	switch msg.OutputType {
+	case JSON:
+		res = resp.StringValue(`{"ok":true,"offset":` +
+			strconv.FormatInt(offset, 10) +
+			`,"elapsed":"` + time.Since(start).String() + "\"}")
+	case RESP:
+		res = resp.IntegerValue(int(offset))
+	}
+	return res, nil
+}
+
+// replLag is the replication lag of a follower. The durations are negative
+// when unknown.
+type replLag struct {
+	addr     string
+	offset   int64         // offset of the follower
+	lagBytes int64         // bytes of the leader's aof not yet received
+	behind   time.Duration // age of the oldest write not yet received
+	lastAck  time.Duration // time since the last acknowledgement
+	lastIO   time.Duration // follower, time since the last received write
+}
+
+func sinceOrNone(t, now time.Time) time.Duration {
+	if t.IsZero() {
+		return -1
+	}
+	return now.Sub(t)
+}
+
+// followerLags returns the lag of each follower of a leader with the aof
+// size, ordered by address.
+func (r *replOffsets) followerLags(size int64) []replLag {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	lags := make([]replLag, 0, len(r.acks))
+	for _, f := range r.acks {
+		lag := replLag{
+			addr:    f.addr,
+			offset:  f.offset,
+			behind:  r.behind(f.offset, now),
+			lastAck: sinceOrNone(f.acked, now),
+			lastIO:  -1,
+		}
+		if f.offset < size {
+			lag.lagBytes = size - f.offset
+		}
+		lags = append(lags, lag)
+	}
+	sort.Slice(lags, func(i, j int) bool {
+		return lags[i].addr < lags[j].addr
+	})
+	return lags
+}
+
+// leaderLag returns the lag of a follower, and whether it is connected to
+// the leader.
+func (r *replOffsets) leaderLag() (lag replLag, linked bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	lag = replLag{
+		offset:  r.applied,
+		lastAck: sinceOrNone(r.ackSent, now),
+		lastIO:  sinceOrNone(r.received, now),
+	}
+	if r.applied < r.leaderSize {
+		lag.lagBytes = r.leaderSize - r.applied
+		lag.behind = sinceOrNone(r.synced, now)
+	}
+	return lag, r.linked
+}
+
+// replMaxLag returns the lag of a follower, or the largest lag of the
+// followers of a leader, where each value is the largest of any follower.
+func (s *Server) replMaxLag() replLag {
+	if s.config.followHost() != "" {
+		lag, _ := s.repl.leaderLag()
+		return lag
+	}
+	max := replLag{offset: int64(s.aofsz), lastAck: -1}
+	for _, lag := range s.repl.followerLags(max.offset) {
+		if lag.lagBytes > max.lagBytes {
+			max.lagBytes = lag.lagBytes
+		}
+		if lag.behind > max.behind {
+			max.behind = lag.behind
+		}
+		if lag.lastAck > max.lastAck {
+			max.lastAck = lag.lastAck
+		}
+	}
+	return max
+}
+
+func wholeSeconds(d time.Duration) int {
+	if d < 0 {
+		return -1
+	}
+	return int(d.Seconds())
+}
+
+func seconds(d time.Duration) float64 {
+	if d < 0 {
+		return -1
+	}
+	return d.Seconds()
+}
+
+func appendSeconds(b []byte, d time.Duration) []byte {
+	if d < 0 {
+		return append(b, "-1"...)
+	}
+	return strconv.AppendFloat(b, d.Seconds(), 'f', 3, 64)
+}
+
+func (lag replLag) appendJSON(buf []byte) []byte {
+	buf = append(buf, `{"addr":`...)
+	buf = appendJSONString(buf, lag.addr)
+	buf = append(buf, `,"offset":`...)
+	buf = strconv.AppendInt(buf, lag.offset, 10)
+	buf = append(buf, `,"lag_bytes":`...)
+	buf = strconv.AppendInt(buf, lag.lagBytes, 10)
+	buf = append(buf, `,"seconds_behind":`...)
+	buf = appendSeconds(buf, lag.behind)
+	buf = append(buf, `,"last_ack":`...)
+	buf = appendSeconds(buf, lag.lastAck)
+	return append(buf, '}')
+}
+
+func (lag replLag) respValue() resp.Value {
+	return resp.ArrayValue([]resp.Value{
+		resp.StringValue("addr"), resp.StringValue(lag.addr),
+		resp.StringValue("offset"), resp.IntegerValue(int(lag.offset)),
+		resp.StringValue("lag_bytes"), resp.IntegerValue(int(lag.lagBytes)),
+		resp.StringValue("seconds_behind"),
+		resp.StringValue(string(appendSeconds(nil, lag.behind))),
+		resp.StringValue("last_ack"),
+		resp.StringValue(string(appendSeconds(nil, lag.lastAck))),
+	})
+}
+
+// REPLICA INFO
+//
+// Returns the replication lag. On a leader it has the offset that each
+// follower has acknowledged, the bytes of the aof that the follower has not
+// received, the age of the oldest write that the follower has not received,
+// and the seconds since the follower last acknowledged. On a follower it has
+// the same for the follower itself, using the leader's aof size that is
+// polled every second, and the seconds since a write was last received.
+func (s *Server) cmdReplica(msg *Message) (res resp.Value, err error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	var subcmd string
+	if vs, subcmd, ok = tokenval(vs); !ok {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if strings.ToLower(subcmd) != "info" {
+		return NOMessage, errInvalidArgument(subcmd)
+	}
+	if len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if host := s.config.followHost(); host != "" {
+		lag, linked := s.repl.leaderLag()
+		lag.addr = fmt.Sprintf("%s:%d", host, s.config.followPort())
+		leaderOffset := lag.offset + lag.lagBytes
+		if msg.OutputType == JSON {
+			var buf []byte
+			buf = append(buf, `{"ok":true,"role":"follower","leader":`...)
+			buf = appendJSONString(buf, lag.addr)
+			buf = append(buf, `,"linked":`...)
+			buf = strconv.AppendBool(buf, linked)
+			buf = append(buf, `,"offset":`...)
+			buf = strconv.AppendInt(buf, lag.offset, 10)
+			buf = append(buf, `,"leader_offset":`...)
+			buf = strconv.AppendInt(buf, leaderOffset, 10)
+			buf = append(buf, `,"lag_bytes":`...)
+			buf = strconv.AppendInt(buf, lag.lagBytes, 10)
+			buf = append(buf, `,"seconds_behind":`...)
+			buf = appendSeconds(buf, lag.behind)
+			buf = append(buf, `,"last_io":`...)
+			buf = appendSeconds(buf, lag.lastIO)
+			buf = append(buf, `,"last_ack":`...)
+			buf = appendSeconds(buf, lag.lastAck)
+			buf = append(buf, `,"elapsed":"`...)
+			buf = append(buf, time.Since(start).String()...)
+			buf = append(buf, `"}`...)
+			return resp.StringValue(string(buf)), nil
+		}
+		return resp.ArrayValue([]resp.Value{
+			resp.StringValue("role"), resp.StringValue("follower"),
+			resp.StringValue("leader"), resp.StringValue(lag.addr),
+			resp.StringValue("linked"), resp.IntegerValue(boolInt(linked)),
+			resp.StringValue("offset"), resp.IntegerValue(int(lag.offset)),
+			resp.StringValue("leader_offset"),
+			resp.IntegerValue(int(leaderOffset)),
+			resp.StringValue("lag_bytes"), resp.IntegerValue(int(lag.lagBytes)),
+			resp.StringValue("seconds_behind"),
+			resp.StringValue(string(appendSeconds(nil, lag.behind))),
+			resp.StringValue("last_io"),
+			resp.StringValue(string(appendSeconds(nil, lag.lastIO))),
+			resp.StringValue("last_ack"),
+			resp.StringValue(string(appendSeconds(nil, lag.lastAck))),
+		}), nil
+	}
+	offset := int64(s.aofsz)
+	lags := s.repl.followerLags(offset)
+	if msg.OutputType == JSON {
+		var buf []byte
+		buf = append(buf, `{"ok":true,"role":"leader","offset":`...)
+		buf = strconv.AppendInt(buf, offset, 10)
+		buf = append(buf, `,"followers":[`...)
+		for i, lag := range lags {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = lag.appendJSON(buf)
+		}
+		buf = append(buf, `],"elapsed":"`...)
+		buf = append(buf, time.Since(start).String()...)
+		buf = append(buf, `"}`...)
+		return resp.StringValue(string(buf)), nil
+	}
+	followers := make([]resp.Value, len(lags))
+	for i, lag := range lags {
+		followers[i] = lag.respValue()
+	}
+	return resp.ArrayValue([]resp.Value{
+		resp.StringValue("role"), resp.StringValue("leader"),
+		resp.StringValue("offset"), resp.IntegerValue(int(offset)),
+		resp.StringValue("followers"), resp.ArrayValue(followers),
+	}), nil
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplOffsets(t *testing.T) {
+	var r replOffsets
+	c1, c2 := &net.TCPConn{}, &net.TCPConn{}
+	n, changed := r.acked(100)
+	if n != 0 {
+		t.Fatalf("expected 0, got %d", n)
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		r.ack(c1, 50)
+		r.ack(c2, 150)
+	}()
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected a change")
+	}
+	time.Sleep(time.Millisecond * 10)
+	if n, _ := r.acked(100); n != 1 {
+		t.Fatalf("expected 1, got %d", n)
+	}
+	r.ack(c1, 100)
+	if n, _ := r.acked(100); n != 2 {
+		t.Fatalf("expected 2, got %d", n)
+	}
+	r.remove(c2)
+	if n, _ := r.acked(100); n != 1 {
+		t.Fatalf("expected 1, got %d", n)
+	}
+
+	offset, changed := r.appliedOffset()
+	if offset != 0 {
+		t.Fatalf("expected 0, got %d", offset)
+	}
+	r.setApplied(200)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected a change")
+	}
+	if offset, _ := r.appliedOffset(); offset != 200 {
+		t.Fatalf("expected 200, got %d", offset)
+	}
+}
+
+func TestReplAckedMembers(t *testing.T) {
+	var r replOffsets
+	c1, c2, c3 := &net.TCPConn{}, &net.TCPConn{}, &net.TCPConn{}
+	r.add(c1, 0, "10.0.0.1:9851")
+	r.add(c2, 0, "10.0.0.2:9851")
+	r.add(c3, 0, "")
+	r.ack(c1, 100)
+	r.ack(c3, 100)
+	members, _ := r.ackedMembers(100)
+	if len(members) != 1 || !members["10.0.0.1:9851"] {
+		t.Fatalf("expected the first member, got %v", members)
+	}
+	// a member that reconnects is counted once
+	c4 := &net.TCPConn{}
+	r.add(c4, 100, "10.0.0.1:9851")
+	if members, _ := r.ackedMembers(100); len(members) != 1 {
+		t.Fatalf("expected 1, got %d", len(members))
+	}
+}
+
+func TestReplLag(t *testing.T) {
+	var r replOffsets
+	c1, c2 := &net.TCPConn{}, &net.TCPConn{}
+	r.wrote(100)
+	if len(r.writes) != 0 {
+		t.Fatal("expected no writes without followers")
+	}
+	r.add(c1, 100, "")
+	r.add(c2, 100, "")
+	r.wrote(200)
+	time.Sleep(replWriteInterval * 2)
+	r.wrote(300)
+	if len(r.writes) != 2 {
+		t.Fatalf("expected 2, got %d", len(r.writes))
+	}
+	r.ack(c1, 300)
+	lags := r.followerLags(300)
+	if len(lags) != 2 {
+		t.Fatalf("expected 2, got %d", len(lags))
+	}
+	var lag1, lag2 replLag
+	for _, lag := range lags {
+		if lag.offset == 300 {
+			lag1 = lag
+		} else {
+			lag2 = lag
+		}
+	}
+	if lag1.lagBytes != 0 || lag1.behind != 0 || lag1.lastAck < 0 {
+		t.Fatalf("unexpected %+v", lag1)
+	}
+	if lag2.lagBytes != 200 || lag2.behind < replWriteInterval*2 ||
+		lag2.lastAck != -1 {
+		t.Fatalf("unexpected %+v", lag2)
+	}
+	r.ack(c2, 200)
+	if len(r.writes) != 1 {
+		t.Fatalf("expected 1, got %d", len(r.writes))
+	}
+	r.remove(c2)
+	if len(r.writes) != 0 {
+		t.Fatalf("expected 0, got %d", len(r.writes))
+	}
+
+	var f replOffsets
+	f.setLeaderSize(500)
+	f.setApplied(400)
+	if lag, _ := f.leaderLag(); lag.lagBytes != 100 || lag.behind != -1 {
+		t.Fatalf("unexpected %+v", lag)
+	}
+	f.setApplied(500)
+	f.setLeaderSize(600)
+	if lag, _ := f.leaderLag(); lag.lagBytes != 100 || lag.behind < 0 {
+		t.Fatalf("unexpected %+v", lag)
+	}
+	f.setApplied(600)
+	if lag, _ := f.leaderLag(); lag.lagBytes != 0 || lag.behind != 0 {
+		t.Fatalf("unexpected %+v", lag)
+	}
+}
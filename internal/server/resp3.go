@@ -0,0 +1,266 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/core"
+)
+
+// The commands build RESP2 replies, which are written as RESP3 to the
+// clients that switched with HELLO 3. The types that RESP2 does not have,
+// such as maps and doubles, come from the shape of the reply of a command.
+
+// resp3Shape is the shape of a reply that has RESP3 types.
+type resp3Shape byte
+
+const (
+	// resp3Plain only has the RESP3 null.
+	resp3Plain resp3Shape = iota
+	// resp3Map is an array of names and values, which is a map.
+	resp3Map
+	// resp3Stats is a map of values that are typed by their text, which
+	// are integers, big numbers, doubles, or booleans.
+	resp3Stats
+	// resp3StatsArray is an array of stats, or of nulls.
+	resp3StatsArray
+	// resp3Double is a number, which is a double.
+	resp3Double
+)
+
+// resp3Shapes are the shapes of the replies of the commands.
+var resp3Shapes = map[string]resp3Shape{
+	"hello":      resp3Map,
+	"config get": resp3Map,
+	"labels":     resp3Map,
+	"replica":    resp3Map,
+	"server":     resp3Stats,
+	"memory":     resp3Stats,
+	"aofcheck":   resp3Stats,
+	"stats":      resp3StatsArray,
+	"fincrby":    resp3Double,
+}
+
+// resp3ReplyShape returns the shape of the reply of a command.
+func resp3ReplyShape(msg *Message) resp3Shape {
+	command := msg.Command()
+	if len(msg.Args) > 1 {
+		switch command + " " + strings.ToLower(msg.Args[1]) {
+		case "cluster info", "raft state":
+			return resp3Stats
+		}
+	}
+	return resp3Shapes[command]
+}
+
+// appendRESP3 appends a RESP2 reply as RESP3.
+func appendRESP3(dst []byte, v resp.Value, shape resp3Shape) []byte {
+	if v.IsNull() {
+		return append(dst, "_\r\n"...)
+	}
+	switch v.Type() {
+	case resp.Array:
+		vals := v.Array()
+		elem := resp3Plain
+		switch shape {
+		case resp3Map, resp3Stats:
+			if len(vals)%2 != 0 {
+				break
+			}
+			dst = append(dst, '%')
+			dst = strconv.AppendInt(dst, int64(len(vals)/2), 10)
+			dst = append(dst, "\r\n"...)
+			for i := 0; i < len(vals); i += 2 {
+				dst = appendRESP3(dst, vals[i], resp3Plain)
+				if shape == resp3Stats {
+					dst = appendRESP3Typed(dst, vals[i+1])
+				} else {
+					dst = appendRESP3(dst, vals[i+1], resp3Plain)
+				}
+			}
+			return dst
+		case resp3StatsArray:
+			elem = resp3Stats
+		}
+		dst = redcon.AppendArray(dst, len(vals))
+		for _, val := range vals {
+			dst = appendRESP3(dst, val, elem)
+		}
+		return dst
+	case resp.BulkString, resp.Integer:
+		if shape == resp3Double {
+			if f, err := strconv.ParseFloat(v.String(), 64); err == nil {
+				return appendRESP3Double(dst, f)
+			}
+		}
+	}
+	data, _ := v.MarshalRESP()
+	return append(dst, data...)
+}
+
+// appendRESP3Typed appends a value of stats, which is text in RESP2.
+func appendRESP3Typed(dst []byte, v resp.Value) []byte {
+	if v.Type() != resp.BulkString || v.IsNull() {
+		return appendRESP3(dst, v, resp3Plain)
+	}
+	s := v.String()
+	switch x := tryParseType(s).(type) {
+	case int64:
+		return redcon.AppendInt(dst, x)
+	case float64:
+		if isBigNumber(s) {
+			return append(append(append(dst, '('), s...), "\r\n"...)
+		}
+		return appendRESP3Double(dst, x)
+	case bool:
+		if x {
+			return append(dst, "#t\r\n"...)
+		}
+		return append(dst, "#f\r\n"...)
+	}
+	return redcon.AppendBulkString(dst, s)
+}
+
+// isBigNumber returns true for an integer, which is a big number when it
+// does not fit in 64 bits.
+func isBigNumber(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func appendRESP3Double(dst []byte, f float64) []byte {
+	dst = append(dst, ',')
+	switch {
+	case math.IsInf(f, 1):
+		dst = append(dst, "inf"...)
+	case math.IsInf(f, -1):
+		dst = append(dst, "-inf"...)
+	case math.IsNaN(f):
+		dst = append(dst, "nan"...)
+	default:
+		dst = strconv.AppendFloat(dst, f, 'f', -1, 64)
+	}
+	return append(dst, "\r\n"...)
+}
+
+// appendPush appends the header of a message that is pushed to a client,
+// which is a push of RESP3, or an array of RESP2.
+func appendPush(dst []byte, n int, resp3 bool) []byte {
+	if !resp3 {
+		return redcon.AppendArray(dst, n)
+	}
+	dst = append(dst, '>')
+	dst = strconv.AppendInt(dst, int64(n), 10)
+	return append(dst, "\r\n"...)
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+//
+// Switches the protocol of the connection to RESP2 or RESP3, and returns
+// the info of the server. The username of AUTH is ignored, because there
+// is only the password of the server.
+func (s *Server) cmdHello(msg *Message, client *Client) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	resp3 := client.resp3
+	if len(vs) > 0 {
+		switch vs[0] {
+		case "2":
+			resp3 = false
+		case "3":
+			resp3 = true
+		default:
+			return NOMessage, errors.New("unsupported protocol version")
+		}
+		vs = vs[1:]
+	}
+	var username, password, name string
+	var auth, setname bool
+	for len(vs) > 0 {
+		switch strings.ToLower(vs[0]) {
+		case "auth":
+			if len(vs) < 3 {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			auth, username, password, vs = true, vs[1], vs[2], vs[3:]
+		case "setname":
+			if len(vs) < 2 {
+				return NOMessage, errInvalidNumberOfArguments
+			}
+			setname, name, vs = true, vs[1], vs[2:]
+			for i := 0; i < len(name); i++ {
+				if name[i] < '!' || name[i] > '~' {
+					return NOMessage, clientErrorf(
+						"Client names cannot contain spaces, newlines or special characters.",
+					)
+				}
+			}
+		default:
+			return NOMessage, errInvalidArgument(vs[0])
+		}
+	}
+	requirePass := s.config.requirePass()
+	if auth {
+		if err := s.authenticate(client, username, password); err != nil {
+			return NOMessage, err
+		}
+	} else if requirePass != "" && !client.authd {
+		return NOMessage, errors.New("authentication required")
+	}
+	if setname {
+		client.mu.Lock()
+		client.name = name
+		client.mu.Unlock()
+	}
+	// the reply is in the new protocol
+	client.resp3, msg.resp3 = resp3, resp3
+	proto := 2
+	if resp3 {
+		proto = 3
+	}
+	mode := "standalone"
+	if s.clusterEnabled() {
+		mode = "cluster"
+	}
+	role := "leader"
+	if s.config.followHost() != "" {
+		role = "follower"
+	}
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"server":"tile38","version":`...)
+		buf = appendJSONString(buf, core.Version)
+		buf = append(buf, `,"proto":`...)
+		buf = strconv.AppendInt(buf, int64(proto), 10)
+		buf = append(buf, `,"id":`...)
+		buf = strconv.AppendInt(buf, int64(client.id), 10)
+		buf = append(buf, `,"mode":"`+mode+`","role":"`+role+`"`...)
+		buf = append(buf, `,"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		return resp.ArrayValue([]resp.Value{
+			resp.StringValue("server"), resp.StringValue("tile38"),
+			resp.StringValue("version"), resp.StringValue(core.Version),
+			resp.StringValue("proto"), resp.IntegerValue(proto),
+			resp.StringValue("id"), resp.IntegerValue(client.id),
+			resp.StringValue("mode"), resp.StringValue(mode),
+			resp.StringValue("role"), resp.StringValue(role),
+			resp.StringValue("modules"), resp.ArrayValue(nil),
+		}), nil
+	}
+	return NOMessage, nil
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+func TestAppendRESP3(t *testing.T) {
+	stats := resp.ArrayValue([]resp.Value{
+		resp.StringValue("num"), resp.StringValue("12"),
+		resp.StringValue("big"), resp.StringValue("18446744073709551616"),
+		resp.StringValue("avg"), resp.StringValue("1.5"),
+		resp.StringValue("on"), resp.StringValue("true"),
+		resp.StringValue("id"), resp.StringValue("abc"),
+	})
+	tests := []struct {
+		v        resp.Value
+		shape    resp3Shape
+		expected string
+	}{
+		{resp.NullValue(), resp3Plain, "_\r\n"},
+		{resp.ArrayValue([]resp.Value{resp.NullValue()}), resp3Plain,
+			"*1\r\n_\r\n"},
+		{stats, resp3Stats, "%5\r\n$3\r\nnum\r\n:12\r\n" +
+			"$3\r\nbig\r\n(18446744073709551616\r\n" +
+			"$3\r\navg\r\n,1.5\r\n$2\r\non\r\n#t\r\n$2\r\nid\r\n$3\r\nabc\r\n"},
+		{resp.ArrayValue([]resp.Value{resp.StringValue("a"),
+			resp.StringValue("12")}), resp3Map, "%1\r\n$1\r\na\r\n$2\r\n12\r\n"},
+		{resp.ArrayValue([]resp.Value{resp.NullValue(),
+			resp.ArrayValue(nil)}), resp3StatsArray, "*2\r\n_\r\n%0\r\n"},
+		{resp.StringValue("2.5"), resp3Double, ",2.5\r\n"},
+		{resp.StringValue("2.5"), resp3Plain, "$3\r\n2.5\r\n"},
+		{resp.IntegerValue(3), resp3Plain, ":3\r\n"},
+	}
+	for i, tt := range tests {
+		if got := string(appendRESP3(nil, tt.v, tt.shape)); got != tt.expected {
+			t.Fatalf("%d: expected %q, got %q", i, tt.expected, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 
@@ -28,6 +29,22 @@ func DialTimeout(address string, timeout time.Duration) (*RESPConn, error) {
 	return conn, nil
 }
 
+// DialTLSTimeout dials a resp over TLS
+func DialTLSTimeout(address string, timeout time.Duration, config *tls.Config,
+) (*RESPConn, error) {
+	tlsconn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp",
+		address, config)
+	if err != nil {
+		return nil, err
+	}
+	conn := &RESPConn{
+		conn: tlsconn,
+		rd:   resp.NewReader(tlsconn),
+		wr:   resp.NewWriter(tlsconn),
+	}
+	return conn, nil
+}
+
 // Close closes the connection.
 func (conn *RESPConn) Close() error {
 	conn.wr.WriteMultiBulk("quit")
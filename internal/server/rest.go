@@ -0,0 +1,489 @@
+package server
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/core"
+)
+
+// restContentType is the content type of the OpenAPI document.
+const restContentType = "application/json; charset=utf-8"
+
+// restRoute is a route of the REST API, which maps a request to the
+// arguments of a command. The reply of the route is the json of the command.
+type restRoute struct {
+	method  string
+	path    string // {name} is a parameter of the path
+	summary string
+	query   []restParam
+	body    string // the json schema of the body
+	args    func(req *restRequest) []string
+}
+
+// restParam is a query parameter of a route.
+type restParam struct {
+	name, typ, desc string
+}
+
+// restRequest is a request of a route.
+type restRequest struct {
+	vars  map[string]string
+	query url.Values
+	body  gjson.Result
+}
+
+// the json schemas of the bodies
+const (
+	restPointSchema = `{"type":"object","required":["lat","lon"],` +
+		`"properties":{"lat":{"type":"number"},"lon":{"type":"number"}}}`
+	restBoundsSchema = `{"type":"object",` +
+		`"required":["minLat","minLon","maxLat","maxLon"],"properties":{` +
+		`"minLat":{"type":"number"},"minLon":{"type":"number"},` +
+		`"maxLat":{"type":"number"},"maxLon":{"type":"number"}}}`
+	restGeoJSONSchema = `{"type":"object","description":"a GeoJSON object"}`
+	restSearchSchema  = `"cursor":{"type":"integer"},` +
+		`"limit":{"type":"integer"},` +
+		`"match":{"type":"string","description":"a glob of the ids"},` +
+		`"where":{"type":"array","items":{"type":"object",` +
+		`"required":["field","min","max"],"properties":{` +
+		`"field":{"type":"string"},"min":{"type":"number"},` +
+		`"max":{"type":"number"}}}},` +
+		`"nofields":{"type":"boolean"},` +
+		`"output":{"type":"string",` +
+		`"enum":["objects","ids","count","points","bounds"]}`
+)
+
+var restSearchParams = []restParam{
+	{"cursor", "integer", "the cursor of the next page"},
+	{"limit", "integer", "the number of objects of a page"},
+	{"match", "string", "a glob of the ids"},
+	{"where", "string", "a field filter, which is field,min,max"},
+	{"output", "string", "objects, ids, count, points, or bounds"},
+}
+
+var restRoutes = []restRoute{
+	{method: "GET", path: "/keys", summary: "Returns the keys",
+		query: []restParam{{"match", "string", "a glob of the keys"}},
+		args: func(req *restRequest) []string {
+			match := req.query.Get("match")
+			if match == "" {
+				match = "*"
+			}
+			return []string{"keys", match}
+		}},
+	{method: "GET", path: "/keys/{key}",
+		summary: "Returns the stats of a collection",
+		args: func(req *restRequest) []string {
+			return []string{"stats", req.vars["key"]}
+		}},
+	{method: "DELETE", path: "/keys/{key}",
+		summary: "Removes a collection",
+		args: func(req *restRequest) []string {
+			return []string{"drop", req.vars["key"]}
+		}},
+	{method: "GET", path: "/keys/{key}/bounds",
+		summary: "Returns the bounds of a collection",
+		args: func(req *restRequest) []string {
+			return []string{"bounds", req.vars["key"]}
+		}},
+	{method: "GET", path: "/keys/{key}/objects",
+		summary: "Returns the objects of a collection, ordered by id",
+		query: append([]restParam{
+			{"desc", "boolean", "descending order"},
+		}, restSearchParams...),
+		args: func(req *restRequest) []string {
+			args := []string{"scan", req.vars["key"]}
+			args = req.searchQuery(args)
+			if restBool(req.query, "desc") {
+				args = append(args, "DESC")
+			}
+			return req.output(args, req.query.Get("output"))
+		}},
+	{method: "GET", path: "/keys/{key}/objects/{id}",
+		summary: "Returns an object",
+		query: []restParam{
+			{"withfields", "boolean", "include the fields"},
+		},
+		args: func(req *restRequest) []string {
+			args := []string{"get", req.vars["key"], req.vars["id"]}
+			if restBool(req.query, "withfields") {
+				args = append(args, "WITHFIELDS")
+			}
+			return args
+		}},
+	{method: "PUT", path: "/keys/{key}/objects/{id}",
+		summary: "Sets an object, which is the GeoJSON body, or the object " +
+			"or point of the body with its fields",
+		query: []restParam{
+			{"ex", "number", "the seconds until the object expires"},
+			{"nx", "boolean", "only set the object if it does not exist"},
+			{"xx", "boolean", "only set the object if it already exists"},
+		},
+		body: `{"oneOf":[` + restGeoJSONSchema + `,{"type":"object",` +
+			`"properties":{"object":` + restGeoJSONSchema + `,` +
+			`"point":` + restPointSchema + `,` +
+			`"fields":{"type":"object","additionalProperties":true}}}]}`,
+		args: func(req *restRequest) []string {
+			args := []string{"set", req.vars["key"], req.vars["id"]}
+			req.body.Get("fields").ForEach(func(name, value gjson.Result) bool {
+				args = append(args, "FIELD", name.String(), restValue(value))
+				return true
+			})
+			if ex := req.query.Get("ex"); ex != "" {
+				args = append(args, "EX", ex)
+			}
+			if restBool(req.query, "nx") {
+				args = append(args, "NX")
+			} else if restBool(req.query, "xx") {
+				args = append(args, "XX")
+			}
+			if point := req.body.Get("point"); point.Exists() {
+				return append(args, "POINT", restValue(point.Get("lat")),
+					restValue(point.Get("lon")))
+			} else if object := req.body.Get("object"); object.Exists() {
+				return append(args, "OBJECT", object.Raw)
+			} else if req.body.IsObject() {
+				return append(args, "OBJECT", req.body.Raw)
+			}
+			// missing the object
+			return args
+		}},
+	{method: "DELETE", path: "/keys/{key}/objects/{id}",
+		summary: "Removes an object",
+		args: func(req *restRequest) []string {
+			return []string{"del", req.vars["key"], req.vars["id"]}
+		}},
+	{method: "POST", path: "/keys/{key}/search/nearby",
+		summary: "Searches the objects nearby a point, ordered by distance",
+		body: `{"type":"object","required":["point"],"properties":{` +
+			`"point":` + restPointSchema + `,` +
+			`"radius":{"type":"number","description":"meters"},` +
+			restSearchSchema + `}}`,
+		args: func(req *restRequest) []string {
+			args := req.searchBody([]string{"nearby", req.vars["key"]})
+			point := req.body.Get("point")
+			args = append(args, "POINT", restValue(point.Get("lat")),
+				restValue(point.Get("lon")))
+			if radius := req.body.Get("radius"); radius.Exists() {
+				args = append(args, restValue(radius))
+			}
+			return args
+		}},
+	{method: "POST", path: "/keys/{key}/search/within",
+		summary: "Searches the objects within an area",
+		body:    restAreaSchema(),
+		args: func(req *restRequest) []string {
+			return req.area(req.searchBody(
+				[]string{"within", req.vars["key"]}))
+		}},
+	{method: "POST", path: "/keys/{key}/search/intersects",
+		summary: "Searches the objects that intersect an area",
+		body:    restAreaSchema(),
+		args: func(req *restRequest) []string {
+			return req.area(req.searchBody(
+				[]string{"intersects", req.vars["key"]}))
+		}},
+	{method: "GET", path: "/openapi.json",
+		summary: "Returns the OpenAPI document of the REST API",
+		args: func(req *restRequest) []string {
+			return []string{"openapi"}
+		}},
+}
+
+func restAreaSchema() string {
+	return `{"type":"object","properties":{` +
+		`"bounds":` + restBoundsSchema + `,` +
+		`"object":` + restGeoJSONSchema + `,` +
+		`"point":` + restPointSchema + `,` +
+		`"radius":{"type":"number","description":"the meters of the ` +
+		`circle of the point"},` +
+		restSearchSchema + `}}`
+}
+
+// restValue returns the text of a json value, which is the string of a
+// string, and the json of the others.
+func restValue(v gjson.Result) string {
+	if v.Type == gjson.String {
+		return v.Str
+	}
+	return v.Raw
+}
+
+// restBool returns true when a query parameter is present, and not false.
+func restBool(query url.Values, name string) bool {
+	v, ok := query[name]
+	return ok && (len(v) == 0 || (v[0] != "false" && v[0] != "0"))
+}
+
+// output appends the output of a search, where the objects are the default.
+func (req *restRequest) output(args []string, output string) []string {
+	switch strings.ToLower(output) {
+	case "ids", "count", "points", "bounds":
+		args = append(args, strings.ToUpper(output))
+	}
+	return args
+}
+
+// searchQuery appends the options of a search in the query.
+func (req *restRequest) searchQuery(args []string) []string {
+	if cursor := req.query.Get("cursor"); cursor != "" {
+		args = append(args, "CURSOR", cursor)
+	}
+	if limit := req.query.Get("limit"); limit != "" {
+		args = append(args, "LIMIT", limit)
+	}
+	if match := req.query.Get("match"); match != "" {
+		args = append(args, "MATCH", match)
+	}
+	for _, where := range req.query["where"] {
+		args = append(args, "WHERE")
+		args = append(args, strings.SplitN(where, ",", 3)...)
+	}
+	return args
+}
+
+// searchBody appends the options of a search in the body.
+func (req *restRequest) searchBody(args []string) []string {
+	if cursor := req.body.Get("cursor"); cursor.Exists() {
+		args = append(args, "CURSOR", restValue(cursor))
+	}
+	if limit := req.body.Get("limit"); limit.Exists() {
+		args = append(args, "LIMIT", restValue(limit))
+	}
+	if match := req.body.Get("match"); match.Exists() {
+		args = append(args, "MATCH", restValue(match))
+	}
+	for _, where := range req.body.Get("where").Array() {
+		args = append(args, "WHERE", restValue(where.Get("field")),
+			restValue(where.Get("min")), restValue(where.Get("max")))
+	}
+	if req.body.Get("nofields").Bool() {
+		args = append(args, "NOFIELDS")
+	}
+	return req.output(args, req.body.Get("output").String())
+}
+
+// area appends the area of a within or intersects search.
+func (req *restRequest) area(args []string) []string {
+	if bounds := req.body.Get("bounds"); bounds.Exists() {
+		return append(args, "BOUNDS", restValue(bounds.Get("minLat")),
+			restValue(bounds.Get("minLon")), restValue(bounds.Get("maxLat")),
+			restValue(bounds.Get("maxLon")))
+	}
+	if object := req.body.Get("object"); object.Exists() {
+		return append(args, "OBJECT", object.Raw)
+	}
+	if point := req.body.Get("point"); point.Exists() {
+		return append(args, "CIRCLE", restValue(point.Get("lat")),
+			restValue(point.Get("lon")), restValue(req.body.Get("radius")))
+	}
+	// missing the area
+	return args
+}
+
+// isRESTPath returns true when the path of a request is a path of the REST
+// API, which are /keys and /openapi.json.
+func isRESTPath(rawPath string) bool {
+	if i := strings.IndexByte(rawPath, '?'); i != -1 {
+		rawPath = rawPath[:i]
+	}
+	return rawPath == "keys" || strings.HasPrefix(rawPath, "keys/") ||
+		rawPath == "openapi.json"
+}
+
+// restHTTPArgs returns the arguments of the command of a request of the
+// REST API. The arguments of a request that matches no route are an
+// unknown command.
+func restHTTPArgs(method, rawPath string, body []byte) []string {
+	var rawQuery string
+	if i := strings.IndexByte(rawPath, '?'); i != -1 {
+		rawPath, rawQuery = rawPath[:i], rawPath[i+1:]
+	}
+	segs := strings.Split(strings.TrimSuffix(rawPath, "/"), "/")
+	for i := range segs {
+		seg, err := url.PathUnescape(segs[i])
+		if err != nil {
+			return []string{method + " /" + rawPath}
+		}
+		segs[i] = seg
+	}
+	for i := range restRoutes {
+		route := &restRoutes[i]
+		if route.method != method {
+			continue
+		}
+		vars, ok := route.match(segs)
+		if !ok {
+			continue
+		}
+		query, _ := url.ParseQuery(rawQuery)
+		return route.args(&restRequest{
+			vars:  vars,
+			query: query,
+			body:  gjson.ParseBytes(body),
+		})
+	}
+	return []string{method + " /" + rawPath}
+}
+
+// match returns the parameters of a path, when the path is the path of the
+// route.
+func (route *restRoute) match(segs []string) (map[string]string, bool) {
+	parts := strings.Split(route.path[1:], "/")
+	if len(parts) != len(segs) {
+		return nil, false
+	}
+	vars := make(map[string]string)
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") {
+			if segs[i] == "" {
+				return nil, false
+			}
+			vars[part[1:len(part)-1]] = segs[i]
+		} else if part != segs[i] {
+			return nil, false
+		}
+	}
+	return vars, true
+}
+
+// restStatus returns the HTTP status of an error of the REST API.
+func restStatus(errMsg string) string {
+	switch {
+	case errMsg == errKeyNotFound.Error(), errMsg == errIDNotFound.Error(),
+		strings.HasPrefix(errMsg, "unknown command "):
+		return "404 Not Found"
+	case errMsg == "id already exists":
+		return "409 Conflict"
+	case errMsg == "authentication required", errMsg == "invalid password":
+		return "401 Unauthorized"
+	case errMsg == "timeout":
+		return "504 Gateway Timeout"
+	}
+	return "400 Bad Request"
+}
+
+var restOpenAPIOnce sync.Once
+var restOpenAPIDoc []byte
+
+// restOpenAPI returns the OpenAPI document of the REST API, which is
+// generated from the routes.
+func restOpenAPI() []byte {
+	restOpenAPIOnce.Do(func() {
+		paths := make(map[string][]*restRoute)
+		var names []string
+		for i := range restRoutes {
+			route := &restRoutes[i]
+			if paths[route.path] == nil {
+				names = append(names, route.path)
+			}
+			paths[route.path] = append(paths[route.path], route)
+		}
+		sort.Strings(names)
+		b := []byte(`{"openapi":"3.0.3","info":{"title":"Tile38",` +
+			`"version":`)
+		b = appendJSONString(b, core.Version)
+		b = append(b, `},"paths":{`...)
+		for i, name := range names {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, name)
+			b = append(b, ':', '{')
+			for j, route := range paths[name] {
+				if j > 0 {
+					b = append(b, ',')
+				}
+				b = route.appendOperation(b)
+			}
+			b = append(b, '}')
+		}
+		b = append(b, `}}`...)
+		restOpenAPIDoc = b
+	})
+	return restOpenAPIDoc
+}
+
+// appendOperation appends the OpenAPI operation of a route.
+func (route *restRoute) appendOperation(b []byte) []byte {
+	b = appendJSONString(b, strings.ToLower(route.method))
+	b = append(b, `:{"summary":`...)
+	b = appendJSONString(b, route.summary)
+	b = append(b, `,"parameters":[`...)
+	n := 0
+	for _, part := range strings.Split(route.path[1:], "/") {
+		if !strings.HasPrefix(part, "{") {
+			continue
+		}
+		if n > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"name":`...)
+		b = appendJSONString(b, part[1:len(part)-1])
+		b = append(b, `,"in":"path","required":true,`+
+			`"schema":{"type":"string"}}`...)
+		n++
+	}
+	for _, param := range route.query {
+		if n > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"name":`...)
+		b = appendJSONString(b, param.name)
+		b = append(b, `,"in":"query","description":`...)
+		b = appendJSONString(b, param.desc)
+		b = append(b, `,"schema":{"type":`...)
+		b = appendJSONString(b, param.typ)
+		b = append(b, `}}`...)
+		n++
+	}
+	b = append(b, ']')
+	if route.body != "" {
+		b = append(b, `,"requestBody":{"required":true,"content":`+
+			`{"application/json":{"schema":`...)
+		b = append(b, route.body...)
+		b = append(b, `}}}`...)
+	}
+	b = append(b, `,"responses":{`+
+		`"200":{"description":"the json reply of the command"},`+
+		`"400":{"description":"an invalid request"},`+
+		`"401":{"description":"authentication required"},`+
+		`"404":{"description":"the collection or object was not found"}`...)
+	if route.method == "PUT" {
+		b = append(b, `,"409":{"description":"the object already exists"}`...)
+	}
+	return append(b, `}}`...)
+}
+
+// OPENAPI
+//
+// Returns the OpenAPI document of the REST API, which is also served at
+// /openapi.json by the HTTP transport.
+func (s *Server) cmdOpenAPI(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	doc := restOpenAPI()
+	if msg.ContentType != "" {
+		// the body of a response of the HTTP transport
+		return resp.BytesValue(doc), nil
+	}
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"openapi":`...)
+		buf = append(buf, doc...)
+		buf = append(buf, `,"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		return resp.BytesValue(doc), nil
+	}
+	return NOMessage, nil
+}
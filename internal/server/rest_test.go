@@ -0,0 +1,65 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestRESTHTTPArgs(t *testing.T) {
+	for _, tc := range []struct {
+		method, path, body string
+		expect             string
+	}{
+		{"GET", "keys", "", "keys *"},
+		{"GET", "keys?match=fl*", "", "keys fl*"},
+		{"GET", "keys/fleet/", "", "stats fleet"},
+		{"DELETE", "keys/fleet", "", "drop fleet"},
+		{"GET", "keys/fleet/objects?limit=2&desc&where=speed,0,100&output=ids",
+			"", "scan fleet LIMIT 2 WHERE speed 0 100 DESC IDS"},
+		{"GET", "keys/fleet/objects/truck%2F1?withfields", "",
+			"get fleet truck/1 WITHFIELDS"},
+		{"PUT", "keys/fleet/objects/truck1?ex=10&nx",
+			`{"point":{"lat":33,"lon":-115},"fields":{"speed":90}}`,
+			"set fleet truck1 FIELD speed 90 EX 10 NX POINT 33 -115"},
+		{"PUT", "keys/fleet/objects/truck1",
+			`{"type":"Point","coordinates":[-115,33]}`,
+			`set fleet truck1 OBJECT {"type":"Point","coordinates":[-115,33]}`},
+		{"DELETE", "keys/fleet/objects/truck1", "", "del fleet truck1"},
+		{"POST", "keys/fleet/search/nearby",
+			`{"point":{"lat":33,"lon":-115},"radius":1000,"limit":5}`,
+			"nearby fleet LIMIT 5 POINT 33 -115 1000"},
+		{"POST", "keys/fleet/search/within",
+			`{"bounds":{"minLat":32,"minLon":-116,"maxLat":34,"maxLon":-114},` +
+				`"where":[{"field":"speed","min":0,"max":100}],"output":"count"}`,
+			"within fleet WHERE speed 0 100 COUNT BOUNDS 32 -116 34 -114"},
+		{"POST", "keys/fleet/search/intersects",
+			`{"point":{"lat":33,"lon":-115},"radius":1000}`,
+			"intersects fleet CIRCLE 33 -115 1000"},
+		{"GET", "openapi.json", "", "openapi"},
+		{"PUT", "keys/fleet", "", "PUT /keys/fleet"},
+		{"GET", "keys/fleet/nope", "", "GET /keys/fleet/nope"},
+	} {
+		args := restHTTPArgs(tc.method, tc.path, []byte(tc.body))
+		if s := strings.Join(args, " "); s != tc.expect {
+			t.Fatalf("%s %s: expected '%s', got '%s'", tc.method, tc.path,
+				tc.expect, s)
+		}
+	}
+}
+
+func TestRESTOpenAPI(t *testing.T) {
+	doc := restOpenAPI()
+	if !gjson.ValidBytes(doc) {
+		t.Fatalf("invalid json: %s", doc)
+	}
+	for i := range restRoutes {
+		route := &restRoutes[i]
+		path := strings.Replace(route.path, ".", `\.`, -1) + "." +
+			strings.ToLower(route.method)
+		if !gjson.GetBytes(doc, "paths."+path+".summary").Exists() {
+			t.Fatalf("missing %s %s", route.method, route.path)
+		}
+	}
+}
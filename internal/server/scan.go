@@ -3,13 +3,36 @@ package server
 import (
 	"bytes"
 	"errors"
+	"sort"
 	"time"
 
 	"github.com/tidwall/geojson"
 	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/deadline"
+	"github.com/tidwall/tile38/internal/field"
 	"github.com/tidwall/tile38/internal/glob"
 )
 
+// scanSnapshotMin is the number of objects of a collection that a SCAN
+// reads from a snapshot, without holding the lock of the server or the key.
+const scanSnapshotMin = 1000
+
+// idScanner is a collection, or a snapshot of one, in the order of the ids.
+type idScanner interface {
+	Scan(desc bool, cursor collection.Cursor, deadline *deadline.Deadline,
+		iter func(id string, obj geojson.Object, fields []field.Value) bool,
+	) bool
+	ScanGreaterOrEqual(id string, desc bool, cursor collection.Cursor,
+		deadline *deadline.Deadline,
+		iter func(id string, obj geojson.Object, fields []field.Value) bool,
+	) bool
+	ScanRange(start, end string, desc bool, cursor collection.Cursor,
+		deadline *deadline.Deadline,
+		iter func(id string, obj geojson.Object, fields []field.Value) bool,
+	) bool
+}
+
 func (s *Server) cmdScanArgs(vs []string) (
 	ls liveFenceSwitches, err error,
 ) {
@@ -44,50 +67,77 @@ func (s *Server) cmdScan(msg *Message) (res resp.Value, err error) {
 	if err != nil {
 		return NOMessage, err
 	}
+	setQueryTimeout(msg, start, args.searchScanBaseTokens)
 	wr := &bytes.Buffer{}
 	sw, err := s.newScanWriter(
 		wr, msg, args.key, args.output, args.precision, args.glob, false,
 		args.cursor, args.limit, args.wheres, args.whereins, args.whereevals,
-		args.nofields)
+		args.wherestrs, args.nofields, args.agg)
 	if err != nil {
 		return NOMessage, err
 	}
+	if err := sw.setStream(args.stream); err != nil {
+		return NOMessage, err
+	}
 	if msg.OutputType == JSON {
 		wr.WriteString(`{"ok":true`)
 	}
+	sw.stable = args.stable
+	sw.clusters = args.clusters
+	sw.zrange, sw.zmin, sw.zmax = args.zrange, args.zmin, args.zmax
+	sw.tsrange, sw.tsmin, sw.tsmax = args.tsrange, args.tsmin, args.tsmax
+	sw.sample = args.sample
+	after := args.after.id
 	sw.writeHead()
 	if sw.col != nil {
-		if sw.output == outputCount && len(sw.wheres) == 0 &&
-			len(sw.whereins) == 0 && sw.globEverything {
+		if sw.output == outputCount && sw.agg == nil && !sw.zrange && !sw.tsrange && len(sw.wheres) == 0 &&
+			len(sw.whereins) == 0 && len(sw.wherestrs) == 0 &&
+			sw.globEverything && !args.stable {
 			count := sw.col.Count() - int(args.cursor)
 			if count < 0 {
 				count = 0
 			}
 			sw.count = uint64(count)
+		} else if plan := sw.indexPlan(); plan != nil {
+			scanIndex(sw, plan, args.desc, after, msg.Deadline)
 		} else {
+			var items idScanner = sw.col
+			if sw.col.Count() < scanSnapshotMin {
+				sw.releaseRead(false)
+			} else if msg.keyLock != nil && len(sw.whereevals) == 0 {
+				// a large scan reads a snapshot of the collection without the
+				// locks, so that it doesn't hold back the writes of the key
+				snap := sw.col.Snapshot()
+				sw.fmap, sw.farr = snap.FieldMap(), snap.FieldArr()
+				sw.snap = snap
+				items = snap
+				sw.releaseRead(true)
+			}
+			iter := func(id string, o geojson.Object, fields []field.Value) bool {
+				if after != "" && id == after {
+					// the last object of the previous stable scan
+					return true
+				}
+				return sw.writeObject(ScanWriterParams{
+					id:     id,
+					o:      o,
+					fields: fields,
+				})
+			}
 			g := glob.Parse(sw.globPattern, args.desc)
-			if g.Limits[0] == "" && g.Limits[1] == "" {
-				sw.col.Scan(args.desc, sw,
-					msg.Deadline,
-					func(id string, o geojson.Object, fields []float64) bool {
-						return sw.writeObject(ScanWriterParams{
-							id:     id,
-							o:      o,
-							fields: fields,
-						})
-					},
-				)
+			start, end := g.Limits[0], g.Limits[1]
+			if after != "" && (start == "" ||
+				(!args.desc && after > start) || (args.desc && after < start)) {
+				// a stable scan starts at the last object of the previous one
+				start = after
+			}
+			if start == "" && end == "" {
+				items.Scan(args.desc, sw, msg.Deadline, iter)
+			} else if end == "" {
+				items.ScanGreaterOrEqual(start, args.desc, sw, msg.Deadline,
+					iter)
 			} else {
-				sw.col.ScanRange(g.Limits[0], g.Limits[1], args.desc, sw,
-					msg.Deadline,
-					func(id string, o geojson.Object, fields []float64) bool {
-						return sw.writeObject(ScanWriterParams{
-							id:     id,
-							o:      o,
-							fields: fields,
-						})
-					},
-				)
+				items.ScanRange(start, end, args.desc, sw, msg.Deadline, iter)
 			}
 		}
 	}
@@ -98,3 +148,43 @@ func (s *Server) cmdScan(msg *Message) (res resp.Value, err error) {
 	}
 	return sw.respOut, nil
 }
+
+// scanIndex writes the objects of a field index range in the order of their
+// ids, like a scan of the collection. The cursor is the position in the
+// sorted objects, or the objects follow the after id for a stable scan.
+func scanIndex(sw *scanWriter, plan *indexPlan, desc bool, after string,
+	dl *deadline.Deadline,
+) {
+	var items []iterItem
+	plan.scan(sw.col, nil, dl, func(id string, o geojson.Object,
+		fields []field.Value,
+	) bool {
+		if after != "" && ((!desc && id <= after) || (desc && id >= after)) {
+			return true
+		}
+		items = append(items, iterItem{id: id, o: o, fields: fields})
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool {
+		if desc {
+			return items[j].id < items[i].id
+		}
+		return items[i].id < items[j].id
+	})
+	if sw.cursor >= uint64(len(items)) {
+		items = nil
+	} else {
+		items = items[sw.cursor:]
+	}
+	sw.numberIters = sw.cursor
+	for _, item := range items {
+		sw.Step(1)
+		if !sw.writeObject(ScanWriterParams{
+			id:     item.id,
+			o:      item.o,
+			fields: item.fields,
+		}) {
+			return
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"errors"
 	"math"
+	"math/rand"
+	"sort"
 	"strconv"
 	"sync"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/tidwall/resp"
 	"github.com/tidwall/tile38/internal/clip"
 	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/field"
 	"github.com/tidwall/tile38/internal/glob"
 )
 
@@ -27,6 +30,10 @@ const (
 	outputPoints
 	outputHashes
 	outputBounds
+	outputStats
+	outputClusters
+	outputH3
+	outputTopoJSON
 )
 
 type scanWriter struct {
@@ -35,17 +42,21 @@ type scanWriter struct {
 	wr             *bytes.Buffer
 	msg            *Message
 	col            *collection.Collection
+	snap           *collection.Snapshot // the snapshot of a scan without the lock
 	fmap           map[string]int
 	farr           []string
-	fvals          []float64
+	fvals          []field.Value
 	output         outputT
 	wheres         []whereT
 	whereins       []whereinT
 	whereevals     []whereevalT
+	wherestrs      []wherestrT
 	numberIters    uint64
 	numberItems    uint64
 	nofields       bool
 	cursor         uint64
+	stable         bool      // the cursor is a page token
+	last           pageToken // the last object written
 	limit          uint64
 	hitLimit       bool
 	once           bool
@@ -58,15 +69,31 @@ type scanWriter struct {
 	values         []resp.Value
 	matchValues    bool
 	respOut        resp.Value
+	agg            *aggregation
+	clusters       *pointClusters
+	topo           *topology
+	zrange         bool // only objects in the altitudes of zmin to zmax
+	zmin           float64
+	zmax           float64
+	tsrange        bool // only objects observed from tsmin to tsmax
+	tsmin          int64
+	tsmax          int64
+	sample         uint64 // only a random sample of this many objects
+	samples        []ScanWriterParams
+	sampled        uint64       // the objects that could be in the sample
+	stream         *replyStream // the output is written in chunks, see STREAM
+	jbuf           []byte       // the json of the object being written
 }
 
 // ScanWriterParams ...
 type ScanWriterParams struct {
 	id              string
 	o               geojson.Object
-	fields          []float64
+	fields          []field.Value
 	distance        float64
 	distOutput      bool // query or fence requested distance output
+	score           float64
+	scoreOutput     bool // the SCORE of a NEARBY
 	noLock          bool
 	ignoreGlobMatch bool
 	clip            geojson.Object
@@ -76,17 +103,20 @@ type ScanWriterParams struct {
 func (s *Server) newScanWriter(
 	wr *bytes.Buffer, msg *Message, key string, output outputT,
 	precision uint64, globPattern string, matchValues bool,
-	cursor, limit uint64, wheres []whereT, whereins []whereinT, whereevals []whereevalT, nofields bool,
+	cursor, limit uint64, wheres []whereT, whereins []whereinT, whereevals []whereevalT,
+	wherestrs []wherestrT, nofields bool, agg *aggregation,
 ) (
 	*scanWriter, error,
 ) {
 	switch output {
 	default:
 		return nil, errors.New("invalid output type")
-	case outputIDs, outputObjects, outputCount, outputBounds, outputPoints, outputHashes:
+	case outputIDs, outputObjects, outputCount, outputBounds, outputPoints, outputHashes, outputStats,
+		outputClusters, outputH3, outputTopoJSON:
 	}
 	if limit == 0 {
-		if output == outputCount {
+		if output == outputCount || output == outputStats ||
+			output == outputClusters {
 			limit = math.MaxUint64
 		} else {
 			limit = limitItems
@@ -101,11 +131,13 @@ func (s *Server) newScanWriter(
 		wheres:      wheres,
 		whereins:    whereins,
 		whereevals:  whereevals,
+		wherestrs:   wherestrs,
 		output:      output,
 		nofields:    nofields,
 		precision:   precision,
 		globPattern: globPattern,
 		matchValues: matchValues,
+		agg:         agg,
 	}
 	if globPattern == "*" || globPattern == "" {
 		sw.globEverything = true
@@ -119,7 +151,10 @@ func (s *Server) newScanWriter(
 		sw.fmap = sw.col.FieldMap()
 		sw.farr = sw.col.FieldArr()
 	}
-	sw.fvals = make([]float64, len(sw.farr))
+	if output == outputTopoJSON {
+		sw.topo = newTopology(key, precision)
+	}
+	sw.fvals = make([]field.Value, len(sw.farr))
 	return sw, nil
 }
 
@@ -127,7 +162,7 @@ func (sw *scanWriter) hasFieldsOutput() bool {
 	switch sw.output {
 	default:
 		return false
-	case outputObjects, outputPoints, outputHashes, outputBounds:
+	case outputObjects, outputPoints, outputHashes, outputBounds, outputH3:
 		return !sw.nofields
 	}
 }
@@ -158,7 +193,9 @@ func (sw *scanWriter) writeHead() {
 			sw.wr.WriteString(`,"bounds":[`)
 		case outputHashes:
 			sw.wr.WriteString(`,"hashes":[`)
-		case outputCount:
+		case outputH3:
+			sw.wr.WriteString(`,"h3":[`)
+		case outputCount, outputStats, outputClusters, outputTopoJSON:
 
 		}
 	case RESP:
@@ -172,30 +209,60 @@ func (sw *scanWriter) writeFoot() {
 	if !sw.hitLimit {
 		cursor = 0
 	}
+	var after string
+	if sw.hitLimit {
+		after = sw.last.String()
+	}
+	if sw.sample > 0 {
+		sw.writeSample()
+	}
 	switch sw.msg.OutputType {
 	case JSON:
 		switch sw.output {
 		default:
 			sw.wr.WriteByte(']')
-		case outputCount:
-
+		case outputCount, outputStats, outputClusters, outputTopoJSON:
+			if sw.agg != nil {
+				sw.agg.writeJSON(sw.wr)
+			}
+			if sw.clusters != nil {
+				sw.clusters.writeJSON(sw.wr)
+			}
+			if sw.topo != nil {
+				sw.topo.writeJSON(sw.wr)
+			}
 		}
 		sw.wr.WriteString(`,"count":` + strconv.FormatUint(sw.count, 10))
-		sw.wr.WriteString(`,"cursor":` + strconv.FormatUint(cursor, 10))
+		if sw.stable {
+			sw.wr.WriteString(`,"cursor":` + jsonString(after))
+		} else {
+			sw.wr.WriteString(`,"cursor":` + strconv.FormatUint(cursor, 10))
+		}
 	case RESP:
-		if sw.output == outputCount {
+		if sw.agg != nil {
+			sw.respOut = sw.agg.respValue(sw.count)
+		} else if sw.clusters != nil {
+			sw.respOut = sw.clusters.respValue()
+		} else if sw.output == outputCount {
 			sw.respOut = resp.IntegerValue(int(sw.count))
 		} else {
+			rcursor := resp.IntegerValue(int(cursor))
+			if sw.stable {
+				rcursor = resp.StringValue(after)
+			}
 			values := []resp.Value{
-				resp.IntegerValue(int(cursor)),
+				rcursor,
 				resp.ArrayValue(sw.values),
 			}
+			if sw.topo != nil {
+				values[1] = resp.StringValue(string(sw.topo.appendJSON(nil)))
+			}
 			sw.respOut = resp.ArrayValue(values)
 		}
 	}
 }
 
-func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) (fvals []float64, match bool) {
+func (sw *scanWriter) fieldMatch(fields []field.Value, o geojson.Object) (fvals []field.Value, match bool) {
 	var z float64
 	var gotz bool
 	fvals = sw.fvals
@@ -207,12 +274,12 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) (fvals []fl
 						z = point.Z()
 					}
 				}
-				if !where.match(z) {
+				if !where.match(field.Num(z)) {
 					return
 				}
 				continue
 			}
-			var value float64
+			var value field.Value
 			idx, ok := sw.fmap[where.field]
 			if ok {
 				if len(fields) > idx {
@@ -224,7 +291,7 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) (fvals []fl
 			}
 		}
 		for _, wherein := range sw.whereins {
-			var value float64
+			var value field.Value
 			idx, ok := sw.fmap[wherein.field]
 			if ok {
 				if len(fields) > idx {
@@ -236,12 +303,12 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) (fvals []fl
 			}
 		}
 		for _, whereval := range sw.whereevals {
-			fieldsWithNames := make(map[string]float64)
-			for field, idx := range sw.fmap {
+			fieldsWithNames := make(map[string]field.Value)
+			for name, idx := range sw.fmap {
 				if idx < len(fields) {
-					fieldsWithNames[field] = fields[idx]
+					fieldsWithNames[name] = fields[idx]
 				} else {
-					fieldsWithNames[field] = 0
+					fieldsWithNames[name] = field.Value{}
 				}
 			}
 			if !whereval.match(fieldsWithNames) {
@@ -250,7 +317,7 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) (fvals []fl
 		}
 	} else {
 		for idx := range sw.farr {
-			var value float64
+			var value field.Value
 			if len(fields) > idx {
 				value = fields[idx]
 			}
@@ -263,12 +330,12 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) (fvals []fl
 						z = point.Z()
 					}
 				}
-				if !where.match(z) {
+				if !where.match(field.Num(z)) {
 					return
 				}
 				continue
 			}
-			var value float64
+			var value field.Value
 			idx, ok := sw.fmap[where.field]
 			if ok {
 				value = sw.fvals[idx]
@@ -278,7 +345,7 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) (fvals []fl
 			}
 		}
 		for _, wherein := range sw.whereins {
-			var value float64
+			var value field.Value
 			idx, ok := sw.fmap[wherein.field]
 			if ok {
 				value = sw.fvals[idx]
@@ -288,12 +355,12 @@ func (sw *scanWriter) fieldMatch(fields []float64, o geojson.Object) (fvals []fl
 			}
 		}
 		for _, whereval := range sw.whereevals {
-			fieldsWithNames := make(map[string]float64)
-			for field, idx := range sw.fmap {
+			fieldsWithNames := make(map[string]field.Value)
+			for name, idx := range sw.fmap {
 				if idx < len(fields) {
-					fieldsWithNames[field] = fields[idx]
+					fieldsWithNames[name] = fields[idx]
 				} else {
-					fieldsWithNames[field] = 0
+					fieldsWithNames[name] = field.Value{}
 				}
 			}
 			if !whereval.match(fieldsWithNames) {
@@ -338,19 +405,39 @@ func (sw *scanWriter) Step(n uint64) {
 
 // ok is whether the object passes the test and should be written
 // keepGoing is whether there could be more objects to test
-func (sw *scanWriter) testObject(id string, o geojson.Object, fields []float64, ignoreGlobMatch bool) (
-	ok, keepGoing bool, fieldVals []float64) {
+func (sw *scanWriter) testObject(id string, o geojson.Object, fields []field.Value, ignoreGlobMatch bool) (
+	ok, keepGoing bool, fieldVals []field.Value) {
 	if !ignoreGlobMatch {
 		match, kg := sw.globMatch(id, o)
 		if !match {
 			return false, kg, fieldVals
 		}
 	}
+	if sw.zrange && !zrangeMatch(sw.zmin, sw.zmax, o) {
+		return false, true, fieldVals
+	}
+	if sw.tsrange {
+		// objects without an observation time are never in the range
+		var ts int64
+		if sw.snap != nil {
+			ts = sw.snap.Timestamp(id)
+		} else if sw.col != nil {
+			ts = sw.col.Timestamp(id)
+		}
+		if ts == 0 || ts < sw.tsmin || ts > sw.tsmax {
+			return false, true, fieldVals
+		}
+	}
+	for _, wherestr := range sw.wherestrs {
+		if !wherestr.match(id, o, sw.fmap, fields) {
+			return false, true, fieldVals
+		}
+	}
 	nf, ok := sw.fieldMatch(fields, o)
 	return ok, true, nf
 }
 
-//id string, o geojson.Object, fields []float64, noLock bool
+//id string, o geojson.Object, fields []field.Value, noLock bool
 func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 	if !opts.noLock {
 		sw.mu.Lock()
@@ -364,83 +451,105 @@ func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 			return keepGoing
 		}
 	}
+	if sw.sample > 0 {
+		sw.addSample(opts)
+		return keepGoing
+	}
 	sw.count++
-	if sw.output == outputCount {
+	sw.last.id = opts.id
+	if sw.agg != nil {
+		sw.agg.add(opts.o, sw.fmap, opts.fields)
+	}
+	if sw.clusters != nil {
+		sw.clusters.add(opts.id, opts.o)
+	}
+	if sw.output == outputCount || sw.output == outputStats ||
+		sw.output == outputClusters {
 		return sw.count < sw.limit
 	}
 	if opts.clip != nil {
 		opts.o = clip.Clip(opts.o, opts.clip, &sw.s.geomIndexOpts)
 	}
+	if sw.topo != nil {
+		var props string
+		if !sw.nofields {
+			for _, fv := range orderFields(sw.fmap, sw.farr, opts.fields) {
+				if props == "" {
+					props = "{"
+				} else {
+					props += ","
+				}
+				props += jsonString(fv.field) + ":" + fv.value.JSON()
+			}
+			if props != "" {
+				props += "}"
+			}
+		}
+		sw.topo.add(opts.id, opts.o, props)
+		return sw.nextItem(keepGoing)
+	}
 	switch sw.msg.OutputType {
 	case JSON:
-		var wr bytes.Buffer
-		var jsfields string
+		buf := sw.jbuf[:0]
 		if sw.once {
-			wr.WriteByte(',')
+			buf = append(buf, ',')
 		} else {
 			sw.once = true
 		}
-		if sw.hasFieldsOutput() {
-			if sw.fullFields {
-				if len(sw.fmap) > 0 {
-					jsfields = `,"fields":{`
-					var i int
-					for field, idx := range sw.fmap {
-						if len(opts.fields) > idx {
-							if opts.fields[idx] != 0 {
-								if i > 0 {
-									jsfields += `,`
-								}
-								jsfields += jsonString(field) + ":" + strconv.FormatFloat(opts.fields[idx], 'f', -1, 64)
-								i++
-							}
-						}
-					}
-					jsfields += `}`
-				}
-
-			} else if len(sw.farr) > 0 {
-				jsfields = `,"fields":[`
-				for i, name := range sw.farr {
-					if i > 0 {
-						jsfields += `,`
-					}
-					j := sw.fmap[name]
-					if j < len(opts.fields) {
-						jsfields += strconv.FormatFloat(opts.fields[j], 'f', -1, 64)
-					} else {
-						jsfields += "0"
-					}
-				}
-				jsfields += `]`
-			}
-		}
 		if sw.output == outputIDs {
-			wr.WriteString(jsonString(opts.id))
+			buf = appendJSONString(buf, opts.id)
 		} else {
-			wr.WriteString(`{"id":` + jsonString(opts.id))
+			buf = append(buf, `{"id":`...)
+			buf = appendJSONString(buf, opts.id)
 			switch sw.output {
 			case outputObjects:
-				wr.WriteString(`,"object":` + string(opts.o.AppendJSON(nil)))
+				buf = append(buf, `,"object":`...)
+				if opts.clip != nil {
+					buf = opts.o.AppendJSON(buf)
+					if sw.msg.bounds {
+						buf = appendJSONObjectBounds(buf, nil, opts.o)
+					}
+				} else {
+					buf = sw.appendObjectJSON(buf, opts.o)
+					if sw.msg.bounds {
+						buf = appendJSONObjectBounds(buf, sw.col, opts.o)
+					}
+				}
 			case outputPoints:
-				wr.WriteString(`,"point":` + string(appendJSONSimplePoint(nil, opts.o)))
+				buf = append(buf, `,"point":`...)
+				buf = appendJSONSimplePoint(buf, opts.o)
 			case outputHashes:
 				center := opts.o.Center()
 				p := geohash.EncodeWithPrecision(center.Y, center.X, uint(sw.precision))
-				wr.WriteString(`,"hash":"` + p + `"`)
+				buf = append(buf, `,"hash":"`...)
+				buf = append(buf, p...)
+				buf = append(buf, '"')
+			case outputH3:
+				buf = append(buf, `,"h3":"`...)
+				buf = append(buf, h3Cell(opts.o, int(sw.precision))...)
+				buf = append(buf, '"')
 			case outputBounds:
-				wr.WriteString(`,"bounds":` + string(appendJSONSimpleBounds(nil, opts.o)))
+				buf = append(buf, `,"bounds":`...)
+				buf = appendJSONSimpleBounds(buf, opts.o)
+			}
+			if sw.hasFieldsOutput() {
+				buf = sw.appendJSONFields(buf, opts.fields)
 			}
-
-			wr.WriteString(jsfields)
-
 			if opts.distOutput || opts.distance > 0 {
-				wr.WriteString(`,"distance":` + strconv.FormatFloat(opts.distance, 'f', -1, 64))
+				buf = append(buf, `,"distance":`...)
+				buf = strconv.AppendFloat(buf, opts.distance, 'f', -1, 64)
 			}
-
-			wr.WriteString(`}`)
+			if opts.scoreOutput {
+				buf = append(buf, `,"score":`...)
+				buf = strconv.AppendFloat(buf, opts.score, 'f', -1, 64)
+			}
+			buf = append(buf, '}')
+		}
+		sw.wr.Write(buf)
+		sw.jbuf = buf
+		if !sw.flushStream() {
+			return false
 		}
-		sw.wr.Write(wr.Bytes())
 	case RESP:
 		vals := make([]resp.Value, 1, 3)
 		vals[0] = resp.StringValue(opts.id)
@@ -449,7 +558,11 @@ func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 		} else {
 			switch sw.output {
 			case outputObjects:
-				vals = append(vals, resp.StringValue(opts.o.String()))
+				if opts.clip != nil {
+					vals = append(vals, resp.StringValue(opts.o.String()))
+				} else {
+					vals = append(vals, resp.StringValue(sw.objectString(opts.o)))
+				}
 			case outputPoints:
 				point := opts.o.Center()
 				var z float64
@@ -472,6 +585,8 @@ func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 				center := opts.o.Center()
 				p := geohash.EncodeWithPrecision(center.Y, center.X, uint(sw.precision))
 				vals = append(vals, resp.StringValue(p))
+			case outputH3:
+				vals = append(vals, resp.StringValue(h3Cell(opts.o, int(sw.precision))))
 			case outputBounds:
 				bbox := opts.o.Rect()
 				vals = append(vals, resp.ArrayValue([]resp.Value{
@@ -491,7 +606,7 @@ func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 				if len(fvs) > 0 {
 					fvals := make([]resp.Value, 0, len(fvs)*2)
 					for i, fv := range fvs {
-						fvals = append(fvals, resp.StringValue(fv.field), resp.StringValue(strconv.FormatFloat(fv.value, 'f', -1, 64)))
+						fvals = append(fvals, resp.StringValue(fv.field), resp.StringValue(fv.value.String()))
 						i++
 					}
 					vals = append(vals, resp.ArrayValue(fvals))
@@ -500,10 +615,75 @@ func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 			if opts.distOutput || opts.distance > 0 {
 				vals = append(vals, resp.FloatValue(opts.distance))
 			}
+			if opts.scoreOutput {
+				vals = append(vals, resp.FloatValue(opts.score))
+			}
 
 			sw.values = append(sw.values, resp.ArrayValue(vals))
 		}
 	}
+	return sw.nextItem(keepGoing)
+}
+
+// appendJSONFields appends the fields of an object to its json.
+func (sw *scanWriter) appendJSONFields(dst []byte, fields []field.Value,
+) []byte {
+	if sw.fullFields {
+		if len(sw.fmap) == 0 {
+			return dst
+		}
+		dst = append(dst, `,"fields":{`...)
+		var i int
+		for name, idx := range sw.fmap {
+			if idx < len(fields) && !fields[idx].IsZero() {
+				if i > 0 {
+					dst = append(dst, ',')
+				}
+				dst = appendJSONString(dst, name)
+				dst = append(dst, ':')
+				dst = fields[idx].AppendJSON(dst)
+				i++
+			}
+		}
+		return append(dst, '}')
+	}
+	if len(sw.farr) == 0 {
+		return dst
+	}
+	dst = append(dst, `,"fields":[`...)
+	for i, name := range sw.farr {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		if j := sw.fmap[name]; j < len(fields) {
+			dst = fields[j].AppendJSON(dst)
+		} else {
+			dst = append(dst, '0')
+		}
+	}
+	return append(dst, ']')
+}
+
+// objectString returns the text of an object of the key, which is the json
+// that is cached by the collection for the large objects.
+func (sw *scanWriter) objectString(o geojson.Object) string {
+	if sw.col == nil || !objIsSpatial(o) {
+		return o.String()
+	}
+	return sw.col.ObjectJSON(o)
+}
+
+// appendObjectJSON appends the json of an object of the key, see objectJSON.
+func (sw *scanWriter) appendObjectJSON(dst []byte, o geojson.Object) []byte {
+	if sw.col == nil {
+		return o.AppendJSON(dst)
+	}
+	return sw.col.AppendObjectJSON(dst, o)
+}
+
+// nextItem counts an object that was written, and returns false when the
+// limit is reached.
+func (sw *scanWriter) nextItem(keepGoing bool) bool {
 	sw.numberItems++
 	if sw.numberItems == sw.limit {
 		sw.hitLimit = true
@@ -511,3 +691,30 @@ func (sw *scanWriter) writeObject(opts ScanWriterParams) bool {
 	}
 	return keepGoing
 }
+
+// addSample keeps an object that passed the test for the sample. Every
+// object is equally likely to end up in the sample, no matter the number of
+// objects that follow.
+func (sw *scanWriter) addSample(opts ScanWriterParams) {
+	sw.sampled++
+	opts.skipTesting = true
+	if uint64(len(sw.samples)) < sw.sample {
+		sw.samples = append(sw.samples, opts)
+	} else if i := rand.Int63n(int64(sw.sampled)); uint64(i) < sw.sample {
+		sw.samples[i] = opts
+	}
+}
+
+// writeSample writes the objects of the sample, ordered by id. The caller
+// must hold the lock.
+func (sw *scanWriter) writeSample() {
+	samples := sw.samples
+	sw.sample, sw.samples = 0, nil
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].id < samples[j].id
+	})
+	for _, opts := range samples {
+		opts.noLock = true
+		sw.writeObject(opts)
+	}
+}
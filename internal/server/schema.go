@@ -0,0 +1,381 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+// schemaField is a field declared by a schema
+type schemaField struct {
+	name     string
+	kind     field.Kind
+	required bool
+	min, max *float64
+	enum     []field.Value
+}
+
+// schema declares the fields of the objects of a collection. It is kept by
+// collection key, so it stays in place when the collection is dropped.
+type schema struct {
+	strict bool
+	fields map[string]*schemaField
+	args   []string // the SETSCHEMA arguments following the key
+}
+
+func parseFieldKind(s string) (field.Kind, bool) {
+	for _, kind := range []field.Kind{field.Number, field.String, field.Bool,
+		field.JSON} {
+		if strings.EqualFold(s, kind.String()) {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+// parseSchema parses the arguments of a SETSCHEMA following the key.
+//
+// [STRICT] FIELD name type [REQUIRED] [MIN n] [MAX n] [ENUM count value ...]
+// [FIELD ...]
+func parseSchema(vs []string) (*schema, error) {
+	sc := &schema{fields: make(map[string]*schemaField)}
+	var sf *schemaField
+	var ok bool
+	for len(vs) > 0 {
+		var tok string
+		vs, tok, _ = tokenval(vs)
+		switch {
+		case lc(tok, "strict") && len(sc.fields) == 0:
+			sc.strict = true
+			sc.args = append(sc.args, "strict")
+		case lc(tok, "field"):
+			var name, skind string
+			if vs, name, ok = tokenval(vs); !ok || name == "" {
+				return nil, errInvalidNumberOfArguments
+			}
+			if vs, skind, ok = tokenval(vs); !ok || skind == "" {
+				return nil, errInvalidNumberOfArguments
+			}
+			if isReservedFieldName(name) || sc.fields[name] != nil {
+				return nil, errInvalidArgument(name)
+			}
+			kind, ok := parseFieldKind(skind)
+			if !ok {
+				return nil, errInvalidArgument(skind)
+			}
+			sf = &schemaField{name: name, kind: kind}
+			sc.fields[name] = sf
+			sc.args = append(sc.args, "field", name, kind.String())
+		case sf == nil:
+			return nil, errInvalidArgument(tok)
+		case lc(tok, "required"):
+			sf.required = true
+			sc.args = append(sc.args, "required")
+		case lc(tok, "min"), lc(tok, "max"):
+			var snum string
+			if vs, snum, ok = tokenval(vs); !ok || snum == "" {
+				return nil, errInvalidNumberOfArguments
+			}
+			if sf.kind != field.Number {
+				return nil, errInvalidArgument(tok)
+			}
+			num, err := strconv.ParseFloat(snum, 64)
+			if err != nil {
+				return nil, errInvalidArgument(snum)
+			}
+			if lc(tok, "min") {
+				sf.min = &num
+				sc.args = append(sc.args, "min", snum)
+			} else {
+				sf.max = &num
+				sc.args = append(sc.args, "max", snum)
+			}
+		case lc(tok, "enum"):
+			var scount string
+			if vs, scount, ok = tokenval(vs); !ok || scount == "" {
+				return nil, errInvalidNumberOfArguments
+			}
+			n, err := strconv.ParseUint(scount, 10, 64)
+			if err != nil || n == 0 {
+				return nil, errInvalidArgument(scount)
+			}
+			if uint64(len(vs)) < n {
+				return nil, errInvalidNumberOfArguments
+			}
+			sc.args = append(sc.args, "enum", scount)
+			for _, s := range vs[:n] {
+				value := field.Parse(s)
+				if value.Kind() != sf.kind {
+					return nil, errInvalidArgument(s)
+				}
+				sf.enum = append(sf.enum, value)
+				sc.args = append(sc.args, s)
+			}
+			vs = vs[n:]
+		default:
+			return nil, errInvalidArgument(tok)
+		}
+	}
+	if len(sc.fields) == 0 {
+		return nil, errInvalidNumberOfArguments
+	}
+	return sc, nil
+}
+
+// check returns an error when a field may not be set to the value. Setting
+// a field to zero removes it.
+func (sc *schema) check(name string, value field.Value) error {
+	sf := sc.fields[name]
+	if sf == nil {
+		if sc.strict && !value.IsZero() {
+			return fmt.Errorf("field '%s' is not in the schema", name)
+		}
+		return nil
+	}
+	if value.IsZero() {
+		if sf.required {
+			return fmt.Errorf("field '%s' is required", name)
+		}
+		return nil
+	}
+	if value.Kind() != sf.kind {
+		return fmt.Errorf("field '%s' must be of type %s", name, sf.kind)
+	}
+	if sf.min != nil && value.Num() < *sf.min {
+		return fmt.Errorf("field '%s' must be at least %s", name,
+			strconv.FormatFloat(*sf.min, 'f', -1, 64))
+	}
+	if sf.max != nil && value.Num() > *sf.max {
+		return fmt.Errorf("field '%s' must be at most %s", name,
+			strconv.FormatFloat(*sf.max, 'f', -1, 64))
+	}
+	if len(sf.enum) > 0 {
+		for _, v := range sf.enum {
+			if v == value {
+				return nil
+			}
+		}
+		var opts []string
+		for _, v := range sf.enum {
+			opts = append(opts, v.String())
+		}
+		return fmt.Errorf("field '%s' must be one of %s", name,
+			strings.Join(opts, ", "))
+	}
+	return nil
+}
+
+// checkSet returns an error when an object of the collection may not be set
+// with the fields. The object keeps the fields that it already has, so the
+// required fields may be set by an earlier write. Use a nil collection for a
+// collection that does not exist yet.
+func (sc *schema) checkSet(col *collection.Collection, id string,
+	fields []string, values []field.Value,
+) error {
+	set := make(map[string]bool, len(fields))
+	for i, name := range fields {
+		if err := sc.check(name, values[i]); err != nil {
+			return err
+		}
+		set[name] = !values[i].IsZero()
+	}
+	var fmap map[string]int
+	var old []field.Value
+	if col != nil {
+		if _, values, ok := col.Get(id); ok {
+			fmap, old = col.FieldMap(), values
+		}
+	}
+	var names []string
+	for name, sf := range sc.fields {
+		if sf.required {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if isSet, ok := set[name]; ok {
+			if isSet {
+				continue
+			}
+		} else if idx, ok := fmap[name]; ok && idx < len(old) &&
+			!old[idx].IsZero() {
+			continue
+		}
+		return fmt.Errorf("field '%s' is required", name)
+	}
+	return nil
+}
+
+// checkSchema returns an error when the object with the fields may not be
+// set in the collection. The caller must hold the server lock.
+func (s *Server) checkSchema(key, id string, fields []string,
+	values []field.Value,
+) error {
+	sc := s.schemas[key]
+	if sc == nil {
+		return nil
+	}
+	return sc.checkSet(s.getCol(key), id, fields, values)
+}
+
+// checkSchemaFields returns an error when the fields of an existing object
+// may not be changed to the values. The caller must hold the server lock.
+func (s *Server) checkSchemaFields(key string, fields []string,
+	values []field.Value,
+) error {
+	sc := s.schemas[key]
+	if sc == nil {
+		return nil
+	}
+	for i, name := range fields {
+		if err := sc.check(name, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SETSCHEMA key [STRICT] FIELD name type [REQUIRED] [MIN n] [MAX n]
+// [ENUM count value ...] [FIELD ...]
+//
+// Sets the schema of a collection. The objects that are already stored are
+// not checked, only the writes that follow.
+func (s *Server) cmdSetSchema(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	sc, err := parseSchema(vs)
+	if err != nil {
+		return NOMessage, d, err
+	}
+	s.schemas[d.key] = sc
+	d.updated = true
+	d.timestamp = time.Now()
+	return OKMessage(msg, start), d, nil
+}
+
+// DELSCHEMA key
+func (s *Server) cmdDelSchema(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var ok bool
+	if vs, d.key, ok = tokenval(vs); !ok || d.key == "" || len(vs) != 0 {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if _, ok := s.schemas[d.key]; ok {
+		delete(s.schemas, d.key)
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+	switch msg.OutputType {
+	case JSON:
+		return OKMessage(msg, start), d, nil
+	case RESP:
+		if d.updated {
+			return resp.IntegerValue(1), d, nil
+		}
+		return resp.IntegerValue(0), d, nil
+	}
+	return NOMessage, d, nil
+}
+
+// SCHEMA key
+func (s *Server) cmdSchema(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var key string
+	var ok bool
+	if vs, key, ok = tokenval(vs); !ok || key == "" || len(vs) != 0 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	sc := s.schemas[key]
+	switch msg.OutputType {
+	case JSON:
+		if sc == nil {
+			return NOMessage, errKeyNotFound
+		}
+		var names []string
+		for name := range sc.fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		buf := &bytes.Buffer{}
+		buf.WriteString(`{"ok":true,"schema":{"strict":`)
+		buf.WriteString(strconv.FormatBool(sc.strict))
+		buf.WriteString(`,"fields":[`)
+		for i, name := range names {
+			sf := sc.fields[name]
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"name":` + jsonString(sf.name))
+			buf.WriteString(`,"type":` + jsonString(sf.kind.String()))
+			buf.WriteString(`,"required":` + strconv.FormatBool(sf.required))
+			if sf.min != nil {
+				buf.WriteString(`,"min":` +
+					strconv.FormatFloat(*sf.min, 'f', -1, 64))
+			}
+			if sf.max != nil {
+				buf.WriteString(`,"max":` +
+					strconv.FormatFloat(*sf.max, 'f', -1, 64))
+			}
+			if len(sf.enum) > 0 {
+				buf.WriteString(`,"enum":[`)
+				for i, v := range sf.enum {
+					if i > 0 {
+						buf.WriteByte(',')
+					}
+					buf.WriteString(v.JSON())
+				}
+				buf.WriteByte(']')
+			}
+			buf.WriteByte('}')
+		}
+		buf.WriteString(`]},"elapsed":"` + time.Since(start).String() + "\"}")
+		return resp.StringValue(buf.String()), nil
+	case RESP:
+		if sc == nil {
+			return resp.NullValue(), nil
+		}
+		vals := make([]resp.Value, len(sc.args))
+		for i, arg := range sc.args {
+			vals[i] = resp.StringValue(arg)
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
+// schemaCommands returns the commands needed to recreate the schemas of the
+// keys, or of every key when keys is nil. The caller must hold the server
+// lock.
+func (s *Server) schemaCommands(keys []string) (cmds [][]string) {
+	if keys == nil {
+		for key := range s.schemas {
+			keys = append(keys, key)
+		}
+		// sort the keys for consistency
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		if sc := s.schemas[key]; sc != nil {
+			cmds = append(cmds, append([]string{"setschema", key}, sc.args...))
+		}
+	}
+	return cmds
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/geojson/geo"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+// scoreExpr is the expression of the SCORE of a NEARBY, which ranks the
+// objects by their distance and fields, such as "distance + (100 - battery)
+// * 10". The expression has numbers, the distance in meters, the numeric
+// values of the fields, which are 0 for the missing fields and the strings,
+// the + - * / % operators, parentheses, and the abs, min, max, sqrt, log,
+// and pow functions. A division by zero, or a function outside of its
+// domain, is 0, so that every score is a number.
+type scoreExpr struct {
+	src  string
+	eval func(v *scoreVars) float64
+}
+
+// scoreVars are the values of the variables of a score expression.
+type scoreVars struct {
+	distance float64
+	fields   []field.Value
+	fmap     map[string]int
+}
+
+// scoreFuncs are the functions of a score expression, with their number of
+// arguments, or -1 for one or more.
+var scoreFuncs = map[string]int{
+	"abs": 1, "sqrt": 1, "log": 1, "pow": 2, "min": -1, "max": -1,
+}
+
+// parseScoreExpr parses the expression of a SCORE.
+func parseScoreExpr(src string) (*scoreExpr, error) {
+	p := &scoreParser{src: src}
+	p.next()
+	eval, err := p.parseSum()
+	if err == nil && p.tok != "" {
+		err = errors.New("unexpected '" + p.tok + "'")
+	}
+	if err != nil {
+		return nil, errors.New("invalid score expression '" + src + "': " +
+			err.Error())
+	}
+	return &scoreExpr{src: src, eval: eval}, nil
+}
+
+// score returns the score of an object of a NEARBY, with its distance,
+// which is a haversine for the spherical model, and its fields.
+func (e *scoreExpr) score(model distModel, dist float64,
+	fields []field.Value, fmap map[string]int,
+) float64 {
+	if model == modelSpherical {
+		dist = geo.DistanceFromHaversine(dist)
+	}
+	v := e.eval(&scoreVars{distance: dist, fields: fields, fmap: fmap})
+	switch {
+	case math.IsNaN(v):
+		return 0
+	case math.IsInf(v, 1):
+		return math.MaxFloat64
+	case math.IsInf(v, -1):
+		return -math.MaxFloat64
+	}
+	return v
+}
+
+// scoreParser is a recursive descent parser of score expressions.
+type scoreParser struct {
+	src string
+	pos int
+	tok string // the current token, or empty at the end
+}
+
+// next reads the next token, which is a number, a name, or an operator.
+func (p *scoreParser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c >= '0' && c <= '9' || c == '.':
+			for p.pos < len(p.src) && (isScoreNameChar(p.src[p.pos]) ||
+				p.src[p.pos] == '.' || ((p.src[p.pos] == '-' ||
+				p.src[p.pos] == '+') && (p.src[p.pos-1] == 'e' ||
+				p.src[p.pos-1] == 'E'))) {
+				p.pos++
+			}
+		case isScoreNameChar(c):
+			for p.pos < len(p.src) && (isScoreNameChar(p.src[p.pos]) ||
+				p.src[p.pos] == '.') {
+				p.pos++
+			}
+		default:
+			p.pos++
+		}
+	}
+	p.tok = p.src[start:p.pos]
+}
+
+func isScoreNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_'
+}
+
+// parseSum parses the terms of an addition or a subtraction.
+func (p *scoreParser) parseSum() (func(v *scoreVars) float64, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok
+		p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "+" {
+			left = func(v *scoreVars) float64 { return l(v) + right(v) }
+		} else {
+			left = func(v *scoreVars) float64 { return l(v) - right(v) }
+		}
+	}
+	return left, nil
+}
+
+// parseProduct parses the factors of a multiplication, a division, or a
+// modulo.
+func (p *scoreParser) parseProduct() (func(v *scoreVars) float64, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "*" || p.tok == "/" || p.tok == "%" {
+		op := p.tok
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		switch op {
+		case "*":
+			left = func(v *scoreVars) float64 { return l(v) * right(v) }
+		case "/":
+			left = func(v *scoreVars) float64 {
+				if d := right(v); d != 0 {
+					return l(v) / d
+				}
+				return 0
+			}
+		default:
+			left = func(v *scoreVars) float64 {
+				if d := right(v); d != 0 {
+					return math.Mod(l(v), d)
+				}
+				return 0
+			}
+		}
+	}
+	return left, nil
+}
+
+// parseUnary parses a negation, a number, a variable, a function, or an
+// expression in parentheses.
+func (p *scoreParser) parseUnary() (func(v *scoreVars) float64, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, errors.New("unexpected end")
+	case tok == "-" || tok == "+":
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil || tok == "+" {
+			return operand, err
+		}
+		return func(v *scoreVars) float64 { return -operand(v) }, nil
+	case tok == "(":
+		p.next()
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, errors.New("missing ')'")
+		}
+		p.next()
+		return inner, nil
+	case tok[0] >= '0' && tok[0] <= '9' || tok[0] == '.':
+		n, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, errors.New("invalid number '" + tok + "'")
+		}
+		p.next()
+		return func(v *scoreVars) float64 { return n }, nil
+	case isScoreNameChar(tok[0]):
+		p.next()
+		if p.tok == "(" {
+			return p.parseFunc(strings.ToLower(tok))
+		}
+		if tok == "distance" {
+			return func(v *scoreVars) float64 { return v.distance }, nil
+		}
+		return func(v *scoreVars) float64 {
+			if idx, ok := v.fmap[tok]; ok && idx < len(v.fields) {
+				return v.fields[idx].Num()
+			}
+			return 0
+		}, nil
+	}
+	return nil, errors.New("unexpected '" + tok + "'")
+}
+
+// parseFunc parses the arguments of a function, following its name.
+func (p *scoreParser) parseFunc(name string) (func(v *scoreVars) float64,
+	error,
+) {
+	nargs, ok := scoreFuncs[name]
+	if !ok {
+		return nil, errors.New("unknown function '" + name + "'")
+	}
+	p.next()
+	var args []func(v *scoreVars) float64
+	for p.tok != ")" {
+		if len(args) > 0 {
+			if p.tok != "," {
+				return nil, errors.New("missing ')'")
+			}
+			p.next()
+		}
+		arg, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	if (nargs == -1 && len(args) == 0) || (nargs != -1 && len(args) != nargs) {
+		return nil, errors.New("wrong number of arguments for '" + name + "'")
+	}
+	a := args[0]
+	switch name {
+	case "abs":
+		return func(v *scoreVars) float64 { return math.Abs(a(v)) }, nil
+	case "sqrt":
+		return func(v *scoreVars) float64 {
+			if x := a(v); x >= 0 {
+				return math.Sqrt(x)
+			}
+			return 0
+		}, nil
+	case "log":
+		return func(v *scoreVars) float64 {
+			if x := a(v); x > 0 {
+				return math.Log(x)
+			}
+			return 0
+		}, nil
+	case "pow":
+		b := args[1]
+		return func(v *scoreVars) float64 { return math.Pow(a(v), b(v)) }, nil
+	}
+	max := name == "max"
+	return func(v *scoreVars) float64 {
+		m := a(v)
+		for _, arg := range args[1:] {
+			if x := arg(v); (x > m) == max && x != m {
+				m = x
+			}
+		}
+		return m
+	}, nil
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tidwall/tile38/internal/field"
+)
+
+func TestScoreExpr(t *testing.T) {
+	fmap := map[string]int{"battery": 0, "name": 1}
+	fields := []field.Value{field.Num(40), field.Str("truck")}
+	for _, tc := range []struct {
+		src    string
+		expect float64
+	}{
+		{"distance", 500},
+		{"distance + (100 - battery) * 10", 1100},
+		{"-battery + 2 * 3", -34},
+		{"battery % 7 / 2", 2.5},
+		{"battery / (distance - 500)", 0},
+		{"name + missing", 0},
+		{"min(battery, 10, distance) + max(1, 2)", 12},
+		{"abs(-2) + sqrt(16) + pow(2, 3) + log(1)", 14},
+		{"sqrt(-1) + log(0)", 0},
+		{"1e3 + .5", 1000.5},
+		{"pow(10, 400)", math.MaxFloat64},
+	} {
+		e, err := parseScoreExpr(tc.src)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.src, err)
+		}
+		if v := e.score(modelPlanar, 500, fields, fmap); v != tc.expect {
+			t.Fatalf("%s: expected %v, got %v", tc.src, tc.expect, v)
+		}
+	}
+	for _, src := range []string{
+		"", "battery +", "(battery", "battery)", "2x", "nope(1)", "pow(1)",
+		"min()", "battery $ 2",
+	} {
+		if _, err := parseScoreExpr(src); err == nil {
+			t.Fatalf("%s: expected an error", src)
+		}
+	}
+}
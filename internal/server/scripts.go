@@ -36,10 +36,11 @@ var errTimeout = errors.New("timeout")
 
 // Go-routine-safe pool of read-to-go lua states
 type lStatePool struct {
-	m     sync.Mutex
-	s     *Server
-	saved []*lua.LState
-	total int
+	m       sync.Mutex
+	s       *Server
+	saved   []*lua.LState
+	total   int
+	callers map[*lua.LState]*Client // the clients of the scripts, see setCaller
 }
 
 // newPool returns a new pool of lua states
@@ -110,7 +111,7 @@ func (pl *lStatePool) New() *lua.LState {
 	call := func(ls *lua.LState) int {
 		evalCmd, args := getArgs(ls)
 		var numRet int
-		if res, err := pl.s.luaTile38Call(evalCmd, args[0], args[1:]...); err != nil {
+		if res, err := pl.s.luaTile38Call(evalCmd, pl.caller(L), args[0], args[1:]...); err != nil {
 			ls.RaiseError("ERR %s", err.Error())
 			numRet = 0
 		} else {
@@ -121,7 +122,7 @@ func (pl *lStatePool) New() *lua.LState {
 	}
 	pcall := func(ls *lua.LState) int {
 		evalCmd, args := getArgs(ls)
-		if res, err := pl.s.luaTile38Call(evalCmd, args[0], args[1:]...); err != nil {
+		if res, err := pl.s.luaTile38Call(evalCmd, pl.caller(L), args[0], args[1:]...); err != nil {
 			ls.Push(ConvertToLua(ls, resp.ErrorValue(err)))
 		} else {
 			ls.Push(ConvertToLua(ls, res))
@@ -156,12 +157,13 @@ func (pl *lStatePool) New() *lua.LState {
 		return 1
 	}
 	var exports = map[string]lua.LGFunction{
-		"call":         call,
-		"pcall":        pcall,
-		"error_reply":  errorReply,
-		"status_reply": statusReply,
-		"sha1hex":      sha1hex,
-		"distance_to":  distanceTo,
+		"call":              call,
+		"pcall":             pcall,
+		"error_reply":       errorReply,
+		"status_reply":      statusReply,
+		"sha1hex":           sha1hex,
+		"distance_to":       distanceTo,
+		"register_function": luaRegisterFunction,
 	}
 	L.SetGlobal("tile38", L.SetFuncs(L.NewTable(), exports))
 
@@ -182,10 +184,31 @@ func (pl *lStatePool) New() *lua.LState {
 
 func (pl *lStatePool) Put(L *lua.LState) {
 	pl.m.Lock()
+	delete(pl.callers, L)
 	pl.saved = append(pl.saved, L)
 	pl.m.Unlock()
 }
 
+// setCaller sets the client that runs a script on a state until the state is
+// put back, whose permissions are those of the calls of the script, see
+// luaTile38Call.
+func (pl *lStatePool) setCaller(L *lua.LState, client *Client) {
+	pl.m.Lock()
+	if pl.callers == nil {
+		pl.callers = make(map[*lua.LState]*Client)
+	}
+	pl.callers[L] = client
+	pl.m.Unlock()
+}
+
+// caller returns the client that runs a script on a state, or nil for the
+// scripts of the server, such as the triggers.
+func (pl *lStatePool) caller(L *lua.LState) *Client {
+	pl.m.Lock()
+	defer pl.m.Unlock()
+	return pl.callers[L]
+}
+
 func (pl *lStatePool) Shutdown() {
 	pl.m.Lock()
 	for _, L := range pl.saved {
@@ -370,7 +393,7 @@ func makeSafeErr(err error) error {
 }
 
 // Run eval/evalro/evalna command or it's -sha variant
-func (s *Server) cmdEvalUnified(scriptIsSha bool, msg *Message) (res resp.Value, err error) {
+func (s *Server) cmdEvalUnified(scriptIsSha bool, msg *Message, client *Client) (res resp.Value, err error) {
 	start := time.Now()
 	vs := msg.Args[1:]
 
@@ -404,6 +427,7 @@ func (s *Server) cmdEvalUnified(scriptIsSha bool, msg *Message) (res resp.Value,
 		luaDeadline = lua.LNumber(float64(dlTime.UnixNano()) / 1e9)
 	}
 	defer s.luapool.Put(luaState)
+	s.luapool.setCaller(luaState, client)
 
 	keysTbl := luaState.CreateTable(int(numkeys), 0)
 	for i = 0; i < numkeys; i++ {
@@ -585,7 +609,7 @@ func (s *Server) cmdScriptFlush(msg *Message) (resp.Value, error) {
 	return resp.SimpleStringValue(""), nil
 }
 
-func (s *Server) commandInScript(msg *Message) (
+func (s *Server) commandInScript(msg *Message, client *Client) (
 	res resp.Value, d commandDetails, err error,
 ) {
 	switch msg.Command() {
@@ -593,10 +617,16 @@ func (s *Server) commandInScript(msg *Message) (
 		err = fmt.Errorf("unknown command '%s'", msg.Args[0])
 	case "set":
 		res, d, err = s.cmdSet(msg, true)
+	case "lww":
+		res, d, err = s.cmdLWW(msg)
 	case "fset":
 		res, d, err = s.cmdFset(msg)
+	case "fincrby":
+		res, d, err = s.cmdFincrby(msg)
 	case "del":
 		res, d, err = s.cmdDel(msg)
+	case "pset":
+		res, d, err = s.cmdPset(msg)
 	case "pdel":
 		res, d, err = s.cmdPdel(msg)
 	case "drop":
@@ -607,6 +637,8 @@ func (s *Server) commandInScript(msg *Message) (
 		res, d, err = s.cmdRename(msg, false)
 	case "renamenx":
 		res, d, err = s.cmdRename(msg, true)
+	case "copy":
+		res, d, err = s.cmdCopy(msg)
 	case "persist":
 		res, d, err = s.cmdPersist(msg)
 	case "ttl":
@@ -629,79 +661,118 @@ func (s *Server) commandInScript(msg *Message) (
 		res, err = s.cmdGet(msg)
 	case "jget":
 		res, err = s.cmdJget(msg)
+	case "matrix":
+		res, err = s.cmdMatrix(msg)
+	case "tile":
+		res, err = s.cmdTile(msg)
+	case "history":
+		res, err = s.cmdHistory(msg)
+	case "labels":
+		res, err = s.cmdLabels(msg)
+	case "trajectory":
+		res, err = s.cmdTrajectory(msg)
+	case "passed":
+		res, err = s.cmdPassed(msg)
+	case "memory":
+		res, err = s.cmdMemory(msg)
 	case "jset":
 		res, d, err = s.cmdJset(msg)
 	case "jdel":
 		res, d, err = s.cmdJdel(msg)
 	case "type":
 		res, err = s.cmdType(msg)
+	case "exists":
+		res, err = s.cmdExists(msg)
 	case "keys":
-		res, err = s.cmdKeys(msg)
+		res, err = s.cmdKeys(msg, client)
 	case "test":
 		res, err = s.cmdTest(msg)
 	case "server":
 		res, err = s.cmdServer(msg)
+	case "publish":
+		res, err = s.cmdPublish(msg)
 	}
 	s.sendMonitor(err, msg, nil, true)
 	return
 }
 
-func (s *Server) luaTile38Call(evalcmd string, cmd string, args ...string) (resp.Value, error) {
+func (s *Server) luaTile38Call(evalcmd string, client *Client, cmd string, args ...string) (resp.Value, error) {
 	msg := &Message{}
 	msg.OutputType = RESP
 	msg.Args = append([]string{cmd}, args...)
+	if !s.renameCommand(msg) {
+		return resp.NullValue(), fmt.Errorf("unknown command '%s'", cmd)
+	}
+	if client != nil {
+		// the calls of a script have the permissions of its client, for
+		// the commands and for all of their keys.
+		if err := s.aclPermit(msg, client); err != nil {
+			return resp.NullValue(), err
+		}
+	}
 
 	if msg.Command() == "timeout" {
 		if err := rewriteTimeoutMsg(msg); err != nil {
 			return resp.NullValue(), err
 		}
 	}
+	s.queryDeadline(msg)
 
 	switch msg.Command() {
 	case "ping", "echo", "auth", "massinsert", "shutdown", "gc",
-		"sethook", "pdelhook", "delhook",
-		"follow", "readonly", "config", "output", "client",
-		"aofshrink",
+		"sethook", "pdelhook", "delhook", "renamehook",
+		"pausehook", "ppausehook", "resumehook", "presumehook",
+		"setschema", "delschema", "setlimits", "dellimits", "setindex", "delindex", "setgeoindex",
+		"setlabel", "dellabel",
+		"follow", "readonly", "config", "output", "client", "slowlog", "latency", "health", "acl", "graphql", "openapi", "command", "select", "namespace",
+		"aofshrink", "reindex", "waitaof", "aofcheck", "save", "bgsave", "backup", "restore",
+		"import", "export", "dump", "hookreplay", "raft", "wait", "waitoffset", "peer", "lww", "hooklease", "cluster", "migrate",
 		"script load", "script exists", "script flush",
-		"eval", "evalsha", "evalro", "evalrosha", "evalna", "evalnasha":
+		"eval", "evalsha", "evalro", "evalrosha", "evalna", "evalnasha",
+		"function", "fcall", "fcall_ro", "settrigger", "deltrigger":
 		return resp.NullValue(), errCmdNotSupported
 	}
 
 	switch evalcmd {
 	case "eval", "evalsha":
-		return s.luaTile38AtomicRW(msg)
+		return s.luaTile38AtomicRW(msg, client)
 	case "evalro", "evalrosha":
-		return s.luaTile38AtomicRO(msg)
+		return s.luaTile38AtomicRO(msg, client)
 	case "evalna", "evalnasha":
-		return s.luaTile38NonAtomic(msg)
+		return s.luaTile38NonAtomic(msg, client)
 	}
 
 	return resp.NullValue(), errCmdNotSupported
 }
 
 // The eval command has already got the lock. No locking on the call from within the script.
-func (s *Server) luaTile38AtomicRW(msg *Message) (resp.Value, error) {
+func (s *Server) luaTile38AtomicRW(msg *Message, client *Client) (resp.Value, error) {
 	var write bool
 
 	switch msg.Command() {
 	default:
 		return resp.NullValue(), errCmdNotSupported
-	case "set", "del", "drop", "fset", "flushdb", "expire", "persist", "jset", "pdel",
-		"rename", "renamenx":
+	case "set", "del", "drop", "fset", "fincrby", "flushdb", "expire", "persist",
+		"jset", "pdel", "pset", "rename", "renamenx", "copy":
 		// write operations
 		write = true
-		if s.config.followHost() != "" {
-			return resp.NullValue(), errNotLeader
+		if !s.isLeader() {
+			return resp.NullValue(), s.notLeaderErr()
 		}
 		if s.config.readOnly() {
 			return resp.NullValue(), errReadOnly
 		}
+		msg = s.lwwStamp(s.timestampStamp(s.jitterStamp(msg)))
 	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks", "search",
-		"ttl", "bounds", "server", "info", "type", "jget", "test":
+		"ttl", "bounds", "server", "info", "type", "exists", "jget", "test", "schema", "limits",
+		"indexes", "geoindex", "matrix", "tile", "history", "trajectory", "passed",
+		"memory", "labels":
 		// read operations
 		if s.config.followHost() != "" && !s.fcuponce {
 			return resp.NullValue(), errCatchingUp
 		}
+	case "publish":
+		// no locking for pubsub
 	}
 
 	res, d, err := func() (res resp.Value, d commandDetails, err error) {
@@ -724,7 +795,7 @@ func (s *Server) luaTile38AtomicRW(msg *Message) (resp.Value, error) {
 				}
 			}()
 		}
-		return s.commandInScript(msg)
+		return s.commandInScript(msg, client)
 	}()
 	if err != nil {
 		return resp.NullValue(), err
@@ -739,22 +810,26 @@ func (s *Server) luaTile38AtomicRW(msg *Message) (resp.Value, error) {
 	return res, nil
 }
 
-func (s *Server) luaTile38AtomicRO(msg *Message) (resp.Value, error) {
+func (s *Server) luaTile38AtomicRO(msg *Message, client *Client) (resp.Value, error) {
 	switch msg.Command() {
 	default:
 		return resp.NullValue(), errCmdNotSupported
 
-	case "set", "del", "drop", "fset", "flushdb", "expire", "persist", "jset", "pdel",
-		"rename", "renamenx":
+	case "set", "del", "drop", "fset", "fincrby", "flushdb", "expire", "persist",
+		"jset", "pdel", "pset", "rename", "renamenx", "copy":
 		// write operations
 		return resp.NullValue(), errReadOnly
 
 	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks", "search",
-		"ttl", "bounds", "server", "info", "type", "jget", "test":
+		"ttl", "bounds", "server", "info", "type", "exists", "jget", "test", "schema", "limits",
+		"indexes", "geoindex", "matrix", "tile", "history", "trajectory", "passed",
+		"memory", "labels":
 		// read operations
 		if s.config.followHost() != "" && !s.fcuponce {
 			return resp.NullValue(), errCatchingUp
 		}
+	case "publish":
+		// no locking for pubsub
 	}
 
 	res, _, err := func() (res resp.Value, d commandDetails, err error) {
@@ -772,7 +847,7 @@ func (s *Server) luaTile38AtomicRO(msg *Message) (resp.Value, error) {
 				}
 			}()
 		}
-		return s.commandInScript(msg)
+		return s.commandInScript(msg, client)
 	}()
 	if err != nil {
 		return resp.NullValue(), err
@@ -781,33 +856,38 @@ func (s *Server) luaTile38AtomicRO(msg *Message) (resp.Value, error) {
 	return res, nil
 }
 
-func (s *Server) luaTile38NonAtomic(msg *Message) (resp.Value, error) {
+func (s *Server) luaTile38NonAtomic(msg *Message, client *Client) (resp.Value, error) {
 	var write bool
 
 	// choose the locking strategy
 	switch msg.Command() {
 	default:
 		return resp.NullValue(), errCmdNotSupported
-	case "set", "del", "drop", "fset", "flushdb", "expire", "persist", "jset", "pdel",
-		"rename", "renamenx":
+	case "set", "del", "drop", "fset", "fincrby", "flushdb", "expire", "persist",
+		"jset", "pdel", "pset", "rename", "renamenx", "copy":
 		// write operations
 		write = true
 		s.mu.Lock()
 		defer s.mu.Unlock()
-		if s.config.followHost() != "" {
-			return resp.NullValue(), errNotLeader
+		if !s.isLeader() {
+			return resp.NullValue(), s.notLeaderErr()
 		}
 		if s.config.readOnly() {
 			return resp.NullValue(), errReadOnly
 		}
+		msg = s.lwwStamp(s.timestampStamp(s.jitterStamp(msg)))
 	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks", "search",
-		"ttl", "bounds", "server", "info", "type", "jget", "test":
+		"ttl", "bounds", "server", "info", "type", "exists", "jget", "test", "schema", "limits",
+		"indexes", "geoindex", "matrix", "tile", "history", "trajectory", "passed",
+		"memory", "labels":
 		// read operations
 		s.mu.RLock()
 		defer s.mu.RUnlock()
 		if s.config.followHost() != "" && !s.fcuponce {
 			return resp.NullValue(), errCatchingUp
 		}
+	case "publish":
+		// no locking for pubsub
 	}
 
 	res, d, err := func() (res resp.Value, d commandDetails, err error) {
@@ -830,7 +910,7 @@ func (s *Server) luaTile38NonAtomic(msg *Message) (resp.Value, error) {
 				}
 			}()
 		}
-		return s.commandInScript(msg)
+		return s.commandInScript(msg, client)
 	}()
 	if err != nil {
 		return resp.NullValue(), err
@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"errors"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -14,7 +15,9 @@ import (
 	"github.com/tidwall/geojson/geometry"
 	"github.com/tidwall/resp"
 	"github.com/tidwall/tile38/internal/bing"
+	"github.com/tidwall/tile38/internal/collection"
 	"github.com/tidwall/tile38/internal/deadline"
+	"github.com/tidwall/tile38/internal/field"
 	"github.com/tidwall/tile38/internal/glob"
 )
 
@@ -25,7 +28,16 @@ type liveFenceSwitches struct {
 	obj    geojson.Object
 	cmd    string
 	roam   roamSwitches
+	join   joinSwitches
+	ref    fenceRef
 	groups map[string]string
+	meters float64 // the radius of a NEARBY to an OBJECT or GET area
+}
+
+// fenceRef is a reference to a stored object that is used as the fence area.
+type fenceRef struct {
+	key string
+	id  string
 }
 
 type roamSwitches struct {
@@ -37,6 +49,13 @@ type roamSwitches struct {
 	scan    string
 }
 
+// joinSwitches are the objects of another collection that are the areas of a
+// WITHIN or INTERSECTS, which is then done for each of them.
+type joinSwitches struct {
+	key     string
+	pattern string
+}
+
 type roamMatch struct {
 	id     string
 	obj    geojson.Object
@@ -155,7 +174,12 @@ func (server *Server) cmdSearchArgs(
 				return
 			}
 		}
-		s.obj = geojson.NewCircle(geometry.Point{X: lon, Y: lat}, meters, defaultCircleSteps)
+		center := geometry.Point{X: lon, Y: lat}
+		if cmd == "nearby" {
+			s.obj = geojson.NewCircle(center, meters, defaultCircleSteps)
+		} else {
+			s.obj = s.model.circle(center, meters)
+		}
 	case "object":
 		if s.clip {
 			err = errInvalidArgument("cannot clip with object")
@@ -166,6 +190,19 @@ func (server *Server) cmdSearchArgs(
 			err = errInvalidNumberOfArguments
 			return
 		}
+		if s.fence && !strings.HasPrefix(strings.TrimSpace(obj), "{") {
+			// the fence area is a reference to another object, which
+			// is followed when it moves.
+			s.ref.key = obj
+			if vs, s.ref.id, ok = tokenval(vs); !ok || s.ref.id == "" {
+				err = errInvalidNumberOfArguments
+				return
+			}
+			if col := server.getCol(s.ref.key); col != nil {
+				s.obj, _, _ = col.Get(s.ref.id)
+			}
+			break
+		}
 		s.obj, err = geojson.Parse(obj, &server.geomParseOpts)
 		if err != nil {
 			return
@@ -209,17 +246,24 @@ func (server *Server) cmdSearchArgs(
 			Min: geometry.Point{X: minLon, Y: minLat},
 			Max: geometry.Point{X: maxLon, Y: maxLat},
 		})
-	case "hash":
+	case "hash", "geohash":
 		var hash string
 		if vs, hash, ok = tokenval(vs); !ok || hash == "" {
 			err = errInvalidNumberOfArguments
 			return
 		}
-		box := geohash.BoundingBox(hash)
-		s.obj = geojson.NewRect(geometry.Rect{
-			Min: geometry.Point{X: box.MinLng, Y: box.MinLat},
-			Max: geometry.Point{X: box.MaxLng, Y: box.MaxLat},
-		})
+		if s.obj, err = geohashArea(hash); err != nil {
+			return
+		}
+	case "hex":
+		var hex string
+		if vs, hex, ok = tokenval(vs); !ok || hex == "" {
+			err = errInvalidNumberOfArguments
+			return
+		}
+		if s.obj, err = h3Area(hex); err != nil {
+			return
+		}
 	case "quadkey":
 		var key string
 		if vs, key, ok = tokenval(vs); !ok || key == "" {
@@ -288,6 +332,18 @@ func (server *Server) cmdSearchArgs(
 			err = errKeyNotFound
 			return
 		}
+		if !s.fence && cmd != "nearby" && glob.IsGlob(id) {
+			if s.cursor != 0 {
+				err = errors.New("CURSOR is not allowed when GET has a pattern")
+				return
+			}
+			if s.stable {
+				err = errors.New("STABLE is not allowed when GET has a pattern")
+				return
+			}
+			s.join.key, s.join.pattern = key, id
+			break
+		}
 		s.obj, _, ok = col.Get(id)
 		if !ok {
 			err = errIDNotFound
@@ -326,22 +382,50 @@ func (server *Server) cmdSearchArgs(
 			s.roam.scan = scan
 		}
 	}
+	if cmd == "nearby" && (ltyp == "object" || ltyp == "get") {
+		if s.fence {
+			err = errors.New("FENCE is not allowed when NEARBY has an area")
+			return
+		}
+		// radius is optional, like the one of a point
+		s.meters = -1
+		var smeters string
+		if vs, smeters, ok = tokenval(vs); ok && smeters != "" {
+			if s.meters, err = strconv.ParseFloat(smeters, 64); err != nil ||
+				s.meters < 0 {
+				err = errInvalidArgument(smeters)
+				return
+			}
+		}
+		switch s.obj.(type) {
+		case *geojson.Point, *geojson.SimplePoint:
+			// the area is searched like a point
+			s.obj = geojson.NewCircle(s.obj.Center(), s.meters,
+				defaultCircleSteps)
+		}
+	}
 	if len(vs) != 0 {
 		err = errInvalidNumberOfArguments
 		return
 	}
+	s.obj = bufferObject(s.obj, s.buffer)
 	return
 }
 
 var nearbyTypes = []string{"point"}
+
+// nearbyAreaTypes are the types of a NEARBY, which may search by distance
+// to an area, such as a route or a region.
+var nearbyAreaTypes = []string{"point", "object", "get"}
 var withinOrIntersectsTypes = []string{
-	"geo", "bounds", "hash", "tile", "quadkey", "get", "object", "circle"}
+	"geo", "bounds", "hash", "geohash", "hex", "tile", "quadkey", "get",
+	"object", "circle"}
 
 func (server *Server) cmdNearby(msg *Message) (res resp.Value, err error) {
 	start := time.Now()
 	vs := msg.Args[1:]
 	wr := &bytes.Buffer{}
-	s, err := server.cmdSearchArgs(false, "nearby", vs, nearbyTypes)
+	s, err := server.cmdSearchArgs(false, "nearby", vs, nearbyAreaTypes)
 	if s.usingLua() {
 		defer s.Close()
 		defer func() {
@@ -355,25 +439,43 @@ func (server *Server) cmdNearby(msg *Message) (res resp.Value, err error) {
 	if err != nil {
 		return NOMessage, err
 	}
+	setQueryTimeout(msg, start, s.searchScanBaseTokens)
 	s.cmd = "nearby"
 	if s.fence {
 		return NOMessage, s
 	}
 	sw, err := server.newScanWriter(
 		wr, msg, s.key, s.output, s.precision, s.glob, false,
-		s.cursor, s.limit, s.wheres, s.whereins, s.whereevals, s.nofields)
+		s.cursor, s.limit, s.wheres, s.whereins, s.whereevals, s.wherestrs,
+		s.nofields, s.agg)
 	if err != nil {
 		return NOMessage, err
 	}
+	if err := sw.setStream(s.stream); err != nil {
+		return NOMessage, err
+	}
+	sw.stable, sw.last.nearby = s.stable, s.after.nearby
+	sw.clusters = s.clusters
+	sw.zrange, sw.zmin, sw.zmax = s.zrange, s.zmin, s.zmax
+	sw.tsrange, sw.tsmin, sw.tsmax = s.tsrange, s.tsmin, s.tsmax
 	if msg.OutputType == JSON {
 		wr.WriteString(`{"ok":true`)
 	}
 	sw.writeHead()
+	sw.releaseRead(false)
 	if sw.col != nil {
-		iter := func(id string, o geojson.Object, fields []float64, dist float64) bool {
+		iter := func(id string, o geojson.Object, fields []field.Value, dist float64) bool {
 			meters := 0.0
 			if s.distance {
-				meters = geo.DistanceFromHaversine(dist)
+				meters = dist
+				if s.model == modelSpherical {
+					meters = geo.DistanceFromHaversine(dist)
+				}
+			}
+			sw.last.dist = dist
+			var score float64
+			if s.score != nil {
+				score = s.score.score(s.model, dist, fields, sw.fmap)
 			}
 			return sw.writeObject(ScanWriterParams{
 				id:              id,
@@ -381,12 +483,18 @@ func (server *Server) cmdNearby(msg *Message) (res resp.Value, err error) {
 				fields:          fields,
 				distance:        meters,
 				distOutput:      s.distance,
+				score:           score,
+				scoreOutput:     s.score != nil,
 				noLock:          true,
 				ignoreGlobMatch: true,
 				skipTesting:     true,
 			})
 		}
-		server.nearestNeighbors(&s, sw, msg.Deadline, s.obj.(*geojson.Circle), iter)
+		if target, ok := s.obj.(*geojson.Circle); ok {
+			server.nearestNeighbors(&s, sw, msg.Deadline, target, iter)
+		} else {
+			server.nearestToArea(&s, sw, msg.Deadline, s.obj, s.meters, iter)
+		}
 	}
 	sw.writeFoot()
 	if msg.OutputType == JSON {
@@ -399,45 +507,246 @@ func (server *Server) cmdNearby(msg *Message) (res resp.Value, err error) {
 type iterItem struct {
 	id     string
 	o      geojson.Object
-	fields []float64
+	fields []field.Value
 	dist   float64
+	score  float64 // the SCORE of a NEARBY
+}
+
+// nearbyBound returns the squared distance, in degrees, beyond which an
+// object cannot be within the meters of the center. The objects of a kNN
+// search come in the order of their distance in degrees, which is not the
+// same as the order of their distance in meters.
+func nearbyBound(center geometry.Point, meters float64, model distModel) float64 {
+	switch model {
+	case modelPlanar:
+		return meters * meters
+	case modelGeodesic:
+		// the geodesic distance is within 1% of the spherical distance
+		meters *= 1.01
+	}
+	minLat, minLon, maxLat, maxLon :=
+		geo.RectFromCenter(center.Y, center.X, meters)
+	dx := math.Max(center.X-minLon, maxLon-center.X)
+	dy := math.Max(center.Y-minLat, maxLat-center.Y)
+	return dx*dx + dy*dy
+}
+
+// nearbyDist returns the squared distance, in degrees, of an object to the
+// center.
+func nearbyDist(center geometry.Point, o geojson.Object) float64 {
+	rect := o.Rect()
+	dx := math.Max(0, math.Max(rect.Min.X-center.X, center.X-rect.Max.X))
+	dy := math.Max(0, math.Max(rect.Min.Y-center.Y, center.Y-rect.Max.Y))
+	return dx*dx + dy*dy
 }
 
 func (server *Server) nearestNeighbors(
 	s *liveFenceSwitches, sw *scanWriter, dl *deadline.Deadline,
 	target *geojson.Circle,
-	iter func(id string, o geojson.Object, fields []float64, dist float64,
+	iter func(id string, o geojson.Object, fields []field.Value, dist float64,
 	) bool) {
+	// The distances of the spherical model are haversines, and the
+	// distances of the other models are meters, or the units of planar
+	// coordinates.
+	center := target.Center()
 	maxDist := target.Haversine()
+	var knnTarget geojson.Object = target
+	if s.model != modelSpherical {
+		maxDist = math.Max(target.Meters(), 0)
+		// the collection skips a search when nothing is in the spherical
+		// circle
+		knnTarget = geojson.NewPoint(center)
+	}
+	var bound float64
+	if maxDist > 0 {
+		bound = nearbyBound(center, target.Meters(), s.model)
+	}
+	// The objects are sorted by a field, or come from a field index, after
+	// all of them were found, and the cursor is then the position in the
+	// sorted objects. The objects of a stable search are also found before
+	// they're sorted, and they follow the page token.
+	plan := sw.indexPlan()
+	sorted := s.sortBy != "" || s.score != nil || plan != nil || sw.stable
+	var cursor collection.Cursor = sw
+	limit := sw.limit
+	if sorted {
+		cursor = nil
+		limit = math.MaxUint64
+	}
 	var items []iterItem
-	sw.col.Nearby(target, sw, dl, func(id string, o geojson.Object, fields []float64) bool {
+	visit := func(id string, o geojson.Object, fields []field.Value) bool {
 		if server.hasExpired(s.key, id) {
 			return true
 		}
+		var dist float64
+		if s.model == modelSpherical {
+			dist = target.HaversineTo(o.Center())
+		} else {
+			dist = s.model.distance(center, o.Center())
+		}
+		if maxDist > 0 && dist > maxDist {
+			// an object that is farther in degrees may still be closer
+			// in meters
+			return plan != nil || nearbyDist(center, o) <= bound
+		}
+		if sw.stable && !s.after.follows(dist, id) {
+			return true
+		}
 		ok, keepGoing, _ := sw.testObject(id, o, fields, false)
 		if !ok {
 			return true
 		}
-		dist := target.HaversineTo(o.Center())
-		if maxDist > 0 && dist > maxDist {
-			return false
-		}
 		items = append(items, iterItem{id: id, o: o, fields: fields, dist: dist})
 		if !keepGoing {
 			return false
 		}
-		return uint64(len(items)) < sw.limit
-	})
+		return uint64(len(items)) < limit
+	}
+	if plan != nil {
+		plan.scan(sw.col, nil, dl, func(id string, o geojson.Object,
+			fields []field.Value,
+		) bool {
+			if !objIsSpatial(o) || o.Empty() {
+				return true
+			}
+			return visit(id, o, fields)
+		})
+	} else {
+		sw.col.Nearby(knnTarget, cursor, dl, visit)
+	}
+	iterNearest(s, sw, items, sorted, iter)
+}
+
+// iterNearest iterates over the objects of a NEARBY in the order of their
+// distance, of the field of a SORTBY, or of their SCORE. The objects are
+// iterated from the cursor when all of them were found before they're
+// sorted.
+func iterNearest(s *liveFenceSwitches, sw *scanWriter, items []iterItem,
+	sorted bool,
+	iter func(id string, o geojson.Object, fields []field.Value, dist float64,
+	) bool) {
 	sort.Slice(items, func(i, j int) bool {
-		return items[i].dist < items[j].dist
+		if items[i].dist != items[j].dist {
+			return items[i].dist < items[j].dist
+		}
+		return items[i].id < items[j].id
 	})
+	if s.sortBy != "" {
+		idx, ok := sw.fmap[s.sortBy]
+		value := func(item iterItem) field.Value {
+			if ok && idx < len(item.fields) {
+				return item.fields[idx]
+			}
+			return field.Value{}
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			if s.desc {
+				return field.Less(value(items[j]), value(items[i]))
+			}
+			return field.Less(value(items[i]), value(items[j]))
+		})
+	}
+	if s.score != nil {
+		for i := range items {
+			items[i].score = s.score.score(s.model, items[i].dist,
+				items[i].fields, sw.fmap)
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			if s.desc {
+				return items[j].score < items[i].score
+			}
+			return items[i].score < items[j].score
+		})
+	}
+	if sorted {
+		if sw.cursor >= uint64(len(items)) {
+			items = nil
+		} else {
+			items = items[sw.cursor:]
+		}
+		sw.numberIters = sw.cursor
+	}
 	for _, item := range items {
+		if sorted {
+			sw.Step(1)
+		}
 		if !iter(item.id, item.o, item.fields, item.dist) {
 			return
 		}
 	}
 }
 
+// nearestToArea finds the objects in the order of their distance to an
+// area, which is zero for the objects in the area. The objects are those
+// within the meters of the area, or every object of the collection when the
+// meters are negative, and all of them are found before they're sorted.
+func (server *Server) nearestToArea(
+	s *liveFenceSwitches, sw *scanWriter, dl *deadline.Deadline,
+	area geojson.Object, meters float64,
+	iter func(id string, o geojson.Object, fields []field.Value, dist float64,
+	) bool) {
+	var items []iterItem
+	visit := func(id string, o geojson.Object, fields []field.Value) bool {
+		if !objIsSpatial(o) || o.Empty() || server.hasExpired(s.key, id) {
+			return true
+		}
+		dist := s.model.areaDistance(area, o.Center())
+		if math.IsInf(dist, 0) || (meters >= 0 && dist > meters) {
+			return true
+		}
+		if s.model == modelSpherical {
+			// the distances of the spherical model are haversines
+			dist = geo.DistanceToHaversine(dist)
+		}
+		if sw.stable && !s.after.follows(dist, id) {
+			return true
+		}
+		ok, keepGoing, _ := sw.testObject(id, o, fields, false)
+		if ok {
+			items = append(items, iterItem{id: id, o: o, fields: fields,
+				dist: dist})
+		}
+		return keepGoing
+	}
+	if meters >= 0 {
+		sw.col.Intersects(nearbyAreaRect(area.Rect(), meters, s.model), 0,
+			nil, dl, visit)
+	} else {
+		sw.col.Scan(false, nil, dl, visit)
+	}
+	iterNearest(s, sw, items, true, iter)
+}
+
+// nearbyAreaRect returns the rectangle of the points that may be within the
+// meters of a rectangle.
+func nearbyAreaRect(rect geometry.Rect, meters float64, model distModel,
+) geojson.Object {
+	if model == modelPlanar {
+		return geojson.NewRect(geometry.Rect{
+			Min: geometry.Point{X: rect.Min.X - meters, Y: rect.Min.Y - meters},
+			Max: geometry.Point{X: rect.Max.X + meters, Y: rect.Max.Y + meters},
+		})
+	}
+	if model == modelGeodesic {
+		// the geodesic distance is within 1% of the spherical distance
+		meters *= 1.01
+	}
+	// the degrees of longitude of the meters are more at the corner that is
+	// closest to a pole
+	bounds := rect
+	for _, lat := range []float64{rect.Min.Y, rect.Max.Y} {
+		for _, lon := range []float64{rect.Min.X, rect.Max.X} {
+			minLat, minLon, maxLat, maxLon := geo.RectFromCenter(lat, lon,
+				meters)
+			bounds.Min.X = math.Min(bounds.Min.X, minLon)
+			bounds.Min.Y = math.Min(bounds.Min.Y, minLat)
+			bounds.Max.X = math.Max(bounds.Max.X, maxLon)
+			bounds.Max.Y = math.Max(bounds.Max.Y, maxLat)
+		}
+	}
+	return geojson.NewRect(bounds)
+}
+
 func (server *Server) cmdWithin(msg *Message) (res resp.Value, err error) {
 	return server.cmdWithinOrIntersects("within", msg)
 }
@@ -465,63 +774,267 @@ func (server *Server) cmdWithinOrIntersects(cmd string, msg *Message) (res resp.
 	if err != nil {
 		return NOMessage, err
 	}
+	setQueryTimeout(msg, start, s.searchScanBaseTokens)
 	s.cmd = cmd
 	if s.fence {
 		return NOMessage, s
 	}
+	if s.join.key != "" {
+		if s.stream {
+			// the areas of a join are written one after the other
+			return NOMessage, errInvalidArgument("STREAM")
+		}
+		return server.searchJoin(&s, msg, start)
+	}
 	sw, err := server.newScanWriter(
 		wr, msg, s.key, s.output, s.precision, s.glob, false,
-		s.cursor, s.limit, s.wheres, s.whereins, s.whereevals, s.nofields)
+		s.cursor, s.limit, s.wheres, s.whereins, s.whereevals, s.wherestrs,
+		s.nofields, s.agg)
 	if err != nil {
 		return NOMessage, err
 	}
+	if err := sw.setStream(s.stream); err != nil {
+		return NOMessage, err
+	}
+	sw.stable = s.stable
+	sw.clusters = s.clusters
+	sw.zrange, sw.zmin, sw.zmax = s.zrange, s.zmin, s.zmax
+	sw.tsrange, sw.tsmin, sw.tsmax = s.tsrange, s.tsmin, s.tsmax
+	sw.sample = s.sample
 	if msg.OutputType == JSON {
 		wr.WriteString(`{"ok":true`)
 	}
 	sw.writeHead()
+	sw.releaseRead(false)
 	if sw.col != nil {
-		if cmd == "within" {
-			sw.col.Within(s.obj, s.sparse, sw, msg.Deadline, func(
-				id string, o geojson.Object, fields []float64,
-			) bool {
-				if server.hasExpired(s.key, id) {
-					return true
-				}
-				return sw.writeObject(ScanWriterParams{
-					id:     id,
-					o:      o,
-					fields: fields,
-					noLock: true,
-				})
+		server.searchArea(&s, sw, msg, s.obj)
+	}
+	sw.writeFoot()
+	if msg.OutputType == JSON {
+		wr.WriteString(`,"elapsed":"` + time.Since(start).String() + "\"}")
+		return resp.BytesValue(wr.Bytes()), nil
+	}
+	return sw.respOut, nil
+}
+
+// searchArea writes the objects that are within or intersect an area. The
+// objects of a stable search are written in the order of their ids, after
+// all of them were found.
+func (server *Server) searchArea(
+	s *liveFenceSwitches, sw *scanWriter, msg *Message, area geojson.Object,
+) {
+	if s.sortPoint != nil {
+		server.searchAreaByDistance(s, sw, msg, area)
+		return
+	}
+	if !sw.stable {
+		server.searchAreaObjects(s, sw, sw, msg, area, sw.writeObject)
+		return
+	}
+	var items []ScanWriterParams
+	server.searchAreaObjects(s, sw, nil, msg, area,
+		func(params ScanWriterParams) bool {
+			if params.id > s.after.id {
+				items = append(items, params)
+			}
+			return true
+		},
+	)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].id < items[j].id
+	})
+	for _, params := range items {
+		if !sw.writeObject(params) {
+			return
+		}
+	}
+}
+
+// searchAreaByDistance writes the objects that are within or intersect an
+// area in the order of their distance to the point of a SORTBY DISTANCE,
+// after all of them were found. The cursor is then the position in the
+// sorted objects.
+func (server *Server) searchAreaByDistance(
+	s *liveFenceSwitches, sw *scanWriter, msg *Message, area geojson.Object,
+) {
+	type distItem struct {
+		params ScanWriterParams
+		dist   float64
+	}
+	var items []distItem
+	server.searchAreaObjects(s, sw, nil, msg, area,
+		func(params ScanWriterParams) bool {
+			ok, keepGoing, _ := sw.testObject(params.id, params.o,
+				params.fields, false)
+			if ok {
+				dist := s.model.distance(*s.sortPoint, params.o.Center())
+				items = append(items, distItem{params, dist})
+			}
+			return keepGoing
+		},
+	)
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].dist != items[j].dist {
+			if s.desc {
+				return items[i].dist > items[j].dist
+			}
+			return items[i].dist < items[j].dist
+		}
+		return items[i].params.id < items[j].params.id
+	})
+	if sw.cursor >= uint64(len(items)) {
+		items = nil
+	} else {
+		items = items[sw.cursor:]
+	}
+	sw.numberIters = sw.cursor
+	for _, item := range items {
+		sw.Step(1)
+		params := item.params
+		params.skipTesting = true
+		if s.distance {
+			params.distance = item.dist
+			params.distOutput = true
+		}
+		if !sw.writeObject(params) {
+			return
+		}
+	}
+}
+
+// searchAreaObjects finds the objects that are within or intersect an area.
+func (server *Server) searchAreaObjects(
+	s *liveFenceSwitches, sw *scanWriter, cursor collection.Cursor,
+	msg *Message, area geojson.Object,
+	write func(params ScanWriterParams) bool,
+) {
+	if plan := sw.indexPlan(); plan != nil && s.sparse == 0 {
+		plan.scan(sw.col, cursor, msg.Deadline, func(
+			id string, o geojson.Object, fields []field.Value,
+		) bool {
+			if !objIsSpatial(o) || o.Empty() {
+				return true
+			}
+			if s.cmd == "within" && !o.Within(area) ||
+				s.cmd == "intersects" && !o.Intersects(area) {
+				return true
+			}
+			if server.hasExpired(s.key, id) {
+				return true
+			}
+			params := ScanWriterParams{
+				id:     id,
+				o:      o,
+				fields: fields,
+				noLock: true,
+			}
+			if s.cmd == "intersects" && s.clip {
+				params.clip = area
+			}
+			return write(params)
+		})
+		return
+	}
+	if s.cmd == "within" {
+		sw.col.Within(area, s.sparse, cursor, msg.Deadline, func(
+			id string, o geojson.Object, fields []field.Value,
+		) bool {
+			if server.hasExpired(s.key, id) {
+				return true
+			}
+			return write(ScanWriterParams{
+				id:     id,
+				o:      o,
+				fields: fields,
+				noLock: true,
 			})
-		} else if cmd == "intersects" {
-			sw.col.Intersects(s.obj, s.sparse, sw, msg.Deadline, func(
-				id string,
-				o geojson.Object,
-				fields []float64,
-			) bool {
-				if server.hasExpired(s.key, id) {
-					return true
-				}
-				params := ScanWriterParams{
-					id:     id,
-					o:      o,
-					fields: fields,
-					noLock: true,
-				}
-				if s.clip {
-					params.clip = s.obj
+		})
+	} else if s.cmd == "intersects" {
+		sw.col.Intersects(area, s.sparse, cursor, msg.Deadline, func(
+			id string,
+			o geojson.Object,
+			fields []field.Value,
+		) bool {
+			if server.hasExpired(s.key, id) {
+				return true
+			}
+			params := ScanWriterParams{
+				id:     id,
+				o:      o,
+				fields: fields,
+				noLock: true,
+			}
+			if s.clip {
+				params.clip = area
+			}
+			return write(params)
+		})
+	}
+}
+
+// searchJoin does a WITHIN or INTERSECTS for each object of another
+// collection that matches a pattern, and returns the results of each of them.
+func (server *Server) searchJoin(
+	s *liveFenceSwitches, msg *Message, start time.Time,
+) (resp.Value, error) {
+	type area struct {
+		id  string
+		obj geojson.Object
+	}
+	var areas []area
+	if col := server.getCol(s.join.key); col != nil {
+		col.Scan(false, nil, msg.Deadline,
+			func(id string, o geojson.Object, fields []field.Value) bool {
+				if match, _ := glob.Match(s.join.pattern, id); match &&
+					objIsSpatial(o) && !server.hasExpired(s.join.key, id) {
+					areas = append(areas, area{id, o})
 				}
-				return sw.writeObject(params)
-			})
+				return true
+			},
+		)
+	}
+	wr := &bytes.Buffer{}
+	var values []resp.Value
+	if msg.OutputType == JSON {
+		wr.WriteString(`{"ok":true,"areas":[`)
+	}
+	for i, a := range areas {
+		awr := &bytes.Buffer{}
+		sw, err := server.newScanWriter(
+			awr, msg, s.key, s.output, s.precision, s.glob, false,
+			s.cursor, s.limit, s.wheres, s.whereins, s.whereevals, s.wherestrs,
+			s.nofields, s.agg.fresh())
+		if err != nil {
+			return NOMessage, err
+		}
+		sw.clusters = s.clusters.fresh()
+		sw.zrange, sw.zmin, sw.zmax = s.zrange, s.zmin, s.zmax
+		sw.tsrange, sw.tsmin, sw.tsmax = s.tsrange, s.tsmin, s.tsmax
+		sw.sample = s.sample
+		sw.writeHead()
+		if sw.col != nil {
+			server.searchArea(s, sw, msg, bufferObject(a.obj, s.buffer))
+		}
+		sw.writeFoot()
+		if msg.OutputType == JSON {
+			if i > 0 {
+				wr.WriteByte(',')
+			}
+			wr.WriteString(`{"id":` + jsonString(a.id))
+			wr.Write(awr.Bytes())
+			wr.WriteByte('}')
+		} else {
+			values = append(values, resp.ArrayValue([]resp.Value{
+				resp.StringValue(a.id), sw.respOut,
+			}))
 		}
 	}
-	sw.writeFoot()
 	if msg.OutputType == JSON {
+		wr.WriteString(`],"count":` + strconv.Itoa(len(areas)))
 		wr.WriteString(`,"elapsed":"` + time.Since(start).String() + "\"}")
 		return resp.BytesValue(wr.Bytes()), nil
 	}
-	return sw.respOut, nil
+	return resp.ArrayValue(values), nil
 }
 
 func (server *Server) cmdSeachValuesArgs(vs []string) (
@@ -559,18 +1072,27 @@ func (server *Server) cmdSearch(msg *Message) (res resp.Value, err error) {
 	if err != nil {
 		return NOMessage, err
 	}
+	setQueryTimeout(msg, start, s.searchScanBaseTokens)
 	sw, err := server.newScanWriter(
 		wr, msg, s.key, s.output, s.precision, s.glob, true,
-		s.cursor, s.limit, s.wheres, s.whereins, s.whereevals, s.nofields)
+		s.cursor, s.limit, s.wheres, s.whereins, s.whereevals, s.wherestrs,
+		s.nofields, s.agg)
 	if err != nil {
 		return NOMessage, err
 	}
+	if err := sw.setStream(s.stream); err != nil {
+		return NOMessage, err
+	}
+	sw.zrange, sw.zmin, sw.zmax = s.zrange, s.zmin, s.zmax
+	sw.tsrange, sw.tsmin, sw.tsmax = s.tsrange, s.tsmin, s.tsmax
 	if msg.OutputType == JSON {
 		wr.WriteString(`{"ok":true`)
 	}
 	sw.writeHead()
+	sw.releaseRead(false)
 	if sw.col != nil {
-		if sw.output == outputCount && len(sw.wheres) == 0 && sw.globEverything {
+		if sw.output == outputCount && sw.agg == nil && !sw.zrange && !sw.tsrange && len(sw.wheres) == 0 &&
+			len(sw.wherestrs) == 0 && sw.globEverything {
 			count := sw.col.Count() - int(s.cursor)
 			if count < 0 {
 				count = 0
@@ -580,7 +1102,7 @@ func (server *Server) cmdSearch(msg *Message) (res resp.Value, err error) {
 			g := glob.Parse(sw.globPattern, s.desc)
 			if g.Limits[0] == "" && g.Limits[1] == "" {
 				sw.col.SearchValues(s.desc, sw, msg.Deadline,
-					func(id string, o geojson.Object, fields []float64) bool {
+					func(id string, o geojson.Object, fields []field.Value) bool {
 						return sw.writeObject(ScanWriterParams{
 							id:     id,
 							o:      o,
@@ -595,7 +1117,7 @@ func (server *Server) cmdSearch(msg *Message) (res resp.Value, err error) {
 				sw.globSingle = false
 				sw.col.SearchValuesRange(g.Limits[0], g.Limits[1], s.desc, sw,
 					msg.Deadline,
-					func(id string, o geojson.Object, fields []float64) bool {
+					func(id string, o geojson.Object, fields []field.Value) bool {
 						return sw.writeObject(ScanWriterParams{
 							id:     id,
 							o:      o,
@@ -614,3 +1136,15 @@ func (server *Server) cmdSearch(msg *Message) (res resp.Value, err error) {
 	}
 	return sw.respOut, nil
 }
+
+// geohashArea returns the bounds of a geohash for a HASH or GEOHASH area.
+func geohashArea(hash string) (geojson.Object, error) {
+	if geohash.Validate(hash) != nil {
+		return nil, errInvalidArgument(hash)
+	}
+	box := geohash.BoundingBox(hash)
+	return geojson.NewRect(geometry.Rect{
+		Min: geometry.Point{X: box.MinLng, Y: box.MinLat},
+		Max: geometry.Point{X: box.MaxLng, Y: box.MaxLat},
+	}), nil
+}
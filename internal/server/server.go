@@ -2,10 +2,11 @@ package server
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/tls"
 	"encoding/base64"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -30,24 +31,36 @@ import (
 	"github.com/tidwall/redcon"
 	"github.com/tidwall/resp"
 	"github.com/tidwall/rhh"
-	"github.com/tidwall/rtree"
 	"github.com/tidwall/tile38/core"
 	"github.com/tidwall/tile38/internal/collection"
 	"github.com/tidwall/tile38/internal/deadline"
 	"github.com/tidwall/tile38/internal/endpoint"
 	"github.com/tidwall/tile38/internal/expire"
+	"github.com/tidwall/tile38/internal/field"
+	"github.com/tidwall/tile38/internal/glob"
+	"github.com/tidwall/tile38/internal/history"
 	"github.com/tidwall/tile38/internal/log"
+	"github.com/tidwall/tile38/internal/trace"
 )
 
 var errOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'")
 
+// the loggers of the subsystems, which may have their own levels, see
+// loglevels
+var (
+	aofLog  = log.New("aof")
+	replLog = log.New("replication")
+	epLog   = log.New("endpoint")
+)
+
 func errTimeoutOnCmd(cmd string) error {
 	return fmt.Errorf("timeout not supported for '%s'", cmd)
 }
 
 const (
-	goingLive     = "going live"
-	hookLogPrefix = "hook:log:"
+	goingLive       = "going live"
+	hookLogPrefix   = "hook:log:"
+	hookTracePrefix = "hook:trace:"
 )
 
 // commandDetails is detailed information about a mutable command. It's used
@@ -58,10 +71,13 @@ type commandDetails struct {
 	newKey    string            // new key, for RENAME command
 	fmap      map[string]int    // map of field names to value indexes
 	obj       geojson.Object    // new object
-	fields    []float64         // array of field values
+	fields    []field.Value     // array of field values
 	oldObj    geojson.Object    // previous object, if any
-	oldFields []float64         // previous object field values
+	oldFields []field.Value     // previous object field values
+	observed  int64             // observation time of the object, unix nano
+	version   uint64            // version of the object, after the write
 	updated   bool              // object was updated
+	expired   bool              // DEL of an object that expired
 	timestamp time.Time         // timestamp when the update occured
 	parent    bool              // when true, only children are forwarded
 	pattern   string            // PDEL key pattern
@@ -79,6 +95,8 @@ type Server struct {
 	config  *Config
 	epc     *endpoint.Manager
 
+	tlsCerts *tlsCerts // the certificates of the TLS port, or nil
+
 	// env opts
 	geomParseOpts geojson.ParseOptions
 	geomIndexOpts geometry.IndexOptions
@@ -86,80 +104,268 @@ type Server struct {
 
 	// atomics
 	followc            aint // counter increases when follow property changes
+	peerc              aint // counter increases when peer property changes
 	statsTotalConns    aint // counter for total connections
 	statsTotalCommands aint // counter for total commands
 	statsTotalMsgsSent aint // counter for total sent webhook messages
 	statsExpired       aint // item expiration counter
+	statsExpireSweeps  aint // counter for the sweeps of the expired items
+	statsExpireTime    aint // microseconds of the sweeps
+	statsExpireMax     aint // microseconds of the longest sweep
+	statsExpireLimited aint // counter for the sweeps held back by the rate
+	statsFenceEvents   aint // counter for fence events
+	statsDroppedEvents aint // counter for fence events over the rate limit
+	statsCDCSent       aint // counter for sent change events
+	statsQuotaRejected aint // counter for writes over a quota
+	statsHookErrors    aint // counter for failed deliveries of hook messages
+	statsHookErrDrops  aint // counter for hook errors not sent to the endpoint
 	lastShrinkDuration aint
 	stopServer         abool
+	draining           abool // the server is shutting down, see shutdown
 	outOfMemory        abool
 
-	connsmu sync.RWMutex
-	conns   map[int]*Client
+	// metrics of the commands, see METRICS
+	metrics commandMetrics
+
+	// commands that took longer than slowlog-log-slower-than, see SLOWLOG
+	slowlog slowlog
+
+	// the spikes of latency, see LATENCY
+	latency latencyMonitor
 
-	mu       sync.RWMutex
-	aof      *os.File     // active aof file
-	aofdirty int32        // mark the aofbuf as having data
+	// spans of the commands and the sends to endpoints, see traceurl
+	tracer    *trace.Tracer
+	writeSpan trace.SpanContext // span of the write that holds the lock
+
+	connsmu  sync.RWMutex
+	conns    map[int]*Client
+	clientID int64 // last client id, shared by the plain and tls listeners
+
+	// listeners of the network server, which are closed by the shutdown
+	lnmu sync.Mutex
+	lns  []net.Listener
+
+	mu       serverMutex
+	aof      *cryptFile   // active aof file
 	aofbuf   []byte       // prewrite buffer
 	aofsz    int          // active size of the aof file
 	qdb      *buntdb.DB   // hook queue log
 	qidx     uint64       // hook queue log last idx
 	cols     *btree.BTree // data collections
 	expires  *rhh.Map     // map[string]map[string]time.Time
+	expmu    sync.RWMutex // the keys of expires, read by searches, see lockKey
+	bulkLoad bool         // new collections are bulk loaded, see beginBulkLoad
+
+	// atomics, the bytes of the aof writes since the server started, which
+	// never go back like the aofsz, see flushClientAOF
+	aofwrote   aint // written to the prewrite buffer
+	aofflushed aint // flushed to the aof file
+	aofsynced  aint // flushed and synced to disk
+
+	// the object of the SET that the aof loader applies, see aofLoader
+	parsed *parsedObject
 
 	follows    map[*bytes.Buffer]bool
 	fcond      *sync.Cond
 	lstack     []*commandDetails
 	lives      map[*liveBuffer]bool
 	lcond      *sync.Cond
-	fcup       bool             // follow caught up
-	fcuponce   bool             // follow caught up once
-	shrinking  bool             // aof shrinking flag
-	shrinklog  [][]string       // aof shrinking log
-	hooks      map[string]*Hook // hook name
-	hookCross  rtree.RTree      // hook spatial tree for "cross" geofences
-	hookTree   rtree.RTree      // hook spatial tree for all
-	hooksOut   map[string]*Hook // hooks with "outside" detection
+	fcup       bool                             // follow caught up
+	fcuponce   bool                             // follow caught up once
+	shrinking  bool                             // aof shrinking flag
+	shrinklog  [][]string                       // aof shrinking log
+	migrations map[string]*migration            // collections that are migrating
+	hooks      map[string]*Hook                 // hook name
+	hookIndex  hookIndex                        // hooks by the areas of their fences
+	schemas    map[string]*schema               // collection key
+	limits     map[string]*limits               // collection key
+	indexes    map[string][]string              // collection key to indexed fields
+	geoIndexes map[string]*geojson.ParseOptions // collection key to the options of its geometries
 	aofconnM   map[net.Conn]bool
 	luascripts *lScriptMap
 	luapool    *lStatePool
 
+	// libraries of lua functions, and their functions by name
+	libraries map[string]*luaLibrary
+	functions map[string]*luaFunction
+
+	// triggers that call functions on writes, by name
+	triggers map[string]*trigger
+
 	pubsub *pubsub
 	hookex expire.List
 
+	// fields that expire, by collection key, id, and field name
+	fexpires map[string]map[string]map[string]int64
+	fieldex  expire.List
+
 	monconnsMu sync.RWMutex
 	monconns   map[net.Conn]bool
+
+	// kept positions of the objects, by collection key
+	histories map[string]*history.Store
+
+	// labels of the collections, by collection key
+	labels map[string]map[string]string
+
+	// hooks that use a stored object as the fence area
+	hookRefs map[fenceRef]map[string]bool
+
+	// clients that watch a collection for a transaction, by collection key
+	watches map[string]map[*Client]bool
+
+	// fence events per object for the current second
+	eventRates    map[string]uint64
+	eventRatesSec int64
+
+	// writes from clients per collection key for the current second
+	keyRates    map[string]uint64
+	keyRatesSec int64
+
+	// binary snapshots
+	saving      bool
+	lastSave    time.Time
+	lastSaveErr bool
+
+	// aof and snapshot encryption, nil when not encrypted
+	aead cipher.AEAD
+
+	// backups to object storage
+	backingUp      bool
+	lastBackup     time.Time
+	lastBackupErr  bool
+	lastFullBackup time.Time
+
+	// the persistence engine, nil when persistence is disabled
+	persist persister
+
+	// set when the aof has writes that have not been synced to disk
+	aofunsynced bool
+
+	// change data capture
+	cdcidx uint64        // cdc queue last idx
+	cdcsig chan struct{} // signals that there are new cdc events
+
+	// audit log, see auditlog
+	auditmu   sync.Mutex
+	auditidx  uint64        // audit queue last seq
+	audithash string        // hash of the last audit record
+	auditsig  chan struct{} // signals that there are new audit records
+
+	// the failures of the hooks for the hookerrorsendpoint, see hookFailed
+	hookErrs chan string
+
+	// leader election in raft mode
+	raft raftNode
+
+	// replication offsets for WAIT and WAITOFFSET
+	repl replOffsets
+
+	// recent aof writes, for followers that resume after a short outage
+	backlog replBacklog
+
+	// the leader's aof offset and checksum from the last REPLCONF POS, for
+	// followers of a subset of the collections
+	followPos int64
+	followSum string
+
+	// the commands of a transaction from the leader, which are applied once
+	// its EXEC is received
+	followTx   [][]string
+	followInTx bool
+
+	// the follower that evaluates the follower hooks, see REPLCONF
+	// HOOKLEASE, and when its lease ends on the leader
+	hookOwner        string
+	hookLeaseExpires time.Time
+
+	// versions of the writes for active-active replication with a peer
+	lww lwwState
+
+	// topology of the cluster, when in cluster mode
+	cluster clusterState
+
+	// users of the ACL, see ACL SETUSER
+	acl acl
+
+	// namespaces of the tenants, see NAMESPACE
+	ns namespaces
+
+	// the peer's aof offset and checksum from the last REPLCONF POS
+	peerPos int64
+	peerSum string
 }
 
 // Serve starts a new tile38 server
 func Serve(host string, port int, dir string, http bool) error {
+	server, err := open(host, port, dir, http)
+	if err != nil {
+		return err
+	}
+	defer server.close()
+	defer func() {
+		// Stop background routines
+		server.followc.add(1) // this will force any follow communication to die
+		server.peerc.add(1)
+		server.stopServer.set(true)
+
+		// notify the live geofence connections that we are stopping.
+		server.lcond.L.Lock()
+		server.lcond.Wait()
+		server.lcond.L.Lock()
+	}()
+
+	// Start the network server
+	return server.netServe()
+}
+
+// open loads the database of a directory and starts the background
+// routines, which is the server without the network server.
+func open(host string, port int, dir string, http bool) (
+	server *Server, err error,
+) {
 	if core.AppendFileName == "" {
 		core.AppendFileName = path.Join(dir, "appendonly.aof")
 	}
+	if core.KVFileName == "" {
+		core.KVFileName = path.Join(dir, "kv.db")
+	}
 	if core.QueueFileName == "" {
 		core.QueueFileName = path.Join(dir, "queue.db")
 	}
+	if core.SnapshotFileName == "" {
+		core.SnapshotFileName = path.Join(dir, "snapshot.db")
+	}
 	log.Infof("Server started, Tile38 version %s, git %s", core.Version, core.GitSHA)
 
 	// Initialize the server
-	server := &Server{
-		host:     host,
-		port:     port,
-		dir:      dir,
-		follows:  make(map[*bytes.Buffer]bool),
-		fcond:    sync.NewCond(&sync.Mutex{}),
-		lives:    make(map[*liveBuffer]bool),
-		lcond:    sync.NewCond(&sync.Mutex{}),
-		hooks:    make(map[string]*Hook),
-		hooksOut: make(map[string]*Hook),
-		aofconnM: make(map[net.Conn]bool),
-		expires:  rhh.New(0),
-		started:  time.Now(),
-		conns:    make(map[int]*Client),
-		http:     http,
-		pubsub:   newPubsub(),
-		monconns: make(map[net.Conn]bool),
-		cols:     btree.New(byCollectionKey),
+	server = &Server{
+		host:       host,
+		port:       port,
+		dir:        dir,
+		follows:    make(map[*bytes.Buffer]bool),
+		fcond:      sync.NewCond(&sync.Mutex{}),
+		lives:      make(map[*liveBuffer]bool),
+		lcond:      sync.NewCond(&sync.Mutex{}),
+		cdcsig:     make(chan struct{}, 1),
+		auditsig:   make(chan struct{}, 1),
+		hookErrs:   make(chan string, hookErrorsBacklog),
+		hooks:      make(map[string]*Hook),
+		schemas:    make(map[string]*schema),
+		limits:     make(map[string]*limits),
+		indexes:    make(map[string][]string),
+		geoIndexes: make(map[string]*geojson.ParseOptions),
+		fexpires:   make(map[string]map[string]map[string]int64),
+		hookRefs:   make(map[fenceRef]map[string]bool),
+		watches:    make(map[string]map[*Client]bool),
+		aofconnM:   make(map[net.Conn]bool),
+		expires:    rhh.New(0),
+		started:    time.Now(),
+		conns:      make(map[int]*Client),
+		http:       http,
+		pubsub:     newPubsub(),
+		monconns:   make(map[net.Conn]bool),
+		cols:       btree.New(byCollectionKey),
 	}
 
 	server.hookex.Expired = func(item expire.Item) {
@@ -168,18 +374,39 @@ func Serve(host string, port int, dir string, http bool) error {
 			server.possiblyExpireHook(v.Name)
 		}
 	}
+	server.fieldex.Expired = func(item expire.Item) {
+		server.possiblyExpireField(item.(*fieldExpiry))
+	}
+	server.histories = make(map[string]*history.Store)
+	server.labels = make(map[string]map[string]string)
+	server.libraries = make(map[string]*luaLibrary)
+	server.functions = make(map[string]*luaFunction)
+	server.triggers = make(map[string]*trigger)
 	server.epc = endpoint.NewManager(server)
 	server.luascripts = server.newScriptMap()
 	server.luapool = server.newPool()
-	defer server.luapool.Shutdown()
+	defer func() {
+		if err != nil {
+			server.close()
+		}
+	}()
 
 	if err := os.MkdirAll(dir, 0700); err != nil {
-		return err
+		return nil, err
 	}
-	var err error
 	server.config, err = loadConfig(filepath.Join(dir, "config"))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	server.tracer = trace.NewTracer("tile38", core.Version,
+		server.config.traceURL)
+	server.epc.SetTracer(server.tracer)
+	server.epc.SetReapInterval(server.config.endpointReapInterval)
+	server.latency.threshold = server.config.latencyThreshold
+	collection.SetWorkers(server.config.queryWorkers)
+	server.aead, err = loadEncryptionKey()
+	if err != nil {
+		return nil, err
 	}
 
 	// Send "500 Internal Server" error instead of "200 OK" for json responses
@@ -235,45 +462,70 @@ func Serve(host string, port int, dir string, http bool) error {
 	// Load the queue before the aof
 	qdb, err := buntdb.Open(core.QueueFileName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var qidx uint64
+	var qidx, cdcidx uint64
 	if err := qdb.View(func(tx *buntdb.Tx) error {
 		val, err := tx.Get("hook:idx")
 		if err != nil {
-			if err == buntdb.ErrNotFound {
-				return nil
+			if err != buntdb.ErrNotFound {
+				return err
 			}
-			return err
+		} else {
+			qidx = stringToUint64(val)
+		}
+		val, err = tx.Get(cdcIdxKey)
+		if err != nil {
+			if err != buntdb.ErrNotFound {
+				return err
+			}
+		} else {
+			cdcidx = stringToUint64(val)
+		}
+		val, err = tx.Get(auditIdxKey)
+		if err != nil {
+			if err != buntdb.ErrNotFound {
+				return err
+			}
+		} else {
+			server.auditidx = stringToUint64(val)
+			server.audithash, _ = tx.Get(auditHashKey)
 		}
-		qidx = stringToUint64(val)
 		return nil
 	}); err != nil {
-		return err
+		return nil, err
 	}
 	err = qdb.CreateIndex("hooks", hookLogPrefix+"*", buntdb.IndexJSONCaseSensitive("hook"))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	server.qdb = qdb
 	server.qidx = qidx
+	server.cdcidx = cdcidx
 	if err := server.migrateAOF(); err != nil {
-		return err
+		return nil, err
 	}
 	if core.AppendOnly {
-		f, err := os.OpenFile(core.AppendFileName, os.O_CREATE|os.O_RDWR, 0600)
+		p, err := server.openPersister(core.PersistEngine)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		server.aof = f
-		if err := server.loadAOF(); err != nil {
-			return err
+		server.persist = p
+		if err := p.load(); err != nil {
+			return nil, err
 		}
-		defer func() {
-			server.flushAOF(false)
-			server.aof.Sync()
-		}()
+	} else if err := server.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := server.clusterLoad(); err != nil {
+		return nil, err
+	}
+	if err := server.nsLoad(); err != nil {
+		return nil, err
+	}
+	if err := server.aclLoad(); err != nil {
+		return nil, err
 	}
 	// server.fillExpiresList()
 
@@ -282,25 +534,35 @@ func Serve(host string, port int, dir string, http bool) error {
 		go server.follow(server.config.followHost(), server.config.followPort(),
 			server.followc.get())
 	}
+	if server.config.peerHost() != "" {
+		go server.peer(server.config.peerHost(), server.config.peerPort(),
+			server.peerc.get())
+	}
 	go server.processLives()
 	go server.watchOutOfMemory()
 	go server.watchLuaStatePool()
 	go server.watchAutoGC()
+	go server.watchBackups()
 	go server.backgroundExpiring()
 	go server.backgroundSyncAOF()
-	defer func() {
-		// Stop background routines
-		server.followc.add(1) // this will force any follow communication to die
-		server.stopServer.set(true)
-
-		// notify the live geofence connections that we are stopping.
-		server.lcond.L.Lock()
-		server.lcond.Wait()
-		server.lcond.L.Lock()
-	}()
+	go server.watchCDC()
+	go server.watchAudit()
+	go server.watchHookErrors()
+	go server.watchStatsD()
+	go server.watchRaft()
+	go server.watchCluster()
+	return server, nil
+}
 
-	// Start the network server
-	return server.netServe()
+// close closes the persistence and the lua states.
+func (server *Server) close() {
+	if server.persist != nil {
+		server.persist.close()
+	}
+	if server.qdb != nil {
+		server.qdb.Close()
+	}
+	server.luapool.Shutdown()
 }
 
 func (server *Server) isProtected() bool {
@@ -318,16 +580,74 @@ func (server *Server) isProtected() bool {
 }
 
 func (server *Server) netServe() error {
+	var tlsConfig *tls.Config
+	if core.TLSPort != 0 {
+		var err error
+		tlsConfig, server.tlsCerts, err = loadTLSConfig()
+		if err != nil {
+			return err
+		}
+	}
+	core.ReloadConfig = server.reloadConfig
+	core.Shutdown = server.shutdown
 	ln, err := net.Listen("tcp", fmt.Sprintf("%s:%d", server.host, server.port))
 	if err != nil {
 		return err
 	}
 	defer ln.Close()
-	log.Infof("Ready to accept connections at %s", ln.Addr())
-	var clientID int64
+	server.addListener(ln)
+	var mainConfig *tls.Config
+	if core.TLSPort == server.port {
+		// the main port only accepts tls connections
+		mainConfig = tlsConfig
+		log.Infof("Ready to accept tls connections at %s", ln.Addr())
+	} else {
+		log.Infof("Ready to accept connections at %s", ln.Addr())
+	}
+	if core.TLSPort != 0 && core.TLSPort != server.port {
+		tln, err := net.Listen("tcp",
+			fmt.Sprintf("%s:%d", server.host, core.TLSPort))
+		if err != nil {
+			return err
+		}
+		defer tln.Close()
+		server.addListener(tln)
+		log.Infof("Ready to accept tls connections at %s", tln.Addr())
+		go func() {
+			if err := server.serveConns(tln, tlsConfig); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if core.GRPCPort != 0 {
+		gln, err := net.Listen("tcp",
+			fmt.Sprintf("%s:%d", server.host, core.GRPCPort))
+		if err != nil {
+			return err
+		}
+		defer gln.Close()
+		server.addListener(gln)
+		log.Infof("Ready to accept grpc connections at %s", gln.Addr())
+		go func() {
+			if err := server.serveGRPC(gln); err != nil &&
+				!server.draining.on() {
+				log.Fatal(err)
+			}
+		}()
+	}
+	return server.serveConns(ln, mainConfig)
+}
+
+// serveConns accepts the connections of a listener. The connections are
+// TLS when tlsConfig is not nil.
+func (server *Server) serveConns(ln net.Listener, tlsConfig *tls.Config) error {
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if server.draining.on() {
+				// the process exits once the shutdown is done
+				select {}
+			}
 			return err
 		}
 
@@ -335,7 +655,7 @@ func (server *Server) netServe() error {
 			// open connection
 			// create the client
 			client := new(Client)
-			client.id = int(atomic.AddInt64(&clientID, 1))
+			client.id = int(atomic.AddInt64(&server.clientID, 1))
 			client.opened = time.Now()
 			client.remoteAddr = conn.RemoteAddr().String()
 
@@ -354,6 +674,13 @@ func (server *Server) netServe() error {
 					)
 				}
 			}
+			if tlsConfig != nil {
+				conn = tls.Server(conn, tlsConfig)
+				client.tls = true
+			}
+			client.mu.Lock()
+			client.conn = conn
+			client.mu.Unlock()
 			log.Debugf("Opened connection: %s", client.remoteAddr)
 
 			defer func() {
@@ -362,10 +689,25 @@ func (server *Server) netServe() error {
 				server.connsmu.Lock()
 				delete(server.conns, client.id)
 				server.connsmu.Unlock()
+				if len(client.watched) > 0 {
+					server.mu.Lock()
+					server.unwatch(client)
+					server.mu.Unlock()
+				}
 				log.Debugf("Closed connection: %s", client.remoteAddr)
 				conn.Close()
 			}()
 
+			if tconn, ok := conn.(*tls.Conn); ok {
+				// the handshake verifies the client certificate, which may
+				// authenticate the client as a user of the acl
+				if err := tconn.Handshake(); err != nil {
+					log.Debugf("tls handshake: %s: %v", client.remoteAddr, err)
+					return
+				}
+				server.authenticateCert(client, tconn.ConnectionState())
+			}
+
 			var lastConnType Type
 			var lastOutputType Type
 
@@ -378,13 +720,25 @@ func (server *Server) netServe() error {
 					return // close connection
 				}
 			}
+			if !netAllowed(server.config.netRules(), client.remoteAddr, "") {
+				conn.Write(deniedNetMessage)
+				return // close connection
+			}
 			packet := make([]byte, 0xFFFF)
 			for {
 				var close bool
-				n, err := conn.Read(packet)
+				timeout, _ := server.config.clientLimits()
+				if timeout > 0 {
+					// an idle client is closed
+					conn.SetReadDeadline(time.Now().Add(timeout))
+				}
+				n, err := client.readConn(conn, packet)
 				if err != nil {
 					return
 				}
+				if timeout > 0 {
+					conn.SetReadDeadline(time.Time{})
+				}
 				in := packet[:n]
 
 				// read the payload packet from the client input stream.
@@ -409,6 +763,11 @@ func (server *Server) netServe() error {
 						if client.outputType != Null {
 							msg.OutputType = client.outputType
 						}
+						msg.resp3 = client.resp3
+						msg.encoding = client.encoding
+						msg.csv = client.csv
+						msg.flat = client.flat
+						msg.bounds = client.bounds
 						if msg.Command() == "quit" {
 							if msg.OutputType == RESP {
 								io.WriteString(client, "+OK\r\n")
@@ -426,19 +785,24 @@ func (server *Server) netServe() error {
 						server.statsTotalCommands.add(1)
 
 						// handle the command
+						wrote := server.aofwrote.get()
 						err := server.handleInputCommand(client, msg)
+						if pos := server.aofwrote.get(); pos != wrote {
+							client.aofpos = pos
+						}
 						if err != nil {
 							if err.Error() == goingLive {
 								client.goLiveErr = err
 								client.goLiveMsg = msg
 								// detach
 								var rwc io.ReadWriteCloser = conn
-								client.conn = rwc
 								if len(client.out) > 0 {
-									client.conn.Write(client.out)
+									conn.Write(client.out)
 									client.out = nil
 								}
 								client.in = InputStream{}
+								client.pr.buf = append(client.pr.buf, client.pending...)
+								client.pending = nil
 								client.pr.rd = rwc
 								client.pr.wr = rwc
 								log.Debugf("Detached connection: %s", client.remoteAddr)
@@ -466,6 +830,10 @@ func (server *Server) netServe() error {
 						}
 
 						client.outputType = msg.OutputType
+						client.encoding = msg.encoding
+						client.csv = msg.csv
+						client.flat = msg.flat
+						client.bounds = msg.bounds
 					} else {
 						client.Write([]byte("HTTP/1.1 500 Bad Request\r\nConnection: close\r\n\r\n"))
 						break
@@ -483,19 +851,32 @@ func (server *Server) netServe() error {
 
 				// write to client
 				if len(client.out) > 0 {
-					if atomic.LoadInt32(&server.aofdirty) != 0 {
-						func() {
-							// prewrite
-							server.mu.Lock()
-							defer server.mu.Unlock()
-							server.flushAOF(false)
-						}()
-						atomic.StoreInt32(&server.aofdirty, 0)
+					// prewrite
+					server.flushClientAOF(client)
+					if err := server.raftCommit(client); err != nil {
+						// the writes may or may not survive the next
+						// election, so there is no reply.
+						log.Warnf("Closed connection: %s: %v",
+							client.remoteAddr, err)
+						return // close connection
 					}
+					_, outputLimit := server.config.clientLimits()
+					if outputLimit > 0 && int64(len(client.out)) > outputLimit {
+						log.Warnf("Closed connection: %s: output of %d bytes "+
+							"is over the client-output-buffer-limit",
+							client.remoteAddr, len(client.out))
+						return // close connection
+					}
+					client.mu.Lock()
+					client.omem = len(client.out)
+					client.mu.Unlock()
 					conn.Write(client.out)
 					client.out = nil
+					client.mu.Lock()
+					client.omem = 0
+					client.mu.Unlock()
 				}
-				if close {
+				if close || client.killed {
 					break
 				}
 				if err != nil {
@@ -621,18 +1002,19 @@ func (server *Server) watchLuaStatePool() {
 	}
 }
 
-// backgroundSyncAOF ensures that the aof buffer is does not grow too big.
+// backgroundSyncAOF ensures that the aof buffer is does not grow too big, and
+// syncs the aof every aof-flush-interval milliseconds, which is every second
+// by default, unless appendfsync is "no".
 func (server *Server) backgroundSyncAOF() {
-	t := time.NewTicker(time.Second)
-	defer t.Stop()
-	for range t.C {
+	for {
+		time.Sleep(server.config.aofFlushInterval())
 		if server.stopServer.on() {
 			return
 		}
 		func() {
 			server.mu.Lock()
 			defer server.mu.Unlock()
-			server.flushAOF(true)
+			server.flushAOF(server.config.appendFsync() != "no")
 		}()
 	}
 }
@@ -650,6 +1032,7 @@ func byCollectionKey(a, b interface{}) bool {
 }
 
 func (server *Server) setCol(key string, col *collection.Collection) {
+	server.applyIndexes(key, col)
 	server.cols.Set(&collectionKeyContainer{key, col})
 }
 
@@ -660,6 +1043,49 @@ func (server *Server) getCol(key string) *collection.Collection {
 	return nil
 }
 
+// newCol creates an empty collection for key. Collections with keys that
+// match the diskcollections pattern store their geometries on disk.
+func (server *Server) newCol(key string) (*collection.Collection, error) {
+	var col *collection.Collection
+	if pattern := server.config.diskCollections(); pattern != "" {
+		if match, _ := glob.Match(pattern, key); match {
+			var err error
+			col, err = collection.NewDisk(server.dir, server.parseOpts(key))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if col == nil {
+		col = collection.New()
+	}
+	if server.bulkLoad {
+		col.BeginLoad()
+	}
+	return col, nil
+}
+
+// beginBulkLoad defers the spatial indexing of the objects that are set,
+// until endBulkLoad indexes all of them at once. It's for the loading of the
+// aof or a snapshot, and for the batches of an IMPORT, which hold the lock
+// of the server until they end.
+func (server *Server) beginBulkLoad() {
+	server.bulkLoad = true
+	server.cols.Ascend(nil, func(v interface{}) bool {
+		v.(*collectionKeyContainer).col.BeginLoad()
+		return true
+	})
+}
+
+// endBulkLoad indexes the objects that were set since beginBulkLoad, the
+// collections in parallel.
+func (server *Server) endBulkLoad() {
+	server.bulkLoad = false
+	server.parallelCols(func(col *collection.Collection) {
+		col.EndLoad()
+	})
+}
+
 func (server *Server) scanGreaterOrEqual(
 	key string, iterator func(key string, col *collection.Collection) bool,
 ) {
@@ -716,7 +1142,11 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 			resStr = res.String()
 		case RESP:
 			var resBytes []byte
-			resBytes, err = res.MarshalRESP()
+			if msg.resp3 {
+				resBytes = appendRESP3(nil, res, resp3ReplyShape(msg))
+			} else {
+				resBytes, err = res.MarshalRESP()
+			}
 			resStr = string(resBytes)
 		}
 		return resStr, err
@@ -728,10 +1158,31 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 			log.Error(err)
 			return err
 		case WebSocket:
-			return WriteWebSocketMessage(client, []byte(res))
+			return writeWebSocketMessage(client, []byte(res), msg.ws)
 		case HTTP:
+			if msg.ContentType != "" {
+				status := msg.status
+				if status == "" {
+					status = "200 OK"
+				}
+				_, err := fmt.Fprintf(client, "HTTP/1.1 "+status+"\r\n"+
+					"Connection: close\r\n"+
+					"Content-Length: %d\r\n"+
+					"Content-Type: %s\r\n"+
+					"Access-Control-Allow-Origin: *\r\n"+
+					"\r\n", len(res), msg.ContentType)
+				if err != nil {
+					return err
+				}
+				_, err = io.WriteString(client, res)
+				return err
+			}
 			status := "200 OK"
-			if server.http500Errors && !gjson.Get(res, "ok").Bool() {
+			if msg.rest {
+				if !gjson.Get(res, "ok").Bool() {
+					status = restStatus(gjson.Get(res, "err").String())
+				}
+			} else if server.http500Errors && !gjson.Get(res, "ok").Bool() {
 				status = "500 Internal Server Error"
 			}
 			_, err := fmt.Fprintf(client, "HTTP/1.1 %s\r\n"+
@@ -751,19 +1202,33 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 		case RESP:
 			var err error
 			if msg.OutputType == JSON {
+				if msg.encoding != encodingJSON {
+					res = string(msg.encoding.encode([]byte(res), msg))
+				}
 				_, err = fmt.Fprintf(client, "$%d\r\n%s\r\n", len(res), res)
 			} else {
 				_, err = io.WriteString(client, res)
 			}
 			return err
 		case Native:
+			if msg.encoding != encodingJSON {
+				res = string(msg.encoding.encode([]byte(res), msg))
+			}
 			_, err := fmt.Fprintf(client, "$%d %s\r\n", len(res), res)
 			return err
+		case GRPC, Embedded:
+			// the reply of the call is read from the output
+			_, err := io.WriteString(client, res)
+			return err
 		}
 	}
 
+	// The commands of rename-commands are called by their new names, which
+	// are replaced by the commands that they were renamed from.
+	known := server.renameCommand(msg)
+
 	// Ping. Just send back the response. No need to put through the pipeline.
-	if msg.Command() == "ping" || msg.Command() == "echo" {
+	if known && (msg.Command() == "ping" || msg.Command() == "echo") {
 		switch msg.OutputType {
 		case JSON:
 			if len(msg.Args) > 1 {
@@ -781,102 +1246,271 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 		return nil
 	}
 
+	span := server.startCommandSpan(msg, client)
+	defer span.End()
+
+	var failed bool
 	writeErr := func(errMsg string) error {
+		failed = true
+		span.SetError(errMsg)
+		if msg.stream.active() {
+			// a part of the reply is written already
+			return msg.stream.abort(errMsg)
+		}
+		// errors are always json
+		msg.ContentType = ""
 		switch msg.OutputType {
 		case JSON:
 			return writeOutput(`{"ok":false,"err":` + jsonString(errMsg) + `,"elapsed":"` + time.Since(start).String() + "\"}")
 		case RESP:
-			if errMsg == errInvalidNumberOfArguments.Error() {
-				return writeOutput("-ERR wrong number of arguments for '" + msg.Command() + "' command\r\n")
-			}
-			v, _ := resp.ErrorValue(errors.New("ERR " + errMsg)).MarshalRESP()
+			v, _ := respError(msg.Command(), errMsg).MarshalRESP()
 			return writeOutput(string(v))
 		}
 		return nil
 	}
 
+	if !known {
+		return writeErr(fmt.Sprintf("unknown command '%s'", msg.Args[0]))
+	}
+
+	args := msg.Args
+	defer func() {
+		elapsed := time.Since(start)
+		server.metrics.observe(msg.Command(), elapsed, failed)
+		switch msg.Command() {
+		case "subscribe", "psubscribe":
+			// these run until the client leaves
+		default:
+			slowerThan, maxLen := server.config.slowlog()
+			server.slowlog.observe(args, client, start, elapsed,
+				slowerThan, maxLen)
+			server.latency.observe(latencyCommand, elapsed)
+		}
+		server.audit(args, client, start, failed)
+	}()
+
 	if msg.Command() == "timeout" {
 		if err := rewriteTimeoutMsg(msg); err != nil {
 			return writeErr(err.Error())
 		}
 	}
+	server.queryDeadline(msg)
 
 	var write bool
+	var triggered bool
+
+	// Followers and peers may authenticate with the replpass, which only
+	// allows the replication commands.
+	if replPass := server.config.replPass(); replPass != "" {
+		if msg.Command() == "auth" && len(msg.Args) > 1 &&
+			strings.TrimSpace(msg.Args[1]) == replPass {
+			client.repl = true
+			resStr, _ := serializeOutput(OKMessage(msg, start))
+			return writeOutput(resStr)
+		}
+		if isReplCommand(msg.Command()) && !client.repl {
+			return writeErr("replication authentication required")
+		}
+	}
+	if client.repl && !client.authd && !replAuthCommand(msg.Command()) {
+		return writeErr("command not allowed on a replication connection")
+	}
+	if isReplCommand(msg.Command()) && !client.tls && server.config.replTLSOnly() {
+		return writeErr("replication requires tls")
+	}
 
-	if (!client.authd || msg.Command() == "auth") && msg.Command() != "output" {
-		if server.config.requirePass() != "" {
-			password := ""
+	// HELLO may authenticate, so it comes before the password is required
+	if msg.Command() == "hello" {
+		res, err := server.cmdHello(msg, client)
+		if err != nil {
+			return writeErr(err.Error())
+		}
+		resStr, err := serializeOutput(res)
+		if err != nil {
+			return err
+		}
+		return writeOutput(resStr)
+	}
+
+	if ((!client.authd && !client.repl) || msg.Command() == "auth") &&
+		msg.Command() != "output" && msg.Command() != "health" {
+		requirePass := server.config.requirePass()
+		if requirePass != "" || server.aclEnabled() {
 			// This better be an AUTH command or the Message should contain an Auth
 			if msg.Command() != "auth" && msg.Auth == "" {
-				// Just shut down the pipeline now. The less the client connection knows the better.
-				return writeErr("authentication required")
-			}
-			if msg.Auth != "" {
-				password = msg.Auth
+				if requirePass != "" {
+					// Just shut down the pipeline now. The less the client connection knows the better.
+					return writeErr("authentication required")
+				}
 			} else {
-				if len(msg.Args) > 1 {
+				// AUTH [username] password, or the authorization of an
+				// http request, which is the password or username:password
+				var username, password string
+				if msg.Auth != "" {
+					password = msg.Auth
+					if i := strings.IndexByte(password, ':'); i != -1 &&
+						!server.checkRequirePass(password) {
+						username, password = password[:i], password[i+1:]
+					}
+				} else if len(msg.Args) > 2 {
+					username, password = msg.Args[1], msg.Args[2]
+				} else if len(msg.Args) > 1 {
 					password = msg.Args[1]
 				}
-			}
-			if server.config.requirePass() != strings.TrimSpace(password) {
-				return writeErr("invalid password")
-			}
-			client.authd = true
-			if msg.ConnType != HTTP {
-				resStr, _ := serializeOutput(OKMessage(msg, start))
-				return writeOutput(resStr)
+				if err := server.authenticate(client, username, password); err != nil {
+					return writeErr(err.Error())
+				}
+				if msg.ConnType != HTTP && msg.ConnType != GRPC {
+					resStr, _ := serializeOutput(OKMessage(msg, start))
+					return writeOutput(resStr)
+				}
 			}
 		} else if msg.Command() == "auth" {
 			return writeErr("invalid password")
 		}
 	}
+	if err := server.aclPermit(msg, client); err != nil {
+		return writeErr(err.Error())
+	}
+	if err := server.netPermit(msg, client); err != nil {
+		return writeErr(err.Error())
+	}
+	if ns := server.clientNamespace(client); ns != "" {
+		if err := server.nsRewrite(msg, ns); err != nil {
+			return writeErr(err.Error())
+		}
+	}
+
+	// snap the point of a set to a road network before it's locked
+	msg = server.snapStamp(msg)
+
+	// queue the commands of a transaction, which are applied by EXEC
+	if client.multi {
+		switch msg.Command() {
+		case "multi", "exec", "discard", "watch", "unwatch":
+		default:
+			res, err := server.queueCommand(msg, client)
+			if err != nil {
+				return writeErr(err.Error())
+			}
+			resStr, err := serializeOutput(res)
+			if err != nil {
+				return err
+			}
+			return writeOutput(resStr)
+		}
+	}
 
 	// choose the locking strategy
 	switch msg.Command() {
 	default:
 		server.mu.RLock()
 		defer server.mu.RUnlock()
-	case "set", "del", "drop", "fset", "flushdb",
-		"setchan", "pdelchan", "delchan",
-		"sethook", "pdelhook", "delhook",
-		"expire", "persist", "jset", "pdel", "rename", "renamenx":
+	case "set", "del", "drop", "fset", "fincrby", "flushdb",
+		"setchan", "pdelchan", "delchan", "renamechan",
+		"pausechan", "ppausechan", "resumechan", "presumechan",
+		"sethook", "pdelhook", "delhook", "renamehook",
+		"pausehook", "ppausehook", "resumehook", "presumehook",
+		"setschema", "delschema", "setlimits", "dellimits", "setindex", "delindex", "setgeoindex",
+		"sethistory", "delhistory", "expire", "persist", "jset", "pdel", "pset", "rename", "renamenx",
+		"copy", "settrigger", "deltrigger", "setlabel", "dellabel", "lww":
 		// write operations
 		write = true
-		server.mu.Lock()
-		defer server.mu.Unlock()
-		if server.config.followHost() != "" {
-			return writeErr("not the leader")
+		if kl := server.lockWrite(msg); kl != nil {
+			// a write of a single key waits for the searches of the key
+			defer kl.unlock()
+		} else {
+			server.mu.Lock()
+			defer server.mu.Unlock()
+		}
+		server.setWriteSpan(span)
+		defer server.setWriteSpan(nil)
+		if !server.isLeader() {
+			return writeErr(server.notLeaderErr().Error())
 		}
 		if server.config.readOnly() {
 			return writeErr("read only")
 		}
-	case "eval", "evalsha":
+		if msg.Command() != "lww" {
+			if err := server.checkQuotas(msg, client); err != nil {
+				return writeErr(err.Error())
+			}
+		}
+		// the triggers may change or reject the write
+		if triggered = triggerWrite(msg.Command()); triggered {
+			var err error
+			if msg, err = server.beforeTriggers(msg); err != nil {
+				return writeErr(err.Error())
+			}
+		}
+		// writes are versioned for the peer
+		msg = server.lwwStamp(server.timestampStamp(server.jitterStamp(msg)))
+	case "eval", "evalsha", "fcall":
 		// write operations (potentially) but no AOF for the script command itself
 		server.mu.Lock()
 		defer server.mu.Unlock()
-		if server.config.followHost() != "" {
-			return writeErr("not the leader")
+		server.setWriteSpan(span)
+		defer server.setWriteSpan(nil)
+		if !server.isLeader() {
+			return writeErr(server.notLeaderErr().Error())
 		}
 		if server.config.readOnly() {
 			return writeErr("read only")
 		}
+	case "function":
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		if functionWrite(msg.Args) {
+			// loading and deleting libraries are write operations
+			write = true
+			if !server.isLeader() {
+				return writeErr(server.notLeaderErr().Error())
+			}
+			if server.config.readOnly() {
+				return writeErr("read only")
+			}
+			msg = server.lwwStamp(msg)
+		}
 	case "get", "keys", "scan", "nearby", "within", "intersects", "hooks",
-		"chans", "search", "ttl", "bounds", "server", "info", "type", "jget",
-		"evalro", "evalrosha":
+		"chans", "search", "ttl", "bounds", "server", "info", "metrics", "type", "exists", "jget",
+		"evalro", "evalrosha", "fcall_ro", "fencetest", "schema", "limits", "indexes", "matrix",
+		"geoindex", "tile", "history", "trajectory", "passed", "memory", "triggers",
+		"labels", "stats", "graphql":
 		// read operations
 
-		server.mu.RLock()
-		defer server.mu.RUnlock()
+		if msg.keyLock = server.lockRead(msg); msg.keyLock != nil {
+			defer msg.keyLock.unlock()
+		} else {
+			server.mu.RLock()
+			defer server.mu.RUnlock()
+		}
 		if server.config.followHost() != "" && !server.fcuponce {
 			return writeErr("catching up to leader")
 		}
-	case "follow", "slaveof", "replconf", "readonly", "config":
+	case "follow", "slaveof", "replconf", "readonly", "config", "waitaof",
+		"aofcheck", "peer":
 		// system operations
 		// does not write to aof, but requires a write lock.
 		server.mu.Lock()
 		defer server.mu.Unlock()
 	case "output":
 		// this is local connection operation. Locks not needed.
+	case "slowlog":
+		// the slowlog has its own lock
+	case "latency":
+		// the latency monitor has its own lock
+	case "health":
+		// the lock is acquired with a timeout
+	case "acl":
+		// the acl has its own lock
+	case "namespace":
+		// the namespaces have their own lock
+	case "select":
+		// this is local connection operation. Locks not needed.
+	case "openapi":
+		// the document does not change
+	case "command":
+		// the commands of the config have their own lock
 	case "echo":
 	case "massinsert":
 		// dev operation
@@ -891,16 +1525,57 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 	case "aofshrink":
 		server.mu.RLock()
 		defer server.mu.RUnlock()
+	case "reindex":
+		// Locks are handled by the reindex operation, which builds the index
+		// without the locks.
+	case "save", "bgsave", "backup", "restore", "import", "export", "dump":
+		// Locks are handled by the save, backup, restore, import, export,
+		// and dump operations.
+	case "hookreplay":
+		// the aof is read without the lock, see cmdHookReplay
+	case "hooklease":
+		// a marker of the aof that is written by the leader, see
+		// REPLCONF HOOKLEASE
+		return writeErr(fmt.Sprintf("unknown command '%s'", msg.Args[0]))
 	case "client":
 		server.mu.Lock()
 		defer server.mu.Unlock()
+	case "multi", "exec", "discard", "watch", "unwatch":
+		// transactions, the queued commands are applied by EXEC while
+		// holding the write lock.
+		server.mu.Lock()
+		defer server.mu.Unlock()
 	case "evalna", "evalnasha":
 		// No locking for scripts, otherwise writes cannot happen within scripts
 	case "subscribe", "psubscribe", "publish":
 		// No locking for pubsub
+	case "raft":
+		// Locks are handled by the raft operations
+	case "cluster":
+		// Locks are handled by the cluster operations
+	case "migrate":
+		// Locks are handled by the migrate operation, which must not block
+		// writes while sending the collection.
+	case "wait", "waitoffset":
+		// Locks are handled by the wait operations, which must not block
+		// writes while waiting.
+	case "replica":
+		server.mu.RLock()
+		defer server.mu.RUnlock()
 	case "montior":
 		// No locking for monitor
 	}
+	if err := server.clusterCheck(msg, client); err != nil {
+		return writeErr(err.Error())
+	}
+	if write {
+		if err := server.migrateCheck(msg); err != nil {
+			return writeErr(err.Error())
+		}
+	}
+	if isQueryCommand(msg.Command()) {
+		msg.stream = newReplyStream(client, msg)
+	}
 	res, d, err := func() (res resp.Value, d commandDetails, err error) {
 		if msg.Deadline != nil {
 			if write {
@@ -917,12 +1592,25 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 						}
 					}
 					res = NOMessage
+					if msg.Deadline.Canceled() {
+						err = errDisconnected
+						return
+					}
 					err = writeErr("timeout")
 				}
 			}()
+			if isQueryCommand(msg.Command()) && (msg.ConnType == RESP ||
+				msg.ConnType == Native || msg.ConnType == HTTP) {
+				defer client.watchDisconnect(msg.Deadline)()
+			}
 		}
 		return server.command(msg, client)
 	}()
+	if err == errDisconnected {
+		// there's no one to reply to
+		failed = true
+		return nil
+	}
 	if res.Type() == resp.Error {
 		return writeErr(res.String())
 	}
@@ -940,6 +1628,9 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 			log.Fatal(err)
 			return err
 		}
+		if triggered {
+			server.afterTriggers(&d)
+		}
 	}
 	if !isRespValueEmptyString(res) {
 		var resStr string
@@ -947,6 +1638,9 @@ func (server *Server) handleInputCommand(client *Client, msg *Message) error {
 		if err != nil {
 			return err
 		}
+		if msg.stream.active() {
+			return msg.stream.finish(resStr)
+		}
 		if err := writeOutput(resStr); err != nil {
 			return err
 		}
@@ -970,24 +1664,47 @@ func randomKey(n int) string {
 	return fmt.Sprintf("%x", b)
 }
 
+// respError returns the RESP error of a command, which has the ERR prefix
+// unless it's a redirect of the cluster.
+func respError(command, errMsg string) resp.Value {
+	if errMsg == errInvalidNumberOfArguments.Error() {
+		errMsg = "wrong number of arguments for '" + command + "' command"
+	}
+	if isClusterErr(errMsg) {
+		return resp.ErrorValue(errors.New(errMsg))
+	}
+	return resp.ErrorValue(errors.New("ERR " + errMsg))
+}
+
 func (server *Server) reset() {
 	server.aofsz = 0
 	server.cols = btree.New(byCollectionKey)
 	server.expires = rhh.New(0)
+	server.fexpires = make(map[string]map[string]map[string]int64)
 }
 
 func (server *Server) command(msg *Message, client *Client) (
 	res resp.Value, d commandDetails, err error,
 ) {
+	// the keys of the hooks that are named by the command, see aclPermitHooks
+	if err := server.aclPermitHooks(msg, client); err != nil {
+		return NOMessage, d, err
+	}
 	switch msg.Command() {
 	default:
 		err = fmt.Errorf("unknown command '%s'", msg.Args[0])
 	case "set":
 		res, d, err = server.cmdSet(msg, true)
+	case "lww":
+		res, d, err = server.cmdLWW(msg)
 	case "fset":
 		res, d, err = server.cmdFset(msg)
+	case "fincrby":
+		res, d, err = server.cmdFincrby(msg)
 	case "del":
 		res, d, err = server.cmdDel(msg)
+	case "pset":
+		res, d, err = server.cmdPset(msg)
 	case "pdel":
 		res, d, err = server.cmdPdel(msg)
 	case "drop":
@@ -998,22 +1715,90 @@ func (server *Server) command(msg *Message, client *Client) (
 		res, d, err = server.cmdRename(msg, false)
 	case "renamenx":
 		res, d, err = server.cmdRename(msg, true)
+	case "copy":
+		res, d, err = server.cmdCopy(msg)
 	case "sethook":
 		res, d, err = server.cmdSetHook(msg, false)
 	case "delhook":
 		res, d, err = server.cmdDelHook(msg, false)
 	case "pdelhook":
 		res, d, err = server.cmdPDelHook(msg, false)
+	case "renamehook":
+		res, d, err = server.cmdRenameHook(msg, false)
+	case "pausehook":
+		res, d, err = server.cmdPauseHook(msg, false, false, true)
+	case "ppausehook":
+		res, d, err = server.cmdPauseHook(msg, false, true, true)
+	case "resumehook":
+		res, d, err = server.cmdPauseHook(msg, false, false, false)
+	case "presumehook":
+		res, d, err = server.cmdPauseHook(msg, false, true, false)
 	case "hooks":
-		res, err = server.cmdHooks(msg, false)
+		res, err = server.cmdHooks(msg, client, false)
 	case "setchan":
 		res, d, err = server.cmdSetHook(msg, true)
 	case "delchan":
 		res, d, err = server.cmdDelHook(msg, true)
 	case "pdelchan":
 		res, d, err = server.cmdPDelHook(msg, true)
+	case "renamechan":
+		res, d, err = server.cmdRenameHook(msg, true)
+	case "pausechan":
+		res, d, err = server.cmdPauseHook(msg, true, false, true)
+	case "ppausechan":
+		res, d, err = server.cmdPauseHook(msg, true, true, true)
+	case "resumechan":
+		res, d, err = server.cmdPauseHook(msg, true, false, false)
+	case "presumechan":
+		res, d, err = server.cmdPauseHook(msg, true, true, false)
 	case "chans":
-		res, err = server.cmdHooks(msg, true)
+		res, err = server.cmdHooks(msg, client, true)
+	case "setschema":
+		res, d, err = server.cmdSetSchema(msg)
+	case "delschema":
+		res, d, err = server.cmdDelSchema(msg)
+	case "schema":
+		res, err = server.cmdSchema(msg)
+	case "setlimits":
+		res, d, err = server.cmdSetLimits(msg)
+	case "dellimits":
+		res, d, err = server.cmdDelLimits(msg)
+	case "limits":
+		res, err = server.cmdLimits(msg)
+	case "setindex":
+		res, d, err = server.cmdSetIndex(msg)
+	case "delindex":
+		res, d, err = server.cmdDelIndex(msg)
+	case "indexes":
+		res, err = server.cmdIndexes(msg)
+	case "setgeoindex":
+		res, d, err = server.cmdSetGeoIndex(msg)
+	case "geoindex":
+		res, err = server.cmdGeoIndex(msg)
+	case "settrigger":
+		res, d, err = server.cmdSetTrigger(msg)
+	case "deltrigger":
+		res, d, err = server.cmdDelTrigger(msg)
+	case "triggers":
+		res, err = server.cmdTriggers(msg)
+	case "setlabel":
+		res, d, err = server.cmdSetLabel(msg)
+	case "dellabel":
+		res, d, err = server.cmdDelLabel(msg)
+	case "labels":
+		res, err = server.cmdLabels(msg)
+	case "sethistory":
+		res, d, err = server.cmdSetHistory(msg)
+	case "delhistory":
+		res, d, err = server.cmdDelHistory(msg)
+	case "history":
+		res, err = server.cmdHistory(msg)
+	case "trajectory":
+		res, err = server.cmdTrajectory(msg)
+	case "passed":
+		res, err = server.cmdPassed(msg)
+	case "fencetest":
+		res, err = server.cmdFenceTest(msg)
 	case "expire":
 		res, d, err = server.cmdExpire(msg)
 	case "persist":
@@ -1040,6 +1825,20 @@ func (server *Server) command(msg *Message, client *Client) (
 		res, err = server.cmdSleep(msg)
 	case "follow", "slaveof":
 		res, err = server.cmdFollow(msg)
+	case "peer":
+		res, err = server.cmdPeer(msg)
+	case "raft":
+		res, err = server.cmdRaft(msg)
+	case "cluster":
+		res, err = server.cmdCluster(msg, client)
+	case "migrate":
+		res, err = server.cmdMigrate(msg)
+	case "wait":
+		res, err = server.cmdWait(msg)
+	case "waitoffset":
+		res, err = server.cmdWaitOffset(msg)
+	case "replica":
+		res, err = server.cmdReplica(msg)
 	case "replconf":
 		res, err = server.cmdReplConf(msg, client)
 	case "readonly":
@@ -1048,6 +1847,26 @@ func (server *Server) command(msg *Message, client *Client) (
 		res, err = server.cmdStats(msg)
 	case "server":
 		res, err = server.cmdServer(msg)
+	case "metrics":
+		res, err = server.cmdMetrics(msg)
+	case "slowlog":
+		res, err = server.cmdSlowlog(msg)
+	case "latency":
+		res, err = server.cmdLatency(msg)
+	case "health":
+		res, err = server.cmdHealth(msg)
+	case "acl":
+		res, err = server.cmdACL(msg, client)
+	case "select":
+		res, err = server.cmdSelect(msg, client)
+	case "namespace":
+		res, err = server.cmdNamespace(msg)
+	case "graphql":
+		res, err = server.cmdGraphQL(msg, client)
+	case "openapi":
+		res, err = server.cmdOpenAPI(msg)
+	case "command":
+		res, err = server.cmdCommand(msg)
 	case "info":
 		res, err = server.cmdInfo(msg)
 	case "scan":
@@ -1066,48 +1885,115 @@ func (server *Server) command(msg *Message, client *Client) (
 		res, err = server.cmdGet(msg)
 	case "jget":
 		res, err = server.cmdJget(msg)
+	case "matrix":
+		res, err = server.cmdMatrix(msg)
+	case "tile":
+		res, err = server.cmdTile(msg)
 	case "jset":
 		res, d, err = server.cmdJset(msg)
 	case "jdel":
 		res, d, err = server.cmdJdel(msg)
 	case "type":
 		res, err = server.cmdType(msg)
+	case "exists":
+		res, err = server.cmdExists(msg)
 	case "keys":
-		res, err = server.cmdKeys(msg)
+		res, err = server.cmdKeys(msg, client)
 	case "output":
 		res, err = server.cmdOutput(msg)
 	case "aof":
 		res, err = server.cmdAOF(msg)
 	case "aofmd5":
 		res, err = server.cmdAOFMD5(msg)
+	case "waitaof":
+		res, err = server.cmdWaitAOF(msg)
+	case "aofcheck":
+		res, err = server.cmdAOFCheck(msg)
 	case "gc":
 		runtime.GC()
 		debug.FreeOSMemory()
 		res = OKMessage(msg, time.Now())
+	case "reindex":
+		res, err = server.cmdReindex(msg)
 	case "aofshrink":
+		if server.config.peerHost() != "" {
+			err = errors.New("aofshrink is not supported with a peer")
+			return
+		}
+		if server.raftEnabled() {
+			// the elections compare the aof offsets, see raftLogPosition
+			err = errors.New("aofshrink is not supported in raft mode")
+			return
+		}
 		go server.aofshrink()
 		res = OKMessage(msg, time.Now())
+	case "save":
+		res, err = server.cmdSave(msg)
+	case "bgsave":
+		res, err = server.cmdBGSave(msg)
+	case "backup":
+		res, err = server.cmdBackup(msg)
+	case "restore":
+		res, err = server.cmdRestore(msg)
+	case "import":
+		res, err = server.cmdImport(msg)
+	case "export":
+		res, err = server.cmdExport(msg)
+	case "dump":
+		res, err = server.cmdDump(msg)
+	case "hookreplay":
+		res, err = server.cmdHookReplay(msg)
+	case "hooklease":
+		res, err = server.cmdHookLease(msg)
 	case "config get":
 		res, err = server.cmdConfigGet(msg)
 	case "config set":
 		res, err = server.cmdConfigSet(msg)
 	case "config rewrite":
 		res, err = server.cmdConfigRewrite(msg)
+	case "config reload":
+		res, err = server.cmdConfigReload(msg)
 	case "config", "script":
 		// These get rewritten into "config foo" and "script bar"
 		err = fmt.Errorf("unknown command '%s'", msg.Args[0])
 		if len(msg.Args) > 1 {
-			msg.Args[1] = msg.Args[0] + " " + msg.Args[1]
-			msg.Args = msg.Args[1:]
+			// a new slice, so that the args of the slowlog and the audit
+			// log are not changed
+			msg.Args = append([]string{msg.Args[0] + " " + msg.Args[1]},
+				msg.Args[2:]...)
 			msg._command = ""
 			return server.command(msg, client)
 		}
 	case "client":
 		res, err = server.cmdClient(msg, client)
+	case "multi":
+		if client != nil {
+			res, err = server.cmdMulti(msg, client)
+		}
+		// otherwise it starts a transaction in the aof, see loadAOF
+	case "exec":
+		if client != nil {
+			res, err = server.cmdExec(msg, client)
+		}
+		// otherwise it ends a transaction in the aof, see loadAOF
+	case "discard":
+		res, err = server.cmdDiscard(msg, client)
+	case "watch":
+		res, err = server.cmdWatch(msg, client)
+	case "unwatch":
+		res, err = server.cmdUnwatch(msg, client)
+	case "memory":
+		res, err = server.cmdMemory(msg)
 	case "eval", "evalro", "evalna":
-		res, err = server.cmdEvalUnified(false, msg)
+		res, err = server.cmdEvalUnified(false, msg, client)
 	case "evalsha", "evalrosha", "evalnasha":
-		res, err = server.cmdEvalUnified(true, msg)
+		res, err = server.cmdEvalUnified(true, msg, client)
+	case "fcall":
+		res, err = server.cmdFcall(msg, false, client)
+	case "fcall_ro":
+		res, err = server.cmdFcall(msg, true, client)
+	case "function":
+		res, d, err = server.cmdFunction(msg)
 	case "script load":
 		res, err = server.cmdScriptLoad(msg)
 	case "script exists":
@@ -1150,26 +2036,7 @@ server to start accepting connections from the outside.
 
 // WriteWebSocketMessage write a websocket message to an io.Writer.
 func WriteWebSocketMessage(w io.Writer, data []byte) error {
-	var msg []byte
-	buf := make([]byte, 10+len(data))
-	buf[0] = 129 // FIN + TEXT
-	if len(data) <= 125 {
-		buf[1] = byte(len(data))
-		copy(buf[2:], data)
-		msg = buf[:2+len(data)]
-	} else if len(data) <= 0xFFFF {
-		buf[1] = 126
-		binary.BigEndian.PutUint16(buf[2:], uint16(len(data)))
-		copy(buf[4:], data)
-		msg = buf[:4+len(data)]
-	} else {
-		buf[1] = 127
-		binary.BigEndian.PutUint64(buf[2:], uint64(len(data)))
-		copy(buf[10:], data)
-		msg = buf[:10+len(data)]
-	}
-	_, err := w.Write(msg)
-	return err
+	return writeWebSocketMessage(w, data, webSocketOptions{})
 }
 
 // OKMessage returns a default OK message in JSON or RESP.
@@ -1200,6 +2067,8 @@ const (
 	HTTP
 	WebSocket
 	JSON
+	GRPC
+	Embedded
 )
 
 // Message is a resp message
@@ -1210,6 +2079,39 @@ type Message struct {
 	OutputType Type
 	Auth       string
 	Deadline   *deadline.Deadline
+	// ContentType is the type of an HTTP response that is written as is,
+	// which is a tile of the debug tile server, or a GraphQL response.
+	ContentType string
+	// status is the status of an HTTP response with a ContentType, which is
+	// 200 OK when empty.
+	status string
+	// resp3 is true when the client switched to RESP3 with HELLO 3.
+	resp3 bool
+	// ws are the options of a WebSocket connection.
+	ws webSocketOptions
+	// encoding is the encoding of the json output, such as MessagePack.
+	encoding encoding
+	// csv are the columns of OUTPUT csv.
+	csv []string
+	// flat is the encoder of OUTPUT flatbuffers.
+	flat *flatEncoder
+	// bounds is true when the objects of the json replies have their
+	// bounding box and centroid, see OUTPUT BOUNDS.
+	bounds bool
+	// rest is true for a request of the REST API, which has the HTTP status
+	// of its errors.
+	rest bool
+	// traceparent is the W3C traceparent header of an HTTP request.
+	traceparent string
+	// stream writes the reply of a STREAM search in chunks, which is nil
+	// when the reply of the connection cannot be streamed.
+	stream *replyStream
+	// keyLock is the lock of the key of a search, which releases the lock
+	// of the server while it reads the objects, see lockRead.
+	keyLock *keyLock
+	// namespace is the prefix of the keys of the namespace of the client,
+	// such as "acme:", or empty for the default namespace, see nsRewrite.
+	namespace string
 }
 
 // Command returns the first argument as a lowercase string
@@ -1277,17 +2179,21 @@ func readNextHTTPCommand(packet []byte, argsIn [][]byte, msg *Message, wr io.Wri
 		if len(path) == 0 || path[0] != '/' {
 			return false, errInvalidHTTP
 		}
-		path, err = url.QueryUnescape(path[1:])
+		rawPath := path[1:]
+		path, err = url.QueryUnescape(rawPath)
 		if err != nil {
 			return false, errInvalidHTTP
 		}
-		if method != "GET" && method != "POST" {
+		rest := isRESTPath(rawPath)
+		if method != "GET" && method != "POST" &&
+			(!rest || (method != "PUT" && method != "DELETE")) {
 			return false, errInvalidHTTP
 		}
 		contentLength := 0
 		websocket := false
 		websocketVersion := 0
 		websocketKey := ""
+		var websocketExtensions, websocketProtocols []string
 		for _, header := range headers[1:] {
 			if header[0] == 'a' || header[0] == 'A' {
 				if strings.HasPrefix(strings.ToLower(header), "authorization:") {
@@ -1307,6 +2213,10 @@ func readNextHTTPCommand(packet []byte, argsIn [][]byte, msg *Message, wr io.Wri
 					websocketVersion = int(n)
 				} else if strings.HasPrefix(strings.ToLower(header), "sec-websocket-key:") {
 					websocketKey = strings.TrimSpace(header[len("sec-websocket-key:"):])
+				} else if strings.HasPrefix(strings.ToLower(header), "sec-websocket-extensions:") {
+					websocketExtensions = append(websocketExtensions, header[len("sec-websocket-extensions:"):])
+				} else if strings.HasPrefix(strings.ToLower(header), "sec-websocket-protocol:") {
+					websocketProtocols = append(websocketProtocols, header[len("sec-websocket-protocol:"):])
 				}
 			} else if header[0] == 'c' || header[0] == 'C' {
 				if strings.HasPrefix(strings.ToLower(header), "content-length:") {
@@ -1317,6 +2227,10 @@ func readNextHTTPCommand(packet []byte, argsIn [][]byte, msg *Message, wr io.Wri
 					}
 					contentLength = int(n)
 				}
+			} else if header[0] == 't' || header[0] == 'T' {
+				if strings.HasPrefix(strings.ToLower(header), "traceparent:") {
+					msg.traceparent = strings.TrimSpace(header[len("traceparent:"):])
+				}
 			}
 		}
 		if websocket && websocketVersion >= 13 && websocketKey != "" {
@@ -1326,21 +2240,76 @@ func readNextHTTPCommand(packet []byte, argsIn [][]byte, msg *Message, wr io.Wri
 			}
 			sum := sha1.Sum([]byte(websocketKey + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
 			accept := base64.StdEncoding.EncodeToString(sum[:])
-			wshead := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + accept + "\r\n\r\n"
+			var wsheaders string
+			msg.ws, wsheaders = negotiateWebSocket(
+				strings.Join(websocketExtensions, ","),
+				strings.Join(websocketProtocols, ","))
+			wshead := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + accept + "\r\n" + wsheaders + "\r\n"
 			if _, err = wr.Write([]byte(wshead)); err != nil {
 				return false, err
 			}
-		} else if contentLength > 0 {
+		} else if contentLength > 0 || rest {
 			msg.ConnType = HTTP
 			if len(packet) < contentLength {
 				return false, nil
 			}
-			path += string(packet[:contentLength])
+			body := packet[:contentLength]
 			packet = packet[contentLength:]
+			if rest {
+				msg.OutputType = JSON
+				msg.Args = restHTTPArgs(method, rawPath, body)
+				msg.rest = true
+				if msg.Args[0] == "openapi" {
+					msg.ContentType = restContentType
+				}
+				return true, nil
+			}
+			if args, ok := graphqlHTTPArgs(path, body); ok {
+				msg.OutputType = JSON
+				msg.Args = args
+				msg.ContentType = graphqlContentType
+				return true, nil
+			}
+			args, ok, err := psetArrayArgs(path, body)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				msg.OutputType = JSON
+				msg.Args = args
+				return true, nil
+			}
+			path += string(body)
 		}
 		if path == "" {
 			return true, nil
 		}
+		if method == "GET" {
+			if args, contentType, ok := httpTileArgs(path); ok {
+				msg.Args = args
+				msg.ContentType = contentType
+				return true, nil
+			}
+			if path == "metrics" || strings.HasPrefix(path, "metrics?") {
+				// the metrics for a Prometheus scrape
+				msg.Args = []string{"metrics"}
+				msg.ContentType = metricsContentType
+				return true, nil
+			}
+			if args, ok := healthHTTPArgs(path); ok {
+				// the probes of an orchestrator, such as Kubernetes
+				msg.OutputType = JSON
+				msg.Args = args
+				msg.ContentType = healthContentType
+				return true, nil
+			}
+			if args, ok := graphqlHTTPArgs(path, nil); ok {
+				msg.OutputType = JSON
+				msg.Args = args
+				msg.ContentType = graphqlContentType
+				return true, nil
+			}
+		}
 		nmsg, err := readNativeMessageLine([]byte(path))
 		if err != nil {
 			return false, err
@@ -1358,7 +2327,7 @@ func readNextHTTPCommand(packet []byte, argsIn [][]byte, msg *Message, wr io.Wri
 func readNextCommand(packet []byte, argsIn [][]byte, msg *Message, wr io.Writer) (
 	complete bool, args [][]byte, kind redcon.Kind, leftover []byte, err error,
 ) {
-	if packet[0] == 'G' || packet[0] == 'P' {
+	if packet[0] == 'G' || packet[0] == 'P' || packet[0] == 'D' {
 		// could be an HTTP request
 		var line []byte
 		for i := 1; i < len(packet); i++ {
@@ -1508,3 +2477,7 @@ func (is *InputStream) End(data []byte) {
 func clientErrorf(format string, args ...interface{}) error {
 	return fmt.Errorf(format, args...)
 }
+
+// deniedNetMessage is written to a connection that the netrules deny.
+var deniedNetMessage = []byte("-DENIED connections are not allowed from " +
+	"this network, see the netrules property\r\n")
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/tidwall/tile38/internal/log"
+)
+
+// addListener adds a listener of the network server, which is closed by the
+// shutdown.
+func (s *Server) addListener(ln net.Listener) {
+	s.lnmu.Lock()
+	s.lns = append(s.lns, ln)
+	s.lnmu.Unlock()
+}
+
+// hooksDrained returns the number of hooks that have not sent their queued
+// messages. The caller must hold the server lock.
+func (s *Server) hooksDrained() (pending int) {
+	for _, h := range s.hooks {
+		if !h.drained() {
+			pending++
+		}
+	}
+	return pending
+}
+
+// shutdown stops the server before the process exits on SIGTERM. The
+// listeners are closed, the commands that are running are finished, and the
+// queued messages of the hooks are sent, for up to the
+// shutdown-drain-timeout. Then the AOF and the queue of the hooks are
+// synced to disk. The server lock is held until the process exits.
+func (s *Server) shutdown() {
+	log.Infof("Shutting down")
+	s.draining.set(true)
+	s.lnmu.Lock()
+	for _, ln := range s.lns {
+		ln.Close()
+	}
+	s.lnmu.Unlock()
+
+	// the commands that are running hold the lock
+	s.mu.Lock()
+	s.followc.add(1)
+	s.peerc.add(1)
+	s.stopServer.set(true)
+
+	deadline := time.Now().Add(s.config.shutdownDrainTimeout())
+	pending := s.hooksDrained()
+	for pending > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+		pending = s.hooksDrained()
+	}
+	if pending > 0 {
+		log.Infof("Shutdown: %d hooks have undelivered messages, which are "+
+			"sent after a restart", pending)
+	}
+	s.close()
+	log.Infof("Shutdown complete")
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/tile38/internal/endpoint"
+)
+
+func TestHookDrained(t *testing.T) {
+	db, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.CreateIndex("hooks", hookLogPrefix+"*",
+		buntdb.IndexJSONCaseSensitive("hook"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		},
+	))
+	defer srv.Close()
+
+	h := &Hook{
+		cond:      sync.NewCond(&sync.Mutex{}),
+		Name:      "fleet",
+		Endpoints: []string{srv.URL},
+		db:        db,
+		epm:       endpoint.NewManager(nil),
+		counter:   &aint{},
+		delivery:  &hookDelivery{},
+	}
+	if !h.drained() {
+		t.Fatal("expected a hook that is not open to be drained")
+	}
+	h.Open()
+	defer h.Close()
+	waitDrained := func(drained bool) {
+		t.Helper()
+		start := time.Now()
+		for h.drained() != drained {
+			if time.Since(start) > time.Second*5 {
+				t.Fatalf("expected drained to be %t", drained)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitDrained(true)
+
+	// the message is queued until the endpoint responds
+	err = db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(hookLogPrefix+uint64ToString(1),
+			`{"hook":"fleet"}`, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Signal()
+	if h.drained() {
+		t.Fatal("expected a queued message")
+	}
+	time.Sleep(time.Millisecond * 50)
+	if h.drained() {
+		t.Fatal("expected a message that is being sent")
+	}
+	close(release)
+	waitDrained(true)
+	if h.delivery.sent.get() != 1 {
+		t.Fatalf("expected 1 sent message, got %d", h.delivery.sent.get())
+	}
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	// slowlogMaxArgs is the number of arguments of an entry, and the others
+	// are counted in the last argument.
+	slowlogMaxArgs = 32
+	// slowlogMaxArgLen is the length of an argument of an entry, which keeps
+	// the coordinates of a large polygon from filling the log.
+	slowlogMaxArgLen = 128
+)
+
+// slowlogEntry is a command that took longer than the threshold of the
+// slowlog.
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string // sanitized arguments
+	addr     string   // address of the client
+	name     string   // name of the client
+}
+
+// slowlog is the commands that took longer than slowlog-log-slower-than,
+// newest first.
+type slowlog struct {
+	mu      sync.Mutex
+	entries []slowlogEntry
+	nextID  int64
+}
+
+// observe adds a command when it took longer than the threshold, which is
+// in microseconds. A negative threshold turns off the log.
+func (sl *slowlog) observe(args []string, client *Client, start time.Time,
+	elapsed time.Duration, threshold, maxLen int64,
+) {
+	if threshold < 0 || elapsed < time.Duration(threshold)*time.Microsecond {
+		return
+	}
+	entry := slowlogEntry{
+		time:     start,
+		duration: elapsed,
+		args:     slowlogArgs(args),
+	}
+	if client != nil {
+		client.mu.Lock()
+		entry.addr, entry.name = client.remoteAddr, client.name
+		client.mu.Unlock()
+	}
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	entry.id = sl.nextID
+	sl.nextID++
+	sl.entries = append(sl.entries, slowlogEntry{})
+	copy(sl.entries[1:], sl.entries)
+	sl.entries[0] = entry
+	if int64(len(sl.entries)) > maxLen {
+		sl.entries = sl.entries[:maxLen]
+	}
+}
+
+// slowlogSecrets are the commands with arguments that are secrets, and the
+// index of the first secret.
+var slowlogSecrets = map[string]int{
+	"auth": 1,
+}
+
+// slowlogArgs returns the arguments of an entry, which has no secrets and
+// no long arguments.
+func slowlogArgs(args []string) []string {
+	n := len(args)
+	if n > slowlogMaxArgs {
+		n = slowlogMaxArgs - 1
+	}
+	sargs := make([]string, 0, n+1)
+	for _, arg := range args[:n] {
+		if len(arg) > slowlogMaxArgLen {
+			arg = arg[:slowlogMaxArgLen] + "... (" +
+				strconv.Itoa(len(arg)-slowlogMaxArgLen) + " more bytes)"
+		}
+		sargs = append(sargs, arg)
+	}
+	if n < len(args) {
+		sargs = append(sargs, "... ("+strconv.Itoa(len(args)-n)+
+			" more arguments)")
+	}
+	if len(sargs) == 0 {
+		return sargs
+	}
+	command := strings.ToLower(sargs[0])
+	secret, ok := slowlogSecrets[command]
+	switch {
+	case command == "hello":
+		// HELLO protover AUTH username password
+		for i := 1; i < len(sargs)-2; i++ {
+			if strings.EqualFold(sargs[i], "auth") {
+				secret, ok = i+2, true
+				break
+			}
+		}
+	case command == "acl" && len(sargs) > 3 &&
+		strings.EqualFold(sargs[1], "token"):
+		// ACL TOKEN ADD|DEL token [EX seconds]
+		sargs[3] = "(redacted)"
+	case command == "acl" && len(sargs) > 2 &&
+		strings.EqualFold(sargs[1], "setuser"):
+		// the passwords are the rules that start with > or <
+		for i := 3; i < len(sargs); i++ {
+			if strings.HasPrefix(sargs[i], ">") ||
+				strings.HasPrefix(sargs[i], "<") {
+				sargs[i] = "(redacted)"
+			}
+		}
+	case command == "sethook" && len(sargs) > 2:
+		// SETHOOK name endpoint
+		sargs[2] = redactEndpoints(sargs[2])
+	case command == "config" && len(sargs) > 3 &&
+		strings.EqualFold(sargs[1], "set"):
+		switch strings.ToLower(sargs[2]) {
+		case RequirePass, LeaderAuth, ReplPass:
+			secret, ok = 3, true
+		}
+	}
+	if ok {
+		for i := secret; i < len(sargs); i++ {
+			sargs[i] = "(redacted)"
+		}
+	}
+	return sargs
+}
+
+// SLOWLOG GET [count]
+// SLOWLOG LEN
+// SLOWLOG RESET
+//
+// Returns the commands that took longer than slowlog-log-slower-than, newest
+// first, which are the last slowlog-max-len commands. The count of GET is 10
+// by default, and -1 for all of them.
+func (s *Server) cmdSlowlog(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) < 2 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	switch strings.ToLower(msg.Args[1]) {
+	case "get":
+		count := 10
+		switch len(msg.Args) {
+		case 2:
+		case 3:
+			n, err := strconv.Atoi(msg.Args[2])
+			if err != nil || n < -1 {
+				return NOMessage, errInvalidArgument(msg.Args[2])
+			}
+			count = n
+		default:
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		s.slowlog.mu.Lock()
+		entries := s.slowlog.entries
+		if count != -1 && count < len(entries) {
+			entries = entries[:count]
+		}
+		entries = append([]slowlogEntry(nil), entries...)
+		s.slowlog.mu.Unlock()
+		return slowlogValue(msg, start, entries), nil
+	case "len":
+		if len(msg.Args) != 2 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		s.slowlog.mu.Lock()
+		n := len(s.slowlog.entries)
+		s.slowlog.mu.Unlock()
+		switch msg.OutputType {
+		case JSON:
+			return resp.StringValue(`{"ok":true,"len":` + strconv.Itoa(n) +
+				`,"elapsed":"` + time.Since(start).String() + `"}`), nil
+		case RESP:
+			return resp.IntegerValue(n), nil
+		}
+	case "reset":
+		if len(msg.Args) != 2 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+		s.slowlog.mu.Lock()
+		s.slowlog.entries = nil
+		s.slowlog.mu.Unlock()
+		return OKMessage(msg, start), nil
+	default:
+		return NOMessage, clientErrorf(
+			"Syntax error, try SLOWLOG (GET | LEN | RESET)",
+		)
+	}
+	return NOMessage, nil
+}
+
+func slowlogValue(msg *Message, start time.Time, entries []slowlogEntry,
+) resp.Value {
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"slowlog":[`...)
+		for i, e := range entries {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, `{"id":`...)
+			buf = strconv.AppendInt(buf, e.id, 10)
+			buf = append(buf, `,"time":`...)
+			buf = strconv.AppendInt(buf, e.time.Unix(), 10)
+			buf = append(buf, `,"duration":`...)
+			buf = strconv.AppendInt(buf, e.duration.Microseconds(), 10)
+			buf = append(buf, `,"args":[`...)
+			for j, arg := range e.args {
+				if j > 0 {
+					buf = append(buf, ',')
+				}
+				buf = appendJSONString(buf, arg)
+			}
+			buf = append(buf, `],"addr":`...)
+			buf = appendJSONString(buf, e.addr)
+			buf = append(buf, `,"name":`...)
+			buf = appendJSONString(buf, e.name)
+			buf = append(buf, '}')
+		}
+		buf = append(buf, `],"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf)
+	case RESP:
+		vals := make([]resp.Value, 0, len(entries))
+		for _, e := range entries {
+			args := make([]resp.Value, len(e.args))
+			for i, arg := range e.args {
+				args[i] = resp.StringValue(arg)
+			}
+			vals = append(vals, resp.ArrayValue([]resp.Value{
+				resp.IntegerValue(int(e.id)),
+				resp.IntegerValue(int(e.time.Unix())),
+				resp.IntegerValue(int(e.duration.Microseconds())),
+				resp.ArrayValue(args),
+				resp.StringValue(e.addr),
+				resp.StringValue(e.name),
+			}))
+		}
+		return resp.ArrayValue(vals)
+	}
+	return NOMessage
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/core"
+	"github.com/tidwall/tile38/internal/log"
+)
+
+// snapRawField is the field that has the point of a SET before it was
+// snapped, which is a [lon,lat] or [lon,lat,z] json array.
+const snapRawField = "rawpoint"
+
+var snapClient = &http.Client{Timeout: time.Second * 2}
+
+// snapStamp returns the SET with its point snapped to a road network, when
+// its key matches the snapkeys patterns. The point is snapped by the
+// core.PointSnapper, or by the OSRM nearest service at the snapurl. The
+// snapped point is then what's stored, tested by the fences, and written to
+// the aof, and the point that was sent is kept in the rawpoint field. The
+// point is stored as it was sent when it cannot be snapped.
+//
+// It's called before the command is locked, so that the writes of the other
+// clients do not wait for the snapping service. The SET is parsed under the
+// read lock of the server, which has the geometry options of the keys, and
+// the service is called after the lock is released.
+func (s *Server) snapStamp(msg *Message) *Message {
+	patterns := s.config.snapKeys()
+	if len(patterns) == 0 || msg.Command() != "set" || len(msg.Args) < 2 ||
+		!keyspaceMatch(patterns, msg.Args[1]) {
+		return msg
+	}
+	snapURL := s.config.snapURL()
+	if core.PointSnapper == nil && snapURL == "" {
+		return msg
+	}
+	s.mu.RLock()
+	d, _, _, _, _, _, _, etype, evs, err := s.parseSetArgs(msg.Args[1:])
+	s.mu.RUnlock()
+	if err != nil || !lcb(etype, "point") {
+		return msg
+	}
+	point := d.obj.(*geojson.Point)
+	lat, lon := point.Base().Y, point.Base().X
+	var slat, slon float64
+	if core.PointSnapper != nil {
+		slat, slon, err = core.PointSnapper.Snap(d.key, d.id, lat, lon)
+	} else {
+		slat, slon, err = snapOSRM(snapURL, lat, lon)
+	}
+	if err != nil {
+		log.Errorf("snap failed: %v", err)
+		return msg
+	}
+	raw := "[" + strconv.FormatFloat(lon, 'f', -1, 64) + "," +
+		strconv.FormatFloat(lat, 'f', -1, 64)
+	if len(evs) > 2 {
+		raw += "," + strconv.FormatFloat(point.Z(), 'f', -1, 64)
+	}
+	raw += "]"
+
+	// The lat and lon follow the POINT, which is replaced by the rawpoint
+	// field and the snapped point.
+	i := len(msg.Args) - len(evs) - 1
+	args := make([]string, 0, len(msg.Args)+3)
+	args = append(args, msg.Args[:i]...)
+	args = append(args, "FIELD", snapRawField, raw, msg.Args[i],
+		strconv.FormatFloat(slat, 'f', -1, 64),
+		strconv.FormatFloat(slon, 'f', -1, 64))
+	args = append(args, msg.Args[i+3:]...)
+	nmsg := *msg
+	nmsg.Args = args
+	return &nmsg
+}
+
+// snapOSRM returns the point on a road that is the nearest to a point, from
+// the OSRM nearest service at the url, such as
+// http://localhost:5000/nearest/v1/driving.
+func snapOSRM(snapURL string, lat, lon float64) (float64, float64, error) {
+	u := strings.TrimSuffix(snapURL, "/") + "/" +
+		url.PathEscape(strconv.FormatFloat(lon, 'f', -1, 64)+","+
+			strconv.FormatFloat(lat, 'f', -1, 64)) + "?number=1"
+	resp, err := snapClient.Get(u)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("snap endpoint returned status code %d",
+			resp.StatusCode)
+	}
+	loc := gjson.GetBytes(body, "waypoints.0.location").Array()
+	if gjson.GetBytes(body, "code").String() != "Ok" || len(loc) != 2 {
+		return 0, 0, errors.New("snap endpoint returned no waypoint")
+	}
+	return loc[1].Float(), loc[0].Float(), nil
+}
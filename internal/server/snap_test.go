@@ -0,0 +1,65 @@
+package server
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/tile38/core"
+)
+
+type testSnapper struct{}
+
+func (testSnapper) Snap(key, id string, lat, lon float64) (float64, float64,
+	error,
+) {
+	return 33, -115, nil
+}
+
+func TestSnapStamp(t *testing.T) {
+	s := newSnapshotTestServer()
+	s.geoIndexes = make(map[string]*geojson.ParseOptions)
+	if err := s.config.setProperty(SnapKeys, "fleet", false); err != nil {
+		t.Fatal(err)
+	}
+	core.PointSnapper = testSnapper{}
+	defer func() { core.PointSnapper = nil }()
+
+	// the geometry options are changed by SETGEOINDEX while the points
+	// of the SETs are snapped, which must not race.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			args := []string{"setgeoindex", "fleet", "points", "16"}
+			if i%2 == 1 {
+				args = args[:2]
+			}
+			s.mu.Lock()
+			if _, _, err := s.cmdSetGeoIndex(&Message{Args: args}); err != nil {
+				t.Error(err)
+			}
+			s.mu.Unlock()
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		msg := s.snapStamp(&Message{
+			Args: []string{"set", "fleet", "truck1", "POINT", "33.1", "-115.1"},
+		})
+		if len(msg.Args) != 9 || msg.Args[4] != "rawpoint" || msg.Args[7] != "33" {
+			t.Fatalf("expected a snapped point, got %v", msg.Args)
+		}
+		// an object is parsed with the options of the key, and not snapped
+		msg = &Message{Args: []string{"set", "fleet", "truck2", "OBJECT",
+			`{"type":"Point","coordinates":[-115.1,33.1]}`}}
+		if s.snapStamp(msg) != msg {
+			t.Fatal("expected the object as it was sent")
+		}
+	}
+	wg.Wait()
+	msg := &Message{Args: []string{"set", "other", "truck1", "POINT", "1", "2"}}
+	if s.snapStamp(msg) != msg {
+		t.Fatal("expected the set of another key as it was sent")
+	}
+}
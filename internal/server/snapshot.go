@@ -0,0 +1,630 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/core"
+	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/field"
+	"github.com/tidwall/tile38/internal/log"
+)
+
+// The snapshot file is a compact binary copy of the dataset. It starts with
+// a header that includes the aof position at the time of the snapshot, and
+// ends with a crc32 checksum of everything before it.
+//
+//	header:  "TILE38SNAP" version created aofOffset aofTailCRC
+//	records: 'C' key nfields fields...
+//	         'O' id kind payload nvalues values... expires timestamp version
+//	         'K' nargs args...
+//	         'E'
+//	footer:  crc32
+const snapshotMagic = "TILE38SNAP"
+const snapshotVersion = 4
+
+// snapshot field value kinds, since version 2. A version 1 snapshot only has
+// the numbers, without the kind.
+const (
+	snapshotFieldNumber = 0
+	snapshotFieldText   = 1
+)
+
+// snapshotTailSize is the number of aof bytes, just before the snapshot
+// offset, that are used to verify that the aof still matches the snapshot.
+const snapshotTailSize = 4096
+
+// snapshot record types
+const (
+	snapshotRecCol = 'C'
+	snapshotRecObj = 'O'
+	snapshotRecCmd = 'K'
+	snapshotRecEnd = 'E'
+)
+
+// snapshot object kinds
+const (
+	snapshotObjString = 0
+	snapshotObjPoint  = 1
+	snapshotObjPointZ = 2
+	snapshotObjJSON   = 3
+)
+
+var errSnapshotInProgress = errors.New("snapshot save already in progress")
+var errSnapshotCorrupt = errors.New("snapshot is corrupt")
+var errSnapshotNotSupported = errors.New("snapshots are not supported by the kv persistence engine")
+
+type snapshotHeader struct {
+	created    int64
+	aofOffset  int64
+	aofTailCRC uint32
+}
+
+// aofTailCRC returns the checksum of the aof bytes just before offset.
+func aofTailCRC(f *cryptFile, offset int64) (uint32, error) {
+	start := offset - snapshotTailSize
+	if start < 0 {
+		start = 0
+	}
+	data := make([]byte, offset-start)
+	if _, err := f.ReadAt(data, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(data), nil
+}
+
+func appendSnapshotUvarint(dst []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(dst, buf[:n]...)
+}
+
+func appendSnapshotString(dst []byte, s string) []byte {
+	dst = appendSnapshotUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func appendSnapshotFloat(dst []byte, f float64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+	return append(dst, buf[:]...)
+}
+
+func appendSnapshotInt(dst []byte, x int64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(x))
+	return append(dst, buf[:]...)
+}
+
+// appendSnapshotObject appends the object kind and payload. Plain points are
+// stored as raw coordinates, everything else as a string or geojson.
+func appendSnapshotObject(dst []byte, obj geojson.Object, jbuf []byte) (
+	[]byte, []byte,
+) {
+	if !objIsSpatial(obj) {
+		dst = append(dst, snapshotObjString)
+		return appendSnapshotString(dst, obj.String()), jbuf
+	}
+	jbuf = obj.AppendJSON(jbuf[:0])
+	if point, ok := obj.(*geojson.Point); ok {
+		base := point.Base()
+		// Only use the raw coordinates when the point can be recreated
+		// exactly, otherwise members such as 'bbox' would be lost.
+		if bytes.Equal(jbuf, geojson.NewPoint(base).AppendJSON(nil)) {
+			dst = append(dst, snapshotObjPoint)
+			dst = appendSnapshotFloat(dst, base.X)
+			return appendSnapshotFloat(dst, base.Y), jbuf
+		}
+		z := point.Z()
+		if bytes.Equal(jbuf, geojson.NewPointZ(base, z).AppendJSON(nil)) {
+			dst = append(dst, snapshotObjPointZ)
+			dst = appendSnapshotFloat(dst, base.X)
+			dst = appendSnapshotFloat(dst, base.Y)
+			return appendSnapshotFloat(dst, z), jbuf
+		}
+	}
+	dst = append(dst, snapshotObjJSON)
+	dst = appendSnapshotUvarint(dst, uint64(len(jbuf)))
+	return append(dst, jbuf...), jbuf
+}
+
+// encodeSnapshot writes the collections and hook commands to w, followed
+// by the checksum.
+func encodeSnapshot(w io.Writer, cols []snapshotCol, hooks [][]string,
+	hdr snapshotHeader,
+) error {
+	crc := crc32.NewIEEE()
+	mw := io.MultiWriter(w, crc)
+	var buf, jbuf []byte
+	flush := func(force bool) error {
+		if len(buf) > maxchunk || (force && len(buf) > 0) {
+			if _, err := mw.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+		return nil
+	}
+
+	buf = append(buf, snapshotMagic...)
+	buf = append(buf, snapshotVersion)
+	buf = appendSnapshotInt(buf, hdr.created)
+	buf = appendSnapshotInt(buf, hdr.aofOffset)
+	buf = appendSnapshotUvarint(buf, uint64(hdr.aofTailCRC))
+	for _, scol := range cols {
+		buf = append(buf, snapshotRecCol)
+		buf = appendSnapshotString(buf, scol.key)
+		fields := scol.snap.FieldArr()
+		fmap := scol.snap.FieldMap()
+		names := make([]string, len(fields))
+		for _, field := range fields {
+			names[fmap[field]] = field
+		}
+		buf = appendSnapshotUvarint(buf, uint64(len(names)))
+		for _, name := range names {
+			buf = appendSnapshotString(buf, name)
+		}
+		var werr error
+		scol.snap.Scan(false, nil, nil, func(id string, obj geojson.Object, values []field.Value) bool {
+			buf = append(buf, snapshotRecObj)
+			buf = appendSnapshotString(buf, id)
+			buf, jbuf = appendSnapshotObject(buf, obj, jbuf)
+			buf = appendSnapshotUvarint(buf, uint64(len(values)))
+			for _, value := range values {
+				if value.Kind() == field.Number {
+					buf = append(buf, snapshotFieldNumber)
+					buf = appendSnapshotFloat(buf, value.Num())
+				} else {
+					buf = append(buf, snapshotFieldText)
+					buf = appendSnapshotString(buf, value.String())
+				}
+			}
+			buf = appendSnapshotInt(buf, scol.expires[id])
+			buf = appendSnapshotInt(buf, scol.snap.Timestamp(id))
+			buf = appendSnapshotUvarint(buf, scol.snap.Version(id))
+			werr = flush(false)
+			return werr == nil
+		})
+		if werr != nil {
+			return werr
+		}
+	}
+	for _, args := range hooks {
+		buf = append(buf, snapshotRecCmd)
+		buf = appendSnapshotUvarint(buf, uint64(len(args)))
+		for _, arg := range args {
+			buf = appendSnapshotString(buf, arg)
+		}
+	}
+	buf = append(buf, snapshotRecEnd)
+	if err := flush(true); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc.Sum32())
+	_, err := w.Write(sum[:])
+	return err
+}
+
+// writeSnapshot writes the snapshot file. The file is written to a
+// temporary location and then renamed, so a failed save never replaces a
+// good snapshot.
+func writeSnapshot(cols []snapshotCol, hooks [][]string, hdr snapshotHeader,
+	aead cipher.AEAD,
+) error {
+	path := core.SnapshotFileName + "-tmp"
+	f, err := openCryptFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, aead)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(path)
+	}()
+	if err := encodeSnapshot(f, cols, hooks, hdr); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path, core.SnapshotFileName)
+}
+
+// save writes a point-in-time snapshot of the dataset to disk. The server
+// lock is only held while taking the snapshot.
+func (server *Server) save() error {
+	start := time.Now()
+	server.mu.Lock()
+	if server.saving {
+		server.mu.Unlock()
+		return errSnapshotInProgress
+	}
+	server.saving = true
+	cols, hooks := server.datasetSnapshot()
+	hdr := snapshotHeader{created: start.UnixNano()}
+	var err error
+	if server.aof != nil {
+		server.flushAOF(false)
+		hdr.aofOffset = int64(server.aofsz)
+		hdr.aofTailCRC, err = aofTailCRC(server.aof, hdr.aofOffset)
+	}
+	server.mu.Unlock()
+
+	if err == nil {
+		err = writeSnapshot(cols, hooks, hdr, server.aead)
+	}
+
+	server.mu.Lock()
+	server.saving = false
+	server.lastSaveErr = err != nil
+	if err == nil {
+		server.lastSave = start
+	}
+	server.mu.Unlock()
+	if err != nil {
+		log.Errorf("snapshot save failed: %v", err)
+		return err
+	}
+	log.Infof("snapshot saved %v", time.Since(start))
+	return nil
+}
+
+// SAVE
+func (server *Server) cmdSave(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if _, ok := server.persist.(*kvPersister); ok {
+		return NOMessage, errSnapshotNotSupported
+	}
+	if err := server.save(); err != nil {
+		return NOMessage, err
+	}
+	return OKMessage(msg, start), nil
+}
+
+// BGSAVE
+func (server *Server) cmdBGSave(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	if len(msg.Args) != 1 {
+		return NOMessage, errInvalidNumberOfArguments
+	}
+	if _, ok := server.persist.(*kvPersister); ok {
+		return NOMessage, errSnapshotNotSupported
+	}
+	server.mu.RLock()
+	saving := server.saving
+	server.mu.RUnlock()
+	if saving {
+		return NOMessage, errSnapshotInProgress
+	}
+	go server.save()
+	return OKMessage(msg, start), nil
+}
+
+type snapshotReader struct {
+	rd      *bufio.Reader
+	buf     []byte
+	err     error
+	version byte
+}
+
+func (r *snapshotReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	var b byte
+	b, r.err = r.rd.ReadByte()
+	return b
+}
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var x uint64
+	x, r.err = binary.ReadUvarint(r.rd)
+	return x
+}
+
+func (r *snapshotReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if uint64(cap(r.buf)) < n {
+		r.buf = make([]byte, n)
+	}
+	r.buf = r.buf[:n]
+	_, r.err = io.ReadFull(r.rd, r.buf)
+	return r.buf
+}
+
+func (r *snapshotReader) string() string {
+	return string(r.bytes(r.uvarint()))
+}
+
+func (r *snapshotReader) int() int64 {
+	data := r.bytes(8)
+	if r.err != nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(data))
+}
+
+func (r *snapshotReader) float() float64 {
+	return math.Float64frombits(uint64(r.int()))
+}
+
+// field reads a field value, which is a number or the text of a value.
+func (r *snapshotReader) field() field.Value {
+	if r.version < 2 {
+		return field.Num(r.float())
+	}
+	switch r.byte() {
+	case snapshotFieldNumber:
+		return field.Num(r.float())
+	case snapshotFieldText:
+		return field.Parse(r.string())
+	}
+	if r.err == nil {
+		r.err = errSnapshotCorrupt
+	}
+	return field.Value{}
+}
+
+func (r *snapshotReader) header() (hdr snapshotHeader) {
+	if string(r.bytes(uint64(len(snapshotMagic)))) != snapshotMagic {
+		if r.err == nil {
+			r.err = errSnapshotCorrupt
+		}
+		return hdr
+	}
+	if r.version = r.byte(); r.version < 1 || r.version > snapshotVersion {
+		if r.err == nil {
+			r.err = errSnapshotCorrupt
+		}
+		return hdr
+	}
+	hdr.created = r.int()
+	hdr.aofOffset = r.int()
+	hdr.aofTailCRC = uint32(r.uvarint())
+	return hdr
+}
+
+// verifySnapshot checks the snapshot checksum and returns its header.
+func verifySnapshot(f *cryptFile) (hdr snapshotHeader, err error) {
+	size, err := f.Size()
+	if err != nil {
+		return hdr, err
+	}
+	if size < int64(len(snapshotMagic))+4 {
+		return hdr, errSnapshotCorrupt
+	}
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, io.LimitReader(f, size-4)); err != nil {
+		return hdr, err
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(f, sum[:]); err != nil {
+		return hdr, err
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != crc.Sum32() {
+		return hdr, errSnapshotCorrupt
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return hdr, err
+	}
+	r := &snapshotReader{rd: bufio.NewReader(f)}
+	hdr = r.header()
+	return hdr, r.err
+}
+
+// loadSnapshot restores the dataset from the snapshot file, if one exists,
+// and positions the aof at the first command that was written after the
+// snapshot was taken. When the aof no longer matches the snapshot, such as
+// after an aofshrink, the snapshot is ignored and the full aof is loaded.
+func (s *Server) loadSnapshot() error {
+	f, err := openCryptFile(core.SnapshotFileName, os.O_RDONLY, s.aead)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	start := time.Now()
+	hdr, err := verifySnapshot(f)
+	if err != nil {
+		if s.aof == nil {
+			return fmt.Errorf("snapshot: %v", err)
+		}
+		log.Errorf("Snapshot ignored: %v", err)
+		return nil
+	}
+	if s.aof != nil {
+		size, err := s.aof.Size()
+		if err != nil {
+			return err
+		}
+		var crc uint32
+		if size >= hdr.aofOffset {
+			crc, err = aofTailCRC(s.aof, hdr.aofOffset)
+			if err != nil {
+				return err
+			}
+		}
+		if size < hdr.aofOffset || crc != hdr.aofTailCRC {
+			log.Infof("Snapshot ignored: does not match the aof")
+			return nil
+		}
+	}
+	var count int
+	var col *collection.Collection
+	s.beginBulkLoad()
+	err = s.readSnapshot(f, func(rec *snapshotRecord) error {
+		switch rec.kind {
+		case snapshotRecCol:
+			var err error
+			col, err = s.newCol(rec.key)
+			if err != nil {
+				return err
+			}
+			s.setCol(rec.key, col)
+		case snapshotRecObj:
+			if len(rec.values) > 0 {
+				col.Set(rec.id, rec.obj, rec.fields[:len(rec.values)],
+					rec.values)
+			} else {
+				col.Set(rec.id, rec.obj, nil, nil)
+			}
+			if rec.ex != 0 {
+				s.expireAt(rec.key, rec.id, time.Unix(0, rec.ex))
+			}
+			col.SetTimestamp(rec.id, rec.ts)
+			col.SetVersion(rec.id, rec.ver)
+			count++
+		case snapshotRecCmd:
+			if _, _, err := s.command(&Message{Args: rec.args}, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.endBulkLoad()
+	if err != nil {
+		return err
+	}
+	if s.aof != nil {
+		if _, err := s.aof.Seek(hdr.aofOffset, 0); err != nil {
+			return err
+		}
+		s.aofsz = int(hdr.aofOffset)
+	}
+	log.Infof("Snapshot loaded %d objects: %.2fs", count,
+		float64(time.Since(start))/float64(time.Second))
+	return nil
+}
+
+// snapshotRecord is a collection, object, or command that was read from a
+// snapshot.
+type snapshotRecord struct {
+	kind   byte
+	key    string   // collection key
+	fields []string // collection field names
+	id     string   // object id
+	obj    geojson.Object
+	values []field.Value // object field values
+	ex     int64         // object expiration, unix nano
+	ts     int64         // object observation time, unix nano
+	ver    uint64        // object version
+	args   []string      // command args
+}
+
+// readSnapshot calls iter for every record in a snapshot that was
+// previously verified. The record is reused between calls.
+func (s *Server) readSnapshot(f *cryptFile, iter func(rec *snapshotRecord) error,
+) error {
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+	r := &snapshotReader{rd: bufio.NewReader(f)}
+	r.header()
+	var rec snapshotRecord
+	var hasCol bool
+	for r.err == nil {
+		rec.kind = r.byte()
+		switch rec.kind {
+		case snapshotRecCol:
+			rec.key = r.string()
+			rec.fields = rec.fields[:0]
+			for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+				rec.fields = append(rec.fields, r.string())
+			}
+			hasCol = true
+		case snapshotRecObj:
+			if !hasCol {
+				return errSnapshotCorrupt
+			}
+			rec.id = r.string()
+			rec.obj = nil
+			switch r.byte() {
+			case snapshotObjString:
+				rec.obj = collection.String(r.string())
+			case snapshotObjPoint:
+				x, y := r.float(), r.float()
+				rec.obj = geojson.NewPoint(geometry.Point{X: x, Y: y})
+			case snapshotObjPointZ:
+				x, y, z := r.float(), r.float(), r.float()
+				rec.obj = geojson.NewPointZ(geometry.Point{X: x, Y: y}, z)
+			case snapshotObjJSON:
+				data := r.bytes(r.uvarint())
+				if r.err != nil {
+					break
+				}
+				obj, err := geojson.Parse(string(data), s.parseOpts(rec.key))
+				if err != nil {
+					return err
+				}
+				rec.obj = obj
+			default:
+				if r.err == nil {
+					return errSnapshotCorrupt
+				}
+			}
+			rec.values = rec.values[:0]
+			for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+				rec.values = append(rec.values, r.field())
+			}
+			rec.ex = r.int()
+			rec.ts = 0
+			if r.version >= 3 {
+				// the observation time, since version 3
+				rec.ts = r.int()
+			}
+			rec.ver = 0
+			if r.version >= 4 {
+				// the version, since version 4
+				rec.ver = r.uvarint()
+			}
+			if r.err != nil {
+				break
+			}
+			if len(rec.values) > len(rec.fields) {
+				return errSnapshotCorrupt
+			}
+		case snapshotRecCmd:
+			rec.args = rec.args[:0]
+			for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+				rec.args = append(rec.args, r.string())
+			}
+		case snapshotRecEnd:
+			return nil
+		default:
+			if r.err == nil {
+				return errSnapshotCorrupt
+			}
+		}
+		if r.err == nil {
+			if err := iter(&rec); err != nil {
+				return err
+			}
+		}
+	}
+	return r.err
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tidwall/btree"
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/rhh"
+	"github.com/tidwall/tile38/core"
+	"github.com/tidwall/tile38/internal/collection"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+func newSnapshotTestServer() *Server {
+	s := &Server{
+		cols:    btree.New(byCollectionKey),
+		expires: rhh.New(0),
+		hooks:   make(map[string]*Hook),
+		schemas: make(map[string]*schema),
+		indexes: make(map[string][]string),
+		lcond:   sync.NewCond(&sync.Mutex{}),
+		config:  &Config{},
+	}
+	s.geomParseOpts = *geojson.DefaultParseOptions
+	return s
+}
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	core.SnapshotFileName = filepath.Join(dir, "snapshot.db")
+	defer func() { core.SnapshotFileName = "" }()
+
+	s1 := newSnapshotTestServer()
+	fleet := collection.New()
+	fleet.Set("truck1", geojson.NewPoint(geometry.Point{X: -112, Y: 33}),
+		[]string{"speed", "age"}, []field.Value{field.Num(10), field.Num(20)})
+	fleet.Set("truck2", geojson.NewPointZ(geometry.Point{X: -113, Y: 34}, 50),
+		[]string{"age", "status", "cargo"}, []field.Value{field.Num(5),
+			field.Parse("idle"), field.Parse(`{"kind":"fruit"}`)})
+	poly, err := geojson.Parse(`{"type":"Polygon","coordinates":`+
+		`[[[0,0],[10,0],[10,10],[0,10],[0,0]]]}`, &s1.geomParseOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fleet.Set("area", poly, nil, nil)
+	fleet.Set("name", collection.String("hello"), nil, nil)
+	s1.setCol("fleet", fleet)
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+	s1.expireAt("fleet", "truck1", at)
+	fleet.SetTimestamp("truck2", 1600000000e9)
+	fleet.SetVersion("truck2", 7)
+
+	cols, hooks := s1.datasetSnapshot()
+	if err := writeSnapshot(cols, hooks, snapshotHeader{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := newSnapshotTestServer()
+	if err := s2.loadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	col := s2.getCol("fleet")
+	if col == nil || col.Count() != 4 {
+		t.Fatal("expected 4 objects")
+	}
+	for _, id := range []string{"truck1", "truck2", "area", "name"} {
+		obj1, fields1, _ := fleet.Get(id)
+		obj2, fields2, ok := col.Get(id)
+		if !ok || obj1.String() != obj2.String() {
+			t.Fatalf("%s: expected '%s', got '%v'", id, obj1, obj2)
+		}
+		for i, name := range fleet.FieldArr() {
+			v1 := fieldValue(fleet.FieldMap()[name], fields1)
+			v2 := fieldValue(col.FieldMap()[name], fields2)
+			if v1 != v2 {
+				t.Fatalf("%s: field %d: expected %v, got %v", id, i, v1, v2)
+			}
+		}
+	}
+	if ex, ok := s2.getExpires("fleet", "truck1"); !ok || !ex.Equal(at) {
+		t.Fatalf("expected expires %v, got %v", at, ex)
+	}
+	if ts := col.Timestamp("truck2"); ts != 1600000000e9 {
+		t.Fatalf("expected timestamp %v, got %v", int64(1600000000e9), ts)
+	}
+	if v := col.Version("truck2"); v != 7 {
+		t.Fatalf("expected version 7, got %v", v)
+	}
+
+	// corrupt the snapshot
+	data, err := ioutil.ReadFile(core.SnapshotFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2]++
+	if err := ioutil.WriteFile(core.SnapshotFileName, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := newSnapshotTestServer().loadSnapshot(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func fieldValue(idx int, values []field.Value) field.Value {
+	if idx < len(values) {
+		return values[idx]
+	}
+	return field.Value{}
+}
+
+func TestSnapshotEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	core.SnapshotFileName = filepath.Join(dir, "snapshot.db")
+	defer func() { core.SnapshotFileName = "" }()
+
+	s1 := newSnapshotTestServer()
+	s1.aead = testAEAD(t, 1)
+	fleet := collection.New()
+	fleet.Set("truck1", geojson.NewPoint(geometry.Point{X: -112, Y: 33}), nil, nil)
+	s1.setCol("fleet", fleet)
+	cols, hooks := s1.datasetSnapshot()
+	if err := writeSnapshot(cols, hooks, snapshotHeader{}, s1.aead); err != nil {
+		t.Fatal(err)
+	}
+	if err := newSnapshotTestServer().loadSnapshot(); err != errEncryptionKeyRequired {
+		t.Fatalf("expected '%v', got '%v'", errEncryptionKeyRequired, err)
+	}
+	s2 := newSnapshotTestServer()
+	s2.aead = s1.aead
+	if err := s2.loadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if col := s2.getCol("fleet"); col == nil || col.Count() != 1 {
+		t.Fatal("expected 1 object")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"runtime"
 	"sort"
@@ -13,7 +14,9 @@ import (
 	"time"
 
 	"github.com/tidwall/resp"
+	"github.com/tidwall/rhh"
 	"github.com/tidwall/tile38/core"
+	"github.com/tidwall/tile38/internal/collection"
 )
 
 var memStats runtime.MemStats
@@ -50,6 +53,12 @@ func (s *Server) cmdStats(msg *Message) (res resp.Value, err error) {
 	if len(vs) == 0 {
 		return NOMessage, errInvalidNumberOfArguments
 	}
+	// EXT is the last argument, after at least one key
+	var ext bool
+	if len(vs) > 1 && strings.ToLower(vs[len(vs)-1]) == "ext" {
+		ext = true
+		vs = vs[:len(vs)-1]
+	}
 	var vals []resp.Value
 	var key string
 	var ok bool
@@ -65,6 +74,16 @@ func (s *Server) cmdStats(msg *Message) (res resp.Value, err error) {
 			m["in_memory_size"] = col.TotalWeight()
 			m["num_objects"] = col.Count()
 			m["num_strings"] = col.StringCount()
+			if labels := s.labels[key]; len(labels) > 0 {
+				m["labels"] = labels
+			}
+			if lim := s.limits[key]; lim != nil {
+				m["num_limit_rejected"] = lim.rejected.get()
+			}
+			usageStats(m, col.Usage())
+			if ext {
+				s.extCollectionStats(m, key, col)
+			}
 			switch msg.OutputType {
 			case JSON:
 				ms = append(ms, m)
@@ -94,6 +113,50 @@ func (s *Server) cmdStats(msg *Message) (res resp.Value, err error) {
 	return res, nil
 }
 
+// extCollectionStats adds the details of STATS key EXT, which are the
+// objects by their kind and by their number of points, the shape of the
+// spatial index, the expiring objects and fields, and the bounds. It visits every object of the
+// collection.
+func (s *Server) extCollectionStats(m map[string]interface{}, key string,
+	col *collection.Collection,
+) {
+	stats := col.Stats()
+	m["num_point_objects"] = stats.Points
+	m["num_geometry_objects"] = stats.Geometries
+	m["num_fields"] = len(col.FieldArr())
+	m["num_field_indexes"] = len(col.Indexes())
+	m["index_depth"] = stats.IndexDepth
+	m["index_nodes"] = stats.IndexNodes
+	m["index_fill"] = math.Round(stats.IndexFill*100) / 100
+	m["index_rebuilding"] = col.Rebuilding()
+	// the geometries by their number of points, such as points_le_100 for
+	// the objects with 11 to 100 points
+	for i, n := range stats.Complexity {
+		if i < len(collection.ComplexityBounds) {
+			m["points_le_"+strconv.Itoa(collection.ComplexityBounds[i])] = n
+		} else {
+			m["points_le_inf"] = n
+		}
+	}
+	if stats.MaxPoints > 0 {
+		m["max_object_points"] = stats.MaxPoints
+		m["max_object_points_id"] = stats.MaxPointsID
+	}
+	var expiring, expiringFields int
+	if idm, ok := s.expires.Get(key); ok {
+		expiring = idm.(*rhh.Map).Len()
+	}
+	for _, fields := range s.fexpires[key] {
+		expiringFields += len(fields)
+	}
+	m["num_expiring_objects"] = expiring
+	m["num_expiring_fields"] = expiringFields
+	if stats.Points+stats.Geometries > 0 {
+		minX, minY, maxX, maxY := col.Bounds()
+		m["bounds"] = []float64{minX, minY, maxX, maxY}
+	}
+}
+
 func (s *Server) cmdServer(msg *Message) (res resp.Value, err error) {
 	start := time.Now()
 	m := make(map[string]interface{})
@@ -128,11 +191,22 @@ func (s *Server) cmdServer(msg *Message) (res resp.Value, err error) {
 // basicStats populates the passed map with basic system/go/tile38 statistics
 func (s *Server) basicStats(m map[string]interface{}) {
 	m["id"] = s.config.serverID()
+	m["repl_id"] = s.backlog.id
 	if s.config.followHost() != "" {
 		m["following"] = fmt.Sprintf("%s:%d", s.config.followHost(),
 			s.config.followPort())
 		m["caught_up"] = s.fcup
 		m["caught_up_once"] = s.fcuponce
+		if keys := s.config.followKeys(); len(keys) > 0 {
+			m["following_keys"] = keys
+		}
+	}
+	if s.hookOwner != "" {
+		m["follower_hooks"] = s.hookOwner
+	}
+	if s.config.peerHost() != "" {
+		m["peering"] = fmt.Sprintf("%s:%d", s.config.peerHost(),
+			s.config.peerPort())
 	}
 	m["http_transport"] = s.http
 	m["pid"] = os.Getpid()
@@ -269,9 +343,13 @@ func (s *Server) extStats(m map[string]interface{}) {
 	m["tile38_connected_clients"] = len(s.conns)
 	s.connsmu.RUnlock()
 	// Whether or not a cluster is enabled
-	m["tile38_cluster_enabled"] = false
+	m["tile38_cluster_enabled"] = s.clusterEnabled()
 	// Whether or not the Tile38 AOF is enabled
-	m["tile38_aof_enabled"] = core.AppendOnly
+	m["tile38_aof_enabled"] = s.aof != nil
+	// The persistence engine
+	m["tile38_persist_engine"] = s.persistEngine()
+	// Whether or not the AOF and snapshots are encrypted
+	m["tile38_aof_encrypted"] = s.aead != nil
 	// Whether or not an AOF shrink is currently in progress
 	m["tile38_aof_rewrite_in_progress"] = s.shrinking
 	// Length of time the last AOF shrink took
@@ -285,6 +363,14 @@ func (s *Server) extStats(m map[string]interface{}) {
 	}
 	// Total size of the AOF in bytes
 	m["tile38_aof_size"] = s.aofsz
+	// Whether or not a snapshot save is currently in progress
+	m["tile38_snapshot_in_progress"] = s.saving
+	// Unix time of the last successful snapshot save
+	m["tile38_snapshot_last_save_time"] = lastSaveTime(s.lastSave)
+	// Whether or not a backup is currently in progress
+	m["tile38_backup_in_progress"] = s.backingUp
+	// Unix time of the last successful backup
+	m["tile38_backup_last_time"] = lastSaveTime(s.lastBackup)
 	// Whether or no the HTTP transport is being served
 	m["tile38_http_transport"] = s.http
 	// Number of connections accepted by the server
@@ -295,8 +381,37 @@ func (s *Server) extStats(m map[string]interface{}) {
 	m["tile38_total_messages_sent"] = s.statsTotalMsgsSent.get()
 	// Number of key expiration events
 	m["tile38_expired_keys"] = s.statsExpired.get()
+	// Number of sweeps of the expired keys, and their time in seconds
+	m["tile38_expire_sweeps"] = s.statsExpireSweeps.get()
+	m["tile38_expire_sweep_seconds"] = float64(s.statsExpireTime.get()) / 1e6
+	m["tile38_expire_sweep_max_seconds"] = float64(s.statsExpireMax.get()) / 1e6
+	// Number of sweeps held back by the expire-max-rate
+	m["tile38_expire_sweeps_limited"] = s.statsExpireLimited.get()
+	// Number of fence events of the hooks and the live fences
+	m["tile38_fence_events"] = s.statsFenceEvents.get()
+	// Number of fence events dropped by the event rate limit
+	m["tile38_dropped_events"] = s.statsDroppedEvents.get()
+	// Number of writes rejected by a quota
+	m["tile38_quota_rejected_writes"] = s.statsQuotaRejected.get()
+	// Number of failed deliveries of hook messages
+	m["tile38_hook_errors"] = s.statsHookErrors.get()
+	// Number of hook errors not sent to the hookerrorsendpoint
+	m["tile38_hook_errors_dropped"] = s.statsHookErrDrops.get()
+	// Number of change events sent to the cdc endpoint
+	m["tile38_cdc_events_sent"] = s.statsCDCSent.get()
 	// Number of connected slaves
 	m["tile38_connected_slaves"] = len(s.aofconnM)
+	// Replication lag, of the follower itself or of the follower that is
+	// furthest behind
+	lag := s.replMaxLag()
+	// The aof offset of the leader or follower
+	m["tile38_repl_offset"] = lag.offset
+	// Bytes of the leader's aof not yet received
+	m["tile38_repl_lag_bytes"] = lag.lagBytes
+	// Age of the oldest write not yet received
+	m["tile38_repl_seconds_behind"] = seconds(lag.behind)
+	// Seconds since the last acknowledgement
+	m["tile38_repl_last_ack_seconds"] = seconds(lag.lastAck)
 
 	points := 0
 	objects := 0
@@ -319,6 +434,16 @@ func (s *Server) extStats(m map[string]interface{}) {
 	m["tile38_num_collections"] = s.cols.Len()
 	// Number of hooks in the database
 	m["tile38_num_hooks"] = len(s.hooks)
+	// Number of collections with each label name
+	labels := make(map[string]int)
+	for _, names := range s.labels {
+		for name := range names {
+			labels[name]++
+		}
+	}
+	m["tile38_labels"] = labels
+	// Number of keys with labels
+	m["tile38_num_labeled_keys"] = len(s.labels)
 
 	avgsz := 0
 	if points != 0 {
@@ -359,8 +484,18 @@ func boolInt(t bool) int {
 	}
 	return 0
 }
+func lastSaveTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
 func (s *Server) writeInfoPersistence(w *bytes.Buffer) {
-	fmt.Fprintf(w, "aof_enabled:%d\r\n", boolInt(core.AppendOnly))
+	fmt.Fprintf(w, "aof_enabled:%d\r\n", boolInt(s.aof != nil))
+	fmt.Fprintf(w, "persist_engine:%s\r\n", s.persistEngine())
+	fmt.Fprintf(w, "aof_encrypted:%d\r\n", boolInt(s.aead != nil))
+	fmt.Fprintf(w, "aof_fsync:%s\r\n", s.config.appendFsync())
 	fmt.Fprintf(w, "aof_rewrite_in_progress:%d\r\n", boolInt(s.shrinking))                          // Flag indicating a AOF rewrite operation is on-going
 	fmt.Fprintf(w, "aof_last_rewrite_time_sec:%d\r\n", s.lastShrinkDuration.get()/int(time.Second)) // Duration of the last AOF rewrite operation in seconds
 
@@ -370,6 +505,20 @@ func (s *Server) writeInfoPersistence(w *bytes.Buffer) {
 	} else {
 		fmt.Fprintf(w, "aof_current_rewrite_time_sec:%d\r\n", time.Since(currentShrinkStart)/time.Second) // Duration of the on-going AOF rewrite operation if any
 	}
+	saveStatus := "ok"
+	if s.lastSaveErr {
+		saveStatus = "err"
+	}
+	fmt.Fprintf(w, "rdb_bgsave_in_progress:%d\r\n", boolInt(s.saving))    // Flag indicating a snapshot save is on-going
+	fmt.Fprintf(w, "rdb_last_save_time:%d\r\n", lastSaveTime(s.lastSave)) // Unix time of the last successful snapshot save
+	fmt.Fprintf(w, "rdb_last_bgsave_status:%s\r\n", saveStatus)           // Status of the last snapshot save
+	backupStatus := "ok"
+	if s.lastBackupErr {
+		backupStatus = "err"
+	}
+	fmt.Fprintf(w, "backup_in_progress:%d\r\n", boolInt(s.backingUp))     // Flag indicating a backup is on-going
+	fmt.Fprintf(w, "backup_last_time:%d\r\n", lastSaveTime(s.lastBackup)) // Unix time of the last successful backup
+	fmt.Fprintf(w, "backup_last_status:%s\r\n", backupStatus)             // Status of the last backup
 }
 
 func (s *Server) writeInfoStats(w *bytes.Buffer) {
@@ -377,32 +526,78 @@ func (s *Server) writeInfoStats(w *bytes.Buffer) {
 	fmt.Fprintf(w, "total_commands_processed:%d\r\n", s.statsTotalCommands.get()) // Total number of commands processed by the server
 	fmt.Fprintf(w, "total_messages_sent:%d\r\n", s.statsTotalMsgsSent.get())      // Total number of commands processed by the server
 	fmt.Fprintf(w, "expired_keys:%d\r\n", s.statsExpired.get())                   // Total number of key expiration events
+	fmt.Fprintf(w, "expire_sweeps:%d\r\n", s.statsExpireSweeps.get())             // Total number of sweeps of the expired keys
+	fmt.Fprintf(w, "expire_sweep_usec:%d\r\n", s.statsExpireTime.get())           // Total time of the sweeps in microseconds
+	fmt.Fprintf(w, "expire_sweep_max_usec:%d\r\n", s.statsExpireMax.get())        // Time of the longest sweep in microseconds
+	fmt.Fprintf(w, "expire_sweeps_limited:%d\r\n", s.statsExpireLimited.get())    // Total number of sweeps held back by the expire-max-rate
+	fmt.Fprintf(w, "fence_events:%d\r\n", s.statsFenceEvents.get())               // Total number of fence events of the hooks and the live fences
+	fmt.Fprintf(w, "dropped_events:%d\r\n", s.statsDroppedEvents.get())           // Total number of fence events over the rate limit
+	fmt.Fprintf(w, "quota_rejected_writes:%d\r\n", s.statsQuotaRejected.get())    // Total number of writes rejected by a quota
+	fmt.Fprintf(w, "cdc_events_sent:%d\r\n", s.statsCDCSent.get())                // Total number of change events sent to the cdc endpoint
+	fmt.Fprintf(w, "hook_errors:%d\r\n", s.statsHookErrors.get())                 // Total number of failed deliveries of hook messages
+	fmt.Fprintf(w, "hook_errors_dropped:%d\r\n", s.statsHookErrDrops.get())       // Total number of hook errors not sent to the hookerrorsendpoint
+	fmt.Fprintf(w, "sync_partial_ok:%d\r\n", s.backlog.partialOK)                 // Number of followers that resumed from the backlog
+	fmt.Fprintf(w, "sync_partial_err:%d\r\n", s.backlog.partialErr)               // Number of followers that could not resume from the backlog
 }
 
 // writeInfoReplication writes all replication data to the 'info' response
 func (s *Server) writeInfoReplication(w *bytes.Buffer) {
 	if s.config.followHost() != "" {
+		lag, linked := s.repl.leaderLag()
+		status := "down"
+		if linked {
+			status = "up"
+		}
 		fmt.Fprintf(w, "role:slave\r\n")
 		fmt.Fprintf(w, "master_host:%s\r\n", s.config.followHost())
 		fmt.Fprintf(w, "master_port:%v\r\n", s.config.followPort())
+		fmt.Fprintf(w, "master_link_status:%s\r\n", status)                           // Status of the link to the leader
+		fmt.Fprintf(w, "master_last_io_seconds_ago:%d\r\n", wholeSeconds(lag.lastIO)) // Seconds since a write was received from the leader
+		fmt.Fprintf(w, "master_repl_offset:%d\r\n", lag.offset+lag.lagBytes)          // The leader's aof size
+		fmt.Fprintf(w, "slave_repl_offset:%d\r\n", lag.offset)                        // The offset of the follower
+		fmt.Fprintf(w, "slave_lag_bytes:%d\r\n", lag.lagBytes)                        // Bytes of the leader's aof not yet received
+		fmt.Fprintf(w, "slave_seconds_behind:%s\r\n", appendSeconds(nil, lag.behind)) // Age of the oldest write not yet received
 	} else {
 		fmt.Fprintf(w, "role:master\r\n")
+		fmt.Fprintf(w, "master_repl_offset:%d\r\n", s.aofsz) // The aof size
+		lags := make(map[string]replLag)
+		for _, lag := range s.repl.followerLags(int64(s.aofsz)) {
+			lags[lag.addr] = lag
+		}
 		var i int
 		s.connsmu.RLock()
 		for _, cc := range s.conns {
 			if cc.replPort != 0 {
-				fmt.Fprintf(w, "slave%v:ip=%s,port=%v,state=online\r\n", i,
-					strings.Split(cc.remoteAddr, ":")[0], cc.replPort)
+				lag := lags[cc.remoteAddr]
+				fmt.Fprintf(w, "slave%v:ip=%s,port=%v,state=online,"+
+					"offset=%d,lag=%d,lag_bytes=%d,seconds_behind=%s\r\n", i,
+					strings.Split(cc.remoteAddr, ":")[0], cc.replPort,
+					lag.offset, wholeSeconds(lag.lastAck), lag.lagBytes,
+					appendSeconds(nil, lag.behind))
 				i++
 			}
 		}
 		s.connsmu.RUnlock()
 	}
-	fmt.Fprintf(w, "connected_slaves:%d\r\n", len(s.aofconnM)) // Number of connected slaves
+	fmt.Fprintf(w, "connected_slaves:%d\r\n", len(s.aofconnM))                            // Number of connected slaves
+	fmt.Fprintf(w, "master_replid:%s\r\n", s.backlog.id)                                  // Id of the aof history
+	fmt.Fprintf(w, "repl_backlog_active:%d\r\n", boolInt(s.config.replBacklogSize() > 0)) // Flag indicating the backlog is enabled
+	fmt.Fprintf(w, "repl_backlog_size:%d\r\n", s.config.replBacklogSize())                // Size of the backlog
+	fmt.Fprintf(w, "repl_backlog_first_byte_offset:%d\r\n", s.backlog.start)              // Aof offset of the first byte in the backlog
+	fmt.Fprintf(w, "repl_backlog_histlen:%d\r\n", len(s.backlog.buf))                     // Bytes in the backlog
+	if s.raftEnabled() {
+		term, _ := s.config.raftTerm()
+		s.raft.mu.Lock()
+		role, leader := s.raft.role, s.raft.leader
+		s.raft.mu.Unlock()
+		fmt.Fprintf(w, "raft_role:%s\r\n", role)     // Role in the raft group
+		fmt.Fprintf(w, "raft_term:%d\r\n", term)     // Current raft term
+		fmt.Fprintf(w, "raft_leader:%s\r\n", leader) // Address of the raft leader
+	}
 }
 
 func (s *Server) writeInfoCluster(w *bytes.Buffer) {
-	fmt.Fprintf(w, "cluster_enabled:0\r\n")
+	fmt.Fprintf(w, "cluster_enabled:%d\r\n", boolInt(s.clusterEnabled()))
 }
 
 func (s *Server) cmdInfo(msg *Message) (res resp.Value, err error) {
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/tile38/internal/log"
+)
+
+const (
+	// statsdInterval is how often the metrics are sent to statsd, which is
+	// the flush interval of the Datadog agent.
+	statsdInterval = 10 * time.Second
+	// statsdPacketSize is the max size of a datagram, which is what the
+	// Datadog agent reads by default.
+	statsdPacketSize = 1432
+)
+
+// statsdCounts are the counters that are sent to statsd as the change since
+// the last send.
+type statsdCounts struct {
+	commands    map[string][2]uint64 // calls and errors by command
+	hooks       map[string][2]uint64 // messages sent and send errors by hook
+	fenceEvents uint64
+	dropped     uint64
+	cdcSent     uint64
+	expired     uint64
+}
+
+// statsdGauges are the values that are sent to statsd as they are.
+type statsdGauges struct {
+	clients int
+	objects int
+	hooks   int
+}
+
+// watchStatsD sends the metrics to the statsd address, with the tags of
+// dogstatsd, while there is an address.
+func (s *Server) watchStatsD() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	var conn net.Conn
+	var connAddr string
+	var prev statsdCounts
+	var last time.Time
+	for range t.C {
+		if s.stopServer.on() {
+			break
+		}
+		addr, tags := s.config.statsd()
+		if addr != connAddr {
+			if conn != nil {
+				conn.Close()
+				conn = nil
+			}
+			// the first send is the change since now
+			connAddr = addr
+			prev, last = s.statsdCounts(), time.Now()
+		}
+		if addr == "" || time.Since(last) < statsdInterval {
+			continue
+		}
+		if conn == nil {
+			var err error
+			if conn, err = net.Dial("udp", addr); err != nil {
+				// try again at the next send
+				log.Errorf("statsd: %v", err)
+				last = time.Now()
+				continue
+			}
+		}
+		cur, gauges := s.statsdCounts(), s.statsdGauges()
+		for _, packet := range appendStatsD(nil, &prev, &cur, gauges, tags) {
+			if _, err := conn.Write(packet); err != nil {
+				log.Debugf("statsd: %v", err)
+			}
+		}
+		prev, last = cur, time.Now()
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func (s *Server) statsdCounts() statsdCounts {
+	c := statsdCounts{
+		commands:    make(map[string][2]uint64),
+		hooks:       make(map[string][2]uint64),
+		fenceEvents: uint64(s.statsFenceEvents.get()),
+		dropped:     uint64(s.statsDroppedEvents.get()),
+		cdcSent:     uint64(s.statsCDCSent.get()),
+		expired:     uint64(s.statsExpired.get()),
+	}
+	s.metrics.mu.Lock()
+	for command, m := range s.metrics.commands {
+		c.commands[command] = [2]uint64{m.calls, m.errors}
+	}
+	s.metrics.mu.Unlock()
+	s.mu.RLock()
+	for name, hook := range s.hooks {
+		if !hook.channel {
+			c.hooks[name] = [2]uint64{
+				uint64(hook.delivery.sent.get()),
+				uint64(hook.delivery.failed.get()),
+			}
+		}
+	}
+	s.mu.RUnlock()
+	return c
+}
+
+func (s *Server) statsdGauges() statsdGauges {
+	var g statsdGauges
+	s.connsmu.RLock()
+	g.clients = len(s.conns)
+	s.connsmu.RUnlock()
+	s.mu.RLock()
+	s.cols.Ascend(nil, func(v interface{}) bool {
+		g.objects += v.(*collectionKeyContainer).col.Count()
+		return true
+	})
+	g.hooks = len(s.hooks)
+	s.mu.RUnlock()
+	return g
+}
+
+// statsdWriter writes the lines of statsd, in datagrams that are no larger
+// than statsdPacketSize.
+type statsdWriter struct {
+	packets [][]byte
+	tags    string // the tags of all lines, which start with a comma
+}
+
+// line writes a metric, which is a counter "c" or a gauge "g", with a tag.
+func (w *statsdWriter) line(name string, value uint64, typ string,
+	tag string,
+) {
+	var line []byte
+	line = append(line, "tile38."+name+":"...)
+	line = strconv.AppendUint(line, value, 10)
+	line = append(line, "|"+typ...)
+	if tag != "" || w.tags != "" {
+		line = append(line, "|#"...)
+		if tag != "" {
+			line = append(line, tag+w.tags...)
+		} else {
+			line = append(line, w.tags[1:]...)
+		}
+	}
+	n := len(w.packets)
+	if n > 0 && len(w.packets[n-1])+1+len(line) <= statsdPacketSize {
+		w.packets[n-1] = append(append(w.packets[n-1], '\n'), line...)
+	} else {
+		w.packets = append(w.packets, line)
+	}
+}
+
+// appendStatsD appends the datagrams of the change of the counters since
+// prev, and of the gauges. The counters that did not change are not sent.
+func appendStatsD(packets [][]byte, prev, cur *statsdCounts,
+	gauges statsdGauges, tags []string,
+) [][]byte {
+	w := statsdWriter{packets: packets}
+	for _, tag := range tags {
+		w.tags += "," + tag
+	}
+	count := func(name string, prev, cur uint64, tag string) {
+		if cur > prev {
+			w.line(name, cur-prev, "c", tag)
+		}
+	}
+	commands := make([]string, 0, len(cur.commands))
+	for command := range cur.commands {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	for _, command := range commands {
+		tag := "command:" + statsdTagValue(command)
+		count("commands", prev.commands[command][0], cur.commands[command][0], tag)
+		count("command.errors", prev.commands[command][1], cur.commands[command][1], tag)
+	}
+	hooks := make([]string, 0, len(cur.hooks))
+	for name := range cur.hooks {
+		hooks = append(hooks, name)
+	}
+	sort.Strings(hooks)
+	for _, name := range hooks {
+		tag := "hook:" + statsdTagValue(name)
+		count("hook.sent", prev.hooks[name][0], cur.hooks[name][0], tag)
+		count("hook.send_errors", prev.hooks[name][1], cur.hooks[name][1], tag)
+	}
+	count("fence.events", prev.fenceEvents, cur.fenceEvents, "")
+	count("fence.dropped_events", prev.dropped, cur.dropped, "")
+	count("cdc.sent", prev.cdcSent, cur.cdcSent, "")
+	count("expired_keys", prev.expired, cur.expired, "")
+	w.line("clients", uint64(gauges.clients), "g", "")
+	w.line("objects", uint64(gauges.objects), "g", "")
+	w.line("hooks", uint64(gauges.hooks), "g", "")
+	return w.packets
+}
+
+// statsdTagValue replaces the characters that end a tag of dogstatsd.
+func statsdTagValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
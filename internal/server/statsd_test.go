@@ -0,0 +1,65 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAppendStatsD(t *testing.T) {
+	prev := statsdCounts{
+		commands:    map[string][2]uint64{"set": {10, 1}, "get": {5, 0}},
+		hooks:       map[string][2]uint64{"warehouse": {3, 0}},
+		fenceEvents: 7,
+	}
+	cur := statsdCounts{
+		commands: map[string][2]uint64{
+			"set": {15, 3}, "get": {5, 0}, "nearby": {2, 0},
+		},
+		hooks: map[string][2]uint64{
+			"warehouse": {4, 2}, "my hook|x": {1, 0},
+		},
+		fenceEvents: 12,
+	}
+	gauges := statsdGauges{clients: 2, objects: 100, hooks: 2}
+	packets := appendStatsD(nil, &prev, &cur, gauges, []string{"env:test"})
+	if len(packets) != 1 {
+		t.Fatalf("expected 1 packet, got %d", len(packets))
+	}
+	expect := strings.Join([]string{
+		"tile38.commands:2|c|#command:nearby,env:test",
+		"tile38.commands:5|c|#command:set,env:test",
+		"tile38.command.errors:2|c|#command:set,env:test",
+		"tile38.hook.sent:1|c|#hook:my_hook_x,env:test",
+		"tile38.hook.sent:1|c|#hook:warehouse,env:test",
+		"tile38.hook.send_errors:2|c|#hook:warehouse,env:test",
+		"tile38.fence.events:5|c|#env:test",
+		"tile38.clients:2|g|#env:test",
+		"tile38.objects:100|g|#env:test",
+		"tile38.hooks:2|g|#env:test",
+	}, "\n")
+	if string(packets[0]) != expect {
+		t.Fatalf("expected\n%s\ngot\n%s", expect, packets[0])
+	}
+
+	// no tags, and the lines are split into packets
+	cur.commands = make(map[string][2]uint64)
+	for i := 0; i < 100; i++ {
+		cur.commands["command"+strings.Repeat("x", i)] = [2]uint64{1, 0}
+	}
+	packets = appendStatsD(nil, &statsdCounts{}, &cur, gauges, nil)
+	var lines int
+	for _, packet := range packets {
+		if len(packet) > statsdPacketSize {
+			t.Fatalf("expected at most %d bytes, got %d", statsdPacketSize,
+				len(packet))
+		}
+		lines += strings.Count(string(packet), "\n") + 1
+	}
+	if len(packets) < 2 || lines != 100+3+1+3 {
+		t.Fatalf("expected the lines in packets, got %d lines in %d packets",
+			lines, len(packets))
+	}
+	if !strings.HasSuffix(string(packets[len(packets)-1]), "tile38.hooks:2|g") {
+		t.Fatalf("expected a gauge without tags, got %s", packets[len(packets)-1])
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// streamChunkSize is the size of the output of a STREAM search that is
+// written to the client at once.
+const streamChunkSize = 64 * 1024
+
+// streamWriteTimeout is how long a client of a STREAM search may take to
+// read a chunk, which keeps a slow client from holding the read lock of
+// the server.
+const streamWriteTimeout = 10 * time.Second
+
+var errStreamUnsupported = errors.New(
+	"STREAM requires the json output of an HTTP or WebSocket connection")
+
+// replyStream writes the JSON reply of a search to the client in chunks as
+// the objects are found, so that a search with millions of objects does not
+// hold all of them in memory. The reply is an HTTP response with chunked
+// encoding, or a fragmented WebSocket message. The output of a stream is
+// not limited by the client-output-buffer-limit.
+type replyStream struct {
+	client   *Client
+	conn     net.Conn
+	connType Type
+	started  bool  // the first chunk was written
+	err      error // the error of a write, which ends the stream
+}
+
+// newReplyStream returns the stream of a message, or nil when the reply of
+// the message cannot be streamed.
+func newReplyStream(client *Client, msg *Message) *replyStream {
+	if msg.OutputType != JSON {
+		return nil
+	}
+	switch msg.ConnType {
+	case HTTP:
+		if msg.ContentType != "" {
+			return nil
+		}
+	case WebSocket:
+		if msg.ws.msgpack || msg.ws.deflate {
+			return nil
+		}
+	default:
+		return nil
+	}
+	client.mu.Lock()
+	conn, ok := client.conn.(net.Conn)
+	client.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return &replyStream{client: client, conn: conn, connType: msg.ConnType}
+}
+
+// active returns true when a part of the reply was written.
+func (rs *replyStream) active() bool {
+	return rs != nil && rs.started
+}
+
+// write writes a chunk of the reply.
+func (rs *replyStream) write(data []byte, final bool) error {
+	if rs.err != nil {
+		return rs.err
+	}
+	var out []byte
+	if !rs.started {
+		// the output of the client that is not written yet comes first
+		out = append(out, rs.client.out...)
+		rs.client.out = nil
+		if rs.connType == HTTP {
+			out = append(out, "HTTP/1.1 200 OK\r\n"+
+				"Connection: close\r\n"+
+				"Transfer-Encoding: chunked\r\n"+
+				"Content-Type: application/json; charset=utf-8\r\n"+
+				"\r\n"...)
+		}
+	}
+	switch rs.connType {
+	case HTTP:
+		if len(data) > 0 {
+			out = append(out, fmt.Sprintf("%x\r\n", len(data))...)
+			out = append(out, data...)
+			out = append(out, "\r\n"...)
+		}
+		if final {
+			out = append(out, "0\r\n\r\n"...)
+		}
+	case WebSocket:
+		// the first frame is text, and the ones that follow continue it
+		var head byte
+		if !rs.started {
+			head = 0x01
+		}
+		if final {
+			head |= 0x80
+		}
+		out = appendWebSocketFrameHead(out, head, len(data))
+		out = append(out, data...)
+	}
+	rs.started = true
+	rs.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	_, rs.err = rs.conn.Write(out)
+	rs.conn.SetWriteDeadline(time.Time{})
+	return rs.err
+}
+
+// finish writes the last chunk of the reply.
+func (rs *replyStream) finish(tail string) error {
+	if rs.connType == HTTP {
+		tail += "\r\n"
+	}
+	return rs.write([]byte(tail), true)
+}
+
+// abort ends a stream that could not be finished. The client cannot be told
+// why, so the connection is closed before the end of the reply.
+func (rs *replyStream) abort(errMsg string) error {
+	if rs.err != nil {
+		return fmt.Errorf("stream: %v", rs.err)
+	}
+	return errors.New("stream: " + errMsg)
+}
+
+// appendWebSocketFrameHead appends the head of a frame of a length.
+func appendWebSocketFrameHead(dst []byte, head byte, n int) []byte {
+	switch {
+	case n <= 125:
+		return append(dst, head, byte(n))
+	case n <= 0xFFFF:
+		dst = append(dst, head, 126, 0, 0)
+		binary.BigEndian.PutUint16(dst[len(dst)-2:], uint16(n))
+		return dst
+	default:
+		dst = append(dst, head, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(dst[len(dst)-8:], uint64(n))
+		return dst
+	}
+}
+
+// setStream streams the JSON output of the scan writer when the STREAM
+// option of a search is set.
+func (sw *scanWriter) setStream(stream bool) error {
+	if !stream {
+		return nil
+	}
+	if sw.msg.stream == nil {
+		return errStreamUnsupported
+	}
+	sw.stream = sw.msg.stream
+	return nil
+}
+
+// flushStream writes the output of a stream when it's over the chunk size,
+// and returns false when the stream failed.
+func (sw *scanWriter) flushStream() bool {
+	if sw.stream == nil || sw.wr.Len() < streamChunkSize {
+		return true
+	}
+	if err := sw.stream.write(sw.wr.Bytes(), false); err != nil {
+		return false
+	}
+	sw.wr.Reset()
+	return true
+}
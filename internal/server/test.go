@@ -9,7 +9,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/mmcloughlin/geohash"
 	"github.com/tidwall/geojson"
 	"github.com/tidwall/geojson/geometry"
 	"github.com/tidwall/resp"
@@ -136,17 +135,24 @@ func (s *Server) parseArea(ovs []string, doClip bool) (vs []string, o geojson.Ob
 			Min: geometry.Point{X: minLon, Y: minLat},
 			Max: geometry.Point{X: maxLon, Y: maxLat},
 		})
-	case "hash":
+	case "hash", "geohash":
 		var hash string
 		if vs, hash, ok = tokenval(vs); !ok || hash == "" {
 			err = errInvalidNumberOfArguments
 			return
 		}
-		box := geohash.BoundingBox(hash)
-		o = geojson.NewRect(geometry.Rect{
-			Min: geometry.Point{X: box.MinLng, Y: box.MinLat},
-			Max: geometry.Point{X: box.MaxLng, Y: box.MaxLat},
-		})
+		if o, err = geohashArea(hash); err != nil {
+			return
+		}
+	case "hex":
+		var hex string
+		if vs, hex, ok = tokenval(vs); !ok || hex == "" {
+			err = errInvalidNumberOfArguments
+			return
+		}
+		if o, err = h3Area(hex); err != nil {
+			return
+		}
 	case "quadkey":
 		var key string
 		if vs, key, ok = tokenval(vs); !ok || key == "" {
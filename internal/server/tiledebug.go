@@ -0,0 +1,321 @@
+package server
+
+import (
+	"bytes"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/tile38/internal/field"
+)
+
+// tileFormat is how the objects of a TILE are returned, which is set with
+// the FORMAT keyword.
+type tileFormat uint8
+
+const (
+	tileMVT tileFormat = iota
+	tileGeoJSON
+	tilePNG
+)
+
+// maxPNGTileSize is the largest EXTENT of a png tile, which is its width and
+// height in pixels.
+const maxPNGTileSize = 4096
+
+const defaultPNGTileSize = 256
+
+func parseTileFormat(s string) (tileFormat, bool) {
+	switch s {
+	case "mvt":
+		return tileMVT, true
+	case "geojson":
+		return tileGeoJSON, true
+	case "png":
+		return tilePNG, true
+	}
+	return 0, false
+}
+
+// tileObject is an object of a tile, clipped to the tile, with the
+// properties of its features.
+type tileObject struct {
+	id    string
+	obj   geojson.Object
+	props []tileProp
+}
+
+type tileProp struct {
+	name  string
+	value field.Value
+}
+
+// httpTileArgs returns the TILE command for a request to the debug tile
+// server, which is a GET of /tiles/key/z/x/y.png, .geojson, or .mvt, and the
+// content type of the tile. The fields of the tile may be listed with the
+// fields query parameter. A browser map can show the tiles as an XYZ layer,
+// such as http://localhost:9851/tiles/fleet/{z}/{x}/{y}.png
+func httpTileArgs(path string) (args []string, contentType string, ok bool) {
+	var query string
+	if i := strings.IndexByte(path, '?'); i != -1 {
+		path, query = path[:i], path[i+1:]
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) != 5 || parts[0] != "tiles" || parts[1] == "" {
+		return nil, "", false
+	}
+	i := strings.LastIndexByte(parts[4], '.')
+	if i == -1 {
+		return nil, "", false
+	}
+	y, format := parts[4][:i], strings.ToLower(parts[4][i+1:])
+	switch format {
+	case "png":
+		contentType = "image/png"
+	case "geojson":
+		contentType = "application/geo+json"
+	case "mvt":
+		contentType = "application/vnd.mapbox-vector-tile"
+	default:
+		return nil, "", false
+	}
+	args = []string{"TILE", parts[1], parts[2], parts[3], y, "FORMAT", format}
+	if q, err := url.ParseQuery(query); err == nil && q.Get("fields") != "" {
+		args = append(args, "FIELDS", q.Get("fields"))
+	}
+	return args, contentType, true
+}
+
+// geoJSONTile returns the objects of a tile as a FeatureCollection, with a
+// feature for each geometry of the objects.
+func geoJSONTile(objs []tileObject) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"type":"FeatureCollection","features":[`)
+	var n int
+	for _, obj := range objs {
+		var props string
+		props = `{"id":` + jsonString(obj.id)
+		for _, prop := range obj.props {
+			props += `,` + jsonString(prop.name) + `:` + prop.value.JSON()
+		}
+		props += `}`
+		tileGeometries(obj.obj, func(g geojson.Object) {
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"type":"Feature","geometry":`)
+			buf.WriteString(g.JSON())
+			buf.WriteString(`,"properties":` + props + `}`)
+			n++
+		})
+	}
+	buf.WriteString(`]}`)
+	return buf.Bytes()
+}
+
+// tileGeometries calls iter with the geometries of an object, which is the
+// object itself unless it's a feature or a feature collection.
+func tileGeometries(o geojson.Object, iter func(g geojson.Object)) {
+	switch o := o.(type) {
+	case *geojson.Feature:
+		tileGeometries(o.Base(), iter)
+	case *geojson.FeatureCollection:
+		for _, child := range o.Children() {
+			tileGeometries(child, iter)
+		}
+	default:
+		iter(o)
+	}
+}
+
+// png returns a picture of the objects of a tile, where the width and
+// height of the picture in pixels is the extent of the tile. The points are
+// drawn as dots, and the lines and polygons are drawn in the color of the
+// id of their object, with the polygons filled.
+func (t *mvtTile) png(objs []tileObject) []byte {
+	size := int(t.extent)
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for _, obj := range objs {
+		t.draw(img, obj.obj, tileColor(obj.id))
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// tileColor returns a color for an id, so that each object is drawn in the
+// same color on every tile.
+func tileColor(id string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	sum := h.Sum32()
+	return color.RGBA{
+		R: 32 + uint8(sum)%192,
+		G: 32 + uint8(sum>>8)%192,
+		B: 32 + uint8(sum>>16)%192,
+		A: 255,
+	}
+}
+
+func (t *mvtTile) draw(img *image.RGBA, o geojson.Object, c color.RGBA) {
+	switch o := o.(type) {
+	case *geojson.Feature:
+		t.draw(img, o.Base(), c)
+	case geojson.Collection:
+		for _, child := range o.Children() {
+			t.draw(img, child, c)
+		}
+	case *geojson.LineString:
+		line := o.Base()
+		if line == nil {
+			return
+		}
+		points := make([]geometry.Point, line.NumPoints())
+		for i := range points {
+			points[i] = line.PointAt(i)
+		}
+		t.stroke(img, t.pixels(points), c)
+	case *geojson.Polygon:
+		poly := o.Base()
+		if poly == nil || poly.Exterior == nil {
+			return
+		}
+		rings := [][][2]float64{t.pixels(ringPoints(poly.Exterior))}
+		for _, hole := range poly.Holes {
+			rings = append(rings, t.pixels(ringPoints(hole)))
+		}
+		t.fill(img, rings, c)
+	case *geojson.Rect:
+		r := o.Base()
+		t.fill(img, [][][2]float64{t.pixels([]geometry.Point{
+			r.Min, {X: r.Max.X, Y: r.Min.Y}, r.Max, {X: r.Min.X, Y: r.Max.Y},
+			r.Min,
+		})}, c)
+	default:
+		p := t.pixels([]geometry.Point{o.Center()})[0]
+		for y := -3; y <= 3; y++ {
+			for x := -3; x <= 3; x++ {
+				if x*x+y*y <= 10 {
+					blendPixel(img, int(p[0])+x, int(p[1])+y, c)
+				}
+			}
+		}
+	}
+}
+
+// pixels returns the positions of the points on the picture of the tile.
+func (t *mvtTile) pixels(points []geometry.Point) [][2]float64 {
+	out := make([][2]float64, len(points))
+	for i, p := range points {
+		out[i][0], out[i][1] = t.project(p)
+	}
+	return out
+}
+
+// fill draws the inside of a polygon, with the even-odd rule, and then its
+// rings.
+func (t *mvtTile) fill(img *image.RGBA, rings [][][2]float64, c color.RGBA) {
+	fc := color.RGBA{
+		R: uint8(uint16(c.R) * 0x50 / 255),
+		G: uint8(uint16(c.G) * 0x50 / 255),
+		B: uint8(uint16(c.B) * 0x50 / 255),
+		A: 0x50,
+	}
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, ring := range rings {
+		for _, p := range ring {
+			minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+		}
+	}
+	bounds := img.Bounds()
+	y0 := int(math.Max(float64(bounds.Min.Y), math.Floor(minY)))
+	y1 := int(math.Min(float64(bounds.Max.Y-1), math.Ceil(maxY)))
+	var xs []float64
+	for y := y0; y <= y1; y++ {
+		// the crossings of the center of the row of pixels
+		cy := float64(y) + 0.5
+		xs = xs[:0]
+		for _, ring := range rings {
+			for i := range ring {
+				a, b := ring[(i+len(ring)-1)%len(ring)], ring[i]
+				if (a[1] <= cy) != (b[1] <= cy) {
+					xs = append(xs, a[0]+(cy-a[1])*(b[0]-a[0])/(b[1]-a[1]))
+				}
+			}
+		}
+		sort.Float64s(xs)
+		for i := 1; i < len(xs); i += 2 {
+			x0 := int(math.Max(float64(bounds.Min.X), math.Ceil(xs[i-1]-0.5)))
+			x1 := int(math.Min(float64(bounds.Max.X), math.Ceil(xs[i]-0.5)))
+			for x := x0; x < x1; x++ {
+				blendPixel(img, x, y, fc)
+			}
+		}
+	}
+	for _, ring := range rings {
+		if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+			ring = append(ring, ring[0])
+		}
+		t.stroke(img, ring, c)
+	}
+}
+
+// stroke draws the segments of a line.
+func (t *mvtTile) stroke(img *image.RGBA, points [][2]float64, c color.RGBA) {
+	for i := 1; i < len(points); i++ {
+		x0, y0 := int(math.Floor(points[i-1][0])), int(math.Floor(points[i-1][1]))
+		x1, y1 := int(math.Floor(points[i][0])), int(math.Floor(points[i][1]))
+		dx, dy := abs(x1-x0), -abs(y1-y0)
+		sx, sy := 1, 1
+		if x0 > x1 {
+			sx = -1
+		}
+		if y0 > y1 {
+			sy = -1
+		}
+		err := dx + dy
+		for {
+			blendPixel(img, x0, y0, c)
+			if x0 == x1 && y0 == y1 {
+				break
+			}
+			e2 := 2 * err
+			if e2 >= dy {
+				err += dy
+				x0 += sx
+			}
+			if e2 <= dx {
+				err += dx
+				y0 += sy
+			}
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// blendPixel draws a premultiplied color over a pixel.
+func blendPixel(img *image.RGBA, x, y int, c color.RGBA) {
+	if !(image.Point{x, y}).In(img.Rect) {
+		return
+	}
+	i := img.PixOffset(x, y)
+	p := img.Pix[i : i+4 : i+4]
+	a := 255 - uint32(c.A)
+	p[0] = uint8(uint32(c.R) + uint32(p[0])*a/255)
+	p[1] = uint8(uint32(c.G) + uint32(p[1])*a/255)
+	p[2] = uint8(uint32(c.B) + uint32(p[2])*a/255)
+	p[3] = uint8(uint32(c.A) + uint32(p[3])*a/255)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/tidwall/tile38/internal/deadline"
+)
+
+// errDisconnected is the error of a query that was canceled because its
+// client disconnected.
+var errDisconnected = errors.New("client disconnected")
+
+// isQueryCommand returns true for the searches that have a deadline, which
+// is the TIMEOUT of the search, or the query-timeout property.
+func isQueryCommand(command string) bool {
+	switch command {
+	case "nearby", "within", "intersects", "scan", "search":
+		return true
+	}
+	return false
+}
+
+// queryDeadline gives a deadline to a search, unless it already has one from
+// the TIMEOUT prefix. The deadline has no time limit when the query-timeout
+// property is zero, but it may be set by the TIMEOUT of the search, or be
+// canceled when the client disconnects.
+func (s *Server) queryDeadline(msg *Message) {
+	if msg.Deadline != nil || !isQueryCommand(msg.Command()) {
+		return
+	}
+	msg.Deadline = new(deadline.Deadline)
+	if timeout := s.config.queryTimeout(); timeout > 0 {
+		msg.Deadline.Set(time.Now().Add(timeout))
+	}
+}
+
+// setQueryTimeout sets the deadline of a search to its TIMEOUT, which is
+// counted from the start of the command. TIMEOUT 0 is no time limit.
+func setQueryTimeout(msg *Message, start time.Time, t searchScanBaseTokens) {
+	if !t.utimeout || msg.Deadline == nil {
+		return
+	}
+	if t.timeout == 0 {
+		msg.Deadline.Set(time.Time{})
+	} else {
+		msg.Deadline.Set(start.Add(t.timeout))
+	}
+}
+
+// watchDisconnect cancels the deadline of a query when its client
+// disconnects while the query runs. The input that is read in the meantime
+// is kept for the next read of the connection. The returned func stops the
+// watching, and must be called before the connection is read again.
+func (client *Client) watchDisconnect(dl *deadline.Deadline) (stop func()) {
+	client.mu.Lock()
+	conn, ok := client.conn.(net.Conn)
+	client.mu.Unlock()
+	if !ok {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var buf [4096]byte
+		for {
+			n, err := conn.Read(buf[:])
+			client.pending = append(client.pending, buf[:n]...)
+			if err != nil {
+				if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+					dl.Cancel()
+				}
+				return
+			}
+		}
+	}()
+	return func() {
+		// the read is interrupted by a deadline in the past
+		conn.SetReadDeadline(time.Now())
+		<-done
+		conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// readConn reads the input of a client, which is first the input that was
+// read by watchDisconnect.
+func (client *Client) readConn(conn io.Reader, packet []byte) (int, error) {
+	if len(client.pending) > 0 {
+		n := copy(packet, client.pending)
+		client.pending = client.pending[n:]
+		return n, nil
+	}
+	return conn.Read(packet)
+}
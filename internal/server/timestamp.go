@@ -0,0 +1,160 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTimestamp parses the time that an object was observed, which is a
+// RFC 3339 time or a number of seconds since the unix epoch, and returns it
+// in unix nanoseconds.
+func parseTimestamp(s string) (int64, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return int64(secs * float64(time.Second)), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, errInvalidArgument(s)
+	}
+	return t.UnixNano(), nil
+}
+
+// formatTimestamp returns a time in unix nanoseconds as a RFC 3339 time,
+// which is parsed back exactly with parseTimestamp.
+func formatTimestamp(ts int64) string {
+	return time.Unix(0, ts).UTC().Format(time.RFC3339Nano)
+}
+
+// parseSince parses the age of a SINCE, which is a duration such as "5m" or
+// "1h30m", or a number of seconds.
+func parseSince(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		secs, perr := strconv.ParseFloat(s, 64)
+		if perr != nil {
+			return 0, errInvalidArgument(s)
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d < 0 {
+		return 0, errInvalidArgument(s)
+	}
+	return d, nil
+}
+
+// hasTimestamp returns true when the options that follow the id of a SET
+// include a TIMESTAMP.
+func hasTimestamp(vs []string) bool {
+	for len(vs) > 0 {
+		n := 1
+		switch strings.ToLower(vs[0]) {
+		case "timestamp":
+			return true
+		case "field":
+			n = 3
+		case "ex", "version":
+			n = 2
+		case "if":
+			n = conditionArgs(vs)
+		case "nx", "xx":
+		default:
+			return false
+		}
+		if n > len(vs) {
+			return false
+		}
+		vs = vs[n:]
+	}
+	return false
+}
+
+// timestampArgs returns the arguments of a SET or PSET with a TIMESTAMP
+// added to each object that does not have one. Invalid arguments are
+// returned as is, for the command to fail.
+func timestampArgs(args []string, ts int64) []string {
+	if len(args) < 3 {
+		return args
+	}
+	stamp := []string{"timestamp", formatTimestamp(ts)}
+	switch strings.ToLower(args[0]) {
+	case "set":
+		if hasTimestamp(args[3:]) {
+			return args
+		}
+		nargs := make([]string, 0, len(args)+2)
+		nargs = append(nargs, args[:3]...)
+		nargs = append(nargs, stamp...)
+		return append(nargs, args[3:]...)
+	case "pset":
+		objs, err := psetObjects(args[2:])
+		if err != nil {
+			return args
+		}
+		nargs := make([]string, 0, len(args)+len(objs)*2)
+		nargs = append(nargs, args[:2]...)
+		for _, obj := range objs {
+			nargs = append(nargs, obj[0])
+			if !hasTimestamp(obj[1:]) {
+				nargs = append(nargs, stamp...)
+			}
+			nargs = append(nargs, obj[1:]...)
+		}
+		return nargs
+	}
+	return args
+}
+
+// timestampStamp adds the current time as the TIMESTAMP of a SET or PSET
+// that does not have one. This happens before the write is applied, so that
+// the time is kept in the aof, and is the same on the followers and peers.
+func (s *Server) timestampStamp(msg *Message) *Message {
+	switch msg.Command() {
+	case "set", "pset":
+		args := timestampArgs(msg.Args, time.Now().UnixNano())
+		if len(args) != len(msg.Args) {
+			nmsg := *msg
+			nmsg.Args = args
+			return &nmsg
+		}
+	}
+	return msg
+}
+
+// parseTimeRange parses the arguments of a SINCE age or a BETWEEN start end
+// that follow the keyword, and returns the range of times in unix
+// nanoseconds.
+func parseTimeRange(keyword string, vs []string) (
+	nvs []string, min, max int64, err error,
+) {
+	var ok bool
+	if lc(keyword, "since") {
+		var ssince string
+		if vs, ssince, ok = tokenval(vs); !ok || ssince == "" {
+			return nil, 0, 0, errInvalidNumberOfArguments
+		}
+		since, err := parseSince(ssince)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return vs, time.Now().Add(-since).UnixNano(), math.MaxInt64, nil
+	}
+	var smin, smax string
+	if vs, smin, ok = tokenval(vs); !ok || smin == "" {
+		return nil, 0, 0, errInvalidNumberOfArguments
+	}
+	if vs, smax, ok = tokenval(vs); !ok || smax == "" {
+		return nil, 0, 0, errInvalidNumberOfArguments
+	}
+	if min, err = parseTimestamp(smin); err != nil {
+		return nil, 0, 0, err
+	}
+	if max, err = parseTimestamp(smax); err != nil {
+		return nil, 0, 0, err
+	}
+	if min > max {
+		return nil, 0, 0, errInvalidArgument(smin)
+	}
+	return vs, min, max, nil
+}
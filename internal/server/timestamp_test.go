@@ -0,0 +1,36 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTimestampArgs(t *testing.T) {
+	ts, err := parseTimestamp("2020-01-01T00:00:00.5Z")
+	if err != nil || formatTimestamp(ts) != "2020-01-01T00:00:00.5Z" {
+		t.Fatalf("unexpected %v %v", ts, err)
+	}
+	if ts, err := parseTimestamp("1577836800.5"); err != nil ||
+		formatTimestamp(ts) != "2020-01-01T00:00:00.5Z" {
+		t.Fatalf("unexpected %v %v", ts, err)
+	}
+	for _, tc := range []struct{ args, expect string }{
+		{"set fleet a POINT 1 2", "set fleet a timestamp T POINT 1 2"},
+		{"SET fleet a FIELD speed 1 EX 10 NX POINT 1 2",
+			"SET fleet a timestamp T FIELD speed 1 EX 10 NX POINT 1 2"},
+		{"set fleet a FIELD speed 1 TIMESTAMP 1 POINT 1 2",
+			"set fleet a FIELD speed 1 TIMESTAMP 1 POINT 1 2"},
+		{"pset fleet a POINT 1 2 b TIMESTAMP 1 STRING x c FIELD speed 1 POINT 1 2 3",
+			"pset fleet a timestamp T POINT 1 2 b TIMESTAMP 1 STRING x " +
+				"c timestamp T FIELD speed 1 POINT 1 2 3"},
+		{"pset fleet a POINT 1", "pset fleet a POINT 1"},
+		{"set fleet", "set fleet"},
+		{"fset fleet a speed 1", "fset fleet a speed 1"},
+	} {
+		args := timestampArgs(strings.Fields(tc.args), ts)
+		expect := strings.Replace(tc.expect, " T ", " "+formatTimestamp(ts)+" ", -1)
+		if s := strings.Join(args, " "); s != expect {
+			t.Fatalf("expected '%s', got '%s'", expect, s)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tidwall/tile38/core"
+	"github.com/tidwall/tile38/internal/log"
+)
+
+// tlsReloadInterval is how often the files of the TLS port are checked for
+// changes.
+const tlsReloadInterval = time.Second
+
+// tlsCerts are the certificate and the CA of the TLS port, which are loaded
+// again when their files are modified, so that certificates can be rotated
+// without a restart.
+type tlsCerts struct {
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType
+
+	mu      sync.Mutex
+	checked time.Time   // the last check of the files
+	modTime []time.Time // the modification times of the loaded files
+	config  *tls.Config
+}
+
+// loadTLSConfig returns the config for the TLS port from the --tls-cert-file,
+// --tls-key-file, --tls-ca-cert-file, and --tls-auth-clients options. Clients
+// must have a certificate that is signed by the CA when a CA is provided,
+// unless --tls-auth-clients is optional or no.
+func loadTLSConfig() (*tls.Config, *tlsCerts, error) {
+	certs := &tlsCerts{
+		certFile: core.TLSCertFile,
+		keyFile:  core.TLSKeyFile,
+		caFile:   core.TLSCACertFile,
+	}
+	if certs.caFile != "" {
+		switch core.TLSAuthClients {
+		case "no":
+			certs.clientAuth = tls.NoClientCert
+		case "optional":
+			certs.clientAuth = tls.VerifyClientCertIfGiven
+		default:
+			certs.clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	if err := certs.load(time.Now()); err != nil {
+		return nil, nil, err
+	}
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: certs.getConfigForClient,
+	}, certs, nil
+}
+
+// files returns the files of the certificates.
+func (c *tlsCerts) files() []string {
+	files := []string{c.certFile, c.keyFile}
+	if c.caFile != "" {
+		files = append(files, c.caFile)
+	}
+	return files
+}
+
+// load loads the files, when they are modified since the last load.
+func (c *tlsCerts) load(now time.Time) error {
+	c.checked = now
+	files := c.files()
+	modTime := make([]time.Time, len(files))
+	changed := c.config == nil
+	for i, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTime[i] = fi.ModTime()
+		if !changed && !modTime[i].Equal(c.modTime[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   c.clientAuth,
+	}
+	if c.caFile != "" {
+		pool, err := loadCertPool(c.caFile)
+		if err != nil {
+			return err
+		}
+		config.ClientCAs = pool
+	}
+	if c.config != nil {
+		log.Infof("Reloaded the tls certificates")
+	}
+	c.config = config
+	c.modTime = modTime
+	return nil
+}
+
+// reload loads the files now, even when they are not modified. The last
+// config is kept when the files cannot be loaded.
+func (c *tlsCerts) reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	config, modTime := c.config, c.modTime
+	c.config = nil
+	if err := c.load(time.Now()); err != nil {
+		c.config, c.modTime = config, modTime
+		return err
+	}
+	return nil
+}
+
+// getConfigForClient returns the config of a connection, after loading the
+// files that are modified. The last config is kept when the files cannot be
+// loaded, such as when they are half written.
+func (c *tlsCerts) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config,
+	error,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.checked) >= tlsReloadInterval {
+		if err := c.load(now); err != nil {
+			log.Errorf("tls: %v", err)
+		}
+	}
+	return c.config, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// leaderTLSConfig returns the config for a TLS connection to a leader or
+// peer. The leader's certificate is verified with the leadertlscacert
+// property, or with the system's CAs, and must match the host. The
+// leadertlscert and leadertlskey properties are the client certificate.
+func (s *Server) leaderTLSConfig(host string) (*tls.Config, error) {
+	caCert, cert, key := s.config.leaderTLS()
+	config := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+	if caCert != "" {
+		pool, err := loadCertPool(caCert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, errors.New("leadertlscert and leadertlskey must " +
+				"both be set")
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+// dialLeader connects to a leader or peer, over TLS when useTLS is true.
+func (s *Server) dialLeader(addr string, useTLS bool) (*RESPConn, error) {
+	if !useTLS {
+		return DialTimeout(addr, time.Second*2)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	config, err := s.leaderTLSConfig(host)
+	if err != nil {
+		return nil, err
+	}
+	return DialTLSTimeout(addr, time.Second*2, config)
+}
+
+// isReplCommand returns true for the commands that a follower or peer sends
+// to a leader.
+func isReplCommand(command string) bool {
+	switch command {
+	case "aof", "aofmd5", "replconf":
+		return true
+	}
+	return false
+}
+
+// replAuthCommand returns true for the commands that are allowed on a
+// connection that authenticated with the replpass.
+func replAuthCommand(command string) bool {
+	switch command {
+	case "auth", "server", "output", "raft":
+		return true
+	}
+	return isReplCommand(command)
+}
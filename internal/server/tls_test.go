@@ -0,0 +1,180 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tidwall/tile38/core"
+)
+
+// writeTestCert writes a self-signed certificate and its key, which is also
+// its own CA.
+func writeTestCert(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageDigitalSignature |
+			x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey,
+		key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+		Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func certSerial(t *testing.T, config *tls.Config) int64 {
+	t.Helper()
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.SerialNumber.Int64()
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	certs := &tlsCerts{
+		certFile: filepath.Join(dir, "cert.pem"),
+		keyFile:  filepath.Join(dir, "key.pem"),
+	}
+	writeTestCert(t, certs.certFile, certs.keyFile, 1)
+	if err := certs.load(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := certs.getConfigForClient(nil)
+	if certSerial(t, config) != 1 {
+		t.Fatal("expected the first certificate")
+	}
+
+	// the files are not checked again until the interval has passed
+	writeTestCert(t, certs.certFile, certs.keyFile, 2)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certs.certFile, future, future)
+	os.Chtimes(certs.keyFile, future, future)
+	config, _ = certs.getConfigForClient(nil)
+	if certSerial(t, config) != 1 {
+		t.Fatal("expected the first certificate")
+	}
+	certs.checked = time.Time{}
+	config, _ = certs.getConfigForClient(nil)
+	if certSerial(t, config) != 2 {
+		t.Fatal("expected the second certificate")
+	}
+
+	// a broken file keeps the last certificate
+	ioutil.WriteFile(certs.keyFile, []byte("broken"), 0600)
+	future = future.Add(time.Minute)
+	os.Chtimes(certs.keyFile, future, future)
+	certs.checked = time.Time{}
+	config, _ = certs.getConfigForClient(nil)
+	if certSerial(t, config) != 2 {
+		t.Fatal("expected the second certificate")
+	}
+}
+
+func TestTLSAuthClients(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, 1)
+	defer func(cert, key, ca, auth string) {
+		core.TLSCertFile, core.TLSKeyFile = cert, key
+		core.TLSCACertFile, core.TLSAuthClients = ca, auth
+	}(core.TLSCertFile, core.TLSKeyFile, core.TLSCACertFile,
+		core.TLSAuthClients)
+	core.TLSCertFile, core.TLSKeyFile = certFile, keyFile
+	core.TLSCACertFile = certFile
+
+	pool, err := loadCertPool(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// handshake returns the error of the server side of a handshake
+	handshake := func(withCert bool) error {
+		config, _, err := loadTLSConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		clientConfig := &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+		if withCert {
+			clientConfig.Certificates = []tls.Certificate{clientCert}
+		}
+		go func() {
+			conn, err := tls.Dial("tcp", ln.Addr().String(), clientConfig)
+			if err == nil {
+				conn.Read(make([]byte, 1))
+				conn.Close()
+			}
+		}()
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+		return tls.Server(conn, config).Handshake()
+	}
+	for _, tc := range []struct {
+		auth     string
+		withCert bool
+		ok       bool
+	}{
+		{"yes", true, true},
+		{"yes", false, false},
+		{"optional", true, true},
+		{"optional", false, true},
+		{"no", false, true},
+	} {
+		core.TLSAuthClients = tc.auth
+		if err := handshake(tc.withCert); (err == nil) != tc.ok {
+			t.Fatalf("%s with cert %v: unexpected error %v", tc.auth,
+				tc.withCert, err)
+		}
+	}
+}
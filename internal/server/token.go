@@ -4,9 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/tile38/internal/field"
 	lua "github.com/yuin/gopher-lua"
 )
 
@@ -16,6 +21,7 @@ var errInvalidNumberOfArguments = errors.New("invalid number of arguments")
 var errKeyNotFound = errors.New("key not found")
 var errIDNotFound = errors.New("id not found")
 var errIDAlreadyExists = errors.New("id already exists")
+var errConditionNotMet = errors.New("condition not met")
 var errPathNotFound = errors.New("path not found")
 var errKeyHasHooksSet = errors.New("key has hooks set")
 
@@ -93,7 +99,11 @@ type whereT struct {
 	max   float64
 }
 
-func (where whereT) match(value float64) bool {
+func (where whereT) match(fvalue field.Value) bool {
+	if !fvalue.IsNumeric() {
+		return false
+	}
+	value := fvalue.Num()
 	if !where.minx {
 		if value < where.min {
 			return false
@@ -115,13 +125,65 @@ func (where whereT) match(value float64) bool {
 	return true
 }
 
+// wherestrT compares the id of an object, the value of a string object, or
+// a field that is not a number to a string, such as WHERE ID PREFIX truck or
+// WHERE VALUE REGEX ^[a-z]+$.
+type wherestrT struct {
+	field string // empty for the id or value
+	value bool
+	op    string
+	str   string
+	re    *regexp.Regexp
+}
+
+func (wherestr wherestrT) match(
+	id string, o geojson.Object, fmap map[string]int, fields []field.Value,
+) bool {
+	s := id
+	if wherestr.field != "" {
+		idx, ok := fmap[wherestr.field]
+		if !ok || idx >= len(fields) || fields[idx].Kind() == field.Number {
+			return false
+		}
+		s = fields[idx].String()
+	} else if wherestr.value {
+		if objIsSpatial(o) {
+			return false
+		}
+		s = o.String()
+	}
+	switch wherestr.op {
+	case "eq":
+		return s == wherestr.str
+	case "prefix":
+		return strings.HasPrefix(s, wherestr.str)
+	case "suffix":
+		return strings.HasSuffix(s, wherestr.str)
+	case "regex":
+		return wherestr.re.MatchString(s)
+	}
+	return false
+}
+
+// isWherestrOp returns true for the operators of a WHERE on a string.
+func isWherestrOp(op string) bool {
+	switch op {
+	case "eq", "prefix", "suffix", "regex":
+		return true
+	}
+	return false
+}
+
 type whereinT struct {
 	field  string
 	valMap map[float64]struct{}
 }
 
-func (wherein whereinT) match(value float64) bool {
-	_, ok := wherein.valMap[value]
+func (wherein whereinT) match(value field.Value) bool {
+	if !value.IsNumeric() {
+		return false
+	}
+	_, ok := wherein.valMap[value.Num()]
 	return ok
 }
 
@@ -139,10 +201,17 @@ func (whereeval whereevalT) Close() {
 	whereeval.c.luapool.Put(whereeval.luaState)
 }
 
-func (whereeval whereevalT) match(fieldsWithNames map[string]float64) bool {
+func (whereeval whereevalT) match(fieldsWithNames map[string]field.Value) bool {
 	fieldsTbl := whereeval.luaState.CreateTable(0, len(fieldsWithNames))
-	for field, val := range fieldsWithNames {
-		fieldsTbl.RawSetString(field, lua.LNumber(val))
+	for name, val := range fieldsWithNames {
+		switch val.Kind() {
+		case field.Number:
+			fieldsTbl.RawSetString(name, lua.LNumber(val.Num()))
+		case field.Bool:
+			fieldsTbl.RawSetString(name, lua.LBool(val.Num() != 0))
+		default:
+			fieldsTbl.RawSetString(name, lua.LString(val.String()))
+		}
 	}
 
 	luaSetRawGlobals(
@@ -186,10 +255,13 @@ func (whereeval whereevalT) match(fieldsWithNames map[string]float64) bool {
 type searchScanBaseTokens struct {
 	key        string
 	cursor     uint64
+	stable     bool
+	after      pageToken // the cursor of a stable scan or search
 	output     outputT
 	precision  uint64
 	fence      bool
 	distance   bool
+	model      distModel
 	nodwell    bool
 	detect     map[string]bool
 	accept     map[string]bool
@@ -197,13 +269,30 @@ type searchScanBaseTokens struct {
 	wheres     []whereT
 	whereins   []whereinT
 	whereevals []whereevalT
+	wherestrs  []wherestrT
 	nofields   bool
 	ulimit     bool
 	limit      uint64
 	usparse    bool
 	sparse     uint8
 	desc       bool
+	sortBy     string
+	sortPoint  *geometry.Point // SORTBY DISTANCE of WITHIN or INTERSECTS
+	score      *scoreExpr      // the SCORE of a NEARBY
 	clip       bool
+	buffer     float64
+	zrange     bool
+	zmin       float64
+	zmax       float64
+	tsrange    bool  // only objects observed from tsmin to tsmax
+	tsmin      int64 // unix nano
+	tsmax      int64
+	sample     uint64 // a random sample of this many objects
+	agg        *aggregation
+	clusters   *pointClusters
+	utimeout   bool
+	timeout    time.Duration // the TIMEOUT of a search
+	stream     bool          // the JSON reply is written as it's produced
 }
 
 func (s *Server) parseSearchScanBaseTokens(
@@ -221,7 +310,11 @@ func (s *Server) parseSearchScanBaseTokens(
 
 	var slimit string
 	var ssparse string
+	var ssample string
 	var scursor string
+	var stimeout string
+	var groupBy string
+	var hasModel bool
 	var asc bool
 	for {
 		nvs, wtok, ok := tokenval(vs)
@@ -249,6 +342,30 @@ func (s *Server) parseSearchScanBaseTokens(
 					err = errInvalidNumberOfArguments
 					return
 				}
+				if op := strings.ToLower(smin); isWherestrOp(op) {
+					var str string
+					if vs, str, ok = tokenval(vs); !ok {
+						err = errInvalidNumberOfArguments
+						return
+					}
+					var wherestr wherestrT
+					switch strings.ToLower(field) {
+					case "id":
+					case "value":
+						wherestr.value = true
+					default:
+						wherestr.field = field
+					}
+					wherestr.op, wherestr.str = op, str
+					if op == "regex" {
+						if wherestr.re, err = regexp.Compile(str); err != nil {
+							err = errInvalidArgument(str)
+							return
+						}
+					}
+					t.wherestrs = append(t.wherestrs, wherestr)
+					continue
+				}
 				if vs, smax, ok = tokenval(vs); !ok || smax == "" {
 					err = errInvalidNumberOfArguments
 					return
@@ -387,6 +504,82 @@ func (s *Server) parseSearchScanBaseTokens(
 				}
 				t.whereevals = append(t.whereevals, whereevalT{s, luaState, fn})
 				continue
+			case "buffer":
+				vs = nvs
+				if t.buffer != 0 {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				var sbuffer string
+				if vs, sbuffer, ok = tokenval(vs); !ok || sbuffer == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				t.buffer, err = strconv.ParseFloat(sbuffer, 64)
+				if err != nil || t.buffer <= 0 || math.IsInf(t.buffer, 0) {
+					err = errInvalidArgument(sbuffer)
+					return
+				}
+				continue
+			case "sortby":
+				vs = nvs
+				if t.sortBy != "" {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				if vs, t.sortBy, ok = tokenval(vs); !ok || t.sortBy == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				if (cmd == "within" || cmd == "intersects") &&
+					lc(t.sortBy, "distance") {
+					var slat, slon string
+					if vs, slat, ok = tokenval(vs); !ok || slat == "" {
+						err = errInvalidNumberOfArguments
+						return
+					}
+					if vs, slon, ok = tokenval(vs); !ok || slon == "" {
+						err = errInvalidNumberOfArguments
+						return
+					}
+					var lat, lon float64
+					if lat, err = strconv.ParseFloat(slat, 64); err != nil {
+						err = errInvalidArgument(slat)
+						return
+					}
+					if lon, err = strconv.ParseFloat(slon, 64); err != nil {
+						err = errInvalidArgument(slon)
+						return
+					}
+					t.sortPoint = &geometry.Point{X: lon, Y: lat}
+				}
+				continue
+			case "score":
+				vs = nvs
+				if t.score != nil {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				var sexpr string
+				if vs, sexpr, ok = tokenval(vs); !ok || sexpr == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				if t.score, err = parseScoreExpr(sexpr); err != nil {
+					return
+				}
+				continue
+			case "groupby":
+				vs = nvs
+				if groupBy != "" {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				if vs, groupBy, ok = tokenval(vs); !ok || groupBy == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				continue
 			case "nofields":
 				vs = nvs
 				if t.nofields {
@@ -395,6 +588,14 @@ func (s *Server) parseSearchScanBaseTokens(
 				}
 				t.nofields = true
 				continue
+			case "stream":
+				vs = nvs
+				if t.stream {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				t.stream = true
+				continue
 			case "limit":
 				vs = nvs
 				if slimit != "" {
@@ -406,6 +607,17 @@ func (s *Server) parseSearchScanBaseTokens(
 					return
 				}
 				continue
+			case "timeout":
+				vs = nvs
+				if stimeout != "" {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				if vs, stimeout, ok = tokenval(vs); !ok || stimeout == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				continue
 			case "sparse":
 				vs = nvs
 				if ssparse != "" {
@@ -417,6 +629,17 @@ func (s *Server) parseSearchScanBaseTokens(
 					return
 				}
 				continue
+			case "sample":
+				vs = nvs
+				if ssample != "" {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				if vs, ssample, ok = tokenval(vs); !ok || ssample == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				continue
 			case "fence":
 				vs = nvs
 				if t.fence && !fromFence {
@@ -475,7 +698,8 @@ func (s *Server) parseSearchScanBaseTokens(
 					default:
 						err = errInvalidArgument(peek)
 						return
-					case "inside", "outside", "enter", "exit", "cross":
+					case "inside", "outside", "enter", "exit", "cross",
+						"delete", "expire":
 					}
 					if t.detect[part] {
 						err = errDuplicateArgument(s)
@@ -517,6 +741,38 @@ func (s *Server) parseSearchScanBaseTokens(
 				}
 				asc = true
 				continue
+			case "stable":
+				if cmd != "scan" && cmd != "nearby" && cmd != "within" &&
+					cmd != "intersects" {
+					break
+				}
+				vs = nvs
+				if t.stable {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				t.stable = true
+				continue
+			case "model":
+				if cmd != "nearby" && cmd != "within" && cmd != "intersects" {
+					break
+				}
+				vs = nvs
+				if hasModel {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				var smodel string
+				if vs, smodel, ok = tokenval(vs); !ok || smodel == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				if t.model, ok = parseDistModel(strings.ToLower(smodel)); !ok {
+					err = errInvalidArgument(smodel)
+					return
+				}
+				hasModel = true
+				continue
 			case "match":
 				vs = nvs
 				if t.glob != "" {
@@ -536,6 +792,46 @@ func (s *Server) parseSearchScanBaseTokens(
 				}
 				t.clip = true
 				continue
+			case "zrange":
+				vs = nvs
+				if t.zrange {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				var szmin, szmax string
+				if vs, szmin, ok = tokenval(vs); !ok || szmin == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				if vs, szmax, ok = tokenval(vs); !ok || szmax == "" {
+					err = errInvalidNumberOfArguments
+					return
+				}
+				if t.zmin, err = zparse(szmin); err != nil {
+					err = errInvalidArgument(szmin)
+					return
+				}
+				if t.zmax, err = zparse(szmax); err != nil {
+					err = errInvalidArgument(szmax)
+					return
+				}
+				if t.zmin > t.zmax {
+					err = errInvalidArgument(szmin)
+					return
+				}
+				t.zrange = true
+				continue
+			case "since", "between":
+				vs = nvs
+				if t.tsrange {
+					err = errDuplicateArgument(strings.ToUpper(wtok))
+					return
+				}
+				if vs, t.tsmin, t.tsmax, err = parseTimeRange(wtok, vs); err != nil {
+					return
+				}
+				t.tsrange = true
+				continue
 			}
 		}
 		break
@@ -551,7 +847,8 @@ func (s *Server) parseSearchScanBaseTokens(
 			err = errors.New("FENCE is not allowed for " + strings.ToUpper(cmd))
 			return
 		}
-	} else {
+	} else if t.score == nil &&
+		(t.sortBy == "" || (cmd != "nearby" && t.sortPoint == nil)) {
 		if t.desc {
 			err = errors.New("DESC is not allowed for " + strings.ToUpper(cmd))
 			return
@@ -561,6 +858,40 @@ func (s *Server) parseSearchScanBaseTokens(
 			return
 		}
 	}
+	if t.buffer != 0 {
+		if cmd != "within" && cmd != "intersects" {
+			err = errors.New("BUFFER is not allowed for " + strings.ToUpper(cmd))
+			return
+		}
+		if t.clip {
+			err = errors.New("CLIP is not allowed when BUFFER is specified")
+			return
+		}
+	}
+	if t.sortBy != "" {
+		if cmd != "nearby" && t.sortPoint == nil {
+			err = errors.New("SORTBY is not allowed for " + strings.ToUpper(cmd))
+			return
+		}
+		if t.fence {
+			err = errors.New("SORTBY is not allowed when FENCE is specified")
+			return
+		}
+	}
+	if t.score != nil {
+		if cmd != "nearby" {
+			err = errors.New("SCORE is not allowed for " + strings.ToUpper(cmd))
+			return
+		}
+		if t.fence {
+			err = errors.New("SCORE is not allowed when FENCE is specified")
+			return
+		}
+		if t.sortBy != "" {
+			err = errors.New("SCORE is not allowed when SORTBY is specified")
+			return
+		}
+	}
 	if ssparse != "" && slimit != "" {
 		err = errors.New("LIMIT is not allowed when SPARSE is specified")
 		return
@@ -573,6 +904,44 @@ func (s *Server) parseSearchScanBaseTokens(
 		err = errors.New("CURSOR is not allowed when FENCE is specified")
 		return
 	}
+	if t.stable && ssparse != "" {
+		err = errors.New("STABLE is not allowed when SPARSE is specified")
+		return
+	}
+	if t.stable && t.fence {
+		err = errors.New("STABLE is not allowed when FENCE is specified")
+		return
+	}
+	if t.stable && t.sortBy != "" {
+		err = errors.New("STABLE is not allowed when SORTBY is specified")
+		return
+	}
+	if t.stable && t.score != nil {
+		err = errors.New("STABLE is not allowed when SCORE is specified")
+		return
+	}
+	if t.tsrange && t.fence {
+		err = errors.New("SINCE and BETWEEN are not allowed when FENCE is specified")
+		return
+	}
+	if ssample != "" {
+		if cmd != "scan" && cmd != "within" && cmd != "intersects" {
+			err = errors.New("SAMPLE is not allowed for " + strings.ToUpper(cmd))
+			return
+		}
+		if t.fence {
+			err = errors.New("SAMPLE is not allowed when FENCE is specified")
+			return
+		}
+		if slimit != "" || ssparse != "" || scursor != "" || t.stable {
+			err = errors.New("LIMIT, SPARSE, CURSOR, and STABLE are not allowed when SAMPLE is specified")
+			return
+		}
+		if t.sortBy != "" {
+			err = errors.New("SAMPLE is not allowed when SORTBY is specified")
+			return
+		}
+	}
 	if t.detect != nil && !t.fence {
 		err = errors.New("DETECT is not allowed when FENCE is not specified")
 		return
@@ -581,6 +950,10 @@ func (s *Server) parseSearchScanBaseTokens(
 	t.output = defaultSearchOutput
 	var nvs []string
 	var sprecision string
+	var sres string
+	var sstats string
+	var szoom string
+	var squant string
 	var which string
 	if nvs, which, ok = tokenval(vs); ok && which != "" {
 		updline := true
@@ -603,8 +976,40 @@ func (s *Server) parseSearchScanBaseTokens(
 				err = errInvalidNumberOfArguments
 				return
 			}
+		case "h3":
+			t.output = outputH3
+			if nvs, sres, ok = tokenval(nvs); !ok || sres == "" {
+				err = errInvalidNumberOfArguments
+				return
+			}
 		case "bounds":
 			t.output = outputBounds
+		case "stats":
+			t.output = outputStats
+			if nvs, sstats, ok = tokenval(nvs); !ok || sstats == "" {
+				err = errInvalidNumberOfArguments
+				return
+			}
+		case "clusters":
+			if cmd == "search" {
+				err = errInvalidArgument(which)
+				return
+			}
+			t.output = outputClusters
+			if nvs, szoom, ok = tokenval(nvs); !ok || szoom == "" {
+				err = errInvalidNumberOfArguments
+				return
+			}
+		case "topojson":
+			if cmd == "search" {
+				err = errInvalidArgument(which)
+				return
+			}
+			t.output = outputTopoJSON
+			if nvs, squant, ok = tokenval(nvs); !ok || squant == "" {
+				err = errInvalidNumberOfArguments
+				return
+			}
 		case "ids":
 			t.output = outputIDs
 		}
@@ -612,12 +1017,80 @@ func (s *Server) parseSearchScanBaseTokens(
 			vs = nvs
 		}
 	}
-	if scursor != "" {
+	if t.output == outputStats || groupBy != "" {
+		if t.fence {
+			err = errors.New("GROUPBY and STATS are not allowed when FENCE is specified")
+			return
+		}
+		if t.output != outputCount && t.output != outputStats {
+			err = errors.New("GROUPBY is not allowed without COUNT or STATS")
+			return
+		}
+		if t.agg, err = newAggregation(groupBy, sstats); err != nil {
+			return
+		}
+	}
+	if t.output == outputClusters {
+		if t.fence {
+			err = errors.New("CLUSTERS is not allowed when FENCE is specified")
+			return
+		}
+		zoom, perr := strconv.ParseUint(szoom, 10, 8)
+		if perr != nil || zoom > clusterMaxZoom {
+			err = errInvalidArgument(szoom)
+			return
+		}
+		t.clusters = newPointClusters(int(zoom))
+	}
+	if ssample != "" {
+		if t.output == outputCount || t.output == outputStats ||
+			t.output == outputClusters {
+			err = errors.New("SAMPLE is not allowed with COUNT, STATS, or CLUSTERS")
+			return
+		}
+		if t.sample, err = strconv.ParseUint(ssample, 10, 64); err != nil || t.sample == 0 {
+			err = errInvalidArgument(ssample)
+			return
+		}
+		// every object is tested, and the sample is taken from those that
+		// match
+		t.limit = math.MaxUint64
+	}
+	if t.output == outputTopoJSON {
+		if t.fence {
+			err = errors.New("TOPOJSON is not allowed when FENCE is specified")
+			return
+		}
+		// the precision of the TopoJSON output is its quantization, which is
+		// zero for none
+		t.precision, err = strconv.ParseUint(squant, 10, 32)
+		if err != nil || t.precision == 1 {
+			err = errInvalidArgument(squant)
+			return
+		}
+	}
+	if t.stable {
+		t.after.nearby = cmd == "nearby"
+		if scursor != "" {
+			if t.after, ok = parsePageToken(scursor, t.after.nearby); !ok {
+				err = errInvalidArgument(scursor)
+				return
+			}
+		}
+	} else if scursor != "" {
 		if t.cursor, err = strconv.ParseUint(scursor, 10, 64); err != nil {
 			err = errInvalidArgument(scursor)
 			return
 		}
 	}
+	if t.output == outputH3 {
+		// the precision of the H3 output is the resolution of the cells
+		var res int
+		if res, err = parseH3Res(sres); err != nil {
+			return
+		}
+		t.precision = uint64(res)
+	}
 	if sprecision != "" {
 		t.precision, err = strconv.ParseUint(sprecision, 10, 64)
 		if err != nil || t.precision == 0 || t.precision > 12 {
@@ -632,6 +1105,31 @@ func (s *Server) parseSearchScanBaseTokens(
 			return
 		}
 	}
+	if stimeout != "" {
+		// milliseconds, a fence and COPY do not have a deadline
+		if t.fence || cmd == "copy" {
+			err = errInvalidArgument("TIMEOUT")
+			return
+		}
+		var ms uint64
+		if ms, err = strconv.ParseUint(stimeout, 10, 32); err != nil {
+			err = errInvalidArgument(stimeout)
+			return
+		}
+		t.utimeout = true
+		t.timeout = time.Duration(ms) * time.Millisecond
+	}
+	if t.stream {
+		// a fence and COPY do not have a reply to stream
+		if t.fence || cmd == "copy" {
+			err = errInvalidArgument("STREAM")
+			return
+		}
+		if !t.ulimit {
+			// a stream has every object by default
+			t.limit = math.MaxUint64
+		}
+	}
 	if ssparse != "" {
 		t.usparse = true
 		var sparse uint64
@@ -757,7 +1255,8 @@ loop:
 				ae = &areaExpression{op: OR, children: []*areaExpression{ae}}
 			}
 			vsout = nvs
-		case "point", "circle", "object", "bounds", "hash", "quadkey", "tile", "get":
+		case "point", "circle", "object", "bounds", "hash", "geohash", "hex",
+			"quadkey", "tile", "get":
 			parsedVs, parsedObj, areaErr := s.parseArea(vsout, doClip)
 			if areaErr != nil {
 				err = areaErr
@@ -781,3 +1280,14 @@ loop:
 	}
 	return
 }
+
+// zparse parses an altitude value, allowing for "-inf" and "+inf".
+func zparse(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "-inf":
+		return math.Inf(-1), nil
+	case "+inf", "inf":
+		return math.Inf(+1), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,476 @@
+package server
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+)
+
+// topology returns the objects of a search as TopoJSON, instead of GeoJSON.
+// The lines and the rings of the polygons are cut into arcs where they meet
+// other lines, so that the borders that are shared by the objects are only
+// written once. The coordinates are quantized to a grid of q by q points,
+// and the arcs are delta encoded, unless q is zero. Objects that are not
+// spatial are left out.
+type topology struct {
+	name    string
+	q       uint64
+	objects []topoObject
+	rect    geometry.Rect
+}
+
+type topoObject struct {
+	id    string
+	obj   geojson.Object
+	props string // the json object of the fields, if any
+}
+
+// topoGeom is a geometry of the topology, where the lines and rings are
+// indexes of the lines, which are replaced by their arcs.
+type topoGeom struct {
+	typ      string
+	points   [][2]float64 // Point and MultiPoint
+	parts    [][]int      // lines of LineString to MultiPolygon
+	children []*topoGeom  // GeometryCollection
+}
+
+func newTopology(name string, q uint64) *topology {
+	return &topology{name: name, q: q}
+}
+
+// add includes an object that matched the search.
+func (tp *topology) add(id string, o geojson.Object, props string) {
+	if !objIsSpatial(o) {
+		return
+	}
+	rect := o.Rect()
+	if len(tp.objects) == 0 {
+		tp.rect = rect
+	} else {
+		tp.rect.Min.X = math.Min(tp.rect.Min.X, rect.Min.X)
+		tp.rect.Min.Y = math.Min(tp.rect.Min.Y, rect.Min.Y)
+		tp.rect.Max.X = math.Max(tp.rect.Max.X, rect.Max.X)
+		tp.rect.Max.Y = math.Max(tp.rect.Max.Y, rect.Max.Y)
+	}
+	tp.objects = append(tp.objects, topoObject{id: id, obj: o, props: props})
+}
+
+// topoBuilder holds the lines of the geometries while they are cut into
+// arcs.
+type topoBuilder struct {
+	tp     *topology
+	kx, ky float64
+	lines  [][][2]float64
+	rings  []bool
+}
+
+func (b *topoBuilder) quantize(p geometry.Point) [2]float64 {
+	if b.tp.q == 0 {
+		return [2]float64{p.X, p.Y}
+	}
+	return [2]float64{
+		math.Round((p.X - b.tp.rect.Min.X) / b.kx),
+		math.Round((p.Y - b.tp.rect.Min.Y) / b.ky),
+	}
+}
+
+// addLine returns the index of a line, without the consecutive points that
+// are the same once quantized. Rings are closed.
+func (b *topoBuilder) addLine(points []geometry.Point, ring bool) int {
+	var line [][2]float64
+	for _, p := range points {
+		q := b.quantize(p)
+		if len(line) == 0 || line[len(line)-1] != q {
+			line = append(line, q)
+		}
+	}
+	if len(line) == 0 {
+		line = append(line, [2]float64{})
+	}
+	if ring && (len(line) == 1 || line[0] != line[len(line)-1]) {
+		line = append(line, line[0])
+	} else if len(line) == 1 {
+		line = append(line, line[0])
+	}
+	b.lines = append(b.lines, line)
+	b.rings = append(b.rings, ring)
+	return len(b.lines) - 1
+}
+
+func (b *topoBuilder) addPoly(poly *geometry.Poly) []int {
+	if poly == nil || poly.Exterior == nil {
+		return nil
+	}
+	rings := []int{b.addLine(ringPoints(poly.Exterior), true)}
+	for _, hole := range poly.Holes {
+		rings = append(rings, b.addLine(ringPoints(hole), true))
+	}
+	return rings
+}
+
+func (b *topoBuilder) geom(o geojson.Object) *topoGeom {
+	switch o := o.(type) {
+	case *geojson.Feature:
+		return b.geom(o.Base())
+	case *geojson.MultiPoint:
+		g := &topoGeom{typ: "MultiPoint"}
+		for _, child := range o.Children() {
+			g.points = append(g.points, b.quantize(child.Center()))
+		}
+		return g
+	case *geojson.LineString:
+		return &topoGeom{typ: "LineString",
+			parts: [][]int{{b.addLine(linePoints(o.Base()), false)}}}
+	case *geojson.MultiLineString:
+		g := &topoGeom{typ: "MultiLineString"}
+		for _, child := range o.Children() {
+			if line, ok := child.(*geojson.LineString); ok {
+				g.parts = append(g.parts,
+					[]int{b.addLine(linePoints(line.Base()), false)})
+			}
+		}
+		return g
+	case *geojson.Polygon:
+		return &topoGeom{typ: "Polygon", parts: [][]int{b.addPoly(o.Base())}}
+	case *geojson.MultiPolygon:
+		g := &topoGeom{typ: "MultiPolygon"}
+		for _, child := range o.Children() {
+			if poly, ok := child.(*geojson.Polygon); ok {
+				g.parts = append(g.parts, b.addPoly(poly.Base()))
+			}
+		}
+		return g
+	case *geojson.Rect:
+		r := o.Base()
+		return &topoGeom{typ: "Polygon", parts: [][]int{{b.addLine(
+			[]geometry.Point{r.Min, {X: r.Max.X, Y: r.Min.Y}, r.Max,
+				{X: r.Min.X, Y: r.Max.Y}}, true)}}}
+	case geojson.Collection:
+		g := &topoGeom{typ: "GeometryCollection"}
+		for _, child := range o.Children() {
+			if objIsSpatial(child) {
+				g.children = append(g.children, b.geom(child))
+			}
+		}
+		return g
+	}
+	return &topoGeom{typ: "Point", points: [][2]float64{b.quantize(o.Center())}}
+}
+
+func linePoints(line *geometry.Line) []geometry.Point {
+	if line == nil {
+		return nil
+	}
+	points := make([]geometry.Point, line.NumPoints())
+	for i := range points {
+		points[i] = line.PointAt(i)
+	}
+	return points
+}
+
+// junctions returns the points where the lines meet, which are the ends of
+// the lines, and the points that do not have the same neighbors on every
+// line that they are on.
+func (b *topoBuilder) junctions() map[[2]float64]bool {
+	type neighbors struct{ prev, next [2]float64 }
+	seen := make(map[[2]float64]neighbors)
+	junctions := make(map[[2]float64]bool)
+	visit := func(p, prev, next [2]float64) {
+		n, ok := seen[p]
+		if !ok {
+			seen[p] = neighbors{prev, next}
+		} else if (n.prev != prev || n.next != next) &&
+			(n.prev != next || n.next != prev) {
+			junctions[p] = true
+		}
+	}
+	for i, line := range b.lines {
+		if b.rings[i] {
+			n := len(line) - 1
+			for j := 0; j < n; j++ {
+				visit(line[j], line[(j+n-1)%n], line[(j+1)%n])
+			}
+			continue
+		}
+		junctions[line[0]] = true
+		junctions[line[len(line)-1]] = true
+		for j := 1; j < len(line)-1; j++ {
+			visit(line[j], line[j-1], line[j+1])
+		}
+	}
+	return junctions
+}
+
+// arcs cuts the lines at the junctions, and returns the arcs, and the arc
+// indexes of each line. The index of an arc that is reversed is ^index.
+func (b *topoBuilder) arcs() ([][][2]float64, [][]int) {
+	junctions := b.junctions()
+	var arcs [][][2]float64
+	index := make(map[string]int)
+	arcIndex := func(arc [][2]float64) int {
+		if i, ok := index[topoArcKey(arc, false)]; ok {
+			return i
+		}
+		if i, ok := index[topoArcKey(arc, true)]; ok {
+			return ^i
+		}
+		index[topoArcKey(arc, false)] = len(arcs)
+		arcs = append(arcs, arc)
+		return len(arcs) - 1
+	}
+	lineArcs := make([][]int, len(b.lines))
+	for i, line := range b.lines {
+		if b.rings[i] {
+			n := len(line) - 1
+			start := -1
+			for j := 0; j < n; j++ {
+				if junctions[line[j]] {
+					start = j
+					break
+				}
+			}
+			if start == -1 {
+				// a ring that is not shared with other lines, or is shared as
+				// a whole, which may start at any of its points
+				lineArcs[i] = []int{b.ringIndex(line, index, &arcs)}
+				continue
+			}
+			rotated := make([][2]float64, 0, len(line))
+			rotated = append(rotated, line[start:n]...)
+			rotated = append(rotated, line[:start+1]...)
+			line = rotated
+		}
+		start := 0
+		for j := 1; j < len(line); j++ {
+			if j == len(line)-1 || junctions[line[j]] {
+				lineArcs[i] = append(lineArcs[i], arcIndex(line[start:j+1]))
+				start = j
+			}
+		}
+	}
+	return arcs, lineArcs
+}
+
+// ringIndex returns the arc of a ring that has no junctions, which starts
+// at its lowest point, so that the same ring of another geometry, in either
+// direction, is the same arc.
+func (b *topoBuilder) ringIndex(ring [][2]float64, index map[string]int,
+	arcs *[][][2]float64,
+) int {
+	forward := topoRotate(ring)
+	reversed := make([][2]float64, len(ring))
+	for i, p := range ring {
+		reversed[len(ring)-1-i] = p
+	}
+	reversed = topoRotate(reversed)
+	if i, ok := index[topoArcKey(forward, false)]; ok {
+		return i
+	}
+	if i, ok := index[topoArcKey(reversed, false)]; ok {
+		return ^i
+	}
+	index[topoArcKey(forward, false)] = len(*arcs)
+	*arcs = append(*arcs, forward)
+	return len(*arcs) - 1
+}
+
+// topoRotate returns a closed ring that starts at its lowest point.
+func topoRotate(ring [][2]float64) [][2]float64 {
+	n := len(ring) - 1
+	if n < 1 {
+		return ring
+	}
+	min := 0
+	for i := 1; i < n; i++ {
+		if ring[i][0] < ring[min][0] ||
+			(ring[i][0] == ring[min][0] && ring[i][1] < ring[min][1]) {
+			min = i
+		}
+	}
+	rotated := make([][2]float64, 0, len(ring))
+	rotated = append(rotated, ring[min:n]...)
+	rotated = append(rotated, ring[:min+1]...)
+	return rotated
+}
+
+func topoArcKey(arc [][2]float64, reverse bool) string {
+	b := make([]byte, 0, len(arc)*16)
+	for i := range arc {
+		p := arc[i]
+		if reverse {
+			p = arc[len(arc)-1-i]
+		}
+		b = strconv.AppendFloat(b, p[0], 'g', -1, 64)
+		b = append(b, ',')
+		b = strconv.AppendFloat(b, p[1], 'g', -1, 64)
+		b = append(b, ';')
+	}
+	return string(b)
+}
+
+func appendTopoPoint(b []byte, p [2]float64) []byte {
+	b = append(b, '[')
+	b = strconv.AppendFloat(b, p[0], 'f', -1, 64)
+	b = append(b, ',')
+	b = strconv.AppendFloat(b, p[1], 'f', -1, 64)
+	return append(b, ']')
+}
+
+func appendTopoArcs(b []byte, lineArcs []int) []byte {
+	b = append(b, '[')
+	for i, arc := range lineArcs {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendInt(b, int64(arc), 10)
+	}
+	return append(b, ']')
+}
+
+func (g *topoGeom) appendJSON(b []byte, lineArcs [][]int, id, props string) []byte {
+	b = append(b, `{"type":"`+g.typ+`"`...)
+	if id != "" {
+		b = append(b, `,"id":`+jsonString(id)...)
+	}
+	if props != "" {
+		b = append(b, `,"properties":`+props...)
+	}
+	switch g.typ {
+	case "Point":
+		b = append(b, `,"coordinates":`...)
+		b = appendTopoPoint(b, g.points[0])
+	case "MultiPoint":
+		b = append(b, `,"coordinates":[`...)
+		for i, p := range g.points {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendTopoPoint(b, p)
+		}
+		b = append(b, ']')
+	case "LineString":
+		b = append(b, `,"arcs":`...)
+		b = appendTopoArcs(b, lineArcs[g.parts[0][0]])
+	case "MultiLineString", "Polygon":
+		b = append(b, `,"arcs":[`...)
+		var lines []int
+		if g.typ == "Polygon" {
+			lines = g.parts[0]
+		} else {
+			for _, part := range g.parts {
+				lines = append(lines, part[0])
+			}
+		}
+		for i, line := range lines {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendTopoArcs(b, lineArcs[line])
+		}
+		b = append(b, ']')
+	case "MultiPolygon":
+		b = append(b, `,"arcs":[`...)
+		for i, part := range g.parts {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(b, '[')
+			for j, line := range part {
+				if j > 0 {
+					b = append(b, ',')
+				}
+				b = appendTopoArcs(b, lineArcs[line])
+			}
+			b = append(b, ']')
+		}
+		b = append(b, ']')
+	case "GeometryCollection":
+		b = append(b, `,"geometries":[`...)
+		for i, child := range g.children {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = child.appendJSON(b, lineArcs, "", "")
+		}
+		b = append(b, ']')
+	}
+	return append(b, '}')
+}
+
+// appendJSON appends the topology, which has the objects as a
+// GeometryCollection that is named by the key.
+func (tp *topology) appendJSON(dst []byte) []byte {
+	b := &topoBuilder{tp: tp, kx: 1, ky: 1}
+	if tp.q > 0 {
+		if w := tp.rect.Max.X - tp.rect.Min.X; w > 0 {
+			b.kx = w / float64(tp.q-1)
+		}
+		if h := tp.rect.Max.Y - tp.rect.Min.Y; h > 0 {
+			b.ky = h / float64(tp.q-1)
+		}
+	}
+	geoms := make([]*topoGeom, len(tp.objects))
+	for i, obj := range tp.objects {
+		geoms[i] = b.geom(obj.obj)
+	}
+	arcs, lineArcs := b.arcs()
+
+	dst = append(dst, `{"type":"Topology"`...)
+	if len(tp.objects) > 0 {
+		dst = append(dst, `,"bbox":[`...)
+		for i, v := range [...]float64{tp.rect.Min.X, tp.rect.Min.Y,
+			tp.rect.Max.X, tp.rect.Max.Y} {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = strconv.AppendFloat(dst, v, 'f', -1, 64)
+		}
+		dst = append(dst, ']')
+	}
+	if tp.q > 0 {
+		dst = append(dst, `,"transform":{"scale":`...)
+		dst = appendTopoPoint(dst, [2]float64{b.kx, b.ky})
+		dst = append(dst, `,"translate":`...)
+		dst = appendTopoPoint(dst, [2]float64{tp.rect.Min.X, tp.rect.Min.Y})
+		dst = append(dst, '}')
+	}
+	dst = append(dst, `,"objects":{`+jsonString(tp.name)+
+		`:{"type":"GeometryCollection","geometries":[`...)
+	for i, g := range geoms {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = g.appendJSON(dst, lineArcs, tp.objects[i].id, tp.objects[i].props)
+	}
+	dst = append(dst, `]}},"arcs":[`...)
+	for i, arc := range arcs {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, '[')
+		var prev [2]float64
+		for j, p := range arc {
+			if j > 0 {
+				dst = append(dst, ',')
+			}
+			if tp.q > 0 {
+				// delta encoded
+				dst = appendTopoPoint(dst, [2]float64{p[0] - prev[0], p[1] - prev[1]})
+				prev = p
+			} else {
+				dst = appendTopoPoint(dst, p)
+			}
+		}
+		dst = append(dst, ']')
+	}
+	return append(dst, "]}"...)
+}
+
+// writeJSON writes the topology of the objects.
+func (tp *topology) writeJSON(wr *bytes.Buffer) {
+	wr.WriteString(`,"topology":`)
+	wr.Write(tp.appendJSON(nil))
+}
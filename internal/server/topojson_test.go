@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+	"github.com/tidwall/tile38/internal/collection"
+)
+
+func TestTopologySharedRing(t *testing.T) {
+	// the same ring, wound the other way and starting at another point, is
+	// the same arc reversed
+	tp := newTopology("zones", 0)
+	tp.add("a", geojson.NewPolygon(geometry.NewPoly([]geometry.Point{
+		{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 1, Y: 1}, {X: 0, Y: 0},
+	}, nil, nil)), "")
+	tp.add("b", geojson.NewPolygon(geometry.NewPoly([]geometry.Point{
+		{X: 1, Y: 1}, {X: 1, Y: 0}, {X: 0, Y: 0}, {X: 1, Y: 1},
+	}, nil, nil)), `{"n":1}`)
+	tp.add("c", collection.String("hello"), "")
+	expect := `{"type":"Topology","bbox":[0,0,1,1],"objects":{"zones":{` +
+		`"type":"GeometryCollection","geometries":[` +
+		`{"type":"Polygon","id":"a","arcs":[[0]]},` +
+		`{"type":"Polygon","id":"b","properties":{"n":1},"arcs":[[-1]]}]}},` +
+		`"arcs":[[[0,0],[1,0],[1,1],[0,0]]]}`
+	if s := string(tp.appendJSON(nil)); s != expect {
+		t.Fatalf("expected %s, got %s", expect, s)
+	}
+}
+
+func TestTopologyQuantize(t *testing.T) {
+	tp := newTopology("roads", 11)
+	tp.add("a", geojson.NewLineString(geometry.NewLine([]geometry.Point{
+		{X: 10, Y: 20}, {X: 10.01, Y: 20}, {X: 15, Y: 25}, {X: 20, Y: 20},
+	}, nil)), "")
+	tp.add("b", geojson.NewPoint(geometry.Point{X: 15, Y: 25}), "")
+	// the first two points are the same once quantized, and the point of b
+	// is not an arc
+	expect := `{"type":"Topology","bbox":[10,20,20,25],` +
+		`"transform":{"scale":[1,0.5],"translate":[10,20]},` +
+		`"objects":{"roads":{"type":"GeometryCollection","geometries":[` +
+		`{"type":"LineString","id":"a","arcs":[0]},` +
+		`{"type":"Point","id":"b","coordinates":[5,10]}]}},` +
+		`"arcs":[[[0,0],[5,10],[5,-10]]]}`
+	if s := string(tp.appendJSON(nil)); s != expect {
+		t.Fatalf("expected %s, got %s", expect, s)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"github.com/tidwall/tile38/internal/trace"
+)
+
+// startCommandSpan starts the span of a command, which is a child of the
+// traceparent header of an HTTP request, or of the CLIENT TRACEPARENT of
+// the client. It returns nil when the tracing is off.
+func (s *Server) startCommandSpan(msg *Message, client *Client) *trace.Span {
+	if !s.tracer.Enabled() {
+		return nil
+	}
+	parent := client.traceparent
+	if msg.traceparent != "" {
+		// an invalid header is ignored, like the spec says
+		if sc, err := trace.ParseTraceparent(msg.traceparent); err == nil {
+			parent = sc
+		}
+	}
+	command := msg.Command()
+	if !metricsCommands[command] {
+		command = "unknown"
+	}
+	span := s.tracer.Start(command, trace.KindServer, parent)
+	span.SetAttr("db.system", "tile38")
+	span.SetAttr("db.operation", command)
+	span.SetAttr("tile38.client.id", client.id)
+	return span
+}
+
+// setWriteSpan sets the span of the write that holds the lock, which is the
+// parent of the deliveries of the hooks that the write queues. The caller
+// must hold the lock.
+func (s *Server) setWriteSpan(span *trace.Span) {
+	s.writeSpan = span.Context()
+}
@@ -0,0 +1,423 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/match"
+	"github.com/tidwall/resp"
+	"github.com/tidwall/tile38/internal/field"
+	"github.com/tidwall/tile38/internal/log"
+	lua "github.com/yuin/gopher-lua"
+)
+
+var errTriggerNotFound = errors.New("trigger not found")
+
+// trigger calls a lua function of a library on the SET and DEL commands for
+// the collections that match a pattern, before or after the write is
+// applied.
+type trigger struct {
+	name     string
+	pattern  string // collection key pattern
+	before   bool   // called before the write is applied
+	event    string // set, del, or all
+	function string // name of the function
+}
+
+// matches returns true when the trigger is called for a command on a key.
+func (t *trigger) matches(command, key string) bool {
+	return (t.event == "all" || t.event == command) &&
+		match.Match(key, t.pattern)
+}
+
+// args returns the SETTRIGGER arguments following the name.
+func (t *trigger) args() []string {
+	when := "after"
+	if t.before {
+		when = "before"
+	}
+	return []string{t.pattern, when, t.event, t.function}
+}
+
+// SETTRIGGER name pattern (BEFORE | AFTER) (SET | DEL | ALL) function
+//
+// Calls a function of a library on the writes to the collections that match
+// the pattern. The function is called with a table of the event, which has
+// the trigger name, the command, key, id, object, and fields. A function
+// that is called before a SET may change the write by returning a table with
+// the fields to set and the object to set in place of the one of the SET. A
+// function that is called before a write may reject it by returning false or
+// an error reply. What a function returns after a write is ignored.
+//
+// Only the writes of clients call the triggers, and not the writes of
+// scripts, triggers, followers, and peers. A trigger is removed along with
+// its function.
+func (s *Server) cmdSetTrigger(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var t trigger
+	var when string
+	var ok bool
+	if vs, t.name, ok = tokenval(vs); !ok || t.name == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if vs, t.pattern, ok = tokenval(vs); !ok || t.pattern == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if vs, when, ok = tokenval(vs); !ok || when == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	switch strings.ToLower(when) {
+	case "before":
+		t.before = true
+	case "after":
+	default:
+		return NOMessage, d, errInvalidArgument(when)
+	}
+	if vs, t.event, ok = tokenval(vs); !ok || t.event == "" {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	t.event = strings.ToLower(t.event)
+	switch t.event {
+	case "set", "del", "all":
+	default:
+		return NOMessage, d, errInvalidArgument(t.event)
+	}
+	if vs, t.function, ok = tokenval(vs); !ok || t.function == "" ||
+		len(vs) != 0 {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if s.functions[t.function] == nil {
+		return NOMessage, d, errFunctionNotFound
+	}
+	s.triggers[t.name] = &t
+	d.updated = true
+	d.timestamp = time.Now()
+	return OKMessage(msg, start), d, nil
+}
+
+// DELTRIGGER name
+func (s *Server) cmdDelTrigger(msg *Message) (
+	res resp.Value, d commandDetails, err error,
+) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	var name string
+	var ok bool
+	if vs, name, ok = tokenval(vs); !ok || name == "" || len(vs) != 0 {
+		return NOMessage, d, errInvalidNumberOfArguments
+	}
+	if _, ok := s.triggers[name]; ok {
+		delete(s.triggers, name)
+		d.updated = true
+	}
+	d.timestamp = time.Now()
+	return intResult(msg, start, d.updated), d, nil
+}
+
+// TRIGGERS [pattern]
+//
+// Returns the triggers with names that match the pattern.
+func (s *Server) cmdTriggers(msg *Message) (resp.Value, error) {
+	start := time.Now()
+	vs := msg.Args[1:]
+	pattern := "*"
+	if len(vs) > 0 {
+		var ok bool
+		if vs, pattern, ok = tokenval(vs); !ok || pattern == "" ||
+			len(vs) != 0 {
+			return NOMessage, errInvalidNumberOfArguments
+		}
+	}
+	var ts []*trigger
+	for name, t := range s.triggers {
+		if match.Match(name, pattern) {
+			ts = append(ts, t)
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].name < ts[j].name
+	})
+	switch msg.OutputType {
+	case JSON:
+		var buf []byte
+		buf = append(buf, `{"ok":true,"triggers":[`...)
+		for i, t := range ts {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			args := t.args()
+			buf = append(buf, `{"name":`...)
+			buf = appendJSONString(buf, t.name)
+			buf = append(buf, `,"pattern":`...)
+			buf = appendJSONString(buf, args[0])
+			buf = append(buf, `,"when":`...)
+			buf = appendJSONString(buf, args[1])
+			buf = append(buf, `,"event":`...)
+			buf = appendJSONString(buf, args[2])
+			buf = append(buf, `,"function":`...)
+			buf = appendJSONString(buf, args[3])
+			buf = append(buf, '}')
+		}
+		buf = append(buf, `],"elapsed":"`+time.Since(start).String()+`"}`...)
+		return resp.BytesValue(buf), nil
+	case RESP:
+		vals := make([]resp.Value, len(ts))
+		for i, t := range ts {
+			args := append([]string{t.name}, t.args()...)
+			tvals := make([]resp.Value, len(args))
+			for j, arg := range args {
+				tvals[j] = resp.StringValue(arg)
+			}
+			vals[i] = resp.ArrayValue(tvals)
+		}
+		return resp.ArrayValue(vals), nil
+	}
+	return NOMessage, nil
+}
+
+// triggerWrite returns true for the writes that call triggers.
+func triggerWrite(command string) bool {
+	return command == "set" || command == "del"
+}
+
+// triggerEvent is the write that a trigger is called for.
+type triggerEvent struct {
+	command string
+	key, id string
+	obj     geojson.Object
+	fields  map[string]field.Value
+}
+
+// table returns the event as a lua table.
+func (ev *triggerEvent) table(L *lua.LState, t *trigger) *lua.LTable {
+	tbl := L.CreateTable(0, 6)
+	tbl.RawSetString("trigger", lua.LString(t.name))
+	tbl.RawSetString("command", lua.LString(ev.command))
+	tbl.RawSetString("key", lua.LString(ev.key))
+	tbl.RawSetString("id", lua.LString(ev.id))
+	if ev.obj != nil {
+		tbl.RawSetString("object", lua.LString(ev.obj.String()))
+	}
+	fields := L.CreateTable(0, len(ev.fields))
+	for name, value := range ev.fields {
+		if value.IsZero() {
+			continue
+		}
+		if value.IsNumeric() {
+			fields.RawSetString(name, lua.LNumber(value.Num()))
+		} else {
+			fields.RawSetString(name, lua.LString(value.String()))
+		}
+	}
+	tbl.RawSetString("fields", fields)
+	return tbl
+}
+
+// triggerResult is what the function of a trigger returned before a write.
+type triggerResult struct {
+	rejected bool
+	err      string
+	fields   [][2]string // fields to set
+	object   string      // object to set, when not empty
+}
+
+// callTrigger calls the function of a trigger for an event. The caller must
+// hold the server lock.
+func (s *Server) callTrigger(t *trigger, ev *triggerEvent) (
+	tr triggerResult, err error,
+) {
+	f := s.functions[t.function]
+	if f == nil {
+		return tr, errFunctionNotFound
+	}
+	L, err := s.luapool.Get()
+	if err != nil {
+		return tr, err
+	}
+	defer s.luapool.Put(L)
+	registry, err := f.lib.register(L)
+	if err != nil {
+		return tr, err
+	}
+	entry, ok := registry.RawGetString(f.name).(*lua.LTable)
+	if !ok {
+		return tr, errFunctionNotFound
+	}
+	evalCmd := "eval"
+	if f.readonly {
+		evalCmd = "evalro"
+	}
+	luaSetRawGlobals(L, map[string]lua.LValue{
+		"EVAL_CMD": lua.LString(evalCmd),
+	})
+	defer luaSetRawGlobals(L, map[string]lua.LValue{
+		"EVAL_CMD": lua.LNil,
+	})
+	L.Push(entry.RawGetString("callback"))
+	L.Push(ev.table(L, t))
+	if err := L.PCall(1, 1, nil); err != nil {
+		return tr, makeSafeErr(err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	switch ret := ret.(type) {
+	case lua.LBool:
+		tr.rejected = !bool(ret)
+	case *lua.LTable:
+		if v := ret.RawGetString("err"); v != lua.LNil {
+			tr.rejected, tr.err = true, v.String()
+			break
+		}
+		if fields, ok := ret.RawGetString("fields").(*lua.LTable); ok {
+			fields.ForEach(func(k, v lua.LValue) {
+				tr.fields = append(tr.fields, [2]string{k.String(), v.String()})
+			})
+			// sort the fields for consistency
+			sort.Slice(tr.fields, func(i, j int) bool {
+				return tr.fields[i][0] < tr.fields[j][0]
+			})
+		}
+		if v := ret.RawGetString("object"); v != lua.LNil {
+			tr.object = v.String()
+		}
+	}
+	return tr, nil
+}
+
+// beforeTriggers calls the triggers before a SET or DEL from a client, and
+// returns the write with the changes of the triggers. The caller must hold
+// the server lock.
+func (s *Server) beforeTriggers(msg *Message) (*Message, error) {
+	if len(s.triggers) == 0 || len(msg.Args) < 3 {
+		return msg, nil
+	}
+	command := msg.Command()
+	if !triggerWrite(command) {
+		return msg, nil
+	}
+	for _, t := range s.sortedTriggers(command, msg.Args[1], true) {
+		ev := triggerEvent{command: command}
+		var evs []string
+		if command == "set" {
+			d, fields, values, _, _, _, _, _, nevs, err := s.parseSetArgs(msg.Args[1:])
+			if err != nil {
+				// the error is returned by the SET
+				return msg, nil
+			}
+			ev.key, ev.id, ev.obj, evs = d.key, d.id, d.obj, nevs
+			ev.fields = make(map[string]field.Value, len(fields))
+			for i, name := range fields {
+				ev.fields[name] = values[i]
+			}
+		} else {
+			ev.key, ev.id = msg.Args[1], msg.Args[2]
+			if col := s.getCol(ev.key); col != nil {
+				var values []field.Value
+				ev.obj, values, _ = col.Get(ev.id)
+				ev.fields = make(map[string]field.Value)
+				for name, idx := range col.FieldMap() {
+					if idx < len(values) {
+						ev.fields[name] = values[idx]
+					}
+				}
+			}
+		}
+		tr, err := s.callTrigger(t, &ev)
+		if err != nil {
+			return msg, clientErrorf("trigger '%s': %v", t.name, err)
+		}
+		if tr.rejected {
+			if tr.err != "" {
+				return msg, errors.New(tr.err)
+			}
+			return msg, clientErrorf("rejected by trigger '%s'", t.name)
+		}
+		if command != "set" || (len(tr.fields) == 0 && tr.object == "") {
+			continue
+		}
+		// The fields go after those of the SET, which they replace, and the
+		// object replaces the object of the SET.
+		i := len(msg.Args) - len(evs) - 1
+		args := make([]string, 0, len(msg.Args)+len(tr.fields)*3+2)
+		args = append(args, msg.Args[:i]...)
+		for _, fv := range tr.fields {
+			args = append(args, "field", fv[0], fv[1])
+		}
+		if tr.object != "" {
+			args = append(args, "object", tr.object)
+		} else {
+			args = append(args, msg.Args[i:]...)
+		}
+		nmsg := *msg
+		nmsg.Args = args
+		msg = &nmsg
+	}
+	return msg, nil
+}
+
+// afterTriggers calls the triggers after a SET or DEL from a client was
+// applied. The caller must hold the server lock.
+func (s *Server) afterTriggers(d *commandDetails) {
+	if len(s.triggers) == 0 || !d.updated || !triggerWrite(d.command) {
+		return
+	}
+	for _, t := range s.sortedTriggers(d.command, d.key, false) {
+		ev := triggerEvent{command: d.command, key: d.key, id: d.id, obj: d.obj}
+		ev.fields = make(map[string]field.Value, len(d.fmap))
+		for name, idx := range d.fmap {
+			if idx < len(d.fields) {
+				ev.fields[name] = d.fields[idx]
+			}
+		}
+		if _, err := s.callTrigger(t, &ev); err != nil {
+			log.Errorf("trigger '%s': %v", t.name, err)
+		}
+	}
+}
+
+// sortedTriggers returns the triggers for a command on a key, ordered by
+// name.
+func (s *Server) sortedTriggers(command, key string, before bool) []*trigger {
+	var ts []*trigger
+	for _, t := range s.triggers {
+		if t.before == before && t.matches(command, key) {
+			ts = append(ts, t)
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].name < ts[j].name
+	})
+	return ts
+}
+
+// pruneTriggers removes the triggers of the functions that were deleted. The
+// caller must hold the server lock.
+func (s *Server) pruneTriggers() {
+	for name, t := range s.triggers {
+		if s.functions[t.function] == nil {
+			delete(s.triggers, name)
+		}
+	}
+}
+
+// triggerCommands returns the commands needed to recreate the triggers. The
+// caller must hold the server lock.
+func (s *Server) triggerCommands() (cmds [][]string) {
+	var names []string
+	for name := range s.triggers {
+		names = append(names, name)
+	}
+	// sort the names for consistency
+	sort.Strings(names)
+	for _, name := range names {
+		cmds = append(cmds,
+			append([]string{"settrigger", name}, s.triggers[name].args()...))
+	}
+	return cmds
+}
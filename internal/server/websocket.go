@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"strings"
+	"sync"
+)
+
+// webSocketOptions are the extension and subprotocol of a WebSocket
+// connection, which are negotiated by the upgrade request.
+type webSocketOptions struct {
+	deflate bool // permessage-deflate compression of the messages
+	msgpack bool // binary MessagePack frames instead of json text frames
+}
+
+// webSocketMinDeflate is the size of the smallest message that is
+// compressed, because the smaller messages do not get smaller.
+const webSocketMinDeflate = 128
+
+// negotiateWebSocket returns the options of the offered extensions and
+// subprotocols, and the headers of the accepted ones. The permessage-deflate
+// messages have no context takeover, so that every message is compressed on
+// its own. The msgpack subprotocol has binary MessagePack frames.
+func negotiateWebSocket(extensions, protocols string) (
+	opts webSocketOptions, headers string,
+) {
+	for _, offer := range strings.Split(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for _, param := range params[1:] {
+			name, value := strings.TrimSpace(param), ""
+			if i := strings.IndexByte(name, '='); i != -1 {
+				name, value = strings.TrimSpace(name[:i]),
+					strings.Trim(strings.TrimSpace(name[i+1:]), `"`)
+			}
+			switch name {
+			case "server_no_context_takeover", "client_no_context_takeover",
+				"client_max_window_bits":
+			case "server_max_window_bits":
+				// the compressor has a window of 32K
+				ok = ok && value == "15"
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			opts.deflate = true
+			headers += "Sec-WebSocket-Extensions: permessage-deflate; " +
+				"server_no_context_takeover; client_no_context_takeover\r\n"
+			break
+		}
+	}
+	for _, protocol := range strings.Split(protocols, ",") {
+		switch protocol = strings.TrimSpace(protocol); protocol {
+		case "msgpack", "json":
+			opts.msgpack = protocol == "msgpack"
+			headers += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+		default:
+			continue
+		}
+		break
+	}
+	return opts, headers
+}
+
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// writeWebSocketMessage writes a message of json to a WebSocket, which is
+// a text frame, or a binary frame of MessagePack.
+func writeWebSocketMessage(w io.Writer, data []byte, opts webSocketOptions,
+) error {
+	head := byte(0x81) // FIN + TEXT
+	if opts.msgpack {
+		head = 0x82 // FIN + BINARY
+		data = msgpackOfJSON(data)
+	}
+	if opts.deflate && len(data) >= webSocketMinDeflate {
+		var buf bytes.Buffer
+		fw := flateWriters.Get().(*flate.Writer)
+		fw.Reset(&buf)
+		fw.Write(data)
+		fw.Flush()
+		flateWriters.Put(fw)
+		// the empty block of the flush is removed
+		data = bytes.TrimSuffix(buf.Bytes(), []byte{0, 0, 0xff, 0xff})
+		head |= 0x40 // RSV1
+	}
+	var msg []byte
+	buf := make([]byte, 10+len(data))
+	buf[0] = head
+	if len(data) <= 125 {
+		buf[1] = byte(len(data))
+		copy(buf[2:], data)
+		msg = buf[:2+len(data)]
+	} else if len(data) <= 0xFFFF {
+		buf[1] = 126
+		binary.BigEndian.PutUint16(buf[2:], uint16(len(data)))
+		copy(buf[4:], data)
+		msg = buf[:4+len(data)]
+	} else {
+		buf[1] = 127
+		binary.BigEndian.PutUint64(buf[2:], uint64(len(data)))
+		copy(buf[10:], data)
+		msg = buf[:10+len(data)]
+	}
+	_, err := w.Write(msg)
+	return err
+}
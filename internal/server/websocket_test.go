@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateWebSocket(t *testing.T) {
+	for _, tc := range []struct {
+		extensions, protocols string
+		opts                  webSocketOptions
+	}{
+		{"", "", webSocketOptions{}},
+		{"permessage-deflate; client_max_window_bits", "",
+			webSocketOptions{deflate: true}},
+		{"x-webkit-deflate-frame, permessage-deflate", "json, msgpack",
+			webSocketOptions{deflate: true}},
+		{"permessage-deflate; server_max_window_bits=10", "msgpack",
+			webSocketOptions{msgpack: true}},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate",
+			"chat, msgpack", webSocketOptions{deflate: true, msgpack: true}},
+	} {
+		opts, headers := negotiateWebSocket(tc.extensions, tc.protocols)
+		if opts != tc.opts {
+			t.Fatalf("%q %q: expected %+v, got %+v", tc.extensions,
+				tc.protocols, tc.opts, opts)
+		}
+		if opts.deflate != strings.Contains(headers, "permessage-deflate") {
+			t.Fatalf("unexpected headers: %q", headers)
+		}
+	}
+}
+
+func TestWriteWebSocketMessage(t *testing.T) {
+	var buf bytes.Buffer
+	data := []byte(`{"ok":true}`)
+	if err := writeWebSocketMessage(&buf, data, webSocketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), append([]byte{0x81, byte(len(data))}, data...)) {
+		t.Fatalf("unexpected frame: %x", buf.Bytes())
+	}
+	// the large messages are compressed
+	buf.Reset()
+	data = []byte(`{"ok":true,"id":"` + strings.Repeat("truck", 100) + `"}`)
+	opts := webSocketOptions{deflate: true}
+	if err := writeWebSocketMessage(&buf, data, opts); err != nil {
+		t.Fatal(err)
+	}
+	frame := buf.Bytes()
+	if frame[0] != 0xc1 || int(frame[1]) != len(frame)-2 {
+		t.Fatalf("unexpected frame head: %x", frame[:2])
+	}
+	payload := append(frame[2:], 0, 0, 0xff, 0xff)
+	res, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(payload)))
+	if err != nil && len(res) == 0 {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, data) {
+		t.Fatalf("expected %s, got %s", data, res)
+	}
+	buf.Reset()
+	opts = webSocketOptions{msgpack: true}
+	if err := writeWebSocketMessage(&buf, []byte(`{"ok":true}`), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{0x82, 5, 0x81, 0xa2, 'o', 'k', 0xc3}) {
+		t.Fatalf("unexpected frame: %x", buf.Bytes())
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"math"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/gjson"
+)
+
+// objectZRange returns the lowest and highest altitudes of an object, which
+// are the Z coordinates of its positions. Positions without a Z coordinate
+// are considered to be at ground level.
+func objectZRange(obj geojson.Object) (zmin, zmax float64) {
+	switch obj := obj.(type) {
+	case *geojson.Point:
+		return obj.Z(), obj.Z()
+	case *geojson.SimplePoint:
+		return 0, 0
+	}
+	if !objIsSpatial(obj) {
+		return 0, 0
+	}
+	zmin, zmax = math.Inf(1), math.Inf(-1)
+	var walk func(v gjson.Result)
+	walk = func(v gjson.Result) {
+		if v.IsObject() {
+			for _, key := range []string{
+				"coordinates", "geometry", "geometries", "features",
+			} {
+				walk(v.Get(key))
+			}
+			return
+		}
+		if !v.IsArray() {
+			return
+		}
+		arr := v.Array()
+		if len(arr) > 0 && arr[0].Type == gjson.Number {
+			// a position
+			var z float64
+			if len(arr) > 2 {
+				z = arr[2].Float()
+			}
+			zmin, zmax = math.Min(zmin, z), math.Max(zmax, z)
+			return
+		}
+		for _, child := range arr {
+			walk(child)
+		}
+	}
+	walk(gjson.Parse(obj.JSON()))
+	if zmin > zmax {
+		return 0, 0
+	}
+	return zmin, zmax
+}
+
+// zrangeMatch returns true when the vertical span of the objects overlaps
+// the altitude range of zmin to zmax. A nil object is ignored.
+func zrangeMatch(zmin, zmax float64, objs ...geojson.Object) bool {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, obj := range objs {
+		if obj != nil {
+			omin, omax := objectZRange(obj)
+			lo, hi = math.Min(lo, omin), math.Max(hi, omax)
+		}
+	}
+	return lo <= zmax && hi >= zmin
+}
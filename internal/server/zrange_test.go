@@ -0,0 +1,32 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/tidwall/geojson"
+)
+
+func TestObjectZRange(t *testing.T) {
+	for _, tc := range []struct {
+		js         string
+		zmin, zmax float64
+	}{
+		{`{"type":"Point","coordinates":[1,2,30]}`, 30, 30},
+		{`{"type":"Point","coordinates":[1,2]}`, 0, 0},
+		{`{"type":"LineString","coordinates":[[1,2,30],[3,4,-5]]}`, -5, 30},
+		{`{"type":"FeatureCollection","features":[` +
+			`{"type":"Feature","geometry":{"type":"Point","coordinates":[1,2,7]},` +
+			`"properties":{"coordinates":[0,0,99]}},` +
+			`{"type":"Feature","geometry":{"type":"Polygon","coordinates":` +
+			`[[[0,0,10],[1,0,12],[1,1,11],[0,0,10]]]},"properties":{}}]}`, 7, 12},
+	} {
+		obj, err := geojson.Parse(tc.js, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if zmin, zmax := objectZRange(obj); zmin != tc.zmin || zmax != tc.zmax {
+			t.Fatalf("%s: expected %v %v, got %v %v", tc.js, tc.zmin, tc.zmax,
+				zmin, zmax)
+		}
+	}
+}
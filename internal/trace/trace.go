@@ -0,0 +1,346 @@
+// Package trace records the spans of the commands and of the messages sent
+// to the endpoints, and exports them to an OpenTelemetry collector with
+// OTLP over HTTP.
+package trace
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tidwall/tile38/internal/log"
+)
+
+// maxSpans is the number of ended spans that are held for the next export.
+// The spans that end while the buffer is full are dropped.
+const maxSpans = 4096
+
+// exportInterval is how often the ended spans are exported.
+const exportInterval = time.Second
+
+// Kind is the kind of a span, which has the values of OTLP.
+type Kind int
+
+const (
+	// KindInternal is an operation of the server.
+	KindInternal Kind = 1
+	// KindServer is a command of a client.
+	KindServer Kind = 2
+	// KindClient is a request to another service, such as an endpoint.
+	KindClient Kind = 3
+)
+
+// SpanContext is the identity of a span, which is propagated with the
+// W3C traceparent.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid returns true when the trace and the span are not zero.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent returns the W3C traceparent of the span.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" +
+		hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a W3C traceparent, which is
+// version-traceid-parentid-flags in lowercase hex.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, errInvalidTraceparent
+	}
+	if s[:2] == "ff" || (s[:2] == "00" && len(s) != 55) ||
+		(len(s) > 55 && s[55] != '-') {
+		return sc, errInvalidTraceparent
+	}
+	var version, flags [1]byte
+	if !decodeHex(version[:], s[:2]) ||
+		!decodeHex(sc.TraceID[:], s[3:35]) ||
+		!decodeHex(sc.SpanID[:], s[36:52]) ||
+		!decodeHex(flags[:], s[53:55]) {
+		return sc, errInvalidTraceparent
+	}
+	if !sc.IsValid() {
+		return sc, errInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// decodeHex decodes lowercase hex, which is the only hex of a traceparent.
+func decodeHex(dst []byte, s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// attr is an attribute of a span, which is a string, an int64, or a bool.
+type attr struct {
+	key   string
+	value interface{}
+}
+
+// Span is an operation that is exported when it ends. The methods of a nil
+// span do nothing, which is the span of a tracer that is not enabled.
+type Span struct {
+	tracer *Tracer
+	ctx    SpanContext
+	parent [8]byte
+	name   string
+	kind   Kind
+	start  time.Time
+	end    time.Time
+	attrs  []attr
+	err    string
+}
+
+// Context returns the identity of the span, which is not valid for a nil
+// span.
+func (sp *Span) Context() SpanContext {
+	if sp == nil {
+		return SpanContext{}
+	}
+	return sp.ctx
+}
+
+// SetAttr sets an attribute, which is a string, an int, an int64, or a
+// bool.
+func (sp *Span) SetAttr(key string, value interface{}) {
+	if sp == nil {
+		return
+	}
+	if n, ok := value.(int); ok {
+		value = int64(n)
+	}
+	sp.attrs = append(sp.attrs, attr{key, value})
+}
+
+// SetError sets the status of the span to an error.
+func (sp *Span) SetError(msg string) {
+	if sp == nil {
+		return
+	}
+	sp.err = msg
+}
+
+// End ends the span, which is then exported.
+func (sp *Span) End() {
+	if sp == nil {
+		return
+	}
+	sp.end = time.Now()
+	sp.tracer.add(sp)
+}
+
+// Tracer starts the spans and exports them to the url of a collector.
+type Tracer struct {
+	service string
+	version string
+	url     func() string // the OTLP/HTTP url of the traces, empty when off
+	client  *http.Client
+
+	mu      sync.Mutex
+	spans   []*Span
+	dropped uint64
+}
+
+// NewTracer returns a tracer of a service. The url is read for each span,
+// and the tracer is not enabled while the url is empty.
+func NewTracer(service, version string, url func() string) *Tracer {
+	t := &Tracer{
+		service: service,
+		version: version,
+		url:     url,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	go t.Run()
+	return t
+}
+
+// Enabled returns true when the spans are exported.
+func (t *Tracer) Enabled() bool {
+	return t != nil && t.url() != ""
+}
+
+// Start starts a span, which is a child of the parent when the parent is
+// valid. It returns nil when the tracer is not enabled, or when the parent
+// is not sampled.
+func (t *Tracer) Start(name string, kind Kind, parent SpanContext) *Span {
+	if !t.Enabled() || (parent.IsValid() && !parent.Sampled) {
+		return nil
+	}
+	sp := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+	if parent.IsValid() {
+		sp.ctx.TraceID = parent.TraceID
+		sp.parent = parent.SpanID
+	} else {
+		rand.Read(sp.ctx.TraceID[:])
+	}
+	rand.Read(sp.ctx.SpanID[:])
+	sp.ctx.Sampled = true
+	return sp
+}
+
+// Dropped returns the number of spans that were dropped because the buffer
+// was full.
+func (t *Tracer) Dropped() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dropped
+}
+
+func (t *Tracer) add(sp *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) >= maxSpans {
+		t.dropped++
+		return
+	}
+	t.spans = append(t.spans, sp)
+}
+
+// Run exports the ended spans every second.
+func (t *Tracer) Run() {
+	for {
+		time.Sleep(exportInterval)
+		if err := t.Export(); err != nil {
+			log.Debugf("trace export: %v", err)
+		}
+	}
+}
+
+// Export sends the ended spans to the collector. The spans are dropped
+// when the collector fails.
+func (t *Tracer) Export() error {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	url := t.url()
+	if len(spans) == 0 || url == "" {
+		return nil
+	}
+	body := t.appendRequest(nil, spans)
+	resp, err := t.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("collector returned " + resp.Status)
+	}
+	return nil
+}
+
+// appendRequest appends the JSON of an OTLP export request of the spans.
+func (t *Tracer) appendRequest(dst []byte, spans []*Span) []byte {
+	dst = append(dst, `{"resourceSpans":[{"resource":{"attributes":[`...)
+	dst = appendAttr(dst, attr{"service.name", t.service})
+	dst = append(dst, `]},"scopeSpans":[{"scope":{"name":`...)
+	dst = appendString(dst, t.service)
+	dst = append(dst, `,"version":`...)
+	dst = appendString(dst, t.version)
+	dst = append(dst, `},"spans":[`...)
+	for i, sp := range spans {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendSpan(dst, sp)
+	}
+	return append(dst, `]}]}]}`...)
+}
+
+func appendSpan(dst []byte, sp *Span) []byte {
+	dst = append(dst, `{"traceId":"`...)
+	dst = append(dst, hex.EncodeToString(sp.ctx.TraceID[:])...)
+	dst = append(dst, `","spanId":"`...)
+	dst = append(dst, hex.EncodeToString(sp.ctx.SpanID[:])...)
+	dst = append(dst, '"')
+	if sp.parent != [8]byte{} {
+		dst = append(dst, `,"parentSpanId":"`...)
+		dst = append(dst, hex.EncodeToString(sp.parent[:])...)
+		dst = append(dst, '"')
+	}
+	dst = append(dst, `,"name":`...)
+	dst = appendString(dst, sp.name)
+	dst = append(dst, `,"kind":`...)
+	dst = strconv.AppendInt(dst, int64(sp.kind), 10)
+	dst = append(dst, `,"startTimeUnixNano":"`...)
+	dst = strconv.AppendInt(dst, sp.start.UnixNano(), 10)
+	dst = append(dst, `","endTimeUnixNano":"`...)
+	dst = strconv.AppendInt(dst, sp.end.UnixNano(), 10)
+	dst = append(dst, `","attributes":[`...)
+	for i, a := range sp.attrs {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendAttr(dst, a)
+	}
+	dst = append(dst, ']')
+	if sp.err != "" {
+		dst = append(dst, `,"status":{"code":2,"message":`...)
+		dst = appendString(dst, sp.err)
+		dst = append(dst, '}')
+	}
+	return append(dst, '}')
+}
+
+func appendAttr(dst []byte, a attr) []byte {
+	dst = append(dst, `{"key":`...)
+	dst = appendString(dst, a.key)
+	dst = append(dst, `,"value":{`...)
+	switch v := a.value.(type) {
+	case int64:
+		// the int64 of OTLP/JSON is a string
+		dst = append(dst, `"intValue":"`...)
+		dst = strconv.AppendInt(dst, v, 10)
+		dst = append(dst, '"')
+	case bool:
+		dst = append(dst, `"boolValue":`...)
+		dst = strconv.AppendBool(dst, v)
+	case string:
+		dst = append(dst, `"stringValue":`...)
+		dst = appendString(dst, v)
+	}
+	return append(dst, `}}`...)
+}
+
+func appendString(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] == '\\' || s[i] == '"' || s[i] > 126 {
+			d, _ := json.Marshal(s)
+			return append(dst, d...)
+		}
+	}
+	dst = append(dst, '"')
+	dst = append(dst, s...)
+	return append(dst, '"')
+}
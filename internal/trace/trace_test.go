@@ -0,0 +1,118 @@
+package trace
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestTraceparent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled || sc.Traceparent() != tp {
+		t.Fatalf("expected %s, got %s", tp, sc.Traceparent())
+	}
+	for _, tp := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x",
+	} {
+		if _, err := ParseTraceparent(tp); err == nil {
+			t.Fatalf("expected an error for '%s'", tp)
+		}
+	}
+	// a later version may have more fields
+	if _, err := ParseTraceparent(
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-x",
+	); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOff(t *testing.T) {
+	var nilTracer *Tracer
+	tracer := &Tracer{url: func() string { return "" }}
+	for _, tracer := range []*Tracer{nilTracer, tracer} {
+		span := tracer.Start("get", KindServer, SpanContext{})
+		if span != nil {
+			t.Fatal("expected no span")
+		}
+		span.SetAttr("db.system", "tile38")
+		span.SetError("nope")
+		span.End()
+		if span.Context().IsValid() {
+			t.Fatal("expected an invalid context")
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ = ioutil.ReadAll(r.Body)
+		},
+	))
+	defer ts.Close()
+	tracer := &Tracer{
+		service: "tile38",
+		version: "0.0.0",
+		url:     func() string { return ts.URL },
+		client:  http.DefaultClient,
+	}
+	parent, _ := ParseTraceparent(
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	cmd := tracer.Start("set", KindServer, parent)
+	cmd.SetAttr("tile38.client.id", 7)
+	cmd.SetAttr("db.operation", "set \"x\"\n")
+	cmd.End()
+	send := tracer.Start("send http", KindClient, cmd.Context())
+	send.SetError("refused")
+	send.End()
+	if tracer.Start("get", KindServer, SpanContext{
+		TraceID: parent.TraceID, SpanID: parent.SpanID,
+	}) != nil {
+		t.Fatal("expected no span for a parent that is not sampled")
+	}
+	if err := tracer.Export(); err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(body) {
+		t.Fatalf("invalid json: %s", body)
+	}
+	spans := gjson.GetBytes(body, "resourceSpans.0.scopeSpans.0.spans").Array()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %s", body)
+	}
+	if s := spans[0].Get("traceId").String(); s != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the trace of the parent, got %s", s)
+	}
+	if s := spans[0].Get("parentSpanId").String(); s != "00f067aa0ba902b7" {
+		t.Fatalf("expected the parent, got %s", s)
+	}
+	if s := spans[0].Get(`attributes.#(key=="tile38.client.id").value.intValue`).String(); s != "7" {
+		t.Fatalf("expected 7, got %s", s)
+	}
+	if s := spans[1].Get("parentSpanId").String(); s != cmd.Context().Traceparent()[36:52] {
+		t.Fatalf("expected the set as the parent, got %s", s)
+	}
+	if s := spans[1].Get("status.message").String(); s != "refused" {
+		t.Fatalf("expected refused, got %s", s)
+	}
+	// the spans are exported once
+	body = nil
+	if err := tracer.Export(); err != nil || body != nil {
+		t.Fatalf("expected no export, got %s", body)
+	}
+}
@@ -0,0 +1,342 @@
+// Package engine embeds Tile38 in a Go program. An Engine is the
+// collections, spatial queries, geofences, and hook endpoints of a server
+// without its network server, so that it runs in the process of the program.
+//
+// The commands of an Engine are the commands of the server, and the typed
+// methods are for the common ones. The other commands, such as SETHOOK or
+// JGET, are run with Do.
+//
+// A program has one Engine at a time, because the files of the engine are
+// the options of the core package.
+package engine
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/core"
+	"github.com/tidwall/tile38/internal/server"
+)
+
+// ErrNotFound is the error of a key or an id that does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Options are the options of Open.
+type Options struct {
+	// InMemory does not persist the collections, which are lost when the
+	// engine is closed. The directory still has the config and the queue of
+	// the hooks.
+	InMemory bool
+}
+
+// Engine is an embedded Tile38.
+type Engine struct {
+	s *server.Server
+}
+
+// Field is a field of an object. The value is the text of the FIELD
+// argument, which is a number, a string, true or false, or a json object or
+// array.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Object is an object of a collection. The object is GeoJSON, or the json
+// string of a string object.
+type Object struct {
+	ID       string
+	Object   string
+	Fields   []Field
+	Distance float64 // the meters from the point of Nearby
+}
+
+// Where is a WHERE filter of a search, for the values of a field from min to
+// max.
+type Where struct {
+	Field    string
+	Min, Max float64
+}
+
+// SearchOptions are the options of a search. The cursor is the start of a
+// page, and a limit of zero is the default limit of the server.
+type SearchOptions struct {
+	Cursor uint64
+	Limit  uint64
+	Match  string // a pattern of the ids
+	Where  []Where
+	Desc   bool // the ids are descending, for Scan
+}
+
+// SearchResult is the result of a search, where the cursor is the start of
+// the next page, or zero for the last page.
+type SearchResult struct {
+	Objects []Object
+	Cursor  uint64
+}
+
+// Event is an event of a geofence channel. The json is the message of the
+// event, which is only a string for a message of PUBLISH.
+type Event struct {
+	Channel string
+	Command string
+	Detect  string
+	Key     string
+	ID      string
+	Time    string
+	Object  string
+	JSON    string
+}
+
+// Open opens the engine of a directory, which is created when it does not
+// exist.
+func Open(dir string, opts *Options) (*Engine, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	core.AppendOnly = !opts.InMemory
+	core.AppendFileName = filepath.Join(dir, "appendonly.aof")
+	core.KVFileName = filepath.Join(dir, "kv.db")
+	core.QueueFileName = filepath.Join(dir, "queue.db")
+	core.SnapshotFileName = filepath.Join(dir, "snapshot.db")
+	s, err := server.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{s: s}, nil
+}
+
+// Close closes the engine.
+func (e *Engine) Close() error {
+	return e.s.Close()
+}
+
+// Do runs a command and returns its json reply. The error of the command is
+// an error, which is ErrNotFound for a key or an id that does not exist.
+func (e *Engine) Do(args ...string) (string, error) {
+	out, err := e.s.Do(args...)
+	if err != nil {
+		return "", err
+	}
+	res := gjson.ParseBytes(out)
+	if !res.Get("ok").Bool() {
+		switch errMsg := res.Get("err").String(); errMsg {
+		case "key not found", "id not found":
+			return "", ErrNotFound
+		default:
+			return "", errors.New(errMsg)
+		}
+	}
+	return res.Raw, nil
+}
+
+// Set sets an object, which is GeoJSON.
+func (e *Engine) Set(key, id, object string, fields ...Field) error {
+	args := appendFields([]string{"SET", key, id}, fields)
+	_, err := e.Do(append(args, "OBJECT", object)...)
+	return err
+}
+
+// SetPoint sets a point.
+func (e *Engine) SetPoint(key, id string, lat, lon float64, fields ...Field,
+) error {
+	args := appendFields([]string{"SET", key, id}, fields)
+	_, err := e.Do(append(args, "POINT", formatFloat(lat),
+		formatFloat(lon))...)
+	return err
+}
+
+// Get returns an object and its fields.
+func (e *Engine) Get(key, id string) (*Object, error) {
+	reply, err := e.Do("GET", key, id, "WITHFIELDS", "OBJECT")
+	if err != nil {
+		return nil, err
+	}
+	res := gjson.Parse(reply)
+	obj := &Object{ID: id, Object: res.Get("object").Raw}
+	res.Get("fields").ForEach(func(name, value gjson.Result) bool {
+		obj.Fields = append(obj.Fields, Field{
+			Name:  name.String(),
+			Value: fieldText(value),
+		})
+		return true
+	})
+	return obj, nil
+}
+
+// Del deletes an object.
+func (e *Engine) Del(key, id string) error {
+	_, err := e.Do("DEL", key, id)
+	return err
+}
+
+// Drop deletes a collection.
+func (e *Engine) Drop(key string) error {
+	_, err := e.Do("DROP", key)
+	return err
+}
+
+// Keys returns the keys of the collections that match a pattern.
+func (e *Engine) Keys(pattern string) ([]string, error) {
+	reply, err := e.Do("KEYS", pattern)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	gjson.Get(reply, "keys").ForEach(func(_, key gjson.Result) bool {
+		keys = append(keys, key.String())
+		return true
+	})
+	return keys, nil
+}
+
+// Nearby searches for the objects within meters of a point, which are
+// ordered by their distance.
+func (e *Engine) Nearby(key string, lat, lon, meters float64,
+	opts *SearchOptions,
+) (*SearchResult, error) {
+	args := appendSearchOptions([]string{"NEARBY", key}, opts)
+	return e.search(append(args, "DISTANCE", "POINT", formatFloat(lat),
+		formatFloat(lon), formatFloat(meters)))
+}
+
+// Within searches for the objects within an area, which is GeoJSON.
+func (e *Engine) Within(key, area string, opts *SearchOptions,
+) (*SearchResult, error) {
+	args := appendSearchOptions([]string{"WITHIN", key}, opts)
+	return e.search(append(args, "OBJECT", area))
+}
+
+// Intersects searches for the objects that intersect an area, which is
+// GeoJSON.
+func (e *Engine) Intersects(key, area string, opts *SearchOptions,
+) (*SearchResult, error) {
+	args := appendSearchOptions([]string{"INTERSECTS", key}, opts)
+	return e.search(append(args, "OBJECT", area))
+}
+
+// Scan iterates the objects of a collection, which are ordered by their ids.
+func (e *Engine) Scan(key string, opts *SearchOptions) (*SearchResult, error) {
+	return e.search(appendSearchOptions([]string{"SCAN", key}, opts))
+}
+
+// search runs a search, where the fields of the objects are in the order of
+// the fields of the reply.
+func (e *Engine) search(args []string) (*SearchResult, error) {
+	reply, err := e.Do(args...)
+	if err != nil {
+		return nil, err
+	}
+	res := gjson.Parse(reply)
+	names := res.Get("fields").Array()
+	result := &SearchResult{Cursor: res.Get("cursor").Uint()}
+	res.Get("objects").ForEach(func(_, o gjson.Result) bool {
+		obj := Object{
+			ID:       o.Get("id").String(),
+			Object:   o.Get("object").Raw,
+			Distance: o.Get("distance").Float(),
+		}
+		for i, value := range o.Get("fields").Array() {
+			if i < len(names) {
+				obj.Fields = append(obj.Fields, Field{
+					Name:  names[i].String(),
+					Value: fieldText(value),
+				})
+			}
+		}
+		result.Objects = append(result.Objects, obj)
+		return true
+	})
+	return result, nil
+}
+
+// SetChan sets a geofence channel, where the args are the search of the
+// fence, such as NEARBY fleet FENCE POINT 33 -115 1000.
+func (e *Engine) SetChan(name string, args ...string) error {
+	_, err := e.Do(append([]string{"SETCHAN", name}, args...)...)
+	return err
+}
+
+// DelChan deletes a geofence channel.
+func (e *Engine) DelChan(name string) error {
+	_, err := e.Do("DELCHAN", name)
+	return err
+}
+
+// Subscribe calls fn with the events of the channels, which are the patterns
+// of PSUBSCRIBE when pattern is true, until the context is done or fn
+// returns an error.
+func (e *Engine) Subscribe(ctx context.Context, channels []string,
+	pattern bool, fn func(ev Event) error,
+) error {
+	return e.s.Subscribe(ctx, channels, pattern,
+		func(channel, message string) error {
+			ev := Event{Channel: channel, JSON: message}
+			if gjson.Valid(message) {
+				res := gjson.Parse(message)
+				ev.Command = res.Get("command").String()
+				ev.Detect = res.Get("detect").String()
+				ev.Key = res.Get("key").String()
+				ev.ID = res.Get("id").String()
+				ev.Time = res.Get("time").String()
+				ev.Object = res.Get("object").Raw
+			}
+			return fn(ev)
+		})
+}
+
+// Send sends a message to an endpoint, which is a url of a hook, such as
+// http://localhost:8080/events or kafka://localhost:9092/events.
+func (e *Engine) Send(endpoint, msg string) error {
+	return e.s.SendEndpoint(endpoint, msg)
+}
+
+// ValidateEndpoint returns an error for an endpoint that is not valid.
+func (e *Engine) ValidateEndpoint(endpoint string) error {
+	return e.s.ValidateEndpoint(endpoint)
+}
+
+func appendFields(args []string, fields []Field) []string {
+	for _, f := range fields {
+		args = append(args, "FIELD", f.Name, f.Value)
+	}
+	return args
+}
+
+func appendSearchOptions(args []string, opts *SearchOptions) []string {
+	if opts == nil {
+		return args
+	}
+	if opts.Cursor != 0 {
+		args = append(args, "CURSOR", strconv.FormatUint(opts.Cursor, 10))
+	}
+	if opts.Limit != 0 {
+		args = append(args, "LIMIT", strconv.FormatUint(opts.Limit, 10))
+	}
+	if opts.Match != "" {
+		args = append(args, "MATCH", opts.Match)
+	}
+	for _, w := range opts.Where {
+		args = append(args, "WHERE", w.Field, formatFloat(w.Min),
+			formatFloat(w.Max))
+	}
+	if opts.Desc {
+		args = append(args, "DESC")
+	}
+	return args
+}
+
+// fieldText returns the text of the FIELD argument of a json value.
+func fieldText(v gjson.Result) string {
+	if v.Type == gjson.String {
+		return v.String()
+	}
+	return v.Raw
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
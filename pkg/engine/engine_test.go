@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "engine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetPoint("fleet", "truck1", 33, -115,
+		Field{"speed", "90"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Set("fleet", "truck2",
+		`{"type":"Point","coordinates":[-115.001,33.001]}`); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := e.Get("fleet", "truck1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obj.Fields) != 1 || obj.Fields[0].Value != "90" {
+		t.Fatalf("unexpected object: %+v", obj)
+	}
+	if _, err := e.Get("fleet", "nope"); err != ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	res, err := e.Nearby("fleet", 33, -115, 1000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Objects) != 2 || res.Objects[0].ID != "truck1" ||
+		res.Objects[1].Distance == 0 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	res, err = e.Scan("fleet", &SearchOptions{
+		Where: []Where{{Field: "speed", Min: 80, Max: 100}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Objects) != 1 || res.Objects[0].Fields[0].Name != "speed" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	// geofence events
+	if err := e.SetChan("warehouse", "NEARBY", "fleet", "FENCE", "POINT",
+		"33", "-115", "1000"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events := make(chan Event, 1)
+	go e.Subscribe(ctx, []string{"warehouse"}, false, func(ev Event) error {
+		events <- ev
+		return errors.New("done")
+	})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			e.SetPoint("fleet", "truck3", 33, -115)
+			time.Sleep(time.Millisecond * 10)
+		}
+	}()
+	select {
+	case ev := <-events:
+		if ev.Channel != "warehouse" || ev.Command != "set" ||
+			ev.ID != "truck3" {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+	case <-ctx.Done():
+		t.Fatal("no event")
+	}
+	cancel()
+	wg.Wait()
+	if err := e.ValidateEndpoint("ftp://localhost"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the collections are persisted
+	e, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	keys, err := e.Keys("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "fleet" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func subTestACL(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "users", acl_users_test)
+	runStep(t, mc, "permissions", acl_permissions_test)
+	runStep(t, mc, "tokens", acl_tokens_test)
+	runStep(t, mc, "namespaces", acl_namespaces_test)
+	runStep(t, mc, "keys", acl_keys_test)
+}
+
+func acl_users_test(mc *mockServer) error {
+	defer mc.Do("ACL", "DELUSER", "alice", "bob")
+	return mc.DoBatch([][]interface{}{
+		{"ACL", "NOPE"}, {"ERR Syntax error, try ACL (SETUSER | DELUSER | GETUSER | USERS | LIST | WHOAMI | CAT | TOKEN)"},
+		{"ACL", "SETUSER", "default", "off"}, {"ERR the default user is authenticated with the requirepass"},
+		{"ACL", "SETUSER", "alice", "+nope"}, {"ERR unknown command 'nope'"},
+		{"ACL", "SETUSER", "alice", "+@nope"}, {"ERR unknown category 'nope'"},
+		{"ACL", "SETUSER", "alice", "?"}, {"ERR syntax error in ACL rule '?'"},
+		{"ACL", "USERS"}, {"[default]"},
+		{"ACL", "SETUSER", "alice", "on", "#" + strings.Repeat("ab", 32), "~fleet:*", "+@read", "-jget"}, {"OK"},
+		{"ACL", "SETUSER", "bob", "cert:CN=bob"}, {"OK"},
+		{"ACL", "USERS"}, {"[default alice bob]"},
+		{"ACL", "GETUSER", "alice"}, {"[flags [on] passwords [" + strings.Repeat("ab", 32) + "] commands +@read -jget keys [fleet:*] certs [] namespace ]"},
+		{"ACL", "GETUSER", "bob"}, {"[flags [off] passwords [] commands -@all keys [] certs [CN=bob] namespace ]"},
+		{"ACL", "GETUSER", "carol"}, {"ERR user 'carol' does not exist"},
+		{"ACL", "LIST"}, {"[user default on nopass ~* +@all user alice on #" + strings.Repeat("ab", 32) + " ~fleet:* +@read -jget user bob off resetkeys cert:CN=bob -@all]"},
+		{"ACL", "WHOAMI"}, {"default"},
+		{"ACL", "CAT", "webhook"}, {"[delhook fencetest hookreplay hooks pausehook pdelhook ppausehook presumehook renamehook resumehook sethook]"},
+		{"ACL", "DELUSER", "bob", "carol"}, {1},
+		{"ACL", "USERS"}, {"[default alice]"},
+	})
+}
+
+func acl_permissions_test(mc *mockServer) error {
+	defer func() {
+		mc.ResetConn()
+		mc.Do("ACL", "DELUSER", "reader")
+		mc.Do("DROP", "fleet:1")
+		mc.Do("DROP", "other")
+		mc.Do("FUNCTION", "DELETE", "acl")
+	}()
+	noperm := func(v interface{}) (resp, expect interface{}) {
+		s := fmt.Sprint(v)
+		return strings.Contains(s, "ERR no permission"), true
+	}
+	lib := "#!lua name=acl\n" +
+		"tile38.register_function{function_name='aclget', flags={'no-writes'}," +
+		"callback=function(keys, args) return tile38.call('get', keys[1], args[1], 'point') end}"
+	if err := mc.DoBatch([][]interface{}{
+		{"FUNCTION", "LOAD", lib}, {"acl"},
+		{"ACL", "SETUSER", "reader", "on", ">secret", "~fleet:*", "+@read", "+@search"}, {"OK"},
+		{"SET", "fleet:1", "truck", "POINT", 33, -115}, {"OK"},
+		{"SET", "other", "truck", "POINT", 33, -115}, {"OK"},
+		{"AUTH", "reader", "nope"}, {"ERR invalid username-password pair or user is disabled"},
+		{"AUTH", "reader", "secret"}, {"OK"},
+		{"ACL", "WHOAMI"}, {"ERR no permission to run the 'acl' command"},
+		{"PING"}, {"PONG"},
+		{"GET", "fleet:1", "truck", "POINT"}, {"[33 -115]"},
+		{"NEARBY", "fleet:1", "IDS", "POINT", 33, -115, 100}, {"[0 [truck]]"},
+		{"GET", "other", "truck", "POINT"}, {"ERR no permission to access the key 'other'"},
+		// the calls of the scripts have the permissions of the user
+		{"EVALRO", "return tile38.call('GET', 'fleet:1', 'truck', 'POINT')", 0}, {"[33 -115]"},
+		{"EVALRO", "return tile38.call('GET', 'other', 'truck', 'POINT')", 0}, {noperm},
+		{"EVALRO", "return tile38.pcall('GET', 'other', 'truck', 'POINT')", 0}, {noperm},
+		{"FCALL_RO", "aclget", 1, "fleet:1", "truck"}, {"[33 -115]"},
+		{"FCALL_RO", "aclget", 1, "other", "truck"}, {"ERR no permission to access the key 'other'"},
+		{"WITHIN", "fleet:1", "IDS", "GET", "other", "truck"}, {"ERR no permission to access the key 'other'"},
+		{"WITHIN", "fleet:1", "FENCE", "OBJECT", "other", "truck"}, {"ERR no permission to access the key 'other'"},
+		{"WITHIN", "fleet:1", "IDS", "OBJECT", `{"type":"Point","coordinates":[-115,33]}`}, {"[0 [truck]]"},
+		{"SET", "fleet:1", "van", "POINT", 33, -115}, {"ERR no permission to run the 'set' command"},
+		{"CLIENT", "LIST"}, {"ERR no permission to run the 'client' command"},
+	}); err != nil {
+		return err
+	}
+	mc.ResetConn()
+	return mc.DoBatch([][]interface{}{
+		{"ACL", "SETUSER", "reader", "off"}, {"OK"},
+		{"AUTH", "reader", "secret"}, {"ERR invalid username-password pair or user is disabled"},
+		{"HELLO", 2, "AUTH", "reader", "secret"}, {"ERR invalid username-password pair or user is disabled"},
+		{"ACL", "SETUSER", "reader", "on", "+set"}, {"OK"},
+		{"HELLO", 2, "AUTH", "reader", "secret"}, {func(v interface{}) (resp, expect interface{}) {
+			return nil, nil
+		}},
+		{"SET", "fleet:1", "van", "POINT", 33, -115}, {"OK"},
+		{"CONFIG", "GET", "requirepass"}, {"ERR no permission to run the 'config' command"},
+		{"AUTH", "default", "nope"}, {"ERR invalid password"},
+	})
+}
+
+func acl_tokens_test(mc *mockServer) error {
+	defer func() {
+		mc.ResetConn()
+		mc.Do("AUTH", "newpass")
+		mc.Do("ACL", "TOKEN", "DEL", "forever")
+		mc.Do("CONFIG", "SET", "requirepass", "")
+	}()
+	sum := sha256.Sum256([]byte("oldpass"))
+	oldHash := hex.EncodeToString(sum[:])
+	if err := mc.DoBatch([][]interface{}{
+		{"ACL", "TOKEN"}, {"ERR wrong number of arguments for 'acl' command"},
+		{"ACL", "TOKEN", "NOPE"}, {"ERR Syntax error, try ACL TOKEN (ADD | DEL | LIST)"},
+		{"ACL", "TOKEN", "ADD", "oldpass", "EX", 0}, {"ERR invalid argument '0'"},
+		{"ACL", "TOKEN", "ADD", "oldpass", "PX", 10}, {"ERR invalid argument 'PX'"},
+		{"ACL", "TOKEN", "ADD", "oldpass", "EX", 3600}, {"OK"},
+		{"ACL", "TOKEN", "ADD", "forever"}, {"OK"},
+		{"ACL", "TOKEN", "LIST"}, {func(v interface{}) (resp, expect interface{}) {
+			s := fmt.Sprint(v)
+			return strings.HasPrefix(s, "[["+oldHash+" 3") &&
+				strings.HasSuffix(s, " -1]]"), true
+		}},
+		{"CONFIG", "SET", "requirepass", "newpass"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	mc.ResetConn()
+	return mc.DoBatch([][]interface{}{
+		{"GET", "fleet", "truck"}, {"ERR authentication required"},
+		{"AUTH", "oldpass"}, {"OK"},
+		{"AUTH", "forever"}, {"OK"},
+		{"AUTH", "nope"}, {"ERR invalid password"},
+		{"AUTH", "newpass"}, {"OK"},
+		{"ACL", "TOKEN", "DEL", "oldpass"}, {1},
+		{"ACL", "TOKEN", "DEL", "oldpass"}, {0},
+		{"AUTH", "oldpass"}, {"ERR invalid password"},
+		{"AUTH", "default", "forever"}, {"OK"},
+	})
+}
+
+func acl_namespaces_test(mc *mockServer) error {
+	defer func() {
+		mc.ResetConn()
+		mc.Do("ACL", "DELUSER", "tenant")
+		mc.Do("NAMESPACE", "DEL", "acme")
+		mc.Do("DROP", "acme:fleet")
+		mc.Do("DROP", "acme:truck")
+		mc.Do("DROP", "fleet")
+		mc.Do("DROP", "zones")
+		mc.Do("DELCHAN", "acme:zc")
+	}()
+	if err := mc.DoBatch([][]interface{}{
+		{"NAMESPACE", "NOPE"}, {"ERR Syntax error, try NAMESPACE (SET | DEL | LIST | STATS)"},
+		{"NAMESPACE", "SET", "default"}, {"ERR invalid argument 'default'"},
+		{"NAMESPACE", "SET", "acme", "MAXKEYS", 2, "MAXOBJECTS", 3}, {"OK"},
+		{"NAMESPACE", "LIST"}, {"[acme]"},
+		{"SELECT", "nope"}, {"ERR namespace 'nope' does not exist"},
+		{"SET", "fleet", "truck", "POINT", 33, -115}, {"OK"},
+		{"SET", "zones", "z1", "BOUNDS", 30, -120, 40, -110}, {"OK"},
+		{"SELECT", "acme"}, {"OK"},
+		{"GET", "fleet", "truck"}, {nil},
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck2", "POINT", 33, -115}, {"OK"},
+		{"SET", "truck", "van", "POINT", 33, -115}, {"OK"},
+		{"SET", "bus", "van", "POINT", 33, -115}, {"ERR quota exceeded, the namespace 'acme' is over 2 keys"},
+		{"SET", "fleet", "truck3", "POINT", 33, -115}, {"ERR quota exceeded, the namespace 'acme' is over 3 objects"},
+		{"SET", "fleet", "truck1", "POINT", 34, -115}, {"OK"},
+		{"KEYS", "*"}, {"[fleet truck]"},
+		{"NEARBY", "fleet", "IDS", "POINT", 33, -115, 100}, {"[0 [truck2]]"},
+		{"INTERSECTS", "fleet", "IDS", "GET", "truck", "van"}, {"[0 [truck2]]"},
+		// the fence follows the object of the namespace, not the default
+		{"SETCHAN", "zc", "WITHIN", "fleet", "FENCE", "OBJECT", "zones", "z1"}, {"1"},
+		{"CHANS", "zc"}, {"[[zc fleet [local://acme:zc] [WITHIN fleet FENCE OBJECT acme:zones z1] []]]"},
+		{"CONFIG", "GET", "requirepass"}, {"ERR the 'config' command is not allowed in a namespace"},
+		{"SELECT", "default"}, {"OK"},
+		{"KEYS", "*"}, {"[acme:fleet acme:truck fleet zones]"},
+		{"NAMESPACE", "STATS", "acme"}, {func(v interface{}) (resp, expect interface{}) {
+			s := fmt.Sprint(v)
+			return strings.Contains(s, "num_keys 2") &&
+				strings.Contains(s, "num_objects 3") &&
+				strings.Contains(s, "num_quota_rejected 2"), true
+		}},
+		{"ACL", "SETUSER", "tenant", "on", ">secret", "~*", "+@all", "namespace:acme"}, {"OK"},
+		{"AUTH", "tenant", "secret"}, {"OK"},
+		{"SELECT", "default"}, {"ERR the namespace of the user cannot be changed"},
+		{"GET", "fleet", "truck1", "POINT"}, {"[34 -115]"},
+		{"KEYS", "*"}, {"[fleet truck]"},
+	}); err != nil {
+		return err
+	}
+	mc.ResetConn()
+	return mc.DoBatch([][]interface{}{
+		{"ACL", "GETUSER", "tenant"}, {func(v interface{}) (resp, expect interface{}) {
+			return strings.HasSuffix(fmt.Sprint(v), " namespace acme]"), true
+		}},
+	})
+}
+
+func acl_keys_test(mc *mockServer) error {
+	defer func() {
+		mc.ResetConn()
+		mc.Do("ACL", "DELUSER", "writer")
+		mc.Do("PDELHOOK", "*")
+		mc.Do("PDELCHAN", "*")
+		mc.Do("DROP", "fleet:1")
+		mc.Do("DROP", "secret")
+	}()
+	listed := func(name string, hidden ...string) func(v interface{}) (
+		resp, expect interface{},
+	) {
+		return func(v interface{}) (resp, expect interface{}) {
+			s := fmt.Sprint(v)
+			if !strings.Contains(s, name) {
+				return s, name
+			}
+			for _, h := range hidden {
+				if strings.Contains(s, h) {
+					return s, "no " + h
+				}
+			}
+			return nil, nil
+		}
+	}
+	return mc.DoBatch([][]interface{}{
+		{"ACL", "SETUSER", "writer", "on", ">secret", "~fleet:*", "+@read", "+@write"}, {"OK"},
+		{"SET", "fleet:1", "truck", "POINT", 33, -115}, {"OK"},
+		{"SET", "secret", "truck", "POINT", 33, -115}, {"OK"},
+		{"SETHOOK", "fleethook", "http://localhost:1/x", "NEARBY", "fleet:1", "FENCE", "POINT", 33, -115, 100}, {1},
+		{"SETHOOK", "secrethook", "http://localhost:1/x", "NEARBY", "secret", "FENCE", "POINT", 33, -115, 100}, {1},
+		{"SETCHAN", "fleetchan", "NEARBY", "fleet:1", "FENCE", "POINT", 33, -115, 100}, {1},
+		{"SETCHAN", "secretchan", "NEARBY", "secret", "FENCE", "POINT", 33, -115, 100}, {1},
+		{"AUTH", "writer", "secret"}, {"OK"},
+
+		// the commands that may access every key need allkeys
+		{"FLUSHDB"}, {"ERR no permission to access every key with the 'flushdb' command"},
+		{"PDELHOOK", "fleet*"}, {"ERR no permission to access every key with the 'pdelhook' command"},
+		{"PPAUSECHAN", "*"}, {"ERR no permission to access every key with the 'ppausechan' command"},
+		{"SETTRIGGER", "t1", "*", "BEFORE", "SET", "f1"}, {"ERR no permission to access every key with the 'settrigger' command"},
+
+		// every key of STATS
+		{"STATS", "secret"}, {"ERR no permission to access the key 'secret'"},
+		{"STATS", "fleet:1", "secret", "EXT"}, {"ERR no permission to access the key 'secret'"},
+		{"STATS", "fleet:1", "EXT"}, {listed("num_objects 1")},
+
+		// the listed keys and hooks
+		{"KEYS", "*"}, {"[fleet:1]"},
+		{"KEYS", "secret"}, {"[]"},
+		{"HOOKS", "*"}, {listed("fleethook", "secrethook")},
+		{"CHANS", "*"}, {listed("fleetchan", "secretchan")},
+
+		// the keys of the named hooks
+		{"FENCETEST", "secrethook", "POINT", 33, -115}, {"ERR no permission to access the key 'secret'"},
+		{"DELHOOK", "secrethook"}, {"ERR no permission to access the key 'secret'"},
+		{"PAUSECHAN", "secretchan"}, {"ERR no permission to access the key 'secret'"},
+		{"RENAMEHOOK", "secrethook", "myhook"}, {"ERR no permission to access the key 'secret'"},
+		{"SETHOOK", "secrethook", "http://localhost:1/x", "NEARBY", "fleet:1", "FENCE", "POINT", 33, -115, 100}, {"ERR no permission to access the key 'secret'"},
+		{"DELCHAN", "fleetchan"}, {1},
+		{"DELHOOK", "fleethook"}, {1},
+	})
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func subTestAudit(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "file", audit_file_test)
+}
+
+func audit_file_test(mc *mockServer) error {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	defer mc.Do("CONFIG", "SET", "auditlog", "")
+	err = mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "auditlog", "nope://x"}, {"ERR Invalid argument 'nope://x' for CONFIG SET 'auditlog'"},
+		{"CONFIG", "SET", "auditlog", path}, {"OK"},
+		{"SET", "audit", "truck1", "POINT", 33, -115}, {"OK"},
+		{"GET", "audit", "truck1"}, {`{"type":"Point","coordinates":[-115,33]}`},
+		{"AUTH", "secret"}, {"ERR invalid password"},
+		{"DROP", "audit"}, {1},
+	})
+	if err != nil {
+		return err
+	}
+	// the records are written in the background
+	var lines []string
+	for i := 0; i < 50; i++ {
+		data, _ := ioutil.ReadFile(path)
+		lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) == 4 {
+			break
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	if len(lines) != 4 {
+		return fmt.Errorf("expected 4 records, got %d", len(lines))
+	}
+	expect := []string{"config set", "set", "auth", "drop"}
+	var prev string
+	var seq int64
+	for i, line := range lines {
+		rec := gjson.Parse(line)
+		command := rec.Get("command").String()
+		if rec.Get("args.0").String() == "CONFIG" {
+			command += " " + strings.ToLower(rec.Get("args.1").String())
+		}
+		if command != expect[i] || rec.Get("user").String() != "default" ||
+			rec.Get("ok").Bool() != (command != "auth") {
+			return fmt.Errorf("unexpected record %s", line)
+		}
+		if command == "auth" && rec.Get("args.1").String() != "(redacted)" {
+			return errors.New("expected a redacted password")
+		}
+		if i > 0 && rec.Get("seq").Int() != seq+1 {
+			return fmt.Errorf("expected seq %d, got %d", seq+1, rec.Get("seq").Int())
+		}
+		seq = rec.Get("seq").Int()
+		if i > 0 && rec.Get("prev").String() != prev {
+			return errors.New("unexpected prev")
+		}
+		hash := rec.Get("hash").String()
+		sum := sha256.Sum256([]byte(line[:strings.LastIndex(line, `,"hash":`)]))
+		if hex.EncodeToString(sum[:]) != hash {
+			return fmt.Errorf("unexpected hash for %s", line)
+		}
+		prev = hash
+	}
+	return nil
+}
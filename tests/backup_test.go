@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// objectServer is an in-memory object storage server, which handles the
+// S3 requests for single part uploads, downloads, and listing.
+type objectServer struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *objectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case "PUT":
+		s.objects[path], _ = ioutil.ReadAll(r.Body)
+	case "GET":
+		if r.URL.Query().Get("list-type") != "" {
+			prefix := path + r.URL.Query().Get("prefix")
+			var keys []string
+			for key := range s.objects {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key[len(path):])
+				}
+			}
+			sort.Strings(keys)
+			fmt.Fprintf(w, "<ListBucketResult>")
+			for _, key := range keys {
+				fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", key)
+			}
+			fmt.Fprintf(w, "</ListBucketResult>")
+			return
+		}
+		data, ok := s.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func subTestBackup(t *testing.T, mc *mockServer) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	ts := httptest.NewServer(&objectServer{objects: make(map[string][]byte)})
+	defer ts.Close()
+	rawurl := "s3://bucket/backups?endpoint=" + ts.URL
+	runStep(t, mc, "restore", func(mc *mockServer) error {
+		return backup_restore_test(mc, rawurl)
+	})
+}
+
+func backup_restore_test(mc *mockServer, rawurl string) error {
+	var full, incr string
+	backupURL := func(dst *string, suffix string) func(v interface{}) (resp, expect interface{}) {
+		return func(v interface{}) (resp, expect interface{}) {
+			s, _ := v.(string)
+			if *dst == "" {
+				*dst = s
+			}
+			return strings.HasPrefix(s, "s3://bucket/backups/tile38-") &&
+				strings.HasSuffix(s, suffix) && s == *dst, true
+		}
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"RESTORE", rawurl}, {"ERR no backups found"},
+		{"SET", "fleet", "truck1", "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck2", "STRING", "hello"}, {"OK"},
+		{"SETCHAN", "chan1", "NEARBY", "fleet", "FENCE", "POINT", 33, -115, 100}, {1},
+		{"BACKUP", rawurl}, {backupURL(&full, ".snap")},
+		{"BACKUP", rawurl, "more"}, {"ERR wrong number of arguments for 'backup' command"},
+		{"DEL", "fleet", "truck2"}, {1},
+		{"SET", "fleet", "truck3", "POINT", 34, -116}, {"OK"},
+		{"BACKUP", rawurl, "INCREMENTAL"}, {backupURL(&incr, ".aof")},
+		// nothing changed since the last backup
+		{"BACKUP", rawurl, "INCREMENTAL"}, {backupURL(&incr, ".aof")},
+		{"SET", "fleet", "truck4", "POINT", 35, -117}, {"OK"},
+		{"DELCHAN", "chan1"}, {1},
+		{"RESTORE", rawurl}, {backupURL(&incr, ".aof")},
+		{"SCAN", "fleet", "IDS"}, {"[0 [truck1 truck3]]"},
+		{"GET", "fleet", "truck1", "WITHFIELDS", "POINT"}, {"[[33 -115] [speed 10]]"},
+		{"CHANS", "*"}, {func(v interface{}) (resp, expect interface{}) {
+			return strings.Contains(fmt.Sprint(v), "chan1"), true
+		}},
+		{"RESTORE", rawurl, "missing.snap"}, {"ERR not found: s3://bucket/backups/missing.snap"},
+		{"RESTORE", rawurl, "AT", "0"}, {"ERR no backups found"},
+		{"RESTORE", rawurl, "AT", "yesterday"}, {"ERR invalid argument 'yesterday'"},
+	}); err != nil {
+		return err
+	}
+	return mc.DoBatch([][]interface{}{
+		{"RESTORE", rawurl, full[strings.LastIndex(full, "/")+1:]}, {full},
+		{"SCAN", "fleet", "IDS"}, {"[0 [truck1 truck2]]"},
+		{"GET", "fleet", "truck2"}, {"hello"},
+	})
+}
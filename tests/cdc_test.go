@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+func subTestCDC(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "local endpoint", cdc_local_endpoint_test)
+	runStep(t, mc, "keyspace events", cdc_keyspace_events_test)
+}
+
+func cdc_local_endpoint_test(mc *mockServer) error {
+	sc, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port),
+		redis.DialReadTimeout(time.Second*5))
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+	if _, err := sc.Do("OUTPUT", "json"); err != nil {
+		return err
+	}
+	if _, err := doTile38(sc, "SUBSCRIBE", "cdc"); err != nil {
+		return err
+	}
+	defer mc.Do("CONFIG", "SET", "cdcendpoint", "")
+	err = mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "cdcendpoint", "nope://x"}, {"ERR Invalid argument 'nope://x' for CONFIG SET 'cdcendpoint'"},
+		{"CONFIG", "SET", "cdcendpoint", "local://cdc"}, {"OK"},
+		{"CONFIG", "GET", "cdcendpoint"}, {"[cdcendpoint local://cdc]"},
+		{"SET", "cdcfleet", "t1", "FIELD", "speed", "55", "POINT", "33", "-115"}, {"OK"},
+		{"SET", "cdcfleet", "t1", "NX", "POINT", "34", "-115"}, {nil},
+		{"FSET", "cdcfleet", "t1", "speed", "60"}, {1},
+		{"EXPIRE", "cdcfleet", "t1", "100"}, {1},
+		{"PERSIST", "cdcfleet", "t1"}, {1},
+		{"SET", "cdcfleet", "t2", "STRING", "hello"}, {"OK"},
+		{"SETCHAN", "cdcchan", "NEARBY", "cdcfleet", "POINT", "33", "-115", "100"}, {1},
+		{"DELCHAN", "cdcchan"}, {1},
+		{"PDEL", "cdcfleet", "t*"}, {2},
+		{"DEL", "cdcfleet", "t1"}, {0},
+	})
+	if err != nil {
+		return err
+	}
+	expect := []string{
+		`set cdcfleet t1 {"type":"Point","coordinates":[-115,33]} {"speed":55}`,
+		`fset cdcfleet t1 {"type":"Point","coordinates":[-115,33]} {"speed":60}`,
+		`expire cdcfleet t1 100`,
+		`persist cdcfleet t1`,
+		`set cdcfleet t2 "hello"`,
+		`del cdcfleet t1`,
+		`del cdcfleet t2`,
+	}
+	var seq int64
+	var events []string
+	for len(events) < len(expect) {
+		js, err := redis.String(sc.Receive())
+		if err != nil {
+			return err
+		}
+		res := gjson.Parse(js)
+		if res.Get("seq").Int() <= seq {
+			return fmt.Errorf("expected seq after %d, got '%s'", seq, js)
+		}
+		seq = res.Get("seq").Int()
+		if !res.Get("time").Exists() {
+			return fmt.Errorf("expected time, got '%s'", js)
+		}
+		var parts []string
+		for _, name := range []string{"command", "key", "id", "object", "fields", "ttl"} {
+			if v := res.Get(name); v.Exists() {
+				if name == "command" || name == "key" || name == "id" {
+					parts = append(parts, v.String())
+				} else {
+					parts = append(parts, v.Raw)
+				}
+			}
+		}
+		events = append(events, strings.Join(parts, " "))
+	}
+	if strings.Join(events, "\n") != strings.Join(expect, "\n") {
+		return fmt.Errorf("expected\n%s\ngot\n%s", strings.Join(expect, "\n"),
+			strings.Join(events, "\n"))
+	}
+	return nil
+}
+
+func cdc_keyspace_events_test(mc *mockServer) error {
+	sc, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port),
+		redis.DialReadTimeout(time.Second*5))
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+	psc := redis.PubSubConn{Conn: sc}
+	if err := psc.Subscribe("__keyspace__:ksfleet", "__keyevent__:del"); err != nil {
+		return err
+	}
+	defer mc.Do("CONFIG", "SET", "keyspaceevents", "")
+	err = mc.DoBatch([][]interface{}{
+		{"SET", "ksfleet", "t0", "POINT", "33", "-115"}, {"OK"},
+		{"CONFIG", "SET", "keyspaceevents", "ksfleet, kstrucks*"}, {"OK"},
+		{"CONFIG", "GET", "keyspaceevents"}, {"[keyspaceevents ksfleet,kstrucks*]"},
+		{"SET", "ksfleet", "t1", "FIELD", "speed", "55", "POINT", "33", "-115"}, {"OK"},
+		{"SET", "ksother", "t1", "POINT", "33", "-115"}, {"OK"},
+		{"DEL", "ksother", "t1"}, {1},
+		{"EXPIRE", "ksfleet", "t1", "100"}, {1},
+		{"DEL", "ksfleet", "t1"}, {1},
+	})
+	if err != nil {
+		return err
+	}
+	expect := []string{
+		`__keyspace__:ksfleet set ksfleet t1`,
+		`__keyspace__:ksfleet expire ksfleet t1`,
+		`__keyspace__:ksfleet del ksfleet t1`,
+		`__keyevent__:del del ksfleet t1`,
+	}
+	var events []string
+	for len(events) < len(expect) {
+		switch v := psc.Receive().(type) {
+		case error:
+			return v
+		case redis.Message:
+			res := gjson.ParseBytes(v.Data)
+			events = append(events, fmt.Sprintf("%s %s %s %s", v.Channel,
+				res.Get("command"), res.Get("key"), res.Get("id")))
+		}
+	}
+	if strings.Join(events, "\n") != strings.Join(expect, "\n") {
+		return fmt.Errorf("expected\n%s\ngot\n%s", strings.Join(expect, "\n"),
+			strings.Join(events, "\n"))
+	}
+	return nil
+}
@@ -1,17 +1,42 @@
 package tests
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/websocket"
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/internal/flatbuf"
+	"github.com/tidwall/tile38/internal/qservice"
 )
 
 func subTestClient(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "valid json", client_valid_json_test)
 	runStep(t, mc, "valid client count", info_valid_client_count_test)
+	runStep(t, mc, "RESP3", client_RESP3_test)
+	runStep(t, mc, "RESP3 push", client_RESP3_push_test)
+	runStep(t, mc, "TRACEPARENT", client_TRACEPARENT_test)
+	runStep(t, mc, "websocket", client_websocket_test)
+	runStep(t, mc, "OUTPUT msgpack", client_OUTPUT_msgpack_test)
+	runStep(t, mc, "OUTPUT protobuf", client_OUTPUT_protobuf_test)
+	runStep(t, mc, "OUTPUT csv", client_OUTPUT_csv_test)
+	runStep(t, mc, "OUTPUT flatbuffers", client_OUTPUT_flatbuffers_test)
+	runStep(t, mc, "OUTPUT bounds", client_OUTPUT_bounds_test)
+	runStep(t, mc, "netrules", client_netrules_test)
+	runStep(t, mc, "KILL", client_KILL_test)
+	runStep(t, mc, "limits", client_limits_test)
 }
 
 func client_valid_json_test(mc *mockServer) error {
@@ -75,3 +100,661 @@ func info_valid_client_count_test(mc *mockServer) error {
 	}
 	return nil
 }
+
+// respConn is a raw connection for the replies that redigo cannot read.
+type respConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func dialRESP(mc *mockServer) (*respConn, error) {
+	conn, err := net.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &respConn{conn, bufio.NewReader(conn)}, nil
+}
+
+// expect reads a reply, which must be the expected one.
+func (c *respConn) expect(expected string) error {
+	buf := make([]byte, len(expected))
+	if _, err := io.ReadFull(c.rd, buf); err != nil {
+		return err
+	}
+	if string(buf) != expected {
+		return fmt.Errorf("expected %q, got %q", expected, buf)
+	}
+	return nil
+}
+
+// do sends a command, and reads a reply, which must be the expected one.
+func (c *respConn) do(cmd, expected string) error {
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", cmd); err != nil {
+		return err
+	}
+	if err := c.expect(expected); err != nil {
+		return fmt.Errorf("%s: %v", cmd, err)
+	}
+	return nil
+}
+
+func client_RESP3_test(mc *mockServer) error {
+	c, err := dialRESP(mc)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+	if _, err := fmt.Fprintf(c.conn, "HELLO 3\r\n"); err != nil {
+		return err
+	}
+	if err := c.expect("%7\r\n$6\r\nserver\r\n$6\r\ntile38\r\n"); err != nil {
+		return err
+	}
+	line, err := c.rd.ReadString('\n')
+	for err == nil && line != "modules\r\n" {
+		if line == "proto\r\n" {
+			if line, _ = c.rd.ReadString('\n'); line != ":3\r\n" {
+				return fmt.Errorf("expected proto 3, got %q", line)
+			}
+		}
+		line, err = c.rd.ReadString('\n')
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.expect("*0\r\n"); err != nil {
+		return err
+	}
+	for _, step := range [][2]string{
+		{"SET mykey truck1 FIELD speed 10 POINT 33 -115", "+OK\r\n"},
+		{"GET mykey truck2", "_\r\n"},
+		{"FINCRBY mykey truck1 speed 2.5", ",12.5\r\n"},
+		{"SETLABEL mykey owner fleet1", "+OK\r\n"},
+		{"LABELS mykey", "%1\r\n$5\r\nowner\r\n$6\r\nfleet1\r\n"},
+		{"LABELS nokey", "%0\r\n"},
+		{"HELLO 4", "-ERR unsupported protocol version\r\n"},
+		{"HELLO 2 SETNAME resp2", "*14\r\n$6\r\nserver\r\n$6\r\ntile38\r\n"},
+	} {
+		if err := c.do(step[0], step[1]); err != nil {
+			return err
+		}
+	}
+	// the rest of the reply of HELLO 2
+	for {
+		line, err := c.rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if line == "modules\r\n" {
+			break
+		}
+	}
+	if err := c.expect("*0\r\n"); err != nil {
+		return err
+	}
+	if err := c.do("GET mykey truck2", "$-1\r\n"); err != nil {
+		return err
+	}
+	list, err := redis.String(mc.Do("CLIENT", "LIST"))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(list, " name=resp2 ") {
+		return fmt.Errorf("expected the client name, got %q", list)
+	}
+	return nil
+}
+
+func client_RESP3_push_test(mc *mockServer) error {
+	c, err := dialRESP(mc)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+	if _, err := fmt.Fprintf(c.conn, "HELLO 3\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := c.rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if line == "modules\r\n" {
+			break
+		}
+	}
+	if err := c.expect("*0\r\n"); err != nil {
+		return err
+	}
+	if err := c.do("SUBSCRIBE mychan",
+		">3\r\n$9\r\nsubscribe\r\n$6\r\nmychan\r\n:1\r\n"); err != nil {
+		return err
+	}
+	if _, err := mc.Do("PUBLISH", "mychan", "hello"); err != nil {
+		return err
+	}
+	return c.expect(">3\r\n$7\r\nmessage\r\n$6\r\nmychan\r\n$5\r\nhello\r\n")
+}
+
+func client_TRACEPARENT_test(mc *mockServer) error {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentID = "00f067aa0ba902b7"
+	spans := make(chan gjson.Result, 1024)
+	collector := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			gjson.GetBytes(body, "resourceSpans.#.scopeSpans.#.spans|@flatten|@flatten").
+				ForEach(func(_, span gjson.Result) bool {
+					spans <- span
+					return true
+				})
+		},
+	))
+	defer collector.Close()
+	receiver := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {},
+	))
+	defer receiver.Close()
+
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Do("CLIENT", "TRACEPARENT", "00-nope"); err == nil ||
+		err.Error() != "ERR invalid traceparent" {
+		return fmt.Errorf("expected 'ERR invalid traceparent', got '%v'", err)
+	}
+	defer mc.Do("CONFIG", "SET", "traceurl", "")
+	err = mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "traceurl", "nope://x"}, {"ERR Invalid argument 'nope://x' for CONFIG SET 'traceurl'"},
+		{"CONFIG", "SET", "traceurl", collector.URL + "/v1/traces"}, {"OK"},
+		{"SETHOOK", "tracehook", receiver.URL, "NEARBY", "tracefleet", "FENCE", "POINT", "33", "-115", "1000"}, {1},
+	})
+	if err != nil {
+		return err
+	}
+	defer mc.Do("DELHOOK", "tracehook")
+	reply, err := redis.String(conn.Do("CLIENT", "TRACEPARENT",
+		"00-"+traceID+"-"+parentID+"-01"))
+	if err != nil || reply != "OK" {
+		return fmt.Errorf("expected 'OK', got '%v' '%v'", reply, err)
+	}
+	if _, err := conn.Do("SET", "tracefleet", "t1", "POINT", "33", "-115"); err != nil {
+		return err
+	}
+
+	// the span of the SET is a child of the client, and the span of the
+	// delivery of the hook is a child of the SET
+	var setID string
+	var sent bool
+	timeout := time.After(time.Second * 5)
+	for setID == "" || !sent {
+		select {
+		case span := <-spans:
+			if span.Get("traceId").String() != traceID {
+				continue
+			}
+			switch span.Get("name").String() {
+			case "set":
+				if parent := span.Get("parentSpanId").String(); parent != parentID {
+					return fmt.Errorf("expected parent '%s', got '%s'", parentID, parent)
+				}
+				if kind := span.Get("kind").Int(); kind != 2 {
+					return fmt.Errorf("expected kind 2, got %d", kind)
+				}
+				setID = span.Get("spanId").String()
+			case "send http":
+				if setID == "" || span.Get("parentSpanId").String() != setID {
+					return fmt.Errorf("expected the send after the set, got '%s'", span.Raw)
+				}
+				sent = true
+			}
+		case <-timeout:
+			return fmt.Errorf("expected the spans of the set and the send")
+		}
+	}
+	return nil
+}
+
+func client_websocket_test(mc *mockServer) error {
+	defer mc.Do("DROP", "wsfleet")
+	dialer := websocket.Dialer{
+		EnableCompression: true,
+		Subprotocols:      []string{"msgpack"},
+	}
+	conn, res, err := dialer.Dial(fmt.Sprintf(
+		"ws://localhost:%d/NEARBY+wsfleet+FENCE+POINT+33+-115+10000", mc.port), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if ext := res.Header.Get("Sec-WebSocket-Extensions"); !strings.HasPrefix(ext, "permessage-deflate") {
+		return fmt.Errorf("expected permessage-deflate, got '%s'", ext)
+	}
+	if conn.Subprotocol() != "msgpack" {
+		return fmt.Errorf("expected msgpack, got '%s'", conn.Subprotocol())
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	typ, data, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	// {"ok":true,"live":true}
+	expect := "\x82\xa2ok\xc3\xa4live\xc3"
+	if typ != websocket.BinaryMessage || string(data) != expect {
+		return fmt.Errorf("expected %q, got %d %q", expect, typ, data)
+	}
+	if _, err := mc.Do("SET", "wsfleet", "truck1", "FIELD", "speed", 90,
+		"POINT", 33, -115); err != nil {
+		return err
+	}
+	typ, data, err = conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if typ != websocket.BinaryMessage || data[0]&0xf0 != 0x80 ||
+		!strings.Contains(string(data), "\xa7command\xa3set") ||
+		!strings.Contains(string(data), "\xa6truck1") {
+		return fmt.Errorf("unexpected message: %q", data)
+	}
+	return nil
+}
+
+func client_OUTPUT_msgpack_test(mc *mockServer) error {
+	defer mc.Do("DROP", "mpfleet")
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// {"ok":true,"elapsed":"..."}
+	reply, err := redis.Bytes(conn.Do("OUTPUT", "msgpack"))
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(string(reply), "\x82\xa2ok\xc3\xa7elapsed") {
+		return fmt.Errorf("unexpected reply: %q", reply)
+	}
+	reply, err = redis.Bytes(conn.Do("OUTPUT"))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(reply), "\xa6output\xa7msgpack") {
+		return fmt.Errorf("unexpected reply: %q", reply)
+	}
+	if _, err := mc.Do("SET", "mpfleet", "truck1", "POINT", 33, -115); err != nil {
+		return err
+	}
+	reply, err = redis.Bytes(conn.Do("GET", "mpfleet", "truck1", "POINT"))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(reply), "\xa5point\x82\xa3lat\x21\xa3lon\xd0\x8d") {
+		return fmt.Errorf("unexpected reply: %q", reply)
+	}
+	reply, err = redis.Bytes(conn.Do("NEARBY", "mpfleet", "FENCE", "POINT", 33, -115, 1000))
+	if err != nil {
+		return err
+	}
+	if string(reply) != "\x82\xa2ok\xc3\xa4live\xc3" {
+		return fmt.Errorf("unexpected reply: %q", reply)
+	}
+	if _, err := mc.Do("SET", "mpfleet", "truck2", "POINT", 33, -115); err != nil {
+		return err
+	}
+	reply, err = redis.Bytes(conn.Receive())
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(reply), "\xa7command\xa3set") ||
+		!strings.Contains(string(reply), "\xa2id\xa6truck2") {
+		return fmt.Errorf("unexpected event: %q", reply)
+	}
+	return nil
+}
+
+func client_OUTPUT_protobuf_test(mc *mockServer) error {
+	defer mc.Do("DROP", "pbfleet")
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	do := func(args ...interface{}) (*qservice.Reply, error) {
+		data, err := redis.Bytes(conn.Do(args[0].(string), args[1:]...))
+		if err != nil {
+			return nil, err
+		}
+		reply := new(qservice.Reply)
+		if err := proto.Unmarshal(data, reply); err != nil {
+			return nil, err
+		}
+		return reply, nil
+	}
+	reply, err := do("OUTPUT", "protobuf")
+	if err != nil {
+		return err
+	}
+	if !reply.Ok || reply.Elapsed == "" {
+		return fmt.Errorf("unexpected reply: %v", reply)
+	}
+	if _, err := mc.Do("SET", "pbfleet", "truck1", "FIELD", "speed", 90,
+		"POINT", 33, -115); err != nil {
+		return err
+	}
+	reply, err = do("GET", "pbfleet", "truck1", "WITHFIELDS")
+	if err != nil {
+		return err
+	}
+	if reply.Object == nil || !gjson.Valid(reply.Object.Object) ||
+		len(reply.Object.Fields) != 1 ||
+		reply.Object.Fields[0].Value != "90" {
+		return fmt.Errorf("unexpected reply: %v", reply)
+	}
+	reply, err = do("GET", "pbfleet", "nope")
+	if err != nil {
+		return err
+	}
+	if reply.Ok || reply.Err != "id not found" {
+		return fmt.Errorf("unexpected reply: %v", reply)
+	}
+	reply, err = do("NEARBY", "pbfleet", "FENCE", "POINT", 33, -115, 1000)
+	if err != nil {
+		return err
+	}
+	if !reply.Ok {
+		return fmt.Errorf("unexpected reply: %v", reply)
+	}
+	if _, err := mc.Do("SET", "pbfleet", "truck2", "POINT", 33, -115); err != nil {
+		return err
+	}
+	data, err := redis.Bytes(conn.Receive())
+	if err != nil {
+		return err
+	}
+	reply = new(qservice.Reply)
+	if err := proto.Unmarshal(data, reply); err != nil {
+		return err
+	}
+	if reply.Event == nil || reply.Event.Command != "set" ||
+		reply.Event.Id != "truck2" {
+		return fmt.Errorf("unexpected event: %v", reply)
+	}
+	return nil
+}
+
+func client_OUTPUT_csv_test(mc *mockServer) error {
+	defer mc.Do("DROP", "csvfleet")
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Do("OUTPUT", "csv", "FIELDS", "id,lat,lon,speed"); err != nil {
+		return err
+	}
+	reply, err := redis.String(conn.Do("OUTPUT", "csv", "COLUMNS", "id"))
+	if err != nil {
+		return err
+	}
+	if gjson.Get(reply, "ok").Bool() {
+		return fmt.Errorf("expected an error, got %s", reply)
+	}
+	reply, err = redis.String(conn.Do("OUTPUT"))
+	if err != nil {
+		return err
+	}
+	if gjson.Get(reply, "output").String() != "csv" ||
+		gjson.Get(reply, "fields.3").String() != "speed" {
+		return fmt.Errorf("unexpected reply: %s", reply)
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "csvfleet", "truck1", "FIELD", "speed", 90, "POINT", 33, -115}, {"OK"},
+		{"SET", "csvfleet", "truck2", "POINT", 34, -116}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	reply, err = redis.String(conn.Do("SCAN", "csvfleet"))
+	if err != nil {
+		return err
+	}
+	if reply != "id,lat,lon,speed\ntruck1,33,-115,90\ntruck2,34,-116,0\n" {
+		return fmt.Errorf("unexpected reply: %q", reply)
+	}
+	reply, err = redis.String(conn.Do("WITHIN", "csvfleet", "IDS",
+		"BOUNDS", 32, -116, 33.5, -114))
+	if err != nil {
+		return err
+	}
+	if reply != "id,lat,lon,speed\ntruck1,,,\n" {
+		return fmt.Errorf("unexpected reply: %q", reply)
+	}
+	reply, err = redis.String(conn.Do("GET", "csvfleet", "nope"))
+	if err != nil {
+		return err
+	}
+	if gjson.Get(reply, "err").String() != "id not found" {
+		return fmt.Errorf("unexpected reply: %q", reply)
+	}
+	return nil
+}
+
+func client_OUTPUT_bounds_test(mc *mockServer) error {
+	defer mc.Do("DROP", "bfleet")
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	reply, err := redis.String(conn.Do("OUTPUT", "resp", "BOUNDS"))
+	if err == nil {
+		return fmt.Errorf("expected an error, got %s", reply)
+	}
+	if _, err := conn.Do("OUTPUT", "json", "BOUNDS"); err != nil {
+		return err
+	}
+	reply, err = redis.String(conn.Do("OUTPUT"))
+	if err != nil {
+		return err
+	}
+	if !gjson.Get(reply, "bounds").Bool() {
+		return fmt.Errorf("unexpected reply: %s", reply)
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "bfleet", "area", "OBJECT", `{"type":"Polygon","coordinates":[[[0,0],[2,0],[2,1],[1,1],[1,2],[0,2],[0,0]]]}`}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	const bounds = `{"sw":{"lat":0,"lon":0},"ne":{"lat":2,"lon":2}}`
+	for _, args := range [][]interface{}{
+		{"GET", "bfleet", "area"},
+		{"SCAN", "bfleet"},
+	} {
+		reply, err = redis.String(conn.Do(args[0].(string), args[1:]...))
+		if err != nil {
+			return err
+		}
+		obj := gjson.Parse(reply)
+		if args[0] == "SCAN" {
+			obj = obj.Get("objects.0")
+		}
+		if obj.Get("bounds").Raw != bounds ||
+			math.Abs(obj.Get("centroid.lat").Float()-5.0/6) > 1e-9 ||
+			math.Abs(obj.Get("centroid.lon").Float()-5.0/6) > 1e-9 {
+			return fmt.Errorf("unexpected reply: %s", reply)
+		}
+	}
+	// off again
+	if _, err := conn.Do("OUTPUT", "json"); err != nil {
+		return err
+	}
+	reply, err = redis.String(conn.Do("GET", "bfleet", "area"))
+	if err != nil {
+		return err
+	}
+	if gjson.Get(reply, "centroid").Exists() {
+		return fmt.Errorf("unexpected reply: %s", reply)
+	}
+	return nil
+}
+
+func client_OUTPUT_flatbuffers_test(mc *mockServer) error {
+	defer mc.Do("DROP", "fbfleet")
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Do("OUTPUT", "flatbuffers"); err != nil {
+		return err
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "fbfleet", "truck1", "FIELD", "speed", 90, "POINT", 33, -115}, {"OK"},
+		{"SET", "fbfleet", "truck2", "POINT", 33.01, -115.01}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	// the ids of the fields of reply.fbs
+	const ok, fields, objects, count, json = 0, 3, 4, 6, 8
+	const id, lat, distance, values = 0, 2, 4, 5
+	for i := 0; i < 2; i++ {
+		buf, err := redis.Bytes(conn.Do("NEARBY", "fbfleet", "DISTANCE",
+			"POINT", 33, -115, 10000))
+		if err != nil {
+			return err
+		}
+		if flatbuf.Identifier(buf) != "T38R" {
+			return fmt.Errorf("unexpected reply: %q", buf)
+		}
+		reply := flatbuf.Root(buf)
+		if !reply.Bool(ok) || reply.Uint64(count) != 2 ||
+			reply.Vector(fields).String(0) != "speed" {
+			return fmt.Errorf("unexpected reply: %q", buf)
+		}
+		objs := reply.Vector(objects)
+		if objs.Len() != 2 || objs.Table(0).String(id) != "truck1" ||
+			objs.Table(0).Float64(lat) != 33 ||
+			objs.Table(0).Vector(values).String(0) != "90" ||
+			objs.Table(1).Float64(distance) == 0 {
+			return fmt.Errorf("unexpected reply: %q", buf)
+		}
+	}
+	buf, err := redis.Bytes(conn.Do("OUTPUT"))
+	if err != nil {
+		return err
+	}
+	if s := flatbuf.Root(buf).String(json); gjson.Get(s, "output").String() != "flatbuffers" {
+		return fmt.Errorf("unexpected reply: %q", buf)
+	}
+	return nil
+}
+
+func client_netrules_test(mc *mockServer) error {
+	defer mc.Do("CONFIG", "SET", "netrules", "")
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "netrules", "deny 10.0.0.0/33"}, {"ERR Invalid argument 'deny 10.0.0.0/33' for CONFIG SET 'netrules'"},
+		{"CONFIG", "SET", "netrules", "allow 127.0.0.1 config @read, deny 127.0.0.0/8 @write"}, {"OK"},
+		{"CONFIG", "GET", "netrules"}, {"[netrules allow 127.0.0.1 config @read, deny 127.0.0.0/8 @write]"},
+		{"SET", "net", "truck", "POINT", 33, -115}, {"ERR command not allowed from this network"},
+		{"GET", "net", "truck"}, {nil},
+		{"CONFIG", "SET", "netrules", "deny 10.0.0.0/8"}, {"OK"},
+		{"SET", "net", "truck", "POINT", 33, -115}, {"OK"},
+		{"DROP", "net"}, {1},
+	})
+}
+
+// clientID returns the id of the client with a name, from CLIENT LIST.
+func clientID(mc *mockServer, name string) (string, error) {
+	list, err := redis.String(mc.Do("CLIENT", "LIST"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(list, "\n") {
+		if strings.Contains(line, " name="+name+" ") {
+			return strings.TrimPrefix(strings.Fields(line)[0], "id="), nil
+		}
+	}
+	return "", fmt.Errorf("no client named %s", name)
+}
+
+func client_KILL_test(mc *mockServer) error {
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Do("CLIENT", "SETNAME", "victim"); err != nil {
+		return err
+	}
+	id, err := clientID(mc, "victim")
+	if err != nil {
+		return err
+	}
+	list, err := redis.String(mc.Do("CLIENT", "LIST", "ID", id, "USER", "default"))
+	if err != nil {
+		return err
+	}
+	if strings.Count(list, "\n") != 1 || !strings.Contains(list, " name=victim ") ||
+		!strings.Contains(list, " omem=0") {
+		return fmt.Errorf("expected the victim, got %q", list)
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"CLIENT", "LIST", "USER", "nobody"}, {nil},
+		{"CLIENT", "LIST", "NAME", "victim"}, {"ERR invalid argument 'NAME'"},
+		{"CLIENT", "KILL", "ID", "999999"}, {0},
+		{"CLIENT", "KILL", "127.0.0.1:1"}, {"ERR No such client"},
+		{"CLIENT", "KILL", "ID", id, "SKIPME", "maybe"}, {"ERR invalid argument 'maybe'"},
+		{"CLIENT", "KILL", "ID", id, "USER", "default"}, {1},
+	}); err != nil {
+		return err
+	}
+	if _, err := conn.Do("PING"); err == nil {
+		return errors.New("expected the connection to be closed")
+	}
+	return nil
+}
+
+func client_limits_test(mc *mockServer) error {
+	defer mc.Do("CONFIG", "SET", "timeout", "0")
+	defer mc.Do("CONFIG", "SET", "client-output-buffer-limit", "0")
+
+	// an idle client is closed
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Do("CONFIG", "SET", "timeout", "1"); err != nil {
+		return err
+	}
+	time.Sleep(time.Millisecond * 1500)
+	if _, err := conn.Do("PING"); err == nil {
+		return errors.New("expected the idle connection to be closed")
+	}
+	if _, err := mc.Do("CONFIG", "SET", "timeout", "0"); err != nil {
+		return err
+	}
+
+	// a client is closed instead of writing an output over the limit
+	conn2, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn2.Close()
+	if err := mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "client-output-buffer-limit", "1kb"}, {"OK"},
+		{"CONFIG", "GET", "client-output-buffer-limit"}, {"[client-output-buffer-limit 1kb]"},
+		{"SET", "limits", "big", "STRING", strings.Repeat("x", 2048)}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	if _, err := conn2.Do("GET", "limits", "big"); err == nil {
+		return errors.New("expected the connection to be closed")
+	}
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "client-output-buffer-limit", "0"}, {"OK"},
+		{"DROP", "limits"}, {1},
+	})
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func subTestCluster(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "disabled", cluster_disabled_test)
+	runStep(t, mc, "slots", cluster_slots_test)
+}
+
+func cluster_disabled_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"CLUSTER", "KEYSLOT", "foo"}, {12182},
+		{"CLUSTER", "KEYSLOT", "{foo}.fleet"}, {12182},
+		{"CLUSTER", "KEYSLOT"}, {"ERR wrong number of arguments for 'cluster' command"},
+		{"CLUSTER", "MYID"}, {"ERR cluster disabled"},
+		{"CLUSTER", "INFO"}, {"[cluster_enabled 0]"},
+		{"CONFIG", "SET", "clusteraddr", "nope"}, {"ERR Invalid argument 'nope' for CONFIG SET 'clusteraddr'"},
+	})
+}
+
+func cluster_slots_test(mc *mockServer) error {
+	defer func() {
+		mc.Do("CONFIG", "SET", "clusteraddr", "")
+		mc.Do("DROP", "fleet")
+	}()
+	addr := fmt.Sprintf("localhost:%d", mc.port)
+	var myid string
+	err := mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "clusteraddr", addr}, {"OK"},
+		{"CLUSTER", "MYID"}, {func(v interface{}) (resp, expect interface{}) {
+			myid = fmt.Sprint(v)
+			return nil, nil
+		}},
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"CLUSTERDOWN hash slot not served"},
+		{"CLUSTER", "ADDSLOTSRANGE", 0, 16384}, {"ERR invalid argument '16384'"},
+		{"CLUSTER", "ADDSLOTSRANGE", 10, 0}, {"ERR invalid argument '0'"},
+		{"CLUSTER", "ADDSLOTSRANGE", 0}, {"ERR wrong number of arguments for 'cluster' command"},
+		{"CLUSTER", "ADDSLOTSRANGE", 0, 16383}, {"OK"},
+		{"CLUSTER", "ADDSLOTS", 1}, {"ERR slot 1 is already served"},
+		{"CLUSTER", "INFO"}, {func(v interface{}) (resp, expect interface{}) {
+			s := fmt.Sprint(v)
+			if !strings.Contains(s, "cluster_state ok") ||
+				!strings.Contains(s, "cluster_slots_served 16384") {
+				return v, "cluster_state ok ... cluster_slots_served 16384"
+			}
+			return nil, nil
+		}},
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"OK"},
+		{"GET", "fleet", "truck1", "POINT"}, {"[33 -115]"},
+		{"CLUSTER", "KEYSLOT", "fleet"}, {14574},
+		{"CLUSTER", "COUNTKEYSINSLOT", 14574}, {1},
+		{"CLUSTER", "GETKEYSINSLOT", 14574, 10}, {"[fleet]"},
+		{"CLUSTER", "GETKEYSINSLOT", 14574}, {"ERR wrong number of arguments for 'cluster' command"},
+		{"RENAME", "fleet", "fleet2"}, {"CROSSSLOT keys in request don't hash to the same slot"},
+		{"CLUSTER", "DELSLOTS", 14574}, {"OK"},
+		{"GET", "fleet", "truck1"}, {"CLUSTERDOWN hash slot not served"},
+		{"CLUSTER", "ADDSLOTS", 14574}, {"OK"},
+		{"CLUSTER", "SETSLOT", 14574, "NODE", "nope"}, {"ERR unknown node"},
+		{"CLUSTER", "FORGET", "nope"}, {"ERR unknown node"},
+		{"CLUSTER", "NOPE"}, {"ERR invalid argument 'nope'"},
+	})
+	if err != nil {
+		return err
+	}
+	// a slot that is assigned to another node redirects
+	topo := `{"epoch":1000,"nodes":[{"id":"other","addr":"localhost:1","epoch":1}],` +
+		`"slots":[{"start":14574,"end":14574,"node":"other","epoch":1000}]}`
+	return mc.DoBatch([][]interface{}{
+		{"CLUSTER", "TOPOLOGY", topo}, {func(v interface{}) (resp, expect interface{}) {
+			return nil, nil
+		}},
+		{"GET", "fleet", "truck1"}, {"MOVED 14574 localhost:1"},
+		{"CLUSTER", "FORGET", "other"}, {"ERR cannot forget a node that serves slots"},
+		{"CLUSTER", "FORGET", myid}, {"ERR cannot forget self"},
+		{"CLUSTER", "SETSLOT", 14574, "NODE", myid}, {"OK"},
+		{"CLUSTER", "FORGET", "other"}, {"OK"},
+		{"GET", "fleet", "truck1", "POINT"}, {"[33 -115]"},
+	})
+}
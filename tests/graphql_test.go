@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func subTestGraphQL(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "queries", graphql_queries_test)
+	runStep(t, mc, "http", graphql_http_test)
+	runStep(t, mc, "acl", graphql_acl_test)
+}
+
+func graphql_queries_test(mc *mockServer) error {
+	defer mc.Do("CONFIG", "SET", "graphql", "no")
+	defer mc.Do("DROP", "gqlfleet")
+	if err := mc.DoBatch([][]interface{}{
+		{"GRAPHQL", "{ collections { key } }"}, {"ERR GraphQL is disabled, see CONFIG SET graphql yes"},
+		{"CONFIG", "SET", "graphql", "maybe"}, {"ERR Invalid argument 'maybe' for CONFIG SET 'graphql'"},
+		{"CONFIG", "SET", "graphql", "yes"}, {"OK"},
+		{"CONFIG", "GET", "graphql"}, {"[graphql yes]"},
+		{"SET", "gqlfleet", "truck1", "FIELD", "speed", 90, "POINT", 33.5, -115.5}, {"OK"},
+		{"SET", "gqlfleet", "truck2", "FIELD", "speed", 20, "POINT", 33, -112}, {"OK"},
+		{"SET", "gqlfleet", "truck3", "POINT", 10, 10}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	for _, tc := range []struct {
+		query, vars, expect string
+	}{
+		{`{ collection(key: "gqlfleet") { key count } nope: collection(key: "nope") { key } }`, "",
+			`{"data":{"collection":{"key":"gqlfleet","count":3},"nope":null}}`},
+		{`{ collections(match: "gql*") { key } }`, "",
+			`{"data":{"collections":[{"key":"gqlfleet"}]}}`},
+		{`query ($id: String!) { object(key: "gqlfleet", id: $id) { id object speed: field(name: "speed") } }`, `{"id":"truck1"}`,
+			`{"data":{"object":{"id":"truck1","object":{"type":"Point","coordinates":[-115.5,33.5]},"speed":90}}}`},
+		{`{ object(key: "gqlfleet", id: "nope") { id } }`, "",
+			`{"data":{"object":null}}`},
+		{`{ nearby(key: "gqlfleet", lat: 33.5, lon: -115.5, radius: 500000) { count objects { id } } }`, "",
+			`{"data":{"nearby":{"count":2,"objects":[{"id":"truck1"},{"id":"truck2"}]}}}`},
+		{`{ within(key: "gqlfleet", bounds: {minLat: 32, minLon: -116, maxLat: 34, maxLon: -114}, where: {field: "speed", min: 80, max: 100}) { objects { id fields { name value } } } }`, "",
+			`{"data":{"within":{"objects":[{"id":"truck1","fields":[{"name":"speed","value":90}]}]}}}`},
+		{`{ scan(key: "gqlfleet", desc: true, limit: 2) { cursor objects { id } } }`, "",
+			`{"data":{"scan":{"cursor":2,"objects":[{"id":"truck3"},{"id":"truck2"}]}}}`},
+		{`query Q($near: Boolean = false) { collection(key: "gqlfleet") { ...c } } fragment c on Collection { count @skip(if: $near) }`, "",
+			`{"data":{"collection":{"count":3}}}`},
+		{`{ collection(key: "gqlfleet") { nope } }`, "",
+			`{"errors":[{"message":"Cannot query field \"nope\" on type \"Collection\".","path":["collection","nope"]}],"data":null}`},
+		{`{ within(key: "gqlfleet") { count } }`, "",
+			`{"errors":[{"message":"Field \"within\" requires one of the arguments \"bounds\" or \"object\".","path":["within"]}],"data":null}`},
+		{`mutation { nope }`, "",
+			`{"errors":[{"message":"The mutation operations are not supported."}],"data":null}`},
+	} {
+		args := []interface{}{tc.query}
+		if tc.vars != "" {
+			args = append(args, tc.vars)
+		}
+		v, err := mc.Do("GRAPHQL", args...)
+		if err != nil {
+			return err
+		}
+		res := fmt.Sprintf("%s", v)
+		if res != tc.expect {
+			return fmt.Errorf("%s: expected %s, got %s", tc.query, tc.expect, res)
+		}
+	}
+	return nil
+}
+
+func graphql_http_test(mc *mockServer) error {
+	defer mc.Do("CONFIG", "SET", "graphql", "no")
+	defer mc.Do("DROP", "gqlfleet")
+	if err := mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "graphql", "yes"}, {"OK"},
+		{"SET", "gqlfleet", "truck1", "POINT", 33, -115}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	get := func(res *http.Response, err error) (string, error) {
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return "", err
+		}
+		if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			return "", fmt.Errorf("expected application/json, got '%s'", ct)
+		}
+		return string(body), nil
+	}
+	addr := fmt.Sprintf("http://localhost:%d/graphql", mc.port)
+	body, err := get(http.Post(addr, "application/json", strings.NewReader(
+		`{"query":"query ($key: String!) { collection(key: $key) { count } }","variables":{"key":"gqlfleet"}}`)))
+	if err != nil {
+		return err
+	}
+	if gjson.Get(body, "data.collection.count").Int() != 1 {
+		return fmt.Errorf("unexpected response: %s", body)
+	}
+	body, err = get(http.Get(addr + "?query=" +
+		url.QueryEscape(`{ object(key: "gqlfleet", id: "truck1") { id } }`)))
+	if err != nil {
+		return err
+	}
+	if body != `{"data":{"object":{"id":"truck1"}}}` {
+		return fmt.Errorf("unexpected response: %s", body)
+	}
+	return nil
+}
+
+func graphql_acl_test(mc *mockServer) error {
+	defer func() {
+		mc.ResetConn()
+		mc.Do("ACL", "DELUSER", "gqlreader")
+		mc.Do("DROP", "gql:1")
+		mc.Do("DROP", "gqlsecret")
+		mc.Do("CONFIG", "SET", "graphql", "no")
+	}()
+	noperm := func(field string) string {
+		return `{"errors":[{"message":"no permission to access the key ` +
+			`'gqlsecret'","path":["` + field + `"]}],"data":null}`
+	}
+	// the resolvers only reach the keys of the user
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "graphql", "yes"}, {"OK"},
+		{"SET", "gql:1", "truck1", "POINT", 33, -115}, {"OK"},
+		{"SET", "gqlsecret", "s1", "POINT", 33, -115}, {"OK"},
+		{"ACL", "SETUSER", "gqlreader", "on", ">secret", "~gql:*", "+@read"}, {"OK"},
+		{"AUTH", "gqlreader", "secret"}, {"OK"},
+		{"GRAPHQL", `{ collections(match: "gql*") { key } }`}, {`{"data":{"collections":[{"key":"gql:1"}]}}`},
+		{"GRAPHQL", `{ object(key: "gql:1", id: "truck1") { id } }`}, {`{"data":{"object":{"id":"truck1"}}}`},
+		{"GRAPHQL", `{ object(key: "gqlsecret", id: "s1") { id } }`}, {noperm("object")},
+		{"GRAPHQL", `{ collection(key: "gqlsecret") { count } }`}, {noperm("collection")},
+		{"GRAPHQL", `{ scan(key: "gqlsecret") { count } }`}, {noperm("scan")},
+		{"GRAPHQL", `{ nearby(key: "gqlsecret", lat: 33, lon: -115) { count } }`}, {noperm("nearby")},
+	})
+}
@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/internal/qservice"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func subTestGRPC(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "queries", grpc_queries_test)
+	runStep(t, mc, "subscribe", grpc_subscribe_test)
+	runStep(t, mc, "command", grpc_command_test)
+}
+
+func grpcClient(mc *mockServer) (qservice.QueryServiceClient, func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, fmt.Sprintf("localhost:%d", mc.grpcPort),
+		grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return nil, nil, err
+	}
+	return qservice.NewQueryServiceClient(conn), func() { conn.Close() }, nil
+}
+
+func grpc_queries_test(mc *mockServer) error {
+	defer mc.Do("DROP", "grpcfleet")
+	qs, close, err := grpcClient(mc)
+	if err != nil {
+		return err
+	}
+	defer close()
+	ctx := context.Background()
+	set, err := qs.Set(ctx, &qservice.SetRequest{
+		Key: "grpcfleet", Id: "truck1",
+		Point:  &qservice.Point{Lat: 33.5, Lon: -115.5},
+		Fields: []*qservice.Field{{Name: "speed", Value: "90"}},
+	})
+	if err != nil || !set.Ok {
+		return fmt.Errorf("expected ok, got %v %v", set, err)
+	}
+	set, err = qs.Set(ctx, &qservice.SetRequest{
+		Key: "grpcfleet", Id: "truck1", Nx: true,
+		Object: `{"type":"Point","coordinates":[-115,33]}`,
+	})
+	if err != nil || set.Ok {
+		return fmt.Errorf("expected not ok, got %v %v", set, err)
+	}
+	if _, err := qs.Set(ctx, &qservice.SetRequest{
+		Key: "grpcfleet", Id: "truck2",
+		Object: `{"type":"Point","coordinates":[-112,33]}`,
+		Fields: []*qservice.Field{{Name: "driver", Value: "alice"}},
+	}); err != nil {
+		return err
+	}
+	if _, err := mc.Do("SET", "grpcfleet", "truck3", "POINT", 10, 10); err != nil {
+		return err
+	}
+
+	get, err := qs.Get(ctx, &qservice.GetRequest{
+		Key: "grpcfleet", Id: "truck2", WithFields: true,
+	})
+	if err != nil {
+		return err
+	}
+	if get.Object != `{"type":"Point","coordinates":[-112,33]}` ||
+		len(get.Fields) != 1 || get.Fields[0].Name != "driver" ||
+		get.Fields[0].Value != "alice" {
+		return fmt.Errorf("unexpected get: %v", get)
+	}
+	_, err = qs.Get(ctx, &qservice.GetRequest{Key: "grpcfleet", Id: "nope"})
+	if status.Code(err) != codes.NotFound {
+		return fmt.Errorf("expected not found, got %v", err)
+	}
+
+	nearby, err := qs.Nearby(ctx, &qservice.NearbyRequest{
+		Key: "grpcfleet", Point: &qservice.Point{Lat: 33.5, Lon: -115.5},
+		Radius: 500000,
+	})
+	if err != nil {
+		return err
+	}
+	if len(nearby.Objects) != 2 || nearby.Objects[0].Id != "truck1" ||
+		nearby.Objects[0].Distance != 0 || nearby.Objects[1].Distance == 0 {
+		return fmt.Errorf("unexpected nearby: %v", nearby)
+	}
+	var speed string
+	for _, f := range nearby.Objects[0].Fields {
+		if f.Name == "speed" {
+			speed = f.Value
+		}
+	}
+	if speed != "90" {
+		return fmt.Errorf("expected the speed, got %v", nearby.Objects[0].Fields)
+	}
+	within, err := qs.Within(ctx, &qservice.WithinRequest{
+		Key: "grpcfleet", Bounds: &qservice.Bounds{
+			MinLat: 32, MinLon: -116, MaxLat: 34, MaxLon: -114,
+		},
+		Where: []*qservice.Where{{Field: "speed", Min: 80, Max: 100}},
+	})
+	if err != nil {
+		return err
+	}
+	if len(within.Objects) != 1 || within.Objects[0].Id != "truck1" ||
+		!gjson.Valid(within.Objects[0].Object) {
+		return fmt.Errorf("unexpected within: %v", within)
+	}
+	scan, err := qs.Scan(ctx, &qservice.ScanRequest{
+		Key: "grpcfleet", Desc: true, Limit: 2,
+	})
+	if err != nil {
+		return err
+	}
+	if len(scan.Objects) != 2 || scan.Objects[0].Id != "truck3" ||
+		scan.Cursor != 2 {
+		return fmt.Errorf("unexpected scan: %v", scan)
+	}
+	_, err = qs.Within(ctx, &qservice.WithinRequest{Key: "grpcfleet"})
+	if status.Code(err) != codes.InvalidArgument {
+		return fmt.Errorf("expected invalid argument, got %v", err)
+	}
+	return nil
+}
+
+func grpc_subscribe_test(mc *mockServer) error {
+	defer mc.Do("DELCHAN", "grpcchan")
+	defer mc.Do("DROP", "grpcfleet")
+	qs, close, err := grpcClient(mc)
+	if err != nil {
+		return err
+	}
+	defer close()
+	if _, err := mc.Do("SETCHAN", "grpcchan", "NEARBY", "grpcfleet", "FENCE",
+		"DETECT", "enter", "POINT", 33, -115, 1000); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := qs.Subscribe(ctx, &qservice.SubscribeRequest{
+		Channels: []string{"grpc*"}, Pattern: true,
+	})
+	if err != nil {
+		return err
+	}
+	// wait for the subscription
+	for {
+		n, err := redisInt(mc.Do("PUBLISH", "grpcchan", "hello"))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ev, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if ev.Channel != "grpcchan" || ev.Json != "hello" || ev.Detect != "" {
+		return fmt.Errorf("unexpected message: %v", ev)
+	}
+	if _, err := mc.Do("SET", "grpcfleet", "truck1", "POINT", 33, -115); err != nil {
+		return err
+	}
+	for {
+		ev, err = stream.Recv()
+		if err != nil {
+			return err
+		}
+		if ev.Json != "hello" {
+			break
+		}
+	}
+	if ev.Detect != "enter" || ev.Key != "grpcfleet" || ev.Id != "truck1" ||
+		ev.Command != "set" || !gjson.Valid(ev.Object) {
+		return fmt.Errorf("unexpected event: %v", ev)
+	}
+	return nil
+}
+
+func grpc_command_test(mc *mockServer) error {
+	defer mc.Do("DROP", "grpcfleet")
+	qs, close, err := grpcClient(mc)
+	if err != nil {
+		return err
+	}
+	defer close()
+	ctx := context.Background()
+	reply, err := qs.Command(ctx, &qservice.CommandRequest{
+		Args: []string{"SET", "grpcfleet", "truck1", "FIELD", "speed", "90",
+			"POINT", "33", "-115"},
+	})
+	if err != nil || !reply.Ok {
+		return fmt.Errorf("expected ok, got %v %v", reply, err)
+	}
+	reply, err = qs.Command(ctx, &qservice.CommandRequest{
+		Args: []string{"GET", "grpcfleet", "truck1", "POINT"},
+	})
+	if err != nil {
+		return err
+	}
+	if reply.Point == nil || reply.Point.Lat != 33 || reply.Point.Lon != -115 {
+		return fmt.Errorf("unexpected reply: %v", reply)
+	}
+	reply, err = qs.Command(ctx, &qservice.CommandRequest{
+		Args: []string{"SCAN", "grpcfleet"},
+	})
+	if err != nil {
+		return err
+	}
+	if len(reply.Objects) != 1 || reply.Objects[0].Id != "truck1" ||
+		len(reply.Objects[0].Fields) != 1 ||
+		reply.Objects[0].Fields[0].Name != "speed" || reply.Json != "" {
+		return fmt.Errorf("unexpected reply: %v", reply)
+	}
+	reply, err = qs.Command(ctx, &qservice.CommandRequest{
+		Args: []string{"STATS", "grpcfleet"},
+	})
+	if err != nil {
+		return err
+	}
+	if !gjson.Get(reply.Json, "stats.0.num_objects").Exists() {
+		return fmt.Errorf("unexpected reply: %v", reply)
+	}
+	_, err = qs.Command(ctx, &qservice.CommandRequest{
+		Args: []string{"GET", "grpcfleet", "nope"},
+	})
+	if status.Code(err) != codes.NotFound {
+		return fmt.Errorf("expected not found, got %v", err)
+	}
+	return nil
+}
+
+func redisInt(v interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, errors.New("expected an integer")
+	}
+	return n, nil
+}
@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func subTestImport(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "import export", import_export_test)
+	runStep(t, mc, "import gis files", import_gis_files_test)
+	runStep(t, mc, "import csv", import_csv_test)
+	runStep(t, mc, "dump", import_dump_test)
+}
+
+func import_export_test(mc *mockServer) error {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "parcels.geojson")
+	dst := filepath.Join(dir, "export.geojson")
+	arrow := filepath.Join(dir, "export.arrow")
+	parquet := filepath.Join(dir, "export.parquet")
+	if err := ioutil.WriteFile(src, []byte(`{
+		"type": "FeatureCollection",
+		"name": "parcels",
+		"features": [
+			{"type":"Feature","properties":{"apn":"p1","area":12.5},
+			 "geometry":{"type":"Point","coordinates":[-115,33]}},
+			{"type":"Feature","properties":{"apn":"p2","area":3,"zone":"r1"},
+			 "geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}}
+		]
+	}`), 0600); err != nil {
+		return err
+	}
+	bad := filepath.Join(dir, "bad.geojson")
+	if err := ioutil.WriteFile(bad, []byte(`{"type":"Feature"}`), 0600); err != nil {
+		return err
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"IMPORT", "parcels", src}, {"ERR feature 0: missing id"},
+		{"IMPORT", "parcels", bad}, {"ERR invalid FeatureCollection"},
+		{"IMPORT", "parcels", src, "ID"}, {"ERR wrong number of arguments for 'import' command"},
+		{"IMPORT", "parcels", src, "ID", "apn", "FIELD", "zone"}, {"ERR feature 1: property 'zone' is not a number"},
+		{"IMPORT", "parcels", src, "ID", "apn", "FIELD", "area"}, {2},
+		{"SCAN", "parcels", "IDS"}, {"[0 [p1 p2]]"},
+		{"GET", "parcels", "p1", "WITHFIELDS", "POINT"}, {"[[33 -115] [area 12.5]]"},
+		{"EXPORT", "parcels", dst}, {2},
+		{"EXPORT", "missing", dst}, {"ERR key not found"},
+		{"EXPORT", "parcels", "ARROW"}, {"ERR wrong number of arguments for 'export' command"},
+		{"EXPORT", "parcels", "ARROW", arrow}, {2},
+		{"EXPORT", "parcels", "parquet", parquet}, {2},
+		{"EXPORT", "parcels"}, {func(v interface{}) (resp, expect interface{}) {
+			s, _ := v.(string)
+			return gjson.Get(s, "features.#").Int() == 2 &&
+				gjson.Get(s, "features.1.id").String() == "p2" &&
+				gjson.Get(s, "features.1.properties.zone").String() == "r1", true
+		}},
+	}); err != nil {
+		return err
+	}
+	for path, magic := range map[string]string{arrow: "ARROW1", parquet: "PAR1"} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(string(data), magic) ||
+			!strings.HasSuffix(string(data), magic) ||
+			!strings.Contains(string(data), "area") {
+			return fmt.Errorf("unexpected export %s", path)
+		}
+	}
+	return mc.DoBatch([][]interface{}{
+		{"DROP", "parcels"}, {1},
+		{"IMPORT", "parcels", dst, "FIELD", "area"}, {2},
+		{"GET", "parcels", "p2", "WITHFIELDS", "OBJECT"}, {func(v interface{}) (resp, expect interface{}) {
+			return strings.Contains(fmt.Sprint(v), "[area 3]"), true
+		}},
+	})
+}
+
+func import_dump_test(mc *mockServer) error {
+	dir, err := ioutil.TempDir("", "dump")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	defer mc.Do("DROP", "dump:fleet")
+	defer mc.Do("DROP", "dump:other")
+	cmds := filepath.Join(dir, "fleet.aof")
+	snap := filepath.Join(dir, "fleet.snap")
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "dump:fleet", "truck1", "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"SET", "dump:fleet", "truck2", "POINT", 34, -116}, {"OK"},
+		{"SET", "dump:other", "bus", "POINT", 35, -117}, {"OK"},
+		{"SETSCHEMA", "dump:fleet", "FIELD", "speed", "NUMBER"}, {"OK"},
+		{"DUMP"}, {"ERR wrong number of arguments for 'dump' command"},
+		{"DUMP", cmds, "MATCH"}, {"ERR wrong number of arguments for 'dump' command"},
+		{"DUMP", cmds, "NOPE"}, {"ERR invalid argument 'NOPE'"},
+		{"DUMP", cmds, "COMMANDS", "SNAPSHOT"}, {"ERR duplicate argument 'SNAPSHOT'"},
+		{"DUMP", cmds, "MATCH", "dump:fl*"}, {2},
+		{"DUMP", snap, "MATCH", "dump:fl*", "MATCH", "dump:oth*", "SNAPSHOT"}, {3},
+	}); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(cmds)
+	if err != nil {
+		return err
+	}
+	s := string(data)
+	if !strings.Contains(s, "truck1") || !strings.Contains(s, "truck2") ||
+		!strings.Contains(s, "setschema") || strings.Contains(s, "bus") {
+		return fmt.Errorf("unexpected dump %q", s)
+	}
+	data, err = ioutil.ReadFile(snap)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(string(data), "TILE38SNAP") ||
+		!strings.Contains(string(data), "bus") {
+		return fmt.Errorf("unexpected dump snapshot")
+	}
+	return nil
+}
+
+func import_gis_files_test(mc *mockServer) error {
+	shp, err := filepath.Abs("../internal/gisfile/testdata/polygons.shp")
+	if err != nil {
+		return err
+	}
+	gpkg, err := filepath.Abs("../internal/gisfile/testdata/test.gpkg")
+	if err != nil {
+		return err
+	}
+	return mc.DoBatch([][]interface{}{
+		{"IMPORT", "lots", shp, "FIELD", "AREA"}, {2},
+		{"SCAN", "lots", "IDS"}, {"[0 [1 3]]"},
+		{"GET", "lots", "3", "WITHFIELDS", "BOUNDS"}, {"[[[0 20] [5 25]] [AREA 25.5]]"},
+		{"IMPORT", "wells", gpkg, "LAYER", "wells"}, {1},
+		{"GET", "wells", "1", "OBJECT"}, {func(v interface{}) (resp, expect interface{}) {
+			return gjson.Get(fmt.Sprint(v), "geometry.coordinates").Raw, "[-115.5,33.25,-120.5]"
+		}},
+		{"IMPORT", "parcels", gpkg, "LAYER", "missing"}, {"ERR layer 'missing' not found"},
+		{"IMPORT", "parcels", gpkg, "ID", "apn", "FIELD", "area"}, {60},
+		{"GET", "parcels", "apn-7", "WITHFIELDS", "POINT"}, {func(v interface{}) (resp, expect interface{}) {
+			return strings.Contains(fmt.Sprint(v), "[area 10.5]"), true
+		}},
+		{"DROP", "lots"}, {1},
+		{"DROP", "wells"}, {1},
+		{"DROP", "parcels"}, {1},
+	})
+}
+
+func import_csv_test(mc *mockServer) error {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "trucks.csv")
+	if err := ioutil.WriteFile(src, []byte("\ufeffvehicle,Latitude,Longitude,speed,driver\n"+
+		"truck1,33.5,-115.25,55,\"Smith, J\"\n"+
+		"truck2,,,,\n"+
+		"truck3,34,-116,,Jones\n"), 0600); err != nil {
+		return err
+	}
+	bad := filepath.Join(dir, "bad.csv")
+	if err := ioutil.WriteFile(bad, []byte("id,lat,lon\n1,33,-115\n2,north,-115\n"), 0600); err != nil {
+		return err
+	}
+	return mc.DoBatch([][]interface{}{
+		{"IMPORT", "trucks", src, "ID", "vehicle"}, {"ERR ID and POINT are required for csv files"},
+		{"IMPORT", "trucks", src, "ID", "vehicle", "POINT", "latitude"}, {"ERR wrong number of arguments for 'import' command"},
+		{"IMPORT", "trucks", src, "ID", "vehicle", "POINT", "lat", "lon"}, {"ERR column 'lat' not found"},
+		{"IMPORT", "trucks", src, "ID", "vehicle", "POINT", "latitude", "longitude", "FIELD", "driver", "driver"}, {"ERR row 0: column 'driver' is not a number"},
+		{"IMPORT", "trucks", src, "ID", "vehicle", "POINT", "latitude", "longitude", "LAYER", "x"}, {"ERR invalid argument 'LAYER'"},
+		{"IMPORT", "trucks", bad, "ID", "id", "POINT", "lat", "lon"}, {"ERR row 1: invalid latitude"},
+		{"IMPORT", "trucks", src, "ID", "vehicle", "POINT", "latitude", "longitude", "FIELD", "mph", "speed"}, {2},
+		{"SCAN", "trucks", "IDS"}, {"[0 [truck1 truck3]]"},
+		{"GET", "trucks", "truck1", "WITHFIELDS", "POINT"}, {"[[33.5 -115.25] [mph 55]]"},
+		{"GET", "trucks", "truck3", "POINT"}, {"[34 -116]"},
+		{"DROP", "trucks"}, {1},
+	})
+}
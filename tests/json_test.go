@@ -6,6 +6,8 @@ func subTestJSON(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "basic", json_JSET_basic_test)
 	runStep(t, mc, "geojson", json_JSET_geojson_test)
 	runStep(t, mc, "number", json_JSET_number_test)
+	runStep(t, mc, "jsonpath", json_JGET_jsonpath_test)
+	runStep(t, mc, "array", json_JSET_array_test)
 
 }
 func json_JSET_basic_test(mc *mockServer) error {
@@ -54,3 +56,36 @@ func json_JSET_number_test(mc *mockServer) error {
 		{"JGET", "mykey", "myid1"}, {`{"hello":1.0e10}`},
 	})
 }
+
+func json_JGET_jsonpath_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "route1", "OBJECT", `{"type":"Feature","geometry":{"type":"Point","coordinates":[-115,33]},"properties":{"stops":[{"id":"a","status":"late","eta":12},{"id":"b","status":"ok","eta":3},{"id":"c","status":"late","eta":30}]}}`}, {"OK"},
+		{"JGET", "mykey", "route1", `$.properties.stops[?(@.status=="late")].id`}, {`["a","c"]`},
+		{"JGET", "mykey", "route1", `$.properties.stops[?(@.eta > 10)].id`}, {`["a","c"]`},
+		{"JGET", "mykey", "route1", `$.properties.stops[*].id`}, {`["a","b","c"]`},
+		{"JGET", "mykey", "route1", `$.properties.stops[1]['status']`}, {`ok`},
+		{"JGET", "mykey", "route1", `$.properties.stops[1].status`, "RAW"}, {`"ok"`},
+		{"JGET", "mykey", "route1", `$.geometry.type`, "properties.stops.0.id", "missing"}, {"[Point a nil]"},
+		{"JGET", "mykey", "route1", `$.geometry.type`, "properties.stops.0.id", "RAW"}, {[]interface{}{`"Point"`, `"a"`}},
+		{"JGET", "mykey", "route1", `$..id`}, {"ERR invalid argument '$..id'"},
+		{"JSET", "mykey", "route1", `$.properties.stops[*].status`, "ok"}, {"ERR invalid argument '$.properties.stops[*].status'"},
+		{"JSET", "mykey", "route1", `$.properties.stops[2].status`, "ok"}, {"OK"},
+		{"JGET", "mykey", "route1", `$.properties.stops[?(@.status=="late")].id`}, {`["a"]`},
+		{"JDEL", "mykey", "route1", `$.properties.stops[0].eta`}, {"OK"},
+		{"JGET", "mykey", "route1", `$.properties.stops[0]`}, {`{"id":"a","status":"late"}`},
+	})
+}
+
+func json_JSET_array_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"JSET", "mykey", "myid2", "tags", "hot", "APPEND"}, {"OK"},
+		{"JSET", "mykey", "myid2", "tags", "dry", "APPEND"}, {"OK"},
+		{"JSET", "mykey", "myid2", "tags", `{"a":1}`, "RAW", "INSERT", 1}, {"OK"},
+		{"JSET", "mykey", "myid2", "$.tags", "10", "STR", "INSERT", 0}, {"OK"},
+		{"JGET", "mykey", "myid2"}, {`{"tags":["10","hot",{"a":1},"dry"]}`},
+		{"JSET", "mykey", "myid2", "tags", "x", "INSERT", 5}, {"ERR invalid argument '5'"},
+		{"JSET", "mykey", "myid2", "tags.0", "x", "APPEND"}, {"ERR path is not an array"},
+		{"JSET", "mykey", "myid2", "tags", "x", "INSERT"}, {"ERR wrong number of arguments for 'jset' command"},
+		{"JGET", "mykey", "myid2"}, {`{"tags":["10","hot",{"a":1},"dry"]}`},
+	})
+}
@@ -1,24 +1,59 @@
 package tests
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"net/http"
 	"sort"
+	"strings"
 	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/tile38/core"
 )
 
 func subTestSearch(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "KNN", keys_KNN_test)
 	runStep(t, mc, "KNN_CURSOR", keys_KNN_cursor_test)
+	runStep(t, mc, "KNN_RADIUS", keys_KNN_radius_test)
+	runStep(t, mc, "KNN_SORTBY", keys_KNN_sortby_test)
+	runStep(t, mc, "KNN_SCORE", keys_KNN_score_test)
+	runStep(t, mc, "KNN_AREA", keys_KNN_area_test)
+	runStep(t, mc, "WITHIN_SORTBY", keys_WITHIN_sortby_test)
 	runStep(t, mc, "WITHIN_CIRCLE", keys_WITHIN_CIRCLE_test)
 	runStep(t, mc, "INTERSECTS_CIRCLE", keys_INTERSECTS_CIRCLE_test)
 	runStep(t, mc, "WITHIN", keys_WITHIN_test)
 	runStep(t, mc, "WITHIN_CURSOR", keys_WITHIN_CURSOR_test)
 	runStep(t, mc, "INTERSECTS", keys_INTERSECTS_test)
 	runStep(t, mc, "INTERSECTS_CURSOR", keys_INTERSECTS_CURSOR_test)
+	runStep(t, mc, "BUFFER", keys_BUFFER_test)
+	runStep(t, mc, "MODEL", keys_MODEL_test)
+	runStep(t, mc, "MATRIX", keys_MATRIX_test)
+	runStep(t, mc, "CLUSTERS", keys_CLUSTERS_test)
+	runStep(t, mc, "TILE", keys_TILE_test)
+	runStep(t, mc, "TILE_HTTP", keys_TILE_HTTP_test)
+	runStep(t, mc, "H3", keys_H3_test)
+	runStep(t, mc, "GEOHASH", keys_GEOHASH_test)
+	runStep(t, mc, "TOPOJSON", keys_TOPOJSON_test)
+	runStep(t, mc, "ZRANGE", keys_ZRANGE_test)
+	runStep(t, mc, "SINCE", keys_SINCE_test)
+	runStep(t, mc, "SAMPLE", keys_SAMPLE_test)
+	runStep(t, mc, "STREAM", keys_STREAM_test)
+	runStep(t, mc, "JOIN", keys_JOIN_test)
 	runStep(t, mc, "SCAN_CURSOR", keys_SCAN_CURSOR_test)
+	runStep(t, mc, "SCAN_STABLE", keys_SCAN_STABLE_test)
+	runStep(t, mc, "SCAN_SNAPSHOT", keys_SCAN_SNAPSHOT_test)
+	runStep(t, mc, "SEARCH_STABLE", keys_SEARCH_STABLE_test)
 	runStep(t, mc, "SEARCH_CURSOR", keys_SEARCH_CURSOR_test)
+	runStep(t, mc, "INDEX", keys_INDEX_test)
 	runStep(t, mc, "MATCH", keys_MATCH_test)
 	runStep(t, mc, "FIELDS", keys_FIELDS_search_test)
+	runStep(t, mc, "STATS", keys_STATS_search_test)
 }
 
 func keys_KNN_test(mc *mockServer) error {
@@ -233,6 +268,109 @@ func keys_INTERSECTS_CURSOR_test(mc *mockServer) error {
 	})
 }
 
+func keys_KNN_radius_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		// b is closer in degrees, but farther in meters
+		{"SET", "mykey", "b", "POINT", 60.012, 0}, {"OK"},
+		{"SET", "mykey", "a", "POINT", 60, 0.019}, {"OK"},
+		{"NEARBY", "mykey", "IDS", "POINT", 60, 0, 1200}, {"[0 [a]]"},
+		{"NEARBY", "mykey", "LIMIT", 1, "IDS", "POINT", 60, 0, 1200}, {"[2 [a]]"},
+		{"NEARBY", "mykey", "IDS", "POINT", 60, 0, 1400}, {"[0 [a b]]"},
+	})
+}
+
+func keys_KNN_sortby_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "1", "FIELD", "speed", 2, "POINT", 33.1, -115}, {"OK"},
+		{"SET", "mykey", "2", "FIELD", "speed", 4, "POINT", 33.2, -115}, {"OK"},
+		{"SET", "mykey", "3", "FIELD", "speed", 1, "POINT", 33.3, -115}, {"OK"},
+		{"SET", "mykey", "4", "FIELD", "speed", 3, "POINT", 33.4, -115}, {"OK"},
+		{"SET", "mykey", "5", "POINT", 33.5, -115}, {"OK"},
+		{"SET", "mykey", "6", "FIELD", "speed", 2, "POINT", 33.6, -115}, {"OK"},
+		{"NEARBY", "mykey", "SORTBY", "speed", "IDS", "POINT", 33, -115}, {"[0 [5 3 1 6 4 2]]"},
+		{"NEARBY", "mykey", "SORTBY", "speed", "DESC", "LIMIT", 2, "IDS", "POINT", 33, -115, 200000}, {"[2 [2 4]]"},
+		{"NEARBY", "mykey", "SORTBY", "speed", "DESC", "CURSOR", 2, "LIMIT", 2, "IDS", "POINT", 33, -115, 200000}, {"[4 [1 6]]"},
+		{"NEARBY", "mykey", "SORTBY", "speed", "DESC", "CURSOR", 4, "LIMIT", 2, "IDS", "POINT", 33, -115, 200000}, {"[6 [3 5]]"},
+		{"NEARBY", "mykey", "SORTBY", "speed", "WHERE", "speed", 1, 3, "LIMIT", 2, "IDS", "POINT", 33, -115, 40000}, {"[2 [3 1]]"},
+		{"NEARBY", "mykey", "SORTBY", "speed", "ASC", "IDS", "POINT", 33, -115, 40000}, {"[0 [3 1 2]]"},
+		{"NEARBY", "mykey", "DESC", "IDS", "POINT", 33, -115}, {"ERR DESC is not allowed for NEARBY"},
+		{"WITHIN", "mykey", "SORTBY", "speed", "IDS", "BOUNDS", 33, -116, 34, -114}, {"ERR SORTBY is not allowed for WITHIN"},
+		{"NEARBY", "mykey", "FENCE", "SORTBY", "speed", "POINT", 33, -115, 1000}, {"ERR SORTBY is not allowed when FENCE is specified"},
+	})
+}
+
+func keys_KNN_score_test(mc *mockServer) error {
+	defer mc.Do("DROP", "scorekey")
+	rank := "distance + (100 - battery) * 1000"
+	return mc.DoBatch([][]interface{}{
+		{"SET", "scorekey", "1", "FIELD", "battery", 90, "POINT", 33.1, -115}, {"OK"},
+		{"SET", "scorekey", "2", "FIELD", "battery", 100, "POINT", 33.2, -115}, {"OK"},
+		{"SET", "scorekey", "3", "FIELD", "battery", 10, "POINT", 33.3, -115}, {"OK"},
+		{"SET", "scorekey", "4", "FIELD", "battery", 100, "POINT", 33.4, -115}, {"OK"},
+		{"NEARBY", "scorekey", "SCORE", rank, "IDS", "POINT", 33, -115}, {"[0 [1 2 4 3]]"},
+		{"NEARBY", "scorekey", "SCORE", rank, "LIMIT", 2, "IDS", "POINT", 33, -115}, {"[2 [1 2]]"},
+		{"NEARBY", "scorekey", "SCORE", rank, "CURSOR", 2, "LIMIT", 2, "IDS", "POINT", 33, -115}, {"[4 [4 3]]"},
+		{"NEARBY", "scorekey", "SCORE", rank, "DESC", "IDS", "POINT", 33, -115, 40000}, {"[0 [3 2 1]]"},
+		{"NEARBY", "scorekey", "SCORE", "max(battery, 95) / 5", "LIMIT", 1, "POINTS", "POINT", 33, -115}, {"[1 [[1 [33.1 -115] [battery 90] 19]]]"},
+		{"NEARBY", "scorekey", "SCORE", "battery +", "IDS", "POINT", 33, -115}, {"ERR invalid score expression 'battery +': unexpected end"},
+		{"NEARBY", "scorekey", "SCORE", "nope(battery)", "IDS", "POINT", 33, -115}, {"ERR invalid score expression 'nope(battery)': unknown function 'nope'"},
+		{"NEARBY", "scorekey", "SCORE", "battery", "SCORE", "battery", "IDS", "POINT", 33, -115}, {"ERR duplicate argument 'SCORE'"},
+		{"NEARBY", "scorekey", "SCORE", "battery", "SORTBY", "battery", "IDS", "POINT", 33, -115}, {"ERR SCORE is not allowed when SORTBY is specified"},
+		{"NEARBY", "scorekey", "SCORE", "battery", "STABLE", "IDS", "POINT", 33, -115}, {"ERR STABLE is not allowed when SCORE is specified"},
+		{"WITHIN", "scorekey", "SCORE", "battery", "IDS", "BOUNDS", 33, -116, 34, -114}, {"ERR SCORE is not allowed for WITHIN"},
+	})
+}
+
+func keys_KNN_area_test(mc *mockServer) error {
+	route := `{"type":"LineString","coordinates":[[-115,33],[-114,33]]}`
+	area := `{"type":"Polygon","coordinates":[[[-115,33],[-114,33],[-114,34],[-115,34],[-115,33]]]}`
+	return mc.DoBatch([][]interface{}{
+		// d is closer to the middle of the route, but c is closer to the
+		// route
+		{"SET", "mykey", "a", "POINT", 33.01, -114.5}, {"OK"},
+		{"SET", "mykey", "b", "POINT", 33.1, -115.5}, {"OK"},
+		{"SET", "mykey", "c", "POINT", 33.05, -114.2}, {"OK"},
+		{"SET", "mykey", "d", "POINT", 33.2, -114.5}, {"OK"},
+		{"SET", "routes", "r1", "OBJECT", route}, {"OK"},
+		{"NEARBY", "mykey", "IDS", "OBJECT", route}, {"[0 [a c d b]]"},
+		{"NEARBY", "mykey", "IDS", "OBJECT", route, 10000}, {"[0 [a c]]"},
+		{"NEARBY", "mykey", "IDS", "GET", "routes", "r1"}, {"[0 [a c d b]]"},
+		{"NEARBY", "mykey", "IDS", "GET", "routes", "r1", 10000}, {"[0 [a c]]"},
+		{"NEARBY", "mykey", "LIMIT", 2, "IDS", "GET", "routes", "r1"}, {"[2 [a c]]"},
+		{"NEARBY", "mykey", "CURSOR", 2, "LIMIT", 2, "IDS", "GET", "routes", "r1"}, {"[4 [d b]]"},
+		{"NEARBY", "mykey", "DISTANCE", "LIMIT", 1, "OBJECT", route}, {`[1 [[a {"type":"Point","coordinates":[-114.5,33.01]} 1111.949266445518]]]`},
+		{"NEARBY", "mykey", "DISTANCE", "LIMIT", 1, "OBJECT", area}, {`[1 [[a {"type":"Point","coordinates":[-114.5,33.01]} 0]]]`},
+		{"NEARBY", "mykey", "IDS", "OBJECT", area, 0}, {"[0 [a c d]]"},
+		{"NEARBY", "mykey", "IDS", "OBJECT", `{"type":"Point","coordinates":[-115.5,33.1]}`, 1000}, {"[0 [b]]"},
+		{"NEARBY", "mykey", "IDS", "GET", "routes", "r2"}, {"ERR id not found"},
+		{"NEARBY", "mykey", "IDS", "OBJECT", route, -1}, {"ERR invalid argument '-1'"},
+		{"NEARBY", "mykey", "FENCE", "OBJECT", route}, {"ERR FENCE is not allowed when NEARBY has an area"},
+	})
+}
+
+func keys_WITHIN_sortby_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "1", "FIELD", "speed", 2, "POINT", 33.1, -115}, {"OK"},
+		{"SET", "mykey", "2", "FIELD", "speed", 4, "POINT", 33.4, -115}, {"OK"},
+		{"SET", "mykey", "3", "FIELD", "speed", 1, "POINT", 33.2, -115}, {"OK"},
+		{"SET", "mykey", "4", "FIELD", "speed", 3, "POINT", 33.3, -115}, {"OK"},
+		{"SET", "mykey", "5", "POINT", 35, -115}, {"OK"},
+		{"WITHIN", "mykey", "SORTBY", "DISTANCE", 33, -115, "IDS", "BOUNDS", 33, -116, 34, -114}, {"[0 [1 3 4 2]]"},
+		{"WITHIN", "mykey", "SORTBY", "DISTANCE", 33.5, -115, "IDS", "BOUNDS", 33, -116, 34, -114}, {"[0 [2 4 3 1]]"},
+		{"WITHIN", "mykey", "SORTBY", "DISTANCE", 33, -115, "DESC", "IDS", "BOUNDS", 33, -116, 34, -114}, {"[0 [2 4 3 1]]"},
+		{"WITHIN", "mykey", "SORTBY", "DISTANCE", 33, -115, "LIMIT", 3, "IDS", "BOUNDS", 33, -116, 34, -114}, {"[3 [1 3 4]]"},
+		{"WITHIN", "mykey", "SORTBY", "DISTANCE", 33, -115, "CURSOR", 3, "LIMIT", 3, "IDS", "BOUNDS", 33, -116, 34, -114}, {"[0 [2]]"},
+		{"WITHIN", "mykey", "SORTBY", "DISTANCE", 33, -115, "CURSOR", 1, "LIMIT", 2, "IDS", "BOUNDS", 33, -116, 34, -114}, {"[3 [3 4]]"},
+		{"WITHIN", "mykey", "SORTBY", "DISTANCE", 33, -115, "WHERE", "speed", 2, 4, "IDS", "BOUNDS", 33, -116, 34, -114}, {"[0 [1 4 2]]"},
+		{"INTERSECTS", "mykey", "SORTBY", "DISTANCE", 34, -115, "IDS", "BOUNDS", 33, -116, 34, -114}, {"[0 [2 4 3 1]]"},
+		{"WITHIN", "mykey", "SORTBY", "DISTANCE", 33, -115, "DISTANCE", "LIMIT", 1, "IDS", "BOUNDS", 33, -116, 34, -114}, {"[1 [1]]"},
+		{"WITHIN", "mykey", "SORTBY", "DISTANCE", 33, "IDS", "BOUNDS", 33, -116, 34, -114}, {"ERR invalid argument 'IDS'"},
+		{"WITHIN", "mykey", "SORTBY", "DISTANCE", 33, -115, "SAMPLE", 2, "IDS", "BOUNDS", 33, -116, 34, -114}, {"ERR SAMPLE is not allowed when SORTBY is specified"},
+		{"WITHIN", "mykey", "STABLE", "SORTBY", "DISTANCE", 33, -115, "IDS", "BOUNDS", 33, -116, 34, -114}, {"ERR STABLE is not allowed when SORTBY is specified"},
+		{"WITHIN", "mykey", "DESC", "IDS", "BOUNDS", 33, -116, 34, -114}, {"ERR DESC is not allowed for WITHIN"},
+	})
+}
+
 func keys_WITHIN_CIRCLE_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "1", "POINT", 37.7335, -122.4412}, {"OK"},
@@ -265,6 +403,52 @@ func keys_INTERSECTS_CIRCLE_test(mc *mockServer) error {
 	})
 }
 
+func keys_BUFFER_test(mc *mockServer) error {
+	line := `{"type":"LineString","coordinates":[[-115,33],[-114,33]]}`
+	return mc.DoBatch([][]interface{}{
+		// about 445m and 667m from the line
+		{"SET", "mykey", "p1", "POINT", 33.004, -114.5}, {"OK"},
+		{"SET", "mykey", "p2", "POINT", 33.006, -114.5}, {"OK"},
+		// about 373m and 932m past the end of the line
+		{"SET", "mykey", "p3", "POINT", 33, -113.996}, {"OK"},
+		{"SET", "mykey", "p4", "POINT", 33, -113.99}, {"OK"},
+		{"SET", "mykey", "l1", "OBJECT", `{"type":"LineString","coordinates":[[-114.8,33.003],[-114.7,33.003]]}`}, {"OK"},
+		{"SET", "mykey", "l2", "OBJECT", `{"type":"LineString","coordinates":[[-114.8,33.003],[-114.7,33.01]]}`}, {"OK"},
+		{"SET", "mykey", "poly", "OBJECT", `{"type":"Polygon","coordinates":[[[-114.3,33.007],[-114.2,33.007],[-114.2,33.02],[-114.3,33.02],[-114.3,33.007]]]}`}, {"OK"},
+		{"INTERSECTS", "mykey", "IDS", "OBJECT", line}, {"[0 []]"},
+		{"INTERSECTS", "mykey", "BUFFER", 500, "IDS", "OBJECT", line}, {"[0 [p1 p3 l1 l2]]"},
+		{"WITHIN", "mykey", "BUFFER", 500, "IDS", "OBJECT", line}, {"[0 [p1 p3 l1]]"},
+		{"INTERSECTS", "mykey", "BUFFER", 800, "IDS", "OBJECT", line}, {"[0 [p1 p2 p3 l1 l2 poly]]"},
+		{"WITHIN", "mykey", "BUFFER", 800, "IDS", "OBJECT", line}, {"[0 [p1 p2 p3 l1]]"},
+		{"WITHIN", "mykey", "BUFFER", 500, "IDS", "CIRCLE", 33, -114.5, 10}, {"[0 [p1]]"},
+		{"WITHIN", "mykey", "BUFFER", 0, "IDS", "OBJECT", line}, {"ERR invalid argument '0'"},
+		{"WITHIN", "mykey", "BUFFER", 500, "CLIP", "IDS", "BOUNDS", 33, -115, 34, -114}, {"ERR CLIP is not allowed when BUFFER is specified"},
+		{"NEARBY", "mykey", "BUFFER", 500, "IDS", "POINT", 33, -114.5}, {"ERR BUFFER is not allowed for NEARBY"},
+	})
+}
+
+func keys_JOIN_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "zones", "z1", "BOUNDS", 33, -115, 34, -114}, {"OK"},
+		{"SET", "zones", "z2", "BOUNDS", 35, -115, 36, -114}, {"OK"},
+		{"SET", "zones", "x1", "BOUNDS", 33, -115, 36, -114}, {"OK"},
+		{"SET", "zones", "z3", "STRING", "hello"}, {"OK"},
+		{"SET", "fleet", "t1", "POINT", 33.5, -114.5}, {"OK"},
+		{"SET", "fleet", "t2", "POINT", 33.6, -114.5}, {"OK"},
+		{"SET", "fleet", "t3", "POINT", 35.5, -114.5}, {"OK"},
+		{"SET", "fleet", "t4", "POINT", 34.5, -114.5}, {"OK"},
+		{"INTERSECTS", "fleet", "IDS", "GET", "zones", "z*"}, {"[[z1 [0 [t1 t2]]] [z2 [0 [t3]]]]"},
+		{"WITHIN", "fleet", "COUNT", "GET", "zones", "*"}, {"[[x1 4] [z1 2] [z2 1]]"},
+		{"WITHIN", "fleet", "IDS", "GET", "zones", "y*"}, {"[]"},
+		{"WITHIN", "fleet", "IDS", "GET", "zones", "z1"}, {"[0 [t1 t2]]"},
+		{"WITHIN", "fleet", "BUFFER", 60000, "IDS", "GET", "zones", "z2"}, {"[0 [t3 t4]]"},
+		{"WITHIN", "fleet", "CURSOR", 1, "IDS", "GET", "zones", "z*"}, {"ERR CURSOR is not allowed when GET has a pattern"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"WITHIN", "fleet", "COUNT", "GET", "zones", "z*"}, {`{"ok":true,"areas":[{"id":"z1","count":2,"cursor":0},{"id":"z2","count":1,"cursor":0}],"count":2}`},
+		{"OUTPUT", "resp"}, {"OK"},
+	})
+}
+
 func keys_SCAN_CURSOR_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "id1", "FIELD", "foo", 1, "STRING", "bar1"}, {"OK"},
@@ -287,6 +471,246 @@ func keys_SCAN_CURSOR_test(mc *mockServer) error {
 	})
 }
 
+func keys_SCAN_SNAPSHOT_test(mc *mockServer) error {
+	// a scan of a key with this many objects reads a snapshot of it
+	const n = 1200
+	var cmds [][]interface{}
+	for i := 0; i < n; i++ {
+		cmds = append(cmds, []interface{}{"SET", "sskey", fmt.Sprintf("id%04d", i),
+			"FIELD", "speed", i, "POINT", 33, -115}, []interface{}{"OK"})
+	}
+	err := mc.DoBatch(cmds, [][]interface{}{
+		{"SCAN", "sskey", "WHERE", "speed", 100, 199, "COUNT"}, {100},
+		{"SCAN", "sskey", "DESC", "LIMIT", 2, "IDS"}, {"[2 [id1199 id1198]]"},
+		{"SCAN", "sskey", "MATCH", "id01*", "COUNT"}, {100},
+		{"SCAN", "sskey", "CURSOR", 1198, "IDS"}, {"[0 [id1198 id1199]]"},
+		{"FSET", "sskey", "id0150", "speed", 5000}, {1},
+		{"DEL", "sskey", "id0151"}, {1},
+		{"SCAN", "sskey", "WHERE", "speed", 100, 199, "COUNT"}, {98},
+		{"SCAN", "sskey", "WHERE", "speed", 5000, 5000, "IDS"}, {"[0 [id0150]]"},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the writes of another client go on while the scans read the key
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	errs := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			if i == 151 {
+				continue
+			}
+			_, err := conn.Do("FSET", "sskey", fmt.Sprintf("id%04d", i),
+				"speed", -1)
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+	for i := 0; i < 20; i++ {
+		v, err := redis.Int(mc.Do("SCAN", "sskey", "WHERE", "speed", "-inf",
+			"+inf", "COUNT"))
+		if err != nil {
+			return err
+		}
+		if v != n-1 {
+			return fmt.Errorf("expected %d, got %d", n-1, v)
+		}
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+	return mc.DoBatch([][]interface{}{
+		{"SCAN", "sskey", "WHERE", "speed", -1, -1, "COUNT"}, {n - 1},
+		{"DROP", "sskey"}, {1},
+	})
+}
+
+func keys_SCAN_STABLE_test(mc *mockServer) error {
+	// the page token of a scan is the last id
+	token := func(id string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte("i" + id))
+	}
+	var cmds [][]interface{}
+	for i := 1; i <= 6; i++ {
+		cmds = append(cmds, []interface{}{"SET", "stkey", fmt.Sprintf("id%d", i),
+			"FIELD", "foo", i, "STRING", "bar"}, []interface{}{"OK"})
+	}
+	return mc.DoBatch(cmds, [][]interface{}{
+		{"SCAN", "stkey", "STABLE", "STABLE", "IDS"}, {"ERR duplicate argument 'STABLE'"},
+		{"SCAN", "stkey", "STABLE", "CURSOR", "id2", "IDS"}, {"ERR invalid argument 'id2'"},
+		{"SEARCH", "stkey", "STABLE", "IDS"}, {"ERR wrong number of arguments for 'search' command"},
+		{"SCAN", "stkey", "STABLE", "LIMIT", 2, "IDS"}, {"[" + token("id2") + " [id1 id2]]"},
+		// the objects before the token may change between the pages
+		{"DEL", "stkey", "id1"}, {1},
+		{"SET", "stkey", "id0", "STRING", "bar"}, {"OK"},
+		{"SCAN", "stkey", "STABLE", "CURSOR", token("id2"), "LIMIT", 2, "IDS"}, {"[" + token("id4") + " [id3 id4]]"},
+		{"DEL", "stkey", "id4"}, {1},
+		{"SCAN", "stkey", "STABLE", "CURSOR", token("id4"), "LIMIT", 2, "IDS"}, {"[" + token("id6") + " [id5 id6]]"},
+		{"SCAN", "stkey", "STABLE", "CURSOR", token("id6"), "LIMIT", 2, "IDS"}, {"[ []]"},
+		{"SCAN", "stkey", "STABLE", "DESC", "CURSOR", token("id5"), "LIMIT", 2, "IDS"}, {"[" + token("id2") + " [id3 id2]]"},
+		{"SCAN", "stkey", "STABLE", "CURSOR", token("id2"), "MATCH", "id*", "IDS"}, {"[ [id3 id5 id6]]"},
+		{"SCAN", "stkey", "STABLE", "CURSOR", token("id2"), "WHERE", "foo", 5, 6, "COUNT"}, {"2"},
+		{"SETINDEX", "stkey", "foo"}, {1},
+		{"SCAN", "stkey", "STABLE", "CURSOR", token("id3"), "LIMIT", 1, "WHERE", "foo", 1, 5, "IDS"}, {"[" + token("id5") + " [id5]]"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"SCAN", "stkey", "STABLE", "CURSOR", token("id3"), "LIMIT", 1, "IDS"}, {`{"ok":true,"ids":["id5"],"count":1,"cursor":"` + token("id5") + `"}`},
+		{"OUTPUT", "resp"}, {"OK"},
+	})
+}
+
+// stablePage returns the ids and the page token of a page of a STABLE search.
+func stablePage(mc *mockServer, args ...interface{}) (ids []string,
+	cursor string, err error,
+) {
+	v, err := mc.Do(args[0].(string), args[1:]...)
+	if err != nil {
+		return nil, "", err
+	}
+	vals, ok := v.([]interface{})
+	if !ok || len(vals) != 2 {
+		return nil, "", fmt.Errorf("unexpected response '%v'", v)
+	}
+	for _, id := range vals[1].([]interface{}) {
+		ids = append(ids, string(id.([]byte)))
+	}
+	return ids, string(vals[0].([]byte)), nil
+}
+
+func keys_SEARCH_STABLE_test(mc *mockServer) error {
+	for i := 0; i < 10; i++ {
+		_, err := mc.Do("SET", "stpoints", fmt.Sprintf("p%d", i),
+			"POINT", 33+float64(i)/100, -115)
+		if err != nil {
+			return err
+		}
+	}
+	err := mc.DoBatch([][]interface{}{
+		{"NEARBY", "stpoints", "STABLE", "SORTBY", "x", "IDS", "POINT", 33, -115}, {"ERR STABLE is not allowed when SORTBY is specified"},
+		{"WITHIN", "stpoints", "STABLE", "SPARSE", 1, "IDS", "BOUNDS", 32, -116, 34, -114}, {"ERR STABLE is not allowed when SPARSE is specified"},
+		{"WITHIN", "stpoints", "STABLE", "IDS", "GET", "stpoints", "p*"}, {"ERR STABLE is not allowed when GET has a pattern"},
+		// a page token is only valid for the same kind of search
+		{"NEARBY", "stpoints", "STABLE", "CURSOR", "aXAx", "IDS", "POINT", 33, -115}, {"ERR invalid argument 'aXAx'"},
+	})
+	if err != nil {
+		return err
+	}
+	// page through the objects while they change, and every object that is
+	// there the whole time must be returned once, in order
+	pages := func(cmd []interface{}, limit int) ([]string, error) {
+		var all []string
+		var cursor string
+		for n := 0; ; n++ {
+			args := append([]interface{}{cmd[0], cmd[1], "STABLE",
+				"LIMIT", limit}, cmd[2:]...)
+			if cursor != "" {
+				args = append(args[:3:3], append([]interface{}{"CURSOR",
+					cursor}, args[3:]...)...)
+			}
+			ids, next, err := stablePage(mc, args...)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, ids...)
+			if next == "" {
+				return all, nil
+			}
+			cursor = next
+			if n == 0 {
+				// one object is gone, and one is new and closer to the
+				// point than the page token
+				for _, cmd := range [][]interface{}{
+					{"DEL", "stpoints", "p5"},
+					{"SET", "stpoints", "p05", "POINT", 33.0001, -115},
+				} {
+					if _, err := mc.Do(cmd[0].(string), cmd[1:]...); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	ids, err := pages([]interface{}{"NEARBY", "stpoints", "IDS",
+		"POINT", 33, -115}, 3)
+	if err != nil {
+		return err
+	}
+	if fmt.Sprint(ids) != "[p0 p1 p2 p3 p4 p6 p7 p8 p9]" {
+		return fmt.Errorf("unexpected nearby pages %v", ids)
+	}
+	if _, err := mc.Do("SET", "stpoints", "p5", "POINT", 33.05, -115); err != nil {
+		return err
+	}
+	ids, err = pages([]interface{}{"WITHIN", "stpoints", "IDS",
+		"BOUNDS", 32, -116, 34, -114}, 4)
+	if err != nil {
+		return err
+	}
+	if fmt.Sprint(ids) != "[p0 p05 p1 p2 p3 p4 p6 p7 p8 p9]" {
+		return fmt.Errorf("unexpected within pages %v", ids)
+	}
+	return nil
+}
+
+func keys_INDEX_test(mc *mockServer) error {
+	var cmds [][]interface{}
+	for i := 0; i < 20; i++ {
+		state := "moving"
+		if i%5 == 0 {
+			state = "idle"
+		}
+		cmds = append(cmds, []interface{}{"SET", "mykey", fmt.Sprintf("p%02d", i),
+			"FIELD", "speed", i, "FIELD", "state", state,
+			"POINT", 33 + float64(i)/100, -115}, []interface{}{"OK"})
+	}
+	return mc.DoBatch(cmds, [][]interface{}{
+		{"SETINDEX", "mykey", "z"}, {"ERR invalid argument 'z'"},
+		{"SETINDEX", "mykey", "speed"}, {1},
+		{"SETINDEX", "mykey", "speed"}, {0},
+		{"SETINDEX", "mykey", "state"}, {1},
+		{"INDEXES", "mykey"}, {"[speed state]"},
+		{"SCAN", "mykey", "WHERE", "speed", 2, 4, "IDS"}, {"[0 [p02 p03 p04]]"},
+		// the cursor is the position in the index range
+		{"SCAN", "mykey", "LIMIT", 2, "WHERE", "speed", 2, 4, "IDS"}, {"[2 [p02 p03]]"},
+		{"SCAN", "mykey", "CURSOR", 2, "LIMIT", 2, "WHERE", "speed", 2, 4, "IDS"}, {"[0 [p04]]"},
+		{"SCAN", "mykey", "DESC", "WHERE", "speed", 2, 4, "IDS"}, {"[0 [p04 p03 p02]]"},
+		{"SCAN", "mykey", "WHERE", "speed", 2, 4, "WHERE", "state", "eq", "idle", "IDS"}, {"[0 []]"},
+		{"SCAN", "mykey", "WHERE", "state", "eq", "idle", "IDS"}, {"[0 [p00 p05 p10 p15]]"},
+		{"SCAN", "mykey", "WHERE", "state", "prefix", "id", "COUNT"}, {"4"},
+		// objects without the field have a zero, which is not indexed
+		{"SCAN", "mykey", "WHERE", "speed", 0, 2, "IDS"}, {"[0 [p00 p01 p02]]"},
+		{"WITHIN", "mykey", "WHERE", "speed", 2, 4, "IDS", "BOUNDS", 33.025, -116, 34, -114}, {"[0 [p03 p04]]"},
+		{"INTERSECTS", "mykey", "WHERE", "speed", "(2", 4, "IDS", "BOUNDS", 32, -116, 34, -114}, {"[0 [p03 p04]]"},
+		{"NEARBY", "mykey", "WHERE", "speed", 2, 4, "IDS", "POINT", 33.2, -115}, {"[0 [p04 p03 p02]]"},
+		{"NEARBY", "mykey", "LIMIT", 1, "WHERE", "speed", 2, 4, "IDS", "POINT", 33.2, -115}, {"[1 [p04]]"},
+		{"NEARBY", "mykey", "WHERE", "speed", 2, 4, "IDS", "POINT", 33.04, -115, 1200}, {"[0 [p04 p03]]"},
+		{"FSET", "mykey", "p03", "speed", 10}, {1},
+		{"DEL", "mykey", "p04"}, {1},
+		{"SCAN", "mykey", "WHERE", "speed", 2, 4, "IDS"}, {"[0 [p02]]"},
+		{"DELINDEX", "mykey", "speed"}, {1},
+		{"DELINDEX", "mykey", "speed"}, {0},
+		{"INDEXES", "mykey"}, {"[state]"},
+		{"SCAN", "mykey", "LIMIT", 1, "WHERE", "speed", 2, 4, "IDS"}, {"[3 [p02]]"},
+		// indexes are kept by key
+		{"DROP", "mykey"}, {1},
+		{"SET", "mykey", "p00", "FIELD", "state", "idle", "POINT", 33, -115}, {"OK"},
+		{"INDEXES", "mykey"}, {"[state]"},
+		{"SCAN", "mykey", "WHERE", "state", "eq", "idle", "IDS"}, {"[0 [p00]]"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"INDEXES", "mykey"}, {`{"ok":true,"indexes":["state"]}`},
+		{"OUTPUT", "resp"}, {"OK"},
+		{"FLUSHDB"}, {"OK"},
+		{"INDEXES", "mykey"}, {"[]"},
+	})
+}
+
 func keys_SEARCH_CURSOR_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "id1", "FIELD", "foo", 1, "STRING", "bar1"}, {"OK"},
@@ -432,3 +856,417 @@ func match(expectIn string) func(org, v interface{}) (resp, expect interface{})
 		return fmt.Sprintf("%v", org), expectIn
 	}
 }
+
+func keys_STATS_search_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "1", "FIELD", "type", 1, "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "2", "FIELD", "type", 1, "FIELD", "speed", 20, "POINT", 33.01, -115}, {"OK"},
+		{"SET", "mykey", "3", "FIELD", "type", 2, "FIELD", "speed", 6, "POINT", 33.02, -115}, {"OK"},
+		{"SET", "mykey", "4", "FIELD", "type", 2, "POINT", 50, 50}, {"OK"},
+		{"WITHIN", "mykey", "GROUPBY", "type", "COUNT", "BOUNDS", 32, -116, 34, -114}, {"[[1 2] [2 1]]"},
+		{"WITHIN", "mykey", "STATS", "speed", "BOUNDS", 32, -116, 34, -114}, {"[3 [speed [6 20 12 36]]]"},
+		{"INTERSECTS", "mykey", "GROUPBY", "type", "STATS", "speed", "BOUNDS", 32, -116, 34, -114}, {
+			"[[1 2 [speed [10 20 15 30]]] [2 1 [speed [6 6 6 6]]]]"},
+		{"SCAN", "mykey", "GROUPBY", "type", "COUNT"}, {"[[1 2] [2 2]]"},
+		{"NEARBY", "mykey", "STATS", "speed,type", "POINT", 33, -115, 100000}, {
+			"[3 [speed [6 20 12 36] type [1 2 1.3333333333333333 4]]]"},
+		{"WITHIN", "mykey", "STATS", "speed", "BOUNDS", 0, 0, 1, 1}, {"[0 [speed [0 0 0 0]]]"},
+		{"WITHIN", "mykey", "GROUPBY", "type", "IDS", "BOUNDS", 32, -116, 34, -114}, {"ERR GROUPBY is not allowed without COUNT or STATS"},
+		{"WITHIN", "mykey", "FENCE", "STATS", "speed", "BOUNDS", 32, -116, 34, -114}, {"ERR GROUPBY and STATS are not allowed when FENCE is specified"},
+		{"WITHIN", "mykey", "STATS", "speed,speed", "BOUNDS", 32, -116, 34, -114}, {"ERR duplicate argument 'speed'"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"WITHIN", "mykey", "GROUPBY", "type", "STATS", "speed", "BOUNDS", 32, -116, 34, -114}, {
+			`{"ok":true,"groups":[` +
+				`{"value":1,"count":2,"stats":{"speed":{"min":10,"max":20,"avg":15,"sum":30}}},` +
+				`{"value":2,"count":1,"stats":{"speed":{"min":6,"max":6,"avg":6,"sum":6}}}` +
+				`],"count":3,"cursor":0}`},
+		{"OUTPUT", "resp"}, {"OK"},
+	})
+}
+
+func keys_MODEL_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "eq", "a", "POINT", 0, 1}, {"OK"},
+		{"SET", "eq", "b", "POINT", 0, 2}, {"OK"},
+		{"SET", "plane", "a", "POINT", 300, 400}, {"OK"},
+		{"SET", "plane", "b", "POINT", 3000, 4000}, {"OK"},
+
+		// one degree of the equator is 111195m on the sphere, and 111319m on
+		// the ellipsoid
+		{"NEARBY", "eq", "DISTANCE", "POINT", 0, 0, 111250}, {`[0 [[a {"type":"Point","coordinates":[1,0]} 111194.92664455874]]]`},
+		{"NEARBY", "eq", "MODEL", "spherical", "DISTANCE", "POINT", 0, 0, 111250}, {`[0 [[a {"type":"Point","coordinates":[1,0]} 111194.92664455874]]]`},
+		{"NEARBY", "eq", "MODEL", "geodesic", "DISTANCE", "POINT", 0, 0, 111250}, {"[0 []]"},
+		{"NEARBY", "eq", "MODEL", "GEODESIC", "DISTANCE", "POINT", 0, 0}, {`[0 [[a {"type":"Point","coordinates":[1,0]} 111319.4907932264] [b {"type":"Point","coordinates":[2,0]} 222638.9815864528]]]`},
+		{"WITHIN", "eq", "IDS", "CIRCLE", 0, 0, 111250}, {"[0 [a]]"},
+		{"WITHIN", "eq", "MODEL", "geodesic", "IDS", "CIRCLE", 0, 0, 111250}, {"[0 []]"},
+		{"INTERSECTS", "eq", "MODEL", "geodesic", "IDS", "CIRCLE", 0, 0, 111350}, {"[0 [a]]"},
+
+		// planar distances are in the units of the coordinates
+		{"NEARBY", "plane", "MODEL", "planar", "DISTANCE", "POINT", 0, 0, 1000}, {`[0 [[a {"type":"Point","coordinates":[400,300]} 500]]]`},
+		{"NEARBY", "plane", "MODEL", "planar", "DISTANCE", "POINT", 0, 0}, {`[0 [[a {"type":"Point","coordinates":[400,300]} 500] [b {"type":"Point","coordinates":[4000,3000]} 5000]]]`},
+		{"WITHIN", "plane", "MODEL", "planar", "IDS", "CIRCLE", 3000, 3000, 1000}, {"[0 [b]]"},
+
+		{"NEARBY", "eq", "MODEL", "flat", "POINT", 0, 0}, {"ERR invalid argument 'flat'"},
+		{"NEARBY", "eq", "MODEL", "planar", "MODEL", "planar", "POINT", 0, 0}, {"ERR duplicate argument 'MODEL'"},
+		{"NEARBY", "eq", "MODEL"}, {"ERR wrong number of arguments for 'nearby' command"},
+	})
+}
+
+func keys_MATRIX_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "fleet", "a", "POINT", 0, 1}, {"OK"},
+		{"SET", "fleet", "b", "POINT", 0, 2}, {"OK"},
+		{"SET", "fleet", "c", "POINT", 0, 0.5}, {"OK"},
+		{"SET", "fleet", "d", "STRING", "hello"}, {"OK"},
+		{"MATRIX", "fleet", "ORIGINS", "a", "POINT", 0, 0, "DESTINATIONS", "b", "c"}, {"[[111194.92664455874 55597.46332227937] [222389.85328911748 55597.46332227937]]"},
+		{"MATRIX", "fleet", "KNN", 1, "ORIGINS", "a", "POINT", 0, 0, "DESTINATIONS", "b", "c"}, {"[[[1 55597.46332227937]] [[1 55597.46332227937]]]"},
+		{"MATRIX", "fleet", "MODEL", "geodesic", "KNN", 5, "ORIGINS", "b", "DESTINATIONS", "a", "b", "c"}, {"[[[1 0] [0 111319.4907932264] [2 166979.23618983963]]]"},
+		{"MATRIX", "nokey", "ORIGINS", "POINT", 0, 0, "DESTINATIONS", "POINT", 0, 1}, {"[[111194.92664455874]]"},
+		{"MATRIX", "nokey", "ORIGINS", "a", "DESTINATIONS", "b"}, {"ERR key not found"},
+		{"MATRIX", "fleet", "ORIGINS", "x", "DESTINATIONS", "b"}, {"ERR id not found"},
+		{"MATRIX", "fleet", "ORIGINS", "d", "DESTINATIONS", "b"}, {"ERR invalid argument 'd'"},
+		{"MATRIX", "fleet", "DESTINATIONS", "b"}, {"ERR invalid argument 'DESTINATIONS'"},
+		{"MATRIX", "fleet", "ORIGINS", "a"}, {"ERR wrong number of arguments for 'matrix' command"},
+		{"MATRIX", "fleet", "KNN", 0, "ORIGINS", "a", "DESTINATIONS", "b"}, {"ERR invalid argument '0'"},
+		{"MATRIX", "fleet", "ORIGINS", "a", "DESTINATIONS", "b", "MODEL", "planar"}, {"ERR invalid argument 'MODEL'"},
+		{"MATRIX", "fleet", "ORIGINS", "a", "DESTINATIONS", "POINT", 0}, {"ERR wrong number of arguments for 'matrix' command"},
+	})
+}
+
+func keys_CLUSTERS_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "clfleet", "a", "POINT", 33, -115}, {"OK"},
+		{"SET", "clfleet", "b", "POINT", 33.01, -115.01}, {"OK"},
+		{"SET", "clfleet", "c", "POINT", 40, -100}, {"OK"},
+		{"SET", "clfleet", "d", "POINT", 40, -100}, {"OK"},
+		{"SET", "clfleet", "e", "STRING", "hello"}, {"OK"},
+		{"SCAN", "clfleet", "CLUSTERS", 5}, {"[[2 [40 -100] 24] [2 [33.004999999999995 -115.005] 11]]"},
+		{"SCAN", "clfleet", "CLUSTERS", 14}, {"[[2 [40 -100] 24] [b [33.01 -115.01]] [a [33 -115]]]"},
+		{"WITHIN", "clfleet", "CLUSTERS", 0, "BOUNDS", 30, -120, 35, -110}, {"[[2 [33.004999999999995 -115.005] 11]]"},
+		{"NEARBY", "clfleet", "CLUSTERS", 14, "POINT", 33, -115, 2000}, {"[[b [33.01 -115.01]] [a [33 -115]]]"},
+		{"SCAN", "clfleet", "CLUSTERS", 25}, {"ERR invalid argument '25'"},
+		{"SCAN", "clfleet", "CLUSTERS"}, {"ERR wrong number of arguments for 'scan' command"},
+		{"SEARCH", "clfleet", "CLUSTERS", 5}, {"ERR invalid argument 'CLUSTERS'"},
+		{"WITHIN", "clfleet", "FENCE", "CLUSTERS", 5, "BOUNDS", 30, -120, 35, -110}, {"ERR CLUSTERS is not allowed when FENCE is specified"},
+	})
+}
+
+func keys_TILE_test(mc *mockServer) error {
+	// a layer with the point at the center of the tile, and no fields
+	feature := "\x12\x02\x00\x00\x18\x01\x22\x05\x09\x80\x20\x80\x20"
+	layer := "\x78\x02\x0a\x07mvfleet\x12\x0d" + feature +
+		"\x1a\x02id\x22\x03\x0a\x01a\x28\x80\x20"
+	empty := "\x78\x02\x0a\x07mvfleet\x28\x80\x20"
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mvfleet", "a", "POINT", 0, 0}, {"OK"},
+		{"SET", "mvfleet", "b", "STRING", "hello"}, {"OK"},
+		{"TILE", "mvfleet", 0, 0, 0}, {"\x1a\x26" + layer},
+		{"TILE", "mvfleet", 2, 0, 0}, {"\x1a\x0e" + empty},
+		{"TILE", "mvfleet", 0, 0, 0, "FIELDS", "*"}, {"\x1a\x26" + layer},
+		{"TILE", "mvfleet", 1, 2, 0}, {"ERR invalid argument '2'"},
+		{"TILE", "mvfleet", 0, 0, 0, "EXTENT", 0}, {"ERR invalid argument '0'"},
+		{"TILE", "mvfleet", 0, 0}, {"ERR wrong number of arguments for 'tile' command"},
+		{"TILE", "mvfleet", 0, 0, 0, "FORMAT", "geojson"}, {`{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Point","coordinates":[0,0]},"properties":{"id":"a"}}]}`},
+		{"TILE", "mvfleet", 0, 0, 0, "FORMAT", "jpg"}, {"ERR invalid argument 'jpg'"},
+		{"TILE", "mvfleet", 0, 0, 0, "FORMAT", "png", "EXTENT", 5000}, {"ERR invalid argument '5000'"},
+	})
+}
+
+func keys_TILE_HTTP_test(mc *mockServer) error {
+	if _, err := mc.Do("SET", "mvfleet", "a", "POINT", 0, 0); err != nil {
+		return err
+	}
+	get := func(path string) (*http.Response, []byte, error) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d%s", mc.port, path))
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return resp, body, err
+	}
+	resp, body, err := get("/tiles/mvfleet/0/0/0.png")
+	if err != nil {
+		return err
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+		return fmt.Errorf("expected 'image/png', got '%s'", ct)
+	}
+	img, err := png.Decode(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 256 {
+		return fmt.Errorf("expected a 256x256 picture, got %v", b)
+	}
+	if _, _, _, a := img.At(128, 128).RGBA(); a == 0 {
+		return fmt.Errorf("expected the point at the center of the picture")
+	}
+	resp, body, err = get("/tiles/mvfleet/0/0/0.geojson?fields=speed")
+	if err != nil {
+		return err
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/geo+json" {
+		return fmt.Errorf("expected 'application/geo+json', got '%s'", ct)
+	}
+	if id := gjson.GetBytes(body, "features.0.properties.id").String(); id != "a" {
+		return fmt.Errorf("expected 'a', got '%s'", body)
+	}
+	_, body, err = get("/tiles/mvfleet/1/2/0.png")
+	if err != nil {
+		return err
+	}
+	if s := gjson.GetBytes(body, "err").String(); s != "invalid argument '2'" {
+		return fmt.Errorf("expected an error, got '%s'", body)
+	}
+	return nil
+}
+
+// testH3 is a grid with the layout of H3 cells, where the base cell is the
+// degree of longitude, from 0 to 121, and the first digit is the degree of
+// latitude, from 0 to 6.
+type testH3 struct{}
+
+func (testH3) LatLngToCell(lat, lon float64, res int) uint64 {
+	base := uint64(math.Max(0, math.Min(121, math.Floor(lon))))
+	digit := uint64(math.Max(0, math.Min(6, math.Floor(lat))))
+	cell := uint64(1)<<59 | uint64(res)<<52 | base<<45
+	for r := 1; r <= 15; r++ {
+		d := uint64(7)
+		if r == 1 && r <= res {
+			d = digit
+		} else if r <= res {
+			d = 0
+		}
+		cell |= d << (uint(15-r) * 3)
+	}
+	return cell
+}
+
+func (testH3) CellToBoundary(cell uint64) [][2]float64 {
+	lon := float64((cell >> 45) & 127)
+	lat, size := 0.0, 7.0
+	if (cell>>52)&15 > 0 {
+		lat, size = float64((cell>>42)&7), 1
+	}
+	return [][2]float64{
+		{lat, lon}, {lat, lon + 1}, {lat + size, lon + 1}, {lat + size, lon},
+	}
+}
+
+func keys_H3_test(mc *mockServer) error {
+	err := mc.DoBatch([][]interface{}{
+		// the cells of the H3 library
+		{"SET", "h3world", "a", "POINT", 1.5, 2.5}, {"OK"},
+		{"SET", "h3world", "b", "POINT", 3.5, 2.5}, {"OK"},
+		{"SET", "h3world", "c", "POINT", 12, 2.5}, {"OK"},
+		{"SCAN", "h3world", "H3", 1}, {"[0 [[a 81757ffffffffff] [b 81757ffffffffff] [c 8159bffffffffff]]]"},
+		{"SCAN", "h3world", "H3", 2}, {"[0 [[a 82756ffffffffff] [b 827567fffffffff] [c 82598ffffffffff]]]"},
+		{"WITHIN", "h3world", "IDS", "HEX", "81757ffffffffff"}, {"[0 [a b]]"},
+		{"INTERSECTS", "h3world", "IDS", "HEX", "82598ffffffffff"}, {"[0 [c]]"},
+		{"SCAN", "h3world", "GROUPBY", "H3:1", "COUNT"}, {"[[8159bffffffffff 1] [81757ffffffffff 2]]"},
+
+		{"SET", "h3fleet", "a", "POINT", 1.5, 2.5}, {"OK"},
+		{"SET", "h3fleet", "b", "POINT", 1.2, 2.9}, {"OK"},
+		{"SET", "h3fleet", "c", "POINT", 3.5, 2.5}, {"OK"},
+	})
+	if err != nil {
+		return err
+	}
+	// core.H3 overrides the built-in indexer
+	core.H3 = testH3{}
+	defer func() { core.H3 = nil }()
+	return mc.DoBatch([][]interface{}{
+		{"SCAN", "h3fleet", "H3", 1}, {"[0 [[a 81047ffffffffff] [b 81047ffffffffff] [c 8104fffffffffff]]]"},
+		{"SCAN", "h3fleet", "H3", 0}, {"[0 [[a 8005fffffffffff] [b 8005fffffffffff] [c 8005fffffffffff]]]"},
+		{"WITHIN", "h3fleet", "IDS", "HEX", "81047ffffffffff"}, {"[0 [a b]]"},
+		{"WITHIN", "h3fleet", "IDS", "HEX", "8005fffffffffff"}, {"[0 [a b c]]"},
+		{"INTERSECTS", "h3fleet", "IDS", "HEX", "8104fffffffffff"}, {"[0 [c]]"},
+		{"SCAN", "h3fleet", "GROUPBY", "H3:1", "COUNT"}, {"[[81047ffffffffff 2] [8104fffffffffff 1]]"},
+		{"SCAN", "h3fleet", "GROUPBY", "h3:0", "COUNT"}, {"[[8005fffffffffff 3]]"},
+		{"SCAN", "h3fleet", "H3", 16}, {"ERR invalid argument '16'"},
+		{"SCAN", "h3fleet", "GROUPBY", "H3:x", "COUNT"}, {"ERR invalid argument 'x'"},
+		{"WITHIN", "h3fleet", "IDS", "HEX", "81047fffffffff7"}, {"ERR invalid argument '81047fffffffff7'"},
+	})
+}
+
+func keys_GEOHASH_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "ghfleet", "a", "HASH", "9my5xp7"}, {"OK"},
+		{"SET", "ghfleet", "b", "HASH", "9my5xp8"}, {"OK"},
+		{"WITHIN", "ghfleet", "IDS", "GEOHASH", "9my5xp7"}, {"[0 [a]]"},
+		{"WITHIN", "ghfleet", "IDS", "HASH", "9my5xp7"}, {"[0 [a]]"},
+		{"WITHIN", "ghfleet", "IDS", "GEOHASH", "9my5x"}, {"[0 [a b]]"},
+		{"INTERSECTS", "ghfleet", "IDS", "GEOHASH", "9my5xp8"}, {"[0 [b]]"},
+		{"WITHIN", "ghfleet", "HASHES", 6, "GEOHASH", "9my5x"}, {"[0 [[a 9my5xp] [b 9my5xp]]]"},
+		{"TEST", "GET", "ghfleet", "a", "WITHIN", "GEOHASH", "9my5xp7"}, {"1"},
+		{"TEST", "GET", "ghfleet", "a", "WITHIN", "GEOHASH", "9my5xp8"}, {"0"},
+		{"WITHIN", "ghfleet", "IDS", "GEOHASH", "9my5xpa"}, {"ERR invalid argument '9my5xpa'"},
+		{"WITHIN", "ghfleet", "IDS", "HASH", "9my5xp79my5xp"}, {"ERR invalid argument '9my5xp79my5xp'"},
+		{"WITHIN", "ghfleet", "IDS", "GEOHASH"}, {"ERR wrong number of arguments for 'within' command"},
+	})
+}
+
+func keys_TOPOJSON_test(mc *mockServer) error {
+	// two squares that share an edge, which is a single arc
+	return mc.DoBatch([][]interface{}{
+		{"SET", "tjstates", "a", "FIELD", "pop", 5, "OBJECT", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}`}, {"OK"},
+		{"SET", "tjstates", "b", "OBJECT", `{"type":"Polygon","coordinates":[[[1,0],[2,0],[2,1],[1,1],[1,0]]]}`}, {"OK"},
+		{"SET", "tjstates", "c", "STRING", "hello"}, {"OK"},
+		{"SCAN", "tjstates", "TOPOJSON", 0}, {"[0 " + `{"type":"Topology","bbox":[0,0,2,1],` +
+			`"objects":{"tjstates":{"type":"GeometryCollection","geometries":[` +
+			`{"type":"Polygon","id":"a","properties":{"pop":5},"arcs":[[0,1]]},` +
+			`{"type":"Polygon","id":"b","arcs":[[2,-1]]}]}},` +
+			`"arcs":[[[1,0],[1,1]],[[1,1],[0,1],[0,0],[1,0]],[[1,0],[2,0],[2,1],[1,1]]]}` + "]"},
+		{"INTERSECTS", "tjstates", "NOFIELDS", "TOPOJSON", 3, "BOUNDS", 0, 0, 0.5, 0.5}, {"[0 " + `{"type":"Topology","bbox":[0,0,1,1],` +
+			`"transform":{"scale":[0.5,0.5],"translate":[0,0]},` +
+			`"objects":{"tjstates":{"type":"GeometryCollection","geometries":[` +
+			`{"type":"Polygon","id":"a","arcs":[[0]]}]}},` +
+			`"arcs":[[[0,0],[2,0],[0,2],[-2,0],[0,-2]]]}` + "]"},
+		{"SCAN", "tjstates", "TOPOJSON", 1}, {"ERR invalid argument '1'"},
+		{"SCAN", "tjstates", "TOPOJSON"}, {"ERR wrong number of arguments for 'scan' command"},
+		{"SEARCH", "tjstates", "TOPOJSON", 0}, {"ERR invalid argument 'TOPOJSON'"},
+		{"WITHIN", "tjstates", "FENCE", "TOPOJSON", 0, "BOUNDS", 0, 0, 1, 1}, {"ERR TOPOJSON is not allowed when FENCE is specified"},
+	})
+}
+
+func keys_ZRANGE_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "zfleet", "a", "POINT", 33, -115, 120}, {"OK"},
+		{"SET", "zfleet", "b", "OBJECT", `{"type":"LineString","coordinates":[[-115,33,160],[-114,34,200]]}`}, {"OK"},
+		{"SET", "zfleet", "c", "POINT", 33, -115}, {"OK"},
+		{"GET", "zfleet", "a", "POINT"}, {"[33 -115 120]"},
+		{"NEARBY", "zfleet", "ZRANGE", 0, 150, "IDS", "POINT", 33, -115, 100000}, {"[0 [a c]]"},
+		{"WITHIN", "zfleet", "ZRANGE", 150, "+inf", "IDS", "BOUNDS", 30, -120, 40, -110}, {"[0 [b]]"},
+		{"INTERSECTS", "zfleet", "ZRANGE", "-inf", 0, "IDS", "BOUNDS", 30, -120, 40, -110}, {"[0 [c]]"},
+		{"INTERSECTS", "zfleet", "ZRANGE", 180, 190, "IDS", "BOUNDS", 30, -120, 40, -110}, {"[0 [b]]"},
+		{"SCAN", "zfleet", "ZRANGE", 100, 150, "COUNT"}, {"1"},
+		{"SCAN", "zfleet", "ZRANGE", 100, "COUNT"}, {"ERR invalid argument 'COUNT'"},
+		{"SCAN", "zfleet", "ZRANGE", 100, 150, "ZRANGE", 0, 1, "COUNT"}, {"ERR duplicate argument 'ZRANGE'"},
+	})
+}
+
+func keys_SAMPLE_test(mc *mockServer) error {
+	// sampled checks that a sample has n objects, of those in ids
+	sampled := func(n int, ids string) func(v interface{}) (resp, expect interface{}) {
+		return func(v interface{}) (resp, expect interface{}) {
+			s := fmt.Sprint(v)
+			if !strings.HasPrefix(s, "[0 [") || !strings.HasSuffix(s, "]]") {
+				return v, fmt.Sprintf("%d of %s", n, ids)
+			}
+			sample := strings.Fields(s[4 : len(s)-2])
+			if len(sample) != n {
+				return v, fmt.Sprintf("%d of %s", n, ids)
+			}
+			for _, id := range sample {
+				if !strings.Contains(" "+ids+" ", " "+id+" ") {
+					return v, fmt.Sprintf("%d of %s", n, ids)
+				}
+			}
+			return nil, nil
+		}
+	}
+	return mc.DoBatch([][]interface{}{
+		{"SET", "sfleet", "a", "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"SET", "sfleet", "b", "FIELD", "speed", 20, "POINT", 33.1, -115.1}, {"OK"},
+		{"SET", "sfleet", "c", "FIELD", "speed", 30, "POINT", 33.2, -115.2}, {"OK"},
+		{"SET", "sfleet", "d", "FIELD", "speed", 40, "POINT", 33.3, -115.3}, {"OK"},
+		{"SET", "sfleet", "e", "FIELD", "speed", 50, "POINT", 50, 50}, {"OK"},
+		{"SCAN", "sfleet", "SAMPLE", 10, "IDS"}, {"[0 [a b c d e]]"},
+		{"SCAN", "sfleet", "SAMPLE", 2, "IDS"}, {sampled(2, "a b c d e")},
+		{"SCAN", "sfleet", "SAMPLE", 1, "WHERE", "speed", 15, 35, "IDS"}, {sampled(1, "b c")},
+		{"WITHIN", "sfleet", "SAMPLE", 3, "IDS", "BOUNDS", 30, -120, 40, -110}, {sampled(3, "a b c d")},
+		{"INTERSECTS", "sfleet", "SAMPLE", 4, "IDS", "BOUNDS", 30, -120, 40, -110}, {"[0 [a b c d]]"},
+		{"INTERSECTS", "sfleet", "SAMPLE", 1, "POINTS", "BOUNDS", 49, 49, 51, 51}, {"[0 [[e [50 50] [speed 50]]]]"},
+		{"SCAN", "sfleet", "SAMPLE", 0, "IDS"}, {"ERR invalid argument '0'"},
+		{"SCAN", "sfleet", "SAMPLE", 1, "SAMPLE", 2, "IDS"}, {"ERR duplicate argument 'SAMPLE'"},
+		{"SCAN", "sfleet", "SAMPLE", 2, "COUNT"}, {"ERR SAMPLE is not allowed with COUNT, STATS, or CLUSTERS"},
+		{"SCAN", "sfleet", "SAMPLE", 2, "LIMIT", 1, "IDS"}, {"ERR LIMIT, SPARSE, CURSOR, and STABLE are not allowed when SAMPLE is specified"},
+		{"NEARBY", "sfleet", "SAMPLE", 2, "IDS", "POINT", 33, -115, 1000}, {"ERR SAMPLE is not allowed for NEARBY"},
+		{"WITHIN", "sfleet", "FENCE", "SAMPLE", 2, "BOUNDS", 30, -120, 40, -110}, {"ERR SAMPLE is not allowed when FENCE is specified"},
+	})
+}
+
+func keys_STREAM_test(mc *mockServer) error {
+	// enough objects for a few chunks of the stream
+	const n = 3000
+	for i := 0; i < n; i++ {
+		_, err := mc.Do("SET", "stfleet", fmt.Sprintf("truck%d", i),
+			"FIELD", "speed", i, "POINT", 33+float64(i)/10000, -115)
+		if err != nil {
+			return err
+		}
+	}
+	get := func(path string) (*http.Response, []byte, error) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d%s", mc.port, path))
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return resp, body, err
+	}
+	for _, path := range []string{
+		"/SCAN+stfleet+STREAM",
+		"/WITHIN+stfleet+STREAM+BOUNDS+32+-116+34+-114",
+		"/NEARBY+stfleet+STREAM+POINT+33+-115",
+	} {
+		resp, body, err := get(path)
+		if err != nil {
+			return err
+		}
+		if len(resp.TransferEncoding) == 0 ||
+			resp.TransferEncoding[0] != "chunked" {
+			return fmt.Errorf("%s: expected a chunked response", path)
+		}
+		if !gjson.ValidBytes(body) || !gjson.GetBytes(body, "ok").Bool() {
+			return fmt.Errorf("%s: expected valid json, got '%.100s'", path, body)
+		}
+		res := gjson.GetBytes(body, "count")
+		if res.Int() != n {
+			return fmt.Errorf("%s: expected %d objects, got %s", path, n, res)
+		}
+	}
+	// a small reply is not chunked
+	resp, body, err := get("/SCAN+stfleet+STREAM+LIMIT+2+IDS")
+	if err != nil {
+		return err
+	}
+	if len(resp.TransferEncoding) != 0 || resp.ContentLength == -1 {
+		return fmt.Errorf("expected a response with a length")
+	}
+	if ids := gjson.GetBytes(body, "ids").String(); ids != `["truck0","truck1"]` {
+		return fmt.Errorf("expected two ids, got '%s'", body)
+	}
+	return mc.DoBatch([][]interface{}{
+		{"SCAN", "stfleet", "STREAM", "IDS"}, {"ERR STREAM requires the json output of an HTTP or WebSocket connection"},
+		{"SCAN", "stfleet", "STREAM", "STREAM", "IDS"}, {"ERR duplicate argument 'STREAM'"},
+		{"WITHIN", "stfleet", "FENCE", "STREAM", "BOUNDS", 32, -116, 34, -114}, {"ERR invalid argument 'STREAM'"},
+		{"DROP", "stfleet"}, {1},
+	})
+}
+
+func keys_SINCE_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "tsfleet", "a", "POINT", 33, -115}, {"OK"},
+		{"SET", "tsfleet", "b", "TIMESTAMP", "2020-01-01T00:00:00Z", "POINT", 33, -115}, {"OK"},
+		{"SET", "tsfleet", "c", "TIMESTAMP", 1600000000, "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"PSET", "tsfleet", "d", "POINT", 33, -115, "e", "TIMESTAMP", 1500000000, "POINT", 33, -115}, {"2"},
+		{"SCAN", "tsfleet", "SINCE", "5m", "IDS"}, {"[0 [a d]]"},
+		{"NEARBY", "tsfleet", "SINCE", 300, "IDS", "POINT", 33, -115, 1000}, {"[0 [a d]]"},
+		{"WITHIN", "tsfleet", "BETWEEN", "2019-01-01T00:00:00Z", 1700000000, "IDS", "BOUNDS", 30, -120, 40, -110}, {"[0 [b c]]"},
+		{"INTERSECTS", "tsfleet", "BETWEEN", 0, 1590000000, "IDS", "BOUNDS", 30, -120, 40, -110}, {"[0 [b e]]"},
+		{"SCAN", "tsfleet", "BETWEEN", 1600000000, 1600000000, "COUNT"}, {"1"},
+		// the time is kept when the fields change
+		{"FSET", "tsfleet", "b", "speed", 5}, {"1"},
+		{"SCAN", "tsfleet", "BETWEEN", "2020-01-01T00:00:00Z", "2020-01-01T00:00:00Z", "IDS"}, {"[0 [b]]"},
+		{"SET", "tsfleet", "b", "POINT", 33, -115}, {"OK"},
+		{"SCAN", "tsfleet", "BETWEEN", "2020-01-01T00:00:00Z", "2020-01-01T00:00:00Z", "IDS"}, {"[0 []]"},
+		{"SCAN", "tsfleet", "SINCE", "5m", "BETWEEN", 0, 1, "IDS"}, {"ERR duplicate argument 'BETWEEN'"},
+		{"SCAN", "tsfleet", "BETWEEN", 2, 1, "IDS"}, {"ERR invalid argument '2'"},
+		{"SCAN", "tsfleet", "SINCE", "5x", "IDS"}, {"ERR invalid argument '5x'"},
+		{"SET", "tsfleet", "f", "TIMESTAMP", "yesterday", "POINT", 33, -115}, {"ERR invalid argument 'yesterday'"},
+		{"SET", "tsfleet", "f", "TIMESTAMP", 1, "TIMESTAMP", 2, "POINT", 33, -115}, {"ERR invalid argument 'TIMESTAMP'"},
+		{"WITHIN", "tsfleet", "FENCE", "SINCE", "5m", "BOUNDS", 30, -120, 40, -110}, {"ERR SINCE and BETWEEN are not allowed when FENCE is specified"},
+	})
+}
@@ -3,7 +3,10 @@ package tests
 import (
 	"errors"
 	"fmt"
+	"math"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -20,19 +23,38 @@ func subTestKeys(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "DROP", keys_DROP_test)
 	runStep(t, mc, "RENAME", keys_RENAME_test)
 	runStep(t, mc, "RENAMENX", keys_RENAMENX_test)
+	runStep(t, mc, "COPY", keys_COPY_test)
 	runStep(t, mc, "EXPIRE", keys_EXPIRE_test)
+	runStep(t, mc, "EXPIRE_JITTER", keys_EXPIRE_JITTER_test)
 	runStep(t, mc, "FSET", keys_FSET_test)
+	runStep(t, mc, "FINCRBY", keys_FINCRBY_test)
 	runStep(t, mc, "GET", keys_GET_test)
 	runStep(t, mc, "KEYS", keys_KEYS_test)
+	runStep(t, mc, "LABEL", keys_LABEL_test)
 	runStep(t, mc, "PERSIST", keys_PERSIST_test)
 	runStep(t, mc, "SET", keys_SET_test)
 	runStep(t, mc, "STATS", keys_STATS_test)
+	runStep(t, mc, "STATS EXT", keys_STATS_EXT_test)
+	runStep(t, mc, "MEMORY", keys_MEMORY_test)
 	runStep(t, mc, "TTL", keys_TTL_test)
+	runStep(t, mc, "EXISTS and TYPE", keys_EXISTS_TYPE_test)
 	runStep(t, mc, "SET EX", keys_SET_EX_test)
+	runStep(t, mc, "SET IF", keys_SET_IF_test)
+	runStep(t, mc, "FSET EX", keys_FSET_EX_test)
+	runStep(t, mc, "SET SNAP", keys_SET_SNAP_test)
 	runStep(t, mc, "PDEL", keys_PDEL_test)
+	runStep(t, mc, "PSET", keys_PSET_test)
 	runStep(t, mc, "FIELDS", keys_FIELDS_test)
+	runStep(t, mc, "TYPED FIELDS", keys_TYPED_FIELDS_test)
+	runStep(t, mc, "SCHEMA", keys_SCHEMA_test)
+	runStep(t, mc, "LIMITS", keys_LIMITS_test)
+	runStep(t, mc, "GEOINDEX", keys_GEOINDEX_test)
+	runStep(t, mc, "REINDEX", keys_REINDEX_test)
+	runStep(t, mc, "QUOTA", keys_QUOTA_test)
+	runStep(t, mc, "HISTORY", keys_HISTORY_test)
 	runStep(t, mc, "WHEREIN", keys_WHEREIN_test)
 	runStep(t, mc, "WHEREEVAL", keys_WHEREEVAL_test)
+	runStep(t, mc, "WHERESTR", keys_WHERESTR_test)
 }
 
 func keys_BOUNDS_test(mc *mockServer) error {
@@ -101,6 +123,35 @@ func keys_RENAMENX_test(mc *mockServer) error {
 		{"SCAN", "mynewkey", "COUNT"}, {2},
 	})
 }
+func keys_COPY_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "myid1", "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "myid2", "FIELD", "speed", 20, "POINT", 34, -115}, {"OK"},
+		{"SET", "mykey", "myid3", "FIELD", "speed", 30, "POINT", 50, -100}, {"OK"},
+		{"SET", "mykey", "myid4", "STRING", "hello"}, {"OK"},
+		{"EXPIRE", "mykey", "myid2", 100}, {1},
+		{"FSET", "mykey", "myid3", "EX", 100, "alarm", 1}, {1},
+		{"COPY", "mykey", "mykey"}, {"ERR source and destination keys are the same"},
+		{"COPY", "mykey", "mynewkey", "NOFIELDS"}, {"ERR COPY only accepts filters"},
+		{"COPY", "mykey", "mynewkey", "WHERE", "speed", 15, "+inf"}, {2},
+		{"SCAN", "mynewkey", "IDS"}, {"[0 [myid2 myid3]]"},
+		{"GET", "mynewkey", "myid3", "WITHFIELDS", "POINT"}, {"[[50 -100] [alarm 1 speed 30]]"},
+		{"TTL", "mynewkey", "myid2"}, {99},
+		{"TTL", "mynewkey", "myid3"}, {-1},
+		{"TTL", "mynewkey", "myid3", "alarm"}, {99},
+		{"COPY", "mykey", "mynewkey"}, {0},
+		{"SCAN", "mynewkey", "COUNT"}, {2},
+		{"COPY", "mykey", "mynewkey", "WITHIN", "BOUNDS", 30, -120, 40, -110, "REPLACE"}, {2},
+		{"SCAN", "mynewkey", "IDS"}, {"[0 [myid1 myid2]]"},
+		{"COPY", "mykey", "mynewkey", "MATCH", "myid*", "LIMIT", 3, "replace"}, {3},
+		{"SCAN", "mynewkey", "COUNT"}, {3},
+		{"COPY", "mykey", "mynewkey", "MATCH", "myid4", "REPLACE"}, {1},
+		{"GET", "mynewkey", "myid4"}, {"hello"},
+		{"COPY", "foo", "mynewkey", "REPLACE"}, {0},
+		{"SCAN", "mynewkey", "COUNT"}, {0},
+		{"SCAN", "mykey", "COUNT"}, {4},
+	})
+}
 func keys_EXPIRE_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "myid", "STRING", "value"}, {"OK"},
@@ -111,6 +162,43 @@ func keys_EXPIRE_test(mc *mockServer) error {
 		{"GET", "mykey", "myid"}, {nil},
 	})
 }
+func keys_EXPIRE_JITTER_test(mc *mockServer) error {
+	defer mc.Do("DROP", "jitterkey")
+	defer mc.Do("CONFIG", "SET", "expire-max-rate", "")
+	ttl := func(min, max int) func(v interface{}) (resp, expect interface{}) {
+		return func(v interface{}) (resp, expect interface{}) {
+			n, _ := strconv.Atoi(fmt.Sprint(v))
+			if n < min || n > max {
+				return v, fmt.Sprintf("%d to %d", min, max)
+			}
+			return nil, nil
+		}
+	}
+	return mc.DoBatch([][]interface{}{
+		{"SET", "jitterkey", "j1", "EX", 100, "JITTER", 50, "STRING", "v"}, {"OK"},
+		{"TTL", "jitterkey", "j1"}, {ttl(99, 150)},
+		{"EXPIRE", "jitterkey", "j1", 10, "JITTER", 0}, {1},
+		{"TTL", "jitterkey", "j1"}, {ttl(9, 10)},
+		{"EXPIRE", "jitterkey", "j1", 10, "JITTER", "x"}, {"ERR invalid argument 'x'"},
+		{"SET", "jitterkey", "j1", "JITTER", 5, "STRING", "v"}, {"ERR invalid argument 'JITTER'"},
+		{"CONFIG", "GET", "expire-max-rate"}, {"[expire-max-rate 0]"},
+		{"CONFIG", "SET", "expire-max-rate", "-1"}, {"ERR Invalid argument '-1' for CONFIG SET 'expire-max-rate'"},
+		{"CONFIG", "SET", "expire-max-rate", 1}, {"OK"},
+		{"SET", "jitterkey", "j2", "EX", 0.1, "STRING", "v"}, {"OK"},
+		{"SET", "jitterkey", "j3", "EX", 0.1, "STRING", "v"}, {"OK"},
+		{time.Second / 2}, {}, // sleep
+		{"INFO", "stats"}, {func(v interface{}) (resp, expect interface{}) {
+			s := fmt.Sprintf("%s", v)
+			if !strings.Contains(s, "expire_sweeps:") ||
+				!strings.Contains(s, "expire_sweep_max_usec:") ||
+				strings.Contains(s, "expire_sweeps_limited:0") {
+				return v, "expire_sweeps ... expire_sweep_max_usec ... expire_sweeps_limited:1"
+			}
+			return nil, nil
+		}},
+	})
+}
+
 func keys_FSET_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "myid", "HASH", "9my5xp7"}, {"OK"},
@@ -131,6 +219,26 @@ func keys_FSET_test(mc *mockServer) error {
 		{"GET", "mykey", "myid"}, {nil},
 	})
 }
+func keys_FINCRBY_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"FINCRBY", "mykey", "myid", "visits", 1}, {"ERR key not found"},
+		{"SET", "mykey", "myid", "FIELD", "name", "Tom", "POINT", 33, -115}, {"OK"},
+		{"FINCRBY", "mykey", "myid2", "visits", 1}, {"ERR id not found"},
+		{"FINCRBY", "mykey", "myid", "visits"}, {"ERR wrong number of arguments for 'fincrby' command"},
+		{"FINCRBY", "mykey", "myid", "visits", "many"}, {"ERR invalid argument 'many'"},
+		{"FINCRBY", "mykey", "myid", "name", 1}, {"ERR field 'name' is not a number"},
+		{"FINCRBY", "mykey", "myid", "visits", 1}, {1},
+		{"FINCRBY", "mykey", "myid", "visits", 5}, {6},
+		{"FINCRBY", "mykey", "myid", "visits", -1.5}, {4.5},
+		{"GET", "mykey", "myid", "WITHFIELDS", "POINT"}, {"[[33 -115] [name Tom visits 4.5]]"},
+		{"FSET", "mykey", "myid", "EX", 100, "alarm", 1}, {1},
+		{"FINCRBY", "mykey", "myid", "alarm", 1}, {2},
+		{"TTL", "mykey", "myid", "alarm"}, {99},
+		{"FINCRBY", "mykey", "myid", "alarm", -2}, {0},
+		{"TTL", "mykey", "myid", "alarm"}, {-1},
+	})
+}
+
 func keys_GET_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "myid", "STRING", "value"}, {"OK"},
@@ -165,6 +273,36 @@ func keys_KEYS_test(mc *mockServer) error {
 		{"KEYS", "mykey[^3]*"}, {"[mykey11 mykey22 mykey42]"},
 	})
 }
+
+func keys_LABEL_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "fleet1", "truck1", "STRING", "hello"}, {"OK"},
+		{"SET", "fleet2", "truck1", "STRING", "hello"}, {"OK"},
+		{"SET", "parcels", "p1", "STRING", "hello"}, {"OK"},
+		{"SETLABEL", "fleet1"}, {"ERR wrong number of arguments for 'setlabel' command"},
+		{"SETLABEL", "fleet1", "owner"}, {"ERR wrong number of arguments for 'setlabel' command"},
+		{"SETLABEL", "fleet1", "owner", "alice", "tenant", "acme"}, {"OK"},
+		{"SETLABEL", "fleet2", "owner", "bob", "tenant", "acme"}, {"OK"},
+		{"SETLABEL", "parcels", "tenant", "initech"}, {"OK"},
+		{"LABELS", "fleet1"}, {"[owner alice tenant acme]"},
+		{"LABELS", "foo"}, {"[]"},
+		{"KEYS", "*", "LABEL", "tenant", "acme"}, {"[fleet1 fleet2]"},
+		{"KEYS", "*", "LABEL", "tenant", "acme", "LABEL", "owner", "b*"}, {"[fleet2]"},
+		{"KEYS", "*", "LABEL", "owner", "*"}, {"[fleet1 fleet2]"},
+		{"KEYS", "parcels", "LABEL", "owner", "*"}, {"[]"},
+		{"KEYS", "*", "LABEL", "owner"}, {"ERR wrong number of arguments for 'keys' command"},
+		{"KEYS", "*", "FOO"}, {"ERR invalid argument 'FOO'"},
+		{"SETLABEL", "fleet1", "owner", "carol"}, {"OK"},
+		{"LABELS", "fleet1"}, {"[owner carol tenant acme]"},
+		{"DELLABEL", "fleet1", "owner", "color"}, {1},
+		{"LABELS", "fleet1"}, {"[tenant acme]"},
+		{"STATS", "fleet1"}, {"[[in_memory_fields_size 0 in_memory_geometry_size 5 in_memory_ids_size 6 in_memory_index_size 0 in_memory_size 11 labels map[tenant:acme] num_objects 1 num_points 0 num_strings 1]]"},
+		{"DELLABEL", "fleet2"}, {2},
+		{"LABELS", "fleet2"}, {"[]"},
+		{"DROP", "parcels"}, {1},
+		{"LABELS", "parcels"}, {"[tenant initech]"},
+	})
+}
 func keys_PERSIST_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "myid", "STRING", "value"}, {"OK"},
@@ -232,21 +370,151 @@ func keys_STATS_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"STATS", "mykey"}, {"[nil]"},
 		{"SET", "mykey", "myid", "STRING", "value"}, {"OK"},
-		{"STATS", "mykey"}, {"[[in_memory_size 9 num_objects 1 num_points 0 num_strings 1]]"},
+		{"STATS", "mykey"}, {"[[in_memory_fields_size 0 in_memory_geometry_size 5 in_memory_ids_size 4 in_memory_index_size 0 in_memory_size 9 num_objects 1 num_points 0 num_strings 1]]"},
 		{"SET", "mykey", "myid2", "STRING", "value"}, {"OK"},
-		{"STATS", "mykey"}, {"[[in_memory_size 19 num_objects 2 num_points 0 num_strings 2]]"},
+		{"STATS", "mykey"}, {"[[in_memory_fields_size 0 in_memory_geometry_size 10 in_memory_ids_size 9 in_memory_index_size 0 in_memory_size 19 num_objects 2 num_points 0 num_strings 2]]"},
 		{"SET", "mykey", "myid3", "OBJECT", `{"type":"Point","coordinates":[-115,33]}`}, {"OK"},
-		{"STATS", "mykey"}, {"[[in_memory_size 40 num_objects 3 num_points 1 num_strings 2]]"},
+		{"STATS", "mykey"}, {"[[in_memory_fields_size 0 in_memory_geometry_size 26 in_memory_ids_size 14 in_memory_index_size 0 in_memory_size 40 num_objects 3 num_points 1 num_strings 2]]"},
 		{"DEL", "mykey", "myid"}, {1},
-		{"STATS", "mykey"}, {"[[in_memory_size 31 num_objects 2 num_points 1 num_strings 1]]"},
+		{"STATS", "mykey"}, {"[[in_memory_fields_size 0 in_memory_geometry_size 21 in_memory_ids_size 10 in_memory_index_size 0 in_memory_size 31 num_objects 2 num_points 1 num_strings 1]]"},
 		{"DEL", "mykey", "myid3"}, {1},
-		{"STATS", "mykey"}, {"[[in_memory_size 10 num_objects 1 num_points 0 num_strings 1]]"},
-		{"STATS", "mykey", "mykey2"}, {"[[in_memory_size 10 num_objects 1 num_points 0 num_strings 1] nil]"},
+		{"STATS", "mykey"}, {"[[in_memory_fields_size 0 in_memory_geometry_size 5 in_memory_ids_size 5 in_memory_index_size 0 in_memory_size 10 num_objects 1 num_points 0 num_strings 1]]"},
+		{"STATS", "mykey", "mykey2"}, {"[[in_memory_fields_size 0 in_memory_geometry_size 5 in_memory_ids_size 5 in_memory_index_size 0 in_memory_size 10 num_objects 1 num_points 0 num_strings 1] nil]"},
 		{"DEL", "mykey", "myid2"}, {1},
 		{"STATS", "mykey"}, {"[nil]"},
 		{"STATS", "mykey", "mykey2"}, {"[nil nil]"},
 	})
 }
+func keys_STATS_EXT_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "p1", "FIELD", "speed", 10, "EX", 100, "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "p2", "POINT", 34, -114}, {"OK"},
+		{"SET", "mykey", "poly", "OBJECT", `{"type":"Polygon","coordinates":[[[-116,32],[-113,32],[-113,35],[-116,32]]]}`}, {"OK"},
+		{"SET", "mykey", "str", "STRING", "value"}, {"OK"},
+		{"FSET", "mykey", "p1", "EX", 100, "speed", 20}, {1},
+		{"STATS", "mykey", "EXT"}, {func(v interface{}) (resp, expect interface{}) {
+			s := fmt.Sprint(v)
+			for _, part := range []string{
+				"[[bounds [-116 32 -113 35] ",
+				" index_depth 1 index_fill 0.09 index_nodes 1 ",
+				" max_object_points 4 max_object_points_id poly ",
+				" num_expiring_fields 1 num_expiring_objects 1 " +
+					"num_field_indexes 0 num_fields 1 num_geometry_objects 1 " +
+					"num_objects 4 num_point_objects 2 num_points 6 num_strings 1 " +
+					"points_le_1 2 points_le_10 1 points_le_100 0 " +
+					"points_le_1000 0 points_le_10000 0 points_le_100000 0 " +
+					"points_le_inf 0]]",
+			} {
+				if !strings.Contains(s, part) {
+					return s, part
+				}
+			}
+			return nil, nil
+		}},
+		{"STATS", "ext"}, {"[nil]"},
+		{"STATS", "mykey", "nokey", "EXT"}, {func(v interface{}) (resp, expect interface{}) {
+			return strings.HasSuffix(fmt.Sprint(v), "] nil]"), true
+		}},
+		{"DROP", "mykey"}, {1},
+	})
+}
+func keys_MEMORY_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"MEMORY", "USAGE", "mykey"}, {nil},
+		{"SET", "mykey", "myid", "STRING", "value"}, {"OK"},
+		{"SET", "mykey", "myid2", "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"MEMORY", "USAGE", "mykey"}, {38},
+		{"MEMORY", "USAGE", "mykey", "myid"}, {9},
+		{"MEMORY", "USAGE", "mykey", "myid3"}, {nil},
+		{"SETINDEX", "mykey", "speed"}, {1},
+		{"MEMORY", "USAGE", "mykey", "myid2"}, {42},
+		{"MEMORY", "STATS", "mykey", "myid2"}, {"[in_memory_fields_size 8 in_memory_geometry_size 16 in_memory_ids_size 5 in_memory_index_size 13 in_memory_size 42 num_fields 1 num_points 1 type Point]"},
+		{"MEMORY", "STATS", "mykey"}, {"[in_memory_fields_size 8 in_memory_geometry_size 21 in_memory_ids_size 9 in_memory_index_size 13 in_memory_size 51 num_objects 2]"},
+		{"MEMORY", "STATS", "mykey", "myid", "x"}, {"ERR wrong number of arguments for 'memory' command"},
+		{"MEMORY", "DOCTOR"}, {"ERR Syntax error, try MEMORY (USAGE | STATS)"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"MEMORY", "USAGE", "mykey", "myid"}, {`{"ok":true,"usage":9}`},
+		{"MEMORY", "STATS", "mykey", "myid"}, {`{"ok":true,"stats":{"in_memory_fields_size":0,"in_memory_geometry_size":5,"in_memory_ids_size":4,"in_memory_index_size":0,"in_memory_size":9,"num_fields":0,"num_points":0,"type":"String"}}`},
+		{"OUTPUT", "resp"}, {"OK"},
+		{"DELINDEX", "mykey", "speed"}, {1},
+		{"DROP", "mykey"}, {1},
+	})
+}
+
+func keys_GEOINDEX_test(mc *mockServer) error {
+	poly := `{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,10],[0,0]]]}`
+	return mc.DoBatch([][]interface{}{
+		{"GEOINDEX", "mykey"}, {"[kind quadtree points 64 multi 64]"},
+		{"SET", "mykey", "poly", "OBJECT", poly}, {"OK"},
+		{"SETGEOINDEX", "mykey", "KIND", "octree"}, {"ERR invalid argument 'octree'"},
+		{"SETGEOINDEX", "mykey", "POINTS", -1}, {"ERR invalid argument '-1'"},
+		{"SETGEOINDEX", "mykey", "DEPTH", 1}, {"ERR invalid argument 'DEPTH'"},
+		{"SETGEOINDEX", "mykey", "KIND"}, {"ERR wrong number of arguments for 'setgeoindex' command"},
+		{"SETGEOINDEX", "mykey", "KIND", "rtree", "POINTS", 4}, {1},
+		{"SETGEOINDEX", "mykey", "KIND", "rtree", "POINTS", 4}, {0},
+		{"GEOINDEX", "mykey"}, {"[kind rtree points 4 multi 64]"},
+		{"INTERSECTS", "mykey", "IDS", "BOUNDS", 4, 4, 6, 6}, {"[0 [poly]]"},
+		{"INTERSECTS", "mykey", "IDS", "BOUNDS", 20, 20, 21, 21}, {"[0 []]"},
+		{"DROP", "mykey"}, {1},
+		{"GEOINDEX", "mykey"}, {"[kind rtree points 4 multi 64]"},
+		{"SET", "mykey", "poly", "OBJECT", poly}, {"OK"},
+		{"WITHIN", "mykey", "IDS", "BOUNDS", -1, -1, 11, 11}, {"[0 [poly]]"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"GEOINDEX", "mykey"}, {`{"ok":true,"geoindex":{"kind":"rtree","points":4,"multi":64}}`},
+		{"OUTPUT", "resp"}, {"OK"},
+		{"SETGEOINDEX", "mykey"}, {1},
+		{"SETGEOINDEX", "mykey"}, {0},
+		{"GEOINDEX", "mykey"}, {"[kind quadtree points 64 multi 64]"},
+		{"INTERSECTS", "mykey", "IDS", "BOUNDS", 4, 4, 6, 6}, {"[0 [poly]]"},
+		{"DROP", "mykey"}, {1},
+	})
+}
+
+func keys_REINDEX_test(mc *mockServer) error {
+	const n = 2000
+	var cmds [][]interface{}
+	for i := 0; i < n; i++ {
+		cmds = append(cmds, []interface{}{"SET", "rikey", fmt.Sprintf("id%04d", i),
+			"POINT", 33 + float64(i%100)/100, -115 + float64(i/100)/100},
+			[]interface{}{"OK"})
+	}
+	for i := 0; i < n; i++ {
+		if i%10 != 0 {
+			cmds = append(cmds, []interface{}{"DEL", "rikey", fmt.Sprintf("id%04d", i)},
+				[]interface{}{1})
+		}
+	}
+	err := mc.DoBatch(cmds, [][]interface{}{
+		{"REINDEX", "nokey"}, {"ERR key not found"},
+		{"REINDEX"}, {"ERR wrong number of arguments for 'reindex' command"},
+		{"REINDEX", "rikey"}, {"OK"},
+		// the writes go on while the index is rebuilt
+		{"SET", "rikey", "new", "POINT", 33.5, -114.5}, {"OK"},
+		{"DEL", "rikey", "id0010"}, {1},
+	})
+	if err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		v, err := mc.Do("STATS", "rikey", "EXT")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(fmt.Sprint(v), " index_rebuilding true ") {
+			break
+		}
+		if i == 100 {
+			return errors.New("the index is still being rebuilt")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return mc.DoBatch([][]interface{}{
+		{"INTERSECTS", "rikey", "COUNT", "BOUNDS", 32, -116, 35, -114}, {n / 10},
+		{"NEARBY", "rikey", "LIMIT", 1, "IDS", "POINT", 33.5, -114.5}, {"[1 [new]]"},
+		{"DROP", "rikey"}, {1},
+	})
+}
+
 func keys_TTL_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "myid", "STRING", "value"}, {"OK"},
@@ -256,6 +524,49 @@ func keys_TTL_test(mc *mockServer) error {
 	})
 }
 
+func keys_EXISTS_TYPE_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "exkey", "p", "POINT", 33, -115}, {"OK"},
+		{"SET", "exkey", "s", "STRING", "value"}, {"OK"},
+		{"SET", "exkey", "b", "BOUNDS", 33, -115, 34, -114}, {"OK"},
+		{"SET", "exkey", "l", "OBJECT", `{"type":"LineString","coordinates":[[-115,33],[-114,34]]}`}, {"OK"},
+		{"SET", "exkey", "f", "OBJECT", `{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]},"properties":{}}`}, {"OK"},
+		{"SET", "exkey", "gone", "EX", 0.1, "POINT", 33, -115}, {"OK"},
+		{time.Second / 4}, {}, // sleep
+		{"EXISTS", "exkey"}, {"ERR wrong number of arguments for 'exists' command"},
+		{"EXISTS", "exkey", "p", "nope", "s", "gone"}, {"[1 0 1 0]"},
+		{"EXISTS", "nokey", "p"}, {"[0]"},
+		{"TYPE", "exkey"}, {"hash"},
+		{"TYPE", "exkey", "p", "s", "b", "l", "f", "nope", "gone"},
+		{"[point string polygon linestring polygon nil nil]"},
+		{"TYPE", "nokey", "p"}, {"[nil]"},
+	})
+}
+
+func keys_FSET_EX_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "myid", "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"FSET", "mykey", "myid", "EX"}, {"ERR wrong number of arguments for 'fset' command"},
+		{"FSET", "mykey", "myid", "EX", "soon", "alarm", 1}, {"ERR invalid argument 'soon'"},
+		{"FSET", "mykey", "myid", "EX", 0.5, "alarm", 1, "fuel", 2}, {2},
+		{"FSET", "mykey", "myid", "fuel", 3}, {1},
+		{"TTL", "mykey", "myid"}, {-1},
+		{"TTL", "mykey", "myid", "alarm"}, {0},
+		{"TTL", "mykey", "myid", "fuel"}, {-1},
+		{"TTL", "mykey", "myid", "speed"}, {-1},
+		{"TTL", "mykey", "myid2", "alarm"}, {-2},
+		{time.Second}, {}, // sleep
+		{"GET", "mykey", "myid", "WITHFIELDS", "POINT"}, {"[[33 -115] [fuel 3 speed 10]]"},
+		{"FSET", "mykey", "myid", "EX", 10, "alarm", 1}, {1},
+		{"TTL", "mykey", "myid", "alarm"}, {9},
+		{"SET", "mykey", "myid", "FIELD", "alarm", 1, "POINT", 33, -115}, {"OK"},
+		{"TTL", "mykey", "myid", "alarm"}, {-1},
+		{"SETSCHEMA", "mykey", "FIELD", "alarm", "number", "REQUIRED"}, {"OK"},
+		{"FSET", "mykey", "myid", "EX", 10, "alarm", 2}, {"ERR field 'alarm' is required"},
+		{"DELSCHEMA", "mykey"}, {1},
+	})
+}
+
 type PSAUX struct {
 	User    string
 	PID     int
@@ -312,6 +623,33 @@ func psaux(pid int) PSAUX {
 	}
 	return PSAUX{}
 }
+func keys_SET_IF_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "iffleet", "a", "IF", "VERSION", 1, "POINT", 33, -115}, {nil},
+		{"SET", "iffleet", "a", "IF", "VERSION", 0, "FIELD", "ts", 100, "POINT", 33, -115}, {"OK"},
+		{"GET", "iffleet", "a", "WITHVERSION", "POINT"}, {"[[33 -115] 1]"},
+		{"SET", "iffleet", "a", "IF", "VERSION", 1, "FIELD", "ts", 200, "POINT", 34, -115}, {"OK"},
+		{"SET", "iffleet", "a", "IF", "VERSION", 1, "POINT", 35, -115}, {nil},
+		{"SET", "iffleet", "a", "IF", "FIELD", "ts", "<", 150, "FIELD", "ts", 150, "POINT", 36, -115}, {nil},
+		{"SET", "iffleet", "a", "IF", "FIELD", "ts", "<", 300, "IF", "VERSION", ">=", 2, "FIELD", "ts", 300, "POINT", 37, -115}, {"OK"},
+		{"GET", "iffleet", "a", "WITHFIELDS", "WITHVERSION", "POINT"}, {"[[37 -115] [ts 300] 3]"},
+		{"FSET", "iffleet", "a", "speed", 10}, {"1"},
+		{"GET", "iffleet", "a", "WITHVERSION", "HASH", 1}, {"[9 4]"},
+		// an explicit version
+		{"SET", "iffleet", "b", "VERSION", 10, "IF", "VERSION", "<", 10, "POINT", 33, -115}, {"OK"},
+		{"SET", "iffleet", "b", "VERSION", 9, "IF", "VERSION", "<", 9, "POINT", 34, -115}, {nil},
+		{"GET", "iffleet", "b", "WITHVERSION"}, {`[{"type":"Point","coordinates":[-115,33]} 10]`},
+		{"DEL", "iffleet", "b"}, {"1"},
+		{"SET", "iffleet", "b", "IF", "VERSION", 0, "POINT", 33, -115}, {"OK"},
+		{"SET", "iffleet", "a", "IF", "VERSION", "=", 1, "POINT", 33, -115}, {"ERR invalid argument '='"},
+		{"SET", "iffleet", "a", "IF", "SPEED", 1, "POINT", 33, -115}, {"ERR invalid argument 'SPEED'"},
+		{"SET", "iffleet", "a", "VERSION", 0, "POINT", 33, -115}, {"ERR invalid argument '0'"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"SET", "iffleet", "a", "IF", "VERSION", 1, "POINT", 33, -115}, {`{"ok":false,"err":"condition not met"}`},
+		{"OUTPUT", "resp"}, {"OK"},
+	})
+}
+
 func keys_SET_EX_test(mc *mockServer) (err error) {
 	rand.Seed(time.Now().UnixNano())
 
@@ -352,8 +690,8 @@ func keys_FIELDS_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "myid1a", "FIELD", "a", 1, "POINT", 33, -115}, {"OK"},
 		{"GET", "mykey", "myid1a", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [a 1]]`},
-		{"SET", "mykey", "myid1a", "FIELD", "a", "a", "POINT", 33, -115}, {"ERR invalid argument 'a'"},
-		{"GET", "mykey", "myid1a", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [a 1]]`},
+		{"SET", "mykey", "myid1a", "FIELD", "a", "a", "POINT", 33, -115}, {"OK"},
+		{"GET", "mykey", "myid1a", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [a a]]`},
 		{"SET", "mykey", "myid1a", "FIELD", "a", 1, "FIELD", "b", 2, "POINT", 33, -115}, {"OK"},
 		{"GET", "mykey", "myid1a", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33]} [a 1 b 2]]`},
 		{"SET", "mykey", "myid1a", "FIELD", "b", 2, "POINT", 33, -115}, {"OK"},
@@ -363,6 +701,211 @@ func keys_FIELDS_test(mc *mockServer) error {
 	})
 }
 
+func keys_TYPED_FIELDS_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "truck1", "FIELD", "state", "idle", "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "truck2", "FIELD", "state", "moving", "FIELD", "speed", 10, "POINT", 33.01, -115}, {"OK"},
+		{"FSET", "mykey", "truck1", "active", "true", "cargo", `{"kind":"fruit"}`}, {2},
+		{"FSET", "mykey", "truck2", "active", "false"}, {1},
+		{"FSET", "mykey", "truck2", "active", "false"}, {0},
+		{"GET", "mykey", "truck1", "WITHFIELDS", "POINT"}, {`[[33 -115] [active true cargo {"kind":"fruit"} state idle]]`},
+		{"SCAN", "mykey", "WHERE", "state", "eq", "idle", "IDS"}, {"[0 [truck1]]"},
+		{"SCAN", "mykey", "WHERE", "state", "prefix", "mov", "IDS"}, {"[0 [truck2]]"},
+		{"SCAN", "mykey", "WHERE", "active", 1, 1, "IDS"}, {"[0 [truck1]]"},
+		{"SCAN", "mykey", "WHERE", "active", "eq", "false", "IDS"}, {"[0 [truck2]]"},
+		{"SCAN", "mykey", "WHERE", "state", "-inf", "+inf", "IDS"}, {"[0 []]"},
+		{"SCAN", "mykey", "WHERE", "speed", 0, 5, "IDS"}, {"[0 [truck1]]"},
+		{"SCAN", "mykey", "WHEREEVAL", "return FIELDS.state == ARGV[1] and FIELDS.active", 1, "idle", "IDS"}, {"[0 [truck1]]"},
+		{"FSET", "mykey", "truck1", "state", 0}, {1},
+		{"SCAN", "mykey", "WHERE", "state", "eq", "idle", "IDS"}, {"[0 []]"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"GET", "mykey", "truck2", "WITHFIELDS"}, {`{"ok":true,"object":{"type":"Point","coordinates":[-115,33.01]},"fields":{"active":false,"speed":10,"state":"moving"}}`},
+		{"SCAN", "mykey", "WHERE", "state", "eq", "moving"}, {`{"ok":true,"fields":["active","cargo","speed","state"],"objects":[{"id":"truck2","object":{"type":"Point","coordinates":[-115,33.01]},"fields":[false,0,10,"moving"]}],"count":1,"cursor":0}`},
+		{"OUTPUT", "resp"}, {"OK"},
+	})
+}
+
+func keys_QUOTA_test(mc *mockServer) error {
+	defer mc.Do("CONFIG", "SET", "maxkeyrate", "")
+	defer mc.Do("CONFIG", "SET", "maxclientrate", "")
+	defer mc.Do("CONFIG", "SET", "maxkeyobjects", "")
+	defer mc.Do("CONFIG", "SET", "maxobjectsize", "")
+	if err := mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "maxkeyobjects", "many"}, {"ERR Invalid argument 'many' for CONFIG SET 'maxkeyobjects'"},
+		{"CONFIG", "SET", "maxkeyobjects", "2"}, {"OK"},
+		{"CONFIG", "GET", "maxkeyobjects"}, {"[maxkeyobjects 2]"},
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck2", "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck3", "POINT", 33, -115}, {"ERR quota exceeded, the key 'fleet' is over 2 objects"},
+		{"PSET", "depot", "truck1", "POINT", 33, -115, "truck2", "POINT", 33, -115, "truck3", "POINT", 33, -115}, {"ERR quota exceeded, the key 'depot' is over 2 objects"},
+		// objects that are already stored may be changed
+		{"SET", "fleet", "truck2", "POINT", 34, -116}, {"OK"},
+		{"CONFIG", "SET", "maxkeyobjects", "0"}, {"OK"},
+		{"SET", "fleet", "truck3", "POINT", 33, -115}, {"OK"},
+
+		{"CONFIG", "SET", "maxobjectsize", "1kb"}, {"OK"},
+		{"CONFIG", "GET", "maxobjectsize"}, {"[maxobjectsize 1kb]"},
+		{"SET", "fleet", "truck4", "STRING", strings.Repeat("x", 1000)}, {"OK"},
+		{"SET", "fleet", "truck4", "FIELD", "note", strings.Repeat("x", 1000), "POINT", 33, -115}, {"ERR quota exceeded, the object 'truck4' is over 1kb"},
+		{"CONFIG", "SET", "maxobjectsize", ""}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	// start at the beginning of a second, so that the writes below are in
+	// the same second
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	if err := mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "maxkeyrate", "2"}, {"OK"},
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"OK"},
+		{"SET", "depot", "truck1", "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck2", "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck3", "POINT", 33, -115}, {"ERR quota exceeded, the key 'fleet' is over 2 writes per second"},
+		{"CONFIG", "SET", "maxkeyrate", "0"}, {"OK"},
+		{"CONFIG", "SET", "maxclientrate", "1"}, {"OK"},
+		{"SET", "depot", "truck2", "POINT", 33, -115}, {"ERR quota exceeded, the client is over 1 writes per second"},
+		{"CONFIG", "SET", "maxclientrate", "0"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	info, err := redis.String(mc.Do("INFO", "stats"))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(info, "quota_rejected_writes:5\r\n") {
+		return errors.New("expected 5 writes rejected by a quota")
+	}
+	return nil
+}
+
+func keys_SCHEMA_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "truck0", "FIELD", "speed", 500, "POINT", 33, -115}, {"OK"},
+		{"SCHEMA", "mykey"}, {nil},
+		{"SETSCHEMA", "mykey"}, {"ERR wrong number of arguments for 'setschema' command"},
+		{"SETSCHEMA", "mykey", "FIELD", "speed", "text"}, {"ERR invalid argument 'text'"},
+		{"SETSCHEMA", "mykey", "FIELD", "state", "string", "MIN", 1}, {"ERR invalid argument 'MIN'"},
+		{"SETSCHEMA", "mykey", "FIELD", "state", "string", "ENUM", 2, "idle", 5}, {"ERR invalid argument '5'"},
+		{"SETSCHEMA", "mykey", "STRICT",
+			"FIELD", "speed", "number", "MIN", 0, "MAX", 100,
+			"FIELD", "state", "string", "REQUIRED", "ENUM", 2, "idle", "moving",
+			"FIELD", "active", "bool"}, {"OK"},
+		{"SCHEMA", "mykey"}, {"[strict field speed number min 0 max 100 field state string required enum 2 idle moving field active bool]"},
+		{"SET", "mykey", "truck1", "POINT", 33, -115}, {"ERR field 'state' is required"},
+		{"SET", "mykey", "truck1", "FIELD", "state", "parked", "POINT", 33, -115}, {"ERR field 'state' must be one of idle, moving"},
+		{"SET", "mykey", "truck1", "FIELD", "state", "idle", "FIELD", "speed", "fast", "POINT", 33, -115}, {"ERR field 'speed' must be of type number"},
+		{"SET", "mykey", "truck1", "FIELD", "state", "idle", "FIELD", "speed", 101, "POINT", 33, -115}, {"ERR field 'speed' must be at most 100"},
+		{"SET", "mykey", "truck1", "FIELD", "state", "idle", "FIELD", "spede", 10, "POINT", 33, -115}, {"ERR field 'spede' is not in the schema"},
+		{"SET", "mykey", "truck1", "FIELD", "state", "idle", "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "truck1", "POINT", 33, -116}, {"OK"},
+		{"FSET", "mykey", "truck1", "speed", -1}, {"ERR field 'speed' must be at least 0"},
+		{"FSET", "mykey", "truck1", "active", 1}, {"ERR field 'active' must be of type bool"},
+		{"FSET", "mykey", "truck1", "state", 0}, {"ERR field 'state' is required"},
+		{"FSET", "mykey", "truck1", "active", "true", "state", "moving"}, {2},
+		{"FSET", "mykey", "truck0", "state", "moving"}, {1},
+		{"PSET", "mykey", "truck2", "FIELD", "state", "idle", "POINT", 33, -115, "truck3", "POINT", 33, -115}, {"ERR field 'state' is required"},
+		{"GET", "mykey", "truck2"}, {nil},
+		{"GET", "mykey", "truck1", "WITHFIELDS", "POINT"}, {"[[33 -116] [active true speed 10 state moving]]"},
+		{"DROP", "mykey"}, {1},
+		{"SET", "mykey", "truck1", "POINT", 33, -115}, {"ERR field 'state' is required"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"SCHEMA", "mykey"}, {`{"ok":true,"schema":{"strict":true,"fields":[{"name":"active","type":"bool","required":false},{"name":"speed","type":"number","required":false,"min":0,"max":100},{"name":"state","type":"string","required":true,"enum":["idle","moving"]}]}}`},
+		{"OUTPUT", "resp"}, {"OK"},
+		{"DELSCHEMA", "mykey"}, {1},
+		{"DELSCHEMA", "mykey"}, {0},
+		{"SET", "mykey", "truck1", "POINT", 33, -115}, {"OK"},
+		{"SETSCHEMA", "mykey", "FIELD", "state", "string"}, {"OK"},
+		{"FLUSHDB"}, {"OK"},
+		{"SCHEMA", "mykey"}, {nil},
+	})
+}
+
+func keys_LIMITS_test(mc *mockServer) error {
+	poly := `{"type":"Polygon","coordinates":[[[-116,32],[-113,32],[-113,35],[-116,35],[-116,32]]]}`
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "poly0", "OBJECT", poly}, {"OK"},
+		{"LIMITS", "mykey"}, {nil},
+		{"SETLIMITS", "mykey"}, {"ERR wrong number of arguments for 'setlimits' command"},
+		{"SETLIMITS", "mykey", "MAXPOINTS"}, {"ERR wrong number of arguments for 'setlimits' command"},
+		{"SETLIMITS", "mykey", "MAXPOINTS", 0}, {"ERR invalid argument '0'"},
+		{"SETLIMITS", "mykey", "MAXBYTES", "big"}, {"ERR invalid argument 'big'"},
+		{"SETLIMITS", "mykey", "MAXSIZE", 1}, {"ERR invalid argument 'MAXSIZE'"},
+		{"SETLIMITS", "mykey", "MAXPOINTS", 4, "MAXPOINTS", 5}, {"ERR duplicate argument 'MAXPOINTS'"},
+		{"SETLIMITS", "mykey", "MAXPOINTS", 4, "MAXBYTES", "1kb", "MAXOBJECTS", 3}, {"OK"},
+		{"LIMITS", "mykey"}, {"[maxpoints 4 maxbytes 1kb maxobjects 3]"},
+		{"SET", "mykey", "poly1", "OBJECT", poly}, {"ERR limit exceeded, the object 'poly1' has 5 points, the key 'mykey' allows 4"},
+		{"SET", "mykey", "truck1", "FIELD", "note", strings.Repeat("x", 1024), "POINT", 33, -115}, {"ERR limit exceeded, the object 'truck1' is 1068 bytes, the key 'mykey' allows 1024"},
+		{"SET", "mykey", "truck1", "POINT", 33, -115}, {"OK"},
+		{"PSET", "mykey", "truck2", "POINT", 33, -115, "truck3", "POINT", 33, -115}, {"ERR limit exceeded, the key 'mykey' allows 3 objects"},
+		{"PSET", "mykey", "truck2", "POINT", 33, -115, "truck2", "POINT", 34, -115, "truck1", "POINT", 34, -115}, {3},
+		{"SET", "mykey", "truck4", "POINT", 33, -115}, {"ERR limit exceeded, the key 'mykey' allows 3 objects"},
+		{"SET", "mykey", "truck1", "POINT", 35, -115}, {"OK"},
+		{"STATS", "mykey"}, {func(v interface{}) (resp, expect interface{}) {
+			return strings.Contains(fmt.Sprint(v), " num_limit_rejected 4 "), true
+		}},
+		{"COPY", "mykey", "mykey2"}, {3},
+		{"SETLIMITS", "mykey2", "MAXOBJECTS", 2}, {"OK"},
+		{"COPY", "mykey", "mykey2", "REPLACE"}, {"ERR limit exceeded, the key 'mykey2' allows 2 objects"},
+		{"DROP", "mykey"}, {1},
+		{"SET", "mykey", "poly1", "OBJECT", poly}, {"ERR limit exceeded, the object 'poly1' has 5 points, the key 'mykey' allows 4"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"LIMITS", "mykey"}, {`{"ok":true,"limits":{"maxpoints":4,"maxbytes":1024,"maxobjects":3}}`},
+		{"SETLIMITS", "mykey3", "MAXEVENTRATE", 5}, {`{"ok":true}`},
+		{"LIMITS", "mykey3"}, {`{"ok":true,"limits":{"maxeventrate":5}}`},
+		{"OUTPUT", "resp"}, {"OK"},
+		{"DELLIMITS", "mykey"}, {1},
+		{"DELLIMITS", "mykey"}, {0},
+		{"SET", "mykey", "poly1", "OBJECT", poly}, {"OK"},
+		{"SETLIMITS", "mykey", "MAXOBJECTS", 1}, {"OK"},
+		{"FLUSHDB"}, {"OK"},
+		{"LIMITS", "mykey"}, {nil},
+	})
+}
+
+func keys_HISTORY_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "truck1", "TIMESTAMP", 100, "POINT", 33, -115}, {"OK"},
+		{"HISTORY", "mykey"}, {nil},
+		{"TRAJECTORY", "mykey", "truck1"}, {nil},
+		{"SETHISTORY", "mykey"}, {"ERR wrong number of arguments for 'sethistory' command"},
+		{"SETHISTORY", "mykey", "LIMIT", 0}, {"ERR invalid argument '0'"},
+		{"SETHISTORY", "mykey", "WINDOW", "soon"}, {"ERR invalid argument 'soon'"},
+		{"SETHISTORY", "mykey", "LIMIT", 3, "LIMIT", 4}, {"ERR invalid argument 'LIMIT'"},
+		{"SETHISTORY", "mykey", "LIMIT", 3, "WINDOW", "1h"}, {"OK"},
+		{"HISTORY", "mykey"}, {"[limit 3 window 1h0m0s]"},
+		{"TRAJECTORY", "mykey", "truck1"}, {nil},
+		{"SET", "mykey", "truck1", "TIMESTAMP", 100, "POINT", 33, -115}, {"OK"},
+		{"TRAJECTORY", "mykey", "truck1"}, {`[{"type":"Point","coordinates":[-115,33]} [1970-01-01T00:01:40Z]]`},
+		{"SET", "mykey", "truck1", "TIMESTAMP", 200, "POINT", 34, -115}, {"OK"},
+		{"SET", "mykey", "truck1", "TIMESTAMP", 300, "POINT", 34, -114}, {"OK"},
+		{"SET", "mykey", "truck1", "TIMESTAMP", 400, "POINT", 35, -114}, {"OK"},
+		{"SET", "mykey", "truck2", "TIMESTAMP", 300, "POINT", 40, -100}, {"OK"},
+		{"TRAJECTORY", "mykey", "truck1"}, {`[{"type":"LineString","coordinates":[[-115,34],[-114,34],[-114,35]]} [1970-01-01T00:03:20Z 1970-01-01T00:05:00Z 1970-01-01T00:06:40Z]]`},
+		{"TRAJECTORY", "mykey", "truck1", "BETWEEN", 250, 350}, {`[{"type":"Point","coordinates":[-114,34]} [1970-01-01T00:05:00Z]]`},
+		{"TRAJECTORY", "mykey", "truck1", "SINCE", "1h"}, {nil},
+		{"PASSED", "mykey", "BOUNDS", 34.5, -114.5, 34.6, -113.5}, {"[truck1]"},
+		{"PASSED", "mykey", "BOUNDS", 34.5, -114.5, 34.6, -114.2}, {"[]"},
+		{"PASSED", "mykey", "POINT", 40, -100}, {"[truck2]"},
+		{"PASSED", "mykey", "BETWEEN", 350, 500, "BOUNDS", 33, -116, 41, -99}, {"[truck1]"},
+		{"PASSED", "mykey", "SINCE", "1h", "BOUNDS", 33, -116, 41, -99}, {"[]"},
+		{"PASSED", "mykey", "BOUNDS", 33, -116}, {"ERR wrong number of arguments for 'passed' command"},
+		{"DEL", "mykey", "truck2"}, {1},
+		{"PASSED", "mykey", "POINT", 40, -100}, {"[]"},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"HISTORY", "mykey"}, {`{"ok":true,"history":{"limit":3,"window":3600,"objects":1,"positions":3}}`},
+		{"TRAJECTORY", "mykey", "truck1", "BETWEEN", 350, 500}, {`{"ok":true,"object":{"type":"Point","coordinates":[-114,35]},"times":["1970-01-01T00:06:40Z"]}`},
+		{"PASSED", "mykey", "POINT", 35, -114}, {`{"ok":true,"ids":["truck1"],"count":1}`},
+		{"TRAJECTORY", "mykey", "truck2"}, {`{"ok":false,"err":"id not found"}`},
+		{"OUTPUT", "resp"}, {"OK"},
+		{"SETHISTORY", "mykey", "LIMIT", 1}, {"OK"},
+		{"TRAJECTORY", "mykey", "truck1"}, {`[{"type":"Point","coordinates":[-114,35]} [1970-01-01T00:06:40Z]]`},
+		{"DROP", "mykey"}, {1},
+		{"TRAJECTORY", "mykey", "truck1"}, {nil},
+		{"DELHISTORY", "mykey"}, {1},
+		{"DELHISTORY", "mykey"}, {0},
+		{"HISTORY", "mykey"}, {nil},
+	})
+}
+
 func keys_PDEL_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "myid1a", "POINT", 33, -115}, {"OK"},
@@ -384,6 +927,25 @@ func keys_PDEL_test(mc *mockServer) error {
 	})
 }
 
+func keys_PSET_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"PSET", "mykey"}, {"ERR wrong number of arguments for 'pset' command"},
+		{"PSET", "mykey", "myid1", "POINT", 33, -115, "myid2", "FIELD", "a", 1, "POINT", 34, -112, 10, "myid3", "STRING", "hello"}, {3},
+		{"GET", "mykey", "myid1", "POINT"}, {"[33 -115]"},
+		{"GET", "mykey", "myid2", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-112,34,10]} [a 1]]`},
+		{"GET", "mykey", "myid3"}, {"hello"},
+		{"PSET", "mykey", "myid4", "POINT", 33, -115, "myid5", "POINT", 33}, {"ERR wrong number of arguments for 'pset' command"},
+		{"PSET", "mykey", "myid4", "POINT", 33, -115, "myid5", "POINT", 33, "x"}, {"ERR invalid argument 'x'"},
+		{"PSET", "mykey", "myid4", "POINT", 33, -115, "myid5", "POLYGON", 33, -115}, {"ERR invalid argument 'POLYGON'"},
+		{"GET", "mykey", "myid4"}, {nil},
+		{"PSET", "mykey", "myid1", "NX", "POINT", 1, 1, "myid4", "XX", "POINT", 1, 1, "myid5", "NX", "POINT", 1, 1}, {1},
+		{"GET", "mykey", "myid1", "POINT"}, {"[33 -115]"},
+		{"GET", "mykey", "myid4"}, {nil},
+		{"GET", "mykey", "myid5", "POINT"}, {"[1 1]"},
+		{"SCAN", "mykey", "COUNT"}, {4},
+	})
+}
+
 func keys_WHEREIN_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "myid_a1", "FIELD", "a", 1, "POINT", 33, -115}, {"OK"},
@@ -397,6 +959,28 @@ func keys_WHEREIN_test(mc *mockServer) error {
 	})
 }
 
+func keys_WHERESTR_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "truck1", "FIELD", "a", 1, "POINT", 33, -115}, {"OK"},
+		{"SET", "mykey", "truck22", "FIELD", "a", 2, "POINT", 33.01, -115}, {"OK"},
+		{"SET", "mykey", "car1", "FIELD", "a", 3, "POINT", 33.02, -115}, {"OK"},
+		{"SET", "mykey", "s1", "STRING", "hello world"}, {"OK"},
+		{"SET", "mykey", "s2", "STRING", "goodbye"}, {"OK"},
+		{"SCAN", "mykey", "WHERE", "id", "prefix", "truck", "IDS"}, {"[0 [truck1 truck22]]"},
+		{"SCAN", "mykey", "WHERE", "ID", "SUFFIX", "1", "IDS"}, {"[0 [car1 s1 truck1]]"},
+		{"SCAN", "mykey", "WHERE", "id", "eq", "car1", "IDS"}, {"[0 [car1]]"},
+		{"SCAN", "mykey", "WHERE", "id", "regex", "^truck[0-9]{2}$", "IDS"}, {"[0 [truck22]]"},
+		{"SCAN", "mykey", "WHERE", "id", "prefix", "truck", "WHERE", "a", 2, 3, "IDS"}, {"[0 [truck22]]"},
+		{"SCAN", "mykey", "WHERE", "id", "suffix", "1", "COUNT"}, {"3"},
+		{"SCAN", "mykey", "WHERE", "value", "prefix", "hello", "IDS"}, {"[0 [s1]]"},
+		{"SEARCH", "mykey", "WHERE", "value", "regex", "^[a-z]+$", "IDS"}, {"[0 [s2]]"},
+		{"WITHIN", "mykey", "WHERE", "id", "suffix", "1", "IDS", "BOUNDS", 32.8, -115.2, 33.2, -114.8}, {"[0 [truck1 car1]]"},
+		{"SCAN", "mykey", "WHERE", "a", "prefix", "1", "IDS"}, {"[0 []]"},
+		{"SCAN", "mykey", "WHERE", "id", "regex", "(", "IDS"}, {"ERR invalid argument '('"},
+		{"SCAN", "mykey", "WHERE", "id", "prefix"}, {"ERR wrong number of arguments for 'scan' command"},
+	})
+}
+
 func keys_WHEREEVAL_test(mc *mockServer) error {
 	return mc.DoBatch([][]interface{}{
 		{"SET", "mykey", "myid_a1", "FIELD", "a", 1, "POINT", 33, -115}, {"OK"},
@@ -408,3 +992,39 @@ func keys_WHEREEVAL_test(mc *mockServer) error {
 		{"WITHIN", "mykey", "WHEREEVAL", "return FIELDS.a > tonumber(ARGV[1]) and FIELDS.a ~= tonumber(ARGV[2])", 2, 0.5, 3, "BOUNDS", 32.8, -115.2, 33.2, -114.8}, {`[0 [[myid_a1 {"type":"Point","coordinates":[-115,33]} [a 1]] [myid_a2 {"type":"Point","coordinates":[-115,32.99]} [a 2]]]]`},
 	})
 }
+
+func keys_SET_SNAP_test(mc *mockServer) error {
+	// a road network where the roads are on every hundredth of a degree
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:], ",")
+		lon, _ := strconv.ParseFloat(parts[0], 64)
+		lat, _ := strconv.ParseFloat(parts[1], 64)
+		if lat > 80 {
+			w.Write([]byte(`{"code":"NoSegment"}`))
+			return
+		}
+		fmt.Fprintf(w, `{"code":"Ok","waypoints":[{"location":[%v,%v]}]}`,
+			math.Round(lon*100)/100, math.Round(lat*100)/100)
+	}))
+	defer ts.Close()
+	defer mc.Do("CONFIG", "SET", "snapkeys", "")
+	defer mc.Do("CONFIG", "SET", "snapurl", "")
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "snapurl", "ftp://osrm"}, {"ERR Invalid argument 'ftp://osrm' for CONFIG SET 'snapurl'"},
+		{"CONFIG", "SET", "snapurl", ts.URL + "/nearest/v1/driving"}, {"OK"},
+		{"SET", "snapfleet", "truck1", "POINT", 33.0012, -115.0041}, {"OK"},
+		{"GET", "snapfleet", "truck1", "WITHFIELDS", "POINT"}, {"[[33.0012 -115.0041]]"},
+		{"CONFIG", "SET", "snapkeys", "snapfleet"}, {"OK"},
+		{"CONFIG", "GET", "snapkeys"}, {"[snapkeys snapfleet]"},
+		{"SET", "snapfleet", "truck1", "FIELD", "speed", 50, "POINT", 33.0012, -115.0041}, {"OK"},
+		{"GET", "snapfleet", "truck1", "WITHFIELDS", "POINT"}, {"[[33 -115] [rawpoint [-115.0041,33.0012] speed 50]]"},
+		{"SET", "snapfleet", "truck2", "POINT", 33.0012, -115.0041, 10}, {"OK"},
+		{"GET", "snapfleet", "truck2", "WITHFIELDS"}, {`[{"type":"Point","coordinates":[-115,33,10]} [rawpoint [-115.0041,33.0012,10]]]`},
+		{"SET", "snapfleet", "truck3", "POINT", 85.0012, -115.0041}, {"OK"},
+		{"GET", "snapfleet", "truck3", "WITHFIELDS", "POINT"}, {"[[85.0012 -115.0041]]"},
+		{"SET", "snapfleet", "truck4", "OBJECT", `{"type":"Point","coordinates":[-115.0041,33.0012]}`}, {"OK"},
+		{"GET", "snapfleet", "truck4", "WITHFIELDS", "POINT"}, {"[[33.0012 -115.0041]]"},
+		{"SET", "snapother", "truck1", "POINT", 33.0012, -115.0041}, {"OK"},
+		{"GET", "snapother", "truck1", "POINT"}, {"[33.0012 -115.0041]"},
+	})
+}
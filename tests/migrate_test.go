@@ -0,0 +1,32 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+)
+
+func subTestMigrate(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "errors", migrate_errors_test)
+}
+
+func migrate_errors_test(mc *mockServer) error {
+	defer mc.Do("DROP", "mig")
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mig", "truck1", "POINT", 33, -115}, {"OK"},
+		{"MIGRATE", "mig", "localhost"}, {"ERR wrong number of arguments for 'migrate' command"},
+		{"MIGRATE", "mig", "localhost", "port"}, {"ERR invalid argument 'port'"},
+		{"MIGRATE", "mig", "localhost", mc.port, "AUTH"}, {"ERR wrong number of arguments for 'migrate' command"},
+		{"MIGRATE", "mig", "localhost", mc.port, "NOPE"}, {"ERR invalid argument 'NOPE'"},
+		{"MIGRATE", "nope", "localhost", mc.port}, {"ERR key not found"},
+		{"MIGRATE", "mig", "localhost", mc.port}, {"ERR cannot migrate to self"},
+		{"MIGRATE", "mig", "localhost", 1}, {func(v interface{}) (resp, expect interface{}) {
+			if s := fmt.Sprint(v); len(s) < 23 || s[:23] != "ERR cannot migrate: dia" {
+				return v, "ERR cannot migrate: dial..."
+			}
+			return nil, nil
+		}},
+		{"GET", "mig", "truck1", "POINT"}, {"[33 -115]"},
+		{"RENAME", "mig", "mig2"}, {"OK"},
+		{"RENAME", "mig2", "mig"}, {"OK"},
+	})
+}
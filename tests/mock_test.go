@@ -32,7 +32,8 @@ func mockCleanup() {
 }
 
 type mockServer struct {
-	port int
+	port     int
+	grpcPort int
 	//join string
 	//n    *finn.Node
 	//m    *Machine
@@ -49,7 +50,8 @@ func mockOpenServer() (*mockServer, error) {
 		logOutput = os.Stderr
 	}
 	core.DevMode = true
-	s := &mockServer{port: port}
+	core.GRPCPort = port + 1
+	s := &mockServer{port: port, grpcPort: core.GRPCPort}
 	tlog.SetOutput(logOutput)
 	go func() {
 		if err := server.Serve("localhost", port, dir, true); err != nil {
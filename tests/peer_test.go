@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+)
+
+func subTestPeer(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "lww", peer_lww_test)
+	runStep(t, mc, "lww drop", peer_lww_drop_test)
+	runStep(t, mc, "peer", peer_peer_test)
+	runStep(t, mc, "repl auth", peer_repl_auth_test)
+}
+
+func peer_lww_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"LWW", 100, "a", "SET", "lww", "truck1", "FIELD", "speed", 10, "POINT", 33, -115}, {"OK"},
+		{"LWW", 50, "b", "SET", "lww", "truck1", "POINT", 1, 1}, {0},
+		{"GET", "lww", "truck1", "POINT"}, {"[33 -115]"},
+		{"LWW", 100, "a", "SET", "lww", "truck1", "POINT", 1, 1}, {0},
+		{"LWW", 100, "b", "SET", "lww", "truck1", "FIELD", "speed", 15, "POINT", 33, -115}, {"OK"},
+		{"LWW", 80, "b", "FSET", "lww", "truck1", "speed", 20}, {0},
+		{"LWW", 120, "b", "FSET", "lww", "truck1", "speed", 20}, {1},
+		{"LWW", 110, "a", "SET", "lww", "truck1", "FIELD", "speed", 30, "POINT", 34, -115}, {"OK"},
+		{"GET", "lww", "truck1", "WITHFIELDS", "POINT"}, {"[[34 -115] [speed 20]]"},
+		{"LWW", 105, "a", "EXPIRE", "lww", "truck1", 100}, {0},
+		{"LWW", 115, "a", "EXPIRE", "lww", "truck1", 100}, {1},
+		{"LWW", 112, "b", "SET", "lww", "truck1", "POINT", 35, -115}, {"OK"},
+		{"TTL", "lww", "truck1"}, {func(v interface{}) (resp, expect interface{}) {
+			// the expiration is kept by the older SET
+			if s := fmt.Sprint(v); s != "99" && s != "100" {
+				return v, 100
+			}
+			return nil, nil
+		}},
+		{"LWW", 130, "a", "DEL", "lww", "truck1"}, {1},
+		{"LWW", 125, "b", "SET", "lww", "truck1", "POINT", 1, 1}, {0},
+		{"LWW", 126, "b", "FSET", "lww", "truck1", "speed", 1}, {0},
+		{"GET", "lww", "truck1"}, {nil},
+		{"LWW", 131, "b", "SET", "lww", "truck1", "POINT", 1, 1}, {"OK"},
+		{"GET", "lww", "truck1", "WITHFIELDS", "POINT"}, {"[[1 1]]"},
+		{"LWW", 200, "a", "SET", "lww", "truck2", "POINT", 1, 1}, {"OK"},
+		{"LWW", 190, "b", "SET", "lww", "truck2", "FIELD", "speed", 5, "POINT", 2, 2}, {1},
+		{"GET", "lww", "truck2", "WITHFIELDS", "POINT"}, {"[[1 1] [speed 5]]"},
+		{"LWW", 1, "a", "NOPE"}, {"ERR invalid argument 'NOPE'"},
+		{"LWW", -1, "a", "DEL", "lww", "truck1"}, {"ERR invalid argument '-1'"},
+		{"LWW", 1, "a"}, {"ERR wrong number of arguments for 'lww' command"},
+	})
+}
+
+func peer_lww_drop_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"LWW", 100, "a", "SET", "lwwdrop", "truck1", "POINT", 33, -115}, {"OK"},
+		{"LWW", 140, "b", "SET", "lwwdrop", "truck2", "POINT", 33, -115}, {"OK"},
+		{"LWW", 135, "a", "DROP", "lwwdrop"}, {1},
+		{"SCAN", "lwwdrop", "IDS"}, {"[0 [truck2]]"},
+		{"LWW", 120, "b", "SET", "lwwdrop", "truck3", "POINT", 33, -115}, {0},
+		{"LWW", 150, "a", "PDEL", "lwwdrop", "truck*"}, {1},
+		{"SCAN", "lwwdrop", "IDS"}, {"[0 []]"},
+	})
+}
+
+func peer_peer_test(mc *mockServer) error {
+	defer mc.Do("PEER", "no", "one")
+	return mc.DoBatch([][]interface{}{
+		{"PEER", "localhost"}, {"ERR wrong number of arguments for 'peer' command"},
+		{"PEER", "localhost", "port"}, {"ERR invalid argument 'port'"},
+		{"PEER", "localhost", mc.port}, {"ERR cannot peer with self"},
+		{"PEER", "localhost", 1}, {func(v interface{}) (resp, expect interface{}) {
+			if s := fmt.Sprint(v); len(s) < 20 || s[:20] != "ERR cannot peer: dia" {
+				return v, "ERR cannot peer: dial..."
+			}
+			return nil, nil
+		}},
+		{"PEER", "no", "one"}, {"OK"},
+	})
+}
+
+func peer_repl_auth_test(mc *mockServer) error {
+	defer func() {
+		mc.ResetConn()
+		mc.Do("CONFIG", "SET", "replpass", "")
+		mc.Do("CONFIG", "SET", "repltlsonly", "no")
+	}()
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "repltlsonly", "maybe"}, {"ERR Invalid argument 'maybe' for CONFIG SET 'repltlsonly'"},
+		{"CONFIG", "SET", "repltlsonly", "yes"}, {"OK"},
+		{"CONFIG", "GET", "repltlsonly"}, {"[repltlsonly yes]"},
+		{"AOFMD5", 0, 0}, {"ERR replication requires tls"},
+		{"CONFIG", "SET", "repltlsonly", "no"}, {"OK"},
+		{"CONFIG", "SET", "replpass", "secret"}, {"OK"},
+		{"AOFMD5", 0, 0}, {"ERR replication authentication required"},
+		{"AUTH", "nope"}, {"ERR invalid password"},
+		{"AUTH", "secret"}, {"OK"},
+		{"AOFMD5", 0, 0}, {"d41d8cd98f00b204e9800998ecf8427e"},
+		{"GET", "mykey", "myid"}, {"ERR command not allowed on a replication connection"},
+		{"FOLLOW", "no", "one", "TLS"}, {"ERR command not allowed on a replication connection"},
+	})
+}
@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func subTestRaft(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "redirect", raft_redirect_test)
+}
+
+func raft_redirect_test(mc *mockServer) error {
+	defer func() {
+		mc.Do("CONFIG", "SET", "raftpeers", "")
+		mc.Do("CONFIG", "SET", "raftaddr", "")
+		mc.Do("FOLLOW", "no", "one")
+	}()
+	addr := fmt.Sprintf("127.0.0.1:%d", mc.port)
+	// nothing listens on port 1, so no leader can be elected
+	leader := "127.0.0.1:1"
+	role := func(role string) func(v interface{}) (resp, expect interface{}) {
+		return func(v interface{}) (resp, expect interface{}) {
+			if !strings.Contains(fmt.Sprint(v), "role "+role+" ") {
+				return v, "role " + role
+			}
+			return nil, nil
+		}
+	}
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "raftaddr", "nope"}, {"ERR Invalid argument 'nope' for CONFIG SET 'raftaddr'"},
+		{"CONFIG", "SET", "raftpeers", addr + ",nope"}, {"ERR Invalid argument '" + addr + ",nope' for CONFIG SET 'raftpeers'"},
+		{"RAFT", "STATE"}, {role("disabled")},
+		{"RAFT", "HEARTBEAT", 1, leader}, {"ERR raft disabled"},
+		{"CONFIG", "SET", "raftaddr", addr}, {"OK"},
+		{"CONFIG", "SET", "raftpeers", addr + "," + leader}, {"OK"},
+		{"CONFIG", "GET", "raftpeers"}, {"[raftpeers " + addr + "," + leader + "]"},
+		{"FOLLOW", "no", "one"}, {"ERR cannot follow in raft mode"},
+		{"AOFSHRINK"}, {"ERR aofshrink is not supported in raft mode"},
+		{"SET", "mykey", "myid", "POINT", 33, -115}, {"ERR not the leader"},
+		{"RAFT", "HEARTBEAT", 100, leader}, {"[100 1]"},
+		{"RAFT", "STATE"}, {role("follower")},
+		{"SET", "mykey", "myid", "POINT", 33, -115}, {"ERR not the leader, leader is " + leader},
+		{"RAFT", "VOTE", 99, "127.0.0.1:2", 0, 0}, {"[100 0]"},
+		{"RAFT", "VOTE", 101, "127.0.0.1:2", 0, 0}, {"[101 0]"},
+		{"RAFT", "VOTE", 102, "127.0.0.1:2", 200, 0}, {"[102 1]"},
+		{"RAFT", "VOTE", 102, "127.0.0.1:3", 200, 0}, {"[102 0]"},
+		{"RAFT", "VOTE", 102, "127.0.0.1:2", 200, 0}, {"[102 1]"},
+		{"RAFT", "VOTE", 103, "127.0.0.1:2", 200, -1}, {"ERR invalid argument '-1'"},
+		{"AOF", 0, "MEMBER", "nope"}, {"ERR invalid argument 'nope'"},
+	})
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func subTestREST(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "objects", rest_objects_test)
+	runStep(t, mc, "search", rest_search_test)
+	runStep(t, mc, "openapi", rest_openapi_test)
+}
+
+// restDo sends a request of the REST API and returns the status and body.
+func restDo(mc *mockServer, method, path, body string) (int, string, error) {
+	req, err := http.NewRequest(method,
+		fmt.Sprintf("http://localhost:%d%s", mc.port, path),
+		strings.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	return res.StatusCode, string(data), nil
+}
+
+type restStep struct {
+	method, path, body string
+	status             int
+	expect             string // a gjson path that must be true
+}
+
+func restSteps(mc *mockServer, steps []restStep) error {
+	for _, step := range steps {
+		status, body, err := restDo(mc, step.method, step.path, step.body)
+		if err != nil {
+			return err
+		}
+		if status != step.status {
+			return fmt.Errorf("%s %s: expected status %d, got %d %s",
+				step.method, step.path, step.status, status, body)
+		}
+		if step.expect != "" && !gjson.Get(body, step.expect).Exists() {
+			return fmt.Errorf("%s %s: expected '%s', got %s",
+				step.method, step.path, step.expect, body)
+		}
+	}
+	return nil
+}
+
+func rest_objects_test(mc *mockServer) error {
+	defer mc.Do("DROP", "restfleet")
+	return restSteps(mc, []restStep{
+		{"GET", "/keys/restfleet/objects/truck1", "", 404, ""},
+		{"PUT", "/keys/restfleet/objects/truck1",
+			`{"point":{"lat":33,"lon":-115},"fields":{"speed":90}}`, 200, "ok"},
+		{"PUT", "/keys/restfleet/objects/truck%2F2?nx",
+			`{"type":"Point","coordinates":[-112,33]}`, 200, "ok"},
+		{"PUT", "/keys/restfleet/objects/truck1?nx",
+			`{"type":"Point","coordinates":[-112,33]}`, 409, ""},
+		{"PUT", "/keys/restfleet/objects/truck3", `{"point":{"lat":"x"}}`,
+			400, ""},
+		{"GET", "/keys/restfleet/objects/truck1?withfields", "", 200,
+			`fields.speed`},
+		{"GET", "/keys/restfleet/objects/truck%2F2", "", 200,
+			`object.coordinates`},
+		{"GET", "/keys?match=rest*", "", 200, `keys.#(=="restfleet")`},
+		{"GET", "/keys/restfleet", "", 200, `stats.0.num_objects`},
+		{"GET", "/keys/restfleet/objects?desc&limit=1&output=ids", "", 200,
+			`ids.#(=="truck1")`},
+		{"GET", "/keys/restfleet/objects?where=speed,80,100", "", 200,
+			`objects.#(id=="truck1")`},
+		{"GET", "/keys/restfleet/bounds", "", 200, `bounds`},
+		{"DELETE", "/keys/restfleet/objects/truck1", "", 200, "ok"},
+		{"GET", "/keys/restfleet/objects/truck1", "", 404, ""},
+		{"GET", "/keys/restfleet/nope", "", 404, ""},
+		{"DELETE", "/keys/restfleet", "", 200, "ok"},
+		{"GET", "/keys/restfleet/objects/truck%2F2", "", 404, ""},
+	})
+}
+
+func rest_search_test(mc *mockServer) error {
+	defer mc.Do("DROP", "restfleet")
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "restfleet", "truck1", "FIELD", "speed", 90, "POINT", 33, -115}, {"OK"},
+		{"SET", "restfleet", "truck2", "FIELD", "speed", 20, "POINT", 33.01, -115.01}, {"OK"},
+		{"SET", "restfleet", "truck3", "POINT", 10, 10}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	return restSteps(mc, []restStep{
+		{"POST", "/keys/restfleet/search/nearby",
+			`{"point":{"lat":33,"lon":-115},"radius":10000,"output":"ids"}`,
+			200, `ids.#(=="truck2")`},
+		{"POST", "/keys/restfleet/search/within",
+			`{"bounds":{"minLat":32,"minLon":-116,"maxLat":34,"maxLon":-114},` +
+				`"where":[{"field":"speed","min":80,"max":100}],"output":"count"}`,
+			200, `count`},
+		{"POST", "/keys/restfleet/search/intersects",
+			`{"object":{"type":"Polygon","coordinates":[[[-116,32],[-114,32],[-114,34],[-116,34],[-116,32]]]},"limit":1}`,
+			200, `objects.0.id`},
+		{"POST", "/keys/restfleet/search/within", `{}`, 400, ""},
+	})
+}
+
+func rest_openapi_test(mc *mockServer) error {
+	status, body, err := restDo(mc, "GET", "/openapi.json", "")
+	if err != nil {
+		return err
+	}
+	if status != 200 || gjson.Get(body, "openapi").String() != "3.0.3" ||
+		!gjson.Get(body, `paths./keys/{key}/objects/{id}.put`).Exists() {
+		return fmt.Errorf("unexpected document: %d %s", status, body)
+	}
+	v, err := mc.Do("OPENAPI")
+	if err != nil {
+		return err
+	}
+	if !gjson.Get(fmt.Sprintf("%s", v), "paths").Exists() {
+		return fmt.Errorf("unexpected reply: %v", v)
+	}
+	return nil
+}
@@ -11,6 +11,8 @@ func subTestScripts(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "ATOMIC", scripts_ATOMIC_test)
 	runStep(t, mc, "READONLY", scripts_READONLY_test)
 	runStep(t, mc, "NONATOMIC", scripts_NONATOMIC_test)
+	runStep(t, mc, "FUNCTION", scripts_FUNCTION_test)
+	runStep(t, mc, "TRIGGER", scripts_TRIGGER_test)
 }
 
 func scripts_BASIC_test(mc *mockServer) error {
@@ -52,6 +54,12 @@ func scripts_READONLY_test(mc *mockServer) error {
 		{"EVALRO", "return tile38.pcall('set', KEYS[1], ARGV[1], 'point', 33, -115)", "1", "mykey", "myid1"}, {"ERR read only"},
 		{"SET", "mykey", "myid1", "POINT", 33, -115}, {"OK"},
 		{"EVALRO", "return tile38.call('get', KEYS[1], ARGV[1], ARGV[2])", "1", "mykey", "myid1", "point"}, {"[33 -115]"},
+		{"READONLY", "yes", "NOSAVE"}, {"OK"},
+		{"SET", "mykey", "myid2", "POINT", 33, -115}, {"ERR read only"},
+		{"READONLY", "no", "SAVE"}, {"ERR invalid argument 'SAVE'"},
+		{"READONLY", "no", "NOSAVE", "1"}, {"ERR wrong number of arguments for 'readonly' command"},
+		{"READONLY", "no"}, {"OK"},
+		{"SET", "mykey", "myid2", "POINT", 33, -115}, {"OK"},
 	})
 }
 
@@ -62,3 +70,103 @@ func scripts_NONATOMIC_test(mc *mockServer) error {
 		{"EVALNA", "return tile38.call('get', KEYS[1], ARGV[1], ARGV[2])", "1", "mykey", "myid1", "point"}, {"[33 -115]"},
 	})
 }
+
+func scripts_FUNCTION_test(mc *mockServer) error {
+	lib := strings.Join([]string{
+		"#!lua name=fleet",
+		"tile38.register_function('move', function(keys, args)",
+		"  tile38.call('del', keys[1], args[1])",
+		"  return tile38.call('set', keys[2], args[1], 'point', args[2], args[3])",
+		"end)",
+		"tile38.register_function{function_name='where', flags={'no-writes'},",
+		"  callback=function(keys, args)",
+		"    return tile38.call('get', keys[1], args[1], 'point')",
+		"  end}",
+	}, "\n")
+	return mc.DoBatch([][]interface{}{
+		{"FUNCTION", "FLUSH"}, {"OK"},
+		{"FUNCTION", "LOAD", lib}, {"fleet"},
+		{"FUNCTION", "LOAD", lib}, {"ERR library 'fleet' already exists"},
+		{"FUNCTION", "LOAD", "return 1"}, {"ERR missing library metadata, the code must start with #!lua name=<name>"},
+		{"FUNCTION", "LOAD", "#!lua name=other\nreturn 1"}, {"ERR no functions registered"},
+		{"FUNCTION", "LOAD", "#!lua name=other\ntile38.register_function('move', function() end)"}, {"ERR function 'move' already exists in library 'fleet'"},
+		{"FUNCTION", "LOAD", "REPLACE", lib}, {"fleet"},
+		{"FUNCTION", "LIST"}, {"[[library_name fleet functions [[name move flags []] [name where flags [no-writes]]]]]"},
+		{"SET", "depot", "truck1", "POINT", 33, -115}, {"OK"},
+		{"FCALL", "move", 2, "depot", "road", "truck1", 34, -116}, {"OK"},
+		{"FCALL_RO", "where", 1, "road", "truck1"}, {"[34 -116]"},
+		{"FCALL_RO", "move", 2, "road", "depot", "truck1", 33, -115}, {"ERR FCALL_RO can only call a function with the no-writes flag"},
+		{"FCALL", "missing", 0}, {"ERR function not found"},
+		{"GET", "depot", "truck1"}, {nil},
+		{"FUNCTION", "DELETE", "fleet"}, {"OK"},
+		{"FUNCTION", "DELETE", "fleet"}, {"ERR library not found"},
+		{"FCALL", "move", 0}, {"ERR function not found"},
+		{"FUNCTION", "LIST"}, {"[]"},
+	})
+}
+
+func scripts_TRIGGER_test(mc *mockServer) error {
+	lib := strings.Join([]string{
+		"#!lua name=triggers",
+		"tile38.register_function('stamp', function(event)",
+		"  if event.fields.tenant then return true end",
+		"  return {fields={tenant='acme'}}",
+		"end)",
+		"tile38.register_function('snap', function(event)",
+		"  return {object='{\"type\":\"Point\",\"coordinates\":[-115,33]}'}",
+		"end)",
+		"tile38.register_function('guard', function(event)",
+		"  if event.id == 'locked' then",
+		"    return tile38.error_reply('object is locked')",
+		"  end",
+		"  return event.fields.tenant == 'acme'",
+		"end)",
+		"tile38.register_function('audit', function(event)",
+		"  tile38.call('set', 'audit', event.id, 'string', event.command)",
+		"end)",
+		"tile38.register_function('notify', function(keys, args)",
+		"  return tile38.call('publish', args[1], args[2])",
+		"end)",
+	}, "\n")
+	return mc.DoBatch([][]interface{}{
+		{"FUNCTION", "FLUSH"}, {"OK"},
+		{"FUNCTION", "LOAD", lib}, {"triggers"},
+		{"SETTRIGGER", "stamp", "fleet*", "BEFORE", "SET", "missing"}, {"ERR function not found"},
+		{"SETTRIGGER", "stamp", "fleet*", "DURING", "SET", "stamp"}, {"ERR invalid argument 'DURING'"},
+		{"SETTRIGGER", "stamp", "fleet*", "BEFORE", "SET", "stamp"}, {"OK"},
+		{"SETTRIGGER", "guard", "fleet*", "BEFORE", "DEL", "guard"}, {"OK"},
+		{"SETTRIGGER", "audit", "fleet", "AFTER", "ALL", "audit"}, {"OK"},
+		{"TRIGGERS"}, {"[[audit fleet after all audit] [guard fleet* before del guard] [stamp fleet* before set stamp]]"},
+		{"TRIGGERS", "s*"}, {"[[stamp fleet* before set stamp]]"},
+
+		// the fields of a trigger are added to the write
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"OK"},
+		{"GET", "fleet", "truck1", "WITHFIELDS", "POINT"}, {"[[33 -115] [tenant acme]]"},
+		{"SET", "fleet", "truck2", "FIELD", "tenant", "other", "POINT", 33, -115}, {"OK"},
+		{"GET", "fleet", "truck2", "WITHFIELDS", "POINT"}, {"[[33 -115] [tenant other]]"},
+		{"GET", "audit", "truck1"}, {"set"},
+
+		// a trigger may reject the write
+		{"DEL", "fleet", "truck2"}, {"ERR rejected by trigger 'guard'"},
+		{"SET", "fleet", "locked", "POINT", 33, -115}, {"OK"},
+		{"DEL", "fleet", "locked"}, {"ERR object is locked"},
+		{"DEL", "fleet", "truck1"}, {1},
+		{"GET", "audit", "truck1"}, {"del"},
+
+		// the object of a trigger replaces the object of the write
+		{"SETTRIGGER", "snap", "depot", "BEFORE", "SET", "snap"}, {"OK"},
+		{"SET", "depot", "truck1", "POINT", 40, -100}, {"OK"},
+		{"GET", "depot", "truck1", "POINT"}, {"[33 -115]"},
+		{"DELTRIGGER", "snap"}, {1},
+		{"DELTRIGGER", "snap"}, {0},
+		{"SET", "depot", "truck1", "POINT", 40, -100}, {"OK"},
+		{"GET", "depot", "truck1", "POINT"}, {"[40 -100]"},
+
+		// scripts may publish to a channel
+		{"FCALL", "notify", 0, "alerts", "hello"}, {0},
+
+		// the triggers are removed with their functions
+		{"FUNCTION", "DELETE", "triggers"}, {"OK"},
+		{"TRIGGERS"}, {"[]"},
+	})
+}
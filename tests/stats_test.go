@@ -2,6 +2,10 @@ package tests
 
 import (
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/tidwall/gjson"
@@ -9,6 +13,20 @@ import (
 
 func subTestInfo(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "valid json", info_valid_json_test)
+	runStep(t, mc, "save", info_save_test)
+	runStep(t, mc, "appendfsync", info_appendfsync_test)
+	runStep(t, mc, "aofcheck", info_aofcheck_test)
+	runStep(t, mc, "wait", info_wait_test)
+	runStep(t, mc, "follow keys", info_follow_keys_test)
+	runStep(t, mc, "follower hooks", info_follower_hooks_test)
+	runStep(t, mc, "replica info", info_replica_info_test)
+	runStep(t, mc, "repl backlog", info_repl_backlog_test)
+	runStep(t, mc, "metrics", info_metrics_test)
+	runStep(t, mc, "statsd", info_statsd_test)
+	runStep(t, mc, "log config", info_log_config_test)
+	runStep(t, mc, "slowlog", info_slowlog_test)
+	runStep(t, mc, "latency", info_latency_test)
+	runStep(t, mc, "health", info_health_test)
 }
 
 func info_valid_json_test(mc *mockServer) error {
@@ -33,3 +51,425 @@ func info_valid_json_test(mc *mockServer) error {
 	}
 	return nil
 }
+
+func info_save_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "myid", "POINT", 33, -115}, {"OK"},
+		{"SAVE"}, {"OK"},
+		{"SAVE", "now"}, {"ERR wrong number of arguments for 'save' command"},
+		{"INFO", "persistence"}, {func(v interface{}) (resp, expect interface{}) {
+			info := v.(string)
+			return strings.Contains(info, "rdb_last_bgsave_status:ok") &&
+				!strings.Contains(info, "rdb_last_save_time:0\r\n"), true
+		}},
+	})
+}
+
+func info_appendfsync_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "GET", "appendfsync"}, {"[appendfsync everysec]"},
+		{"CONFIG", "SET", "appendfsync", "sometimes"}, {"ERR Invalid argument 'sometimes' for CONFIG SET 'appendfsync'"},
+		{"CONFIG", "SET", "appendfsync", "ALWAYS"}, {"OK"},
+		{"CONFIG", "GET", "appendfsync"}, {"[appendfsync always]"},
+		{"SET", "mykey", "myid2", "POINT", 33, -115}, {"OK"},
+		{"INFO", "persistence"}, {func(v interface{}) (resp, expect interface{}) {
+			return strings.Contains(v.(string), "aof_fsync:always"), true
+		}},
+		{"CONFIG", "SET", "appendfsync", "no"}, {"OK"},
+		{"SET", "mykey", "myid3", "POINT", 33, -115}, {"OK"},
+		{"WAITAOF"}, {"OK"},
+		{"WAITAOF", "now"}, {"ERR wrong number of arguments for 'waitaof' command"},
+		{"CONFIG", "SET", "appendfsync", "everysec"}, {"OK"},
+	})
+}
+
+func info_aofcheck_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "myid4", "POINT", 33, -115}, {"OK"},
+		{"AOFCHECK", "now"}, {"ERR invalid argument 'now'"},
+		{"AOFCHECK", "FIX", "now"}, {"ERR wrong number of arguments for 'aofcheck' command"},
+		{"AOFCHECK"}, {func(v interface{}) (resp, expect interface{}) {
+			s := fmt.Sprint(v)
+			return strings.Contains(s, "fixed false") &&
+				strings.Contains(s, "status ok"), true
+		}},
+		{"OUTPUT", "json"}, {func(v interface{}) (resp, expect interface{}) {
+			return gjson.Get(v.(string), "ok").Bool(), true
+		}},
+		{"AOFCHECK", "FIX"}, {func(v interface{}) (resp, expect interface{}) {
+			res := gjson.Parse(v.(string))
+			return res.Get("status").String() == "ok" &&
+				res.Get("valid_offset").Int() == res.Get("size").Int() &&
+				res.Get("commands").Int() > 0 && !res.Get("fixed").Bool(), true
+		}},
+		{"OUTPUT", "resp"}, {"OK"},
+	})
+}
+
+func info_wait_test(mc *mockServer) error {
+	// there are no followers, so only WAIT 0 succeeds
+	return mc.DoBatch([][]interface{}{
+		{"SET", "mykey", "myid4", "POINT", 33, -115}, {"OK"},
+		{"WAIT", 0, 0}, {0},
+		{"WAIT", 1, 50}, {0},
+		{"WAIT", 1}, {"ERR wrong number of arguments for 'wait' command"},
+		{"WAIT", -1, 0}, {"ERR invalid argument '-1'"},
+		{"WAIT", 1, "abc"}, {"ERR invalid argument 'abc'"},
+		// the leader has all of its own writes
+		{"WAITOFFSET", 1, 10}, {func(v interface{}) (resp, expect interface{}) {
+			if n, ok := v.(int64); !ok || n <= 1 {
+				return v, "an offset"
+			}
+			return nil, nil
+		}},
+		{"WAITOFFSET", -1, 0}, {"ERR invalid argument '-1'"},
+	})
+}
+
+func info_follow_keys_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"FOLLOW", "localhost", 1, "KEYS"}, {"ERR wrong number of arguments for 'follow' command"},
+		{"FOLLOW", "localhost", 1, "NOPE", "fleet"}, {"ERR invalid argument 'NOPE'"},
+		{"FOLLOW", "no", "one", "KEYS", "fleet"}, {"ERR wrong number of arguments for 'follow' command"},
+		{"FOLLOW", "no", "one"}, {"OK"},
+	})
+}
+
+func info_follower_hooks_test(mc *mockServer) error {
+	defer mc.Do("DELHOOK", "fhook")
+	defer mc.Do("CONFIG", "SET", "followerhooks", "")
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "GET", "followerhooks"}, {"[followerhooks no]"},
+		{"CONFIG", "SET", "followerhooks", "maybe"}, {"ERR Invalid argument 'maybe' for CONFIG SET 'followerhooks'"},
+		{"CONFIG", "SET", "followerhooks", "yes"}, {"OK"},
+		{"CONFIG", "GET", "followerhooks"}, {"[followerhooks yes]"},
+		{"SETHOOK", "fhook", "http://127.0.0.1:1/hook", "FOLLOWER", "NEARBY", "fleet",
+			"FENCE", "POINT", 33, -115, 100}, {1},
+		{"SETHOOK", "fhook", "http://127.0.0.1:1/hook", "FOLLOWER", "NEARBY", "fleet",
+			"FENCE", "POINT", 33, -115, 100}, {0},
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"HOOKS", "fhook"}, {func(v interface{}) (resp, expect interface{}) {
+			if !gjson.Get(fmt.Sprintf("%s", v), "hooks.0.follower").Bool() {
+				return v, `"follower":true`
+			}
+			return nil, nil
+		}},
+		{"OUTPUT", "resp"}, {"OK"},
+		// the markers of the lease are written by the leader only
+		{"hooklease", "f1"}, {"ERR unknown command 'hooklease'"},
+		{"REPLCONF", "HOOKLEASE"}, {"ERR wrong number of arguments for 'replconf' command"},
+	})
+}
+
+func info_replica_info_test(mc *mockServer) error {
+	// a leader without followers
+	return mc.DoBatch([][]interface{}{
+		{"REPLICA"}, {"ERR wrong number of arguments for 'replica' command"},
+		{"REPLICA", "NOPE"}, {"ERR invalid argument 'NOPE'"},
+		{"REPLICA", "INFO", "NOPE"}, {"ERR wrong number of arguments for 'replica' command"},
+		{"REPLICA", "INFO"}, {func(v interface{}) (resp, expect interface{}) {
+			s := fmt.Sprint(v)
+			if !strings.HasPrefix(s, "[role leader offset ") ||
+				!strings.HasSuffix(s, " followers []]") {
+				return v, "[role leader offset ... followers []]"
+			}
+			return nil, nil
+		}},
+		{"INFO", "replication"}, {func(v interface{}) (resp, expect interface{}) {
+			if !strings.Contains(fmt.Sprintf("%s", v), "master_repl_offset:") {
+				return v, "master_repl_offset"
+			}
+			return nil, nil
+		}},
+	})
+}
+
+func info_repl_backlog_test(mc *mockServer) error {
+	defer mc.Do("CONFIG", "SET", "replbacklogsize", "")
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "GET", "replbacklogsize"}, {"[replbacklogsize 16mb]"},
+		{"CONFIG", "SET", "replbacklogsize", "1kb"}, {"OK"},
+		{"CONFIG", "GET", "replbacklogsize"}, {"[replbacklogsize 1kb]"},
+		{"CONFIG", "SET", "replbacklogsize", "big"}, {"ERR Invalid argument 'big' for CONFIG SET 'replbacklogsize'"},
+		{"SET", "backlog", "truck1", "POINT", 33, -115}, {"OK"},
+		{"INFO", "replication"}, {func(v interface{}) (resp, expect interface{}) {
+			s := fmt.Sprintf("%s", v)
+			if !strings.Contains(s, "repl_backlog_active:1") ||
+				!strings.Contains(s, "repl_backlog_size:1024") {
+				return v, "repl_backlog_active:1 ... repl_backlog_size:1024"
+			}
+			i := strings.Index(s, "master_replid:")
+			if i == -1 {
+				return v, "master_replid"
+			}
+			replid := strings.TrimSpace(strings.Split(s[i+14:], "\n")[0])
+			if len(replid) != 40 {
+				return v, "a 40 character master_replid"
+			}
+			return nil, nil
+		}},
+		{"REPLCONF", "RESUME", "nope", 0, 0, "x"}, {"ERR cannot resume"},
+		{"REPLCONF", "RESUME", "nope", 0, 0}, {"ERR wrong number of arguments for 'replconf' command"},
+		{"REPLCONF", "RESUME", "nope", -1, 0, "x"}, {"ERR invalid argument '-1'"},
+		{"INFO", "stats"}, {func(v interface{}) (resp, expect interface{}) {
+			if !strings.Contains(fmt.Sprintf("%s", v), "sync_partial_err:1") {
+				return v, "sync_partial_err:1"
+			}
+			return nil, nil
+		}},
+		{"DROP", "backlog"}, {1},
+	})
+}
+
+func info_metrics_test(mc *mockServer) error {
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"OK"},
+		{"SET", "fleet", "truck2", "POINT", 33, -116}, {"OK"},
+		{"GET", "fleet", "truck3"}, {nil},
+		{"NOSUCHCMD"}, {"ERR unknown command 'NOSUCHCMD'"},
+		{"METRICS", "now"}, {"ERR wrong number of arguments for 'metrics' command"},
+	}); err != nil {
+		return err
+	}
+	res, err := http.Get(fmt.Sprintf("http://localhost:%d/metrics", mc.port))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		return fmt.Errorf("expected text/plain, got '%s'", ct)
+	}
+	metrics := string(body)
+	for _, line := range []string{
+		"# TYPE tile38_command_duration_seconds histogram\n",
+		"\ntile38_command_calls_total{command=\"set\"} ",
+		"\ntile38_command_errors_total{command=\"unknown\"} ",
+		"\ntile38_command_duration_seconds_bucket{command=\"get\",le=\"+Inf\"} ",
+		"\ntile38_collection_objects{key=\"fleet\"} 2\n",
+		"\ntile38_collection_points{key=\"fleet\"} 2\n",
+		"\ntile38_num_collections ",
+		"\ngo_goroutines ",
+	} {
+		if !strings.Contains(metrics, line) {
+			return fmt.Errorf("expected %q in the metrics", line)
+		}
+	}
+	// the metrics are also a command
+	v, err := mc.Do("METRICS")
+	if err != nil {
+		return err
+	}
+	if b, ok := v.([]byte); !ok || !strings.Contains(string(b),
+		"tile38_collection_objects{key=\"fleet\"} 2\n") {
+		return fmt.Errorf("unexpected %v", v)
+	}
+	return nil
+}
+
+func info_statsd_test(mc *mockServer) error {
+	defer mc.Do("CONFIG", "SET", "statsdaddr", "")
+	defer mc.Do("CONFIG", "SET", "statsdtags", "")
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "statsdaddr", "nope"}, {"ERR Invalid argument 'nope' for CONFIG SET 'statsdaddr'"},
+		{"CONFIG", "SET", "statsdaddr", "127.0.0.1:8125"}, {"OK"},
+		{"CONFIG", "GET", "statsdaddr"}, {"[statsdaddr 127.0.0.1:8125]"},
+		{"CONFIG", "SET", "statsdtags", "env:test|x"}, {"ERR Invalid argument 'env:test|x' for CONFIG SET 'statsdtags'"},
+		{"CONFIG", "SET", "statsdtags", "env:test, team:geo"}, {"OK"},
+		{"CONFIG", "GET", "statsdtags"}, {"[statsdtags env:test,team:geo]"},
+	})
+}
+
+func info_log_config_test(mc *mockServer) error {
+	defer mc.Do("CONFIG", "SET", "logformat", "text")
+	defer mc.Do("CONFIG", "SET", "loglevels", "")
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "GET", "logformat"}, {"[logformat text]"},
+		{"CONFIG", "SET", "logformat", "xml"}, {"ERR Invalid argument 'xml' for CONFIG SET 'logformat'"},
+		{"CONFIG", "SET", "logformat", "JSON"}, {"OK"},
+		{"CONFIG", "GET", "logformat"}, {"[logformat json]"},
+		{"CONFIG", "SET", "loglevels", "nope:debug"}, {"ERR Invalid argument 'nope:debug' for CONFIG SET 'loglevels'"},
+		{"CONFIG", "SET", "loglevels", "aof:loud"}, {"ERR Invalid argument 'aof:loud' for CONFIG SET 'loglevels'"},
+		{"CONFIG", "SET", "loglevels", "replication:debug, aof:warn,endpoint:silent"}, {"OK"},
+		{"CONFIG", "GET", "loglevels"}, {"[loglevels aof:warn,endpoint:silent,replication:debug]"},
+		{"CONFIG", "SET", "loglevels", ""}, {"OK"},
+		{"CONFIG", "GET", "loglevels"}, {"[loglevels ]"},
+	})
+}
+
+func info_slowlog_test(mc *mockServer) error {
+	defer mc.Do("SLOWLOG", "RESET")
+	defer mc.Do("DEL", "slowlog", "truck")
+	defer mc.Do("CONFIG", "SET", "slowlog-max-len", "")
+	defer mc.Do("CONFIG", "SET", "slowlog-log-slower-than", "")
+	if err := mc.DoBatch([][]interface{}{
+		{"CONFIG", "GET", "slowlog-log-slower-than"}, {"[slowlog-log-slower-than 10000]"},
+		{"CONFIG", "SET", "slowlog-max-len", "-1"}, {"ERR Invalid argument '-1' for CONFIG SET 'slowlog-max-len'"},
+		{"SLOWLOG", "NOPE"}, {"ERR Syntax error, try SLOWLOG (GET | LEN | RESET)"},
+		{"SLOWLOG", "GET", "-2"}, {"ERR invalid argument '-2'"},
+		{"CONFIG", "SET", "slowlog-log-slower-than", "0"}, {"OK"},
+		{"SLOWLOG", "RESET"}, {"OK"},
+		{"SET", "slowlog", "truck", "POINT", "33", "-115"}, {"OK"},
+		{"AUTH", "secret"}, {func(v interface{}) (resp, expect interface{}) {
+			// the reply does not matter, only the entry
+			return nil, nil
+		}},
+		{"CONFIG", "SET", "slowlog-log-slower-than", "-1"}, {"OK"},
+		{"SLOWLOG", "LEN"}, {3},
+	}); err != nil {
+		return err
+	}
+	v, err := mc.Do("SLOWLOG", "GET", "2")
+	if err != nil {
+		return err
+	}
+	entries, ok := v.([]interface{})
+	if !ok || len(entries) != 2 {
+		return fmt.Errorf("expected 2 entries, got %v", v)
+	}
+	var args [2]string
+	for i, entry := range entries {
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) != 6 {
+			return fmt.Errorf("expected 6 fields, got %v", entry)
+		}
+		var ss []string
+		for _, arg := range fields[3].([]interface{}) {
+			ss = append(ss, string(arg.([]byte)))
+		}
+		args[i] = strings.Join(ss, " ")
+	}
+	if args[0] != "AUTH (redacted)" {
+		return fmt.Errorf("expected a redacted auth, got '%s'", args[0])
+	}
+	if args[1] != "SET slowlog truck POINT 33 -115" {
+		return fmt.Errorf("expected the set, got '%s'", args[1])
+	}
+	return mc.DoBatch([][]interface{}{
+		{"CONFIG", "SET", "slowlog-log-slower-than", "0"}, {"OK"},
+		{"CONFIG", "SET", "slowlog-max-len", "1"}, {"OK"},
+		{"PING"}, {"PONG"},
+		{"SLOWLOG", "LEN"}, {1},
+		{"CONFIG", "SET", "slowlog-log-slower-than", "-1"}, {"OK"},
+		{"SLOWLOG", "RESET"}, {"OK"},
+		{"SLOWLOG", "LEN"}, {0},
+	})
+}
+
+func info_latency_test(mc *mockServer) error {
+	defer mc.Do("LATENCY", "RESET")
+	defer mc.Do("CONFIG", "SET", "latency-monitor-threshold", "")
+	if err := mc.DoBatch([][]interface{}{
+		{"CONFIG", "GET", "latency-monitor-threshold"}, {"[latency-monitor-threshold 0]"},
+		{"CONFIG", "SET", "latency-monitor-threshold", "-1"}, {"ERR Invalid argument '-1' for CONFIG SET 'latency-monitor-threshold'"},
+		{"LATENCY", "NOPE"}, {"ERR Syntax error, try LATENCY (LATEST | HISTORY | RESET)"},
+		{"LATENCY", "HISTORY"}, {"ERR wrong number of arguments for 'latency' command"},
+		{"LATENCY", "RESET"}, {0},
+		{"SLEEP", "0.05"}, {"OK"},
+		{"LATENCY", "LATEST"}, {"[]"},
+		{"CONFIG", "SET", "latency-monitor-threshold", "20"}, {"OK"},
+		{"PING"}, {"PONG"},
+		{"LATENCY", "LATEST"}, {"[]"},
+		{"SLEEP", "0.05"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	v, err := mc.Do("LATENCY", "LATEST")
+	if err != nil {
+		return err
+	}
+	events, ok := v.([]interface{})
+	if !ok || len(events) != 1 {
+		return fmt.Errorf("expected 1 event, got %v", v)
+	}
+	fields, ok := events[0].([]interface{})
+	if !ok || len(fields) != 4 || string(fields[0].([]byte)) != "command" {
+		return fmt.Errorf("expected the command event, got %v", events[0])
+	}
+	if latency, _ := fields[2].(int64); latency < 50 || fields[3] != fields[2] {
+		return fmt.Errorf("expected a latency of 50ms or more, got %v", fields)
+	}
+	v, err = mc.Do("LATENCY", "HISTORY", "command")
+	if err != nil {
+		return err
+	}
+	if samples, ok := v.([]interface{}); !ok || len(samples) != 1 {
+		return fmt.Errorf("expected 1 sample, got %v", v)
+	}
+	return mc.DoBatch([][]interface{}{
+		{"LATENCY", "HISTORY", "aof-fsync"}, {"[]"},
+		{"LATENCY", "RESET", "aof-fsync"}, {0},
+		{"LATENCY", "RESET", "command"}, {1},
+		{"LATENCY", "LATEST"}, {"[]"},
+	})
+}
+
+func info_health_test(mc *mockServer) error {
+	get := func(path string, status int) (string, error) {
+		res, err := http.Get(fmt.Sprintf("http://localhost:%d%s", mc.port, path))
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return "", err
+		}
+		if res.StatusCode != status {
+			return "", fmt.Errorf("%s: expected %d, got %d %s", path, status,
+				res.StatusCode, body)
+		}
+		return string(body), nil
+	}
+	defer mc.Do("DELHOOK", "health")
+	if err := mc.DoBatch([][]interface{}{
+		{"HEALTH"}, {"ERR wrong number of arguments for 'health' command"},
+		{"HEALTH", "NOPE"}, {"ERR Syntax error, try HEALTH (LIVE | READY)"},
+		{"HEALTH", "READY", "NOPE"}, {"ERR invalid argument 'NOPE'"},
+		{"HEALTH", "LIVE"}, {"OK"},
+		{"HEALTH", "READY", "ENDPOINTS"}, {"OK"},
+		{"SETHOOK", "health", "http://127.0.0.1:1/hook", "NEARBY", "fleet",
+			"FENCE", "POINT", 33, -115, 100}, {1},
+		{"HEALTH", "READY"}, {"OK"},
+		{"HEALTH", "READY", "ENDPOINTS"}, {func(v interface{}) (resp, expect interface{}) {
+			s := fmt.Sprint(v)
+			if strings.HasPrefix(s, "ERR not ready, endpoint http://127.0.0.1:1/hook: ") {
+				return nil, nil
+			}
+			return s, "ERR not ready, endpoint http://127.0.0.1:1/hook: ..."
+		}},
+	}); err != nil {
+		return err
+	}
+	body, err := get("/livez", 200)
+	if err != nil {
+		return err
+	}
+	if !gjson.Get(body, "live").Bool() ||
+		gjson.Get(body, "checks.responsive").String() != "ok" {
+		return fmt.Errorf("unexpected %s", body)
+	}
+	body, err = get("/readyz", 200)
+	if err != nil {
+		return err
+	}
+	if !gjson.Get(body, "ready").Bool() ||
+		gjson.Get(body, "checks.follow").String() != "ok" ||
+		gjson.Get(body, "checks.memory").String() != "ok" {
+		return fmt.Errorf("unexpected %s", body)
+	}
+	body, err = get("/readyz?endpoints", 503)
+	if err != nil {
+		return err
+	}
+	endpoints := gjson.Get(body, "checks.endpoints").Map()
+	if gjson.Get(body, "ready").Bool() || len(endpoints) != 1 ||
+		endpoints["http://127.0.0.1:1/hook"].String() == "ok" ||
+		!endpoints["http://127.0.0.1:1/hook"].Exists() {
+		return fmt.Errorf("unexpected %s", body)
+	}
+	return nil
+}
@@ -46,7 +46,20 @@ func TestAll(t *testing.T) {
 	runSubTest(t, "fence", mc, subTestFence)
 	runSubTest(t, "scripts", mc, subTestScripts)
 	runSubTest(t, "info", mc, subTestInfo)
+	runSubTest(t, "backup", mc, subTestBackup)
+	runSubTest(t, "import", mc, subTestImport)
+	runSubTest(t, "cdc", mc, subTestCDC)
+	runSubTest(t, "raft", mc, subTestRaft)
+	runSubTest(t, "peer", mc, subTestPeer)
+	runSubTest(t, "cluster", mc, subTestCluster)
+	runSubTest(t, "migrate", mc, subTestMigrate)
 	runSubTest(t, "client", mc, subTestClient)
+	runSubTest(t, "acl", mc, subTestACL)
+	runSubTest(t, "audit", mc, subTestAudit)
+	runSubTest(t, "grpc", mc, subTestGRPC)
+	runSubTest(t, "graphql", mc, subTestGraphQL)
+	runSubTest(t, "rest", mc, subTestREST)
+	runSubTest(t, "transactions", mc, subTestTransactions)
 	runSubTest(t, "timeouts", mc, subTestTimeout)
 }
 
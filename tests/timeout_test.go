@@ -13,6 +13,8 @@ import (
 func subTestTimeout(t *testing.T, mc *mockServer) {
 	runStep(t, mc, "spatial", timeout_spatial_test)
 	runStep(t, mc, "search", timeout_search_test)
+	runStep(t, mc, "modifier", timeout_modifier_test)
+	runStep(t, mc, "disconnect", timeout_disconnect_test)
 	runStep(t, mc, "scripts", timeout_scripts_test)
 	runStep(t, mc, "no writes", timeout_no_writes_test)
 	runStep(t, mc, "within scripts", timeout_within_scripts_test)
@@ -162,3 +164,80 @@ func timeout_no_writes_within_scripts_test(mc *mockServer) (err error) {
 		{"EVALSHA", sha2, 0, "foo"}, {scriptTimeoutNotSupportedErr},
 	})
 }
+
+// slowEval is a WHEREEVAL that takes a millisecond for each object. The
+// deadline is checked every 256 objects.
+const slowEval = `local t0 = os.clock() while os.clock() - t0 < 0.001 do end return true`
+
+// completed validates the ids of a query that was not aborted.
+func completed(v interface{}) (resp, expect interface{}) {
+	if s := fmt.Sprint(v); strings.HasPrefix(s, "ERR") {
+		return s, "the ids"
+	}
+	return nil, nil
+}
+
+func timeout_modifier_test(mc *mockServer) (err error) {
+	defer mc.Do("CONFIG", "SET", "query-timeout", "")
+	defer mc.Do("DROP", "slowkey")
+	for i := 0; i < 600; i++ {
+		_, err := mc.Do("SET", "slowkey", fmt.Sprintf("id%d", i), "POINT", 33, -115)
+		if err != nil {
+			return err
+		}
+	}
+	return mc.DoBatch([][]interface{}{
+		{"SCAN", "slowkey", "TIMEOUT", "-1", "COUNT"}, {"ERR invalid argument '-1'"},
+		{"SCAN", "slowkey", "TIMEOUT", "10", "TIMEOUT", "10", "COUNT"}, {"ERR duplicate argument 'TIMEOUT'"},
+		{"SCAN", "slowkey", "TIMEOUT", "20", "LIMIT", 10000, "WHEREEVAL", slowEval, 0, "IDS"}, {"ERR timeout"},
+		{"WITHIN", "slowkey", "TIMEOUT", "20", "LIMIT", 10000, "WHEREEVAL", slowEval, 0, "IDS", "BOUNDS", -90, -180, 90, 180}, {"ERR timeout"},
+		{"INTERSECTS", "slowkey", "TIMEOUT", "20", "LIMIT", 10000, "WHEREEVAL", slowEval, 0, "IDS", "BOUNDS", -90, -180, 90, 180}, {"ERR timeout"},
+		{"NEARBY", "slowkey", "TIMEOUT", "20", "LIMIT", 10000, "WHEREEVAL", slowEval, 0, "IDS", "POINT", 33, -115}, {"ERR timeout"},
+		{"SCAN", "slowkey", "TIMEOUT", "5000", "LIMIT", 10000, "WHEREEVAL", slowEval, 0, "IDS"}, {completed},
+
+		// the server default, which the modifier overrides
+		{"CONFIG", "SET", "query-timeout", "20"}, {"OK"},
+		{"CONFIG", "GET", "query-timeout"}, {"[query-timeout 20]"},
+		{"SCAN", "slowkey", "LIMIT", 10000, "WHEREEVAL", slowEval, 0, "IDS"}, {"ERR timeout"},
+		{"SCAN", "slowkey", "TIMEOUT", "0", "LIMIT", 10000, "WHEREEVAL", slowEval, 0, "IDS"}, {completed},
+		{"TIMEOUT", "5", "SCAN", "slowkey", "LIMIT", 10000, "WHEREEVAL", slowEval, 0, "IDS"}, {completed},
+		{"CONFIG", "SET", "query-timeout", ""}, {"OK"},
+		{"SCAN", "slowkey", "LIMIT", 10000, "WHEREEVAL", slowEval, 0, "IDS"}, {completed},
+
+		{"NEARBY", "slowkey", "TIMEOUT", "20", "FENCE", "POINT", 33, -115, 100}, {"ERR invalid argument 'TIMEOUT'"},
+	})
+}
+
+func timeout_disconnect_test(mc *mockServer) (err error) {
+	defer mc.Do("DROP", "slowkey")
+	for i := 0; i < 3000; i++ {
+		_, err := mc.Do("SET", "slowkey", fmt.Sprintf("id%d", i), "POINT", 33, -115)
+		if err != nil {
+			return err
+		}
+	}
+	// the scan takes three seconds, unless it's canceled
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	if err := conn.Send("SCAN", "slowkey", "LIMIT", 10000, "WHEREEVAL", slowEval, 0, "IDS"); err != nil {
+		return err
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	time.Sleep(time.Millisecond * 100)
+	conn.Close()
+
+	// a write waits for the lock of the scan
+	start := time.Now()
+	if _, err := mc.Do("SET", "slowkey", "id0", "POINT", 33, -115); err != nil {
+		return err
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		return fmt.Errorf("expected the scan to be canceled, the write "+
+			"waited %s", elapsed)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/gjson"
+)
+
+func subTestTransactions(t *testing.T, mc *mockServer) {
+	runStep(t, mc, "MULTI EXEC", transactions_MULTI_EXEC_test)
+	runStep(t, mc, "DISCARD", transactions_DISCARD_test)
+	runStep(t, mc, "WATCH", transactions_WATCH_test)
+	runStep(t, mc, "RESP errors", transactions_RESP_errors_test)
+}
+
+func transactions_MULTI_EXEC_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"OK"},
+		{"MULTI"}, {"OK"},
+		{"DEL", "fleet", "truck1"}, {"QUEUED"},
+		{"SET", "garage", "truck1", "POINT", 34, -116}, {"QUEUED"},
+		{"GET", "garage", "truck1", "POINT"}, {"QUEUED"},
+		{"EXEC"}, {"[1 OK [34 -116]]"},
+		{"GET", "fleet", "truck1"}, {nil},
+		{"GET", "garage", "truck1", "POINT"}, {"[34 -116]"},
+
+		// the errors of commands do not stop the transaction
+		{"MULTI"}, {"OK"},
+		{"FSET", "fleet", "truck1", "speed", 10}, {"QUEUED"},
+		{"SET", "fleet", "truck2", "POINT", 33, -115}, {"QUEUED"},
+		{"EXEC"}, {"[ERR key not found OK]"},
+
+		// a command that cannot be queued discards the transaction
+		{"MULTI"}, {"OK"},
+		{"MULTI"}, {"ERR MULTI calls can not be nested"},
+		{"SET", "fleet", "truck3", "POINT", 33, -115}, {"QUEUED"},
+		{"FOLLOW", "localhost", 9851}, {"ERR FOLLOW is not allowed in a transaction"},
+		{"EXEC"}, {"ERR EXECABORT Transaction discarded because of previous errors"},
+		{"GET", "fleet", "truck3"}, {nil},
+		{"EXEC"}, {"ERR EXEC without MULTI"},
+
+		{"OUTPUT", "json"}, {`{"ok":true}`},
+		{"MULTI"}, {`{"ok":true}`},
+		{"DEL", "fleet", "truck2"}, {`{"ok":true,"queued":true}`},
+		{"GET", "fleet", "truck2"}, {`{"ok":true,"queued":true}`},
+		{"EXEC"}, {func(v interface{}) (resp, expect interface{}) {
+			return gjson.Get(v.(string), "results.#.err").String(),
+				`["key not found"]`
+		}},
+	})
+}
+
+func transactions_DISCARD_test(mc *mockServer) error {
+	return mc.DoBatch([][]interface{}{
+		{"DISCARD"}, {"ERR DISCARD without MULTI"},
+		{"MULTI"}, {"OK"},
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"QUEUED"},
+		{"DISCARD"}, {"OK"},
+		{"GET", "fleet", "truck1"}, {nil},
+		{"EXEC"}, {"ERR EXEC without MULTI"},
+	})
+}
+
+func transactions_WATCH_test(mc *mockServer) error {
+	conn, err := redis.Dial("tcp", fmt.Sprintf(":%d", mc.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// a write from another client, between the WATCH and the EXEC
+	other := func(args ...interface{}) error {
+		_, err := conn.Do(args[0].(string), args[1:]...)
+		return err
+	}
+	if err := mc.DoBatch([][]interface{}{
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"OK"},
+		{"WATCH", "fleet", "truck1"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	if err := other("SET", "fleet", "truck1", "POINT", 34, -116); err != nil {
+		return err
+	}
+	// a watched object that was changed fails the transaction
+	if err := mc.DoBatch([][]interface{}{
+		{"MULTI"}, {"OK"},
+		{"SET", "fleet", "truck1", "POINT", 35, -117}, {"QUEUED"},
+		{"EXEC"}, {nil},
+		{"GET", "fleet", "truck1", "POINT"}, {"[34 -116]"},
+		{"WATCH", "fleet", "truck1"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	if err := other("SET", "fleet", "truck2", "POINT", 34, -116); err != nil {
+		return err
+	}
+	// other objects of the collection do not
+	if err := mc.DoBatch([][]interface{}{
+		{"MULTI"}, {"OK"},
+		{"SET", "fleet", "truck1", "POINT", 35, -117}, {"QUEUED"},
+		{"EXEC"}, {"[OK]"},
+		{"WATCH", "fleet"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	if err := other("DEL", "fleet", "truck2"); err != nil {
+		return err
+	}
+	// a watched collection fails on any of its objects, and the watches
+	// end with the transaction
+	if err := mc.DoBatch([][]interface{}{
+		{"MULTI"}, {"OK"},
+		{"WATCH", "fleet"}, {"ERR WATCH inside MULTI is not allowed"},
+		{"DEL", "fleet", "truck1"}, {"QUEUED"},
+		{"EXEC"}, {nil},
+		{"MULTI"}, {"OK"},
+		{"DEL", "fleet", "truck1"}, {"QUEUED"},
+		{"EXEC"}, {"[1]"},
+		{"WATCH", "fleet"}, {"OK"},
+		{"UNWATCH"}, {"OK"},
+	}); err != nil {
+		return err
+	}
+	if err := other("FLUSHDB"); err != nil {
+		return err
+	}
+	return mc.DoBatch([][]interface{}{
+		{"MULTI"}, {"OK"},
+		{"SET", "fleet", "truck1", "POINT", 33, -115}, {"QUEUED"},
+		{"EXEC"}, {"[OK]"},
+	})
+}
+
+func transactions_RESP_errors_test(mc *mockServer) error {
+	c, err := dialRESP(mc)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+	// the errors of the commands have the ERR prefix of their replies
+	// outside of a transaction
+	for _, cmd := range [][2]string{
+		{"MULTI", "+OK\r\n"},
+		{"FSET nofleet truck1 speed 10", "+QUEUED\r\n"},
+		{"GET", "+QUEUED\r\n"},
+		{"SET nofleet truck1 POINT 33 -115", "+QUEUED\r\n"},
+		{"EXEC", "*3\r\n-ERR key not found\r\n" +
+			"-ERR wrong number of arguments for 'get' command\r\n+OK\r\n"},
+		{"FSET nofleet truck2 speed 10", "-ERR id not found\r\n"},
+		{"DROP nofleet", ":1\r\n"},
+	} {
+		if err := c.do(cmd[0], cmd[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}